@@ -0,0 +1,90 @@
+// Package bus carries the commands of the engine and the events of the workers between the processes.
+package bus
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Message is a message of a topic. The messages published with the same key are delivered in the order they were
+// published, the commands and the events of a market are keyed by its symbol.
+type Message struct {
+	Topic string
+	Key   []byte
+	Value []byte
+	// Delivery identifies the record of the message in its topic, it's nil when the driver doesn't number them
+	Delivery *Delivery
+
+	// source is the record of the driver the message was polled from, it's committed with the message
+	source interface{}
+}
+
+// Delivery is the partition and the offset of a record, a record delivered again has the same.
+type Delivery struct {
+	Partition int32
+	Offset    int64
+}
+
+// Consumer polls the messages of its topics for a group, the messages committed aren't delivered again to the group.
+type Consumer interface {
+	Poll() ([]*Message, error)
+	Commit(messages ...*Message) error
+	Close()
+}
+
+// Handler processes a message of a subscription, an error stops the subscription before the message is committed.
+type Handler func(message *Message) error
+
+// EventBus publishes the messages of the processes to their topics and delivers them to the consumers.
+type EventBus interface {
+	// Publish encodes payload to JSON and publishes it to topic with key, an empty key doesn't order the message.
+	Publish(topic string, key string, payload interface{}) error
+	// Subscribe handles the messages of topic for group and commits each once handled. It returns the error of the
+	// handler or of the bus, the messages not committed are delivered again to the group.
+	Subscribe(topic string, group string, handler Handler) error
+	// Consumer returns a consumer of topics for group, for the consumers committing their messages themselves.
+	Consumer(group string, topics ...string) (Consumer, error)
+	// Broadcast handles the messages of topic published from now on, every process broadcasting the topic takes each
+	// of them. It returns the error of the handler or of the bus, the messages published in between are lost.
+	Broadcast(topic string, handler Handler) error
+	Close()
+}
+
+// DriverKafka is the Kafka bus, the default.
+const DriverKafka = "kafka"
+
+// Open connects the bus of driver to brokers.
+func Open(driver string, brokers []string, logger *logrus.Entry) (EventBus, error) {
+	switch driver {
+	case "", DriverKafka:
+		return NewKafka(brokers, logger)
+	default:
+		return nil, fmt.Errorf("unknown event bus driver %q", driver)
+	}
+}
+
+// consume handles the messages of topic polled by consumer and commits each once handled, until the poll or the
+// handler fails. A message which failed to commit is delivered again after a rebalance, the handlers take it twice.
+func consume(consumer Consumer, topic string, handler Handler, logger *logrus.Entry) error {
+	for {
+		messages, err := consumer.Poll()
+		if err != nil {
+			return fmt.Errorf("failed to poll topic %s: %w", topic, err)
+		}
+
+		for _, message := range messages {
+			if message.Topic != topic {
+				continue
+			}
+
+			if err := handler(message); err != nil {
+				return err
+			}
+
+			if err := consumer.Commit(message); err != nil {
+				logger.Errorf("Failed to commit a message of topic %s: %v", topic, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package bus
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeConsumer delivers its messages in one poll, then fails the next polls.
+type fakeConsumer struct {
+	messages  []*Message
+	polled    bool
+	committed []string
+}
+
+var errNoMoreMessages = errors.New("no more messages")
+
+func (c *fakeConsumer) Poll() ([]*Message, error) {
+	if c.polled {
+		return nil, errNoMoreMessages
+	}
+	c.polled = true
+
+	return c.messages, nil
+}
+
+func (c *fakeConsumer) Commit(messages ...*Message) error {
+	for _, message := range messages {
+		c.committed = append(c.committed, string(message.Value))
+	}
+
+	return nil
+}
+
+func (c *fakeConsumer) Close() {}
+
+func TestConsumeCommitsTheMessagesHandled(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	consumer := &fakeConsumer{messages: []*Message{
+		{Topic: "order_processor", Value: []byte("1")},
+		{Topic: "trade_executor", Value: []byte("2")},
+		{Topic: "order_processor", Value: []byte("3")},
+		{Topic: "order_processor", Value: []byte("4")},
+		{Topic: "order_processor", Value: []byte("5")},
+	}}
+
+	var handled []string
+	errHandler := errors.New("database is gone")
+	err := consume(consumer, "order_processor", func(message *Message) error {
+		handled = append(handled, string(message.Value))
+		if string(message.Value) == "4" {
+			return errHandler
+		}
+
+		return nil
+	}, logrus.NewEntry(logger))
+
+	if !errors.Is(err, errHandler) {
+		t.Fatalf("expected the error of the handler, got %v", err)
+	}
+
+	// the messages of other topics are skipped, the one the handler failed on isn't committed nor the ones after it
+	if fmt.Sprint(handled) != "[1 3 4]" || fmt.Sprint(consumer.committed) != "[1 3]" {
+		t.Errorf("expected 1 3 4 handled and 1 3 committed, got %v handled and %v committed", handled, consumer.committed)
+	}
+
+	consumer = &fakeConsumer{}
+	if err := consume(consumer, "order_processor", func(message *Message) error { return nil }, logrus.NewEntry(logger)); !errors.Is(err, errNoMoreMessages) {
+		t.Errorf("expected the error of the poll, got %v", err)
+	}
+}
+
+func TestOpenUnknownDriver(t *testing.T) {
+	if _, err := Open("amqp", []string{"localhost:9092"}, logrus.NewEntry(logrus.New())); err == nil {
+		t.Error("expected an unknown driver to be refused")
+	}
+}
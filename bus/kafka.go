@@ -0,0 +1,147 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+var errConsumerClosed = errors.New("consumer is closed")
+
+// Kafka is the bus on a Kafka cluster. The messages of a key go to one partition of their topic, so the messages of
+// a market are consumed in order while the markets are spread across the partitions.
+type Kafka struct {
+	brokers  []string
+	logger   *logrus.Entry
+	producer *kgo.Client
+}
+
+func NewKafka(brokers []string, logger *logrus.Entry) (*Kafka, error) {
+	producer, err := kgo.NewClient(kgo.SeedBrokers(brokers...))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Kafka{brokers: brokers, logger: logger, producer: producer}, nil
+}
+
+// Publish waits for the brokers to acknowledge the message, the partition of a keyed message is the hash of its key.
+func (k *Kafka) Publish(topic string, key string, payload interface{}) error {
+	value, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	record := &kgo.Record{Topic: topic, Value: value}
+	if len(key) > 0 {
+		record.Key = []byte(key)
+	}
+
+	return k.producer.ProduceSync(context.Background(), record).FirstErr()
+}
+
+func (k *Kafka) Subscribe(topic string, group string, handler Handler) error {
+	consumer, err := k.Consumer(group, topic)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	return consume(consumer, topic, handler, k.logger)
+}
+
+// Consumer joins group, the messages are committed by the consumer only.
+func (k *Kafka) Consumer(group string, topics ...string) (Consumer, error) {
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(k.brokers...),
+		kgo.ConsumerGroup(group),
+		kgo.ConsumeTopics(topics...),
+		kgo.DisableAutoCommit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafkaConsumer{client: client}, nil
+}
+
+// Broadcast reads topic outside of a group from its end, nothing is committed.
+func (k *Kafka) Broadcast(topic string, handler Handler) error {
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(k.brokers...),
+		kgo.ConsumeTopics(topic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtEnd()),
+	)
+	if err != nil {
+		return err
+	}
+
+	consumer := &kafkaConsumer{client: client}
+	defer consumer.Close()
+
+	for {
+		messages, err := consumer.Poll()
+		if err != nil {
+			return fmt.Errorf("failed to poll topic %s: %w", topic, err)
+		}
+
+		for _, message := range messages {
+			if err := handler(message); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (k *Kafka) Close() {
+	k.producer.Close()
+}
+
+type kafkaConsumer struct {
+	client *kgo.Client
+}
+
+// Poll waits for the next messages, the messages fetched along a failed partition are delivered again.
+func (c *kafkaConsumer) Poll() ([]*Message, error) {
+	fetches := c.client.PollFetches(context.Background())
+	if fetches.IsClientClosed() {
+		return nil, errConsumerClosed
+	}
+
+	if errs := fetches.Errors(); len(errs) > 0 {
+		return nil, fmt.Errorf("failed to fetch partition %d of %s: %w", errs[0].Partition, errs[0].Topic, errs[0].Err)
+	}
+
+	messages := make([]*Message, 0)
+	fetches.EachRecord(func(record *kgo.Record) {
+		messages = append(messages, &Message{
+			Topic:    record.Topic,
+			Key:      record.Key,
+			Value:    record.Value,
+			Delivery: &Delivery{Partition: record.Partition, Offset: record.Offset},
+			source:   record,
+		})
+	})
+
+	return messages, nil
+}
+
+// Commit commits the offsets of the messages, and of the ones before them in their partitions.
+func (c *kafkaConsumer) Commit(messages ...*Message) error {
+	records := make([]*kgo.Record, 0, len(messages))
+	for _, message := range messages {
+		if record, ok := message.source.(*kgo.Record); ok {
+			records = append(records, record)
+		}
+	}
+
+	return c.client.CommitRecords(context.Background(), records...)
+}
+
+func (c *kafkaConsumer) Close() {
+	c.client.Close()
+}
@@ -0,0 +1,100 @@
+//go:build integration
+
+package bus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var errReceived = errors.New("received every message")
+
+// The test needs KAFKA_URL, the brokers of a disposable cluster. The per-key order is only put to the test when the
+// topics are created with several partitions, KAFKA_CFG_NUM_PARTITIONS=4 on the bitnami image:
+//
+//	go test -tags integration -run Kafka ./bus
+func TestKafkaKeepsTheOrderOfEachKey(t *testing.T) {
+	if len(os.Getenv("KAFKA_URL")) == 0 {
+		t.Skip("KAFKA_URL isn't set")
+	}
+
+	logger := logrus.NewEntry(logrus.New())
+	kafka, err := NewKafka(strings.Split(os.Getenv("KAFKA_URL"), ","), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kafka.Close()
+
+	topic := fmt.Sprintf("bus_test_%d", time.Now().UnixNano())
+	markets := []string{"btcusdt", "ethusdt", "trxusdt"}
+	const count = 100
+
+	for i := 0; i < count; i++ {
+		for _, market := range markets {
+			if err := kafka.Publish(topic, market, map[string]interface{}{"market": market, "sequence": i}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	next := make(map[string]int)
+	received := 0
+	subscribed := make(chan error, 1)
+	go func() {
+		subscribed <- kafka.Subscribe(topic, topic, func(message *Message) error {
+			var published struct {
+				Market   string `json:"market"`
+				Sequence int    `json:"sequence"`
+			}
+			if err := json.Unmarshal(message.Value, &published); err != nil || published.Market != string(message.Key) {
+				return fmt.Errorf("unexpected message %s of key %s", message.Value, message.Key)
+			}
+
+			if published.Sequence != next[published.Market] {
+				return fmt.Errorf("expected message %d of %s, got %d", next[published.Market], published.Market, published.Sequence)
+			}
+			next[published.Market]++
+
+			received++
+			if received == count*len(markets) {
+				return errReceived
+			}
+
+			return nil
+		})
+	}()
+
+	select {
+	case err := <-subscribed:
+		if !errors.Is(err, errReceived) {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Minute):
+		t.Fatalf("received %d messages of %d in time", received, count*len(markets))
+	}
+
+	// the last message wasn't committed, the group gets it again
+	redelivered := make(chan *Message, 1)
+	go func() {
+		kafka.Subscribe(topic, topic, func(message *Message) error {
+			redelivered <- message
+			return errReceived
+		})
+	}()
+
+	select {
+	case message := <-redelivered:
+		if !strings.Contains(string(message.Value), fmt.Sprintf(`"sequence":%d`, count-1)) {
+			t.Errorf("expected the last message delivered again, got %s", message.Value)
+		}
+	case <-time.After(time.Minute):
+		t.Fatal("the message not committed wasn't delivered again")
+	}
+}
@@ -0,0 +1,208 @@
+// Package cli is the finex command line, it runs the servers and the operational tasks as subcommands:
+//
+//	finex serve api|engine|cron|worker|daemon
+//	finex commission backfill --from --to [--dry-run]
+//	finex export trades --market --date [--output] [--dry-run]
+//	finex engine snapshot --market [--limit]
+//	finex engine replay --file [--market] [--from] [--to] [--speed] [--flags]
+//	finex engine reproduce --file [--market-price] [--flags]
+//	finex ledger check [--since]
+//	finex ledger decimals
+//	finex fast_ack close --reason
+//	finex fast_ack reopen
+//	finex market delist --market --cancel-at --halt-at [--cancel-only-at]
+//	finex market delisting --market
+//	finex market abort_delisting --market
+//	finex klines rebuild --market --from --to [--period]
+//
+// A command exits with ExitFailure when it fails and ExitUsage when its arguments are wrong,
+// so runbooks and jobs can tell them apart.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zsmartex/finex/config"
+)
+
+const (
+	ExitOK      = 0
+	ExitFailure = 1
+	ExitUsage   = 2
+)
+
+// DateLayout is the layout of the dates given to the commands.
+const DateLayout = "2006-01-02"
+
+// Context is what a command runs with.
+type Context struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	Now    func() time.Time
+}
+
+// Command is a subcommand, Run gets the arguments following its name.
+type Command struct {
+	Name    string
+	Summary string
+	Run     func(ctx *Context, args []string) error
+}
+
+// initialize loads the configuration and connects the database, the broker and the stores, tests replace it.
+var initialize = config.InitializeConfig
+
+// Initialize wires the configuration and the logger, commands call it once their flags are parsed
+// so a wrong flag never needs a database.
+func (ctx *Context) Initialize() error {
+	if err := initialize(); err != nil {
+		return fmt.Errorf("failed to initialize: %w", err)
+	}
+
+	return nil
+}
+
+// Printf writes the output of a command.
+func (ctx *Context) Printf(format string, args ...interface{}) {
+	fmt.Fprintf(ctx.Stdout, format, args...)
+}
+
+// UsageError is an error in the arguments of a command.
+type UsageError struct {
+	Err error
+}
+
+func (e *UsageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.Err
+}
+
+func usagef(format string, args ...interface{}) error {
+	return &UsageError{Err: fmt.Errorf(format, args...)}
+}
+
+// Commands are the subcommands of the finex binary.
+var Commands = []*Command{
+	{Name: "serve api", Summary: "serve the HTTP API", Run: serveAPI},
+	{Name: "serve engine", Summary: "serve the matching engine", Run: serveEngine},
+	{Name: "serve cron", Summary: "run the scheduled jobs", Run: serveCron},
+	{Name: "serve worker", Summary: "run a broker worker: order_processor, trade_executor, ...", Run: serveWorker},
+	{Name: "serve daemon", Summary: "run daemons: algo_order_scheduler, report_generator, ...", Run: serveDaemon},
+	{Name: "commission backfill", Summary: "release the commissions of days the release job missed", Run: commissionBackfill},
+	{Name: "export trades", Summary: "write the trades of a market on a day as CSV", Run: exportTrades},
+	{Name: "engine snapshot", Summary: "print the order book of a market held by the engine", Run: engineSnapshot},
+	{Name: "engine replay", Summary: "replay an engine capture and report where it diverged", Run: engineReplay},
+	{Name: "engine reproduce", Summary: "print the trades and the depth diffs of a command log processed into empty books", Run: engineReproduce},
+	{Name: "ledger check", Summary: "check the balances, the decimals and the rounding drift", Run: ledgerCheck},
+	{Name: "ledger decimals", Summary: "list the columns with decimals exceeding their scale", Run: ledgerDecimals},
+	{Name: "fast_ack close", Summary: "place the orders of every API process synchronously", Run: fastAckClose},
+	{Name: "fast_ack reopen", Summary: "reopen the fast order acknowledgement once its closure is resolved", Run: fastAckReopen},
+	{Name: "market delist", Summary: "schedule the delisting of a market, the market_delister daemon runs it", Run: marketDelist},
+	{Name: "market delisting", Summary: "print the progress of the delisting of a market", Run: marketDelisting},
+	{Name: "market abort_delisting", Summary: "abort the delisting of a market before its orders are cancelled", Run: marketAbortDelisting},
+	{Name: "klines rebuild", Summary: "rebuild the candles of a market over a range from its trades", Run: klinesRebuild},
+}
+
+// FindCommand returns the command named by the first arguments, and the arguments following its name.
+func FindCommand(args []string) (*Command, []string) {
+	if len(args) < 2 {
+		return nil, args
+	}
+
+	name := args[0] + " " + args[1]
+	for _, command := range Commands {
+		if command.Name == name {
+			return command, args[2:]
+		}
+	}
+
+	return nil, args
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: finex <command> [flags]")
+	fmt.Fprintln(w)
+	for _, command := range Commands {
+		fmt.Fprintf(w, "  %-20s %s\n", command.Name, command.Summary)
+	}
+}
+
+// Run runs the command named by args and returns its exit code.
+func Run(ctx *Context, args []string) int {
+	command, rest := FindCommand(args)
+	if command == nil {
+		if len(args) > 0 && args[0] != "help" && args[0] != "-h" && args[0] != "--help" {
+			fmt.Fprintf(ctx.Stderr, "finex: unknown command %q\n", strings.Join(args, " "))
+			usage(ctx.Stderr)
+			return ExitUsage
+		}
+
+		usage(ctx.Stdout)
+		if len(args) == 0 {
+			return ExitUsage
+		}
+		return ExitOK
+	}
+
+	err := command.Run(ctx, rest)
+
+	var usage_error *UsageError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return ExitOK
+	case errors.As(err, &usage_error):
+		fmt.Fprintf(ctx.Stderr, "finex %s: %v\n", command.Name, err)
+		return ExitUsage
+	default:
+		fmt.Fprintf(ctx.Stderr, "finex %s: %v\n", command.Name, err)
+		return ExitFailure
+	}
+}
+
+// Main runs the command named by args with the standard streams, the main packages exit with its result.
+func Main(args []string) int {
+	return Run(&Context{Stdout: os.Stdout, Stderr: os.Stderr, Now: time.Now}, args)
+}
+
+// newFlagSet returns the flags of a command, its errors are returned rather than exiting.
+func newFlagSet(ctx *Context, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("finex "+name, flag.ContinueOnError)
+	fs.SetOutput(ctx.Stderr)
+
+	return fs
+}
+
+// parseFlags parses the flags of a command, flag errors are usage errors.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+
+		return &UsageError{Err: err}
+	}
+
+	return nil
+}
+
+// parseDate parses a date flag in the location of the server, like the daily jobs count days.
+func parseDate(name, value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, usagef("--%s is required", name)
+	}
+
+	date, err := time.ParseInLocation(DateLayout, value, time.Local)
+	if err != nil {
+		return time.Time{}, usagef("--%s must be a date like %s", name, DateLayout)
+	}
+
+	return date, nil
+}
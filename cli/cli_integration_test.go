@@ -0,0 +1,133 @@
+//go:build integration
+
+package cli
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/types"
+)
+
+// The tests need the DATABASE_* variables of a disposable database:
+//
+//	go test -tags integration -run 'TestCommissionBackfill|TestExportTrades' ./cli
+func setupCLIDatabase(t *testing.T) *Context {
+	if len(os.Getenv("DATABASE_HOST")) == 0 {
+		t.Skip("DATABASE_HOST isn't set")
+	}
+
+	db, err := config.NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&models.Commission{}, &models.ReleaseCommission{}, &models.Trade{}); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Where("1 = 1").Delete(&models.Commission{})
+	db.Where("1 = 1").Delete(&models.ReleaseCommission{})
+	db.Where("1 = 1").Delete(&models.Trade{})
+
+	config.DataBase = db
+
+	initialized := initialize
+	initialize = func() error { return nil }
+	t.Cleanup(func() { initialize = initialized })
+
+	now := time.Date(2022, 5, 10, 12, 0, 0, 0, time.Local)
+
+	return &Context{Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}, Now: func() time.Time { return now }}
+}
+
+func TestCommissionBackfill(t *testing.T) {
+	ctx := setupCLIDatabase(t)
+
+	day := func(d int) time.Time { return time.Date(2022, 5, d, 15, 0, 0, 0, time.Local) }
+	for _, commission := range []*models.Commission{
+		{AccountType: types.AccountTypeSpot, MemberID: 1, FriendUID: "ID2", EarnAmount: decimal.RequireFromString("0.1"), CurrencyID: "btc", CreatedAt: day(2)},
+		{AccountType: types.AccountTypeSpot, MemberID: 1, FriendUID: "ID3", EarnAmount: decimal.RequireFromString("0.2"), CurrencyID: "btc", CreatedAt: day(2)},
+		{AccountType: types.AccountTypeSpot, MemberID: 4, FriendUID: "ID5", EarnAmount: decimal.RequireFromString("0.5"), CurrencyID: "btc", CreatedAt: day(3)},
+		// today isn't over, it's left to the release job
+		{AccountType: types.AccountTypeSpot, MemberID: 4, FriendUID: "ID5", EarnAmount: decimal.RequireFromString("1"), CurrencyID: "btc", CreatedAt: day(10)},
+	} {
+		if result := config.DataBase.Create(commission); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+
+	// member 4 was released by the job on the 3rd
+	config.DataBase.Create(&models.ReleaseCommission{
+		AccountType: types.AccountTypeSpot, MemberID: 4, Kind: models.ReleaseCommissionKindRelease,
+		EarnedBTC: decimal.RequireFromString("0.5"), FriendTrade: 1, CreatedAt: models.CommissionReleaseAt(day(3)),
+	})
+
+	if code := Run(ctx, []string{"commission", "backfill", "--from", "2022-05-01", "--to", "2022-05-10", "--dry-run"}); code != ExitOK {
+		t.Fatalf("dry run exited with %d: %s", code, ctx.Stderr)
+	}
+
+	var count int64
+	config.DataBase.Model(&models.ReleaseCommission{}).Count(&count)
+	if count != 1 || !strings.Contains(ctx.Stdout.(*bytes.Buffer).String(), "1 releases to backfill") {
+		t.Fatalf("expected the dry run to save nothing, got %d releases: %s", count, ctx.Stdout)
+	}
+
+	for run := 0; run < 2; run++ {
+		if code := Run(ctx, []string{"commission", "backfill", "--from", "2022-05-01", "--to", "2022-05-10"}); code != ExitOK {
+			t.Fatalf("backfill exited with %d: %s", code, ctx.Stderr)
+		}
+	}
+
+	var releases []*models.ReleaseCommission
+	config.DataBase.Order("member_id").Find(&releases)
+	if len(releases) != 2 {
+		t.Fatalf("expected one release backfilled once, got %d", len(releases))
+	}
+
+	release := releases[0]
+	if release.MemberID != 1 || release.FriendTrade != 2 || !release.EarnedBTC.Equal(decimal.RequireFromString("0.3")) || !release.CreatedAt.Equal(models.CommissionReleaseAt(day(2))) {
+		t.Errorf("unexpected release %+v", release)
+	}
+}
+
+func TestExportTrades(t *testing.T) {
+	ctx := setupCLIDatabase(t)
+
+	at := time.Date(2022, 5, 9, 10, 0, 0, 0, time.UTC)
+	for i, created_at := range []time.Time{at, at.Add(time.Hour), at.AddDate(0, 0, 1)} {
+		if result := config.DataBase.Create(&models.Trade{
+			Price: decimal.NewFromInt(100), Amount: decimal.NewFromInt(int64(i + 1)), Total: decimal.NewFromInt(int64(100 * (i + 1))),
+			MarketID: "btcusdt", MakerOrderID: 1, TakerOrderID: 2, TakerType: types.TypeBuy, CreatedAt: created_at,
+		}); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+
+	output := t.TempDir() + "/trades.csv"
+	if code := Run(ctx, []string{"export", "trades", "--market", "btcusdt", "--date", "2022-05-09", "--output", output}); code != ExitOK {
+		t.Fatalf("export exited with %d: %s", code, ctx.Stderr)
+	}
+
+	csv, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(csv)), "\n")
+	if len(lines) != 3 || lines[0] != strings.Join(models.TradeArchiveHeader, ",") {
+		t.Errorf("expected the header and the 2 trades of the day, got %q", lines)
+	}
+
+	ctx.Stdout.(*bytes.Buffer).Reset()
+	if code := Run(ctx, []string{"export", "trades", "--market", "btcusdt", "--date", "2022-05-09", "--dry-run"}); code != ExitOK || !strings.HasPrefix(ctx.Stdout.(*bytes.Buffer).String(), "2 trades") {
+		t.Errorf("expected the dry run to count the trades, got %d: %s", code, ctx.Stdout)
+	}
+}
@@ -0,0 +1,273 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/types"
+)
+
+var errNoDatabase = errors.New("no database")
+
+// newTestContext returns a context whose commands can't initialize, so only their flags are run.
+func newTestContext(t *testing.T) (*Context, *bytes.Buffer, *bytes.Buffer) {
+	initialized := initialize
+	initialize = func() error { return errNoDatabase }
+	t.Cleanup(func() { initialize = initialized })
+
+	var stdout, stderr bytes.Buffer
+	now := time.Date(2022, 5, 10, 12, 0, 0, 0, time.Local)
+
+	return &Context{Stdout: &stdout, Stderr: &stderr, Now: func() time.Time { return now }}, &stdout, &stderr
+}
+
+func TestRunExitCodes(t *testing.T) {
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{nil, ExitUsage},
+		{[]string{"help"}, ExitOK},
+		{[]string{"serve"}, ExitUsage},
+		{[]string{"serve", "everything"}, ExitUsage},
+		{[]string{"commission", "backfill", "-h"}, ExitOK},
+		{[]string{"commission", "backfill", "--from", "yesterday"}, ExitUsage},
+		{[]string{"commission", "backfill", "--unknown"}, ExitUsage},
+		{[]string{"serve", "worker", "matching"}, ExitUsage},
+		{[]string{"serve", "daemon"}, ExitUsage},
+		// valid flags get as far as initializing, which fails in the test
+		{[]string{"commission", "backfill", "--from", "2022-05-01"}, ExitFailure},
+		{[]string{"export", "trades", "--market", "btcusdt", "--date", "2022-05-01"}, ExitFailure},
+		{[]string{"serve", "daemon", "cron_job", "report_generator"}, ExitFailure},
+		{[]string{"ledger", "check"}, ExitFailure},
+	}
+
+	for _, tt := range tests {
+		ctx, _, stderr := newTestContext(t)
+
+		if code := Run(ctx, tt.args); code != tt.code {
+			t.Errorf("finex %s exited with %d, want %d: %s", strings.Join(tt.args, " "), code, tt.code, stderr)
+		}
+	}
+}
+
+func TestParseCommissionBackfill(t *testing.T) {
+	ctx, _, _ := newTestContext(t)
+
+	opts, err := parseCommissionBackfill(ctx, []string{"--from", "2022-05-01", "--to", "2022-05-03", "--dry-run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.From.Format(DateLayout) != "2022-05-01" || opts.To.Format(DateLayout) != "2022-05-03" || !opts.DryRun {
+		t.Errorf("unexpected options %+v", opts)
+	}
+
+	if opts, err := parseCommissionBackfill(ctx, []string{"--from", "2022-05-01"}); err != nil || !opts.To.Equal(opts.From) || opts.DryRun {
+		t.Errorf("expected --to to default to --from, got %+v, %v", opts, err)
+	}
+
+	var usage_error *UsageError
+	for _, args := range [][]string{
+		{},
+		{"--to", "2022-05-01"},
+		{"--from", "2022-05-03", "--to", "2022-05-01"},
+		{"--from", "05/01/2022"},
+	} {
+		if _, err := parseCommissionBackfill(ctx, args); !errors.As(err, &usage_error) {
+			t.Errorf("expected %v to be a usage error, got %v", args, err)
+		}
+	}
+}
+
+func TestParseExportTrades(t *testing.T) {
+	ctx, _, _ := newTestContext(t)
+
+	opts, err := parseExportTrades(ctx, []string{"--market", "btcusdt", "--date", "2022-05-09", "--output", "trades.csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.Market != "btcusdt" || !opts.Date.Equal(time.Date(2022, 5, 9, 0, 0, 0, 0, time.UTC)) || opts.Output != "trades.csv" || opts.DryRun {
+		t.Errorf("unexpected options %+v", opts)
+	}
+
+	var usage_error *UsageError
+	for _, args := range [][]string{
+		{"--date", "2022-05-09"},
+		{"--market", "btcusdt"},
+		// the day isn't over at the time of the context
+		{"--market", "btcusdt", "--date", "2022-05-10"},
+	} {
+		if _, err := parseExportTrades(ctx, args); !errors.As(err, &usage_error) {
+			t.Errorf("expected %v to be a usage error, got %v", args, err)
+		}
+	}
+}
+
+func TestParseEngineSnapshotAndLedgerCheck(t *testing.T) {
+	ctx, _, _ := newTestContext(t)
+
+	if opts, err := parseEngineSnapshot(ctx, []string{"--market", "btcusdt"}); err != nil || opts.Limit != 100 {
+		t.Errorf("unexpected options %+v, %v", opts, err)
+	}
+
+	if _, err := parseEngineSnapshot(ctx, []string{"--market", "btcusdt", "--limit", "0"}); err == nil {
+		t.Error("expected a limit of 0 to be refused")
+	}
+
+	opts, err := parseLedgerCheck(ctx, nil)
+	if err != nil || !opts.Since.Equal(ctx.Now().AddDate(0, 0, -defaultLedgerCheckDays)) {
+		t.Errorf("expected the check to default to the last week, got %+v, %v", opts, err)
+	}
+
+	if opts, err := parseLedgerCheck(ctx, []string{"--since", "2022-04-01"}); err != nil || opts.Since.Format(DateLayout) != "2022-04-01" {
+		t.Errorf("unexpected options %+v, %v", opts, err)
+	}
+}
+
+func TestParseEngineReplay(t *testing.T) {
+	ctx, _, _ := newTestContext(t)
+
+	opts, err := parseEngineReplay(ctx, []string{"--file", "capture.jsonl", "--market", "btcusdt,ethusdt", "--from", "2022-05-10T10:00:00Z", "--speed", "10", "--flags", "fifo_tiebreak_v2=true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(opts.Options.Markets) != 2 || opts.Options.Speed != 10 || !opts.Options.To.IsZero() || !opts.Options.Flags[types.FeatureFifoTiebreakV2] {
+		t.Errorf("unexpected options %+v", opts.Options)
+	}
+
+	var usage_error *UsageError
+	for _, args := range [][]string{
+		{},
+		{"--file", "capture.jsonl", "--speed", "-1"},
+		{"--file", "capture.jsonl", "--from", "2022-05-10"},
+		{"--file", "capture.jsonl", "--from", "2022-05-10T10:00:00Z", "--to", "2022-05-10T09:00:00Z"},
+		{"--file", "capture.jsonl", "--flags", "fifo_tiebreak_v2"},
+	} {
+		if _, err := parseEngineReplay(ctx, args); !errors.As(err, &usage_error) {
+			t.Errorf("expected %v to be a usage error, got %v", args, err)
+		}
+	}
+}
+
+func TestParseEngineReproduce(t *testing.T) {
+	ctx, _, _ := newTestContext(t)
+
+	opts, err := parseEngineReproduce(ctx, []string{"--file", "btcusdt.wal", "--market-price", "100.5", "--flags", "fifo_tiebreak_v2=true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !opts.Options.MarketPrice.Equal(decimal.RequireFromString("100.5")) || !opts.Options.Flags[types.FeatureFifoTiebreakV2] {
+		t.Errorf("unexpected options %+v", opts.Options)
+	}
+
+	var usage_error *UsageError
+	for _, args := range [][]string{
+		{},
+		{"--file", "btcusdt.wal", "--market-price", "-1"},
+		{"--file", "btcusdt.wal", "--flags", "fifo_tiebreak_v2"},
+	} {
+		if _, err := parseEngineReproduce(ctx, args); !errors.As(err, &usage_error) {
+			t.Errorf("expected %v to be a usage error, got %v", args, err)
+		}
+	}
+}
+
+func TestParseServe(t *testing.T) {
+	ctx, _, _ := newTestContext(t)
+
+	if id, err := parseServeWorker(ctx, []string{"trade_executor"}); err != nil || id != "trade_executor" {
+		t.Errorf("unexpected worker %s, %v", id, err)
+	}
+
+	if ids, err := parseServeDaemon(ctx, []string{"algo_order_scheduler", "report_generator"}); err != nil || len(ids) != 2 {
+		t.Errorf("unexpected daemons %v, %v", ids, err)
+	}
+
+	if opts, err := parseServeAPI(ctx, []string{"--addr", ":8080"}); err != nil || opts.Addr != ":8080" {
+		t.Errorf("unexpected options %+v, %v", opts, err)
+	}
+}
+
+func TestParseFastAckClose(t *testing.T) {
+	ctx, _, _ := newTestContext(t)
+
+	if reason, err := parseFastAckClose(ctx, []string{"--reason", "ledger incident"}); err != nil || reason != "ledger incident" {
+		t.Errorf("unexpected reason %q, %v", reason, err)
+	}
+
+	var usage_error *UsageError
+	if _, err := parseFastAckClose(ctx, nil); !errors.As(err, &usage_error) {
+		t.Errorf("expected a closure without a reason to be a usage error, got %v", err)
+	}
+}
+
+func TestParseMarketDelist(t *testing.T) {
+	ctx, _, _ := newTestContext(t)
+
+	opts, err := parseMarketDelist(ctx, []string{"--market", "btcusdt", "--cancel-at", "2022-05-11T10:00:00Z", "--halt-at", "2022-05-11T12:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.Market != "btcusdt" || !opts.CancelOnlyAt.IsZero() || !opts.HaltAt.Equal(opts.CancelAt.Add(2*time.Hour)) {
+		t.Errorf("unexpected options %+v", opts)
+	}
+
+	var usage_error *UsageError
+	for _, args := range [][]string{
+		{"--cancel-at", "2022-05-11T10:00:00Z", "--halt-at", "2022-05-11T12:00:00Z"},
+		{"--market", "btcusdt", "--halt-at", "2022-05-11T12:00:00Z"},
+		{"--market", "btcusdt", "--cancel-at", "2022-05-11", "--halt-at", "2022-05-11T12:00:00Z"},
+		{"--market", "btcusdt", "--cancel-at", "2022-05-11T12:00:00Z", "--halt-at", "2022-05-11T10:00:00Z"},
+		{"--market", "btcusdt", "--cancel-only-at", "2022-05-11T11:00:00Z", "--cancel-at", "2022-05-11T10:00:00Z", "--halt-at", "2022-05-11T12:00:00Z"},
+	} {
+		if _, err := parseMarketDelist(ctx, args); !errors.As(err, &usage_error) {
+			t.Errorf("expected %v to be a usage error, got %v", args, err)
+		}
+	}
+
+	if _, err := parseMarketFlag(ctx, "market delisting", nil); !errors.As(err, &usage_error) {
+		t.Errorf("expected a delisting without a market to be a usage error, got %v", err)
+	}
+}
+
+func TestParseKlinesRebuild(t *testing.T) {
+	ctx, _, _ := newTestContext(t)
+
+	opts, err := parseKlinesRebuild(ctx, []string{"--market", "btcusdt", "--from", "2022-05-11T10:00:00Z", "--to", "2022-05-11T12:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.Market != "btcusdt" || len(opts.Periods) != len(models.DefaultKlinePeriods) || !opts.To.Equal(opts.From.Add(2*time.Hour)) {
+		t.Errorf("unexpected options %+v", opts)
+	}
+
+	opts, err = parseKlinesRebuild(ctx, []string{"--market", "btcusdt", "--period", "1h", "--from", "2022-05-11T10:00:00Z", "--to", "2022-05-11T12:00:00Z"})
+	if err != nil || len(opts.Periods) != 1 || opts.Periods[0] != "1h" {
+		t.Errorf("expected the 1h candles rebuilt, got %+v, %v", opts, err)
+	}
+
+	var usage_error *UsageError
+	for _, args := range [][]string{
+		{"--from", "2022-05-11T10:00:00Z", "--to", "2022-05-11T12:00:00Z"},
+		{"--market", "btcusdt", "--to", "2022-05-11T12:00:00Z"},
+		{"--market", "btcusdt", "--from", "2022-05-11", "--to", "2022-05-11T12:00:00Z"},
+		{"--market", "btcusdt", "--from", "2022-05-11T12:00:00Z", "--to", "2022-05-11T10:00:00Z"},
+		{"--market", "btcusdt", "--period", "8h", "--from", "2022-05-11T10:00:00Z", "--to", "2022-05-11T12:00:00Z"},
+	} {
+		if _, err := parseKlinesRebuild(ctx, args); !errors.As(err, &usage_error) {
+			t.Errorf("expected %v to be a usage error, got %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"time"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+)
+
+type commissionBackfillOptions struct {
+	From   time.Time
+	To     time.Time
+	DryRun bool
+}
+
+func parseCommissionBackfill(ctx *Context, args []string) (*commissionBackfillOptions, error) {
+	opts := &commissionBackfillOptions{}
+
+	var from, to string
+	fs := newFlagSet(ctx, "commission backfill")
+	fs.StringVar(&from, "from", "", "first day to release, "+DateLayout)
+	fs.StringVar(&to, "to", "", "last day to release, "+DateLayout+", defaults to --from")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "print the releases without saving them")
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if opts.From, err = parseDate("from", from); err != nil {
+		return nil, err
+	}
+
+	opts.To = opts.From
+	if len(to) > 0 {
+		if opts.To, err = parseDate("to", to); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.To.Before(opts.From) {
+		return nil, usagef("--to is before --from")
+	}
+
+	return opts, nil
+}
+
+// commissionBackfill releases the commissions of the days the release job missed, members already released are skipped
+// so it can run again over the same days.
+func commissionBackfill(ctx *Context, args []string) error {
+	opts, err := parseCommissionBackfill(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+
+	releases, err := models.BackfillCommissionReleases(config.DataBase, opts.From, opts.To, ctx.Now(), opts.DryRun)
+	for _, release := range releases {
+		ctx.Printf("%s member=%d friend_trade=%d earned_btc=%s\n", release.CreatedAt.AddDate(0, 0, -1).Format(DateLayout), release.MemberID, release.FriendTrade, release.EarnedBTC)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if opts.DryRun {
+		ctx.Printf("%d releases to backfill, nothing saved\n", len(releases))
+	} else {
+		ctx.Printf("%d releases backfilled\n", len(releases))
+	}
+
+	return nil
+}
@@ -0,0 +1,282 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	GrpcEngine "github.com/zsmartex/pkg/Grpc/engine"
+	GrpcSymbol "github.com/zsmartex/pkg/Grpc/symbol"
+	clientEngine "github.com/zsmartex/pkg/client/engine"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/matching/replay"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/types"
+)
+
+type engineSnapshotOptions struct {
+	Market string
+	Limit  int64
+}
+
+func parseEngineSnapshot(ctx *Context, args []string) (*engineSnapshotOptions, error) {
+	opts := &engineSnapshotOptions{}
+
+	fs := newFlagSet(ctx, "engine snapshot")
+	fs.StringVar(&opts.Market, "market", "", "market of the order book")
+	fs.Int64Var(&opts.Limit, "limit", 100, "price levels of each side")
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+
+	if len(opts.Market) == 0 {
+		return nil, usagef("--market is required")
+	}
+
+	if opts.Limit <= 0 {
+		return nil, usagef("--limit must be positive")
+	}
+
+	return opts, nil
+}
+
+type bookSnapshot struct {
+	Market   string              `json:"market"`
+	Sequence int64               `json:"sequence"`
+	Asks     [][]decimal.Decimal `json:"asks"`
+	Bids     [][]decimal.Decimal `json:"bids"`
+}
+
+func bookLevels(orders []*GrpcEngine.BookOrder) [][]decimal.Decimal {
+	levels := make([][]decimal.Decimal, 0, len(orders))
+	for _, order := range orders {
+		levels = append(levels, []decimal.Decimal{order.PriceQuantity[0].ToDecimal(), order.PriceQuantity[1].ToDecimal()})
+	}
+
+	return levels
+}
+
+// engineSnapshot prints the price levels of a market held by the running engine as JSON.
+func engineSnapshot(ctx *Context, args []string) error {
+	opts, err := parseEngineSnapshot(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", opts.Market); result.Error != nil {
+		return fmt.Errorf("market %s: %w", opts.Market, result.Error)
+	}
+
+	matching_client := clientEngine.NewMatchingClient()
+	defer matching_client.Close()
+
+	symbol := market.GetSymbol()
+	response, err := matching_client.FetchOrderBook(&GrpcEngine.FetchOrderBookRequest{
+		Symbol: &GrpcSymbol.Symbol{BaseCurrency: symbol.BaseCurrency, QuoteCurrency: symbol.QuoteCurrency},
+		Limit:  opts.Limit,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch the order book of %s: %w", opts.Market, err)
+	}
+
+	snapshot, err := json.MarshalIndent(&bookSnapshot{
+		Market:   market.Symbol,
+		Sequence: response.Sequence,
+		Asks:     bookLevels(response.Asks),
+		Bids:     bookLevels(response.Bids),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	ctx.Printf("%s\n", snapshot)
+
+	return nil
+}
+
+type engineReplayOptions struct {
+	File    string
+	Options replay.Options
+}
+
+// parseReplayTime parses a bound of the replay window, empty doesn't bound it.
+func parseReplayTime(name, value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, usagef("--%s must be a time like %s", name, time.RFC3339)
+	}
+
+	return at, nil
+}
+
+func parseEngineReplay(ctx *Context, args []string) (*engineReplayOptions, error) {
+	opts := &engineReplayOptions{}
+	var markets, from, to, flags string
+
+	fs := newFlagSet(ctx, "engine replay")
+	fs.StringVar(&opts.File, "file", "", "capture recorded by an engine with engine.capture_dir")
+	fs.StringVar(&markets, "market", "", "comma separated markets replayed, every market of the capture by default")
+	fs.StringVar(&from, "from", "", "first command compared, "+time.RFC3339)
+	fs.StringVar(&to, "to", "", "end of the commands compared, "+time.RFC3339)
+	fs.Float64Var(&opts.Options.Speed, "speed", 1, "times faster than captured the commands are replayed, 0 as fast as possible")
+	fs.StringVar(&flags, "flags", "", "feature flags overriding the captured ones, like use_price_level_book=true,fifo_tiebreak_v2=false")
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+
+	if len(opts.File) == 0 {
+		return nil, usagef("--file is required")
+	}
+
+	if opts.Options.Speed < 0 {
+		return nil, usagef("--speed can't be negative")
+	}
+
+	if len(markets) > 0 {
+		opts.Options.Markets = strings.Split(markets, ",")
+	}
+
+	var err error
+	if opts.Options.From, err = parseReplayTime("from", from); err != nil {
+		return nil, err
+	}
+	if opts.Options.To, err = parseReplayTime("to", to); err != nil {
+		return nil, err
+	}
+
+	if !opts.Options.From.IsZero() && !opts.Options.To.IsZero() && !opts.Options.From.Before(opts.Options.To) {
+		return nil, usagef("--from must be before --to")
+	}
+
+	if opts.Options.Flags, err = parseFeatureFlags(flags); err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
+
+// parseFeatureFlags parses the feature flags overriding the ones of the books, nil when there's none.
+func parseFeatureFlags(flags string) (map[types.FeatureFlag]bool, error) {
+	if len(flags) == 0 {
+		return nil, nil
+	}
+
+	overrides := make(map[types.FeatureFlag]bool)
+	for _, flag := range strings.Split(flags, ",") {
+		name, value, found := strings.Cut(flag, "=")
+		enabled, err := strconv.ParseBool(value)
+		if !found || err != nil {
+			return nil, usagef("--flags must be like use_price_level_book=true")
+		}
+
+		overrides[types.FeatureFlag(name)] = enabled
+	}
+
+	return overrides, nil
+}
+
+// engineReplay replays a capture into books off the broker and prints the divergences from the captured
+// trades and depth as JSON, it fails when the replay diverged. It needs neither the database nor the broker.
+func engineReplay(ctx *Context, args []string) error {
+	opts, err := parseEngineReplay(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(opts.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	report, err := replay.Replay(file, opts.Options)
+	if err != nil {
+		return err
+	}
+
+	output, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	ctx.Printf("%s\n", output)
+
+	if report.Diverged() {
+		return fmt.Errorf("the replay diverged from the capture in %d places", len(report.Divergences))
+	}
+
+	return nil
+}
+
+type engineReproduceOptions struct {
+	File    string
+	Options replay.ReproduceOptions
+}
+
+func parseEngineReproduce(ctx *Context, args []string) (*engineReproduceOptions, error) {
+	opts := &engineReproduceOptions{}
+	var market_price, flags string
+
+	fs := newFlagSet(ctx, "engine reproduce")
+	fs.StringVar(&opts.File, "file", "", "command log of a book, the <market>.wal file of engine.wal_dir")
+	fs.StringVar(&market_price, "market-price", "0", "market price the books start with")
+	fs.StringVar(&flags, "flags", "", "feature flags of the books, like use_price_level_book=true,fifo_tiebreak_v2=false")
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+
+	if len(opts.File) == 0 {
+		return nil, usagef("--file is required")
+	}
+
+	price, err := decimal.NewFromString(market_price)
+	if err != nil || price.IsNegative() {
+		return nil, usagef("--market-price must be a positive decimal")
+	}
+	opts.Options.MarketPrice = price
+
+	if opts.Options.Flags, err = parseFeatureFlags(flags); err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
+
+// engineReproduce processes a command log into empty books and prints the trades and the depth diffs they produce
+// as JSON lines, the same for the same log, to be diffed with the events of the engine. It needs neither the
+// database nor the broker.
+func engineReproduce(ctx *Context, args []string) error {
+	opts, err := parseEngineReproduce(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(opts.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	summary, err := replay.Reproduce(file, ctx.Stdout, opts.Options)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(ctx.Stderr, "%d commands, %d skipped: %d trades and %d depth diffs\n", summary.Commands, summary.Skipped, summary.Trades, summary.Diffs)
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+)
+
+type exportTradesOptions struct {
+	Market string
+	Date   time.Time
+	Output string
+	DryRun bool
+}
+
+func parseExportTrades(ctx *Context, args []string) (*exportTradesOptions, error) {
+	opts := &exportTradesOptions{}
+
+	var date string
+	fs := newFlagSet(ctx, "export trades")
+	fs.StringVar(&opts.Market, "market", "", "market of the trades")
+	fs.StringVar(&date, "date", "", "UTC day of the trades, "+DateLayout)
+	fs.StringVar(&opts.Output, "output", "-", "file the CSV is written to, - for stdout")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "count the trades without writing them")
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+
+	if len(opts.Market) == 0 {
+		return nil, usagef("--market is required")
+	}
+
+	if len(date) == 0 {
+		return nil, usagef("--date is required")
+	}
+
+	// trades are archived by UTC day, a day not over yet can't be exported
+	day, err := models.ParseTradeArchiveDate(date, ctx.Now())
+	if err != nil {
+		return nil, usagef("--date must be a past day like %s", DateLayout)
+	}
+	opts.Date = day
+
+	return opts, nil
+}
+
+// exportTrades writes the trades of a market on a day in the format of the daily archives.
+func exportTrades(ctx *Context, args []string) error {
+	opts, err := parseExportTrades(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+
+	var w io.Writer = ctx.Stdout
+	switch {
+	case opts.DryRun:
+		w = io.Discard
+	case opts.Output != "-":
+		file, err := os.Create(opts.Output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		w = file
+	}
+
+	count, err := models.WriteTradeArchive(config.DataBase, w, opts.Market, opts.Date)
+	if err != nil {
+		return err
+	}
+
+	// the summary goes to stderr when the CSV is written to stdout
+	summary := ctx.Stdout
+	if !opts.DryRun && opts.Output == "-" {
+		summary = ctx.Stderr
+	}
+
+	if opts.DryRun {
+		fmt.Fprintf(summary, "%d trades of %s on %s, nothing written\n", count, opts.Market, opts.Date.Format(DateLayout))
+	} else {
+		fmt.Fprintf(summary, "%d trades of %s on %s exported\n", count, opts.Market, opts.Date.Format(DateLayout))
+	}
+
+	return nil
+}
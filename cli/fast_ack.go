@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"github.com/zsmartex/finex/models"
+)
+
+func parseFastAckClose(ctx *Context, args []string) (string, error) {
+	var reason string
+
+	fs := newFlagSet(ctx, "fast_ack close")
+	fs.StringVar(&reason, "reason", "", "why the fast path is closed, shown in the logs of the API")
+	if err := parseFlags(fs, args); err != nil {
+		return "", err
+	}
+
+	if len(reason) == 0 {
+		return "", usagef("--reason is required")
+	}
+
+	return reason, nil
+}
+
+// fastAckClose closes the fast order acknowledgement of every API process, orders are placed synchronously.
+func fastAckClose(ctx *Context, args []string) error {
+	reason, err := parseFastAckClose(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+
+	if err := models.CloseFastAck(reason); err != nil {
+		return err
+	}
+
+	ctx.Printf("fast order acknowledgement closed\n")
+
+	return nil
+}
+
+// fastAckReopen reopens the fast order acknowledgement, the API processes reopen on their next reconcile.
+func fastAckReopen(ctx *Context, args []string) error {
+	fs := newFlagSet(ctx, "fast_ack reopen")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+
+	if err := models.ReopenFastAck(); err != nil {
+		return err
+	}
+
+	ctx.Printf("fast order acknowledgement reopened\n")
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"time"
+
+	"github.com/zsmartex/finex/models"
+)
+
+type klinesRebuildOptions struct {
+	Market  string
+	Periods []string
+	From    time.Time
+	To      time.Time
+}
+
+// parseKlineTime parses a bound of the rebuilt range, it's required.
+func parseKlineTime(name, value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, usagef("--%s is required", name)
+	}
+
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, usagef("--%s must be a time like %s", name, time.RFC3339)
+	}
+
+	return at, nil
+}
+
+func parseKlinesRebuild(ctx *Context, args []string) (*klinesRebuildOptions, error) {
+	opts := &klinesRebuildOptions{}
+
+	var period, from, to string
+	fs := newFlagSet(ctx, "klines rebuild")
+	fs.StringVar(&opts.Market, "market", "", "market of the candles")
+	fs.StringVar(&period, "period", "", "period of the candles, every period of klines.periods when it's not set")
+	fs.StringVar(&from, "from", "", "start of the range, "+time.RFC3339)
+	fs.StringVar(&to, "to", "", "end of the range, "+time.RFC3339)
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+
+	if len(opts.Market) == 0 {
+		return nil, usagef("--market is required")
+	}
+
+	opts.Periods = models.KlinePeriods()
+	if len(period) > 0 {
+		if !models.KeptKlinePeriod(period) {
+			return nil, usagef("--period must be one of %v", models.KlinePeriods())
+		}
+		opts.Periods = []string{period}
+	}
+
+	var err error
+	if opts.From, err = parseKlineTime("from", from); err != nil {
+		return nil, err
+	}
+
+	if opts.To, err = parseKlineTime("to", to); err != nil {
+		return nil, err
+	}
+
+	if !opts.From.Before(opts.To) {
+		return nil, usagef("--to isn't after --from")
+	}
+
+	return opts, nil
+}
+
+// klinesRebuild rebuilds the candles of a market over a range from the trades table, for the trades the kline
+// builder missed. It can run while the builder runs and again over the same range.
+func klinesRebuild(ctx *Context, args []string) error {
+	opts, err := parseKlinesRebuild(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+
+	store := models.Klines()
+	for _, period := range opts.Periods {
+		count, err := models.RebuildKlines(store, opts.Market, period, opts.From, opts.To)
+		if err != nil {
+			return err
+		}
+
+		ctx.Printf("%s %s: %d candles rebuilt\n", opts.Market, period, count)
+	}
+
+	return nil
+}
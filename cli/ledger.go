@@ -0,0 +1,162 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+)
+
+// sampleRows is the number of rows printed for each problem found.
+const sampleRows = 10
+
+// defaultLedgerCheckDays is how far back the rounding drift is checked when --since isn't set.
+const defaultLedgerCheckDays = 7
+
+type ledgerCheckOptions struct {
+	Since time.Time
+}
+
+func parseLedgerCheck(ctx *Context, args []string) (*ledgerCheckOptions, error) {
+	opts := &ledgerCheckOptions{}
+
+	var since string
+	fs := newFlagSet(ctx, "ledger check")
+	fs.StringVar(&since, "since", "", "first day the rounding drift is checked, "+DateLayout)
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+
+	if len(since) == 0 {
+		opts.Since = ctx.Now().AddDate(0, 0, -defaultLedgerCheckDays)
+		return opts, nil
+	}
+
+	var err error
+	if opts.Since, err = parseDate("since", since); err != nil {
+		return nil, err
+	}
+
+	return opts, nil
+}
+
+// checkNegativeAccounts reports the accounts with a negative balance or locked amount.
+func checkNegativeAccounts(ctx *Context, tx *gorm.DB) (int, error) {
+	var accounts []*models.Account
+	if result := tx.Where("balance < 0 OR locked < 0").Order("member_id, currency_id").Limit(sampleRows).Find(&accounts); result.Error != nil {
+		return 0, result.Error
+	}
+
+	for _, account := range accounts {
+		ctx.Printf("account member=%d currency=%s balance=%s locked=%s is negative\n", account.MemberID, account.CurrencyID, account.Balance, account.Locked)
+	}
+
+	return len(accounts), nil
+}
+
+// checkDecimalScales reports the columns with values exceeding their scale, it returns how many columns do.
+func checkDecimalScales(ctx *Context, tx *gorm.DB) (int, error) {
+	exceeding := 0
+	for _, audit := range models.DecimalScaleAudits {
+		var count int64
+		if result := audit.Query(tx).Count(&count); result.Error != nil {
+			return exceeding, fmt.Errorf("%s.%s: %w", audit.Table, audit.Column, result.Error)
+		}
+
+		if count == 0 {
+			continue
+		}
+
+		var ids []int64
+		if audit.Table != "accounts" {
+			audit.Query(tx).Order(audit.Table+".id asc").Limit(sampleRows).Pluck(audit.Table+".id", &ids)
+		}
+
+		exceeding++
+		ctx.Printf("%s.%s: %d rows exceed scale %s, first ids %v\n", audit.Table, audit.Column, count, audit.Scale, ids)
+	}
+
+	return exceeding, nil
+}
+
+// checkRoundingDrift reports the code paths whose rounding drifted a currency beyond the threshold on a day.
+func checkRoundingDrift(ctx *Context, tx *gorm.DB, since time.Time) (int, error) {
+	drifts, err := models.RoundingDriftReport(tx, since, ctx.Now(), "", decimal.Zero)
+	if err != nil {
+		return 0, err
+	}
+
+	flagged := 0
+	for _, drift := range drifts {
+		if drift.Flagged {
+			flagged++
+			ctx.Printf("rounding of %s drifted %s %s on %s over %d rows\n", drift.Path, drift.CurrencyID, drift.Drift, drift.Day.Format(DateLayout), drift.Rows)
+		}
+	}
+
+	return flagged, nil
+}
+
+// ledgerCheck checks the accounts, the decimals stored and the rounding drift since --since,
+// it fails when any of them finds a problem.
+func ledgerCheck(ctx *Context, args []string) error {
+	opts, err := parseLedgerCheck(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+
+	problems := 0
+	for _, check := range []func() (int, error){
+		func() (int, error) { return checkNegativeAccounts(ctx, config.DataBase) },
+		func() (int, error) { return checkDecimalScales(ctx, config.DataBase) },
+		func() (int, error) { return checkRoundingDrift(ctx, config.DataBase, opts.Since) },
+	} {
+		found, err := check()
+		if err != nil {
+			return err
+		}
+
+		problems += found
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%d problems found", problems)
+	}
+
+	ctx.Printf("The ledger is consistent\n")
+
+	return nil
+}
+
+// ledgerDecimals reports the rows stored before decimals were normalized on save whose values exceed the scale of their column.
+func ledgerDecimals(ctx *Context, args []string) error {
+	fs := newFlagSet(ctx, "ledger decimals")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+
+	exceeding, err := checkDecimalScales(ctx, config.DataBase)
+	if err != nil {
+		return err
+	}
+
+	if exceeding > 0 {
+		return fmt.Errorf("%d columns exceed their scale", exceeding)
+	}
+
+	ctx.Printf("No decimal exceeds the scale of its column\n")
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+)
+
+type marketDelistOptions struct {
+	Market       string
+	CancelOnlyAt time.Time
+	CancelAt     time.Time
+	HaltAt       time.Time
+}
+
+// parseDelistingTime parses a time of the schedule of a delisting, empty is the zero time.
+func parseDelistingTime(name, value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, usagef("--%s must be a time like %s", name, time.RFC3339)
+	}
+
+	return at, nil
+}
+
+func parseMarketDelist(ctx *Context, args []string) (*marketDelistOptions, error) {
+	opts := &marketDelistOptions{}
+	var cancel_only_at, cancel_at, halt_at string
+
+	fs := newFlagSet(ctx, "market delist")
+	fs.StringVar(&opts.Market, "market", "", "market delisted")
+	fs.StringVar(&cancel_only_at, "cancel-only-at", "", "when the market starts to take cancels only, right away when it's not set, "+time.RFC3339)
+	fs.StringVar(&cancel_at, "cancel-at", "", "when the orders left are cancelled, "+time.RFC3339)
+	fs.StringVar(&halt_at, "halt-at", "", "when the market is halted, hidden and delisted, "+time.RFC3339)
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+
+	if len(opts.Market) == 0 {
+		return nil, usagef("--market is required")
+	}
+
+	if len(cancel_at) == 0 || len(halt_at) == 0 {
+		return nil, usagef("--cancel-at and --halt-at are required")
+	}
+
+	var err error
+	if opts.CancelOnlyAt, err = parseDelistingTime("cancel-only-at", cancel_only_at); err != nil {
+		return nil, err
+	}
+
+	if opts.CancelAt, err = parseDelistingTime("cancel-at", cancel_at); err != nil {
+		return nil, err
+	}
+
+	if opts.HaltAt, err = parseDelistingTime("halt-at", halt_at); err != nil {
+		return nil, err
+	}
+
+	if opts.HaltAt.Before(opts.CancelAt) || !opts.CancelOnlyAt.IsZero() && opts.CancelAt.Before(opts.CancelOnlyAt) {
+		return nil, usagef("the times must be --cancel-only-at, --cancel-at then --halt-at")
+	}
+
+	return opts, nil
+}
+
+func parseMarketFlag(ctx *Context, name string, args []string) (string, error) {
+	var market string
+
+	fs := newFlagSet(ctx, name)
+	fs.StringVar(&market, "market", "", "market of the delisting")
+	if err := parseFlags(fs, args); err != nil {
+		return "", err
+	}
+
+	if len(market) == 0 {
+		return "", usagef("--market is required")
+	}
+
+	return market, nil
+}
+
+// marketDelist schedules the delisting of a market, the market_delister daemon runs its steps.
+func marketDelist(ctx *Context, args []string) error {
+	opts, err := parseMarketDelist(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", opts.Market); result.Error != nil {
+		return fmt.Errorf("market %s: %w", opts.Market, result.Error)
+	}
+
+	delisting, err := models.NewMarketDelisting(market, opts.CancelOnlyAt, opts.CancelAt, opts.HaltAt, "", ctx.Now())
+	if err != nil {
+		return err
+	}
+
+	if err := models.ScheduleMarketDelisting(config.DataBase, delisting); err != nil {
+		return err
+	}
+
+	return printMarketDelisting(ctx, market.Symbol)
+}
+
+// marketDelisting prints the progress of the latest delisting of a market.
+func marketDelisting(ctx *Context, args []string) error {
+	market, err := parseMarketFlag(ctx, "market delisting", args)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+
+	return printMarketDelisting(ctx, market)
+}
+
+// marketAbortDelisting aborts the delisting of a market until its orders start to be cancelled.
+func marketAbortDelisting(ctx *Context, args []string) error {
+	market, err := parseMarketFlag(ctx, "market abort_delisting", args)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+
+	delisting, _, _, err := models.LastMarketDelisting(config.DataBase, market)
+	if err != nil {
+		return fmt.Errorf("delisting of %s: %w", market, err)
+	}
+
+	if err := models.AbortMarketDelisting(config.DataBase, delisting, "", models.BusDelistingEngine{}, ctx.Now()); err != nil {
+		return err
+	}
+
+	return printMarketDelisting(ctx, market)
+}
+
+func printMarketDelisting(ctx *Context, market string) error {
+	delisting, logs, snapshots, err := models.LastMarketDelisting(config.DataBase, market)
+	if err != nil {
+		return fmt.Errorf("delisting of %s: %w", market, err)
+	}
+
+	open_orders, err := models.OpenOrdersCount(config.DataBase, market)
+	if err != nil {
+		return err
+	}
+
+	now := ctx.Now()
+	next_step, due := delisting.NextStep(now)
+
+	ctx.Printf("delisting of %s: %s\n", delisting.MarketID, delisting.State)
+	ctx.Printf("  cancel only at %s, cancels at %s, halt at %s\n",
+		delisting.CancelOnlyAt.Format(time.RFC3339), delisting.CancelAt.Format(time.RFC3339), delisting.HaltAt.Format(time.RFC3339))
+	if len(next_step) > 0 {
+		ctx.Printf("  next step %s, due %t, abortable %t\n", next_step, due, delisting.Abortable(now))
+	}
+	ctx.Printf("  open orders %d\n", open_orders)
+
+	for _, log := range logs {
+		step := string(log.Step)
+		if len(step) == 0 {
+			step = "-"
+		}
+
+		ctx.Printf("  %s %-13s %s\n", log.CreatedAt.Format(time.RFC3339), step, log.Message)
+	}
+
+	for _, snapshot := range snapshots {
+		ctx.Printf("  final %-3s open %s high %s low %s close %s volume %s\n",
+			snapshot.Period, snapshot.Open, snapshot.High, snapshot.Low, snapshot.Close, snapshot.Volume)
+	}
+
+	return nil
+}
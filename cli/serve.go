@@ -0,0 +1,335 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	GrpcEngine "github.com/zsmartex/pkg/Grpc/engine"
+	"google.golang.org/grpc"
+
+	"github.com/zsmartex/finex/bus"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/matching"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/routes"
+	engine "github.com/zsmartex/finex/server"
+	"github.com/zsmartex/finex/streams"
+	"github.com/zsmartex/finex/workers/daemons"
+	"github.com/zsmartex/finex/workers/engines"
+)
+
+type serveAPIOptions struct {
+	Addr string
+}
+
+func parseServeAPI(ctx *Context, args []string) (*serveAPIOptions, error) {
+	opts := &serveAPIOptions{}
+
+	fs := newFlagSet(ctx, "serve api")
+	fs.StringVar(&opts.Addr, "addr", ":3000", "address to listen on")
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() > 0 {
+		return nil, usagef("unexpected arguments %v", fs.Args())
+	}
+
+	return opts, nil
+}
+
+func serveAPI(ctx *Context, args []string) error {
+	opts, err := parseServeAPI(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+
+	if err := models.LoadPreTradeChecks(config.PreTradeChecks); err != nil {
+		return err
+	}
+
+	if config.FastAck.Enabled {
+		models.StartFastAck()
+	}
+
+	streams.StartPrivateHub()
+
+	return routes.SetupRouter().Listen(opts.Addr)
+}
+
+// serveEngine serves the matching engine on ENGINE_PORT, its status on ENGINE_STATUS_PORT and the public channels
+// of its books on ENGINE_STREAM_PORT when they're set.
+func serveEngine(ctx *Context, args []string) error {
+	fs := newFlagSet(ctx, "serve engine")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+
+	matching.DepthBatches = events.NewStreamBatcher("depth", config.MarketData.DepthBatchInterval)
+	matching.DepthBatches.Start()
+
+	var public_streams *engine.PublicStreams
+	stream_port := os.Getenv("ENGINE_STREAM_PORT")
+	if len(stream_port) > 0 {
+		public_streams = engine.NewPublicStreams()
+	}
+
+	server := engine.NewEngineServer(public_streams)
+	grpcServer := grpc.NewServer()
+
+	router := engine.NewEngineRouter(server.ProcessCommand, config.Engine.LaneCapacity)
+	router.Park = func(payload []byte, err error) error {
+		return events.ParkDeadLetter("matching", payload, err)
+	}
+	server.Router = router
+
+	consumer := engine.NewConsumerSupervisor("zsmartex", []string{"matching"}, router.Route)
+	consumer.OnHalt = server.HaltMarkets
+	consumer.OnResume = server.ResumeMarkets
+	server.Consumer = consumer.Health
+
+	matching.PrometheusRegistry.MustRegister(server.Collector())
+
+	go consumer.Run()
+
+	go server.ReportMetrics()
+	go server.CheckInvariants()
+
+	if status_port := os.Getenv("ENGINE_STATUS_PORT"); len(status_port) > 0 {
+		go func() {
+			if err := server.NewStatusRouter().Listen(":" + status_port); err != nil {
+				config.Logger.Errorf("Failed to serve engine status: %v", err)
+			}
+		}()
+	}
+
+	if public_streams != nil {
+		go public_streams.ReportTickers()
+		go func() {
+			if err := public_streams.NewPublicRouter().Listen(":" + stream_port); err != nil {
+				config.Logger.Errorf("Failed to serve the public streams: %v", err)
+			}
+		}()
+	}
+
+	config.Logger.Info("Starting Finex G-RPC")
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", os.Getenv("ENGINE_PORT")))
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	GrpcEngine.RegisterMatchingEngineServiceServer(grpcServer, server)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- grpcServer.Serve(lis)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-served:
+		return err
+	case sig := <-signals:
+		config.Logger.Infof("Received %s, shutting down the engine", sig)
+	}
+
+	// the clients of the grpc server read the books, they're served until the books are snapshotted
+	defer grpcServer.Stop()
+
+	return server.Shutdown(consumer, engine.ShutdownTimeout())
+}
+
+func serveCron(ctx *Context, args []string) error {
+	return serveDaemon(ctx, append([]string{"cron_job"}, args...))
+}
+
+// NewWorker returns the broker worker consuming the topic id, nil when there's none.
+func NewWorker(id string) engines.Worker {
+	switch id {
+	case "order_processor":
+		return engines.NewOrderProcessorWorker()
+	case "trade_executor":
+		return engines.NewTradeExecutorWorker()
+	case "ieo_order_processor":
+		return engines.NewIEOOrderProcessorWorker()
+	case "ieo_order_executor":
+		return engines.NewIEOOrderExecutorWorker()
+	case "security_event_recorder":
+		return engines.NewSecurityEventRecorderWorker()
+	case "dead_letter_recorder":
+		return engines.NewDeadLetterRecorderWorker()
+	case "kline_builder":
+		return engines.NewKlineBuilderWorker()
+	default:
+		return nil
+	}
+}
+
+// NewDaemon returns the daemon id, nil when there's none.
+func NewDaemon(id string) daemons.Worker {
+	switch id {
+	case "cron_job":
+		return daemons.NewCronJob()
+	case "algo_order_scheduler":
+		return daemons.NewAlgoOrderScheduler()
+	case "report_generator":
+		return daemons.NewReportGenerator()
+	case "background_migrator":
+		return daemons.NewBackgroundMigrator()
+	case "convert_hedger":
+		return daemons.NewConvertHedger()
+	case "scheduled_order_scheduler":
+		return daemons.NewScheduledOrderScheduler()
+	case "market_delister":
+		return daemons.NewMarketDelister()
+	default:
+		return nil
+	}
+}
+
+var workerIDs = []string{"order_processor", "trade_executor", "ieo_order_processor", "ieo_order_executor", "security_event_recorder", "dead_letter_recorder", "kline_builder"}
+
+// workerTopic is the topic the worker id consumes, the topic of its name but for the dead letter recorder and the
+// kline builder.
+func workerTopic(id string) string {
+	switch id {
+	case "dead_letter_recorder":
+		return events.DeadLetterTopic
+	case "kline_builder":
+		return events.ExecutedTradeTopic
+	}
+
+	return id
+}
+
+var daemonIDs = []string{"cron_job", "algo_order_scheduler", "report_generator", "background_migrator", "convert_hedger", "scheduled_order_scheduler", "market_delister"}
+
+func knownID(ids []string, id string) bool {
+	for _, known := range ids {
+		if known == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseServeWorker(ctx *Context, args []string) (string, error) {
+	fs := newFlagSet(ctx, "serve worker")
+	if err := parseFlags(fs, args); err != nil {
+		return "", err
+	}
+
+	if fs.NArg() != 1 || !knownID(workerIDs, fs.Arg(0)) {
+		return "", usagef("expected one worker of %s", strings.Join(workerIDs, ", "))
+	}
+
+	return fs.Arg(0), nil
+}
+
+// serveWorker consumes the topic of the worker and commits each record once processed, failed records are logged,
+// malformed ones are parked as dead letters and the ones to deliver again stop the worker.
+func serveWorker(ctx *Context, args []string) error {
+	id, err := parseServeWorker(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+
+	config.Logger.Infof("Start finex-engine: %s", id)
+	worker := NewWorker(id)
+
+	return config.Bus.Subscribe(workerTopic(id), "zsmartex", func(message *bus.Message) error {
+		config.Logger.Debugf("Recevie message from topic: %s payload: %s", message.Topic, string(message.Value))
+
+		return processRecord(worker, message, events.ParkDeadLetter)
+	})
+}
+
+// processRecord processes a record with worker, a malformed record is parked with park before it's committed. It
+// returns an error when the record can't be parked or is to be delivered again, the record isn't committed and the
+// broker delivers it again.
+func processRecord(worker engines.Worker, record *bus.Message, park func(topic string, payload []byte, err error) error) error {
+	err := worker.Process(record.Value)
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, events.ErrRedeliver) {
+		return fmt.Errorf("failed to process a message of topic %s: %w", record.Topic, err)
+	}
+
+	// the dead letters aren't parked again, the recorder would consume its own
+	if errors.Is(err, events.ErrMalformed) && record.Topic != events.DeadLetterTopic {
+		if park_err := park(record.Topic, record.Value, err); park_err != nil {
+			return fmt.Errorf("failed to park a malformed message of topic %s: %w", record.Topic, park_err)
+		}
+
+		config.Logger.Warnf("Parked a malformed message of topic %s: %v", record.Topic, err)
+
+		return nil
+	}
+
+	config.Logger.Errorf("Worker error: %v", err.Error())
+
+	return nil
+}
+
+func parseServeDaemon(ctx *Context, args []string) ([]string, error) {
+	fs := newFlagSet(ctx, "serve daemon")
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() == 0 {
+		return nil, usagef("expected daemons of %s", strings.Join(daemonIDs, ", "))
+	}
+
+	for _, id := range fs.Args() {
+		if !knownID(daemonIDs, id) {
+			return nil, usagef("unknown daemon %q, expected daemons of %s", id, strings.Join(daemonIDs, ", "))
+		}
+	}
+
+	return fs.Args(), nil
+}
+
+// serveDaemon starts the daemons in order, a daemon runs until the process stops.
+func serveDaemon(ctx *Context, args []string) error {
+	ids, err := parseServeDaemon(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		config.Logger.Infof("Start finex-daemon: %s", id)
+		NewDaemon(id).Start()
+	}
+
+	return nil
+}
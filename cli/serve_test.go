@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/zsmartex/finex/bus"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/workers/engines"
+)
+
+type parkedRecord struct {
+	topic   string
+	payload string
+	err     error
+}
+
+type failingWorker struct {
+	err error
+}
+
+func (w failingWorker) Process(payload []byte) error {
+	return w.err
+}
+
+func TestProcessRecordParksMalformedMessages(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	config.Logger = logrus.NewEntry(logger)
+
+	var parked []parkedRecord
+	park := func(topic string, payload []byte, err error) error {
+		parked = append(parked, parkedRecord{topic, string(payload), err})
+		return nil
+	}
+
+	broken := []struct {
+		worker  engines.Worker
+		topic   string
+		payload string
+	}{
+		{engines.OrderProcessorWorker{}, "order_processor", `{"type":"order","version":10,"action":"submit","id":`},
+		{engines.OrderProcessorWorker{}, "order_processor", `{"type":"order","version":10,"action":"decrement","id":7}`},
+		{engines.OrderProcessorWorker{}, "order_processor", `{"type":"order","version":99,"action":"submit","id":7}`},
+		{&engines.TradeExecutorWorker{}, "trade_executor", `{"type":"trade","version":6,"price":"thirty"}`},
+		{&engines.IEOOrderProcessorWorker{}, "ieo_order_processor", `[1, 2`},
+	}
+
+	for _, record := range broken {
+		if err := processRecord(record.worker, &bus.Message{Topic: record.topic, Value: []byte(record.payload)}, park); err != nil {
+			t.Fatalf("expected %s to be parked, got %v", record.payload, err)
+		}
+	}
+
+	if len(parked) != len(broken) {
+		t.Fatalf("expected the %d broken payloads parked, got %d", len(broken), len(parked))
+	}
+
+	for i, record := range broken {
+		if parked[i].topic != record.topic || parked[i].payload != record.payload || !errors.Is(parked[i].err, events.ErrMalformed) {
+			t.Errorf("expected %s parked from %s as malformed, got %+v", record.payload, record.topic, parked[i])
+		}
+	}
+
+	// a message which fails otherwise is only logged, and the dead letters aren't parked again
+	processRecord(failingWorker{errors.New("database is gone")}, &bus.Message{Topic: "order_processor", Value: []byte(`{}`)}, park)
+	processRecord(engines.DeadLetterRecorderWorker{}, &bus.Message{Topic: events.DeadLetterTopic, Value: []byte(`{"topic":`)}, park)
+
+	if len(parked) != len(broken) {
+		t.Errorf("expected nothing else parked, got %+v", parked[len(broken):])
+	}
+
+	// a message which can't be parked is left to the broker
+	err := processRecord(engines.OrderProcessorWorker{}, &bus.Message{Topic: "order_processor", Value: []byte(`{`)}, func(topic string, payload []byte, err error) error {
+		return errors.New("broker is gone")
+	})
+	if err == nil {
+		t.Error("expected the message which can't be parked to be left uncommitted")
+	}
+
+	// nor is a message to deliver again
+	err = processRecord(failingWorker{events.Redeliver(errors.New("broker is gone"))}, &bus.Message{Topic: "order_processor", Value: []byte(`{}`)}, park)
+	if !errors.Is(err, events.ErrRedeliver) {
+		t.Errorf("expected the message to deliver again to be left uncommitted, got %v", err)
+	}
+
+	if len(parked) != len(broken) {
+		t.Errorf("expected the message to deliver again not to be parked, got %+v", parked[len(broken):])
+	}
+}
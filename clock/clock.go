@@ -0,0 +1,31 @@
+// Package clock is the time source of the engine and the jobs. They're given a Clock rather than calling
+// time.Now, so tests can set and advance the time and a replay can run at the time of the events it replays.
+package clock
+
+import "time"
+
+// Clock tells the time and runs functions after a duration.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine once d elapsed on the clock.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a call scheduled with AfterFunc.
+type Timer interface {
+	// Stop prevents the call, it reports false when the call already ran or was stopped.
+	Stop() bool
+}
+
+// Real is the wall clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,96 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a clock which only moves when it's set or advanced, the calls scheduled on it run when it reaches their time.
+// Unlike time.AfterFunc, the calls run in the goroutine moving the clock, in the order of their time, so a test
+// knows they all ran once Set or Advance returns.
+type Fake struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *Fake
+	at    time.Time
+	f     func()
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (c *Fake) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+func (c *Fake) AfterFunc(d time.Duration, f func()) Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	timer := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+
+	return timer
+}
+
+// Advance moves the clock forward by d, see Set.
+func (c *Fake) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to now and runs the calls due by then. A call scheduling another call due by now runs it too.
+// Setting the clock back doesn't run anything.
+func (c *Fake) Set(now time.Time) {
+	for {
+		c.mutex.Lock()
+
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+
+		if len(c.timers) == 0 || c.timers[0].at.After(now) {
+			c.now = now
+			c.mutex.Unlock()
+			return
+		}
+
+		timer := c.timers[0]
+		c.timers = c.timers[1:]
+		// the call sees the time it was due at
+		if timer.at.After(c.now) {
+			c.now = timer.at
+		}
+		c.mutex.Unlock()
+
+		timer.f()
+	}
+}
+
+// Pending is the number of calls scheduled and not run yet.
+func (c *Fake) Pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.timers)
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,56 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeRunsTheCallsDue(t *testing.T) {
+	start := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFake(start)
+
+	var ran []string
+	clock.AfterFunc(2*time.Minute, func() { ran = append(ran, "second") })
+	clock.AfterFunc(time.Minute, func() {
+		ran = append(ran, "first")
+
+		if !clock.Now().Equal(start.Add(time.Minute)) {
+			t.Errorf("expected the call to see its time, got %s", clock.Now())
+		}
+
+		// rescheduled from a call, due before the clock stops
+		clock.AfterFunc(30*time.Second, func() { ran = append(ran, "rescheduled") })
+	})
+	stopped := clock.AfterFunc(time.Minute, func() { ran = append(ran, "stopped") })
+
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("expected a timer to stop once")
+	}
+
+	clock.Advance(59 * time.Second)
+	if len(ran) > 0 {
+		t.Fatalf("expected nothing to run before its time, got %v", ran)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if len(ran) != 3 || ran[0] != "first" || ran[1] != "rescheduled" || ran[2] != "second" {
+		t.Errorf("expected the calls to run in the order of their time, got %v", ran)
+	}
+
+	if !clock.Now().Equal(start.Add(179*time.Second)) || clock.Pending() != 0 {
+		t.Errorf("unexpected clock at %s with %d calls pending", clock.Now(), clock.Pending())
+	}
+}
+
+func TestFakeSetBack(t *testing.T) {
+	start := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFake(start)
+
+	ran := false
+	clock.AfterFunc(time.Minute, func() { ran = true })
+
+	clock.Set(start.Add(-time.Hour))
+	if ran || !clock.Now().Equal(start.Add(-time.Hour)) || clock.Pending() != 1 {
+		t.Errorf("expected setting the clock back to run nothing")
+	}
+}
@@ -1,17 +1,12 @@
 package main
 
 import (
-	"github.com/zsmartex/finex/config"
-	"github.com/zsmartex/finex/routes"
+	"os"
+
+	"github.com/zsmartex/finex/cli"
 )
 
+// finex-api serves the HTTP API, like finex serve api.
 func main() {
-	if err := config.InitializeConfig(); err != nil {
-		config.Logger.Error(err.Error())
-		return
-	}
-
-	r := routes.SetupRouter()
-	// running
-	r.Listen(":3000")
+	os.Exit(cli.Main(append([]string{"serve", "api"}, os.Args[1:]...)))
 }
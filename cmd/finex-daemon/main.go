@@ -1,34 +1,12 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
-	"github.com/zsmartex/finex/config"
-	"github.com/zsmartex/finex/workers/daemons"
+	"github.com/zsmartex/finex/cli"
 )
 
-func CreateWorker(id string) daemons.Worker {
-	switch id {
-	case "cron_job":
-		return daemons.NewCronJob()
-	default:
-		return nil
-	}
-}
-
+// finex-daemon starts the daemons named by its arguments, like finex serve daemon.
 func main() {
-	if err := config.InitializeConfig(); err != nil {
-		fmt.Println(err.Error())
-		return
-	}
-
-	ARVG := os.Args[1:]
-
-	for _, id := range ARVG {
-		fmt.Println("Start finex-daemon: " + id)
-		worker := CreateWorker(id)
-
-		worker.Start()
-	}
+	os.Exit(cli.Main(append([]string{"serve", "daemon"}, os.Args[1:]...)))
 }
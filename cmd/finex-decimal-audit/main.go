@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"github.com/zsmartex/finex/cli"
+)
+
+// finex-decimal-audit reports the rows stored before decimals were normalized on save
+// whose values exceed the scale of their column, like finex ledger decimals.
+func main() {
+	os.Exit(cli.Main(append([]string{"ledger", "decimals"}, os.Args[1:]...)))
+}
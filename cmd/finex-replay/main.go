@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"github.com/zsmartex/finex/cli"
+)
+
+// finex-replay prints the trades and the depth diffs of a command log of the engine processed into empty books,
+// like finex engine reproduce.
+func main() {
+	os.Exit(cli.Main(append([]string{"engine", "reproduce"}, os.Args[1:]...)))
+}
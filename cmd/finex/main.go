@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+
+	"github.com/zsmartex/finex/cli"
+)
+
+// finex runs the servers and the operational tasks, see the cli package for the commands.
+func main() {
+	os.Exit(cli.Main(os.Args[1:]))
+}
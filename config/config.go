@@ -5,7 +5,9 @@ import (
 	"os"
 	"strings"
 
+	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
+	"github.com/zsmartex/finex/bus"
 	"github.com/zsmartex/finex/types"
 	"github.com/zsmartex/pkg/services"
 	"gopkg.in/yaml.v2"
@@ -15,9 +17,37 @@ import (
 var DataBase *gorm.DB
 var Logger *logrus.Entry
 var KafkaProducer *services.KafkaProducer
+var Bus bus.EventBus
 var RangoClient *services.RangoClient
 var Referral *types.Referral
+var APIVersions map[string]*types.APIVersionConfig
+var EventVersions map[string]int
+var Engine *types.EngineConfig
 var Redis *services.RedisClient
+var CandleIntegrity *types.CandleIntegrityConfig
+var Klines *types.KlinesConfig
+var PreTradeChecks []*types.PreTradeCheckConfig
+var MarketGroupDomains map[string]string
+var AlgoOrders *types.AlgoOrdersConfig
+var DecimalScaleEpsilon decimal.Decimal
+var RoundingDriftThreshold decimal.Decimal
+var Reports *types.ReportsConfig
+var BackgroundMigrations *types.BackgroundMigrationsConfig
+var MarketData *types.MarketDataConfig
+var ConversionBridge string
+var Downloads *types.DownloadsConfig
+var OrderIDs *types.OrderIDsConfig
+var MemberExports *types.MemberExportsConfig
+var Archival *types.ArchivalConfig
+var FastAck *types.FastAckConfig
+var StreamAuth *types.StreamAuthConfig
+var SecurityEvents *types.SecurityEventsConfig
+var RateLimits map[string]*types.RateLimitConfig
+var StreamFanout *types.StreamFanoutConfig
+var Convert *types.ConvertConfig
+var MakerProgram *types.MakerProgramConfig
+var ScheduledOrders *types.ScheduledOrdersConfig
+var Delisting *types.DelistingConfig
 
 func InitializeConfig() error {
 	Logger = services.NewLoggerService("Finex")
@@ -58,7 +88,119 @@ func InitializeConfig() error {
 		return err
 	}
 
+	driver := ""
+	if config.EventBus != nil {
+		driver = config.EventBus.Driver
+	}
+
+	Bus, err = bus.Open(driver, strings.Split(os.Getenv("KAFKA_URL"), ","), Logger)
+	if err != nil {
+		return err
+	}
+
 	Referral = config.Referral
+	APIVersions = config.APIVersions
+	EventVersions = config.EventVersions
+	Engine = config.Engine
+	if Engine == nil {
+		Engine = &types.EngineConfig{}
+	}
+
+	CandleIntegrity = config.CandleIntegrity
+	if CandleIntegrity == nil {
+		CandleIntegrity = &types.CandleIntegrityConfig{}
+	}
+
+	Klines = config.Klines
+	if Klines == nil {
+		Klines = &types.KlinesConfig{}
+	}
+
+	PreTradeChecks = config.PreTradeChecks
+	MarketGroupDomains = config.MarketGroupDomains
+	AlgoOrders = config.AlgoOrders
+	DecimalScaleEpsilon = config.DecimalScaleEpsilon
+	RoundingDriftThreshold = config.RoundingDriftThreshold
+	Reports = config.Reports
+	if Reports == nil {
+		Reports = &types.ReportsConfig{}
+	}
+
+	BackgroundMigrations = config.BackgroundMigrations
+	if BackgroundMigrations == nil {
+		BackgroundMigrations = &types.BackgroundMigrationsConfig{}
+	}
+
+	MarketData = config.MarketData
+	if MarketData == nil {
+		MarketData = &types.MarketDataConfig{}
+	}
+
+	ConversionBridge = config.ConversionBridge
+	Downloads = config.Downloads
+	if Downloads == nil {
+		Downloads = &types.DownloadsConfig{}
+	}
+
+	OrderIDs = config.OrderIDs
+	if OrderIDs == nil {
+		OrderIDs = &types.OrderIDsConfig{}
+	}
+
+	MemberExports = config.MemberExports
+	if MemberExports == nil {
+		MemberExports = &types.MemberExportsConfig{}
+	}
+
+	Archival = config.Archival
+	if Archival == nil {
+		Archival = &types.ArchivalConfig{}
+	}
+
+	FastAck = config.FastAck
+	if FastAck == nil {
+		FastAck = &types.FastAckConfig{}
+	}
+
+	StreamAuth = config.StreamAuth
+	if StreamAuth == nil {
+		StreamAuth = &types.StreamAuthConfig{}
+	}
+
+	SecurityEvents = config.SecurityEvents
+	if SecurityEvents == nil {
+		SecurityEvents = &types.SecurityEventsConfig{}
+	}
+
+	RateLimits = config.RateLimits
+	if RateLimits == nil {
+		RateLimits = make(map[string]*types.RateLimitConfig)
+	}
+
+	StreamFanout = config.StreamFanout
+	if StreamFanout == nil {
+		StreamFanout = &types.StreamFanoutConfig{}
+	}
+
+	Convert = config.Convert
+	if Convert == nil {
+		Convert = &types.ConvertConfig{}
+	}
+
+	MakerProgram = config.MakerProgram
+	if MakerProgram == nil {
+		MakerProgram = &types.MakerProgramConfig{}
+	}
+
+	ScheduledOrders = config.ScheduledOrders
+	if ScheduledOrders == nil {
+		ScheduledOrders = &types.ScheduledOrdersConfig{}
+	}
+
+	Delisting = config.Delisting
+	if Delisting == nil {
+		Delisting = &types.DelistingConfig{}
+	}
 
 	return nil
 }
@@ -35,6 +35,11 @@ func (c *InfluxClient) NewBatchPoints() (client.BatchPoints, error) {
 }
 
 func (c *InfluxClient) NewPoint(name string, tags map[string]string, fields map[string]interface{}) {
+	c.NewPointAt(name, tags, fields, time.Now())
+}
+
+// NewPointAt writes a point at the given time, it overwrites the fields of the point with the same tags and time.
+func (c *InfluxClient) NewPointAt(name string, tags map[string]string, fields map[string]interface{}, at time.Time) {
 	bp, err := c.NewBatchPoints()
 
 	if err != nil {
@@ -42,7 +47,7 @@ func (c *InfluxClient) NewPoint(name string, tags map[string]string, fields map[
 		return
 	}
 
-	point, err := client.NewPoint(name, tags, fields, time.Now())
+	point, err := client.NewPoint(name, tags, fields, at)
 	if err != nil {
 		Logger.Errorf("Error %v", err.Error())
 		return
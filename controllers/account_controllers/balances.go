@@ -0,0 +1,29 @@
+package account_controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/models"
+)
+
+func availableBalanceToEntity(available *models.AvailableBalance) *entities.BalanceEntity {
+	return &entities.BalanceEntity{
+		Currency:  available.CurrencyID,
+		Balance:   available.Balance,
+		Locked:    available.Locked,
+		Available: available.Available,
+	}
+}
+
+// GetBalances returns the balances of the member with what's available to trade or withdraw.
+func GetBalances(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	balance_entities := make([]*entities.BalanceEntity, 0)
+	for _, available := range CurrentUser.GetAvailableBalances() {
+		balance_entities = append(balance_entities, availableBalanceToEntity(available))
+	}
+
+	return c.Status(200).JSON(balance_entities)
+}
@@ -0,0 +1,49 @@
+package account_controllers
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/controllers/queries"
+	"github.com/zsmartex/finex/models"
+)
+
+func GetInvoice(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	return RenderInvoice(c, CurrentUser)
+}
+
+// RenderInvoice responds with the invoice of the member for the month given in the query.
+func RenderInvoice(c *fiber.Ctx, member *models.Member) error {
+	var errors = new(helpers.Errors)
+	params := new(queries.InvoiceQueries)
+
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	helpers.Vaildate(params, errors)
+	if errors.Size() > 0 {
+		return c.Status(422).JSON(errors)
+	}
+
+	from, _, err := models.InvoiceMonth(params.Month)
+	if err != nil || from.After(time.Now()) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"account.invoice.invalid_month"},
+		})
+	}
+
+	invoice, err := models.GetInvoice(member, params.Month)
+	if err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"account.invoice.failed"},
+		})
+	}
+
+	return c.Status(200).JSON(invoice)
+}
@@ -0,0 +1,32 @@
+package account_controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+// GetLimits lists the rate limits of the member, with the requests left in the current window of each bucket.
+// The usage is the one of the API replica serving the request.
+func GetLimits(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	usages := models.RateLimits.InspectAll(CurrentUser.ID)
+
+	rate_limit_entities := make([]*entities.RateLimitEntity, 0, len(usages))
+	for _, usage := range usages {
+		rate_limit_entities = append(rate_limit_entities, &entities.RateLimitEntity{
+			Bucket:        string(usage.Bucket),
+			Limit:         usage.Limit,
+			WindowSeconds: int64(usage.Window.Seconds()),
+			Used:          usage.Used,
+			Remaining:     usage.Remaining,
+			ResetAt:       usage.ResetAt,
+			Override:      usage.Overridden,
+		})
+	}
+
+	return helpers.RenderList(c, 200, rate_limit_entities)
+}
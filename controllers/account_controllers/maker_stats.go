@@ -0,0 +1,83 @@
+package account_controllers
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/controllers/queries"
+	"github.com/zsmartex/finex/models"
+)
+
+// GetMakerStats returns the liquidity provided by the member on a market, read from the hourly rollups.
+func GetMakerStats(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	var errors = new(helpers.Errors)
+	params := new(queries.MakerStatsQuery)
+
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	helpers.Vaildate(params, errors)
+	if errors.Size() > 0 {
+		return c.Status(422).JSON(errors)
+	}
+
+	if len(params.Period) == 0 {
+		params.Period = "7d"
+	}
+
+	var market models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", params.Market); result.Error != nil || !models.MarketVisibility.Visible(models.MarketGroupOf(CurrentUser), market.Symbol) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"account.maker_stats.invalid_market"},
+		})
+	}
+
+	stats, err := models.LoadMakerStats(config.DataBase, CurrentUser, market.Symbol, params.Period, time.Now())
+	if err == models.ErrMakerStatsNotEnrolled {
+		return c.Status(403).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	} else if err == models.ErrMakerStatsPeriod {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	} else if err != nil {
+		config.Logger.Errorf("Failed to load the maker stats of %s: %v", CurrentUser.UID, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.internal_error"},
+		})
+	}
+
+	return c.Status(200).JSON(entities.MakerStatsEntity{
+		Market:        stats.Market,
+		Period:        stats.Period,
+		From:          stats.From,
+		To:            stats.To,
+		MakerVolume:   stats.MakerVolume,
+		VolumeShare:   stats.VolumeShare,
+		AverageSpread: stats.AverageSpread,
+		QuoteUptime:   stats.QuoteUptime,
+		OrdersPlaced:  stats.OrdersPlaced,
+		MakerFills:    stats.MakerFills,
+		FillRatio:     stats.FillRatio,
+		CancelRatio:   stats.CancelRatio,
+		Makers:        stats.Makers,
+		Percentiles: entities.MakerStatsPercentilesEntity{
+			VolumeShare:   stats.Percentiles.VolumeShare,
+			AverageSpread: stats.Percentiles.AverageSpread,
+			QuoteUptime:   stats.Percentiles.QuoteUptime,
+			FillRatio:     stats.Percentiles.FillRatio,
+			CancelRatio:   stats.Percentiles.CancelRatio,
+		},
+	})
+}
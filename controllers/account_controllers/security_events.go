@@ -0,0 +1,63 @@
+package account_controllers
+
+import (
+	"encoding/json"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/controllers/queries"
+	"github.com/zsmartex/finex/models"
+)
+
+// GetSecurityEvents lists the security events of the member, the most recent first.
+func GetSecurityEvents(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	var errors = new(helpers.Errors)
+	params := new(queries.SecurityEventQueries)
+
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	helpers.Vaildate(params, errors)
+	if errors.Size() > 0 {
+		return c.Status(422).JSON(errors)
+	}
+
+	if len(params.Kind) > 0 && !models.ValidSecurityEventKind(models.SecurityEventKind(params.Kind)) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"account.security_event.invalid_kind"},
+		})
+	}
+
+	helpers.PageDefaults(&params.Page, &params.Limit)
+
+	tx := config.DataBase.Where("member_id = ?", CurrentUser.ID)
+	if len(params.Kind) > 0 {
+		tx = tx.Where("kind = ?", params.Kind)
+	}
+
+	var security_events []*models.SecurityEvent
+	tx.Order("id desc").Offset(params.Page*params.Limit - params.Limit).Limit(params.Limit).Find(&security_events)
+
+	security_event_entities := make([]*entities.SecurityEventEntity, 0, len(security_events))
+	for _, security_event := range security_events {
+		security_event_entities = append(security_event_entities, &entities.SecurityEventEntity{
+			ID:        security_event.ID,
+			Kind:      string(security_event.Kind),
+			Admin:     len(security_event.ActorUID) > 0,
+			Data:      json.RawMessage(security_event.Data),
+			CreatedAt: security_event.CreatedAt,
+		})
+	}
+
+	helpers.SetPageHeaders(c, params.Page, params.Limit)
+
+	return helpers.RenderList(c, 200, security_event_entities)
+}
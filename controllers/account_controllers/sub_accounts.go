@@ -0,0 +1,184 @@
+package account_controllers
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/controllers/queries"
+	"github.com/zsmartex/finex/models"
+)
+
+func subAccountToEntity(sub_account *models.Member) *entities.SubAccountEntity {
+	return &entities.SubAccountEntity{
+		UID:       sub_account.UID,
+		Label:     sub_account.Username.String,
+		State:     sub_account.State,
+		CreatedAt: sub_account.CreatedAt,
+	}
+}
+
+func GetSubAccounts(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	sub_account_entities := make([]*entities.SubAccountEntity, 0)
+	for _, sub_account := range CurrentUser.SubAccounts() {
+		sub_account_entities = append(sub_account_entities, subAccountToEntity(sub_account))
+	}
+
+	return c.Status(200).JSON(sub_account_entities)
+}
+
+func CreateSubAccount(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	payload := new(queries.SubAccountPayload)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	sub_account, err := CurrentUser.CreateSubAccount(payload.Label)
+	if errors.Is(err, models.ErrSubAccountLimit) || errors.Is(err, models.ErrNestedSubAccount) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	} else if err != nil {
+		config.Logger.Errorf("Failed to create sub-account of member %d: %v", CurrentUser.ID, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"account.sub_account.create_error"},
+		})
+	}
+
+	return c.Status(201).JSON(subAccountToEntity(sub_account))
+}
+
+// GetSubAccountsBalances returns the balances of the member and its sub-accounts, and their sum per currency.
+func GetSubAccountsBalances(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	members := append([]*models.Member{CurrentUser}, CurrentUser.SubAccounts()...)
+	member_ids := make([]int64, 0, len(members))
+	for _, member := range members {
+		member_ids = append(member_ids, member.ID)
+	}
+
+	var accounts []*models.Account
+	config.DataBase.Order("currency_id asc").Find(&accounts, "member_id IN ?", member_ids)
+
+	member_accounts := make(map[int64][]*entities.BalanceEntity)
+	totals := make(map[string]*entities.BalanceEntity)
+	for _, account := range accounts {
+		available := models.GetAvailableBalance(config.DataBase, account, 0)
+		member_accounts[account.MemberID] = append(member_accounts[account.MemberID], availableBalanceToEntity(available))
+
+		total, ok := totals[account.CurrencyID]
+		if !ok {
+			total = &entities.BalanceEntity{Currency: account.CurrencyID}
+			totals[account.CurrencyID] = total
+		}
+
+		total.Balance = total.Balance.Add(account.Balance)
+		total.Locked = total.Locked.Add(account.Locked)
+		total.Available = total.Available.Add(available.Available)
+	}
+
+	result := &entities.AggregatedBalancesEntity{
+		Total:   make([]*entities.BalanceEntity, 0, len(totals)),
+		Members: make([]*entities.MemberBalancesEntity, 0, len(members)),
+	}
+
+	for _, total := range totals {
+		result.Total = append(result.Total, total)
+	}
+
+	sort.Slice(result.Total, func(i, j int) bool {
+		return result.Total[i].Currency < result.Total[j].Currency
+	})
+
+	for _, member := range members {
+		balances := member_accounts[member.ID]
+		if balances == nil {
+			balances = make([]*entities.BalanceEntity, 0)
+		}
+
+		result.Members = append(result.Members, &entities.MemberBalancesEntity{
+			UID:      member.UID,
+			Balances: balances,
+		})
+	}
+
+	return c.Status(200).JSON(result)
+}
+
+// CreateSubAccountTransfer moves main funds between the member and one of its sub-accounts.
+func CreateSubAccountTransfer(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	payload := new(queries.SubAccountTransferPayload)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	sub_account := CurrentUser.GetSubAccount(payload.SubAccountUID)
+	if sub_account == nil {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	if !payload.Amount.IsPositive() {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"account.transfer.non_positive_amount"},
+		})
+	}
+
+	var currency *models.Currency
+	if result := config.DataBase.First(&currency, "id = ?", strings.ToLower(payload.Currency)); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"account.currency.doesnt_exist"},
+		})
+	}
+
+	var from, to *models.Member
+	switch payload.Direction {
+	case "deposit":
+		from, to = CurrentUser, sub_account
+	case "withdraw":
+		from, to = sub_account, CurrentUser
+	default:
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"account.transfer.invalid_direction"},
+		})
+	}
+
+	transfer, err := models.CreateTransfer(models.TransferKindSubAccount, from, to, currency, payload.Amount)
+	if err != nil {
+		config.Logger.Errorf("Failed to transfer %s %s from member %d to member %d: %v", payload.Amount, currency.ID, from.ID, to.ID, err)
+
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"account.transfer.insufficient_balance"},
+		})
+	}
+
+	models.RecordSubAccountAudit(CurrentUser, sub_account, "transfer", payload.Direction+" "+payload.Amount.String()+" "+currency.ID)
+
+	return c.Status(201).JSON(&entities.TransferEntity{
+		ID:        transfer.ID,
+		From:      from.UID,
+		To:        to.UID,
+		Currency:  transfer.CurrencyID,
+		Amount:    transfer.Amount,
+		CreatedAt: transfer.CreatedAt,
+	})
+}
@@ -0,0 +1,84 @@
+package admin_controllers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/admin_controllers/entities"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+func backgroundMigrationToEntity(migration *models.BackgroundMigration) *entities.BackgroundMigration {
+	entity := &entities.BackgroundMigration{
+		Name:      migration.Name,
+		State:     string(migration.State),
+		Cursor:    migration.Cursor,
+		Processed: migration.Processed,
+		Batches:   migration.Batches,
+		LastError: migration.LastError,
+	}
+
+	if migration.StartedAt.Valid {
+		entity.StartedAt = &migration.StartedAt.Time
+	}
+
+	if migration.FinishedAt.Valid {
+		entity.FinishedAt = &migration.FinishedAt.Time
+	}
+
+	if !migration.UpdatedAt.IsZero() {
+		entity.UpdatedAt = &migration.UpdatedAt
+	}
+
+	return entity
+}
+
+// GetBackgroundMigrations lists every registered migration with its progress, the ones never queued are "not_queued".
+func GetBackgroundMigrations(c *fiber.Ctx) error {
+	var migrations []*models.BackgroundMigration
+	config.DataBase.Find(&migrations)
+
+	queued := make(map[string]*models.BackgroundMigration, len(migrations))
+	for _, migration := range migrations {
+		queued[migration.Name] = migration
+	}
+
+	migration_entities := make([]*entities.BackgroundMigration, 0, len(queued))
+	for _, name := range models.BackgroundMigrationNames() {
+		migration, ok := queued[name]
+		if !ok {
+			migration_entities = append(migration_entities, &entities.BackgroundMigration{Name: name, State: "not_queued"})
+			continue
+		}
+
+		migration_entities = append(migration_entities, backgroundMigrationToEntity(migration))
+	}
+
+	return c.Status(200).JSON(migration_entities)
+}
+
+// EnqueueBackgroundMigration queues a migration for the background migrator, or resumes it after a failure.
+func EnqueueBackgroundMigration(c *fiber.Ctx) error {
+	migration, err := models.EnqueueBackgroundMigration(config.DataBase, c.Params("name"))
+	switch {
+	case errors.Is(err, models.ErrBackgroundMigrationUnknown):
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	case errors.Is(err, models.ErrBackgroundMigrationDone):
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	case err != nil:
+		config.Logger.Errorf("Failed to queue background migration %s: %v", c.Params("name"), err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.background_migration.enqueue_error"},
+		})
+	}
+
+	return c.Status(201).JSON(backgroundMigrationToEntity(migration))
+}
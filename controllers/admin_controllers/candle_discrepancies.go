@@ -0,0 +1,65 @@
+package admin_controllers
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/admin_controllers/entities"
+	"github.com/zsmartex/finex/controllers/admin_controllers/queries"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+// GetCandleDiscrepancies lists the discrepancies found by the candle integrity check, the most recent first.
+func GetCandleDiscrepancies(c *fiber.Ctx) error {
+	params := new(queries.CandleDiscrepancyFilters)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	if params.Limit == 0 {
+		params.Limit = 100
+	}
+
+	if params.Page == 0 {
+		params.Page = 1
+	}
+
+	tx := config.DataBase.Order("id desc")
+	if len(params.Market) > 0 {
+		tx = tx.Where("market_id = ?", params.Market)
+	}
+
+	if len(params.Period) > 0 {
+		tx = tx.Where("period = ?", params.Period)
+	}
+
+	var discrepancies []*models.CandleDiscrepancy
+	tx.Offset(params.Page*params.Limit - params.Limit).Limit(params.Limit).Find(&discrepancies)
+
+	discrepancy_entities := make([]*entities.CandleDiscrepancy, 0, len(discrepancies))
+	for _, discrepancy := range discrepancies {
+		discrepancy_entities = append(discrepancy_entities, &entities.CandleDiscrepancy{
+			ID:        discrepancy.ID,
+			Market:    discrepancy.MarketID,
+			Period:    discrepancy.Period,
+			Time:      discrepancy.Time,
+			Field:     discrepancy.Field,
+			Expected:  discrepancy.Expected,
+			Stored:    discrepancy.Stored,
+			Delta:     discrepancy.Delta,
+			Drift:     discrepancy.Drift,
+			Repaired:  discrepancy.Repaired,
+			CreatedAt: discrepancy.CreatedAt,
+		})
+	}
+
+	c.Response().Header.Add("page", strconv.FormatInt(int64(params.Page), 10))
+	c.Response().Header.Add("per-page", strconv.FormatInt(int64(params.Limit), 10))
+
+	return c.Status(200).JSON(discrepancy_entities)
+}
@@ -0,0 +1,96 @@
+package admin_controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/admin_controllers/entities"
+	"github.com/zsmartex/finex/controllers/admin_controllers/queries"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+func deadLetterToEntity(dead_letter *models.DeadLetter) *entities.DeadLetter {
+	return &entities.DeadLetter{
+		ID:           dead_letter.ID,
+		Topic:        dead_letter.Topic,
+		Payload:      string(dead_letter.Payload),
+		Error:        dead_letter.Error,
+		Attempts:     dead_letter.Attempts,
+		State:        string(dead_letter.State),
+		ReinjectedAt: dead_letter.ReinjectedAt,
+		CreatedAt:    dead_letter.CreatedAt,
+		UpdatedAt:    dead_letter.UpdatedAt,
+	}
+}
+
+// GetDeadLetters lists the messages the consumers parked, the most recent first.
+func GetDeadLetters(c *fiber.Ctx) error {
+	params := new(queries.DeadLetterFilters)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	helpers.PageDefaults(&params.Page, &params.Limit)
+
+	tx := config.DataBase.Order("id desc")
+	if len(params.Topic) > 0 {
+		tx = tx.Where("topic = ?", params.Topic)
+	}
+
+	if len(params.State) > 0 {
+		tx = tx.Where("state = ?", params.State)
+	}
+
+	var dead_letters []*models.DeadLetter
+	tx.Offset(params.Page*params.Limit - params.Limit).Limit(params.Limit).Find(&dead_letters)
+
+	dead_letter_entities := make([]*entities.DeadLetter, 0, len(dead_letters))
+	for _, dead_letter := range dead_letters {
+		dead_letter_entities = append(dead_letter_entities, deadLetterToEntity(dead_letter))
+	}
+
+	helpers.SetPageHeaders(c, params.Page, params.Limit)
+
+	return c.Status(200).JSON(dead_letter_entities)
+}
+
+// ReinjectDeadLetter produces a parked message to the topic it was consumed from again, once its consumer is fixed.
+func ReinjectDeadLetter(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.dead_letter.invalid_id"},
+		})
+	}
+
+	dead_letter, err := models.ReinjectDeadLetter(id)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	case errors.Is(err, models.ErrDeadLetterNotParked), errors.Is(err, models.ErrDeadLetterNotJSON):
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	case err != nil:
+		config.Logger.Errorf("Failed to re-inject dead letter %d: %v", id, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.dead_letter.reinject_error"},
+		})
+	}
+
+	config.Logger.Infof("Dead letter %d re-injected to topic %s by %s", dead_letter.ID, dead_letter.Topic, CurrentUser.UID)
+
+	return c.Status(200).JSON(deadLetterToEntity(dead_letter))
+}
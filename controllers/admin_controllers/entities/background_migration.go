@@ -0,0 +1,15 @@
+package entities
+
+import "time"
+
+type BackgroundMigration struct {
+	Name       string     `json:"name"`
+	State      string     `json:"state"`
+	Cursor     int64      `json:"cursor"`
+	Processed  int64      `json:"processed"`
+	Batches    int64      `json:"batches"`
+	LastError  string     `json:"last_error,omitempty"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	UpdatedAt  *time.Time `json:"updated_at"`
+}
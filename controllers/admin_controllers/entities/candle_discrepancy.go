@@ -0,0 +1,21 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type CandleDiscrepancy struct {
+	ID        int64           `json:"id"`
+	Market    string          `json:"market"`
+	Period    string          `json:"period"`
+	Time      time.Time       `json:"time"`
+	Field     string          `json:"field"`
+	Expected  decimal.Decimal `json:"expected"`
+	Stored    decimal.Decimal `json:"stored"`
+	Delta     decimal.Decimal `json:"delta"`
+	Drift     decimal.Decimal `json:"drift"`
+	Repaired  bool            `json:"repaired"`
+	CreatedAt time.Time       `json:"created_at"`
+}
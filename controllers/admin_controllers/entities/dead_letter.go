@@ -0,0 +1,19 @@
+package entities
+
+import (
+	"database/sql"
+	"time"
+)
+
+type DeadLetter struct {
+	ID    int64  `json:"id"`
+	Topic string `json:"topic"`
+	// Payload is the message as it was consumed, it may not be JSON
+	Payload      string       `json:"payload"`
+	Error        string       `json:"error"`
+	Attempts     int          `json:"attempts"`
+	State        string       `json:"state"`
+	ReinjectedAt sql.NullTime `json:"reinjected_at"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
@@ -0,0 +1,14 @@
+package entities
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type MarketConfigVersion struct {
+	Market    string          `json:"market"`
+	Version   int64           `json:"version"`
+	Config    json.RawMessage `json:"config"`
+	ActorUID  string          `json:"actor_uid"`
+	CreatedAt time.Time       `json:"created_at"`
+}
@@ -0,0 +1,8 @@
+package entities
+
+type MarketDataPolicy struct {
+	Tier          string   `json:"tier"`
+	DepthLevels   int64    `json:"depth_levels"`
+	TradesLimit   int64    `json:"trades_limit"`
+	CandlePeriods []string `json:"candle_periods"`
+}
@@ -0,0 +1,51 @@
+package entities
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type MarketDelistingLog struct {
+	Step      string    `json:"step"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type MarketDelistingSnapshot struct {
+	Period string          `json:"period"`
+	Time   time.Time       `json:"time"`
+	Open   decimal.Decimal `json:"open"`
+	High   decimal.Decimal `json:"high"`
+	Low    decimal.Decimal `json:"low"`
+	Close  decimal.Decimal `json:"close"`
+	Volume decimal.Decimal `json:"volume"`
+}
+
+type MarketDelisting struct {
+	ID           int64     `json:"id"`
+	Market       string    `json:"market"`
+	State        string    `json:"state"`
+	CancelOnlyAt time.Time `json:"cancel_only_at"`
+	CancelAt     time.Time `json:"cancel_at"`
+	HaltAt       time.Time `json:"halt_at"`
+	CreatedBy    string    `json:"created_by"`
+	// NextStep is the step the delisting runs next, empty once it's delisted or aborted
+	NextStep string `json:"next_step"`
+	// Abortable tells whether the delisting can still be aborted, until its orders start to be cancelled
+	Abortable bool `json:"abortable"`
+	// OpenOrders are the orders of the market which aren't in a terminal state
+	OpenOrders       int64                      `json:"open_orders"`
+	CancelOnlyDoneAt sql.NullTime               `json:"cancel_only_done_at"`
+	CancelStartedAt  sql.NullTime               `json:"cancel_started_at"`
+	CancelDoneAt     sql.NullTime               `json:"cancel_done_at"`
+	HaltDoneAt       sql.NullTime               `json:"halt_done_at"`
+	SnapshotDoneAt   sql.NullTime               `json:"snapshot_done_at"`
+	DelistedAt       sql.NullTime               `json:"delisted_at"`
+	AbortedAt        sql.NullTime               `json:"aborted_at"`
+	Logs             []*MarketDelistingLog      `json:"logs"`
+	Snapshots        []*MarketDelistingSnapshot `json:"snapshots"`
+	CreatedAt        time.Time                  `json:"created_at"`
+	UpdatedAt        time.Time                  `json:"updated_at"`
+}
@@ -0,0 +1,12 @@
+package entities
+
+import "time"
+
+type MarketGroup struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Markets     []string  `json:"markets"`
+	Members     int64     `json:"members"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
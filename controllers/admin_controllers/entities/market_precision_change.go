@@ -0,0 +1,20 @@
+package entities
+
+import (
+	"database/sql"
+	"time"
+)
+
+type MarketPrecisionChange struct {
+	ID                 int64  `json:"id"`
+	Market             string `json:"market"`
+	FromPricePrecision int    `json:"from_price_precision"`
+	PricePrecision     int    `json:"price_precision"`
+	State              string `json:"state"`
+	// Repriced and Cancelled are the orders the engine moved to a price of the precision and the ones it cancelled
+	Repriced    int          `json:"repriced"`
+	Cancelled   int          `json:"cancelled"`
+	CreatedBy   string       `json:"created_by"`
+	CompletedAt sql.NullTime `json:"completed_at"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
@@ -0,0 +1,27 @@
+package entities
+
+import (
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/types"
+)
+
+type MarketSettings struct {
+	Market         string                     `json:"market"`
+	State          string                     `json:"state"`
+	FeatureFlags   map[types.FeatureFlag]bool `json:"feature_flags"`
+	MinAmount      decimal.Decimal            `json:"min_amount"`
+	MaxAmount      decimal.Decimal            `json:"max_amount"`
+	MaxQuoteAmount decimal.Decimal            `json:"max_quote_amount"`
+	LotSize        decimal.Decimal            `json:"lot_size"`
+	MinNotional    decimal.Decimal            `json:"min_notional"`
+	// BatchIntervalMs is the interval of the batch auctions of the market, zero while it matches continuously
+	BatchIntervalMs int64 `json:"batch_interval_ms"`
+	// CircuitBreakerRate, CircuitBreakerWindowSec and CircuitBreakerCoolDownSec are the circuit breaker of the market,
+	// a zero rate disables it
+	CircuitBreakerRate        decimal.Decimal `json:"circuit_breaker_rate"`
+	CircuitBreakerWindowSec   int64           `json:"circuit_breaker_window_sec"`
+	CircuitBreakerCoolDownSec int64           `json:"circuit_breaker_cool_down_sec"`
+	// ConfigVersion is the version of the configuration of the market, trades keep the version they were matched with
+	ConfigVersion int64 `json:"config_version"`
+}
@@ -0,0 +1,17 @@
+package entities
+
+import (
+	"database/sql"
+	"time"
+)
+
+type MarketTradingStateChange struct {
+	ID               int64        `json:"id"`
+	Market           string       `json:"market"`
+	FromTradingState string       `json:"from_trading_state"`
+	TradingState     string       `json:"trading_state"`
+	State            string       `json:"state"`
+	CreatedBy        string       `json:"created_by"`
+	CompletedAt      sql.NullTime `json:"completed_at"`
+	CreatedAt        time.Time    `json:"created_at"`
+}
@@ -0,0 +1,12 @@
+package entities
+
+import (
+	"time"
+)
+
+type MemberExport struct {
+	*ReportJob
+	// DownloadURL is the signed link of the export once it's done, it stops working at ExpiresAt
+	DownloadURL string     `json:"download_url,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
@@ -0,0 +1,13 @@
+package entities
+
+import "time"
+
+type RateLimit struct {
+	Bucket        string    `json:"bucket"`
+	Limit         int       `json:"limit"`
+	WindowSeconds int64     `json:"window_seconds"`
+	Used          int       `json:"used"`
+	Remaining     int       `json:"remaining"`
+	ResetAt       time.Time `json:"reset_at"`
+	Override      bool      `json:"override"`
+}
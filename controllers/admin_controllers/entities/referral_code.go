@@ -0,0 +1,20 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type ReferralCode struct {
+	ID          int64           `json:"id"`
+	UID         string          `json:"uid"`
+	Code        string          `json:"code"`
+	Label       string          `json:"label"`
+	FriendShare decimal.Decimal `json:"friend_share"`
+	Default     bool            `json:"default"`
+	State       string          `json:"state"`
+	Signups     int64           `json:"signups"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
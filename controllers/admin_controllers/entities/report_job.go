@@ -0,0 +1,17 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ReportJob struct {
+	UUID       uuid.UUID  `json:"uuid"`
+	Kind       string     `json:"kind"`
+	State      string     `json:"state"`
+	Location   string     `json:"location,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
@@ -0,0 +1,23 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type RevenueReport struct {
+	Period              time.Time           `json:"period"`
+	Currency            string              `json:"currency"`
+	Market              string              `json:"market"`
+	Fees                decimal.Decimal     `json:"fees"`
+	ReferralCommissions decimal.Decimal     `json:"referral_commissions"`
+	Rebates             decimal.Decimal     `json:"rebates"`
+	Kickbacks           decimal.Decimal     `json:"kickbacks"`
+	Net                 decimal.Decimal     `json:"net"`
+	USDTRate            decimal.NullDecimal `json:"usdt_rate"`
+	NetUSDT             decimal.NullDecimal `json:"net_usdt"`
+	StatsNet            decimal.Decimal     `json:"stats_net"`
+	Reconciled          bool                `json:"reconciled"`
+	Discrepancy         bool                `json:"discrepancy"`
+}
@@ -0,0 +1,16 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type RoundingDrift struct {
+	Currency string          `json:"currency"`
+	Day      time.Time       `json:"day"`
+	Path     string          `json:"path"`
+	Rows     int64           `json:"rows"`
+	Drift    decimal.Decimal `json:"drift"`
+	Flagged  bool            `json:"flagged"`
+}
@@ -0,0 +1,15 @@
+package entities
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type SecurityEvent struct {
+	ID        int64           `json:"id"`
+	MemberID  int64           `json:"member_id"`
+	Kind      string          `json:"kind"`
+	ActorUID  string          `json:"actor_uid"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
@@ -1,6 +1,7 @@
 package entities
 
 import (
+	"database/sql"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -25,6 +26,9 @@ type TradeEntity struct {
 	TakerFee         decimal.Decimal `json:"taker_fee"`
 	TakerFeeAmount   decimal.Decimal `json:"taker_fee_amount"`
 	TakerFeeCurrency string          `json:"taker_fee_currency"`
-	CreatedAt        time.Time       `json:"created_at"`
-	UpdatedAt        time.Time       `json:"updated_at"`
+	// ConfigVersion is the version of the configuration of the market the trade was matched with
+	ConfigVersion int64        `json:"config_version"`
+	RevertedAt    sql.NullTime `json:"reverted_at"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
 }
@@ -0,0 +1,31 @@
+package entities
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type TradeReversalEntry struct {
+	Kind        string          `json:"kind"`
+	ReferenceID int64           `json:"reference_id"`
+	MemberID    int64           `json:"member_id"`
+	CurrencyID  string          `json:"currency_id"`
+	Amount      decimal.Decimal `json:"amount"`
+	Note        string          `json:"note"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+type TradeReversal struct {
+	ID          int64                 `json:"id"`
+	TradeID     int64                 `json:"trade_id"`
+	Reason      string                `json:"reason"`
+	State       string                `json:"state"`
+	RequestedBy int64                 `json:"requested_by"`
+	ReviewedBy  sql.NullInt64         `json:"reviewed_by"`
+	ExecutedAt  sql.NullTime          `json:"executed_at"`
+	Entries     []*TradeReversalEntry `json:"entries"`
+	CreatedAt   time.Time             `json:"created_at"`
+	UpdatedAt   time.Time             `json:"updated_at"`
+}
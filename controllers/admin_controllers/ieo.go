@@ -1,6 +1,7 @@
 package admin_controllers
 
 import (
+	"errors"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -60,10 +61,22 @@ func ValidateIEOPayload(payload *queries.IEOPayload) *helpers.Errors {
 	}
 }
 
+// GetIEOList lists the IEOs, archived IEOs are listed with include_archived.
 func GetIEOList(c *fiber.Ctx) error {
-	var lst_ieo []*models.IEO
+	params := new(queries.ArchivedQuery)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	query := config.DataBase
+	if !params.IncludeArchived {
+		query = query.Where("state <> ?", types.MarketStateArchived)
+	}
 
-	config.DataBase.Find(&lst_ieo)
+	var lst_ieo []*models.IEO
+	query.Find(&lst_ieo)
 
 	ieo_entities := make([]*entities.IEO, 0)
 
@@ -71,7 +84,7 @@ func GetIEOList(c *fiber.Ctx) error {
 		ieo_entities = append(ieo_entities, IEOToEntity(ieo))
 	}
 
-	return c.Status(200).JSON(ieo_entities)
+	return helpers.RenderList(c, 200, ieo_entities)
 }
 
 func GetIEO(c *fiber.Ctx) error {
@@ -150,6 +163,13 @@ func UpdateIEO(c *fiber.Ctx) error {
 		})
 	}
 
+	// archived IEOs only leave the archive through UnarchiveIEO
+	if ieo.State == types.MarketStateArchived {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.ieo.archived"},
+		})
+	}
+
 	ieo.MainPaymentCurrency = payload.MainPaymentCurrency
 	ieo.Price = payload.Price
 	ieo.OriginQuantity = payload.OriginQuantity
@@ -186,6 +206,41 @@ func DeleteIEO(c *fiber.Ctx) error {
 	return c.Status(200).JSON(200)
 }
 
+// UnarchiveIEO takes an IEO out of the archive, back to the state it was archived from, only admins can and it's audited.
+func UnarchiveIEO(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_query"},
+		})
+	}
+
+	var ieo *models.IEO
+	if result := config.DataBase.First(&ieo, id); result.Error != nil {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	if err := models.RestoreIEO(config.DataBase, ieo, CurrentUser.UID); err != nil {
+		if errors.Is(err, models.ErrArchiveNotArchived) {
+			return c.Status(422).JSON(helpers.Errors{
+				Errors: []string{"admin.ieo.not_archived"},
+			})
+		}
+
+		config.Logger.Errorf("Failed to unarchive IEO %d: %v", ieo.ID, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.ieo.unarchive_error"},
+		})
+	}
+
+	return c.Status(200).JSON(IEOToEntity(ieo))
+}
+
 type PayloadIEOCurrency struct {
 	ID         int64    `json:"id"`
 	Currencies []string `json:"currencies"`
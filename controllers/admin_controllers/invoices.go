@@ -0,0 +1,26 @@
+package admin_controllers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/account_controllers"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+func GetMemberInvoice(c *fiber.Ctx) error {
+	var member *models.Member
+
+	result := config.DataBase.Where("uid = ?", c.Params("uid")).First(&member)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	return account_controllers.RenderInvoice(c, member)
+}
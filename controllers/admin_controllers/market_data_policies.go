@@ -0,0 +1,103 @@
+package admin_controllers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/admin_controllers/entities"
+	"github.com/zsmartex/finex/controllers/admin_controllers/queries"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+func marketDataPolicyToEntity(policy *models.MarketDataPolicy) *entities.MarketDataPolicy {
+	return &entities.MarketDataPolicy{
+		Tier:          string(policy.Tier),
+		DepthLevels:   policy.DepthLevels,
+		TradesLimit:   policy.TradesLimit,
+		CandlePeriods: policy.Periods(),
+	}
+}
+
+func marketDataPolicyError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, models.ErrMarketDataTier):
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	case errors.Is(err, models.ErrMarketDataPolicyLimit), errors.Is(err, models.ErrMarketDataPolicyPeriod):
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	default:
+		config.Logger.Errorf("Failed to update market data policy: %v", err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.market_data_policy.update_error"},
+		})
+	}
+}
+
+// GetMarketDataPolicies lists how much of the public market data each tier of users is served.
+func GetMarketDataPolicies(c *fiber.Ctx) error {
+	policies := make([]*entities.MarketDataPolicy, 0)
+	for _, policy := range models.MarketDataPolicies.All() {
+		policies = append(policies, marketDataPolicyToEntity(policy))
+	}
+
+	return helpers.RenderList(c, 200, policies)
+}
+
+// UpdateMarketDataPolicy replaces the policy of a tier, every replica serves it within 30 seconds without a restart.
+func UpdateMarketDataPolicy(c *fiber.Ctx) error {
+	params := new(queries.MarketDataPolicyPayload)
+	if err := c.BodyParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	policy := &models.MarketDataPolicy{
+		Tier:          models.MarketDataTier(c.Params("tier")),
+		DepthLevels:   params.DepthLevels,
+		TradesLimit:   params.TradesLimit,
+		CandlePeriods: strings.Join(params.CandlePeriods, ","),
+	}
+
+	if err := models.SaveMarketDataPolicy(policy); err != nil {
+		return marketDataPolicyError(c, err)
+	}
+
+	return c.Status(200).JSON(marketDataPolicyToEntity(policy))
+}
+
+// UpdateMemberMarketDataSubscription moves a member to or out of the subscriber tier of the market data.
+func UpdateMemberMarketDataSubscription(c *fiber.Ctx) error {
+	var member *models.Member
+	if result := config.DataBase.First(&member, "uid = ?", c.Params("uid")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	params := new(queries.MemberMarketDataSubscriptionPayload)
+	if err := c.BodyParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	if result := config.DataBase.Model(member).Update("market_data_subscription", params.Subscribed); result.Error != nil {
+		return marketDataPolicyError(c, result.Error)
+	}
+	member.MarketDataSubscription = params.Subscribed
+
+	return c.Status(200).JSON(fiber.Map{
+		"uid":                      member.UID,
+		"market_data_subscription": member.MarketDataSubscription,
+	})
+}
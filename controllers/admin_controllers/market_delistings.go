@@ -0,0 +1,165 @@
+package admin_controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/admin_controllers/entities"
+	"github.com/zsmartex/finex/controllers/admin_controllers/queries"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+func marketDelistingToEntity(delisting *models.MarketDelisting, logs []*models.MarketDelistingLog, snapshots []*models.MarketDelistingSnapshot, open_orders int64, now time.Time) *entities.MarketDelisting {
+	log_entities := make([]*entities.MarketDelistingLog, 0, len(logs))
+	for _, log := range logs {
+		log_entities = append(log_entities, &entities.MarketDelistingLog{
+			Step:      string(log.Step),
+			Message:   log.Message,
+			CreatedAt: log.CreatedAt,
+		})
+	}
+
+	snapshot_entities := make([]*entities.MarketDelistingSnapshot, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		snapshot_entities = append(snapshot_entities, &entities.MarketDelistingSnapshot{
+			Period: snapshot.Period,
+			Time:   snapshot.Time,
+			Open:   snapshot.Open,
+			High:   snapshot.High,
+			Low:    snapshot.Low,
+			Close:  snapshot.Close,
+			Volume: snapshot.Volume,
+		})
+	}
+
+	next_step, _ := delisting.NextStep(now)
+
+	return &entities.MarketDelisting{
+		ID:               delisting.ID,
+		Market:           delisting.MarketID,
+		State:            string(delisting.State),
+		CancelOnlyAt:     delisting.CancelOnlyAt,
+		CancelAt:         delisting.CancelAt,
+		HaltAt:           delisting.HaltAt,
+		CreatedBy:        delisting.CreatedBy,
+		NextStep:         string(next_step),
+		Abortable:        delisting.Abortable(now),
+		OpenOrders:       open_orders,
+		CancelOnlyDoneAt: delisting.CancelOnlyDoneAt,
+		CancelStartedAt:  delisting.CancelStartedAt,
+		CancelDoneAt:     delisting.CancelDoneAt,
+		HaltDoneAt:       delisting.HaltDoneAt,
+		SnapshotDoneAt:   delisting.SnapshotDoneAt,
+		DelistedAt:       delisting.DelistedAt,
+		AbortedAt:        delisting.AbortedAt,
+		Logs:             log_entities,
+		Snapshots:        snapshot_entities,
+		CreatedAt:        delisting.CreatedAt,
+		UpdatedAt:        delisting.UpdatedAt,
+	}
+}
+
+func renderMarketDelisting(c *fiber.Ctx, status int, market_id string) error {
+	delisting, logs, snapshots, err := models.LastMarketDelisting(config.DataBase, market_id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	var open_orders int64
+	if err == nil {
+		open_orders, err = models.OpenOrdersCount(config.DataBase, market_id)
+	}
+
+	if err != nil {
+		config.Logger.Errorf("Failed to load the delisting of market %s: %v", market_id, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.market.delisting_error"},
+		})
+	}
+
+	return c.Status(status).JSON(marketDelistingToEntity(delisting, logs, snapshots, open_orders, time.Now()))
+}
+
+// ScheduleMarketDelisting delists a market: it takes cancels only from cancel_only_at, its orders are cancelled
+// at cancel_at and it's halted, hidden and delisted at halt_at. The market delister daemon runs the steps.
+func ScheduleMarketDelisting(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", c.Params("market")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	params := new(queries.MarketDelistingPayload)
+	if err := c.BodyParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	delisting, err := models.NewMarketDelisting(market, params.CancelOnlyAt, params.CancelAt, params.HaltAt, CurrentUser.UID, time.Now())
+	if err == nil {
+		err = models.ScheduleMarketDelisting(config.DataBase, delisting)
+	}
+
+	switch {
+	case errors.Is(err, models.ErrDelistingSchedule), errors.Is(err, models.ErrDelistingMarketState), errors.Is(err, models.ErrDelistingExists):
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	case err != nil:
+		config.Logger.Errorf("Failed to schedule the delisting of market %s: %v", market.Symbol, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.market.delisting_error"},
+		})
+	}
+
+	return renderMarketDelisting(c, 201, market.Symbol)
+}
+
+// GetMarketDelisting returns the progress of the latest delisting of a market.
+func GetMarketDelisting(c *fiber.Ctx) error {
+	return renderMarketDelisting(c, 200, c.Params("market"))
+}
+
+// AbortMarketDelisting aborts the delisting of a market until its orders start to be cancelled, the market takes orders again.
+func AbortMarketDelisting(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	delisting, _, _, err := models.LastMarketDelisting(config.DataBase, c.Params("market"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	if err == nil {
+		err = models.AbortMarketDelisting(config.DataBase, delisting, CurrentUser.UID, models.BusDelistingEngine{}, time.Now())
+	}
+
+	switch {
+	case errors.Is(err, models.ErrDelistingNotAbortable):
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	case err != nil:
+		config.Logger.Errorf("Failed to abort the delisting of market %s: %v", c.Params("market"), err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.market.delisting_error"},
+		})
+	}
+
+	return renderMarketDelisting(c, 200, delisting.MarketID)
+}
@@ -0,0 +1,186 @@
+package admin_controllers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/admin_controllers/entities"
+	"github.com/zsmartex/finex/controllers/admin_controllers/queries"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+func marketGroupToEntity(group *models.MarketGroup) *entities.MarketGroup {
+	var members int64
+	config.DataBase.Model(&models.Member{}).Where("market_group = ?", group.Name).Count(&members)
+
+	return &entities.MarketGroup{
+		Name:        group.Name,
+		Description: group.Description,
+		Markets:     group.Markets(),
+		Members:     members,
+		CreatedAt:   group.CreatedAt,
+		UpdatedAt:   group.UpdatedAt,
+	}
+}
+
+func marketGroupError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, models.ErrMarketGroupNotFound):
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	case errors.Is(err, models.ErrMarketGroupInvalid),
+		errors.Is(err, models.ErrMarketGroupTaken),
+		errors.Is(err, models.ErrMarketGroupDefault),
+		errors.Is(err, models.ErrMarketGroupMembers):
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	default:
+		config.Logger.Errorf("Failed to update market group: %v", err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.market_group.update_error"},
+		})
+	}
+}
+
+// GetMarketGroups lists the market groups with their markets, the public group first.
+func GetMarketGroups(c *fiber.Ctx) error {
+	var groups []*models.MarketGroup
+	config.DataBase.Order("id asc").Find(&groups)
+
+	public, _ := models.GetMarketGroup(models.DefaultMarketGroup)
+	group_entities := []*entities.MarketGroup{marketGroupToEntity(public)}
+	for _, group := range groups {
+		if group.Name != models.DefaultMarketGroup {
+			group_entities = append(group_entities, marketGroupToEntity(group))
+		}
+	}
+
+	return c.Status(200).JSON(group_entities)
+}
+
+func CreateMarketGroup(c *fiber.Ctx) error {
+	params := new(queries.MarketGroupPayload)
+	if err := c.BodyParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	errs := new(helpers.Errors)
+	helpers.Vaildate(params, errs)
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	group, err := models.CreateMarketGroup(params.Name, params.Description)
+	if err != nil {
+		return marketGroupError(c, err)
+	}
+
+	return c.Status(201).JSON(marketGroupToEntity(group))
+}
+
+func UpdateMarketGroup(c *fiber.Ctx) error {
+	group, err := models.GetMarketGroup(c.Params("name"))
+	if err != nil {
+		return marketGroupError(c, err)
+	}
+
+	if group.ID == 0 {
+		return marketGroupError(c, models.ErrMarketGroupDefault)
+	}
+
+	params := new(queries.MarketGroupUpdatePayload)
+	if err := c.BodyParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	if err := group.Update(params.Description); err != nil {
+		return marketGroupError(c, err)
+	}
+
+	return c.Status(200).JSON(marketGroupToEntity(group))
+}
+
+func DeleteMarketGroup(c *fiber.Ctx) error {
+	group, err := models.GetMarketGroup(c.Params("name"))
+	if err != nil {
+		return marketGroupError(c, err)
+	}
+
+	if err := group.Delete(); err != nil {
+		return marketGroupError(c, err)
+	}
+
+	return c.SendStatus(204)
+}
+
+// SetMarketGroupMarkets replaces the markets of a group, markets left without a group are public.
+func SetMarketGroupMarkets(c *fiber.Ctx) error {
+	group, err := models.GetMarketGroup(c.Params("name"))
+	if err != nil {
+		return marketGroupError(c, err)
+	}
+
+	params := new(queries.MarketGroupMarketsPayload)
+	if err := c.BodyParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	var count int64
+	config.DataBase.Model(&models.Market{}).Where("symbol IN ?", params.Markets).Count(&count)
+	if count != int64(len(params.Markets)) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.market_group.market_doesnt_exist"},
+		})
+	}
+
+	if err := group.SetMarkets(params.Markets); err != nil {
+		return marketGroupError(c, err)
+	}
+
+	return c.Status(200).JSON(marketGroupToEntity(group))
+}
+
+// UpdateMemberMarketGroup assigns a member and its sub-accounts to a market group.
+func UpdateMemberMarketGroup(c *fiber.Ctx) error {
+	var member *models.Member
+	if result := config.DataBase.First(&member, "uid = ?", c.Params("uid")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	params := new(queries.MemberMarketGroupPayload)
+	if err := c.BodyParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	errs := new(helpers.Errors)
+	helpers.Vaildate(params, errs)
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	if err := member.SetMarketGroup(params.Group); err != nil {
+		return marketGroupError(c, err)
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"uid":          member.UID,
+		"market_group": member.MarketGroup,
+	})
+}
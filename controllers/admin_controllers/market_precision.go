@@ -0,0 +1,87 @@
+package admin_controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/admin_controllers/entities"
+	"github.com/zsmartex/finex/controllers/admin_controllers/queries"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+func marketPrecisionChangeToEntity(change *models.MarketPrecisionChange) entities.MarketPrecisionChange {
+	return entities.MarketPrecisionChange{
+		ID:                 change.ID,
+		Market:             change.MarketID,
+		FromPricePrecision: change.FromPricePrecision,
+		PricePrecision:     change.PricePrecision,
+		State:              string(change.State),
+		Repriced:           change.Repriced,
+		Cancelled:          change.Cancelled,
+		CreatedBy:          change.CreatedBy,
+		CompletedAt:        change.CompletedAt,
+		CreatedAt:          change.CreatedAt,
+	}
+}
+
+// UpdateMarketPrecision changes the price precision of a market. The engine re-keys the resting orders and the
+// stop orders to it first, the market only takes the precision once the engine confirmed. Without the
+// confirmation within engine.rekey_timeout the change is refused and the market keeps its precision.
+func UpdateMarketPrecision(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", c.Params("market")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	params := new(queries.MarketPrecisionPayload)
+	if err := c.BodyParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	if params.PricePrecision == nil {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{models.ErrInvalidPricePrecision.Error()},
+		})
+	}
+
+	change, err := models.NewMarketPrecisionChange(market, *params.PricePrecision, CurrentUser.UID)
+	if err == nil {
+		err = models.RequestMarketPrecisionChange(config.DataBase, change, market, time.Now())
+	}
+
+	if err == nil {
+		err = models.AwaitMarketPrecisionChange(change, models.PrecisionChangeTimeout())
+	}
+
+	switch {
+	case errors.Is(err, models.ErrInvalidPricePrecision), errors.Is(err, models.ErrPrecisionChangeExists), errors.Is(err, models.ErrPrecisionChangeUnsupported):
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	case errors.Is(err, models.ErrPrecisionChangeUnconfirmed):
+		config.Logger.Warnf("Engine of market %s didn't confirm precision change %d", market.Symbol, change.ID)
+
+		return c.Status(504).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	case err != nil:
+		config.Logger.Errorf("Failed to change the price precision of market %s: %v", market.Symbol, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.market.update_error"},
+		})
+	}
+
+	return c.Status(200).JSON(marketPrecisionChangeToEntity(change))
+}
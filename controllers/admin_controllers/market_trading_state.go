@@ -0,0 +1,79 @@
+package admin_controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/admin_controllers/entities"
+	"github.com/zsmartex/finex/controllers/admin_controllers/queries"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+func marketTradingStateChangeToEntity(change *models.MarketTradingStateChange) entities.MarketTradingStateChange {
+	return entities.MarketTradingStateChange{
+		ID:               change.ID,
+		Market:           change.MarketID,
+		FromTradingState: string(change.FromTradingState),
+		TradingState:     string(change.TradingState),
+		State:            string(change.State),
+		CreatedBy:        change.CreatedBy,
+		CompletedAt:      change.CompletedAt,
+		CreatedAt:        change.CreatedAt,
+	}
+}
+
+// UpdateMarketTradingState sets the trading state of a market: trading, cancel_only or halted. The engine enforces
+// it on the book first, the market only takes the state once the engine confirmed. Without the confirmation within
+// engine.trading_state_timeout the change is refused and the market keeps its state.
+func UpdateMarketTradingState(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", c.Params("market")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	params := new(queries.MarketTradingStatePayload)
+	if err := c.BodyParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	change, err := models.NewMarketTradingStateChange(market, params.TradingState, CurrentUser.UID)
+	if err == nil {
+		err = models.RequestMarketTradingStateChange(config.DataBase, change, market, time.Now())
+	}
+
+	if err == nil {
+		err = models.AwaitMarketTradingStateChange(change, models.TradingStateChangeTimeout())
+	}
+
+	switch {
+	case errors.Is(err, models.ErrInvalidTradingState), errors.Is(err, models.ErrTradingStateChangeExists):
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	case errors.Is(err, models.ErrTradingStateChangeUnconfirmed):
+		config.Logger.Warnf("Engine of market %s didn't confirm trading state change %d", market.Symbol, change.ID)
+
+		return c.Status(504).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	case err != nil:
+		config.Logger.Errorf("Failed to change the trading state of market %s: %v", market.Symbol, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.market.update_error"},
+		})
+	}
+
+	return c.Status(200).JSON(marketTradingStateChangeToEntity(change))
+}
@@ -0,0 +1,423 @@
+package admin_controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/admin_controllers/entities"
+	"github.com/zsmartex/finex/controllers/admin_controllers/queries"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/types"
+	"github.com/zsmartex/pkg"
+)
+
+func marketSettingsToEntity(market *models.Market) entities.MarketSettings {
+	return entities.MarketSettings{
+		Market:                    market.Symbol,
+		State:                     market.State,
+		FeatureFlags:              models.GetMarketFeatureFlags(market.Symbol),
+		MinAmount:                 market.MinAmount,
+		MaxAmount:                 market.MaxAmount,
+		MaxQuoteAmount:            market.MaxQuoteAmount,
+		LotSize:                   market.LotSize,
+		MinNotional:               market.MinNotional,
+		BatchIntervalMs:           market.BatchIntervalMs,
+		CircuitBreakerRate:        market.CircuitBreakerRate,
+		CircuitBreakerWindowSec:   market.CircuitBreakerWindowSec,
+		CircuitBreakerCoolDownSec: market.CircuitBreakerCoolDownSec,
+		ConfigVersion:             market.ConfigVersion,
+	}
+}
+
+// GetMarkets lists the settings of the markets, archived markets are listed with include_archived.
+func GetMarkets(c *fiber.Ctx) error {
+	params := new(queries.ArchivedQuery)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	query := config.DataBase.Order("position asc")
+	if !params.IncludeArchived {
+		query = query.Where("state <> ?", types.MarketStateArchived)
+	}
+
+	var markets []*models.Market
+	query.Find(&markets)
+
+	market_entities := make([]entities.MarketSettings, 0, len(markets))
+	for _, market := range markets {
+		market_entities = append(market_entities, marketSettingsToEntity(market))
+	}
+
+	return helpers.RenderList(c, 200, market_entities)
+}
+
+// UnarchiveMarket takes a market out of the archive, back to disabled, only admins can and it's audited.
+func UnarchiveMarket(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", c.Params("market")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	if err := models.RestoreMarket(config.DataBase, market, CurrentUser.UID); err != nil {
+		if errors.Is(err, models.ErrArchiveNotArchived) {
+			return c.Status(422).JSON(helpers.Errors{
+				Errors: []string{"admin.market.not_archived"},
+			})
+		}
+
+		config.Logger.Errorf("Failed to unarchive market %s: %v", market.Symbol, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.market.unarchive_error"},
+		})
+	}
+
+	return c.Status(200).JSON(marketSettingsToEntity(market))
+}
+
+func GetMarketSettings(c *fiber.Ctx) error {
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", c.Params("market")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	return c.Status(200).JSON(marketSettingsToEntity(market))
+}
+
+// UpdateMarketSettings saves the settings as the next version of the configuration of the market and reloads
+// its engine so they're applied, the API reads the size limits from the market on every order.
+func UpdateMarketSettings(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", c.Params("market")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	params := new(queries.MarketSettingsPayload)
+	if err := c.BodyParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	for name := range params.FeatureFlags {
+		if !validFeatureFlag(name) {
+			return c.Status(422).JSON(helpers.Errors{
+				Errors: []string{"admin.market.invalid_feature_flag"},
+			})
+		}
+	}
+
+	if params.MaxAmount.Valid && (params.MaxAmount.Decimal.IsNegative() || params.MaxAmount.Decimal.IsPositive() && params.MaxAmount.Decimal.LessThan(market.MinAmount)) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.market.invalid_max_amount"},
+		})
+	}
+
+	if params.MaxQuoteAmount.Valid && params.MaxQuoteAmount.Decimal.IsNegative() {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.market.invalid_max_quote_amount"},
+		})
+	}
+
+	if params.LotSize.Valid && params.LotSize.Decimal.IsNegative() {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.market.invalid_lot_size"},
+		})
+	}
+
+	if params.MinNotional.Valid && params.MinNotional.Decimal.IsNegative() {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.market.invalid_min_notional"},
+		})
+	}
+
+	if params.BatchIntervalMs != nil && !models.ValidBatchInterval(*params.BatchIntervalMs) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.market.invalid_batch_interval"},
+		})
+	}
+
+	if params.CircuitBreakerRate.Valid && params.CircuitBreakerRate.Decimal.IsNegative() {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.market.invalid_circuit_breaker_rate"},
+		})
+	}
+
+	if params.CircuitBreakerWindowSec != nil && !models.ValidCircuitBreakerWindow(*params.CircuitBreakerWindowSec) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.market.invalid_circuit_breaker_window"},
+		})
+	}
+
+	if params.CircuitBreakerCoolDownSec != nil && *params.CircuitBreakerCoolDownSec < 0 {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.market.invalid_circuit_breaker_cool_down"},
+		})
+	}
+
+	updates := make(map[string]interface{})
+	if params.MaxAmount.Valid {
+		updates["max_amount"] = params.MaxAmount.Decimal
+	}
+
+	if params.MaxQuoteAmount.Valid {
+		updates["max_quote_amount"] = params.MaxQuoteAmount.Decimal
+	}
+
+	if params.LotSize.Valid {
+		updates["lot_size"] = params.LotSize.Decimal
+	}
+
+	if params.MinNotional.Valid {
+		updates["min_notional"] = params.MinNotional.Decimal
+	}
+
+	if params.BatchIntervalMs != nil {
+		updates["batch_interval_ms"] = *params.BatchIntervalMs
+	}
+
+	if params.CircuitBreakerRate.Valid {
+		updates["circuit_breaker_rate"] = params.CircuitBreakerRate.Decimal
+	}
+
+	if params.CircuitBreakerWindowSec != nil {
+		updates["circuit_breaker_window_sec"] = *params.CircuitBreakerWindowSec
+	}
+
+	if params.CircuitBreakerCoolDownSec != nil {
+		updates["circuit_breaker_cool_down_sec"] = *params.CircuitBreakerCoolDownSec
+	}
+
+	// the settings and the version of the configuration they make are written at once, the engine reloads both
+	err := config.DataBase.Transaction(func(tx *gorm.DB) error {
+		if len(updates) > 0 {
+			if result := tx.Model(market).Updates(updates); result.Error != nil {
+				return result.Error
+			}
+		}
+
+		for name, enabled := range params.FeatureFlags {
+			if err := models.SetMarketFeatureFlag(tx, market.Symbol, name, enabled); err != nil {
+				return err
+			}
+		}
+
+		_, err := models.BumpMarketConfigVersion(tx, market, CurrentUser.UID)
+
+		return err
+	})
+	if err != nil {
+		config.Logger.Errorf("Failed to update the settings of market %s: %v", market.Symbol, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.market.update_error"},
+		})
+	}
+
+	config.Bus.Publish("matching", market.Symbol, map[string]interface{}{
+		"action": pkg.ActionReload,
+		"symbol": market.GetSymbol(),
+	})
+
+	return c.Status(200).JSON(marketSettingsToEntity(market))
+}
+
+// ScheduleMarketListing lists a market at an announced time, it's hidden until visible_at, takes post-only orders
+// from the warm-up and opens exactly at opens_at. The engine of the market is reloaded to time the open itself.
+func ScheduleMarketListing(c *fiber.Ctx) error {
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", c.Params("market")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	params := new(queries.MarketListingPayload)
+	if err := c.BodyParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	err := market.ScheduleListing(params.VisibleAt, params.OpensAt, time.Duration(params.WarmupSeconds)*time.Second, time.Now())
+	switch {
+	case errors.Is(err, models.ErrListingSchedule), errors.Is(err, models.ErrListingOpened):
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	case err != nil:
+		config.Logger.Errorf("Failed to schedule the listing of market %s: %v", market.Symbol, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.market.update_error"},
+		})
+	}
+
+	if err := config.Bus.Publish("matching", market.Symbol, map[string]interface{}{
+		"action": pkg.ActionReload,
+		"symbol": market.GetSymbol(),
+	}); err != nil {
+		config.Logger.Errorf("Failed to reload the engine of market %s: %v", market.Symbol, err)
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"market":             market.Symbol,
+		"listing_visible_at": market.ListingVisibleAt,
+		"listing_warmup_at":  market.ListingWarmupAt.Time,
+		"listing_opens_at":   market.ListingOpensAt.Time,
+	})
+}
+
+// LiftMarketCircuitBreaker lets a market whose circuit breaker tripped take orders before its cool-down is over.
+func LiftMarketCircuitBreaker(c *fiber.Ctx) error {
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", c.Params("market")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	if err := config.Bus.Publish("matching", market.Symbol, map[string]interface{}{
+		"action": events.ActionLiftCircuitBreaker,
+		"symbol": market.GetSymbol(),
+	}); err != nil {
+		config.Logger.Errorf("Failed to lift the circuit breaker of market %s: %v", market.Symbol, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.market.update_error"},
+		})
+	}
+
+	return c.Status(200).JSON(marketSettingsToEntity(market))
+}
+
+// StartMarketAuction holds the book of a market in a call auction, its orders accumulate without matching until
+// the auction is uncrossed, see docs/call_auction.md.
+func StartMarketAuction(c *fiber.Ctx) error {
+	return sendAuctionCommand(c, events.ActionStartAuction)
+}
+
+// UncrossMarketAuction uncrosses the call auction of the book of a market at its clearing price.
+func UncrossMarketAuction(c *fiber.Ctx) error {
+	return sendAuctionCommand(c, events.ActionUncross)
+}
+
+func sendAuctionCommand(c *fiber.Ctx, action pkg.PayloadAction) error {
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", c.Params("market")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	if err := config.Bus.Publish("matching", market.Symbol, map[string]interface{}{
+		"action": action,
+		"symbol": market.GetSymbol(),
+	}); err != nil {
+		config.Logger.Errorf("Failed to send %s to market %s: %v", action, market.Symbol, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.market.update_error"},
+		})
+	}
+
+	return c.Status(200).JSON(marketSettingsToEntity(market))
+}
+
+// CheckMarketInvariants has the engine check the invariants of the book of a market now, see docs/invariants.md.
+func CheckMarketInvariants(c *fiber.Ctx) error {
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", c.Params("market")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	check, err := helpers.CheckEngineInvariants(market.Symbol)
+	if err != nil {
+		return c.Status(503).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	}
+
+	return c.Status(200).JSON(check)
+}
+
+func validFeatureFlag(name types.FeatureFlag) bool {
+	for _, flag := range types.FeatureFlags {
+		if flag == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetMarketConfig returns the configuration of a market as of a version or a time, the latest one without either.
+// Trades keep the version they were matched with.
+func GetMarketConfig(c *fiber.Ctx) error {
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", c.Params("market")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	params := new(queries.MarketConfigQuery)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	var config_version *models.MarketConfigVersion
+	var err error
+	switch {
+	case params.Version > 0:
+		config_version, err = models.FindMarketConfigVersion(market.Symbol, params.Version)
+	case params.At > 0:
+		config_version, err = models.MarketConfigVersionAt(market.Symbol, time.Unix(params.At, 0))
+	default:
+		config_version, err = models.FindMarketConfigVersion(market.Symbol, market.ConfigVersion)
+	}
+
+	if errors.Is(err, models.ErrMarketConfigVersionNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	} else if err != nil {
+		config.Logger.Errorf("Failed to find the configuration of market %s: %v", market.Symbol, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.internal_error"},
+		})
+	}
+
+	return c.Status(200).JSON(entities.MarketConfigVersion{
+		Market:    config_version.MarketID,
+		Version:   config_version.Version,
+		Config:    json.RawMessage(config_version.Config),
+		ActorUID:  config_version.ActorUID,
+		CreatedAt: config_version.CreatedAt,
+	})
+}
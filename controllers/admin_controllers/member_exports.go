@@ -0,0 +1,159 @@
+package admin_controllers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/admin_controllers/entities"
+	"github.com/zsmartex/finex/controllers/admin_controllers/queries"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+func memberExportError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, models.ErrMemberExportKey), errors.Is(err, models.ErrMemberExportNotReady), errors.Is(err, models.ErrMemberExportCorrupt):
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	case errors.Is(err, models.ErrMemberExportLink):
+		return c.Status(403).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	default:
+		return reportError(c, err)
+	}
+}
+
+func getMemberExport(c *fiber.Ctx) (*models.ReportJob, error) {
+	id, err := uuid.Parse(c.Params("uuid"))
+	if err != nil {
+		return nil, models.ErrReportJobNotFound
+	}
+
+	job, err := models.GetReportJob(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.Kind != models.ReportKindMemberExport {
+		return nil, models.ErrReportJobNotFound
+	}
+
+	return job, nil
+}
+
+// CreateMemberExport queues the export of everything held about a member for the report generator.
+func CreateMemberExport(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	var member *models.Member
+	result := config.DataBase.Where("uid = ?", c.Params("uid")).First(&member)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	job, err := models.EnqueueMemberExport(member, CurrentUser)
+	if err != nil {
+		return memberExportError(c, err)
+	}
+
+	return c.Status(202).JSON(&entities.MemberExport{ReportJob: reportJobToEntity(job)})
+}
+
+// GetMemberExport returns the state of an export, with a download link valid for member_exports.link_ttl once it's done.
+func GetMemberExport(c *fiber.Ctx) error {
+	job, err := getMemberExport(c)
+	if err != nil {
+		return memberExportError(c, err)
+	}
+
+	entity := &entities.MemberExport{ReportJob: reportJobToEntity(job)}
+	// the location in the bucket is only of use to the download endpoint
+	entity.Location = ""
+
+	if job.State == models.ReportJobStateDone {
+		expires, signature, err := models.SignMemberExportLink(job.UUID, time.Now())
+		if err != nil {
+			return memberExportError(c, err)
+		}
+
+		expires_at := time.Unix(expires, 0)
+		entity.ExpiresAt = &expires_at
+		entity.DownloadURL = fmt.Sprintf("/api/v2/admin/member_exports/%s/download?expires=%d&signature=%s", job.UUID, expires, signature)
+	}
+
+	return c.Status(200).JSON(entity)
+}
+
+// DownloadMemberExport decrypts an export for a signed link which hasn't expired and records who downloaded it.
+func DownloadMemberExport(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	params := new(queries.MemberExportLink)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	job, err := getMemberExport(c)
+	if err != nil {
+		return memberExportError(c, err)
+	}
+
+	if err := models.VerifyMemberExportLink(job.UUID, params.Expires, params.Signature, time.Now()); err != nil {
+		return memberExportError(c, err)
+	}
+
+	if job.State != models.ReportJobStateDone {
+		return memberExportError(c, models.ErrMemberExportNotReady)
+	}
+
+	member, err := job.MemberExportMember()
+	if err != nil {
+		return memberExportError(c, err)
+	}
+
+	file, err := os.Open(job.Location)
+	if err != nil {
+		return memberExportError(c, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return memberExportError(c, err)
+	}
+
+	// the export is checked before the response starts, so an altered export gets an error instead of a broken zip
+	if err := models.AuthenticateMemberExport(file, info.Size()); err != nil {
+		file.Close()
+		return memberExportError(c, err)
+	}
+
+	models.RecordAuditEvent(member.ID, CurrentUser.UID, "member_export.downloaded", job.UUID.String())
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"%s-export.zip\"", member.UID))
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer file.Close()
+
+		if err := models.DecryptMemberExport(file, info.Size(), w); err != nil {
+			config.Logger.Errorf("Failed to decrypt member export %s: %v", job.UUID, err)
+		}
+	})
+
+	return nil
+}
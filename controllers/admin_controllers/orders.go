@@ -34,7 +34,7 @@ func CancelOrder(c *fiber.Ctx) error {
 	}
 
 	// Doing cancel
-	config.KafkaProducer.Produce("matching", map[string]interface{}{
+	config.Bus.Publish("matching", order.MarketID, map[string]interface{}{
 		"action": pkg.ActionCancel,
 		"order":  order.ToMatchingAttributes(),
 	})
@@ -43,6 +43,8 @@ func CancelOrder(c *fiber.Ctx) error {
 }
 
 func CancelAllOrders(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
 	var orders []*models.Order
 	params := new(queries.CancelOrderParams)
 
@@ -76,14 +78,50 @@ func CancelAllOrders(c *fiber.Ctx) error {
 
 	tx.Find(&orders)
 
+	// every member whose orders are cancelled gets an event, written before the cancels are sent
+	var security_events []*models.SecurityEvent
+	if err := config.DataBase.Transaction(func(tx *gorm.DB) error {
+		cancelled := make(map[int64]int)
+		members := make([]int64, 0)
+		for _, order := range orders {
+			if cancelled[order.MemberID] == 0 {
+				members = append(members, order.MemberID)
+			}
+			cancelled[order.MemberID]++
+		}
+
+		for _, member_id := range members {
+			security_event, err := models.RecordSecurityEvent(tx, member_id, models.SecurityEventCancelAll, CurrentUser.UID, map[string]interface{}{
+				"market": params.Market,
+				"side":   params.Side,
+				"orders": cancelled[member_id],
+			})
+			if err != nil {
+				return err
+			}
+
+			security_events = append(security_events, security_event)
+		}
+
+		return nil
+	}); err != nil {
+		config.Logger.Errorf("Failed to record the cancel all of the members: %v", err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.orders.cancel_all_failed"},
+		})
+	}
+
 	for _, order := range orders {
 		// Doing cancel
-		config.KafkaProducer.Produce("matching", map[string]interface{}{
+		config.Bus.Publish("matching", order.MarketID, map[string]interface{}{
 			"action": pkg.ActionCancel,
 			"order":  order.ToMatchingAttributes(),
 		})
 	}
 
+	models.NotifySecurityEvents(security_events)
+
 	var ordersJSON []entities.OrderEntity
 
 	for _, order := range orders {
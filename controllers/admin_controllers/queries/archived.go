@@ -0,0 +1,6 @@
+package queries
+
+// ArchivedQuery is the filter of the admin listings, archived IEOs and markets are left out unless they're asked for.
+type ArchivedQuery struct {
+	IncludeArchived bool `query:"include_archived"`
+}
@@ -0,0 +1,8 @@
+package queries
+
+type CandleDiscrepancyFilters struct {
+	Market string `query:"market"`
+	Period string `query:"period"`
+	Limit  int    `query:"limit"`
+	Page   int    `query:"page"`
+}
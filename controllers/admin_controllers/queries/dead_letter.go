@@ -0,0 +1,8 @@
+package queries
+
+type DeadLetterFilters struct {
+	Topic string `query:"topic"`
+	State string `query:"state"`
+	Limit int    `query:"limit"`
+	Page  int    `query:"page"`
+}
@@ -0,0 +1,7 @@
+package queries
+
+type MarketConfigQuery struct {
+	Version int64 `query:"version"`
+	// At is a unix timestamp, the version in force at that time is returned
+	At int64 `query:"at"`
+}
@@ -0,0 +1,11 @@
+package queries
+
+type MarketDataPolicyPayload struct {
+	DepthLevels   int64    `json:"depth_levels"`
+	TradesLimit   int64    `json:"trades_limit"`
+	CandlePeriods []string `json:"candle_periods"`
+}
+
+type MemberMarketDataSubscriptionPayload struct {
+	Subscribed bool `json:"subscribed"`
+}
@@ -0,0 +1,12 @@
+package queries
+
+import "time"
+
+type MarketDelistingPayload struct {
+	// CancelOnlyAt is when the market starts to take cancels only, right away when it's not set
+	CancelOnlyAt time.Time `json:"cancel_only_at"`
+	// CancelAt is when the orders left are cancelled, the delisting can be aborted until then
+	CancelAt time.Time `json:"cancel_at" validate:"required"`
+	// HaltAt is when the market is halted, hidden and delisted
+	HaltAt time.Time `json:"halt_at" validate:"required"`
+}
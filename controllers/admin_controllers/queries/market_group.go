@@ -0,0 +1,18 @@
+package queries
+
+type MarketGroupPayload struct {
+	Name        string `json:"name" validate:"required"`
+	Description string `json:"description"`
+}
+
+type MarketGroupUpdatePayload struct {
+	Description string `json:"description"`
+}
+
+type MarketGroupMarketsPayload struct {
+	Markets []string `json:"markets"`
+}
+
+type MemberMarketGroupPayload struct {
+	Group string `json:"group" validate:"required"`
+}
@@ -0,0 +1,11 @@
+package queries
+
+import "time"
+
+type MarketListingPayload struct {
+	// VisibleAt is when the market is shown with its countdown, right away when it's not set
+	VisibleAt time.Time `json:"visible_at"`
+	OpensAt   time.Time `json:"opens_at" validate:"required"`
+	// WarmupSeconds is how long before OpensAt the post-only warm-up starts, 10 minutes when it's not set
+	WarmupSeconds int64 `json:"warmup_seconds"`
+}
@@ -0,0 +1,6 @@
+package queries
+
+type MarketPrecisionPayload struct {
+	// PricePrecision is the number of decimals of the prices of the market, the resting orders are re-keyed to it
+	PricePrecision *int `json:"price_precision"`
+}
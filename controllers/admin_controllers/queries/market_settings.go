@@ -0,0 +1,24 @@
+package queries
+
+import (
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/types"
+)
+
+type MarketSettingsPayload struct {
+	FeatureFlags map[types.FeatureFlag]bool `json:"feature_flags"`
+	// MaxAmount and MaxQuoteAmount are left unchanged when they're not set, zero removes the cap
+	MaxAmount      decimal.NullDecimal `json:"max_amount"`
+	MaxQuoteAmount decimal.NullDecimal `json:"max_quote_amount"`
+	// LotSize and MinNotional are left unchanged when they're not set, zero removes the constraint
+	LotSize     decimal.NullDecimal `json:"lot_size"`
+	MinNotional decimal.NullDecimal `json:"min_notional"`
+	// BatchIntervalMs is left unchanged when it's not set, zero goes back to continuous matching
+	BatchIntervalMs *int64 `json:"batch_interval_ms"`
+	// CircuitBreakerRate, CircuitBreakerWindowSec and CircuitBreakerCoolDownSec are left unchanged when they're not
+	// set, a zero rate disables the circuit breaker
+	CircuitBreakerRate        decimal.NullDecimal `json:"circuit_breaker_rate"`
+	CircuitBreakerWindowSec   *int64              `json:"circuit_breaker_window_sec"`
+	CircuitBreakerCoolDownSec *int64              `json:"circuit_breaker_cool_down_sec"`
+}
@@ -0,0 +1,8 @@
+package queries
+
+import "github.com/zsmartex/finex/types"
+
+type MarketTradingStatePayload struct {
+	// TradingState is what the book of the market takes: trading, cancel_only or halted
+	TradingState types.TradingState `json:"trading_state"`
+}
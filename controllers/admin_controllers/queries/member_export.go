@@ -0,0 +1,6 @@
+package queries
+
+type MemberExportLink struct {
+	Expires   int64  `query:"expires"`
+	Signature string `query:"signature"`
+}
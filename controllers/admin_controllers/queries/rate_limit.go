@@ -0,0 +1,7 @@
+package queries
+
+type RateLimitPayload struct {
+	Limit int `json:"limit"`
+	// WindowSeconds is the window of the override, the window of the bucket when it's zero
+	WindowSeconds int64 `json:"window_seconds"`
+}
@@ -0,0 +1,13 @@
+package queries
+
+type ReferralCodeFilters struct {
+	UID   string `query:"uid"`
+	Code  string `query:"code"`
+	State string `query:"state"`
+	Limit int    `query:"limit"`
+	Page  int    `query:"page"`
+}
+
+type ReferralCodeStatePayload struct {
+	State string `json:"state"`
+}
@@ -0,0 +1,25 @@
+package queries
+
+type CommissionReportFilters struct {
+	Year   int    `query:"year"`
+	Format string `query:"format"`
+	// Async writes the report to the report bucket instead of streaming it
+	Async bool `query:"async"`
+}
+
+type RoundingDriftFilters struct {
+	Currency string `query:"currency"`
+	TimeFrom int64  `query:"time_from"`
+	TimeTo   int64  `query:"time_to"`
+	// Threshold overrides rounding_drift_threshold
+	Threshold string `query:"threshold"`
+}
+
+type RevenueReportFilters struct {
+	// Period is day or month, month when it's not set
+	Period   string `query:"period"`
+	Currency string `query:"currency"`
+	Market   string `query:"market"`
+	TimeFrom int64  `query:"time_from"`
+	TimeTo   int64  `query:"time_to"`
+}
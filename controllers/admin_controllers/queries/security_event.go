@@ -0,0 +1,9 @@
+package queries
+
+type SecurityEventFilters struct {
+	Kind string `query:"kind"`
+	// UID is the uid of the member of the events
+	UID   string `query:"uid"`
+	Limit int    `query:"limit"`
+	Page  int    `query:"page"`
+}
@@ -0,0 +1,11 @@
+package queries
+
+type TradeReversalFilters struct {
+	State string `query:"state"`
+	Limit int    `query:"limit"`
+	Page  int    `query:"page"`
+}
+
+type TradeReversalPayload struct {
+	Reason string `json:"reason" validate:"required"`
+}
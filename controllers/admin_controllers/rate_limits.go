@@ -0,0 +1,109 @@
+package admin_controllers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/admin_controllers/entities"
+	"github.com/zsmartex/finex/controllers/admin_controllers/queries"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+func rateLimitUsageToEntity(usage models.RateLimitUsage) *entities.RateLimit {
+	return &entities.RateLimit{
+		Bucket:        string(usage.Bucket),
+		Limit:         usage.Limit,
+		WindowSeconds: int64(usage.Window.Seconds()),
+		Used:          usage.Used,
+		Remaining:     usage.Remaining,
+		ResetAt:       usage.ResetAt,
+		Override:      usage.Overridden,
+	}
+}
+
+func rateLimitError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, models.ErrRateLimitBucket):
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	case errors.Is(err, models.ErrRateLimitLimit), errors.Is(err, models.ErrRateLimitWindow):
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	default:
+		config.Logger.Errorf("Failed to update rate limit: %v", err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.rate_limit.update_error"},
+		})
+	}
+}
+
+// GetMemberRateLimits lists the rate limits of a member, with its usage on the API replica serving the request.
+func GetMemberRateLimits(c *fiber.Ctx) error {
+	var member *models.Member
+	if result := config.DataBase.First(&member, "uid = ?", c.Params("uid")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	rate_limits := make([]*entities.RateLimit, 0)
+	for _, usage := range models.RateLimits.InspectAll(member.ID) {
+		rate_limits = append(rate_limits, rateLimitUsageToEntity(usage))
+	}
+
+	return helpers.RenderList(c, 200, rate_limits)
+}
+
+// UpdateMemberRateLimit overrides the limit of a bucket for a member, every replica applies it within 30 seconds.
+func UpdateMemberRateLimit(c *fiber.Ctx) error {
+	var member *models.Member
+	if result := config.DataBase.First(&member, "uid = ?", c.Params("uid")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	params := new(queries.RateLimitPayload)
+	if err := c.BodyParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	override := &models.RateLimitOverride{
+		MemberID:      member.ID,
+		Bucket:        models.RateLimitBucket(c.Params("bucket")),
+		Limit:         params.Limit,
+		WindowSeconds: params.WindowSeconds,
+	}
+
+	if err := models.SaveRateLimitOverride(override); err != nil {
+		return rateLimitError(c, err)
+	}
+
+	return c.Status(200).JSON(rateLimitUsageToEntity(models.RateLimits.Inspect(member.ID, override.Bucket)))
+}
+
+// DeleteMemberRateLimit puts a member back on the limit of the bucket for everyone.
+func DeleteMemberRateLimit(c *fiber.Ctx) error {
+	var member *models.Member
+	if result := config.DataBase.First(&member, "uid = ?", c.Params("uid")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	bucket := models.RateLimitBucket(c.Params("bucket"))
+	if err := models.DeleteRateLimitOverride(member.ID, bucket); err != nil {
+		return rateLimitError(c, err)
+	}
+
+	return c.Status(200).JSON(rateLimitUsageToEntity(models.RateLimits.Inspect(member.ID, bucket)))
+}
@@ -0,0 +1,125 @@
+package admin_controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/admin_controllers/entities"
+	"github.com/zsmartex/finex/controllers/admin_controllers/queries"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+type referralCodeRow struct {
+	models.ReferralCode
+	UID     string
+	Signups int64
+}
+
+func referralCodeRowToEntity(row *referralCodeRow) *entities.ReferralCode {
+	return &entities.ReferralCode{
+		ID:          row.ID,
+		UID:         row.UID,
+		Code:        row.Code,
+		Label:       row.Label,
+		FriendShare: row.FriendShare,
+		Default:     row.Default,
+		State:       string(row.State),
+		Signups:     row.Signups,
+		CreatedAt:   row.CreatedAt,
+		UpdatedAt:   row.UpdatedAt,
+	}
+}
+
+func referralCodesQuery() *gorm.DB {
+	return config.DataBase.
+		Table("referral_codes").
+		Select("referral_codes.*, members.uid AS uid, (SELECT COUNT(*) FROM members friends WHERE friends.referral_code_id = referral_codes.id) AS signups").
+		Joins("JOIN members ON members.id = referral_codes.member_id")
+}
+
+// GetReferralCodes lists the referral codes of every member, the codes with the most signups first.
+func GetReferralCodes(c *fiber.Ctx) error {
+	params := new(queries.ReferralCodeFilters)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	if params.Limit == 0 {
+		params.Limit = 100
+	}
+
+	if params.Page == 0 {
+		params.Page = 1
+	}
+
+	tx := referralCodesQuery()
+
+	if len(params.UID) > 0 {
+		tx = tx.Where("members.uid = ?", params.UID)
+	}
+
+	if len(params.Code) > 0 {
+		tx = tx.Where("referral_codes.code = ?", models.NormalizeReferralCode(params.Code))
+	}
+
+	if len(params.State) > 0 {
+		tx = tx.Where("referral_codes.state = ?", params.State)
+	}
+
+	var rows []*referralCodeRow
+	tx.Order("signups desc, referral_codes.id asc").Offset(params.Page*params.Limit - params.Limit).Limit(params.Limit).Scan(&rows)
+
+	referral_code_entities := make([]*entities.ReferralCode, 0, len(rows))
+	for _, row := range rows {
+		referral_code_entities = append(referral_code_entities, referralCodeRowToEntity(row))
+	}
+
+	c.Response().Header.Add("page", strconv.FormatInt(int64(params.Page), 10))
+	c.Response().Header.Add("per-page", strconv.FormatInt(int64(params.Limit), 10))
+
+	return c.Status(200).JSON(referral_code_entities)
+}
+
+// UpdateReferralCodeState disables or re-enables a code, friends of a disabled code earn nothing to their referrer.
+func UpdateReferralCodeState(c *fiber.Ctx) error {
+	var referral_code *models.ReferralCode
+	if result := config.DataBase.First(&referral_code, "code = ?", models.NormalizeReferralCode(c.Params("code"))); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	params := new(queries.ReferralCodeStatePayload)
+	if err := c.BodyParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	state := models.ReferralCodeState(params.State)
+	if state != models.ReferralCodeStateActive && state != models.ReferralCodeStateDisabled {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.referral_code.invalid_state"},
+		})
+	}
+
+	if err := referral_code.SetState(state); err != nil {
+		config.Logger.Errorf("Failed to set state of referral code %s: %v", referral_code.Code, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.referral_code.update_error"},
+		})
+	}
+
+	var row *referralCodeRow
+	referralCodesQuery().Where("referral_codes.id = ?", referral_code.ID).Scan(&row)
+
+	return c.Status(200).JSON(referralCodeRowToEntity(row))
+}
@@ -0,0 +1,241 @@
+package admin_controllers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/admin_controllers/entities"
+	"github.com/zsmartex/finex/controllers/admin_controllers/queries"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+func reportJobToEntity(job *models.ReportJob) *entities.ReportJob {
+	entity := &entities.ReportJob{
+		UUID:      job.UUID,
+		Kind:      string(job.Kind),
+		State:     string(job.State),
+		Location:  job.Location,
+		Error:     job.Error,
+		CreatedAt: job.CreatedAt,
+	}
+
+	if job.FinishedAt.Valid {
+		entity.FinishedAt = &job.FinishedAt.Time
+	}
+
+	return entity
+}
+
+func reportError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, models.ErrReportJobNotFound):
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	case errors.Is(err, models.ErrReportStorage):
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	default:
+		config.Logger.Errorf("Failed to create report: %v", err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.report.create_error"},
+		})
+	}
+}
+
+// GetCommissionsReport streams the referral earnings of every member over a year as CSV,
+// or queues the report for the report generator when async is set.
+func GetCommissionsReport(c *fiber.Ctx) error {
+	params := new(queries.CommissionReportFilters)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	now := time.Now()
+
+	if len(params.Format) > 0 && params.Format != "csv" {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.report.invalid_format"},
+		})
+	}
+
+	if !models.ValidCommissionYear(params.Year, now) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{models.ErrCommissionStatementYear.Error()},
+		})
+	}
+
+	if params.Async {
+		job, err := models.EnqueueReportJob(models.ReportKindCommissions, models.CommissionReportParams{Year: params.Year})
+		if err != nil {
+			return reportError(c, err)
+		}
+
+		return c.Status(202).JSON(reportJobToEntity(job))
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"commissions-%d.csv\"", params.Year))
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := models.WriteCommissionStatement(config.DataBase, w, params.Year, now, config.Reports.ChunkSize, w.Flush); err != nil {
+			config.Logger.Errorf("Failed to stream the commissions report of %d: %v", params.Year, err)
+		}
+	})
+
+	return nil
+}
+
+// GetReportJob returns the state of a report queued with async, and where to find it once it's done.
+func GetReportJob(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("uuid"))
+	if err != nil {
+		return reportError(c, models.ErrReportJobNotFound)
+	}
+
+	job, err := models.GetReportJob(id)
+	if err != nil {
+		return reportError(c, err)
+	}
+
+	return c.Status(200).JSON(reportJobToEntity(job))
+}
+
+// GetRoundingDriftReport sums the drift between the fees and commissions booked and the exact values they were
+// rounded from, per currency, day and code path. Paths drifting more than the threshold in a day are flagged.
+func GetRoundingDriftReport(c *fiber.Ctx) error {
+	params := new(queries.RoundingDriftFilters)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	threshold := decimal.Zero
+	if len(params.Threshold) > 0 {
+		value, err := decimal.NewFromString(params.Threshold)
+		if err != nil || value.IsNegative() {
+			return c.Status(422).JSON(helpers.Errors{
+				Errors: []string{"admin.report.invalid_threshold"},
+			})
+		}
+
+		threshold = value
+	}
+
+	time_to := time.Now()
+	if params.TimeTo > 0 {
+		time_to = time.Unix(params.TimeTo, 0)
+	}
+
+	time_from := time_to.AddDate(0, 0, -7)
+	if params.TimeFrom > 0 {
+		time_from = time.Unix(params.TimeFrom, 0)
+	}
+
+	drifts, err := models.RoundingDriftReport(config.DataBase, time_from, time_to, params.Currency, threshold)
+	if err != nil {
+		config.Logger.Errorf("Failed to report the rounding drift: %v", err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.report.rounding_drift_error"},
+		})
+	}
+
+	drift_entities := make([]*entities.RoundingDrift, 0, len(drifts))
+	for _, drift := range drifts {
+		drift_entities = append(drift_entities, &entities.RoundingDrift{
+			Currency: drift.CurrencyID,
+			Day:      drift.Day,
+			Path:     string(drift.Path),
+			Rows:     drift.Rows,
+			Drift:    drift.Drift,
+			Flagged:  drift.Flagged,
+		})
+	}
+
+	return c.Status(200).JSON(drift_entities)
+}
+
+// GetRevenueReport returns the fee revenue per period, currency and market, net of the referral commissions, rebates
+// and kickbacks paid from it and valued in USDT at the prices of the end of each period. Each row is reconciled
+// against the daily stats of its days, the rows which don't match them are flagged.
+func GetRevenueReport(c *fiber.Ctx) error {
+	params := new(queries.RevenueReportFilters)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	if len(params.Period) == 0 {
+		params.Period = "month"
+	}
+
+	now := time.Now()
+
+	time_to := now
+	if params.TimeTo > 0 {
+		time_to = time.Unix(params.TimeTo, 0)
+	}
+
+	time_from := time_to.AddDate(-1, 0, 0)
+	if params.Period == "day" {
+		time_from = time_to.AddDate(0, 0, -30)
+	}
+	if params.TimeFrom > 0 {
+		time_from = time.Unix(params.TimeFrom, 0)
+	}
+
+	rows, err := models.RevenueReport(config.DataBase, params.Period, time_from, time_to, now, models.HistoricalMarketPrices)
+	if errors.Is(err, models.ErrRevenueReportPeriod) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	}
+
+	if err != nil {
+		config.Logger.Errorf("Failed to report the revenue: %v", err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.report.revenue_error"},
+		})
+	}
+
+	revenue_entities := make([]*entities.RevenueReport, 0, len(rows))
+	for _, row := range rows {
+		if len(params.Currency) > 0 && row.CurrencyID != params.Currency || len(params.Market) > 0 && row.MarketID != params.Market {
+			continue
+		}
+
+		revenue_entities = append(revenue_entities, &entities.RevenueReport{
+			Period:              row.Period,
+			Currency:            row.CurrencyID,
+			Market:              row.MarketID,
+			Fees:                row.Fees,
+			ReferralCommissions: row.ReferralCommissions,
+			Rebates:             row.Rebates,
+			Kickbacks:           row.Kickbacks,
+			Net:                 row.Net,
+			USDTRate:            row.USDTRate,
+			NetUSDT:             row.NetUSDT,
+			StatsNet:            row.StatsNet,
+			Reconciled:          row.Reconciled,
+			Discrepancy:         row.Discrepancy,
+		})
+	}
+
+	return c.Status(200).JSON(revenue_entities)
+}
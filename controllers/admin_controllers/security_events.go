@@ -0,0 +1,69 @@
+package admin_controllers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/admin_controllers/entities"
+	"github.com/zsmartex/finex/controllers/admin_controllers/queries"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+// GetSecurityEvents lists the security events of every member, the most recent first.
+func GetSecurityEvents(c *fiber.Ctx) error {
+	params := new(queries.SecurityEventFilters)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	if len(params.Kind) > 0 && !models.ValidSecurityEventKind(models.SecurityEventKind(params.Kind)) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.security_event.invalid_kind"},
+		})
+	}
+
+	helpers.PageDefaults(&params.Page, &params.Limit)
+
+	tx := config.DataBase.Order("id desc")
+	if len(params.Kind) > 0 {
+		tx = tx.Where("kind = ?", params.Kind)
+	}
+
+	if len(params.UID) > 0 {
+		var member *models.Member
+		result := config.DataBase.First(&member, "uid = ?", params.UID)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return c.Status(404).JSON(helpers.Errors{
+				Errors: []string{"record.not_found"},
+			})
+		}
+
+		tx = tx.Where("member_id = ?", member.ID)
+	}
+
+	var security_events []*models.SecurityEvent
+	tx.Offset(params.Page*params.Limit - params.Limit).Limit(params.Limit).Find(&security_events)
+
+	security_event_entities := make([]*entities.SecurityEvent, 0, len(security_events))
+	for _, security_event := range security_events {
+		security_event_entities = append(security_event_entities, &entities.SecurityEvent{
+			ID:        security_event.ID,
+			MemberID:  security_event.MemberID,
+			Kind:      string(security_event.Kind),
+			ActorUID:  security_event.ActorUID,
+			Data:      json.RawMessage(security_event.Data),
+			CreatedAt: security_event.CreatedAt,
+		})
+	}
+
+	helpers.SetPageHeaders(c, params.Page, params.Limit)
+
+	return c.Status(200).JSON(security_event_entities)
+}
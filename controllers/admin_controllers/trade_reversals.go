@@ -0,0 +1,193 @@
+package admin_controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/admin_controllers/entities"
+	"github.com/zsmartex/finex/controllers/admin_controllers/queries"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+func tradeReversalToEntity(reversal *models.TradeReversal, with_entries bool) *entities.TradeReversal {
+	entry_entities := make([]*entities.TradeReversalEntry, 0)
+	if with_entries {
+		for _, entry := range reversal.Entries() {
+			entry_entities = append(entry_entities, &entities.TradeReversalEntry{
+				Kind:        entry.Kind,
+				ReferenceID: entry.ReferenceID,
+				MemberID:    entry.MemberID,
+				CurrencyID:  entry.CurrencyID,
+				Amount:      entry.Amount,
+				Note:        entry.Note,
+				CreatedAt:   entry.CreatedAt,
+			})
+		}
+	}
+
+	return &entities.TradeReversal{
+		ID:          reversal.ID,
+		TradeID:     reversal.TradeID,
+		Reason:      reversal.Reason,
+		State:       string(reversal.State),
+		RequestedBy: reversal.RequestedBy,
+		ReviewedBy:  reversal.ReviewedBy,
+		ExecutedAt:  reversal.ExecutedAt,
+		Entries:     entry_entities,
+		CreatedAt:   reversal.CreatedAt,
+		UpdatedAt:   reversal.UpdatedAt,
+	}
+}
+
+func tradeReversalError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, models.ErrTradeReversalRequested),
+		errors.Is(err, models.ErrTradeReversalReverted),
+		errors.Is(err, models.ErrTradeReversalNotPending),
+		errors.Is(err, models.ErrTradeReversalSameAdmin),
+		errors.Is(err, models.ErrTradeReversalOrderOpen):
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	case errors.Is(err, models.ErrTradeReversalInsufficient):
+		config.Logger.Errorf("Failed to revert trade: %v", err)
+
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{models.ErrTradeReversalInsufficient.Error()},
+		})
+	default:
+		config.Logger.Errorf("Failed to revert trade: %v", err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"admin.trade_reversal.revert_error"},
+		})
+	}
+}
+
+func findTradeReversal(c *fiber.Ctx) (*models.TradeReversal, error) {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return nil, c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.trade_reversal.invalid_id"},
+		})
+	}
+
+	var reversal *models.TradeReversal
+	if result := config.DataBase.First(&reversal, id); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	return reversal, nil
+}
+
+// RequestTradeReversal asks for a trade to be reverted, another admin has to approve it.
+func RequestTradeReversal(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"admin.trade.invalid_id"},
+		})
+	}
+
+	params := new(queries.TradeReversalPayload)
+	if err := c.BodyParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	errs := new(helpers.Errors)
+	helpers.Vaildate(params, errs)
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	var trade *models.Trade
+	if result := config.DataBase.First(&trade, id); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	reversal, err := models.RequestTradeReversal(trade, CurrentUser, params.Reason)
+	if err != nil {
+		return tradeReversalError(c, err)
+	}
+
+	return c.Status(201).JSON(tradeReversalToEntity(reversal, false))
+}
+
+func GetTradeReversals(c *fiber.Ctx) error {
+	params := new(queries.TradeReversalFilters)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	if params.Limit == 0 {
+		params.Limit = 100
+	}
+
+	if params.Page == 0 {
+		params.Page = 1
+	}
+
+	tx := config.DataBase.Order("id desc")
+	if len(params.State) > 0 {
+		tx = tx.Where("state = ?", params.State)
+	}
+
+	var reversals []*models.TradeReversal
+	tx.Offset(params.Page*params.Limit - params.Limit).Limit(params.Limit).Find(&reversals)
+
+	reversal_entities := make([]*entities.TradeReversal, 0, len(reversals))
+	for _, reversal := range reversals {
+		reversal_entities = append(reversal_entities, tradeReversalToEntity(reversal, reversal.State == models.TradeReversalStateExecuted))
+	}
+
+	c.Response().Header.Add("page", strconv.FormatInt(int64(params.Page), 10))
+	c.Response().Header.Add("per-page", strconv.FormatInt(int64(params.Limit), 10))
+
+	return c.Status(200).JSON(reversal_entities)
+}
+
+// ApproveTradeReversal reverts the trade of a reversal requested by another admin.
+func ApproveTradeReversal(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	reversal, err := findTradeReversal(c)
+	if reversal == nil {
+		return err
+	}
+
+	if err := reversal.Approve(CurrentUser); err != nil {
+		return tradeReversalError(c, err)
+	}
+
+	return c.Status(200).JSON(tradeReversalToEntity(reversal, true))
+}
+
+func RejectTradeReversal(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	reversal, err := findTradeReversal(c)
+	if reversal == nil {
+		return err
+	}
+
+	if err := reversal.Reject(CurrentUser); err != nil {
+		return tradeReversalError(c, err)
+	}
+
+	return c.Status(200).JSON(tradeReversalToEntity(reversal, false))
+}
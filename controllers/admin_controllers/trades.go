@@ -1,7 +1,6 @@
 package admin_controllers
 
 import (
-	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -63,25 +62,18 @@ func GetTrades(c *fiber.Ctx) error {
 		tx = tx.Where("created_at < ?", time_to)
 	}
 
-	if params.Limit == 0 {
-		params.Limit = 100
-	}
-
-	if params.Page == 0 {
-		params.Page = 1
-	}
+	helpers.PageDefaults(&params.Page, &params.Limit)
 
 	tx = tx.Offset(params.Page*params.Limit - params.Limit).Limit(params.Limit)
 	tx.Find(&trades)
 
-	var trades_json []entities.TradeEntity
+	trades_json := make([]entities.TradeEntity, 0, len(trades))
 
 	for _, trade := range trades {
 		trades_json = append(trades_json, trade.ToJSON())
 	}
 
-	c.Response().Header.Add("page", strconv.FormatInt(int64(params.Page), 10))
-	c.Response().Header.Add("per-page", strconv.FormatInt(int64(len(trades)), 10))
+	helpers.SetPageHeaders(c, params.Page, params.Limit)
 
-	return c.Status(200).JSON(trades_json)
+	return helpers.RenderList(c, 200, trades_json)
 }
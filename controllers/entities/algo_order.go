@@ -0,0 +1,27 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/types"
+)
+
+type AlgoOrderEntity struct {
+	UUID             uuid.UUID           `json:"uuid"`
+	Market           string              `json:"market"`
+	Side             types.OrderSide     `json:"side"`
+	OrdType          types.OrderType     `json:"ord_type"`
+	Price            decimal.NullDecimal `json:"price"`
+	Quantity         decimal.Decimal     `json:"quantity"`
+	ExecutedQuantity decimal.Decimal     `json:"executed_quantity"`
+	MaxParticipation decimal.Decimal     `json:"max_participation"`
+	Slices           int64               `json:"slices"`
+	State            string              `json:"state"`
+	LastError        string              `json:"last_error,omitempty"`
+	StartAt          time.Time           `json:"start_at"`
+	EndAt            time.Time           `json:"end_at"`
+	CreatedAt        time.Time           `json:"created_at"`
+	UpdatedAt        time.Time           `json:"updated_at"`
+}
@@ -0,0 +1,23 @@
+package entities
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// BookTickerEntity are the best prices of a market, a price is null when its side is empty.
+type BookTickerEntity struct {
+	Market    string              `json:"market"`
+	BestBid   decimal.NullDecimal `json:"best_bid"`
+	BestAsk   decimal.NullDecimal `json:"best_ask"`
+	Sequence  int64               `json:"sequence"`
+	Timestamp int64               `json:"timestamp"`
+	// Signals are only rendered for extended requests
+	Signals *BookSignalsEntity `json:"signals,omitempty"`
+}
+
+// BookSignalsEntity are the signals of the top levels of a book, see matching.BookSignals for their formulas.
+// They're null unless both sides have orders.
+type BookSignalsEntity struct {
+	Imbalance  decimal.NullDecimal `json:"imbalance"`
+	Microprice decimal.NullDecimal `json:"microprice"`
+}
@@ -16,6 +16,8 @@ type CommissionEntity struct {
 	CurrencyID      string            `json:"currency_id"`
 	ParentID        int64             `json:"parent_id"`
 	ParentCreatedAt time.Time         `json:"parent_created_at"`
+	Level           int               `json:"level"`
+	State           string            `json:"state"`
 	CreatedAt       time.Time         `json:"created_at"`
 	UpdatedAt       time.Time         `json:"updated_at"`
 }
@@ -0,0 +1,56 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// CommissionExplanationEntity is how a commission was computed, the friend is masked.
+type CommissionExplanationEntity struct {
+	ID         int64  `json:"id"`
+	State      string `json:"state"`
+	Source     string `json:"source"`
+	Level      int    `json:"level"`
+	FriendUID  string `json:"friend_uid"`
+	CurrencyID string `json:"currency_id"`
+	// Fee is the fee the friend paid and FeeExact the one the reward is computed from, Rate the part rewarded.
+	// They're null on the commissions created before they were kept.
+	Fee      decimal.NullDecimal `json:"fee"`
+	FeeExact decimal.NullDecimal `json:"fee_exact"`
+	Rate     decimal.NullDecimal `json:"rate"`
+	// Reward is the reward before the split, EarnedAmount the part earned and EarnedExact it before rounding
+	Reward       decimal.Decimal             `json:"reward"`
+	EarnedAmount decimal.Decimal             `json:"earned_amount"`
+	EarnedExact  decimal.NullDecimal         `json:"earned_exact"`
+	Trade        *CommissionTradeEntity      `json:"trade"`
+	Split        *CommissionSplitEntity      `json:"split"`
+	Conversion   *CommissionConversionEntity `json:"conversion"`
+	CreatedAt    time.Time                   `json:"created_at"`
+}
+
+// CommissionTradeEntity is the trade a commission was earned on, without its members.
+type CommissionTradeEntity struct {
+	ID        int64           `json:"id"`
+	Market    string          `json:"market"`
+	Price     decimal.Decimal `json:"price"`
+	Amount    decimal.Decimal `json:"amount"`
+	Total     decimal.Decimal `json:"total"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// CommissionSplitEntity is the part of the reward the referral code gave back to the friend.
+type CommissionSplitEntity struct {
+	ReferralCode   string              `json:"referral_code"`
+	Label          string              `json:"label"`
+	DiscountAmount decimal.Decimal     `json:"discount_amount"`
+	DiscountExact  decimal.NullDecimal `json:"discount_exact"`
+}
+
+// CommissionConversionEntity is the value of the commission in the currency the release pays it in.
+type CommissionConversionEntity struct {
+	CurrencyID string          `json:"currency_id"`
+	Rate       decimal.Decimal `json:"rate"`
+	Exact      decimal.Decimal `json:"exact"`
+	Amount     decimal.Decimal `json:"amount"`
+}
@@ -0,0 +1,22 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type ConvertQuoteEntity struct {
+	UUID       uuid.UUID       `json:"uuid"`
+	From       string          `json:"from"`
+	To         string          `json:"to"`
+	AmountIn   decimal.Decimal `json:"amount_in"`
+	AmountOut  decimal.Decimal `json:"amount_out"`
+	Rate       decimal.Decimal `json:"rate"`
+	Route      []string        `json:"route"`
+	State      string          `json:"state"`
+	ExpiresAt  time.Time       `json:"expires_at"`
+	AcceptedAt *time.Time      `json:"accepted_at,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
@@ -0,0 +1,14 @@
+package entities
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+type DepthEntity struct {
+	Asks      [][]decimal.Decimal `json:"asks"`
+	Bids      [][]decimal.Decimal `json:"bids"`
+	Sequence  int64               `json:"sequence"`
+	Timestamp int64               `json:"timestamp" since:"3"`
+	// Truncated is set when the market data policy of the user served fewer levels than were asked for
+	Truncated bool `json:"truncated"`
+}
@@ -0,0 +1,16 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type FeeKickbackStatsEntity struct {
+	// Eligible is true while the member gets part of its fees back
+	Eligible bool            `json:"eligible"`
+	Rate     decimal.Decimal `json:"rate"`
+	// EndsAt is when the kickbacks of the member end, zero for the members who weren't referred
+	EndsAt    time.Time                   `json:"ends_at"`
+	Kickbacks []*ReferralCodeAmountEntity `json:"kickbacks"`
+}
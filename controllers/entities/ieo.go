@@ -19,7 +19,7 @@ type IEO struct {
 	StartTime           int64           `json:"start_time"`
 	EndTime             int64           `json:"end_time"`
 	Ended               bool            `json:"ended"`
-	BoughtQuantity      decimal.Decimal `json:"bought_quantity,omitempty"`
+	BoughtQuantity      decimal.Decimal `json:"bought_quantity"`
 	BannerUrl           string          `json:"banner_url"`
 	Data                string          `json:"data"`
 	Distributors        int64           `json:"distributors"`
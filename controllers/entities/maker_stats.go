@@ -0,0 +1,36 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// MakerStatsPercentilesEntity ranks the member among the makers of the market, each is the percentage of the
+// makers doing no better than the member.
+type MakerStatsPercentilesEntity struct {
+	VolumeShare   int64 `json:"volume_share"`
+	AverageSpread int64 `json:"average_spread"`
+	QuoteUptime   int64 `json:"quote_uptime"`
+	FillRatio     int64 `json:"fill_ratio"`
+	CancelRatio   int64 `json:"cancel_ratio"`
+}
+
+type MakerStatsEntity struct {
+	Market string    `json:"market"`
+	Period string    `json:"period"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	// MakerVolume is the quote volume of the trades the member made on the market, VolumeShare its part of the market volume
+	MakerVolume   decimal.Decimal `json:"maker_volume"`
+	VolumeShare   decimal.Decimal `json:"volume_share"`
+	AverageSpread decimal.Decimal `json:"average_spread"`
+	QuoteUptime   decimal.Decimal `json:"quote_uptime"`
+	OrdersPlaced  int64           `json:"orders_placed"`
+	MakerFills    int64           `json:"maker_fills"`
+	FillRatio     decimal.Decimal `json:"fill_ratio"`
+	CancelRatio   decimal.Decimal `json:"cancel_ratio"`
+	// Makers is the number of enrolled makers active on the market over the period
+	Makers      int64                       `json:"makers"`
+	Percentiles MakerStatsPercentilesEntity `json:"percentiles"`
+}
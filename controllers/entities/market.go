@@ -0,0 +1,20 @@
+package entities
+
+import "github.com/shopspring/decimal"
+
+// MarketEntity is the trading rules of a market.
+type MarketEntity struct {
+	ID              string          `json:"id"`
+	BaseUnit        string          `json:"base_unit"`
+	QuoteUnit       string          `json:"quote_unit"`
+	State           string          `json:"state"`
+	AmountPrecision int             `json:"amount_precision"`
+	PricePrecision  int             `json:"price_precision"`
+	MinPrice        decimal.Decimal `json:"min_price"`
+	MaxPrice        decimal.Decimal `json:"max_price"`
+	MinAmount       decimal.Decimal `json:"min_amount"`
+	MaxAmount       decimal.Decimal `json:"max_amount"`
+	MaxQuoteAmount  decimal.Decimal `json:"max_quote_amount"`
+	LotSize         decimal.Decimal `json:"lot_size"`
+	MinNotional     decimal.Decimal `json:"min_notional"`
+}
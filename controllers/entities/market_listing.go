@@ -0,0 +1,13 @@
+package entities
+
+import "time"
+
+// MarketListingEntity is the schedule of the listing of a market, for the countdown of the frontend.
+type MarketListingEntity struct {
+	Market   string     `json:"market"`
+	Phase    string     `json:"phase"`
+	WarmupAt *time.Time `json:"warmup_at"`
+	OpensAt  *time.Time `json:"opens_at"`
+	// ServerTime is the time of the response, clients count down from it rather than from their own clock
+	ServerTime time.Time `json:"server_time"`
+}
@@ -0,0 +1,129 @@
+package entities
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	adminEntities "github.com/zsmartex/finex/controllers/admin_controllers/entities"
+)
+
+// renderedEntities are the entities the API renders, typed clients expect every decimal and list field of them.
+var renderedEntities = []interface{}{
+	AlgoOrderEntity{},
+	BookTickerEntity{},
+	CommissionEntity{},
+	CommissionExplanationEntity{},
+	ConvertQuoteEntity{},
+	DepthEntity{},
+	FeeKickbackStatsEntity{},
+	IEO{},
+	MakerStatsEntity{},
+	MarketEntity{},
+	MarketListingEntity{},
+	OrderEntity{},
+	PrivateBalanceMessage{},
+	PrivateOrderMessage{},
+	PrivateStreamReply{},
+	PrivateTradeMessage{},
+	PublicTradesEntity{},
+	RateLimitEntity{},
+	ReferralCodeEntity{},
+	ReferralCodeStatsEntity{},
+	ReleaseCommissionEntity{},
+	ScheduledOrderEntity{},
+	SecurityEventEntity{},
+	StreamAuthEntity{},
+	SubAccountEntity{},
+	TickerEntity{},
+	MemberBalancesEntity{},
+	AggregatedBalancesEntity{},
+	TransferEntity{},
+	SummaryEntity{},
+	TradeEntity{},
+	adminEntities.BackgroundMigration{},
+	adminEntities.CandleDiscrepancy{},
+	adminEntities.IEO{},
+	adminEntities.MarketConfigVersion{},
+	adminEntities.MarketDataPolicy{},
+	adminEntities.MarketDelisting{},
+	adminEntities.MarketGroup{},
+	adminEntities.MarketPrecisionChange{},
+	adminEntities.MarketSettings{},
+	adminEntities.MemberExport{},
+	adminEntities.RateLimit{},
+	adminEntities.ReferralCode{},
+	adminEntities.ReportJob{},
+	adminEntities.RevenueReport{},
+	adminEntities.RoundingDrift{},
+	adminEntities.SecurityEvent{},
+	adminEntities.TradeEntity{},
+	adminEntities.TradeReversal{},
+}
+
+var (
+	decimalType     = reflect.TypeOf(decimal.Decimal{})
+	nullDecimalType = reflect.TypeOf(decimal.NullDecimal{})
+)
+
+// omittedFields returns the decimal and list fields of t, and of the entities it nests, which are tagged omitempty.
+func omittedFields(t reflect.Type, seen map[reflect.Type]bool) []string {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct || t == decimalType || t == nullDecimalType || seen[t] {
+		return nil
+	}
+	seen[t] = true
+
+	omitted := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		_, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		field_type := field.Type
+		for field_type.Kind() == reflect.Ptr {
+			field_type = field_type.Elem()
+		}
+
+		switch {
+		case field_type == decimalType, field_type == nullDecimalType, field_type.Kind() == reflect.Slice, field_type.Kind() == reflect.Array:
+			if strings.Contains(options, "omitempty") {
+				omitted = append(omitted, t.Name()+"."+field.Name)
+			}
+		}
+
+		omitted = append(omitted, omittedFields(field.Type, seen)...)
+	}
+
+	return omitted
+}
+
+func TestEntitiesDontOmitDecimalsOrLists(t *testing.T) {
+	seen := make(map[reflect.Type]bool)
+	for _, entity := range renderedEntities {
+		for _, field := range omittedFields(reflect.TypeOf(entity), seen) {
+			t.Errorf("%s is omitted when it's zero or empty, render it as \"0\" or []", field)
+		}
+	}
+}
+
+func TestEmptyListsAreRendered(t *testing.T) {
+	stats := ReferralCodeStatsEntity{Code: "abc"}
+
+	got, err := Serialize(&stats, V2).MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"code":"abc","signups":0,"trading_friends":0,"earnings":[],"discounts":[]}`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -16,11 +16,28 @@ type OrderEntity struct {
 	Price           decimal.NullDecimal `json:"price"`
 	StopPrice       decimal.NullDecimal `json:"stop_price"`
 	AvgPrice        decimal.Decimal     `json:"avg_price"`
+	FundsReceived   decimal.Decimal     `json:"funds_received" since:"3"`
 	State           string              `json:"state"`
 	OriginVolume    decimal.Decimal     `json:"origin_volume"`
 	RemainingVolume decimal.Decimal     `json:"remaining_volume"`
 	ExecutedVolume  decimal.Decimal     `json:"executed_volume"`
 	TradesCount     int64               `json:"trades_count"`
-	CreatedAt       time.Time           `json:"created_at"`
-	UpdatedAt       time.Time           `json:"updated_at"`
+	MakerFee        decimal.Decimal     `json:"maker_fee" since:"3"`
+	TakerFee        decimal.Decimal     `json:"taker_fee" since:"3"`
+	// AlgoOrderUUID is the algo order which placed the order
+	AlgoOrderUUID uuid.NullUUID `json:"algo_order_uuid"`
+	// ClientID is the id the member placed the order with
+	ClientID            uuid.NullUUID             `json:"client_id" since:"3"`
+	PostOnly            bool                      `json:"post_only" since:"3"`
+	TimeInForce         types.TimeInForce         `json:"time_in_force" since:"3"`
+	TrailingOffset      decimal.NullDecimal       `json:"trailing_offset" since:"3"`
+	SelfTradePrevention types.SelfTradePrevention `json:"self_trade_prevention" since:"3"`
+	DisplayQuantity     decimal.NullDecimal       `json:"display_quantity" since:"3"`
+	ExpiresAt           *time.Time                `json:"expires_at" since:"3"`
+	MaxSlippage         decimal.NullDecimal       `json:"max_slippage" since:"3"`
+	QuoteQuantity       decimal.NullDecimal       `json:"quote_quantity" since:"3"`
+	TriggerBy           types.TriggerBy           `json:"trigger_by" since:"3"`
+	DoneAt              *time.Time                `json:"done_at" since:"3"`
+	CreatedAt           time.Time                 `json:"created_at"`
+	UpdatedAt           time.Time                 `json:"updated_at"`
 }
@@ -0,0 +1,21 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+)
+
+type OrderBatchEntity struct {
+	ID           string                  `json:"id"`
+	AllOrNothing bool                    `json:"all_or_nothing"`
+	Entries      []OrderBatchEntryEntity `json:"entries"`
+}
+
+// OrderBatchEntryEntity is an entry of a batch, in the order it was given. Order is the order an accepted submit
+// placed, Errors why the entry was left out of the batch.
+type OrderBatchEntryEntity struct {
+	Index  int          `json:"index"`
+	Action string       `json:"action"`
+	UUID   uuid.UUID    `json:"uuid,omitempty"`
+	Order  *OrderEntity `json:"order,omitempty"`
+	Errors []string     `json:"errors,omitempty"`
+}
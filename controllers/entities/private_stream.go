@@ -0,0 +1,64 @@
+package entities
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// The private streams of a member, a connection to /api/v2/ws/private subscribes to them.
+const (
+	PrivateStreamOrder   = "order"
+	PrivateStreamTrade   = "trade"
+	PrivateStreamBalance = "balance"
+)
+
+var PrivateStreams = []string{PrivateStreamOrder, PrivateStreamTrade, PrivateStreamBalance}
+
+type PrivateOrderAction string
+
+var (
+	PrivateOrderCreated   PrivateOrderAction = "created"
+	PrivateOrderUpdated   PrivateOrderAction = "updated"
+	PrivateOrderCancelled PrivateOrderAction = "cancelled"
+)
+
+// PrivateOrderMessage is sent on the order stream when an order of the member is created in the book, updated by a
+// trade or an amend, and when it's cancelled or rejected.
+type PrivateOrderMessage struct {
+	Event  string             `json:"event"`
+	Action PrivateOrderAction `json:"action"`
+	Order  OrderEntity        `json:"order"`
+}
+
+// PrivateTradeMessage is sent on the trade stream to each of the members of a trade, from their side.
+type PrivateTradeMessage struct {
+	Event string      `json:"event"`
+	Trade TradeEntity `json:"trade"`
+}
+
+type PrivateBalance struct {
+	Currency string          `json:"currency"`
+	Balance  decimal.Decimal `json:"balance"`
+	Locked   decimal.Decimal `json:"locked"`
+}
+
+// PrivateBalanceMessage is sent on the balance stream with the balance of an account after it changed.
+type PrivateBalanceMessage struct {
+	Event   string         `json:"event"`
+	Balance PrivateBalance `json:"balance"`
+}
+
+// PrivateStreamRequest is a message of a client: subscribe and unsubscribe take Streams, auth takes the fresh Token
+// of the member once the session is sent auth_expiring.
+type PrivateStreamRequest struct {
+	Event   string   `json:"event"`
+	Streams []string `json:"streams"`
+	Token   string   `json:"token"`
+}
+
+// PrivateStreamReply answers a request of a client with the streams the connection is subscribed to, and the error
+// of the request when it failed.
+type PrivateStreamReply struct {
+	Event   string   `json:"event"`
+	Streams []string `json:"streams"`
+	Error   string   `json:"error,omitempty"`
+}
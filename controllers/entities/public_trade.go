@@ -0,0 +1,23 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/types"
+)
+
+type PublicTradeEntity struct {
+	ID        int64           `json:"id"`
+	Price     decimal.Decimal `json:"price"`
+	Amount    decimal.Decimal `json:"amount"`
+	Total     decimal.Decimal `json:"total"`
+	TakerType types.TakerType `json:"taker_type"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type PublicTradesEntity struct {
+	Trades []*PublicTradeEntity `json:"trades"`
+	// Truncated is set when the market data policy of the user served fewer trades than were asked for
+	Truncated bool `json:"truncated"`
+}
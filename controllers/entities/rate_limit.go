@@ -0,0 +1,15 @@
+package entities
+
+import "time"
+
+type RateLimitEntity struct {
+	Bucket        string `json:"bucket"`
+	Limit         int    `json:"limit"`
+	WindowSeconds int64  `json:"window_seconds"`
+	Used          int    `json:"used"`
+	Remaining     int    `json:"remaining"`
+	// ResetAt is when the bucket is full again
+	ResetAt time.Time `json:"reset_at"`
+	// Override is true when the limit was set for the member by an admin
+	Override bool `json:"override"`
+}
@@ -0,0 +1,31 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type ReferralCodeEntity struct {
+	Code          string          `json:"code"`
+	Label         string          `json:"label"`
+	ReferrerShare decimal.Decimal `json:"referrer_share"`
+	FriendShare   decimal.Decimal `json:"friend_share"`
+	Default       bool            `json:"default"`
+	State         string          `json:"state"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+type ReferralCodeAmountEntity struct {
+	Currency string          `json:"currency"`
+	Amount   decimal.Decimal `json:"amount"`
+}
+
+type ReferralCodeStatsEntity struct {
+	Code           string                      `json:"code"`
+	Signups        int64                       `json:"signups"`
+	TradingFriends int64                       `json:"trading_friends"`
+	Earnings       []*ReferralCodeAmountEntity `json:"earnings"`
+	Discounts      []*ReferralCodeAmountEntity `json:"discounts"`
+}
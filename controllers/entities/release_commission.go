@@ -11,6 +11,7 @@ type ReleaseCommissionEntity struct {
 	ID          int64             `json:"id"`
 	AccountType types.AccountType `json:"account_type"`
 	MemberID    int64             `json:"member_id"`
+	Kind        string            `json:"kind"`
 	EarnedBTC   decimal.Decimal   `json:"earned_btc"`
 	FriendTrade int64             `json:"friend_trade"`
 	Friend      int64             `json:"friend"`
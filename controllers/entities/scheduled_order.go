@@ -0,0 +1,30 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/types"
+)
+
+type ScheduledOrderEntity struct {
+	UUID      uuid.UUID           `json:"uuid"`
+	Market    string              `json:"market"`
+	Side      types.OrderSide     `json:"side"`
+	OrdType   types.OrderType     `json:"ord_type"`
+	Price     decimal.NullDecimal `json:"price"`
+	StopPrice decimal.NullDecimal `json:"stop_price"`
+	Quantity  decimal.Decimal     `json:"quantity"`
+	PostOnly  bool                `json:"post_only"`
+	ExecuteAt time.Time           `json:"execute_at"`
+	State     string              `json:"state"`
+	// Locked are the funds locked until the order is placed, zero when they're locked when it's placed
+	Locked decimal.Decimal `json:"locked"`
+	// OrderUUID is the order placed at the execution
+	OrderUUID  uuid.NullUUID `json:"order_uuid"`
+	Reason     string        `json:"reason,omitempty"`
+	ExecutedAt *time.Time    `json:"executed_at"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+}
@@ -0,0 +1,15 @@
+package entities
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type SecurityEventEntity struct {
+	ID   int64  `json:"id"`
+	Kind string `json:"kind"`
+	// Admin is true when an admin took the action on the account
+	Admin     bool            `json:"admin"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
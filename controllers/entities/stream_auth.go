@@ -0,0 +1,9 @@
+package entities
+
+// StreamAuthEntity is the authentication of a private websocket session, the gateway sends auth_expiring at
+// NoticeAt and stops the private streams at ExpiresAt unless the session refreshed its token.
+type StreamAuthEntity struct {
+	UID       string `json:"uid"`
+	ExpiresAt int64  `json:"expires_at"`
+	NoticeAt  int64  `json:"notice_at"`
+}
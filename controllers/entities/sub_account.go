@@ -0,0 +1,41 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type SubAccountEntity struct {
+	UID       string    `json:"uid"`
+	Label     string    `json:"label"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type BalanceEntity struct {
+	Currency string          `json:"currency"`
+	Balance  decimal.Decimal `json:"balance"`
+	Locked   decimal.Decimal `json:"locked"`
+	// Available is the part of the balance which can be traded or withdrawn
+	Available decimal.Decimal `json:"available"`
+}
+
+type MemberBalancesEntity struct {
+	UID      string           `json:"uid"`
+	Balances []*BalanceEntity `json:"balances"`
+}
+
+type AggregatedBalancesEntity struct {
+	Total   []*BalanceEntity        `json:"total"`
+	Members []*MemberBalancesEntity `json:"members"`
+}
+
+type TransferEntity struct {
+	ID        int64           `json:"id"`
+	From      string          `json:"from"`
+	To        string          `json:"to"`
+	Currency  string          `json:"currency"`
+	Amount    decimal.Decimal `json:"amount"`
+	CreatedAt time.Time       `json:"created_at"`
+}
@@ -0,0 +1,19 @@
+package entities
+
+import "github.com/shopspring/decimal"
+
+type MarketSummaryEntity struct {
+	Market      string              `json:"market"`
+	BaseVolume  decimal.Decimal     `json:"base_volume"`
+	QuoteVolume decimal.Decimal     `json:"quote_volume"`
+	USDTVolume  decimal.NullDecimal `json:"usdt_volume"`
+	LastPrice   decimal.Decimal     `json:"last_price"`
+	// Sparkline is the base volume of each of the last 24 hours, the oldest first
+	Sparkline []decimal.Decimal `json:"sparkline"`
+}
+
+type SummaryEntity struct {
+	TotalUSDTVolume decimal.Decimal        `json:"total_usdt_volume"`
+	Markets         []*MarketSummaryEntity `json:"markets"`
+	UpdatedAt       int64                  `json:"updated_at"`
+}
@@ -0,0 +1,17 @@
+package entities
+
+import "github.com/shopspring/decimal"
+
+// TickerEntity are the statistics of the trades of a market over the last 24 hours.
+type TickerEntity struct {
+	Market      string          `json:"market"`
+	At          int64           `json:"at"`
+	Open        decimal.Decimal `json:"open"`
+	High        decimal.Decimal `json:"high"`
+	Low         decimal.Decimal `json:"low"`
+	Last        decimal.Decimal `json:"last"`
+	Volume      decimal.Decimal `json:"volume"`
+	QuoteVolume decimal.Decimal `json:"quote_volume"`
+	// PriceChangePercent is the change from open to last in percent
+	PriceChangePercent decimal.Decimal `json:"price_change_percent"`
+}
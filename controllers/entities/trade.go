@@ -3,6 +3,7 @@ package entities
 import (
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 	"github.com/zsmartex/finex/types"
 )
@@ -16,8 +17,11 @@ type TradeEntity struct {
 	FeeCurrency string          `json:"fee_currency"`
 	Fee         decimal.Decimal `json:"fee"`
 	FeeAmount   decimal.Decimal `json:"fee_amount"`
-	TakerType   types.TakerType `json:"taker_type"`
+	TakerType   types.TakerType `json:"taker_type" until:"2"`
+	TakerSide   types.TakerType `json:"taker_side" since:"3"`
 	Side        types.TakerType `json:"side"`
 	OrderID     int64           `json:"order_id"`
-	CreatedAt   time.Time       `json:"created_at"`
+	// ClientID is the id the member placed the order of the trade with
+	ClientID  uuid.NullUUID `json:"client_id" since:"3"`
+	CreatedAt time.Time     `json:"created_at"`
 }
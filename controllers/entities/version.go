@@ -0,0 +1,152 @@
+package entities
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Version is the major version of the REST API an entity is rendered for.
+type Version int
+
+const (
+	V2 Version = 2
+	V3 Version = 3
+
+	LatestVersion = V3
+)
+
+func (v Version) String() string {
+	return "v" + strconv.Itoa(int(v))
+}
+
+// Versioned renders an entity (or a slice of entities) in the shape of an API version.
+// Entity fields tagged with `since:"N"` are only rendered from version N on,
+// fields tagged with `until:"N"` are only rendered up to version N.
+// Nil slices are rendered as [], an empty list has the same type as any other.
+type Versioned struct {
+	Entity  interface{}
+	Version Version
+}
+
+func Serialize(entity interface{}, version Version) Versioned {
+	return Versioned{Entity: entity, Version: version}
+}
+
+func (s Versioned) MarshalJSON() ([]byte, error) {
+	return marshalVersioned(reflect.ValueOf(s.Entity), s.Version)
+}
+
+func marshalVersioned(value reflect.Value, version Version) ([]byte, error) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return []byte("null"), nil
+		}
+
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			return []byte("[]"), nil
+		}
+
+		var buf bytes.Buffer
+		buf.WriteByte('[')
+		for i := 0; i < value.Len(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			b, err := marshalVersioned(value.Index(i), version)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(b)
+		}
+		buf.WriteByte(']')
+
+		return buf.Bytes(), nil
+	case reflect.Struct:
+		if _, ok := value.Interface().(json.Marshaler); ok {
+			return json.Marshal(value.Interface())
+		}
+
+		return marshalVersionedStruct(value, version)
+	default:
+		return json.Marshal(value.Interface())
+	}
+}
+
+func marshalVersionedStruct(value reflect.Value, version Version) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	value_type := value.Type()
+	first := true
+	for i := 0; i < value_type.NumField(); i++ {
+		field := value_type.Field(i)
+		if !field.IsExported() || !fieldInVersion(field, version) {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if len(name) == 0 {
+			name = field.Name
+		}
+
+		if strings.Contains(options, "omitempty") && value.Field(i).IsZero() {
+			continue
+		}
+
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := marshalField(value.Field(i))
+		if err != nil {
+			return nil, err
+		}
+
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(b)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+func marshalField(value reflect.Value) ([]byte, error) {
+	if value.Kind() == reflect.Slice && value.IsNil() {
+		return []byte("[]"), nil
+	}
+
+	return json.Marshal(value.Interface())
+}
+
+func fieldInVersion(field reflect.StructField, version Version) bool {
+	if since, err := strconv.Atoi(field.Tag.Get("since")); err == nil && version < Version(since) {
+		return false
+	}
+
+	if until, err := strconv.Atoi(field.Tag.Get("until")); err == nil && version > Version(until) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,83 @@
+package entities
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestSerializeVersions(t *testing.T) {
+	trade := TradeEntity{
+		ID:        1,
+		Market:    "btcusdt",
+		Price:     decimal.RequireFromString("10.5"),
+		TakerType: "buy",
+		TakerSide: "buy",
+		CreatedAt: time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		version Version
+		present string
+		absent  string
+	}{
+		{V2, "taker_type", "taker_side"},
+		{V3, "taker_side", "taker_type"},
+	}
+
+	for _, tt := range tests {
+		b, err := json.Marshal(Serialize([]TradeEntity{trade}, tt.version))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var trades []map[string]interface{}
+		if err := json.Unmarshal(b, &trades); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := trades[0][tt.present]; !ok {
+			t.Errorf("%s: expected %s in %s", tt.version, tt.present, b)
+		}
+
+		if _, ok := trades[0][tt.absent]; ok {
+			t.Errorf("%s: unexpected %s in %s", tt.version, tt.absent, b)
+		}
+
+		if trades[0]["price"] != "10.5" || trades[0]["created_at"] != "2022-05-01T00:00:00Z" {
+			t.Errorf("%s: fields aren't rendered by their own marshaler: %s", tt.version, b)
+		}
+	}
+}
+
+func TestSerializeV2MatchesPlainEntity(t *testing.T) {
+	depth := DepthEntity{
+		Asks:      [][]decimal.Decimal{{decimal.NewFromInt(1), decimal.NewFromInt(2)}},
+		Bids:      [][]decimal.Decimal{},
+		Sequence:  7,
+		Timestamp: 1651363200000,
+	}
+
+	got, err := json.Marshal(Serialize(depth, V2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"asks":[["1","2"]],"bids":[],"sequence":7,"truncated":false}`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	got, _ = json.Marshal(Serialize(&depth, V3))
+	want = `{"asks":[["1","2"]],"bids":[],"sequence":7,"timestamp":1651363200000,"truncated":false}`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	var nothing []OrderEntity
+	if got, _ := json.Marshal(Serialize(nothing, V3)); string(got) != "[]" {
+		t.Errorf("got %s for a nil slice", got)
+	}
+}
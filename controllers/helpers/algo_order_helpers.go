@@ -0,0 +1,95 @@
+package helpers
+
+import (
+	"time"
+
+	"github.com/gookit/validate"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/types"
+)
+
+// CreateAlgoOrderParams describes a TWAP order, Duration is in seconds.
+type CreateAlgoOrderParams struct {
+	Market           string              `json:"market" form:"market" validate:"required"`
+	Side             types.OrderSide     `json:"side" form:"side" validate:"required|VaildateSide"`
+	OrdType          types.OrderType     `json:"ord_type" form:"ord_type" validate:"VaildateOrdType"`
+	Price            decimal.NullDecimal `json:"price" form:"price" validate:"VaildatePrice"`
+	Quantity         decimal.Decimal     `json:"quantity" form:"quantity" validate:"VaildateQuantity"`
+	Duration         int64               `json:"duration" form:"duration" validate:"required"`
+	MaxParticipation decimal.Decimal     `json:"max_participation" form:"max_participation"`
+}
+
+func (p CreateAlgoOrderParams) Messages() map[string]string {
+	invalid_message := "algo_order.invalid_{field}"
+
+	return validate.MS{
+		"required":         invalid_message,
+		"VaildateSide":     invalid_message,
+		"VaildateOrdType":  invalid_message,
+		"VaildatePrice":    "algo_order.non_positive_price",
+		"VaildateQuantity": "algo_order.non_positive_quantity",
+	}
+}
+
+func (p CreateAlgoOrderParams) VaildateSide(val types.OrderSide) bool {
+	return p.Side == types.SideBuy || p.Side == types.SideSell
+}
+
+func (p CreateAlgoOrderParams) VaildateOrdType(OrdType types.OrderType) bool {
+	if OrdType == types.TypeMarket {
+		return !p.Price.Valid
+	}
+
+	return p.Price.Valid
+}
+
+func (p CreateAlgoOrderParams) VaildatePrice(Price decimal.NullDecimal) bool {
+	if Price.Valid {
+		return Price.Decimal.IsPositive()
+	}
+
+	return true
+}
+
+func (p CreateAlgoOrderParams) VaildateQuantity(Quantity decimal.Decimal) bool {
+	return Quantity.IsPositive()
+}
+
+// CreateAlgoOrder records a TWAP order of the member, the scheduler places its slices.
+func (p CreateAlgoOrderParams) CreateAlgoOrder(member *models.Member, err_src *Errors) *models.AlgoOrder {
+	if len(p.OrdType) == 0 {
+		p.OrdType = types.TypeLimit
+	}
+
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ? AND state = ?", p.Market, types.MarketStateEndabled); result.Error != nil || !models.MarketVisibility.Visible(models.MarketGroupOf(member), market.Symbol) {
+		err_src.Errors = append(err_src.Errors, "algo_order.invalid_market")
+
+		return nil
+	}
+
+	if p.Quantity.LessThan(market.MinAmount) {
+		err_src.Errors = append(err_src.Errors, "algo_order.quantity_too_small")
+
+		return nil
+	}
+
+	algo_order, err := models.NewTWAPOrder(member, market, p.Side, p.OrdType, p.Price, p.Quantity, time.Duration(p.Duration)*time.Second, p.MaxParticipation, time.Now())
+	if err != nil {
+		err_src.Errors = append(err_src.Errors, err.Error())
+
+		return nil
+	}
+
+	if result := config.DataBase.Create(&algo_order); result.Error != nil {
+		config.Logger.Errorf("Failed to create algo order: %v", result.Error)
+		err_src.Errors = append(err_src.Errors, "algo_order.create_error")
+
+		return nil
+	}
+
+	return algo_order
+}
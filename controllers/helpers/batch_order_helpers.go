@@ -0,0 +1,203 @@
+package helpers
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/zsmartex/pkg"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/models"
+)
+
+// MaxBatchEntries is the most orders a batch submits and cancels.
+const MaxBatchEntries = 50
+
+// BatchOrderEntryParams is an entry of a batch, either the order it places or the uuid of the open order it cancels.
+type BatchOrderEntryParams struct {
+	Order  *CreateOrderParams `json:"order" form:"order"`
+	Cancel string             `json:"cancel" form:"cancel"`
+}
+
+// BatchOrderParams are the orders a batch places and cancels, in the order the engine processes them. The entries
+// of a batch are of one market.
+type BatchOrderParams struct {
+	Entries []*BatchOrderEntryParams `json:"entries" form:"entries"`
+	// AllOrNothing places none of the orders when one of them is refused or its funds can't be locked
+	AllOrNothing bool `json:"all_or_nothing" form:"all_or_nothing"`
+}
+
+// PlaceBatch checks the entries of the batch, reserves the funds of its orders one after the other and hands it to
+// the order processor. An entry refused is left out of the batch with its errors, unless the batch is all or
+// nothing, then nothing is placed. err_src gets the errors refusing the whole batch.
+func (p BatchOrderParams) PlaceBatch(member *models.Member, err_src *Errors) *entities.OrderBatchEntity {
+	if !events.ProducesOrderBatches() {
+		err_src.Errors = append(err_src.Errors, "market.order.batch_unavailable")
+
+		return nil
+	}
+
+	if len(p.Entries) == 0 || len(p.Entries) > MaxBatchEntries {
+		err_src.Errors = append(err_src.Errors, "market.order.invalid_batch_size")
+
+		return nil
+	}
+
+	batch := models.NewBatch(p.AllOrNothing)
+	result := &entities.OrderBatchEntity{ID: batch.ID, AllOrNothing: p.AllOrNothing, Entries: make([]entities.OrderBatchEntryEntity, len(p.Entries))}
+	orders := make([]*models.Order, len(p.Entries))
+	cancels := make([]*models.Order, len(p.Entries))
+	market := ""
+	refused := false
+
+	for i, entry := range p.Entries {
+		entry_errors := new(Errors)
+		result.Entries[i] = entities.OrderBatchEntryEntity{Index: i}
+
+		switch {
+		case entry == nil || entry.Order != nil && len(entry.Cancel) > 0 || entry.Order == nil && len(entry.Cancel) == 0:
+			entry_errors.Errors = append(entry_errors.Errors, "market.order.invalid_batch_entry")
+		case entry.Order != nil:
+			result.Entries[i].Action = string(pkg.ActionSubmit)
+
+			Vaildate(entry.Order, entry_errors)
+			if entry_errors.Size() == 0 {
+				orders[i] = entry.Order.BuildOrder(member, entry_errors)
+			}
+
+			if entry_errors.Size() == 0 && len(market) > 0 && orders[i].MarketID != market {
+				entry_errors.Errors = append(entry_errors.Errors, "market.order.batch_markets_differ")
+			}
+
+			if entry_errors.Size() == 0 {
+				market = orders[i].MarketID
+			}
+		default:
+			result.Entries[i].Action = string(pkg.ActionCancel)
+
+			order_uuid, err := uuid.Parse(entry.Cancel)
+			if err != nil {
+				entry_errors.Errors = append(entry_errors.Errors, "market.order.invaild_uuid")
+
+				break
+			}
+
+			result.Entries[i].UUID = order_uuid
+
+			var order *models.Order
+			if query := config.DataBase.Where("uuid = ? AND member_id = ?", order_uuid, member.ID).First(&order); errors.Is(query.Error, gorm.ErrRecordNotFound) {
+				entry_errors.Errors = append(entry_errors.Errors, "record.not_found")
+
+				break
+			}
+
+			if order.State != models.StateWait {
+				entry_errors.Errors = append(entry_errors.Errors, "market.order.not_open")
+			} else if len(market) > 0 && order.MarketID != market {
+				entry_errors.Errors = append(entry_errors.Errors, "market.order.batch_markets_differ")
+			} else {
+				market = order.MarketID
+				cancels[i] = order
+			}
+		}
+
+		if entry_errors.Size() > 0 {
+			orders[i], cancels[i] = nil, nil
+			result.Entries[i].Errors = entry_errors.Errors
+			refused = true
+		}
+	}
+
+	if p.AllOrNothing && refused {
+		err_src.Errors = append(err_src.Errors, "market.order.batch_refused")
+
+		return result
+	}
+
+	// the funds of an order are reserved with the orders before it pending, each order is checked against what
+	// the others left
+	for i, order := range orders {
+		if order == nil {
+			continue
+		}
+
+		if order.ClientID.Valid {
+			// an order of a batch can't take the client id of an open order, not even its own
+			existing, err := models.CreateClientOrder(order)
+			switch {
+			case err != nil:
+				result.Entries[i].Errors = []string{"market.order.invalid_volume_or_price"}
+			case existing != nil:
+				result.Entries[i].Errors = []string{models.ErrClientIDTaken.Error()}
+			}
+
+			if err != nil || existing != nil {
+				orders[i], refused = nil, true
+
+				continue
+			}
+		} else if err := config.DataBase.Create(&order).Error; err != nil {
+			result.Entries[i].Errors = []string{"market.order.invalid_volume_or_price"}
+			orders[i], refused = nil, true
+
+			continue
+		}
+
+		if err := order.ReserveFunds(); err != nil {
+			order.State = models.StateReject
+			config.DataBase.Save(&order)
+
+			result.Entries[i].Errors = []string{err.Error()}
+			orders[i], refused = nil, true
+		}
+	}
+
+	if p.AllOrNothing && refused {
+		for _, order := range orders {
+			if order != nil {
+				order.State = models.StateReject
+				config.DataBase.Save(&order)
+			}
+		}
+
+		err_src.Errors = append(err_src.Errors, "market.order.batch_refused")
+
+		return result
+	}
+
+	for i := range p.Entries {
+		switch {
+		case orders[i] != nil:
+			order_json := orders[i].ToJSON()
+			result.Entries[i].UUID = orders[i].UUID
+			result.Entries[i].Order = &order_json
+			batch.Entries = append(batch.Entries, &events.BatchEntry{Action: pkg.ActionSubmit, ID: orders[i].ID, UUID: orders[i].UUID})
+		case cancels[i] != nil:
+			batch.Entries = append(batch.Entries, &events.BatchEntry{Action: pkg.ActionCancel, ID: cancels[i].ID, UUID: cancels[i].UUID})
+		}
+	}
+
+	if len(batch.Entries) == 0 {
+		return result
+	}
+
+	if err := models.SubmitBatch(market, batch); err != nil {
+		config.Logger.Errorf("Failed to submit batch %s: %v", batch.ID, err)
+
+		for _, order := range orders {
+			if order != nil {
+				order.State = models.StateReject
+				config.DataBase.Save(&order)
+			}
+		}
+
+		err_src.Errors = append(err_src.Errors, "server.internal_error")
+
+		return nil
+	}
+
+	return result
+}
@@ -0,0 +1,62 @@
+package helpers
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gookit/validate"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/models"
+)
+
+type ConvertQuoteParams struct {
+	From   string          `json:"from" form:"from" validate:"required"`
+	To     string          `json:"to" form:"to" validate:"required"`
+	Amount decimal.Decimal `json:"amount" form:"amount" validate:"VaildateAmount"`
+}
+
+func (p ConvertQuoteParams) Messages() map[string]string {
+	return validate.MS{
+		"required":       "convert.invalid_{field}",
+		"VaildateAmount": "convert.non_positive_amount",
+	}
+}
+
+func (p ConvertQuoteParams) VaildateAmount(Amount decimal.Decimal) bool {
+	return Amount.IsPositive()
+}
+
+// CreateQuote quotes the conversion for the member.
+func (p ConvertQuoteParams) CreateQuote(member *models.Member, err_src *Errors) *models.ConvertQuote {
+	quote, err := models.NewConvertQuote(member, p.From, p.To, p.Amount, time.Now())
+	if err != nil {
+		err_src.Errors = append(err_src.Errors, err.Error())
+
+		return nil
+	}
+
+	return quote
+}
+
+type ConvertAcceptParams struct {
+	UUID uuid.UUID `json:"uuid" form:"uuid" validate:"required"`
+}
+
+func (p ConvertAcceptParams) Messages() map[string]string {
+	return validate.MS{
+		"required": "convert.invalid_{field}",
+	}
+}
+
+// Accept settles the member at the quote.
+func (p ConvertAcceptParams) Accept(member *models.Member, err_src *Errors) *models.ConvertQuote {
+	quote, err := models.AcceptConvertQuote(member, p.UUID, time.Now())
+	if err != nil {
+		err_src.Errors = append(err_src.Errors, err.Error())
+
+		return nil
+	}
+
+	return quote
+}
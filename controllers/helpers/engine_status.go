@@ -0,0 +1,145 @@
+package helpers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/matching"
+)
+
+var (
+	// ErrOrderNotInBook is returned for the queue position of an order the engine doesn't have in its book, it was
+	// filled or cancelled meanwhile
+	ErrOrderNotInBook = errors.New("market.order.not_in_book")
+	// ErrQueuePositionUnavailable is returned when the engine can't be asked for the queue position
+	ErrQueuePositionUnavailable = errors.New("market.order.queue_position_unavailable")
+)
+
+var engineStatusClient = &http.Client{Timeout: 2 * time.Second}
+
+// FetchQueuePosition asks the engine what's ahead of the order of id resting in the book of market.
+func FetchQueuePosition(market string, id int64) (*matching.QueuePosition, error) {
+	if len(config.Engine.StatusURL) == 0 {
+		return nil, ErrQueuePositionUnavailable
+	}
+
+	response, err := engineStatusClient.Get(fmt.Sprintf("%s/orders/%s/%d/position", strings.TrimRight(config.Engine.StatusURL, "/"), market, id))
+	if err != nil {
+		config.Logger.Errorf("Failed to fetch the queue position of order %d: %v", id, err)
+		return nil, ErrQueuePositionUnavailable
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		var position *matching.QueuePosition
+		if err := json.NewDecoder(response.Body).Decode(&position); err != nil {
+			return nil, ErrQueuePositionUnavailable
+		}
+
+		return position, nil
+	case http.StatusNotFound:
+		var errs Errors
+		if err := json.NewDecoder(response.Body).Decode(&errs); err == nil && len(errs.Errors) > 0 && errs.Errors[0] == matching.ErrOrderNotResting.Error() {
+			return nil, ErrOrderNotInBook
+		}
+
+		return nil, ErrQueuePositionUnavailable
+	default:
+		return nil, ErrQueuePositionUnavailable
+	}
+}
+
+// ErrInvariantCheckUnavailable is returned when the engine can't be asked to check the invariants of a book
+var ErrInvariantCheckUnavailable = errors.New("admin.market.invariant_check_unavailable")
+
+// CheckEngineInvariants asks the engine to check the invariants of the book of market.
+func CheckEngineInvariants(market string) (*matching.InvariantCheck, error) {
+	if len(config.Engine.StatusURL) == 0 {
+		return nil, ErrInvariantCheckUnavailable
+	}
+
+	response, err := engineStatusClient.Post(fmt.Sprintf("%s/invariants/%s", strings.TrimRight(config.Engine.StatusURL, "/"), market), "application/json", nil)
+	if err != nil {
+		config.Logger.Errorf("Failed to check the invariants of %s: %v", market, err)
+		return nil, ErrInvariantCheckUnavailable
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, ErrInvariantCheckUnavailable
+	}
+
+	var check *matching.InvariantCheck
+	if err := json.NewDecoder(response.Body).Decode(&check); err != nil {
+		return nil, ErrInvariantCheckUnavailable
+	}
+
+	return check, nil
+}
+
+// ErrDepthUnavailable is returned when the engine can't be asked for the grouped depth of a market
+var ErrDepthUnavailable = errors.New("public.market_depth.unavailable")
+
+// FetchGroupedDepth asks the engine for the best limit groups of each side of the book of market grouped by step.
+func FetchGroupedDepth(market string, limit int64, step decimal.Decimal) (*matching.GroupedDepth, error) {
+	if len(config.Engine.StatusURL) == 0 {
+		return nil, ErrDepthUnavailable
+	}
+
+	query := url.Values{"limit": {strconv.FormatInt(limit, 10)}, "step": {step.String()}}
+	response, err := engineStatusClient.Get(fmt.Sprintf("%s/depth/%s?%s", strings.TrimRight(config.Engine.StatusURL, "/"), market, query.Encode()))
+	if err != nil {
+		config.Logger.Errorf("Failed to fetch the depth of %s grouped by %s: %v", market, step, err)
+		return nil, ErrDepthUnavailable
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, ErrDepthUnavailable
+	}
+
+	var depth *matching.GroupedDepth
+	if err := json.NewDecoder(response.Body).Decode(&depth); err != nil {
+		return nil, ErrDepthUnavailable
+	}
+
+	return depth, nil
+}
+
+// ErrRecentTradesUnavailable is returned when the engine can't be asked for the recent trades of a market
+var ErrRecentTradesUnavailable = errors.New("public.market_trades.recent_unavailable")
+
+// FetchRecentTrades asks the engine for up to limit of the latest trades of market from the tape of its book, the
+// newest first.
+func FetchRecentTrades(market string, limit int64) ([]matching.TapeTrade, error) {
+	if len(config.Engine.StatusURL) == 0 {
+		return nil, ErrRecentTradesUnavailable
+	}
+
+	response, err := engineStatusClient.Get(fmt.Sprintf("%s/trades/%s?limit=%d", strings.TrimRight(config.Engine.StatusURL, "/"), market, limit))
+	if err != nil {
+		config.Logger.Errorf("Failed to fetch the recent trades of %s: %v", market, err)
+		return nil, ErrRecentTradesUnavailable
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, ErrRecentTradesUnavailable
+	}
+
+	var trades []matching.TapeTrade
+	if err := json.NewDecoder(response.Body).Decode(&trades); err != nil {
+		return nil, ErrRecentTradesUnavailable
+	}
+
+	return trades, nil
+}
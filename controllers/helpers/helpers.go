@@ -1,7 +1,9 @@
 package helpers
 
 import (
+	"github.com/gofiber/fiber/v2"
 	"github.com/gookit/validate"
+	"github.com/zsmartex/finex/controllers/entities"
 	"github.com/zsmartex/finex/types"
 )
 
@@ -27,6 +29,7 @@ func Vaildate(payload interface{}, err_src *Errors) {
 func VaildateMessage(prefix string) map[string]string {
 	return validate.MS{
 		"uint":               prefix + ".non_integer_{field}",
+		"required":           prefix + ".missing_{field}",
 		"ValidateOrderState": prefix + ".invalid_{field}",
 		"ValidateType":       prefix + ".invalid_{field}",
 		"ValidateOrderBy":    prefix + ".invalid_{field}",
@@ -46,6 +49,9 @@ func VaildateTranslateFields() map[string]string {
 		"TimeFrom":  "time_from",
 		"TimeTo":    "time_to",
 		"OrderBy":   "order_by",
+		"Period":    "period",
+		"Points":    "points",
+		"Month":     "month",
 	}
 }
 
@@ -99,3 +105,12 @@ func ValidateTakerType(val types.TakerType) bool {
 func ValidateMarketType(val string) bool {
 	return true
 }
+
+// APIVersion returns the API version of the request, set by the version middleware of the route group.
+func APIVersion(c *fiber.Ctx) entities.Version {
+	if version, ok := c.Locals("APIVersion").(entities.Version); ok {
+		return version
+	}
+
+	return entities.V2
+}
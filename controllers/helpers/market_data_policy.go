@@ -0,0 +1,17 @@
+package helpers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/models"
+)
+
+// MarketDataPolicy returns the market data policy of the user of the request and tells the client its tier.
+func MarketDataPolicy(c *fiber.Ctx) *models.MarketDataPolicy {
+	member, _ := c.Locals("CurrentUser").(*models.Member)
+	tier := models.MarketDataTierOf(member)
+
+	c.Set("X-Market-Data-Tier", string(tier))
+
+	return models.MarketDataPolicies.Of(tier)
+}
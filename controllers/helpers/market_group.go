@@ -0,0 +1,22 @@
+package helpers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+)
+
+// MarketGroup returns the market group of the request, the group of the member when it's authenticated
+// or the group of the requested domain.
+func MarketGroup(c *fiber.Ctx) string {
+	if member, ok := c.Locals("CurrentUser").(*models.Member); ok {
+		return models.MarketGroupOf(member)
+	}
+
+	if group, ok := config.MarketGroupDomains[c.Hostname()]; ok {
+		return group
+	}
+
+	return models.DefaultMarketGroup
+}
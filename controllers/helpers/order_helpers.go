@@ -1,6 +1,11 @@
 package helpers
 
 import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
 	"github.com/gookit/validate"
 	"github.com/shopspring/decimal"
 
@@ -11,6 +16,8 @@ import (
 	clientEngine "github.com/zsmartex/pkg/client/engine"
 
 	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/decimalutil"
+	"github.com/zsmartex/finex/events"
 	"github.com/zsmartex/finex/models"
 	"github.com/zsmartex/finex/types"
 )
@@ -23,6 +30,31 @@ type CreateOrderParams struct {
 	StopPrice decimal.NullDecimal `json:"stop_price" form:"stop_price" validate:"VaildateStopPrice"`
 	Quantity  decimal.NullDecimal `json:"quantity" form:"quantity"`
 	Volume    decimal.NullDecimal `json:"volume" form:"volume"`
+	// PostOnly cancels the order rather than letting it take liquidity, limit orders only
+	PostOnly bool `json:"post_only" form:"post_only"`
+	// TimeInForce is GTC, IOC, FOK or GTD, limit orders are good till cancelled and market orders immediate or cancel by default,
+	// limit orders with an expiry good till the date
+	TimeInForce types.TimeInForce `json:"time_in_force" form:"time_in_force" validate:"VaildateTimeInForce"`
+	// ExpiresAt is when a good-till-date order is cancelled, it must be in the future
+	ExpiresAt *time.Time `json:"expires_at" form:"expires_at"`
+	// TrailingOffset makes the order a trailing stop, its stop price follows the market price at the offset
+	TrailingOffset decimal.NullDecimal `json:"trailing_offset" form:"trailing_offset" validate:"VaildateTrailingOffset"`
+	// SelfTradePrevention keeps the order from matching the orders of the member, they're matched when it's not set
+	SelfTradePrevention types.SelfTradePrevention `json:"self_trade_prevention" form:"self_trade_prevention" validate:"VaildateSelfTradePrevention"`
+	// DisplayQuantity makes a limit order an iceberg, the book only shows slices of it of the quantity
+	DisplayQuantity decimal.NullDecimal `json:"display_quantity" form:"display_quantity" validate:"VaildateDisplayQuantity"`
+	// MaxSlippage cancels the rest of a market order rather than matching it further than the ratio from the best price
+	MaxSlippage decimal.NullDecimal `json:"max_slippage" form:"max_slippage" validate:"VaildateMaxSlippage"`
+	// QuoteQuantity makes a market buy spend the quote amount rather than buy a quantity, it's placed without a quantity
+	QuoteQuantity decimal.NullDecimal `json:"quote_quantity" form:"quote_quantity" validate:"VaildateQuoteQuantity"`
+	// TriggerBy is the price a stop order is triggered by, last_price or index_price, the last price when it's not set
+	TriggerBy types.TriggerBy `json:"trigger_by" form:"trigger_by" validate:"VaildateTriggerBy"`
+	// AlgoOrderUUID is set by the algo order scheduler on the slices it places
+	AlgoOrderUUID uuid.NullUUID `json:"-" form:"-"`
+	// ConvertQuoteUUID is set by the convert hedger on the orders of the route of a conversion
+	ConvertQuoteUUID uuid.NullUUID `json:"-" form:"-"`
+	// ClientID is the id the member places the order with, placing the same order again with it returns the order
+	ClientID uuid.NullUUID `json:"client_id" form:"client_id"`
 }
 
 func (p CreateOrderParams) Messages() map[string]string {
@@ -34,6 +66,20 @@ func (p CreateOrderParams) Messages() map[string]string {
 		"VaildatePrice":     "market.order.non_positive_price",
 		"VaildateStopPrice": "market.order.non_positive_stop_price",
 		"VaildateVolume":    "market.order.non_positive_volume",
+		// a market order doesn't rest and a post-only order must be able to
+		"VaildateTimeInForce": "market.order.invalid_time_in_force",
+		// a trailing stop waits for its stop price, it can't be post-only or not rest
+		"VaildateTrailingOffset": "market.order.invalid_trailing_offset",
+		// decrements need the order events v5
+		"VaildateSelfTradePrevention": "market.order.invalid_self_trade_prevention",
+		// an iceberg is a limit order which rests, it hides a part of its quantity
+		"VaildateDisplayQuantity": "market.order.invalid_display_quantity",
+		// a ratio of the best price, market orders only
+		"VaildateMaxSlippage": "market.order.invalid_max_slippage",
+		// a market buy placed without a quantity, which isn't filled or killed
+		"VaildateQuoteQuantity": "market.order.invalid_quote_quantity",
+		// stop orders only
+		"VaildateTriggerBy": "market.order.invalid_trigger_by",
 	}
 }
 
@@ -54,7 +100,7 @@ func (p CreateOrderParams) VaildateStopPrice(StopPrice decimal.NullDecimal) bool
 }
 
 func (p CreateOrderParams) VaildateOrdType(OrdType types.OrderType) bool {
-	if OrdType == types.TypeMarket && (p.Price.Valid || p.StopPrice.Valid) {
+	if OrdType == types.TypeMarket && (p.Price.Valid || p.StopPrice.Valid || p.PostOnly || p.ExpiresAt != nil) {
 		return false
 	} else if OrdType == types.TypeLimit && !p.Price.Valid {
 		return false
@@ -63,6 +109,87 @@ func (p CreateOrderParams) VaildateOrdType(OrdType types.OrderType) bool {
 	return true
 }
 
+func (p CreateOrderParams) VaildateTimeInForce(time_in_force types.TimeInForce) bool {
+	// only a limit order which rests can expire, an order without a time in force and with an expiry is good till the date
+	if p.ExpiresAt != nil {
+		return (len(time_in_force) == 0 || time_in_force == types.TimeInForceGTD) && p.OrdType != types.TypeMarket && p.ExpiresAt.After(time.Now())
+	}
+
+	switch time_in_force {
+	case "":
+		return true
+	case types.TimeInForceGTC:
+		return p.OrdType != types.TypeMarket
+	case types.TimeInForceIOC, types.TimeInForceFOK:
+		return !p.PostOnly
+	}
+
+	return false
+}
+
+func (p CreateOrderParams) VaildateTrailingOffset(TrailingOffset decimal.NullDecimal) bool {
+	if !TrailingOffset.Valid {
+		return true
+	}
+
+	return TrailingOffset.Decimal.IsPositive() && !p.PostOnly && p.OrdType != types.TypeMarket
+}
+
+func (p CreateOrderParams) VaildateSelfTradePrevention(policy types.SelfTradePrevention) bool {
+	switch policy {
+	case "", types.SelfTradePreventionCancelNewest, types.SelfTradePreventionCancelOldest, types.SelfTradePreventionCancelBoth:
+		return true
+	case types.SelfTradePreventionDecrementAndCancel:
+		return events.ProducesOrderDecrements()
+	}
+
+	return false
+}
+
+func (p CreateOrderParams) VaildateDisplayQuantity(DisplayQuantity decimal.NullDecimal) bool {
+	if !DisplayQuantity.Valid {
+		return true
+	}
+
+	if p.OrdType != types.TypeLimit || p.TimeInForce == types.TimeInForceIOC || p.TimeInForce == types.TimeInForceFOK {
+		return false
+	}
+
+	return DisplayQuantity.Decimal.IsPositive() && p.Quantity.Valid && DisplayQuantity.Decimal.LessThan(p.Quantity.Decimal)
+}
+
+func (p CreateOrderParams) VaildateMaxSlippage(MaxSlippage decimal.NullDecimal) bool {
+	if !MaxSlippage.Valid {
+		return true
+	}
+
+	return p.OrdType == types.TypeMarket && MaxSlippage.Decimal.IsPositive() && MaxSlippage.Decimal.LessThan(decimal.NewFromInt(1))
+}
+
+func (p CreateOrderParams) VaildateQuoteQuantity(QuoteQuantity decimal.NullDecimal) bool {
+	if !QuoteQuantity.Valid {
+		return true
+	}
+
+	// the quantity it buys isn't known before it's matched, it can't be filled whole
+	if p.OrdType != types.TypeMarket || p.Side != types.SideBuy || p.Quantity.Valid || p.TimeInForce == types.TimeInForceFOK {
+		return false
+	}
+
+	return QuoteQuantity.Decimal.IsPositive()
+}
+
+func (p CreateOrderParams) VaildateTriggerBy(TriggerBy types.TriggerBy) bool {
+	switch TriggerBy {
+	case "":
+		return true
+	case types.TriggerByLastPrice, types.TriggerByIndexPrice:
+		return p.StopPrice.Valid || p.TrailingOffset.Valid
+	}
+
+	return false
+}
+
 func (p CreateOrderParams) VaildateVolume(Volume decimal.Decimal) bool {
 	return Volume.IsPositive()
 }
@@ -83,10 +210,53 @@ func (p CreateOrderParams) BuildOrder(member *models.Member, err_src *Errors) *m
 	var order_side models.OrderSide
 	market := p.GetMarket()
 
+	if !models.MarketVisibility.Visible(models.MarketGroupOf(member), market.Symbol) {
+		err_src.Errors = append(err_src.Errors, "market.order.invalid_market")
+
+		return nil
+	}
+
+	// the matching engine couldn't process the order until its consumer is back
+	if market.State == string(types.MarketStateHalted) {
+		err_src.Errors = append(err_src.Errors, "market.order.market_halted")
+
+		return nil
+	}
+
+	// an admin halted the book, the engine would reject the order
+	if market.EngineTradingState() == types.TradingStateHalted {
+		err_src.Errors = append(err_src.Errors, models.ErrMarketHalted.Error())
+
+		return nil
+	}
+
+	// a market being delisted takes cancels only
+	if market.EngineTradingState() == types.TradingStateCancelOnly {
+		err_src.Errors = append(err_src.Errors, models.ErrMarketCancelOnly.Error())
+
+		return nil
+	}
+
 	if len(p.OrdType) == 0 {
 		p.OrdType = types.TypeLimit
 	}
 
+	if len(p.TimeInForce) == 0 {
+		p.TimeInForce = types.TimeInForceGTC
+		if p.ExpiresAt != nil {
+			p.TimeInForce = types.TimeInForceGTD
+		} else if p.OrdType == types.TypeMarket {
+			p.TimeInForce = types.TimeInForceIOC
+		}
+	}
+
+	// a listed market takes no order before its warm-up and only resting limit orders during it
+	if err := market.AcceptsOrder(p.OrdType, time.Now()); err != nil {
+		err_src.Errors = append(err_src.Errors, err.Error())
+
+		return nil
+	}
+
 	if p.Side == types.SideBuy {
 		order_side = models.SideBuy
 	} else {
@@ -110,39 +280,52 @@ func (p CreateOrderParams) BuildOrder(member *models.Member, err_src *Errors) *m
 			side = pkg.SideSell
 		}
 
-		matching_client := clientEngine.NewMatchingClient()
-		defer matching_client.Close()
-
-		symbol := market.GetSymbol()
-
-		calc_market_order_response, err := matching_client.CalcMarketOrder(&GrpcEngine.CalcMarketOrderRequest{
-			Symbol: &GrpcSymbol.Symbol{BaseCurrency: symbol.BaseCurrency, QuoteCurrency: symbol.QuoteCurrency},
-			Side:   string(side),
-			Quantity: &GrpcUtils.Decimal{
-				Val: p.Quantity.Decimal.CoefficientInt64(),
-				Exp: p.Quantity.Decimal.Exponent(),
-			},
-			// Volume: &engineGrpc.Decimal{
-			// 	Val: p.Volume.Decimal.CoefficientInt64(),
-			// 	Exp: p.Volume.Decimal.Exponent(),
-			// },
-		})
-		if err != nil {
-			err_src.Errors = append(err_src.Errors, "market.order.insufficient_market_liquidity")
+		if p.QuoteQuantity.Valid {
+			// the engine spends the funds of the order, its quantity caps what they buy
+			asks := models.GetDepth(models.SideSell, market.Symbol)
+			if len(asks) == 0 {
+				err_src.Errors = append(err_src.Errors, "market.order.insufficient_market_liquidity")
 
-			return nil
-		}
+				return nil
+			}
 
-		quantity = calc_market_order_response.Quantity.ToDecimal()
-		locked = calc_market_order_response.Locked.ToDecimal()
-
-		if quantity.IsZero() || locked.IsZero() {
-			err_src.Errors = append(err_src.Errors, "market.order.insufficient_market_liquidity")
+			quantity = market.QuoteQuantityVolume(p.QuoteQuantity.Decimal, asks[0][0])
+			locked = decimalutil.RoundLocked(p.QuoteQuantity.Decimal, models.SchemaDecimalScale)
+		} else {
+			matching_client := clientEngine.NewMatchingClient()
+			defer matching_client.Close()
+
+			symbol := market.GetSymbol()
+
+			calc_market_order_response, err := matching_client.CalcMarketOrder(&GrpcEngine.CalcMarketOrderRequest{
+				Symbol: &GrpcSymbol.Symbol{BaseCurrency: symbol.BaseCurrency, QuoteCurrency: symbol.QuoteCurrency},
+				Side:   string(side),
+				Quantity: &GrpcUtils.Decimal{
+					Val: p.Quantity.Decimal.CoefficientInt64(),
+					Exp: p.Quantity.Decimal.Exponent(),
+				},
+				// Volume: &engineGrpc.Decimal{
+				// 	Val: p.Volume.Decimal.CoefficientInt64(),
+				// 	Exp: p.Volume.Decimal.Exponent(),
+				// },
+			})
+			if err != nil {
+				err_src.Errors = append(err_src.Errors, "market.order.insufficient_market_liquidity")
+
+				return nil
+			}
+
+			quantity = calc_market_order_response.Quantity.ToDecimal()
+			locked = calc_market_order_response.Locked.ToDecimal()
+
+			if quantity.IsZero() || locked.IsZero() {
+				err_src.Errors = append(err_src.Errors, "market.order.insufficient_market_liquidity")
+			}
 		}
 	} else {
 		quantity = p.Quantity.Decimal
 		if p.Side == types.SideBuy {
-			locked = p.Price.Decimal.Mul(p.Quantity.Decimal)
+			locked = decimalutil.RoundLocked(p.Price.Decimal.Mul(p.Quantity.Decimal), models.SchemaDecimalScale)
 		} else {
 			locked = p.Quantity.Decimal
 		}
@@ -153,36 +336,95 @@ func (p CreateOrderParams) BuildOrder(member *models.Member, err_src *Errors) *m
 	}
 
 	order := &models.Order{
-		MemberID:     member.ID,
-		Ask:          market.BaseUnit,
-		Bid:          market.QuoteUnit,
-		MarketID:     market.Symbol,
-		MarketType:   types.AccountTypeSpot,
-		OrdType:      p.OrdType,
-		State:        models.StatePending,
-		Type:         order_side,
-		Price:        p.Price,
-		StopPrice:    p.StopPrice,
-		Volume:       quantity,
-		MakerFee:     trading_fee.Maker,
-		TakerFee:     trading_fee.Taker,
-		OriginVolume: quantity,
-		Locked:       locked,
-		OriginLocked: locked,
+		MemberID:            member.ID,
+		Ask:                 market.BaseUnit,
+		Bid:                 market.QuoteUnit,
+		MarketID:            market.Symbol,
+		MarketType:          types.AccountTypeSpot,
+		OrdType:             p.OrdType,
+		State:               models.StatePending,
+		Type:                order_side,
+		Price:               p.Price,
+		StopPrice:           p.StopPrice,
+		Volume:              quantity,
+		MakerFee:            trading_fee.Maker,
+		TakerFee:            trading_fee.Taker,
+		OriginVolume:        quantity,
+		Locked:              locked,
+		OriginLocked:        locked,
+		AlgoOrderUUID:       p.AlgoOrderUUID,
+		ConvertQuoteUUID:    p.ConvertQuoteUUID,
+		ClientID:            p.ClientID,
+		PostOnly:            p.PostOnly,
+		TimeInForce:         p.TimeInForce,
+		TrailingOffset:      p.TrailingOffset,
+		SelfTradePrevention: p.SelfTradePrevention,
+		DisplayQuantity:     p.DisplayQuantity,
+		MaxSlippage:         p.MaxSlippage,
+		QuoteQuantity:       p.QuoteQuantity,
+		TriggerBy:           p.TriggerBy,
+	}
+
+	if p.ExpiresAt != nil {
+		order.ExpiresAt = sql.NullTime{Time: *p.ExpiresAt, Valid: true}
 	}
 
 	Vaildate(order, err_src)
+	if err_src.Size() > 0 {
+		return order
+	}
+
+	if err := market.ValidateOrderSize(order.OriginVolume, order.QuoteAmount()); err != nil {
+		err_src.Errors = append(err_src.Errors, err.Error())
+
+		return order
+	}
+
+	if denial := models.PreTradeChecks.Run(models.NewPreTradeContext(order, member, &market, preTradeLastPrice(order, &market))); denial != nil {
+		err_src.Errors = append(err_src.Errors, denial.Code)
+	}
 
 	return order
 }
 
+// preTradeLastPrice is the price market sell orders are valued at by the pre-trade checks.
+func preTradeLastPrice(order *models.Order, market *models.Market) decimal.Decimal {
+	if order.Type == models.SideBuy || order.Price.Valid {
+		return decimal.Zero
+	}
+
+	return models.LoadMarketVolume(market.Symbol, time.Now()).LastPrice
+}
+
 func (p CreateOrderParams) CreateOrder(member *models.Member, err_src *Errors) (order *models.Order) {
+	if p.ClientID.Valid {
+		if existing := models.OpenOrderByClientID(config.DataBase, member.ID, p.ClientID.UUID); existing != nil {
+			return p.resubmitted(existing, err_src)
+		}
+	}
+
 	order = p.BuildOrder(member, err_src)
 
 	if len(err_src.Errors) > 0 {
 		return
 	}
 
+	// the client id is checked as the order is inserted, the orders placed with one don't take the paths inserting
+	// them later
+	if order.ClientID.Valid {
+		return p.createClientOrder(order, err_src)
+	}
+
+	if config.FastAck.Enabled && events.ProducesOrderAttributes() {
+		if acknowledged, fallback := acknowledgeOrder(order, err_src); !fallback {
+			return acknowledged
+		}
+	}
+
+	if config.OrderIDs.AsyncPersist && events.ProducesOrderAttributes() {
+		return submitNewOrder(order, err_src)
+	}
+
 	if err := config.DataBase.Create(&order).Error; err != nil {
 		err_src.Errors = append(err_src.Errors, "market.order.invalid_volume_or_price")
 
@@ -196,3 +438,258 @@ func (p CreateOrderParams) CreateOrder(member *models.Member, err_src *Errors) (
 
 	return order
 }
+
+// createClientOrder inserts and submits an order placed with a client id, or returns the open order of the member
+// placed with it meanwhile.
+func (p CreateOrderParams) createClientOrder(order *models.Order, err_src *Errors) *models.Order {
+	existing, err := models.CreateClientOrder(order)
+	if err != nil {
+		err_src.Errors = append(err_src.Errors, "market.order.invalid_volume_or_price")
+
+		return nil
+	}
+
+	if existing != nil {
+		return p.resubmitted(existing, err_src)
+	}
+
+	if err := order.Submit(); err != nil {
+		err_src.Errors = append(err_src.Errors, err.Error())
+
+		return nil
+	}
+
+	return order
+}
+
+// resubmitted returns the open order placed with the client id of the params when the params place the same order,
+// placing an order twice with its client id places it once. Another order can't take the client id.
+func (p CreateOrderParams) resubmitted(existing *models.Order, err_src *Errors) *models.Order {
+	ord_type := p.OrdType
+	if len(ord_type) == 0 {
+		ord_type = types.TypeLimit
+	}
+
+	same := existing.MarketID == p.Market &&
+		string(existing.Side()) == string(p.Side) &&
+		existing.OrdType == ord_type &&
+		sameNullDecimal(existing.Price, p.Price) &&
+		sameNullDecimal(existing.StopPrice, p.StopPrice) &&
+		(p.Quantity.Valid && existing.OriginVolume.Equal(p.Quantity.Decimal) || p.QuoteQuantity.Valid && sameNullDecimal(existing.QuoteQuantity, p.QuoteQuantity))
+
+	if !same {
+		err_src.Errors = append(err_src.Errors, models.ErrClientIDTaken.Error())
+
+		return nil
+	}
+
+	return existing
+}
+
+func sameNullDecimal(a, b decimal.NullDecimal) bool {
+	return a.Valid == b.Valid && (!a.Valid || a.Decimal.Equal(b.Decimal))
+}
+
+// assignOrderID assigns the id of an order placed without waiting for its insert.
+func assignOrderID(order *models.Order) error {
+	id, err := models.NextOrderID()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	order.ID = id
+	order.UUID = uuid.New()
+	order.CreatedAt = now
+	order.UpdatedAt = now
+
+	return nil
+}
+
+// acknowledgeOrder places the order on the fast path, it falls back to the other paths when the fast path
+// is closed or fails before the order was handed over.
+func acknowledgeOrder(order *models.Order, err_src *Errors) (*models.Order, bool) {
+	if order.ID == 0 {
+		if err := assignOrderID(order); err != nil {
+			return nil, true
+		}
+	}
+
+	err := order.Acknowledge()
+	switch {
+	case err == nil:
+		return order, false
+	case errors.Is(err, models.ErrInsufficientBalance):
+		// the ledger doesn't know the unpersisted orders, a placement it accepted could overspend
+		err_src.Errors = append(err_src.Errors, err.Error())
+
+		return nil, false
+	default:
+		if !errors.Is(err, models.ErrFastAckClosed) {
+			config.Logger.Errorf("Failed to acknowledge order %d, it's placed synchronously: %v", order.ID, err)
+		}
+
+		return nil, true
+	}
+}
+
+// submitNewOrder assigns the id of the order and hands it to the order processor, which inserts it.
+func submitNewOrder(order *models.Order, err_src *Errors) *models.Order {
+	if order.ID == 0 {
+		if err := assignOrderID(order); err != nil {
+			err_src.Errors = append(err_src.Errors, "server.internal_error")
+
+			return nil
+		}
+	}
+
+	if err := order.SubmitNew(); err != nil {
+		err_src.Errors = append(err_src.Errors, err.Error())
+
+		return nil
+	}
+
+	return order
+}
+
+// ReplaceOrderParams describes the order replacing an open order, the market and the side stay the ones of the replaced order.
+type ReplaceOrderParams struct {
+	OrdType   types.OrderType     `json:"ord_type" form:"ord_type"`
+	Price     decimal.NullDecimal `json:"price" form:"price"`
+	StopPrice decimal.NullDecimal `json:"stop_price" form:"stop_price"`
+	Quantity  decimal.NullDecimal `json:"quantity" form:"quantity"`
+}
+
+func (p ReplaceOrderParams) ReplaceOrder(member *models.Member, order *models.Order, err_src *Errors) *models.Order {
+	create_params := &CreateOrderParams{
+		Market:    order.MarketID,
+		Side:      types.SideSell,
+		OrdType:   p.OrdType,
+		Price:     p.Price,
+		StopPrice: p.StopPrice,
+		Quantity:  p.Quantity,
+		// the replacement is kept from the orders of the member as the order it replaces was
+		SelfTradePrevention: order.SelfTradePrevention,
+	}
+
+	if order.Type == models.SideBuy {
+		create_params.Side = types.SideBuy
+	}
+
+	if len(create_params.OrdType) == 0 {
+		create_params.OrdType = order.OrdType
+	}
+
+	// a limit replacement is post-only when the order it replaces was
+	create_params.PostOnly = order.PostOnly && create_params.OrdType == types.TypeLimit
+	if create_params.OrdType == types.TypeLimit {
+		create_params.TimeInForce = order.TimeInForce
+		create_params.TrailingOffset = order.TrailingOffset
+		if order.ExpiresAt.Valid {
+			create_params.ExpiresAt = &order.ExpiresAt.Time
+		}
+
+		// an iceberg replacement keeps the slice while it still hides a part of its quantity
+		if order.DisplayQuantity.Valid && create_params.Quantity.Valid && order.DisplayQuantity.Decimal.LessThan(create_params.Quantity.Decimal) {
+			create_params.DisplayQuantity = order.DisplayQuantity
+		}
+	} else {
+		// a market replacement of a stop order keeps its collar
+		create_params.MaxSlippage = order.MaxSlippage
+	}
+
+	// a stop replacement is triggered by the price the order it replaces was
+	if create_params.StopPrice.Valid || create_params.TrailingOffset.Valid {
+		create_params.TriggerBy = order.TriggerBy
+	}
+
+	Vaildate(create_params, err_src)
+	if err_src.Size() > 0 {
+		return nil
+	}
+
+	replacement := create_params.BuildOrder(member, err_src)
+	if err_src.Size() > 0 {
+		return nil
+	}
+
+	if err := models.ReplaceOrder(order, replacement); err != nil {
+		if errors.Is(err, models.ErrInsufficientBalance) || errors.Is(err, models.ErrReplaceNotOpen) {
+			err_src.Errors = append(err_src.Errors, err.Error())
+		} else {
+			err_src.Errors = append(err_src.Errors, "market.order.invalid_volume_or_price")
+		}
+
+		return nil
+	}
+
+	return replacement
+}
+
+// AmendOrderParams describes the price and the quantity an open limit order is amended to, the ones left out
+// stay the ones of the order. The quantity is the whole quantity of the order, its filled part included.
+type AmendOrderParams struct {
+	Price    decimal.NullDecimal `json:"price" form:"price"`
+	Quantity decimal.NullDecimal `json:"quantity" form:"quantity"`
+}
+
+func (p AmendOrderParams) AmendOrder(order *models.Order, err_src *Errors) *models.Order {
+	if !events.ProducesOrderAmends() {
+		err_src.Errors = append(err_src.Errors, "market.order.amend_unavailable")
+
+		return nil
+	}
+
+	if order.State != models.StateWait || order.OrdType != types.TypeLimit {
+		err_src.Errors = append(err_src.Errors, models.ErrAmendNotOpen.Error())
+
+		return nil
+	}
+
+	market := order.Market()
+	switch market.EngineTradingState() {
+	case types.TradingStateHalted:
+		err_src.Errors = append(err_src.Errors, models.ErrMarketHalted.Error())
+
+		return nil
+	case types.TradingStateCancelOnly:
+		err_src.Errors = append(err_src.Errors, models.ErrMarketCancelOnly.Error())
+
+		return nil
+	}
+
+	// the amended order is checked like an order placed at its price and quantity
+	amended := *order
+	if p.Price.Valid {
+		amended.Price = p.Price
+	}
+
+	if p.Quantity.Valid {
+		amended.OriginVolume = p.Quantity.Decimal
+	}
+
+	Vaildate(&amended, err_src)
+	if err_src.Size() > 0 {
+		return nil
+	}
+
+	quote_amount := decimalutil.RoundLocked(amended.Price.Decimal.Mul(amended.OriginVolume), models.SchemaDecimalScale)
+	if err := market.ValidateOrderSize(amended.OriginVolume, quote_amount); err != nil {
+		err_src.Errors = append(err_src.Errors, err.Error())
+
+		return nil
+	}
+
+	if err := models.AmendOrder(order, amended.Price.Decimal, amended.OriginVolume); err != nil {
+		switch {
+		case errors.Is(err, models.ErrInsufficientBalance), errors.Is(err, models.ErrAmendNotOpen), errors.Is(err, models.ErrAmendPending), errors.Is(err, models.ErrAmendBelowFilled):
+			err_src.Errors = append(err_src.Errors, err.Error())
+		default:
+			err_src.Errors = append(err_src.Errors, "market.order.invalid_volume_or_price")
+		}
+
+		return nil
+	}
+
+	return order
+}
@@ -0,0 +1,36 @@
+package helpers
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/controllers/entities"
+)
+
+const (
+	DefaultPageLimit = 100
+	DefaultPage      = 1
+)
+
+// RenderList renders the entities of a list endpoint for the API version of the request, an empty list is [].
+func RenderList(c *fiber.Ctx, status int, list interface{}) error {
+	return c.Status(status).JSON(entities.Serialize(list, APIVersion(c)))
+}
+
+// PageDefaults fills the page and the limit of a paginated query when they're not set.
+func PageDefaults(page, limit *int) {
+	if *limit == 0 {
+		*limit = DefaultPageLimit
+	}
+
+	if *page == 0 {
+		*page = DefaultPage
+	}
+}
+
+// SetPageHeaders sets the pagination headers of a list endpoint, empty pages have them too.
+func SetPageHeaders(c *fiber.Ctx, page, limit int) {
+	c.Response().Header.Set("page", strconv.Itoa(page))
+	c.Response().Header.Set("per-page", strconv.Itoa(limit))
+}
@@ -0,0 +1,115 @@
+package helpers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gookit/validate"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/types"
+)
+
+// CreateScheduledOrderParams describes an order to place at ExecuteAt, the order params are checked again when it's placed.
+type CreateScheduledOrderParams struct {
+	Market    string              `json:"market" form:"market" validate:"required"`
+	Side      types.OrderSide     `json:"side" form:"side" validate:"required|VaildateSide"`
+	OrdType   types.OrderType     `json:"ord_type" form:"ord_type" validate:"VaildateOrdType"`
+	Price     decimal.NullDecimal `json:"price" form:"price" validate:"VaildatePrice"`
+	StopPrice decimal.NullDecimal `json:"stop_price" form:"stop_price" validate:"VaildatePrice"`
+	Quantity  decimal.Decimal     `json:"quantity" form:"quantity" validate:"VaildateQuantity"`
+	PostOnly  bool                `json:"post_only" form:"post_only"`
+	ExecuteAt time.Time           `json:"execute_at" form:"execute_at" validate:"required"`
+}
+
+func (p CreateScheduledOrderParams) Messages() map[string]string {
+	invalid_message := "scheduled_order.invalid_{field}"
+
+	return validate.MS{
+		"required":         invalid_message,
+		"VaildateSide":     invalid_message,
+		"VaildateOrdType":  invalid_message,
+		"VaildatePrice":    "scheduled_order.non_positive_price",
+		"VaildateQuantity": "scheduled_order.non_positive_quantity",
+	}
+}
+
+func (p CreateScheduledOrderParams) VaildateSide(val types.OrderSide) bool {
+	return p.Side == types.SideBuy || p.Side == types.SideSell
+}
+
+func (p CreateScheduledOrderParams) VaildateOrdType(OrdType types.OrderType) bool {
+	if OrdType == types.TypeMarket {
+		return !p.Price.Valid && !p.StopPrice.Valid && !p.PostOnly
+	}
+
+	return p.Price.Valid
+}
+
+func (p CreateScheduledOrderParams) VaildatePrice(Price decimal.NullDecimal) bool {
+	if Price.Valid {
+		return Price.Decimal.IsPositive()
+	}
+
+	return true
+}
+
+func (p CreateScheduledOrderParams) VaildateQuantity(Quantity decimal.Decimal) bool {
+	return Quantity.IsPositive()
+}
+
+// CreateScheduledOrder schedules the order of the member, the scheduled order scheduler places it at its time.
+func (p CreateScheduledOrderParams) CreateScheduledOrder(member *models.Member, err_src *Errors) *models.ScheduledOrder {
+	if len(p.OrdType) == 0 {
+		p.OrdType = types.TypeLimit
+	}
+
+	// a market whose listing isn't open yet can be scheduled, orders are usually scheduled for its opening
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ? AND state = ?", p.Market, types.MarketStateEndabled); result.Error != nil || !models.MarketVisibility.Visible(models.MarketGroupOf(member), market.Symbol) {
+		err_src.Errors = append(err_src.Errors, "scheduled_order.invalid_market")
+
+		return nil
+	}
+
+	if err := market.ValidateOrderSize(p.Quantity, p.Price.Decimal.Mul(p.Quantity)); err != nil {
+		err_src.Errors = append(err_src.Errors, err.Error())
+
+		return nil
+	}
+
+	scheduled_order, err := models.NewScheduledOrder(member, market, p.Side, p.OrdType, p.Price, p.StopPrice, p.Quantity, p.PostOnly, p.ExecuteAt, time.Now())
+	if err != nil {
+		err_src.Errors = append(err_src.Errors, err.Error())
+
+		return nil
+	}
+
+	if err := models.CreateScheduledOrder(scheduled_order); err != nil {
+		if errors.Is(err, models.ErrScheduledOrderTooMany) || errors.Is(err, models.ErrInsufficientBalance) {
+			err_src.Errors = append(err_src.Errors, err.Error())
+		} else {
+			config.Logger.Errorf("Failed to create scheduled order: %v", err)
+			err_src.Errors = append(err_src.Errors, "scheduled_order.create_error")
+		}
+
+		return nil
+	}
+
+	return scheduled_order
+}
+
+// ScheduledOrderParams are the params of the order placed for a scheduled order.
+func ScheduledOrderParams(scheduled_order *models.ScheduledOrder) *CreateOrderParams {
+	return &CreateOrderParams{
+		Market:    scheduled_order.MarketID,
+		Side:      scheduled_order.Side,
+		OrdType:   scheduled_order.OrdType,
+		Price:     scheduled_order.Price,
+		StopPrice: scheduled_order.StopPrice,
+		Quantity:  decimal.NewNullDecimal(scheduled_order.Quantity),
+		PostOnly:  scheduled_order.PostOnly,
+	}
+}
@@ -106,12 +106,21 @@ func CreateIEOOrder(c *fiber.Ctx) error {
 		})
 	}
 
+	price, err := ieo.GetPriceByParent(payload.PaymentCurrency)
+	if err != nil {
+		config.Logger.Errorf("Failed to price IEO %d in %s: %v", ieo.ID, payload.PaymentCurrency, err)
+
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"market.ieo.invalid_payment_currency"},
+		})
+	}
+
 	ieo_order := &models.IEOOrder{
 		IEOID:             payload.IEOID,
 		UUID:              uuid.New(),
 		MemberID:          CurrentUser.ID,
 		PaymentCurrencyID: payload.PaymentCurrency,
-		Price:             ieo.GetPriceByParent(payload.PaymentCurrency),
+		Price:             price,
 		Quantity:          payload.Quantity,
 		State:             models.StatePending,
 	}
@@ -132,7 +141,7 @@ func CreateIEOOrder(c *fiber.Ctx) error {
 		})
 	}
 
-	config.KafkaProducer.Produce("ieo_order_processor", ieo_order.ToJSON())
+	config.Bus.Publish("ieo_order_processor", "", ieo_order.ToJSON())
 
 	return c.Status(201).JSON(ieo_order.ToJSON())
 }
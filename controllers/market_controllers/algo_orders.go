@@ -0,0 +1,144 @@
+package market_controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/controllers/queries"
+	"github.com/zsmartex/finex/models"
+)
+
+// CreateAlgoOrder accepts a TWAP order, its quantity is placed in slices over its duration.
+func CreateAlgoOrder(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	payload := new(helpers.CreateAlgoOrderParams)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	errs := new(helpers.Errors)
+	helpers.Vaildate(payload, errs)
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	algo_order := payload.CreateAlgoOrder(CurrentUser, errs)
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	return c.Status(201).JSON(algo_order.ToJSON())
+}
+
+func GetAlgoOrders(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	params := new(queries.AlgoOrderFilters)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	if params.Limit == 0 {
+		params.Limit = 100
+	}
+
+	if params.Page == 0 {
+		params.Page = 1
+	}
+
+	tx := config.DataBase.Where("member_id = ?", CurrentUser.ID).Order("id desc")
+	if len(params.Market) > 0 {
+		tx = tx.Where("market_id = ?", params.Market)
+	}
+
+	if len(params.State) > 0 {
+		tx = tx.Where("state = ?", params.State)
+	}
+
+	var algo_orders []*models.AlgoOrder
+	tx.Offset(params.Page*params.Limit - params.Limit).Limit(params.Limit).Find(&algo_orders)
+
+	algo_orders_json := make([]entities.AlgoOrderEntity, 0, len(algo_orders))
+	for _, algo_order := range algo_orders {
+		algo_orders_json = append(algo_orders_json, algo_order.ToJSON())
+	}
+
+	c.Response().Header.Add("page", strconv.FormatInt(int64(params.Page), 10))
+	c.Response().Header.Add("per-page", strconv.FormatInt(int64(params.Limit), 10))
+
+	return c.Status(200).JSON(algo_orders_json)
+}
+
+func findAlgoOrder(c *fiber.Ctx) (*models.AlgoOrder, error) {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	uuid, err := uuid.Parse(c.Params("uuid"))
+	if err != nil {
+		return nil, c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"algo_order.invaild_uuid"},
+		})
+	}
+
+	var algo_order *models.AlgoOrder
+	if result := config.DataBase.Where("uuid = ? AND member_id = ?", uuid, CurrentUser.ID).First(&algo_order); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	return algo_order, nil
+}
+
+// GetAlgoOrderByUUID returns an algo order with the orders it placed.
+func GetAlgoOrderByUUID(c *fiber.Ctx) error {
+	algo_order, err := findAlgoOrder(c)
+	if algo_order == nil {
+		return err
+	}
+
+	children := make([]entities.Versioned, 0)
+	for _, child := range algo_order.Children() {
+		children = append(children, entities.Serialize(child.ToJSON(), helpers.APIVersion(c)))
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"algo_order": algo_order.ToJSON(),
+		"orders":     children,
+	})
+}
+
+// CancelAlgoOrderByUUID stops placing slices and cancels the open ones.
+func CancelAlgoOrderByUUID(c *fiber.Ctx) error {
+	algo_order, err := findAlgoOrder(c)
+	if algo_order == nil {
+		return err
+	}
+
+	if err := algo_order.Cancel(); err != nil {
+		if errors.Is(err, models.ErrAlgoOrderNotActive) {
+			return c.Status(422).JSON(helpers.Errors{
+				Errors: []string{err.Error()},
+			})
+		}
+
+		config.Logger.Errorf("Failed to cancel algo order %s: %v", algo_order.UUID, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"algo_order.cancel_error"},
+		})
+	}
+
+	return c.Status(200).JSON(algo_order.ToJSON())
+}
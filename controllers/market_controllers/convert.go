@@ -0,0 +1,58 @@
+package market_controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+// CreateConvertQuote returns a firm quote converting an amount of a currency to another, it expires after convert.quote_ttl.
+func CreateConvertQuote(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	payload := new(helpers.ConvertQuoteParams)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	errs := new(helpers.Errors)
+	helpers.Vaildate(payload, errs)
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	quote := payload.CreateQuote(CurrentUser, errs)
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	return c.Status(201).JSON(quote.ToJSON())
+}
+
+// AcceptConvertQuote converts at a quote of the member, once and before it expires.
+func AcceptConvertQuote(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	payload := new(helpers.ConvertAcceptParams)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	errs := new(helpers.Errors)
+	helpers.Vaildate(payload, errs)
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	quote := payload.Accept(CurrentUser, errs)
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	return c.Status(200).JSON(quote.ToJSON())
+}
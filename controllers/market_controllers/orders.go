@@ -2,7 +2,6 @@ package market_controllers
 
 import (
 	"errors"
-	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -13,6 +12,7 @@ import (
 	"github.com/zsmartex/finex/controllers/entities"
 	"github.com/zsmartex/finex/controllers/helpers"
 	"github.com/zsmartex/finex/controllers/queries"
+	"github.com/zsmartex/finex/events"
 	"github.com/zsmartex/finex/models"
 	"github.com/zsmartex/finex/types"
 
@@ -40,7 +40,36 @@ func CreateOrder(c *fiber.Ctx) error {
 		return c.Status(422).JSON(errors)
 	}
 
-	return c.Status(201).JSON(order.ToJSON())
+	return c.Status(201).JSON(entities.Serialize(order.ToJSON(), helpers.APIVersion(c)))
+}
+
+// CreateOrderBatch places and cancels orders of a market in one batch the engine processes in one cycle, see
+// docs/order_batch.md.
+func CreateOrderBatch(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	errors := new(helpers.Errors)
+	payload := new(helpers.BatchOrderParams)
+
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	batch := payload.PlaceBatch(CurrentUser, errors)
+	if errors.Size() > 0 {
+		if batch != nil {
+			return c.Status(422).JSON(fiber.Map{
+				"errors": errors.Errors,
+				"batch":  entities.Serialize(batch, helpers.APIVersion(c)),
+			})
+		}
+
+		return c.Status(422).JSON(errors)
+	}
+
+	return c.Status(201).JSON(entities.Serialize(batch, helpers.APIVersion(c)))
 }
 
 func GetOrders(c *fiber.Ctx) error {
@@ -115,13 +144,7 @@ func GetOrders(c *fiber.Ctx) error {
 		tx = tx.Where("created_at < ?", time_to)
 	}
 
-	if params.Limit == 0 {
-		params.Limit = 100
-	}
-
-	if params.Page == 0 {
-		params.Page = 1
-	}
+	helpers.PageDefaults(&params.Page, &params.Limit)
 
 	tx = tx.Offset(params.Page*params.Limit - params.Limit).Limit(params.Limit)
 
@@ -131,10 +154,9 @@ func GetOrders(c *fiber.Ctx) error {
 		orders_json = append(orders_json, order.ToJSON())
 	}
 
-	c.Response().Header.Add("page", strconv.FormatInt(int64(params.Page), 10))
-	c.Response().Header.Add("per-page", strconv.FormatInt(int64(len(orders)), 10))
+	helpers.SetPageHeaders(c, params.Page, params.Limit)
 
-	return c.Status(200).JSON(orders_json)
+	return helpers.RenderList(c, 200, orders_json)
 }
 
 func GetOrderByUUID(c *fiber.Ctx) error {
@@ -156,7 +178,68 @@ func GetOrderByUUID(c *fiber.Ctx) error {
 		})
 	}
 
-	return c.Status(200).JSON(order.ToJSON())
+	return c.Status(200).JSON(entities.Serialize(order.ToJSON(), helpers.APIVersion(c)))
+}
+
+// GetOrderByClientID returns the pending or open order the member placed with a client id.
+func GetOrderByClientID(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	client_id, err := uuid.Parse(c.Params("client_id"))
+	if err != nil {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"market.order.invalid_client_id"},
+		})
+	}
+
+	order := models.OpenOrderByClientID(config.DataBase, CurrentUser.ID, client_id)
+	if order == nil {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	return c.Status(200).JSON(entities.Serialize(order.ToJSON(), helpers.APIVersion(c)))
+}
+
+// GetOrderPositionByUUID returns what's ahead of an open order in the queue of its side of the book.
+func GetOrderPositionByUUID(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	uuid, err := uuid.Parse(c.Params("uuid"))
+	if err != nil {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"market.order.invaild_uuid"},
+		})
+	}
+
+	var order *models.Order
+
+	result := config.DataBase.Where("uuid = ? AND member_id = ?", uuid, CurrentUser.ID).First(&order)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	if order.State != models.StateWait {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{helpers.ErrOrderNotInBook.Error()},
+		})
+	}
+
+	position, err := helpers.FetchQueuePosition(order.MarketID, order.ID)
+	if errors.Is(err, helpers.ErrOrderNotInBook) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	} else if err != nil {
+		return c.Status(503).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	}
+
+	return c.Status(200).JSON(position)
 }
 
 func CancelOrderByUUID(c *fiber.Ctx) error {
@@ -179,12 +262,112 @@ func CancelOrderByUUID(c *fiber.Ctx) error {
 	}
 
 	// Doing cancel
-	config.KafkaProducer.Produce("matching", map[string]interface{}{
+	config.Bus.Publish("matching", order.MarketID, map[string]interface{}{
+		"action": pkg.ActionCancel,
+		"order":  order.ToMatchingAttributes(),
+	})
+
+	return c.Status(200).JSON(entities.Serialize(order.ToJSON(), helpers.APIVersion(c)))
+}
+
+// CancelOrderByClientID cancels the open order the member placed with a client id.
+func CancelOrderByClientID(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	client_id, err := uuid.Parse(c.Params("client_id"))
+	if err != nil {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"market.order.invalid_client_id"},
+		})
+	}
+
+	order := models.OpenOrderByClientID(config.DataBase, CurrentUser.ID, client_id)
+	if order == nil {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	config.Bus.Publish("matching", order.MarketID, map[string]interface{}{
 		"action": pkg.ActionCancel,
 		"order":  order.ToMatchingAttributes(),
 	})
 
-	return c.Status(200).JSON(order.ToJSON())
+	return c.Status(200).JSON(entities.Serialize(order.ToJSON(), helpers.APIVersion(c)))
+}
+
+// ReplaceOrderByUUID atomically cancels an open order and submits its replacement,
+// the replacement is rejected when the order already left the book.
+func ReplaceOrderByUUID(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	uuid, err := uuid.Parse(c.Params("uuid"))
+	if err != nil {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"market.order.invaild_uuid"},
+		})
+	}
+
+	payload := new(helpers.ReplaceOrderParams)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	var order *models.Order
+
+	result := config.DataBase.Where("uuid = ? AND member_id = ?", uuid, CurrentUser.ID).First(&order)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	errs := new(helpers.Errors)
+	replacement := payload.ReplaceOrder(CurrentUser, order, errs)
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	return c.Status(201).JSON(entities.Serialize(replacement.ToJSON(), helpers.APIVersion(c)))
+}
+
+// AmendOrderByUUID amends the price or the quantity of an open limit order, the order keeps its place in the book
+// when only its quantity is reduced.
+func AmendOrderByUUID(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	uuid, err := uuid.Parse(c.Params("uuid"))
+	if err != nil {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"market.order.invaild_uuid"},
+		})
+	}
+
+	payload := new(helpers.AmendOrderParams)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	var order *models.Order
+
+	result := config.DataBase.Where("uuid = ? AND member_id = ?", uuid, CurrentUser.ID).First(&order)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	errs := new(helpers.Errors)
+	amended := payload.AmendOrder(order, errs)
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	return c.Status(200).JSON(entities.Serialize(amended.ToJSON(), helpers.APIVersion(c)))
 }
 
 func CancelAllOrders(c *fiber.Ctx) error {
@@ -223,19 +406,115 @@ func CancelAllOrders(c *fiber.Ctx) error {
 
 	tx.Find(&orders)
 
+	// the event is written before the cancels are sent, a cancel all is never missing from the feed
+	security_event, err := models.RecordSecurityEvent(config.DataBase, CurrentUser.ID, models.SecurityEventCancelAll, "", map[string]interface{}{
+		"market": params.Market,
+		"side":   params.Side,
+		"orders": len(orders),
+	})
+	if err != nil {
+		config.Logger.Errorf("Failed to record the cancel all of member %d: %v", CurrentUser.ID, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"market.orders.cancel_all_failed"},
+		})
+	}
+
 	for _, order := range orders {
 		// Doing cancel
-		config.KafkaProducer.Produce("matching", map[string]interface{}{
+		config.Bus.Publish("matching", order.MarketID, map[string]interface{}{
 			"action": pkg.ActionCancel,
 			"order":  order.ToMatchingAttributes(),
 		})
 	}
 
-	var ordersJSON []entities.OrderEntity
+	models.NotifySecurityEvents([]*models.SecurityEvent{security_event})
+
+	ordersJSON := make([]entities.OrderEntity, 0, len(orders))
 
 	for _, order := range orders {
 		ordersJSON = append(ordersJSON, order.ToJSON())
 	}
 
-	return c.Status(201).JSON(ordersJSON)
+	return helpers.RenderList(c, 201, ordersJSON)
+}
+
+// DeleteOrders cancels the open orders of the member, of a market and a side when they're given, with one command
+// the engine processes in one cycle. It responds with the orders open when the command was sent, the ones filled
+// meanwhile aren't cancelled.
+func DeleteOrders(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	params := new(queries.CancelOrderParams)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	cancel_all := &events.CancelAll{MemberID: CurrentUser.ID}
+	tx := config.DataBase.Model(&models.Order{}).Where("member_id = ? AND state = ?", CurrentUser.ID, models.StateWait)
+
+	var symbol pkg.Symbol
+	if len(params.Market) > 0 {
+		var market *models.Market
+		if result := config.DataBase.First(&market, "symbol = ?", params.Market); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return c.Status(422).JSON(helpers.Errors{
+				Errors: []string{"market.order.invalid_market"},
+			})
+		}
+
+		symbol = market.GetSymbol()
+		tx = tx.Where("market_id = ?", params.Market)
+	}
+
+	switch params.Side {
+	case "":
+	case types.TypeBuy:
+		cancel_all.Side = pkg.SideBuy
+		tx = tx.Where("type = ?", models.SideBuy)
+	case types.TypeSell:
+		cancel_all.Side = pkg.SideSell
+		tx = tx.Where("type = ?", models.SideSell)
+	default:
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"market.orders.invalid_side"},
+		})
+	}
+
+	ids := make([]int64, 0)
+	tx.Order("id asc").Pluck("id", &ids)
+
+	// the event is written before the command is sent, a cancel all is never missing from the feed
+	security_event, err := models.RecordSecurityEvent(config.DataBase, CurrentUser.ID, models.SecurityEventCancelAll, "", map[string]interface{}{
+		"market": params.Market,
+		"side":   params.Side,
+		"orders": len(ids),
+	})
+	if err != nil {
+		config.Logger.Errorf("Failed to record the cancel all of member %d: %v", CurrentUser.ID, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"market.orders.cancel_all_failed"},
+		})
+	}
+
+	if err := config.Bus.Publish("matching", params.Market, map[string]interface{}{
+		"action":     events.ActionCancelAll,
+		"symbol":     symbol,
+		"cancel_all": cancel_all,
+	}); err != nil {
+		config.Logger.Errorf("Failed to send the cancel all of member %d: %v", CurrentUser.ID, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"market.orders.cancel_all_failed"},
+		})
+	}
+
+	models.NotifySecurityEvents([]*models.SecurityEvent{security_event})
+
+	return c.Status(200).JSON(fiber.Map{
+		"count": len(ids),
+		"ids":   ids,
+	})
 }
@@ -0,0 +1,117 @@
+package market_controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/controllers/queries"
+	"github.com/zsmartex/finex/models"
+)
+
+// CreateScheduledOrder schedules an order, it's placed at its execute_at.
+func CreateScheduledOrder(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	payload := new(helpers.CreateScheduledOrderParams)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	errs := new(helpers.Errors)
+	helpers.Vaildate(payload, errs)
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	scheduled_order := payload.CreateScheduledOrder(CurrentUser, errs)
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	return c.Status(201).JSON(scheduled_order.ToJSON())
+}
+
+func GetScheduledOrders(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	params := new(queries.ScheduledOrderFilters)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	if params.Limit == 0 {
+		params.Limit = 100
+	}
+
+	if params.Page == 0 {
+		params.Page = 1
+	}
+
+	tx := config.DataBase.Where("member_id = ?", CurrentUser.ID).Order("execute_at desc, id desc")
+	if len(params.Market) > 0 {
+		tx = tx.Where("market_id = ?", params.Market)
+	}
+
+	if len(params.State) > 0 {
+		tx = tx.Where("state = ?", params.State)
+	}
+
+	var scheduled_orders []*models.ScheduledOrder
+	tx.Offset(params.Page*params.Limit - params.Limit).Limit(params.Limit).Find(&scheduled_orders)
+
+	scheduled_orders_json := make([]entities.ScheduledOrderEntity, 0, len(scheduled_orders))
+	for _, scheduled_order := range scheduled_orders {
+		scheduled_orders_json = append(scheduled_orders_json, scheduled_order.ToJSON())
+	}
+
+	c.Response().Header.Add("page", strconv.FormatInt(int64(params.Page), 10))
+	c.Response().Header.Add("per-page", strconv.FormatInt(int64(params.Limit), 10))
+
+	return c.Status(200).JSON(scheduled_orders_json)
+}
+
+// CancelScheduledOrderByUUID cancels an order which wasn't placed yet and unlocks its funds.
+func CancelScheduledOrderByUUID(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	uuid, err := uuid.Parse(c.Params("uuid"))
+	if err != nil {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"scheduled_order.invaild_uuid"},
+		})
+	}
+
+	var scheduled_order *models.ScheduledOrder
+	if result := config.DataBase.Where("uuid = ? AND member_id = ?", uuid, CurrentUser.ID).First(&scheduled_order); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	if err := models.CloseScheduledOrder(scheduled_order, models.ScheduledOrderStateCancelled, ""); err != nil {
+		if errors.Is(err, models.ErrScheduledOrderNotScheduled) {
+			return c.Status(422).JSON(helpers.Errors{
+				Errors: []string{err.Error()},
+			})
+		}
+
+		config.Logger.Errorf("Failed to cancel scheduled order %s: %v", scheduled_order.UUID, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"scheduled_order.cancel_error"},
+		})
+	}
+
+	return c.Status(200).JSON(scheduled_order.ToJSON())
+}
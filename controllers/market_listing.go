@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/types"
+)
+
+func MarketListingToEntity(market *models.Market, now time.Time) *entities.MarketListingEntity {
+	entity := &entities.MarketListingEntity{
+		Market:     market.Symbol,
+		Phase:      string(market.ListingPhase(now)),
+		ServerTime: now,
+	}
+
+	if market.ListingOpensAt.Valid {
+		entity.WarmupAt = &market.ListingWarmupAt.Time
+		entity.OpensAt = &market.ListingOpensAt.Time
+	}
+
+	return entity
+}
+
+// GetMarketListing returns the listing schedule of a market and the phase it's in.
+func GetMarketListing(c *fiber.Ctx) error {
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", c.Params("market")); errors.Is(result.Error, gorm.ErrRecordNotFound) || !models.MarketVisibility.Visible(helpers.MarketGroup(c), market.Symbol) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"public.market.doesnt_exist"},
+		})
+	}
+
+	return c.Status(200).JSON(MarketListingToEntity(market, time.Now()))
+}
+
+// GetUpcomingListings lists the announced listings of the group of the request which aren't open yet, the next first.
+func GetUpcomingListings(c *fiber.Ctx) error {
+	now := time.Now()
+
+	var markets []*models.Market
+	config.DataBase.
+		Where("state = ? AND listing_opens_at > ?", types.MarketStateEndabled, now).
+		Order("listing_opens_at asc").
+		Find(&markets)
+
+	group := helpers.MarketGroup(c)
+	listings := make([]*entities.MarketListingEntity, 0, len(markets))
+	for _, market := range markets {
+		if market.ListingPhase(now) != models.ListingPhaseHidden && models.MarketVisibility.Visible(group, market.Symbol) {
+			listings = append(listings, MarketListingToEntity(market, now))
+		}
+	}
+
+	return helpers.RenderList(c, 200, listings)
+}
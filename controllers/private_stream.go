@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/routes/middlewares"
+	"github.com/zsmartex/finex/streams"
+)
+
+// privateStreamWriteTimeout is how long a write waits on a client, a client not reading is closed.
+const privateStreamWriteTimeout = 10 * time.Second
+
+var (
+	ErrPrivateStreamUpgradeRequired = errors.New("stream.websocket.upgrade_required")
+	ErrPrivateStreamUnknown         = errors.New("stream.unknown")
+	ErrPrivateStreamInvalidRequest  = errors.New("stream.request.invalid")
+	ErrPrivateStreamUnknownEvent    = errors.New("stream.request.unknown_event")
+
+	errPrivateStreamInternal = errors.New(middlewares.ServerInternalError)
+	errPrivateStreamToken    = errors.New(middlewares.JwtDecodeAndVerify)
+)
+
+func privateStreamsOf(names []string) ([]string, error) {
+	subscriptions := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+
+		known := false
+		for _, stream := range entities.PrivateStreams {
+			known = known || stream == name
+		}
+
+		if !known {
+			return nil, ErrPrivateStreamUnknown
+		}
+
+		subscriptions = append(subscriptions, name)
+	}
+
+	return subscriptions, nil
+}
+
+// privateStreamToken verifies a token of a member, the error is the one rendered to the client.
+func privateStreamToken(token string) (*middlewares.Auth, error) {
+	auth, err := middlewares.ParseToken(token)
+
+	var validation_error *jwt.ValidationError
+	switch {
+	case errors.Is(err, middlewares.ErrPublicKey):
+		config.Logger.Errorf("Failed to verify the token of a private stream: %v", err)
+
+		return nil, errPrivateStreamInternal
+	case errors.As(err, &validation_error) && validation_error.Errors&jwt.ValidationErrorExpired != 0:
+		return nil, models.ErrStreamAuthExpired
+	case err != nil:
+		return nil, errPrivateStreamToken
+	}
+
+	return auth, nil
+}
+
+// ConnectPrivateStream upgrades a request of a member to a websocket receiving their private streams. The request
+// carries the JWT of the REST API, in the Authorization header or in the token parameter since browsers don't set
+// headers on a websocket, and the streams to start with in the streams parameter, separated by commas.
+func ConnectPrivateStream(c *fiber.Ctx) error {
+	key := c.Get(fiber.HeaderSecWebSocketKey)
+	if !strings.EqualFold(c.Get("Upgrade"), "websocket") || len(key) == 0 || c.Get(fiber.HeaderSecWebSocketVersion) != "13" {
+		c.Set(fiber.HeaderSecWebSocketVersion, "13")
+
+		return c.Status(426).JSON(helpers.Errors{
+			Errors: []string{ErrPrivateStreamUpgradeRequired.Error()},
+		})
+	}
+
+	token := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+	if len(token) == 0 {
+		token = c.Query("token")
+	}
+
+	if len(token) == 0 {
+		return c.Status(401).JSON(helpers.Errors{
+			Errors: []string{middlewares.AuthzInvalidSession},
+		})
+	}
+
+	auth, err := privateStreamToken(token)
+	if err != nil {
+		status := 422
+		if errors.Is(err, errPrivateStreamInternal) {
+			status = 500
+		}
+
+		return c.Status(status).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	}
+
+	subscriptions, err := privateStreamsOf(strings.Split(c.Query("streams"), ","))
+	if err != nil {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{err.Error()},
+		})
+	}
+
+	hub := streams.PrivateHub
+	if hub == nil {
+		return c.Status(503).JSON(helpers.Errors{
+			Errors: []string{errPrivateStreamInternal.Error()},
+		})
+	}
+
+	session := &privateStreamSession{
+		hub:     hub,
+		session: models.NewStreamSession(auth.UID, models.StreamAuthJWT, time.Unix(auth.ExpiresAt, 0)),
+	}
+
+	c.Status(101)
+	c.Set("Upgrade", "websocket")
+	c.Set(fiber.HeaderConnection, "Upgrade")
+	c.Set(fiber.HeaderSecWebSocketAccept, streams.WebsocketAccept(key))
+
+	c.Context().Hijack(func(conn net.Conn) {
+		session.serve(streams.NewWebsocket(conn, privateStreamWriteTimeout), subscriptions)
+	})
+
+	return nil
+}
+
+// privateStreamSession is a websocket connection of a member to the hub. Its reader takes the requests of the
+// client, its writer sends the events of the hub, and its keepalive pings the client and notices the expiry of
+// the token as the gateway does, see docs/stream_auth.md.
+type privateStreamSession struct {
+	hub       *streams.Hub
+	websocket *streams.Websocket
+	conn      *streams.PrivateConn
+
+	mutex   sync.Mutex
+	session *models.StreamSession
+}
+
+func (s *privateStreamSession) serve(websocket *streams.Websocket, subscriptions []string) {
+	s.websocket = websocket
+	s.conn = s.hub.Connect(s.session.UID)
+	s.conn.Subscribe(subscriptions...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer s.hub.Disconnect(s.conn)
+
+	go s.write(ctx)
+	go s.keepalive(ctx)
+
+	s.read()
+}
+
+func (s *privateStreamSession) send(message interface{}) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	return s.websocket.WriteText(payload)
+}
+
+func (s *privateStreamSession) reply(event string, err error) error {
+	subscriptions := s.conn.Streams()
+	sort.Strings(subscriptions)
+
+	reply := entities.PrivateStreamReply{Event: event, Streams: subscriptions}
+	if err != nil {
+		reply.Event = "error"
+		reply.Error = err.Error()
+	}
+
+	return s.send(reply)
+}
+
+// read handles the requests of the client until it closes the connection or fails to keep it alive.
+func (s *privateStreamSession) read() {
+	for {
+		_, payload, err := s.websocket.ReadMessage(streams.PongTimeout())
+		switch {
+		case errors.Is(err, io.EOF):
+			s.websocket.Close(streams.CloseNormal, "")
+			return
+		case errors.Is(err, streams.ErrWebsocketTooLarge):
+			s.websocket.Close(streams.CloseTooLarge, err.Error())
+			return
+		case errors.Is(err, streams.ErrWebsocketProtocol):
+			s.websocket.Close(streams.CloseProtocolError, err.Error())
+			return
+		case err != nil:
+			s.websocket.Close(streams.CloseNormal, "")
+			return
+		}
+
+		var request *entities.PrivateStreamRequest
+		if json.Unmarshal(payload, &request) != nil || request == nil {
+			err = s.reply("", ErrPrivateStreamInvalidRequest)
+		} else {
+			err = s.handle(request)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handle answers a request, it returns an error once the connection is to be closed.
+func (s *privateStreamSession) handle(request *entities.PrivateStreamRequest) error {
+	switch request.Event {
+	case "subscribe", "unsubscribe":
+		subscriptions, err := privateStreamsOf(request.Streams)
+		if err != nil {
+			return s.reply("", err)
+		}
+
+		if request.Event == "subscribe" {
+			s.conn.Subscribe(subscriptions...)
+			return s.reply("subscribed", nil)
+		}
+
+		s.conn.Unsubscribe(subscriptions...)
+		return s.reply("unsubscribed", nil)
+	case "auth":
+		auth, err := privateStreamToken(request.Token)
+		if err != nil {
+			return s.reply("", err)
+		}
+
+		s.mutex.Lock()
+		err = s.session.Refresh(auth.UID, time.Unix(auth.ExpiresAt, 0), time.Now())
+		s.mutex.Unlock()
+
+		if errors.Is(err, models.ErrStreamAuthMemberMismatch) {
+			config.Logger.Warnf("Closed the private stream of %s refreshed with a token of %s", s.session.UID, auth.UID)
+
+			s.reply("", err)
+			s.websocket.Close(streams.ClosePolicyViolation, err.Error())
+
+			return err
+		}
+
+		return s.reply("authenticated", err)
+	default:
+		return s.reply("", ErrPrivateStreamUnknownEvent)
+	}
+}
+
+// write sends the events queued by the hub, the ones coming after the token expired are dropped until the client
+// sends a fresh one. A connection the hub closed for being too slow is closed, the client replays what it missed.
+func (s *privateStreamSession) write(ctx context.Context) {
+	for {
+		message, err := s.conn.Next(ctx)
+		if errors.Is(err, streams.ErrSlowConsumer) {
+			s.websocket.Close(streams.CloseTryAgainLater, err.Error())
+			return
+		} else if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		receives := s.session.Receives(true, time.Now())
+		s.mutex.Unlock()
+
+		if !receives {
+			continue
+		}
+
+		payload, _ := message.Payload.(json.RawMessage)
+		if err := s.websocket.WriteText(payload); err != nil {
+			s.websocket.Close(streams.CloseNormal, "")
+			return
+		}
+	}
+}
+
+func (s *privateStreamSession) keepalive(ctx context.Context) {
+	ticker := time.NewTicker(streams.PingInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mutex.Lock()
+			notices := s.session.Tick(now, config.StreamAuth.ExpiringNotice)
+			expires_at := s.session.ExpiresAt.Unix()
+			s.mutex.Unlock()
+
+			for _, notice := range notices {
+				message := map[string]interface{}{string(notice): struct{}{}}
+				if notice == models.StreamNoticeAuthExpiring {
+					message[string(notice)] = map[string]int64{"expires_at": expires_at}
+				}
+
+				s.send(message)
+			}
+
+			if err := s.websocket.Ping(); err != nil {
+				s.websocket.Close(streams.CloseNormal, "")
+				return
+			}
+		}
+	}
+}
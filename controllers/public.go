@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -17,6 +19,7 @@ import (
 	"github.com/zsmartex/finex/controllers/entities"
 	"github.com/zsmartex/finex/controllers/helpers"
 	"github.com/zsmartex/finex/controllers/queries"
+	"github.com/zsmartex/finex/matching"
 	"github.com/zsmartex/finex/models"
 	"github.com/zsmartex/finex/types"
 	engineGrpc "github.com/zsmartex/pkg/Grpc/engine"
@@ -52,10 +55,17 @@ func GetTimestamp(c *fiber.Ctx) error {
 	return nil
 }
 
+// GetIEOList lists the enabled IEOs, and the archived ones with include_archived.
 func GetIEOList(c *fiber.Ctx) error {
-	var lst_ieo []*models.IEO
+	params := new(queries.ArchivedQuery)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
 
-	config.DataBase.Find(&lst_ieo, "state = ?", types.MarketStateEndabled)
+	var lst_ieo []*models.IEO
+	config.DataBase.Find(&lst_ieo, "state IN ?", models.ArchivedStates([]types.MarketState{types.MarketStateEndabled}, params.IncludeArchived))
 
 	ieo_entities := make([]*entities.IEO, 0)
 
@@ -63,7 +73,7 @@ func GetIEOList(c *fiber.Ctx) error {
 		ieo_entities = append(ieo_entities, IEOToEntity(ieo))
 	}
 
-	return c.Status(200).JSON(ieo_entities)
+	return helpers.RenderList(c, 200, ieo_entities)
 }
 
 func GetIEO(c *fiber.Ctx) error {
@@ -101,35 +111,66 @@ func GetDepth(c *fiber.Ctx) error {
 		return c.Status(422).JSON(errs)
 	}
 
+	// markets of other groups don't exist for the request
 	var market *models.Market
-	if result := config.DataBase.First(&market, "symbol = ?", marketID); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+	if result := config.DataBase.First(&market, "symbol = ?", marketID); errors.Is(result.Error, gorm.ErrRecordNotFound) || !models.MarketVisibility.Visible(helpers.MarketGroup(c), market.Symbol) {
 		return c.Status(422).JSON(helpers.Errors{
 			Errors: []string{"public.market.doesnt_exist"},
 		})
 	}
 
-	matching_client := clientEngine.NewMatchingClient()
-	defer matching_client.Close()
+	if !market.ValidDepthStep(params.Step) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"public.market_depth.invalid_step"},
+		})
+	}
 
 	if params.Limit == 0 {
 		params.Limit = 100
 	}
 
-	depth := pkg.DepthJSON{
-		Asks:     [][]decimal.Decimal{},
-		Bids:     [][]decimal.Decimal{},
-		Sequence: 0,
+	// a top-N snapshot has the levels of its format whatever the limit
+	if len(params.Format) > 0 {
+		params.Limit = int64(matching.DepthTops[params.Format])
+	}
+
+	limit, capped := helpers.MarketDataPolicy(c).DepthLimit(params.Limit)
+
+	depth := entities.DepthEntity{
+		Asks:      [][]decimal.Decimal{},
+		Bids:      [][]decimal.Decimal{},
+		Sequence:  0,
+		Timestamp: time.Now().UnixMilli(),
 	}
 	symbol := market.GetSymbol()
+
+	// the engine groups the levels, the gRPC request has no step
+	if params.Step.IsPositive() {
+		grouped, err := helpers.FetchGroupedDepth(market.Symbol, limit, params.Step)
+		if err != nil {
+			config.Logger.Errorf("Failed to fetch %s depth grouped by %s, Error: %v", symbol.String(), params.Step, err)
+
+			return c.Status(200).JSON(entities.Serialize(depth, helpers.APIVersion(c)))
+		}
+
+		depth.Asks, depth.Bids, depth.Sequence = grouped.Asks, grouped.Bids, grouped.Sequence
+		depth.Truncated = capped && (int64(len(depth.Asks)) >= limit || int64(len(depth.Bids)) >= limit)
+
+		return c.Status(200).JSON(entities.Serialize(depth, helpers.APIVersion(c)))
+	}
+
+	matching_client := clientEngine.NewMatchingClient()
+	defer matching_client.Close()
+
 	fetch_orderbook_response, err := matching_client.FetchOrderBook(&engineGrpc.FetchOrderBookRequest{
 		Symbol: &GrpcSymbol.Symbol{BaseCurrency: symbol.BaseCurrency, QuoteCurrency: symbol.QuoteCurrency},
-		Limit:  params.Limit,
+		Limit:  limit,
 	})
 	if err != nil {
 		log.Println(err)
 		config.Logger.Errorf("Failed to fetch %s depth, Error: %v", symbol.String(), err)
 
-		return c.Status(200).JSON(depth)
+		return c.Status(200).JSON(entities.Serialize(depth, helpers.APIVersion(c)))
 	}
 
 	for _, bookOrder := range fetch_orderbook_response.Asks {
@@ -147,8 +188,204 @@ func GetDepth(c *fiber.Ctx) error {
 	}
 
 	depth.Sequence = fetch_orderbook_response.Sequence
+	depth.Truncated = capped && (int64(len(depth.Asks)) >= limit || int64(len(depth.Bids)) >= limit)
+
+	return c.Status(200).JSON(entities.Serialize(depth, helpers.APIVersion(c)))
+}
+
+// GetBookTicker serves the best prices of a market, and with extended=true the imbalance and the microprice
+// of its top matching.BookSignalLevels levels.
+func GetBookTicker(c *fiber.Ctx) error {
+	params := new(queries.BookTickerQuery)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", c.Params("market")); errors.Is(result.Error, gorm.ErrRecordNotFound) || !models.MarketVisibility.Visible(helpers.MarketGroup(c), market.Symbol) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"public.market.doesnt_exist"},
+		})
+	}
+
+	matching_client := clientEngine.NewMatchingClient()
+	defer matching_client.Close()
+
+	symbol := market.GetSymbol()
+	fetch_orderbook_response, err := matching_client.FetchOrderBook(&engineGrpc.FetchOrderBookRequest{
+		Symbol: &GrpcSymbol.Symbol{BaseCurrency: symbol.BaseCurrency, QuoteCurrency: symbol.QuoteCurrency},
+		Limit:  matching.BookSignalLevels,
+	})
+	if err != nil {
+		config.Logger.Errorf("Failed to fetch %s ticker, Error: %v", symbol.String(), err)
+
+		return c.Status(503).JSON(helpers.Errors{
+			Errors: []string{"public.market_ticker.unavailable"},
+		})
+	}
+
+	asks := make([][]decimal.Decimal, 0, len(fetch_orderbook_response.Asks))
+	for _, bookOrder := range fetch_orderbook_response.Asks {
+		asks = append(asks, []decimal.Decimal{bookOrder.PriceQuantity[0].ToDecimal(), bookOrder.PriceQuantity[1].ToDecimal()})
+	}
+
+	bids := make([][]decimal.Decimal, 0, len(fetch_orderbook_response.Bids))
+	for _, bookOrder := range fetch_orderbook_response.Bids {
+		bids = append(bids, []decimal.Decimal{bookOrder.PriceQuantity[0].ToDecimal(), bookOrder.PriceQuantity[1].ToDecimal()})
+	}
+
+	signals := matching.ComputeBookSignals(bids, asks)
+	ticker := entities.BookTickerEntity{
+		Market:    market.Symbol,
+		BestBid:   signals.BestBid,
+		BestAsk:   signals.BestAsk,
+		Sequence:  fetch_orderbook_response.Sequence,
+		Timestamp: time.Now().UnixMilli(),
+	}
+
+	if params.Extended {
+		ticker.Signals = &entities.BookSignalsEntity{
+			Imbalance:  signals.Imbalance,
+			Microprice: signals.Microprice,
+		}
+	}
+
+	return c.Status(200).JSON(ticker)
+}
+
+// defaultPublicTradesLimit is the number of recent trades served when the request doesn't ask for a number.
+const defaultPublicTradesLimit = 100
+
+// maxPublicTradesLimit is the largest number of recent trades a request can ask for.
+const maxPublicTradesLimit = 1000
 
-	return c.Status(200).JSON(depth)
+// GetPublicTrades lists the recent trades of a market, the newest first.
+func GetPublicTrades(c *fiber.Ctx) error {
+	var errs = new(helpers.Errors)
+
+	params := new(queries.PublicTradesQuery)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	helpers.Vaildate(params, errs)
+
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	if params.Limit > maxPublicTradesLimit {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"public.market_trades.too_many_trades"},
+		})
+	}
+
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", c.Params("market")); errors.Is(result.Error, gorm.ErrRecordNotFound) || !models.MarketVisibility.Visible(helpers.MarketGroup(c), market.Symbol) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"public.market.doesnt_exist"},
+		})
+	}
+
+	if params.Limit == 0 {
+		params.Limit = defaultPublicTradesLimit
+	}
+
+	limit, capped := helpers.MarketDataPolicy(c).TradesCount(params.Limit)
+
+	// the latest trades come from the tape of the engine, the older ones from the database
+	public_trades, before_id := tapePublicTrades(market, limit)
+
+	if int64(len(public_trades)) < limit {
+		query := config.DataBase.Where("market_id = ? AND reverted_at IS NULL", market.Symbol)
+		if before_id > 0 {
+			query = query.Where("id < ?", before_id)
+		}
+
+		var trades []*models.Trade
+		query.Order("id desc").Limit(int(limit) - len(public_trades)).Find(&trades)
+
+		for _, trade := range trades {
+			public_trades = append(public_trades, &entities.PublicTradeEntity{
+				ID:        trade.ID,
+				Price:     trade.Price,
+				Amount:    trade.Amount,
+				Total:     trade.Total,
+				TakerType: trade.TakerType,
+				CreatedAt: trade.CreatedAt,
+			})
+		}
+	}
+
+	entity := &entities.PublicTradesEntity{
+		Trades:    public_trades,
+		Truncated: capped && int64(len(public_trades)) >= limit,
+	}
+
+	return c.Status(200).JSON(entity)
+}
+
+// tapePublicTrades returns up to limit of the latest trades of market from the tape of its engine, the newest first,
+// and the id of the oldest one the trade executor created, the database has the trades before it. The trades of the
+// tape get their id from the trades the executor created with their sequence: the ones it didn't create yet have
+// none and the reverted ones are left out. It returns nothing when the engine can't be asked.
+func tapePublicTrades(market *models.Market, limit int64) ([]*entities.PublicTradeEntity, int64) {
+	public_trades := make([]*entities.PublicTradeEntity, 0, limit)
+	if limit > matching.TradeTapeSize {
+		limit = matching.TradeTapeSize
+	}
+
+	tape, err := helpers.FetchRecentTrades(market.Symbol, limit)
+	if err != nil || len(tape) == 0 {
+		return public_trades, 0
+	}
+
+	// the ID of a trade of the tape is its sequence in the market
+	var trades []*models.Trade
+	config.DataBase.
+		Where("market_id = ? AND trade_sequence >= ?", market.Symbol, tape[len(tape)-1].ID).
+		Find(&trades)
+
+	created := make(map[int64]*models.Trade, len(trades))
+	for _, trade := range trades {
+		created[trade.TradeSequence] = trade
+	}
+
+	before_id := int64(0)
+	for _, tape_trade := range tape {
+		public_trade := &entities.PublicTradeEntity{
+			Price:     tape_trade.Price,
+			Amount:    tape_trade.Amount,
+			Total:     tape_trade.Total,
+			TakerType: types.TypeBuy,
+			CreatedAt: tape_trade.MatchedAt,
+		}
+
+		if tape_trade.TakerSide == pkg.SideSell {
+			public_trade.TakerType = types.TypeSell
+		}
+
+		if trade, ok := created[tape_trade.ID]; ok {
+			if before_id == 0 || trade.ID < before_id {
+				before_id = trade.ID
+			}
+
+			if trade.RevertedAt.Valid {
+				continue
+			}
+
+			public_trade.ID = trade.ID
+			public_trade.CreatedAt = trade.CreatedAt
+		}
+
+		public_trades = append(public_trades, public_trade)
+	}
+
+	return public_trades, before_id
 }
 
 func GetGlobalPrice(c *fiber.Ctx) error {
@@ -173,3 +410,284 @@ func GetGlobalPrice(c *fiber.Ctx) error {
 
 	return c.Status(200).JSON(global_price)
 }
+
+func GetPriceSeries(c *fiber.Ctx) error {
+	var errs = new(helpers.Errors)
+
+	marketID := c.Params("market")
+	params := new(queries.PriceSeriesQuery)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	helpers.Vaildate(params, errs)
+
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	policy := helpers.MarketDataPolicy(c)
+	if len(params.Period) == 0 {
+		params.Period = policy.DefaultCandlePeriod("1h")
+	}
+
+	period, ok := models.PriceSeriesPeriods[params.Period]
+	if !ok {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"public.price_series.invalid_period"},
+		})
+	}
+
+	if !policy.AllowsCandlePeriod(params.Period) {
+		return c.Status(403).JSON(helpers.Errors{
+			Errors: []string{models.ErrMarketDataPeriodDenied.Error()},
+		})
+	}
+
+	if params.Points == 0 {
+		params.Points = 48
+	}
+
+	if params.Points > 500 {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"public.price_series.too_many_points"},
+		})
+	}
+
+	var market *models.Market
+	result := config.DataBase.First(&market, "symbol = ?", marketID)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) || result.Error == nil && !models.MarketVisibility.Visible(helpers.MarketGroup(c), market.Symbol) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"public.market.doesnt_exist"},
+		})
+	} else if result.Error != nil {
+		config.Logger.Errorf("Failed to fetch market %s: %v", marketID, result.Error)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"public.price_series.failed"},
+		})
+	}
+
+	// the last bucket is the one in progress
+	to := models.CandleBucket(time.Now(), period).Add(period)
+	from := to.Add(-time.Duration(params.Points) * period)
+	source_period := models.SourceCandlePeriod(period)
+
+	candles, err := models.QueryCandlesFromInflux(market.Symbol, source_period, from, to)
+	if err != nil {
+		config.Logger.Errorf("Failed to fetch %s candles for %s: %v", source_period, market.Symbol, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"public.price_series.failed"},
+		})
+	}
+
+	previous_close, err := models.QueryLastCandleCloseFromInflux(market.Symbol, source_period, from)
+	if err != nil {
+		config.Logger.Errorf("Failed to fetch the %s close of %s before %s: %v", source_period, market.Symbol, from, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"public.price_series.failed"},
+		})
+	}
+
+	series := make([][]interface{}, 0, params.Points)
+	for _, point := range models.DownsampleCandles(candles, from, period, params.Points, previous_close) {
+		series = append(series, []interface{}{point.Time.Unix(), point.Close, point.Volume})
+	}
+
+	// the series served depends on the market data tier of the user
+	c.Vary(fiber.HeaderAuthorization)
+	c.Set(fiber.HeaderCacheControl, "public, max-age="+strconv.Itoa(priceSeriesMaxAge(period)))
+
+	return c.Status(200).JSON(series)
+}
+
+// priceSeriesMaxAge caches the series for a tenth of its period, capped to one minute
+func priceSeriesMaxAge(period time.Duration) int {
+	max_age := period / 10
+	if max_age > time.Minute {
+		max_age = time.Minute
+	}
+
+	return int(max_age.Seconds())
+}
+
+// GetKLine serves the candles of a market built by the kline builder, each as
+// [time, open, high, low, close, volume, quote_volume]. The periods without trades carry the close before them.
+func GetKLine(c *fiber.Ctx) error {
+	var errs = new(helpers.Errors)
+
+	marketID := c.Params("market")
+	params := new(queries.KLineQuery)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	helpers.Vaildate(params, errs)
+
+	if errs.Size() > 0 {
+		return c.Status(422).JSON(errs)
+	}
+
+	policy := helpers.MarketDataPolicy(c)
+	if len(params.Period) == 0 {
+		params.Period = policy.DefaultCandlePeriod("1m")
+	}
+
+	if !models.KeptKlinePeriod(params.Period) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{models.ErrKlinePeriod.Error()},
+		})
+	}
+
+	if !policy.AllowsCandlePeriod(params.Period) {
+		return c.Status(403).JSON(helpers.Errors{
+			Errors: []string{models.ErrMarketDataPeriodDenied.Error()},
+		})
+	}
+
+	if params.Limit == 0 {
+		params.Limit = 100
+	}
+
+	if params.Limit > 1000 {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"public.k_line.too_many_candles"},
+		})
+	}
+
+	if params.TimeFrom > 0 && params.TimeTo > 0 && params.TimeTo < params.TimeFrom {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"public.k_line.invalid_range"},
+		})
+	}
+
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", marketID); errors.Is(result.Error, gorm.ErrRecordNotFound) || !models.MarketVisibility.Visible(helpers.MarketGroup(c), market.Symbol) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"public.market.doesnt_exist"},
+		})
+	}
+
+	from, to := klineRange(models.PriceSeriesPeriods[params.Period], params.TimeFrom, params.TimeTo, params.Limit, time.Now())
+
+	store := models.Klines()
+	klines, err := store.Klines(market.Symbol, params.Period, from, to)
+	if err != nil {
+		config.Logger.Errorf("Failed to read the klines of %s: %v", market.Symbol, err)
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.internal_error"},
+		})
+	}
+	previous_close := store.LastClose(market.Symbol, params.Period, from)
+
+	candles := make([][]interface{}, 0, params.Limit)
+	for _, kline := range models.FillKlines(klines, market.Symbol, params.Period, from, to, previous_close) {
+		candles = append(candles, []interface{}{kline.Time.Unix(), kline.Open, kline.High, kline.Low, kline.Close, kline.Volume, kline.QuoteVolume})
+	}
+
+	// the periods allowed depend on the market data tier of the user
+	c.Vary(fiber.HeaderAuthorization)
+	c.Set(fiber.HeaderCacheControl, "public, max-age="+strconv.Itoa(priceSeriesMaxAge(models.PriceSeriesPeriods[params.Period])))
+
+	return c.Status(200).JSON(candles)
+}
+
+// klineRange returns the periods of the candles served, at most limit of them: the ones from time_from on, or the
+// last ones up to time_to, up to the one in progress when it's not set.
+func klineRange(period time.Duration, time_from, time_to int64, limit int, now time.Time) (time.Time, time.Time) {
+	span := time.Duration(limit) * period
+	// the last bucket is the one in progress
+	last := models.CandleBucket(now, period).Add(period)
+
+	to := last
+	if time_to > 0 {
+		to = models.CandleBucket(time.Unix(time_to, 0), period).Add(period)
+	}
+
+	if time_from == 0 {
+		return to.Add(-span), to
+	}
+
+	from := models.CandleBucket(time.Unix(time_from, 0), period)
+	if time_to == 0 && from.Add(span).Before(last) {
+		to = from.Add(span)
+	}
+
+	if to.Sub(from) > span {
+		from = to.Add(-span)
+	}
+
+	return from, to
+}
+
+// GetVisibleStreams filters the public streams a websocket client subscribes to, the websocket gateway
+// asks for it on subscription so clients only receive the events of the markets of their group.
+func GetVisibleStreams(c *fiber.Ctx) error {
+	params := new(queries.StreamsQuery)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	streams := make([]string, 0)
+	for _, stream := range strings.Split(params.Streams, ",") {
+		if stream = strings.TrimSpace(stream); len(stream) > 0 {
+			streams = append(streams, stream)
+		}
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"streams": helpers.MarketDataPolicy(c).FilterStreams(models.MarketVisibility.VisibleStreams(helpers.MarketGroup(c), streams)),
+	})
+}
+
+func MarketToEntity(market *models.Market) *entities.MarketEntity {
+	return &entities.MarketEntity{
+		ID:              market.Symbol,
+		BaseUnit:        market.BaseUnit,
+		QuoteUnit:       market.QuoteUnit,
+		State:           market.State,
+		AmountPrecision: market.AmountPrecision,
+		PricePrecision:  market.PricePrecision,
+		MinPrice:        market.MinPrice,
+		MaxPrice:        market.MaxPrice,
+		MinAmount:       market.MinAmount,
+		MaxAmount:       market.MaxAmount,
+		MaxQuoteAmount:  market.MaxQuoteAmount,
+		LotSize:         market.LotSize,
+		MinNotional:     market.MinNotional,
+	}
+}
+
+// GetMarkets lists the trading rules of the markets of the group of the request, and the archived markets
+// with include_archived.
+func GetMarkets(c *fiber.Ctx) error {
+	params := new(queries.ArchivedQuery)
+	if err := c.QueryParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_query"},
+		})
+	}
+
+	var markets []*models.Market
+	config.DataBase.
+		Order("position asc").
+		Find(&markets, "state IN ?", models.ArchivedStates([]types.MarketState{types.MarketStateEndabled, types.MarketStateHalted}, params.IncludeArchived))
+
+	group := helpers.MarketGroup(c)
+	market_entities := make([]*entities.MarketEntity, 0, len(markets))
+	for _, market := range markets {
+		if models.MarketVisibility.Visible(group, market.Symbol) {
+			market_entities = append(market_entities, MarketToEntity(market))
+		}
+	}
+
+	return c.Status(200).JSON(market_entities)
+}
@@ -0,0 +1,6 @@
+package queries
+
+// ArchivedQuery is the filter of listings leaving out the archived IEOs and markets unless they're asked for.
+type ArchivedQuery struct {
+	IncludeArchived bool `query:"include_archived"`
+}
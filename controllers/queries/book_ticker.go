@@ -0,0 +1,15 @@
+package queries
+
+import "github.com/zsmartex/finex/controllers/helpers"
+
+type BookTickerQuery struct {
+	Extended bool `query:"extended"`
+}
+
+func (t BookTickerQuery) Messages() map[string]string {
+	return helpers.VaildateMessage("public.market_ticker")
+}
+
+func (t BookTickerQuery) Translates() map[string]string {
+	return helpers.VaildateTranslateFields()
+}
@@ -1,15 +1,36 @@
 package queries
 
-import "github.com/zsmartex/finex/controllers/helpers"
+import (
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/matching"
+)
 
 type DepthQuery struct {
 	Limit int64 `query:"limit" validate:"uint"`
+	// Format serves a top-N snapshot, top5 or top20, as the <market>.depth.<format> channel pushes it
+	Format string `query:"format" validate:"ValidateFormat"`
+	// Step groups the levels by a price step, one of the depth steps of the market, zero doesn't group them
+	Step decimal.Decimal `query:"step"`
 }
 
 func (t DepthQuery) Messages() map[string]string {
-	return helpers.VaildateMessage("public.market_depth")
+	messages := helpers.VaildateMessage("public.market_depth")
+	messages["ValidateFormat"] = "public.market_depth.invalid_format"
+
+	return messages
 }
 
 func (t DepthQuery) Translates() map[string]string {
 	return helpers.VaildateTranslateFields()
 }
+
+func (t DepthQuery) ValidateFormat(format string) bool {
+	if len(format) == 0 {
+		return true
+	}
+
+	_, ok := matching.DepthTops[format]
+	return ok
+}
@@ -0,0 +1,15 @@
+package queries
+
+import "github.com/zsmartex/finex/controllers/helpers"
+
+type InvoiceQueries struct {
+	Month string `query:"month" validate:"required"`
+}
+
+func (t InvoiceQueries) Messages() map[string]string {
+	return helpers.VaildateMessage("account.invoice")
+}
+
+func (t InvoiceQueries) Translates() map[string]string {
+	return helpers.VaildateTranslateFields()
+}
@@ -0,0 +1,19 @@
+package queries
+
+import "github.com/zsmartex/finex/controllers/helpers"
+
+type KLineQuery struct {
+	Period string `query:"period"`
+	// TimeFrom and TimeTo are unix timestamps bounding the candles, zero when they're not set
+	TimeFrom int64 `query:"time_from" validate:"uint"`
+	TimeTo   int64 `query:"time_to" validate:"uint"`
+	Limit    int   `query:"limit" validate:"uint"`
+}
+
+func (t KLineQuery) Messages() map[string]string {
+	return helpers.VaildateMessage("public.k_line")
+}
+
+func (t KLineQuery) Translates() map[string]string {
+	return helpers.VaildateTranslateFields()
+}
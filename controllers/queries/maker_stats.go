@@ -0,0 +1,16 @@
+package queries
+
+import "github.com/zsmartex/finex/controllers/helpers"
+
+type MakerStatsQuery struct {
+	Market string `query:"market" validate:"required"`
+	Period string `query:"period"`
+}
+
+func (t MakerStatsQuery) Messages() map[string]string {
+	return helpers.VaildateMessage("account.maker_stats")
+}
+
+func (t MakerStatsQuery) Translates() map[string]string {
+	return helpers.VaildateTranslateFields()
+}
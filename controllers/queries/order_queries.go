@@ -35,8 +35,8 @@ func (t OrderFilters) Translates() map[string]string {
 }
 
 type CancelOrderParams struct {
-	Market string          `json:"market" form:"market" validate:"ValidateType"`
-	Side   types.TakerType `json:"side" form:"side"`
+	Market string          `json:"market" form:"market" query:"market" validate:"ValidateType"`
+	Side   types.TakerType `json:"side" form:"side" query:"side"`
 }
 
 func (t CancelOrderParams) ValidateType(val types.TakerType) bool {
@@ -0,0 +1,16 @@
+package queries
+
+import "github.com/zsmartex/finex/controllers/helpers"
+
+type PriceSeriesQuery struct {
+	Period string `query:"period"`
+	Points int    `query:"points" validate:"uint"`
+}
+
+func (t PriceSeriesQuery) Messages() map[string]string {
+	return helpers.VaildateMessage("public.price_series")
+}
+
+func (t PriceSeriesQuery) Translates() map[string]string {
+	return helpers.VaildateTranslateFields()
+}
@@ -0,0 +1,15 @@
+package queries
+
+import "github.com/zsmartex/finex/controllers/helpers"
+
+type PublicTradesQuery struct {
+	Limit int64 `query:"limit" validate:"uint"`
+}
+
+func (t PublicTradesQuery) Messages() map[string]string {
+	return helpers.VaildateMessage("public.market_trades")
+}
+
+func (t PublicTradesQuery) Translates() map[string]string {
+	return helpers.VaildateTranslateFields()
+}
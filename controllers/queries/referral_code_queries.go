@@ -0,0 +1,19 @@
+package queries
+
+import "github.com/shopspring/decimal"
+
+type ReferralCodePayload struct {
+	// Code is generated when it's empty
+	Code  string `json:"code" form:"code"`
+	Label string `json:"label" form:"label"`
+	// FriendShare is the part of the commission given back to the friend as a fee discount, from 0 to 1
+	FriendShare decimal.Decimal `json:"friend_share" form:"friend_share"`
+	Default     bool            `json:"default" form:"default"`
+}
+
+// ReferralCodeUpdatePayload only updates the fields it's given.
+type ReferralCodeUpdatePayload struct {
+	Label       *string          `json:"label"`
+	FriendShare *decimal.Decimal `json:"friend_share"`
+	Default     *bool            `json:"default"`
+}
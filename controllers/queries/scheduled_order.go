@@ -0,0 +1,8 @@
+package queries
+
+type ScheduledOrderFilters struct {
+	Market string `query:"market"`
+	State  string `query:"state"`
+	Limit  int    `query:"limit" validate:"uint"`
+	Page   int    `query:"page" validate:"uint"`
+}
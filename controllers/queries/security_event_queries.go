@@ -0,0 +1,17 @@
+package queries
+
+import "github.com/zsmartex/finex/controllers/helpers"
+
+type SecurityEventQueries struct {
+	Kind  string `query:"kind"`
+	Limit int    `query:"limit" validate:"uint"`
+	Page  int    `query:"page" validate:"uint"`
+}
+
+func (t SecurityEventQueries) Messages() map[string]string {
+	return helpers.VaildateMessage("account.security_event")
+}
+
+func (t SecurityEventQueries) Translates() map[string]string {
+	return helpers.VaildateTranslateFields()
+}
@@ -0,0 +1,12 @@
+package queries
+
+type StreamsQuery struct {
+	// Streams are the comma separated streams the websocket client subscribes to
+	Streams string `query:"streams"`
+}
+
+type StreamAuthParams struct {
+	// UID is the member of the session the token refreshes, empty when the session authenticates on connect
+	UID   string `json:"uid" form:"uid"`
+	Token string `json:"token" form:"token"`
+}
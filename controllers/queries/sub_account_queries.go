@@ -0,0 +1,15 @@
+package queries
+
+import "github.com/shopspring/decimal"
+
+type SubAccountPayload struct {
+	Label string `json:"label" form:"label"`
+}
+
+type SubAccountTransferPayload struct {
+	SubAccountUID string          `json:"sub_account_uid" form:"sub_account_uid"`
+	Currency      string          `json:"currency" form:"currency"`
+	Amount        decimal.Decimal `json:"amount" form:"amount"`
+	// Direction is "deposit" to move funds from the parent to the sub-account, "withdraw" for the other way.
+	Direction string `json:"direction" form:"direction"`
+}
@@ -1,7 +1,7 @@
 package referral_controllers
 
 import (
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -46,6 +46,7 @@ func GetReleaseCommission(c *fiber.Ctx) error {
 			ID:          release_commission.ID,
 			AccountType: release_commission.AccountType,
 			MemberID:    release_commission.MemberID,
+			Kind:        string(release_commission.Kind),
 			EarnedBTC:   release_commission.EarnedBTC,
 			FriendTrade: release_commission.FriendTrade,
 			Friend:      release_commission.Friend,
@@ -54,7 +55,7 @@ func GetReleaseCommission(c *fiber.Ctx) error {
 		})
 	}
 
-	return c.Status(200).JSON(release_commission_entities)
+	return helpers.RenderList(c, 200, release_commission_entities)
 }
 
 func GetCommissions(c *fiber.Ctx) error {
@@ -72,13 +73,7 @@ func GetCommissions(c *fiber.Ctx) error {
 		return c.Status(422).JSON(errors)
 	}
 
-	if params.Limit == 0 {
-		params.Limit = 100
-	}
-
-	if params.Page == 0 {
-		params.Page = 1
-	}
+	helpers.PageDefaults(&params.Page, &params.Limit)
 
 	var commissions []*models.Commission
 
@@ -96,13 +91,125 @@ func GetCommissions(c *fiber.Ctx) error {
 			CurrencyID:      commission.CurrencyID,
 			ParentID:        commission.ParentID,
 			ParentCreatedAt: commission.ParentCreatedAt,
+			Level:           commission.Level,
+			State:           string(commission.State),
 			CreatedAt:       commission.CreatedAt,
 			UpdatedAt:       commission.UpdatedAt,
 		})
 	}
 
-	c.Response().Header.Add("page", strconv.FormatInt(int64(params.Page), 10))
-	c.Response().Header.Add("per-page", strconv.FormatInt(int64(len(commissions)), 10))
+	helpers.SetPageHeaders(c, params.Page, params.Limit)
+
+	return helpers.RenderList(c, 200, commission_entities)
+}
+
+// GetFeeKickback returns the fee kickbacks of a referred member, the part of its own fees it gets back during
+// its first days. Their releases are listed with the commissions.
+func GetFeeKickback(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	stats := CurrentUser.FeeKickbackStats(time.Now())
+
+	return c.Status(200).JSON(&entities.FeeKickbackStatsEntity{
+		Eligible:  stats.Eligible,
+		Rate:      stats.Rate,
+		EndsAt:    stats.EndsAt,
+		Kickbacks: referralCodeAmountsToEntities(stats.Kickbacks),
+	})
+}
+
+// ExplainCommission returns how a commission was computed, to the member who earned it or an admin.
+func ExplainCommission(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_query"},
+		})
+	}
+
+	// another member's commission is not found rather than forbidden, its id tells nothing
+	admin := CurrentUser.Role == "admin" || CurrentUser.Role == "superadmin"
+
+	var commission *models.Commission
+	if result := config.DataBase.First(&commission, id); result.Error != nil || (!admin && commission.MemberID != CurrentUser.ID) {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	explanation, err := models.ExplainCommission(config.DataBase, commission, models.StoredCurrencyPrices())
+	if err != nil {
+		config.Logger.Errorf("Failed to explain the commission %d: %v", commission.ID, err)
+
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.internal_error"},
+		})
+	}
+
+	return c.Status(200).JSON(commissionExplanationToEntity(explanation))
+}
+
+func commissionExplanationToEntity(explanation *models.CommissionExplanation) *entities.CommissionExplanationEntity {
+	commission := explanation.Commission
+
+	entity := &entities.CommissionExplanationEntity{
+		ID:           commission.ID,
+		State:        string(commission.State),
+		Source:       string(commission.Source),
+		Level:        commission.Level,
+		FriendUID:    maskIdentifier(commission.FriendUID),
+		CurrencyID:   commission.CurrencyID,
+		Fee:          commission.FeeAmount,
+		FeeExact:     commission.FeeExact,
+		Rate:         commission.Rate,
+		Reward:       explanation.Reward(),
+		EarnedAmount: commission.EarnAmount,
+		EarnedExact:  commission.RoundingExact,
+		CreatedAt:    commission.CreatedAt,
+	}
+
+	if trade := explanation.Trade; trade != nil {
+		entity.Trade = &entities.CommissionTradeEntity{
+			ID:        trade.ID,
+			Market:    trade.MarketID,
+			Price:     trade.Price,
+			Amount:    trade.Amount,
+			Total:     trade.Total,
+			CreatedAt: trade.CreatedAt,
+		}
+	}
+
+	if referral_code := explanation.ReferralCode; referral_code != nil {
+		entity.Split = &entities.CommissionSplitEntity{
+			ReferralCode: referral_code.Code,
+			Label:        referral_code.Label,
+		}
+
+		if discount := explanation.Discount; discount != nil {
+			entity.Split.DiscountAmount = discount.Amount
+			entity.Split.DiscountExact = discount.RoundingExact
+		}
+	}
+
+	if conversion := explanation.Conversion; conversion != nil {
+		entity.Conversion = &entities.CommissionConversionEntity{
+			CurrencyID: conversion.CurrencyID,
+			Rate:       conversion.Rate,
+			Exact:      conversion.Exact,
+			Amount:     conversion.Value,
+		}
+	}
+
+	return entity
+}
+
+// maskIdentifier keeps the first and the last two characters of an identifier of a counterparty.
+func maskIdentifier(identifier string) string {
+	if len(identifier) <= 4 {
+		return strings.Repeat("*", len(identifier))
+	}
 
-	return c.Status(200).JSON(commission_entities)
+	return identifier[:2] + strings.Repeat("*", len(identifier)-4) + identifier[len(identifier)-2:]
 }
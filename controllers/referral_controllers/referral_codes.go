@@ -0,0 +1,154 @@
+package referral_controllers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/controllers/queries"
+	"github.com/zsmartex/finex/models"
+)
+
+func referralCodeToEntity(referral_code *models.ReferralCode) *entities.ReferralCodeEntity {
+	return &entities.ReferralCodeEntity{
+		Code:          referral_code.Code,
+		Label:         referral_code.Label,
+		ReferrerShare: decimal.NewFromInt(1).Sub(referral_code.FriendShare),
+		FriendShare:   referral_code.FriendShare,
+		Default:       referral_code.Default,
+		State:         string(referral_code.State),
+		CreatedAt:     referral_code.CreatedAt,
+		UpdatedAt:     referral_code.UpdatedAt,
+	}
+}
+
+func referralCodeAmountsToEntities(amounts []*models.ReferralCodeEarning) []*entities.ReferralCodeAmountEntity {
+	amount_entities := make([]*entities.ReferralCodeAmountEntity, 0, len(amounts))
+	for _, amount := range amounts {
+		amount_entities = append(amount_entities, &entities.ReferralCodeAmountEntity{
+			Currency: amount.CurrencyID,
+			Amount:   amount.Amount,
+		})
+	}
+
+	return amount_entities
+}
+
+// referralCodeError renders the errors of the referral code model, unexpected ones are logged.
+func referralCodeError(c *fiber.Ctx, err error, fallback string) error {
+	for _, known := range []error{
+		models.ErrReferralCodeLimit,
+		models.ErrReferralCodeInvalid,
+		models.ErrReferralCodeTaken,
+		models.ErrReferralCodeFriendShare,
+		models.ErrReferralCodeHasSignups,
+		models.ErrReferralCodeSubAccount,
+	} {
+		if errors.Is(err, known) {
+			return c.Status(422).JSON(helpers.Errors{
+				Errors: []string{err.Error()},
+			})
+		}
+	}
+
+	config.Logger.Errorf("Referral code error: %v", err)
+
+	return c.Status(500).JSON(helpers.Errors{
+		Errors: []string{fallback},
+	})
+}
+
+func GetReferralCodes(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	referral_code_entities := make([]*entities.ReferralCodeEntity, 0)
+	for _, referral_code := range CurrentUser.ReferralCodes() {
+		referral_code_entities = append(referral_code_entities, referralCodeToEntity(referral_code))
+	}
+
+	return c.Status(200).JSON(referral_code_entities)
+}
+
+func CreateReferralCode(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	payload := new(queries.ReferralCodePayload)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	referral_code, err := CurrentUser.CreateReferralCode(payload.Code, payload.Label, payload.FriendShare, payload.Default)
+	if err != nil {
+		return referralCodeError(c, err, "referral.code.create_error")
+	}
+
+	return c.Status(201).JSON(referralCodeToEntity(referral_code))
+}
+
+func UpdateReferralCode(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	referral_code := CurrentUser.GetReferralCode(c.Params("code"))
+	if referral_code == nil {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	payload := new(queries.ReferralCodeUpdatePayload)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	if err := referral_code.Update(payload.Label, payload.FriendShare, payload.Default); err != nil {
+		return referralCodeError(c, err, "referral.code.update_error")
+	}
+
+	return c.Status(200).JSON(referralCodeToEntity(referral_code))
+}
+
+func DeleteReferralCode(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	referral_code := CurrentUser.GetReferralCode(c.Params("code"))
+	if referral_code == nil {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	if err := referral_code.Delete(); err != nil {
+		return referralCodeError(c, err, "referral.code.delete_error")
+	}
+
+	return c.Status(200).JSON(referralCodeToEntity(referral_code))
+}
+
+func GetReferralCodeStats(c *fiber.Ctx) error {
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	referral_code := CurrentUser.GetReferralCode(c.Params("code"))
+	if referral_code == nil {
+		return c.Status(404).JSON(helpers.Errors{
+			Errors: []string{"record.not_found"},
+		})
+	}
+
+	stats := referral_code.Stats()
+
+	return c.Status(200).JSON(&entities.ReferralCodeStatsEntity{
+		Code:           referral_code.Code,
+		Signups:        stats.Signups,
+		TradingFriends: stats.TradingFriends,
+		Earnings:       referralCodeAmountsToEntities(stats.Earnings),
+		Discounts:      referralCodeAmountsToEntities(stats.Discounts),
+	})
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/controllers/queries"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/routes/middlewares"
+)
+
+// AuthenticateStream verifies the token of an auth message a private websocket session sent, the websocket gateway
+// asks for it on connect and on every refresh. A refresh with the token of another member is refused with 403 and
+// the gateway closes the session, an expired or invalid token leaves the session as it was.
+func AuthenticateStream(c *fiber.Ctx) error {
+	params := new(queries.StreamAuthParams)
+	if err := c.BodyParser(params); err != nil {
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{"server.method.invalid_message_body"},
+		})
+	}
+
+	auth, err := middlewares.ParseToken(params.Token)
+
+	var validation_error *jwt.ValidationError
+	switch {
+	case errors.Is(err, middlewares.ErrPublicKey):
+		return c.Status(500).JSON(helpers.Errors{
+			Errors: []string{middlewares.ServerInternalError},
+		})
+	case errors.As(err, &validation_error) && validation_error.Errors&jwt.ValidationErrorExpired != 0:
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{models.ErrStreamAuthExpired.Error()},
+		})
+	case err != nil:
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{middlewares.JwtDecodeAndVerify},
+		})
+	}
+
+	expires_at := time.Unix(auth.ExpiresAt, 0)
+	session := models.NewStreamSession(auth.UID, models.StreamAuthJWT, expires_at)
+	if len(params.UID) > 0 {
+		session = models.NewStreamSession(params.UID, models.StreamAuthJWT, time.Time{})
+
+		err := session.Refresh(auth.UID, expires_at, time.Now())
+		switch {
+		case errors.Is(err, models.ErrStreamAuthMemberMismatch):
+			config.Logger.Warnf("Closed the websocket session of %s refreshed with a token of %s", params.UID, auth.UID)
+
+			return c.Status(403).JSON(helpers.Errors{
+				Errors: []string{err.Error()},
+			})
+		case err != nil:
+			return c.Status(422).JSON(helpers.Errors{
+				Errors: []string{err.Error()},
+			})
+		}
+	}
+
+	return c.Status(200).JSON(entities.StreamAuthEntity{
+		UID:       session.UID,
+		ExpiresAt: session.ExpiresAt.Unix(),
+		NoticeAt:  session.NoticeAt(config.StreamAuth.ExpiringNotice).Unix(),
+	})
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/types"
+)
+
+// summaryTTL is how long a replica serves the summary before reading the volume mirrors again.
+var summaryTTL = 10 * time.Second
+
+// summaryCache holds the summary of every market, it's filtered by the market group of each request.
+var summaryCache struct {
+	sync.Mutex
+	summary   *models.VolumeSummary
+	expiresAt time.Time
+}
+
+func buildSummary(now time.Time) *models.VolumeSummary {
+	var markets []*models.Market
+	config.DataBase.Order("position asc").Find(&markets, "state = ?", types.MarketStateEndabled)
+
+	volumes := make(map[string]*models.MarketVolume, len(markets))
+	for _, market := range markets {
+		volumes[market.Symbol] = models.LoadMarketVolume(market.Symbol, now)
+	}
+
+	return models.SummarizeVolumes(markets, volumes, now)
+}
+
+func summaryToEntity(summary *models.VolumeSummary) *entities.SummaryEntity {
+	entity := &entities.SummaryEntity{
+		TotalUSDTVolume: summary.TotalUSDTVolume,
+		Markets:         make([]*entities.MarketSummaryEntity, 0, len(summary.Markets)),
+		UpdatedAt:       summary.UpdatedAt.Unix(),
+	}
+
+	for _, market := range summary.Markets {
+		entity.Markets = append(entity.Markets, &entities.MarketSummaryEntity{
+			Market:      market.Market,
+			BaseVolume:  market.BaseVolume,
+			QuoteVolume: market.QuoteVolume,
+			USDTVolume:  market.USDTVolume,
+			LastPrice:   market.LastPrice,
+			Sparkline:   market.Sparkline,
+		})
+	}
+
+	return entity
+}
+
+// GetSummary returns the 24h volume of every market visible to the request and their total in USDT.
+func GetSummary(c *fiber.Ctx) error {
+	summaryCache.Lock()
+	now := time.Now()
+	if summaryCache.summary == nil || now.After(summaryCache.expiresAt) {
+		summaryCache.summary = buildSummary(now)
+		summaryCache.expiresAt = now.Add(summaryTTL)
+	}
+	summary := summaryCache.summary
+	summaryCache.Unlock()
+
+	visible := summary.Visible(models.MarketVisibility.Markets(helpers.MarketGroup(c)))
+
+	return c.Status(200).JSON(summaryToEntity(visible))
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/types"
+)
+
+// tickersTTL is how long a replica serves the tickers before reading the volume mirrors again, the trade executor
+// mirrors them every 5 seconds.
+var tickersTTL = 5 * time.Second
+
+// tickersCache holds the ticker of every market in the order of the markets, it's filtered by the market group of
+// each request.
+var tickersCache struct {
+	sync.Mutex
+	tickers   []*models.MarketTicker
+	expiresAt time.Time
+}
+
+func buildTickers(now time.Time) []*models.MarketTicker {
+	var markets []*models.Market
+	config.DataBase.Order("position asc").Find(&markets, "state = ?", types.MarketStateEndabled)
+
+	tickers := make([]*models.MarketTicker, 0, len(markets))
+	for _, market := range markets {
+		ticker := models.LoadMarketVolume(market.Symbol, now).Ticker(now)
+		tickers = append(tickers, models.RoundTicker(ticker, market))
+	}
+
+	return tickers
+}
+
+func loadTickers() []*models.MarketTicker {
+	tickersCache.Lock()
+	defer tickersCache.Unlock()
+
+	now := time.Now()
+	if tickersCache.tickers == nil || now.After(tickersCache.expiresAt) {
+		tickersCache.tickers = buildTickers(now)
+		tickersCache.expiresAt = now.Add(tickersTTL)
+	}
+
+	return tickersCache.tickers
+}
+
+func tickerToEntity(ticker *models.MarketTicker) *entities.TickerEntity {
+	return &entities.TickerEntity{
+		Market:             ticker.Market,
+		At:                 ticker.At.Unix(),
+		Open:               ticker.Open,
+		High:               ticker.High,
+		Low:                ticker.Low,
+		Last:               ticker.Last,
+		Volume:             ticker.Volume,
+		QuoteVolume:        ticker.QuoteVolume,
+		PriceChangePercent: ticker.PriceChangePercent,
+	}
+}
+
+// GetTickers returns the 24h ticker of every market visible to the request.
+func GetTickers(c *fiber.Ctx) error {
+	visible := models.MarketVisibility.Markets(helpers.MarketGroup(c))
+
+	tickers := make([]*entities.TickerEntity, 0)
+	for _, ticker := range loadTickers() {
+		if visible[ticker.Market] {
+			tickers = append(tickers, tickerToEntity(ticker))
+		}
+	}
+
+	return c.Status(200).JSON(tickers)
+}
+
+// GetTicker returns the 24h ticker of a market.
+func GetTicker(c *fiber.Ctx) error {
+	symbol := c.Params("market")
+
+	if models.MarketVisibility.Visible(helpers.MarketGroup(c), symbol) {
+		for _, ticker := range loadTickers() {
+			if ticker.Market == symbol {
+				return c.Status(200).JSON(tickerToEntity(ticker))
+			}
+		}
+	}
+
+	return c.Status(422).JSON(helpers.Errors{
+		Errors: []string{"public.market.doesnt_exist"},
+	})
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+// ArchiveSizeHeader is the size of the archive in bytes, HEAD requests get it without the download.
+const ArchiveSizeHeader = "X-Archive-Size"
+
+// GetTradeArchive serves the daily archive of the trades of a market as gzipped CSV, the dates
+// with an archive are listed when there's no date.
+func GetTradeArchive(c *fiber.Ctx) error {
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", c.Params("market")); errors.Is(result.Error, gorm.ErrRecordNotFound) || !models.MarketVisibility.Visible(helpers.MarketGroup(c), market.Symbol) {
+		return c.Status(422).JSON(helpers.Errors{
+			Errors: []string{"public.market.doesnt_exist"},
+		})
+	}
+
+	date := c.Query("date")
+	if len(date) == 0 {
+		dates, err := models.TradeArchiveDates(market.Symbol)
+		if err != nil {
+			return tradeArchiveError(c, err)
+		}
+
+		return c.Status(200).JSON(dates)
+	}
+
+	day, err := models.ParseTradeArchiveDate(date, time.Now())
+	if err != nil {
+		return tradeArchiveError(c, err)
+	}
+
+	file, size, err := models.OpenTradeArchive(market.Symbol, day)
+	if err != nil {
+		return tradeArchiveError(c, err)
+	}
+
+	c.Set(ArchiveSizeHeader, strconv.FormatInt(size, 10))
+	c.Set(fiber.HeaderContentType, "application/gzip")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+market.Symbol+"-trades-"+date+`.csv.gz"`)
+
+	if c.Method() == fiber.MethodHead {
+		file.Close()
+		c.Set(fiber.HeaderContentLength, strconv.FormatInt(size, 10))
+
+		return c.SendStatus(200)
+	}
+
+	// the file is closed by fasthttp once it's sent
+	return c.Status(200).SendStream(file, int(size))
+}
+
+func tradeArchiveError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, models.ErrTradeArchiveDate):
+		return c.Status(422).JSON(helpers.Errors{Errors: []string{err.Error()}})
+	case errors.Is(err, models.ErrTradeArchiveNotFound):
+		return c.Status(404).JSON(helpers.Errors{Errors: []string{err.Error()}})
+	default:
+		config.Logger.Errorf("Failed to serve a trade archive: %v", err)
+
+		return c.Status(500).JSON(helpers.Errors{Errors: []string{"server.internal_error"}})
+	}
+}
@@ -0,0 +1,83 @@
+// Package decimalutil rounds the amounts booked and shown by finex. Each helper names what it rounds, with
+// the mode and the precision it's rounded to, so every path rounding the same kind of amount rounds it the
+// same way. Decimals are only rounded through this package, lint_test.go fails on a rounding call elsewhere.
+package decimalutil
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// Mode is how a value is rounded to its precision.
+type Mode string
+
+var (
+	// HalfUp rounds halves away from zero, the value moves by at most half a unit of the precision either way
+	HalfUp Mode = "half_up"
+	// Up rounds away from zero
+	Up Mode = "up"
+	// Down rounds towards zero, it truncates
+	Down Mode = "down"
+)
+
+// Round rounds value to places with mode.
+func Round(value decimal.Decimal, places int32, mode Mode) decimal.Decimal {
+	switch mode {
+	case Up:
+		return value.RoundUp(places)
+	case Down:
+		return value.RoundDown(places)
+	default:
+		return value.Round(places)
+	}
+}
+
+// ULP is a unit of the last place of a value rounded to places.
+func ULP(places int32) decimal.Decimal {
+	return decimal.New(1, -places)
+}
+
+// RoundQuote rounds a price or an amount of the quote currency half up, to the price precision of its market.
+func RoundQuote(value decimal.Decimal, price_precision int32) decimal.Decimal {
+	return Round(value, price_precision, HalfUp)
+}
+
+// DivQuote divides to a price, an average price from the funds of an order, rounded half up to the price
+// precision of its market. It rounds once, the quotient isn't rounded to the division precision first.
+func DivQuote(numerator, denominator decimal.Decimal, price_precision int32) decimal.Decimal {
+	return numerator.DivRound(denominator, price_precision)
+}
+
+// RoundBase rounds an amount of the base currency half up, to the amount precision of its market.
+func RoundBase(value decimal.Decimal, amount_precision int32) decimal.Decimal {
+	return Round(value, amount_precision, HalfUp)
+}
+
+// RoundFee rounds a fee half up to the scale it's booked at, the scale of the balances. A member pays at most
+// half a unit of the scale less than the exact fee.
+func RoundFee(fee decimal.Decimal, scale int32) decimal.Decimal {
+	return Round(fee, scale, HalfUp)
+}
+
+// RoundFeeDown rounds down a fee the engine computes for a trade to the scale of the balances, the fee is never
+// above the amount it's charged on.
+func RoundFeeDown(fee decimal.Decimal, scale int32) decimal.Decimal {
+	return Round(fee, scale, Down)
+}
+
+// RoundLocked rounds the funds an order locks up to the scale of the balances, so the lock always covers
+// what the order can spend.
+func RoundLocked(funds decimal.Decimal, scale int32) decimal.Decimal {
+	return Round(funds, scale, Up)
+}
+
+// RoundPayout rounds down what's paid out to a member valued in another currency, released commissions and
+// kickbacks valued in BTC, to places, so a payout is never above what was earned.
+func RoundPayout(value decimal.Decimal, places int32) decimal.Decimal {
+	return Round(value, places, Down)
+}
+
+// ConvertWithRate converts amount to another currency at rate, rounded half up to places, the precision
+// of the currency converted to.
+func ConvertWithRate(amount, rate decimal.Decimal, places int32) decimal.Decimal {
+	return Round(amount.Mul(rate), places, HalfUp)
+}
@@ -0,0 +1,120 @@
+package decimalutil
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func randomPositive(random *rand.Rand) decimal.Decimal {
+	return decimal.New(random.Int63n(1e15)+1, -random.Int31n(20))
+}
+
+// favor is how far rounding moved a value in the favor of the member, towards what favors them.
+type favor func(exact, rounded decimal.Decimal) decimal.Decimal
+
+// paid favors the member when the rounded value is below the exact one, fees and prices they pay.
+func paid(exact, rounded decimal.Decimal) decimal.Decimal {
+	return exact.Sub(rounded)
+}
+
+// received favors the member when the rounded value is above the exact one, payouts they get.
+func received(exact, rounded decimal.Decimal) decimal.Decimal {
+	return rounded.Sub(exact)
+}
+
+func TestRoundingProperties(t *testing.T) {
+	half := decimal.New(5, -1)
+
+	cases := []struct {
+		name  string
+		round func(decimal.Decimal, int32) decimal.Decimal
+		favor favor
+		// max is the most the rounding can favor the member, in units of the precision
+		max decimal.Decimal
+	}{
+		{"RoundQuote", RoundQuote, paid, half},
+		{"RoundBase", RoundBase, paid, half},
+		{"RoundFee", RoundFee, paid, half},
+		{"RoundFeeDown", RoundFeeDown, paid, decimal.NewFromInt(1)},
+		{"RoundLocked", RoundLocked, paid, decimal.Zero},
+		{"RoundPayout", RoundPayout, received, decimal.Zero},
+	}
+
+	random := rand.New(rand.NewSource(1))
+
+	for _, c := range cases {
+		for i := 0; i < 10000; i++ {
+			exact := randomPositive(random)
+			places := random.Int31n(17)
+			ulp := ULP(places)
+
+			rounded := c.round(exact, places)
+
+			if rounded.Sub(exact).Abs().GreaterThanOrEqual(ulp) {
+				t.Fatalf("%s(%s, %d) = %s, moved by a unit or more", c.name, exact, places, rounded)
+			}
+
+			if c.favor(exact, rounded).GreaterThan(ulp.Mul(c.max)) {
+				t.Fatalf("%s(%s, %d) = %s, favors the member by more than %s of a unit", c.name, exact, places, rounded, c.max)
+			}
+
+			if !rounded.Equal(Round(rounded, places, HalfUp)) {
+				t.Fatalf("%s(%s, %d) = %s, has more than %d places", c.name, exact, places, rounded, places)
+			}
+		}
+	}
+}
+
+func TestConvertWithRateProperties(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+
+	for i := 0; i < 10000; i++ {
+		amount, rate := randomPositive(random), randomPositive(random)
+		places := random.Int31n(17)
+		half_ulp := ULP(places).Mul(decimal.New(5, -1))
+
+		converted := ConvertWithRate(amount, rate, places)
+		if paid(amount.Mul(rate), converted).GreaterThan(half_ulp) {
+			t.Fatalf("ConvertWithRate(%s, %s, %d) = %s, favors the member by more than half a unit", amount, rate, places, converted)
+		}
+	}
+}
+
+func TestDivQuoteProperties(t *testing.T) {
+	random := rand.New(rand.NewSource(3))
+
+	for i := 0; i < 10000; i++ {
+		numerator, denominator := randomPositive(random), randomPositive(random)
+		places := random.Int31n(17)
+
+		// |quotient - numerator / denominator| <= ulp / 2, without the inexact division
+		quotient := DivQuote(numerator, denominator, places)
+		drift := quotient.Mul(denominator).Sub(numerator).Abs()
+		if drift.GreaterThan(ULP(places).Mul(denominator).Mul(decimal.New(5, -1))) {
+			t.Fatalf("DivQuote(%s, %s, %d) = %s, moved by more than half a unit", numerator, denominator, places, quotient)
+		}
+	}
+}
+
+func TestRoundModes(t *testing.T) {
+	cases := []struct {
+		value string
+		mode  Mode
+		want  string
+	}{
+		{"1.005", HalfUp, "1.01"},
+		{"-1.005", HalfUp, "-1.01"},
+		{"1.001", Up, "1.01"},
+		{"-1.001", Up, "-1.01"},
+		{"1.009", Down, "1"},
+		{"-1.009", Down, "-1"},
+	}
+
+	for _, c := range cases {
+		if got := Round(decimal.RequireFromString(c.value), 2, c.mode); !got.Equal(decimal.RequireFromString(c.want)) {
+			t.Errorf("Round(%s, 2, %s) = %s, want %s", c.value, c.mode, got, c.want)
+		}
+	}
+}
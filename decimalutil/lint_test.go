@@ -0,0 +1,113 @@
+package decimalutil
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// roundingMethods are the methods of decimal.Decimal which round, they're only called from this package.
+var roundingMethods = map[string]bool{
+	"Round":           true,
+	"DivRound":        true,
+	"RoundBank":       true,
+	"RoundCeil":       true,
+	"RoundFloor":      true,
+	"RoundUp":         true,
+	"RoundDown":       true,
+	"RoundCash":       true,
+	"StringFixedBank": true,
+}
+
+// timeArgument reports whether a call passes a duration of the time package, time.Time has a Round method too.
+func timeArgument(call *ast.CallExpr) bool {
+	for _, arg := range call.Args {
+		timed := false
+		ast.Inspect(arg, func(node ast.Node) bool {
+			if selector, ok := node.(*ast.SelectorExpr); ok {
+				if ident, ok := selector.X.(*ast.Ident); ok && ident.Name == "time" {
+					timed = true
+				}
+			}
+
+			return !timed
+		})
+
+		if timed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestNoRoundingOutsideDecimalutil(t *testing.T) {
+	root, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fset := token.NewFileSet()
+	var found []string
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			switch info.Name() {
+			case ".git", "vendor", "decimalutil":
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+
+		ast.Inspect(file, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+
+			selector, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || !roundingMethods[selector.Sel.Name] {
+				return true
+			}
+
+			if ident, ok := selector.X.(*ast.Ident); ok && ident.Name == "decimalutil" {
+				return true
+			}
+
+			if !timeArgument(call) {
+				position := fset.Position(call.Pos())
+				relative, _ := filepath.Rel(root, position.Filename)
+				found = append(found, relative+":"+strings.TrimPrefix(position.String(), position.Filename+":")+" "+selector.Sel.Name)
+			}
+
+			return true
+		})
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, call := range found {
+		t.Errorf("%s rounds a decimal outside decimalutil, use one of its helpers", call)
+	}
+}
@@ -0,0 +1,76 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// DeadLetterTopic is the topic the consumers park the messages they can't decode or validate on, the dead letter
+// recorder keeps them until an admin re-injects them.
+const DeadLetterTopic = "finex.deadletter"
+
+// ErrMalformed is matched by the errors of the messages which can't be decoded or validated. Processing them again
+// fails the same way, the consumers park them as dead letters.
+var ErrMalformed = errors.New("malformed message")
+
+type malformedError struct {
+	err error
+}
+
+func (e *malformedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *malformedError) Unwrap() error {
+	return e.err
+}
+
+func (e *malformedError) Is(target error) bool {
+	return target == ErrMalformed
+}
+
+// Malformed marks err as the error of a message which can't be decoded or validated, it still matches the errors err
+// wraps.
+func Malformed(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &malformedError{err: err}
+}
+
+// DeadLetter is a message a consumer parked with the error it failed on. The producer doesn't take headers, the
+// topic the message was consumed from, its routing key, comes with it.
+type DeadLetter struct {
+	Topic          string    `json:"topic"`
+	Payload        []byte    `json:"payload"`
+	Error          string    `json:"error"`
+	DeadLetteredAt time.Time `json:"dead_lettered_at"`
+}
+
+func DecodeDeadLetter(payload []byte) (*DeadLetter, error) {
+	var dead_letter *DeadLetter
+	if err := json.Unmarshal(payload, &dead_letter); err != nil {
+		return nil, err
+	}
+
+	if dead_letter == nil || len(dead_letter.Topic) == 0 {
+		return nil, errors.New("dead letter without a topic")
+	}
+
+	return dead_letter, nil
+}
+
+// ParkDeadLetter produces payload, consumed from topic, to the dead letter topic with the error it failed on. The
+// consumer commits the message once it's parked.
+func ParkDeadLetter(topic string, payload []byte, err error) error {
+	return config.Bus.Publish(DeadLetterTopic, "", &DeadLetter{
+		Topic:          topic,
+		Payload:        payload,
+		Error:          err.Error(),
+		DeadLetteredAt: time.Now(),
+	})
+}
@@ -0,0 +1,366 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func readFixture(t *testing.T, event_type EventType, version int) []byte {
+	payload, err := os.ReadFile(filepath.Join("testdata", fmt.Sprintf("%s_v%d.json", event_type, version)))
+	if err != nil {
+		t.Fatalf("missing fixture for %s v%d: %v", event_type, version, err)
+	}
+
+	return payload
+}
+
+// Every supported version must keep decoding the payloads recorded when it was introduced.
+func TestTradeFixtures(t *testing.T) {
+	versions := SupportedVersions(TypeTrade)
+	if len(versions) < 2 {
+		t.Fatalf("expected the current and the previous trade versions, got %v", versions)
+	}
+
+	for _, version := range versions {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			trade, err := DecodeTrade(readFixture(t, TypeTrade, version))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if trade.Symbol.BaseCurrency != "BTC" || trade.Symbol.QuoteCurrency != "USDT" {
+				t.Errorf("unexpected symbol %+v", trade.Symbol)
+			}
+
+			if !trade.Price.Equal(decimal.RequireFromString("30000.5")) || !trade.Quantity.Equal(decimal.RequireFromString("0.25")) || !trade.Total.Equal(decimal.RequireFromString("7500.125")) {
+				t.Errorf("unexpected amounts %s * %s = %s", trade.Price, trade.Quantity, trade.Total)
+			}
+
+			if trade.MakerOrder.ID != 11 || trade.TakerOrder.ID != 12 || trade.TakerOrder.MemberID != 4 {
+				t.Errorf("unexpected orders %d and %d", trade.MakerOrder.ID, trade.TakerOrder.ID)
+			}
+
+			if trade.TakerSide != pkg.SideBuy || trade.SellOrder().ID != 11 {
+				t.Errorf("unexpected taker side %s", trade.TakerSide)
+			}
+
+			if version >= 3 && (trade.MatchedAt == nil || !trade.MatchedAt.Equal(time.Date(2022, 5, 1, 10, 0, 1, 500000000, time.UTC))) {
+				t.Errorf("unexpected match time %v", trade.MatchedAt)
+			}
+
+			if version >= 4 && trade.ConfigVersion != 7 {
+				t.Errorf("unexpected config version %d", trade.ConfigVersion)
+			}
+
+			if version >= 5 && (trade.MakerFee == nil || !trade.MakerFee.Equal(decimal.RequireFromString("15.00025")) || trade.MakerFeeCurrency != "USDT" ||
+				trade.TakerFee == nil || !trade.TakerFee.Equal(decimal.RequireFromString("0.0005")) || trade.TakerFeeCurrency != "BTC") {
+				t.Errorf("unexpected fees %v %s and %v %s", trade.MakerFee, trade.MakerFeeCurrency, trade.TakerFee, trade.TakerFeeCurrency)
+			}
+
+			if version >= 6 && trade.Sequence != 42 {
+				t.Errorf("unexpected sequence %d", trade.Sequence)
+			}
+		})
+	}
+}
+
+func TestOrderFixtures(t *testing.T) {
+	versions := SupportedVersions(TypeOrder)
+	if len(versions) < 2 {
+		t.Fatalf("expected the current and the previous order versions, got %v", versions)
+	}
+
+	for _, version := range versions {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			order, err := DecodeOrder(readFixture(t, TypeOrder, version))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if order.Action != pkg.ActionCancel || order.ID != 12 || order.Reason != "price_limit" {
+				t.Errorf("unexpected order event %+v", order)
+			}
+
+			if version >= 2 && order.UUID != uuid.MustParse("0c8d6a4e-2b7f-4e21-9f3b-6a1d5e7c9b02") {
+				t.Errorf("unexpected uuid %s", order.UUID)
+			}
+
+			if version >= 3 && order.ReplacedID != 11 {
+				t.Errorf("unexpected replaced order %d", order.ReplacedID)
+			}
+
+			if version >= 10 && (order.ClientID == nil || *order.ClientID != uuid.MustParse("3f6d2b8e-9a41-4c57-b0e2-7d1c5a9f4e63")) {
+				t.Errorf("unexpected client id %v", order.ClientID)
+			}
+		})
+	}
+}
+
+// Events encoded in any supported version decode back to the same event.
+func TestEncodeRoundTrip(t *testing.T) {
+	trade, err := DecodeTrade(readFixture(t, TypeTrade, LatestVersion(TypeTrade)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range SupportedVersions(TypeTrade) {
+		payload, err := EncodeVersion(TypeTrade, version, trade)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, _ := json.Marshal(payload)
+		decoded, err := DecodeTrade(b)
+		if err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+
+		if decoded.Version != version && version != 1 {
+			t.Errorf("v%d: decoded version %d", version, decoded.Version)
+		}
+
+		if !decoded.Total.Equal(trade.Total) || decoded.TakerOrder.UUID != trade.TakerOrder.UUID || decoded.TakerSide != trade.TakerSide {
+			t.Errorf("v%d: round trip changed the trade: %s", version, b)
+		}
+
+		if version < 4 && decoded.ConfigVersion != 0 || version >= 4 && decoded.ConfigVersion != trade.ConfigVersion {
+			t.Errorf("v%d: unexpected config version %d", version, decoded.ConfigVersion)
+		}
+
+		if version < 5 && decoded.MakerFee != nil || version >= 5 && (decoded.TakerFee == nil || !decoded.TakerFee.Equal(*trade.TakerFee)) {
+			t.Errorf("v%d: unexpected fees %v and %v", version, decoded.MakerFee, decoded.TakerFee)
+		}
+
+		if version < 6 && decoded.Sequence != 0 || version >= 6 && decoded.Sequence != trade.Sequence {
+			t.Errorf("v%d: unexpected sequence %d", version, decoded.Sequence)
+		}
+	}
+
+	order := NewOrder(pkg.ActionSubmit, 7, uuid.New(), "")
+	for _, version := range SupportedVersions(TypeOrder) {
+		payload, _ := EncodeVersion(TypeOrder, version, order)
+		b, _ := json.Marshal(payload)
+
+		decoded, err := DecodeOrder(b)
+		if err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+
+		if decoded.Action != order.Action || decoded.ID != order.ID {
+			t.Errorf("v%d: round trip changed the order: %s", version, b)
+		}
+	}
+}
+
+func TestOrderCreateAttributes(t *testing.T) {
+	attributes := []byte(`{"id":7,"market_id":"btcusdt","volume":"1.5"}`)
+	create := NewOrderCreate(7, uuid.New(), attributes)
+
+	payload, _ := json.Marshal(EncodeOrder(create))
+	decoded, err := DecodeOrder(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Action != ActionCreate || string(decoded.Attributes) != string(attributes) {
+		t.Errorf("round trip changed the create: %s", payload)
+	}
+
+	// the previous versions have no attributes, creates need v4
+	previous, _ := EncodeVersion(TypeOrder, 3, create)
+	if b, _ := json.Marshal(previous); strings.Contains(string(b), "attributes") {
+		t.Errorf("v3 carries the attributes: %s", b)
+	}
+}
+
+func TestOrderDecrementQuantity(t *testing.T) {
+	decrement := NewOrderDecrement(7, uuid.New(), decimal.RequireFromString("0.5"), "self_trade")
+
+	payload, _ := json.Marshal(EncodeOrder(decrement))
+	decoded, err := DecodeOrder(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Action != ActionDecrement || decoded.Quantity == nil || !decoded.Quantity.Equal(decimal.RequireFromString("0.5")) {
+		t.Errorf("round trip changed the decrement: %s", payload)
+	}
+
+	// the previous versions have no quantity, decrements need v5
+	previous, _ := EncodeVersion(TypeOrder, 4, decrement)
+	if b, _ := json.Marshal(previous); strings.Contains(string(b), "quantity") {
+		t.Errorf("v4 carries the quantity: %s", b)
+	}
+}
+
+func TestOrderReprice(t *testing.T) {
+	reprice := NewOrderReprice(7, uuid.New(), decimal.RequireFromString("1.23"), decimal.Zero, "precision")
+
+	payload, _ := json.Marshal(EncodeOrder(reprice))
+	decoded, err := DecodeOrder(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Action != ActionReprice || decoded.Price == nil || !decoded.Price.Equal(decimal.RequireFromString("1.23")) || decoded.StopPrice == nil || !decoded.StopPrice.IsZero() {
+		t.Errorf("round trip changed the reprice: %s", payload)
+	}
+
+	// the previous versions have no prices, reprices need v7
+	previous, _ := EncodeVersion(TypeOrder, 6, reprice)
+	if b, _ := json.Marshal(previous); strings.Contains(string(b), `"price"`) {
+		t.Errorf("v6 carries the prices: %s", b)
+	}
+}
+
+func TestOrderAmend(t *testing.T) {
+	amend := NewOrderAmend(7, uuid.New(), decimal.RequireFromString("101.5"), decimal.RequireFromString("3"))
+
+	payload, _ := json.Marshal(EncodeOrder(amend))
+	decoded, err := DecodeOrder(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Action != ActionAmend || decoded.Version < 8 || !decoded.Price.Equal(decimal.RequireFromString("101.5")) || !decoded.Quantity.Equal(decimal.RequireFromString("3")) {
+		t.Errorf("round trip changed the amend: %s", payload)
+	}
+}
+
+func TestOrderBatch(t *testing.T) {
+	result := NewOrderBatch(ActionBatchResult, &Batch{
+		ID: "5b0f3c1e-8d2a-4c7e-a6b9-1f4e2d7c8a30",
+		Entries: []*BatchEntry{
+			{Action: pkg.ActionSubmit, ID: 12, UUID: uuid.New(), Accepted: true},
+			{Action: pkg.ActionCancel, ID: 11, UUID: uuid.New(), Reason: "already_gone"},
+		},
+	})
+
+	payload, _ := json.Marshal(EncodeOrder(result))
+	decoded, err := DecodeOrder(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Action != ActionBatchResult || decoded.Batch == nil || len(decoded.Batch.Entries) != 2 ||
+		!decoded.Batch.Entries[0].Accepted || decoded.Batch.Entries[1].Accepted || decoded.Batch.Entries[1].Reason != "already_gone" {
+		t.Errorf("round trip changed the batch result: %s", payload)
+	}
+
+	// the previous versions have no batches, batches need v9
+	previous, _ := EncodeVersion(TypeOrder, 8, result)
+	if b, _ := json.Marshal(previous); strings.Contains(string(b), "batch\"") {
+		t.Errorf("v8 carries the batch: %s", b)
+	}
+}
+
+// The outcomes of a cancel command producers correlate with the id of their command.
+func TestCancelOutcomeFixtures(t *testing.T) {
+	order_uuid := uuid.MustParse("0c8d6a4e-2b7f-4e21-9f3b-6a1d5e7c9b02")
+
+	outcomes := map[string]*Order{
+		"order_cancel_cancelled":     NewOrderCancelOutcome(pkg.ActionCancel, 12, order_uuid, "", "liquidator-7f3a"),
+		"order_cancel_already_gone":  NewOrderCancelOutcome(ActionCancelAlreadyGone, 12, order_uuid, "already_gone", "liquidator-7f3a"),
+		"order_cancel_never_existed": NewOrderCancelOutcome(ActionCancelNeverExisted, 12, order_uuid, "never_existed", "liquidator-7f3a"),
+		"order_cancel_rejected":      NewOrderCancelOutcome(ActionCancelRejected, 12, order_uuid, "halted", "liquidator-7f3a"),
+	}
+
+	for name, outcome := range outcomes {
+		t.Run(name, func(t *testing.T) {
+			fixture, err := os.ReadFile(filepath.Join("testdata", name+".json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payload, _ := EncodeVersion(TypeOrder, 6, outcome)
+			if b, _ := json.Marshal(payload); strings.TrimSpace(string(fixture)) != string(b) {
+				t.Errorf("expected %s, got %s", fixture, b)
+			}
+
+			decoded, err := DecodeOrder(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if decoded.Action != outcome.Action || decoded.Reason != outcome.Reason || decoded.CommandID != outcome.CommandID {
+				t.Errorf("unexpected outcome %+v", decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeRejectsUnknownPayloads(t *testing.T) {
+	if _, err := DecodeTrade([]byte(`{"type":"trade","version":99}`)); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected an unsupported version error, got %v", err)
+	}
+
+	if _, err := DecodeTrade(readFixture(t, TypeOrder, 2)); !errors.Is(err, ErrMalformed) {
+		t.Errorf("expected an order payload to be rejected as a malformed trade, got %v", err)
+	}
+}
+
+// The consumers park the payloads they can't decode, every way a payload breaks must be malformed.
+func TestDecodeBrokenPayloads(t *testing.T) {
+	broken := map[string]string{
+		"truncated":       `{"type":"order","version":6,"action":"submit","id":`,
+		"not json":        `submit order 7`,
+		"wrong version":   `{"type":"order","version":"6"}`,
+		"unknown version": `{"type":"order","version":99}`,
+		"wrong field":     `{"type":"order","version":6,"action":"submit","id":"7"}`,
+	}
+
+	for name, payload := range broken {
+		if _, err := DecodeOrder([]byte(payload)); !errors.Is(err, ErrMalformed) {
+			t.Errorf("%s: expected a malformed order, got %v", name, err)
+		}
+	}
+
+	if _, err := DecodeTrade([]byte(`{"type":"trade","version":6,"price":"thirty"}`)); !errors.Is(err, ErrMalformed) {
+		t.Errorf("expected a malformed trade, got %v", err)
+	}
+
+	for _, payload := range []string{`{"id":"7"}`, `{"market":"btcusdt"}`, `{"id":7}`, `null`} {
+		if _, err := DecodeExecutedTrade([]byte(payload)); !errors.Is(err, ErrMalformed) {
+			t.Errorf("%s: expected a malformed executed trade, got %v", payload, err)
+		}
+	}
+
+	if _, err := DecodeOrder(readFixture(t, TypeOrder, LatestVersion(TypeOrder))); err != nil {
+		t.Errorf("expected the latest order fixture to decode, got %v", err)
+	}
+}
+
+func TestDeadLetterKeepsThePayload(t *testing.T) {
+	payload := []byte(`{"type":"order","version":6,"action":"submit","id":`)
+	b, err := json.Marshal(&DeadLetter{Topic: "order_processor", Payload: payload, Error: "unexpected end of JSON input"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dead_letter, err := DecodeDeadLetter(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dead_letter.Topic != "order_processor" || string(dead_letter.Payload) != string(payload) || dead_letter.Error != "unexpected end of JSON input" {
+		t.Errorf("unexpected dead letter %+v", dead_letter)
+	}
+
+	if _, err := DecodeDeadLetter([]byte(`{"payload":"e30="}`)); err == nil {
+		t.Error("expected a dead letter without a topic to be rejected")
+	}
+
+	if err := Malformed(errors.New("batch without entries")); !errors.Is(err, ErrMalformed) || err.Error() != "batch without entries" {
+		t.Errorf("expected the malformed error to keep its message, got %v", err)
+	}
+}
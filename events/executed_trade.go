@@ -0,0 +1,51 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// ExecutedTradeTopic carries the trades the trade executor committed to the workers building from them, the kline
+// builder among them. The trades are keyed by their market, the ones of a market are consumed in the order of
+// their id.
+const ExecutedTradeTopic = "finex.trades"
+
+// ExecutedTrade is a trade once executed, ID is the id of its row in the trades table.
+type ExecutedTrade struct {
+	ID        int64           `json:"id"`
+	Market    string          `json:"market"`
+	Price     decimal.Decimal `json:"price"`
+	Amount    decimal.Decimal `json:"amount"`
+	Total     decimal.Decimal `json:"total"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func DecodeExecutedTrade(payload []byte) (*ExecutedTrade, error) {
+	var trade *ExecutedTrade
+	if err := json.Unmarshal(payload, &trade); err != nil {
+		return nil, Malformed(err)
+	}
+
+	if trade == nil || trade.ID <= 0 || len(trade.Market) == 0 {
+		return nil, Malformed(errors.New("executed trade without an id or a market"))
+	}
+
+	return trade, nil
+}
+
+// PublishExecutedTrade publishes a trade once it's committed. A failure is logged rather than failing the trade, the
+// candles it's missing from are rebuilt from the trades table.
+func PublishExecutedTrade(trade *ExecutedTrade) {
+	if config.Bus == nil {
+		return
+	}
+
+	if err := config.Bus.Publish(ExecutedTradeTopic, trade.Market, trade); err != nil {
+		config.Logger.Errorf("Failed to publish the executed trade %d of %s: %v", trade.ID, trade.Market, err)
+	}
+}
@@ -0,0 +1,22 @@
+package events
+
+import (
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/types"
+)
+
+// MarketStateEvent is the public event of a market whose trading state changed, the clients connected to the
+// market get it as market_state.
+type MarketStateEvent struct {
+	Market       string             `json:"market"`
+	TradingState types.TradingState `json:"trading_state"`
+	// Reason is circuit_breaker when the circuit breaker of the book tripped or was lifted, empty for an admin's change
+	Reason string `json:"reason,omitempty"`
+	// Price and Reference are the price of the trade which tripped the circuit breaker and the price it moved from
+	Price     *decimal.Decimal `json:"price,omitempty"`
+	Reference *decimal.Decimal `json:"reference,omitempty"`
+	At        int64            `json:"at"`
+	// Until is when the tripped circuit breaker lifts itself
+	Until int64 `json:"until,omitempty"`
+}
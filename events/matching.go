@@ -0,0 +1,159 @@
+package events
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/types"
+)
+
+// OrderOptions are the instructions of an order the engine gets along with it, pkg.Order has no room for them.
+type OrderOptions struct {
+	// PostOnly cancels a limit order which would trade as taker rather than letting it match
+	PostOnly bool `json:"post_only,omitempty"`
+	// TimeInForce is how long the part of the order not matched right away stays in the book, empty is good till cancelled
+	TimeInForce types.TimeInForce `json:"time_in_force,omitempty"`
+	// TrailingOffset makes a stop order trail the market price at the offset, its stop price follows the highest
+	// price since it was placed less the offset for a sell and the lowest price plus the offset for a buy
+	TrailingOffset *decimal.Decimal `json:"trailing_offset,omitempty"`
+	// SelfTradePrevention keeps the order from matching the resting orders of its member, empty lets it
+	SelfTradePrevention types.SelfTradePrevention `json:"self_trade_prevention,omitempty"`
+	// DisplayQuantity makes a limit order an iceberg, the book only shows slices of it of the quantity
+	DisplayQuantity *decimal.Decimal `json:"display_quantity,omitempty"`
+	// ExpiresAt makes the order good till the date, it's cancelled once the time passed
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// MaxSlippage is how far from the best price when it's matched a market order can be matched, as a ratio of it,
+	// the rest of the order is cancelled
+	MaxSlippage *decimal.Decimal `json:"max_slippage,omitempty"`
+	// QuoteQuantity makes a market buy spend at most the quote amount, its quantity only caps what it buys
+	QuoteQuantity *decimal.Decimal `json:"quote_quantity,omitempty"`
+	// TriggerBy is the price a stop order is triggered by, empty is the last trade price
+	TriggerBy types.TriggerBy `json:"trigger_by,omitempty"`
+	// ClientID is the id the member placed the order with, the engine doesn't use it
+	ClientID *uuid.UUID `json:"client_id,omitempty"`
+}
+
+// Rests reports whether the part of a limit order not matched right away rests in the book.
+func (o *OrderOptions) Rests() bool {
+	return o.TimeInForce != types.TimeInForceIOC && o.TimeInForce != types.TimeInForceFOK
+}
+
+// ActionRekey re-keys the book of a market to the price precision of a precision change.
+const ActionRekey pkg.PayloadAction = "rekey"
+
+// PrecisionChange is the price precision a rekey command re-keys the book to, for the precision change of id.
+type PrecisionChange struct {
+	ID             int64 `json:"id"`
+	PricePrecision int32 `json:"price_precision"`
+}
+
+// ActionCancelAll cancels the orders of a member in the books, of the market of the command or of every market when
+// it has none.
+const ActionCancelAll pkg.PayloadAction = "cancel_all"
+
+// CancelAll is the member whose orders a cancel all command cancels, of the side unless it's empty.
+type CancelAll struct {
+	MemberID int64         `json:"member_id"`
+	Side     pkg.OrderSide `json:"side,omitempty"`
+}
+
+// ActionTradingState sets the trading state of the book of the market of the command.
+const ActionTradingState pkg.PayloadAction = "trading_state"
+
+// ActionStartAuction holds the book of the market of the command in a call auction, ActionUncross uncrosses it.
+const (
+	ActionStartAuction pkg.PayloadAction = "start_auction"
+	ActionUncross      pkg.PayloadAction = "uncross"
+)
+
+// ActionLiftCircuitBreaker lets the book of the market of the command take orders before the cool-down of its
+// tripped circuit breaker is over.
+const ActionLiftCircuitBreaker pkg.PayloadAction = "lift_circuit_breaker"
+
+// ActionIndexPrice moves the index price of the book of the market of the command, the stop orders triggered by the
+// index price are triggered from it.
+const ActionIndexPrice pkg.PayloadAction = "index_price"
+
+// IndexPriceUpdate is the index price an index_price command moves the book to, from the price feed of the market.
+type IndexPriceUpdate struct {
+	Price decimal.Decimal `json:"price"`
+}
+
+// TradingStateChange is the trading state a trading_state command sets, for the state change of id.
+type TradingStateChange struct {
+	ID    int64              `json:"id"`
+	State types.TradingState `json:"state"`
+}
+
+// Amendment is the price and the quantity an amend command sets an order to, nil keeps the ones it has. The
+// quantity is the whole quantity of the order, its filled part included.
+type Amendment struct {
+	Price    *decimal.Decimal `json:"price,omitempty"`
+	Quantity *decimal.Decimal `json:"quantity,omitempty"`
+}
+
+// MatchingPayload is a command of the engine, with the options of the order it submits.
+type MatchingPayload struct {
+	pkg.MatchingPayloadMessage
+	Options *OrderOptions `json:"options,omitempty"`
+	// CommandID is set by the producers of cancel commands, the outcome of the cancel is published with it
+	CommandID string `json:"command_id,omitempty"`
+	// Precision is the precision change of a rekey command
+	Precision *PrecisionChange `json:"precision,omitempty"`
+	// Amendment is the amendment of an amend command
+	Amendment *Amendment `json:"amendment,omitempty"`
+	// Batch is the submits and the cancels of a batch command
+	Batch *MatchingBatch `json:"batch,omitempty"`
+	// CancelAll is the member and the side of a cancel all command
+	CancelAll *CancelAll `json:"cancel_all,omitempty"`
+	// TradingState is the state a trading_state command sets
+	TradingState *TradingStateChange `json:"trading_state,omitempty"`
+	// IndexPrice is the index price of an index_price command
+	IndexPrice *IndexPriceUpdate `json:"index_price,omitempty"`
+	// ReceivedAt is when the engine server received the command, the latency of its cycles counts from it so the
+	// wait of the command in the queue of its market is measured. It's not sent, zero for the replayed commands
+	ReceivedAt time.Time `json:"-"`
+}
+
+// Market returns the market of the book the command is for, it's false for the commands of no single book: the
+// reloads, the cancel alls of every market and the batches without entries.
+func (p *MatchingPayload) Market() (pkg.Symbol, bool) {
+	switch {
+	case p.Key != nil:
+		return p.Key.Symbol, true
+	case p.Order != nil:
+		return p.Order.Symbol, true
+	case p.Batch != nil:
+		return p.Batch.Symbol()
+	case p.Action == ActionCancelAll || p.Action == ActionRekey || p.Action == ActionTradingState ||
+		p.Action == ActionStartAuction || p.Action == ActionUncross || p.Action == ActionLiftCircuitBreaker ||
+		p.Action == ActionIndexPrice:
+		return p.Symbol, len(p.Symbol.BaseCurrency) > 0
+	}
+
+	return pkg.Symbol{}, false
+}
+
+// MatchingBatch is the submits and the cancels of the orders of a market the engine processes in one cycle, in
+// their order.
+type MatchingBatch struct {
+	ID      string             `json:"id"`
+	Entries []*MatchingPayload `json:"entries"`
+}
+
+// Symbol returns the market of the batch, the one of its first entry.
+func (b *MatchingBatch) Symbol() (pkg.Symbol, bool) {
+	for _, entry := range b.Entries {
+		switch {
+		case entry.Order != nil:
+			return entry.Order.Symbol, true
+		case entry.Key != nil:
+			return entry.Key.Symbol, true
+		}
+	}
+
+	return pkg.Symbol{}, false
+}
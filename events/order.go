@@ -0,0 +1,429 @@
+package events
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+// ActionCancelReplace cancels an order and inserts its replacement in the same matching cycle.
+const ActionCancelReplace pkg.PayloadAction = "cancel_replace"
+
+// ActionCreate inserts an order the API placed with an id of its own, then submits it.
+const ActionCreate pkg.PayloadAction = "create"
+
+// ActionDecrement takes a quantity off an order the engine keeps in the book and unlocks its funds.
+const ActionDecrement pkg.PayloadAction = "decrement"
+
+// ActionCancelAlreadyGone answers a cancel command of an order which left the book before it, filled or cancelled.
+const ActionCancelAlreadyGone pkg.PayloadAction = "cancel_already_gone"
+
+// ActionCancelNeverExisted answers a cancel command of an order the engine has no trace of.
+const ActionCancelNeverExisted pkg.PayloadAction = "cancel_never_existed"
+
+// ActionCancelRejected answers a cancel command the engine refused, the order stays in the book of a halted market.
+const ActionCancelRejected pkg.PayloadAction = "cancel_rejected"
+
+// ActionReprice moves an order the engine keeps to the price and the stop price it re-keyed it at.
+const ActionReprice pkg.PayloadAction = "reprice"
+
+// ActionAmend changes the price or the quantity of an order the engine keeps, the command carries the amendment
+// and the order event the price and the quantity the order has once amended.
+const ActionAmend pkg.PayloadAction = "amend"
+
+// ActionAmendRejected answers an amend command the engine didn't apply, the order is left as it was.
+const ActionAmendRejected pkg.PayloadAction = "amend_rejected"
+
+// ActionPersist inserts an order the API already submitted to the engine and locks its funds.
+const ActionPersist pkg.PayloadAction = "persist"
+
+// ActionBatch locks the funds of the orders of a batch, then submits them and cancels the orders of its cancels in
+// one matching cycle, in their order.
+const ActionBatch pkg.PayloadAction = "batch"
+
+// ActionBatchResult answers a batch with the outcome of each of its entries.
+const ActionBatchResult pkg.PayloadAction = "batch_result"
+
+// Batch is the orders a batch submits and cancels, in their order.
+type Batch struct {
+	ID string `json:"id"`
+	// AllOrNothing submits none of the orders when the funds of one of them can't be locked
+	AllOrNothing bool          `json:"all_or_nothing,omitempty"`
+	Entries      []*BatchEntry `json:"entries"`
+}
+
+// BatchEntry is an order a batch submits or cancels, a batch result tells whether the engine accepted it and the
+// reason it cancelled it.
+type BatchEntry struct {
+	Action   pkg.PayloadAction `json:"action"`
+	ID       int64             `json:"id"`
+	UUID     uuid.UUID         `json:"uuid"`
+	Accepted bool              `json:"accepted,omitempty"`
+	Reason   string            `json:"reason,omitempty"`
+}
+
+// Order is the latest order event, consumed by the order processor.
+//
+// v1: action, id and the optional reason of the cancel.
+// v2: adds the envelope and the uuid of the order.
+// v3: adds the id of the order a cancel-replace replaced.
+// v4: adds the attributes of the order a create inserts.
+// v5: adds the quantity a decrement takes off the order.
+// v6: adds the id of the cancel command an outcome answers, and the outcomes of the cancels which didn't cancel.
+// v7: adds the price and the stop price a reprice moves the order to.
+// v8: adds the amends, with the price and the quantity they set the order to, and the amends rejected.
+// v9: adds the batches and their results.
+// v10: adds the client id of the orders the API submits.
+type Order struct {
+	Envelope
+	Action     pkg.PayloadAction `json:"action"`
+	ID         int64             `json:"id"`
+	UUID       uuid.UUID         `json:"uuid"`
+	Reason     string            `json:"reason,omitempty"`
+	ReplacedID int64             `json:"replaced_id,omitempty"`
+	Attributes json.RawMessage   `json:"attributes,omitempty"`
+	Quantity   *decimal.Decimal  `json:"quantity,omitempty"`
+	CommandID  string            `json:"command_id,omitempty"`
+	Price      *decimal.Decimal  `json:"price,omitempty"`
+	StopPrice  *decimal.Decimal  `json:"stop_price,omitempty"`
+	Batch      *Batch            `json:"batch,omitempty"`
+	ClientID   *uuid.UUID        `json:"client_id,omitempty"`
+}
+
+type orderV1 struct {
+	Action pkg.PayloadAction `json:"action"`
+	ID     int64             `json:"id"`
+	Reason string            `json:"reason,omitempty"`
+}
+
+func init() {
+	Register(TypeOrder, 1, decodeOrderV1, encodeOrderV1)
+	Register(TypeOrder, 2, decodeOrderV2, encodeOrderV2)
+	Register(TypeOrder, 3, decodeOrderV3, encodeOrderV3)
+	Register(TypeOrder, 4, decodeOrderV4, encodeOrderV4)
+	Register(TypeOrder, 5, decodeOrderV5, encodeOrderV5)
+	Register(TypeOrder, 6, decodeOrderV6, encodeOrderV6)
+	Register(TypeOrder, 7, decodeOrderV7, encodeOrderV7)
+	Register(TypeOrder, 8, decodeOrderV8, encodeOrderV8)
+	Register(TypeOrder, 9, decodeOrderV9, encodeOrderV9)
+	Register(TypeOrder, 10, decodeOrderV10, encodeOrderV10)
+}
+
+func NewOrder(action pkg.PayloadAction, id int64, order_uuid uuid.UUID, reason string) *Order {
+	return &Order{
+		Envelope: Envelope{Type: TypeOrder, Version: LatestVersion(TypeOrder)},
+		Action:   action,
+		ID:       id,
+		UUID:     order_uuid,
+		Reason:   reason,
+	}
+}
+
+// NewOrderCreate is the create of an order, attributes is the order encoded in JSON.
+func NewOrderCreate(id int64, order_uuid uuid.UUID, attributes []byte) *Order {
+	order := NewOrder(ActionCreate, id, order_uuid, "")
+	order.Attributes = attributes
+
+	return order
+}
+
+// NewOrderPersist is the persist of an acknowledged order, attributes is the order encoded in JSON.
+func NewOrderPersist(id int64, order_uuid uuid.UUID, attributes []byte) *Order {
+	order := NewOrder(ActionPersist, id, order_uuid, "")
+	order.Attributes = attributes
+
+	return order
+}
+
+// NewOrderDecrement is the decrement of an order the engine keeps in the book by quantity.
+func NewOrderDecrement(id int64, order_uuid uuid.UUID, quantity decimal.Decimal, reason string) *Order {
+	order := NewOrder(ActionDecrement, id, order_uuid, reason)
+	order.Quantity = &quantity
+
+	return order
+}
+
+// NewOrderCancelOutcome is the answer of the engine to the cancel command command_id, action tells what it found.
+func NewOrderCancelOutcome(action pkg.PayloadAction, id int64, order_uuid uuid.UUID, reason, command_id string) *Order {
+	order := NewOrder(action, id, order_uuid, reason)
+	order.CommandID = command_id
+
+	return order
+}
+
+// NewOrderReprice is the reprice of an order the engine keeps to price and stop_price, stop_price is zero
+// unless it's a stop order.
+func NewOrderReprice(id int64, order_uuid uuid.UUID, price, stop_price decimal.Decimal, reason string) *Order {
+	order := NewOrder(ActionReprice, id, order_uuid, reason)
+	order.Price = &price
+	order.StopPrice = &stop_price
+
+	return order
+}
+
+// NewOrderAmend is the amend of an order the engine keeps to price and quantity, quantity is the whole quantity
+// of the order, its filled part included.
+func NewOrderAmend(id int64, order_uuid uuid.UUID, price, quantity decimal.Decimal) *Order {
+	order := NewOrder(ActionAmend, id, order_uuid, "")
+	order.Price = &price
+	order.Quantity = &quantity
+
+	return order
+}
+
+// NewOrderBatch is a batch or the result of a batch, action tells which.
+func NewOrderBatch(action pkg.PayloadAction, batch *Batch) *Order {
+	order := NewOrder(action, 0, uuid.Nil, "")
+	order.Batch = batch
+
+	return order
+}
+
+// ProducesOrderAttributes reports whether the order events producers emit carry the attributes of the
+// order, creates can't be sent in the previous versions.
+func ProducesOrderAttributes() bool {
+	return ProducerVersion(TypeOrder) >= 4
+}
+
+// ProducesOrderDecrements reports whether the order events producers emit carry the quantity of a decrement,
+// decrements can't be sent in the previous versions.
+func ProducesOrderDecrements() bool {
+	return ProducerVersion(TypeOrder) >= 5
+}
+
+// ProducesCancelOutcomes reports whether the order events producers emit tell the outcomes of the cancel commands
+// apart, every cancel command is answered with a cancel in the previous versions.
+func ProducesCancelOutcomes() bool {
+	return ProducerVersion(TypeOrder) >= 6
+}
+
+// ProducesOrderReprices reports whether the order events producers emit carry the prices of a reprice,
+// reprices can't be sent in the previous versions.
+func ProducesOrderReprices() bool {
+	return ProducerVersion(TypeOrder) >= 7
+}
+
+// ProducesOrderAmends reports whether the order processors consuming the order events producers emit apply
+// amends, orders can't be amended before.
+func ProducesOrderAmends() bool {
+	return ProducerVersion(TypeOrder) >= 8
+}
+
+// ProducesOrderBatches reports whether the order processors consuming the order events producers emit process
+// batches, orders can't be placed in batches before.
+func ProducesOrderBatches() bool {
+	return ProducerVersion(TypeOrder) >= 9
+}
+
+func DecodeOrder(payload []byte) (*Order, error) {
+	event, err := Decode(TypeOrder, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return event.(*Order), nil
+}
+
+func EncodeOrder(order *Order) interface{} {
+	return Encode(TypeOrder, order)
+}
+
+func decodeOrderV1(payload []byte) (interface{}, error) {
+	var order orderV1
+	if err := json.Unmarshal(payload, &order); err != nil {
+		return nil, err
+	}
+
+	return NewOrder(order.Action, order.ID, uuid.Nil, order.Reason), nil
+}
+
+func encodeOrderV1(event interface{}) interface{} {
+	order := event.(*Order)
+
+	return orderV1{
+		Action: order.Action,
+		ID:     order.ID,
+		Reason: order.Reason,
+	}
+}
+
+func decodeOrderV2(payload []byte) (interface{}, error) {
+	var order *Order
+	if err := json.Unmarshal(payload, &order); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func encodeOrderV2(event interface{}) interface{} {
+	order := *event.(*Order)
+	order.Envelope = Envelope{Type: TypeOrder, Version: 2}
+	order.ClientID = nil
+	order.Batch = nil
+	order.ReplacedID = 0
+	order.Attributes = nil
+	order.Quantity = nil
+	order.CommandID = ""
+	order.Price = nil
+	order.StopPrice = nil
+
+	return order
+}
+
+func decodeOrderV3(payload []byte) (interface{}, error) {
+	var order *Order
+	if err := json.Unmarshal(payload, &order); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func encodeOrderV3(event interface{}) interface{} {
+	order := *event.(*Order)
+	order.Envelope = Envelope{Type: TypeOrder, Version: 3}
+	order.ClientID = nil
+	order.Batch = nil
+	order.Attributes = nil
+	order.Quantity = nil
+	order.CommandID = ""
+	order.Price = nil
+	order.StopPrice = nil
+
+	return order
+}
+
+func decodeOrderV4(payload []byte) (interface{}, error) {
+	var order *Order
+	if err := json.Unmarshal(payload, &order); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func encodeOrderV4(event interface{}) interface{} {
+	order := *event.(*Order)
+	order.Envelope = Envelope{Type: TypeOrder, Version: 4}
+	order.ClientID = nil
+	order.Batch = nil
+	order.Quantity = nil
+	order.CommandID = ""
+	order.Price = nil
+	order.StopPrice = nil
+
+	return order
+}
+
+func decodeOrderV5(payload []byte) (interface{}, error) {
+	var order *Order
+	if err := json.Unmarshal(payload, &order); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func encodeOrderV5(event interface{}) interface{} {
+	order := *event.(*Order)
+	order.Envelope = Envelope{Type: TypeOrder, Version: 5}
+	order.ClientID = nil
+	order.Batch = nil
+	order.CommandID = ""
+	order.Price = nil
+	order.StopPrice = nil
+
+	return order
+}
+
+func decodeOrderV6(payload []byte) (interface{}, error) {
+	var order *Order
+	if err := json.Unmarshal(payload, &order); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func encodeOrderV6(event interface{}) interface{} {
+	order := *event.(*Order)
+	order.Envelope = Envelope{Type: TypeOrder, Version: 6}
+	order.ClientID = nil
+	order.Batch = nil
+	order.Price = nil
+	order.StopPrice = nil
+
+	return order
+}
+
+func decodeOrderV7(payload []byte) (interface{}, error) {
+	var order *Order
+	if err := json.Unmarshal(payload, &order); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func encodeOrderV7(event interface{}) interface{} {
+	order := *event.(*Order)
+	order.Envelope = Envelope{Type: TypeOrder, Version: 7}
+	order.ClientID = nil
+	order.Batch = nil
+
+	return order
+}
+
+func decodeOrderV8(payload []byte) (interface{}, error) {
+	var order *Order
+	if err := json.Unmarshal(payload, &order); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func encodeOrderV8(event interface{}) interface{} {
+	order := *event.(*Order)
+	order.Envelope = Envelope{Type: TypeOrder, Version: 8}
+	order.ClientID = nil
+	order.Batch = nil
+
+	return order
+}
+
+func decodeOrderV9(payload []byte) (interface{}, error) {
+	var order *Order
+	if err := json.Unmarshal(payload, &order); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func encodeOrderV9(event interface{}) interface{} {
+	order := *event.(*Order)
+	order.Envelope = Envelope{Type: TypeOrder, Version: 9}
+	order.ClientID = nil
+
+	return order
+}
+
+func decodeOrderV10(payload []byte) (interface{}, error) {
+	var order *Order
+	if err := json.Unmarshal(payload, &order); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func encodeOrderV10(event interface{}) interface{} {
+	order := *event.(*Order)
+	order.Envelope = Envelope{Type: TypeOrder, Version: 10}
+
+	return order
+}
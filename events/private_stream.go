@@ -0,0 +1,50 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// PrivateStreamTopic carries the private events of the members from the workers to the API processes, which send
+// them to the websocket connections of their members. The events are keyed by the uid of their member.
+const PrivateStreamTopic = "finex.private"
+
+// PrivateEvent is an event of a private stream of a member, Payload is the message sent to the connections
+// subscribed to the stream.
+type PrivateEvent struct {
+	UID     string          `json:"uid"`
+	Stream  string          `json:"stream"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func DecodePrivateEvent(payload []byte) (*PrivateEvent, error) {
+	var event *PrivateEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+
+	if event == nil || len(event.UID) == 0 || len(event.Stream) == 0 {
+		return nil, errors.New("private event without a member or a stream")
+	}
+
+	return event, nil
+}
+
+// PublishPrivate publishes message to the stream of the member uid. The events are published along the changes they
+// report, a failure is logged rather than failing the change: the client replays what it missed from the history.
+func PublishPrivate(uid string, stream string, message interface{}) {
+	if config.Bus == nil {
+		return
+	}
+
+	payload, err := json.Marshal(message)
+	if err == nil {
+		err = config.Bus.Publish(PrivateStreamTopic, uid, &PrivateEvent{UID: uid, Stream: stream, Payload: payload})
+	}
+
+	if err != nil {
+		config.Logger.Errorf("Failed to publish the %s event of %s: %v", stream, uid, err)
+	}
+}
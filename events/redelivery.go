@@ -0,0 +1,32 @@
+package events
+
+import "errors"
+
+// ErrRedeliver is matched by the errors of the messages which failed on something which comes back, the broker
+// being down. The consumers don't commit them, the broker delivers them again once the consumer is restarted.
+var ErrRedeliver = errors.New("message to deliver again")
+
+type redeliverError struct {
+	err error
+}
+
+func (e *redeliverError) Error() string {
+	return e.err.Error()
+}
+
+func (e *redeliverError) Unwrap() error {
+	return e.err
+}
+
+func (e *redeliverError) Is(target error) bool {
+	return target == ErrRedeliver
+}
+
+// Redeliver marks err as the error of a message to process again, it still matches the errors err wraps.
+func Redeliver(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &redeliverError{err: err}
+}
@@ -0,0 +1,138 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/zsmartex/finex/config"
+)
+
+type EventType string
+
+const (
+	TypeTrade EventType = "trade"
+	TypeOrder EventType = "order"
+)
+
+var ErrUnsupportedVersion = errors.New("unsupported event version")
+
+// Envelope starts every versioned event, payloads produced before versioning don't have it and are version 1.
+type Envelope struct {
+	Type    EventType `json:"type"`
+	Version int       `json:"version"`
+}
+
+// Decoder turns a payload of one version into the latest event struct.
+type Decoder func(payload []byte) (interface{}, error)
+
+// Encoder turns the latest event struct into the payload of one version.
+type Encoder func(event interface{}) interface{}
+
+type registryKey struct {
+	Type    EventType
+	Version int
+}
+
+type registryEntry struct {
+	decoder Decoder
+	encoder Encoder
+}
+
+var registry = make(map[registryKey]registryEntry)
+var latestVersions = make(map[EventType]int)
+
+// Register adds a version of an event type, the greatest registered version is the latest one.
+func Register(event_type EventType, version int, decoder Decoder, encoder Encoder) {
+	registry[registryKey{event_type, version}] = registryEntry{decoder, encoder}
+
+	if version > latestVersions[event_type] {
+		latestVersions[event_type] = version
+	}
+}
+
+func LatestVersion(event_type EventType) int {
+	return latestVersions[event_type]
+}
+
+// SupportedVersions returns the registered versions of an event type in ascending order.
+func SupportedVersions(event_type EventType) []int {
+	versions := make([]int, 0)
+	for key := range registry {
+		if key.Type == event_type {
+			versions = append(versions, key.Version)
+		}
+	}
+
+	sort.Ints(versions)
+
+	return versions
+}
+
+// ProducerVersion is the version producers emit, it's set by event_versions in the config
+// so producers can keep emitting the previous version until every consumer is upgraded.
+func ProducerVersion(event_type EventType) int {
+	if version, ok := config.EventVersions[string(event_type)]; ok {
+		if _, registered := registry[registryKey{event_type, version}]; registered {
+			return version
+		}
+	}
+
+	return LatestVersion(event_type)
+}
+
+// PayloadVersion reads the version of a payload.
+func PayloadVersion(payload []byte) (Envelope, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return envelope, err
+	}
+
+	if envelope.Version == 0 {
+		envelope.Version = 1
+	}
+
+	return envelope, nil
+}
+
+// Decode returns the latest event struct of a payload of any supported version, its errors are ErrMalformed.
+func Decode(event_type EventType, payload []byte) (interface{}, error) {
+	envelope, err := PayloadVersion(payload)
+	if err != nil {
+		return nil, Malformed(err)
+	}
+
+	if len(envelope.Type) > 0 && envelope.Type != event_type {
+		return nil, Malformed(fmt.Errorf("expected a %s event, got %s", event_type, envelope.Type))
+	}
+
+	entry, ok := registry[registryKey{event_type, envelope.Version}]
+	if !ok {
+		return nil, Malformed(fmt.Errorf("%w: %s v%d", ErrUnsupportedVersion, event_type, envelope.Version))
+	}
+
+	event, err := entry.decoder(payload)
+	if err != nil {
+		return nil, Malformed(err)
+	}
+
+	return event, nil
+}
+
+// EncodeVersion returns the payload of an event in the given version.
+func EncodeVersion(event_type EventType, version int, event interface{}) (interface{}, error) {
+	entry, ok := registry[registryKey{event_type, version}]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s v%d", ErrUnsupportedVersion, event_type, version)
+	}
+
+	return entry.encoder(event), nil
+}
+
+// Encode returns the payload of an event in the version producers emit.
+func Encode(event_type EventType, event interface{}) interface{} {
+	payload, _ := EncodeVersion(event_type, ProducerVersion(event_type), event)
+
+	return payload
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// BatchSuffix is appended to the event of a batched stream, clients opt in to the batches by
+// subscribing to <market>.<event>-batch instead of <market>.<event>.
+const BatchSuffix = "-batch"
+
+// StreamBatch is one frame of a batched stream, the updates published during the interval in order.
+type StreamBatch struct {
+	Updates []interface{} `json:"updates"`
+	// Sequence is the sequence of the last update, the id of the last trade for the trades stream
+	Sequence int64 `json:"sequence,omitempty"`
+}
+
+// StreamBatcher coalesces the updates of a public stream into at most one frame per market per interval.
+// The frame-per-event stream is still published, the batches are an additional stream.
+type StreamBatcher struct {
+	Event    string
+	Interval time.Duration
+
+	mutex   sync.Mutex
+	pending map[string]*StreamBatch
+	// markets keeps the order the markets got their first update in, so frames go out in a stable order
+	markets []string
+}
+
+// NewStreamBatcher returns nil when interval isn't positive, a nil batcher drops its updates.
+func NewStreamBatcher(event string, interval time.Duration) *StreamBatcher {
+	if interval <= 0 {
+		return nil
+	}
+
+	return &StreamBatcher{
+		Event:    event,
+		Interval: interval,
+		pending:  make(map[string]*StreamBatch),
+	}
+}
+
+// Add queues an update of market for the next frame.
+func (b *StreamBatcher) Add(market string, sequence int64, update interface{}) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	batch, ok := b.pending[market]
+	if !ok {
+		batch = &StreamBatch{Updates: make([]interface{}, 0, 1)}
+		b.pending[market] = batch
+		b.markets = append(b.markets, market)
+	}
+
+	batch.Updates = append(batch.Updates, update)
+	if sequence > batch.Sequence {
+		batch.Sequence = sequence
+	}
+}
+
+// Flush takes the pending frames, markets without updates since the last flush get none.
+func (b *StreamBatcher) Flush() (markets []string, batches []*StreamBatch) {
+	if b == nil {
+		return nil, nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	markets = b.markets
+	batches = make([]*StreamBatch, len(markets))
+	for i, market := range markets {
+		batches[i] = b.pending[market]
+	}
+
+	b.pending = make(map[string]*StreamBatch, len(markets))
+	b.markets = nil
+
+	return markets, batches
+}
+
+// Start publishes the frames every interval until the process exits.
+func (b *StreamBatcher) Start() {
+	if b == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(b.Interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			b.Publish()
+		}
+	}()
+}
+
+// Publish flushes the pending frames and publishes them, the engine publishes the last ones before it exits.
+func (b *StreamBatcher) Publish() {
+	markets, batches := b.Flush()
+	for i, market := range markets {
+		config.RangoClient.EnqueueEvent(pkg.EnqueueEventKindPublic, market, b.Event+BatchSuffix, batches[i])
+	}
+}
@@ -0,0 +1,58 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStreamBatcherCoalescesPerMarket(t *testing.T) {
+	batcher := NewStreamBatcher("depth", 50*time.Millisecond)
+
+	batcher.Add("btcusdt", 1, "a")
+	batcher.Add("ethusdt", 7, "x")
+	batcher.Add("btcusdt", 2, "b")
+	batcher.Add("btcusdt", 3, "c")
+
+	markets, batches := batcher.Flush()
+	if len(markets) != 2 || markets[0] != "btcusdt" || markets[1] != "ethusdt" {
+		t.Fatalf("expected a frame for btcusdt then ethusdt, got %v", markets)
+	}
+
+	if batches[0].Sequence != 3 || len(batches[0].Updates) != 3 || batches[0].Updates[2] != "c" {
+		t.Errorf("expected the 3 updates of btcusdt with sequence 3, got %+v", batches[0])
+	}
+
+	if batches[1].Sequence != 7 || len(batches[1].Updates) != 1 {
+		t.Errorf("expected the update of ethusdt with sequence 7, got %+v", batches[1])
+	}
+
+	if markets, _ := batcher.Flush(); len(markets) != 0 {
+		t.Errorf("expected no frame without updates, got %v", markets)
+	}
+}
+
+func TestStreamBatchPayload(t *testing.T) {
+	payload, err := json.Marshal(&StreamBatch{Updates: []interface{}{map[string]int{"id": 1}}, Sequence: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(payload) != `{"updates":[{"id":1}],"sequence":42}` {
+		t.Errorf("unexpected payload %s", payload)
+	}
+}
+
+func TestStreamBatcherOff(t *testing.T) {
+	batcher := NewStreamBatcher("trades", 0)
+	if batcher != nil {
+		t.Fatal("expected no batcher without an interval")
+	}
+
+	batcher.Add("btcusdt", 1, "a")
+	batcher.Start()
+
+	if markets, batches := batcher.Flush(); markets != nil || batches != nil {
+		t.Errorf("expected an off batcher to drop its updates")
+	}
+}
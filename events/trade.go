@@ -0,0 +1,177 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+// Trade is the latest trade event, produced by the matching engine to the trade executor.
+//
+// v1: the bare matching trade.
+// v2: adds the envelope and the side of the taker.
+// v3: adds the time the engine matched the trade at.
+// v4: adds the version of the market configuration the engine matched the trade with.
+// v5: adds the fees the engine computed for the maker and the taker, with their currencies.
+// v6: adds the sequence of the trade in its market.
+type Trade struct {
+	Envelope
+	pkg.Trade
+	TakerSide pkg.OrderSide `json:"taker_side"`
+	// MatchedAt is nil for the trades of the previous versions, they're created at the time they're executed
+	MatchedAt *time.Time `json:"matched_at,omitempty"`
+	// ConfigVersion is zero for the trades of the previous versions
+	ConfigVersion int64 `json:"config_version,omitempty"`
+	// MakerFee and TakerFee are nil for the trades of the previous versions, a buyer pays its fee in the base
+	// currency and a seller in the quote currency
+	MakerFee         *decimal.Decimal `json:"maker_fee,omitempty"`
+	MakerFeeCurrency string           `json:"maker_fee_currency,omitempty"`
+	TakerFee         *decimal.Decimal `json:"taker_fee,omitempty"`
+	TakerFeeCurrency string           `json:"taker_fee_currency,omitempty"`
+	// Sequence is zero for the trades of the previous versions, the engine numbers the trades of a market one after
+	// another and never gives two of them the same sequence
+	Sequence int64 `json:"sequence,omitempty"`
+}
+
+func init() {
+	Register(TypeTrade, 1, decodeTradeV1, encodeTradeV1)
+	Register(TypeTrade, 2, decodeTradeV2, encodeTradeV2)
+	Register(TypeTrade, 3, decodeTradeV3, encodeTradeV3)
+	Register(TypeTrade, 4, decodeTradeV4, encodeTradeV4)
+	Register(TypeTrade, 5, decodeTradeV5, encodeTradeV5)
+	Register(TypeTrade, 6, decodeTradeV6, encodeTradeV6)
+}
+
+func NewTrade(trade *pkg.Trade) *Trade {
+	return &Trade{
+		Envelope:  Envelope{Type: TypeTrade, Version: LatestVersion(TypeTrade)},
+		Trade:     *trade,
+		TakerSide: trade.TakerOrder.Side,
+	}
+}
+
+func DecodeTrade(payload []byte) (*Trade, error) {
+	event, err := Decode(TypeTrade, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return event.(*Trade), nil
+}
+
+func EncodeTrade(trade *Trade) interface{} {
+	return Encode(TypeTrade, trade)
+}
+
+func decodeTradeV1(payload []byte) (interface{}, error) {
+	var trade *pkg.Trade
+	if err := json.Unmarshal(payload, &trade); err != nil {
+		return nil, err
+	}
+
+	return NewTrade(trade), nil
+}
+
+func encodeTradeV1(event interface{}) interface{} {
+	return event.(*Trade).Trade
+}
+
+func decodeTradeV2(payload []byte) (interface{}, error) {
+	var trade *Trade
+	if err := json.Unmarshal(payload, &trade); err != nil {
+		return nil, err
+	}
+
+	return trade, nil
+}
+
+func encodeTradeV2(event interface{}) interface{} {
+	trade := *event.(*Trade)
+	trade.Envelope = Envelope{Type: TypeTrade, Version: 2}
+	trade.Sequence = 0
+	trade.MatchedAt = nil
+	trade.ConfigVersion = 0
+	trade.withoutFees()
+
+	return trade
+}
+
+func decodeTradeV3(payload []byte) (interface{}, error) {
+	var trade *Trade
+	if err := json.Unmarshal(payload, &trade); err != nil {
+		return nil, err
+	}
+
+	return trade, nil
+}
+
+func encodeTradeV3(event interface{}) interface{} {
+	trade := *event.(*Trade)
+	trade.Envelope = Envelope{Type: TypeTrade, Version: 3}
+	trade.Sequence = 0
+	trade.ConfigVersion = 0
+	trade.withoutFees()
+
+	return trade
+}
+
+func decodeTradeV4(payload []byte) (interface{}, error) {
+	var trade *Trade
+	if err := json.Unmarshal(payload, &trade); err != nil {
+		return nil, err
+	}
+
+	return trade, nil
+}
+
+func encodeTradeV4(event interface{}) interface{} {
+	trade := *event.(*Trade)
+	trade.Envelope = Envelope{Type: TypeTrade, Version: 4}
+	trade.Sequence = 0
+	trade.withoutFees()
+
+	return trade
+}
+
+func decodeTradeV5(payload []byte) (interface{}, error) {
+	var trade *Trade
+	if err := json.Unmarshal(payload, &trade); err != nil {
+		return nil, err
+	}
+
+	return trade, nil
+}
+
+func encodeTradeV5(event interface{}) interface{} {
+	trade := *event.(*Trade)
+	trade.Envelope = Envelope{Type: TypeTrade, Version: 5}
+	trade.Sequence = 0
+
+	return trade
+}
+
+func decodeTradeV6(payload []byte) (interface{}, error) {
+	var trade *Trade
+	if err := json.Unmarshal(payload, &trade); err != nil {
+		return nil, err
+	}
+
+	return trade, nil
+}
+
+func encodeTradeV6(event interface{}) interface{} {
+	trade := *event.(*Trade)
+	trade.Envelope = Envelope{Type: TypeTrade, Version: 6}
+
+	return trade
+}
+
+// withoutFees drops the fees for the versions before v5.
+func (t *Trade) withoutFees() {
+	t.MakerFee = nil
+	t.MakerFeeCurrency = ""
+	t.TakerFee = nil
+	t.TakerFeeCurrency = ""
+}
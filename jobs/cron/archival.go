@@ -0,0 +1,28 @@
+package cron
+
+import (
+	"github.com/jasonlvhit/gocron"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+)
+
+// ArchivalJob archives the IEOs and the markets which ended long ago, see the archival config.
+type ArchivalJob struct {
+}
+
+func (j *ArchivalJob) Process() {
+	s := gocron.NewScheduler()
+	s.Every(1).Day().At("01:00:00").Do(archiveStale)
+	<-s.Start()
+}
+
+func archiveStale() {
+	ieos, markets, err := models.ArchiveStale(config.DataBase, jobClock.Now(), config.Archival.IEOAfter, config.Archival.MarketAfter)
+	if err != nil {
+		config.Logger.Errorf("Failed to archive the stale IEOs and markets: %v", err)
+	}
+
+	if ieos > 0 || markets > 0 {
+		config.Logger.Infof("Archived %d IEOs and %d markets", ieos, markets)
+	}
+}
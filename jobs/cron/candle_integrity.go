@@ -0,0 +1,62 @@
+package cron
+
+import (
+	"math/rand"
+
+	"github.com/jasonlvhit/gocron"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/types"
+)
+
+type CandleIntegrityJob struct {
+}
+
+func (j *CandleIntegrityJob) Process() {
+	s := gocron.NewScheduler()
+	s.Every(1).Day().At("01:00:00").Do(checkCandles)
+	<-s.Start()
+}
+
+// sampleMarkets picks n of the markets at random, all of them when n is zero.
+func sampleMarkets(markets []*models.Market, n int) []*models.Market {
+	if n <= 0 || n >= len(markets) {
+		return markets
+	}
+
+	rand.Shuffle(len(markets), func(i, j int) {
+		markets[i], markets[j] = markets[j], markets[i]
+	})
+
+	return markets[:n]
+}
+
+func storedCandlePeriod(period string) bool {
+	for _, p := range models.CandlePeriods {
+		if p == period {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkCandles() {
+	var markets []*models.Market
+	config.DataBase.Where("state = ?", types.MarketStateEndabled).Find(&markets)
+
+	now := jobClock.Now()
+	for _, market := range sampleMarkets(markets, config.CandleIntegrity.Markets) {
+		for _, period := range config.CandleIntegrity.Periods {
+			if !storedCandlePeriod(period) {
+				config.Logger.Errorf("Unknown candle period %s in candle_integrity.periods", period)
+				continue
+			}
+
+			discrepancies := models.CheckCandles(market, period, now, config.CandleIntegrity.AutoRepair, config.CandleIntegrity.RepairThreshold)
+			if len(discrepancies) > 0 {
+				config.Logger.Warnf("Found %d candle discrepancies on %s %s", len(discrepancies), market.Symbol, period)
+			}
+		}
+	}
+}
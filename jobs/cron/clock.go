@@ -0,0 +1,6 @@
+package cron
+
+import "github.com/zsmartex/finex/clock"
+
+// jobClock is the time the jobs run at, tests set it to a fake clock.
+var jobClock clock.Clock = clock.Real
@@ -0,0 +1,47 @@
+package cron
+
+import (
+	"time"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+)
+
+// makerStatsRollupDelay is how long after the end of an hour it's rolled up, for the trades of the hour to be executed.
+const makerStatsRollupDelay = 5 * time.Minute
+
+// MakerStatsSampleJob samples the quotes of the makers enrolled in the maker program.
+type MakerStatsSampleJob struct {
+}
+
+func (j *MakerStatsSampleJob) Process() {
+	interval := config.MakerProgram.SampleInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	if err := models.SampleMakerQuotes(config.DataBase, jobClock.Now()); err != nil {
+		config.Logger.Errorf("Failed to sample the quotes of the makers: %v", err)
+	}
+
+	time.Sleep(interval)
+}
+
+// MakerStatsRollupJob rolls up the activity of the enrolled makers and the volume of the markets of every hour.
+type MakerStatsRollupJob struct {
+}
+
+func (j *MakerStatsRollupJob) Process() {
+	now := jobClock.Now()
+	next := now.Truncate(time.Hour).Add(makerStatsRollupDelay)
+	if !next.After(now) {
+		next = next.Add(time.Hour)
+	}
+
+	time.Sleep(next.Sub(now))
+
+	hour := next.Truncate(time.Hour).Add(-time.Hour)
+	if err := models.RollupMakerStats(config.DataBase, hour); err != nil {
+		config.Logger.Errorf("Failed to roll up the maker stats of %s: %v", hour.Format(time.RFC3339), err)
+	}
+}
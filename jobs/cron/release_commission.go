@@ -19,59 +19,50 @@ func (j *ReleaseCommissionJob) Process() {
 	<-s.Start()
 }
 
-type GroupReferral struct {
-	FriendTrade int64
-	MemberID    int64
-}
-
 type GroupUserReferral struct {
 	Friend int64
 	UID    string
 }
 
-func releaseReferrals() {
-	var group_referrals []*GroupReferral
-
-	today := time.Now().Format("2006-01-02")
-	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
-
-	config.DataBase.
-		Model(&models.Commission{}).
-		Select("COUNT(DISTINCT friend_uid) as friend_trade", "member_id").
-		Where("CAST(\"created_at\" AS DATE) = ?", yesterday).
-		Group("member_id").
-		Find(&group_referrals)
-
-	for _, group_referral := range group_referrals {
-		var commissions []*models.Commission
-
-		earned_usdt := decimal.Zero
+// releaseDays returns the day a release run at now is recorded on and the day it releases, the day before.
+// A run fired early by the scheduler, in the last second of a day, still releases the day before it.
+func releaseDays(now time.Time) (today, yesterday time.Time) {
+	year, month, day := now.Add(time.Second).Date()
+	today = time.Date(year, month, day, 0, 0, 0, 0, now.Location())
 
-		config.DataBase.Where("member_id = ? AND CAST(\"created_at\" AS DATE) = ?", group_referral.MemberID, yesterday).Find(&commissions)
+	return today, today.AddDate(0, 0, -1)
+}
 
-		for _, commission := range commissions {
-			var currency *models.Currency
+func releaseReferrals() {
+	today_day, yesterday_day := releaseDays(jobClock.Now())
+	today := today_day.Format("2006-01-02")
+	yesterday := yesterday_day.Format("2006-01-02")
 
-			config.DataBase.First(&currency, "id = ?", commission.CurrencyID)
-			earned_usdt = earned_usdt.Add(currency.Price.Mul(commission.EarnAmount))
-		}
+	prices := models.StoredCurrencyPrices()
 
-		var btc_currency *models.Currency
-		config.DataBase.First(&btc_currency, "id = ?", "btc")
+	// members already released, by a backfill or a previous run, are skipped
+	releases, err := models.PendingCommissionReleases(config.DataBase, yesterday_day, prices)
+	if err != nil {
+		config.Logger.Errorf("Failed to release the commissions of %s: %v", yesterday, err)
+	}
 
-		earned_btc := earned_usdt.DivRound(btc_currency.Price, 8)
+	for _, release_commission := range releases {
+		config.DataBase.Create(&release_commission)
+	}
 
-		release_commission := &models.ReleaseCommission{
-			AccountType: types.AccountTypeSpot,
-			MemberID:    group_referral.MemberID,
-			EarnedBTC:   earned_btc,
-			FriendTrade: group_referral.FriendTrade,
-			Friend:      0,
-		}
+	// the kickbacks of the friends are released with the commissions, the same way
+	kickback_releases, err := models.PendingKickbackReleases(config.DataBase, yesterday_day, prices)
+	if err != nil {
+		config.Logger.Errorf("Failed to release the fee kickbacks of %s: %v", yesterday, err)
+	}
 
-		config.DataBase.Create(&release_commission)
+	for _, release_kickback := range kickback_releases {
+		config.DataBase.Create(&release_kickback)
 	}
 
+	releaseAdjustments(yesterday, prices)
+	releaseKickbackAdjustments(yesterday, prices)
+
 	var group_user_referrals []*GroupUserReferral
 
 	config.DataBase.
@@ -86,12 +77,13 @@ func releaseReferrals() {
 		var release_referral *models.ReleaseCommission
 
 		config.DataBase.Where("uid = ?", group_user_referral.UID).Find(&member)
-		if result := config.DataBase.Where("member_id = ? AND CAST(\"created_at\" AS DATE) = ?", member.ID, today).First(&release_referral); result.Error == nil {
+		if result := config.DataBase.Where("member_id = ? AND kind = ? AND CAST(\"created_at\" AS DATE) = ?", member.ID, models.ReleaseCommissionKindRelease, today).First(&release_referral); result.Error == nil {
 			config.DataBase.Model(&release_referral).Update("friend", group_user_referral.Friend)
 		} else {
 			release_commission := &models.ReleaseCommission{
 				AccountType: types.AccountTypeSpot,
 				MemberID:    member.ID,
+				Kind:        models.ReleaseCommissionKindRelease,
 				EarnedBTC:   decimal.Zero,
 				FriendTrade: 0,
 				Friend:      group_user_referral.Friend,
@@ -101,3 +93,65 @@ func releaseReferrals() {
 		}
 	}
 }
+
+// releaseAdjustments takes back the commissions voided on day after a previous run already released them.
+func releaseAdjustments(day string, prices []*models.MarketPrice) {
+	var commissions []*models.Commission
+
+	config.DataBase.
+		Where("state = ? AND CAST(\"voided_at\" AS DATE) = ? AND CAST(\"created_at\" AS DATE) < CAST(\"voided_at\" AS DATE)", models.CommissionStateVoid, day).
+		Find(&commissions)
+
+	member_commissions := make(map[int64][]*models.Commission)
+	for _, commission := range commissions {
+		member_commissions[commission.MemberID] = append(member_commissions[commission.MemberID], commission)
+	}
+
+	for member_id, voided := range member_commissions {
+		earned_btc, err := models.EarnedBTC(voided, prices)
+		if err != nil {
+			config.Logger.Errorf("Failed to take back the voided commissions of member %d: %v", member_id, err)
+			continue
+		}
+
+		adjustment := &models.ReleaseCommission{
+			AccountType: types.AccountTypeSpot,
+			MemberID:    member_id,
+			Kind:        models.ReleaseCommissionKindAdjustment,
+			EarnedBTC:   earned_btc.Neg(),
+		}
+
+		config.DataBase.Create(&adjustment)
+	}
+}
+
+// releaseKickbackAdjustments takes back the fee kickbacks voided on day after a previous run already released them.
+func releaseKickbackAdjustments(day string, prices []*models.MarketPrice) {
+	var kickbacks []*models.FeeKickback
+
+	config.DataBase.
+		Where("state = ? AND CAST(\"voided_at\" AS DATE) = ? AND CAST(\"created_at\" AS DATE) < CAST(\"voided_at\" AS DATE)", models.CommissionStateVoid, day).
+		Find(&kickbacks)
+
+	member_kickbacks := make(map[int64][]*models.FeeKickback)
+	for _, kickback := range kickbacks {
+		member_kickbacks[kickback.MemberID] = append(member_kickbacks[kickback.MemberID], kickback)
+	}
+
+	for member_id, voided := range member_kickbacks {
+		earned_btc, err := models.FeeKickbacksBTC(voided, prices)
+		if err != nil {
+			config.Logger.Errorf("Failed to take back the voided fee kickbacks of member %d: %v", member_id, err)
+			continue
+		}
+
+		adjustment := &models.ReleaseCommission{
+			AccountType: types.AccountTypeSpot,
+			MemberID:    member_id,
+			Kind:        models.ReleaseCommissionKindKickbackAdjustment,
+			EarnedBTC:   earned_btc.Neg(),
+		}
+
+		config.DataBase.Create(&adjustment)
+	}
+}
@@ -0,0 +1,55 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zsmartex/finex/clock"
+)
+
+func TestReleaseDays(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		name      string
+		now       time.Time
+		today     string
+		yesterday string
+	}{
+		{"on time", time.Date(2022, 5, 10, 0, 0, 0, 0, location), "2022-05-10", "2022-05-09"},
+		{"late", time.Date(2022, 5, 10, 0, 3, 0, 0, location), "2022-05-10", "2022-05-09"},
+		{"fired early", time.Date(2022, 5, 9, 23, 59, 59, 500000000, location), "2022-05-10", "2022-05-09"},
+		{"month", time.Date(2022, 3, 1, 0, 0, 0, 0, location), "2022-03-01", "2022-02-28"},
+		// the day before the switch to summer time is 23 hours long
+		{"summer time", time.Date(2022, 3, 28, 0, 0, 0, 0, location), "2022-03-28", "2022-03-27"},
+	}
+
+	for _, tt := range tests {
+		today, yesterday := releaseDays(tt.now)
+		if today.Format("2006-01-02") != tt.today || yesterday.Format("2006-01-02") != tt.yesterday {
+			t.Errorf("%s: expected a run at %s to release %s on %s, got %s on %s", tt.name, tt.now, tt.yesterday, tt.today, yesterday, today)
+		}
+	}
+}
+
+// The jobs scheduled by a day, run on the fake clock, see the time they were due at.
+func TestJobClock(t *testing.T) {
+	fake := clock.NewFake(time.Date(2022, 5, 9, 12, 0, 0, 0, time.UTC))
+
+	jobs_clock := jobClock
+	jobClock = fake
+	t.Cleanup(func() { jobClock = jobs_clock })
+
+	var released time.Time
+	jobClock.AfterFunc(12*time.Hour, func() {
+		_, released = releaseDays(jobClock.Now())
+	})
+
+	fake.Advance(24 * time.Hour)
+	if !released.Equal(time.Date(2022, 5, 9, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the run at midnight to release 2022-05-09, got %s", released)
+	}
+}
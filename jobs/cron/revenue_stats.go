@@ -0,0 +1,30 @@
+package cron
+
+import (
+	"time"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+)
+
+// revenueStatsDelay is how long after the end of a UTC day it's rolled up, for the trades of the day to be executed.
+const revenueStatsDelay = 15 * time.Minute
+
+// RevenueStatsJob writes the daily stats of the fee revenue, the revenue report reconciles the ledger against them.
+type RevenueStatsJob struct {
+}
+
+func (j *RevenueStatsJob) Process() {
+	now := jobClock.Now().UTC()
+	next := now.Truncate(24 * time.Hour).Add(revenueStatsDelay)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+
+	time.Sleep(next.Sub(now))
+
+	day := next.Truncate(24*time.Hour).AddDate(0, 0, -1)
+	if err := models.RollupRevenueStats(config.DataBase, day); err != nil {
+		config.Logger.Errorf("Failed to roll up the revenue stats of %s: %v", day.Format("2006-01-02"), err)
+	}
+}
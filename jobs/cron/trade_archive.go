@@ -0,0 +1,44 @@
+package cron
+
+import (
+	"time"
+
+	"github.com/jasonlvhit/gocron"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+)
+
+// tradeArchiveCatchUp is the number of past days the archive job goes over, so days missed while it was down
+// are archived on the next run. Days already archived are skipped.
+const tradeArchiveCatchUp = 7
+
+type TradeArchiveJob struct {
+}
+
+func (j *TradeArchiveJob) Process() {
+	s := gocron.NewScheduler()
+	s.Every(1).Day().At("00:30:00").Do(archiveTrades)
+	<-s.Start()
+}
+
+func archiveTrades() {
+	var markets []*models.Market
+	config.DataBase.Find(&markets)
+
+	today := jobClock.Now().UTC().Truncate(24 * time.Hour)
+	for days := tradeArchiveCatchUp; days >= 1; days-- {
+		day := today.AddDate(0, 0, -days)
+
+		for _, market := range markets {
+			generated, err := models.GenerateTradeArchive(config.DataBase, market.Symbol, day)
+			if err != nil {
+				config.Logger.Errorf("Failed to archive the trades of %s on %s: %v", market.Symbol, day.Format(models.TradeArchiveDateLayout), err)
+				continue
+			}
+
+			if generated {
+				config.Logger.Infof("Archived the trades of %s on %s", market.Symbol, day.Format(models.TradeArchiveDateLayout))
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package matching
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/events"
+)
+
+// Amend sets the order of key to the price and the quantity of amendment, nil keeping the ones it has, and publishes
+// the amend or its rejection. A quantity reduced at the same price keeps the priority of the order. A new price or a
+// larger quantity takes the order out and puts it back with a new creation time, behind the orders of its price,
+// it's matched when it crosses. An amend leaving nothing to fill, a quantity not above the filled quantity, is
+// rejected like the amends of orders the book doesn't keep. A book which only takes cancels only takes reductions, a
+// halted book takes none.
+func (ob *OrderBook) Amend(key *pkg.OrderKey, amendment *events.Amendment) (accepted bool, cascade_depth int) {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	if !validSide(key.Side) || amendment == nil || ob.halted() {
+		ob.publishAmend(key, decimal.Zero, false)
+		return
+	}
+
+	o, in_depth := ob.lookup(key)
+	if o == nil {
+		ob.publishAmend(key, decimal.Zero, false)
+		return
+	}
+
+	price, quantity := o.Price, o.Quantity
+	if amendment.Price != nil {
+		price = *amendment.Price
+	}
+
+	if amendment.Quantity != nil {
+		quantity = *amendment.Quantity
+	}
+
+	repriced := !price.Equal(o.Price)
+	if !quantity.GreaterThan(o.FilledQuantity) || repriced && (o.Type != pkg.TypeLimit || !price.IsPositive()) {
+		ob.publishAmend(key, decimal.Zero, false)
+		return
+	}
+
+	// the order keeps its place in the queue
+	if !repriced && quantity.LessThanOrEqual(o.Quantity) {
+		o.Quantity = quantity
+		ob.publishAmend(o.Key(), quantity, true)
+
+		if in_depth {
+			ob.matchMutex.Lock()
+			defer ob.matchMutex.Unlock()
+
+			ob.Depth.Add(o)
+		}
+
+		return true, 0
+	}
+
+	if _, rejected := ob.rejectsOrders(); rejected {
+		ob.publishAmend(key, decimal.Zero, false)
+		return
+	}
+
+	options := ob.options[o.ID]
+	ob.removeOrder(o.Key())
+
+	o.Price, o.Quantity, o.CreatedAt = price, quantity, ob.clock.Now()
+	ob.publishAmend(o.Key(), quantity, true)
+
+	return true, ob.insert(o, options)
+}
+
+// lookup returns the order of key the book keeps, in the book or waiting for its stop price, nil when it has
+// none. in_depth reports whether it's in the book.
+func (ob *OrderBook) lookup(key *pkg.OrderKey) (o *pkg.Order, in_depth bool) {
+	if o, found := ob.trailing[key.ID]; found && o.Side == key.Side {
+		return o, false
+	}
+
+	if key.StopPrice.IsPositive() {
+		if value, found := ob.stopBookOf(key.ID, key.Side).Get(key); found {
+			return value.(*pkg.Order), false
+		}
+	}
+
+	ob.matchMutex.Lock()
+	defer ob.matchMutex.Unlock()
+
+	o = ob.Depth.Get(key)
+
+	return o, o != nil
+}
+
+// publishAmend publishes an amend of an order which isn't fake.
+func (ob *OrderBook) publishAmend(key *pkg.OrderKey, quantity decimal.Decimal, accepted bool) {
+	if !key.Fake {
+		ob.publisher.PublishAmend(key, quantity, accepted)
+	}
+}
@@ -0,0 +1,123 @@
+package matching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/finex/events"
+)
+
+func amendment(price, quantity string) *events.Amendment {
+	a := &events.Amendment{}
+	if len(price) > 0 {
+		p := decimal.RequireFromString(price)
+		a.Price = &p
+	}
+
+	if len(quantity) > 0 {
+		q := decimal.RequireFromString(quantity)
+		a.Quantity = &q
+	}
+
+	return a
+}
+
+func TestAmendReductionKeepsPriority(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{Flags: FeatureFlags{FifoTiebreakV2: true}}, nil)
+
+	first := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "5")
+	ob.Add(first)
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+
+	if accepted, _ := ob.Amend(first.Key(), amendment("", "3")); !accepted {
+		t.Fatal("expected the reduction to be accepted")
+	}
+
+	if ids := levelOrders(ob, pkg.SideSell); ids[0] != first.ID {
+		t.Errorf("expected the reduced order to keep its place, got %v", ids)
+	}
+
+	if asks, _ := ob.Depth.Levels(); !asks[0][1].Equal(decimal.NewFromInt(4)) {
+		t.Errorf("expected the level to show the reduced quantity, got %v", asks)
+	}
+
+	if len(publisher.Amends) != 1 || !publisher.Amends[0].Quantity.Equal(decimal.NewFromInt(3)) || !publisher.Amends[0].Price.Equal(decimal.NewFromInt(101)) {
+		t.Errorf("expected the amend to be published with the new values, got %+v", publisher.Amends)
+	}
+}
+
+func TestAmendIncreaseLosesPriority(t *testing.T) {
+	fake := clock.NewFake(time.Now().Add(time.Hour))
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{Flags: FeatureFlags{FifoTiebreakV2: true}}, fake)
+
+	first := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	ob.Add(first)
+	second := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	ob.Add(second)
+
+	if accepted, _ := ob.Amend(first.Key(), amendment("", "2")); !accepted {
+		t.Fatal("expected the increase to be accepted")
+	}
+
+	if ids := levelOrders(ob, pkg.SideSell); len(ids) != 2 || ids[0] != second.ID {
+		t.Errorf("expected the increased order to go after the other order, got %v", ids)
+	}
+
+	if !first.CreatedAt.Equal(fake.Now()) {
+		t.Errorf("expected the increased order to be re-keyed at %s, got %s", fake.Now(), first.CreatedAt)
+	}
+
+	// a price change re-keys the order at its new price
+	if accepted, _ := ob.Amend(second.Key(), amendment("102", "")); !accepted || !second.Price.Equal(decimal.NewFromInt(102)) {
+		t.Fatal("expected the order to be moved to its new price")
+	}
+
+	if asks, _ := ob.Depth.Levels(); len(asks) != 2 || !asks[1][0].Equal(decimal.NewFromInt(102)) {
+		t.Errorf("expected the order to leave its level for its new price, got %v", asks)
+	}
+}
+
+func TestAmendBelowFilledRejected(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	maker := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "5")
+	ob.Add(maker)
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "2"))
+
+	if accepted, _ := ob.Amend(maker.Key(), amendment("", "2")); accepted {
+		t.Fatal("expected an amend to the filled quantity to be rejected")
+	}
+
+	if !maker.Quantity.Equal(decimal.NewFromInt(5)) || len(publisher.Amends) != 1 || publisher.Amends[0].Accepted {
+		t.Errorf("expected the order to be left as it was and the rejection published, got %s and %+v", maker.Quantity, publisher.Amends)
+	}
+
+	if accepted, _ := ob.Amend(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1").Key(), amendment("", "3")); accepted {
+		t.Error("expected the amend of an order the book doesn't keep to be rejected")
+	}
+}
+
+func TestAmendCrossingPriceMatches(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	ob.Add(ask)
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "2")
+	ob.Add(bid)
+
+	if accepted, _ := ob.Amend(bid.Key(), amendment("101", "")); !accepted {
+		t.Fatal("expected the price change to be accepted")
+	}
+
+	if len(publisher.Trades) != 1 || publisher.Trades[0].TakerOrder.ID != bid.ID || !publisher.Trades[0].Price.Equal(decimal.NewFromInt(101)) {
+		t.Fatalf("expected the amended order to take the ask, got %d trades", len(publisher.Trades))
+	}
+
+	if bookHas(ob, ask) || !bookHas(ob, bid) {
+		t.Error("expected the ask to be filled and the rest of the amended order to rest")
+	}
+}
@@ -0,0 +1,188 @@
+package matching
+
+import (
+	"time"
+
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/pkg"
+)
+
+// batchAuction is a book matching in frequent batch auctions rather than continuously: orders accumulate for the
+// interval without matching and are uncrossed together at a single clearing price, an order sent late in a batch
+// gets the same price as the orders sent before it. The depth changes of a batch are published after its uncross.
+type batchAuction struct {
+	interval time.Duration
+	timer    clock.Timer
+	// market_orders are the market orders of the batch, they don't rest in the book
+	market_orders []*pkg.Order
+}
+
+// SetBatchInterval switches the book to batch auctions of the interval, or back to continuous matching when it's
+// zero. A book leaving the batch mode uncrosses its last batch first, so no crossing orders are left to match
+// continuously in the order they came.
+func (ob *OrderBook) SetBatchInterval(interval time.Duration) {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	ob.setBatchInterval(interval)
+}
+
+// BatchInterval returns the interval of the batch auctions of the book, zero when it matches continuously.
+func (ob *OrderBook) BatchInterval() time.Duration {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	if ob.batch == nil {
+		return 0
+	}
+
+	return ob.batch.interval
+}
+
+// StopBatch stops the timer of the batches of a book which is replaced, its last batch is never uncrossed.
+func (ob *OrderBook) StopBatch() {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	if ob.batch != nil {
+		ob.batch.timer.Stop()
+	}
+}
+
+// waiting returns the market orders waiting out of the book for the uncross of the auction, or of the batch when
+// the book isn't in an auction, nil when the book matches continuously. It's called with the orderMutex held.
+func (ob *OrderBook) waiting() *[]*pkg.Order {
+	switch {
+	case ob.auction != nil:
+		return &ob.auction.market_orders
+	case ob.batch != nil:
+		return &ob.batch.market_orders
+	}
+
+	return nil
+}
+
+func (ob *OrderBook) setBatchInterval(interval time.Duration) {
+	previous := ob.batch
+	if previous != nil {
+		previous.timer.Stop()
+	}
+
+	if interval > 0 {
+		ob.batch = &batchAuction{interval: interval}
+		if previous != nil {
+			ob.batch.market_orders = previous.market_orders
+		}
+
+		ob.Depth.Notification.Hold()
+		ob.scheduleBatch(ob.batch)
+
+		return
+	}
+
+	ob.batch = nil
+	if previous == nil {
+		return
+	}
+
+	config.Logger.Infof("[oceanbook.orderbook] %s switched to continuous matching", ob.Symbol.String())
+
+	// a book in a call auction keeps the orders of the batch for its uncross
+	if ob.listing == nil && ob.auction == nil {
+		ob.uncross(previous.market_orders)
+	}
+	ob.Depth.Notification.Release(true)
+
+	// the stop orders triggered by the last batch are matched continuously
+	ob.matchPending()
+}
+
+func (ob *OrderBook) scheduleBatch(batch *batchAuction) {
+	batch.timer = ob.clock.AfterFunc(batch.interval, func() {
+		ob.orderMutex.Lock()
+		defer ob.orderMutex.Unlock()
+
+		// switched meanwhile
+		if ob.batch != batch {
+			return
+		}
+
+		// a halted book keeps its batch until it's resumed, a book in a call auction until it's uncrossed
+		if !ob.halted() && ob.auction == nil {
+			ob.runBatch()
+		}
+		ob.scheduleBatch(batch)
+	})
+}
+
+// runBatch uncrosses the batch, publishes its depth changes and starts the next batch with the stop orders
+// the clearing price triggered. It's called with the orderMutex held.
+func (ob *OrderBook) runBatch() {
+	ob.PriceLimit.Rollover(ob.clock.Now(), ob.MarketPrice)
+
+	// a listed market uncrosses the orders of its warm-up in its first batch
+	if ob.openIfDue() {
+		return
+	}
+
+	market_orders := ob.batch.market_orders
+	ob.batch.market_orders = nil
+
+	if uncross, found := ob.uncross(market_orders); found {
+		config.Logger.Debugf("[oceanbook.orderbook] %s uncrossed %s at %s", ob.Symbol.String(), uncross.Volume, uncross.Price)
+	}
+
+	ob.Depth.Notification.Release(false)
+
+	ob.accumulatePending()
+}
+
+// accumulatePending adds the stop orders an uncross triggered to the next batch, with the orderMutex held.
+func (ob *OrderBook) accumulatePending() {
+	for ob.pendingOrdersQueue.Size() > 0 {
+		pending_orders := ob.pendingOrdersQueue.Values()
+		ob.pendingOrdersQueue.Clear()
+
+		for _, o := range pending_orders {
+			ob.accumulate(o)
+		}
+	}
+}
+
+// accumulate adds an order to the batch or to the call auction with the orderMutex held, limit orders rest in the
+// book until the uncross. Immediate-or-cancel limit orders and fill-or-kill orders can't wait for it, they're cancelled.
+func (ob *OrderBook) accumulate(o *pkg.Order) {
+	if ob.rejectImmediate(o) {
+		return
+	}
+
+	if o.Type == pkg.TypeMarket {
+		waiting := ob.waiting()
+		*waiting = append(*waiting, o)
+		return
+	}
+
+	ob.matchMutex.Lock()
+	defer ob.matchMutex.Unlock()
+
+	if !ob.PriceLimit.Accept(o.Price) {
+		ob.forget(o.ID)
+		if !o.IsFake() {
+			ob.PublishCancel(o.Key(), CancelReasonPriceLimit)
+		}
+		return
+	}
+
+	// a post-only order crossing the book when it's placed would take liquidity in the uncross
+	offers := ob.Depth.Asks
+	if o.IsAsk() {
+		offers = ob.Depth.Bids
+	}
+
+	if ob.rejectPostOnly(o, offers) {
+		return
+	}
+
+	ob.Depth.Add(o)
+}
@@ -0,0 +1,233 @@
+package matching
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/pkg"
+)
+
+type batchAuctionOrder struct {
+	Side     pkg.OrderSide `json:"side"`
+	Type     pkg.OrderType `json:"type"`
+	Price    string        `json:"price"`
+	Quantity string        `json:"quantity"`
+	// Cancel is the index of the order cancelled, for the cancels
+	Cancel *int `json:"cancel"`
+}
+
+type batchAuctionTrade struct {
+	Price    decimal.Decimal `json:"price"`
+	Quantity decimal.Decimal `json:"quantity"`
+	Buy      int             `json:"buy"`
+	Sell     int             `json:"sell"`
+}
+
+type batchAuctionCase struct {
+	Name        string              `json:"name"`
+	MarketPrice decimal.Decimal     `json:"market_price"`
+	Orders      []batchAuctionOrder `json:"orders"`
+	Continuous  []batchAuctionTrade `json:"continuous"`
+	Batch       []batchAuctionTrade `json:"batch"`
+}
+
+// play sends the orders and the cancels of the case to the book, it returns the orders by index.
+func (c batchAuctionCase) play(ob *OrderBook) map[int64]int {
+	indexes := make(map[int64]int)
+	orders := make([]*pkg.Order, len(c.Orders))
+
+	for i, o := range c.Orders {
+		if o.Cancel != nil {
+			ob.Remove(orders[*o.Cancel].Key())
+			continue
+		}
+
+		orders[i] = newTestOrder(o.Side, o.Type, o.Price, o.Quantity)
+		indexes[orders[i].ID] = i
+		ob.Add(orders[i])
+	}
+
+	return indexes
+}
+
+func checkBatchAuctionTrades(t *testing.T, want []batchAuctionTrade, trades []*pkg.Trade, indexes map[int64]int) {
+	t.Helper()
+
+	if len(trades) != len(want) {
+		t.Fatalf("expected %d trades, got %d", len(want), len(trades))
+	}
+
+	for i, trade := range trades {
+		got := batchAuctionTrade{
+			Price:    trade.Price,
+			Quantity: trade.Quantity,
+			Buy:      indexes[trade.BuyOrder().ID],
+			Sell:     indexes[trade.SellOrder().ID],
+		}
+
+		if !got.Price.Equal(want[i].Price) || !got.Quantity.Equal(want[i].Quantity) || got.Buy != want[i].Buy || got.Sell != want[i].Sell {
+			t.Errorf("trade %d: expected %+v, got %+v", i, want[i], got)
+		}
+	}
+}
+
+// batchTestStart is the time of the fake clock of the batch tests, their batches are an hour long.
+var batchTestStart = time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestBatchAuctions(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "batch_auctions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases []batchAuctionCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			// the fixtures give the orders of a price level in time priority
+			flags := FeatureFlags{FifoTiebreakV2: true}
+
+			continuous, continuous_trades := newTestOrderBook(c.MarketPrice, OrderBookConfig{Flags: flags}, nil)
+			checkBatchAuctionTrades(t, c.Continuous, continuous_trades.Trades, c.play(continuous))
+
+			fake := clock.NewFake(batchTestStart)
+			batch, batch_trades := newTestOrderBook(c.MarketPrice, OrderBookConfig{Flags: flags, BatchInterval: time.Hour}, fake)
+
+			indexes := c.play(batch)
+			if len(batch_trades.Trades) > 0 {
+				t.Fatalf("expected no trade before the uncross, got %d", len(batch_trades.Trades))
+			}
+
+			fake.Advance(time.Hour)
+			checkBatchAuctionTrades(t, c.Batch, batch_trades.Trades, indexes)
+
+			// every trade of a batch is at the clearing price, matched at the end of the batch
+			for i, trade := range batch_trades.Trades {
+				if !trade.Price.Equal(batch.MarketPrice) {
+					t.Errorf("expected the trades at the clearing price %s, got %s", batch.MarketPrice, trade.Price)
+				}
+
+				if !batch_trades.MatchedAt[i].Equal(batchTestStart.Add(time.Hour)) {
+					t.Errorf("expected the trades matched at the end of the batch, got %s", batch_trades.MatchedAt[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBatchAuctionHoldsDepth(t *testing.T) {
+	fake := clock.NewFake(batchTestStart)
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{BatchInterval: time.Hour}, fake)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "2"))
+
+	if depth := ob.Depth.Notification.flush(); depth != nil {
+		t.Fatalf("expected the crossed book of the batch to be held, got %+v", depth)
+	}
+
+	fake.Advance(time.Hour)
+
+	depth := ob.Depth.Notification.flush()
+	if depth == nil {
+		t.Fatal("expected the changes of the batch after its uncross")
+	}
+
+	// the ask traded away and the bid left with 1 are the net changes of the batch
+	if len(depth.Asks) != 1 || !depth.Asks[0][1].IsZero() || len(depth.Bids) != 1 || !depth.Bids[0][1].Equal(decimal.NewFromInt(1)) {
+		t.Errorf("expected the net changes of the batch, got %+v", depth)
+	}
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "105", "1"))
+	if depth := ob.Depth.Notification.flush(); depth != nil {
+		t.Errorf("expected the next batch to be held, got %+v", depth)
+	}
+}
+
+func TestBatchAuctionStopOrdersJoinTheNextBatch(t *testing.T) {
+	fake := clock.NewFake(batchTestStart)
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{BatchInterval: time.Hour}, fake)
+
+	stop := newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1")
+	stop.StopPrice = decimal.NewFromInt(99)
+	ob.Add(stop)
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "98", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "98", "1"))
+	fake.Advance(time.Hour)
+
+	if len(publisher.Trades) != 1 || !bookHas(ob, stop) {
+		t.Fatalf("expected the stop order triggered at 98 to rest for the next batch, got %d trades", len(publisher.Trades))
+	}
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1"))
+	fake.Advance(time.Hour)
+
+	if len(publisher.Trades) != 2 || publisher.Trades[1].SellOrder().ID != stop.ID || !publisher.Trades[1].Price.Equal(decimal.NewFromInt(100)) {
+		t.Errorf("expected the stop order to trade in the next batch at 100, got %+v", publisher.Trades)
+	}
+}
+
+func TestSwitchBatchToContinuous(t *testing.T) {
+	fake := clock.NewFake(batchTestStart)
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(102), OrderBookConfig{BatchInterval: time.Hour}, fake)
+
+	early := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "105", "1")
+	ob.Add(early)
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "2"))
+	late := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "105", "1")
+	ob.Add(late)
+
+	ob.SetBatchInterval(0)
+
+	// the batch is uncrossed once at a single price rather than matched in the order it came
+	if len(publisher.Trades) != 2 || !publisher.Trades[0].Price.Equal(decimal.NewFromInt(100)) || !publisher.Trades[1].Price.Equal(decimal.NewFromInt(100)) {
+		t.Fatalf("expected the last batch to be uncrossed at 100, got %+v", publisher.Trades)
+	}
+
+	if ob.BatchInterval() != 0 || ob.Depth.Notification.flush() == nil {
+		t.Errorf("expected the book to match continuously and publish its depth")
+	}
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "99", "1"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1"))
+	if len(publisher.Trades) != 3 {
+		t.Errorf("expected the next orders to match continuously, got %d trades", len(publisher.Trades))
+	}
+
+	// and back to batch auctions, the book isn't crossed so nothing is uncrossed
+	ob.SetBatchInterval(time.Hour)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "99", "1"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1"))
+	if len(publisher.Trades) != 3 {
+		t.Errorf("expected the orders to wait for the batch, got %d trades", len(publisher.Trades))
+	}
+}
+
+func TestClearingPriceWithoutCross(t *testing.T) {
+	bids := []*pkg.Order{newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1")}
+	asks := []*pkg.Order{newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1")}
+
+	if uncross, found := ClearingPrice(bids, asks, decimal.NewFromInt(100)); found {
+		t.Errorf("expected no clearing price for a book which doesn't cross, got %+v", uncross)
+	}
+
+	markets := []*pkg.Order{newTestOrder(pkg.SideBuy, pkg.TypeMarket, "", "1")}
+	sells := []*pkg.Order{newTestOrder(pkg.SideSell, pkg.TypeMarket, "", "1")}
+	if _, found := ClearingPrice(markets, sells, decimal.Zero); found {
+		t.Errorf("expected market orders alone not to clear without a reference")
+	}
+
+	if uncross, found := ClearingPrice(markets, sells, decimal.NewFromInt(100)); !found || !uncross.Price.Equal(decimal.NewFromInt(100)) {
+		t.Errorf("expected market orders alone to clear at the reference, got %+v", uncross)
+	}
+}
@@ -0,0 +1,163 @@
+package matching
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/events"
+)
+
+// BookImage is the state of a book between two cycles, what a book restored from it matches exactly as the book it
+// was taken from. The orders are copies, the book goes on matching the ones it holds.
+type BookImage struct {
+	MarketPrice decimal.Decimal `json:"market_price"`
+	// IndexPrice is the last index price pushed to the book, the stop orders triggered by the index price follow it
+	IndexPrice decimal.Decimal `json:"index_price,omitempty"`
+	// Orders are the orders of the book, the asks then the bids in the order they're matched, then its stop orders
+	Orders []*pkg.Order `json:"orders"`
+	// Waiting are the market orders of a batch or of a call auction waiting for its uncross
+	Waiting []*pkg.Order `json:"waiting,omitempty"`
+	// Auction is set while the book is in a call auction
+	Auction bool `json:"auction,omitempty"`
+	// Options are the options of the orders submitted with some, by order id
+	Options map[int64]*events.OrderOptions `json:"options,omitempty"`
+	// Icebergs are the slices shown by the iceberg orders, by order id
+	Icebergs map[int64]*IcebergSlice `json:"icebergs,omitempty"`
+	// Departed are the last orders which left the book, oldest first
+	Departed []int64 `json:"departed,omitempty"`
+	// Admitted are the last orders submitted to the book, oldest first
+	Admitted []int64 `json:"admitted,omitempty"`
+	// DepthSequence is the sequence of the last depth diff of the book
+	DepthSequence int64 `json:"depth_sequence"`
+	// TradeSequence is the sequence of the last trade of the book, zero in the images taken before it was kept
+	TradeSequence int64 `json:"trade_sequence,omitempty"`
+}
+
+// IcebergSlice is the slice an iceberg order shows in a book image.
+type IcebergSlice struct {
+	Display   decimal.Decimal `json:"display"`
+	Shown     decimal.Decimal `json:"shown"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Image copies the state of the book between two cycles.
+func (e *Engine) Image() *BookImage {
+	e.MatchingMutex.Lock()
+	defer e.MatchingMutex.Unlock()
+
+	return e.OrderBook.image()
+}
+
+// Restore puts the state of image into the book, which must be empty. The orders aren't matched, they're put
+// back where they were, and the depth diffs go on from the sequence of the image.
+func (e *Engine) Restore(image *BookImage) {
+	e.MatchingMutex.Lock()
+	defer e.MatchingMutex.Unlock()
+
+	e.OrderBook.restoreImage(image)
+}
+
+func (ob *OrderBook) image() *BookImage {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	image := &BookImage{
+		MarketPrice: ob.MarketPrice,
+		IndexPrice:  ob.indexPrice,
+		Orders:      make([]*pkg.Order, 0),
+		Options:     make(map[int64]*events.OrderOptions),
+		Icebergs:    make(map[int64]*IcebergSlice),
+		Departed:    ob.departed.list(),
+		Admitted:    ob.admitted.list(),
+	}
+
+	keep := func(o *pkg.Order) *pkg.Order {
+		if options, found := ob.options[o.ID]; found {
+			image.Options[o.ID] = options
+		}
+
+		if ice := ob.Depth.icebergOf(o.ID); ice != nil {
+			image.Icebergs[o.ID] = &IcebergSlice{Display: ice.display, Shown: ice.shown, CreatedAt: ice.createdAt}
+		}
+
+		copied := *o
+		return &copied
+	}
+
+	for _, o := range ob.Depth.Orders() {
+		image.Orders = append(image.Orders, keep(o))
+	}
+
+	for _, book := range ob.stopBooks() {
+		for _, value := range book.Values() {
+			image.Orders = append(image.Orders, keep(value.(*pkg.Order)))
+		}
+	}
+
+	if waiting := ob.waiting(); waiting != nil {
+		for _, o := range *waiting {
+			image.Waiting = append(image.Waiting, keep(o))
+		}
+	}
+	image.Auction = ob.auction != nil
+
+	image.DepthSequence = ob.Depth.Snapshot().Sequence
+	image.TradeSequence = ob.tradeSequence
+
+	return image
+}
+
+func (ob *OrderBook) restoreImage(image *BookImage) {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	ob.MarketPrice = image.MarketPrice
+	ob.indexPrice = image.IndexPrice
+
+	for _, id := range image.Departed {
+		ob.departed.add(id)
+	}
+
+	// the images taken before the submits were kept only have the orders of the book
+	for _, id := range image.Admitted {
+		ob.admitted.add(id)
+	}
+
+	for _, orders := range [][]*pkg.Order{image.Orders, image.Waiting} {
+		for _, o := range orders {
+			ob.admitted.add(o.ID)
+		}
+	}
+
+	for _, o := range image.Orders {
+		var ice *iceberg
+		if slice, found := image.Icebergs[o.ID]; found {
+			ice = &iceberg{display: slice.Display, shown: slice.Shown, createdAt: slice.CreatedAt}
+		}
+
+		ob.restore(o, image.Options[o.ID], ice)
+	}
+
+	if image.Auction {
+		ob.auction = &callAuction{}
+	}
+
+	// a book restored out of the batch mode matches the market orders the batch was waiting with
+	for _, o := range image.Waiting {
+		if waiting := ob.waiting(); waiting != nil {
+			ob.keepOptions(o, image.Options[o.ID])
+			*waiting = append(*waiting, o)
+		} else {
+			ob.insert(o, image.Options[o.ID])
+		}
+	}
+
+	ob.Depth.resume(image.DepthSequence)
+
+	// the trades matched again from the commands logged after the image get the sequences they were published with
+	if image.TradeSequence > 0 {
+		ob.tradeSequence = image.TradeSequence
+	}
+}
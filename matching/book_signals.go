@@ -0,0 +1,103 @@
+package matching
+
+import (
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/shopspring/decimal"
+)
+
+// BookSignalLevels is the number of price levels of each side the imbalance is computed on.
+const BookSignalLevels = 5
+
+// BookSignals are the best prices of a book and the signals computed from its top levels. Every value is null
+// when a side it needs is empty, the imbalance and the microprice are null unless both sides have orders.
+//
+// With Vb and Va the amounts of the best BookSignalLevels bid and ask levels, or of every level when there are less:
+//
+//	imbalance = (Vb - Va) / (Vb + Va)
+//
+// it's in [-1, 1], positive when the bids outweigh the asks. With Pb, Qb the price and amount of the best bid
+// and Pa, Qa those of the best ask, the microprice is the mid weighted by the amount on the other side:
+//
+//	microprice = (Pb * Qa + Pa * Qb) / (Qa + Qb)
+//
+// it's in [Pb, Pa] and leans towards the side with less amount, the one more likely to be traded through.
+// Both are divided with decimal.DivisionPrecision, 16 decimal places.
+type BookSignals struct {
+	BestBid    decimal.NullDecimal `json:"best_bid"`
+	BestAsk    decimal.NullDecimal `json:"best_ask"`
+	Imbalance  decimal.NullDecimal `json:"imbalance"`
+	Microprice decimal.NullDecimal `json:"microprice"`
+}
+
+// ComputeBookSignals computes the signals of a book from its levels as [price, amount], the best level first.
+// Levels past BookSignalLevels are ignored.
+func ComputeBookSignals(bids, asks [][]decimal.Decimal) BookSignals {
+	signals := BookSignals{}
+
+	if len(bids) > 0 {
+		signals.BestBid = decimal.NewNullDecimal(bids[0][0])
+	}
+
+	if len(asks) > 0 {
+		signals.BestAsk = decimal.NewNullDecimal(asks[0][0])
+	}
+
+	if len(bids) == 0 || len(asks) == 0 {
+		return signals
+	}
+
+	bid_volume := topVolume(bids)
+	ask_volume := topVolume(asks)
+	if total := bid_volume.Add(ask_volume); total.IsPositive() {
+		signals.Imbalance = decimal.NewNullDecimal(bid_volume.Sub(ask_volume).Div(total))
+	}
+
+	best_bid, best_ask := bids[0], asks[0]
+	if total := best_bid[1].Add(best_ask[1]); total.IsPositive() {
+		signals.Microprice = decimal.NewNullDecimal(best_bid[0].Mul(best_ask[1]).Add(best_ask[0].Mul(best_bid[1])).Div(total))
+	}
+
+	return signals
+}
+
+func topVolume(levels [][]decimal.Decimal) decimal.Decimal {
+	volume := decimal.Zero
+	for i, level := range levels {
+		if i == BookSignalLevels {
+			break
+		}
+
+		volume = volume.Add(level[1])
+	}
+
+	return volume
+}
+
+// topLevels returns the best levels of a side of the book as [price, amount], the best first.
+// The best level of both sides is the last of their tree.
+func (d *Depth) topLevels(price_levels *redblacktree.Tree, limit int) [][]decimal.Decimal {
+	levels := make([][]decimal.Decimal, 0, limit)
+
+	it := price_levels.Iterator()
+	it.End()
+	for len(levels) < limit && it.Prev() {
+		price_level := it.Value().(*PriceLevel)
+		levels = append(levels, []decimal.Decimal{price_level.Price, d.shown(price_level)})
+	}
+
+	return levels
+}
+
+// Signals computes the signals of the book from its top levels.
+func (d *Depth) Signals() BookSignals {
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
+
+	return ComputeBookSignals(d.topLevels(d.Bids, BookSignalLevels), d.topLevels(d.Asks, BookSignalLevels))
+}
+
+// Equal reports whether the signals are the same, so unchanged signals aren't published again.
+func (s BookSignals) Equal(other BookSignals) bool {
+	return sameNullDecimal(s.BestBid, other.BestBid) && sameNullDecimal(s.BestAsk, other.BestAsk) &&
+		sameNullDecimal(s.Imbalance, other.Imbalance) && sameNullDecimal(s.Microprice, other.Microprice)
+}
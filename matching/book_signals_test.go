@@ -0,0 +1,86 @@
+package matching
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+type bookSignalsCase struct {
+	Name       string              `json:"name"`
+	Bids       [][]decimal.Decimal `json:"bids"`
+	Asks       [][]decimal.Decimal `json:"asks"`
+	BestBid    decimal.NullDecimal `json:"best_bid"`
+	BestAsk    decimal.NullDecimal `json:"best_ask"`
+	Imbalance  decimal.NullDecimal `json:"imbalance"`
+	Microprice decimal.NullDecimal `json:"microprice"`
+}
+
+func TestComputeBookSignals(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "book_signals.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases []bookSignalsCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			want := BookSignals{BestBid: c.BestBid, BestAsk: c.BestAsk, Imbalance: c.Imbalance, Microprice: c.Microprice}
+
+			if got := ComputeBookSignals(c.Bids, c.Asks); !got.Equal(want) {
+				t.Errorf("expected %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestDepthSignals(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(10), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "9", "1"))
+	if signals := ob.Depth.Signals(); !signals.BestBid.Valid || signals.BestAsk.Valid || signals.Imbalance.Valid || signals.Microprice.Valid {
+		t.Fatalf("expected only the best bid of a one-sided book, got %+v", signals)
+	}
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "9", "2"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "8", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "11", "1"))
+
+	signals := ob.Depth.Signals()
+	if !signals.BestBid.Decimal.Equal(decimal.NewFromInt(9)) || !signals.BestAsk.Decimal.Equal(decimal.NewFromInt(11)) {
+		t.Errorf("expected the best prices 9 and 11, got %+v", signals)
+	}
+
+	// (4 - 1) / (4 + 1) and (9 * 1 + 11 * 3) / (1 + 3)
+	if !signals.Imbalance.Decimal.Equal(decimal.RequireFromString("0.6")) || !signals.Microprice.Decimal.Equal(decimal.RequireFromString("10.5")) {
+		t.Errorf("expected an imbalance of 0.6 and a microprice of 10.5, got %+v", signals)
+	}
+}
+
+func TestBookTickerOnlyWhenSignalsChange(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(10), OrderBookConfig{}, nil)
+	notification := ob.Depth.Notification
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "9", "1"))
+	ticker := notification.bookTicker(1)
+	if ticker == nil || ticker.Sequence != 1 || !ticker.BestBid.Valid {
+		t.Fatalf("expected a ticker with the best bid, got %+v", ticker)
+	}
+
+	if ticker := notification.bookTicker(2); ticker != nil {
+		t.Errorf("expected no ticker while the signals are unchanged, got %+v", ticker)
+	}
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "11", "1"))
+	if ticker := notification.bookTicker(3); ticker == nil || !ticker.Microprice.Valid {
+		t.Errorf("expected a ticker with the microprice, got %+v", ticker)
+	}
+}
@@ -0,0 +1,140 @@
+package matching
+
+import (
+	"time"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/pkg"
+)
+
+// CancelReasonAuctionUnfilled cancels what a market order of a call auction has left once the auction is uncrossed,
+// a market order can't rest in the book.
+const CancelReasonAuctionUnfilled CancelReason = "auction_unfilled"
+
+// callAuction is a book in an opening or closing call auction: the orders accumulate without matching, even when
+// they cross, until the auction is uncrossed at a single clearing price.
+type callAuction struct {
+	// market_orders are the market orders of the auction, they don't rest in the book
+	market_orders []*pkg.Order
+	// stopped is set on the auction of a book which is replaced, it's never uncrossed
+	stopped bool
+}
+
+// StartAuction starts a call auction in a cycle, see OrderBook.StartAuction.
+func (e *Engine) StartAuction() {
+	started_at := time.Now()
+
+	e.MatchingMutex.Lock()
+	defer e.MatchingMutex.Unlock()
+
+	e.OrderBook.StartAuction()
+
+	e.Metrics.Observe(Cycle{
+		Action:  events.ActionStartAuction,
+		Latency: time.Since(started_at),
+	})
+}
+
+// Uncross uncrosses the call auction in a cycle, see OrderBook.Uncross.
+func (e *Engine) Uncross() (Uncross, bool) {
+	started_at := time.Now()
+
+	e.MatchingMutex.Lock()
+	defer e.MatchingMutex.Unlock()
+
+	uncross, found := e.OrderBook.Uncross()
+
+	e.Metrics.Observe(Cycle{
+		Action:  events.ActionUncross,
+		Latency: time.Since(started_at),
+	})
+
+	return uncross, found
+}
+
+// StartAuction holds the book in a call auction until it's uncrossed. The orders submitted meanwhile are accepted
+// and the cancels taken, limit orders rest in the book and market orders wait out of it, but nothing is matched.
+// A book matching in batch auctions doesn't uncross its batches during the auction, the market orders its batch
+// was waiting with wait for the auction.
+func (ob *OrderBook) StartAuction() {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	if ob.auction != nil {
+		return
+	}
+
+	ob.auction = &callAuction{}
+	if ob.batch != nil {
+		ob.auction.market_orders, ob.batch.market_orders = ob.batch.market_orders, nil
+	}
+
+	config.Logger.Infof("[oceanbook.orderbook] %s started a call auction", ob.Symbol.String())
+}
+
+// InAuction reports whether the book is in a call auction.
+func (ob *OrderBook) InAuction() bool {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	return ob.auction != nil
+}
+
+// ContinueAuction holds the book in the call auction of the book previous, which this book replaces, a reload
+// doesn't uncross the auction. It's called before the orders are loaded: the crossing orders rest without matching
+// and the market orders loaded wait for the uncross.
+func (ob *OrderBook) ContinueAuction(previous *OrderBook) {
+	previous.orderMutex.Lock()
+	defer previous.orderMutex.Unlock()
+
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	if previous.auction != nil && ob.auction == nil {
+		ob.auction = &callAuction{}
+	}
+}
+
+// StopAuction stops the call auction of a book which is replaced, it's never uncrossed and the book doesn't match.
+func (ob *OrderBook) StopAuction() {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	if ob.auction != nil {
+		ob.auction.stopped = true
+	}
+}
+
+// Uncross ends the call auction of the book: the orders crossing each other trade at the clearing price, the price
+// executing the most volume with the smallest imbalance left, see ClearingPrice, then the book goes back to
+// continuous matching, or to its batch auctions. What the market orders of the auction have left is cancelled. found
+// is false when the orders don't cross, the auction ends without trades. A halted book stays in its auction.
+func (ob *OrderBook) Uncross() (uncross Uncross, found bool) {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	if ob.auction == nil || ob.auction.stopped || ob.halted() {
+		return uncross, false
+	}
+
+	market_orders := ob.auction.market_orders
+	ob.auction = nil
+
+	uncross, found = ob.uncross(market_orders)
+
+	for _, o := range market_orders {
+		if !o.Filled() {
+			ob.cancelUnmatched(o, CancelReasonAuctionUnfilled)
+		}
+	}
+
+	// the stop orders triggered by the clearing price wait for the next batch of a book matching in batches
+	if ob.batch == nil {
+		ob.matchPending()
+	} else {
+		ob.accumulatePending()
+	}
+
+	return uncross, found
+}
@@ -0,0 +1,137 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func TestCallAuctionUncross(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.StartAuction()
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "2"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "102", "1"))
+	resting := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1")
+	ob.Add(resting)
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeMarket, "", "2"))
+
+	// the cancels are taken during the auction, of the market orders waiting out of the book too
+	cancelled := newTestOrder(pkg.SideBuy, pkg.TypeMarket, "", "5")
+	ob.Add(cancelled)
+	if outcome := ob.Cancel(cancelled.Key(), ""); outcome != CancelOutcomeCancelled {
+		t.Errorf("expected the market order to be cancelled, got %s", outcome)
+	}
+
+	if len(publisher.Trades) != 0 || !ob.InAuction() {
+		t.Fatalf("expected nothing matched during the auction, got %d trades", len(publisher.Trades))
+	}
+
+	// the book is restored in its auction
+	restored, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	restored.restoreImage(ob.image())
+	if !restored.InAuction() || len(restored.auction.market_orders) != 1 {
+		t.Errorf("expected the restored book in its auction with its market order, got %+v", restored.auction)
+	}
+
+	// 101 and 102 both execute 3, 102 leaves no imbalance
+	uncross, found := ob.Uncross()
+	if !found || !uncross.Price.Equal(decimal.NewFromInt(102)) || !uncross.Volume.Equal(decimal.NewFromInt(3)) {
+		t.Fatalf("expected 3 uncrossed at 102, got %+v", uncross)
+	}
+
+	volume := decimal.Zero
+	for _, trade := range publisher.Trades {
+		if !trade.Price.Equal(uncross.Price) {
+			t.Errorf("expected every trade at the clearing price, got %s", trade.Price)
+		}
+		volume = volume.Add(trade.Quantity)
+	}
+
+	if !volume.Equal(uncross.Volume) || ob.InAuction() || !bookHas(ob, resting) || ob.Depth.Asks.Size() != 0 {
+		t.Errorf("expected the uncross to trade %s, got %s", uncross.Volume, volume)
+	}
+
+	// the book matches continuously again
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+	if len(publisher.Trades) != 4 || !publisher.Trades[3].Price.Equal(decimal.NewFromInt(101)) {
+		t.Errorf("expected the book to match once uncrossed, got %d trades", len(publisher.Trades))
+	}
+}
+
+func TestCallAuctionCancelsUnfilledMarketOrders(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.StartAuction()
+
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1")
+	ob.Add(bid)
+	market := newTestOrder(pkg.SideBuy, pkg.TypeMarket, "", "1")
+	ob.Add(market)
+
+	if _, found := ob.Uncross(); found {
+		t.Error("expected the orders not to cross")
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0] != (cancelRecord{ID: market.ID, Reason: CancelReasonAuctionUnfilled}) {
+		t.Errorf("expected the market order to be cancelled, got %+v", publisher.Cancels)
+	}
+
+	if !bookHas(ob, bid) || ob.InAuction() {
+		t.Error("expected the limit order to rest once the auction ended")
+	}
+
+	if _, found := ob.Uncross(); found {
+		t.Error("expected no auction to uncross")
+	}
+}
+
+// A book reloaded during its call auction loads its crossing orders without matching them, the auction of the book
+// replaced is never uncrossed.
+func TestCallAuctionContinues(t *testing.T) {
+	ob, previous_publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.StartAuction()
+	ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1")
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1")
+	market := newTestOrder(pkg.SideBuy, pkg.TypeMarket, "", "1")
+	ob.Add(ask)
+	ob.Add(bid)
+
+	reloaded, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	ob.StopAuction()
+	reloaded.ContinueAuction(ob)
+
+	// the orders are loaded again from the database
+	for _, o := range []*pkg.Order{ask, bid, market} {
+		loaded := *o
+		reloaded.Add(&loaded)
+	}
+
+	if !reloaded.InAuction() || len(publisher.Trades) != 0 || len(reloaded.auction.market_orders) != 1 {
+		t.Fatalf("expected the reloaded book to stay in its auction, got %d trades", len(publisher.Trades))
+	}
+
+	if _, found := ob.Uncross(); found || len(previous_publisher.Trades) != 0 {
+		t.Error("expected the auction of the book replaced not to be uncrossed")
+	}
+
+	uncross, found := reloaded.Uncross()
+	if !found || !uncross.Volume.Equal(decimal.NewFromInt(1)) || len(publisher.Trades) == 0 {
+		t.Errorf("expected the reloaded book to uncross its auction, got %+v", uncross)
+	}
+}
+
+func TestCallAuctionNotContinued(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	reloaded, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	reloaded.ContinueAuction(ob)
+
+	if reloaded.InAuction() {
+		t.Error("expected a book reloaded out of an auction to match continuously")
+	}
+}
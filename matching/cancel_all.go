@@ -0,0 +1,66 @@
+package matching
+
+import (
+	"time"
+
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/events"
+)
+
+// CancelAll cancels the orders of member_id in a cycle, see OrderBook.CancelAll.
+func (e *Engine) CancelAll(member_id int64, side pkg.OrderSide) []int64 {
+	started_at := time.Now()
+
+	e.MatchingMutex.Lock()
+	defer e.MatchingMutex.Unlock()
+
+	ids := e.OrderBook.CancelAll(member_id, side)
+
+	e.Metrics.Observe(Cycle{
+		Action:  events.ActionCancelAll,
+		Latency: time.Since(started_at),
+	})
+
+	return ids
+}
+
+// CancelAll takes the orders of member_id of side, of both sides when it's empty, out of the book and out of the
+// stop orders, and publishes a cancel of each, as cancels the member requested. It returns the ids of the orders
+// it cancelled, the asks then the bids of the book, then the stop orders. A halted book cancels none.
+func (ob *OrderBook) CancelAll(member_id int64, side pkg.OrderSide) []int64 {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	if ob.halted() {
+		return nil
+	}
+
+	orders := make([]*pkg.Order, 0)
+	for _, o := range ob.Depth.Orders() {
+		if o.MemberID == member_id && (len(side) == 0 || o.Side == side) {
+			orders = append(orders, o)
+		}
+	}
+
+	for _, book := range ob.stopBooks() {
+		for _, value := range book.Values() {
+			if o := value.(*pkg.Order); o.MemberID == member_id && (len(side) == 0 || o.Side == side) {
+				orders = append(orders, o)
+			}
+		}
+	}
+
+	ids := make([]int64, 0, len(orders))
+	for _, o := range orders {
+		key := o.Key()
+		if o.IsFake() || !ob.removeOrder(key) {
+			continue
+		}
+
+		ob.PublishCancel(key, "")
+		ids = append(ids, o.ID)
+	}
+
+	return ids
+}
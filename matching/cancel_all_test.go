@@ -0,0 +1,45 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func TestCancelAll(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	engine := newEngine(testSymbol, ob, 0)
+
+	member_order := func(member_id int64, o *pkg.Order) *pkg.Order {
+		o.MemberID = member_id
+		engine.Submit(o)
+
+		return o
+	}
+
+	ask := member_order(1, newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+	bid := member_order(1, newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1"))
+	stop := member_order(1, newTestStopOrder(pkg.SideBuy, "105", "106", "1"))
+	other := member_order(2, newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "2"))
+
+	if ids := engine.CancelAll(1, pkg.SideBuy); len(ids) != 2 || ids[0] != bid.ID || ids[1] != stop.ID {
+		t.Fatalf("expected the bid then the stop order to be cancelled, got %v", ids)
+	}
+
+	if ob.Depth.Get(ask.Key()) == nil || ob.Depth.Get(other.Key()) == nil || ob.Depth.Get(bid.Key()) != nil {
+		t.Error("expected only the buys of the member to leave the book")
+	}
+
+	if ids := engine.CancelAll(1, ""); len(ids) != 1 || ids[0] != ask.ID {
+		t.Errorf("expected the ask to be cancelled, got %v", ids)
+	}
+
+	if ids := engine.CancelAll(1, ""); len(ids) != 0 {
+		t.Errorf("expected nothing left to cancel, got %v", ids)
+	}
+
+	if len(publisher.Cancels) != 3 || publisher.Cancels[0].Reason != "" {
+		t.Errorf("expected a cancel of each order, got %+v", publisher.Cancels)
+	}
+}
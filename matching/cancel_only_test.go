@@ -0,0 +1,54 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func TestCancelOnlyRejectsOrders(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(10), OrderBookConfig{}, nil)
+
+	ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "1")
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "9", "1")
+	ob.Add(ask)
+	ob.Add(bid)
+
+	ob.SetCancelOnly(true)
+
+	// an order crossing the book isn't matched
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "10", "1")
+	ob.Add(taker)
+
+	if len(publisher.Trades) != 0 || bookHas(ob, taker) {
+		t.Fatalf("expected the order to be rejected, got %d trades", len(publisher.Trades))
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0] != (cancelRecord{ID: taker.ID, Reason: CancelReasonCancelOnly}) {
+		t.Fatalf("expected the order to be cancelled, got %+v", publisher.Cancels)
+	}
+
+	// a replace is a new order, the replaced one stays
+	replacement := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "9.5", "1")
+	if accepted, _ := ob.CancelReplace(bid.Key(), replacement, nil); accepted {
+		t.Error("expected the replace to be rejected")
+	}
+
+	if !bookHas(ob, bid) || bookHas(ob, replacement) {
+		t.Error("expected the replaced order to stay in the book")
+	}
+
+	// the orders in the book are still cancelled
+	ob.Remove(ask.Key())
+	if bookHas(ob, ask) {
+		t.Error("expected the cancelled order to leave the book")
+	}
+
+	ob.SetCancelOnly(false)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "9", "1"))
+	if len(publisher.Trades) != 1 {
+		t.Errorf("expected the book to match again once unset, got %d trades", len(publisher.Trades))
+	}
+}
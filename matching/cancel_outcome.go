@@ -0,0 +1,110 @@
+package matching
+
+import (
+	"sync"
+
+	"github.com/zsmartex/pkg"
+)
+
+// CancelOutcome is what a cancel command found, it's published to the producer of the command.
+type CancelOutcome string
+
+const (
+	// CancelOutcomeCancelled took the order out of the book.
+	CancelOutcomeCancelled CancelOutcome = "cancelled"
+	// CancelOutcomeAlreadyGone found the order gone, it was filled or cancelled before the command.
+	CancelOutcomeAlreadyGone CancelOutcome = "already_gone"
+	// CancelOutcomeNeverExisted found no trace of the order, the book never had it or forgot it.
+	CancelOutcomeNeverExisted CancelOutcome = "never_existed"
+	// CancelOutcomeRejected left the order in the book, the market is halted.
+	CancelOutcomeRejected CancelOutcome = "rejected"
+)
+
+// departedCapacity is the number of orders which left a book it remembers, a cancel of an order which left
+// before them is answered as if it never existed.
+const departedCapacity = 100000
+
+// departures remembers the ids of the last orders which left a book, filled or cancelled.
+type departures struct {
+	mutex sync.Mutex
+	ids   map[int64]struct{}
+	ring  []int64
+	next  int
+}
+
+func newDepartures(capacity int) *departures {
+	return &departures{
+		ids:  make(map[int64]struct{}, capacity),
+		ring: make([]int64, 0, capacity),
+	}
+}
+
+func (d *departures) add(id int64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, found := d.ids[id]; found {
+		return
+	}
+
+	if len(d.ring) < cap(d.ring) {
+		d.ring = append(d.ring, id)
+	} else {
+		delete(d.ids, d.ring[d.next])
+		d.ring[d.next] = id
+		d.next = (d.next + 1) % len(d.ring)
+	}
+
+	d.ids[id] = struct{}{}
+}
+
+// list returns the ids remembered, the oldest first.
+func (d *departures) list() []int64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	ids := make([]int64, 0, len(d.ring))
+	ids = append(ids, d.ring[d.next:]...)
+	return append(ids, d.ring[:d.next]...)
+}
+
+func (d *departures) has(id int64) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	_, found := d.ids[id]
+	return found
+}
+
+// Cancel takes the order of key out of the book for the cancel command command_id and publishes the outcome
+// with the id of the command. An order in the book or waiting for its stop price is cancelled, one which left
+// the book is already gone and one the book has no trace of never existed. A halted book rejects the cancel.
+func (ob *OrderBook) Cancel(key *pkg.OrderKey, command_id string) CancelOutcome {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	return ob.cancel(key, command_id)
+}
+
+// cancel cancels the order of key with the orderMutex held.
+func (ob *OrderBook) cancel(key *pkg.OrderKey, command_id string) CancelOutcome {
+	outcome := CancelOutcomeNeverExisted
+	switch {
+	case ob.halted():
+		outcome = CancelOutcomeRejected
+	case !validSide(key.Side):
+		// the book can't have an order of another side
+	case ob.removeOrder(key):
+		outcome = CancelOutcomeCancelled
+		ob.departed.add(key.ID)
+		ob.metrics.cancel("")
+	case ob.departed.has(key.ID):
+		outcome = CancelOutcomeAlreadyGone
+	}
+
+	if !key.Fake {
+		ob.publisher.PublishCancelOutcome(key, outcome, command_id)
+	}
+
+	return outcome
+}
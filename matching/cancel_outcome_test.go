@@ -0,0 +1,144 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/events"
+)
+
+func TestCancelOutcomes(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1")
+	ob.Add(bid)
+
+	if outcome := ob.Cancel(bid.Key(), "cmd-1"); outcome != CancelOutcomeCancelled || bookHas(ob, bid) {
+		t.Fatalf("expected the bid to be cancelled, got %s", outcome)
+	}
+
+	if outcome := ob.Cancel(bid.Key(), "cmd-2"); outcome != CancelOutcomeAlreadyGone {
+		t.Errorf("expected a second cancel to find the bid already gone, got %s", outcome)
+	}
+
+	// an order filled before the cancel
+	ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	ob.Add(ask)
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1"))
+
+	if outcome := ob.Cancel(ask.Key(), "cmd-3"); outcome != CancelOutcomeAlreadyGone {
+		t.Errorf("expected the filled ask to be already gone, got %s", outcome)
+	}
+
+	unknown := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	if outcome := ob.Cancel(unknown.Key(), "cmd-4"); outcome != CancelOutcomeNeverExisted {
+		t.Errorf("expected an order the book never had to never exist, got %s", outcome)
+	}
+
+	expected := []CancelOutcome{CancelOutcomeCancelled, CancelOutcomeAlreadyGone, CancelOutcomeAlreadyGone, CancelOutcomeNeverExisted}
+	if len(publisher.Outcomes) != len(expected) {
+		t.Fatalf("expected an outcome per cancel, got %v", publisher.Outcomes)
+	}
+
+	for i := range expected {
+		if publisher.Outcomes[i] != expected[i] {
+			t.Errorf("expected the outcomes %v, got %v", expected, publisher.Outcomes)
+			break
+		}
+	}
+
+	// only the cancel which cancelled is a cancel
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0].ID != bid.ID {
+		t.Errorf("expected the bid to be the only cancelled order, got %+v", publisher.Cancels)
+	}
+}
+
+func TestSubmitDeliveredAgainDropped(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1")
+	ob.Add(bid)
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1"))
+	ob.Add(bid)
+
+	if depth := ob.Depth.Snapshot(); len(depth.Bids) != 1 || !depth.Bids[0][1].Equal(decimal.NewFromInt(2)) {
+		t.Fatalf("expected the bid resting once, got %+v", depth.Bids)
+	}
+
+	// an order which left the book isn't matched again
+	ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "99", "1")
+	ob.Add(ask)
+	ob.Add(ask)
+
+	if len(publisher.Trades) != 1 {
+		t.Fatalf("expected the ask filled once, got %d trades", len(publisher.Trades))
+	}
+
+	// nor is an order submitted before the image the book was restored from
+	restored, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	restored.restoreImage(ob.image())
+	restored.Add(bid)
+	restored.Add(ask)
+
+	if depth := restored.Depth.Snapshot(); len(depth.Bids) != 1 || !depth.Bids[0][1].Equal(decimal.NewFromInt(1)) || len(depth.Asks) != 0 {
+		t.Errorf("expected the restored book to drop the submits, got %+v %+v", depth.Bids, depth.Asks)
+	}
+}
+
+func TestCancelStopOrder(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	stop := newTestStopOrder(pkg.SideSell, "95", "94", "1")
+	ob.Add(stop)
+
+	if outcome := ob.Remove(stop.Key()); outcome != CancelOutcomeCancelled || ob.StopAsks.Size() != 0 {
+		t.Errorf("expected the stop order to be cancelled out of the stop orders, got %s", outcome)
+	}
+}
+
+func TestCancelOutcomeEvent(t *testing.T) {
+	key := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1").Key()
+
+	cases := map[CancelOutcome]events.Order{
+		CancelOutcomeCancelled:    {Action: pkg.ActionCancel},
+		CancelOutcomeAlreadyGone:  {Action: events.ActionCancelAlreadyGone, Reason: string(CancelReasonAlreadyGone)},
+		CancelOutcomeNeverExisted: {Action: events.ActionCancelNeverExisted, Reason: string(CancelReasonNeverExisted)},
+	}
+
+	for outcome, want := range cases {
+		event := cancelOutcomeEvent(key, outcome, "cmd-1", true)
+		if event.Action != want.Action || event.Reason != want.Reason || event.CommandID != "cmd-1" || event.ID != key.ID {
+			t.Errorf("%s: unexpected event %+v", outcome, event)
+		}
+
+		// producers which don't tell them apart answer every cancel command with a cancel
+		if event := cancelOutcomeEvent(key, outcome, "cmd-1", false); event.Action != pkg.ActionCancel || len(event.Reason) > 0 || len(event.CommandID) > 0 {
+			t.Errorf("%s: expected a cancel, got %+v", outcome, event)
+		}
+	}
+
+	event := cancelOutcomeEvent(key, CancelOutcomeRejected, "cmd-1", true)
+	if event.Action != events.ActionCancelRejected || event.Reason != string(CancelReasonHalted) || event.CommandID != "cmd-1" {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	// the order is still in the book, it mustn't be cancelled
+	if event := cancelOutcomeEvent(key, CancelOutcomeRejected, "cmd-1", false); event != nil {
+		t.Errorf("expected no event of a rejected cancel, got %+v", event)
+	}
+}
+
+func TestDeparturesForgetTheOldest(t *testing.T) {
+	departed := newDepartures(2)
+
+	departed.add(1)
+	departed.add(2)
+	departed.add(2)
+	departed.add(3)
+
+	if departed.has(1) || !departed.has(2) || !departed.has(3) {
+		t.Errorf("expected the last 2 orders to be remembered, got %v", departed.ids)
+	}
+}
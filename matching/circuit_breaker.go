@@ -0,0 +1,222 @@
+package matching
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/types"
+)
+
+// CancelReasonCircuitBreaker cancels an order submitted to a book whose circuit breaker tripped.
+const CancelReasonCircuitBreaker CancelReason = "circuit_breaker"
+
+// CircuitBreakerConfig trips the circuit breaker of a book when a trade is priced more than Rate away from the
+// price of the book Window before it, the book takes cancels only for CoolDown then. A zero field disables it.
+type CircuitBreakerConfig struct {
+	Rate     decimal.Decimal
+	Window   time.Duration
+	CoolDown time.Duration
+}
+
+func (c CircuitBreakerConfig) Enabled() bool {
+	return c.Rate.IsPositive() && c.Window > 0 && c.CoolDown > 0
+}
+
+// CircuitBreak is a circuit breaker of a book tripping or lifted.
+type CircuitBreak struct {
+	Symbol pkg.Symbol
+	// Tripped is set when the breaker trips, it's unset once the breaker is lifted
+	Tripped bool
+	// State is what the book takes from then on
+	State types.TradingState
+	// Price is the price of the trade which tripped the breaker, Reference the price it moved away from
+	Price     decimal.Decimal
+	Reference decimal.Decimal
+	At        time.Time
+	// Until is when the breaker lifts itself, zero once it's lifted
+	Until time.Time
+}
+
+// pricePoint is the last trade price of a second of the window of a circuit breaker.
+type pricePoint struct {
+	at    time.Time
+	price decimal.Decimal
+}
+
+// circuitBreaker keeps the prices of the trades of a book over its window, from its own trades.
+type circuitBreaker struct {
+	config CircuitBreakerConfig
+	// prices are the last trade price of each second with trades, oldest first. The first one is the reference,
+	// the price at the start of the window or the first one after it when the book didn't trade before.
+	prices []pricePoint
+	// until is when the book takes orders again, zero while the breaker isn't tripped
+	until time.Time
+	timer clock.Timer
+}
+
+// observe records a trade price at at and returns the reference it's compared to.
+func (b *circuitBreaker) observe(at time.Time, price decimal.Decimal) decimal.Decimal {
+	// the reference isn't overwritten by the trades of its own second
+	second := at.Truncate(time.Second)
+	if last := len(b.prices) - 1; last > 0 && b.prices[last].at.Equal(second) {
+		b.prices[last].price = price
+	} else {
+		b.prices = append(b.prices, pricePoint{at: second, price: price})
+	}
+
+	// the price the window starts with is kept as the reference
+	start := at.Add(-b.config.Window)
+	drop := 0
+	for drop+1 < len(b.prices) && !b.prices[drop+1].at.After(start) {
+		drop++
+	}
+	b.prices = b.prices[drop:]
+
+	return b.prices[0].price
+}
+
+func (b *circuitBreaker) tripped() bool {
+	return !b.until.IsZero()
+}
+
+// tripCircuitBreaker checks the price of a trade against the window of the breaker with the orderMutex held, it
+// trips the breaker when the trade moved the price too far. The order being matched and the stop orders it
+// triggered are matched to the end, the book takes cancels only from the next command.
+func (ob *OrderBook) tripCircuitBreaker(price decimal.Decimal) {
+	breaker := ob.breaker
+	if !breaker.config.Enabled() || breaker.tripped() {
+		return
+	}
+
+	now := ob.clock.Now()
+	reference := breaker.observe(now, price)
+	if !reference.IsPositive() || price.Sub(reference).Abs().Div(reference).LessThanOrEqual(breaker.config.Rate) {
+		return
+	}
+
+	breaker.until = now.Add(breaker.config.CoolDown)
+	// the book starts over from the price it tripped at once it's lifted
+	breaker.prices = []pricePoint{{at: now.Truncate(time.Second), price: price}}
+	ob.scheduleCircuitBreakerLift(breaker.until.Sub(now))
+
+	config.Logger.Warnf("[oceanbook.orderbook] %s circuit breaker tripped at %s, %s from %s, until %s", ob.Symbol.String(), price, price.Sub(reference).Div(reference).StringFixed(4), reference, breaker.until)
+
+	ob.publisher.PublishCircuitBreak(&CircuitBreak{
+		Symbol:    ob.Symbol,
+		Tripped:   true,
+		State:     ob.tradingStateLocked(),
+		Price:     price,
+		Reference: reference,
+		At:        now,
+		Until:     breaker.until,
+	})
+}
+
+func (ob *OrderBook) scheduleCircuitBreakerLift(cool_down time.Duration) {
+	breaker := ob.breaker
+	breaker.timer = ob.clock.AfterFunc(cool_down, func() {
+		ob.orderMutex.Lock()
+		defer ob.orderMutex.Unlock()
+
+		// lifted or replaced meanwhile
+		if ob.breaker != breaker || !breaker.tripped() || ob.clock.Now().Before(breaker.until) {
+			return
+		}
+
+		ob.liftCircuitBreaker()
+	})
+}
+
+// liftCircuitBreaker lets the book take orders again with the orderMutex held.
+func (ob *OrderBook) liftCircuitBreaker() bool {
+	breaker := ob.breaker
+	if !breaker.tripped() {
+		return false
+	}
+
+	if breaker.timer != nil {
+		breaker.timer.Stop()
+		breaker.timer = nil
+	}
+	breaker.until = time.Time{}
+
+	config.Logger.Infof("[oceanbook.orderbook] %s circuit breaker lifted", ob.Symbol.String())
+
+	ob.publisher.PublishCircuitBreak(&CircuitBreak{
+		Symbol: ob.Symbol,
+		State:  ob.tradingStateLocked(),
+		Price:  ob.MarketPrice,
+		At:     ob.clock.Now(),
+	})
+
+	return true
+}
+
+// LiftCircuitBreaker lifts the circuit breaker of the book in a cycle, see OrderBook.LiftCircuitBreaker.
+func (e *Engine) LiftCircuitBreaker() bool {
+	started_at := time.Now()
+
+	e.MatchingMutex.Lock()
+	defer e.MatchingMutex.Unlock()
+
+	lifted := e.OrderBook.LiftCircuitBreaker()
+
+	e.Metrics.Observe(Cycle{
+		Action:  events.ActionLiftCircuitBreaker,
+		Latency: time.Since(started_at),
+	})
+
+	return lifted
+}
+
+// LiftCircuitBreaker lets the book take orders before the cool-down of its tripped circuit breaker is over, it
+// reports whether the breaker was tripped.
+func (ob *OrderBook) LiftCircuitBreaker() bool {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	return ob.liftCircuitBreaker()
+}
+
+// CircuitBreakerUntil returns when the tripped circuit breaker of the book lifts itself, zero when it isn't tripped.
+func (ob *OrderBook) CircuitBreakerUntil() time.Time {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	return ob.breaker.until
+}
+
+// ContinueCircuitBreaker takes the prices and the cool-down of the circuit breaker of the book previous, which this
+// book replaces, a reload neither lifts the breaker nor forgets the window.
+func (ob *OrderBook) ContinueCircuitBreaker(previous *OrderBook) {
+	previous.orderMutex.Lock()
+	defer previous.orderMutex.Unlock()
+
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	from := previous.breaker
+	if from.timer != nil {
+		from.timer.Stop()
+		from.timer = nil
+	}
+
+	ob.breaker.prices = append([]pricePoint(nil), from.prices...)
+	if !from.tripped() {
+		return
+	}
+
+	ob.breaker.until = from.until
+	from.until = time.Time{}
+
+	if remaining := ob.breaker.until.Sub(ob.clock.Now()); remaining > 0 {
+		ob.scheduleCircuitBreakerLift(remaining)
+	} else {
+		ob.liftCircuitBreaker()
+	}
+}
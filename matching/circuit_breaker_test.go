@@ -0,0 +1,145 @@
+package matching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/finex/types"
+	"github.com/zsmartex/pkg"
+)
+
+var circuitBreakerTestStart = time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+
+func newCircuitBreakerTestBook() (*OrderBook, *recordingPublisher, *clock.Fake) {
+	fake := clock.NewFake(circuitBreakerTestStart)
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{
+		CircuitBreaker: CircuitBreakerConfig{
+			Rate:     decimal.RequireFromString("0.1"),
+			Window:   time.Minute,
+			CoolDown: 5 * time.Minute,
+		},
+	}, fake)
+
+	return ob, publisher, fake
+}
+
+func TestCircuitBreakerTrips(t *testing.T) {
+	ob, publisher, fake := newCircuitBreakerTestBook()
+
+	tradeAt(ob, "100")
+	fake.Advance(30 * time.Second)
+	tradeAt(ob, "109")
+
+	if ob.TradingState() != types.TradingStateTrading || len(publisher.CircuitBreaks) != 0 {
+		t.Fatal("expected a move within the rate not to trip the breaker")
+	}
+
+	resting := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "90", "1")
+	ob.Add(resting)
+
+	fake.Advance(10 * time.Second)
+	tradeAt(ob, "111")
+
+	if ob.TradingState() != types.TradingStateCancelOnly || len(publisher.CircuitBreaks) != 1 {
+		t.Fatalf("expected the breaker to trip, got %s", ob.TradingState())
+	}
+
+	circuit_break := publisher.CircuitBreaks[0]
+	if !circuit_break.Tripped || !circuit_break.Reference.Equal(decimal.NewFromInt(100)) || !circuit_break.Until.Equal(fake.Now().Add(5*time.Minute)) {
+		t.Errorf("expected the trip from 100 until the end of the cool-down, got %+v", circuit_break)
+	}
+
+	rejected := newTestOrder(pkg.SideSell, pkg.TypeLimit, "90", "1")
+	ob.Add(rejected)
+	if len(publisher.Trades) != 3 || publisher.Cancels[len(publisher.Cancels)-1] != (cancelRecord{ID: rejected.ID, Reason: CancelReasonCircuitBreaker}) {
+		t.Errorf("expected the order to be rejected, got %+v", publisher.Cancels)
+	}
+
+	if outcome := ob.Cancel(resting.Key(), ""); outcome != CancelOutcomeCancelled {
+		t.Errorf("expected the cancels to be taken, got %s", outcome)
+	}
+
+	fake.Advance(5 * time.Minute)
+
+	if ob.TradingState() != types.TradingStateTrading || !ob.CircuitBreakerUntil().IsZero() {
+		t.Fatal("expected the breaker to lift after its cool-down")
+	}
+
+	if len(publisher.CircuitBreaks) != 2 || publisher.CircuitBreaks[1].Tripped || publisher.CircuitBreaks[1].State != types.TradingStateTrading {
+		t.Errorf("expected the lift to be published, got %+v", publisher.CircuitBreaks)
+	}
+
+	// the book starts over from the price it tripped at
+	tradeAt(ob, "115")
+	if ob.TradingState() != types.TradingStateTrading {
+		t.Error("expected the window to start from the price the breaker tripped at")
+	}
+}
+
+func TestCircuitBreakerWindowSlides(t *testing.T) {
+	ob, publisher, fake := newCircuitBreakerTestBook()
+
+	tradeAt(ob, "100")
+	fake.Advance(50 * time.Second)
+	tradeAt(ob, "108")
+	fake.Advance(70 * time.Second)
+
+	// the price a minute before is 108, 116 is 7.4% away from it
+	tradeAt(ob, "116")
+	if ob.TradingState() != types.TradingStateTrading || len(publisher.CircuitBreaks) != 0 {
+		t.Errorf("expected the reference to slide with the window, got %+v", publisher.CircuitBreaks)
+	}
+}
+
+func TestCircuitBreakerLift(t *testing.T) {
+	ob, publisher, fake := newCircuitBreakerTestBook()
+
+	tradeAt(ob, "100")
+	tradeAt(ob, "80")
+
+	if !ob.LiftCircuitBreaker() || ob.TradingState() != types.TradingStateTrading {
+		t.Fatal("expected the breaker to be lifted")
+	}
+
+	if ob.LiftCircuitBreaker() {
+		t.Error("expected the breaker lifted not to be tripped")
+	}
+
+	// the cool-down lifted early doesn't lift it again
+	fake.Advance(5 * time.Minute)
+	if len(publisher.CircuitBreaks) != 2 {
+		t.Errorf("expected a trip and a lift, got %+v", publisher.CircuitBreaks)
+	}
+}
+
+func TestCircuitBreakerContinues(t *testing.T) {
+	ob, _, fake := newCircuitBreakerTestBook()
+
+	tradeAt(ob, "100")
+	tradeAt(ob, "80")
+
+	reloaded, _ := newTestOrderBook(decimal.NewFromInt(80), OrderBookConfig{CircuitBreaker: ob.breaker.config}, fake)
+	reloaded.ContinueCircuitBreaker(ob)
+
+	if reloaded.TradingState() != types.TradingStateCancelOnly || !reloaded.CircuitBreakerUntil().Equal(fake.Now().Add(5*time.Minute)) {
+		t.Fatalf("expected the reloaded book to keep the breaker tripped, got %s", reloaded.TradingState())
+	}
+
+	fake.Advance(5 * time.Minute)
+	if reloaded.TradingState() != types.TradingStateTrading {
+		t.Error("expected the reloaded book to lift the breaker after the cool-down")
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	tradeAt(ob, "100")
+	tradeAt(ob, "200")
+
+	if ob.TradingState() != types.TradingStateTrading || len(publisher.CircuitBreaks) != 0 {
+		t.Error("expected a book without a circuit breaker to take orders")
+	}
+}
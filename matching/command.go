@@ -0,0 +1,94 @@
+package matching
+
+import (
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/events"
+)
+
+// Apply processes a command of the matching topic on the engine of its market, as the engine server does, for the
+// tools rebuilding a book from the commands it processed. The orders the server drops before they get to the book
+// are dropped, the ones outside the size limits are cancelled. It reports whether the engine knows the command.
+func (e *Engine) Apply(command *events.MatchingPayload) bool {
+	switch command.Action {
+	case pkg.ActionSubmit:
+		if command.Order == nil {
+			return false
+		}
+
+		if command.Order.Price.IsNegative() || command.Order.StopPrice.IsNegative() {
+			return true
+		}
+
+		if !e.SizeLimits.Accept(command.Order) {
+			e.OrderBook.PublishCancel(command.Order.Key(), CancelReasonOrderSize)
+			return true
+		}
+
+		e.SubmitWithOptions(command.Order, command.Options)
+	case pkg.ActionCancel:
+		if command.Order == nil {
+			return false
+		}
+
+		e.CancelCommand(command.Order.Key(), command.CommandID)
+	case pkg.ActionCancelWithKey:
+		if command.Key == nil {
+			return false
+		}
+
+		e.CancelCommand(command.Key, command.CommandID)
+	case events.ActionCancelReplace:
+		if command.Key == nil || command.Order == nil {
+			return false
+		}
+
+		e.CancelReplace(command.Key, command.Order, command.Options)
+	case events.ActionAmend:
+		if command.Key == nil || command.Amendment == nil {
+			return false
+		}
+
+		e.Amend(command.Key, command.Amendment)
+	case events.ActionRekey:
+		if command.Precision == nil {
+			return false
+		}
+
+		e.Reprice(command.Precision.PricePrecision)
+	case events.ActionBatch:
+		if command.Batch == nil {
+			return false
+		}
+
+		e.ProcessBatch(command.Batch)
+	case events.ActionCancelAll:
+		if command.CancelAll == nil {
+			return false
+		}
+
+		e.CancelAll(command.CancelAll.MemberID, command.CancelAll.Side)
+	case events.ActionTradingState:
+		if command.TradingState == nil || !command.TradingState.State.Valid() {
+			return false
+		}
+
+		e.SetTradingState(command.TradingState.State)
+	case events.ActionIndexPrice:
+		if command.IndexPrice == nil || !command.IndexPrice.Price.IsPositive() {
+			return false
+		}
+
+		e.UpdateIndexPrice(command.IndexPrice.Price)
+	case events.ActionStartAuction:
+		e.StartAuction()
+	case events.ActionUncross:
+		e.Uncross()
+	case events.ActionLiftCircuitBreaker:
+		e.LiftCircuitBreaker()
+	default:
+		return false
+	}
+
+	return true
+}
@@ -26,6 +26,16 @@ type Depth struct {
 	Asks         *redblacktree.Tree
 	Bids         *redblacktree.Tree
 	Notification *Notification
+	Flags        FeatureFlags
+
+	// icebergs are the slices shown by the iceberg orders, by id
+	icebergs map[int64]*iceberg
+	// diffs sequences the changes of the levels for the depth subscribers
+	diffs depthDiffs
+	// ticker takes the best prices of the depth, set by the book it belongs to
+	ticker *ticker
+	// resting are the orders of the book by id
+	resting map[int64]*pkg.Order
 
 	// default peatio ws
 	SnapshotTime   time.Time
@@ -33,12 +43,27 @@ type Depth struct {
 	// close
 }
 
-func NewDepth(symbol pkg.Symbol) *Depth {
+func NewDepth(symbol pkg.Symbol, flags FeatureFlags) *Depth {
+	depth := newDepth(symbol, NewNotification(symbol), flags)
+	depth.Notification.Start()
+
+	return depth
+}
+
+func newDepth(symbol pkg.Symbol, notification *Notification, flags FeatureFlags) *Depth {
 	depth := &Depth{
 		Symbol:       symbol,
 		Asks:         redblacktree.NewWith(makeComparator),
 		Bids:         redblacktree.NewWith(makeComparator),
-		Notification: NewNotification(symbol),
+		Notification: notification,
+		Flags:        flags,
+		icebergs:     make(map[int64]*iceberg),
+		resting:      make(map[int64]*pkg.Order),
+	}
+
+	if notification != nil {
+		notification.signals = depth.Signals
+		notification.top = depth.Top
 	}
 
 	return depth
@@ -47,98 +72,147 @@ func NewDepth(symbol pkg.Symbol) *Depth {
 func (d *Depth) Add(o *pkg.Order) {
 	d.depthMutex.Lock()
 	defer d.depthMutex.Unlock()
-	var price_levels *redblacktree.Tree
-	if o.Side == pkg.SideSell {
-		price_levels = d.Asks
-	} else {
-		price_levels = d.Bids
+
+	d.resting[o.ID] = o
+
+	price_level := d.level(o.Side, o.Price)
+	if price_level == nil {
+		price_level = newPriceLevel(o.Side, o.Price, d.Flags)
+		d.levels(o.Side).Put(price_level.Key(), price_level)
 	}
 
-	pl := NewPriceLevel(o.Side, o.Price)
+	price_level.Add(o)
+	d.publish(price_level.Side, price_level.Price, d.shown(price_level))
+}
 
-	value, found := price_levels.Get(pl.Key())
+// levelKeys are the keys the price levels are looked up with. The trees never keep them: Get only compares the key
+// with the ones of the tree, and the levels are put and removed with a key of their own, see PriceLevel.Key. A key is
+// back in the pool once the lookup returned, the lookups under the read lock of the depth each take their own.
+var levelKeys = sync.Pool{
+	New: func() interface{} {
+		return new(PriceLevelKey)
+	},
+}
+
+// level returns the price level of price on side of the book, nil when there's none, with the depthMutex held.
+func (d *Depth) level(side pkg.OrderSide, price decimal.Decimal) *PriceLevel {
+	key := levelKeys.Get().(*PriceLevelKey)
+	key.Side, key.Price = side, price
+	value, found := d.levels(side).Get(key)
+	*key = PriceLevelKey{}
+	levelKeys.Put(key)
 
 	if !found {
-		pl.Add(o)
-		price_levels.Put(pl.Key(), pl)
-		d.Notification.Publish(pl.Side, pl.Price, pl.Total())
-		return
+		return nil
 	}
 
-	price_level := value.(*PriceLevel)
-	price_level.Add(o)
-	d.Notification.Publish(price_level.Side, price_level.Price, price_level.Total())
+	return value.(*PriceLevel)
 }
 
-func (d *Depth) Remove(key *pkg.OrderKey) {
+// Remove takes the order out of the book and reports whether it was in it.
+func (d *Depth) Remove(key *pkg.OrderKey) bool {
 	d.depthMutex.Lock()
 	defer d.depthMutex.Unlock()
-	var price_levels *redblacktree.Tree
-	if key.Side == pkg.SideSell {
-		price_levels = d.Asks
-	} else {
-		price_levels = d.Bids
+
+	price_level := d.level(key.Side, key.Price)
+	if price_level == nil {
+		return false
 	}
 
-	pl := NewPriceLevel(key.Side, key.Price)
+	removed := price_level.Remove(key)
+	if removed {
+		delete(d.resting, key.ID)
+	}
 
-	value, found := price_levels.Get(pl.Key())
+	// the quantity of the level is summed once, after the order left it
+	remain_quantity := decimal.Zero
+	if !price_level.Empty() {
+		remain_quantity = d.shown(price_level)
+	}
 
-	if !found {
-		return
+	if remain_quantity.IsZero() {
+		d.levels(key.Side).Remove(price_level.Key())
 	}
 
-	price_level := value.(*PriceLevel)
-	remain_quantity := price_level.Remove(key)
+	d.publish(price_level.Side, price_level.Price, remain_quantity)
+
+	return removed
+}
+
+// Get returns the order of key in the book, nil when it isn't in it.
+func (d *Depth) Get(key *pkg.OrderKey) *pkg.Order {
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
 
-	if price_level.Empty() || remain_quantity.IsZero() {
-		price_levels.Remove(pl.Key())
+	price_level := d.level(key.Side, key.Price)
+	if price_level == nil {
+		return nil
 	}
 
-	d.Notification.Publish(pl.Side, pl.Price, remain_quantity)
+	return price_level.Get(key)
 }
 
-func (d *Depth) FetchOrderBook(limit int64) *GrpcEngine.FetchOrderBookResponse {
-	d.depthMutex.Lock()
-	defer d.depthMutex.Unlock()
+// Orders returns the orders of the book, the asks then the bids.
+func (d *Depth) Orders() []*pkg.Order {
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
+
+	orders := make([]*pkg.Order, 0)
+	for _, price_levels := range []*redblacktree.Tree{d.Asks, d.Bids} {
+		for _, value := range price_levels.Values() {
+			for _, order := range value.(*PriceLevel).Orders.Values() {
+				orders = append(orders, order.(*pkg.Order))
+			}
+		}
+	}
 
-	result := &GrpcEngine.FetchOrderBookResponse{
-		Symbol:   &GrpcSymbol.Symbol{BaseCurrency: d.Symbol.BaseCurrency, QuoteCurrency: d.Symbol.QuoteCurrency},
-		Asks:     make([]*GrpcEngine.BookOrder, 0),
-		Bids:     make([]*GrpcEngine.BookOrder, 0),
-		Sequence: d.Notification.Sequence,
+	return orders
+}
+
+// Levels returns the price and the quantity shown of every price level of the book, best first.
+func (d *Depth) Levels() (asks, bids [][]decimal.Decimal) {
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
+
+	return d.shownLevels(d.Asks), d.shownLevels(d.Bids)
+}
+
+// shownLevels returns the price and the quantity shown of the price levels, best first, with the depthMutex held.
+func (d *Depth) shownLevels(price_levels *redblacktree.Tree) [][]decimal.Decimal {
+	result := make([][]decimal.Decimal, 0, price_levels.Size())
+
+	it := price_levels.Iterator()
+	it.End()
+	for it.Prev() {
+		price_level := it.Value().(*PriceLevel)
+		result = append(result, []decimal.Decimal{price_level.Price, d.shown(price_level)})
 	}
 
-	ait := d.Asks.Iterator()
-	ait.End()
-	var i int64
-	for i = 0; ait.Prev() && i < limit; i++ {
-		price_level := ait.Value().(*PriceLevel)
-		price := price_level.Price
-		quantity := price_level.Total()
+	return result
+}
 
-		result.Asks = append(result.Asks, &GrpcEngine.BookOrder{
-			PriceQuantity: []*GrpcUtils.Decimal{
-				{
-					Val: price.CoefficientInt64(),
-					Exp: price.Exponent(),
-				},
-				{
-					Val: quantity.CoefficientInt64(),
-					Exp: quantity.Exponent(),
-				},
-			},
-		})
+// FetchOrderBook returns the best limit levels of each side of the book, from the same levels as the top-N snapshots.
+func (d *Depth) FetchOrderBook(limit int64) *GrpcEngine.FetchOrderBookResponse {
+	asks, bids := d.Top(int(limit))
+
+	d.Notification.NotifyMutex.RLock()
+	sequence := d.Notification.Sequence
+	d.Notification.NotifyMutex.RUnlock()
+
+	return &GrpcEngine.FetchOrderBookResponse{
+		Symbol:   &GrpcSymbol.Symbol{BaseCurrency: d.Symbol.BaseCurrency, QuoteCurrency: d.Symbol.QuoteCurrency},
+		Asks:     bookOrders(asks),
+		Bids:     bookOrders(bids),
+		Sequence: sequence,
 	}
+}
 
-	bit := d.Bids.Iterator()
-	bit.End()
-	for i = 0; bit.Prev() && i < limit; i++ {
-		price_level := bit.Value().(*PriceLevel)
-		price := price_level.Price
-		quantity := price_level.Total()
+func bookOrders(levels [][]decimal.Decimal) []*GrpcEngine.BookOrder {
+	book_orders := make([]*GrpcEngine.BookOrder, 0, len(levels))
+	for _, level := range levels {
+		price, quantity := level[0], level[1]
 
-		result.Bids = append(result.Bids, &GrpcEngine.BookOrder{
+		book_orders = append(book_orders, &GrpcEngine.BookOrder{
 			PriceQuantity: []*GrpcUtils.Decimal{
 				{
 					Val: price.CoefficientInt64(),
@@ -152,7 +226,7 @@ func (d *Depth) FetchOrderBook(limit int64) *GrpcEngine.FetchOrderBookResponse {
 		})
 	}
 
-	return result
+	return book_orders
 }
 
 func (d *Depth) PublishSnapshot() {
@@ -166,7 +240,7 @@ func (d *Depth) PublishSnapshot() {
 		i++
 		pl := r.(*PriceLevel)
 
-		asks_depth = append(asks_depth, []decimal.Decimal{pl.Price, pl.Total()})
+		asks_depth = append(asks_depth, []decimal.Decimal{pl.Price, d.shown(pl)})
 		if i >= 300 {
 			break
 		}
@@ -177,23 +251,32 @@ func (d *Depth) PublishSnapshot() {
 		i++
 		pl := r.(*PriceLevel)
 
-		bids_depth = append(bids_depth, []decimal.Decimal{pl.Price, pl.Total()})
+		bids_depth = append(bids_depth, []decimal.Decimal{pl.Price, d.shown(pl)})
 		if i >= 300 {
 			break
 		}
 	}
 
-	config.RangoClient.EnqueueEvent(pkg.EnqueueEventKindPublic, strings.ToLower(d.Symbol.ToSymbol("")), "ob-snap", pkg.DepthJSON{
-		Asks:     asks_depth,
-		Bids:     bids_depth,
-		Sequence: d.Notification.Sequence,
+	config.RangoClient.EnqueueEvent(pkg.EnqueueEventKindPublic, strings.ToLower(d.Symbol.ToSymbol("")), "ob-snap", checksummedDepth{
+		DepthJSON: pkg.DepthJSON{
+			Asks:     asks_depth,
+			Bids:     bids_depth,
+			Sequence: d.Notification.Sequence,
+		},
+		Checksum: d.Checksum(),
 	})
 }
 
+// makeComparator orders the price levels of a side of the book, the best level is the greatest. Levels of different
+// sides can't be ordered, it panics with an InconsistencyError.
 func makeComparator(a, b interface{}) int {
 	aPriceLevel := a.(*PriceLevelKey)
 	bPriceLevel := b.(*PriceLevelKey)
 
+	if aPriceLevel.Side != bPriceLevel.Side || !validSide(aPriceLevel.Side) {
+		panic(inconsistency("price levels %s and %s of sides %q and %q compared", aPriceLevel.Price, bPriceLevel.Price, aPriceLevel.Side, bPriceLevel.Side))
+	}
+
 	switch {
 	case aPriceLevel.Side == pkg.SideSell && aPriceLevel.Price.LessThan(bPriceLevel.Price):
 		return 1
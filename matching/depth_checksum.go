@@ -0,0 +1,59 @@
+package matching
+
+import (
+	"hash/crc32"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+const (
+	// ChecksumLevels are the best levels of each side of a book its checksum covers
+	ChecksumLevels = 25
+	// depthChecksumEvery is how many depth diffs there are between two diffs carrying the checksum of the book
+	depthChecksumEvery = 100
+)
+
+// checksummedDepth is a depth snapshot with the checksum of the book.
+type checksummedDepth struct {
+	pkg.DepthJSON
+	Checksum uint32 `json:"checksum"`
+}
+
+// Checksum is the CRC32 (IEEE) of the best ChecksumLevels levels of each side of the book, for the clients to
+// verify the book they rebuilt from the depth diffs.
+//
+// The checksummed string interleaves the levels best first, bid then ask: "bid_price:bid_amount:ask_price:ask_amount"
+// for the best levels, then for the second best and so on, a side out of levels is skipped. Every field is a
+// decimal in plain notation without trailing zeros, nor a trailing point: 30100.50 is "30100.5" and 2.000 is "2".
+func (d *Depth) Checksum() uint32 {
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
+
+	return d.checksum()
+}
+
+// checksum computes the checksum of the book with the depthMutex held.
+func (d *Depth) checksum() uint32 {
+	asks, bids := d.topLevels(d.Asks, ChecksumLevels), d.topLevels(d.Bids, ChecksumLevels)
+
+	fields := make([]string, 0, 2*(len(asks)+len(bids)))
+	for i := 0; i < ChecksumLevels; i++ {
+		if i < len(bids) {
+			fields = append(fields, checksumDecimal(bids[i][0]), checksumDecimal(bids[i][1]))
+		}
+
+		if i < len(asks) {
+			fields = append(fields, checksumDecimal(asks[i][0]), checksumDecimal(asks[i][1]))
+		}
+	}
+
+	return crc32.ChecksumIEEE([]byte(strings.Join(fields, ":")))
+}
+
+// checksumDecimal formats a price or an amount for the checksum, the same value is formatted the same whatever
+// its exponent: decimal.String trims the trailing zeros and never uses the scientific notation.
+func checksumDecimal(value decimal.Decimal) string {
+	return value.String()
+}
@@ -0,0 +1,96 @@
+package matching
+
+import (
+	"fmt"
+	"hash/crc32"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func TestChecksumFormat(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101.50", "2.000"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "102", "1"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99.1", "0.5"))
+
+	if want := crc32.ChecksumIEEE([]byte("99.1:0.5:101.5:2:102:1")); ob.Depth.Checksum() != want {
+		t.Errorf("expected the checksum of the documented string %d, got %d", want, ob.Depth.Checksum())
+	}
+}
+
+func TestChecksumStableAcrossInsertionOrders(t *testing.T) {
+	orders := make([][3]string, 0)
+	for i := 0; i < 30; i++ {
+		orders = append(orders, [3]string{string(pkg.SideSell), fmt.Sprintf("%d.5", 101+i), "1.25"})
+		orders = append(orders, [3]string{string(pkg.SideBuy), fmt.Sprintf("%d", 99-i), "0.5"})
+	}
+
+	build := func(order []int) *OrderBook {
+		ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+		for _, i := range order {
+			ob.Add(newTestOrder(pkg.OrderSide(orders[i][0]), pkg.TypeLimit, orders[i][1], orders[i][2]))
+		}
+
+		return ob
+	}
+
+	forward, backward := make([]int, len(orders)), make([]int, len(orders))
+	for i := range orders {
+		forward[i] = i
+		backward[i] = len(orders) - 1 - i
+	}
+
+	ob := build(forward)
+	if ob.Depth.Checksum() != build(backward).Depth.Checksum() {
+		t.Fatal("expected identical books built in different orders to have the same checksum")
+	}
+
+	// every change of a top level changes the checksum, a change past the top levels doesn't
+	checksum := ob.Depth.Checksum()
+	for _, level := range []struct {
+		side  pkg.OrderSide
+		price string
+	}{{pkg.SideSell, "101.5"}, {pkg.SideSell, "125.5"}, {pkg.SideBuy, "99"}, {pkg.SideBuy, "75"}} {
+		o := newTestOrder(level.side, pkg.TypeLimit, level.price, "0.1")
+		ob.Add(o)
+		if ob.Depth.Checksum() == checksum {
+			t.Errorf("expected a change of the %s level at %s to change the checksum", level.side, level.price)
+		}
+
+		ob.Cancel(o.Key(), "")
+		if ob.Depth.Checksum() != checksum {
+			t.Errorf("expected the checksum back once the %s level at %s is restored", level.side, level.price)
+		}
+	}
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "126.5", "1"))
+	if ob.Depth.Checksum() != checksum {
+		t.Error("expected a change past the top levels to keep the checksum")
+	}
+}
+
+func TestDepthDiffsCarryChecksum(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	recorder := &diffRecorder{}
+	ob.Depth.Subscribe(recorder)
+
+	for i := 0; i < depthChecksumEvery; i++ {
+		ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, fmt.Sprintf("%d", 101+i%30), "1"))
+	}
+
+	for i, diff := range recorder.diffs {
+		if (i+1)%depthChecksumEvery != 0 && diff.Checksum != nil {
+			t.Errorf("expected no checksum on the diff %d", diff.Sequence)
+		}
+	}
+
+	if last := recorder.diffs[len(recorder.diffs)-1]; last.Checksum == nil || *last.Checksum != ob.Depth.Checksum() {
+		t.Errorf("expected the diff %d to carry the checksum of the book", last.Sequence)
+	}
+
+	if snapshot := ob.Depth.Snapshot(); snapshot.Checksum != ob.Depth.Checksum() {
+		t.Error("expected the snapshot to carry the checksum of the book")
+	}
+}
@@ -0,0 +1,128 @@
+package matching
+
+import (
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+// DepthDiff is a change of a price level of a book, NewAmount is the quantity shown at the price once changed,
+// zero when the level is gone. The diffs of a book are numbered from one without a gap.
+type DepthDiff struct {
+	Sequence  int64           `json:"sequence"`
+	Side      pkg.OrderSide   `json:"side"`
+	Price     decimal.Decimal `json:"price"`
+	NewAmount decimal.Decimal `json:"new_amount"`
+	// Checksum is the checksum of the book once the diff is applied, on every depthChecksumEvery-th diff
+	Checksum *uint32 `json:"checksum,omitempty"`
+}
+
+// DepthSubscriber gets the diffs of a book in the order of their sequence, the transports pushing them to the
+// clients implement it. It's called while the book is changed, it mustn't block nor call back the book.
+type DepthSubscriber interface {
+	DepthDiff(symbol pkg.Symbol, diff DepthDiff)
+}
+
+// DepthSnapshot is every level of a book at a sequence, best first, the diffs after the sequence apply on top of it.
+type DepthSnapshot struct {
+	Sequence int64               `json:"sequence"`
+	Asks     [][]decimal.Decimal `json:"asks"`
+	Bids     [][]decimal.Decimal `json:"bids"`
+	Checksum uint32              `json:"checksum"`
+}
+
+// depthDiffs numbers the changes of the levels of a depth and hands them to its subscribers.
+type depthDiffs struct {
+	sequence    int64
+	subscribers []DepthSubscriber
+}
+
+// publish notifies the change of a level to the frames and to the subscribers, with the depthMutex held.
+func (d *Depth) publish(side pkg.OrderSide, price, amount decimal.Decimal) {
+	d.Notification.Publish(side, price, amount)
+	d.diff(side, price, amount, true)
+	d.quoteTicker()
+}
+
+// diff hands the next diff of the book to the subscribers, with the depthMutex held. It carries the checksum of
+// the book when it's due and the book is checksummed, the levels of the book are the ones of the diff.
+func (d *Depth) diff(side pkg.OrderSide, price, amount decimal.Decimal, checksummed bool) {
+	d.diffs.sequence++
+
+	diff := DepthDiff{Sequence: d.diffs.sequence, Side: side, Price: price, NewAmount: amount}
+	if checksummed && d.diffs.sequence%depthChecksumEvery == 0 && len(d.diffs.subscribers) > 0 {
+		checksum := d.checksum()
+		diff.Checksum = &checksum
+	}
+	for _, subscriber := range d.diffs.subscribers {
+		subscriber.DepthDiff(d.Symbol, diff)
+	}
+}
+
+// Subscribe hands the next diffs of the book to subscriber, a client syncs from a Snapshot taken after it.
+func (d *Depth) Subscribe(subscriber DepthSubscriber) {
+	d.depthMutex.Lock()
+	defer d.depthMutex.Unlock()
+
+	d.diffs.subscribers = append(d.diffs.subscribers, subscriber)
+}
+
+// Snapshot returns every level of the book with the sequence of the last diff applied to them.
+func (d *Depth) Snapshot() *DepthSnapshot {
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
+
+	return d.snapshot()
+}
+
+// SnapshotThen calls fn with the snapshot of the book before the next diff is handed to the subscribers: a subscriber
+// queuing the snapshot ahead of the diffs it gets next gives its client the diffs following the snapshot without a
+// gap. fn is called with the book locked, it mustn't block nor call back the book.
+func (d *Depth) SnapshotThen(fn func(snapshot *DepthSnapshot)) {
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
+
+	fn(d.snapshot())
+}
+
+// snapshot returns the snapshot of the book, with the depthMutex held.
+func (d *Depth) snapshot() *DepthSnapshot {
+	return &DepthSnapshot{
+		Sequence: d.diffs.sequence,
+		Asks:     d.shownLevels(d.Asks),
+		Bids:     d.shownLevels(d.Bids),
+		Checksum: d.checksum(),
+	}
+}
+
+// Continue takes over the diffs of the book previous the depth replaces, it must be called before the depth gets
+// an order. The levels of previous are removed with the next diffs of its sequence, the orders loaded into the
+// depth add theirs back, the subscribers don't resync. These diffs carry no checksum, the book the subscribers
+// rebuild is only the one of the depth once the orders are loaded.
+func (d *Depth) Continue(previous *Depth) {
+	previous.depthMutex.Lock()
+	defer previous.depthMutex.Unlock()
+
+	d.depthMutex.Lock()
+	defer d.depthMutex.Unlock()
+
+	d.diffs = previous.diffs
+	previous.diffs.subscribers = nil
+
+	remove := func(side pkg.OrderSide, price_levels *redblacktree.Tree) {
+		for _, level := range previous.shownLevels(price_levels) {
+			d.diff(side, level[0], decimal.Zero, false)
+		}
+	}
+
+	remove(pkg.SideSell, previous.Asks)
+	remove(pkg.SideBuy, previous.Bids)
+}
+
+// resume makes the next diffs of the depth follow sequence, the last diff of the book the depth was restored from.
+func (d *Depth) resume(sequence int64) {
+	d.depthMutex.Lock()
+	defer d.depthMutex.Unlock()
+
+	d.diffs.sequence = sequence
+}
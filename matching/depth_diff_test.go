@@ -0,0 +1,176 @@
+package matching
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+type diffRecorder struct {
+	diffs []DepthDiff
+}
+
+func (r *diffRecorder) DepthDiff(symbol pkg.Symbol, diff DepthDiff) {
+	r.diffs = append(r.diffs, diff)
+}
+
+// apply applies the diffs after the sequence of snapshot to it, as a client syncing from it does.
+func apply(snapshot *DepthSnapshot, diffs []DepthDiff) map[string]string {
+	levels := make(map[string]string)
+	for side, side_levels := range map[pkg.OrderSide][][]decimal.Decimal{pkg.SideSell: snapshot.Asks, pkg.SideBuy: snapshot.Bids} {
+		for _, level := range side_levels {
+			levels[string(side)+level[0].String()] = level[1].String()
+		}
+	}
+
+	for _, diff := range diffs {
+		if diff.Sequence <= snapshot.Sequence {
+			continue
+		}
+
+		key := string(diff.Side) + diff.Price.String()
+		if diff.NewAmount.IsZero() {
+			delete(levels, key)
+		} else {
+			levels[key] = diff.NewAmount.String()
+		}
+	}
+
+	return levels
+}
+
+func TestDepthDiffsGapFree(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	recorder := &diffRecorder{}
+	ob.Depth.Subscribe(recorder)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "2"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "102", "1"))
+	snapshot := ob.Depth.Snapshot()
+
+	// a fill, a stop order triggered by it resting, an immediate-or-cancel order cancelled and a cancel
+	ob.Add(newTestStopOrder(pkg.SideBuy, "101", "99", "1"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1"))
+	ob.add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "3"), immediateOrCancel)
+	resting := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "98", "1")
+	ob.Add(resting)
+	ob.Cancel(resting.Key(), "")
+
+	for i, diff := range recorder.diffs {
+		if diff.Sequence != int64(i+1) {
+			t.Fatalf("expected the diffs to be numbered without a gap, got %d at %d", diff.Sequence, i)
+		}
+	}
+
+	asks, bids := ob.Depth.Levels()
+	want := apply(&DepthSnapshot{Asks: asks, Bids: bids}, nil)
+	if got := apply(snapshot, recorder.diffs); len(got) != len(want) || got["ask102"] != want["ask102"] || got["bid99"] != want["bid99"] {
+		t.Errorf("expected the diffs applied to the snapshot to give the book %v, got %v", want, got)
+	}
+
+	if _, found := want["bid99"]; !found {
+		t.Error("expected the triggered stop order to rest at 99")
+	}
+}
+
+func TestDepthDiffsContinueReplacedBook(t *testing.T) {
+	previous, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	recorder := &diffRecorder{}
+	previous.Depth.Subscribe(recorder)
+
+	previous.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+	previous.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1"))
+	snapshot := previous.Depth.Snapshot()
+
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	ob.Depth.Continue(previous.Depth)
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+
+	if len(recorder.diffs) != 5 || recorder.diffs[4].Sequence != 5 {
+		t.Fatalf("expected the replacing book to go on with the sequence, got %+v", recorder.diffs)
+	}
+
+	if got := apply(snapshot, recorder.diffs); len(got) != 1 || got["ask101"] != "1" {
+		t.Errorf("expected the levels of the replaced book to be removed, got %v", got)
+	}
+}
+
+// queueRecorder queues the diffs of a book and the snapshots taken with SnapshotThen in a single queue, as a
+// connection does.
+type queueRecorder struct {
+	mutex sync.Mutex
+	queue []interface{}
+}
+
+func (r *queueRecorder) DepthDiff(symbol pkg.Symbol, diff DepthDiff) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.queue = append(r.queue, diff)
+}
+
+func TestDepthSnapshotThenQueuesAheadOfTheNextDiffs(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	recorder := &queueRecorder{}
+	ob.Depth.Subscribe(recorder)
+
+	orders := make([]*pkg.Order, 0, 200)
+	for i := 0; i < 100; i++ {
+		orders = append(orders, newTestOrder(pkg.SideSell, pkg.TypeLimit, fmt.Sprint(101+i%10), "1"))
+		orders = append(orders, newTestOrder(pkg.SideBuy, pkg.TypeLimit, fmt.Sprint(90+i%10), "1"))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for _, order := range orders {
+			ob.Add(order)
+		}
+	}()
+
+	var snapshot *DepthSnapshot
+	for snapshot == nil || snapshot.Sequence == 0 {
+		ob.Depth.SnapshotThen(func(s *DepthSnapshot) {
+			snapshot = s
+
+			recorder.mutex.Lock()
+			recorder.queue = append(recorder.queue, s)
+			recorder.mutex.Unlock()
+		})
+	}
+	<-done
+
+	var diffs []DepthDiff
+	queued := false
+	for _, item := range recorder.queue {
+		switch item := item.(type) {
+		case *DepthSnapshot:
+			queued = item == snapshot
+		case DepthDiff:
+			if queued {
+				diffs = append(diffs, item)
+			}
+		}
+	}
+
+	if len(diffs) > 0 && diffs[0].Sequence != snapshot.Sequence+1 {
+		t.Fatalf("expected the diffs queued after the snapshot to follow its sequence %d, got %d", snapshot.Sequence, diffs[0].Sequence)
+	}
+
+	asks, bids := ob.Depth.Levels()
+	want := apply(&DepthSnapshot{Asks: asks, Bids: bids}, nil)
+	got := apply(snapshot, diffs)
+	for level, amount := range want {
+		if got[level] != amount {
+			t.Errorf("expected %s at %s, got %s", amount, level, got[level])
+		}
+	}
+
+	if len(got) != len(want) {
+		t.Errorf("expected the diffs queued after the snapshot to give the book %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,79 @@
+package matching
+
+import (
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+// GroupedDepth is a depth snapshot of a book with its levels grouped by a price step.
+type GroupedDepth struct {
+	Asks     [][]decimal.Decimal `json:"asks"`
+	Bids     [][]decimal.Decimal `json:"bids"`
+	Sequence int64               `json:"sequence"`
+}
+
+// Depth returns the best limit levels of each side of the book grouped by step, see Depth.Grouped.
+func (e *Engine) Depth(limit int, step decimal.Decimal) *GroupedDepth {
+	e.MatchingMutex.RLock()
+	defer e.MatchingMutex.RUnlock()
+
+	asks, bids := e.OrderBook.Depth.Grouped(limit, step)
+
+	e.OrderBook.Depth.Notification.NotifyMutex.RLock()
+	sequence := e.OrderBook.Depth.Notification.Sequence
+	e.OrderBook.Depth.Notification.NotifyMutex.RUnlock()
+
+	return &GroupedDepth{Asks: asks, Bids: bids, Sequence: sequence}
+}
+
+// Grouped returns the best limit groups of each side of the book as [price, amount], the best first. The bids are
+// grouped at their price floored to a multiple of step and the asks at their price ceiled to one, so a group never
+// shows a better price than its levels. A zero step doesn't group the levels, as Top.
+func (d *Depth) Grouped(limit int, step decimal.Decimal) (asks, bids [][]decimal.Decimal) {
+	if !step.IsPositive() {
+		return d.Top(limit)
+	}
+
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
+
+	return d.groupedLevels(d.Asks, pkg.SideSell, limit, step), d.groupedLevels(d.Bids, pkg.SideBuy, limit, step)
+}
+
+// groupedLevels walks the price levels best first, with the depthMutex held, and adds up the ones of the same group.
+// It stops at the first level past the limit groups.
+func (d *Depth) groupedLevels(price_levels *redblacktree.Tree, side pkg.OrderSide, limit int, step decimal.Decimal) [][]decimal.Decimal {
+	groups := make([][]decimal.Decimal, 0, limit)
+
+	it := price_levels.Iterator()
+	it.End()
+	for it.Prev() {
+		price_level := it.Value().(*PriceLevel)
+		price := groupPrice(side, price_level.Price, step)
+
+		// the levels are sorted, a level of the last group follows it
+		if last := len(groups) - 1; last >= 0 && groups[last][0].Equal(price) {
+			groups[last][1] = groups[last][1].Add(d.shown(price_level))
+			continue
+		}
+
+		if len(groups) >= limit {
+			break
+		}
+
+		groups = append(groups, []decimal.Decimal{price, d.shown(price_level)})
+	}
+
+	return groups
+}
+
+// groupPrice returns the price of the group of a level of side at price, a price on a multiple of step is its own.
+func groupPrice(side pkg.OrderSide, price, step decimal.Decimal) decimal.Decimal {
+	steps := price.Div(step)
+	if side == pkg.SideBuy {
+		return steps.Floor().Mul(step)
+	}
+
+	return steps.Ceil().Mul(step)
+}
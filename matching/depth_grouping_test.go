@@ -0,0 +1,77 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func levelsString(levels [][]decimal.Decimal) string {
+	s := ""
+	for _, level := range levels {
+		s += "[" + level[0].String() + " " + level[1].String() + "]"
+	}
+
+	return s
+}
+
+func TestDepthGroupedByStep(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	// 99.5 and 99 are in the bid group of 99, 98 sits on the edge of its own group and 97.9 is in the one below it
+	for _, bid := range [][2]string{{"99.5", "1"}, {"99", "2"}, {"98", "3"}, {"97.9", "4"}} {
+		ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, bid[0], bid[1]))
+	}
+
+	// 100.5 and 101 are in the ask group of 101, 102 sits on the edge of its own group and 102.1 is in the one above it
+	for _, ask := range [][2]string{{"100.5", "1"}, {"101", "2"}, {"102", "3"}, {"102.1", "4"}} {
+		ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, ask[0], ask[1]))
+	}
+
+	asks, bids := ob.Depth.Grouped(10, decimal.NewFromInt(1))
+
+	if got := levelsString(bids); got != "[99 3][98 3][97 4]" {
+		t.Errorf("expected the bids floored to the step, got %s", got)
+	}
+
+	if got := levelsString(asks); got != "[101 3][102 3][103 4]" {
+		t.Errorf("expected the asks ceiled to the step, got %s", got)
+	}
+
+	// the groups add up to the quantity of the book, no level is counted twice
+	total := decimal.Zero
+	for _, level := range append(asks, bids...) {
+		total = total.Add(level[1])
+	}
+
+	if !total.Equal(decimal.NewFromInt(20)) {
+		t.Errorf("expected the groups to hold the 20 resting, got %s", total)
+	}
+
+	// a wider step gathers the edges of the narrower groups, the limit counts groups rather than levels
+	asks, bids = ob.Depth.Grouped(1, decimal.NewFromInt(2))
+
+	if got := levelsString(bids); got != "[98 6]" {
+		t.Errorf("expected the best bid group of 2 from 98, got %s", got)
+	}
+
+	if got := levelsString(asks); got != "[102 6]" {
+		t.Errorf("expected the best ask group of 2 up to 102, got %s", got)
+	}
+}
+
+func TestDepthGroupedWithoutStep(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99.5", "1"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "2"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "100.5", "1"))
+
+	asks, bids := ob.Depth.Grouped(10, decimal.Zero)
+	top_asks, top_bids := ob.Depth.Top(10)
+
+	if levelsString(asks) != levelsString(top_asks) || levelsString(bids) != levelsString(top_bids) {
+		t.Errorf("expected the levels of the book without a step, got %s and %s", levelsString(asks), levelsString(bids))
+	}
+}
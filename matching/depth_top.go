@@ -0,0 +1,82 @@
+package matching
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+// DepthTops are the levels of each side of the top-N depth snapshots by format. A snapshot is pushed on
+// <market>.depth.<format> and served by the depth endpoint with ?format=<format>.
+var DepthTops = map[string]int{
+	"top5":  5,
+	"top20": 20,
+}
+
+// defaultDepthTopInterval is the least time between two top-N snapshots of a market when
+// market_data.depth_top_interval isn't set.
+const defaultDepthTopInterval = 200 * time.Millisecond
+
+// depthTops throttles the top-N snapshots of a notification, they're only taken from the loop of the notification.
+type depthTops struct {
+	interval  time.Duration
+	pushed_at time.Time
+	// changed is set by a depth frame, the top levels can't have changed without one
+	changed bool
+	last    map[string]*pkg.DepthJSON
+}
+
+// Top returns the best limit levels of each side of the book as [price, amount], the best first.
+// It only walks those levels, not the whole book.
+func (d *Depth) Top(limit int) (asks, bids [][]decimal.Decimal) {
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
+
+	return d.topLevels(d.Asks, limit), d.topLevels(d.Bids, limit)
+}
+
+// dueTops returns the top-N snapshots to push at now by format: none before the interval since the last push,
+// and only those which changed since they were pushed. A quiet book has none. A book accumulating a batch
+// has none either, its levels may cross until they're uncrossed.
+func (n *Notification) dueTops(now time.Time) map[string]*pkg.DepthJSON {
+	n.NotifyMutex.RLock()
+	held, sequence := n.held, n.Sequence
+	n.NotifyMutex.RUnlock()
+
+	if n.top == nil || held || !n.tops.changed || now.Sub(n.tops.pushed_at) < n.tops.interval {
+		return nil
+	}
+
+	n.tops.changed = false
+	n.tops.pushed_at = now
+
+	due := make(map[string]*pkg.DepthJSON)
+	for format, limit := range DepthTops {
+		asks, bids := n.top(limit)
+
+		if last, ok := n.tops.last[format]; ok && sameLevels(last.Asks, asks) && sameLevels(last.Bids, bids) {
+			continue
+		}
+
+		snapshot := &pkg.DepthJSON{Asks: asks, Bids: bids, Sequence: sequence}
+		n.tops.last[format] = snapshot
+		due[format] = snapshot
+	}
+
+	return due
+}
+
+func sameLevels(a, b [][]decimal.Decimal) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !a[i][0].Equal(b[i][0]) || !a[i][1].Equal(b[i][1]) {
+			return false
+		}
+	}
+
+	return true
+}
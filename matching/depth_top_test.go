@@ -0,0 +1,69 @@
+package matching
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func TestDepthTopsOnlyWhenChanged(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	notification := ob.Depth.Notification
+	now := time.Now()
+
+	for i := 0; i < 6; i++ {
+		ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, fmt.Sprint(99-i), "1"))
+		ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, fmt.Sprint(101+i), "1"))
+	}
+
+	depth, tops := notification.frame(now)
+	if depth == nil || len(tops) != 2 {
+		t.Fatalf("expected a frame and both snapshots, got %+v and %d snapshots", depth, len(tops))
+	}
+
+	if top := tops["top5"]; len(top.Bids) != 5 || len(top.Asks) != 5 || !top.Bids[0][0].Equal(decimal.NewFromInt(99)) || top.Sequence != depth.Sequence {
+		t.Errorf("expected the best 5 levels of each side from 99 and 101, got %+v", top)
+	}
+
+	// a quiet book pushes nothing
+	for i := 1; i <= 10; i++ {
+		if depth, tops := notification.frame(now.Add(time.Duration(i) * time.Second)); depth != nil || tops != nil {
+			t.Fatalf("expected nothing from a quiet book, got %+v and %+v", depth, tops)
+		}
+	}
+
+	// the 6th bid is only in the top 20
+	now = now.Add(time.Minute)
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "94", "1"))
+
+	if _, tops := notification.frame(now); len(tops) != 1 || tops["top20"] == nil {
+		t.Fatalf("expected only the top 20 to change, got %+v", tops)
+	}
+
+	// snapshots are throttled to the interval, the change is pushed once it's over
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1"))
+
+	if depth, tops := notification.frame(now.Add(defaultDepthTopInterval / 2)); depth == nil || tops != nil {
+		t.Fatalf("expected the frame without the snapshots within the interval, got %+v", tops)
+	}
+
+	if depth, tops := notification.frame(now.Add(defaultDepthTopInterval)); depth != nil || len(tops) != 2 {
+		t.Errorf("expected both snapshots after the interval, got %+v", tops)
+	}
+}
+
+func TestFetchOrderBookTop(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "98", "1"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "2"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+
+	response := ob.Depth.FetchOrderBook(1)
+	if len(response.Bids) != 1 || len(response.Asks) != 1 || !response.Bids[0].PriceQuantity[0].ToDecimal().Equal(decimal.NewFromInt(99)) {
+		t.Errorf("expected the best level of each side, got %+v", response)
+	}
+}
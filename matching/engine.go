@@ -2,8 +2,10 @@ package matching
 
 import (
 	"sync"
+	"time"
 
 	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/events"
 	"github.com/zsmartex/pkg"
 )
 
@@ -11,16 +13,42 @@ type Engine struct {
 	MatchingMutex sync.RWMutex
 	Symbol        pkg.Symbol
 	OrderBook     *OrderBook
+	Metrics       *CycleMetrics
+	SizeLimits    OrderSizeLimits
 	Initialized   bool
 }
 
-func NewEngine(symbol pkg.Symbol, price decimal.Decimal) *Engine {
+func NewEngine(symbol pkg.Symbol, price decimal.Decimal, book_config OrderBookConfig) *Engine {
+	engine := newEngine(symbol, NewOrderBook(
+		symbol,
+		price,
+		book_config,
+	), book_config.SlowCycleThreshold)
+	engine.SizeLimits = book_config.SizeLimits
+
+	return engine
+}
+
+// NewDetachedEngine returns an engine whose book isn't restored from redis nor publishes its depth frames,
+// for the tools running a book off the broker. Its output goes to book_config.Publisher, which must be set.
+func NewDetachedEngine(symbol pkg.Symbol, price decimal.Decimal, book_config OrderBookConfig) *Engine {
+	engine := newEngine(symbol, newOrderBook(
+		symbol,
+		price,
+		book_config,
+		newNotification(symbol),
+		book_config.Publisher,
+	), book_config.SlowCycleThreshold)
+	engine.SizeLimits = book_config.SizeLimits
+
+	return engine
+}
+
+func newEngine(symbol pkg.Symbol, order_book *OrderBook, slow_cycle_threshold time.Duration) *Engine {
 	engine := &Engine{
-		Symbol: symbol,
-		OrderBook: NewOrderBook(
-			symbol,
-			price,
-		),
+		Symbol:      symbol,
+		OrderBook:   order_book,
+		Metrics:     NewCycleMetrics(symbol, slow_cycle_threshold),
 		Initialized: false,
 	}
 
@@ -28,17 +56,94 @@ func NewEngine(symbol pkg.Symbol, price decimal.Decimal) *Engine {
 }
 
 func (e *Engine) Submit(o *pkg.Order) {
+	e.SubmitWithOptions(o, nil)
+}
+
+// SubmitWithOptions submits an order with the options it was placed with, nil when it has none.
+func (e *Engine) SubmitWithOptions(o *pkg.Order, options *events.OrderOptions) {
+	started_at := time.Now()
+
 	e.MatchingMutex.Lock()
 	defer e.MatchingMutex.Unlock()
 
-	e.OrderBook.Add(o)
+	cascade_depth := e.OrderBook.add(o, options)
+
+	e.Metrics.Observe(Cycle{
+		Action:       pkg.ActionSubmit,
+		Order:        o,
+		Latency:      time.Since(started_at),
+		CascadeDepth: cascade_depth,
+		Submitted:    1,
+	})
 }
 
 func (e *Engine) CancelWithKey(key *pkg.OrderKey) {
+	e.CancelCommand(key, "")
+}
+
+// CancelCommand cancels the order of key for the cancel command command_id, the outcome is published with the id.
+func (e *Engine) CancelCommand(key *pkg.OrderKey, command_id string) CancelOutcome {
+	started_at := time.Now()
+
+	e.MatchingMutex.Lock()
+	defer e.MatchingMutex.Unlock()
+
+	outcome := e.OrderBook.Cancel(key, command_id)
+
+	e.Metrics.Observe(Cycle{
+		Action:  pkg.ActionCancel,
+		Key:     key,
+		Latency: time.Since(started_at),
+	})
+
+	return outcome
+}
+
+// CancelReplace cancels the order of replaced_key and submits its replacement in the same cycle.
+func (e *Engine) CancelReplace(replaced_key *pkg.OrderKey, o *pkg.Order, options *events.OrderOptions) bool {
+	started_at := time.Now()
+
+	e.MatchingMutex.Lock()
+	defer e.MatchingMutex.Unlock()
+
+	accepted, cascade_depth := e.OrderBook.CancelReplace(replaced_key, o, options)
+
+	e.Metrics.Observe(Cycle{
+		Action:       events.ActionCancelReplace,
+		Order:        o,
+		Key:          replaced_key,
+		Latency:      time.Since(started_at),
+		CascadeDepth: cascade_depth,
+	})
+
+	return accepted
+}
+
+// Amend amends the order of key in a cycle, see OrderBook.Amend.
+func (e *Engine) Amend(key *pkg.OrderKey, amendment *events.Amendment) bool {
+	started_at := time.Now()
+
+	e.MatchingMutex.Lock()
+	defer e.MatchingMutex.Unlock()
+
+	accepted, cascade_depth := e.OrderBook.Amend(key, amendment)
+
+	e.Metrics.Observe(Cycle{
+		Action:       events.ActionAmend,
+		Key:          key,
+		Latency:      time.Since(started_at),
+		CascadeDepth: cascade_depth,
+	})
+
+	return accepted
+}
+
+// Reprice re-keys the orders of the book to a price precision, see OrderBook.Reprice.
+func (e *Engine) Reprice(precision int32) Repricing {
 	e.MatchingMutex.Lock()
 	defer e.MatchingMutex.Unlock()
 
-	e.OrderBook.Remove(key)
+	return e.OrderBook.Reprice(precision)
 }
 
 func (e *Engine) Cancel(o *pkg.Order) {
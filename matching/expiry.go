@@ -0,0 +1,116 @@
+package matching
+
+import (
+	"sort"
+	"time"
+
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+)
+
+// keepOptions keeps the options of an order of the book, an order with an expiry is kept with the orders the
+// sweep looks at. It's called with the orderMutex held.
+func (ob *OrderBook) keepOptions(o *pkg.Order, options *events.OrderOptions) {
+	if options == nil {
+		return
+	}
+
+	ob.options[o.ID] = options
+	if options.ExpiresAt != nil {
+		ob.expiring[o.ID] = o
+	}
+}
+
+// expired reports whether the good-till-date order o is past its expiry on the clock of the book.
+func (ob *OrderBook) expired(o *pkg.Order) bool {
+	expires_at := ob.optionsOf(o).ExpiresAt
+
+	return expires_at != nil && !ob.clock.Now().Before(*expires_at)
+}
+
+// expiredOnArrival reports whether an order submitted with options is past its expiry already.
+func (ob *OrderBook) expiredOnArrival(options *events.OrderOptions) bool {
+	return options != nil && options.ExpiresAt != nil && !ob.clock.Now().Before(*options.ExpiresAt)
+}
+
+// SweepExpired cancels the orders of the book and the stop orders past their expiry, it returns how many.
+// The orders reaching the top of their level are cancelled by the matching before, an expired order never
+// trades, the sweep takes out the ones deeper in the book.
+func (ob *OrderBook) SweepExpired() int {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	return ob.sweepExpired()
+}
+
+// sweepExpired cancels the orders past their expiry with the orderMutex held, by id so the cancels come in the
+// same order on every run.
+func (ob *OrderBook) sweepExpired() int {
+	expired := make([]*pkg.Order, 0)
+	for _, o := range ob.expiring {
+		if ob.expired(o) {
+			expired = append(expired, o)
+		}
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].ID < expired[j].ID
+	})
+
+	for _, o := range expired {
+		key := o.Key()
+		if ob.removeOrder(key) && !key.Fake {
+			ob.PublishCancel(key, CancelReasonExpired)
+		}
+	}
+
+	if len(expired) > 0 {
+		config.Logger.Debugf("[oceanbook.orderbook] %s cancelled %d expired orders", ob.Symbol.String(), len(expired))
+	}
+
+	return len(expired)
+}
+
+// SetExpirySweepInterval sweeps the expired orders every interval, zero stops the sweep.
+func (ob *OrderBook) SetExpirySweepInterval(interval time.Duration) {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	ob.setExpirySweepInterval(interval)
+}
+
+// StopExpirySweep stops the sweep of a book being replaced, the book taking over sweeps the orders.
+func (ob *OrderBook) StopExpirySweep() {
+	ob.SetExpirySweepInterval(0)
+}
+
+func (ob *OrderBook) setExpirySweepInterval(interval time.Duration) {
+	if ob.expiryTimer != nil {
+		ob.expiryTimer.Stop()
+		ob.expiryTimer = nil
+	}
+
+	ob.expiryInterval = interval
+	if interval > 0 {
+		ob.scheduleExpirySweep(interval)
+	}
+}
+
+func (ob *OrderBook) scheduleExpirySweep(interval time.Duration) {
+	ob.expiryTimer = ob.clock.AfterFunc(interval, func() {
+		ob.orderMutex.Lock()
+		defer ob.orderMutex.Unlock()
+
+		// stopped or changed meanwhile
+		if ob.expiryInterval != interval {
+			return
+		}
+
+		if !ob.halted() {
+			ob.sweepExpired()
+		}
+		ob.scheduleExpirySweep(interval)
+	})
+}
@@ -0,0 +1,81 @@
+package matching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/finex/events"
+)
+
+func expiresIn(fake *clock.Fake, d time.Duration) *events.OrderOptions {
+	expires_at := fake.Now().Add(d)
+
+	return &events.OrderOptions{ExpiresAt: &expires_at}
+}
+
+func TestEngineSweepsExpiredOrders(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{ExpirySweepInterval: 100 * time.Millisecond}, fake)
+	engine := newEngine(testSymbol, ob, 0)
+
+	// deep in the book, nothing would match it before its expiry
+	expiring := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "90", "1")
+	engine.SubmitWithOptions(expiring, expiresIn(fake, time.Second))
+	engine.Submit(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1"))
+
+	fake.Advance(900 * time.Millisecond)
+	if !bookHas(ob, expiring) || len(publisher.Cancels) != 0 {
+		t.Fatal("expected the order to stay in the book until its expiry")
+	}
+
+	fake.Advance(200 * time.Millisecond)
+	if bookHas(ob, expiring) {
+		t.Error("expected the expired order to be swept out of the book")
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0].ID != expiring.ID || publisher.Cancels[0].Reason != CancelReasonExpired {
+		t.Errorf("expected the expiry of the order to be published, got %+v", publisher.Cancels)
+	}
+
+	if ob.Options(expiring.ID) != nil || len(ob.expiring) != 0 {
+		t.Error("expected the expired order to be forgotten")
+	}
+}
+
+func TestExpiredMakerNeverTrades(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, fake)
+
+	expiring := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	ob.add(expiring, expiresIn(fake, time.Second))
+	other := newTestOrder(pkg.SideSell, pkg.TypeLimit, "102", "1")
+	ob.Add(other)
+
+	// the book doesn't sweep, the order is found expired when it's matched
+	fake.Advance(2 * time.Second)
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "102", "1"))
+
+	if len(publisher.Trades) != 1 || publisher.Trades[0].MakerOrder.ID != other.ID {
+		t.Fatalf("expected the taker to skip the expired order, got %d trades", len(publisher.Trades))
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0].ID != expiring.ID || publisher.Cancels[0].Reason != CancelReasonExpired {
+		t.Errorf("expected the expired maker to be cancelled, got %+v", publisher.Cancels)
+	}
+}
+
+func TestOrderExpiredOnArrivalCancelled(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, fake)
+
+	o := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1")
+	ob.add(o, expiresIn(fake, -time.Second))
+
+	if bookHas(ob, o) || len(publisher.Cancels) != 1 || publisher.Cancels[0].Reason != CancelReasonExpired {
+		t.Errorf("expected the expired order to be cancelled rather than booked, got %+v", publisher.Cancels)
+	}
+}
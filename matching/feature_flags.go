@@ -0,0 +1,28 @@
+package matching
+
+import "github.com/zsmartex/finex/types"
+
+// FeatureFlags switches a book between the current and the new implementation of risky matching changes,
+// they're set once when the book is built so a market never changes behavior while running.
+type FeatureFlags struct {
+	// UsePriceLevelBook indexes the orders of a price level by UUID and inserts them in place
+	// instead of sorting the whole level on every insert.
+	UsePriceLevelBook bool
+	// FifoTiebreakV2 matches the oldest order of a price level first,
+	// orders created at the same time are matched by ID.
+	FifoTiebreakV2 bool
+}
+
+func NewFeatureFlags(flags map[types.FeatureFlag]bool) FeatureFlags {
+	return FeatureFlags{
+		UsePriceLevelBook: flags[types.FeatureUsePriceLevelBook],
+		FifoTiebreakV2:    flags[types.FeatureFifoTiebreakV2],
+	}
+}
+
+func (f FeatureFlags) Map() map[types.FeatureFlag]bool {
+	return map[types.FeatureFlag]bool{
+		types.FeatureUsePriceLevelBook: f.UsePriceLevelBook,
+		types.FeatureFifoTiebreakV2:    f.FifoTiebreakV2,
+	}
+}
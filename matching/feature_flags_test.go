@@ -0,0 +1,88 @@
+package matching
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func TestFeatureFlagsTiebreak(t *testing.T) {
+	for _, use_price_level_book := range []bool{false, true} {
+		for _, fifo_tiebreak_v2 := range []bool{false, true} {
+			flags := FeatureFlags{UsePriceLevelBook: use_price_level_book, FifoTiebreakV2: fifo_tiebreak_v2}
+
+			t.Run(fmt.Sprintf("%+v", flags), func(t *testing.T) {
+				ob, publisher := newTestOrderBook(decimal.Zero, OrderBookConfig{Flags: flags}, nil)
+
+				older := newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "1")
+				newer := newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "1")
+				// created at the same time as newer, but with a greater ID
+				same_time := newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "1")
+				same_time.CreatedAt = newer.CreatedAt
+
+				ob.Add(older)
+				ob.Add(same_time)
+				ob.Add(newer)
+
+				ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "10", "3"))
+
+				if len(publisher.Trades) != 3 {
+					t.Fatalf("expected 3 trades, got %d", len(publisher.Trades))
+				}
+
+				makers := []int64{publisher.Trades[0].MakerOrder.ID, publisher.Trades[1].MakerOrder.ID, publisher.Trades[2].MakerOrder.ID}
+
+				if fifo_tiebreak_v2 {
+					want := []int64{older.ID, newer.ID, same_time.ID}
+					if fmt.Sprint(makers) != fmt.Sprint(want) {
+						t.Errorf("expected makers %v, got %v", want, makers)
+					}
+				} else if makers[2] != older.ID {
+					// the legacy comparator matches the newest order of a level first
+					t.Errorf("expected the oldest order to be matched last, got makers %v", makers)
+				}
+
+				if ob.Depth.Asks.Size() != 0 || ob.Depth.Bids.Size() != 0 {
+					t.Errorf("expected an empty book, got %d asks and %d bids", ob.Depth.Asks.Size(), ob.Depth.Bids.Size())
+				}
+			})
+		}
+	}
+}
+
+func TestPriceLevelBookPartialFill(t *testing.T) {
+	for _, use_price_level_book := range []bool{false, true} {
+		t.Run(fmt.Sprintf("use_price_level_book=%v", use_price_level_book), func(t *testing.T) {
+			flags := FeatureFlags{UsePriceLevelBook: use_price_level_book, FifoTiebreakV2: true}
+			ob, publisher := newTestOrderBook(decimal.Zero, OrderBookConfig{Flags: flags}, nil)
+
+			first := newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "2")
+			second := newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "2")
+			ob.Add(first)
+			ob.Add(second)
+
+			ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "10", "1"))
+			ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "10", "2"))
+
+			if len(publisher.Trades) != 3 {
+				t.Fatalf("expected 3 trades, got %d", len(publisher.Trades))
+			}
+
+			value, found := ob.Depth.Asks.Get(&PriceLevelKey{Side: pkg.SideSell, Price: decimal.NewFromInt(10)})
+			if !found {
+				t.Fatal("expected the ask level to remain")
+			}
+
+			price_level := value.(*PriceLevel)
+			if price_level.Size() != 1 || price_level.Top().ID != second.ID || !price_level.Total().Equal(decimal.NewFromInt(1)) {
+				t.Errorf("expected order %d with 1 left, got %d orders with %s left", second.ID, price_level.Size(), price_level.Total())
+			}
+
+			if price_level.Get(first.Key()) != nil {
+				t.Errorf("expected order %d to be removed from the level", first.ID)
+			}
+		})
+	}
+}
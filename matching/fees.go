@@ -0,0 +1,58 @@
+package matching
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/decimalutil"
+)
+
+// FeeSchedule is the maker and the taker rates the book computes the fees of its trades with, as ratios of what
+// the maker and the taker receive. The zero schedule charges no fee.
+type FeeSchedule struct {
+	Maker decimal.Decimal
+	Taker decimal.Decimal
+	// Scale is the scale the fees are rounded down to, the scale of the balances
+	Scale int32
+}
+
+// TradeFees are the fees the maker and the taker of a trade pay on what they receive, a buyer pays in the base
+// currency on the quantity and a seller in the quote currency on the total.
+type TradeFees struct {
+	MakerFee         decimal.Decimal
+	MakerFeeCurrency string
+	TakerFee         decimal.Decimal
+	TakerFeeCurrency string
+}
+
+// Fees computes the fees of trade, its maker and taker orders set. The fake orders pay none.
+func (s FeeSchedule) Fees(trade *pkg.Trade) TradeFees {
+	maker_fee, maker_currency := s.fee(trade, &trade.MakerOrder, s.Maker)
+	taker_fee, taker_currency := s.fee(trade, &trade.TakerOrder, s.Taker)
+
+	return TradeFees{
+		MakerFee:         maker_fee,
+		MakerFeeCurrency: maker_currency,
+		TakerFee:         taker_fee,
+		TakerFeeCurrency: taker_currency,
+	}
+}
+
+func (s FeeSchedule) fee(trade *pkg.Trade, o *pkg.Order, rate decimal.Decimal) (decimal.Decimal, string) {
+	income, currency := trade.Total, trade.Symbol.QuoteCurrency
+	if o.Side == pkg.SideBuy {
+		income, currency = trade.Quantity, trade.Symbol.BaseCurrency
+	}
+
+	if o.Fake || !rate.IsPositive() {
+		return decimal.Zero, currency
+	}
+
+	// rounded down the fee is never above what the order receives, a rate above one is misconfigured
+	fee := decimalutil.RoundFeeDown(income.Mul(rate), s.Scale)
+	if fee.GreaterThan(income) {
+		fee = income
+	}
+
+	return fee, currency
+}
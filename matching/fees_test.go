@@ -0,0 +1,58 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func TestTradeFeesAtMatchTime(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{
+		Fees: FeeSchedule{Maker: decimal.RequireFromString("0.001"), Taker: decimal.RequireFromString("0.002"), Scale: 4},
+	}, nil)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101.5", "0.3333"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101.5", "0.3333"))
+
+	if len(publisher.Fees) != 1 {
+		t.Fatalf("expected the trade to be published with its fees, got %d", len(publisher.Fees))
+	}
+
+	// the seller maker pays on the total 33.82995 and the buyer taker on the quantity, rounded down
+	fees := publisher.Fees[0]
+	if !fees.MakerFee.Equal(decimal.RequireFromString("0.0338")) || fees.MakerFeeCurrency != testSymbol.QuoteCurrency {
+		t.Errorf("unexpected maker fee %s %s", fees.MakerFee, fees.MakerFeeCurrency)
+	}
+
+	if !fees.TakerFee.Equal(decimal.RequireFromString("0.0006")) || fees.TakerFeeCurrency != testSymbol.BaseCurrency {
+		t.Errorf("unexpected taker fee %s %s", fees.TakerFee, fees.TakerFeeCurrency)
+	}
+}
+
+func TestTradeFeesNeverAboveGross(t *testing.T) {
+	schedule := FeeSchedule{Maker: decimal.RequireFromString("1.5"), Taker: decimal.RequireFromString("0.0001"), Scale: 2}
+	trade := &pkg.Trade{
+		Symbol:     testSymbol,
+		Price:      decimal.NewFromInt(3),
+		Quantity:   decimal.RequireFromString("0.01"),
+		Total:      decimal.RequireFromString("0.03"),
+		MakerOrder: pkg.Order{Side: pkg.SideBuy},
+		TakerOrder: pkg.Order{Side: pkg.SideSell},
+	}
+
+	fees := schedule.Fees(trade)
+	if !fees.MakerFee.Equal(trade.Quantity) {
+		t.Errorf("expected the maker fee to be capped at the quantity, got %s", fees.MakerFee)
+	}
+
+	if !fees.TakerFee.IsZero() {
+		t.Errorf("expected the taker fee below the scale to be rounded down to zero, got %s", fees.TakerFee)
+	}
+
+	trade.TakerOrder.Fake = true
+	trade.TakerOrder.Side = pkg.SideSell
+	if fees := (FeeSchedule{Taker: decimal.RequireFromString("0.5"), Scale: 8}).Fees(trade); !fees.TakerFee.IsZero() {
+		t.Errorf("expected a fake order to pay no fee, got %s", fees.TakerFee)
+	}
+}
@@ -0,0 +1,192 @@
+package matching
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/pkg"
+)
+
+var testSymbol = pkg.Symbol{BaseCurrency: "ABC", QuoteCurrency: "XYZ"}
+
+func init() {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	config.Logger = logrus.NewEntry(logger)
+}
+
+type cancelRecord struct {
+	ID     int64
+	Reason CancelReason
+}
+
+type decrementRecord struct {
+	ID       int64
+	Quantity decimal.Decimal
+}
+
+type repriceRecord struct {
+	ID        int64
+	Price     decimal.Decimal
+	StopPrice decimal.Decimal
+}
+
+type amendRecord struct {
+	ID       int64
+	Price    decimal.Decimal
+	Quantity decimal.Decimal
+	Accepted bool
+}
+
+type replaceRecord struct {
+	ReplacedID int64
+	ID         int64
+	Accepted   bool
+}
+
+// recordingPublisher keeps the orderbook output in memory.
+type recordingPublisher struct {
+	sync.Mutex
+	Trades []*pkg.Trade
+	// MatchedAt is the time each trade was matched at
+	MatchedAt []time.Time
+	// ConfigVersions is the market configuration version each trade was matched with
+	ConfigVersions []int64
+	// Fees are the fees of each trade
+	Fees []TradeFees
+	// Sequences are the sequences each trade was numbered with
+	Sequences  []int64
+	Cancels    []cancelRecord
+	Replaces   []replaceRecord
+	Decrements []decrementRecord
+	Reprices   []repriceRecord
+	Amends     []amendRecord
+	// Batches are the outcomes of the entries of each batch
+	Batches [][]*events.BatchEntry
+	// Outcomes are the outcomes of the cancel commands, the cancelled ones are in Cancels too
+	Outcomes []CancelOutcome
+	// CircuitBreaks are the circuit breakers tripped and lifted
+	CircuitBreaks []CircuitBreak
+}
+
+func (p *recordingPublisher) PublishTrade(trade *pkg.Trade, stamp TradeStamp) {
+	p.Lock()
+	defer p.Unlock()
+
+	recorded := *trade
+	p.Trades = append(p.Trades, &recorded)
+	p.MatchedAt = append(p.MatchedAt, stamp.MatchedAt)
+	p.ConfigVersions = append(p.ConfigVersions, stamp.ConfigVersion)
+	p.Fees = append(p.Fees, stamp.Fees)
+	p.Sequences = append(p.Sequences, stamp.Sequence)
+}
+
+func (p *recordingPublisher) PublishCancel(key *pkg.OrderKey, reason CancelReason) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.Cancels = append(p.Cancels, cancelRecord{ID: key.ID, Reason: reason})
+}
+
+func (p *recordingPublisher) PublishCancelOutcome(key *pkg.OrderKey, outcome CancelOutcome, command_id string) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.Outcomes = append(p.Outcomes, outcome)
+	if outcome == CancelOutcomeCancelled {
+		p.Cancels = append(p.Cancels, cancelRecord{ID: key.ID})
+	}
+}
+
+func (p *recordingPublisher) PublishReplace(replaced_key *pkg.OrderKey, order *pkg.Order, accepted bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.Replaces = append(p.Replaces, replaceRecord{ReplacedID: replaced_key.ID, ID: order.ID, Accepted: accepted})
+}
+
+func (p *recordingPublisher) PublishDecrement(key *pkg.OrderKey, quantity decimal.Decimal, reason CancelReason) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.Decrements = append(p.Decrements, decrementRecord{ID: key.ID, Quantity: quantity})
+}
+
+func (p *recordingPublisher) PublishReprice(key *pkg.OrderKey, reason CancelReason) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.Reprices = append(p.Reprices, repriceRecord{ID: key.ID, Price: key.Price, StopPrice: key.StopPrice})
+}
+
+func (p *recordingPublisher) PublishAmend(key *pkg.OrderKey, quantity decimal.Decimal, accepted bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.Amends = append(p.Amends, amendRecord{ID: key.ID, Price: key.Price, Quantity: quantity, Accepted: accepted})
+}
+
+func (p *recordingPublisher) PublishBatch(batch_id string, entries []*events.BatchEntry) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.Batches = append(p.Batches, entries)
+}
+
+func (p *recordingPublisher) PublishCircuitBreak(circuit_break *CircuitBreak) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.CircuitBreaks = append(p.CircuitBreaks, *circuit_break)
+}
+
+// discardPublisher drops the output of the book, for the benchmarks.
+type discardPublisher struct {
+	recordingPublisher
+}
+
+func (p *discardPublisher) PublishTrade(trade *pkg.Trade, stamp TradeStamp) {}
+
+func (p *discardPublisher) PublishCancel(key *pkg.OrderKey, reason CancelReason) {}
+
+// newTestOrderBook returns a book publishing to memory, on the wall clock unless fake is given.
+func newTestOrderBook(market_price decimal.Decimal, book_config OrderBookConfig, fake *clock.Fake) (*OrderBook, *recordingPublisher) {
+	publisher := &recordingPublisher{}
+
+	if fake != nil {
+		book_config.Clock = fake
+	}
+
+	ob := newOrderBook(testSymbol, market_price, book_config, newNotification(testSymbol), publisher)
+
+	return ob, publisher
+}
+
+var testOrderID int64
+
+func newTestOrder(side pkg.OrderSide, order_type pkg.OrderType, price, quantity string) *pkg.Order {
+	testOrderID++
+
+	o := &pkg.Order{
+		ID:        testOrderID,
+		UUID:      uuid.New(),
+		Symbol:    testSymbol,
+		MemberID:  1,
+		Side:      side,
+		Type:      order_type,
+		Quantity:  decimal.RequireFromString(quantity),
+		CreatedAt: time.Now().Add(time.Duration(testOrderID) * time.Microsecond),
+	}
+
+	if len(price) > 0 {
+		o.Price = decimal.RequireFromString(price)
+	}
+
+	return o
+}
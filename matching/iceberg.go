@@ -0,0 +1,150 @@
+package matching
+
+import (
+	"time"
+
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+// iceberg is the slice an iceberg order shows in the book, the rest of its quantity is hidden.
+type iceberg struct {
+	// display is the quantity of a slice
+	display decimal.Decimal
+	// shown is what is left of the slice shown, it's never shown beyond the unfilled quantity of the order
+	shown decimal.Decimal
+	// createdAt is when the order was created, each slice is queued with a later time but the order stays as old
+	createdAt time.Time
+}
+
+// hide makes the order an iceberg showing slices of display, the first slice is shown once it rests.
+func (d *Depth) hide(o *pkg.Order, display decimal.Decimal) {
+	d.depthMutex.Lock()
+	defer d.depthMutex.Unlock()
+
+	d.icebergs[o.ID] = &iceberg{display: display, shown: display, createdAt: o.CreatedAt}
+}
+
+// forget drops the slice of the order of id once it left the book.
+func (d *Depth) forget(id int64) {
+	d.depthMutex.Lock()
+	defer d.depthMutex.Unlock()
+
+	delete(d.icebergs, id)
+}
+
+// icebergOf returns the slice of the order of id, nil when it isn't an iceberg.
+func (d *Depth) icebergOf(id int64) *iceberg {
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
+
+	return d.icebergs[id]
+}
+
+// keep gives the order of id back the slice icebergOf returned before it left the book.
+func (d *Depth) keep(id int64, ice *iceberg) {
+	d.depthMutex.Lock()
+	defer d.depthMutex.Unlock()
+
+	d.icebergs[id] = ice
+}
+
+// createdAt returns when the order was created, not when the slice it shows was queued.
+func (d *Depth) createdAt(o *pkg.Order) time.Time {
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
+
+	if ice, found := d.icebergs[o.ID]; found {
+		return ice.createdAt
+	}
+
+	return o.CreatedAt
+}
+
+// matchable returns the quantity of the order an order matching it can take at once, the slice of an iceberg.
+func (d *Depth) matchable(o *pkg.Order) decimal.Decimal {
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
+
+	return d.shownQuantity(o)
+}
+
+// shownQuantity returns the quantity of the order the book shows, with the depthMutex held.
+func (d *Depth) shownQuantity(o *pkg.Order) decimal.Decimal {
+	if ice, found := d.icebergs[o.ID]; found {
+		return decimal.Min(ice.shown, o.UnfilledQuantity())
+	}
+
+	return o.UnfilledQuantity()
+}
+
+// shown returns the quantity of a level the book shows, with the depthMutex held. The hidden quantity of the
+// icebergs of the level isn't in it.
+func (d *Depth) shown(price_level *PriceLevel) decimal.Decimal {
+	price_level.Lock()
+	defer price_level.Unlock()
+
+	total := decimal.Zero
+	for _, order := range price_level.Orders.Values() {
+		total = total.Add(d.shownQuantity(order.(*pkg.Order)))
+	}
+
+	return total
+}
+
+// fill takes quantity filled off the slice of an iceberg order of the book, other orders are left as they are.
+// Once the slice is filled and quantity is left, the order shows the next slice from the end of its level whatever
+// the comparator of the level, so it loses its time priority to the orders shown meanwhile.
+func (d *Depth) fill(o *pkg.Order, quantity decimal.Decimal, now time.Time) {
+	d.depthMutex.Lock()
+	defer d.depthMutex.Unlock()
+
+	ice, found := d.icebergs[o.ID]
+	if !found {
+		return
+	}
+
+	ice.shown = decimal.Max(ice.shown.Sub(quantity), decimal.Zero)
+	if ice.shown.IsPositive() || !o.UnfilledQuantity().IsPositive() {
+		return
+	}
+
+	ice.shown = ice.display
+
+	price_level := d.level(o.Side, o.Price)
+	if price_level == nil {
+		return
+	}
+
+	price_level.Remove(o.Key())
+
+	latest, earliest := now, now
+	for i, order := range price_level.Orders.Values() {
+		created_at := order.(*pkg.Order).CreatedAt
+		if !latest.After(created_at) {
+			latest = created_at.Add(time.Nanosecond)
+		}
+
+		if i == 0 || !earliest.Before(created_at) {
+			earliest = created_at.Add(-time.Nanosecond)
+		}
+	}
+
+	// the level of the newest first queues the slice last only when it is older than every order of it
+	o.CreatedAt = latest
+	if last, found := price_level.Orders.Get(price_level.Orders.Size() - 1); found && price_level.comparator(o, last) < 0 {
+		o.CreatedAt = earliest
+	}
+
+	price_level.Add(o)
+}
+
+// levels returns the price levels of a side of the book.
+func (d *Depth) levels(side pkg.OrderSide) *redblacktree.Tree {
+	if side == pkg.SideSell {
+		return d.Asks
+	}
+
+	return d.Bids
+}
@@ -0,0 +1,121 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/pkg"
+)
+
+func display(quantity string) *events.OrderOptions {
+	d := decimal.RequireFromString(quantity)
+
+	return &events.OrderOptions{DisplayQuantity: &d}
+}
+
+func TestIcebergShowsASlice(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "10"), display("2"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+
+	asks, _ := ob.Depth.Levels()
+	if len(asks) != 1 || !asks[0][1].Equal(decimal.NewFromInt(3)) {
+		t.Fatalf("expected the level to show the slice and the other order, 3, got %v", asks)
+	}
+
+	if top, _ := ob.Depth.Top(5); !top[0][1].Equal(decimal.NewFromInt(3)) {
+		t.Errorf("expected the top levels not to show the hidden quantity, got %v", top)
+	}
+}
+
+func TestIcebergSlicesLoseTimePriority(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{Flags: FeatureFlags{FifoTiebreakV2: true}}, nil)
+
+	iceberg := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "5")
+	ob.add(iceberg, display("2"))
+	other := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	ob.Add(other)
+
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "4")
+	ob.Add(taker)
+
+	// the first slice, the order queued meanwhile, then the next slice
+	want := []struct {
+		maker    int64
+		quantity int64
+	}{{iceberg.ID, 2}, {other.ID, 1}, {iceberg.ID, 1}}
+
+	if len(publisher.Trades) != len(want) {
+		t.Fatalf("expected %d trades, got %d", len(want), len(publisher.Trades))
+	}
+
+	for i, trade := range publisher.Trades {
+		if trade.MakerOrder.ID != want[i].maker || trade.TakerOrder.ID != taker.ID || !trade.Quantity.Equal(decimal.NewFromInt(want[i].quantity)) {
+			t.Errorf("expected trade %d of %d from order %d, got %s from order %d", i, want[i].quantity, want[i].maker, trade.Quantity, trade.MakerOrder.ID)
+		}
+	}
+
+	// the last slice shows what is left of it, never the hidden quantity
+	if asks, _ := ob.Depth.Levels(); len(asks) != 1 || !asks[0][1].Equal(decimal.NewFromInt(1)) || !iceberg.UnfilledQuantity().Equal(decimal.NewFromInt(2)) {
+		t.Errorf("expected the level to show 1 of the 2 left, got %v", asks)
+	}
+
+	// an order queued after the slice was shown comes after it
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+	if ids := levelOrders(ob, pkg.SideSell); len(ids) != 2 || ids[0] != iceberg.ID {
+		t.Errorf("expected the iceberg to keep the queue of its slice, got %v", ids)
+	}
+}
+
+// The default comparator puts the newest order first, the next slice is queued last all the same.
+func TestIcebergSlicesLoseTimePriorityNewestFirst(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	other := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	ob.Add(other)
+	iceberg := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "5")
+	ob.add(iceberg, display("2"))
+
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "4")
+	ob.Add(taker)
+
+	want := []struct {
+		maker    int64
+		quantity int64
+	}{{iceberg.ID, 2}, {other.ID, 1}, {iceberg.ID, 1}}
+
+	if len(publisher.Trades) != len(want) {
+		t.Fatalf("expected %d trades, got %d", len(want), len(publisher.Trades))
+	}
+
+	for i, trade := range publisher.Trades {
+		if trade.MakerOrder.ID != want[i].maker || !trade.Quantity.Equal(decimal.NewFromInt(want[i].quantity)) {
+			t.Errorf("expected trade %d of %d from order %d, got %s from order %d", i, want[i].quantity, want[i].maker, trade.Quantity, trade.MakerOrder.ID)
+		}
+	}
+
+	// a sell shown after the slice goes ahead of it, the newest first
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+	if ids := levelOrders(ob, pkg.SideSell); len(ids) != 2 || ids[1] != iceberg.ID {
+		t.Errorf("expected the iceberg at the back of its level, got %v", ids)
+	}
+}
+
+func TestIcebergSweptByOneTaker(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{Flags: FeatureFlags{FifoTiebreakV2: true}}, nil)
+
+	iceberg := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "5")
+	ob.add(iceberg, display("2"))
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeMarket, "", "5"))
+
+	if len(publisher.Trades) != 3 || !iceberg.Filled() || bookHas(ob, iceberg) {
+		t.Fatalf("expected the iceberg to be filled a slice at a time, got %d trades", len(publisher.Trades))
+	}
+
+	if ob.Depth.icebergOf(iceberg.ID) != nil {
+		t.Error("expected the slice of the filled iceberg to be dropped")
+	}
+}
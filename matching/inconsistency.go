@@ -0,0 +1,74 @@
+package matching
+
+import (
+	"fmt"
+
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// CancelReasonMalformed cancels an order the book can't take: its side, its type or its quantities are invalid, or
+// the book found it inconsistent with the orders it holds.
+const CancelReasonMalformed CancelReason = "malformed"
+
+// InconsistencyError is an order or a key the book can't process. The comparators of the trees of the book panic
+// with it, Match returns it, the book rejects the order which caused it and goes on with the next command.
+type InconsistencyError struct {
+	Reason string
+}
+
+func (e *InconsistencyError) Error() string {
+	return "inconsistent order: " + e.Reason
+}
+
+func inconsistency(format string, args ...interface{}) *InconsistencyError {
+	return &InconsistencyError{Reason: fmt.Sprintf(format, args...)}
+}
+
+func validSide(side pkg.OrderSide) bool {
+	return side == pkg.SideBuy || side == pkg.SideSell
+}
+
+// checkOrder returns why the book can't take o, nil when it can.
+func checkOrder(o *pkg.Order) error {
+	switch {
+	case !validSide(o.Side):
+		return inconsistency("order %d has the unknown side %q", o.ID, o.Side)
+	case o.Type != pkg.TypeLimit && o.Type != pkg.TypeMarket:
+		return inconsistency("order %d has the unknown type %q", o.ID, o.Type)
+	case !o.Quantity.IsPositive():
+		return inconsistency("order %d has the quantity %s", o.ID, o.Quantity)
+	case o.FilledQuantity.IsNegative() || o.FilledQuantity.GreaterThan(o.Quantity):
+		return inconsistency("order %d has %s filled of %s", o.ID, o.FilledQuantity, o.Quantity)
+	}
+
+	return nil
+}
+
+// rejectInconsistent cancels what's left of o with the orderMutex held, the book couldn't process it.
+func (ob *OrderBook) rejectInconsistent(o *pkg.Order, err error) {
+	config.Logger.Errorf("[oceanbook.orderbook] %s rejected order %d: %v", ob.Symbol.String(), o.ID, err)
+
+	ob.forget(o.ID)
+	ob.departed.add(o.ID)
+	if !o.IsFake() {
+		ob.PublishCancel(o.Key(), CancelReasonMalformed)
+	}
+}
+
+// recoverInconsistency rejects o when the command processing it panicked with an InconsistencyError, deferred by
+// the commands taking an order. Any other panic goes on.
+func (ob *OrderBook) recoverInconsistency(o *pkg.Order) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+
+	err, inconsistent := recovered.(*InconsistencyError)
+	if !inconsistent {
+		panic(recovered)
+	}
+
+	ob.rejectInconsistent(o, err)
+}
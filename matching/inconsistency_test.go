@@ -0,0 +1,108 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func lastCancel(publisher *recordingPublisher) cancelRecord {
+	if len(publisher.Cancels) == 0 {
+		return cancelRecord{}
+	}
+
+	return publisher.Cancels[len(publisher.Cancels)-1]
+}
+
+func TestMalformedOrdersRejected(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	unknown_side := newTestOrder("middle", pkg.TypeLimit, "100", "1")
+	ob.Add(unknown_side)
+	if lastCancel(publisher) != (cancelRecord{ID: unknown_side.ID, Reason: CancelReasonMalformed}) {
+		t.Errorf("expected the order of an unknown side to be rejected, got %+v", publisher.Cancels)
+	}
+
+	empty := newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "0")
+	ob.Add(empty)
+	if lastCancel(publisher) != (cancelRecord{ID: empty.ID, Reason: CancelReasonMalformed}) {
+		t.Errorf("expected the order without a quantity to be rejected, got %+v", publisher.Cancels)
+	}
+
+	if ob.Depth.Asks.Size() != 0 || ob.Depth.Bids.Size() != 0 {
+		t.Fatal("expected the malformed orders not to rest")
+	}
+
+	// the book goes on matching
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1"))
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "100", "1")
+	taker.MemberID = 2
+	ob.Add(taker)
+
+	if len(publisher.Trades) != 1 {
+		t.Errorf("expected the book to match after the rejects, got %d trades", len(publisher.Trades))
+	}
+}
+
+func TestInconsistentOfferRejectsTaker(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	corrupted := newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1")
+	ob.Add(corrupted)
+	corrupted.Side = pkg.SideBuy
+
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "100", "1")
+	taker.MemberID = 2
+	ob.Add(taker)
+
+	if len(publisher.Trades) != 0 || lastCancel(publisher) != (cancelRecord{ID: taker.ID, Reason: CancelReasonMalformed}) {
+		t.Fatalf("expected the taker to be rejected, got %d trades and %+v", len(publisher.Trades), publisher.Cancels)
+	}
+
+	if bookHas(ob, taker) {
+		t.Error("expected the rejected taker not to rest")
+	}
+}
+
+func TestInconsistentStopOrderRejected(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	// a sell stop in the tree of the buy stops can't be compared with them
+	planted := newTestOrder(pkg.SideSell, pkg.TypeLimit, "90", "1")
+	planted.StopPrice = decimal.NewFromInt(95)
+	ob.StopBids.Put(planted.Key(), planted)
+
+	stop := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "110", "1")
+	stop.StopPrice = decimal.NewFromInt(105)
+	ob.Add(stop)
+
+	if lastCancel(publisher) != (cancelRecord{ID: stop.ID, Reason: CancelReasonMalformed}) {
+		t.Fatalf("expected the stop order to be rejected, got %+v", publisher.Cancels)
+	}
+
+	// the next order is processed
+	resting := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	ob.Add(resting)
+	if !bookHas(ob, resting) {
+		t.Error("expected the book to take orders after the reject")
+	}
+}
+
+func TestCancelOfUnknownSide(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	resting := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	ob.Add(resting)
+
+	key := resting.Key()
+	key.Side = "middle"
+
+	if outcome := ob.Cancel(key, ""); outcome != CancelOutcomeNeverExisted {
+		t.Errorf("expected the key of an unknown side not to be found, got %s", outcome)
+	}
+
+	if !bookHas(ob, resting) {
+		t.Error("expected the order to stay in the book")
+	}
+}
@@ -0,0 +1,90 @@
+package matching
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/types"
+)
+
+// UpdateIndexPrice moves the index price of the book in a cycle, see OrderBook.UpdateIndexPrice.
+func (e *Engine) UpdateIndexPrice(price decimal.Decimal) {
+	started_at := time.Now()
+
+	e.MatchingMutex.Lock()
+	defer e.MatchingMutex.Unlock()
+
+	cascade_depth := e.OrderBook.UpdateIndexPrice(price)
+
+	e.Metrics.Observe(Cycle{
+		Action:       events.ActionIndexPrice,
+		Latency:      time.Since(started_at),
+		CascadeDepth: cascade_depth,
+	})
+}
+
+// UpdateIndexPrice moves the index price of the book and triggers the stop orders triggered by the index price it
+// crossed, as a trade does with the ones triggered by the last price: the orders triggered are matched once the price
+// moved, or wait for the uncross of a book in a batch or in a call auction. It returns the depth of the stop cascade.
+// A book which doesn't take orders, or isn't open yet, ignores the price: its stop orders are triggered from the last
+// index price it took once it takes orders again.
+func (ob *OrderBook) UpdateIndexPrice(price decimal.Decimal) (cascade_depth int) {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	if _, rejected := ob.rejectsOrders(); rejected || ob.listing != nil || !price.IsPositive() {
+		return
+	}
+
+	previous := ob.indexPrice
+	ob.indexPrice = price
+
+	ob.trailStops(types.TriggerByIndexPrice, price)
+	ob.triggerCrossed(ob.indexStopAsks, ob.indexStopBids, previous, price)
+
+	if ob.waiting() != nil {
+		ob.accumulatePending()
+		return
+	}
+
+	return ob.matchPending()
+}
+
+// IndexPrice returns the last index price pushed to the book, zero before the first one.
+func (ob *OrderBook) IndexPrice() decimal.Decimal {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	return ob.indexPrice
+}
+
+// triggerBy returns the price the stop order of id is triggered by.
+func (ob *OrderBook) triggerBy(id int64) types.TriggerBy {
+	if options, found := ob.options[id]; found && options.TriggerBy == types.TriggerByIndexPrice {
+		return types.TriggerByIndexPrice
+	}
+
+	return types.TriggerByLastPrice
+}
+
+// triggerPrice returns the price the stop orders triggered by trigger follow.
+func (ob *OrderBook) triggerPrice(trigger types.TriggerBy) decimal.Decimal {
+	if trigger == types.TriggerByIndexPrice {
+		return ob.indexPrice
+	}
+
+	return ob.MarketPrice
+}
+
+// ContinueIndexPrice takes the index price of the book previous, which this book replaces: the stop orders of a book
+// reloaded are triggered from the index price it had.
+func (ob *OrderBook) ContinueIndexPrice(previous *OrderBook) {
+	index_price := previous.IndexPrice()
+
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	ob.indexPrice = index_price
+}
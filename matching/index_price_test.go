@@ -0,0 +1,141 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/types"
+)
+
+var indexTriggered = &events.OrderOptions{TriggerBy: types.TriggerByIndexPrice}
+
+func newStopOrder(side pkg.OrderSide, price, stop_price string) *pkg.Order {
+	o := newTestOrder(side, pkg.TypeLimit, price, "1")
+	o.StopPrice = decimal.RequireFromString(stop_price)
+
+	return o
+}
+
+func waitsForStopPrice(ob *OrderBook, o *pkg.Order) bool {
+	for _, stop := range ob.StopOrders() {
+		if stop.ID == o.ID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestIndexAndLastPriceStopsDiverge(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	ob.UpdateIndexPrice(decimal.NewFromInt(100))
+
+	by_last := newStopOrder(pkg.SideSell, "90", "95")
+	ob.Add(by_last)
+	by_index := newStopOrder(pkg.SideSell, "90", "95")
+	ob.add(by_index, indexTriggered)
+
+	// the book trades below the stop price while the index stays above it
+	tradeAt(ob, "94")
+
+	if waitsForStopPrice(ob, by_last) || !bookHas(ob, by_last) {
+		t.Error("expected the stop triggered by the last price to be triggered by the trade")
+	}
+
+	if !waitsForStopPrice(ob, by_index) {
+		t.Fatal("expected the stop triggered by the index price to wait for it")
+	}
+
+	ob.UpdateIndexPrice(decimal.NewFromInt(96))
+	if !waitsForStopPrice(ob, by_index) {
+		t.Fatal("expected an index price above the stop price not to trigger it")
+	}
+
+	ob.UpdateIndexPrice(decimal.NewFromInt(95))
+	if waitsForStopPrice(ob, by_index) || !bookHas(ob, by_index) {
+		t.Error("expected the index price to trigger the stop")
+	}
+}
+
+func TestIndexPriceTriggersBuyStops(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	maker := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	maker.MemberID = 2
+	ob.Add(maker)
+
+	by_index := newStopOrder(pkg.SideBuy, "101", "105")
+	ob.add(by_index, indexTriggered)
+	by_last := newStopOrder(pkg.SideBuy, "101", "105")
+	ob.Add(by_last)
+
+	// the first index price is where the stops start from, it triggers none
+	ob.UpdateIndexPrice(decimal.NewFromInt(110))
+	if !waitsForStopPrice(ob, by_index) {
+		t.Fatal("expected the first index price not to trigger the stop")
+	}
+
+	ob.UpdateIndexPrice(decimal.NewFromInt(104))
+	ob.UpdateIndexPrice(decimal.NewFromInt(106))
+
+	if waitsForStopPrice(ob, by_index) || len(publisher.Trades) != 1 || publisher.Trades[0].TakerOrder.ID != by_index.ID {
+		t.Fatalf("expected the stop to be triggered and to match, got %d trades", len(publisher.Trades))
+	}
+
+	if !waitsForStopPrice(ob, by_last) || !ob.MarketPrice.Equal(decimal.NewFromInt(101)) {
+		t.Error("expected the stop triggered by the last price to wait for a trade at its stop price")
+	}
+}
+
+func TestHaltedBookIgnoresIndexPrice(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	ob.UpdateIndexPrice(decimal.NewFromInt(100))
+
+	by_index := newStopOrder(pkg.SideSell, "90", "95")
+	ob.add(by_index, indexTriggered)
+
+	ob.SetTradingState(types.TradingStateHalted)
+	ob.UpdateIndexPrice(decimal.NewFromInt(90))
+
+	if !waitsForStopPrice(ob, by_index) || !ob.IndexPrice().Equal(decimal.NewFromInt(100)) {
+		t.Fatal("expected the halted book to ignore the index price")
+	}
+
+	ob.SetTradingState(types.TradingStateTrading)
+	ob.UpdateIndexPrice(decimal.NewFromInt(90))
+
+	if waitsForStopPrice(ob, by_index) {
+		t.Error("expected the stop to be triggered once the book is resumed")
+	}
+}
+
+func TestCancelIndexStop(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	by_index := newStopOrder(pkg.SideSell, "90", "95")
+	ob.add(by_index, indexTriggered)
+
+	if outcome := ob.Cancel(by_index.Key(), ""); outcome != CancelOutcomeCancelled || waitsForStopPrice(ob, by_index) {
+		t.Errorf("expected the stop triggered by the index price to be cancelled, got %s", outcome)
+	}
+}
+
+func TestApplyIndexPrice(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	engine := newEngine(testSymbol, ob, 0)
+
+	if engine.Apply(&events.MatchingPayload{MatchingPayloadMessage: pkg.MatchingPayloadMessage{Action: events.ActionIndexPrice}}) {
+		t.Error("expected an index price command without a price to be unknown")
+	}
+
+	command := &events.MatchingPayload{
+		MatchingPayloadMessage: pkg.MatchingPayloadMessage{Action: events.ActionIndexPrice, Symbol: testSymbol},
+		IndexPrice:             &events.IndexPriceUpdate{Price: decimal.NewFromInt(99)},
+	}
+	if !engine.Apply(command) || !ob.IndexPrice().Equal(decimal.NewFromInt(99)) {
+		t.Errorf("expected the index price to move, got %s", ob.IndexPrice())
+	}
+}
@@ -0,0 +1,162 @@
+package matching
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/sirupsen/logrus"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+	"github.com/zsmartex/pkg"
+)
+
+// Invariant is a property every book holds between two commands, a book breaking one is corrupted.
+type Invariant string
+
+const (
+	// InvariantCrossedBook is broken by a best bid at or above the best ask of a book matching continuously, the books
+	// in a batch, a call auction or the warm-up of their listing hold crossing orders until they're uncrossed
+	InvariantCrossedBook Invariant = "crossed_book"
+	// InvariantOverfilled is broken by an order of the book filled beyond its quantity
+	InvariantOverfilled Invariant = "overfilled_order"
+	// InvariantEmptyOrder is broken by an order resting in the book with nothing left to fill
+	InvariantEmptyOrder Invariant = "empty_order"
+	// InvariantOrderCount is broken when the orders of the price levels aren't the orders the book counts as resting
+	InvariantOrderCount Invariant = "order_count"
+)
+
+// InvariantViolation is an invariant a book broke, Detail holds the state which broke it.
+type InvariantViolation struct {
+	Invariant Invariant `json:"invariant"`
+	// OrderID is the order which broke the invariant, zero when it isn't broken by one order
+	OrderID int64  `json:"order_id,omitempty"`
+	Detail  string `json:"detail"`
+}
+
+// InvariantCheck is the outcome of a check of the invariants of the book of a market.
+type InvariantCheck struct {
+	Market     string               `json:"market"`
+	Violations []InvariantViolation `json:"violations"`
+	// Halted is set when the book was halted for the violations
+	Halted bool `json:"halted"`
+}
+
+// CheckInvariants checks the book in a cycle, see OrderBook.CheckInvariants. The violations are logged and counted
+// in the metrics of the engine, the book is halted on a violation when halt is set: the engine stops matching a
+// corrupted book until it's reloaded.
+func (e *Engine) CheckInvariants(halt bool) []InvariantViolation {
+	e.MatchingMutex.Lock()
+	defer e.MatchingMutex.Unlock()
+
+	violations := e.OrderBook.CheckInvariants()
+	if len(violations) == 0 {
+		return violations
+	}
+
+	e.Metrics.ObserveInvariantViolations(len(violations))
+	for _, violation := range violations {
+		config.Logger.WithFields(logrus.Fields{
+			"market":    e.Symbol.String(),
+			"invariant": violation.Invariant,
+			"order_id":  violation.OrderID,
+			"detail":    violation.Detail,
+		}).Error("Order book invariant violated")
+	}
+
+	if halt {
+		e.OrderBook.SetTradingState(types.TradingStateHalted)
+		config.Logger.Errorf("[oceanbook.orderbook] %s halted after %d invariant violations, reload its engine to rebuild the book", e.Symbol.String(), len(violations))
+	}
+
+	return violations
+}
+
+// CheckInvariants returns the invariants the book breaks, none for a sound book.
+func (ob *OrderBook) CheckInvariants() []InvariantViolation {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	// the orders of a batch, a call auction or a listing rest in the book without matching
+	return ob.Depth.checkInvariants(ob.waiting() == nil && ob.listing == nil)
+}
+
+// checkInvariants checks the price levels of the book, matching when its bids must not reach its asks.
+func (d *Depth) checkInvariants(matching bool) []InvariantViolation {
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
+
+	violations := make([]InvariantViolation, 0)
+
+	if best_ask, best_bid := bestLevel(d.Asks), bestLevel(d.Bids); matching && best_ask != nil && best_bid != nil && best_bid.Price.GreaterThanOrEqual(best_ask.Price) {
+		violations = append(violations, InvariantViolation{
+			Invariant: InvariantCrossedBook,
+			Detail:    fmt.Sprintf("best bid %s at or above best ask %s, bids %s, asks %s", best_bid.Price, best_ask.Price, levelDetail(best_bid), levelDetail(best_ask)),
+		})
+	}
+
+	count := 0
+	for _, price_levels := range []*redblacktree.Tree{d.Asks, d.Bids} {
+		for _, value := range price_levels.Values() {
+			for _, value := range value.(*PriceLevel).Orders.Values() {
+				o := value.(*pkg.Order)
+				count++
+
+				switch {
+				case o.FilledQuantity.GreaterThan(o.Quantity):
+					violations = append(violations, InvariantViolation{
+						Invariant: InvariantOverfilled,
+						OrderID:   o.ID,
+						Detail:    orderDetail(o),
+					})
+				case !o.UnfilledQuantity().IsPositive():
+					violations = append(violations, InvariantViolation{
+						Invariant: InvariantEmptyOrder,
+						OrderID:   o.ID,
+						Detail:    orderDetail(o),
+					})
+				}
+
+				if d.resting[o.ID] != o {
+					violations = append(violations, InvariantViolation{
+						Invariant: InvariantOrderCount,
+						OrderID:   o.ID,
+						Detail:    fmt.Sprintf("%s isn't counted as resting", orderDetail(o)),
+					})
+				}
+			}
+		}
+	}
+
+	if count != len(d.resting) {
+		violations = append(violations, InvariantViolation{
+			Invariant: InvariantOrderCount,
+			Detail:    fmt.Sprintf("%d orders in %d ask and %d bid levels, %d counted as resting", count, d.Asks.Size(), d.Bids.Size(), len(d.resting)),
+		})
+	}
+
+	return violations
+}
+
+// bestLevel returns the best price level of a side of the book, nil when it's empty.
+func bestLevel(price_levels *redblacktree.Tree) *PriceLevel {
+	if price_levels.Empty() {
+		return nil
+	}
+
+	return price_levels.Right().Value.(*PriceLevel)
+}
+
+func levelDetail(price_level *PriceLevel) string {
+	details := make([]string, 0, price_level.Orders.Size())
+	for _, value := range price_level.Orders.Values() {
+		details = append(details, orderDetail(value.(*pkg.Order)))
+	}
+
+	return fmt.Sprintf("%v", details)
+}
+
+func orderDetail(o *pkg.Order) string {
+	return fmt.Sprintf("order %d of member %d %s %s %s filled %s of %s created at %s", o.ID, o.MemberID, o.Side, o.Type, o.Price, o.FilledQuantity, o.Quantity, o.CreatedAt.Format(time.RFC3339Nano))
+}
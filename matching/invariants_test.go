@@ -0,0 +1,103 @@
+package matching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/types"
+	"github.com/zsmartex/pkg"
+)
+
+func invariantsOf(violations []InvariantViolation) []Invariant {
+	invariants := make([]Invariant, 0, len(violations))
+	for _, violation := range violations {
+		invariants = append(invariants, violation.Invariant)
+	}
+
+	return invariants
+}
+
+func TestInvariantsOfASoundBook(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "2"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1"))
+
+	if violations := ob.CheckInvariants(); len(violations) != 0 {
+		t.Errorf("expected no violation, got %+v", violations)
+	}
+}
+
+func TestInvariantsCrossedBook(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1"))
+	// a bid put in the levels without matching
+	ob.Depth.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1"))
+
+	if invariants := invariantsOf(ob.CheckInvariants()); len(invariants) != 1 || invariants[0] != InvariantCrossedBook {
+		t.Errorf("expected the crossed book, got %v", invariants)
+	}
+
+	// the orders of a batch cross until it's uncrossed
+	batched, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{BatchInterval: time.Hour}, nil)
+	batched.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1"))
+	batched.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1"))
+
+	if violations := batched.CheckInvariants(); len(violations) != 0 {
+		t.Errorf("expected the batch to cross, got %+v", violations)
+	}
+}
+
+func TestInvariantsOrders(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	overfilled := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	ob.Add(overfilled)
+	empty := newTestOrder(pkg.SideSell, pkg.TypeLimit, "102", "1")
+	ob.Add(empty)
+	uncounted := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1")
+	ob.Add(uncounted)
+
+	overfilled.FilledQuantity = decimal.NewFromInt(2)
+	empty.FilledQuantity = empty.Quantity
+	delete(ob.Depth.resting, uncounted.ID)
+
+	violations := ob.CheckInvariants()
+	expected := map[int64]Invariant{overfilled.ID: InvariantOverfilled, empty.ID: InvariantEmptyOrder, uncounted.ID: InvariantOrderCount}
+	if len(violations) != 4 {
+		t.Fatalf("expected 4 violations, got %+v", violations)
+	}
+
+	for _, violation := range violations[:3] {
+		if expected[violation.OrderID] != violation.Invariant {
+			t.Errorf("expected order %d to break %s, got %s", violation.OrderID, expected[violation.OrderID], violation.Invariant)
+		}
+	}
+
+	if violations[3].Invariant != InvariantOrderCount || violations[3].OrderID != 0 {
+		t.Errorf("expected the count of the orders not to match, got %+v", violations[3])
+	}
+}
+
+func TestEngineInvariantViolationHalts(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	engine := newEngine(testSymbol, ob, 0)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1"))
+	ob.Depth.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1"))
+
+	if violations := engine.CheckInvariants(false); len(violations) != 1 || ob.TradingState() != types.TradingStateTrading {
+		t.Fatalf("expected the violation found without halting the book, got %+v", violations)
+	}
+
+	if violations := engine.CheckInvariants(true); len(violations) != 1 || ob.TradingState() != types.TradingStateHalted {
+		t.Fatalf("expected the book halted, got %s", ob.TradingState())
+	}
+
+	if engine.Metrics.InvariantViolations() != 2 {
+		t.Errorf("expected the violations counted, got %d", engine.Metrics.InvariantViolations())
+	}
+}
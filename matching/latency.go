@@ -0,0 +1,232 @@
+package matching
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/pkg"
+)
+
+const (
+	// latencyBucketBase is the upper bound of the first latency bucket,
+	// every following bucket is twice as wide as the previous one.
+	latencyBucketBase = 10 * time.Microsecond
+	// latencyBuckets covers cycles up to ~84s, slower ones land in the last bucket.
+	latencyBuckets = 24
+	// maxTrackedCascadeDepth is the deepest stop cascade counted on its own.
+	maxTrackedCascadeDepth = 16
+)
+
+// LatencyHistogram counts durations in preallocated power-of-two buckets,
+// observing is a couple of atomic adds so it can sit on the matching path.
+type LatencyHistogram struct {
+	counts [latencyBuckets]uint64
+}
+
+func latencyBucket(d time.Duration) int {
+	if d < latencyBucketBase {
+		return 0
+	}
+
+	bucket := bits.Len64(uint64(d / latencyBucketBase))
+	if bucket >= latencyBuckets {
+		return latencyBuckets - 1
+	}
+
+	return bucket
+}
+
+// latencyBucketBound returns the upper bound of a bucket.
+func latencyBucketBound(bucket int) time.Duration {
+	return latencyBucketBase << bucket
+}
+
+func (h *LatencyHistogram) Observe(d time.Duration) {
+	atomic.AddUint64(&h.counts[latencyBucket(d)], 1)
+}
+
+func (h *LatencyHistogram) Count() (count uint64) {
+	for i := range h.counts {
+		count += atomic.LoadUint64(&h.counts[i])
+	}
+
+	return
+}
+
+// Quantile returns the upper bound of the bucket holding the q-th quantile, zero when nothing was observed.
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	var counts [latencyBuckets]uint64
+	var total uint64
+	for i := range h.counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+		total += counts[i]
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	var seen uint64
+	for i, count := range counts {
+		seen += count
+		if seen >= rank {
+			return latencyBucketBound(i)
+		}
+	}
+
+	return latencyBucketBound(latencyBuckets - 1)
+}
+
+// Cycle describes one matching command, from the moment the engine server received it, see CycleMetrics.Receive, or
+// else the engine took it, to the moment its last event is published. Submissions carry the order and cancellations
+// the key.
+type Cycle struct {
+	Action  pkg.PayloadAction
+	Order   *pkg.Order
+	Key     *pkg.OrderKey
+	Latency time.Duration
+	// QueueWait is the time the command waited for the engine once received, observed with the latency
+	QueueWait    time.Duration
+	CascadeDepth int
+	// Submitted is the number of orders the command submitted, the submits of a batch
+	Submitted int
+}
+
+// CycleMetrics tracks the latency and the stop cascade depth of the matching cycles of a market.
+type CycleMetrics struct {
+	Symbol pkg.Symbol
+	// SlowCycleThreshold is the latency above which a cycle is logged, zero disables the log.
+	SlowCycleThreshold time.Duration
+	Latency            LatencyHistogram
+
+	// market are the Prometheus metrics of the market, observed with the cycles
+	market *marketMetrics
+	// receivedAt is the UnixNano of the receipt of the command processed, zero out of Receive
+	receivedAt int64
+	slowCycles uint64
+	// invariantViolations counts the invariants the book was found breaking, see Engine.CheckInvariants
+	invariantViolations uint64
+	maxCascadeDepth     uint64
+	cascadeDepths       [maxTrackedCascadeDepth + 1]uint64
+}
+
+func NewCycleMetrics(symbol pkg.Symbol, slow_cycle_threshold time.Duration) *CycleMetrics {
+	return &CycleMetrics{
+		Symbol:             symbol,
+		SlowCycleThreshold: slow_cycle_threshold,
+		market:             newMarketMetrics(symbol),
+	}
+}
+
+// Receive starts the clock of the cycles of the command received at received_at, the function it returns stops it once
+// the engine processed the command. The commands of a market are processed one at a time, it's called by the one
+// processing them. A zero received_at leaves the clock to start when the engine takes the command.
+func (m *CycleMetrics) Receive(received_at time.Time) func() {
+	if received_at.IsZero() {
+		return func() {}
+	}
+
+	atomic.StoreInt64(&m.receivedAt, received_at.UnixNano())
+
+	return func() {
+		atomic.StoreInt64(&m.receivedAt, 0)
+	}
+}
+
+func (m *CycleMetrics) Observe(cycle Cycle) {
+	if received_at := atomic.LoadInt64(&m.receivedAt); received_at != 0 {
+		latency := time.Since(time.Unix(0, received_at))
+		if latency > cycle.Latency {
+			cycle.QueueWait = latency - cycle.Latency
+			cycle.Latency = latency
+		}
+	}
+
+	m.Latency.Observe(cycle.Latency)
+	m.market.latency.Observe(cycle.Latency.Seconds())
+	if cycle.Submitted > 0 {
+		m.market.submitted.Add(float64(cycle.Submitted))
+	}
+
+	depth := cycle.CascadeDepth
+	if depth > maxTrackedCascadeDepth {
+		depth = maxTrackedCascadeDepth
+	}
+	atomic.AddUint64(&m.cascadeDepths[depth], 1)
+
+	for {
+		max := atomic.LoadUint64(&m.maxCascadeDepth)
+		if uint64(cycle.CascadeDepth) <= max || atomic.CompareAndSwapUint64(&m.maxCascadeDepth, max, uint64(cycle.CascadeDepth)) {
+			break
+		}
+	}
+
+	if m.SlowCycleThreshold > 0 && cycle.Latency > m.SlowCycleThreshold {
+		atomic.AddUint64(&m.slowCycles, 1)
+		m.logSlowCycle(cycle)
+	}
+}
+
+func (m *CycleMetrics) logSlowCycle(cycle Cycle) {
+	fields := logrus.Fields{
+		"market":        m.Symbol.String(),
+		"action":        cycle.Action,
+		"latency_ms":    float64(cycle.Latency) / float64(time.Millisecond),
+		"queue_wait_ms": float64(cycle.QueueWait) / float64(time.Millisecond),
+		"threshold_ms":  float64(m.SlowCycleThreshold) / float64(time.Millisecond),
+		"cascade_depth": cycle.CascadeDepth,
+	}
+
+	key := cycle.Key
+	if cycle.Order != nil {
+		key = cycle.Order.Key()
+		fields["type"] = cycle.Order.Type
+		fields["quantity"] = cycle.Order.Quantity.String()
+	}
+
+	if key != nil {
+		fields["order_id"] = key.ID
+		fields["side"] = key.Side
+		fields["price"] = key.Price.String()
+		fields["stop_price"] = key.StopPrice.String()
+	}
+
+	config.Logger.WithFields(fields).Warn("Slow matching cycle")
+}
+
+func (m *CycleMetrics) SlowCycles() uint64 {
+	return atomic.LoadUint64(&m.slowCycles)
+}
+
+// ObserveInvariantViolations counts the violations a check of the book found.
+func (m *CycleMetrics) ObserveInvariantViolations(count int) {
+	atomic.AddUint64(&m.invariantViolations, uint64(count))
+}
+
+func (m *CycleMetrics) InvariantViolations() uint64 {
+	return atomic.LoadUint64(&m.invariantViolations)
+}
+
+func (m *CycleMetrics) MaxCascadeDepth() int {
+	return int(atomic.LoadUint64(&m.maxCascadeDepth))
+}
+
+// CascadeDepths returns how many cycles triggered each depth of stop cascade,
+// the last entry counts every cycle at least maxTrackedCascadeDepth deep.
+func (m *CycleMetrics) CascadeDepths() []uint64 {
+	depths := make([]uint64, len(m.cascadeDepths))
+	for i := range m.cascadeDepths {
+		depths[i] = atomic.LoadUint64(&m.cascadeDepths[i])
+	}
+
+	return depths
+}
@@ -0,0 +1,148 @@
+package matching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func TestLatencyHistogramQuantile(t *testing.T) {
+	var h LatencyHistogram
+
+	if h.Quantile(0.99) != 0 {
+		t.Fatal("expected an empty histogram to report zero")
+	}
+
+	for i := 0; i < 98; i++ {
+		h.Observe(15 * time.Microsecond)
+	}
+	h.Observe(3 * time.Millisecond)
+	h.Observe(time.Hour)
+
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.5, 20 * time.Microsecond},
+		{0.98, 20 * time.Microsecond},
+		{0.99, latencyBucketBound(latencyBucket(3 * time.Millisecond))},
+		{1, latencyBucketBound(latencyBuckets - 1)},
+	}
+
+	for _, tt := range tests {
+		if got := h.Quantile(tt.q); got != tt.want {
+			t.Errorf("Quantile(%v) = %s, want %s", tt.q, got, tt.want)
+		}
+	}
+
+	if h.Count() != 100 {
+		t.Errorf("expected 100 observations, got %d", h.Count())
+	}
+}
+
+func TestCycleMetricsSlowCycles(t *testing.T) {
+	metrics := NewCycleMetrics(testSymbol, 100*time.Millisecond)
+	order := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "100", "1")
+
+	metrics.Observe(Cycle{Action: pkg.ActionSubmit, Order: order, Latency: time.Millisecond})
+	metrics.Observe(Cycle{Action: pkg.ActionSubmit, Order: order, Latency: 2 * time.Second, CascadeDepth: 3})
+	metrics.Observe(Cycle{Action: pkg.ActionCancel, Key: order.Key(), Latency: 200 * time.Millisecond})
+
+	if metrics.SlowCycles() != 2 {
+		t.Errorf("expected 2 slow cycles, got %d", metrics.SlowCycles())
+	}
+
+	if metrics.MaxCascadeDepth() != 3 || metrics.CascadeDepths()[3] != 1 || metrics.CascadeDepths()[0] != 2 {
+		t.Errorf("unexpected cascade depths %v", metrics.CascadeDepths())
+	}
+}
+
+// A stop order triggered by another stop order is matched in the same cycle, one generation deeper.
+func TestEngineStopCascadeDepth(t *testing.T) {
+	d := decimal.RequireFromString
+	ob, publisher := newTestOrderBook(d("100"), OrderBookConfig{}, nil)
+	engine := newEngine(testSymbol, ob, 0)
+
+	engine.Submit(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+	engine.Submit(newTestOrder(pkg.SideSell, pkg.TypeLimit, "110", "1"))
+	engine.Submit(newTestOrder(pkg.SideSell, pkg.TypeLimit, "120", "1"))
+
+	// triggered once the price rises to 101, it trades up to 110
+	first_stop := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "110", "1")
+	first_stop.StopPrice = d("101")
+	engine.Submit(first_stop)
+
+	// triggered once the price rises to 105, it trades up to 120
+	second_stop := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "120", "1")
+	second_stop.StopPrice = d("105")
+	engine.Submit(second_stop)
+
+	engine.Submit(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1"))
+
+	if len(publisher.Trades) != 3 || !ob.MarketPrice.Equal(d("120")) {
+		t.Fatalf("expected the cascade to trade 3 times up to 120, got %d trades at %s", len(publisher.Trades), ob.MarketPrice)
+	}
+
+	if engine.Metrics.MaxCascadeDepth() != 2 {
+		t.Errorf("expected a cascade depth of 2, got %d", engine.Metrics.MaxCascadeDepth())
+	}
+
+	if engine.Metrics.Latency.Count() != 6 {
+		t.Errorf("expected 6 cycles, got %d", engine.Metrics.Latency.Count())
+	}
+}
+
+// The cycles of a received command count from its receipt, the wait for the engine included.
+func TestCycleLatencyFromReceipt(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	engine := newEngine(testSymbol, ob, 0)
+
+	processed := engine.Metrics.Receive(time.Now().Add(-time.Second))
+	engine.Submit(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "100", "1"))
+	processed()
+
+	if latency := engine.Metrics.Latency.Quantile(1); latency < time.Second {
+		t.Fatalf("expected the second the command waited in the latency, got %s", latency)
+	}
+
+	// the commands received after it start their own clock
+	engine.Submit(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1"))
+	if latency := engine.Metrics.Latency.Quantile(0.5); latency >= time.Second {
+		t.Errorf("expected the wait of the first command left out of the next one, got %s", latency)
+	}
+}
+
+func BenchmarkCycleMetricsObserve(b *testing.B) {
+	metrics := NewCycleMetrics(testSymbol, time.Hour)
+	cycle := Cycle{Action: pkg.ActionSubmit, Latency: 42 * time.Microsecond, CascadeDepth: 1}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		metrics.Observe(cycle)
+	}
+}
+
+// BenchmarkEngineSubmit is BenchmarkInsertOrder through the engine, the gap between them is the instrumentation.
+func BenchmarkEngineSubmit(b *testing.B) {
+	ob, _ := newTestOrderBook(decimal.Zero, OrderBookConfig{}, nil)
+	engine := newEngine(testSymbol, ob, time.Hour)
+
+	orders := make([]*pkg.Order, b.N)
+	for n := 0; n < b.N; n++ {
+		side := pkg.SideBuy
+		if n%2 == 0 {
+			side = pkg.SideSell
+		}
+
+		orders[n] = newTestOrder(side, pkg.TypeLimit, decimal.NewFromInt(int64(n%10)).String(), "1")
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		engine.Submit(orders[n])
+	}
+	b.StopTimer()
+}
@@ -0,0 +1,148 @@
+package matching
+
+import (
+	"sort"
+	"time"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/pkg"
+)
+
+// CancelReasonMarketNotOpen cancels the orders a listed market can't take before it opens,
+// any order before its warm-up and the orders which can't rest during it.
+const CancelReasonMarketNotOpen CancelReason = "market_not_open"
+
+// ListingSchedule is when a newly listed market starts taking orders and when it starts matching them.
+// Between WarmupAt and OpensAt limit orders rest in the book without matching, even when they cross.
+type ListingSchedule struct {
+	WarmupAt time.Time
+	OpensAt  time.Time
+}
+
+// SetListing holds the book in its listing until the schedule opens it, the book opens itself at OpensAt
+// with an engine-side timer and on the first order received after it, whichever comes first.
+func (ob *OrderBook) SetListing(schedule *ListingSchedule) {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	ob.stopListingTimer()
+	ob.listing = schedule
+
+	if schedule == nil {
+		return
+	}
+
+	ob.listingTimer = ob.clock.AfterFunc(schedule.OpensAt.Sub(ob.clock.Now()), func() {
+		ob.orderMutex.Lock()
+		defer ob.orderMutex.Unlock()
+
+		ob.openIfDue()
+	})
+}
+
+// Listing returns the schedule the book is waiting on, nil once it's open.
+func (ob *OrderBook) Listing() *ListingSchedule {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	return ob.listing
+}
+
+// StopListing stops the timer of the listing of a book which is replaced, so it never opens.
+func (ob *OrderBook) StopListing() {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	ob.stopListingTimer()
+}
+
+func (ob *OrderBook) stopListingTimer() {
+	if ob.listingTimer != nil {
+		ob.listingTimer.Stop()
+		ob.listingTimer = nil
+	}
+}
+
+// openIfDue opens the book when its listing reached OpensAt, it reports whether the book is still waiting. A
+// halted book waits until it's resumed.
+// It's called with the orderMutex held.
+func (ob *OrderBook) openIfDue() (waiting bool) {
+	if ob.listing == nil {
+		return false
+	}
+
+	if ob.halted() || ob.clock.Now().Before(ob.listing.OpensAt) {
+		return true
+	}
+
+	ob.stopListingTimer()
+	ob.listing = nil
+	ob.open()
+
+	return false
+}
+
+// open matches the orders which rested during the warm-up in the order they were placed, the orders placed
+// first rest again and the orders crossing them trade as takers, as if the market had been open all along.
+func (ob *OrderBook) open() {
+	// a book matching in batch auctions leaves them to its next uncross
+	if ob.batch != nil {
+		config.Logger.Infof("[oceanbook.orderbook] %s opened, the orders from the warm-up are uncrossed in the next batch", ob.Symbol.String())
+		return
+	}
+
+	ob.matchMutex.Lock()
+	orders := ob.Depth.Orders()
+	for _, o := range orders {
+		ob.Depth.Remove(o.Key())
+	}
+	ob.matchMutex.Unlock()
+
+	sort.SliceStable(orders, func(i, j int) bool {
+		if orders[i].CreatedAt.Equal(orders[j].CreatedAt) {
+			return orders[i].ID < orders[j].ID
+		}
+
+		return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+	})
+
+	config.Logger.Infof("[oceanbook.orderbook] %s opened with %d orders from the warm-up", ob.Symbol.String(), len(orders))
+
+	for _, o := range orders {
+		ob.match(o)
+	}
+}
+
+// warmup takes an order of a book which isn't open yet with the orderMutex held. Before the warm-up every order
+// is cancelled, during it limit orders good till cancelled rest and stop orders wait for their price, the others are cancelled.
+func (ob *OrderBook) warmup(o *pkg.Order) {
+	if ob.clock.Now().Before(ob.listing.WarmupAt) || o.Type != pkg.TypeLimit {
+		ob.forget(o.ID)
+		if !o.IsFake() {
+			ob.PublishCancel(o.Key(), CancelReasonMarketNotOpen)
+		}
+		return
+	}
+
+	if ob.isStop(o) {
+		ob.putStop(o)
+		return
+	}
+
+	if ob.rejectImmediate(o) {
+		return
+	}
+
+	ob.matchMutex.Lock()
+	defer ob.matchMutex.Unlock()
+
+	if !ob.PriceLimit.Accept(o.Price) {
+		ob.forget(o.ID)
+		if !o.IsFake() {
+			ob.PublishCancel(o.Key(), CancelReasonPriceLimit)
+		}
+		return
+	}
+
+	ob.Depth.Add(o)
+}
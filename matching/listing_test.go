@@ -0,0 +1,136 @@
+package matching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/pkg"
+)
+
+func newListedOrderBook(fake *clock.Fake) (*OrderBook, *recordingPublisher, *ListingSchedule) {
+	ob, publisher := newTestOrderBook(decimal.Zero, OrderBookConfig{}, fake)
+
+	schedule := &ListingSchedule{
+		WarmupAt: fake.Now().Add(time.Minute),
+		OpensAt:  fake.Now().Add(11 * time.Minute),
+	}
+	ob.SetListing(schedule)
+
+	return ob, publisher, schedule
+}
+
+func TestListingWarmupNeverMatches(t *testing.T) {
+	fake := clock.NewFake(time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC))
+	ob, publisher, schedule := newListedOrderBook(fake)
+	defer ob.StopListing()
+
+	early := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "10", "1")
+	ob.Add(early)
+
+	if bookHas(ob, early) || len(publisher.Cancels) != 1 || publisher.Cancels[0] != (cancelRecord{ID: early.ID, Reason: CancelReasonMarketNotOpen}) {
+		t.Fatalf("expected an order before the warm-up to be cancelled, got %+v", publisher.Cancels)
+	}
+
+	fake.Set(schedule.WarmupAt)
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "12", "1")
+	ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "2")
+	ob.Add(bid)
+	ob.Add(ask)
+
+	// the last instant of the warm-up still doesn't match the crossed book
+	fake.Set(schedule.OpensAt.Add(-time.Nanosecond))
+	crossing := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "11", "1")
+	ob.Add(crossing)
+
+	if len(publisher.Trades) != 0 {
+		t.Fatalf("expected no trade before the open, got %d", len(publisher.Trades))
+	}
+
+	for _, o := range []*pkg.Order{bid, ask, crossing} {
+		if !bookHas(ob, o) {
+			t.Errorf("expected order %d to rest during the warm-up", o.ID)
+		}
+	}
+
+	market := newTestOrder(pkg.SideBuy, pkg.TypeMarket, "", "1")
+	ob.Add(market)
+
+	if last := publisher.Cancels[len(publisher.Cancels)-1]; last != (cancelRecord{ID: market.ID, Reason: CancelReasonMarketNotOpen}) || len(publisher.Trades) != 0 {
+		t.Errorf("expected a market order to be cancelled during the warm-up, got %+v", publisher.Cancels)
+	}
+
+	// the timer of the listing opens the book at the open time
+	fake.Set(schedule.OpensAt)
+
+	// the ask placed after the bid at 12 takes it, then rests and is taken by the bid at 11 placed after it
+	if len(publisher.Trades) != 2 {
+		t.Fatalf("expected the crossed warm-up book to trade at the open, got %d trades", len(publisher.Trades))
+	}
+
+	if !publisher.Trades[0].Price.Equal(decimal.RequireFromString("12")) || !publisher.Trades[1].Price.Equal(decimal.RequireFromString("10")) {
+		t.Errorf("expected the trades at the prices of the orders placed first, got %s and %s", publisher.Trades[0].Price, publisher.Trades[1].Price)
+	}
+
+	if ob.Listing() != nil {
+		t.Error("expected the listing to be over")
+	}
+}
+
+func TestListingOpensOnFirstOrderAfterOpen(t *testing.T) {
+	fake := clock.NewFake(time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC))
+	ob, publisher, schedule := newListedOrderBook(fake)
+	defer ob.StopListing()
+
+	fake.Set(schedule.WarmupAt)
+	ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "1")
+	ob.Add(ask)
+
+	// the timer is late, the first order after the open opens the book before it's matched
+	ob.orderMutex.Lock()
+	ob.stopListingTimer()
+	ob.orderMutex.Unlock()
+
+	fake.Set(schedule.OpensAt.Add(time.Millisecond))
+	market := newTestOrder(pkg.SideBuy, pkg.TypeMarket, "", "1")
+	ob.Add(market)
+
+	if len(publisher.Trades) != 1 || publisher.Trades[0].TakerOrder.ID != market.ID {
+		t.Fatalf("expected the market order to trade once the market is open, got %d trades", len(publisher.Trades))
+	}
+}
+
+func TestListingTimerOpensBook(t *testing.T) {
+	fake := clock.NewFake(time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC))
+	ob, publisher := newTestOrderBook(decimal.Zero, OrderBookConfig{}, fake)
+	ob.SetListing(&ListingSchedule{WarmupAt: fake.Now().Add(-time.Minute), OpensAt: fake.Now().Add(20 * time.Millisecond)})
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "10", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "1"))
+
+	fake.Advance(19 * time.Millisecond)
+	if len(publisher.Trades) != 0 {
+		t.Fatalf("expected no trade before the open, got %d", len(publisher.Trades))
+	}
+
+	fake.Advance(time.Millisecond)
+	if len(publisher.Trades) != 1 {
+		t.Errorf("expected the engine timer to open the book without another order, got %d trades", len(publisher.Trades))
+	}
+}
+
+func TestListingStoppedNeverOpens(t *testing.T) {
+	fake := clock.NewFake(time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC))
+	ob, publisher := newTestOrderBook(decimal.Zero, OrderBookConfig{}, fake)
+	ob.SetListing(&ListingSchedule{WarmupAt: fake.Now().Add(-time.Minute), OpensAt: fake.Now().Add(10 * time.Millisecond)})
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "10", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "1"))
+	ob.StopListing()
+
+	fake.Advance(time.Hour)
+	if len(publisher.Trades) != 0 || fake.Pending() != 0 {
+		t.Errorf("expected a replaced book not to open, got %d trades", len(publisher.Trades))
+	}
+}
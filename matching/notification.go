@@ -8,9 +8,16 @@ import (
 
 	"github.com/shopspring/decimal"
 	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
 	"github.com/zsmartex/pkg"
 )
 
+// defaultDepthInterval is the time between two depth frames when market_data.depth_interval isn't set.
+const defaultDepthInterval = 100 * time.Millisecond
+
+// DepthBatches is the batched depth stream, depth frames are added to it when the engine process started it.
+var DepthBatches *events.StreamBatcher
+
 type Book struct {
 	Asks [][]decimal.Decimal
 	Bids [][]decimal.Decimal
@@ -22,17 +29,34 @@ type Notification struct {
 	BookCache *Book // cache for notify to websocket
 
 	NotifyMutex sync.RWMutex
+	// publishing keeps the loop and Publish from taking a frame at once
+	publishing sync.Mutex
+
+	// signals computes the signals of the book, set by the depth the notification belongs to
+	signals      func() BookSignals
+	last_signals BookSignals
+	// top takes the best levels of the book, set by the depth the notification belongs to
+	top  func(limit int) (asks, bids [][]decimal.Decimal)
+	tops depthTops
+	// ticker loads the ticker of the book, set by the book the notification belongs to
+	ticker               func() TickerSnapshot
+	last_ticker_sequence int64
+
+	// held keeps the changes of a book accumulating a batch out of the frames, released are the changes
+	// of the batches uncrossed since the last frame
+	held     bool
+	released *Book
+}
+
+// BookTicker is the book_ticker event, published after a depth frame when the best prices or the signals changed.
+type BookTicker struct {
+	Sequence int64 `json:"sequence"`
+	BookSignals
 }
 
+// NewNotification returns a notification restored from redis, it's started once the depth it belongs to is created.
 func NewNotification(symbol pkg.Symbol) *Notification {
-	notification := &Notification{
-		Symbol:   symbol,
-		Sequence: 0,
-		BookCache: &Book{
-			Asks: make([][]decimal.Decimal, 0),
-			Bids: make([][]decimal.Decimal, 0),
-		},
-	}
+	notification := newNotification(symbol)
 
 	exist, _ := config.Redis.Exist("finex:" + strings.ToLower(symbol.ToSymbol("")) + ":depth:sequence")
 	if exist {
@@ -49,43 +73,144 @@ func NewNotification(symbol pkg.Symbol) *Notification {
 		notification.Sequence = sq
 	}
 
-	notification.Start()
-
 	return notification
 }
 
+// newNotification returns a notification which isn't restored from redis nor started,
+// changes are only cached until the loop is started.
+func newNotification(symbol pkg.Symbol) *Notification {
+	return &Notification{
+		Symbol:   symbol,
+		Sequence: 0,
+		BookCache: &Book{
+			Asks: make([][]decimal.Decimal, 0),
+			Bids: make([][]decimal.Decimal, 0),
+		},
+		tops: depthTops{
+			interval: defaultDepthTopInterval,
+			last:     make(map[string]*pkg.DepthJSON),
+		},
+	}
+}
+
 func (n *Notification) Start() {
 	go n.StartLoop()
 }
 
 func (n *Notification) StartLoop() {
+	interval := config.MarketData.DepthInterval
+	if interval <= 0 {
+		interval = defaultDepthInterval
+	}
+
+	if config.MarketData.DepthTopInterval > 0 {
+		n.tops.interval = config.MarketData.DepthTopInterval
+	}
+
 	for {
-		time.Sleep(100 * time.Millisecond)
+		time.Sleep(interval)
+
+		n.PublishFrame(time.Now())
+	}
+}
+
+// PublishFrame publishes the depth frame of the changes since the last one and the events due at now, the engine
+// publishes the last frame of each book before it exits.
+func (n *Notification) PublishFrame(now time.Time) {
+	n.publishing.Lock()
+	defer n.publishing.Unlock()
+
+	market := strings.ToLower(n.Symbol.ToSymbol(""))
 
-		if len(n.BookCache.Asks) == 0 && len(n.BookCache.Bids) == 0 {
-			continue
+	depth, tops := n.frame(now)
+	if depth != nil {
+		config.Redis.Set("finex:"+market+":depth:sequence", depth.Sequence, 0)
+		config.RangoClient.EnqueueEvent(pkg.EnqueueEventKindPublic, market, "depth", depth)
+		DepthBatches.Add(market, depth.Sequence, depth)
+
+		if ticker := n.bookTicker(depth.Sequence); ticker != nil {
+			config.RangoClient.EnqueueEvent(pkg.EnqueueEventKindPublic, market, "book_ticker", ticker)
 		}
+	}
 
-		n.NotifyMutex.Lock()
+	for format, top := range tops {
+		config.RangoClient.EnqueueEvent(pkg.EnqueueEventKindPublic, market, "depth."+format, top)
+	}
 
-		n.Sequence++
-		config.Redis.Set("finex:"+strings.ToLower(n.Symbol.ToSymbol(""))+":depth:sequence", n.Sequence, 0)
+	if ticker := n.tickerUpdate(); ticker != nil {
+		config.RangoClient.EnqueueEvent(pkg.EnqueueEventKindPublic, market, "ticker", ticker)
+	}
+}
 
-		asks_depth := make([][]decimal.Decimal, 0)
-		bids_depth := make([][]decimal.Decimal, 0)
+// tickerUpdate returns the ticker of the book, nil when it didn't change since the last one published.
+func (n *Notification) tickerUpdate() *TickerSnapshot {
+	if n.ticker == nil {
+		return nil
+	}
 
-		asks_depth = append(asks_depth, n.BookCache.Asks...)
-		bids_depth = append(bids_depth, n.BookCache.Bids...)
+	ticker := n.ticker()
+	if ticker.Sequence == n.last_ticker_sequence {
+		return nil
+	}
+	n.last_ticker_sequence = ticker.Sequence
 
-		config.RangoClient.EnqueueEvent(pkg.EnqueueEventKindPublic, strings.ToLower(n.Symbol.ToSymbol("")), "depth", pkg.DepthJSON{
-			Asks:     asks_depth,
-			Bids:     bids_depth,
-			Sequence: n.Sequence,
-		})
+	return &ticker
+}
 
-		n.BookCache.Asks = make([][]decimal.Decimal, 0)
-		n.BookCache.Bids = make([][]decimal.Decimal, 0)
-		n.NotifyMutex.Unlock()
+// frame takes the depth frame of the changes since the last one and the top-N snapshots due at now,
+// both are nil when the book didn't change.
+func (n *Notification) frame(now time.Time) (*pkg.DepthJSON, map[string]*pkg.DepthJSON) {
+	depth := n.flush()
+	if depth != nil {
+		n.tops.changed = true
+	}
+
+	return depth, n.dueTops(now)
+}
+
+// bookTicker returns the book ticker of the frame sequence, nil when the signals didn't change since the last one.
+func (n *Notification) bookTicker(sequence int64) *BookTicker {
+	if n.signals == nil {
+		return nil
+	}
+
+	signals := n.signals()
+	if signals.Equal(n.last_signals) {
+		return nil
+	}
+	n.last_signals = signals
+
+	return &BookTicker{Sequence: sequence, BookSignals: signals}
+}
+
+// flush takes the changes cached since the last frame with the next sequence, nil when there's none.
+// While the notification is held it only takes the changes released by Release.
+func (n *Notification) flush() *pkg.DepthJSON {
+	n.NotifyMutex.Lock()
+	defer n.NotifyMutex.Unlock()
+
+	if !n.held {
+		n.release()
+	}
+
+	if n.released == nil {
+		return nil
+	}
+
+	n.Sequence++
+
+	asks_depth := make([][]decimal.Decimal, 0)
+	bids_depth := make([][]decimal.Decimal, 0)
+
+	asks_depth = append(asks_depth, n.released.Asks...)
+	bids_depth = append(bids_depth, n.released.Bids...)
+
+	n.released = nil
+
+	return &pkg.DepthJSON{
+		Asks:     asks_depth,
+		Bids:     bids_depth,
+		Sequence: n.Sequence,
 	}
 }
 
@@ -93,8 +218,13 @@ func (n *Notification) Publish(side pkg.OrderSide, price, amount decimal.Decimal
 	n.NotifyMutex.Lock()
 	defer n.NotifyMutex.Unlock()
 
+	n.BookCache.publish(side, price, amount)
+}
+
+// publish sets the amount of a level of the cache, a level changed twice keeps its last amount.
+func (b *Book) publish(side pkg.OrderSide, price, amount decimal.Decimal) {
 	if side == pkg.SideBuy {
-		for _, o := range n.BookCache.Bids {
+		for _, o := range b.Bids {
 			if o[0].Equal(price) {
 				o[1] = amount
 
@@ -102,9 +232,9 @@ func (n *Notification) Publish(side pkg.OrderSide, price, amount decimal.Decimal
 			}
 		}
 
-		n.BookCache.Bids = append(n.BookCache.Bids, []decimal.Decimal{price, amount})
+		b.Bids = append(b.Bids, []decimal.Decimal{price, amount})
 	} else {
-		for _, o := range n.BookCache.Asks {
+		for _, o := range b.Asks {
 			if o[0].Equal(price) {
 				o[1] = amount
 
@@ -112,6 +242,51 @@ func (n *Notification) Publish(side pkg.OrderSide, price, amount decimal.Decimal
 			}
 		}
 
-		n.BookCache.Asks = append(n.BookCache.Asks, []decimal.Decimal{price, amount})
+		b.Asks = append(b.Asks, []decimal.Decimal{price, amount})
 	}
 }
+
+// Hold keeps the next changes out of the frames until they're released, a book accumulating a batch
+// doesn't show the orders crossing each other before they're uncrossed.
+func (n *Notification) Hold() {
+	n.NotifyMutex.Lock()
+	defer n.NotifyMutex.Unlock()
+
+	n.held = true
+}
+
+// Release lets the changes cached so far into the next frame, the notification stays held for the next changes
+// unless unhold is set.
+func (n *Notification) Release(unhold bool) {
+	n.NotifyMutex.Lock()
+	defer n.NotifyMutex.Unlock()
+
+	n.release()
+	if unhold {
+		n.held = false
+	}
+}
+
+// release moves the cached changes to the released ones with the NotifyMutex held.
+func (n *Notification) release() {
+	if len(n.BookCache.Asks) == 0 && len(n.BookCache.Bids) == 0 {
+		return
+	}
+
+	if n.released == nil {
+		n.released = &Book{
+			Asks: make([][]decimal.Decimal, 0),
+			Bids: make([][]decimal.Decimal, 0),
+		}
+	}
+
+	for _, level := range n.BookCache.Bids {
+		n.released.publish(pkg.SideBuy, level[0], level[1])
+	}
+	for _, level := range n.BookCache.Asks {
+		n.released.publish(pkg.SideSell, level[0], level[1])
+	}
+
+	n.BookCache.Asks = make([][]decimal.Decimal, 0)
+	n.BookCache.Bids = make([][]decimal.Decimal, 0)
+}
@@ -0,0 +1,55 @@
+package matching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/events"
+)
+
+func TestNotificationFlush(t *testing.T) {
+	notification := newNotification(testSymbol)
+	if depth := notification.flush(); depth != nil {
+		t.Fatalf("expected no frame without changes, got %+v", depth)
+	}
+
+	notification.Publish(pkg.SideBuy, decimal.NewFromInt(10), decimal.NewFromInt(1))
+	notification.Publish(pkg.SideBuy, decimal.NewFromInt(10), decimal.NewFromInt(3))
+	notification.Publish(pkg.SideSell, decimal.NewFromInt(11), decimal.Zero)
+
+	depth := notification.flush()
+	if depth == nil || depth.Sequence != 1 {
+		t.Fatalf("expected the first frame, got %+v", depth)
+	}
+
+	if len(depth.Bids) != 1 || !depth.Bids[0][1].Equal(decimal.NewFromInt(3)) || len(depth.Asks) != 1 {
+		t.Errorf("expected the last amount of each level, got %+v", depth)
+	}
+
+	if depth := notification.flush(); depth != nil {
+		t.Errorf("expected the changes to be taken by the frame, got %+v", depth)
+	}
+}
+
+func TestDepthBatchKeepsTheLastSequence(t *testing.T) {
+	notification := newNotification(testSymbol)
+	batcher := events.NewStreamBatcher("depth", 50*time.Millisecond)
+
+	for i := int64(1); i <= 3; i++ {
+		notification.Publish(pkg.SideSell, decimal.NewFromInt(100+i), decimal.NewFromInt(i))
+		depth := notification.flush()
+		batcher.Add("btcusdt", depth.Sequence, depth)
+	}
+
+	_, batches := batcher.Flush()
+	if len(batches) != 1 || len(batches[0].Updates) != 3 || batches[0].Sequence != 3 {
+		t.Fatalf("expected one frame with the 3 diffs up to sequence 3, got %+v", batches)
+	}
+
+	if first := batches[0].Updates[0].(*pkg.DepthJSON); first.Sequence != 1 {
+		t.Errorf("expected the diffs in order, got sequence %d first", first.Sequence)
+	}
+}
@@ -0,0 +1,109 @@
+package matching
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/events"
+)
+
+// ProcessBatch submits the orders and cancels the orders of the cancels of a batch in one cycle, in their order,
+// and publishes the outcome of each of them. The orders are checked as the engine checks the orders it's submitted,
+// one rejected doesn't stop the others.
+func (e *Engine) ProcessBatch(batch *events.MatchingBatch) []*events.BatchEntry {
+	started_at := time.Now()
+
+	e.MatchingMutex.Lock()
+	defer e.MatchingMutex.Unlock()
+
+	entries, cascade_depth := e.OrderBook.ProcessBatch(batch, e.SizeLimits)
+
+	submitted := 0
+	for _, entry := range entries {
+		if entry.Action == pkg.ActionSubmit {
+			submitted++
+		}
+	}
+
+	e.Metrics.Observe(Cycle{
+		Action:       events.ActionBatch,
+		Latency:      time.Since(started_at),
+		CascadeDepth: cascade_depth,
+		Submitted:    submitted,
+	})
+
+	return entries
+}
+
+// ProcessBatch processes the entries of batch, orders outside limits are cancelled with the order_size reason.
+// A submit is accepted unless the book cancelled its order without matching any of it, the reason it cancelled
+// it is kept. A cancel is accepted when it took its order out of the book.
+func (ob *OrderBook) ProcessBatch(batch *events.MatchingBatch, limits OrderSizeLimits) (entries []*events.BatchEntry, cascade_depth int) {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	recorder := &batchRecorder{Publisher: ob.publisher, cancels: make(map[int64]CancelReason)}
+	ob.publisher = recorder
+	defer func() { ob.publisher = recorder.Publisher }()
+
+	entries = make([]*events.BatchEntry, 0, len(batch.Entries))
+	for _, command := range batch.Entries {
+		switch {
+		case command.Action == pkg.ActionSubmit && command.Order != nil:
+			o := command.Order
+			entry := &events.BatchEntry{Action: pkg.ActionSubmit, ID: o.ID, UUID: o.UUID}
+
+			switch {
+			case o.Price.IsNegative() || o.StopPrice.IsNegative():
+				ob.PublishCancel(o.Key(), CancelReasonInvalidPrice)
+			case !limits.Accept(o):
+				ob.PublishCancel(o.Key(), CancelReasonOrderSize)
+			default:
+				if depth := ob.insert(o, command.Options); depth > cascade_depth {
+					cascade_depth = depth
+				}
+			}
+
+			reason, cancelled := recorder.cancels[o.ID]
+			entry.Accepted = !cancelled || o.FilledQuantity.GreaterThan(decimal.Zero)
+			entry.Reason = string(reason)
+			entries = append(entries, entry)
+		case command.Key != nil:
+			key := command.Key
+			entry := &events.BatchEntry{Action: pkg.ActionCancel, ID: key.ID, UUID: key.UUID}
+
+			switch ob.cancel(key, command.CommandID) {
+			case CancelOutcomeCancelled:
+				entry.Accepted = true
+			case CancelOutcomeAlreadyGone:
+				entry.Reason = string(CancelReasonAlreadyGone)
+			case CancelOutcomeRejected:
+				entry.Reason = string(CancelReasonHalted)
+			default:
+				entry.Reason = string(CancelReasonNeverExisted)
+			}
+
+			entries = append(entries, entry)
+		}
+	}
+
+	recorder.Publisher.PublishBatch(batch.ID, entries)
+
+	return entries, cascade_depth
+}
+
+// batchRecorder keeps the reasons the book cancelled the orders of a batch with while it's processed.
+type batchRecorder struct {
+	Publisher
+	cancels map[int64]CancelReason
+}
+
+func (r *batchRecorder) PublishCancel(key *pkg.OrderKey, reason CancelReason) {
+	if _, found := r.cancels[key.ID]; !found {
+		r.cancels[key.ID] = reason
+	}
+
+	r.Publisher.PublishCancel(key, reason)
+}
@@ -0,0 +1,80 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/events"
+)
+
+func submitEntry(o *pkg.Order, options *events.OrderOptions) *events.MatchingPayload {
+	return &events.MatchingPayload{MatchingPayloadMessage: pkg.MatchingPayloadMessage{Action: pkg.ActionSubmit, Order: o}, Options: options}
+}
+
+func cancelEntry(o *pkg.Order) *events.MatchingPayload {
+	return &events.MatchingPayload{MatchingPayloadMessage: pkg.MatchingPayloadMessage{Action: pkg.ActionCancelWithKey, Key: o.Key()}}
+}
+
+func TestProcessBatch(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	engine := newEngine(testSymbol, ob, 0)
+	engine.SizeLimits = OrderSizeLimits{MinAmount: decimal.RequireFromString("0.1")}
+
+	resting := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	gone := newTestOrder(pkg.SideSell, pkg.TypeLimit, "102", "1")
+	engine.Submit(resting)
+	engine.Submit(gone)
+	engine.Cancel(gone)
+
+	post_only := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1")
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "2")
+	too_small := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "0.01")
+	quote := newTestOrder(pkg.SideSell, pkg.TypeLimit, "105", "1")
+
+	entries := engine.ProcessBatch(&events.MatchingBatch{ID: "batch-1", Entries: []*events.MatchingPayload{
+		submitEntry(post_only, &events.OrderOptions{PostOnly: true}),
+		submitEntry(taker, nil),
+		submitEntry(too_small, nil),
+		cancelEntry(gone),
+		submitEntry(quote, nil),
+		cancelEntry(quote),
+	}})
+
+	expected := []struct {
+		id       int64
+		accepted bool
+		reason   string
+	}{
+		{post_only.ID, false, string(CancelReasonPostOnly)},
+		{taker.ID, true, ""},
+		{too_small.ID, false, string(CancelReasonOrderSize)},
+		{gone.ID, false, string(CancelReasonAlreadyGone)},
+		{quote.ID, true, ""},
+		{quote.ID, true, ""},
+	}
+
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(entries))
+	}
+
+	for i, e := range expected {
+		if entries[i].ID != e.id || entries[i].Accepted != e.accepted || entries[i].Reason != e.reason {
+			t.Errorf("entry %d: expected %+v, got %+v", i, e, entries[i])
+		}
+	}
+
+	// the taker matched the resting order in the batch and rests with what it has left
+	if len(publisher.Trades) != 1 || publisher.Trades[0].MakerOrder.ID != resting.ID || ob.Depth.Get(taker.Key()) == nil {
+		t.Errorf("expected the taker to match the resting order, got %d trades", len(publisher.Trades))
+	}
+
+	if len(publisher.Batches) != 1 || len(publisher.Batches[0]) != len(expected) {
+		t.Errorf("expected the batch result to be published once, got %v", publisher.Batches)
+	}
+
+	if ob.Depth.Get(quote.Key()) != nil {
+		t.Error("expected the order cancelled later in the batch to be out of the book")
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/emirpasic/gods/trees/redblacktree"
 	"github.com/shopspring/decimal"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/zsmartex/finex/clock"
 	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/types"
 	"github.com/zsmartex/pkg"
 	GrpcEngine "github.com/zsmartex/pkg/Grpc/engine"
 	GrpcOrder "github.com/zsmartex/pkg/Grpc/order"
@@ -27,8 +31,82 @@ type OrderBook struct {
 	Depth              *Depth
 	StopBids           *redblacktree.Tree
 	StopAsks           *redblacktree.Tree
+	PriceLimit         *PriceLimit
 	pendingOrdersQueue *OrderQueue
 	quantexClient      *clientQuantex.GrpcQuantexClient
+	Flags              FeatureFlags
+	publisher          Publisher
+	clock              clock.Clock
+	configVersion      int64
+	// listing holds the book until the market opens, nil once it's open
+	listing      *ListingSchedule
+	listingTimer clock.Timer
+	// batch accumulates the orders of the book between its batch auctions, nil while it matches continuously
+	batch *batchAuction
+	// auction holds the orders of the book until its call auction is uncrossed, nil while it matches
+	auction *callAuction
+	// options are the options of the orders of the book submitted with some, by order id
+	options map[int64]*events.OrderOptions
+	// tradingState is what the book takes, see SetTradingState, empty takes everything
+	tradingState types.TradingState
+	// trailing are the trailing stops waiting in the stop orders, by order id
+	trailing map[int64]*pkg.Order
+	// sizeLimits are the size limits of the market, the orders partially filled below its minimum notional are cancelled
+	sizeLimits OrderSizeLimits
+	// departed are the last orders which left the book, a cancel of one of them finds it already gone
+	departed *departures
+	// admitted are the last orders submitted to the book, a submit of one of them again is dropped
+	admitted *departures
+	// expiring are the good-till-date orders of the book and of the stop orders, by order id
+	expiring       map[int64]*pkg.Order
+	expiryInterval time.Duration
+	expiryTimer    clock.Timer
+	// fees are the rates the fees of the trades are computed with
+	fees FeeSchedule
+	// breaker keeps the trade prices of the window of the circuit breaker of the book
+	breaker *circuitBreaker
+	// indexPrice is the last index price pushed to the book, zero before the first one
+	indexPrice decimal.Decimal
+	// indexStopBids and indexStopAsks are the stop orders triggered by the index price
+	indexStopBids *redblacktree.Tree
+	indexStopAsks *redblacktree.Tree
+	// ticker is the snapshot of the best prices and the last trade the readers off the matching path load
+	ticker *ticker
+	// metrics are the Prometheus metrics of the market
+	metrics *marketMetrics
+	// tape is the ring of the latest trades the readers off the matching path load
+	tape *tradeTape
+	// tradeSequence is the sequence of the last trade of the book, see TradeSequence
+	tradeSequence int64
+}
+
+// OrderBookConfig holds the market settings an orderbook is built with.
+type OrderBookConfig struct {
+	// DailyPriceLimit is the allowed deviation ratio from the previous UTC day close, zero disables it.
+	DailyPriceLimit decimal.Decimal
+	// PreviousClose is the close of the previous UTC day, the market price is used when it's zero.
+	PreviousClose decimal.Decimal
+	Flags         FeatureFlags
+	// SlowCycleThreshold is the matching cycle latency above which the engine logs the cycle, zero disables it.
+	SlowCycleThreshold time.Duration
+	SizeLimits         OrderSizeLimits
+	// BatchInterval makes the book match in batch auctions of the interval, zero matches continuously.
+	BatchInterval time.Duration
+	// Clock is the time of the listings, the batches, the daily price limit and the trades, the wall clock when it's nil.
+	Clock clock.Clock
+	// ConfigVersion is the version of the market configuration the book is built with, it's stamped on its trades.
+	ConfigVersion int64
+	// Publisher delivers the output of the book, the Kafka publisher when it's nil.
+	Publisher Publisher
+	// ExpirySweepInterval is the time between two sweeps of the expired orders, zero only cancels them when the
+	// matching reaches them.
+	ExpirySweepInterval time.Duration
+	// Fees are the maker and the taker rates of the market, the trades are published with their fees
+	Fees FeeSchedule
+	// CircuitBreaker makes the book take cancels only for a while when its price moves too fast
+	CircuitBreaker CircuitBreakerConfig
+	// TradeSequence is the sequence of the last trade of the market, the trades of the book are numbered after it
+	TradeSequence int64
 }
 
 const (
@@ -36,32 +114,33 @@ const (
 	pendingOrdersCap int64 = 1024
 )
 
-// StopComparator is used for comparing Key.
+// StopComparator is used for comparing Key, the stop order triggered first is the greatest: the sell stop with
+// the highest stop price as the price falls and the buy stop with the lowest stop price as it rises. Keys of
+// different sides can't be ordered, it panics with an InconsistencyError.
 func StopComparator(a, b interface{}) (result int) {
 	this := a.(*pkg.OrderKey)
 	that := b.(*pkg.OrderKey)
 
-	if this.Side != that.Side {
-		config.Logger.Errorf("[oceanbook.orderbook] compare order with different sides")
+	if this.Side != that.Side || !validSide(this.Side) {
+		panic(inconsistency("stop orders %d and %d of sides %q and %q compared", this.ID, that.ID, this.Side, that.Side))
 	}
 
 	if this.ID == that.ID {
 		return
 	}
 
-	// based on ask
 	switch {
-	case this.Side == pkg.SideSell && this.StopPrice.LessThan(that.StopPrice):
+	case this.Side == pkg.SideSell && this.StopPrice.GreaterThan(that.StopPrice):
 		result = 1
 
-	case this.Side == pkg.SideSell && this.StopPrice.GreaterThan(that.StopPrice):
+	case this.Side == pkg.SideSell && this.StopPrice.LessThan(that.StopPrice):
 		result = -1
 
 	case this.Side == pkg.SideBuy && this.StopPrice.LessThan(that.StopPrice):
-		result = -1
+		result = 1
 
 	case this.Side == pkg.SideBuy && this.StopPrice.GreaterThan(that.StopPrice):
-		result = 1
+		result = -1
 
 	default:
 		if this.CreatedAt.Before(that.CreatedAt) {
@@ -74,7 +153,7 @@ func StopComparator(a, b interface{}) (result int) {
 	return
 }
 
-func NewOrderBook(symbol pkg.Symbol, market_price decimal.Decimal) *OrderBook {
+func NewOrderBook(symbol pkg.Symbol, market_price decimal.Decimal, book_config OrderBookConfig) *OrderBook {
 	var quantex_client *clientQuantex.GrpcQuantexClient
 	quantexEnabled, _ := strconv.ParseBool(os.Getenv("QUANTEX_ENABLED"))
 
@@ -82,14 +161,73 @@ func NewOrderBook(symbol pkg.Symbol, market_price decimal.Decimal) *OrderBook {
 		quantex_client = clientQuantex.NewQuantexClient()
 	}
 
+	publisher := book_config.Publisher
+	if publisher == nil {
+		publisher = NewBusPublisher(symbol)
+	}
+
+	ob := newOrderBook(symbol, market_price, book_config, NewNotification(symbol), publisher)
+	ob.quantexClient = quantex_client
+	ob.Depth.Notification.Start()
+
+	return ob
+}
+
+func newOrderBook(symbol pkg.Symbol, market_price decimal.Decimal, book_config OrderBookConfig, notification *Notification, publisher Publisher) *OrderBook {
+	book_clock := book_config.Clock
+	if book_clock == nil {
+		book_clock = clock.Real
+	}
+
+	reference_close := book_config.PreviousClose
+	if reference_close.IsZero() {
+		reference_close = market_price
+	}
+
 	ob := &OrderBook{
-		Symbol:             symbol,
-		MarketPrice:        market_price,
-		Depth:              NewDepth(symbol),
-		StopBids:           redblacktree.NewWith(StopComparator),
-		StopAsks:           redblacktree.NewWith(StopComparator),
+		Symbol:      symbol,
+		MarketPrice: market_price,
+		Depth:       newDepth(symbol, notification, book_config.Flags),
+		StopBids:    redblacktree.NewWith(StopComparator),
+		StopAsks:    redblacktree.NewWith(StopComparator),
+		PriceLimit: &PriceLimit{
+			Rate:           book_config.DailyPriceLimit,
+			ReferenceClose: reference_close,
+		},
 		pendingOrdersQueue: NewOrderQueue(pendingOrdersCap),
-		quantexClient:      quantex_client,
+		Flags:              book_config.Flags,
+		publisher:          publisher,
+		clock:              book_clock,
+		configVersion:      book_config.ConfigVersion,
+		sizeLimits:         book_config.SizeLimits,
+		fees:               book_config.Fees,
+		options:            make(map[int64]*events.OrderOptions),
+		trailing:           make(map[int64]*pkg.Order),
+		departed:           newDepartures(departedCapacity),
+		admitted:           newDepartures(departedCapacity),
+		expiring:           make(map[int64]*pkg.Order),
+		breaker:            &circuitBreaker{config: book_config.CircuitBreaker},
+		indexStopBids:      redblacktree.NewWith(StopComparator),
+		indexStopAsks:      redblacktree.NewWith(StopComparator),
+		tradeSequence:      book_config.TradeSequence,
+	}
+
+	ob.ticker = newTicker(market_price)
+	ob.metrics = newMarketMetrics(symbol)
+	ob.tape = &tradeTape{}
+	ob.Depth.ticker = ob.ticker
+	if notification != nil {
+		notification.ticker = ob.ticker.Load
+	}
+
+	ob.PriceLimit.Rollover(book_clock.Now(), market_price)
+
+	if book_config.BatchInterval > 0 {
+		ob.setBatchInterval(book_config.BatchInterval)
+	}
+
+	if book_config.ExpirySweepInterval > 0 {
+		ob.setExpirySweepInterval(book_config.ExpirySweepInterval)
 	}
 
 	return ob
@@ -176,129 +314,445 @@ func (ob *OrderBook) CalcMarketOrder(side pkg.OrderSide, quantity decimal.NullDe
 	}
 }
 
+// LastPrice returns the market price of the book, for the readers off the matching path.
+func (ob *OrderBook) LastPrice() decimal.Decimal {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	return ob.MarketPrice
+}
+
+// setMarketPrice moves the market price to the last trade price and triggers the stop orders it crossed: sell
+// stops when it falls to their stop price and buy stops when it rises to it. The triggered orders are queued to
+// be matched once the order matching now is done, a stop-limit order as a limit order at its price and a
+// stop-market order as a market order.
 func (ob *OrderBook) setMarketPrice(newPrice decimal.Decimal) {
 	previousPrice := ob.MarketPrice
 	ob.MarketPrice = newPrice
 
+	ob.tripCircuitBreaker(newPrice)
+
+	ob.trailStops(types.TriggerByLastPrice, newPrice)
+
+	ob.triggerCrossed(ob.StopAsks, ob.StopBids, previousPrice, newPrice)
+}
+
+// triggerCrossed triggers the stop orders of asks and bids the move of a price from previousPrice to newPrice
+// crossed, nothing is crossed from a zero price.
+func (ob *OrderBook) triggerCrossed(asks, bids *redblacktree.Tree, previousPrice, newPrice decimal.Decimal) {
 	if previousPrice.IsZero() {
 		return
 	}
 
 	switch {
 	case newPrice.LessThan(previousPrice):
-		// price gone done, check stop asks
-		for {
-			best := ob.StopBids.Right()
-			if best == nil {
-				break
-			}
+		// price gone down, check stop asks
+		ob.triggerStops(asks, func(stop_price decimal.Decimal) bool {
+			return stop_price.GreaterThanOrEqual(newPrice)
+		})
 
-			bestOrder := best.Value.(*pkg.Order)
-			if bestOrder.StopPrice.LessThan(newPrice) {
-				break
-			}
+	case newPrice.GreaterThan(previousPrice):
+		// price gone up, check stop bids
+		ob.triggerStops(bids, func(stop_price decimal.Decimal) bool {
+			return stop_price.LessThanOrEqual(newPrice)
+		})
 
-			config.Logger.Debugf("[oceanbook.orderbook] bid order %d with stop price %s enqueued", bestOrder.ID, bestOrder.Price)
+	default:
+		// previous price equals to new price
+		return
+	}
+}
 
-			ob.StopBids.Remove(best.Key)
-			ob.pendingOrdersQueue.Push(bestOrder)
+// triggerStops queues the stop orders of book from the first to trigger while crossed accepts their stop price.
+func (ob *OrderBook) triggerStops(book *redblacktree.Tree, crossed func(stop_price decimal.Decimal) bool) {
+	for {
+		best := book.Right()
+		if best == nil {
+			break
 		}
 
-	case newPrice.GreaterThan(previousPrice):
-		// price gone done, check stop asks
-		for {
-			best := ob.StopAsks.Right()
-			if best == nil {
-				break
-			}
+		bestOrder := best.Value.(*pkg.Order)
+		if !crossed(bestOrder.StopPrice) {
+			break
+		}
 
-			bestOrder := best.Value.(*pkg.Order)
-			if bestOrder.StopPrice.GreaterThan(newPrice) {
-				break
-			}
+		config.Logger.Debugf("[oceanbook.orderbook] %s order %d with stop price %s enqueued", bestOrder.Side, bestOrder.ID, bestOrder.StopPrice)
+
+		book.Remove(best.Key)
+		delete(ob.trailing, bestOrder.ID)
+		ob.pendingOrdersQueue.Push(bestOrder)
+	}
+}
+
+func (ob *OrderBook) Add(o *pkg.Order) {
+	ob.add(o, nil)
+}
 
-			config.Logger.Debugf("[oceanbook.orderbook] ask order %d with stop price %s enqueued", bestOrder.ID, bestOrder.Price)
+// add returns the depth of the stop cascade the order set off,
+// 1 when it only triggered stop orders and 2 when these triggered more stop orders and so on. The submit of an order
+// the book was submitted already is delivered again, it's dropped whether the order still rests or left the book.
+func (ob *OrderBook) add(o *pkg.Order, options *events.OrderOptions) (cascade_depth int) {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
 
-			ob.StopAsks.Remove(best.Key)
-			ob.pendingOrdersQueue.Push(bestOrder)
+	if !o.IsFake() {
+		if ob.admitted.has(o.ID) {
+			config.Logger.Warnf("[oceanbook.orderbook] order %d submitted again, the submit is dropped", o.ID)
+			return 0
 		}
 
-	default:
-		// previous price equals to new price
+		ob.admitted.add(o.ID)
+	}
+
+	return ob.insert(o, options)
+}
+
+// insert adds the order with the orderMutex held, options are kept while the order is in the book. An order the
+// book can't process is rejected, see InconsistencyError.
+func (ob *OrderBook) insert(o *pkg.Order, options *events.OrderOptions) (cascade_depth int) {
+	defer ob.recoverInconsistency(o)
+
+	if err := checkOrder(o); err != nil {
+		ob.rejectInconsistent(o, err)
+		return
+	}
+
+	if reason, rejected := ob.rejectsOrders(); rejected {
+		ob.PublishCancel(o.Key(), reason)
+		return
+	}
+
+	ob.PriceLimit.Rollover(ob.clock.Now(), ob.MarketPrice)
+
+	if ob.expiredOnArrival(options) {
+		ob.cancelUnmatched(o, CancelReasonExpired)
 		return
 	}
+
+	ob.keepOptions(o, options)
+
+	if display := ob.displayQuantity(o); display.IsPositive() {
+		ob.Depth.hide(o, display)
+	}
+
+	if ob.openIfDue() {
+		ob.warmup(o)
+		return
+	}
+
+	if ob.isStop(o) {
+		ob.putStop(o)
+		return
+	}
+
+	if ob.auction != nil || ob.batch != nil {
+		ob.accumulate(o)
+		return
+	}
+
+	return ob.match(o)
 }
 
-func (ob *OrderBook) Add(o *pkg.Order) {
+// putStop keeps a stop order until the market price reaches its stop price, a trailing stop starts trailing
+// the market price.
+func (ob *OrderBook) putStop(o *pkg.Order) {
+	book := ob.stopBookOf(o.ID, o.Side)
+
+	if _, found := book.Get(o.Key()); found {
+		return
+	}
+
+	if offset := ob.trailingOffset(o); offset.IsPositive() {
+		o.StopPrice = trailedStopPrice(o, offset, ob.triggerPrice(ob.triggerBy(o.ID)))
+		ob.trailing[o.ID] = o
+	}
+
+	book.Put(o.Key(), o)
+}
+
+// stopBook returns the stop orders of a side triggered by the price of trigger.
+func (ob *OrderBook) stopBook(side pkg.OrderSide, trigger types.TriggerBy) *redblacktree.Tree {
+	if trigger == types.TriggerByIndexPrice {
+		if side == pkg.SideSell {
+			return ob.indexStopAsks
+		}
+
+		return ob.indexStopBids
+	}
+
+	if side == pkg.SideSell {
+		return ob.StopAsks
+	}
+
+	return ob.StopBids
+}
+
+// stopBookOf returns the stop orders the order of id waits in, by its side and the price it's triggered by.
+func (ob *OrderBook) stopBookOf(id int64, side pkg.OrderSide) *redblacktree.Tree {
+	return ob.stopBook(side, ob.triggerBy(id))
+}
+
+// stopBooks returns every stop orders of the book: the asks then the bids triggered by the last price, then the
+// ones triggered by the index price.
+func (ob *OrderBook) stopBooks() []*redblacktree.Tree {
+	return []*redblacktree.Tree{ob.StopAsks, ob.StopBids, ob.indexStopAsks, ob.indexStopBids}
+}
+
+// match matches the order, then the stop orders it triggered, with the orderMutex held.
+func (ob *OrderBook) match(o *pkg.Order) (cascade_depth int) {
+	if err := ob.Match(o); err != nil {
+		ob.rejectInconsistent(o, err)
+	}
+
+	return ob.matchPending()
+}
+
+// matchPending matches the stop orders triggered, then the ones they triggered and so on, with the orderMutex held.
+func (ob *OrderBook) matchPending() (cascade_depth int) {
+	// stop orders triggered while matching the previous generation are matched as the next one
+	for ob.pendingOrdersQueue.Size() > 0 {
+		cascade_depth++
+
+		pendingOrders := ob.pendingOrdersQueue.Values()
+		ob.pendingOrdersQueue.Clear()
+
+		for i := range pendingOrders {
+			pendingOrder := pendingOrders[i]
+
+			config.Logger.Debugf("[oceanbook.orderbook] insert stop order with id %d - %s * %s, side %s", pendingOrder.ID, pendingOrder.Price, pendingOrder.Quantity, pendingOrder.Side)
+
+			if err := ob.Match(pendingOrder); err != nil {
+				ob.rejectInconsistent(pendingOrder, err)
+			}
+		}
+	}
+
+	return
+}
+
+// Remove cancels the order of key for a cancel command without an id, see Cancel.
+func (ob *OrderBook) Remove(key *pkg.OrderKey) CancelOutcome {
+	return ob.Cancel(key, "")
+}
+
+// CancelReplace removes the order of replaced_key and adds its replacement without letting another command
+// in between. The replacement is rejected when the order isn't in the book anymore or doesn't match it.
+func (ob *OrderBook) CancelReplace(replaced_key *pkg.OrderKey, o *pkg.Order, options *events.OrderOptions) (accepted bool, cascade_depth int) {
 	ob.orderMutex.Lock()
 	defer ob.orderMutex.Unlock()
 
-	if o.StopPrice.IsPositive() {
-		var book *redblacktree.Tree
-		switch o.Side {
-		case pkg.SideSell:
-			book = ob.StopAsks
-		case pkg.SideBuy:
-			book = ob.StopBids
+	_, rejected := ob.rejectsOrders()
+	accepted = !rejected && validReplacement(replaced_key, o) && ob.removeOrder(replaced_key)
+
+	ob.publisher.PublishReplace(replaced_key, o, accepted)
+	if !accepted {
+		return
+	}
+
+	return true, ob.insert(o, options)
+}
+
+// validReplacement reports whether o can replace the order of key, a replacement keeps the market and the side.
+func validReplacement(key *pkg.OrderKey, o *pkg.Order) bool {
+	if key.Fake || o.IsFake() || key.ID == o.ID {
+		return false
+	}
+
+	if key.Symbol != o.Symbol || key.Side != o.Side || !validSide(key.Side) {
+		return false
+	}
+
+	return !o.Price.IsNegative() && !o.StopPrice.IsNegative() && o.Quantity.IsPositive()
+}
+
+// removeOrder takes an order out of the book or out of the stop orders with the orderMutex held.
+func (ob *OrderBook) removeOrder(key *pkg.OrderKey) bool {
+	// the stop orders are found by their options, before they're forgotten
+	stop_book := ob.stopBookOf(key.ID, key.Side)
+	ob.forget(key.ID)
+
+	// the stop price of a trailing stop moved since it was placed
+	if o, found := ob.trailing[key.ID]; found && o.Side == key.Side {
+		delete(ob.trailing, key.ID)
+		stop_book.Remove(o.Key())
+
+		return true
+	}
+
+	// a market order of a batch or of an auction waits for the uncross out of the book
+	if waiting := ob.waiting(); waiting != nil {
+		for i, o := range *waiting {
+			if o.ID == key.ID {
+				*waiting = append((*waiting)[:i], (*waiting)[i+1:]...)
+
+				return true
+			}
 		}
+	}
 
-		_, found := book.Get(o.Key())
-		if found {
-			return
+	if key.StopPrice.IsPositive() {
+		if _, found := stop_book.Get(key); found {
+			stop_book.Remove(key)
+
+			return true
 		}
+	}
+
+	ob.matchMutex.Lock()
+	defer ob.matchMutex.Unlock()
 
-		book.Put(o.Key(), o)
+	return ob.Depth.Remove(key)
+}
 
-		return
+// StopOrders returns the stop orders waiting for their stop price, the asks then the bids triggered by the last
+// price, then the ones triggered by the index price.
+func (ob *OrderBook) StopOrders() []*pkg.Order {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	orders := make([]*pkg.Order, 0, ob.StopAsks.Size()+ob.StopBids.Size()+ob.indexStopAsks.Size()+ob.indexStopBids.Size())
+	for _, book := range ob.stopBooks() {
+		for _, order := range book.Values() {
+			orders = append(orders, order.(*pkg.Order))
+		}
 	}
 
-	ob.Match(o)
+	return orders
+}
 
-	pendingOrders := ob.pendingOrdersQueue.Values()
-	for i := range pendingOrders {
-		pendingOrder := pendingOrders[i]
+func (ob *OrderBook) PublishCancel(key *pkg.OrderKey, reason CancelReason) {
+	ob.departed.add(key.ID)
+	ob.publisher.PublishCancel(key, reason)
+	ob.metrics.cancel(reason)
+}
 
-		config.Logger.Debugf("[oceanbook.orderbook] insert stop order with id %d - %s * %s, side %s", pendingOrder.ID, pendingOrder.Price, pendingOrder.Quantity, pendingOrder.Side)
+func (ob *OrderBook) PublishDecrement(key *pkg.OrderKey, quantity decimal.Decimal, reason CancelReason) {
+	ob.publisher.PublishDecrement(key, quantity, reason)
+}
 
-		ob.Match(pendingOrder)
+// SetCancelOnly makes the book reject the orders submitted to it until it's unset, the orders in it stay
+// and can be cancelled. The engine sets it once it loaded the orders of the book.
+func (ob *OrderBook) SetCancelOnly(cancel_only bool) {
+	if cancel_only {
+		ob.SetTradingState(types.TradingStateCancelOnly)
+	} else {
+		ob.SetTradingState(types.TradingStateTrading)
 	}
-	ob.pendingOrdersQueue.Clear()
 }
 
-func (ob *OrderBook) Remove(key *pkg.OrderKey) {
+// CancelOnly reports whether the book rejects the orders submitted to it.
+func (ob *OrderBook) CancelOnly() bool {
+	return ob.TradingState() != types.TradingStateTrading
+}
+
+// Options returns the options the order of id was submitted with, nil when it had none or left the book.
+func (ob *OrderBook) Options(id int64) *events.OrderOptions {
 	ob.orderMutex.Lock()
-	ob.matchMutex.Lock()
 	defer ob.orderMutex.Unlock()
-	defer ob.matchMutex.Unlock()
 
-	ob.Depth.Remove(key)
+	return ob.options[id]
+}
 
-	if !key.Fake {
-		ob.PublishCancel(key)
+// optionsOf returns the options the order was submitted with, the zero options when it had none.
+func (ob *OrderBook) optionsOf(o *pkg.Order) *events.OrderOptions {
+	if options, found := ob.options[o.ID]; found {
+		return options
 	}
+
+	return &events.OrderOptions{}
 }
 
-func (ob *OrderBook) PublishCancel(key *pkg.OrderKey) {
-	config.KafkaProducer.Produce("order_processor", map[string]interface{}{
-		"action": pkg.ActionCancel,
-		"id":     key.ID,
-	})
+// forget drops the options of the order of id and the slice it shows once it left the book, with the orderMutex held.
+func (ob *OrderBook) forget(id int64) {
+	delete(ob.options, id)
+	delete(ob.expiring, id)
+	ob.Depth.forget(id)
 }
 
-func (ob *OrderBook) Match(order *pkg.Order) {
+// displayQuantity returns the slice an iceberg limit order shows, zero when the order shows all its quantity.
+func (ob *OrderBook) displayQuantity(o *pkg.Order) decimal.Decimal {
+	display := ob.optionsOf(o).DisplayQuantity
+	if o.Type != pkg.TypeLimit || display == nil || !display.IsPositive() || !display.LessThan(o.Quantity) {
+		return decimal.Zero
+	}
+
+	return *display
+}
+
+// crosses reports whether the limit order o would trade against the best order of offers.
+func crosses(o *pkg.Order, offers *redblacktree.Tree) bool {
+	best := offers.Right()
+	if best == nil {
+		return false
+	}
+
+	price_level := best.Value.(*PriceLevel)
+	if price_level.Size() == 0 {
+		return false
+	}
+
+	return o.IsCrossed(price_level.Top().Price)
+}
+
+// rejectPostOnly cancels a post-only limit order which would cross offers, it reports whether it did.
+// Nothing of the order is matched, even when only a part of it would cross.
+func (ob *OrderBook) rejectPostOnly(o *pkg.Order, offers *redblacktree.Tree) bool {
+	if o.Type != pkg.TypeLimit || !ob.optionsOf(o).PostOnly || !crosses(o, offers) {
+		return false
+	}
+
+	config.Logger.Debugf("[oceanbook.orderbook] post-only order %d with price %s would take liquidity", o.ID, o.Price)
+
+	ob.forget(o.ID)
+	if !o.IsFake() {
+		ob.PublishCancel(o.Key(), CancelReasonPostOnly)
+	}
+
+	return true
+}
+
+// Match matches order against the offers of the book and rests what a limit order has left, it returns an
+// InconsistencyError when the book holds an order of the side of order among its offers.
+func (ob *OrderBook) Match(order *pkg.Order) error {
 	ob.matchMutex.Lock()
 	defer ob.matchMutex.Unlock()
 	var offers *redblacktree.Tree
 
+	if order.Type == pkg.TypeLimit && !ob.PriceLimit.Accept(order.Price) {
+		config.Logger.Debugf("[oceanbook.orderbook] order %d with price %s rejected by the price limit", order.ID, order.Price)
+
+		ob.forget(order.ID)
+		if !order.IsFake() {
+			ob.PublishCancel(order.Key(), CancelReasonPriceLimit)
+		}
+		return nil
+	}
+
+	// a stop order can reach its stop price after its expiry
+	if ob.expired(order) {
+		ob.cancelUnmatched(order, CancelReasonExpired)
+		return nil
+	}
+
 	if order.IsAsk() {
 		offers = ob.Depth.Bids
 	} else {
 		offers = ob.Depth.Asks
 	}
 
+	if ob.rejectPostOnly(order, offers) || ob.rejectFillOrKill(order, offers) {
+		return nil
+	}
+
+	collar := ob.collar(order, offers)
+	budget, spends := ob.quoteQuantity(order)
+
 	for {
 		best := offers.Right()
 		if best == nil {
+			if spends {
+				ob.stopSpending(order)
+			}
 			break
 		}
 
@@ -308,8 +762,18 @@ func (ob *OrderBook) Match(order *pkg.Order) {
 		}
 
 		counter_order := price_level.Top()
+		if counter_order.Side == order.Side {
+			return inconsistency("order %d of side %s offered to order %d of the same side", counter_order.ID, counter_order.Side, order.ID)
+		}
+
+		// an expired order never trades, it's cancelled once it comes to the top of its level
+		if ob.expired(counter_order) {
+			ob.cancelResting(counter_order, CancelReasonExpired)
+			continue
+		}
 
-		quantity := decimal.Min(order.UnfilledQuantity(), counter_order.UnfilledQuantity())
+		// an iceberg is matched a slice at a time, the next slice queued behind the orders of its level
+		quantity := decimal.Min(order.UnfilledQuantity(), ob.Depth.matchable(counter_order))
 
 		if order.Type == pkg.TypeLimit {
 			if !order.IsCrossed(counter_order.Price) {
@@ -317,107 +781,165 @@ func (ob *OrderBook) Match(order *pkg.Order) {
 			}
 		}
 
+		// the rest of a market order is cancelled rather than matched past its collar
+		if beyondCollar(order, collar, counter_order.Price) {
+			if !order.IsFake() {
+				ob.PublishCancel(order.Key(), CancelReasonSlippage)
+			}
+			break
+		}
+
+		if ob.selfTrade(order, counter_order) {
+			if ob.preventSelfTrade(order, counter_order) {
+				return nil
+			}
+			continue
+		}
+
+		price, found := ob.PriceLimit.TradePrice(order.Side, counter_order.Price)
+		if !found {
+			if order.Type == pkg.TypeMarket && !order.IsFake() {
+				ob.PublishCancel(order.Key(), CancelReasonPriceLimit)
+			}
+			break
+		}
+
+		// a market buy of a quote quantity buys what the rest of its quote quantity affords at the price
+		if spends {
+			quantity = decimal.Min(quantity, ob.sizeLimits.affordable(budget, price))
+			if !quantity.IsPositive() {
+				ob.stopSpending(order)
+				break
+			}
+			budget = budget.Sub(price.Mul(quantity))
+		}
+
 		order.Fill(quantity)
 		counter_order.Fill(quantity)
 
-		if counter_order.Filled() || counter_order.Cancelled {
-			ob.Depth.Remove(counter_order.Key())
+		// a maker left with dust is cancelled once its trade is published
+		dust_reason, dust := ob.sizeLimits.Dust(counter_order)
+
+		// the key of the maker is built once, for its removal and its cancel
+		var counter_key *pkg.OrderKey
+		if counter_order.Filled() || counter_order.Cancelled || dust {
+			counter_key = counter_order.Key()
+			ob.Depth.Remove(counter_key)
+			ob.forget(counter_order.ID)
 		} else {
+			ob.Depth.fill(counter_order, quantity, ob.clock.Now())
 			ob.Depth.Add(counter_order)
 		}
-		ob.setMarketPrice(counter_order.Price)
+		ob.setMarketPrice(price)
 
 		if counter_order.IsFake() {
-			if _, err := ob.quantexClient.UpdateOrder(&GrpcQuantex.UpdateOrderRequest{
-				Order: &GrpcOrder.Order{
-					Id:       counter_order.ID,
-					Uuid:     counter_order.UUID[:],
-					MemberId: counter_order.MemberID,
-					Symbol:   &GrpcSymbol.Symbol{BaseCurrency: counter_order.Symbol.BaseCurrency, QuoteCurrency: counter_order.Symbol.QuoteCurrency},
-					Side:     string(counter_order.Side),
-					Type:     string(counter_order.Type),
-					Price: &GrpcUtils.Decimal{
-						Val: counter_order.Price.CoefficientInt64(),
-						Exp: counter_order.Price.Exponent(),
-					},
-					StopPrice: &GrpcUtils.Decimal{
-						Val: counter_order.StopPrice.CoefficientInt64(),
-						Exp: counter_order.StopPrice.Exponent(),
-					},
-					Quantity: &GrpcUtils.Decimal{
-						Val: counter_order.Quantity.CoefficientInt64(),
-						Exp: counter_order.Quantity.Exponent(),
-					},
-					FilledQuantity: &GrpcUtils.Decimal{
-						Val: counter_order.FilledQuantity.CoefficientInt64(),
-						Exp: counter_order.FilledQuantity.Exponent(),
-					},
-					Fake:      counter_order.Fake,
-					Cancelled: counter_order.Cancelled,
-					CreatedAt: timestamppb.New(counter_order.CreatedAt),
-				},
-			}); err != nil {
-				config.Logger.Errorf("[orderbook] update order %d failed: %s", counter_order.ID, err)
-			}
+			ob.updateQuantexOrder(counter_order)
 		}
 
-		trade := &pkg.Trade{
-			Symbol:   ob.Symbol,
-			Price:    counter_order.Price,
-			Quantity: quantity,
-			Total:    counter_order.Price.Mul(quantity),
-		}
+		ob.publishTrade(order, counter_order, price, quantity)
 
-		ob.PublishTrade(order, counter_order, trade)
+		if dust {
+			ob.PublishCancel(counter_key, dust_reason)
+		}
 
 		if order.Filled() {
-			return
+			ob.forget(order.ID)
+			return nil
 		}
 	}
 
 	if order.UnfilledQuantity().IsPositive() && order.Type == pkg.TypeLimit {
+		if !ob.optionsOf(order).Rests() {
+			ob.cancelUnmatched(order, CancelReasonImmediateOrCancel)
+			return nil
+		}
+
+		if reason, dust := ob.sizeLimits.Dust(order); dust {
+			ob.cancelUnmatched(order, reason)
+			return nil
+		}
+
 		ob.Depth.Add(order)
 		if order.IsFake() {
-			if _, err := ob.quantexClient.UpdateOrder(&GrpcQuantex.UpdateOrderRequest{
-				Order: &GrpcOrder.Order{
-					Id:       order.ID,
-					Uuid:     order.UUID[:],
-					MemberId: order.MemberID,
-					Symbol:   &GrpcSymbol.Symbol{BaseCurrency: order.Symbol.BaseCurrency, QuoteCurrency: order.Symbol.QuoteCurrency},
-					Side:     string(order.Side),
-					Type:     string(order.Type),
-					Price: &GrpcUtils.Decimal{
-						Val: order.Price.CoefficientInt64(),
-						Exp: order.Price.Exponent(),
-					},
-					StopPrice: &GrpcUtils.Decimal{
-						Val: order.StopPrice.CoefficientInt64(),
-						Exp: order.StopPrice.Exponent(),
-					},
-					Quantity: &GrpcUtils.Decimal{
-						Val: order.Quantity.CoefficientInt64(),
-						Exp: order.Quantity.Exponent(),
-					},
-					FilledQuantity: &GrpcUtils.Decimal{
-						Val: order.FilledQuantity.CoefficientInt64(),
-						Exp: order.FilledQuantity.Exponent(),
-					},
-					Fake:      order.Fake,
-					Cancelled: order.Cancelled,
-					CreatedAt: timestamppb.New(order.CreatedAt),
-				},
-			}); err != nil {
-				config.Logger.Errorf("[orderbook] update order %d failed: %s", order.ID, err)
-			}
+			ob.updateQuantexOrder(order)
 		}
+	} else {
+		ob.forget(order.ID)
+		ob.departed.add(order.ID)
 	}
+
+	return nil
+}
+
+// updateQuantexOrder reports the fills of an order of the liquidity provider to it,
+// books without a client, like the detached ones, don't report them.
+func (ob *OrderBook) updateQuantexOrder(order *pkg.Order) {
+	if ob.quantexClient == nil {
+		return
+	}
+
+	if _, err := ob.quantexClient.UpdateOrder(&GrpcQuantex.UpdateOrderRequest{
+		Order: &GrpcOrder.Order{
+			Id:       order.ID,
+			Uuid:     order.UUID[:],
+			MemberId: order.MemberID,
+			Symbol:   &GrpcSymbol.Symbol{BaseCurrency: order.Symbol.BaseCurrency, QuoteCurrency: order.Symbol.QuoteCurrency},
+			Side:     string(order.Side),
+			Type:     string(order.Type),
+			Price: &GrpcUtils.Decimal{
+				Val: order.Price.CoefficientInt64(),
+				Exp: order.Price.Exponent(),
+			},
+			StopPrice: &GrpcUtils.Decimal{
+				Val: order.StopPrice.CoefficientInt64(),
+				Exp: order.StopPrice.Exponent(),
+			},
+			Quantity: &GrpcUtils.Decimal{
+				Val: order.Quantity.CoefficientInt64(),
+				Exp: order.Quantity.Exponent(),
+			},
+			FilledQuantity: &GrpcUtils.Decimal{
+				Val: order.FilledQuantity.CoefficientInt64(),
+				Exp: order.FilledQuantity.Exponent(),
+			},
+			Fake:      order.Fake,
+			Cancelled: order.Cancelled,
+			CreatedAt: timestamppb.New(order.CreatedAt),
+		},
+	}); err != nil {
+		config.Logger.Errorf("[orderbook] update order %d failed: %s", order.ID, err)
+	}
+}
+
+// trades are the trades the books publish, a trade is reused once the publisher returns.
+var trades = sync.Pool{
+	New: func() interface{} {
+		return new(pkg.Trade)
+	},
+}
+
+// publishTrade publishes the trade of quantity at price between order and counter_order.
+func (ob *OrderBook) publishTrade(order, counter_order *pkg.Order, price, quantity decimal.Decimal) {
+	trade := trades.Get().(*pkg.Trade)
+	*trade = pkg.Trade{
+		Symbol:   ob.Symbol,
+		Price:    price,
+		Quantity: quantity,
+		Total:    price.Mul(quantity),
+	}
+
+	ob.PublishTrade(order, counter_order, trade)
+
+	*trade = pkg.Trade{}
+	trades.Put(trade)
 }
 
 func (ob *OrderBook) PublishTrade(order, counter_order *pkg.Order, trade *pkg.Trade) {
 	var maker_order pkg.Order
 	var taker_order pkg.Order
 
-	if order.CreatedAt.Before(counter_order.CreatedAt) {
+	// the slices of an iceberg are queued later than the order was created, it's as old as the order
+	if ob.Depth.createdAt(order).Before(ob.Depth.createdAt(counter_order)) {
 		maker_order = *order
 		taker_order = *counter_order
 	} else {
@@ -428,5 +950,15 @@ func (ob *OrderBook) PublishTrade(order, counter_order *pkg.Order, trade *pkg.Tr
 	trade.MakerOrder = maker_order
 	trade.TakerOrder = taker_order
 
-	config.KafkaProducer.Produce("trade_executor", trade)
+	stamp := TradeStamp{Sequence: ob.nextTradeSequence(), MatchedAt: ob.clock.Now(), ConfigVersion: ob.configVersion, Fees: ob.fees.Fees(trade)}
+	ob.publisher.PublishTrade(trade, stamp)
+	ob.ticker.trade(trade.Price, trade.Quantity)
+	ob.tape.add(trade, stamp)
+	ob.metrics.trade(trade)
+
+	for _, o := range []*pkg.Order{order, counter_order} {
+		if o.Filled() {
+			ob.departed.add(o.ID)
+		}
+	}
 }
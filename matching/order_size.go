@@ -0,0 +1,75 @@
+package matching
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+// OrderSizeLimits are the size limits of the orders of a market. The API validates them first,
+// the engine checks them again so an order which went around the API doesn't reach the book.
+type OrderSizeLimits struct {
+	MinAmount decimal.Decimal
+	// MaxAmount and MaxQuoteAmount don't cap the size of an order when they're zero
+	MaxAmount      decimal.Decimal
+	MaxQuoteAmount decimal.Decimal
+	// LotSize is the step of the quantities and MinNotional the smallest quote amount of the limit orders,
+	// zero doesn't constrain them
+	LotSize     decimal.Decimal
+	MinNotional decimal.Decimal
+	// AmountPrecision is the number of decimal places of the quantities, the market buys of a quote quantity are
+	// rounded to it when the market has no lot size
+	AmountPrecision int32
+}
+
+// Accept reports whether the order fits the limits, the quote amount of market orders isn't known
+// before they're matched so only the API checks it, from the funds it locked.
+func (l OrderSizeLimits) Accept(o *pkg.Order) bool {
+	if o.IsFake() {
+		return true
+	}
+
+	if o.Quantity.LessThan(l.MinAmount) {
+		return false
+	}
+
+	if l.MaxAmount.IsPositive() && o.Quantity.GreaterThan(l.MaxAmount) {
+		return false
+	}
+
+	if l.MaxQuoteAmount.IsPositive() && o.Type == pkg.TypeLimit && o.Price.Mul(o.Quantity).GreaterThan(l.MaxQuoteAmount) {
+		return false
+	}
+
+	if l.LotSize.IsPositive() && !o.Quantity.Mod(l.LotSize).IsZero() {
+		return false
+	}
+
+	if l.MinNotional.IsPositive() && o.Type == pkg.TypeLimit && o.Price.Mul(o.Quantity).LessThan(l.MinNotional) {
+		return false
+	}
+
+	return true
+}
+
+// Dust reports whether what a limit order partially filled has left is less than the minimum amount, or worth less
+// than the minimum notional at its price, with the reason it's cancelled for rather than left in the book.
+func (l OrderSizeLimits) Dust(o *pkg.Order) (CancelReason, bool) {
+	if o.IsFake() || o.Type != pkg.TypeLimit || !o.FilledQuantity.IsPositive() {
+		return "", false
+	}
+
+	remaining := o.UnfilledQuantity()
+	if !remaining.IsPositive() {
+		return "", false
+	}
+
+	if remaining.LessThan(l.MinAmount) {
+		return CancelReasonDust, true
+	}
+
+	if l.MinNotional.IsPositive() && o.Price.Mul(remaining).LessThan(l.MinNotional) {
+		return CancelReasonMinNotional, true
+	}
+
+	return "", false
+}
@@ -0,0 +1,145 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func TestOrderSizeLimits(t *testing.T) {
+	d := decimal.RequireFromString
+	limits := OrderSizeLimits{MinAmount: d("0.1"), MaxAmount: d("100"), MaxQuoteAmount: d("5000")}
+
+	tests := []struct {
+		order *pkg.Order
+		want  bool
+	}{
+		{&pkg.Order{Type: pkg.TypeLimit, Price: d("50"), Quantity: d("0.1")}, true},
+		{&pkg.Order{Type: pkg.TypeLimit, Price: d("50"), Quantity: d("0.09")}, false},
+		{&pkg.Order{Type: pkg.TypeLimit, Price: d("50"), Quantity: d("100")}, true},
+		{&pkg.Order{Type: pkg.TypeLimit, Price: d("50.01"), Quantity: d("100")}, false},
+		{&pkg.Order{Type: pkg.TypeLimit, Price: d("1"), Quantity: d("100.1")}, false},
+		// the API checked the funds of market orders
+		{&pkg.Order{Type: pkg.TypeMarket, Quantity: d("100")}, true},
+		{&pkg.Order{Type: pkg.TypeMarket, Quantity: d("101")}, false},
+		{&pkg.Order{Type: pkg.TypeLimit, Price: d("50"), Quantity: d("1000"), Fake: true}, true},
+	}
+
+	for i, tt := range tests {
+		if got := limits.Accept(tt.order); got != tt.want {
+			t.Errorf("%d: got %t, want %t", i, got, tt.want)
+		}
+	}
+}
+
+func TestOrderLotSizeAndMinNotional(t *testing.T) {
+	d := decimal.RequireFromString
+	limits := OrderSizeLimits{LotSize: d("0.01"), MinNotional: d("10")}
+
+	tests := []struct {
+		order *pkg.Order
+		want  bool
+	}{
+		{&pkg.Order{Type: pkg.TypeLimit, Price: d("100"), Quantity: d("0.1")}, true},
+		{&pkg.Order{Type: pkg.TypeLimit, Price: d("100"), Quantity: d("0.105")}, false},
+		{&pkg.Order{Type: pkg.TypeLimit, Price: d("99.99"), Quantity: d("0.1")}, false},
+		// the price of a market order isn't known before it's matched
+		{&pkg.Order{Type: pkg.TypeMarket, Quantity: d("0.01")}, true},
+	}
+
+	for i, tt := range tests {
+		if got := limits.Accept(tt.order); got != tt.want {
+			t.Errorf("%d: got %t, want %t", i, got, tt.want)
+		}
+	}
+}
+
+func TestPartialFillLeavingDustCancelled(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{SizeLimits: OrderSizeLimits{MinNotional: decimal.NewFromInt(10)}}, nil)
+
+	maker := newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1")
+	ob.Add(maker)
+
+	// 0.95 leaves 0.05 worth 5, below the minimum notional of 10
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "100", "0.95"))
+
+	if len(publisher.Trades) != 1 || !publisher.Trades[0].Quantity.Equal(decimal.RequireFromString("0.95")) {
+		t.Fatalf("expected the trade to be published, got %d trades", len(publisher.Trades))
+	}
+
+	if bookHas(ob, maker) {
+		t.Error("expected the dust of the maker not to rest")
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0].ID != maker.ID || publisher.Cancels[0].Reason != CancelReasonMinNotional {
+		t.Errorf("expected the rest of the maker to be cancelled, got %+v", publisher.Cancels)
+	}
+
+	// a taker partially filled doesn't rest its dust either
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1"))
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "100", "1.05")
+	ob.Add(taker)
+
+	if bookHas(ob, taker) || len(publisher.Cancels) != 2 || publisher.Cancels[1].ID != taker.ID {
+		t.Errorf("expected the dust of the taker to be cancelled, got %+v", publisher.Cancels)
+	}
+}
+
+func TestPartialFillBelowMinAmountCancelled(t *testing.T) {
+	d := decimal.RequireFromString
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{SizeLimits: OrderSizeLimits{MinAmount: d("0.0001")}}, nil)
+
+	maker := newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1")
+	ob.Add(maker)
+
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "100", "0.99999997")
+	taker.MemberID = 2
+	ob.Add(taker)
+
+	if bookHas(ob, maker) || len(publisher.Cancels) != 1 || publisher.Cancels[0] != (cancelRecord{ID: maker.ID, Reason: CancelReasonDust}) {
+		t.Fatalf("expected the dust of the maker to be cancelled, got %+v", publisher.Cancels)
+	}
+
+	// the cancel releases the funds of the residual whole, the trade took the rest of them
+	if residual := maker.UnfilledQuantity(); !residual.Equal(d("0.00000003")) || !publisher.Trades[0].Quantity.Add(residual).Equal(maker.Quantity) {
+		t.Errorf("expected a residual of 0.00000003 to be released, got %s", residual)
+	}
+
+	// a rest at the minimum amount stays in the book
+	resting := newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1")
+	ob.Add(resting)
+	partial := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "100", "0.9999")
+	partial.MemberID = 2
+	ob.Add(partial)
+
+	if !bookHas(ob, resting) || len(publisher.Cancels) != 1 {
+		t.Errorf("expected a rest of the minimum amount to stay in the book, got %+v", publisher.Cancels)
+	}
+}
+
+func TestDustReasons(t *testing.T) {
+	d := decimal.RequireFromString
+	limits := OrderSizeLimits{MinAmount: d("0.01"), MinNotional: d("10")}
+
+	cases := []struct {
+		filled string
+		reason CancelReason
+		dust   bool
+	}{
+		{"0", "", false},
+		{"0.5", "", false},
+		{"0.95", CancelReasonMinNotional, true},
+		{"0.995", CancelReasonDust, true},
+		{"1", "", false},
+	}
+
+	for _, c := range cases {
+		o := newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1")
+		o.FilledQuantity = d(c.filled)
+
+		if reason, dust := limits.Dust(o); reason != c.reason || dust != c.dust {
+			t.Errorf("filled %s: expected %q %t, got %q %t", c.filled, c.reason, c.dust, reason, dust)
+		}
+	}
+}
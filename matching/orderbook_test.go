@@ -2,15 +2,18 @@ package matching
 
 import (
 	"math/rand"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 	"github.com/zsmartex/pkg"
 )
 
-func BenchmarkInsertOrder(b *testing.B) {
-	orderBook := NewOrderBook(pkg.Symbol{BaseCurrency: "ABC", QuoteCurrency: "XYZ"}, decimal.Zero)
+func BenchmarkInsert(b *testing.B) {
+	orderBook, _ := newTestOrderBook(decimal.Zero, OrderBookConfig{}, nil)
 
 	orders := make([]*pkg.Order, b.N)
 	for n := 0; n < b.N; n++ {
@@ -27,6 +30,7 @@ func BenchmarkInsertOrder(b *testing.B) {
 
 		orders[n] = &pkg.Order{
 			ID:        int64(n),
+			UUID:      uuid.New(),
 			Side:      side,
 			Price:     decimal.NewFromFloat(float64(price)),
 			Quantity:  decimal.NewFromFloat(float64(quantity)),
@@ -34,9 +38,243 @@ func BenchmarkInsertOrder(b *testing.B) {
 		}
 	}
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
 		orderBook.Add(orders[n])
 	}
 	b.StopTimer()
 }
+
+// matchSweepOrders is the number of asks a market buy sweeps in BenchmarkMatchSweep.
+const matchSweepOrders = 10000
+
+// BenchmarkMatchSweep measures a market buy taking matchSweepOrders asks of 100 price levels.
+func BenchmarkMatchSweep(b *testing.B) {
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		orderBook, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+		orderBook.publisher = &discardPublisher{}
+
+		for i := 0; i < matchSweepOrders; i++ {
+			ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1")
+			ask.Price = decimal.NewFromInt(int64(100 + i%100))
+			orderBook.Add(ask)
+		}
+
+		sweep := newTestOrder(pkg.SideBuy, pkg.TypeMarket, "", strconv.Itoa(matchSweepOrders))
+		sweep.MemberID = 2
+		b.StartTimer()
+
+		orderBook.Add(sweep)
+	}
+}
+
+func bookHas(ob *OrderBook, o *pkg.Order) bool {
+	price_levels := ob.Depth.Bids
+	if o.IsAsk() {
+		price_levels = ob.Depth.Asks
+	}
+
+	value, found := price_levels.Get(NewPriceLevel(o.Side, o.Price).Key())
+	if !found {
+		return false
+	}
+
+	return value.(*PriceLevel).Get(o.Key()) != nil
+}
+
+// The pooled keys of the level lookups never end up in the trees, whatever the lookups are interleaved with.
+func TestDepthLevelLookupsConcurrent(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	depth := ob.Depth
+
+	// each writer rests orders on 5 levels of its own and takes them out again, its last orders are left
+	writers := make([][][]*pkg.Order, 4)
+	for w := range writers {
+		writers[w] = make([][]*pkg.Order, 200)
+		for round := range writers[w] {
+			for level := 0; level < 5; level++ {
+				price := strconv.Itoa(80 + w*5 + level)
+				writers[w][round] = append(writers[w][round], newTestOrder(pkg.SideBuy, pkg.TypeLimit, price, "1"))
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+
+			for i := 0; i < 2000; i++ {
+				price := decimal.NewFromInt(int64(80 + (i+r)%20))
+				depth.depthMutex.RLock()
+				if level := depth.level(pkg.SideBuy, price); level != nil && !level.Price.Equal(price) {
+					t.Errorf("expected the level of %s, got the one of %s", price, level.Price)
+				}
+				depth.depthMutex.RUnlock()
+
+				depth.Get(writers[r][i%200][i%5].Key())
+			}
+		}(r)
+	}
+
+	for w := range writers {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for round, orders := range writers[w] {
+				for _, o := range orders {
+					depth.Add(o)
+				}
+
+				if round == len(writers[w])-1 {
+					break
+				}
+
+				for _, o := range orders {
+					if !depth.Remove(o.Key()) {
+						t.Errorf("expected order %d to be removed", o.ID)
+					}
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+
+	if depth.Bids.Size() != 20 {
+		t.Fatalf("expected the 20 levels of the last orders, got %d", depth.Bids.Size())
+	}
+
+	for _, node := range depth.Bids.Keys() {
+		key := node.(*PriceLevelKey)
+		value, _ := depth.Bids.Get(key)
+		if level := value.(*PriceLevel); key.Side != pkg.SideBuy || !key.Price.Equal(level.Price) || level.Orders.Size() != 1 {
+			t.Errorf("expected the key of the level of %s, got %+v", level.Price, key)
+		}
+	}
+}
+
+func TestCancelReplace(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.RequireFromString("10"), OrderBookConfig{}, nil)
+
+	ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "11", "1")
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "9", "1")
+	ob.Add(ask)
+	ob.Add(bid)
+
+	replacement := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "11", "2")
+	accepted, _ := ob.CancelReplace(bid.Key(), replacement, nil)
+	if !accepted {
+		t.Fatal("expected the replace to be accepted")
+	}
+
+	if bookHas(ob, bid) {
+		t.Error("expected the replaced order to leave the book")
+	}
+
+	if len(publisher.Trades) != 1 || publisher.Trades[0].TakerOrder.ID != replacement.ID || !publisher.Trades[0].Quantity.Equal(decimal.NewFromInt(1)) {
+		t.Fatalf("expected the replacement to match the ask, got %+v", publisher.Trades)
+	}
+
+	if !bookHas(ob, replacement) {
+		t.Error("expected the rest of the replacement to be in the book")
+	}
+
+	if len(publisher.Replaces) != 1 || publisher.Replaces[0] != (replaceRecord{ReplacedID: bid.ID, ID: replacement.ID, Accepted: true}) {
+		t.Errorf("unexpected replace events %+v", publisher.Replaces)
+	}
+
+	if len(publisher.Cancels) != 0 {
+		t.Errorf("expected the replaced order not to be reported as cancelled, got %+v", publisher.Cancels)
+	}
+}
+
+func TestCancelReplaceRejected(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.RequireFromString("10"), OrderBookConfig{}, nil)
+
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "9", "1")
+	ob.Add(bid)
+
+	// the side of an order can't be replaced, the order stays in the book
+	other_side := newTestOrder(pkg.SideSell, pkg.TypeLimit, "12", "1")
+	if accepted, _ := ob.CancelReplace(bid.Key(), other_side, nil); accepted {
+		t.Error("expected a replacement on the other side to be rejected")
+	}
+
+	if !bookHas(ob, bid) {
+		t.Error("expected a rejected replace to keep the order")
+	}
+
+	// an order which already left the book can't be replaced
+	ob.Remove(bid.Key())
+	replacement := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "9.5", "1")
+	if accepted, _ := ob.CancelReplace(bid.Key(), replacement, nil); accepted {
+		t.Error("expected the replace of a removed order to be rejected")
+	}
+
+	if bookHas(ob, replacement) {
+		t.Error("expected a rejected replacement to stay out of the book")
+	}
+
+	expected := []replaceRecord{
+		{ReplacedID: bid.ID, ID: other_side.ID},
+		{ReplacedID: bid.ID, ID: replacement.ID},
+	}
+	if len(publisher.Replaces) != len(expected) || publisher.Replaces[0] != expected[0] || publisher.Replaces[1] != expected[1] {
+		t.Errorf("unexpected replace events %+v", publisher.Replaces)
+	}
+}
+
+func TestCancelReplaceStopOrder(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.RequireFromString("10"), OrderBookConfig{}, nil)
+
+	stop := newTestOrder(pkg.SideSell, pkg.TypeLimit, "8", "1")
+	stop.StopPrice = decimal.RequireFromString("9")
+	ob.Add(stop)
+
+	replacement := newTestOrder(pkg.SideSell, pkg.TypeLimit, "7", "1")
+	replacement.StopPrice = decimal.RequireFromString("8")
+	if accepted, _ := ob.CancelReplace(stop.Key(), replacement, nil); !accepted {
+		t.Fatalf("expected the stop order to be replaced, got %+v", publisher.Replaces)
+	}
+
+	if ob.StopAsks.Size() != 1 {
+		t.Fatalf("expected one stop order, got %d", ob.StopAsks.Size())
+	}
+
+	if _, found := ob.StopAsks.Get(replacement.Key()); !found {
+		t.Error("expected the replacement to wait for its stop price")
+	}
+}
+
+// The engine rebuilds the book of a market when its configuration changes, the trades of each book
+// reference the version it was built with.
+func TestTradesStampConfigVersion(t *testing.T) {
+	before, before_publisher := newTestOrderBook(decimal.NewFromInt(10), OrderBookConfig{ConfigVersion: 1}, nil)
+
+	ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "2")
+	before.Add(ask)
+	before.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "10", "1"))
+
+	after, after_publisher := newTestOrderBook(decimal.NewFromInt(10), OrderBookConfig{ConfigVersion: 2}, nil)
+
+	reloaded := *ask
+	reloaded.FilledQuantity = decimal.Zero
+	reloaded.Quantity = decimal.NewFromInt(1)
+	after.Add(&reloaded)
+	after.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "10", "1"))
+
+	if len(before_publisher.ConfigVersions) != 1 || before_publisher.ConfigVersions[0] != 1 {
+		t.Errorf("expected the trade before the change on version 1, got %v", before_publisher.ConfigVersions)
+	}
+
+	if len(after_publisher.ConfigVersions) != 1 || after_publisher.ConfigVersions[0] != 2 {
+		t.Errorf("expected the trade after the change on version 2, got %v", after_publisher.ConfigVersions)
+	}
+}
@@ -0,0 +1,67 @@
+package matching
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+// RestingOrder is an order resting in the book as an order-by-order snapshot shows it. Quantity is what the order
+// has left, only the slice shown of an iceberg unless the snapshot shows the members of the orders.
+type RestingOrder struct {
+	ID        int64           `json:"id"`
+	Price     decimal.Decimal `json:"price"`
+	Quantity  decimal.Decimal `json:"quantity"`
+	CreatedAt time.Time       `json:"created_at"`
+	// MemberID is only set by the snapshots showing the members of the orders
+	MemberID int64 `json:"member_id,omitempty"`
+}
+
+// OrdersSnapshot returns the first limit orders resting on side of the book, in the order they'd be matched, with
+// their members when members is set. The orders are copied between two matching cycles, the book only waits for
+// the copy of them.
+func (e *Engine) OrdersSnapshot(side pkg.OrderSide, limit int, members bool) []*RestingOrder {
+	e.MatchingMutex.RLock()
+	defer e.MatchingMutex.RUnlock()
+
+	return e.OrderBook.Depth.restingOrders(side, limit, members)
+}
+
+func (d *Depth) restingOrders(side pkg.OrderSide, limit int, members bool) []*RestingOrder {
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
+
+	orders := make([]*RestingOrder, 0)
+
+	it := d.levels(side).Iterator()
+	it.End()
+	for len(orders) < limit && it.Prev() {
+		price_level := it.Value().(*PriceLevel)
+
+		price_level.Lock()
+		for _, value := range price_level.Orders.Values() {
+			if len(orders) >= limit {
+				break
+			}
+
+			o := value.(*pkg.Order)
+			resting := &RestingOrder{
+				ID:        o.ID,
+				Price:     o.Price,
+				Quantity:  d.shownQuantity(o),
+				CreatedAt: o.CreatedAt,
+			}
+
+			if members {
+				resting.Quantity = o.UnfilledQuantity()
+				resting.MemberID = o.MemberID
+			}
+
+			orders = append(orders, resting)
+		}
+		price_level.Unlock()
+	}
+
+	return orders
+}
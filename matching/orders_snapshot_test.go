@@ -0,0 +1,43 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func TestOrdersSnapshotPriorityOrder(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{Flags: FeatureFlags{FifoTiebreakV2: true}}, nil)
+	engine := newEngine(testSymbol, ob, 0)
+
+	second := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	third := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "2")
+	first := newTestOrder(pkg.SideSell, pkg.TypeLimit, "100.5", "3")
+	iceberg := newTestOrder(pkg.SideSell, pkg.TypeLimit, "102", "10")
+	iceberg.MemberID = 7
+	engine.Submit(second)
+	engine.Submit(third)
+	engine.Submit(first)
+	engine.SubmitWithOptions(iceberg, display("1"))
+	engine.Submit(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1"))
+
+	orders := engine.OrdersSnapshot(pkg.SideSell, 3, false)
+	if len(orders) != 3 || orders[0].ID != first.ID || orders[1].ID != second.ID || orders[2].ID != third.ID {
+		t.Fatalf("expected the first 3 asks in priority order, got %+v", orders)
+	}
+
+	if orders[0].MemberID != 0 || !orders[0].Quantity.Equal(decimal.NewFromInt(3)) {
+		t.Errorf("expected the public snapshot to hide the member, got %+v", orders[0])
+	}
+
+	orders = engine.OrdersSnapshot(pkg.SideSell, 10, false)
+	if last := orders[len(orders)-1]; last.ID != iceberg.ID || !last.Quantity.Equal(decimal.NewFromInt(1)) {
+		t.Errorf("expected the public snapshot to show the slice of the iceberg, got %+v", last)
+	}
+
+	orders = engine.OrdersSnapshot(pkg.SideSell, 10, true)
+	if last := orders[len(orders)-1]; last.MemberID != 7 || !last.Quantity.Equal(decimal.NewFromInt(10)) {
+		t.Errorf("expected the member snapshot to show the member and the whole iceberg, got %+v", last)
+	}
+}
@@ -0,0 +1,107 @@
+package matching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/pkg"
+)
+
+var postOnly = &events.OrderOptions{PostOnly: true}
+
+func TestPostOnlyRejectedWhenCrossing(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(10), OrderBookConfig{}, nil)
+
+	ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "1")
+	ob.Add(ask)
+
+	// only one of the two units would cross, none of them is matched
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "11", "2")
+	ob.add(bid, postOnly)
+
+	if len(publisher.Trades) != 0 {
+		t.Fatalf("expected the post-only order not to trade, got %d trades", len(publisher.Trades))
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0] != (cancelRecord{ID: bid.ID, Reason: CancelReasonPostOnly}) {
+		t.Errorf("expected the post-only order to be cancelled, got %+v", publisher.Cancels)
+	}
+
+	if bookHas(ob, bid) || !bookHas(ob, ask) || !ask.FilledQuantity.IsZero() {
+		t.Error("expected the book to be left as it was")
+	}
+
+	if ob.Options(bid.ID) != nil {
+		t.Error("expected the options of the cancelled order to be forgotten")
+	}
+}
+
+func TestPostOnlyRestsAsMaker(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(10), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "11", "1"))
+
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "10", "1")
+	ob.add(bid, postOnly)
+
+	if !bookHas(ob, bid) || len(publisher.Cancels) != 0 {
+		t.Fatal("expected the post-only order not crossing to rest")
+	}
+
+	ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "1")
+	ob.Add(ask)
+
+	if len(publisher.Trades) != 1 || publisher.Trades[0].MakerOrder.ID != bid.ID || publisher.Trades[0].TakerOrder.ID != ask.ID {
+		t.Fatalf("expected the post-only order to trade as maker, got %+v", publisher.Trades)
+	}
+
+	if ob.Options(bid.ID) != nil {
+		t.Error("expected the options of the filled order to be forgotten")
+	}
+}
+
+func TestPostOnlyStopOrder(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(10), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "11", "5"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "10.5", "1"))
+
+	// the stop order is triggered by the trade at 10.5 and would buy the ask at 11
+	stop := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "11", "1")
+	stop.StopPrice = decimal.RequireFromString("10.5")
+	ob.add(stop, postOnly)
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "10.5", "1"))
+
+	if len(publisher.Trades) != 1 {
+		t.Fatalf("expected only the trade triggering the stop order, got %d trades", len(publisher.Trades))
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0] != (cancelRecord{ID: stop.ID, Reason: CancelReasonPostOnly}) {
+		t.Errorf("expected the triggered post-only order to be cancelled, got %+v", publisher.Cancels)
+	}
+}
+
+func TestPostOnlyBatchAuction(t *testing.T) {
+	fake := clock.NewFake(time.Date(2022, 5, 10, 10, 0, 0, 0, time.UTC))
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(10), OrderBookConfig{BatchInterval: time.Second}, fake)
+
+	ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "1")
+	ob.Add(ask)
+
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "10", "1")
+	ob.add(bid, postOnly)
+
+	fake.Advance(time.Second)
+
+	if len(publisher.Trades) != 0 || len(publisher.Cancels) != 1 || publisher.Cancels[0].ID != bid.ID {
+		t.Errorf("expected the post-only order crossing the batch to be cancelled, got %+v %+v", publisher.Trades, publisher.Cancels)
+	}
+
+	if !bookHas(ob, ask) {
+		t.Error("expected the ask to keep resting")
+	}
+}
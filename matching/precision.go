@@ -0,0 +1,107 @@
+package matching
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/decimalutil"
+	"github.com/zsmartex/finex/events"
+)
+
+// Repricing is what re-keying the book to a price precision did.
+type Repricing struct {
+	// Repriced are the orders moved to a price of the precision
+	Repriced int
+	// Cancelled are the orders whose price can't be represented at the precision
+	Cancelled int
+}
+
+// repricedPrice rounds the price of a limit order to precision without making it more aggressive: the price
+// of a bid is rounded down and the one of an ask up, a book which didn't cross doesn't cross once re-keyed.
+func repricedPrice(side pkg.OrderSide, price decimal.Decimal, precision int32) decimal.Decimal {
+	if side == pkg.SideSell {
+		return decimalutil.Round(price, precision, decimalutil.Up)
+	}
+
+	return decimalutil.Round(price, precision, decimalutil.Down)
+}
+
+// repricedStopPrice rounds a stop price to precision so the stop doesn't trigger sooner: the stop price of a sell,
+// triggered as the price falls, is rounded down and the one of a buy, triggered as it rises, up.
+func repricedStopPrice(side pkg.OrderSide, stop_price decimal.Decimal, precision int32) decimal.Decimal {
+	if side == pkg.SideSell {
+		return decimalutil.Round(stop_price, precision, decimalutil.Down)
+	}
+
+	return decimalutil.Round(stop_price, precision, decimalutil.Up)
+}
+
+// Reprice re-keys the orders of the book and the stop orders to a price precision, see repricedPrice and
+// repricedStopPrice. An order moved is put back under its new prices, its creation time keeps its priority among
+// the orders of that price, and a reprice is published for it unless it's fake. An order whose price rounds down to zero is
+// cancelled. Prices already of the precision aren't moved, re-keying twice to a precision moves nothing.
+func (ob *OrderBook) Reprice(precision int32) (repricing Repricing) {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	orders := ob.Depth.Orders()
+	for _, book := range ob.stopBooks() {
+		for _, order := range book.Values() {
+			orders = append(orders, order.(*pkg.Order))
+		}
+	}
+
+	for _, o := range orders {
+		price, stop_price := o.Price, o.StopPrice
+		if price.IsPositive() {
+			price = repricedPrice(o.Side, price, precision)
+		}
+
+		if stop_price.IsPositive() {
+			stop_price = repricedStopPrice(o.Side, stop_price, precision)
+		}
+
+		if price.Equal(o.Price) && stop_price.Equal(o.StopPrice) {
+			continue
+		}
+
+		key, options, ice := o.Key(), ob.options[o.ID], ob.Depth.icebergOf(o.ID)
+		ob.removeOrder(key)
+
+		if o.Price.IsPositive() && !price.IsPositive() || o.StopPrice.IsPositive() && !stop_price.IsPositive() {
+			ob.PublishCancel(key, CancelReasonPrecision)
+			repricing.Cancelled++
+			continue
+		}
+
+		o.Price, o.StopPrice = price, stop_price
+		ob.restore(o, options, ice)
+		if !o.IsFake() {
+			ob.publisher.PublishReprice(o.Key(), CancelReasonPrecision)
+		}
+		repricing.Repriced++
+	}
+
+	return
+}
+
+// restore puts an order taken out by removeOrder back with the options it had, nil when it had none, without
+// matching it. A trailing stop keeps trailing and an iceberg keeps the slice it showed, nil when it isn't one.
+func (ob *OrderBook) restore(o *pkg.Order, options *events.OrderOptions, ice *iceberg) {
+	ob.keepOptions(o, options)
+
+	if ice != nil {
+		ob.Depth.keep(o.ID, ice)
+	}
+
+	if !ob.isStop(o) {
+		ob.Depth.Add(o)
+		return
+	}
+
+	if ob.trailingOffset(o).IsPositive() {
+		ob.trailing[o.ID] = o
+	}
+
+	ob.stopBookOf(o.ID, o.Side).Put(o.Key(), o)
+}
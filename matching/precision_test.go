@@ -0,0 +1,137 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+// levelOrders returns the ids of the orders of the best level of a side, the first in the queue first.
+func levelOrders(ob *OrderBook, side pkg.OrderSide) []int64 {
+	levels := ob.Depth.Bids
+	if side == pkg.SideSell {
+		levels = ob.Depth.Asks
+	}
+
+	ids := make([]int64, 0)
+	if best := levels.Right(); best != nil {
+		for _, order := range best.Value.(*PriceLevel).Orders.Values() {
+			ids = append(ids, order.(*pkg.Order).ID)
+		}
+	}
+
+	return ids
+}
+
+func TestRepriceToFewerDecimals(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{Flags: FeatureFlags{FifoTiebreakV2: true}}, nil)
+
+	first_bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99.1299", "1")
+	second_bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99.12", "1")
+	dust_bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "0.0040", "1")
+	first_ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101.0001", "1")
+	second_ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101.01", "1")
+	stop_sell := newTestStopOrder(pkg.SideSell, "95.5555", "95.0001", "1")
+	stop_buy := newTestStopOrder(pkg.SideBuy, "105.0001", "105.5", "1")
+
+	for _, o := range []*pkg.Order{first_bid, second_bid, dust_bid, first_ask, second_ask, stop_sell, stop_buy} {
+		ob.Add(o)
+	}
+
+	repricing := ob.Reprice(2)
+	if repricing.Repriced != 4 || repricing.Cancelled != 1 {
+		t.Fatalf("expected 4 orders repriced and 1 cancelled, got %+v", repricing)
+	}
+
+	// a bid is rounded down and an ask up, the orders merged in a level keep their time priority
+	if ids := levelOrders(ob, pkg.SideBuy); len(ids) != 2 || ids[0] != first_bid.ID || !first_bid.Price.Equal(decimal.RequireFromString("99.12")) {
+		t.Errorf("expected both bids at 99.12 with the first one first, got %v at %s", ids, first_bid.Price)
+	}
+
+	if ids := levelOrders(ob, pkg.SideSell); len(ids) != 2 || ids[0] != first_ask.ID || !first_ask.Price.Equal(decimal.RequireFromString("101.01")) {
+		t.Errorf("expected both asks at 101.01 with the first one first, got %v at %s", ids, first_ask.Price)
+	}
+
+	// a stop doesn't trigger sooner, a sell stop is rounded down and a buy stop up
+	if !stop_sell.StopPrice.Equal(decimal.RequireFromString("95.55")) || !stop_sell.Price.Equal(decimal.RequireFromString("95.01")) {
+		t.Errorf("expected the sell stop at 95.55 for 95.01, got %s for %s", stop_sell.StopPrice, stop_sell.Price)
+	}
+
+	if !stop_buy.StopPrice.Equal(decimal.RequireFromString("105.01")) || !stop_buy.Price.Equal(decimal.RequireFromString("105.5")) {
+		t.Errorf("expected the buy stop at 105.01 for 105.5, got %s for %s", stop_buy.StopPrice, stop_buy.Price)
+	}
+
+	if ob.StopAsks.Size() != 1 || ob.StopBids.Size() != 1 {
+		t.Errorf("expected the stop orders to be re-keyed in the stop orders, got %d and %d", ob.StopAsks.Size(), ob.StopBids.Size())
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0].ID != dust_bid.ID || publisher.Cancels[0].Reason != CancelReasonPrecision || bookHas(ob, dust_bid) {
+		t.Errorf("expected the bid rounded down to zero to be cancelled, got %+v", publisher.Cancels)
+	}
+
+	if len(publisher.Reprices) != 4 {
+		t.Errorf("expected a reprice per order moved, got %+v", publisher.Reprices)
+	}
+
+	for _, reprice := range publisher.Reprices {
+		if reprice.ID == stop_sell.ID && (!reprice.Price.Equal(stop_sell.Price) || !reprice.StopPrice.Equal(stop_sell.StopPrice)) {
+			t.Errorf("expected the reprice of the sell stop to carry its new prices, got %+v", reprice)
+		}
+	}
+
+	// the re-keyed book is found by the keys of the new prices
+	if ob.Remove(first_ask.Key()) != CancelOutcomeCancelled || ob.Remove(stop_sell.Key()) != CancelOutcomeCancelled {
+		t.Error("expected the re-keyed orders to be cancelled with their new keys")
+	}
+
+	if repricing := ob.Reprice(2); repricing != (Repricing{}) {
+		t.Errorf("expected a book of the precision to be left as it is, got %+v", repricing)
+	}
+}
+
+func TestRepriceToMoreDecimals(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{Flags: FeatureFlags{FifoTiebreakV2: true}}, nil)
+
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99.12", "1")
+	ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101.5", "1")
+	stop := newTestStopOrder(pkg.SideSell, "95.55", "95", "1")
+
+	for _, o := range []*pkg.Order{bid, ask, stop} {
+		ob.Add(o)
+	}
+
+	if repricing := ob.Reprice(4); repricing != (Repricing{}) {
+		t.Fatalf("expected every price to be a price of the precision, got %+v", repricing)
+	}
+
+	if !bookHas(ob, bid) || !bookHas(ob, ask) || ob.StopAsks.Size() != 1 || len(publisher.Reprices) != 0 || len(publisher.Cancels) != 0 {
+		t.Errorf("expected the book to be left as it is, got %+v and %+v", publisher.Reprices, publisher.Cancels)
+	}
+
+	// the orders of the new precision share the levels of the orders already there
+	finer := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99.1200", "1")
+	ob.Add(finer)
+
+	if ids := levelOrders(ob, pkg.SideBuy); len(ids) != 2 || ids[1] != finer.ID {
+		t.Errorf("expected the bid of 4 decimals to queue behind the bid at 99.12, got %v", ids)
+	}
+}
+
+func TestRepriceKeepsTrailing(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	stop := newTestOrder(pkg.SideSell, pkg.TypeMarket, "", "1")
+	ob.add(stop, trailing("0.0005"))
+
+	if repricing := ob.Reprice(2); repricing.Repriced != 1 || !stopPriceOf(t, ob, stop).Equal(decimal.RequireFromString("99.99")) {
+		t.Fatalf("expected the trailing stop at 99.99, got %s", stop.StopPrice)
+	}
+
+	// it keeps trailing the market price
+	tradeAt(ob, "101")
+
+	if stop_price := stopPriceOf(t, ob, stop); !stop_price.GreaterThan(decimal.NewFromInt(100)) {
+		t.Errorf("expected the stop to trail the price of 101, got %s", stop_price)
+	}
+}
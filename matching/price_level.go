@@ -1,9 +1,12 @@
 package matching
 
 import (
+	"sort"
 	"sync"
 
 	"github.com/emirpasic/gods/lists/arraylist"
+	"github.com/emirpasic/gods/utils"
+	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 	"github.com/zsmartex/pkg"
 )
@@ -13,6 +16,10 @@ type PriceLevel struct {
 	Side   pkg.OrderSide
 	Price  decimal.Decimal
 	Orders *arraylist.List
+
+	comparator utils.Comparator
+	// index is only kept by levels of books with the use_price_level_book flag
+	index map[uuid.UUID]*pkg.Order
 }
 
 type PriceLevelKey struct {
@@ -21,11 +28,26 @@ type PriceLevelKey struct {
 }
 
 func NewPriceLevel(side pkg.OrderSide, price decimal.Decimal) *PriceLevel {
-	return &PriceLevel{
-		Side:   side,
-		Price:  price,
-		Orders: arraylist.New(),
+	return newPriceLevel(side, price, FeatureFlags{})
+}
+
+func newPriceLevel(side pkg.OrderSide, price decimal.Decimal, flags FeatureFlags) *PriceLevel {
+	pl := &PriceLevel{
+		Side:       side,
+		Price:      price,
+		Orders:     arraylist.New(),
+		comparator: OrderComparator,
+	}
+
+	if flags.FifoTiebreakV2 {
+		pl.comparator = FifoOrderComparator
 	}
+
+	if flags.UsePriceLevelBook {
+		pl.index = make(map[uuid.UUID]*pkg.Order)
+	}
+
+	return pl
 }
 
 func (p *PriceLevel) Key() *PriceLevelKey {
@@ -39,6 +61,11 @@ func (p *PriceLevel) Add(o *pkg.Order) {
 	p.Lock()
 	defer p.Unlock()
 
+	if p.index != nil {
+		p.insert(o)
+		return
+	}
+
 	index, _ := p.Orders.Find(func(index int, value interface{}) bool {
 		order := value.(*pkg.Order)
 
@@ -47,13 +74,33 @@ func (p *PriceLevel) Add(o *pkg.Order) {
 
 	if index == -1 {
 		p.Orders.Add(o)
-		p.Orders.Sort(OrderComparator)
+		p.Orders.Sort(p.comparator)
+	}
+}
+
+// insert puts the order after every order it doesn't come before, the level stays sorted without sorting it again.
+func (p *PriceLevel) insert(o *pkg.Order) {
+	if _, found := p.index[o.UUID]; found {
+		return
 	}
+
+	values := p.Orders.Values()
+	position := sort.Search(len(values), func(i int) bool {
+		return p.comparator(o, values[i]) < 0
+	})
+
+	p.Orders.Insert(position, o)
+	p.index[o.UUID] = o
 }
 
 func (p *PriceLevel) Get(key *pkg.OrderKey) *pkg.Order {
 	p.Lock()
 	defer p.Unlock()
+
+	if p.index != nil {
+		return p.index[key.UUID]
+	}
+
 	index, value := p.Orders.Find(func(index int, value interface{}) bool {
 		order := value.(*pkg.Order)
 
@@ -97,25 +144,28 @@ func (p *PriceLevel) Total() decimal.Decimal {
 	return total
 }
 
-func (p *PriceLevel) Remove(key *pkg.OrderKey) decimal.Decimal {
-	total := p.Total()
-
+// Remove takes the order of key out of the level and reports whether it was in it.
+func (p *PriceLevel) Remove(key *pkg.OrderKey) bool {
 	p.Lock()
 	defer p.Unlock()
 
-	index, order := p.Orders.Find(func(index int, value interface{}) bool {
+	index, _ := p.Orders.Find(func(index int, value interface{}) bool {
 		order := value.(*pkg.Order)
 
 		return order.UUID == key.UUID
 	})
 
-	if index >= 0 {
-		p.Orders.Remove(index)
+	if index < 0 {
+		return false
+	}
 
-		return total.Sub(order.(*pkg.Order).UnfilledQuantity())
+	p.Orders.Remove(index)
+
+	if p.index != nil {
+		delete(p.index, key.UUID)
 	}
 
-	return total
+	return true
 }
 
 func OrderComparator(a, b interface{}) int {
@@ -130,3 +180,22 @@ func OrderComparator(a, b interface{}) int {
 
 	return 0
 }
+
+// FifoOrderComparator puts the oldest order first, orders created at the same time are ordered by ID.
+func FifoOrderComparator(a, b interface{}) int {
+	aKey := a.(*pkg.Order)
+	bKey := b.(*pkg.Order)
+
+	switch {
+	case aKey.CreatedAt.Before(bKey.CreatedAt):
+		return -1
+	case aKey.CreatedAt.After(bKey.CreatedAt):
+		return 1
+	case aKey.ID < bKey.ID:
+		return -1
+	case aKey.ID > bKey.ID:
+		return 1
+	default:
+		return 0
+	}
+}
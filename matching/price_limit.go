@@ -0,0 +1,77 @@
+package matching
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+// PriceLimit keeps trades of a market within ±Rate of the previous UTC day close.
+type PriceLimit struct {
+	Rate           decimal.Decimal
+	ReferenceClose decimal.Decimal
+	day            time.Time
+}
+
+func (l *PriceLimit) Enabled() bool {
+	return l.Rate.IsPositive() && l.ReferenceClose.IsPositive()
+}
+
+// Rollover moves the reference to the close of the previous day once `now` enters a new UTC day.
+// It's called before every command, so last_price is still the last trade of the previous day.
+func (l *PriceLimit) Rollover(now time.Time, last_price decimal.Decimal) {
+	day := now.UTC().Truncate(24 * time.Hour)
+
+	if !day.After(l.day) {
+		return
+	}
+
+	if !l.day.IsZero() && last_price.IsPositive() {
+		l.ReferenceClose = last_price
+	}
+
+	l.day = day
+}
+
+// Band returns the lowest and highest tradable prices.
+func (l *PriceLimit) Band() (lower, upper decimal.Decimal) {
+	lower = l.ReferenceClose.Mul(decimal.NewFromInt(1).Sub(l.Rate))
+	upper = l.ReferenceClose.Mul(decimal.NewFromInt(1).Add(l.Rate))
+
+	return
+}
+
+func (l *PriceLimit) Accept(price decimal.Decimal) bool {
+	if !l.Enabled() {
+		return true
+	}
+
+	lower, upper := l.Band()
+
+	return price.GreaterThanOrEqual(lower) && price.LessThanOrEqual(upper)
+}
+
+// TradePrice returns the price a taker on `side` trades with a maker at `maker_price`.
+// Makers resting beyond the limit in the taker's favor trade at the boundary,
+// found is false when the maker is beyond the limit against the taker.
+func (l *PriceLimit) TradePrice(side pkg.OrderSide, maker_price decimal.Decimal) (price decimal.Decimal, found bool) {
+	if !l.Enabled() {
+		return maker_price, true
+	}
+
+	lower, upper := l.Band()
+
+	switch {
+	case side == pkg.SideBuy && maker_price.GreaterThan(upper):
+		return decimal.Zero, false
+	case side == pkg.SideSell && maker_price.LessThan(lower):
+		return decimal.Zero, false
+	case maker_price.LessThan(lower):
+		return lower, true
+	case maker_price.GreaterThan(upper):
+		return upper, true
+	default:
+		return maker_price, true
+	}
+}
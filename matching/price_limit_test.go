@@ -0,0 +1,120 @@
+package matching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/pkg"
+)
+
+func TestPriceLimitTradePrice(t *testing.T) {
+	d := decimal.RequireFromString
+	limit := &PriceLimit{Rate: d("0.1"), ReferenceClose: d("100")}
+
+	tests := []struct {
+		side        pkg.OrderSide
+		maker_price string
+		price       string
+		found       bool
+	}{
+		{pkg.SideBuy, "105", "105", true},
+		{pkg.SideBuy, "85", "90", true},
+		{pkg.SideBuy, "115", "0", false},
+		{pkg.SideSell, "95", "95", true},
+		{pkg.SideSell, "120", "110", true},
+		{pkg.SideSell, "85", "0", false},
+	}
+
+	for _, tt := range tests {
+		price, found := limit.TradePrice(tt.side, d(tt.maker_price))
+		if found != tt.found || !price.Equal(d(tt.price)) {
+			t.Errorf("TradePrice(%s, %s) = (%s, %v), want (%s, %v)", tt.side, tt.maker_price, price, found, tt.price, tt.found)
+		}
+	}
+}
+
+func TestPriceLimitRollover(t *testing.T) {
+	d := decimal.RequireFromString
+	fake := clock.NewFake(time.Date(2022, 5, 1, 23, 59, 0, 0, time.UTC))
+	ob, publisher := newTestOrderBook(d("100"), OrderBookConfig{DailyPriceLimit: d("0.1"), PreviousClose: d("100")}, fake)
+
+	// last trade of the day at 105
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "105", "1"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "105", "1"))
+
+	if len(publisher.Trades) != 1 || !ob.MarketPrice.Equal(d("105")) {
+		t.Fatalf("expected a trade at 105, got %d trades and market price %s", len(publisher.Trades), ob.MarketPrice)
+	}
+
+	rejected := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "112", "1")
+	ob.Add(rejected)
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0].ID != rejected.ID || publisher.Cancels[0].Reason != CancelReasonPriceLimit {
+		t.Fatalf("expected order %d to be cancelled by the price limit, got %+v", rejected.ID, publisher.Cancels)
+	}
+
+	fake.Set(time.Date(2022, 5, 2, 0, 0, 0, 0, time.UTC))
+
+	accepted := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "112", "1")
+	ob.Add(accepted)
+
+	if !ob.PriceLimit.ReferenceClose.Equal(d("105")) {
+		t.Fatalf("expected reference close 105 after rollover, got %s", ob.PriceLimit.ReferenceClose)
+	}
+
+	if len(publisher.Cancels) != 1 {
+		t.Fatalf("expected order %d to be accepted, got cancels %+v", accepted.ID, publisher.Cancels)
+	}
+
+	if ob.Depth.Bids.Size() != 1 {
+		t.Errorf("expected order %d to rest in the book", accepted.ID)
+	}
+}
+
+func TestPriceLimitRestingOrdersOutOfBand(t *testing.T) {
+	d := decimal.RequireFromString
+	fake := clock.NewFake(time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC))
+	book_config := OrderBookConfig{DailyPriceLimit: d("0.1"), PreviousClose: d("100")}
+
+	t.Run("market taker stops at the band", func(t *testing.T) {
+		ob, publisher := newTestOrderBook(d("100"), book_config, fake)
+
+		ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "108", "1"))
+		ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "92", "1"))
+
+		// band moves to 81 - 99, the ask at 108 is out of reach
+		ob.PriceLimit.ReferenceClose = d("90")
+
+		market := newTestOrder(pkg.SideBuy, pkg.TypeMarket, "", "2")
+		ob.Add(market)
+
+		if len(publisher.Trades) != 1 || !publisher.Trades[0].Price.Equal(d("92")) {
+			t.Fatalf("expected a single trade at 92, got %+v", publisher.Trades)
+		}
+
+		if len(publisher.Cancels) != 1 || publisher.Cancels[0].ID != market.ID || publisher.Cancels[0].Reason != CancelReasonPriceLimit {
+			t.Fatalf("expected market order %d to be cancelled by the price limit, got %+v", market.ID, publisher.Cancels)
+		}
+	})
+
+	t.Run("maker beyond the band trades at the bound", func(t *testing.T) {
+		ob, publisher := newTestOrderBook(d("100"), book_config, fake)
+
+		ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "105", "1"))
+
+		// band moves to 81 - 99, the bid at 105 is above it
+		ob.PriceLimit.ReferenceClose = d("90")
+
+		ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "95", "1"))
+
+		if len(publisher.Trades) != 1 || !publisher.Trades[0].Price.Equal(d("99")) {
+			t.Fatalf("expected a trade at 99, got %+v", publisher.Trades)
+		}
+
+		if len(publisher.Cancels) != 0 {
+			t.Errorf("expected no cancels, got %+v", publisher.Cancels)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package matching
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zsmartex/pkg"
+)
+
+// PrometheusRegistry holds the Prometheus metrics of the engines of the process, the status router of the engine
+// serves it on /metrics.
+var PrometheusRegistry = prometheus.NewRegistry()
+
+var (
+	ordersSubmitted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "finex",
+		Subsystem: "matching",
+		Name:      "orders_submitted_total",
+		Help:      "Orders submitted to the book of the market, one by one or in batches.",
+	}, []string{"market"})
+
+	ordersCancelled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "finex",
+		Subsystem: "matching",
+		Name:      "orders_cancelled_total",
+		Help:      "Orders taken out of the book of the market, by their member (an empty reason) or by the book.",
+	}, []string{"market", "reason"})
+
+	ordersRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "finex",
+		Subsystem: "matching",
+		Name:      "orders_rejected_total",
+		Help:      "Orders the book of the market refused to take, by reason.",
+	}, []string{"market", "reason"})
+
+	tradesExecuted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "finex",
+		Subsystem: "matching",
+		Name:      "trades_total",
+		Help:      "Trades matched by the book of the market.",
+	}, []string{"market"})
+
+	tradedVolume = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "finex",
+		Subsystem: "matching",
+		Name:      "traded_volume_total",
+		Help:      "Base quantity traded by the book of the market.",
+	}, []string{"market"})
+
+	matchLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "finex",
+		Subsystem: "matching",
+		Name:      "match_latency_seconds",
+		Help:      "Time from the engine taking a command of the market to the last event of the command published.",
+		// the buckets of the cycle latency histogram, from 10µs up to ~0.3s
+		Buckets: prometheus.ExponentialBuckets(latencyBucketBase.Seconds(), 2, 16),
+	}, []string{"market"})
+)
+
+func init() {
+	PrometheusRegistry.MustRegister(ordersSubmitted, ordersCancelled, ordersRejected, tradesExecuted, tradedVolume, matchLatency)
+}
+
+// rejectReasons are the cancels of the orders the book refused, the other cancels take out orders it took.
+var rejectReasons = map[CancelReason]bool{
+	CancelReasonPriceLimit:      true,
+	CancelReasonReplaceRejected: true,
+	CancelReasonOrderSize:       true,
+	CancelReasonPostOnly:        true,
+	CancelReasonCancelOnly:      true,
+	CancelReasonInvalidPrice:    true,
+	CancelReasonHalted:          true,
+}
+
+// MarketLabel is the market label of the metrics of symbol, the market id.
+func MarketLabel(symbol pkg.Symbol) string {
+	return strings.ToLower(symbol.ToSymbol(""))
+}
+
+// marketMetrics are the Prometheus metrics of a market, their labels are looked up once when the engine is built
+// rather than on the matching path.
+type marketMetrics struct {
+	submitted prometheus.Counter
+	// requested counts the cancels of the members, the most common ones
+	requested prometheus.Counter
+	cancelled *prometheus.CounterVec
+	rejected  *prometheus.CounterVec
+	trades    prometheus.Counter
+	volume    prometheus.Counter
+	latency   prometheus.Observer
+}
+
+func newMarketMetrics(symbol pkg.Symbol) *marketMetrics {
+	market := prometheus.Labels{"market": MarketLabel(symbol)}
+
+	return &marketMetrics{
+		submitted: ordersSubmitted.With(market),
+		requested: ordersCancelled.MustCurryWith(market).WithLabelValues(""),
+		cancelled: ordersCancelled.MustCurryWith(market),
+		rejected:  ordersRejected.MustCurryWith(market),
+		trades:    tradesExecuted.With(market),
+		volume:    tradedVolume.With(market),
+		latency:   matchLatency.With(market),
+	}
+}
+
+// cancel counts the cancel of an order for reason.
+func (m *marketMetrics) cancel(reason CancelReason) {
+	switch {
+	case len(reason) == 0:
+		m.requested.Inc()
+	case rejectReasons[reason]:
+		m.rejected.WithLabelValues(string(reason)).Inc()
+	default:
+		m.cancelled.WithLabelValues(string(reason)).Inc()
+	}
+}
+
+// trade counts a trade, its quantity is the only decimal converted on the matching path.
+func (m *marketMetrics) trade(trade *pkg.Trade) {
+	m.trades.Inc()
+	m.volume.Add(trade.Quantity.InexactFloat64())
+}
+
+// OrderCounts returns the number of orders resting on each side of the depth, the icebergs count once.
+func (d *Depth) OrderCounts() (bids, asks int) {
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
+
+	for _, price_level := range d.Bids.Values() {
+		bids += price_level.(*PriceLevel).Size()
+	}
+
+	for _, price_level := range d.Asks.Values() {
+		asks += price_level.(*PriceLevel).Size()
+	}
+
+	return
+}
@@ -0,0 +1,103 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/types"
+)
+
+// matchLatencyCount returns the number of cycles of market observed by the latency histogram.
+func matchLatencyCount(t *testing.T, market string) uint64 {
+	families, err := PrometheusRegistry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != "finex_matching_match_latency_seconds" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "market" && label.GetValue() == market {
+					return metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+
+	return 0
+}
+
+func TestPrometheusMetricsFollowTheEngine(t *testing.T) {
+	market := MarketLabel(testSymbol)
+	submitted := func() float64 { return testutil.ToFloat64(ordersSubmitted.WithLabelValues(market)) }
+	cancelled := func(reason CancelReason) float64 {
+		return testutil.ToFloat64(ordersCancelled.WithLabelValues(market, string(reason)))
+	}
+	rejected := func(reason CancelReason) float64 {
+		return testutil.ToFloat64(ordersRejected.WithLabelValues(market, string(reason)))
+	}
+	trades := func() float64 { return testutil.ToFloat64(tradesExecuted.WithLabelValues(market)) }
+	volume := func() float64 { return testutil.ToFloat64(tradedVolume.WithLabelValues(market)) }
+
+	// the other tests of the package matched in the same market, the metrics are compared to the ones before
+	submitted_before, trades_before, volume_before := submitted(), trades(), volume()
+	requested_before, ioc_before, post_only_before := cancelled(""), cancelled(CancelReasonImmediateOrCancel), rejected(CancelReasonPostOnly)
+	cycles_before := matchLatencyCount(t, market)
+
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	engine := newEngine(testSymbol, ob, 0)
+
+	engine.Submit(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+	ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "102", "1")
+	engine.Submit(ask)
+	engine.Submit(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1"))
+
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "0.4")
+	taker.MemberID = 2
+	engine.Submit(taker)
+
+	post_only := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "102", "1")
+	post_only.MemberID = 2
+	engine.SubmitWithOptions(post_only, &events.OrderOptions{PostOnly: true})
+
+	ioc := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "90", "1")
+	engine.SubmitWithOptions(ioc, &events.OrderOptions{TimeInForce: types.TimeInForceIOC})
+
+	engine.CancelWithKey(ask.Key())
+
+	if got := submitted() - submitted_before; got != 6 {
+		t.Errorf("expected 6 orders submitted, got %v", got)
+	}
+
+	if got := trades() - trades_before; got != 1 {
+		t.Errorf("expected 1 trade, got %v", got)
+	}
+
+	if got := volume() - volume_before; got < 0.3999 || got > 0.4001 {
+		t.Errorf("expected 0.4 traded, got %v", got)
+	}
+
+	if got := rejected(CancelReasonPostOnly) - post_only_before; got != 1 {
+		t.Errorf("expected the post only order to be rejected, got %v", got)
+	}
+
+	if requested, ioc := cancelled("")-requested_before, cancelled(CancelReasonImmediateOrCancel)-ioc_before; requested != 1 || ioc != 1 {
+		t.Errorf("expected a cancel of the member and one of the immediate or cancel order, got %v and %v", requested, ioc)
+	}
+
+	if got := matchLatencyCount(t, market) - cycles_before; got != 7 {
+		t.Errorf("expected the latency of the 7 commands to be observed, got %d", got)
+	}
+
+	if bids, asks := ob.Depth.OrderCounts(); bids != 1 || asks != 1 {
+		t.Errorf("expected an order resting on each side, got %d bids and %d asks", bids, asks)
+	}
+}
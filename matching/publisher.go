@@ -0,0 +1,218 @@
+package matching
+
+import (
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/pkg"
+)
+
+// CancelReason tells the order processor why the engine cancelled an order,
+// it's empty for cancels requested by the member.
+type CancelReason string
+
+const (
+	CancelReasonPriceLimit CancelReason = "price_limit"
+	// CancelReasonReplaceRejected cancels the replacement of an order which wasn't in the book anymore.
+	CancelReasonReplaceRejected CancelReason = "replace_rejected"
+	// CancelReasonOrderSize cancels an order outside the size limits of its market.
+	CancelReasonOrderSize CancelReason = "order_size"
+	// CancelReasonPostOnly cancels a post-only order which would have taken liquidity.
+	CancelReasonPostOnly CancelReason = "post_only"
+	// CancelReasonCancelOnly cancels an order submitted to a market which only takes cancels, a market being delisted.
+	CancelReasonCancelOnly CancelReason = "cancel_only"
+	// CancelReasonImmediateOrCancel cancels the part of an immediate-or-cancel order which wasn't matched right away.
+	CancelReasonImmediateOrCancel CancelReason = "immediate_or_cancel"
+	// CancelReasonFillOrKill cancels a fill-or-kill order the book couldn't match in full, nothing of it was matched.
+	CancelReasonFillOrKill CancelReason = "fill_or_kill"
+	// CancelReasonSelfTrade cancels an order which would have matched an order of the same member.
+	CancelReasonSelfTrade CancelReason = "self_trade"
+	// CancelReasonAlreadyGone answers a cancel command of an order which left the book before it.
+	CancelReasonAlreadyGone CancelReason = "already_gone"
+	// CancelReasonNeverExisted answers a cancel command of an order the book has no trace of.
+	CancelReasonNeverExisted CancelReason = "never_existed"
+	// CancelReasonPrecision reprices an order to the price precision of its market, or cancels it when its price
+	// can't be represented at the precision.
+	CancelReasonPrecision CancelReason = "precision"
+	// CancelReasonExpired cancels a good-till-date order past its expiry.
+	CancelReasonExpired CancelReason = "expired"
+	// CancelReasonSlippage cancels the rest of a market order the book would have matched past its max slippage.
+	CancelReasonSlippage CancelReason = "slippage"
+	// CancelReasonMinNotional cancels what a partially filled order has left when it's worth less than the minimum
+	// notional of its market.
+	CancelReasonMinNotional CancelReason = "min_notional"
+	// CancelReasonDust cancels what a partially filled order has left when it's less than the minimum amount of its
+	// market, no order could take it.
+	CancelReasonDust CancelReason = "dust"
+	// CancelReasonInvalidPrice cancels an order of a batch with a negative price or stop price.
+	CancelReasonInvalidPrice CancelReason = "invalid_price"
+	// CancelReasonHalted cancels an order submitted to a halted market, and answers a cancel command the halted
+	// market refused.
+	CancelReasonHalted CancelReason = "halted"
+)
+
+// TradeStamp is what the book knew of a trade when it matched it.
+type TradeStamp struct {
+	// Sequence numbers the trade among the trades of the market
+	Sequence int64
+	// MatchedAt is the time of the clock of the book
+	MatchedAt time.Time
+	// ConfigVersion is the version of the market configuration the book was built with
+	ConfigVersion int64
+	// Fees are the fees of the maker and the taker at the rates of the book
+	Fees TradeFees
+}
+
+// Publisher delivers the orderbook output to the workers.
+type Publisher interface {
+	// PublishTrade reports a trade of the book, the book reuses the trade once it returns: a publisher keeping the
+	// trade keeps a copy of it.
+	PublishTrade(trade *pkg.Trade, stamp TradeStamp)
+	PublishCancel(key *pkg.OrderKey, reason CancelReason)
+	// PublishCancelOutcome answers the cancel command command_id with what it found.
+	PublishCancelOutcome(key *pkg.OrderKey, outcome CancelOutcome, command_id string)
+	// PublishReplace reports the outcome of a cancel-replace, it's published before the replacement is matched.
+	PublishReplace(replaced_key *pkg.OrderKey, order *pkg.Order, accepted bool)
+	// PublishDecrement reports a quantity the engine took off an order it keeps in the book.
+	PublishDecrement(key *pkg.OrderKey, quantity decimal.Decimal, reason CancelReason)
+	// PublishReprice reports the engine moved an order it keeps in the book to the price and the stop price of key.
+	PublishReprice(key *pkg.OrderKey, reason CancelReason)
+	// PublishAmend reports the outcome of an amend, key has the price and quantity the whole quantity of the
+	// amended order. It's published before the order is matched.
+	PublishAmend(key *pkg.OrderKey, quantity decimal.Decimal, accepted bool)
+	// PublishBatch reports the outcome of the entries of the batch batch_id, in their order. It's published after
+	// the output of the entries.
+	PublishBatch(batch_id string, entries []*events.BatchEntry)
+	// PublishCircuitBreak reports the circuit breaker of a book tripped or was lifted.
+	PublishCircuitBreak(circuit_break *CircuitBreak)
+}
+
+// BusPublisher publishes trades to the trade executor and cancels to the order processor, keyed by the market of the
+// book so the events of a market are consumed in the order the engine published them.
+type BusPublisher struct {
+	Market string
+}
+
+func NewBusPublisher(symbol pkg.Symbol) *BusPublisher {
+	return &BusPublisher{Market: strings.ToLower(symbol.ToSymbol(""))}
+}
+
+func (p *BusPublisher) publish(topic string, payload interface{}) {
+	if err := config.Bus.Publish(topic, p.Market, payload); err != nil {
+		config.Logger.Errorf("Failed to publish to %s the event of %s: %v", topic, p.Market, err)
+	}
+}
+
+func (p *BusPublisher) PublishTrade(trade *pkg.Trade, stamp TradeStamp) {
+	p.publish("trade_executor", events.EncodeTrade(TradeEvent(trade, stamp)))
+}
+
+// TradeEvent returns the trade event of a trade of the book stamped with stamp, as the engine publishes it.
+func TradeEvent(trade *pkg.Trade, stamp TradeStamp) *events.Trade {
+	event := events.NewTrade(trade)
+	event.Sequence = stamp.Sequence
+	event.MatchedAt = &stamp.MatchedAt
+	event.ConfigVersion = stamp.ConfigVersion
+	event.MakerFee = &stamp.Fees.MakerFee
+	event.MakerFeeCurrency = stamp.Fees.MakerFeeCurrency
+	event.TakerFee = &stamp.Fees.TakerFee
+	event.TakerFeeCurrency = stamp.Fees.TakerFeeCurrency
+
+	return event
+}
+
+func (p *BusPublisher) PublishCancel(key *pkg.OrderKey, reason CancelReason) {
+	p.publish("order_processor", events.EncodeOrder(events.NewOrder(pkg.ActionCancel, key.ID, key.UUID, string(reason))))
+}
+
+func (p *BusPublisher) PublishCancelOutcome(key *pkg.OrderKey, outcome CancelOutcome, command_id string) {
+	event := cancelOutcomeEvent(key, outcome, command_id, events.ProducesCancelOutcomes())
+	if event == nil {
+		return
+	}
+
+	p.publish("order_processor", events.EncodeOrder(event))
+}
+
+// cancelOutcomeEvent is the order event of the outcome of a cancel command. Every outcome is a cancel
+// when the producers don't tell them apart yet, as every cancel command was, but a rejected cancel which has
+// no event then: the order is still in the book.
+func cancelOutcomeEvent(key *pkg.OrderKey, outcome CancelOutcome, command_id string, distinct bool) *events.Order {
+	if !distinct {
+		if outcome == CancelOutcomeRejected {
+			return nil
+		}
+
+		return events.NewOrder(pkg.ActionCancel, key.ID, key.UUID, "")
+	}
+
+	switch outcome {
+	case CancelOutcomeRejected:
+		return events.NewOrderCancelOutcome(events.ActionCancelRejected, key.ID, key.UUID, string(CancelReasonHalted), command_id)
+	case CancelOutcomeAlreadyGone:
+		return events.NewOrderCancelOutcome(events.ActionCancelAlreadyGone, key.ID, key.UUID, string(CancelReasonAlreadyGone), command_id)
+	case CancelOutcomeNeverExisted:
+		return events.NewOrderCancelOutcome(events.ActionCancelNeverExisted, key.ID, key.UUID, string(CancelReasonNeverExisted), command_id)
+	default:
+		return events.NewOrderCancelOutcome(pkg.ActionCancel, key.ID, key.UUID, "", command_id)
+	}
+}
+
+func (p *BusPublisher) PublishReplace(replaced_key *pkg.OrderKey, order *pkg.Order, accepted bool) {
+	event := events.NewOrder(events.ActionCancelReplace, order.ID, order.UUID, "")
+	if !accepted {
+		event = events.NewOrder(pkg.ActionCancel, order.ID, order.UUID, string(CancelReasonReplaceRejected))
+	}
+	event.ReplacedID = replaced_key.ID
+
+	p.publish("order_processor", events.EncodeOrder(event))
+}
+
+func (p *BusPublisher) PublishDecrement(key *pkg.OrderKey, quantity decimal.Decimal, reason CancelReason) {
+	p.publish("order_processor", events.EncodeOrder(events.NewOrderDecrement(key.ID, key.UUID, quantity, string(reason))))
+}
+
+func (p *BusPublisher) PublishReprice(key *pkg.OrderKey, reason CancelReason) {
+	p.publish("order_processor", events.EncodeOrder(events.NewOrderReprice(key.ID, key.UUID, key.Price, key.StopPrice, string(reason))))
+}
+
+func (p *BusPublisher) PublishAmend(key *pkg.OrderKey, quantity decimal.Decimal, accepted bool) {
+	event := events.NewOrderAmend(key.ID, key.UUID, key.Price, quantity)
+	if !accepted {
+		event = events.NewOrder(events.ActionAmendRejected, key.ID, key.UUID, "")
+	}
+
+	p.publish("order_processor", events.EncodeOrder(event))
+}
+
+func (p *BusPublisher) PublishBatch(batch_id string, entries []*events.BatchEntry) {
+	p.publish("order_processor", events.EncodeOrder(events.NewOrderBatch(events.ActionBatchResult, &events.Batch{ID: batch_id, Entries: entries})))
+}
+
+// PublishCircuitBreak tells the clients connected to the market its book takes cancels only, or orders again.
+func (p *BusPublisher) PublishCircuitBreak(circuit_break *CircuitBreak) {
+	market := strings.ToLower(circuit_break.Symbol.ToSymbol(""))
+	config.RangoClient.EnqueueEvent(pkg.EnqueueEventKindPublic, market, "market_state", circuitBreakEvent(market, circuit_break))
+}
+
+// circuitBreakEvent is the market state event of a circuit breaker tripping or lifted.
+func circuitBreakEvent(market string, circuit_break *CircuitBreak) *events.MarketStateEvent {
+	event := &events.MarketStateEvent{
+		Market:       market,
+		TradingState: circuit_break.State,
+		Reason:       string(CancelReasonCircuitBreaker),
+		Price:        &circuit_break.Price,
+		At:           circuit_break.At.Unix(),
+	}
+
+	if circuit_break.Tripped {
+		event.Reference = &circuit_break.Reference
+		event.Until = circuit_break.Until.Unix()
+	}
+
+	return event
+}
@@ -0,0 +1,79 @@
+package matching
+
+import (
+	"errors"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+// ErrOrderNotResting is returned for the position of an order which isn't resting in the book, it was filled,
+// cancelled or it's a stop order waiting for its stop price.
+var ErrOrderNotResting = errors.New("matching.order_not_resting")
+
+// QueuePosition is what's ahead of an order resting in the book. The quantities are the ones the book shows, the
+// hidden quantity of the icebergs isn't in them.
+type QueuePosition struct {
+	ID    int64           `json:"id"`
+	Side  pkg.OrderSide   `json:"side"`
+	Price decimal.Decimal `json:"price"`
+	// QuantityAhead is the quantity of the orders of the side at a better price, or at the price with priority
+	QuantityAhead decimal.Decimal `json:"quantity_ahead"`
+	// QuantityAheadAtPrice and OrdersAheadAtPrice are the ones of the orders at the price with priority
+	QuantityAheadAtPrice decimal.Decimal `json:"quantity_ahead_at_price"`
+	OrdersAheadAtPrice   int             `json:"orders_ahead_at_price"`
+}
+
+// PositionOf returns the position of the order of id in the queue of its side, see Depth.PositionOf.
+func (e *Engine) PositionOf(id int64) (*QueuePosition, error) {
+	e.MatchingMutex.RLock()
+	defer e.MatchingMutex.RUnlock()
+
+	return e.OrderBook.Depth.PositionOf(id)
+}
+
+// PositionOf returns the position of the order of id in the queue of its side, ErrOrderNotResting when it isn't in
+// the book anymore. It walks the levels better than the one of the order and the orders before it at its price.
+func (d *Depth) PositionOf(id int64) (*QueuePosition, error) {
+	d.depthMutex.RLock()
+	defer d.depthMutex.RUnlock()
+
+	o, found := d.resting[id]
+	if !found {
+		return nil, ErrOrderNotResting
+	}
+
+	position := &QueuePosition{ID: o.ID, Side: o.Side, Price: o.Price, QuantityAhead: decimal.Zero, QuantityAheadAtPrice: decimal.Zero}
+
+	it := d.levels(o.Side).Iterator()
+	it.End()
+	for it.Prev() {
+		price_level := it.Value().(*PriceLevel)
+		if o.Side == pkg.SideSell && price_level.Price.GreaterThan(o.Price) || o.Side == pkg.SideBuy && price_level.Price.LessThan(o.Price) {
+			break
+		}
+
+		if !price_level.Price.Equal(o.Price) {
+			position.QuantityAhead = position.QuantityAhead.Add(d.shown(price_level))
+			continue
+		}
+
+		price_level.Lock()
+		defer price_level.Unlock()
+
+		for _, value := range price_level.Orders.Values() {
+			if value.(*pkg.Order).ID == o.ID {
+				position.QuantityAhead = position.QuantityAhead.Add(position.QuantityAheadAtPrice)
+
+				return position, nil
+			}
+
+			position.OrdersAheadAtPrice++
+			position.QuantityAheadAtPrice = position.QuantityAheadAtPrice.Add(d.shownQuantity(value.(*pkg.Order)))
+		}
+
+		break
+	}
+
+	return nil, ErrOrderNotResting
+}
@@ -0,0 +1,42 @@
+package matching
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func TestPositionOf(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{Flags: FeatureFlags{FifoTiebreakV2: true}}, nil)
+	engine := newEngine(testSymbol, ob, 0)
+
+	engine.Submit(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99.5", "2"))
+	engine.SubmitWithOptions(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99.5", "10"), display("1"))
+	engine.Submit(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "3"))
+	mine := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1")
+	engine.Submit(mine)
+	engine.Submit(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "5"))
+	engine.Submit(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "98", "7"))
+
+	position, err := engine.PositionOf(mine.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the better level shows 2 and the slice of the iceberg
+	if position.OrdersAheadAtPrice != 1 || !position.QuantityAheadAtPrice.Equal(decimal.NewFromInt(3)) || !position.QuantityAhead.Equal(decimal.NewFromInt(6)) {
+		t.Errorf("unexpected position %+v", position)
+	}
+
+	// the order is filled between the query and the lookup
+	engine.Submit(newTestOrder(pkg.SideSell, pkg.TypeLimit, "99", "20"))
+	if _, err := engine.PositionOf(mine.ID); !errors.Is(err, ErrOrderNotResting) {
+		t.Errorf("expected a filled order to be reported not resting, got %v", err)
+	}
+
+	if _, err := engine.PositionOf(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1").ID); !errors.Is(err, ErrOrderNotResting) {
+		t.Errorf("expected an unknown order to be reported not resting, got %v", err)
+	}
+}
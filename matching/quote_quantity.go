@@ -0,0 +1,48 @@
+package matching
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+// CancelReasonQuoteQuantity cancels the rest of a market buy of a quote quantity the book can't fill: what it has
+// left to spend can't buy a lot at the next price level, or no level is left.
+const CancelReasonQuoteQuantity CancelReason = "quote_quantity"
+
+// quoteQuantity returns the quote amount a market buy has to spend, false when only its quantity limits it.
+func (ob *OrderBook) quoteQuantity(o *pkg.Order) (decimal.Decimal, bool) {
+	quote_quantity := ob.optionsOf(o).QuoteQuantity
+	if o.Type != pkg.TypeMarket || o.Side != pkg.SideBuy || quote_quantity == nil {
+		return decimal.Zero, false
+	}
+
+	return *quote_quantity, true
+}
+
+// affordable returns the quantity budget buys at price, rounded down to the lot size of the market or to its amount
+// precision when it has none. The trade of the quantity at price is never worth more than budget.
+func (l OrderSizeLimits) affordable(budget, price decimal.Decimal) decimal.Decimal {
+	if !budget.IsPositive() || !price.IsPositive() {
+		return decimal.Zero
+	}
+
+	step := l.LotSize
+	if !step.IsPositive() {
+		step = decimal.New(1, -l.AmountPrecision)
+	}
+
+	quantity := budget.Div(price.Mul(step)).Floor().Mul(step)
+	// the division is rounded, it can round up to a lot the budget can't buy
+	if price.Mul(quantity).GreaterThan(budget) {
+		quantity = quantity.Sub(step)
+	}
+
+	return quantity
+}
+
+// stopSpending cancels the rest of a market buy of a quote quantity the book can't fill.
+func (ob *OrderBook) stopSpending(o *pkg.Order) {
+	if !o.IsFake() {
+		ob.PublishCancel(o.Key(), CancelReasonQuoteQuantity)
+	}
+}
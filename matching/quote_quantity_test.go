@@ -0,0 +1,102 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/events"
+)
+
+func quoteQuantity(amount string) *events.OrderOptions {
+	q := decimal.RequireFromString(amount)
+
+	return &events.OrderOptions{QuoteQuantity: &q}
+}
+
+func TestQuoteQuantitySpendsTheBudget(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{
+		SizeLimits: OrderSizeLimits{LotSize: decimal.RequireFromString("0.1")},
+	}, nil)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "110", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "120", "5"))
+
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeMarket, "", "10")
+	ob.add(taker, quoteQuantity("250"))
+
+	// 100 at 100 and 110 at 110 leave 40, which buys 0.3 at 120
+	expected := []struct{ price, quantity string }{{"100", "1"}, {"110", "1"}, {"120", "0.3"}}
+	if len(publisher.Trades) != len(expected) {
+		t.Fatalf("expected %d trades, got %d", len(expected), len(publisher.Trades))
+	}
+
+	spent := decimal.Zero
+	for i, trade := range publisher.Trades {
+		if !trade.Price.Equal(decimal.RequireFromString(expected[i].price)) || !trade.Quantity.Equal(decimal.RequireFromString(expected[i].quantity)) {
+			t.Errorf("expected trade %d of %s at %s, got %s at %s", i, expected[i].quantity, expected[i].price, trade.Quantity, trade.Price)
+		}
+
+		if !trade.Total.Equal(trade.Price.Mul(trade.Quantity)) {
+			t.Errorf("expected trade %d to total its quantity at its price, got %s", i, trade.Total)
+		}
+		spent = spent.Add(trade.Total)
+	}
+
+	if !spent.Equal(decimal.NewFromInt(246)) {
+		t.Errorf("expected 246 spent, got %s", spent)
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0] != (cancelRecord{ID: taker.ID, Reason: CancelReasonQuoteQuantity}) {
+		t.Errorf("expected the rest of the order to be cancelled, got %+v", publisher.Cancels)
+	}
+}
+
+func TestQuoteQuantityBeyondTheBook(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1"))
+
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeMarket, "", "10")
+	ob.add(taker, quoteQuantity("1000"))
+
+	if len(publisher.Trades) != 1 || len(publisher.Cancels) != 1 || publisher.Cancels[0].Reason != CancelReasonQuoteQuantity {
+		t.Errorf("expected the rest of the order to be cancelled once the asks are taken, got %d trades and %+v", len(publisher.Trades), publisher.Cancels)
+	}
+}
+
+func TestQuoteQuantityCappedByQuantity(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "5"))
+
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeMarket, "", "2")
+	ob.add(taker, quoteQuantity("1000"))
+
+	if len(publisher.Trades) != 1 || !publisher.Trades[0].Quantity.Equal(decimal.NewFromInt(2)) || len(publisher.Cancels) != 0 {
+		t.Errorf("expected the order to buy its quantity, got %d trades and %+v", len(publisher.Trades), publisher.Cancels)
+	}
+}
+
+func TestAffordable(t *testing.T) {
+	d := decimal.RequireFromString
+
+	cases := []struct {
+		limits                OrderSizeLimits
+		budget, price, expect string
+	}{
+		{OrderSizeLimits{AmountPrecision: 2}, "10", "3", "3.33"},
+		{OrderSizeLimits{AmountPrecision: 0}, "10", "3", "3"},
+		{OrderSizeLimits{LotSize: d("0.5"), AmountPrecision: 4}, "10", "3", "3"},
+		{OrderSizeLimits{LotSize: d("0.5")}, "1", "3", "0"},
+		{OrderSizeLimits{AmountPrecision: 8}, "0", "3", "0"},
+	}
+
+	for _, c := range cases {
+		if affordable := c.limits.affordable(d(c.budget), d(c.price)); !affordable.Equal(d(c.expect)) {
+			t.Errorf("expected %s to buy %s at %s, got %s", c.budget, c.expect, c.price, affordable)
+		}
+	}
+}
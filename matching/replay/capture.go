@@ -0,0 +1,402 @@
+// Package replay captures the commands of the engine with the output they produced, and replays them
+// into a book off the broker to check it still produces the same trades and depth.
+package replay
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/matching"
+	"github.com/zsmartex/pkg"
+)
+
+// Kind is what a record of a capture holds.
+type Kind string
+
+const (
+	// KindBook is the state of a book when the capture started or the engine reloaded it
+	KindBook Kind = "book"
+	// KindCommand is a command the engine processed
+	KindCommand Kind = "command"
+	// KindTrade is a trade the engine matched
+	KindTrade Kind = "trade"
+	// KindDepth is a checkpoint of the price levels of a book
+	KindDepth Kind = "depth"
+)
+
+// Record is a line of a capture, the records are in the order the engine processed or produced them.
+type Record struct {
+	Kind   Kind      `json:"kind"`
+	At     time.Time `json:"at"`
+	Market string    `json:"market"`
+
+	Book    *BookState              `json:"book,omitempty"`
+	Command *events.MatchingPayload `json:"command,omitempty"`
+	Trade   *pkg.Trade              `json:"trade,omitempty"`
+	Depth   *DepthLevels            `json:"depth,omitempty"`
+}
+
+// BookState is what a book is rebuilt from, its settings and the orders resting in it.
+type BookState struct {
+	Symbol          pkg.Symbol               `json:"symbol"`
+	MarketPrice     decimal.Decimal          `json:"market_price"`
+	DailyPriceLimit decimal.Decimal          `json:"daily_price_limit"`
+	PreviousClose   decimal.Decimal          `json:"previous_close"`
+	BatchInterval   time.Duration            `json:"batch_interval"`
+	ConfigVersion   int64                    `json:"config_version"`
+	Flags           matching.FeatureFlags    `json:"flags"`
+	SizeLimits      matching.OrderSizeLimits `json:"size_limits"`
+	Fees            matching.FeeSchedule     `json:"fees"`
+	// Orders are the orders of the book then its stop orders
+	Orders []*pkg.Order `json:"orders"`
+	// Options are the options of the orders submitted with some, by order id
+	Options map[int64]*events.OrderOptions `json:"options,omitempty"`
+}
+
+// DepthLevels are the price and the total of the price levels of a book, best first.
+type DepthLevels struct {
+	Asks [][]decimal.Decimal `json:"asks"`
+	Bids [][]decimal.Decimal `json:"bids"`
+}
+
+// Equal reports whether both books have the same levels.
+func (d *DepthLevels) Equal(other *DepthLevels) bool {
+	return equalLevels(d.Asks, other.Asks) && equalLevels(d.Bids, other.Bids)
+}
+
+func equalLevels(a, b [][]decimal.Decimal) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !a[i][0].Equal(b[i][0]) || !a[i][1].Equal(b[i][1]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func depthOf(engine *matching.Engine) *DepthLevels {
+	asks, bids := engine.OrderBook.Depth.Levels()
+
+	return &DepthLevels{Asks: asks, Bids: bids}
+}
+
+// MarketOf is the market of a symbol as records name it.
+func MarketOf(symbol pkg.Symbol) string {
+	return strings.ToLower(symbol.ToSymbol(""))
+}
+
+// Recorder writes the records of a capture, member IDs are replaced by a keyed hash of them
+// so a capture taken in production doesn't tell who placed the orders.
+type Recorder struct {
+	mutex  sync.Mutex
+	writer *bufio.Writer
+	closer io.Closer
+	salt   []byte
+	// checkpoint is the time between two depth records of a book, zero records one after every command
+	checkpoint  time.Duration
+	checkpoints map[string]time.Time
+}
+
+func NewRecorder(w io.Writer, salt []byte, checkpoint time.Duration) *Recorder {
+	return &Recorder{
+		writer:      bufio.NewWriter(w),
+		salt:        salt,
+		checkpoint:  checkpoint,
+		checkpoints: make(map[string]time.Time),
+	}
+}
+
+// Create starts a capture in a new file of dir named after now. Without salt the member IDs are hashed
+// with a random key, they can't be told apart across captures but can't be guessed either.
+func Create(dir string, salt string, checkpoint time.Duration, now time.Time) (*Recorder, error) {
+	key := []byte(salt)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(filepath.Join(dir, "capture-"+now.UTC().Format("20060102T150405Z")+".jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	recorder := NewRecorder(file, key, checkpoint)
+	recorder.closer = file
+
+	return recorder, nil
+}
+
+// Anonymize returns the ID a member has in the captures hashed with salt, zero stays zero.
+func Anonymize(salt []byte, member_id int64) int64 {
+	if member_id == 0 {
+		return 0
+	}
+
+	mac := hmac.New(sha256.New, salt)
+	binary.Write(mac, binary.BigEndian, member_id)
+
+	id := int64(binary.BigEndian.Uint64(mac.Sum(nil)) & math.MaxInt64)
+	if id == 0 {
+		return 1
+	}
+
+	return id
+}
+
+func (r *Recorder) anonymize(order *pkg.Order) *pkg.Order {
+	if order == nil {
+		return nil
+	}
+
+	anonymized := *order
+	anonymized.MemberID = Anonymize(r.salt, order.MemberID)
+
+	return &anonymized
+}
+
+func (r *Recorder) write(record *Record) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	// a capture of an engine which is killed keeps every record written before
+	return r.writer.Flush()
+}
+
+// Book records the state of the book of engine, built with book_config, at the time the capture started or the engine was reloaded.
+func (r *Recorder) Book(engine *matching.Engine, book_config matching.OrderBookConfig, at time.Time) error {
+	state := &BookState{
+		Symbol:          engine.Symbol,
+		MarketPrice:     engine.OrderBook.MarketPrice,
+		DailyPriceLimit: book_config.DailyPriceLimit,
+		PreviousClose:   book_config.PreviousClose,
+		BatchInterval:   engine.OrderBook.BatchInterval(),
+		ConfigVersion:   book_config.ConfigVersion,
+		Flags:           book_config.Flags,
+		SizeLimits:      engine.SizeLimits,
+		Fees:            book_config.Fees,
+		Orders:          make([]*pkg.Order, 0),
+		Options:         make(map[int64]*events.OrderOptions),
+	}
+
+	for _, order := range append(engine.OrderBook.Depth.Orders(), engine.OrderBook.StopOrders()...) {
+		state.Orders = append(state.Orders, r.anonymize(order))
+
+		if options := engine.OrderBook.Options(order.ID); options != nil {
+			state.Options[order.ID] = options
+		}
+	}
+
+	if err := r.write(&Record{Kind: KindBook, At: at, Market: MarketOf(engine.Symbol), Book: state}); err != nil {
+		return err
+	}
+
+	return r.writeDepth(engine, at)
+}
+
+// Command records a command processed at at, the commands reloading the engines aren't recorded, the books they build are.
+func (r *Recorder) Command(command *events.MatchingPayload, at time.Time) error {
+	var symbol pkg.Symbol
+	switch {
+	case command.Action == pkg.ActionNew || command.Action == pkg.ActionReload:
+		return nil
+	case command.Action == events.ActionRekey || command.Action == events.ActionTradingState ||
+		command.Action == events.ActionStartAuction || command.Action == events.ActionUncross ||
+		command.Action == events.ActionLiftCircuitBreaker || command.Action == events.ActionIndexPrice:
+		symbol = command.Symbol
+	case command.Action == events.ActionCancelAll:
+		// the cancel alls of every market are recorded for each of them
+		if len(command.Symbol.BaseCurrency) == 0 {
+			return nil
+		}
+		symbol = command.Symbol
+	case command.Key != nil:
+		symbol = command.Key.Symbol
+	case command.Order != nil:
+		symbol = command.Order.Symbol
+	case command.Batch != nil:
+		batch_symbol, found := command.Batch.Symbol()
+		if !found {
+			return nil
+		}
+		symbol = batch_symbol
+	default:
+		return fmt.Errorf("command %s has no order", command.Action)
+	}
+
+	return r.write(&Record{
+		Kind:   KindCommand,
+		At:     at,
+		Market: MarketOf(symbol),
+		Command: &events.MatchingPayload{
+			MatchingPayloadMessage: pkg.MatchingPayloadMessage{
+				Action: command.Action,
+				Order:  r.anonymize(command.Order),
+				Key:    command.Key,
+				Symbol: command.Symbol,
+			},
+			Options:      command.Options,
+			Precision:    command.Precision,
+			Amendment:    command.Amendment,
+			Batch:        r.anonymizeBatch(command.Batch),
+			CancelAll:    r.anonymizeCancelAll(command.CancelAll),
+			TradingState: command.TradingState,
+		},
+	})
+}
+
+// anonymizeCancelAll returns a copy of cancel_all with its member anonymized as the members of the orders are.
+func (r *Recorder) anonymizeCancelAll(cancel_all *events.CancelAll) *events.CancelAll {
+	if cancel_all == nil {
+		return nil
+	}
+
+	return &events.CancelAll{MemberID: Anonymize(r.salt, cancel_all.MemberID), Side: cancel_all.Side}
+}
+
+// anonymizeBatch returns a copy of batch with its orders anonymized.
+func (r *Recorder) anonymizeBatch(batch *events.MatchingBatch) *events.MatchingBatch {
+	if batch == nil {
+		return nil
+	}
+
+	recorded := &events.MatchingBatch{ID: batch.ID, Entries: make([]*events.MatchingPayload, 0, len(batch.Entries))}
+	for _, entry := range batch.Entries {
+		recorded_entry := *entry
+		recorded_entry.Order = r.anonymize(entry.Order)
+		recorded.Entries = append(recorded.Entries, &recorded_entry)
+	}
+
+	return recorded
+}
+
+// Checkpoint records the depth of the book of engine when the checkpoint interval passed since its last one.
+func (r *Recorder) Checkpoint(engine *matching.Engine, at time.Time) error {
+	r.mutex.Lock()
+	last, found := r.checkpoints[MarketOf(engine.Symbol)]
+	r.mutex.Unlock()
+
+	if found && at.Sub(last) < r.checkpoint {
+		return nil
+	}
+
+	return r.writeDepth(engine, at)
+}
+
+func (r *Recorder) writeDepth(engine *matching.Engine, at time.Time) error {
+	market := MarketOf(engine.Symbol)
+
+	r.mutex.Lock()
+	r.checkpoints[market] = at
+	r.mutex.Unlock()
+
+	return r.write(&Record{Kind: KindDepth, At: at, Market: market, Depth: depthOf(engine)})
+}
+
+// Close records the depth of the books of engines and closes the capture.
+func (r *Recorder) Close(engines []*matching.Engine, at time.Time) error {
+	for _, engine := range engines {
+		if err := r.writeDepth(engine, at); err != nil {
+			return err
+		}
+	}
+
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+
+	return nil
+}
+
+// Publisher returns a publisher recording the trades published to next.
+func (r *Recorder) Publisher(next matching.Publisher) matching.Publisher {
+	return &capturePublisher{recorder: r, next: next}
+}
+
+type capturePublisher struct {
+	recorder *Recorder
+	next     matching.Publisher
+}
+
+func (p *capturePublisher) PublishTrade(trade *pkg.Trade, stamp matching.TradeStamp) {
+	recorded := *trade
+	recorded.MakerOrder = *p.recorder.anonymize(&trade.MakerOrder)
+	recorded.TakerOrder = *p.recorder.anonymize(&trade.TakerOrder)
+
+	if err := p.recorder.write(&Record{Kind: KindTrade, At: stamp.MatchedAt, Market: MarketOf(trade.Symbol), Trade: &recorded}); err != nil {
+		captureFailed(err)
+	}
+
+	p.next.PublishTrade(trade, stamp)
+}
+
+func (p *capturePublisher) PublishCancel(key *pkg.OrderKey, reason matching.CancelReason) {
+	p.next.PublishCancel(key, reason)
+}
+
+func (p *capturePublisher) PublishCancelOutcome(key *pkg.OrderKey, outcome matching.CancelOutcome, command_id string) {
+	p.next.PublishCancelOutcome(key, outcome, command_id)
+}
+
+func (p *capturePublisher) PublishReplace(replaced_key *pkg.OrderKey, order *pkg.Order, accepted bool) {
+	p.next.PublishReplace(replaced_key, order, accepted)
+}
+
+func (p *capturePublisher) PublishDecrement(key *pkg.OrderKey, quantity decimal.Decimal, reason matching.CancelReason) {
+	p.next.PublishDecrement(key, quantity, reason)
+}
+
+func (p *capturePublisher) PublishReprice(key *pkg.OrderKey, reason matching.CancelReason) {
+	p.next.PublishReprice(key, reason)
+}
+
+func (p *capturePublisher) PublishAmend(key *pkg.OrderKey, quantity decimal.Decimal, accepted bool) {
+	p.next.PublishAmend(key, quantity, accepted)
+}
+
+func (p *capturePublisher) PublishBatch(batch_id string, entries []*events.BatchEntry) {
+	p.next.PublishBatch(batch_id, entries)
+}
+
+func (p *capturePublisher) PublishCircuitBreak(circuit_break *matching.CircuitBreak) {
+	p.next.PublishCircuitBreak(circuit_break)
+}
+
+// captureFailed logs a record which couldn't be written, the engine keeps running without it.
+func captureFailed(err error) {
+	config.Logger.Errorf("Failed to write engine capture record: %v", err)
+}
@@ -0,0 +1,354 @@
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/matching"
+	"github.com/zsmartex/finex/types"
+	"github.com/zsmartex/pkg"
+)
+
+// Options select the part of a capture replayed and how fast.
+type Options struct {
+	// Markets are the markets replayed, every market of the capture when it's empty
+	Markets []string
+	// From and To bound the commands compared, the commands before From are still replayed to build the books,
+	// as fast as possible. Zero doesn't bound them
+	From time.Time
+	To   time.Time
+	// Speed is how many times faster than they were captured the commands are replayed,
+	// 1 replays them at their original pace and zero as fast as possible
+	Speed float64
+	// Flags override the feature flags the books were captured with
+	Flags map[types.FeatureFlag]bool
+	// Sleep waits between two commands, time.Sleep when it's nil
+	Sleep func(time.Duration)
+}
+
+func (o *Options) replays(market string) bool {
+	if len(o.Markets) == 0 {
+		return true
+	}
+
+	for _, m := range o.Markets {
+		if m == market {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o *Options) compares(at time.Time) bool {
+	return (o.From.IsZero() || !at.Before(o.From)) && (o.To.IsZero() || at.Before(o.To))
+}
+
+// TradeSummary is what's compared of a trade.
+type TradeSummary struct {
+	Price         decimal.Decimal `json:"price"`
+	Quantity      decimal.Decimal `json:"quantity"`
+	MakerOrderID  int64           `json:"maker_order_id"`
+	TakerOrderID  int64           `json:"taker_order_id"`
+	MakerMemberID int64           `json:"maker_member_id"`
+	TakerMemberID int64           `json:"taker_member_id"`
+}
+
+func summarize(trade *pkg.Trade) *TradeSummary {
+	return &TradeSummary{
+		Price:         trade.Price,
+		Quantity:      trade.Quantity,
+		MakerOrderID:  trade.MakerOrder.ID,
+		TakerOrderID:  trade.TakerOrder.ID,
+		MakerMemberID: trade.MakerOrder.MemberID,
+		TakerMemberID: trade.TakerOrder.MemberID,
+	}
+}
+
+func (t *TradeSummary) Equal(other *TradeSummary) bool {
+	return t.Price.Equal(other.Price) && t.Quantity.Equal(other.Quantity) &&
+		t.MakerOrderID == other.MakerOrderID && t.TakerOrderID == other.TakerOrderID &&
+		t.MakerMemberID == other.MakerMemberID && t.TakerMemberID == other.TakerMemberID
+}
+
+// Divergence is a trade or a depth checkpoint the replay didn't reproduce, Want is the captured one.
+// A missing trade or an extra one has a nil Want or Got.
+type Divergence struct {
+	Kind   Kind      `json:"kind"`
+	Market string    `json:"market"`
+	At     time.Time `json:"at"`
+	// Index is the position of the trade among the trades of the market compared
+	Index int         `json:"index"`
+	Want  interface{} `json:"want"`
+	Got   interface{} `json:"got"`
+}
+
+// Report is the outcome of a replay, only the first diverging trade of a market is reported
+// as every trade following it usually diverges too.
+type Report struct {
+	Commands int `json:"commands"`
+	// Skipped are the commands of markets without a captured book
+	Skipped        int           `json:"skipped"`
+	RecordedTrades int           `json:"recorded_trades"`
+	ReplayedTrades int           `json:"replayed_trades"`
+	Checkpoints    int           `json:"checkpoints"`
+	Divergences    []*Divergence `json:"divergences"`
+}
+
+func (r *Report) Diverged() bool {
+	return len(r.Divergences) > 0
+}
+
+type tradeAt struct {
+	at      time.Time
+	summary *TradeSummary
+}
+
+type replayer struct {
+	options  Options
+	clock    *clock.Fake
+	engines  map[string]*matching.Engine
+	report   *Report
+	recorded map[string][]tradeAt
+	replayed map[string][]tradeAt
+	// comparing is set while the command replayed is in the compared window, its trades are compared
+	comparing bool
+	// paced is the time of the last command compared, the next one waits for the time between them
+	paced time.Time
+}
+
+// Replay replays the commands of the capture read from r into books off the broker, and compares
+// the trades they matched and their depth checkpoints with the captured ones.
+func Replay(r io.Reader, options Options) (*Report, error) {
+	if options.Sleep == nil {
+		options.Sleep = time.Sleep
+	}
+
+	replayer := &replayer{
+		options:  options,
+		engines:  make(map[string]*matching.Engine),
+		report:   &Report{Divergences: make([]*Divergence, 0)},
+		recorded: make(map[string][]tradeAt),
+		replayed: make(map[string][]tradeAt),
+	}
+
+	decoder := json.NewDecoder(r)
+	for {
+		var record Record
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read capture record: %w", err)
+		}
+
+		if !options.replays(record.Market) {
+			continue
+		}
+
+		// the records are in order, the ones past the window can't be compared anymore
+		if record.Kind == KindCommand && !options.To.IsZero() && !record.At.Before(options.To) {
+			break
+		}
+
+		replayer.replay(&record)
+	}
+
+	for _, engine := range replayer.engines {
+		engine.OrderBook.StopBatch()
+		engine.OrderBook.StopListing()
+	}
+
+	replayer.compareTrades()
+
+	return replayer.report, nil
+}
+
+func (r *replayer) advance(at time.Time) {
+	if r.clock == nil {
+		r.clock = clock.NewFake(at)
+	}
+
+	// the captured times of the markets interleave, the clock never goes back
+	if at.After(r.clock.Now()) {
+		r.clock.Set(at)
+	}
+}
+
+func (r *replayer) replay(record *Record) {
+	r.advance(record.At)
+
+	switch record.Kind {
+	case KindBook:
+		r.build(record.Market, record.Book)
+	case KindCommand:
+		r.command(record)
+	case KindTrade:
+		if r.comparing {
+			r.report.RecordedTrades++
+			r.recorded[record.Market] = append(r.recorded[record.Market], tradeAt{at: record.At, summary: summarize(record.Trade)})
+		}
+	case KindDepth:
+		r.checkpoint(record)
+	}
+}
+
+// build replaces the book of market by the one captured.
+func (r *replayer) build(market string, state *BookState) {
+	if previous, found := r.engines[market]; found {
+		previous.OrderBook.StopListing()
+		previous.OrderBook.StopBatch()
+	}
+
+	flags := state.Flags.Map()
+	for flag, enabled := range r.options.Flags {
+		flags[flag] = enabled
+	}
+
+	engine := matching.NewDetachedEngine(state.Symbol, state.MarketPrice, matching.OrderBookConfig{
+		DailyPriceLimit: state.DailyPriceLimit,
+		PreviousClose:   state.PreviousClose,
+		Flags:           matching.NewFeatureFlags(flags),
+		SizeLimits:      state.SizeLimits,
+		Fees:            state.Fees,
+		BatchInterval:   state.BatchInterval,
+		Clock:           r.clock,
+		ConfigVersion:   state.ConfigVersion,
+		Publisher:       &replayPublisher{replayer: r},
+	})
+
+	// the orders of the book were resting, they don't match each other
+	comparing := r.comparing
+	r.comparing = false
+	for _, order := range state.Orders {
+		engine.SubmitWithOptions(order, state.Options[order.ID])
+	}
+	r.comparing = comparing
+
+	r.engines[market] = engine
+}
+
+func (r *replayer) command(record *Record) {
+	engine, found := r.engines[record.Market]
+	if !found {
+		r.report.Skipped++
+		return
+	}
+
+	r.comparing = r.options.compares(record.At)
+	if r.comparing {
+		r.pace(record.At)
+		r.report.Commands++
+	}
+
+	engine.Apply(record.Command)
+}
+
+// pace waits the time between the command at at and the previous one compared, divided by the speed.
+func (r *replayer) pace(at time.Time) {
+	if r.options.Speed > 0 && !r.paced.IsZero() && at.After(r.paced) {
+		r.options.Sleep(time.Duration(float64(at.Sub(r.paced)) / r.options.Speed))
+	}
+
+	r.paced = at
+}
+
+func (r *replayer) checkpoint(record *Record) {
+	engine, found := r.engines[record.Market]
+	if !found || !r.options.compares(record.At) {
+		return
+	}
+
+	r.report.Checkpoints++
+
+	got := depthOf(engine)
+	if !got.Equal(record.Depth) {
+		r.report.Divergences = append(r.report.Divergences, &Divergence{
+			Kind:   KindDepth,
+			Market: record.Market,
+			At:     record.At,
+			Want:   record.Depth,
+			Got:    got,
+		})
+	}
+}
+
+// compareTrades reports the first trade of each market the replay didn't match as it was captured.
+func (r *replayer) compareTrades() {
+	markets := make([]string, 0)
+	for market := range r.recorded {
+		markets = append(markets, market)
+	}
+	for market := range r.replayed {
+		if _, found := r.recorded[market]; !found {
+			markets = append(markets, market)
+		}
+	}
+	sort.Strings(markets)
+
+	for _, market := range markets {
+		recorded, replayed := r.recorded[market], r.replayed[market]
+
+		for i := 0; i < len(recorded) || i < len(replayed); i++ {
+			divergence := &Divergence{Kind: KindTrade, Market: market, Index: i}
+
+			switch {
+			case i >= len(replayed):
+				divergence.At, divergence.Want = recorded[i].at, recorded[i].summary
+			case i >= len(recorded):
+				divergence.At, divergence.Got = replayed[i].at, replayed[i].summary
+			case !recorded[i].summary.Equal(replayed[i].summary):
+				divergence.At, divergence.Want, divergence.Got = recorded[i].at, recorded[i].summary, replayed[i].summary
+			default:
+				continue
+			}
+
+			r.report.Divergences = append(r.report.Divergences, divergence)
+			break
+		}
+	}
+}
+
+// replayPublisher keeps the trades the replayed books matched while their commands are compared.
+type replayPublisher struct {
+	replayer *replayer
+}
+
+func (p *replayPublisher) PublishTrade(trade *pkg.Trade, stamp matching.TradeStamp) {
+	if !p.replayer.comparing {
+		return
+	}
+
+	market := MarketOf(trade.Symbol)
+	p.replayer.report.ReplayedTrades++
+	p.replayer.replayed[market] = append(p.replayer.replayed[market], tradeAt{at: stamp.MatchedAt, summary: summarize(trade)})
+}
+
+// the cancels, the replaces, the decrements, the reprices, the amends and the batches aren't compared, the trades and the depth tell when they diverged
+func (p *replayPublisher) PublishCancel(key *pkg.OrderKey, reason matching.CancelReason) {}
+
+func (p *replayPublisher) PublishCancelOutcome(key *pkg.OrderKey, outcome matching.CancelOutcome, command_id string) {
+}
+
+func (p *replayPublisher) PublishReplace(replaced_key *pkg.OrderKey, order *pkg.Order, accepted bool) {
+}
+
+func (p *replayPublisher) PublishDecrement(key *pkg.OrderKey, quantity decimal.Decimal, reason matching.CancelReason) {
+}
+
+func (p *replayPublisher) PublishReprice(key *pkg.OrderKey, reason matching.CancelReason) {}
+
+func (p *replayPublisher) PublishAmend(key *pkg.OrderKey, quantity decimal.Decimal, accepted bool) {}
+
+func (p *replayPublisher) PublishBatch(batch_id string, entries []*events.BatchEntry) {}
+
+func (p *replayPublisher) PublishCircuitBreak(circuit_break *matching.CircuitBreak) {}
@@ -0,0 +1,204 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/matching"
+	"github.com/zsmartex/finex/types"
+	"github.com/zsmartex/pkg"
+)
+
+var (
+	testSymbol = pkg.Symbol{BaseCurrency: "BTC", QuoteCurrency: "USDT"}
+	testStart  = time.Date(2022, 5, 10, 10, 0, 0, 0, time.UTC)
+	testSalt   = []byte("salt")
+)
+
+type nopPublisher struct{}
+
+func (p *nopPublisher) PublishTrade(trade *pkg.Trade, stamp matching.TradeStamp) {}
+
+func (p *nopPublisher) PublishCancel(key *pkg.OrderKey, reason matching.CancelReason) {}
+
+func (p *nopPublisher) PublishCancelOutcome(key *pkg.OrderKey, outcome matching.CancelOutcome, command_id string) {
+}
+
+func (p *nopPublisher) PublishReplace(replaced_key *pkg.OrderKey, order *pkg.Order, accepted bool) {
+}
+
+func (p *nopPublisher) PublishDecrement(key *pkg.OrderKey, quantity decimal.Decimal, reason matching.CancelReason) {
+}
+
+func (p *nopPublisher) PublishReprice(key *pkg.OrderKey, reason matching.CancelReason) {}
+
+func (p *nopPublisher) PublishAmend(key *pkg.OrderKey, quantity decimal.Decimal, accepted bool) {}
+
+func (p *nopPublisher) PublishBatch(batch_id string, entries []*events.BatchEntry) {}
+
+func (p *nopPublisher) PublishCircuitBreak(circuit_break *matching.CircuitBreak) {}
+
+func testOrder(id int64, member_id int64, side pkg.OrderSide, price, quantity string, at time.Time) *pkg.Order {
+	return &pkg.Order{
+		ID:        id,
+		UUID:      uuid.New(),
+		Symbol:    testSymbol,
+		MemberID:  member_id,
+		Side:      side,
+		Type:      pkg.TypeLimit,
+		Price:     decimal.RequireFromString(price),
+		Quantity:  decimal.RequireFromString(quantity),
+		CreatedAt: at,
+	}
+}
+
+// capture runs the commands through an engine like the engine server does with a capture, one second apart.
+func capture(t *testing.T, resting []*pkg.Order, commands []*pkg.MatchingPayloadMessage) []byte {
+	var buffer bytes.Buffer
+	recorder := NewRecorder(&buffer, testSalt, 0)
+	fake := clock.NewFake(testStart)
+
+	book_config := matching.OrderBookConfig{Clock: fake, Publisher: recorder.Publisher(&nopPublisher{})}
+	engine := matching.NewDetachedEngine(testSymbol, decimal.NewFromInt(100), book_config)
+	for _, order := range resting {
+		engine.Submit(order)
+	}
+
+	if err := recorder.Book(engine, book_config, fake.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, command := range commands {
+		fake.Advance(time.Second)
+
+		if err := recorder.Command(&events.MatchingPayload{MatchingPayloadMessage: *command}, fake.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		switch command.Action {
+		case pkg.ActionSubmit:
+			engine.Submit(command.Order)
+		case pkg.ActionCancelWithKey:
+			engine.CancelWithKey(command.Key)
+		}
+
+		if err := recorder.Checkpoint(engine, fake.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := recorder.Close([]*matching.Engine{engine}, fake.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func submit(order *pkg.Order) *pkg.MatchingPayloadMessage {
+	return &pkg.MatchingPayloadMessage{Action: pkg.ActionSubmit, Order: order}
+}
+
+// tieCapture has two asks at the same price, the older one rests in the book when the capture starts.
+func tieCapture(t *testing.T) []byte {
+	return capture(t, []*pkg.Order{
+		testOrder(1, 11, pkg.SideSell, "101", "1", testStart.Add(-time.Minute)),
+	}, []*pkg.MatchingPayloadMessage{
+		submit(testOrder(2, 12, pkg.SideSell, "101", "1", testStart.Add(time.Second))),
+		submit(testOrder(3, 13, pkg.SideBuy, "99", "2", testStart.Add(2*time.Second))),
+		submit(testOrder(4, 14, pkg.SideBuy, "101", "1", testStart.Add(3*time.Second))),
+		{Action: pkg.ActionCancelWithKey, Key: testOrder(3, 13, pkg.SideBuy, "99", "2", testStart.Add(2*time.Second)).Key()},
+	})
+}
+
+func TestReplayReproducesCapture(t *testing.T) {
+	data := tieCapture(t)
+
+	if strings.Contains(string(data), `"member_id":12,`) {
+		t.Error("expected the member IDs to be anonymized")
+	}
+
+	var slept time.Duration
+	report, err := Replay(bytes.NewReader(data), Options{Speed: 2, Sleep: func(d time.Duration) { slept += d }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Diverged() {
+		divergences, _ := json.Marshal(report.Divergences)
+		t.Fatalf("expected the replay to reproduce the capture, got %s", divergences)
+	}
+
+	if report.Commands != 4 || report.RecordedTrades != 1 || report.ReplayedTrades != 1 || report.Checkpoints != 6 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// 3 seconds between the first and the last command, replayed twice as fast
+	if slept != 1500*time.Millisecond {
+		t.Errorf("expected the replay to wait 1.5s, got %v", slept)
+	}
+}
+
+func TestReplayReportsDivergences(t *testing.T) {
+	data := tieCapture(t)
+
+	// the captured book matched the newest ask of the level first, the FIFO tie-break matches the oldest
+	report, err := Replay(bytes.NewReader(data), Options{Flags: map[types.FeatureFlag]bool{types.FeatureFifoTiebreakV2: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Divergences) != 1 {
+		t.Fatalf("expected the first trade to diverge, got %d divergences", len(report.Divergences))
+	}
+
+	divergence := report.Divergences[0]
+	want, got := divergence.Want.(*TradeSummary), divergence.Got.(*TradeSummary)
+	if divergence.Kind != KindTrade || want.MakerOrderID != 2 || got.MakerOrderID != 1 {
+		t.Errorf("unexpected divergence %+v", divergence)
+	}
+
+	if want.MakerMemberID != Anonymize(testSalt, 12) {
+		t.Errorf("expected the captured maker to be anonymized, got %d", want.MakerMemberID)
+	}
+}
+
+func TestReplayWindowAndMarkets(t *testing.T) {
+	data := tieCapture(t)
+
+	// the commands before the window still build the book, only the last two are compared
+	report, err := Replay(bytes.NewReader(data), Options{From: testStart.Add(3 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Diverged() || report.Commands != 2 || report.RecordedTrades != 1 || report.ReplayedTrades != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// the trade was matched by the third command, it's out of a window ending before
+	report, err = Replay(bytes.NewReader(data), Options{To: testStart.Add(3 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Diverged() || report.Commands != 2 || report.RecordedTrades != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	report, err = Replay(bytes.NewReader(data), Options{Markets: []string{"ethusdt"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Commands != 0 || report.Checkpoints != 0 {
+		t.Errorf("expected the other markets to be skipped, got %+v", report)
+	}
+}
@@ -0,0 +1,212 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/matching"
+	"github.com/zsmartex/finex/matching/wal"
+	"github.com/zsmartex/finex/types"
+	"github.com/zsmartex/pkg"
+)
+
+// KindDepthDiff is a change of a price level in the output of a reproduction.
+const KindDepthDiff Kind = "depth_diff"
+
+// ReproduceOptions are the settings of the books a reproduction builds, they start empty.
+type ReproduceOptions struct {
+	// MarketPrice is the market price the books start with, the stop orders are triggered from it
+	MarketPrice decimal.Decimal
+	Flags       map[types.FeatureFlag]bool
+	SizeLimits  matching.OrderSizeLimits
+	// Fees are the rates the fees of the trades are computed with, the trades carry no fee without them
+	Fees matching.FeeSchedule
+}
+
+// Output is a line of the output of a reproduction, a trade or a depth diff of a book in the order it produced them.
+type Output struct {
+	Kind   Kind   `json:"kind"`
+	Market string `json:"market"`
+	// Sequence is the sequence of the command which produced it, the last command processed for the output of
+	// the batch auctions and the expiry sweeps
+	Sequence int64               `json:"sequence"`
+	Trade    *events.Trade       `json:"trade,omitempty"`
+	Diff     *matching.DepthDiff `json:"diff,omitempty"`
+}
+
+// ReproduceSummary counts what a reproduction read and wrote.
+type ReproduceSummary struct {
+	Commands int `json:"commands"`
+	// Skipped are the commands of no single book and the ones the engine doesn't know
+	Skipped int `json:"skipped"`
+	Trades  int `json:"trades"`
+	Diffs   int `json:"diffs"`
+}
+
+type reproducer struct {
+	options ReproduceOptions
+	clock   *clock.Fake
+	engines map[pkg.Symbol]*matching.Engine
+	writer  *bufio.Writer
+	summary *ReproduceSummary
+	// sequence is the sequence of the command processed
+	sequence int64
+	// err is the first output which couldn't be written
+	err error
+}
+
+// Reproduce processes the commands read from r, the lines of the write-ahead log of the books, into empty books
+// and writes the trades and the depth diffs they produce to w, one JSON line each, as the engine published them.
+// The clock of the books is the time each command was logged at, not the time of the machine, so the same
+// commands always produce the same output byte for byte: the output of a dispute is diffed against the events
+// the engine published.
+func Reproduce(r io.Reader, w io.Writer, options ReproduceOptions) (*ReproduceSummary, error) {
+	reproducer := &reproducer{
+		options: options,
+		engines: make(map[pkg.Symbol]*matching.Engine),
+		writer:  bufio.NewWriter(w),
+		summary: &ReproduceSummary{},
+	}
+
+	decoder := json.NewDecoder(r)
+	for {
+		var entry wal.Entry
+		err := decoder.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read command: %w", err)
+		}
+
+		if entry.Command == nil {
+			return nil, fmt.Errorf("command %d is empty", entry.Sequence)
+		}
+
+		reproducer.process(&entry)
+		if reproducer.err != nil {
+			return nil, fmt.Errorf("failed to write output: %w", reproducer.err)
+		}
+	}
+
+	for _, engine := range reproducer.engines {
+		engine.OrderBook.StopBatch()
+		engine.OrderBook.StopListing()
+	}
+
+	if err := reproducer.writer.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write output: %w", err)
+	}
+
+	return reproducer.summary, nil
+}
+
+func (r *reproducer) process(entry *wal.Entry) {
+	if r.clock == nil {
+		r.clock = clock.NewFake(entry.At)
+	}
+
+	// the clock never goes back, the books see the times the engine saw
+	if entry.At.After(r.clock.Now()) {
+		r.clock.Set(entry.At)
+	}
+
+	r.summary.Commands++
+	r.sequence = entry.Sequence
+
+	symbol, found := entry.Command.Market()
+	if !found {
+		r.summary.Skipped++
+		return
+	}
+
+	if !r.engineOf(symbol).Apply(entry.Command) {
+		r.summary.Skipped++
+	}
+}
+
+// engineOf returns the book of symbol, an empty one the first time.
+func (r *reproducer) engineOf(symbol pkg.Symbol) *matching.Engine {
+	if engine, found := r.engines[symbol]; found {
+		return engine
+	}
+
+	market := MarketOf(symbol)
+	engine := matching.NewDetachedEngine(symbol, r.options.MarketPrice, matching.OrderBookConfig{
+		Flags:      matching.NewFeatureFlags(r.options.Flags),
+		SizeLimits: r.options.SizeLimits,
+		Fees:       r.options.Fees,
+		Clock:      r.clock,
+		Publisher:  &reproducePublisher{reproducer: r, market: market},
+	})
+	engine.OrderBook.Depth.Subscribe(&reproduceSubscriber{reproducer: r, market: market})
+
+	r.engines[symbol] = engine
+
+	return engine
+}
+
+func (r *reproducer) write(output *Output) {
+	if r.err != nil {
+		return
+	}
+
+	line, err := json.Marshal(output)
+	if err != nil {
+		r.err = err
+		return
+	}
+
+	if _, err := r.writer.Write(append(line, '\n')); err != nil {
+		r.err = err
+	}
+}
+
+// reproducePublisher writes the trades of a book as they're matched, the rest of the output isn't reproduced.
+type reproducePublisher struct {
+	reproducer *reproducer
+	market     string
+}
+
+func (p *reproducePublisher) PublishTrade(trade *pkg.Trade, stamp matching.TradeStamp) {
+	p.reproducer.summary.Trades++
+	p.reproducer.write(&Output{Kind: KindTrade, Market: p.market, Sequence: p.reproducer.sequence, Trade: matching.TradeEvent(trade, stamp)})
+}
+
+func (p *reproducePublisher) PublishCancel(key *pkg.OrderKey, reason matching.CancelReason) {}
+
+func (p *reproducePublisher) PublishCancelOutcome(key *pkg.OrderKey, outcome matching.CancelOutcome, command_id string) {
+}
+
+func (p *reproducePublisher) PublishReplace(replaced_key *pkg.OrderKey, order *pkg.Order, accepted bool) {
+}
+
+func (p *reproducePublisher) PublishDecrement(key *pkg.OrderKey, quantity decimal.Decimal, reason matching.CancelReason) {
+}
+
+func (p *reproducePublisher) PublishReprice(key *pkg.OrderKey, reason matching.CancelReason) {}
+
+func (p *reproducePublisher) PublishAmend(key *pkg.OrderKey, quantity decimal.Decimal, accepted bool) {
+}
+
+func (p *reproducePublisher) PublishBatch(batch_id string, entries []*events.BatchEntry) {}
+
+func (p *reproducePublisher) PublishCircuitBreak(circuit_break *matching.CircuitBreak) {}
+
+// reproduceSubscriber writes the depth diffs of a book.
+type reproduceSubscriber struct {
+	reproducer *reproducer
+	market     string
+}
+
+func (s *reproduceSubscriber) DepthDiff(symbol pkg.Symbol, diff matching.DepthDiff) {
+	s.reproducer.summary.Diffs++
+	s.reproducer.write(&Output{Kind: KindDepthDiff, Market: s.market, Sequence: s.reproducer.sequence, Diff: &diff})
+}
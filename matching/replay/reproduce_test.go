@@ -0,0 +1,83 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/matching/wal"
+	"github.com/zsmartex/pkg"
+)
+
+func commandLog(t *testing.T, commands []*events.MatchingPayload) []byte {
+	var buffer bytes.Buffer
+	for i, command := range commands {
+		line, err := json.Marshal(&wal.Entry{Sequence: int64(i + 1), At: testStart.Add(time.Duration(i) * time.Minute), Command: command})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buffer.Write(append(line, '\n'))
+	}
+
+	return buffer.Bytes()
+}
+
+func TestReproduce(t *testing.T) {
+	maker := testOrder(1, 10, pkg.SideSell, "101", "2", testStart)
+	log := commandLog(t, []*events.MatchingPayload{
+		{MatchingPayloadMessage: pkg.MatchingPayloadMessage{Action: pkg.ActionSubmit, Order: maker}},
+		{MatchingPayloadMessage: pkg.MatchingPayloadMessage{Action: pkg.ActionSubmit, Order: testOrder(2, 11, pkg.SideBuy, "99", "1", testStart)}},
+		{MatchingPayloadMessage: pkg.MatchingPayloadMessage{Action: pkg.ActionSubmit, Order: testOrder(3, 12, pkg.SideBuy, "101", "1.5", testStart)}},
+		{MatchingPayloadMessage: pkg.MatchingPayloadMessage{Action: pkg.ActionCancelWithKey, Key: maker.Key()}},
+		{MatchingPayloadMessage: pkg.MatchingPayloadMessage{Action: pkg.ActionReload, Symbol: testSymbol}},
+	})
+
+	var first, second bytes.Buffer
+	summary, err := Reproduce(bytes.NewReader(log), &first, ReproduceOptions{MarketPrice: decimal.NewFromInt(100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Reproduce(bytes.NewReader(log), &second, ReproduceOptions{MarketPrice: decimal.NewFromInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Fatalf("expected the same output twice, got\n%s\nthen\n%s", first.String(), second.String())
+	}
+
+	if summary.Commands != 5 || summary.Skipped != 1 || summary.Trades != 1 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+
+	decoder := json.NewDecoder(&first)
+	diffs := int64(0)
+	for decoder.More() {
+		var output Output
+		if err := decoder.Decode(&output); err != nil {
+			t.Fatal(err)
+		}
+
+		switch output.Kind {
+		case KindTrade:
+			// the trade is stamped with the time the command was logged at
+			if output.Sequence != 3 || !output.Trade.MatchedAt.Equal(testStart.Add(2*time.Minute)) || !output.Trade.Quantity.Equal(decimal.RequireFromString("1.5")) {
+				t.Errorf("unexpected trade %+v", output)
+			}
+		case KindDepthDiff:
+			diffs++
+			if output.Diff.Sequence != diffs {
+				t.Errorf("expected diff %d, got %+v", diffs, output.Diff)
+			}
+		}
+	}
+
+	if diffs != int64(summary.Diffs) || diffs == 0 {
+		t.Errorf("expected %d diffs, got %d", summary.Diffs, diffs)
+	}
+}
@@ -0,0 +1,70 @@
+package matching
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+// selfTrade reports whether order would trade with counter_order, a resting order of the same member, under
+// a self-trade prevention. Orders of the liquidity provider are always matched.
+func (ob *OrderBook) selfTrade(order, counter_order *pkg.Order) bool {
+	return order.MemberID == counter_order.MemberID && !order.IsFake() && !counter_order.IsFake() &&
+		len(ob.optionsOf(order).SelfTradePrevention) > 0
+}
+
+// preventSelfTrade applies the self-trade prevention of order to counter_order instead of matching them,
+// the orders cancelled or decremented are published so their funds are unlocked. It reports whether order
+// is done, the matching of order goes on with the next offer otherwise.
+func (ob *OrderBook) preventSelfTrade(order, counter_order *pkg.Order) bool {
+	policy := ob.optionsOf(order).SelfTradePrevention
+
+	config.Logger.Debugf("[oceanbook.orderbook] order %d would trade with order %d of member %d, %s", order.ID, counter_order.ID, order.MemberID, policy)
+
+	switch policy {
+	case types.SelfTradePreventionCancelNewest:
+		ob.cancelUnmatched(order, CancelReasonSelfTrade)
+
+		return true
+	case types.SelfTradePreventionCancelOldest:
+		ob.cancelResting(counter_order, CancelReasonSelfTrade)
+
+		return false
+	case types.SelfTradePreventionCancelBoth:
+		ob.cancelResting(counter_order, CancelReasonSelfTrade)
+		ob.cancelUnmatched(order, CancelReasonSelfTrade)
+
+		return true
+	}
+
+	// decrement and cancel
+	quantity := decimal.Min(order.UnfilledQuantity(), counter_order.UnfilledQuantity())
+
+	counter_order.Quantity = counter_order.Quantity.Sub(quantity)
+	if counter_order.UnfilledQuantity().IsPositive() {
+		ob.Depth.Add(counter_order)
+		ob.PublishDecrement(counter_order.Key(), quantity, CancelReasonSelfTrade)
+	} else {
+		ob.cancelResting(counter_order, CancelReasonSelfTrade)
+	}
+
+	order.Quantity = order.Quantity.Sub(quantity)
+	if order.UnfilledQuantity().IsPositive() {
+		ob.PublishDecrement(order.Key(), quantity, CancelReasonSelfTrade)
+
+		return false
+	}
+
+	ob.cancelUnmatched(order, CancelReasonSelfTrade)
+
+	return true
+}
+
+// cancelResting takes an order out of the book while an order is matched against it, with the matchMutex held.
+func (ob *OrderBook) cancelResting(o *pkg.Order, reason CancelReason) {
+	ob.Depth.Remove(o.Key())
+	ob.forget(o.ID)
+	ob.PublishCancel(o.Key(), reason)
+}
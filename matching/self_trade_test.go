@@ -0,0 +1,109 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/types"
+)
+
+func selfTradePrevention(policy types.SelfTradePrevention) *events.OrderOptions {
+	return &events.OrderOptions{SelfTradePrevention: policy}
+}
+
+// newSelfTradeBook returns a book with an ask of member 1 at 101 above an ask of member 2 at 100, and a bid
+// of member 1 about to take both.
+func newSelfTradeBook(bid_quantity string) (*OrderBook, *recordingPublisher, *pkg.Order, *pkg.Order) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	other := newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1")
+	other.MemberID = 2
+	ob.Add(other)
+
+	own := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "2")
+	ob.Add(own)
+
+	return ob, publisher, own, newTestOrder(pkg.SideBuy, pkg.TypeLimit, "102", bid_quantity)
+}
+
+func TestSelfTradeAllowedByDefault(t *testing.T) {
+	ob, publisher, own, bid := newSelfTradeBook("3")
+	ob.Add(bid)
+
+	if len(publisher.Trades) != 2 || publisher.Trades[1].SellOrder().ID != own.ID || len(publisher.Cancels) != 0 {
+		t.Errorf("expected the bid to match the ask of its member, got %+v", publisher.Trades)
+	}
+}
+
+func TestSelfTradeCancelNewest(t *testing.T) {
+	ob, publisher, own, bid := newSelfTradeBook("3")
+	ob.add(bid, selfTradePrevention(types.SelfTradePreventionCancelNewest))
+
+	if len(publisher.Trades) != 1 || !bookHas(ob, own) || bookHas(ob, bid) {
+		t.Fatalf("expected the bid to stop at the ask of its member, got %d trades", len(publisher.Trades))
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0].ID != bid.ID || publisher.Cancels[0].Reason != CancelReasonSelfTrade {
+		t.Errorf("expected the bid to be cancelled, got %+v", publisher.Cancels)
+	}
+}
+
+func TestSelfTradeCancelOldest(t *testing.T) {
+	ob, publisher, own, bid := newSelfTradeBook("3")
+	ob.add(bid, selfTradePrevention(types.SelfTradePreventionCancelOldest))
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0].ID != own.ID || bookHas(ob, own) {
+		t.Fatalf("expected the ask of the member to be cancelled, got %+v", publisher.Cancels)
+	}
+
+	// nothing else crosses it, the rest of the bid rests
+	if len(publisher.Trades) != 1 || !bookHas(ob, bid) || !bid.UnfilledQuantity().Equal(decimal.NewFromInt(2)) {
+		t.Errorf("expected 2 of the bid to rest, %s unfilled", bid.UnfilledQuantity())
+	}
+}
+
+func TestSelfTradeCancelBoth(t *testing.T) {
+	ob, publisher, own, bid := newSelfTradeBook("3")
+	ob.add(bid, selfTradePrevention(types.SelfTradePreventionCancelBoth))
+
+	if len(publisher.Cancels) != 2 || publisher.Cancels[0].ID != own.ID || publisher.Cancels[1].ID != bid.ID {
+		t.Fatalf("expected both orders to be cancelled, got %+v", publisher.Cancels)
+	}
+
+	if bookHas(ob, own) || bookHas(ob, bid) {
+		t.Error("expected both orders out of the book")
+	}
+}
+
+func TestSelfTradeDecrementAndCancel(t *testing.T) {
+	// the bid has 2 left at the ask of 2 of its member, both are left without quantity
+	ob, publisher, own, bid := newSelfTradeBook("3")
+	ob.add(bid, selfTradePrevention(types.SelfTradePreventionDecrementAndCancel))
+
+	if len(publisher.Cancels) != 2 || len(publisher.Decrements) != 0 || bookHas(ob, own) || bookHas(ob, bid) {
+		t.Fatalf("expected both orders to be cancelled, got %+v and %+v", publisher.Cancels, publisher.Decrements)
+	}
+
+	// the bid of 2 has 1 left, the ask of 2 is decremented by 1 and keeps its place
+	ob, publisher, own, bid = newSelfTradeBook("2")
+	ob.add(bid, selfTradePrevention(types.SelfTradePreventionDecrementAndCancel))
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0].ID != bid.ID {
+		t.Fatalf("expected the bid to be cancelled, got %+v", publisher.Cancels)
+	}
+
+	if len(publisher.Decrements) != 1 || publisher.Decrements[0].ID != own.ID || !publisher.Decrements[0].Quantity.Equal(decimal.NewFromInt(1)) {
+		t.Fatalf("expected the ask to be decremented by 1, got %+v", publisher.Decrements)
+	}
+
+	if !bookHas(ob, own) || !own.UnfilledQuantity().Equal(decimal.NewFromInt(1)) {
+		t.Errorf("expected 1 of the ask to rest, %s unfilled", own.UnfilledQuantity())
+	}
+
+	if asks, _ := ob.Depth.Top(1); len(asks) != 1 || !asks[0][1].Equal(decimal.NewFromInt(1)) {
+		t.Errorf("expected the level of the ask to show 1, got %+v", asks)
+	}
+}
@@ -0,0 +1,42 @@
+package matching
+
+import (
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+// collar returns the worst price a market order with a max slippage is matched at, the best price of offers when
+// the order is matched moved by the slippage. It's zero when the order has no max slippage or offers are empty, the
+// collar doesn't move as the order takes the levels.
+func (ob *OrderBook) collar(o *pkg.Order, offers *redblacktree.Tree) decimal.Decimal {
+	slippage := ob.optionsOf(o).MaxSlippage
+	if o.Type != pkg.TypeMarket || slippage == nil || !slippage.IsPositive() {
+		return decimal.Zero
+	}
+
+	best := offers.Right()
+	if best == nil {
+		return decimal.Zero
+	}
+
+	price := best.Value.(*PriceLevel).Price
+	if o.Side == pkg.SideBuy {
+		return price.Mul(decimal.NewFromInt(1).Add(*slippage))
+	}
+
+	return price.Mul(decimal.NewFromInt(1).Sub(*slippage))
+}
+
+// beyondCollar reports whether a market order matched at price would go past its collar, zero is no collar.
+func beyondCollar(o *pkg.Order, collar, price decimal.Decimal) bool {
+	if !collar.IsPositive() {
+		return false
+	}
+
+	if o.Side == pkg.SideBuy {
+		return price.GreaterThan(collar)
+	}
+
+	return price.LessThan(collar)
+}
@@ -0,0 +1,54 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/events"
+)
+
+func maxSlippage(ratio string) *events.OrderOptions {
+	r := decimal.RequireFromString(ratio)
+
+	return &events.OrderOptions{MaxSlippage: &r}
+}
+
+func TestMarketOrderStopsAtCollar(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "104", "1"))
+	beyond := newTestOrder(pkg.SideSell, pkg.TypeLimit, "106", "1")
+	ob.Add(beyond)
+
+	// 5% from 100, the collar stays at 105 while the order takes the levels
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeMarket, "", "3")
+	ob.add(taker, maxSlippage("0.05"))
+
+	if len(publisher.Trades) != 2 || !publisher.Trades[1].Price.Equal(decimal.NewFromInt(104)) {
+		t.Fatalf("expected the order to match up to the collar, got %d trades", len(publisher.Trades))
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0].ID != taker.ID || publisher.Cancels[0].Reason != CancelReasonSlippage {
+		t.Errorf("expected the rest of the order to be cancelled for its slippage, got %+v", publisher.Cancels)
+	}
+
+	if !bookHas(ob, beyond) {
+		t.Error("expected the order beyond the collar to stay in the book")
+	}
+}
+
+func TestMarketOrderWithoutCollarWalksTheBook(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "100", "1"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "80", "1"))
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeMarket, "", "2"))
+
+	if len(publisher.Trades) != 2 || len(publisher.Cancels) != 0 {
+		t.Errorf("expected the order without a max slippage to be filled, got %d trades and %+v", len(publisher.Trades), publisher.Cancels)
+	}
+}
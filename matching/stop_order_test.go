@@ -0,0 +1,147 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func newTestStopOrder(side pkg.OrderSide, stop_price, price, quantity string) *pkg.Order {
+	o := newTestOrder(side, pkg.TypeLimit, price, quantity)
+	o.StopPrice = decimal.RequireFromString(stop_price)
+
+	return o
+}
+
+func TestStopOrdersTriggerBySide(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	buy_stop := newTestStopOrder(pkg.SideBuy, "102", "103", "1")
+	sell_stop := newTestStopOrder(pkg.SideSell, "98", "97", "1")
+	ob.Add(buy_stop)
+	ob.Add(sell_stop)
+
+	// the price falls to 99, neither stop price is reached
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "99", "1"))
+
+	if ob.StopBids.Size() != 1 || ob.StopAsks.Size() != 1 {
+		t.Fatalf("expected both stop orders to wait, got %d bids and %d asks", ob.StopBids.Size(), ob.StopAsks.Size())
+	}
+
+	// the price rises to 102, it triggers the buy stop only
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "102", "1"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "102", "1"))
+
+	if ob.StopBids.Size() != 0 || !bookHas(ob, buy_stop) {
+		t.Fatal("expected the buy stop to rest as a limit order at 103")
+	}
+
+	if ob.StopAsks.Size() != 1 {
+		t.Fatal("expected the sell stop not to be triggered by a rising price")
+	}
+
+	// the price falls to 98 past the buy stop resting at 103, it triggers the sell stop
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "98", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "98", "2"))
+
+	if ob.StopAsks.Size() != 0 || bookHas(ob, buy_stop) || !bookHas(ob, sell_stop) {
+		t.Error("expected the sell stop to be triggered and to rest as a limit order at 97")
+	}
+}
+
+func TestStopOrdersTriggerInStopPriceOrder(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	far := newTestStopOrder(pkg.SideSell, "95", "90", "1")
+	near := newTestStopOrder(pkg.SideSell, "99", "90", "1")
+	ob.Add(far)
+	ob.Add(near)
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "94", "2"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "94", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "94", "1"))
+
+	if len(publisher.Trades) != 3 {
+		t.Fatalf("expected both stops to be triggered by the trade at 94, got %d trades", len(publisher.Trades))
+	}
+
+	if publisher.Trades[1].SellOrder().ID != near.ID || publisher.Trades[2].SellOrder().ID != far.ID {
+		t.Errorf("expected the stop closest to the price to be matched first, got %d then %d",
+			publisher.Trades[1].SellOrder().ID, publisher.Trades[2].SellOrder().ID)
+	}
+}
+
+// A stop triggered while an order sweeps the book is matched once that order is done, not in the middle of it.
+func TestStopOrderTriggeredMidMatch(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "102", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "104", "1"))
+
+	stop := newTestStopOrder(pkg.SideBuy, "101", "103", "2")
+	ob.Add(stop)
+
+	// the trade at 101 triggers the stop before the taker reaches 102
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "102", "2")
+	ob.Add(taker)
+
+	if len(publisher.Trades) != 2 || publisher.Trades[0].TakerOrder.ID != taker.ID || publisher.Trades[1].TakerOrder.ID != taker.ID {
+		t.Fatalf("expected the taker to fill before the stop is matched, got %+v", publisher.Trades)
+	}
+
+	// the stop-limit can't buy at 104, it rests at its price with the stop price it had
+	if !bookHas(ob, stop) || ob.StopBids.Size() != 0 {
+		t.Fatal("expected the triggered stop to rest as a limit order at 103")
+	}
+
+	if !ob.removeOrder(stop.Key()) || bookHas(ob, stop) {
+		t.Error("expected the triggered stop to be cancelled from the book")
+	}
+}
+
+func TestStopOrderCrossesOnceTriggered(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "97", "1"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "95", "1"))
+
+	stop := newTestStopOrder(pkg.SideSell, "99", "96", "3")
+	ob.Add(stop)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "99", "1"))
+
+	if len(publisher.Trades) != 2 {
+		t.Fatalf("expected the trade at 99 and the stop taking the bid at 97, got %d trades", len(publisher.Trades))
+	}
+
+	trade := publisher.Trades[1]
+	if trade.TakerOrder.ID != stop.ID || !trade.Price.Equal(decimal.NewFromInt(97)) {
+		t.Errorf("expected the stop to trade as taker at 97, got %+v", trade)
+	}
+
+	// it doesn't sell below its price, the rest of it rests at 96
+	if !bookHas(ob, stop) || !stop.UnfilledQuantity().Equal(decimal.NewFromInt(2)) {
+		t.Errorf("expected 2 of the stop to rest at 96, %s unfilled", stop.UnfilledQuantity())
+	}
+}
+
+func TestStopMarketOrder(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "110", "1"))
+
+	stop := newTestOrder(pkg.SideBuy, pkg.TypeMarket, "0", "1")
+	stop.StopPrice = decimal.NewFromInt(101)
+	ob.Add(stop)
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1"))
+
+	if len(publisher.Trades) != 2 || publisher.Trades[1].TakerOrder.ID != stop.ID || !publisher.Trades[1].Price.Equal(decimal.NewFromInt(110)) {
+		t.Errorf("expected the stop-market order to buy at any price, got %+v", publisher.Trades)
+	}
+}
@@ -0,0 +1,103 @@
+package matching
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/shopspring/decimal"
+)
+
+// TickerSnapshot is the best prices of a book and its last trade. Sequence grows with each change of the snapshot,
+// a reader holding two of them knows whether the book moved in between.
+type TickerSnapshot struct {
+	BestBid      decimal.NullDecimal `json:"best_bid"`
+	BestAsk      decimal.NullDecimal `json:"best_ask"`
+	LastPrice    decimal.Decimal     `json:"last_price"`
+	LastQuantity decimal.Decimal     `json:"last_quantity"`
+	Sequence     int64               `json:"sequence"`
+}
+
+// ticker keeps the latest snapshot of a book for the readers off the matching path: the book stores a copy of the
+// snapshot once it changed, the readers load the last copy stored and never wait for the book.
+type ticker struct {
+	// mutex orders the changes of the book and the depth, the readers don't take it
+	mutex   sync.Mutex
+	current TickerSnapshot
+	latest  atomic.Value
+}
+
+// newTicker returns the ticker of a book which starts at last_price, before its first trade.
+func newTicker(last_price decimal.Decimal) *ticker {
+	t := &ticker{current: TickerSnapshot{LastPrice: last_price}}
+	t.latest.Store(t.current)
+
+	return t
+}
+
+// Load returns the last snapshot stored, it's safe to call from any goroutine.
+func (t *ticker) Load() TickerSnapshot {
+	return t.latest.Load().(TickerSnapshot)
+}
+
+// quote moves the best prices of the snapshot, the depth calls it once a level changed. The best prices are only
+// stored when they moved, most changes of the book are below its best levels.
+func (t *ticker) quote(best_bid, best_ask decimal.NullDecimal) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if sameNullDecimal(t.current.BestBid, best_bid) && sameNullDecimal(t.current.BestAsk, best_ask) {
+		return
+	}
+
+	t.current.BestBid = best_bid
+	t.current.BestAsk = best_ask
+	t.store()
+}
+
+// trade sets the last trade of the snapshot.
+func (t *ticker) trade(price, quantity decimal.Decimal) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.current.LastPrice = price
+	t.current.LastQuantity = quantity
+	t.store()
+}
+
+// store publishes the next snapshot with the mutex held.
+func (t *ticker) store() {
+	t.current.Sequence++
+	t.latest.Store(t.current)
+}
+
+func sameNullDecimal(a, b decimal.NullDecimal) bool {
+	return a.Valid == b.Valid && (!a.Valid || a.Decimal.Equal(b.Decimal))
+}
+
+// quoteTicker hands the best prices of the depth to its ticker, with the depthMutex held.
+func (d *Depth) quoteTicker() {
+	if d.ticker == nil {
+		return
+	}
+
+	best_price := func(price_levels *redblacktree.Tree) decimal.NullDecimal {
+		if best := bestLevel(price_levels); best != nil {
+			return decimal.NewNullDecimal(best.Price)
+		}
+
+		return decimal.NullDecimal{}
+	}
+
+	d.ticker.quote(best_price(d.Bids), best_price(d.Asks))
+}
+
+// Ticker returns the last snapshot of the book without waiting for it, readers never block the matching.
+func (ob *OrderBook) Ticker() TickerSnapshot {
+	return ob.ticker.Load()
+}
+
+// Ticker returns the last snapshot of the book of the engine without waiting for it.
+func (e *Engine) Ticker() TickerSnapshot {
+	return e.OrderBook.Ticker()
+}
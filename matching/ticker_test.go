@@ -0,0 +1,129 @@
+package matching
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func TestTickerFollowsTheBook(t *testing.T) {
+	d := decimal.RequireFromString
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	if ticker := ob.Ticker(); !ticker.LastPrice.Equal(d("100")) || ticker.BestBid.Valid || ticker.BestAsk.Valid {
+		t.Fatalf("expected an empty book at its market price, got %+v", ticker)
+	}
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1"))
+	best_ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	ob.Add(best_ask)
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "102", "1"))
+
+	quoted := ob.Ticker()
+	if !quoted.BestBid.Decimal.Equal(d("99")) || !quoted.BestAsk.Decimal.Equal(d("101")) {
+		t.Fatalf("expected the best prices 99 and 101, got %+v", quoted)
+	}
+
+	// a level below the best prices doesn't move the ticker
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "103", "1"))
+	if ticker := ob.Ticker(); ticker.Sequence != quoted.Sequence {
+		t.Errorf("expected the ticker not to move, got sequence %d after %d", ticker.Sequence, quoted.Sequence)
+	}
+
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "0.4")
+	taker.MemberID = 2
+	ob.Add(taker)
+
+	traded := ob.Ticker()
+	if !traded.LastPrice.Equal(d("101")) || !traded.LastQuantity.Equal(d("0.4")) || traded.Sequence <= quoted.Sequence {
+		t.Errorf("expected the last trade of 0.4 at 101, got %+v", traded)
+	}
+
+	ob.Cancel(best_ask.Key(), "")
+	if ticker := ob.Ticker(); !ticker.BestAsk.Decimal.Equal(d("102")) || !ticker.BestBid.Decimal.Equal(d("99")) {
+		t.Errorf("expected the best ask to move to 102, got %+v", ticker)
+	}
+}
+
+func TestTickerUpdate(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	notification := ob.Depth.Notification
+
+	if ticker := notification.tickerUpdate(); ticker != nil {
+		t.Fatalf("expected the ticker of a book which didn't move not to be published, got %+v", ticker)
+	}
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1"))
+
+	if ticker := notification.tickerUpdate(); ticker == nil || !ticker.BestBid.Decimal.Equal(decimal.NewFromInt(99)) {
+		t.Fatalf("expected the ticker to be published, got %+v", ticker)
+	}
+
+	if ticker := notification.tickerUpdate(); ticker != nil {
+		t.Errorf("expected the ticker published not to be published again, got %+v", ticker)
+	}
+}
+
+// TestTickerReadersDontBlock is meant to run with -race: the readers load the ticker while the engine matches.
+func TestTickerReadersDontBlock(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	engine := newEngine(testSymbol, ob, 0)
+
+	done := make(chan struct{})
+	errs := make(chan error, 4)
+
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+
+			var last int64
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				ticker := engine.Ticker()
+				if ticker.Sequence < last {
+					errs <- fmt.Errorf("the ticker went back from sequence %d to %d", last, ticker.Sequence)
+					return
+				}
+				last = ticker.Sequence
+
+				// the book doesn't rest crossed, not even while it matches
+				if ticker.BestBid.Valid && ticker.BestAsk.Valid && !ticker.BestBid.Decimal.LessThan(ticker.BestAsk.Decimal) {
+					errs <- fmt.Errorf("the ticker is crossed at %s and %s", ticker.BestBid.Decimal, ticker.BestAsk.Decimal)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 2000; i++ {
+		price := fmt.Sprint(95 + i%10)
+		maker := newTestOrder(pkg.SideSell, pkg.TypeLimit, price, "1")
+		engine.Submit(maker)
+
+		taker := newTestOrder(pkg.SideBuy, pkg.TypeLimit, price, "0.5")
+		taker.MemberID = 2
+		engine.Submit(taker)
+	}
+
+	close(done)
+	readers.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if ticker := engine.Ticker(); !ticker.LastQuantity.Equal(decimal.RequireFromString("0.5")) {
+		t.Errorf("expected the last trade to be in the ticker, got %+v", ticker)
+	}
+}
@@ -0,0 +1,100 @@
+package matching
+
+import (
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+// fillable walks offers from the best price without matching them and reports whether o would be matched in full
+// at the prices its limit, its slippage collar and the price limit accept, by the orders the matching would trade.
+func (ob *OrderBook) fillable(o *pkg.Order, offers *redblacktree.Tree) bool {
+	remaining := o.UnfilledQuantity()
+	collar := ob.collar(o, offers)
+
+	iterator := offers.Iterator()
+	for iterator.End(); remaining.IsPositive() && iterator.Prev(); {
+		price_level := iterator.Value().(*PriceLevel)
+		if o.Type == pkg.TypeLimit && !o.IsCrossed(price_level.Price) {
+			break
+		}
+
+		if beyondCollar(o, collar, price_level.Price) {
+			break
+		}
+
+		if _, found := ob.PriceLimit.TradePrice(o.Side, price_level.Price); !found {
+			break
+		}
+
+		// the matching cancels the expired orders and the ones self-trade prevention takes out instead of them
+		for _, value := range price_level.Orders.Values() {
+			counter_order := value.(*pkg.Order)
+			if ob.expired(counter_order) {
+				continue
+			}
+
+			if ob.selfTrade(o, counter_order) {
+				switch ob.optionsOf(o).SelfTradePrevention {
+				case types.SelfTradePreventionCancelNewest, types.SelfTradePreventionCancelBoth:
+					return false
+				case types.SelfTradePreventionCancelOldest:
+					continue
+				}
+
+				// decrement and cancel takes the quantity off o without a trade
+			}
+
+			remaining = remaining.Sub(counter_order.UnfilledQuantity())
+			if !remaining.IsPositive() {
+				break
+			}
+		}
+	}
+
+	return !remaining.IsPositive()
+}
+
+// rejectFillOrKill cancels a fill-or-kill order offers can't match in full, it reports whether it did.
+// Nothing of the order is matched then, the orders of offers are left as they were.
+func (ob *OrderBook) rejectFillOrKill(o *pkg.Order, offers *redblacktree.Tree) bool {
+	if ob.optionsOf(o).TimeInForce != types.TimeInForceFOK || ob.fillable(o, offers) {
+		return false
+	}
+
+	config.Logger.Debugf("[oceanbook.orderbook] fill-or-kill order %d of %s can't be matched in full", o.ID, o.UnfilledQuantity())
+
+	ob.cancelUnmatched(o, CancelReasonFillOrKill)
+
+	return true
+}
+
+// rejectImmediate cancels an immediate-or-cancel or a fill-or-kill order submitted to a book which doesn't match
+// it right away, during the warm-up of its listing or between its batch auctions. It reports whether it did.
+func (ob *OrderBook) rejectImmediate(o *pkg.Order) bool {
+	switch ob.optionsOf(o).TimeInForce {
+	case types.TimeInForceFOK:
+		ob.cancelUnmatched(o, CancelReasonFillOrKill)
+	case types.TimeInForceIOC:
+		// a market order is immediate or cancel by itself
+		if o.Type == pkg.TypeMarket {
+			return false
+		}
+
+		ob.cancelUnmatched(o, CancelReasonImmediateOrCancel)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// cancelUnmatched cancels the part of the order which isn't matched and forgets its options.
+func (ob *OrderBook) cancelUnmatched(o *pkg.Order, reason CancelReason) {
+	ob.forget(o.ID)
+	if !o.IsFake() {
+		ob.PublishCancel(o.Key(), reason)
+	}
+}
@@ -0,0 +1,179 @@
+package matching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/types"
+)
+
+var (
+	immediateOrCancel = &events.OrderOptions{TimeInForce: types.TimeInForceIOC}
+	fillOrKill        = &events.OrderOptions{TimeInForce: types.TimeInForceFOK}
+)
+
+func TestFillOrKillMatchedInFull(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(10), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "11", "2"))
+
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "11", "3")
+	ob.add(bid, fillOrKill)
+
+	if len(publisher.Trades) != 2 || !bid.Filled() {
+		t.Fatalf("expected the order to be matched in full across both levels, got %d trades", len(publisher.Trades))
+	}
+
+	if len(publisher.Cancels) != 0 || ob.Options(bid.ID) != nil {
+		t.Errorf("expected the filled order to leave the book, got %+v", publisher.Cancels)
+	}
+}
+
+func TestFillOrKillLiquidityBeyondLimit(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(10), OrderBookConfig{}, nil)
+
+	near := newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "1")
+	far := newTestOrder(pkg.SideSell, pkg.TypeLimit, "12", "5")
+	ob.Add(near)
+	ob.Add(far)
+
+	// the book holds enough but only one unit at the limit price, nothing is matched
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "11", "2")
+	ob.add(bid, fillOrKill)
+
+	if len(publisher.Trades) != 0 || !bid.FilledQuantity.IsZero() {
+		t.Fatalf("expected the order not to trade, got %d trades", len(publisher.Trades))
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0] != (cancelRecord{ID: bid.ID, Reason: CancelReasonFillOrKill}) {
+		t.Fatalf("expected the order to be killed, got %+v", publisher.Cancels)
+	}
+
+	if !near.FilledQuantity.IsZero() || !far.FilledQuantity.IsZero() || !bookHas(ob, near) || !bookHas(ob, far) || bookHas(ob, bid) {
+		t.Error("expected the book to be left as it was")
+	}
+}
+
+func TestFillOrKillMarketOrder(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(10), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "10", "1"))
+
+	ask := newTestOrder(pkg.SideSell, pkg.TypeMarket, "0", "2")
+	ob.add(ask, fillOrKill)
+
+	if len(publisher.Trades) != 0 || len(publisher.Cancels) != 1 || publisher.Cancels[0].Reason != CancelReasonFillOrKill {
+		t.Fatalf("expected the market order to be killed, got %d trades and %+v", len(publisher.Trades), publisher.Cancels)
+	}
+}
+
+func TestFillOrKillLiquidityBeyondCollar(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	near := newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1")
+	beyond := newTestOrder(pkg.SideSell, pkg.TypeLimit, "106", "5")
+	ob.Add(near)
+	ob.Add(beyond)
+
+	// the book holds enough but only one unit within 5% of 100, nothing is matched
+	slippage := decimal.RequireFromString("0.05")
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeMarket, "", "2")
+	ob.add(bid, &events.OrderOptions{TimeInForce: types.TimeInForceFOK, MaxSlippage: &slippage})
+
+	if len(publisher.Trades) != 0 || !bid.FilledQuantity.IsZero() {
+		t.Fatalf("expected the order not to trade, got %d trades", len(publisher.Trades))
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0] != (cancelRecord{ID: bid.ID, Reason: CancelReasonFillOrKill}) {
+		t.Fatalf("expected the order to be killed, got %+v", publisher.Cancels)
+	}
+
+	if !bookHas(ob, near) || !bookHas(ob, beyond) {
+		t.Error("expected the book to be left as it was")
+	}
+}
+
+func TestFillOrKillExpiredAtBestPrice(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(10), OrderBookConfig{}, fake)
+
+	expired := newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "2")
+	ob.add(expired, expiresIn(fake, time.Second))
+	live := newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "1")
+	ob.Add(live)
+	fake.Advance(2 * time.Second)
+
+	// the level holds 3 of which only 1 trades
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "10", "2")
+	ob.add(bid, fillOrKill)
+
+	if len(publisher.Trades) != 0 || !live.FilledQuantity.IsZero() {
+		t.Fatalf("expected the order not to trade, got %d trades", len(publisher.Trades))
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0] != (cancelRecord{ID: bid.ID, Reason: CancelReasonFillOrKill}) {
+		t.Errorf("expected the order to be killed, got %+v", publisher.Cancels)
+	}
+}
+
+func TestFillOrKillSelfTradeCancelOldest(t *testing.T) {
+	ob, publisher, own, bid := newSelfTradeBook("3")
+
+	// the ask of its member is cancelled rather than matched, only 1 of 3 trades
+	ob.add(bid, &events.OrderOptions{TimeInForce: types.TimeInForceFOK, SelfTradePrevention: types.SelfTradePreventionCancelOldest})
+
+	if len(publisher.Trades) != 0 || !bookHas(ob, own) {
+		t.Fatalf("expected the order not to trade, got %d trades", len(publisher.Trades))
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0] != (cancelRecord{ID: bid.ID, Reason: CancelReasonFillOrKill}) {
+		t.Errorf("expected the order to be killed, got %+v", publisher.Cancels)
+	}
+}
+
+func TestImmediateOrCancel(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(10), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "10", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "12", "1"))
+
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "11", "2")
+	ob.add(bid, immediateOrCancel)
+
+	if len(publisher.Trades) != 1 || !bid.FilledQuantity.Equal(decimal.NewFromInt(1)) {
+		t.Fatalf("expected the part at the limit to be matched, got %d trades", len(publisher.Trades))
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0] != (cancelRecord{ID: bid.ID, Reason: CancelReasonImmediateOrCancel}) {
+		t.Fatalf("expected the rest to be cancelled, got %+v", publisher.Cancels)
+	}
+
+	if bookHas(ob, bid) || ob.Options(bid.ID) != nil {
+		t.Error("expected the order not to rest")
+	}
+}
+
+func TestTimeInForceBatchAuction(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(10), OrderBookConfig{BatchInterval: time.Second}, nil)
+	defer ob.StopBatch()
+
+	ioc := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "10", "1")
+	ob.add(ioc, immediateOrCancel)
+
+	fok := newTestOrder(pkg.SideSell, pkg.TypeMarket, "0", "1")
+	ob.add(fok, fillOrKill)
+
+	market := newTestOrder(pkg.SideSell, pkg.TypeMarket, "0", "1")
+	ob.add(market, immediateOrCancel)
+
+	expected := []cancelRecord{{ID: ioc.ID, Reason: CancelReasonImmediateOrCancel}, {ID: fok.ID, Reason: CancelReasonFillOrKill}}
+	if len(publisher.Cancels) != 2 || publisher.Cancels[0] != expected[0] || publisher.Cancels[1] != expected[1] {
+		t.Errorf("expected the orders which can't wait for the uncross to be cancelled, got %+v", publisher.Cancels)
+	}
+}
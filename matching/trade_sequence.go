@@ -0,0 +1,30 @@
+package matching
+
+// TradeSequence is the sequence of the last trade of the book. The trades of a market are numbered one after the
+// other, the trade executor executes a trade delivered again once by its market and its sequence.
+func (ob *OrderBook) TradeSequence() int64 {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	return ob.tradeSequence
+}
+
+// ContinueTradeSequence numbers the trades of the book after the ones of the book previous, which this book replaces.
+// A book seeded past the sequence of previous keeps its own.
+func (ob *OrderBook) ContinueTradeSequence(previous *OrderBook) {
+	trade_sequence := previous.TradeSequence()
+
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	if trade_sequence > ob.tradeSequence {
+		ob.tradeSequence = trade_sequence
+	}
+}
+
+// nextTradeSequence numbers the next trade of the book, with the orderMutex held.
+func (ob *OrderBook) nextTradeSequence() int64 {
+	ob.tradeSequence++
+
+	return ob.tradeSequence
+}
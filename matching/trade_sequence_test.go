@@ -0,0 +1,50 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func TestTradeSequenceGoesOnAcrossBooks(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{TradeSequence: 41}, nil)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "102", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "103", "1"))
+
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "102", "2")
+	taker.MemberID = 2
+	ob.Add(taker)
+
+	if len(publisher.Sequences) != 2 || publisher.Sequences[0] != 42 || publisher.Sequences[1] != 43 {
+		t.Fatalf("expected the trades numbered after 41, got %v", publisher.Sequences)
+	}
+
+	// a book restored from an image numbers the trades matched again as the book it was taken from
+	image := ob.image()
+	restored, restored_publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	restored.restoreImage(image)
+
+	next := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "103", "1")
+	next.MemberID = 2
+	restored.Add(next)
+
+	if len(restored_publisher.Sequences) != 1 || restored_publisher.Sequences[0] != 44 {
+		t.Errorf("expected the restored book to go on from 43, got %v", restored_publisher.Sequences)
+	}
+
+	// a book reloaded goes on from the book it replaces
+	reloaded, reloaded_publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+	reloaded.ContinueTradeSequence(restored)
+
+	reloaded.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+	last := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1")
+	last.MemberID = 2
+	reloaded.Add(last)
+
+	if len(reloaded_publisher.Sequences) != 1 || reloaded_publisher.Sequences[0] != 45 || reloaded.TradeSequence() != 45 {
+		t.Errorf("expected the reloaded book to go on from 44, got %v", reloaded_publisher.Sequences)
+	}
+}
@@ -0,0 +1,80 @@
+package matching
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+// TradeTapeSize is the number of the latest trades of a book its tape keeps.
+const TradeTapeSize = 500
+
+// TapeTrade is a trade of a book as its tape keeps it. ID is the sequence of the trade in its market, a trade with a
+// greater ID was matched after it.
+type TapeTrade struct {
+	ID           int64           `json:"id"`
+	Price        decimal.Decimal `json:"price"`
+	Amount       decimal.Decimal `json:"amount"`
+	Total        decimal.Decimal `json:"total"`
+	TakerSide    pkg.OrderSide   `json:"taker_side"`
+	MakerOrderID int64           `json:"maker_order_id"`
+	TakerOrderID int64           `json:"taker_order_id"`
+	// MatchedAt is the time of the clock of the book, the time the trade executor creates the trade at
+	MatchedAt time.Time `json:"matched_at"`
+}
+
+// tradeTape keeps the latest trades of a book in a ring for the readers off the matching path. The book stores each
+// trade in its slot before it moves the last ID, the readers load the last ID then walk the slots back and never wait
+// for the book: a slot holding a trade other than the one expected was overwritten by a newer trade meanwhile.
+type tradeTape struct {
+	slots [TradeTapeSize]atomic.Value
+	last  int64
+}
+
+// add appends a trade published with stamp, only the book calls it.
+func (t *tradeTape) add(trade *pkg.Trade, stamp TradeStamp) {
+	id := stamp.Sequence
+
+	t.slots[id%TradeTapeSize].Store(&TapeTrade{
+		ID:           id,
+		Price:        trade.Price,
+		Amount:       trade.Quantity,
+		Total:        trade.Total,
+		TakerSide:    trade.TakerOrder.Side,
+		MakerOrderID: trade.MakerOrder.ID,
+		TakerOrderID: trade.TakerOrder.ID,
+		MatchedAt:    stamp.MatchedAt,
+	})
+
+	atomic.StoreInt64(&t.last, id)
+}
+
+// Recent returns up to limit of the trades after since_id the tape still holds, the newest first. It's safe to call
+// from any goroutine.
+func (t *tradeTape) Recent(limit int, since_id int64) []TapeTrade {
+	trades := make([]TapeTrade, 0)
+
+	for id := atomic.LoadInt64(&t.last); id > since_id && len(trades) < limit; id-- {
+		trade, ok := t.slots[id%TradeTapeSize].Load().(*TapeTrade)
+		if !ok || trade.ID != id {
+			break
+		}
+
+		trades = append(trades, *trade)
+	}
+
+	return trades
+}
+
+// RecentTrades returns up to limit of the trades of the book after since_id its tape holds, the newest first, without
+// waiting for the matching.
+func (ob *OrderBook) RecentTrades(limit int, since_id int64) []TapeTrade {
+	return ob.tape.Recent(limit, since_id)
+}
+
+// RecentTrades returns the recent trades of the book of the engine without waiting for it, see OrderBook.RecentTrades.
+func (e *Engine) RecentTrades(limit int, since_id int64) []TapeTrade {
+	return e.OrderBook.RecentTrades(limit, since_id)
+}
@@ -0,0 +1,88 @@
+package matching
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+)
+
+func TestTradeTapeFollowsTheBook(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1"))
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "102", "1"))
+
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "102", "1.5")
+	taker.MemberID = 2
+	ob.Add(taker)
+
+	trades := ob.RecentTrades(10, 0)
+	if len(trades) != 2 {
+		t.Fatalf("expected the 2 trades of the taker, got %+v", trades)
+	}
+
+	// the newest first
+	if trades[0].ID != 2 || !trades[0].Price.Equal(decimal.NewFromInt(102)) || !trades[0].Amount.Equal(decimal.RequireFromString("0.5")) {
+		t.Errorf("expected the trade of 0.5 at 102 last, got %+v", trades[0])
+	}
+
+	if trades[1].ID != 1 || !trades[1].Price.Equal(decimal.NewFromInt(101)) || trades[1].TakerSide != pkg.SideBuy || trades[1].TakerOrderID != taker.ID {
+		t.Errorf("expected the trade at 101 of the taker first, got %+v", trades[1])
+	}
+
+	if since := ob.RecentTrades(10, 1); len(since) != 1 || since[0].ID != 2 {
+		t.Errorf("expected only the trade after 1, got %+v", since)
+	}
+
+	if limited := ob.RecentTrades(1, 0); len(limited) != 1 || limited[0].ID != 2 {
+		t.Errorf("expected only the newest trade, got %+v", limited)
+	}
+}
+
+func TestTradeTapeKeepsTheLatestTrades(t *testing.T) {
+	tape := &tradeTape{}
+
+	for i := 1; i <= TradeTapeSize+10; i++ {
+		tape.add(&pkg.Trade{Price: decimal.NewFromInt(int64(i)), Quantity: decimal.NewFromInt(1)}, TradeStamp{Sequence: int64(i), MatchedAt: time.Now()})
+	}
+
+	trades := tape.Recent(TradeTapeSize*2, 0)
+	if len(trades) != TradeTapeSize {
+		t.Fatalf("expected the tape to hold %d trades, got %d", TradeTapeSize, len(trades))
+	}
+
+	for i, trade := range trades {
+		if want := int64(TradeTapeSize + 10 - i); trade.ID != want || !trade.Price.Equal(decimal.NewFromInt(want)) {
+			t.Fatalf("expected trade %d at %d, got %+v", want, want, trade)
+		}
+	}
+}
+
+func TestTradeTapeReadWhileMatching(t *testing.T) {
+	tape := &tradeTape{}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 1; i <= TradeTapeSize*4; i++ {
+			tape.add(&pkg.Trade{Price: decimal.NewFromInt(int64(i)), Quantity: decimal.NewFromInt(1)}, TradeStamp{Sequence: int64(i), MatchedAt: time.Now()})
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		trades := tape.Recent(TradeTapeSize, 0)
+
+		for j := 1; j < len(trades); j++ {
+			if trades[j].ID != trades[j-1].ID-1 {
+				t.Fatalf("expected the trades in order without a gap, got %d then %d", trades[j-1].ID, trades[j].ID)
+			}
+		}
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,87 @@
+package matching
+
+import (
+	"time"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/types"
+)
+
+// SetTradingState sets the state of the book in a cycle, see OrderBook.SetTradingState.
+func (e *Engine) SetTradingState(state types.TradingState) {
+	started_at := time.Now()
+
+	e.MatchingMutex.Lock()
+	defer e.MatchingMutex.Unlock()
+
+	e.OrderBook.SetTradingState(state)
+
+	e.Metrics.Observe(Cycle{
+		Action:  events.ActionTradingState,
+		Latency: time.Since(started_at),
+	})
+}
+
+// SetTradingState sets what the book takes. A book trading takes every command. A book which only takes cancels
+// rejects the orders submitted to it, their replaces and the amends which aren't reductions, the orders in it stay
+// and can be cancelled. A halted book is frozen: it rejects the orders and the amends, the cancels are rejected
+// too and the orders stay as they are, the stop orders aren't triggered, the batch isn't uncrossed, the listing
+// doesn't open and the expired orders aren't swept until it's resumed.
+func (ob *OrderBook) SetTradingState(state types.TradingState) {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	was_halted := ob.halted()
+	ob.tradingState = state
+
+	if ob.tradingState != types.TradingStateTrading {
+		config.Logger.Infof("[oceanbook.orderbook] %s is %s", ob.Symbol.String(), ob.tradingState)
+	}
+
+	// a listing which reached its opening while the book was halted opens now
+	if was_halted && !ob.halted() {
+		ob.openIfDue()
+	}
+}
+
+// TradingState returns what the book takes, a book trading takes cancels only while its circuit breaker is tripped.
+func (ob *OrderBook) TradingState() types.TradingState {
+	ob.orderMutex.Lock()
+	defer ob.orderMutex.Unlock()
+
+	return ob.tradingStateLocked()
+}
+
+// tradingStateLocked returns what the book takes with the orderMutex held.
+func (ob *OrderBook) tradingStateLocked() types.TradingState {
+	switch {
+	case len(ob.tradingState) > 0 && ob.tradingState != types.TradingStateTrading:
+		return ob.tradingState
+	case ob.breaker.tripped():
+		return types.TradingStateCancelOnly
+	}
+
+	return types.TradingStateTrading
+}
+
+// halted reports whether the book is frozen, with the orderMutex held.
+func (ob *OrderBook) halted() bool {
+	return ob.tradingState == types.TradingStateHalted
+}
+
+// rejectsOrders returns the reason the orders submitted to the book are cancelled with, with the orderMutex held.
+func (ob *OrderBook) rejectsOrders() (CancelReason, bool) {
+	switch ob.tradingState {
+	case types.TradingStateHalted:
+		return CancelReasonHalted, true
+	case types.TradingStateCancelOnly:
+		return CancelReasonCancelOnly, true
+	}
+
+	if ob.breaker.tripped() {
+		return CancelReasonCircuitBreaker, true
+	}
+
+	return "", false
+}
@@ -0,0 +1,104 @@
+package matching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/types"
+)
+
+func TestHaltedBookIsFrozen(t *testing.T) {
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	ask := newTestOrder(pkg.SideSell, pkg.TypeLimit, "101", "1")
+	bid := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "99", "1")
+	ob.Add(ask)
+	ob.Add(bid)
+
+	ob.SetTradingState(types.TradingStateHalted)
+	if state := ob.TradingState(); state != types.TradingStateHalted || !ob.CancelOnly() {
+		t.Fatalf("expected the book halted, got %s", state)
+	}
+
+	taker := newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1")
+	ob.Add(taker)
+
+	if len(publisher.Trades) != 0 || bookHas(ob, taker) {
+		t.Fatalf("expected the order to be rejected, got %d trades", len(publisher.Trades))
+	}
+
+	if len(publisher.Cancels) != 1 || publisher.Cancels[0] != (cancelRecord{ID: taker.ID, Reason: CancelReasonHalted}) {
+		t.Fatalf("expected the order to be cancelled, got %+v", publisher.Cancels)
+	}
+
+	// the orders in the book stay as they are
+	if outcome := ob.Cancel(ask.Key(), "cmd-1"); outcome != CancelOutcomeRejected || !bookHas(ob, ask) {
+		t.Errorf("expected the cancel to be rejected, got %s", outcome)
+	}
+
+	quantity := decimal.RequireFromString("0.5")
+	if accepted, _ := ob.Amend(bid.Key(), &events.Amendment{Quantity: &quantity}); accepted || !bid.Quantity.Equal(decimal.NewFromInt(1)) {
+		t.Error("expected the amend to be rejected")
+	}
+
+	if ids := ob.CancelAll(ask.MemberID, ""); len(ids) != 0 || !bookHas(ob, ask) || !bookHas(ob, bid) {
+		t.Errorf("expected no order to be cancelled, got %v", ids)
+	}
+
+	ob.SetTradingState(types.TradingStateCancelOnly)
+
+	if outcome := ob.Cancel(ask.Key(), "cmd-2"); outcome != CancelOutcomeCancelled || bookHas(ob, ask) {
+		t.Errorf("expected the cancel to be taken once the book takes cancels, got %s", outcome)
+	}
+
+	ob.SetTradingState(types.TradingStateTrading)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "99", "1"))
+	if len(publisher.Trades) != 1 {
+		t.Errorf("expected the book to match again once resumed, got %d trades", len(publisher.Trades))
+	}
+}
+
+func TestHaltedBookKeepsItsBatch(t *testing.T) {
+	start := time.Date(2022, 5, 10, 10, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	ob, publisher := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{BatchInterval: time.Hour}, fake)
+
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, "100", "1"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1"))
+
+	ob.SetTradingState(types.TradingStateHalted)
+	fake.Advance(time.Hour)
+
+	if len(publisher.Trades) != 0 {
+		t.Fatalf("expected the halted book not to uncross, got %d trades", len(publisher.Trades))
+	}
+
+	ob.SetTradingState(types.TradingStateTrading)
+	fake.Advance(time.Hour)
+
+	if len(publisher.Trades) != 1 {
+		t.Errorf("expected the batch to uncross once resumed, got %d trades", len(publisher.Trades))
+	}
+}
+
+func TestApplyTradingState(t *testing.T) {
+	engine := NewDetachedEngine(testSymbol, decimal.NewFromInt(100), OrderBookConfig{Publisher: &recordingPublisher{}})
+
+	command := &events.MatchingPayload{TradingState: &events.TradingStateChange{ID: 1, State: types.TradingStateCancelOnly}}
+	command.Action = events.ActionTradingState
+
+	if !engine.Apply(command) || engine.OrderBook.TradingState() != types.TradingStateCancelOnly {
+		t.Errorf("expected the book to take cancels only, got %s", engine.OrderBook.TradingState())
+	}
+
+	command.TradingState.State = "closed"
+	if engine.Apply(command) || engine.OrderBook.TradingState() != types.TradingStateCancelOnly {
+		t.Error("expected an unknown state to be refused")
+	}
+}
@@ -0,0 +1,63 @@
+package matching
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/types"
+)
+
+// trailingOffset returns the offset a stop order trails the market price at, zero unless it's a trailing stop.
+func (ob *OrderBook) trailingOffset(o *pkg.Order) decimal.Decimal {
+	if offset := ob.optionsOf(o).TrailingOffset; offset != nil {
+		return *offset
+	}
+
+	return decimal.Zero
+}
+
+// isStop reports whether an order waits in the stop orders, a trailing stop does even without a stop price.
+func (ob *OrderBook) isStop(o *pkg.Order) bool {
+	return o.StopPrice.IsPositive() || ob.trailingOffset(o).IsPositive()
+}
+
+// trailedStopPrice returns the stop price of a trailing stop once the market price is at price: the offset below
+// it for a sell and above it for a buy, unless the stop price it had is closer to the price. The stop price of
+// a sell only goes up and the one of a buy only goes down.
+func trailedStopPrice(o *pkg.Order, offset, price decimal.Decimal) decimal.Decimal {
+	if !price.IsPositive() {
+		return o.StopPrice
+	}
+
+	if o.Side == pkg.SideSell {
+		return decimal.Max(o.StopPrice, price.Sub(offset))
+	}
+
+	stop_price := price.Add(offset)
+	if o.StopPrice.IsPositive() && o.StopPrice.LessThan(stop_price) {
+		return o.StopPrice
+	}
+
+	return stop_price
+}
+
+// trailStops moves the stop prices of the trailing stops triggered by the price of trigger along with it, with the
+// orderMutex held. A stop moved is put back in the stop orders under its new stop price, its creation time keeps its
+// priority among the stops of that price.
+func (ob *OrderBook) trailStops(trigger types.TriggerBy, price decimal.Decimal) {
+	for _, o := range ob.trailing {
+		if ob.triggerBy(o.ID) != trigger {
+			continue
+		}
+
+		stop_price := trailedStopPrice(o, ob.trailingOffset(o), price)
+		if stop_price.Equal(o.StopPrice) {
+			continue
+		}
+
+		book := ob.stopBook(o.Side, trigger)
+		book.Remove(o.Key())
+		o.StopPrice = stop_price
+		book.Put(o.Key(), o)
+	}
+}
@@ -0,0 +1,134 @@
+package matching
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/pkg"
+)
+
+func trailing(offset string) *events.OrderOptions {
+	d := decimal.RequireFromString(offset)
+
+	return &events.OrderOptions{TrailingOffset: &d}
+}
+
+// tradeAt moves the market price of a book without other orders crossing price.
+func tradeAt(ob *OrderBook, price string) {
+	ob.Add(newTestOrder(pkg.SideSell, pkg.TypeLimit, price, "1"))
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, price, "1"))
+}
+
+func stopPriceOf(t *testing.T, ob *OrderBook, o *pkg.Order) decimal.Decimal {
+	t.Helper()
+
+	if _, found := ob.stopBookOf(o.ID, o.Side).Get(o.Key()); !found {
+		t.Fatalf("expected order %d to wait in the stop orders under stop price %s", o.ID, o.StopPrice)
+	}
+
+	return o.StopPrice
+}
+
+func TestTrailingSellStop(t *testing.T) {
+	d := decimal.RequireFromString
+	ob, publisher := newTestOrderBook(d("100"), OrderBookConfig{}, nil)
+
+	stop := newTestOrder(pkg.SideSell, pkg.TypeMarket, "0", "1")
+	ob.add(stop, trailing("5"))
+
+	if !stopPriceOf(t, ob, stop).Equal(d("95")) {
+		t.Fatalf("expected the stop to start 5 below the market price, got %s", stop.StopPrice)
+	}
+
+	tradeAt(ob, "102")
+	tradeAt(ob, "108")
+	if !stopPriceOf(t, ob, stop).Equal(d("103")) {
+		t.Fatalf("expected the stop to follow the highest price, got %s", stop.StopPrice)
+	}
+
+	// the price falling back doesn't lower it
+	tradeAt(ob, "104")
+	if !stopPriceOf(t, ob, stop).Equal(d("103")) {
+		t.Fatalf("expected the stop to stay at 103 after the reversal, got %s", stop.StopPrice)
+	}
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "101", "1"))
+	trades := len(publisher.Trades)
+	tradeAt(ob, "103")
+
+	if ob.StopAsks.Size() != 0 || len(ob.trailing) != 0 {
+		t.Fatal("expected the stop to be triggered at 103")
+	}
+
+	if len(publisher.Trades) != trades+2 || publisher.Trades[trades+1].SellOrder().ID != stop.ID || !publisher.Trades[trades+1].Price.Equal(d("101")) {
+		t.Errorf("expected the triggered stop to sell at 101, got %+v", publisher.Trades[trades:])
+	}
+}
+
+func TestTrailingBuyStop(t *testing.T) {
+	d := decimal.RequireFromString
+	ob, _ := newTestOrderBook(d("100"), OrderBookConfig{}, nil)
+
+	// the stop price it's placed with is closer to the price than the offset
+	stop := newTestStopOrder(pkg.SideBuy, "103", "110", "1")
+	ob.add(stop, trailing("5"))
+
+	if !stopPriceOf(t, ob, stop).Equal(d("103")) {
+		t.Fatalf("expected the stop to keep its stop price, got %s", stop.StopPrice)
+	}
+
+	tradeAt(ob, "96")
+	tradeAt(ob, "94")
+	if !stopPriceOf(t, ob, stop).Equal(d("99")) {
+		t.Fatalf("expected the stop to follow the lowest price, got %s", stop.StopPrice)
+	}
+
+	tradeAt(ob, "97")
+	if !stopPriceOf(t, ob, stop).Equal(d("99")) {
+		t.Fatalf("expected the stop to stay at 99 after the reversal, got %s", stop.StopPrice)
+	}
+
+	tradeAt(ob, "99")
+	if ob.StopBids.Size() != 0 || !bookHas(ob, stop) {
+		t.Error("expected the stop to be triggered at 99 and to rest at 110")
+	}
+}
+
+func TestTrailingStopsKeepTheirPriority(t *testing.T) {
+	d := decimal.RequireFromString
+	ob, publisher := newTestOrderBook(d("100"), OrderBookConfig{}, nil)
+
+	first := newTestStopOrder(pkg.SideSell, "90", "90", "1")
+	ob.add(first, trailing("5"))
+	second := newTestStopOrder(pkg.SideSell, "96", "90", "1")
+	ob.add(second, trailing("5"))
+
+	tradeAt(ob, "110")
+	if !stopPriceOf(t, ob, first).Equal(d("105")) || !stopPriceOf(t, ob, second).Equal(d("105")) {
+		t.Fatalf("expected both stops at 105, got %s and %s", first.StopPrice, second.StopPrice)
+	}
+
+	ob.Add(newTestOrder(pkg.SideBuy, pkg.TypeLimit, "100", "2"))
+	trades := len(publisher.Trades)
+	tradeAt(ob, "104")
+
+	if len(publisher.Trades) != trades+3 || publisher.Trades[trades+1].SellOrder().ID != first.ID || publisher.Trades[trades+2].SellOrder().ID != second.ID {
+		t.Errorf("expected the stop placed first to be matched first, got %+v", publisher.Trades[trades:])
+	}
+}
+
+func TestCancelTrailingStop(t *testing.T) {
+	ob, _ := newTestOrderBook(decimal.NewFromInt(100), OrderBookConfig{}, nil)
+
+	stop := newTestOrder(pkg.SideSell, pkg.TypeMarket, "0", "1")
+	// the key the order is cancelled with is the one it was placed with
+	placed_key := stop.Key()
+	ob.add(stop, trailing("5"))
+
+	tradeAt(ob, "110")
+
+	if !ob.removeOrder(placed_key) || ob.StopAsks.Size() != 0 || len(ob.trailing) != 0 {
+		t.Error("expected the trailing stop to be cancelled with the key it was placed with")
+	}
+}
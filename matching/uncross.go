@@ -0,0 +1,165 @@
+package matching
+
+import (
+	"github.com/emirpasic/gods/trees/redblacktree"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/pkg"
+)
+
+// Uncross is the outcome of a call auction, the orders crossing each other trade Volume at the single Price.
+type Uncross struct {
+	Price  decimal.Decimal
+	Volume decimal.Decimal
+}
+
+// ClearingPrice returns the price of a call auction between bids and asks, each given in priority: market orders
+// first, then by price and time. It's the limit price executing the most volume, ties are broken by the smallest
+// imbalance left, then by the price closest to reference, then by the lowest price. found is false when the orders
+// don't cross, or when only market orders cross and there's no reference.
+func ClearingPrice(bids, asks []*pkg.Order, reference decimal.Decimal) (uncross Uncross, found bool) {
+	candidates := make([]decimal.Decimal, 0, len(bids)+len(asks))
+	for _, orders := range [][]*pkg.Order{bids, asks} {
+		for _, o := range orders {
+			if o.Type == pkg.TypeLimit {
+				candidates = append(candidates, o.Price)
+			}
+		}
+	}
+
+	if len(candidates) == 0 && reference.IsPositive() {
+		candidates = append(candidates, reference)
+	}
+
+	var imbalance decimal.Decimal
+	for _, price := range candidates {
+		demand := auctionVolume(bids, func(o *pkg.Order) bool { return !o.Price.LessThan(price) })
+		supply := auctionVolume(asks, func(o *pkg.Order) bool { return !o.Price.GreaterThan(price) })
+
+		volume := decimal.Min(demand, supply)
+		if !volume.IsPositive() {
+			continue
+		}
+
+		candidate_imbalance := demand.Sub(supply).Abs()
+		if !found || betterUncross(Uncross{Price: price, Volume: volume}, candidate_imbalance, uncross, imbalance, reference) {
+			uncross = Uncross{Price: price, Volume: volume}
+			imbalance = candidate_imbalance
+			found = true
+		}
+	}
+
+	return uncross, found
+}
+
+// betterUncross reports whether candidate is a better clearing price than best, see ClearingPrice.
+func betterUncross(candidate Uncross, candidate_imbalance decimal.Decimal, best Uncross, best_imbalance, reference decimal.Decimal) bool {
+	if !candidate.Volume.Equal(best.Volume) {
+		return candidate.Volume.GreaterThan(best.Volume)
+	}
+
+	if !candidate_imbalance.Equal(best_imbalance) {
+		return candidate_imbalance.LessThan(best_imbalance)
+	}
+
+	candidate_distance := candidate.Price.Sub(reference).Abs()
+	best_distance := best.Price.Sub(reference).Abs()
+	if !candidate_distance.Equal(best_distance) {
+		return candidate_distance.LessThan(best_distance)
+	}
+
+	return candidate.Price.LessThan(best.Price)
+}
+
+// auctionVolume is the unfilled quantity of the market orders and of the limit orders executable at a price.
+func auctionVolume(orders []*pkg.Order, executable func(o *pkg.Order) bool) decimal.Decimal {
+	volume := decimal.Zero
+	for _, o := range orders {
+		if o.Type == pkg.TypeMarket || executable(o) {
+			volume = volume.Add(o.UnfilledQuantity())
+		}
+	}
+
+	return volume
+}
+
+// priorityOrders returns the orders of a side of the book, the best price first and in time priority within a price.
+func priorityOrders(price_levels *redblacktree.Tree) []*pkg.Order {
+	orders := make([]*pkg.Order, 0)
+
+	it := price_levels.Iterator()
+	it.End()
+	for it.Prev() {
+		for _, value := range it.Value().(*PriceLevel).Orders.Values() {
+			orders = append(orders, value.(*pkg.Order))
+		}
+	}
+
+	return orders
+}
+
+// uncross trades the crossing orders of the book, with the market orders given, at the clearing price. It's called
+// with the orderMutex held, the stop orders the clearing price triggers are left in the pending orders queue.
+func (ob *OrderBook) uncross(market_orders []*pkg.Order) (uncross Uncross, found bool) {
+	ob.matchMutex.Lock()
+	defer ob.matchMutex.Unlock()
+
+	bids := make([]*pkg.Order, 0)
+	asks := make([]*pkg.Order, 0)
+	for _, o := range market_orders {
+		if o.Side == pkg.SideBuy {
+			bids = append(bids, o)
+		} else {
+			asks = append(asks, o)
+		}
+	}
+	bids = append(bids, priorityOrders(ob.Depth.Bids)...)
+	asks = append(asks, priorityOrders(ob.Depth.Asks)...)
+
+	uncross, found = ClearingPrice(bids, asks, ob.MarketPrice)
+	if !found {
+		return uncross, false
+	}
+
+	// the orders are in priority so the ones up to the volume are all executable at the clearing price
+	remaining := uncross.Volume
+	for i, j := 0, 0; remaining.IsPositive() && i < len(bids) && j < len(asks); {
+		bid, ask := bids[i], asks[j]
+		quantity := decimal.Min(bid.UnfilledQuantity(), ask.UnfilledQuantity(), remaining)
+
+		bid.Fill(quantity)
+		ask.Fill(quantity)
+		remaining = remaining.Sub(quantity)
+
+		for _, o := range []*pkg.Order{bid, ask} {
+			if o.Type != pkg.TypeLimit {
+				continue
+			}
+
+			if o.Filled() {
+				ob.Depth.Remove(o.Key())
+				ob.forget(o.ID)
+			} else {
+				ob.Depth.fill(o, quantity, ob.clock.Now())
+				ob.Depth.Add(o)
+			}
+
+			if o.IsFake() {
+				ob.updateQuantexOrder(o)
+			}
+		}
+
+		ob.publishTrade(bid, ask, uncross.Price, quantity)
+
+		if bid.Filled() {
+			i++
+		}
+		if ask.Filled() {
+			j++
+		}
+	}
+
+	ob.setMarketPrice(uncross.Price)
+
+	return uncross, true
+}
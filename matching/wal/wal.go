@@ -0,0 +1,378 @@
+// Package wal persists the books of the engine, so a restarted engine gets them back as they were instead of
+// rebuilding them from the database. The commands an engine accepted are appended to a write-ahead log of its
+// market with their sequence, and an image of the book is written every few commands, which compacts the log:
+// a book is restored from the last image then the commands logged after it.
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/matching"
+)
+
+// Entry is a line of a log, a command the engine accepted.
+type Entry struct {
+	Sequence int64                   `json:"sequence"`
+	At       time.Time               `json:"at"`
+	Command  *events.MatchingPayload `json:"command"`
+}
+
+// Snapshot is the image of a book once it processed the command of Sequence, zero before the first one.
+type Snapshot struct {
+	Sequence int64     `json:"sequence"`
+	TakenAt  time.Time `json:"taken_at"`
+	*matching.BookImage
+}
+
+// Log is the write-ahead log of the book of a market, and its last snapshot.
+type Log struct {
+	mutex  sync.Mutex
+	market string
+	dir    string
+	file   *os.File
+	writer *bufio.Writer
+	// every is the number of commands between two snapshots, zero only takes them when asked
+	every int
+	// next is the sequence of the next command appended
+	next int64
+	// logged is the number of commands appended since the last snapshot
+	logged int
+	// snapshot and tail are what the log was opened with, the book is restored from them
+	snapshot *Snapshot
+	tail     []*Entry
+	gate     *gatePublisher
+}
+
+// Open opens the log of market in dir, created when it's missing, with a snapshot every commands. A command
+// the engine was killed in the middle of writing is dropped, it wasn't accepted.
+func Open(dir string, market string, every int) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create the log directory: %w", err)
+	}
+
+	l := &Log{market: market, dir: dir, every: every, next: 1}
+
+	snapshot, err := l.readSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	l.snapshot = snapshot
+	if snapshot != nil {
+		l.next = snapshot.Sequence + 1
+	}
+
+	file, err := os.OpenFile(l.path("wal"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the log of %s: %w", market, err)
+	}
+
+	end, err := l.readTail(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if err := file.Truncate(end); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to truncate the log of %s: %w", market, err)
+	}
+
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to seek the log of %s: %w", market, err)
+	}
+
+	l.file = file
+	l.writer = bufio.NewWriter(file)
+	l.logged = len(l.tail)
+
+	return l, nil
+}
+
+func (l *Log) path(extension string) string {
+	return filepath.Join(l.dir, l.market+"."+extension)
+}
+
+func (l *Log) readSnapshot() (*Snapshot, error) {
+	content, err := os.ReadFile(l.path("snapshot"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the snapshot of %s: %w", l.market, err)
+	}
+
+	var snapshot *Snapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode the snapshot of %s: %w", l.market, err)
+	}
+
+	return snapshot, nil
+}
+
+// readTail reads the commands logged after the snapshot and returns the end of the last one complete.
+func (l *Log) readTail(file *os.File) (int64, error) {
+	reader := bufio.NewReader(file)
+	end := int64(0)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// a line without its end was being written when the engine was killed
+			return end, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read the log of %s: %w", l.market, err)
+		}
+
+		var entry *Entry
+		if err := json.Unmarshal(bytes.TrimSpace(line), &entry); err != nil || entry.Command == nil {
+			return 0, fmt.Errorf("failed to decode the log of %s at offset %d: %v", l.market, end, err)
+		}
+
+		end += int64(len(line))
+
+		// a log written before the snapshot was compacted still has the commands the snapshot holds
+		if entry.Sequence < l.next {
+			continue
+		}
+
+		if entry.Sequence != l.next {
+			return 0, fmt.Errorf("the log of %s skips from sequence %d to %d", l.market, l.next-1, entry.Sequence)
+		}
+
+		l.tail = append(l.tail, entry)
+		l.next++
+	}
+}
+
+// Empty reports whether the log has nothing to restore a book from.
+func (l *Log) Empty() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.snapshot == nil && len(l.tail) == 0
+}
+
+// Next returns the sequence the next command appended gets.
+func (l *Log) Next() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.next
+}
+
+// Publisher returns the publisher of the book of the log delivering to next, it's muted while Restore replays
+// the commands, their output was published before the engine was killed.
+func (l *Log) Publisher(next matching.Publisher) matching.Publisher {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.gate = &gatePublisher{next: next}
+	return l.gate
+}
+
+// Restore rebuilds the book of engine, an empty one, from the snapshot and the commands logged after it. It
+// returns the number of commands replayed.
+func (l *Log) Restore(engine *matching.Engine) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.gate != nil {
+		atomic.StoreInt32(&l.gate.muted, 1)
+		defer atomic.StoreInt32(&l.gate.muted, 0)
+	}
+
+	if l.snapshot != nil && l.snapshot.BookImage != nil {
+		engine.Restore(l.snapshot.BookImage)
+	}
+
+	for _, entry := range l.tail {
+		engine.Apply(entry.Command)
+	}
+
+	return len(l.tail)
+}
+
+// Append logs a command of the book before the engine processes it and returns its sequence.
+func (l *Log) Append(command *events.MatchingPayload, at time.Time) (int64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	line, err := json.Marshal(&Entry{Sequence: l.next, At: at, Command: command})
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := l.writer.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+
+	// the command must be in the log before the engine acts on it, a killed engine keeps what's flushed
+	if err := l.writer.Flush(); err != nil {
+		return 0, err
+	}
+
+	sequence := l.next
+	l.next++
+	l.logged++
+
+	return sequence, nil
+}
+
+// SnapshotDue reports whether enough commands were logged since the last snapshot to take the next one.
+func (l *Log) SnapshotDue() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.every > 0 && l.logged >= l.every
+}
+
+// Snapshot writes the image of the book of engine, which processed every command logged, and compacts the log.
+// The snapshot replaces the previous one at once, an engine killed before the log is emptied skips the commands
+// the snapshot holds.
+func (l *Log) Snapshot(engine *matching.Engine, at time.Time) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	snapshot := &Snapshot{Sequence: l.next - 1, TakenAt: at, BookImage: engine.Image()}
+
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	temp := l.path("snapshot.tmp")
+	if err := writeSynced(temp, content); err != nil {
+		return fmt.Errorf("failed to write the snapshot of %s: %w", l.market, err)
+	}
+
+	if err := os.Rename(temp, l.path("snapshot")); err != nil {
+		return fmt.Errorf("failed to replace the snapshot of %s: %w", l.market, err)
+	}
+
+	if err := l.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to compact the log of %s: %w", l.market, err)
+	}
+
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to compact the log of %s: %w", l.market, err)
+	}
+
+	l.writer.Reset(l.file)
+	l.snapshot = snapshot
+	l.tail = nil
+	l.logged = 0
+
+	return nil
+}
+
+func writeSynced(path string, content []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Close flushes the log and closes it.
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.writer.Flush(); err != nil {
+		l.file.Close()
+		return err
+	}
+
+	return l.file.Close()
+}
+
+// gatePublisher delivers the output of a book to next unless it's muted, the timers of the book publish too.
+type gatePublisher struct {
+	next  matching.Publisher
+	muted int32
+}
+
+func (p *gatePublisher) open() bool {
+	return atomic.LoadInt32(&p.muted) == 0
+}
+
+func (p *gatePublisher) PublishTrade(trade *pkg.Trade, stamp matching.TradeStamp) {
+	if p.open() {
+		p.next.PublishTrade(trade, stamp)
+	}
+}
+
+func (p *gatePublisher) PublishCancel(key *pkg.OrderKey, reason matching.CancelReason) {
+	if p.open() {
+		p.next.PublishCancel(key, reason)
+	}
+}
+
+func (p *gatePublisher) PublishCancelOutcome(key *pkg.OrderKey, outcome matching.CancelOutcome, command_id string) {
+	if p.open() {
+		p.next.PublishCancelOutcome(key, outcome, command_id)
+	}
+}
+
+func (p *gatePublisher) PublishReplace(replaced_key *pkg.OrderKey, order *pkg.Order, accepted bool) {
+	if p.open() {
+		p.next.PublishReplace(replaced_key, order, accepted)
+	}
+}
+
+func (p *gatePublisher) PublishDecrement(key *pkg.OrderKey, quantity decimal.Decimal, reason matching.CancelReason) {
+	if p.open() {
+		p.next.PublishDecrement(key, quantity, reason)
+	}
+}
+
+func (p *gatePublisher) PublishReprice(key *pkg.OrderKey, reason matching.CancelReason) {
+	if p.open() {
+		p.next.PublishReprice(key, reason)
+	}
+}
+
+func (p *gatePublisher) PublishAmend(key *pkg.OrderKey, quantity decimal.Decimal, accepted bool) {
+	if p.open() {
+		p.next.PublishAmend(key, quantity, accepted)
+	}
+}
+
+func (p *gatePublisher) PublishBatch(batch_id string, entries []*events.BatchEntry) {
+	if p.open() {
+		p.next.PublishBatch(batch_id, entries)
+	}
+}
+
+func (p *gatePublisher) PublishCircuitBreak(circuit_break *matching.CircuitBreak) {
+	if p.open() {
+		p.next.PublishCircuitBreak(circuit_break)
+	}
+}
@@ -0,0 +1,204 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/matching"
+	"github.com/zsmartex/pkg"
+)
+
+var (
+	testSymbol = pkg.Symbol{BaseCurrency: "BTC", QuoteCurrency: "USDT"}
+	testStart  = time.Date(2022, 5, 10, 10, 0, 0, 0, time.UTC)
+)
+
+type tradesPublisher struct {
+	trades []*pkg.Trade
+}
+
+func (p *tradesPublisher) PublishTrade(trade *pkg.Trade, stamp matching.TradeStamp) {
+	recorded := *trade
+	p.trades = append(p.trades, &recorded)
+}
+
+func (p *tradesPublisher) PublishCancel(key *pkg.OrderKey, reason matching.CancelReason) {}
+
+func (p *tradesPublisher) PublishCancelOutcome(key *pkg.OrderKey, outcome matching.CancelOutcome, command_id string) {
+}
+
+func (p *tradesPublisher) PublishReplace(replaced_key *pkg.OrderKey, order *pkg.Order, accepted bool) {
+}
+
+func (p *tradesPublisher) PublishDecrement(key *pkg.OrderKey, quantity decimal.Decimal, reason matching.CancelReason) {
+}
+
+func (p *tradesPublisher) PublishReprice(key *pkg.OrderKey, reason matching.CancelReason) {}
+
+func (p *tradesPublisher) PublishAmend(key *pkg.OrderKey, quantity decimal.Decimal, accepted bool) {}
+
+func (p *tradesPublisher) PublishBatch(batch_id string, entries []*events.BatchEntry) {}
+
+func (p *tradesPublisher) PublishCircuitBreak(circuit_break *matching.CircuitBreak) {}
+
+func testOrder(id int64, side pkg.OrderSide, price, quantity string) *pkg.Order {
+	return &pkg.Order{
+		ID:        id,
+		UUID:      uuid.New(),
+		Symbol:    testSymbol,
+		MemberID:  id,
+		Side:      side,
+		Type:      pkg.TypeLimit,
+		Price:     decimal.RequireFromString(price),
+		Quantity:  decimal.RequireFromString(quantity),
+		CreatedAt: testStart.Add(time.Duration(id) * time.Second),
+	}
+}
+
+func submit(o *pkg.Order, options *events.OrderOptions) *events.MatchingPayload {
+	return &events.MatchingPayload{
+		MatchingPayloadMessage: pkg.MatchingPayloadMessage{Action: pkg.ActionSubmit, Order: o},
+		Options:                options,
+	}
+}
+
+func cancel(o *pkg.Order) *events.MatchingPayload {
+	return &events.MatchingPayload{MatchingPayloadMessage: pkg.MatchingPayloadMessage{Action: pkg.ActionCancelWithKey, Key: o.Key()}}
+}
+
+// newEngine returns the engine of a market logged to dir, restored from the log.
+func newEngine(t *testing.T, dir string) (*matching.Engine, *Log, *tradesPublisher) {
+	log, err := Open(dir, "btcusdt", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trades := &tradesPublisher{}
+	engine := matching.NewDetachedEngine(testSymbol, decimal.NewFromInt(100), matching.OrderBookConfig{
+		Clock:     clock.NewFake(testStart.Add(time.Hour)),
+		Publisher: log.Publisher(trades),
+	})
+	log.Restore(engine)
+
+	return engine, log, trades
+}
+
+// process logs the command then processes it, as the engine server does.
+func process(t *testing.T, engine *matching.Engine, log *Log, command *events.MatchingPayload) {
+	if _, err := log.Append(command, testStart); err != nil {
+		t.Fatal(err)
+	}
+
+	engine.Apply(command)
+
+	if log.SnapshotDue() {
+		if err := log.Snapshot(engine, testStart); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	dir := t.TempDir()
+	engine, log, trades := newEngine(t, dir)
+
+	display := decimal.NewFromInt(2)
+	stop := testOrder(5, pkg.SideSell, "95", "1")
+	stop.StopPrice = decimal.NewFromInt(97)
+	resting := testOrder(2, pkg.SideBuy, "99", "3")
+
+	commands := []*events.MatchingPayload{
+		submit(testOrder(1, pkg.SideSell, "101", "5"), nil),
+		submit(resting, nil),
+		submit(testOrder(3, pkg.SideSell, "102", "10"), &events.OrderOptions{DisplayQuantity: &display}),
+		submit(testOrder(4, pkg.SideBuy, "98", "4"), nil),
+		// a snapshot is taken every 4 commands, the iceberg is filled on both sides of them
+		submit(stop, nil),
+		submit(testOrder(6, pkg.SideBuy, "102", "8"), nil),
+		cancel(resting),
+		submit(testOrder(7, pkg.SideBuy, "97.5", "2"), nil),
+		submit(testOrder(8, pkg.SideBuy, "102", "1.5"), nil),
+		submit(testOrder(9, pkg.SideSell, "99.5", "1"), nil),
+	}
+
+	for _, command := range commands {
+		process(t, engine, log, command)
+	}
+
+	if len(trades.trades) == 0 {
+		t.Fatal("expected the scenario to trade")
+	}
+
+	// the engine is killed in the middle of logging a command
+	file, err := os.OpenFile(filepath.Join(dir, "btcusdt.wal"), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString(`{"sequence":11,"command":{"act`); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	restored, restored_log, restored_trades := newEngine(t, dir)
+
+	if len(restored_trades.trades) > 0 {
+		t.Errorf("expected the restore to publish nothing, got %d trades", len(restored_trades.trades))
+	}
+
+	if restored_log.Next() != log.Next() || restored_log.Next() != int64(len(commands)+1) {
+		t.Errorf("expected the next sequence %d, got %d", log.Next(), restored_log.Next())
+	}
+
+	want, got := engine.OrderBook.Depth.Snapshot(), restored.OrderBook.Depth.Snapshot()
+	if len(want.Asks) == 0 || len(want.Bids) == 0 {
+		t.Fatalf("expected orders resting on both sides, got %+v", want)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("expected the restored depth %+v, got %+v", want, got)
+	}
+
+	if !restored.OrderBook.MarketPrice.Equal(engine.OrderBook.MarketPrice) {
+		t.Errorf("expected the market price %s, got %s", engine.OrderBook.MarketPrice, restored.OrderBook.MarketPrice)
+	}
+
+	// both books match the next command the same way, the iceberg shows the slice it showed
+	next := submit(testOrder(10, pkg.SideBuy, "102", "6"), nil)
+	process(t, engine, log, next)
+	process(t, restored, restored_log, submit(testOrder(10, pkg.SideBuy, "102", "6"), nil))
+
+	want_trades, got_trades := trades.trades[len(trades.trades)-len(restored_trades.trades):], restored_trades.trades
+	if len(got_trades) == 0 {
+		t.Fatal("expected the restored book to trade")
+	}
+	for i := range want_trades {
+		if want_trades[i].MakerOrder.ID != got_trades[i].MakerOrder.ID || !want_trades[i].Quantity.Equal(got_trades[i].Quantity) || !want_trades[i].Price.Equal(got_trades[i].Price) {
+			t.Errorf("expected trade %d %+v, got %+v", i, want_trades[i], got_trades[i])
+		}
+	}
+
+	if !reflect.DeepEqual(engine.OrderBook.Depth.Snapshot(), restored.OrderBook.Depth.Snapshot()) {
+		t.Error("expected the books to stay the same")
+	}
+}
+
+func TestOpenRejectsGaps(t *testing.T) {
+	dir := t.TempDir()
+	content := `{"sequence":1,"at":"2022-05-10T10:00:00Z","command":{"action":"cancel_with_key"}}
+{"sequence":3,"at":"2022-05-10T10:00:00Z","command":{"action":"cancel_with_key"}}
+`
+	if err := os.WriteFile(filepath.Join(dir, "btcusdt.wal"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dir, "btcusdt", 0); err == nil {
+		t.Error("expected a log skipping a sequence to be refused")
+	}
+}
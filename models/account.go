@@ -6,6 +6,8 @@ import (
 
 	"github.com/shopspring/decimal"
 	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/events"
 	"github.com/zsmartex/finex/types"
 	"gorm.io/gorm"
 )
@@ -50,6 +52,26 @@ func (a *Account) TriggerEvent() {
 	member := a.Member()
 
 	config.RangoClient.EnqueueEvent("private", member.UID, "balance", a.ToJSON())
+	events.PublishPrivate(member.UID, entities.PrivateStreamBalance, entities.PrivateBalanceMessage{
+		Event: entities.PrivateStreamBalance,
+		Balance: entities.PrivateBalance{
+			Currency: a.CurrencyID,
+			Balance:  a.Balance,
+			Locked:   a.Locked,
+		},
+	})
+}
+
+// updateFunds writes the balances of funds to the account, rounded to the scale of the columns.
+func (a *Account) updateFunds(tx *gorm.DB, funds Account) error {
+	funds, err := normalizeAccountFunds(funds)
+	if err != nil {
+		return err
+	}
+
+	tx = tx.Model(a).Where("currency_id = ? AND member_id = ?", a.CurrencyID, a.MemberID).Updates(funds)
+	a.TriggerEvent()
+	return tx.Error
 }
 
 func (a *Account) PlusFunds(tx *gorm.DB, amount decimal.Decimal) error {
@@ -57,9 +79,7 @@ func (a *Account) PlusFunds(tx *gorm.DB, amount decimal.Decimal) error {
 		return fmt.Errorf("cannot add funds (member id: %d, currency id: %s, amount: %s, balance: %s)", a.MemberID, a.CurrencyID, amount.String(), a.Balance.String())
 	}
 
-	tx = tx.Model(a).Where("currency_id = ? AND member_id = ?", a.CurrencyID, a.MemberID).Updates(Account{Balance: a.Balance.Add(amount)})
-	a.TriggerEvent()
-	return tx.Error
+	return a.updateFunds(tx, Account{Balance: a.Balance.Add(amount)})
 }
 
 func (a *Account) PlusLockedFunds(tx *gorm.DB, amount decimal.Decimal) error {
@@ -67,9 +87,7 @@ func (a *Account) PlusLockedFunds(tx *gorm.DB, amount decimal.Decimal) error {
 		return fmt.Errorf("cannot add funds (member id: %d, currency id: %s, amount: %s, locked: %s)", a.MemberID, a.CurrencyID, amount.String(), a.Locked.String())
 	}
 
-	tx = tx.Model(a).Where("currency_id = ? AND member_id = ?", a.CurrencyID, a.MemberID).Updates(Account{Locked: a.Locked.Add(amount)})
-	a.TriggerEvent()
-	return tx.Error
+	return a.updateFunds(tx, Account{Locked: a.Locked.Add(amount)})
 }
 
 func (a *Account) SubFunds(tx *gorm.DB, amount decimal.Decimal) error {
@@ -77,9 +95,7 @@ func (a *Account) SubFunds(tx *gorm.DB, amount decimal.Decimal) error {
 		return fmt.Errorf("cannot subtract funds (member id: %d, currency id: %s, amount: %s, balance: %s)", a.MemberID, a.CurrencyID, amount.String(), a.Balance.String())
 	}
 
-	tx = tx.Model(a).Where("currency_id = ? AND member_id = ?", a.CurrencyID, a.MemberID).Updates(Account{Balance: a.Balance.Sub(amount)})
-	a.TriggerEvent()
-	return tx.Error
+	return a.updateFunds(tx, Account{Balance: a.Balance.Sub(amount)})
 }
 
 func (a *Account) LockFunds(tx *gorm.DB, amount decimal.Decimal) error {
@@ -87,9 +103,7 @@ func (a *Account) LockFunds(tx *gorm.DB, amount decimal.Decimal) error {
 		return fmt.Errorf("cannot lock funds (member id: %d, currency id: %s, amount: %s, balance: %s, locked: %s)", a.MemberID, a.CurrencyID, amount.String(), a.Balance.String(), a.Locked.String())
 	}
 
-	tx = tx.Model(a).Where("currency_id = ? AND member_id = ?", a.CurrencyID, a.MemberID).Updates(Account{Balance: a.Balance.Sub(amount), Locked: a.Locked.Add(amount)})
-	a.TriggerEvent()
-	return tx.Error
+	return a.updateFunds(tx, Account{Balance: a.Balance.Sub(amount), Locked: a.Locked.Add(amount)})
 }
 
 func (a *Account) UnlockFunds(tx *gorm.DB, amount decimal.Decimal) error {
@@ -97,9 +111,7 @@ func (a *Account) UnlockFunds(tx *gorm.DB, amount decimal.Decimal) error {
 		return fmt.Errorf("cannot unlock funds (member id: %d, currency id: %s, amount: %s, balance: %s, locked: %s)", a.MemberID, a.CurrencyID, amount.String(), a.Balance.String(), a.Locked.String())
 	}
 
-	tx = tx.Model(a).Where("currency_id = ? AND member_id = ?", a.CurrencyID, a.MemberID).Updates(Account{Balance: a.Balance.Add(amount), Locked: a.Locked.Sub(amount)})
-	a.TriggerEvent()
-	return tx.Error
+	return a.updateFunds(tx, Account{Balance: a.Balance.Add(amount), Locked: a.Locked.Sub(amount)})
 }
 
 func (a *Account) UnlockAndSubFunds(tx *gorm.DB, amount decimal.Decimal) error {
@@ -107,9 +119,7 @@ func (a *Account) UnlockAndSubFunds(tx *gorm.DB, amount decimal.Decimal) error {
 		return fmt.Errorf("cannot unlock and sub funds (member id: %d, currency id: %s, amount: %s, balance: %s, locked: %s)", a.MemberID, a.CurrencyID, amount.String(), a.Balance.String(), a.Locked.String())
 	}
 
-	tx = tx.Model(a).Where("currency_id = ? AND member_id = ?", a.CurrencyID, a.MemberID).Updates(Account{Locked: a.Locked.Sub(amount)})
-	a.TriggerEvent()
-	return tx.Error
+	return a.updateFunds(tx, Account{Locked: a.Locked.Sub(amount)})
 }
 
 func (a *Account) Amount() decimal.Decimal {
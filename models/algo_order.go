@@ -0,0 +1,248 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/decimalutil"
+	"github.com/zsmartex/finex/types"
+	"github.com/zsmartex/pkg"
+)
+
+const (
+	// DefaultAlgoSliceInterval is the time between two slices when algo_orders.slice_interval isn't set.
+	DefaultAlgoSliceInterval = 30 * time.Second
+	// DefaultAlgoMaxDuration is the longest TWAP when algo_orders.max_duration isn't set.
+	DefaultAlgoMaxDuration = 24 * time.Hour
+	// DefaultMaxOpenOrders is the number of open orders of a member when algo_orders.max_open_orders isn't set.
+	DefaultMaxOpenOrders = 100
+)
+
+type AlgoOrderState string
+
+var (
+	AlgoOrderStateActive     AlgoOrderState = "active"
+	AlgoOrderStateDone       AlgoOrderState = "done"
+	AlgoOrderStateCancelling AlgoOrderState = "cancelling"
+	AlgoOrderStateCancelled  AlgoOrderState = "cancelled"
+)
+
+var (
+	ErrAlgoOrderDuration      = errors.New("algo_order.invalid_duration")
+	ErrAlgoOrderParticipation = errors.New("algo_order.invalid_max_participation")
+	ErrAlgoOrderNotActive     = errors.New("algo_order.not_active")
+)
+
+// AlgoOrder is a TWAP parent order, the scheduler feeds its quantity to the market in child orders
+// evenly spread between StartAt and EndAt. Its progress is derived from its children so the scheduler
+// resumes from the database after a restart.
+type AlgoOrder struct {
+	ID       int64           `json:"id" gorm:"primaryKey"`
+	UUID     uuid.UUID       `json:"uuid" gorm:"default:gen_random_uuid()"`
+	MemberID int64           `json:"member_id"`
+	MarketID string          `json:"market_id"`
+	Side     types.OrderSide `json:"side"`
+	// OrdType is the type of the child orders, limit children are placed at Price
+	OrdType  types.OrderType     `json:"ord_type"`
+	Price    decimal.NullDecimal `json:"price"`
+	Quantity decimal.Decimal     `json:"quantity"`
+	// MaxParticipation caps a slice to this share of the volume the market traded since the previous slice, zero disables it
+	MaxParticipation decimal.Decimal `json:"max_participation" gorm:"default:0"`
+	// Executed is the quantity filled by the children, refreshed by the scheduler
+	Executed    decimal.Decimal `json:"executed" gorm:"default:0"`
+	Slices      int64           `json:"slices" gorm:"default:0"`
+	State       AlgoOrderState  `json:"state" gorm:"default:active"`
+	LastError   sql.NullString  `json:"last_error"`
+	StartAt     time.Time       `json:"start_at"`
+	EndAt       time.Time       `json:"end_at"`
+	LastSliceAt sql.NullTime    `json:"last_slice_at"`
+	NextSliceAt time.Time       `json:"next_slice_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+func AlgoSliceInterval() time.Duration {
+	if config.AlgoOrders != nil && config.AlgoOrders.SliceInterval > 0 {
+		return config.AlgoOrders.SliceInterval
+	}
+
+	return DefaultAlgoSliceInterval
+}
+
+func algoMaxDuration() time.Duration {
+	if config.AlgoOrders != nil && config.AlgoOrders.MaxDuration > 0 {
+		return config.AlgoOrders.MaxDuration
+	}
+
+	return DefaultAlgoMaxDuration
+}
+
+// MaxOpenOrders is the number of open orders a member can have, the scheduler waits for orders to close
+// before placing a slice of a member at the limit.
+func MaxOpenOrders() int64 {
+	if config.AlgoOrders != nil && config.AlgoOrders.MaxOpenOrders > 0 {
+		return int64(config.AlgoOrders.MaxOpenOrders)
+	}
+
+	return DefaultMaxOpenOrders
+}
+
+// NewTWAPOrder builds the TWAP parent order of a member starting at now, its first slice is placed one interval later.
+func NewTWAPOrder(member *Member, market *Market, side types.OrderSide, ord_type types.OrderType, price decimal.NullDecimal, quantity decimal.Decimal, duration time.Duration, max_participation decimal.Decimal, now time.Time) (*AlgoOrder, error) {
+	interval := AlgoSliceInterval()
+	if duration < interval || duration > algoMaxDuration() {
+		return nil, ErrAlgoOrderDuration
+	}
+
+	if max_participation.IsNegative() || max_participation.GreaterThan(decimal.NewFromInt(1)) {
+		return nil, ErrAlgoOrderParticipation
+	}
+
+	return &AlgoOrder{
+		MemberID:         member.ID,
+		MarketID:         market.Symbol,
+		Side:             side,
+		OrdType:          ord_type,
+		Price:            price,
+		Quantity:         quantity,
+		MaxParticipation: max_participation,
+		Executed:         decimal.Zero,
+		State:            AlgoOrderStateActive,
+		StartAt:          now,
+		EndAt:            now.Add(duration),
+		NextSliceAt:      now.Add(interval),
+	}, nil
+}
+
+// TWAPSlice returns the quantity of the slice placed at now: what the schedule expects to be placed by now
+// minus the committed quantity (executed or still open), capped by the participation in the market volume
+// traded since the previous slice and truncated to the amount precision.
+func TWAPSlice(quantity, committed decimal.Decimal, start, end, now time.Time, max_participation, market_volume decimal.Decimal, precision int32) decimal.Decimal {
+	target := quantity
+	if now.Before(end) {
+		elapsed := decimal.NewFromInt(int64(now.Sub(start)))
+		target = quantity.Mul(elapsed).Div(decimal.NewFromInt(int64(end.Sub(start))))
+	}
+
+	slice := target.Sub(committed)
+	if max_participation.IsPositive() {
+		slice = decimal.Min(slice, market_volume.Mul(max_participation))
+	}
+
+	slice = decimalutil.Round(slice, precision, decimalutil.Down)
+	if !slice.IsPositive() {
+		return decimal.Zero
+	}
+
+	return slice
+}
+
+// Pause pushes the schedule back to the next slice after now, the slices skipped while the market is halted aren't caught up.
+func (a *AlgoOrder) Pause(now time.Time, interval time.Duration) {
+	shift := now.Add(interval).Sub(a.NextSliceAt)
+
+	a.StartAt = a.StartAt.Add(shift)
+	a.EndAt = a.EndAt.Add(shift)
+	a.NextSliceAt = a.NextSliceAt.Add(shift)
+}
+
+// Finished reports whether no slice is left to place, one last slice is placed after EndAt for the remainder.
+func (a *AlgoOrder) Finished(now time.Time, interval time.Duration) bool {
+	return a.Executed.GreaterThanOrEqual(a.Quantity) || !now.Before(a.EndAt.Add(interval))
+}
+
+// UUIDRef is the reference the children of the algo order carry.
+func (a *AlgoOrder) UUIDRef() uuid.NullUUID {
+	return uuid.NullUUID{UUID: a.UUID, Valid: true}
+}
+
+// Children returns the child orders of the algo order.
+func (a *AlgoOrder) Children() []*Order {
+	var orders []*Order
+	config.DataBase.Order("id asc").Find(&orders, "algo_order_uuid = ?", a.UUID)
+
+	return orders
+}
+
+// AlgoProgress returns the quantity filled by the children and the orders still open with their remaining quantity.
+func AlgoProgress(children []*Order) (executed, open decimal.Decimal, open_orders []*Order) {
+	executed = decimal.Zero
+	open = decimal.Zero
+	open_orders = make([]*Order, 0)
+
+	for _, child := range children {
+		executed = executed.Add(child.OriginVolume.Sub(child.Volume))
+
+		if child.State == StatePending || child.State == StateWait {
+			open = open.Add(child.Volume)
+			open_orders = append(open_orders, child)
+		}
+	}
+
+	return
+}
+
+// CancelChildren asks the engine to cancel the open children, children not yet in the book are cancelled on a later call.
+func CancelChildren(open_orders []*Order) {
+	for _, child := range open_orders {
+		if child.State != StateWait {
+			continue
+		}
+
+		config.Bus.Publish("matching", child.MarketID, map[string]interface{}{
+			"action": pkg.ActionCancel,
+			"order":  child.ToMatchingAttributes(),
+		})
+	}
+}
+
+// Cancel stops the algo order, the scheduler cancels its open children and marks it cancelled once they're closed.
+func (a *AlgoOrder) Cancel() error {
+	if a.State != AlgoOrderStateActive {
+		return ErrAlgoOrderNotActive
+	}
+
+	a.State = AlgoOrderStateCancelling
+	if err := config.DataBase.Model(a).Update("state", a.State).Error; err != nil {
+		return err
+	}
+
+	_, _, open_orders := AlgoProgress(a.Children())
+	CancelChildren(open_orders)
+
+	return nil
+}
+
+// OpenOrdersCount counts the orders of the member which aren't closed.
+func (m *Member) OpenOrdersCount() int64 {
+	var count int64
+	config.DataBase.Model(&Order{}).Where("member_id = ? AND state IN ?", m.ID, []OrderState{StatePending, StateWait}).Count(&count)
+
+	return count
+}
+
+func (a *AlgoOrder) ToJSON() entities.AlgoOrderEntity {
+	return entities.AlgoOrderEntity{
+		UUID:             a.UUID,
+		Market:           a.MarketID,
+		Side:             a.Side,
+		OrdType:          a.OrdType,
+		Price:            a.Price,
+		Quantity:         a.Quantity,
+		ExecutedQuantity: a.Executed,
+		MaxParticipation: a.MaxParticipation,
+		Slices:           a.Slices,
+		State:            string(a.State),
+		LastError:        a.LastError.String,
+		StartAt:          a.StartAt,
+		EndAt:            a.EndAt,
+		CreatedAt:        a.CreatedAt,
+		UpdatedAt:        a.UpdatedAt,
+	}
+}
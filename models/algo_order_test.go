@@ -0,0 +1,109 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/types"
+)
+
+func TestTWAPSlice(t *testing.T) {
+	d := decimal.RequireFromString
+	start := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(10 * time.Minute)
+
+	tests := []struct {
+		name          string
+		committed     string
+		now           time.Time
+		participation string
+		market_volume string
+		want          string
+	}{
+		{"on schedule", "0", start.Add(time.Minute), "0", "0", "1"},
+		{"catches up unfilled slices", "1", start.Add(3 * time.Minute), "0", "0", "2"},
+		{"ahead of schedule", "4", start.Add(3 * time.Minute), "0", "0", "0"},
+		{"remainder after the end", "7.5", end.Add(time.Second), "0", "0", "2.5"},
+		{"capped by participation", "0", start.Add(5 * time.Minute), "0.1", "20", "2"},
+		{"no market volume", "0", start.Add(5 * time.Minute), "0.1", "0", "0"},
+		{"truncated to the precision", "0", start.Add(time.Minute + 30*time.Second), "0", "0", "1.5"},
+		{"truncated down", "0", start.Add(time.Minute + 7*time.Second), "0", "0", "1.11"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TWAPSlice(d("10"), d(tt.committed), start, end, tt.now, d(tt.participation), d(tt.market_volume), 2)
+			if !got.Equal(d(tt.want)) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlgoOrderSchedule(t *testing.T) {
+	now := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	interval := DefaultAlgoSliceInterval
+
+	algo_order, err := NewTWAPOrder(&Member{ID: 1}, &Market{Symbol: "btcusdt"}, types.SideBuy, types.TypeLimit, decimal.NewNullDecimal(decimal.NewFromInt(100)), decimal.NewFromInt(10), 10*time.Minute, decimal.Zero, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !algo_order.NextSliceAt.Equal(now.Add(interval)) || !algo_order.EndAt.Equal(now.Add(10*time.Minute)) {
+		t.Fatalf("got next slice %v end %v", algo_order.NextSliceAt, algo_order.EndAt)
+	}
+
+	// the market is halted two minutes after the first slice was due, the schedule moves to the next slice
+	halted_at := algo_order.NextSliceAt.Add(2 * time.Minute)
+	algo_order.Pause(halted_at, interval)
+
+	shift := 2*time.Minute + interval
+	if !algo_order.NextSliceAt.Equal(halted_at.Add(interval)) || !algo_order.EndAt.Equal(now.Add(10*time.Minute+shift)) || !algo_order.StartAt.Equal(now.Add(shift)) {
+		t.Errorf("paused schedule: start %v next slice %v end %v", algo_order.StartAt, algo_order.NextSliceAt, algo_order.EndAt)
+	}
+
+	if algo_order.Finished(algo_order.EndAt, interval) {
+		t.Error("finished before the last slice")
+	}
+
+	if !algo_order.Finished(algo_order.EndAt.Add(interval), interval) {
+		t.Error("not finished after the last slice")
+	}
+
+	algo_order.Executed = decimal.NewFromInt(10)
+	if !algo_order.Finished(now, interval) {
+		t.Error("not finished once filled")
+	}
+}
+
+func TestNewTWAPOrderErrors(t *testing.T) {
+	now := time.Now()
+	member := &Member{ID: 1}
+	market := &Market{Symbol: "btcusdt"}
+
+	if _, err := NewTWAPOrder(member, market, types.SideBuy, types.TypeMarket, decimal.NullDecimal{}, decimal.NewFromInt(1), time.Second, decimal.Zero, now); err != ErrAlgoOrderDuration {
+		t.Errorf("got %v, want %v", err, ErrAlgoOrderDuration)
+	}
+
+	if _, err := NewTWAPOrder(member, market, types.SideBuy, types.TypeMarket, decimal.NullDecimal{}, decimal.NewFromInt(1), time.Hour, decimal.NewFromFloat(1.5), now); err != ErrAlgoOrderParticipation {
+		t.Errorf("got %v, want %v", err, ErrAlgoOrderParticipation)
+	}
+}
+
+func TestAlgoProgress(t *testing.T) {
+	d := decimal.RequireFromString
+
+	executed, open, open_orders := AlgoProgress([]*Order{
+		{ID: 1, State: StateDone, OriginVolume: d("1"), Volume: d("0")},
+		{ID: 2, State: StateCancel, OriginVolume: d("1"), Volume: d("0.4")},
+		{ID: 3, State: StateWait, OriginVolume: d("1"), Volume: d("0.7")},
+		{ID: 4, State: StatePending, OriginVolume: d("1"), Volume: d("1")},
+		{ID: 5, State: StateReject, OriginVolume: d("1"), Volume: d("1")},
+	})
+
+	if !executed.Equal(d("1.9")) || !open.Equal(d("1.7")) || len(open_orders) != 2 {
+		t.Errorf("got executed %s open %s (%d orders)", executed, open, len(open_orders))
+	}
+}
@@ -0,0 +1,243 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+type ArchiveRecordType string
+
+var (
+	ArchiveRecordIEO    ArchiveRecordType = "ieo"
+	ArchiveRecordMarket ArchiveRecordType = "market"
+)
+
+var (
+	ErrArchiveNotTerminal = errors.New("archive.not_terminal")
+	ErrArchiveNotArchived = errors.New("archive.not_archived")
+)
+
+// Archive is the payload of an IEO or a market as it was when it was archived, later schema changes
+// leave the snapshot as it was.
+type Archive struct {
+	ID         int64             `json:"id" gorm:"primaryKey"`
+	RecordType ArchiveRecordType `json:"record_type"`
+	// RecordID is the id of an IEO or the symbol of a market
+	RecordID string `json:"record_id"`
+	// State is the state the record was archived from, it's restored to it
+	State   types.MarketState `json:"state"`
+	Payload string            `json:"payload"`
+	// ArchivedBy is the uid of the admin who archived the record, empty when the archival job did
+	ArchivedBy string       `json:"archived_by"`
+	RestoredBy string       `json:"restored_by"`
+	RestoredAt sql.NullTime `json:"restored_at"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
+// Archivable reports whether the IEO reached a terminal state, it ended or was disabled.
+func (m *IEO) Archivable(now time.Time) bool {
+	switch m.State {
+	case types.MarketStateArchived:
+		return false
+	case types.MarketStateDisabled:
+		return true
+	default:
+		return now.After(m.EndTime)
+	}
+}
+
+// Archivable reports whether the market was disabled, the terminal state of a market.
+func (m *Market) Archivable() bool {
+	return m.State == string(types.MarketStateDisabled)
+}
+
+// ArchivedStates returns the states listings include, archived records are only listed when they're asked for.
+func ArchivedStates(states []types.MarketState, include_archived bool) []types.MarketState {
+	if include_archived {
+		return append(states, types.MarketStateArchived)
+	}
+
+	return states
+}
+
+// ArchiveIEO snapshots the IEO and archives it, actor_uid is empty when the archival job archives it.
+func ArchiveIEO(tx *gorm.DB, ieo *IEO, actor_uid string, now time.Time) error {
+	if !ieo.Archivable(now) {
+		return ErrArchiveNotTerminal
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"ieo":                ieo,
+		"payment_currencies": ieo.PaymentCurrencies(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return archiveRecord(tx, ieo, ArchiveRecordIEO, strconv.FormatInt(ieo.ID, 10), ieo.State, string(payload), actor_uid)
+}
+
+// ArchiveMarket snapshots the market and archives it, actor_uid is empty when the archival job archives it.
+func ArchiveMarket(tx *gorm.DB, market *Market, actor_uid string) error {
+	if !market.Archivable() {
+		return ErrArchiveNotTerminal
+	}
+
+	payload, err := json.Marshal(market)
+	if err != nil {
+		return err
+	}
+
+	return archiveRecord(tx, market, ArchiveRecordMarket, market.Symbol, types.MarketState(market.State), string(payload), actor_uid)
+}
+
+// archiveRecord moves record to the archived state, unless its state changed since it was read.
+func archiveRecord(tx *gorm.DB, record interface{}, record_type ArchiveRecordType, record_id string, state types.MarketState, payload, actor_uid string) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(record).Where("state = ?", state).Update("state", types.MarketStateArchived)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrArchiveNotTerminal
+		}
+
+		archive := &Archive{
+			RecordType: record_type,
+			RecordID:   record_id,
+			State:      state,
+			Payload:    payload,
+			ArchivedBy: actor_uid,
+		}
+		if result := tx.Create(archive); result.Error != nil {
+			return result.Error
+		}
+
+		return tx.Create(&AuditEvent{ActorUID: actor_uid, Action: string(record_type) + ".archived", Data: record_id}).Error
+	})
+}
+
+// RestoreIEO takes the IEO out of the archive, back to the state it was archived from.
+func RestoreIEO(tx *gorm.DB, ieo *IEO, actor_uid string) error {
+	if ieo.State != types.MarketStateArchived {
+		return ErrArchiveNotArchived
+	}
+
+	state, err := restoreRecord(tx, ieo, ArchiveRecordIEO, strconv.FormatInt(ieo.ID, 10), actor_uid)
+	if err != nil {
+		return err
+	}
+
+	ieo.State = state
+
+	return nil
+}
+
+// RestoreMarket takes the market out of the archive, back to the state it was archived from.
+func RestoreMarket(tx *gorm.DB, market *Market, actor_uid string) error {
+	if market.State != string(types.MarketStateArchived) {
+		return ErrArchiveNotArchived
+	}
+
+	state, err := restoreRecord(tx, market, ArchiveRecordMarket, market.Symbol, actor_uid)
+	if err != nil {
+		return err
+	}
+
+	market.State = string(state)
+
+	return nil
+}
+
+func restoreRecord(tx *gorm.DB, record interface{}, record_type ArchiveRecordType, record_id, actor_uid string) (types.MarketState, error) {
+	var state types.MarketState
+
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		var archive *Archive
+		if result := tx.
+			Where("record_type = ? AND record_id = ? AND restored_at IS NULL", record_type, record_id).
+			Order("id DESC").
+			First(&archive); result.Error != nil {
+			return result.Error
+		}
+
+		result := tx.Model(record).Where("state = ?", types.MarketStateArchived).Update("state", archive.State)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return ErrArchiveNotArchived
+		}
+
+		if result := tx.Model(archive).Updates(map[string]interface{}{
+			"restored_by": actor_uid,
+			"restored_at": time.Now(),
+		}); result.Error != nil {
+			return result.Error
+		}
+
+		state = archive.State
+
+		return tx.Create(&AuditEvent{ActorUID: actor_uid, Action: string(record_type) + ".unarchived", Data: record_id}).Error
+	})
+
+	return state, err
+}
+
+// ArchiveStale archives the IEOs which ended or were disabled before ieo_after, and the markets disabled
+// without trades for market_after. A zero duration archives none of them, records failing to archive are logged and skipped.
+func ArchiveStale(tx *gorm.DB, now time.Time, ieo_after, market_after time.Duration) (ieos, markets int, err error) {
+	if ieo_after > 0 {
+		cutoff := now.Add(-ieo_after)
+
+		var stale_ieos []*IEO
+		if result := tx.
+			Where("state <> ?", types.MarketStateArchived).
+			Where("end_time < ? OR (state = ? AND updated_at < ?)", cutoff, types.MarketStateDisabled, cutoff).
+			Find(&stale_ieos); result.Error != nil {
+			return ieos, markets, result.Error
+		}
+
+		for _, ieo := range stale_ieos {
+			if err := ArchiveIEO(tx, ieo, "", now); err != nil {
+				config.Logger.Errorf("Failed to archive IEO %d: %v", ieo.ID, err)
+				continue
+			}
+
+			ieos++
+		}
+	}
+
+	if market_after > 0 {
+		cutoff := now.Add(-market_after)
+
+		var stale_markets []*Market
+		if result := tx.
+			Where("state = ? AND updated_at < ?", types.MarketStateDisabled, cutoff).
+			Where("NOT EXISTS (SELECT 1 FROM trades WHERE trades.market_id = markets.symbol AND trades.created_at >= ?)", cutoff).
+			Find(&stale_markets); result.Error != nil {
+			return ieos, markets, result.Error
+		}
+
+		for _, market := range stale_markets {
+			if err := ArchiveMarket(tx, market, ""); err != nil {
+				config.Logger.Errorf("Failed to archive market %s: %v", market.Symbol, err)
+				continue
+			}
+
+			markets++
+		}
+	}
+
+	return ieos, markets, nil
+}
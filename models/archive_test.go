@@ -0,0 +1,57 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zsmartex/finex/types"
+)
+
+func TestIEOArchivable(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		state types.MarketState
+		end   time.Time
+		want  bool
+	}{
+		{"running", types.MarketStateEndabled, now.Add(time.Hour), false},
+		{"ended", types.MarketStateEndabled, now.Add(-time.Hour), true},
+		{"disabled before its end", types.MarketStateDisabled, now.Add(time.Hour), true},
+		{"already archived", types.MarketStateArchived, now.Add(-time.Hour), false},
+	}
+
+	for _, test := range tests {
+		ieo := &IEO{State: test.state, EndTime: test.end}
+		if got := ieo.Archivable(now); got != test.want {
+			t.Errorf("%s: expected archivable %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestMarketArchivable(t *testing.T) {
+	for state, want := range map[types.MarketState]bool{
+		types.MarketStateEndabled: false,
+		types.MarketStateHalted:   false,
+		types.MarketStateDisabled: true,
+		types.MarketStateArchived: false,
+	} {
+		market := &Market{State: string(state)}
+		if got := market.Archivable(); got != want {
+			t.Errorf("expected a %s market archivable %v, got %v", state, want, got)
+		}
+	}
+}
+
+func TestArchivedStates(t *testing.T) {
+	listed := []types.MarketState{types.MarketStateEndabled}
+
+	if states := ArchivedStates(listed, false); len(states) != 1 {
+		t.Errorf("expected archived records to be left out, got %v", states)
+	}
+
+	if states := ArchivedStates(listed, true); len(states) != 2 || states[1] != types.MarketStateArchived {
+		t.Errorf("expected archived records to be included, got %v", states)
+	}
+}
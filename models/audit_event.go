@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// AuditEvent records an action taken on the data of a member, by an admin or by the system.
+type AuditEvent struct {
+	ID       int64 `json:"id" gorm:"primaryKey"`
+	MemberID int64 `json:"member_id"`
+	// ActorUID is the uid of who took the action, empty when a worker took it
+	ActorUID  string    `json:"actor_uid"`
+	Action    string    `json:"action"`
+	Data      string    `json:"data"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func RecordAuditEvent(member_id int64, actor_uid, action, data string) {
+	event := &AuditEvent{
+		MemberID: member_id,
+		ActorUID: actor_uid,
+		Action:   action,
+		Data:     data,
+	}
+
+	if result := config.DataBase.Create(&event); result.Error != nil {
+		config.Logger.Errorf("Failed to record audit event %s of member %d: %v", action, member_id, result.Error)
+	}
+}
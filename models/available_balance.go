@@ -0,0 +1,105 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// AvailableBalance is the part of the balance of an account a member can trade or withdraw.
+// The balance still holds the funds of the orders the order processor didn't lock yet, so they're held back.
+// Commissions are credited to the balance with the trade which earns them, the release job only reports them.
+type AvailableBalance struct {
+	CurrencyID    string
+	Balance       decimal.Decimal
+	Locked        decimal.Decimal
+	PendingOrders decimal.Decimal
+	Available     decimal.Decimal
+}
+
+// ComputeAvailable is the only place the available balance is derived, order placement and the balances
+// endpoint both go through it so the UI never shows more than an order would be accepted for.
+func ComputeAvailable(balance, pending_orders decimal.Decimal) decimal.Decimal {
+	available := balance.Sub(pending_orders)
+	if available.IsNegative() {
+		return decimal.Zero
+	}
+
+	return available
+}
+
+// pendingReleasesSince is the start of the day the release job didn't settle yet,
+// it releases the commissions of the previous day at midnight.
+func pendingReleasesSince(now time.Time) time.Time {
+	year, month, day := now.Date()
+
+	return time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+}
+
+// pendingOrdersLocked sums the funds of the orders of a member waiting for the order processor,
+// exclude_order_id leaves out the order being placed.
+func pendingOrdersLocked(tx *gorm.DB, member_id int64, currency_id string, exclude_order_id int64) decimal.Decimal {
+	var result struct {
+		Locked decimal.NullDecimal
+	}
+
+	tx.
+		Model(&Order{}).
+		Select("SUM(locked) AS locked").
+		Where("member_id = ? AND state = ? AND id != ?", member_id, StatePending, exclude_order_id).
+		Where("(type = ? AND bid = ?) OR (type = ? AND ask = ?)", SideBuy, currency_id, SideSell, currency_id).
+		Scan(&result)
+
+	return result.Locked.Decimal
+}
+
+// GetAvailableBalance computes the available balance of an account with tx, which should hold a lock
+// on the account row when the result is used to accept an order.
+func GetAvailableBalance(tx *gorm.DB, account *Account, exclude_order_id int64) *AvailableBalance {
+	available := &AvailableBalance{
+		CurrencyID:    account.CurrencyID,
+		Balance:       account.Balance,
+		Locked:        account.Locked,
+		PendingOrders: pendingOrdersLocked(tx, account.MemberID, account.CurrencyID, exclude_order_id),
+	}
+
+	available.Available = ComputeAvailable(available.Balance, available.PendingOrders)
+
+	return available
+}
+
+// GetAvailableBalances returns the available balance of every account of the member.
+func (m *Member) GetAvailableBalances() []*AvailableBalance {
+	var accounts []*Account
+	config.DataBase.Order("currency_id asc").Find(&accounts, "member_id = ?", m.ID)
+
+	balances := make([]*AvailableBalance, 0, len(accounts))
+	for _, account := range accounts {
+		balances = append(balances, GetAvailableBalance(config.DataBase, account, 0))
+	}
+
+	return balances
+}
+
+// ReserveFunds checks the order fits in the available balance of its member, with the account row locked
+// so concurrent placements of the same member are checked one after the other.
+func (o *Order) ReserveFunds() error {
+	return config.DataBase.Transaction(func(tx *gorm.DB) error {
+		var account *Account
+
+		tx.
+			Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "accounts"}}).
+			Where("member_id = ? AND currency_id = ?", o.MemberID, o.Currency().ID).
+			FirstOrCreate(&account)
+
+		if GetAvailableBalance(tx, account, o.ID).Available.LessThan(o.Locked) {
+			return ErrInsufficientBalance
+		}
+
+		return nil
+	})
+}
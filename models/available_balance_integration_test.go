@@ -0,0 +1,76 @@
+//go:build integration
+
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+// The test needs the DATABASE_* variables of a disposable database and KAFKA_URL for the events of the orders:
+//
+//	go test -tags integration -run 'AvailableBalance' ./models
+func TestAvailableBalanceRightAfterPlacement(t *testing.T) {
+	setupDelistingDatabase(t)
+
+	db := config.DataBase
+	if err := db.AutoMigrate(&Commission{}); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Where("symbol = ?", "avlusdt").Delete(&Market{})
+	db.Where("id = ?", "avl").Delete(&Currency{})
+	db.Where("id = ?", 71).Delete(&Member{})
+	db.Where("member_id = ?", 71).Delete(&Account{})
+	db.Where("member_id = ?", 71).Delete(&Commission{})
+
+	db.Create(&Market{Symbol: "avlusdt", BaseUnit: "avl", QuoteUnit: "usdt", AmountPrecision: 4, PricePrecision: 2, State: string(types.MarketStateEndabled)})
+	db.Create(&[]*Currency{{ID: "avl", Type: "coin"}, {ID: "usdt", Type: "coin"}})
+	db.Create(&Member{ID: 71, UID: "ID71"})
+
+	// 30 of the balance of 130 is a commission earned today, credited with the trade which paid it
+	db.Create(&Account{MemberID: 71, CurrencyID: "usdt", Balance: decimal.NewFromInt(130)})
+	db.Create(&Commission{
+		AccountType: types.AccountTypeSpot, MemberID: 71, FriendUID: "ID72", EarnAmount: decimal.NewFromInt(30),
+		CurrencyID: "usdt", ParentID: 9300, CreatedAt: time.Now(),
+	})
+
+	placed := func(id int64, locked int64) *Order {
+		return &Order{
+			ID: id, MemberID: 71, Ask: "avl", Bid: "usdt", MarketID: "avlusdt", Type: SideBuy, OrdType: types.TypeLimit,
+			Price: decimal.NewNullDecimal(decimal.NewFromInt(locked)), Volume: decimal.NewFromInt(1), OriginVolume: decimal.NewFromInt(1),
+			Locked: decimal.NewFromInt(locked), OriginLocked: decimal.NewFromInt(locked), State: StatePending,
+		}
+	}
+
+	if err := placed(9300, 100).Submit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// read before the order processor locked the funds of the order
+	var available decimal.Decimal
+	for _, balance := range (&Member{ID: 71}).GetAvailableBalances() {
+		if balance.CurrencyID == "usdt" {
+			available = balance.Available
+		}
+	}
+
+	if !available.Equal(decimal.NewFromInt(30)) {
+		t.Fatalf("expected 30 available with the order pending, got %s", available)
+	}
+
+	// the placement accepts what the balances show and not a cent more
+	if err := placed(9301, 31).ReserveFunds(); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("expected an order of more than the available balance rejected, got %v", err)
+	}
+
+	if err := placed(9302, 30).ReserveFunds(); err != nil {
+		t.Errorf("expected an order of the available balance accepted, got %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestComputeAvailable(t *testing.T) {
+	d := decimal.RequireFromString
+
+	tests := []struct {
+		name           string
+		balance        string
+		pending_orders string
+		available      string
+	}{
+		{"nothing pending", "10", "0", "10"},
+		{"pending orders", "10", "3.5", "6.5"},
+		{"exactly spent", "10", "10", "0"},
+		{"more pending than the balance", "10", "12", "0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			available := ComputeAvailable(d(test.balance), d(test.pending_orders))
+			if !available.Equal(d(test.available)) {
+				t.Errorf("expected %s to be available, got %s", test.available, available)
+			}
+		})
+	}
+}
+
+func TestPendingReleasesSince(t *testing.T) {
+	now := time.Date(2022, 5, 2, 23, 59, 59, 0, time.UTC)
+
+	if since := pendingReleasesSince(now); !since.Equal(time.Date(2022, 5, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the commissions of the day to be pending, got %s", since)
+	}
+
+	midnight := time.Date(2022, 5, 3, 0, 0, 0, 0, time.UTC)
+	if since := pendingReleasesSince(midnight); !since.Equal(midnight) {
+		t.Errorf("expected nothing to be pending right after the release, got %s", since)
+	}
+}
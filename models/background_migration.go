@@ -0,0 +1,168 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// DefaultBackgroundMigrationBatchSize is the number of rows a batch covers when background_migrations.batch_size isn't set.
+const DefaultBackgroundMigrationBatchSize = 1000
+
+type BackgroundMigrationState string
+
+var (
+	BackgroundMigrationStatePending BackgroundMigrationState = "pending"
+	BackgroundMigrationStateRunning BackgroundMigrationState = "running"
+	BackgroundMigrationStateDone    BackgroundMigrationState = "done"
+	BackgroundMigrationStateFailed  BackgroundMigrationState = "failed"
+)
+
+var (
+	ErrBackgroundMigrationUnknown = errors.New("admin.background_migration.unknown")
+	ErrBackgroundMigrationDone    = errors.New("admin.background_migration.already_done")
+)
+
+// BackgroundMigrationBatch migrates the rows after cursor, at most batch_size of them, and returns the cursor of the
+// next batch with the number of rows it went through. The migration is done when a batch goes through no row.
+// A batch may run twice when the worker stops before the cursor is saved, so it must be idempotent.
+type BackgroundMigrationBatch func(tx *gorm.DB, cursor int64, batch_size int) (next_cursor int64, processed int64, err error)
+
+var backgroundMigrations = make(map[string]BackgroundMigrationBatch)
+
+// RegisterBackgroundMigration makes a migration runnable by the background migration worker.
+func RegisterBackgroundMigration(name string, batch BackgroundMigrationBatch) {
+	backgroundMigrations[name] = batch
+}
+
+// BackgroundMigrationNames returns the registered migrations in order.
+func BackgroundMigrationNames() []string {
+	names := make([]string, 0, len(backgroundMigrations))
+	for name := range backgroundMigrations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// BackgroundMigration is the progress of a data migration too long to run in a deploy, it's run in batches
+// by the background migration worker and resumes from Cursor when the worker restarts.
+type BackgroundMigration struct {
+	ID         int64                    `json:"id" gorm:"primaryKey"`
+	Name       string                   `json:"name"`
+	State      BackgroundMigrationState `json:"state" gorm:"default:pending"`
+	Cursor     int64                    `json:"cursor" gorm:"default:0"`
+	Processed  int64                    `json:"processed" gorm:"default:0"`
+	Batches    int64                    `json:"batches" gorm:"default:0"`
+	LastError  string                   `json:"last_error"`
+	StartedAt  sql.NullTime             `json:"started_at"`
+	FinishedAt sql.NullTime             `json:"finished_at"`
+	CreatedAt  time.Time                `json:"created_at"`
+	UpdatedAt  time.Time                `json:"updated_at"`
+}
+
+// EnqueueBackgroundMigration queues a registered migration with tx, schema migrations call it in their transaction
+// so the backfill starts once the schema is there. A failed migration is queued again from its cursor.
+func EnqueueBackgroundMigration(tx *gorm.DB, name string) (*BackgroundMigration, error) {
+	if _, ok := backgroundMigrations[name]; !ok {
+		return nil, ErrBackgroundMigrationUnknown
+	}
+
+	var migration *BackgroundMigration
+	result := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where(BackgroundMigration{Name: name}).
+		Attrs(BackgroundMigration{State: BackgroundMigrationStatePending}).
+		FirstOrCreate(&migration)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	switch migration.State {
+	case BackgroundMigrationStateDone:
+		return migration, ErrBackgroundMigrationDone
+	case BackgroundMigrationStateFailed:
+		migration.State = BackgroundMigrationStatePending
+		migration.LastError = ""
+		if result := tx.Save(migration); result.Error != nil {
+			return nil, result.Error
+		}
+	}
+
+	return migration, nil
+}
+
+// NextBackgroundMigration returns the oldest migration left to run, nil when there's none.
+func NextBackgroundMigration() *BackgroundMigration {
+	var migration *BackgroundMigration
+
+	result := config.DataBase.
+		Where("state IN ?", []BackgroundMigrationState{BackgroundMigrationStatePending, BackgroundMigrationStateRunning}).
+		Order("id asc").
+		First(&migration)
+	if result.Error != nil {
+		return nil
+	}
+
+	return migration
+}
+
+// Step runs the next batch of the migration in tx and moves its cursor, without saving it. The worker saves the
+// migration in the same transaction as the batch, so a crash resumes from the last batch which was committed.
+func (m *BackgroundMigration) Step(tx *gorm.DB, batch_size int, now time.Time) error {
+	batch, ok := backgroundMigrations[m.Name]
+	if !ok {
+		return ErrBackgroundMigrationUnknown
+	}
+
+	return m.step(tx, batch, batch_size, now)
+}
+
+func (m *BackgroundMigration) step(tx *gorm.DB, batch BackgroundMigrationBatch, batch_size int, now time.Time) error {
+	if batch_size <= 0 {
+		batch_size = DefaultBackgroundMigrationBatchSize
+	}
+
+	if m.State == BackgroundMigrationStatePending {
+		m.State = BackgroundMigrationStateRunning
+		m.StartedAt = sql.NullTime{Time: now, Valid: true}
+	}
+
+	next_cursor, processed, err := batch(tx, m.Cursor, batch_size)
+	if err != nil {
+		return err
+	}
+
+	m.Batches++
+	if processed == 0 {
+		m.State = BackgroundMigrationStateDone
+		m.FinishedAt = sql.NullTime{Time: now, Valid: true}
+
+		return nil
+	}
+
+	m.Cursor = next_cursor
+	m.Processed += processed
+
+	return nil
+}
+
+// Fail stops the migration until it's queued again, its cursor is kept.
+func (m *BackgroundMigration) Fail(err error) {
+	m.State = BackgroundMigrationStateFailed
+	m.LastError = err.Error()
+
+	if result := config.DataBase.Model(m).Updates(map[string]interface{}{
+		"state":      m.State,
+		"last_error": m.LastError,
+	}); result.Error != nil {
+		config.Logger.Errorf("Failed to save the failure of background migration %s: %v", m.Name, result.Error)
+	}
+}
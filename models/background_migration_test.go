@@ -0,0 +1,136 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// memoryOrders stands for the orders table, snapshots stand for the commits of the migrator transactions.
+type memoryOrders struct {
+	ids      []int64
+	done_at  map[int64]bool
+	backfill map[int64]int
+}
+
+func newMemoryOrders(count int) *memoryOrders {
+	orders := &memoryOrders{done_at: make(map[int64]bool), backfill: make(map[int64]int)}
+	for id := int64(1); id <= int64(count); id++ {
+		orders.ids = append(orders.ids, id)
+	}
+
+	return orders
+}
+
+func (o *memoryOrders) snapshot() map[int64]bool {
+	done_at := make(map[int64]bool, len(o.done_at))
+	for id := range o.done_at {
+		done_at[id] = true
+	}
+
+	return done_at
+}
+
+func (o *memoryOrders) batch(tx *gorm.DB, cursor int64, batch_size int) (int64, int64, error) {
+	var processed int64
+	next := cursor
+
+	for _, id := range o.ids {
+		if id <= cursor || processed == int64(batch_size) {
+			continue
+		}
+
+		if !o.done_at[id] {
+			o.done_at[id] = true
+			o.backfill[id]++
+		}
+
+		next = id
+		processed++
+	}
+
+	return next, processed, nil
+}
+
+func TestBackgroundMigrationResumeAfterCrash(t *testing.T) {
+	now := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	orders := newMemoryOrders(25)
+
+	migration := &BackgroundMigration{Name: BackfillOrdersDoneAt, State: BackgroundMigrationStatePending}
+
+	// two batches are committed
+	for i := 0; i < 2; i++ {
+		if err := migration.step(nil, orders.batch, 10, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	committed := *migration
+	committed_rows := orders.snapshot()
+
+	// the worker dies after the third batch ran but before its transaction committed, both roll back
+	if err := migration.step(nil, orders.batch, 10, now); err != nil {
+		t.Fatal(err)
+	}
+	orders.done_at = committed_rows
+
+	resumed := committed
+	for resumed.State != BackgroundMigrationStateDone {
+		if err := resumed.step(nil, orders.batch, 10, now); err != nil {
+			t.Fatal(err)
+		}
+
+		if resumed.Batches > 10 {
+			t.Fatal("the migration doesn't end")
+		}
+	}
+
+	if resumed.Cursor != 25 || resumed.Processed != 25 || !resumed.FinishedAt.Valid {
+		t.Errorf("got cursor %d processed %d finished %t", resumed.Cursor, resumed.Processed, resumed.FinishedAt.Valid)
+	}
+
+	for _, id := range orders.ids {
+		if !orders.done_at[id] {
+			t.Errorf("order %d wasn't backfilled", id)
+		}
+	}
+
+	// only the batch which was rolled back ran twice
+	for id, count := range orders.backfill {
+		want := 1
+		if id > 20 {
+			want = 2
+		}
+
+		if count != want {
+			t.Errorf("order %d backfilled %d times, want %d", id, count, want)
+		}
+	}
+}
+
+func TestBackgroundMigrationBatchError(t *testing.T) {
+	migration := &BackgroundMigration{Name: "failing", State: BackgroundMigrationStateRunning, Cursor: 40, Processed: 40}
+	failing := func(tx *gorm.DB, cursor int64, batch_size int) (int64, int64, error) {
+		return cursor + 10, 10, errors.New("deadlock")
+	}
+
+	if err := migration.step(nil, failing, 10, time.Now()); err == nil {
+		t.Fatal("the error of the batch was lost")
+	}
+
+	if migration.Cursor != 40 || migration.Processed != 40 {
+		t.Errorf("a failed batch moved the cursor to %d", migration.Cursor)
+	}
+}
+
+func TestEnqueueUnknownBackgroundMigration(t *testing.T) {
+	if _, err := EnqueueBackgroundMigration(nil, "unknown"); err != ErrBackgroundMigrationUnknown {
+		t.Errorf("got %v", err)
+	}
+
+	if names := BackgroundMigrationNames(); len(names) == 0 || names[0] != BackfillOrdersAvgPrice {
+		t.Errorf("got %v", names)
+	}
+}
@@ -0,0 +1,248 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/config"
+)
+
+// CandlePeriods are the k-line periods stored in InfluxDB (measurement "candles_<period>"),
+// ordered from the smallest to the largest.
+var CandlePeriods = []string{"1m", "5m", "15m", "30m", "1h", "2h", "4h", "6h", "12h", "1d", "3d", "1w"}
+
+// PriceSeriesPeriods are the periods accepted by the price series endpoint,
+// periods which are not stored are downsampled from a stored one.
+var PriceSeriesPeriods = map[string]time.Duration{
+	"1m":  time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"30m": 30 * time.Minute,
+	"1h":  time.Hour,
+	"2h":  2 * time.Hour,
+	"4h":  4 * time.Hour,
+	"6h":  6 * time.Hour,
+	"8h":  8 * time.Hour,
+	"12h": 12 * time.Hour,
+	"1d":  24 * time.Hour,
+	"3d":  3 * 24 * time.Hour,
+	"1w":  7 * 24 * time.Hour,
+}
+
+type Candle struct {
+	Time   time.Time
+	Open   decimal.Decimal
+	High   decimal.Decimal
+	Low    decimal.Decimal
+	Close  decimal.Decimal
+	Volume decimal.Decimal
+}
+
+// CandleBucket returns the start of the candle of period holding at. Candles are aligned to the unix epoch
+// like the InfluxDB GROUP BY time() buckets they're aggregated in, and hold the trades from their start
+// included to their end excluded. Every bucketing of candles goes through it.
+func CandleBucket(at time.Time, period time.Duration) time.Time {
+	nanos := at.UnixNano()
+	offset := nanos % int64(period)
+	if offset < 0 {
+		offset += int64(period)
+	}
+
+	return time.Unix(0, nanos-offset).UTC()
+}
+
+// RoundCandle rounds the prices of a candle to the price precision of its market and the volume
+// to its amount precision, stored candles are floats so they're compared once rounded.
+func RoundCandle(candle *Candle, market *Market) *Candle {
+	return &Candle{
+		Time:   candle.Time,
+		Open:   market.round_price(candle.Open),
+		High:   market.round_price(candle.High),
+		Low:    market.round_price(candle.Low),
+		Close:  market.round_price(candle.Close),
+		Volume: market.round_amount(candle.Volume),
+	}
+}
+
+// AggregateCandles builds the candles of period from trades ordered by creation, buckets without trades have no candle.
+func AggregateCandles(trades []*Trade, period time.Duration) []*Candle {
+	candles := make([]*Candle, 0)
+
+	var candle *Candle
+	for _, trade := range trades {
+		bucket := CandleBucket(trade.CreatedAt, period)
+		if candle == nil || !candle.Time.Equal(bucket) {
+			candle = &Candle{
+				Time:   bucket,
+				Open:   trade.Price,
+				High:   trade.Price,
+				Low:    trade.Price,
+				Close:  trade.Price,
+				Volume: decimal.Zero,
+			}
+			candles = append(candles, candle)
+		}
+
+		if trade.Price.GreaterThan(candle.High) {
+			candle.High = trade.Price
+		}
+
+		if trade.Price.LessThan(candle.Low) {
+			candle.Low = trade.Price
+		}
+
+		candle.Close = trade.Price
+		candle.Volume = candle.Volume.Add(trade.Amount)
+	}
+
+	return candles
+}
+
+type PricePoint struct {
+	Time   time.Time
+	Close  decimal.Decimal
+	Volume decimal.Decimal
+}
+
+// SourceCandlePeriod returns the largest stored period which evenly divides the requested period.
+func SourceCandlePeriod(period time.Duration) string {
+	source := CandlePeriods[0]
+
+	for _, p := range CandlePeriods {
+		d := PriceSeriesPeriods[p]
+		if d > period {
+			break
+		}
+
+		if period%d == 0 {
+			source = p
+		}
+	}
+
+	return source
+}
+
+// DownsampleCandles groups candles into `points` evenly spaced buckets of `period` starting at `from`,
+// each bucket takes the close of its last candle and the sum of its volumes.
+// Buckets without candles carry the previous close (starting from `previous_close`) with zero volume.
+func DownsampleCandles(candles []*Candle, from time.Time, period time.Duration, points int, previous_close decimal.Decimal) []*PricePoint {
+	series := make([]*PricePoint, 0, points)
+	last_close := previous_close
+
+	i := 0
+	for n := 0; n < points; n++ {
+		bucket_start := from.Add(time.Duration(n) * period)
+		bucket_end := bucket_start.Add(period)
+
+		point := &PricePoint{
+			Time:   bucket_start,
+			Close:  last_close,
+			Volume: decimal.Zero,
+		}
+
+		// skip candles before the bucket
+		for i < len(candles) && candles[i].Time.Before(bucket_start) {
+			i++
+		}
+
+		for i < len(candles) && candles[i].Time.Before(bucket_end) {
+			point.Close = candles[i].Close
+			point.Volume = point.Volume.Add(candles[i].Volume)
+			i++
+		}
+
+		last_close = point.Close
+		series = append(series, point)
+	}
+
+	return series
+}
+
+func GetCandlesFromInflux(market, period string, from, to time.Time) []*Candle {
+	candles, err := QueryCandlesFromInflux(market, period, from, to)
+	if err != nil {
+		config.Logger.Errorf("Failed to fetch %s candles for %s: %v", period, market, err)
+		return make([]*Candle, 0)
+	}
+
+	return candles
+}
+
+// QueryCandlesFromInflux is GetCandlesFromInflux for the callers which can't serve without the candles, it returns
+// the error of the query.
+func QueryCandlesFromInflux(market, period string, from, to time.Time) ([]*Candle, error) {
+	var rows []map[string]interface{}
+
+	query := fmt.Sprintf(
+		"SELECT * FROM \"candles_%s\" WHERE \"market\"='%s' AND time >= %d AND time < %d ORDER BY time ASC",
+		period, market, from.UnixNano(), to.UnixNano(),
+	)
+	if err := config.InfluxDB.Query(query, &rows); err != nil {
+		return nil, err
+	}
+
+	candles := make([]*Candle, 0, len(rows))
+	for _, row := range rows {
+		candles = append(candles, candleFromInfluxRow(row))
+	}
+
+	return candles, nil
+}
+
+// GetLastCandleCloseFromInflux returns the close of the last candle before `before`, or zero when there isn't any.
+func GetLastCandleCloseFromInflux(market, period string, before time.Time) decimal.Decimal {
+	last_close, _ := QueryLastCandleCloseFromInflux(market, period, before)
+
+	return last_close
+}
+
+// QueryLastCandleCloseFromInflux is GetLastCandleCloseFromInflux which returns the error of the query.
+func QueryLastCandleCloseFromInflux(market, period string, before time.Time) (decimal.Decimal, error) {
+	var rows []map[string]interface{}
+
+	query := fmt.Sprintf(
+		"SELECT LAST(\"close\") AS \"close\" FROM \"candles_%s\" WHERE \"market\"='%s' AND time < %d",
+		period, market, before.UnixNano(),
+	)
+	if err := config.InfluxDB.Query(query, &rows); err != nil {
+		return decimal.Zero, err
+	}
+
+	if len(rows) == 0 {
+		return decimal.Zero, nil
+	}
+
+	return influxDecimal(rows[0]["close"]), nil
+}
+
+func candleFromInfluxRow(row map[string]interface{}) *Candle {
+	var timestamp int64
+	if n, ok := row["time"].(json.Number); ok {
+		timestamp, _ = n.Int64()
+	}
+
+	return &Candle{
+		Time:   time.Unix(0, timestamp).UTC(),
+		Open:   influxDecimal(row["open"]),
+		High:   influxDecimal(row["high"]),
+		Low:    influxDecimal(row["low"]),
+		Close:  influxDecimal(row["close"]),
+		Volume: influxDecimal(row["volume"]),
+	}
+}
+
+func influxDecimal(value interface{}) decimal.Decimal {
+	n, ok := value.(json.Number)
+	if !ok {
+		return decimal.Zero
+	}
+
+	d, err := decimal.NewFromString(n.String())
+	if err != nil {
+		return decimal.Zero
+	}
+
+	return d
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/decimalutil"
+)
+
+// CandleDiscrepancy is a field of a stored candle which differs from the candle recomputed from the trades table.
+// A missing stored candle is recorded with stored values of zero, a stored candle without trades with expected values of zero.
+type CandleDiscrepancy struct {
+	ID       int64     `json:"id" gorm:"primaryKey"`
+	MarketID string    `json:"market_id"`
+	Period   string    `json:"period"`
+	Time     time.Time `json:"time"`
+	// Field is open, high, low, close or volume
+	Field    string          `json:"field"`
+	Expected decimal.Decimal `json:"expected"`
+	Stored   decimal.Decimal `json:"stored"`
+	// Delta is the stored value minus the expected one
+	Delta decimal.Decimal `json:"delta"`
+	// Drift is the delta relative to the expected value, 1 when the expected value is zero
+	Drift     decimal.Decimal `json:"drift"`
+	Repaired  bool            `json:"repaired"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// candleDiscrepancyCheckWindow is the span checked each night for periods up to a day.
+const candleDiscrepancyCheckWindow = 24 * time.Hour
+
+// CandleCheckWindow returns the candles of period the nightly check covers: the previous UTC day,
+// or the last complete candle for periods longer than a day.
+func CandleCheckWindow(now time.Time, period time.Duration) (from, to time.Time) {
+	window := candleDiscrepancyCheckWindow
+	if period > window {
+		window = period
+	}
+
+	to = CandleBucket(now, window)
+
+	return to.Add(-window), to
+}
+
+func candleDrift(expected, delta decimal.Decimal) decimal.Decimal {
+	if expected.IsZero() {
+		return decimal.NewFromInt(1)
+	}
+
+	return decimalutil.Round(delta.Div(expected).Abs(), 8, decimalutil.HalfUp)
+}
+
+func diffCandle(expected, stored *Candle) []*CandleDiscrepancy {
+	discrepancies := make([]*CandleDiscrepancy, 0)
+
+	fields := []struct {
+		name             string
+		expected, stored decimal.Decimal
+	}{
+		{"open", expected.Open, stored.Open},
+		{"high", expected.High, stored.High},
+		{"low", expected.Low, stored.Low},
+		{"close", expected.Close, stored.Close},
+		{"volume", expected.Volume, stored.Volume},
+	}
+
+	for _, f := range fields {
+		if f.expected.Equal(f.stored) {
+			continue
+		}
+
+		delta := f.stored.Sub(f.expected)
+		discrepancies = append(discrepancies, &CandleDiscrepancy{
+			Time:     expected.Time,
+			Field:    f.name,
+			Expected: f.expected,
+			Stored:   f.stored,
+			Delta:    delta,
+			Drift:    candleDrift(f.expected, delta),
+		})
+	}
+
+	return discrepancies
+}
+
+// DiffCandles compares the candles recomputed from the trades to the stored ones, both rounded with RoundCandle.
+// Stored candles without volume and without trades are the filled empty buckets and are not discrepancies.
+func DiffCandles(expected, stored []*Candle) []*CandleDiscrepancy {
+	discrepancies := make([]*CandleDiscrepancy, 0)
+
+	stored_by_time := make(map[int64]*Candle, len(stored))
+	for _, candle := range stored {
+		stored_by_time[candle.Time.UnixNano()] = candle
+	}
+
+	for _, candle := range expected {
+		stored_candle, ok := stored_by_time[candle.Time.UnixNano()]
+		if !ok {
+			stored_candle = &Candle{Time: candle.Time}
+		}
+		delete(stored_by_time, candle.Time.UnixNano())
+
+		discrepancies = append(discrepancies, diffCandle(candle, stored_candle)...)
+	}
+
+	for _, candle := range stored {
+		if _, ok := stored_by_time[candle.Time.UnixNano()]; !ok || candle.Volume.IsZero() {
+			continue
+		}
+
+		discrepancies = append(discrepancies, diffCandle(&Candle{Time: candle.Time}, candle)...)
+	}
+
+	return discrepancies
+}
+
+// repairCandle overwrites the stored candle with the one recomputed from the trades.
+func repairCandle(market, period string, candle *Candle) {
+	float := func(d decimal.Decimal) float64 {
+		f, _ := d.Float64()
+		return f
+	}
+
+	config.InfluxDB.NewPointAt("candles_"+period, map[string]string{"market": market}, map[string]interface{}{
+		"open":   float(candle.Open),
+		"high":   float(candle.High),
+		"low":    float(candle.Low),
+		"close":  float(candle.Close),
+		"volume": float(candle.Volume),
+	}, candle.Time)
+}
+
+// CheckCandles recomputes the candles of period of the market in the nightly window from the trades table,
+// records the fields differing from the stored candles and, when auto_repair is set, overwrites the stored candles
+// drifting more than repair_threshold. Stored candles without trades are only recorded.
+func CheckCandles(market *Market, period string, now time.Time, auto_repair bool, repair_threshold decimal.Decimal) []*CandleDiscrepancy {
+	from, to := CandleCheckWindow(now, PriceSeriesPeriods[period])
+
+	var trades []*Trade
+	config.DataBase.
+		Where("market_id = ? AND created_at >= ? AND created_at < ?", market.Symbol, from, to).
+		Order("created_at asc, id asc").
+		Find(&trades)
+
+	expected := make([]*Candle, 0)
+	expected_by_time := make(map[int64]*Candle)
+	for _, candle := range AggregateCandles(trades, PriceSeriesPeriods[period]) {
+		candle = RoundCandle(candle, market)
+		expected = append(expected, candle)
+		expected_by_time[candle.Time.UnixNano()] = candle
+	}
+
+	stored := make([]*Candle, 0)
+	for _, candle := range GetCandlesFromInflux(market.Symbol, period, from, to) {
+		stored = append(stored, RoundCandle(candle, market))
+	}
+
+	discrepancies := DiffCandles(expected, stored)
+
+	repaired := make(map[int64]bool)
+	for _, discrepancy := range discrepancies {
+		discrepancy.MarketID = market.Symbol
+		discrepancy.Period = period
+
+		candle, ok := expected_by_time[discrepancy.Time.UnixNano()]
+		if auto_repair && ok && discrepancy.Drift.GreaterThan(repair_threshold) && !repaired[candle.Time.UnixNano()] {
+			repairCandle(market.Symbol, period, candle)
+			repaired[candle.Time.UnixNano()] = true
+		}
+	}
+
+	for _, discrepancy := range discrepancies {
+		discrepancy.Repaired = repaired[discrepancy.Time.UnixNano()]
+
+		config.Logger.Warnf(
+			"Candle discrepancy on %s %s at %s: %s stored %s expected %s (delta %s, repaired %t)",
+			discrepancy.MarketID, discrepancy.Period, discrepancy.Time.Format(time.RFC3339), discrepancy.Field,
+			discrepancy.Stored, discrepancy.Expected, discrepancy.Delta, discrepancy.Repaired,
+		)
+	}
+
+	if len(discrepancies) > 0 {
+		if result := config.DataBase.Create(&discrepancies); result.Error != nil {
+			config.Logger.Errorf("Failed to record the candle discrepancies of %s %s: %v", market.Symbol, period, result.Error)
+		}
+	}
+
+	return discrepancies
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCandleCheckWindow(t *testing.T) {
+	now := time.Date(2022, 5, 1, 1, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		period   string
+		from, to time.Time
+	}{
+		{"1m", time.Date(2022, 4, 30, 0, 0, 0, 0, time.UTC), time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"1d", time.Date(2022, 4, 30, 0, 0, 0, 0, time.UTC), time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"1w", time.Date(2022, 4, 21, 0, 0, 0, 0, time.UTC), time.Date(2022, 4, 28, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		from, to := CandleCheckWindow(now, PriceSeriesPeriods[tt.period])
+		if !from.Equal(tt.from) || !to.Equal(tt.to) {
+			t.Errorf("CandleCheckWindow(%s) = [%v, %v), want [%v, %v)", tt.period, from, to, tt.from, tt.to)
+		}
+	}
+}
+
+func TestDiffCandles(t *testing.T) {
+	from := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	d := decimal.RequireFromString
+
+	candle := func(minutes int, open, high, low, close, volume string) *Candle {
+		return &Candle{Time: from.Add(time.Duration(minutes) * time.Minute), Open: d(open), High: d(high), Low: d(low), Close: d(close), Volume: d(volume)}
+	}
+
+	expected := []*Candle{
+		candle(0, "10", "12", "9", "11", "4"),
+		candle(1, "11", "11", "11", "11", "1"),
+		candle(2, "12", "12", "12", "12", "2"),
+	}
+
+	stored := []*Candle{
+		candle(0, "10", "12", "9", "11", "4"),
+		// high and volume drifted
+		candle(1, "11", "11.5", "11", "11", "0.8"),
+		// the candle of minute 2 is missing, minute 3 is a filled empty bucket, minute 4 has no trades
+		candle(3, "12", "12", "12", "12", "0"),
+		candle(4, "12", "12", "12", "12", "3"),
+	}
+
+	discrepancies := DiffCandles(expected, stored)
+
+	type want struct {
+		minutes int
+		field   string
+		delta   string
+		drift   string
+	}
+
+	wants := []want{
+		{1, "high", "0.5", "0.04545455"},
+		{1, "volume", "-0.2", "0.2"},
+		{2, "open", "-12", "1"},
+		{2, "high", "-12", "1"},
+		{2, "low", "-12", "1"},
+		{2, "close", "-12", "1"},
+		{2, "volume", "-2", "1"},
+		{4, "open", "12", "1"},
+		{4, "high", "12", "1"},
+		{4, "low", "12", "1"},
+		{4, "close", "12", "1"},
+		{4, "volume", "3", "1"},
+	}
+
+	if len(discrepancies) != len(wants) {
+		t.Fatalf("got %d discrepancies, want %d", len(discrepancies), len(wants))
+	}
+
+	for i, w := range wants {
+		got := discrepancies[i]
+		if !got.Time.Equal(from.Add(time.Duration(w.minutes)*time.Minute)) || got.Field != w.field || !got.Delta.Equal(d(w.delta)) || !got.Drift.Equal(d(w.drift)) {
+			t.Errorf("discrepancy %d: got %s %s delta %s drift %s, want minute %d %s delta %s drift %s", i, got.Time, got.Field, got.Delta, got.Drift, w.minutes, w.field, w.delta, w.drift)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestSourceCandlePeriod(t *testing.T) {
+	tests := []struct {
+		period string
+		source string
+	}{
+		{"1m", "1m"},
+		{"1h", "1h"},
+		{"8h", "4h"},
+		{"1d", "1d"},
+		{"1w", "1w"},
+	}
+
+	for _, tt := range tests {
+		if got := SourceCandlePeriod(PriceSeriesPeriods[tt.period]); got != tt.source {
+			t.Errorf("SourceCandlePeriod(%s) = %s, want %s", tt.period, got, tt.source)
+		}
+	}
+}
+
+func TestDownsampleCandles(t *testing.T) {
+	from := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	d := decimal.RequireFromString
+
+	candle := func(minutes int, close, volume string) *Candle {
+		return &Candle{Time: from.Add(time.Duration(minutes) * time.Minute), Close: d(close), Volume: d(volume)}
+	}
+
+	type point struct {
+		close  string
+		volume string
+	}
+
+	tests := []struct {
+		name           string
+		candles        []*Candle
+		period         time.Duration
+		points         int
+		previous_close string
+		want           []point
+	}{
+		{
+			name:           "same granularity",
+			candles:        []*Candle{candle(0, "10", "1"), candle(60, "11", "2")},
+			period:         time.Hour,
+			points:         2,
+			previous_close: "0",
+			want:           []point{{"10", "1"}, {"11", "2"}},
+		},
+		{
+			name:           "last close and summed volume",
+			candles:        []*Candle{candle(0, "10", "1"), candle(60, "12", "2"), candle(120, "9", "3"), candle(180, "8", "4")},
+			period:         2 * time.Hour,
+			points:         2,
+			previous_close: "0",
+			want:           []point{{"12", "3"}, {"8", "7"}},
+		},
+		{
+			name:           "missing buckets carry previous close",
+			candles:        []*Candle{candle(60, "11", "2")},
+			period:         time.Hour,
+			points:         3,
+			previous_close: "9",
+			want:           []point{{"9", "0"}, {"11", "2"}, {"11", "0"}},
+		},
+		{
+			name:           "candles outside the range are ignored",
+			candles:        []*Candle{candle(-60, "1", "1"), candle(0, "10", "1"), candle(120, "20", "1")},
+			period:         time.Hour,
+			points:         2,
+			previous_close: "5",
+			want:           []point{{"10", "1"}, {"10", "0"}},
+		},
+		{
+			name:           "empty",
+			candles:        []*Candle{},
+			period:         time.Hour,
+			points:         2,
+			previous_close: "0",
+			want:           []point{{"0", "0"}, {"0", "0"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series := DownsampleCandles(tt.candles, from, tt.period, tt.points, d(tt.previous_close))
+
+			if len(series) != len(tt.want) {
+				t.Fatalf("got %d points, want %d", len(series), len(tt.want))
+			}
+
+			for i, p := range series {
+				if !p.Time.Equal(from.Add(time.Duration(i) * tt.period)) {
+					t.Errorf("point %d: time %v is not evenly spaced", i, p.Time)
+				}
+
+				if !p.Close.Equal(d(tt.want[i].close)) || !p.Volume.Equal(d(tt.want[i].volume)) {
+					t.Errorf("point %d: got (%s, %s), want (%s, %s)", i, p.Close, p.Volume, tt.want[i].close, tt.want[i].volume)
+				}
+			}
+		})
+	}
+}
+
+func TestCandleBucket(t *testing.T) {
+	at := time.Date(2022, 5, 1, 13, 47, 12, 0, time.UTC)
+
+	tests := []struct {
+		period string
+		at     time.Time
+		want   time.Time
+	}{
+		{"1m", at, time.Date(2022, 5, 1, 13, 47, 0, 0, time.UTC)},
+		{"4h", at, time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{"1d", at, time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)},
+		// aligned to the unix epoch, a Thursday, like InfluxDB
+		{"1w", at, time.Date(2022, 4, 28, 0, 0, 0, 0, time.UTC)},
+		{"3d", at.AddDate(0, 0, 2), time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)},
+		// the end of a candle is the start of the next one
+		{"1h", time.Date(2022, 5, 1, 14, 0, 0, 0, time.UTC), time.Date(2022, 5, 1, 14, 0, 0, 0, time.UTC)},
+		// buckets are in UTC whatever the zone of the time
+		{"1h", time.Date(2022, 5, 1, 14, 0, 0, 0, time.FixedZone("ICT", 7*3600)), time.Date(2022, 5, 1, 7, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		if got := CandleBucket(tt.at, PriceSeriesPeriods[tt.period]); !got.Equal(tt.want) {
+			t.Errorf("CandleBucket(%v, %s) = %v, want %v", tt.at, tt.period, got, tt.want)
+		}
+	}
+}
+
+func TestAggregateCandles(t *testing.T) {
+	from := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	d := decimal.RequireFromString
+
+	trade := func(seconds int, price, amount string) *Trade {
+		return &Trade{CreatedAt: from.Add(time.Duration(seconds) * time.Second), Price: d(price), Amount: d(amount)}
+	}
+
+	candles := AggregateCandles([]*Trade{
+		trade(0, "10", "1"),
+		trade(20, "12", "2"),
+		trade(40, "9", "1"),
+		trade(59, "11", "0.5"),
+		trade(60, "11.5", "3"),
+		trade(300, "13", "1"),
+	}, time.Minute)
+
+	want := []*Candle{
+		{Time: from, Open: d("10"), High: d("12"), Low: d("9"), Close: d("11"), Volume: d("4.5")},
+		{Time: from.Add(time.Minute), Open: d("11.5"), High: d("11.5"), Low: d("11.5"), Close: d("11.5"), Volume: d("3")},
+		{Time: from.Add(5 * time.Minute), Open: d("13"), High: d("13"), Low: d("13"), Close: d("13"), Volume: d("1")},
+	}
+
+	if len(candles) != len(want) {
+		t.Fatalf("got %d candles, want %d", len(candles), len(want))
+	}
+
+	for i, c := range candles {
+		w := want[i]
+		if !c.Time.Equal(w.Time) || !c.Open.Equal(w.Open) || !c.High.Equal(w.High) || !c.Low.Equal(w.Low) || !c.Close.Equal(w.Close) || !c.Volume.Equal(w.Volume) {
+			t.Errorf("candle %d: got %+v, want %+v", i, c, w)
+		}
+	}
+}
+
+func TestRoundCandle(t *testing.T) {
+	d := decimal.RequireFromString
+	market := &Market{PricePrecision: 2, AmountPrecision: 4}
+
+	// stored candles are floats
+	candle := RoundCandle(&Candle{Open: d("10.0049999999"), High: d("10.01"), Low: d("9.999999"), Close: d("10"), Volume: d("1.00004999")}, market)
+
+	if !candle.Open.Equal(d("10")) || !candle.Low.Equal(d("10")) || !candle.Volume.Equal(d("1")) {
+		t.Errorf("got %+v", candle)
+	}
+}
@@ -1,21 +1,171 @@
 package models
 
 import (
+	"database/sql"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
 	"github.com/zsmartex/finex/types"
 )
 
+type CommissionState string
+
+var (
+	CommissionStateActive CommissionState = "active"
+	// CommissionStateVoid is a commission clawed back because its trade was reverted
+	CommissionStateVoid CommissionState = "void"
+)
+
+// CommissionTradeReferenceIndex is the unique index of the active commissions on their trade reference, a trade
+// pays a referrer once per leg and level. The currency of a commission is the income currency of the leg it's paid on.
+const CommissionTradeReferenceIndex = "index_commissions_on_trade_reference"
+
+// CommissionLevelDirect is the level of the commissions paid to the referrer of the member, the only level paid for now.
+const CommissionLevelDirect = 1
+
 type Commission struct {
 	ID              int64
 	AccountType     types.AccountType
-	MemberID        int64
+	MemberID        int64 `gorm:"uniqueIndex:index_commissions_on_trade_reference,where:state = 'active'"`
 	FriendUID       string
 	EarnAmount      decimal.Decimal
-	CurrencyID      string
-	ParentID        int64
+	CurrencyID      string `gorm:"uniqueIndex:index_commissions_on_trade_reference"`
+	ParentID        int64  `gorm:"uniqueIndex:index_commissions_on_trade_reference"`
 	ParentCreatedAt time.Time
-	CreatedAt       time.Time
-	UpdatedAt       time.Time
+	Level           int `gorm:"uniqueIndex:index_commissions_on_trade_reference;default:1"`
+	ReferralCodeID  sql.NullInt64
+	// Source is what the friend paid the fee the commission is earned on for
+	Source CommissionSource `gorm:"default:trade"`
+	// FeeAmount is the fee the friend paid, FeeExact the fee before rounding the reward is computed from and Rate
+	// the part of it rewarded, as they were when the commission was created. Null on commissions created before.
+	FeeAmount decimal.NullDecimal
+	FeeExact  decimal.NullDecimal
+	Rate      decimal.NullDecimal
+	State     CommissionState `gorm:"default:active"`
+	VoidedAt  sql.NullTime
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	RoundingAudit
+}
+
+// CommissionReleased reports whether the release job already counted a commission voided at voided_at,
+// the job releases the commissions of a day at the following midnight.
+func CommissionReleased(created_at, voided_at time.Time) bool {
+	return pendingReleasesSince(voided_at).After(created_at)
+}
+
+// createCommission inserts a commission unless its trade reference already has one, a trade executed again
+// after a redelivery creates nothing. It reports whether the commission was created, skipped duplicates are
+// counted in the commission_duplicates measurement.
+func createCommission(tx *gorm.DB, commission *Commission) (bool, error) {
+	if commission.Level == 0 {
+		commission.Level = CommissionLevelDirect
+	}
+
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(commission)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		config.Logger.Warnf("Skipped a duplicate commission of member %d on trade %d in %s", commission.MemberID, commission.ParentID, commission.CurrencyID)
+		config.InfluxDB.NewPoint("commission_duplicates", map[string]string{"currency": commission.CurrencyID}, map[string]interface{}{
+			"trade_id":  strconv.FormatInt(commission.ParentID, 10),
+			"member_id": strconv.FormatInt(commission.MemberID, 10),
+			"count":     1,
+		})
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// DedupeCommissions voids the active commissions created again for the trade reference of an earlier one, before
+// the unique index existed, and takes them back from their referrers. The earliest commission of a reference is kept.
+// Like the commissions of reverted trades, duplicates already released are taken out of the next release. The last
+// batch adds the unique index.
+const DedupeCommissions = "dedupe_commissions"
+
+func init() {
+	RegisterBackgroundMigration(DedupeCommissions, dedupeCommissions)
+}
+
+func dedupeCommissions(tx *gorm.DB, cursor int64, batch_size int) (int64, int64, error) {
+	var commissions []*Commission
+	if result := tx.Where("id > ?", cursor).Order("id asc").Limit(batch_size).Find(&commissions); result.Error != nil {
+		return cursor, 0, result.Error
+	}
+
+	if len(commissions) == 0 {
+		return cursor, 0, EnsureCommissionTradeReferenceIndex(tx)
+	}
+
+	now := time.Now()
+	for _, commission := range commissions {
+		if commission.State != CommissionStateActive {
+			continue
+		}
+
+		var earlier int64
+		if result := tx.
+			Model(&Commission{}).
+			Where("parent_id = ? AND currency_id = ? AND member_id = ? AND level = ? AND state = ?", commission.ParentID, commission.CurrencyID, commission.MemberID, commission.Level, CommissionStateActive).
+			Where("created_at < ? OR (created_at = ? AND id < ?)", commission.CreatedAt, commission.CreatedAt, commission.ID).
+			Count(&earlier); result.Error != nil {
+			return cursor, 0, result.Error
+		}
+
+		if earlier == 0 {
+			continue
+		}
+
+		if err := voidDuplicateCommission(tx, commission, now); err != nil {
+			return cursor, 0, err
+		}
+	}
+
+	return commissions[len(commissions)-1].ID, int64(len(commissions)), nil
+}
+
+// voidDuplicateCommission takes a duplicate back from its referrer, the migration fails on the duplicates a
+// referrer spent and resumes from them once they're resolved.
+func voidDuplicateCommission(tx *gorm.DB, commission *Commission, now time.Time) error {
+	var account *Account
+	if result := tx.
+		Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "accounts"}}).
+		Where("member_id = ? AND currency_id = ?", commission.MemberID, commission.CurrencyID).
+		First(&account); result.Error != nil {
+		return result.Error
+	}
+
+	if err := account.SubFunds(tx, commission.EarnAmount); err != nil {
+		return fmt.Errorf("duplicate commission %d: %w", commission.ID, err)
+	}
+
+	if result := tx.Model(commission).Updates(map[string]interface{}{
+		"state":     CommissionStateVoid,
+		"voided_at": now,
+	}); result.Error != nil {
+		return result.Error
+	}
+
+	config.Logger.Infof("Voided the duplicate commission %d of member %d on trade %d", commission.ID, commission.MemberID, commission.ParentID)
+
+	return tx.Create(&AuditEvent{MemberID: commission.MemberID, Action: "commission.deduplicated", Data: strconv.FormatInt(commission.ID, 10)}).Error
+}
+
+// EnsureCommissionTradeReferenceIndex creates the unique index of the trade references, once the duplicates are voided.
+func EnsureCommissionTradeReferenceIndex(tx *gorm.DB) error {
+	if tx.Migrator().HasIndex(&Commission{}, CommissionTradeReferenceIndex) {
+		return nil
+	}
+
+	return tx.Migrator().CreateIndex(&Commission{}, CommissionTradeReferenceIndex)
 }
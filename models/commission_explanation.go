@@ -0,0 +1,100 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/decimalutil"
+)
+
+// CommissionSource is what the fee a commission is earned on was paid for.
+type CommissionSource string
+
+var (
+	CommissionSourceTrade CommissionSource = "trade"
+	CommissionSourceIEO   CommissionSource = "ieo"
+)
+
+// CommissionExplanation is what a commission was computed from, read from what was stored when it was created.
+type CommissionExplanation struct {
+	Commission *Commission
+	// Trade is the trade of the fee, nil for the other sources
+	Trade *Trade
+	// ReferralCode is the code the friend signed up with and Discount the part of the reward it gave back to the
+	// friend, both nil when the reward wasn't split
+	ReferralCode *ReferralCode
+	Discount     *FeeDiscount
+	// Conversion values the commission in CommissionSettlementCurrency, nil when the stored prices can't
+	Conversion *CommissionConversion
+}
+
+// CommissionConversion is the value of a commission in the currency the release pays it in.
+type CommissionConversion struct {
+	CurrencyID string
+	Rate       decimal.Decimal
+	// Exact is the commission times the rate, Value is it rounded down like a payout
+	Exact decimal.Decimal
+	Value decimal.Decimal
+}
+
+// Reward is the reward of the commission before the split, the earned amount and the discount given back.
+func (e *CommissionExplanation) Reward() decimal.Decimal {
+	if e.Discount == nil {
+		return e.Commission.EarnAmount
+	}
+
+	return e.Commission.EarnAmount.Add(e.Discount.Amount)
+}
+
+// ExplainCommission gathers the trade, the split and the conversion of a commission. The conversion is at the
+// stored prices, like the release values the commissions.
+func ExplainCommission(tx *gorm.DB, commission *Commission, prices []*MarketPrice) (*CommissionExplanation, error) {
+	explanation := &CommissionExplanation{Commission: commission}
+
+	if commission.Source == CommissionSourceTrade {
+		var trade *Trade
+		result := tx.First(&trade, commission.ParentID)
+		if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, result.Error
+		}
+
+		if result.Error == nil {
+			explanation.Trade = trade
+		}
+	}
+
+	if commission.ReferralCodeID.Valid {
+		var referral_code *ReferralCode
+		if result := tx.First(&referral_code, commission.ReferralCodeID.Int64); result.Error != nil {
+			return nil, result.Error
+		}
+		explanation.ReferralCode = referral_code
+
+		var discounts []*FeeDiscount
+		if result := tx.
+			Where("trade_id = ? AND referral_code_id = ? AND currency_id = ?", commission.ParentID, referral_code.ID, commission.CurrencyID).
+			Limit(1).
+			Find(&discounts); result.Error != nil {
+			return nil, result.Error
+		}
+
+		if len(discounts) > 0 {
+			explanation.Discount = discounts[0]
+		}
+	}
+
+	if rate, err := ConversionRate(commission.CurrencyID, CommissionSettlementCurrency, ConversionBridge(), prices); err == nil {
+		exact := commission.EarnAmount.Mul(rate)
+
+		explanation.Conversion = &CommissionConversion{
+			CurrencyID: CommissionSettlementCurrency,
+			Rate:       rate,
+			Exact:      exact,
+			Value:      decimalutil.RoundPayout(exact, 8),
+		}
+	}
+
+	return explanation, nil
+}
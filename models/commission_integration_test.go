@@ -0,0 +1,182 @@
+//go:build integration
+
+package models
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+// The tests need the DATABASE_* variables of a disposable database:
+//
+//	go test -tags integration -run 'Commission' ./models
+func setupCommissionDatabase(t *testing.T) {
+	if len(os.Getenv("DATABASE_HOST")) == 0 {
+		t.Skip("DATABASE_HOST isn't set")
+	}
+
+	db, err := config.NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&Commission{}, &ReleaseCommission{}, &Account{}, &AuditEvent{}); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Where("1 = 1").Delete(&Commission{})
+	db.Where("1 = 1").Delete(&ReleaseCommission{})
+	db.Where("member_id = ?", 7).Delete(&Account{})
+
+	config.DataBase = db
+}
+
+func testCommission(parent_id int64, amount string, created_at time.Time) *Commission {
+	return &Commission{
+		AccountType: types.AccountTypeSpot, MemberID: 7, FriendUID: "ID8", EarnAmount: decimal.RequireFromString(amount),
+		CurrencyID: "btc", ParentID: parent_id, CreatedAt: created_at,
+	}
+}
+
+// The same trade event delivered twice pays its commission once, and the nightly release counts it once.
+func TestCommissionRedeliveredTradeReleasedOnce(t *testing.T) {
+	setupCommissionDatabase(t)
+
+	day := time.Date(2022, 5, 2, 15, 0, 0, 0, time.Local)
+	for delivery := 0; delivery < 2; delivery++ {
+		created, err := createCommission(config.DataBase, testCommission(1, "0.1", day))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if created != (delivery == 0) {
+			t.Fatalf("expected delivery %d to create the commission: %t", delivery, created)
+		}
+	}
+
+	if _, err := createCommission(config.DataBase, testCommission(2, "0.2", day)); err != nil {
+		t.Fatal(err)
+	}
+
+	releases, err := PendingCommissionReleases(config.DataBase, day, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(releases) != 1 || releases[0].FriendTrade != 2 || !releases[0].EarnedBTC.Equal(decimal.RequireFromString("0.3")) {
+		t.Fatalf("expected one release of the 2 trades, got %+v", releases)
+	}
+}
+
+// Duplicates created before the unique index are voided and taken back, the earliest is kept.
+func TestDedupeCommissions(t *testing.T) {
+	setupCommissionDatabase(t)
+
+	if err := config.DataBase.Migrator().DropIndex(&Commission{}, CommissionTradeReferenceIndex); err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2022, 5, 2, 15, 0, 0, 0, time.Local)
+	for _, commission := range []*Commission{
+		testCommission(1, "0.1", day),
+		testCommission(1, "0.1", day.Add(time.Second)),
+		testCommission(1, "0.1", day.Add(2*time.Second)),
+		testCommission(2, "0.2", day),
+	} {
+		if result := config.DataBase.Create(commission); result.Error != nil {
+			t.Fatal(result.Error)
+		}
+	}
+
+	config.DataBase.Create(&Account{MemberID: 7, CurrencyID: "btc", Balance: decimal.RequireFromString("0.5")})
+
+	// batches of 2 split the duplicates of trade 1 over two batches
+	migration := &BackgroundMigration{Name: DedupeCommissions, State: BackgroundMigrationStatePending}
+	for migration.State != BackgroundMigrationStateDone {
+		if err := migration.Step(config.DataBase, 2, day); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var voided int64
+	config.DataBase.Model(&Commission{}).Where("state = ?", CommissionStateVoid).Count(&voided)
+	if voided != 2 {
+		t.Errorf("expected 2 duplicates voided, got %d", voided)
+	}
+
+	var account *Account
+	config.DataBase.Where("member_id = ? AND currency_id = ?", 7, "btc").First(&account)
+	if !account.Balance.Equal(decimal.RequireFromString("0.3")) {
+		t.Errorf("expected the duplicates to be taken back, balance %s", account.Balance)
+	}
+
+	if !config.DataBase.Migrator().HasIndex(&Commission{}, CommissionTradeReferenceIndex) {
+		t.Errorf("expected the last batch to add the unique index")
+	}
+
+	releases, err := PendingCommissionReleases(config.DataBase, day, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(releases) != 1 || releases[0].FriendTrade != 2 || !releases[0].EarnedBTC.Equal(decimal.RequireFromString("0.3")) {
+		t.Fatalf("expected the release to count the kept commissions, got %+v", releases)
+	}
+}
+
+// An explanation reads the trade, the split and the fee of a commission from what was stored along with it.
+func TestExplainCommission(t *testing.T) {
+	setupCommissionDatabase(t)
+
+	if err := config.DataBase.AutoMigrate(&Trade{}, &ReferralCode{}, &FeeDiscount{}); err != nil {
+		t.Fatal(err)
+	}
+
+	trade := &Trade{Price: decimal.NewFromInt(30000), Amount: decimal.RequireFromString("0.5"), Total: decimal.NewFromInt(15000), MarketID: "btcusdt"}
+	referral_code := &ReferralCode{MemberID: 7, Code: "EXPLAIN7", Label: "blog", FriendShare: decimal.RequireFromString("0.25")}
+	for _, record := range []interface{}{trade, referral_code} {
+		if err := config.DataBase.Create(record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	commission := testCommission(trade.ID, "0.0003", time.Now())
+	commission.Source = CommissionSourceTrade
+	commission.ReferralCodeID.Int64, commission.ReferralCodeID.Valid = referral_code.ID, true
+	commission.FeeAmount = decimal.NewNullDecimal(decimal.RequireFromString("0.002"))
+	commission.FeeExact = decimal.NewNullDecimal(decimal.RequireFromString("0.002"))
+	commission.Rate = decimal.NewNullDecimal(decimal.RequireFromString("0.2"))
+	if _, err := createCommission(config.DataBase, commission); err != nil {
+		t.Fatal(err)
+	}
+
+	discount := &FeeDiscount{MemberID: 8, ReferralCodeID: referral_code.ID, TradeID: trade.ID, CurrencyID: "btc", Amount: decimal.RequireFromString("0.0001")}
+	if err := config.DataBase.Create(discount).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	explanation, err := ExplainCommission(config.DataBase, commission, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if explanation.Trade == nil || explanation.Trade.ID != trade.ID || explanation.ReferralCode == nil || explanation.ReferralCode.Code != "EXPLAIN7" {
+		t.Fatalf("expected the trade and the code of the commission, got %+v", explanation)
+	}
+
+	// the reward of 0.2 of the fee is split between the referrer and the friend
+	if !explanation.Reward().Equal(decimal.RequireFromString("0.0004")) {
+		t.Errorf("expected a reward of 0.0004, got %s", explanation.Reward())
+	}
+
+	// a commission in the settlement currency is valued as it is
+	if explanation.Conversion == nil || !explanation.Conversion.Value.Equal(commission.EarnAmount) {
+		t.Errorf("expected the commission valued at %s, got %+v", commission.EarnAmount, explanation.Conversion)
+	}
+}
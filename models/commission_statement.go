@@ -0,0 +1,285 @@
+package models
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// CommissionSettlementCurrency is the currency the release job pays the commissions in.
+const CommissionSettlementCurrency = "btc"
+
+// DefaultCommissionStatementChunk is the number of members a statement is generated for at once.
+const DefaultCommissionStatementChunk = 1000
+
+// commissionEarned is the total a member earned, the member-facing stats and the statements both sum it
+// over the active commissions only.
+const commissionEarned = "SUM(commissions.earn_amount)"
+
+var ErrCommissionStatementYear = errors.New("admin.report.invalid_year")
+
+type CommissionStatementKind string
+
+var (
+	// CommissionStatementKindNative rows are the commissions of a member in the currency of the fees they come from
+	CommissionStatementKindNative CommissionStatementKind = "native"
+	// CommissionStatementKindSettlement rows are the releases of a member, paid in CommissionSettlementCurrency
+	CommissionStatementKindSettlement CommissionStatementKind = "settlement"
+)
+
+var CommissionStatementHeader = []string{"uid", "currency", "kind", "earned", "released", "commissions", "voided"}
+
+// CommissionStatementRow is the referral earnings of a member in a currency over a year.
+type CommissionStatementRow struct {
+	MemberID    int64
+	UID         string
+	CurrencyID  string
+	Kind        CommissionStatementKind
+	Earned      decimal.Decimal
+	Released    decimal.Decimal
+	Commissions int64
+	Voided      int64
+}
+
+func (r *CommissionStatementRow) Record() []string {
+	return []string{
+		r.UID,
+		r.CurrencyID,
+		string(r.Kind),
+		r.Earned.String(),
+		r.Released.String(),
+		strconv.FormatInt(r.Commissions, 10),
+		strconv.FormatInt(r.Voided, 10),
+	}
+}
+
+// activeCommissions scopes a query to the commissions which weren't clawed back.
+func activeCommissions(tx *gorm.DB) *gorm.DB {
+	return tx.Model(&Commission{}).Where("commissions.state = ?", CommissionStateActive)
+}
+
+// CommissionYear is the period of the commissions of a statement, in UTC.
+func CommissionYear(year int) (from, to time.Time) {
+	from = time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	return from, from.AddDate(1, 0, 0)
+}
+
+// ValidCommissionYear reports whether the statement of year can be generated at now, the current year is a statement to date.
+func ValidCommissionYear(year int, now time.Time) bool {
+	return year >= 2000 && year <= now.UTC().Year()
+}
+
+// commissionsReleasedBefore is the time before which the commissions of the period were released at now.
+func commissionsReleasedBefore(to, now time.Time) time.Time {
+	if released := pendingReleasesSince(now); released.Before(to) {
+		return released
+	}
+
+	return to
+}
+
+// commissionStatementMembers returns the next limit members with commissions in the period, after after_member_id.
+func commissionStatementMembers(tx *gorm.DB, from, to time.Time, after_member_id int64, limit int) []int64 {
+	member_ids := make([]int64, 0, limit)
+
+	tx.
+		Model(&Commission{}).
+		Distinct("member_id").
+		Where("created_at >= ? AND created_at < ? AND member_id > ?", from, to, after_member_id).
+		Order("member_id asc").
+		Limit(limit).
+		Pluck("member_id", &member_ids)
+
+	return member_ids
+}
+
+type commissionStatementKey struct {
+	MemberID   int64
+	CurrencyID string
+}
+
+// CommissionStatementChunk computes the rows of the next limit members of the statement of year, after after_member_id,
+// ordered by member and currency. It returns no row once every member is done.
+func CommissionStatementChunk(tx *gorm.DB, year int, now time.Time, after_member_id int64, limit int) (rows []*CommissionStatementRow, last_member_id int64) {
+	from, to := CommissionYear(year)
+
+	member_ids := commissionStatementMembers(tx, from, to, after_member_id, limit)
+	if len(member_ids) == 0 {
+		return nil, after_member_id
+	}
+
+	var uids []struct {
+		ID  int64
+		UID string
+	}
+	tx.Model(&Member{}).Select("id, uid").Where("id IN ?", member_ids).Scan(&uids)
+
+	member_uids := make(map[int64]string, len(uids))
+	for _, member := range uids {
+		member_uids[member.ID] = member.UID
+	}
+
+	var earned []struct {
+		MemberID    int64
+		CurrencyID  string
+		Earned      decimal.Decimal
+		Released    decimal.NullDecimal
+		Commissions int64
+	}
+	activeCommissions(tx).
+		Select(
+			"commissions.member_id, commissions.currency_id, "+commissionEarned+" AS earned, "+
+				"SUM(CASE WHEN commissions.created_at < ? THEN commissions.earn_amount END) AS released, COUNT(*) AS commissions",
+			commissionsReleasedBefore(to, now),
+		).
+		Where("commissions.member_id IN ? AND commissions.created_at >= ? AND commissions.created_at < ?", member_ids, from, to).
+		Group("commissions.member_id, commissions.currency_id").
+		Scan(&earned)
+
+	var voided []struct {
+		MemberID   int64
+		CurrencyID string
+		Voided     int64
+	}
+	tx.
+		Model(&Commission{}).
+		Select("member_id, currency_id, COUNT(*) AS voided").
+		Where("state = ? AND member_id IN ? AND created_at >= ? AND created_at < ?", CommissionStateVoid, member_ids, from, to).
+		Group("member_id, currency_id").
+		Scan(&voided)
+
+	// the commissions of a day are released at the following midnight, so the releases are a day behind the year
+	var settled []struct {
+		MemberID int64
+		Released decimal.Decimal
+		Releases int64
+	}
+	tx.
+		Model(&ReleaseCommission{}).
+		Select("member_id, SUM(earned_btc) AS released, COUNT(*) AS releases").
+		Where("kind NOT IN ?", []ReleaseCommissionKind{ReleaseCommissionKindKickback, ReleaseCommissionKindKickbackAdjustment}).
+		Where("member_id IN ? AND created_at >= ? AND created_at < ?", member_ids, from.AddDate(0, 0, 1), to.AddDate(0, 0, 1)).
+		Group("member_id").
+		Scan(&settled)
+
+	native := make(map[commissionStatementKey]*CommissionStatementRow)
+	for _, e := range earned {
+		native[commissionStatementKey{e.MemberID, e.CurrencyID}] = &CommissionStatementRow{
+			MemberID:    e.MemberID,
+			CurrencyID:  e.CurrencyID,
+			Kind:        CommissionStatementKindNative,
+			Earned:      e.Earned,
+			Released:    e.Released.Decimal,
+			Commissions: e.Commissions,
+		}
+	}
+
+	for _, v := range voided {
+		key := commissionStatementKey{v.MemberID, v.CurrencyID}
+		row, ok := native[key]
+		if !ok {
+			row = &CommissionStatementRow{
+				MemberID:   v.MemberID,
+				CurrencyID: v.CurrencyID,
+				Kind:       CommissionStatementKindNative,
+				Earned:     decimal.Zero,
+				Released:   decimal.Zero,
+			}
+			native[key] = row
+		}
+
+		row.Voided = v.Voided
+	}
+
+	settlements := make(map[int64]*CommissionStatementRow, len(settled))
+	for _, s := range settled {
+		settlements[s.MemberID] = &CommissionStatementRow{
+			MemberID:    s.MemberID,
+			CurrencyID:  CommissionSettlementCurrency,
+			Kind:        CommissionStatementKindSettlement,
+			Earned:      s.Released,
+			Released:    s.Released,
+			Commissions: s.Releases,
+		}
+	}
+
+	return orderCommissionStatement(member_ids, member_uids, native, settlements), member_ids[len(member_ids)-1]
+}
+
+// orderCommissionStatement lists the rows of each member, its native currencies in order then its settlement.
+func orderCommissionStatement(member_ids []int64, member_uids map[int64]string, native map[commissionStatementKey]*CommissionStatementRow, settlements map[int64]*CommissionStatementRow) []*CommissionStatementRow {
+	currencies := make(map[int64][]string)
+	for key := range native {
+		currencies[key.MemberID] = append(currencies[key.MemberID], key.CurrencyID)
+	}
+
+	rows := make([]*CommissionStatementRow, 0, len(native)+len(settlements))
+	for _, member_id := range member_ids {
+		member_currencies := currencies[member_id]
+		sort.Strings(member_currencies)
+
+		for _, currency_id := range member_currencies {
+			row := native[commissionStatementKey{member_id, currency_id}]
+			row.UID = member_uids[member_id]
+			rows = append(rows, row)
+		}
+
+		if row, ok := settlements[member_id]; ok {
+			row.UID = member_uids[member_id]
+			rows = append(rows, row)
+		}
+	}
+
+	return rows
+}
+
+// WriteCommissionStatement writes the statement of year as CSV, chunk members at a time so
+// the memory used doesn't grow with the number of members. flush is called after every chunk.
+func WriteCommissionStatement(tx *gorm.DB, w io.Writer, year int, now time.Time, chunk int, flush func() error) error {
+	if chunk <= 0 {
+		chunk = DefaultCommissionStatementChunk
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(CommissionStatementHeader); err != nil {
+		return err
+	}
+
+	var after_member_id int64
+	for {
+		rows, last_member_id := CommissionStatementChunk(tx, year, now, after_member_id, chunk)
+		if last_member_id == after_member_id {
+			break
+		}
+
+		for _, row := range rows {
+			if err := writer.Write(row.Record()); err != nil {
+				return err
+			}
+		}
+
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+
+		if flush != nil {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		after_member_id = last_member_id
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCommissionsReleasedBefore(t *testing.T) {
+	from, to := CommissionYear(2024)
+	if !from.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || !to.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("got the year %s to %s", from, to)
+	}
+
+	// a statement to date only counts what the release job settled, up to the start of today
+	now := time.Date(2024, 6, 15, 13, 0, 0, 0, time.UTC)
+	if got := commissionsReleasedBefore(to, now); !got.Equal(time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %s during the year", got)
+	}
+
+	// the commissions of December 31 are released on January 1
+	if got := commissionsReleasedBefore(to, time.Date(2025, 1, 1, 0, 0, 1, 0, time.UTC)); !got.Equal(to) {
+		t.Errorf("got %s after the year", got)
+	}
+}
+
+func TestValidCommissionYear(t *testing.T) {
+	now := time.Date(2024, 6, 15, 13, 0, 0, 0, time.UTC)
+
+	for year, want := range map[int]bool{1999: false, 2023: true, 2024: true, 2025: false} {
+		if got := ValidCommissionYear(year, now); got != want {
+			t.Errorf("ValidCommissionYear(%d) = %t, want %t", year, got, want)
+		}
+	}
+}
+
+func TestOrderCommissionStatement(t *testing.T) {
+	d := decimal.RequireFromString
+	native := map[commissionStatementKey]*CommissionStatementRow{
+		{2, "usdt"}: {MemberID: 2, CurrencyID: "usdt", Kind: CommissionStatementKindNative, Earned: d("3"), Released: d("2"), Commissions: 4},
+		{1, "usdt"}: {MemberID: 1, CurrencyID: "usdt", Kind: CommissionStatementKindNative, Earned: d("1"), Released: d("1"), Commissions: 1, Voided: 1},
+		{1, "eth"}:  {MemberID: 1, CurrencyID: "eth", Kind: CommissionStatementKindNative, Earned: d("0.5"), Released: d("0.5"), Commissions: 2},
+	}
+	settlements := map[int64]*CommissionStatementRow{
+		1: {MemberID: 1, CurrencyID: CommissionSettlementCurrency, Kind: CommissionStatementKindSettlement, Earned: d("0.0001"), Released: d("0.0001"), Commissions: 2},
+	}
+
+	rows := orderCommissionStatement([]int64{1, 2}, map[int64]string{1: "UID1", 2: "UID2"}, native, settlements)
+
+	got := make([][]string, 0, len(rows))
+	for _, row := range rows {
+		got = append(got, row.Record())
+	}
+
+	want := [][]string{
+		{"UID1", "eth", "native", "0.5", "0.5", "2", "0"},
+		{"UID1", "usdt", "native", "1", "1", "1", "1"},
+		{"UID1", "btc", "settlement", "0.0001", "0.0001", "2", "0"},
+		{"UID2", "usdt", "native", "3", "2", "4", "0"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -2,12 +2,14 @@ package concerns
 
 import (
 	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/decimalutil"
 )
 
 type PrecisionValidator struct {
 }
 
 func (p PrecisionValidator) LessThanOrEqTo(value decimal.Decimal, precision int32) bool {
-	value_rounded := value.Round(precision)
+	value_rounded := decimalutil.Round(value, precision, decimalutil.HalfUp)
 	return value.Equal(value_rounded)
 }
@@ -0,0 +1,489 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/decimalutil"
+	"github.com/zsmartex/finex/types"
+)
+
+// DefaultConvertQuoteTTL is how long a quote can be accepted when convert.quote_ttl isn't set.
+const DefaultConvertQuoteTTL = 10 * time.Second
+
+type ConvertQuoteState string
+
+var (
+	ConvertQuoteStateQuoted ConvertQuoteState = "quoted"
+	// ConvertQuoteStateAccepted is a quote the member was settled at, the hedger is placing the orders of its route
+	ConvertQuoteStateAccepted ConvertQuoteState = "accepted"
+	// ConvertQuoteStateSettled is a quote whose route was executed, the difference with the quote is booked
+	ConvertQuoteStateSettled ConvertQuoteState = "settled"
+)
+
+var (
+	ErrConvertDisabled            = errors.New("convert.disabled")
+	ErrConvertCurrency            = errors.New("convert.invalid_currency")
+	ErrConvertAmount              = errors.New("convert.invalid_amount")
+	ErrConvertRouteUnavailable    = errors.New("convert.route_unavailable")
+	ErrConvertQuoteNotFound       = errors.New("convert.quote_not_found")
+	ErrConvertQuoteExpired        = errors.New("convert.quote_expired")
+	ErrConvertQuoteAccepted       = errors.New("convert.quote_already_accepted")
+	ErrConvertInsufficientBalance = errors.New("convert.insufficient_balance")
+)
+
+// ConvertLeg is a market order of the route of a conversion, selling the base of its market for the quote or buying it.
+type ConvertLeg struct {
+	Market string
+	Side   OrderSide
+	From   string
+	To     string
+	// Precision is the precision of what the leg gets, the amount precision of a buy, the total precision of a sell
+	Precision       int32
+	AmountPrecision int32
+	MinAmount       decimal.Decimal
+}
+
+func newConvertLeg(market *Market, from string) ConvertLeg {
+	leg := ConvertLeg{
+		Market:          market.Symbol,
+		From:            from,
+		AmountPrecision: int32(market.AmountPrecision),
+		MinAmount:       market.MinAmount,
+	}
+
+	if market.BaseUnit == from {
+		leg.Side = SideSell
+		leg.To = market.QuoteUnit
+		leg.Precision = int32(market.TotalPrecision)
+	} else {
+		leg.Side = SideBuy
+		leg.To = market.BaseUnit
+		leg.Precision = int32(market.AmountPrecision)
+	}
+
+	return leg
+}
+
+func pairedMarket(a, b string, markets []*Market) *Market {
+	for _, market := range markets {
+		if market.BaseUnit == a && market.QuoteUnit == b || market.BaseUnit == b && market.QuoteUnit == a {
+			return market
+		}
+	}
+
+	return nil
+}
+
+// FindConvertRoute returns the legs converting from to to through markets, the market pairing them or the
+// markets pairing each with the conversion bridge. Longer routes aren't followed.
+func FindConvertRoute(from, to string, markets []*Market) ([]ConvertLeg, error) {
+	if market := pairedMarket(from, to, markets); market != nil {
+		return []ConvertLeg{newConvertLeg(market, from)}, nil
+	}
+
+	bridge := ConversionBridge()
+	if bridge == from || bridge == to {
+		return nil, ErrConvertRouteUnavailable
+	}
+
+	first, second := pairedMarket(from, bridge, markets), pairedMarket(bridge, to, markets)
+	if first == nil || second == nil {
+		return nil, ErrConvertRouteUnavailable
+	}
+
+	return []ConvertLeg{newConvertLeg(first, from), newConvertLeg(second, bridge)}, nil
+}
+
+// BookSide is the side of the book a leg takes liquidity from.
+func (l ConvertLeg) BookSide() OrderSide {
+	if l.Side == SideSell {
+		return SideBuy
+	}
+
+	return SideSell
+}
+
+// Output returns what the leg gets for amount_in at the levels of its book side, best first. ok is false
+// when the levels can't take the whole amount.
+func (l ConvertLeg) Output(amount_in decimal.Decimal, levels [][]decimal.Decimal) (output decimal.Decimal, ok bool) {
+	remaining := amount_in
+	output = decimal.Zero
+
+	for _, level := range levels {
+		if !remaining.IsPositive() {
+			break
+		}
+
+		price, amount := level[0], level[1]
+		if !price.IsPositive() {
+			continue
+		}
+
+		if l.Side == SideSell {
+			volume := decimal.Min(remaining, amount)
+			output = output.Add(price.Mul(volume))
+			remaining = remaining.Sub(volume)
+		} else {
+			cost := price.Mul(amount)
+			if cost.GreaterThanOrEqual(remaining) {
+				output = output.Add(remaining.Div(price))
+				remaining = decimal.Zero
+			} else {
+				output = output.Add(amount)
+				remaining = remaining.Sub(cost)
+			}
+		}
+	}
+
+	return output, !remaining.IsPositive()
+}
+
+// QuoteConvertRoute returns what the route gets for amount_in at the depth of its markets, less the buffer and
+// rounded down to the precision of the last leg.
+func QuoteConvertRoute(legs []ConvertLeg, amount_in, buffer decimal.Decimal, depth func(side OrderSide, market string) [][]decimal.Decimal) (decimal.Decimal, error) {
+	amount := amount_in
+	for _, leg := range legs {
+		output, ok := leg.Output(amount, depth(leg.BookSide(), leg.Market))
+		if !ok {
+			return decimal.Zero, ErrConvertRouteUnavailable
+		}
+
+		amount = output
+	}
+
+	amount = amount.Mul(decimal.NewFromInt(1).Sub(buffer))
+
+	return decimalutil.RoundPayout(amount, legs[len(legs)-1].Precision), nil
+}
+
+func ConvertQuoteTTL() time.Duration {
+	if config.Convert.QuoteTTL > 0 {
+		return config.Convert.QuoteTTL
+	}
+
+	return DefaultConvertQuoteTTL
+}
+
+// ConvertDesk is the member taking the other side of the conversions, it pays them from its balances
+// and executes their routes. Conversions are disabled without one.
+func ConvertDesk() (*Member, error) {
+	if len(config.Convert.DeskUID) == 0 {
+		return nil, ErrConvertDisabled
+	}
+
+	var desk *Member
+	if result := config.DataBase.First(&desk, "uid = ?", config.Convert.DeskUID); result.Error != nil {
+		return nil, ErrConvertDisabled
+	}
+
+	return desk, nil
+}
+
+// ConvertQuote is a firm price to convert an amount of a currency to another, a member accepts it once before
+// it expires. The member is settled at the quote against the desk, which executes the route with market orders
+// and books the difference with the quote, the buffer covering the price moving in between.
+type ConvertQuote struct {
+	ID           int64           `json:"id" gorm:"primaryKey"`
+	UUID         uuid.UUID       `json:"uuid" gorm:"default:gen_random_uuid();uniqueIndex"`
+	MemberID     int64           `json:"member_id" gorm:"index"`
+	FromCurrency string          `json:"from_currency"`
+	ToCurrency   string          `json:"to_currency"`
+	AmountIn     decimal.Decimal `json:"amount_in"`
+	AmountOut    decimal.Decimal `json:"amount_out"`
+	Rate         decimal.Decimal `json:"rate"`
+	// Route are the markets of the legs, comma separated, the side of each leg follows from the currency it converts
+	Route  string            `json:"route"`
+	Buffer decimal.Decimal   `json:"buffer"`
+	State  ConvertQuoteState `json:"state" gorm:"index"`
+	// HedgeStep is the leg of the route the hedger is at, HedgeAmount what it converts and HedgeOrderID its order
+	HedgeStep    int             `json:"hedge_step" gorm:"default:0"`
+	HedgeAmount  decimal.Decimal `json:"hedge_amount" gorm:"default:0"`
+	HedgeOrderID sql.NullInt64   `json:"hedge_order_id"`
+	// Difference is what the route got less AmountOut, booked to the revenues once settled
+	Difference decimal.Decimal `json:"difference" gorm:"default:0"`
+	LastError  sql.NullString  `json:"last_error"`
+	ExpiresAt  time.Time       `json:"expires_at"`
+	AcceptedAt sql.NullTime    `json:"accepted_at"`
+	SettledAt  sql.NullTime    `json:"settled_at"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+func enabledMarkets() []*Market {
+	var markets []*Market
+	config.DataBase.Find(&markets, "state = ?", types.MarketStateEndabled)
+
+	return markets
+}
+
+// NewConvertQuote quotes the conversion of amount_in of from to to for the member at the depth of the enabled
+// markets, the quote is stored and expires ConvertQuoteTTL after now.
+func NewConvertQuote(member *Member, from, to string, amount_in decimal.Decimal, now time.Time) (*ConvertQuote, error) {
+	if _, err := ConvertDesk(); err != nil {
+		return nil, err
+	}
+
+	from, to = strings.ToLower(from), strings.ToLower(to)
+	if len(from) == 0 || from == to {
+		return nil, ErrConvertCurrency
+	}
+
+	if !amount_in.IsPositive() {
+		return nil, ErrConvertAmount
+	}
+
+	legs, err := FindConvertRoute(from, to, enabledMarkets())
+	if err != nil {
+		return nil, err
+	}
+
+	amount_out, err := QuoteConvertRoute(legs, amount_in, config.Convert.Buffer, GetDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	if !amount_out.IsPositive() {
+		return nil, ErrConvertAmount
+	}
+
+	markets := make([]string, 0, len(legs))
+	for _, leg := range legs {
+		markets = append(markets, leg.Market)
+	}
+
+	quote := &ConvertQuote{
+		MemberID:     member.ID,
+		FromCurrency: from,
+		ToCurrency:   to,
+		AmountIn:     amount_in,
+		AmountOut:    amount_out,
+		Rate:         decimalutil.DivQuote(amount_out, amount_in, SchemaDecimalScale),
+		Route:        strings.Join(markets, ","),
+		Buffer:       config.Convert.Buffer,
+		State:        ConvertQuoteStateQuoted,
+		ExpiresAt:    now.Add(ConvertQuoteTTL()),
+	}
+
+	if result := config.DataBase.Create(&quote); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return quote, nil
+}
+
+// Legs returns the legs of the route of the quote from its markets.
+func (q *ConvertQuote) Legs() ([]ConvertLeg, error) {
+	symbols := strings.Split(q.Route, ",")
+
+	var markets []*Market
+	if result := config.DataBase.Find(&markets, "symbol IN ?", symbols); result.Error != nil {
+		return nil, result.Error
+	}
+
+	legs := make([]ConvertLeg, 0, len(symbols))
+	from := q.FromCurrency
+	for _, symbol := range symbols {
+		var market *Market
+		for _, m := range markets {
+			if m.Symbol == symbol {
+				market = m
+			}
+		}
+
+		if market == nil {
+			return nil, ErrConvertRouteUnavailable
+		}
+
+		leg := newConvertLeg(market, from)
+		legs = append(legs, leg)
+		from = leg.To
+	}
+
+	return legs, nil
+}
+
+// Acceptable returns why the quote can't be accepted at now, nil when it can.
+func (q *ConvertQuote) Acceptable(now time.Time) error {
+	if q.State != ConvertQuoteStateQuoted {
+		return ErrConvertQuoteAccepted
+	}
+
+	if !now.Before(q.ExpiresAt) {
+		return ErrConvertQuoteExpired
+	}
+
+	return nil
+}
+
+// AcceptConvertQuote settles the member at the quote against the desk, once, and hands the quote to the hedger.
+func AcceptConvertQuote(member *Member, quote_uuid uuid.UUID, now time.Time) (*ConvertQuote, error) {
+	desk, err := ConvertDesk()
+	if err != nil {
+		return nil, err
+	}
+
+	var quote *ConvertQuote
+	var from, to *Currency
+
+	err = config.DataBase.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("uuid = ? AND member_id = ?", quote_uuid, member.ID).Limit(1).Find(&quote); result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return ErrConvertQuoteNotFound
+		}
+
+		if err := quote.Acceptable(now); err != nil {
+			return err
+		}
+
+		if result := tx.First(&from, "id = ?", quote.FromCurrency); result.Error != nil {
+			return result.Error
+		}
+
+		if result := tx.First(&to, "id = ?", quote.ToCurrency); result.Error != nil {
+			return result.Error
+		}
+
+		// make sure the accounts exist before locking them, in the same order on every conversion
+		member.GetAccount(from)
+		member.GetAccount(to)
+		desk.GetAccount(from)
+		desk.GetAccount(to)
+
+		var accounts []*Account
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("member_id IN ? AND currency_id IN ?", []int64{member.ID, desk.ID}, []string{from.ID, to.ID}).
+			Order("member_id, currency_id").
+			Find(&accounts); result.Error != nil {
+			return result.Error
+		}
+
+		account := func(member_id int64, currency_id string) *Account {
+			for _, a := range accounts {
+				if a.MemberID == member_id && a.CurrencyID == currency_id {
+					return a
+				}
+			}
+
+			return &Account{MemberID: member_id, CurrencyID: currency_id}
+		}
+
+		if err := account(member.ID, from.ID).SubFunds(tx, quote.AmountIn); err != nil {
+			return ErrConvertInsufficientBalance
+		}
+
+		// the desk can't pay what it doesn't hold
+		if err := account(desk.ID, to.ID).SubFunds(tx, quote.AmountOut); err != nil {
+			config.Logger.Errorf("Convert desk can't pay quote %s: %v", quote.UUID, err)
+			return ErrConvertRouteUnavailable
+		}
+
+		if err := account(desk.ID, from.ID).PlusFunds(tx, quote.AmountIn); err != nil {
+			return err
+		}
+
+		if err := account(member.ID, to.ID).PlusFunds(tx, quote.AmountOut); err != nil {
+			return err
+		}
+
+		quote.State = ConvertQuoteStateAccepted
+		quote.AcceptedAt = sql.NullTime{Time: now, Valid: true}
+		quote.HedgeAmount = quote.AmountIn
+
+		return tx.Save(quote).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	quote.RecordOperations(desk, from, to)
+
+	return quote, nil
+}
+
+func (q *ConvertQuote) reference() Reference {
+	return Reference{ID: q.ID, Type: "ConvertQuote"}
+}
+
+// RecordOperations books the settlement of the member against the desk.
+func (q *ConvertQuote) RecordOperations(desk *Member, from, to *Currency) {
+	reference := q.reference()
+
+	LiabilityDebit(q.AmountIn, from, reference, "main", q.MemberID)
+	LiabilityCredit(q.AmountIn, from, reference, "main", desk.ID)
+	LiabilityDebit(q.AmountOut, to, reference, "main", desk.ID)
+	LiabilityCredit(q.AmountOut, to, reference, "main", q.MemberID)
+}
+
+// CompleteHedgeStep moves the quote past the leg order executed, what the order didn't spend of the hedge amount,
+// a partial fill or what was too small to be placed, is returned as unspent. order is nil when none was placed.
+func (q *ConvertQuote) CompleteHedgeStep(order *Order) (unspent decimal.Decimal) {
+	spent, received := decimal.Zero, decimal.Zero
+	if order != nil {
+		spent, received = order.FundsUsed(), order.FundsReceived
+	}
+
+	unspent = q.HedgeAmount.Sub(spent)
+	q.HedgeAmount = received
+	q.HedgeStep++
+	q.HedgeOrderID = sql.NullInt64{}
+
+	return unspent
+}
+
+// Settle books what the route got less what the member was paid, the route is executed.
+func (q *ConvertQuote) Settle(now time.Time) {
+	q.Difference = q.HedgeAmount.Sub(q.AmountOut)
+	q.State = ConvertQuoteStateSettled
+	q.SettledAt = sql.NullTime{Time: now, Valid: true}
+}
+
+// BookConvertRevenue books an amount the desk kept or lacked on a conversion, a credit when it's positive.
+func BookConvertRevenue(q *ConvertQuote, currency_id string, amount decimal.Decimal) {
+	if amount.IsZero() {
+		return
+	}
+
+	var currency *Currency
+	if result := config.DataBase.First(&currency, "id = ?", currency_id); result.Error != nil {
+		config.Logger.Errorf("Failed to book the revenue of convert quote %s: %v", q.UUID, result.Error)
+		return
+	}
+
+	if amount.IsPositive() {
+		RevenueCredit(amount, currency, q.reference(), q.MemberID)
+	} else {
+		RevenueDebit(amount.Neg(), currency, q.reference(), q.MemberID)
+	}
+}
+
+func (q *ConvertQuote) ToJSON() entities.ConvertQuoteEntity {
+	entity := entities.ConvertQuoteEntity{
+		UUID:      q.UUID,
+		From:      q.FromCurrency,
+		To:        q.ToCurrency,
+		AmountIn:  q.AmountIn,
+		AmountOut: q.AmountOut,
+		Rate:      q.Rate,
+		Route:     strings.Split(q.Route, ","),
+		State:     string(q.State),
+		ExpiresAt: q.ExpiresAt,
+		CreatedAt: q.CreatedAt,
+	}
+
+	if q.AcceptedAt.Valid {
+		entity.AcceptedAt = &q.AcceptedAt.Time
+	}
+
+	return entity
+}
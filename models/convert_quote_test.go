@@ -0,0 +1,175 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var convertMarkets = []*Market{
+	{Symbol: "btcusdt", BaseUnit: "btc", QuoteUnit: "usdt", AmountPrecision: 6, TotalPrecision: 4},
+	{Symbol: "ethusdt", BaseUnit: "eth", QuoteUnit: "usdt", AmountPrecision: 4, TotalPrecision: 4},
+	{Symbol: "ethbtc", BaseUnit: "eth", QuoteUnit: "btc", AmountPrecision: 4, TotalPrecision: 8},
+	{Symbol: "tokbtc", BaseUnit: "tok", QuoteUnit: "btc", AmountPrecision: 2, TotalPrecision: 8},
+}
+
+func TestFindConvertRoute(t *testing.T) {
+	tests := []struct {
+		from, to string
+		route    []string
+		sides    []OrderSide
+		err      error
+	}{
+		{"btc", "usdt", []string{"btcusdt"}, []OrderSide{SideSell}, nil},
+		{"usdt", "eth", []string{"ethusdt"}, []OrderSide{SideBuy}, nil},
+		// eth/btc is traded directly, the bridge isn't needed
+		{"btc", "eth", []string{"ethbtc"}, []OrderSide{SideBuy}, nil},
+		{"tok", "usdt", nil, nil, ErrConvertRouteUnavailable},
+		{"doge", "btc", nil, nil, ErrConvertRouteUnavailable},
+	}
+
+	for _, tt := range tests {
+		legs, err := FindConvertRoute(tt.from, tt.to, convertMarkets)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("FindConvertRoute(%s, %s) error = %v, want %v", tt.from, tt.to, err, tt.err)
+			continue
+		}
+
+		if len(legs) != len(tt.route) {
+			t.Errorf("FindConvertRoute(%s, %s) = %d legs, want %v", tt.from, tt.to, len(legs), tt.route)
+			continue
+		}
+
+		for i, leg := range legs {
+			if leg.Market != tt.route[i] || leg.Side != tt.sides[i] {
+				t.Errorf("FindConvertRoute(%s, %s) leg %d = %s %s, want %s %s", tt.from, tt.to, i, leg.Market, leg.Side, tt.route[i], tt.sides[i])
+			}
+		}
+	}
+}
+
+func TestFindConvertRouteThroughTheBridge(t *testing.T) {
+	markets := []*Market{convertMarkets[0], convertMarkets[1]}
+
+	legs, err := FindConvertRoute("btc", "eth", markets)
+	if err != nil {
+		t.Fatalf("FindConvertRoute() error = %v", err)
+	}
+
+	if len(legs) != 2 || legs[0].Market != "btcusdt" || legs[0].Side != SideSell || legs[1].Market != "ethusdt" || legs[1].Side != SideBuy {
+		t.Fatalf("FindConvertRoute() = %+v, want btcusdt sell then ethusdt buy", legs)
+	}
+
+	if legs[1].From != "usdt" || legs[1].To != "eth" || legs[1].Precision != 4 {
+		t.Errorf("FindConvertRoute() second leg = %+v, want usdt to eth at the eth amount precision", legs[1])
+	}
+}
+
+func TestConvertLegOutput(t *testing.T) {
+	d := decimal.RequireFromString
+	bids := [][]decimal.Decimal{{d("100"), d("1")}, {d("90"), d("2")}}
+	asks := [][]decimal.Decimal{{d("100"), d("1")}, {d("125"), d("2")}}
+
+	sell := ConvertLeg{Side: SideSell}
+	buy := ConvertLeg{Side: SideBuy}
+
+	tests := []struct {
+		name   string
+		leg    ConvertLeg
+		in     string
+		levels [][]decimal.Decimal
+		out    string
+		ok     bool
+	}{
+		{"sell within the best level", sell, "0.5", bids, "50", true},
+		{"sell walks the book", sell, "2", bids, "190", true},
+		{"sell beyond the book", sell, "4", bids, "280", false},
+		{"buy within the best level", buy, "50", asks, "0.5", true},
+		{"buy walks the book", buy, "225", asks, "2", true},
+		{"buy beyond the book", buy, "1000", asks, "3", false},
+	}
+
+	for _, tt := range tests {
+		out, ok := tt.leg.Output(d(tt.in), tt.levels)
+		if ok != tt.ok || !out.Equal(d(tt.out)) {
+			t.Errorf("%s: Output() = %s, %v, want %s, %v", tt.name, out, ok, tt.out, tt.ok)
+		}
+	}
+}
+
+func TestQuoteConvertRoute(t *testing.T) {
+	d := decimal.RequireFromString
+	depth := func(side OrderSide, market string) [][]decimal.Decimal {
+		switch {
+		case market == "btcusdt" && side == SideBuy:
+			return [][]decimal.Decimal{{d("20000"), d("10")}}
+		case market == "ethusdt" && side == SideSell:
+			return [][]decimal.Decimal{{d("1500"), d("100")}}
+		default:
+			return nil
+		}
+	}
+
+	legs, _ := FindConvertRoute("btc", "eth", []*Market{convertMarkets[0], convertMarkets[1]})
+
+	// 1 btc => 20000 usdt => 13.3333.. eth, less 0.2% => 13.30666.., rounded down to 4 places
+	out, err := QuoteConvertRoute(legs, d("1"), d("0.002"), depth)
+	if err != nil || !out.Equal(d("13.3066")) {
+		t.Fatalf("QuoteConvertRoute() = %s, %v, want 13.3066", out, err)
+	}
+
+	if _, err := QuoteConvertRoute(legs, d("11"), d("0.002"), depth); !errors.Is(err, ErrConvertRouteUnavailable) {
+		t.Errorf("QuoteConvertRoute() beyond the depth error = %v, want ErrConvertRouteUnavailable", err)
+	}
+}
+
+func TestConvertQuoteAcceptable(t *testing.T) {
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	quote := &ConvertQuote{State: ConvertQuoteStateQuoted, ExpiresAt: now.Add(10 * time.Second)}
+
+	if err := quote.Acceptable(now.Add(9 * time.Second)); err != nil {
+		t.Errorf("Acceptable() before the expiry = %v, want nil", err)
+	}
+
+	if err := quote.Acceptable(now.Add(10 * time.Second)); !errors.Is(err, ErrConvertQuoteExpired) {
+		t.Errorf("Acceptable() at the expiry = %v, want ErrConvertQuoteExpired", err)
+	}
+
+	quote.State = ConvertQuoteStateAccepted
+	if err := quote.Acceptable(now); !errors.Is(err, ErrConvertQuoteAccepted) {
+		t.Errorf("Acceptable() once accepted = %v, want ErrConvertQuoteAccepted", err)
+	}
+}
+
+func TestConvertQuoteHedgePartialFill(t *testing.T) {
+	d := decimal.RequireFromString
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	quote := &ConvertQuote{AmountIn: d("2"), AmountOut: d("39900"), HedgeAmount: d("2")}
+
+	// the market sell of 2 btc only filled 1.5 at 20000
+	order := &Order{OriginLocked: d("2"), Locked: d("0.5"), FundsReceived: d("30000")}
+	if unspent := quote.CompleteHedgeStep(order); !unspent.Equal(d("0.5")) {
+		t.Errorf("CompleteHedgeStep() unspent = %s, want 0.5", unspent)
+	}
+
+	if quote.HedgeStep != 1 || !quote.HedgeAmount.Equal(d("30000")) || quote.HedgeOrderID.Valid {
+		t.Errorf("CompleteHedgeStep() = step %d amount %s, want step 1 amount 30000", quote.HedgeStep, quote.HedgeAmount)
+	}
+
+	quote.Settle(now)
+	if quote.State != ConvertQuoteStateSettled || !quote.Difference.Equal(d("-9900")) {
+		t.Errorf("Settle() = %s difference %s, want settled with -9900", quote.State, quote.Difference)
+	}
+}
+
+func TestConvertQuoteHedgeTooSmall(t *testing.T) {
+	d := decimal.RequireFromString
+	quote := &ConvertQuote{HedgeAmount: d("0.00001")}
+
+	if unspent := quote.CompleteHedgeStep(nil); !unspent.Equal(d("0.00001")) || !quote.HedgeAmount.IsZero() {
+		t.Errorf("CompleteHedgeStep(nil) = %s, amount %s, want the whole amount unspent", unspent, quote.HedgeAmount)
+	}
+}
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// USDT is the currency exchange-wide totals are valued in.
+const USDT = "usdt"
+
+// MarketPrice is the last price of a market, in its quote currency.
+type MarketPrice struct {
+	BaseUnit  string
+	QuoteUnit string
+	Price     decimal.Decimal
+}
+
+var ErrConversionPath = errors.New("conversion.path.unresolvable")
+
+// ConversionBridge is the currency conversions go through when two currencies aren't traded against each other.
+func ConversionBridge() string {
+	if len(config.ConversionBridge) == 0 {
+		return USDT
+	}
+
+	return strings.ToLower(config.ConversionBridge)
+}
+
+// USDTRate returns the USDT value of one unit of currency.
+func USDTRate(currency string, prices []*MarketPrice) (decimal.Decimal, bool) {
+	rate, err := ConversionRate(currency, USDT, ConversionBridge(), prices)
+
+	return rate, err == nil
+}
+
+// ConversionRate returns the price of one unit of from in to. Without a market pairing them the rate goes through
+// one other currency, the bridge when both are traded against it, else a currency from is traded against.
+// Longer paths aren't followed, the rate is ErrConversionPath.
+func ConversionRate(from, to, bridge string, prices []*MarketPrice) (decimal.Decimal, error) {
+	from = strings.ToLower(from)
+	to = strings.ToLower(to)
+	if from == to {
+		return decimal.NewFromInt(1), nil
+	}
+
+	if rate, ok := directRate(from, to, prices); ok {
+		return rate, nil
+	}
+
+	if bridge != from && bridge != to {
+		if rate, ok := hopRate(from, bridge, to, prices); ok {
+			return rate, nil
+		}
+	}
+
+	for _, p := range prices {
+		var hop string
+		switch {
+		case p.BaseUnit == from && p.QuoteUnit != to:
+			hop = p.QuoteUnit
+		case p.QuoteUnit == from && p.BaseUnit != to:
+			hop = p.BaseUnit
+		default:
+			continue
+		}
+
+		if rate, ok := hopRate(from, hop, to, prices); ok {
+			return rate, nil
+		}
+	}
+
+	return decimal.Zero, fmt.Errorf("%w: %s to %s", ErrConversionPath, from, to)
+}
+
+// hopRate returns the rate of from in to through hop.
+func hopRate(from, hop, to string, prices []*MarketPrice) (decimal.Decimal, bool) {
+	to_hop, ok := directRate(from, hop, prices)
+	if !ok {
+		return decimal.Zero, false
+	}
+
+	hop_rate, ok := directRate(hop, to, prices)
+	if !ok {
+		return decimal.Zero, false
+	}
+
+	return to_hop.Mul(hop_rate), true
+}
+
+// CurrencyPrices are the stored prices of currencies, each in its own price currency.
+func CurrencyPrices(currencies []*Currency) []*MarketPrice {
+	prices := make([]*MarketPrice, 0, len(currencies))
+	for _, currency := range currencies {
+		price_currency := strings.ToLower(currency.PriceCurrency)
+		if len(price_currency) == 0 {
+			price_currency = USDT
+		}
+
+		prices = append(prices, &MarketPrice{
+			BaseUnit:  currency.ID,
+			QuoteUnit: price_currency,
+			Price:     currency.Price,
+		})
+	}
+
+	return prices
+}
+
+// StoredCurrencyPrices loads the stored prices of every currency.
+func StoredCurrencyPrices() []*MarketPrice {
+	var currencies []*Currency
+	config.DataBase.Find(&currencies)
+
+	return CurrencyPrices(currencies)
+}
+
+// directRate returns the price of one unit of from in to, using the from/to or the to/from market.
+func directRate(from, to string, prices []*MarketPrice) (decimal.Decimal, bool) {
+	for _, p := range prices {
+		if !p.Price.IsPositive() {
+			continue
+		}
+
+		if p.BaseUnit == from && p.QuoteUnit == to {
+			return p.Price, true
+		}
+
+		if p.BaseUnit == to && p.QuoteUnit == from {
+			return decimal.NewFromInt(1).Div(p.Price), true
+		}
+	}
+
+	return decimal.Zero, false
+}
+
+// MarketPricesAt returns the prices the markets had at a time, the close of their last candle before it, the markets
+// which hadn't traded yet are left out. Historical values are converted at these prices rather than the stored ones.
+func MarketPricesAt(markets []*Market, at time.Time, last_close func(market string, before time.Time) decimal.Decimal) []*MarketPrice {
+	prices := make([]*MarketPrice, 0, len(markets))
+	for _, market := range markets {
+		price := last_close(market.Symbol, at)
+		if !price.IsPositive() {
+			continue
+		}
+
+		prices = append(prices, &MarketPrice{
+			BaseUnit:  strings.ToLower(market.BaseUnit),
+			QuoteUnit: strings.ToLower(market.QuoteUnit),
+			Price:     price,
+		})
+	}
+
+	return prices
+}
+
+// HistoricalMarketPrices returns the prices every market had at a time, from the closes of their hourly candles.
+func HistoricalMarketPrices(at time.Time) []*MarketPrice {
+	var markets []*Market
+	config.DataBase.Find(&markets)
+
+	return MarketPricesAt(markets, at, func(market string, before time.Time) decimal.Decimal {
+		return GetLastCandleCloseFromInflux(market, "1h", before)
+	})
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestConversionRate(t *testing.T) {
+	d := decimal.RequireFromString
+	prices := []*MarketPrice{
+		{BaseUnit: "btc", QuoteUnit: "usdt", Price: d("25000")},
+		{BaseUnit: "eth", QuoteUnit: "btc", Price: d("0.05")},
+		{BaseUnit: "eur", QuoteUnit: "usdt", Price: d("1.25")},
+		// tok is only traded against eth and btc
+		{BaseUnit: "tok", QuoteUnit: "eth", Price: d("0.1")},
+		{BaseUnit: "tok", QuoteUnit: "btc", Price: d("0.004")},
+	}
+
+	tests := []struct {
+		from, to string
+		rate     string
+		err      error
+	}{
+		{"btc", "btc", "1", nil},
+		{"BTC", "usdt", "25000", nil},
+		{"usdt", "btc", "0.00004", nil},
+		// through the bridge
+		{"eur", "btc", "0.00005", nil},
+		// tok has no usdt market and eth no usdt market either, its usdt value comes from tok/btc
+		{"tok", "usdt", "100", nil},
+		{"tok", "eth", "0.1", nil},
+		// eth/btc then btc/usdt then usdt/eur is more than one hop
+		{"eth", "eur", "0", ErrConversionPath},
+		{"doge", "usdt", "0", ErrConversionPath},
+	}
+
+	for _, tt := range tests {
+		rate, err := ConversionRate(tt.from, tt.to, USDT, prices)
+		if !errors.Is(err, tt.err) || !rate.Equal(d(tt.rate)) {
+			t.Errorf("ConversionRate(%s, %s) = (%s, %v), want (%s, %v)", tt.from, tt.to, rate, err, tt.rate, tt.err)
+		}
+	}
+}
+
+func TestCurrencyPrices(t *testing.T) {
+	d := decimal.RequireFromString
+	prices := CurrencyPrices([]*Currency{
+		{ID: "btc", Price: d("25000")},
+		{ID: "tok", Price: d("0.004"), PriceCurrency: "BTC"},
+		{ID: "eur", Price: d("1.25"), PriceCurrency: "usdt"},
+	})
+
+	// prices stored in btc aren't taken for usdt
+	rate, err := ConversionRate("tok", CommissionSettlementCurrency, USDT, prices)
+	if err != nil || !rate.Equal(d("0.004")) {
+		t.Errorf("expected tok to be valued at its btc price, got (%s, %v)", rate, err)
+	}
+
+	if rate, err := ConversionRate("tok", USDT, USDT, prices); err != nil || !rate.Equal(d("100")) {
+		t.Errorf("expected the usdt value of tok to go through btc, got (%s, %v)", rate, err)
+	}
+
+	if _, err := ConversionRate("tok", "eur", USDT, prices); !errors.Is(err, ErrConversionPath) {
+		t.Errorf("expected tok/btc, btc/usdt, usdt/eur to be too long a path, got %v", err)
+	}
+}
@@ -22,7 +22,9 @@ type Currency struct {
 	Precision   string          `json:"precision"`
 	IconURL     string          `json:"icon_url"`
 	Price       decimal.Decimal `json:"price"`
-	Status      string          `json:"status"`
-	CreatedAt   time.Time       `json:"created_at"`
-	UpdatedAt   time.Time       `json:"updated_at"`
+	// PriceCurrency is the currency Price is in
+	PriceCurrency string    `json:"price_currency" gorm:"default:usdt"`
+	Status        string    `json:"status"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+)
+
+type DeadLetterState string
+
+var (
+	DeadLetterStateParked     DeadLetterState = "parked"
+	DeadLetterStateReinjected DeadLetterState = "reinjected"
+)
+
+var (
+	ErrDeadLetterNotParked = errors.New("admin.dead_letter.not_parked")
+	ErrDeadLetterNotJSON   = errors.New("admin.dead_letter.not_json")
+)
+
+// DeadLetter is a message a consumer couldn't decode or validate, parked until an admin re-injects it to its topic
+// after a fix. A message re-injected which fails again is parked again on the same dead letter, Attempts counts the
+// times it failed.
+type DeadLetter struct {
+	ID    int64  `json:"id" gorm:"primaryKey"`
+	Topic string `json:"topic" gorm:"uniqueIndex:index_dead_letters_on_topic_and_digest"`
+	// Digest is the SHA-256 of the payload, the same message parked again finds its dead letter by it
+	Digest       string          `json:"digest" gorm:"uniqueIndex:index_dead_letters_on_topic_and_digest"`
+	Payload      []byte          `json:"payload"`
+	Error        string          `json:"error"`
+	Attempts     int             `json:"attempts" gorm:"default:1"`
+	State        DeadLetterState `json:"state" gorm:"default:parked;index"`
+	ReinjectedAt sql.NullTime    `json:"reinjected_at"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+func deadLetterDigest(payload []byte) string {
+	sum := sha256.Sum256(payload)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// RecordDeadLetter keeps a message parked by a consumer. A message parked before is parked again with the error it
+// failed on this time.
+func RecordDeadLetter(parked *events.DeadLetter) (*DeadLetter, error) {
+	dead_letter := &DeadLetter{
+		Topic:   parked.Topic,
+		Digest:  deadLetterDigest(parked.Payload),
+		Payload: parked.Payload,
+		Error:   parked.Error,
+		State:   DeadLetterStateParked,
+	}
+
+	err := config.DataBase.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(dead_letter)
+		if result.Error != nil || result.RowsAffected > 0 {
+			return result.Error
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("topic = ? AND digest = ?", dead_letter.Topic, dead_letter.Digest).
+			First(dead_letter).Error; err != nil {
+			return err
+		}
+
+		// the broker delivers the dead letter again after a crash, it's only another attempt once it was re-injected
+		if dead_letter.State == DeadLetterStateParked {
+			return nil
+		}
+
+		dead_letter.Attempts++
+		dead_letter.Error = parked.Error
+		dead_letter.State = DeadLetterStateParked
+
+		return tx.Save(dead_letter).Error
+	})
+
+	return dead_letter, err
+}
+
+// ReinjectDeadLetter produces the payload of a parked dead letter to the topic it was consumed from. The consumers
+// only take JSON, a payload which isn't can't be re-injected.
+func ReinjectDeadLetter(id int64) (*DeadLetter, error) {
+	var dead_letter *DeadLetter
+
+	err := config.DataBase.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&dead_letter, "id = ?", id).Error; err != nil {
+			return err
+		}
+
+		if dead_letter.State != DeadLetterStateParked {
+			return ErrDeadLetterNotParked
+		}
+
+		if !json.Valid(dead_letter.Payload) {
+			return ErrDeadLetterNotJSON
+		}
+
+		dead_letter.State = DeadLetterStateReinjected
+		dead_letter.ReinjectedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		if err := tx.Save(dead_letter).Error; err != nil {
+			return err
+		}
+
+		// produced last, a failure rolls the dead letter back to parked
+		return config.Bus.Publish(dead_letter.Topic, "", json.RawMessage(dead_letter.Payload))
+	})
+
+	return dead_letter, err
+}
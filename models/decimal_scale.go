@@ -0,0 +1,228 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/decimalutil"
+)
+
+const (
+	// SchemaDecimalScale is the scale of the decimal(32,16) columns without a market or fee precision.
+	SchemaDecimalScale int32 = 16
+	// FeePrecision is the scale of the decimal(7,6) fee columns.
+	FeePrecision int32 = 6
+)
+
+// DefaultDecimalScaleEpsilon is the largest change normalization accepts when decimal_scale_epsilon isn't set.
+var DefaultDecimalScaleEpsilon = decimal.New(1, -12)
+
+var ErrDecimalScale = errors.New("decimal.scale_exceeded")
+
+// DecimalScaleError is a value which can't be stored at the scale of its column without losing more than the epsilon,
+// it points to a rounding missing upstream.
+type DecimalScaleError struct {
+	Model string
+	Field string
+	Value decimal.Decimal
+	Scale int32
+}
+
+func (e *DecimalScaleError) Error() string {
+	return fmt.Sprintf("%s: %s.%s %s exceeds scale %d", ErrDecimalScale, e.Model, e.Field, e.Value, e.Scale)
+}
+
+func (e *DecimalScaleError) Unwrap() error {
+	return ErrDecimalScale
+}
+
+func decimalScaleEpsilon() decimal.Decimal {
+	if config.DecimalScaleEpsilon.IsPositive() {
+		return config.DecimalScaleEpsilon
+	}
+
+	return DefaultDecimalScaleEpsilon
+}
+
+// NormalizeDecimal rounds value to scale, ok is false when the rounding changes it by more than epsilon.
+func NormalizeDecimal(value decimal.Decimal, scale int32, epsilon decimal.Decimal) (normalized decimal.Decimal, ok bool) {
+	normalized = decimalutil.Round(value, scale, decimalutil.HalfUp)
+
+	return normalized, value.Sub(normalized).Abs().LessThanOrEqual(epsilon)
+}
+
+// decimalNormalizer rounds the decimal fields of a model before it's saved, keeping the first field which failed.
+type decimalNormalizer struct {
+	model   string
+	epsilon decimal.Decimal
+	err     error
+}
+
+func newDecimalNormalizer(model string) *decimalNormalizer {
+	return &decimalNormalizer{model: model, epsilon: decimalScaleEpsilon()}
+}
+
+func (n *decimalNormalizer) field(name string, value *decimal.Decimal, scale int32) {
+	// unset fields stay unset, gorm skips them on updates
+	if *value == (decimal.Decimal{}) {
+		return
+	}
+
+	normalized, ok := NormalizeDecimal(*value, scale, n.epsilon)
+	if !ok {
+		if n.err == nil {
+			n.err = &DecimalScaleError{Model: n.model, Field: name, Value: *value, Scale: scale}
+		}
+		return
+	}
+
+	*value = normalized
+}
+
+func (n *decimalNormalizer) nullField(name string, value *decimal.NullDecimal, scale int32) {
+	if value.Valid {
+		n.field(name, &value.Decimal, scale)
+	}
+}
+
+// Err returns the error of the first field which couldn't be normalized, it's logged as it means a rounding bug.
+func (n *decimalNormalizer) Err() error {
+	if n.err != nil {
+		config.Logger.Errorf("Refused to save %s: %v", n.model, n.err)
+	}
+
+	return n.err
+}
+
+func (o *Order) normalizeDecimals(market *Market) error {
+	n := newDecimalNormalizer("order")
+	n.nullField("price", &o.Price, int32(market.PricePrecision))
+	n.nullField("stop_price", &o.StopPrice, int32(market.PricePrecision))
+	n.field("volume", &o.Volume, int32(market.AmountPrecision))
+	n.field("origin_volume", &o.OriginVolume, int32(market.AmountPrecision))
+	n.field("maker_fee", &o.MakerFee, FeePrecision)
+	n.field("taker_fee", &o.TakerFee, FeePrecision)
+	n.field("locked", &o.Locked, SchemaDecimalScale)
+	n.field("origin_locked", &o.OriginLocked, SchemaDecimalScale)
+	n.field("funds_received", &o.FundsReceived, SchemaDecimalScale)
+	n.field("funds_traded", &o.FundsTraded, SchemaDecimalScale)
+	n.field("avg_price", &o.AvgPrice, int32(market.PricePrecision))
+
+	return n.Err()
+}
+
+func (t *Trade) normalizeDecimals(market *Market) error {
+	n := newDecimalNormalizer("trade")
+	n.field("price", &t.Price, int32(market.PricePrecision))
+	n.field("amount", &t.Amount, int32(market.AmountPrecision))
+	n.field("total", &t.Total, SchemaDecimalScale)
+
+	return n.Err()
+}
+
+// BeforeSave rounds the trade to the precisions of its market.
+func (t *Trade) BeforeSave(tx *gorm.DB) error {
+	if len(t.MarketID) == 0 {
+		return nil
+	}
+
+	var market *Market
+	if result := tx.Session(&gorm.Session{NewDB: true}).First(&market, "symbol = ?", t.MarketID); result.Error != nil {
+		return nil
+	}
+
+	return t.normalizeDecimals(market)
+}
+
+func (l *Liability) BeforeSave(tx *gorm.DB) error {
+	n := newDecimalNormalizer("liability")
+	n.field("debit", &l.Debit, SchemaDecimalScale)
+	n.field("credit", &l.Credit, SchemaDecimalScale)
+
+	return n.Err()
+}
+
+func (r *Revenue) BeforeSave(tx *gorm.DB) error {
+	n := newDecimalNormalizer("revenue")
+	n.field("debit", &r.Debit, SchemaDecimalScale)
+	n.field("credit", &r.Credit, SchemaDecimalScale)
+
+	return n.Err()
+}
+
+func (c *Commission) BeforeSave(tx *gorm.DB) error {
+	n := newDecimalNormalizer("commission")
+	n.field("earn_amount", &c.EarnAmount, SchemaDecimalScale)
+
+	return n.Err()
+}
+
+func (f *TradingFee) BeforeSave(tx *gorm.DB) error {
+	n := newDecimalNormalizer("trading_fee")
+	n.field("maker", &f.Maker, FeePrecision)
+	n.field("taker", &f.Taker, FeePrecision)
+
+	return n.Err()
+}
+
+// normalizeAccountFunds rounds the balances an account is updated to, accounts are updated from a copy so hooks don't apply.
+func normalizeAccountFunds(funds Account) (Account, error) {
+	n := newDecimalNormalizer("account")
+	n.field("balance", &funds.Balance, SchemaDecimalScale)
+	n.field("locked", &funds.Locked, SchemaDecimalScale)
+
+	return funds, n.Err()
+}
+
+// DecimalScaleAudit is a column checked by the decimal audit, Scale is the SQL expression of its scale.
+type DecimalScaleAudit struct {
+	Table  string
+	Column string
+	Scale  string
+	Join   string
+}
+
+var marketJoin = "JOIN markets ON markets.symbol = %s.market_id"
+
+// DecimalScaleAudits are the columns normalized on save, with the scale the hooks round them to.
+var DecimalScaleAudits = []*DecimalScaleAudit{
+	{"orders", "price", "markets.price_precision", marketJoin},
+	{"orders", "stop_price", "markets.price_precision", marketJoin},
+	{"orders", "volume", "markets.amount_precision", marketJoin},
+	{"orders", "origin_volume", "markets.amount_precision", marketJoin},
+	{"orders", "maker_fee", fmt.Sprint(FeePrecision), ""},
+	{"orders", "taker_fee", fmt.Sprint(FeePrecision), ""},
+	{"orders", "locked", fmt.Sprint(SchemaDecimalScale), ""},
+	{"orders", "origin_locked", fmt.Sprint(SchemaDecimalScale), ""},
+	{"orders", "funds_received", fmt.Sprint(SchemaDecimalScale), ""},
+	{"orders", "funds_traded", fmt.Sprint(SchemaDecimalScale), ""},
+	{"orders", "avg_price", "markets.price_precision", marketJoin},
+	{"trades", "price", "markets.price_precision", marketJoin},
+	{"trades", "amount", "markets.amount_precision", marketJoin},
+	{"trades", "total", fmt.Sprint(SchemaDecimalScale), ""},
+	{"liabilities", "debit", fmt.Sprint(SchemaDecimalScale), ""},
+	{"liabilities", "credit", fmt.Sprint(SchemaDecimalScale), ""},
+	{"revenues", "debit", fmt.Sprint(SchemaDecimalScale), ""},
+	{"revenues", "credit", fmt.Sprint(SchemaDecimalScale), ""},
+	{"commissions", "earn_amount", fmt.Sprint(SchemaDecimalScale), ""},
+	{"trading_fees", "maker", fmt.Sprint(FeePrecision), ""},
+	{"trading_fees", "taker", fmt.Sprint(FeePrecision), ""},
+	{"accounts", "balance", fmt.Sprint(SchemaDecimalScale), ""},
+	{"accounts", "locked", fmt.Sprint(SchemaDecimalScale), ""},
+}
+
+// Query returns the rows of the column which aren't rounded to its scale.
+func (a *DecimalScaleAudit) Query(tx *gorm.DB) *gorm.DB {
+	column := a.Table + "." + a.Column
+
+	tx = tx.Table(a.Table)
+	if len(a.Join) > 0 {
+		tx = tx.Joins(fmt.Sprintf(a.Join, a.Table))
+	}
+
+	return tx.Where(fmt.Sprintf("%s IS NOT NULL AND ROUND(%s, %s) <> %s", column, column, a.Scale, column))
+}
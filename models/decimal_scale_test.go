@@ -0,0 +1,179 @@
+package models
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+
+	"github.com/zsmartex/finex/config"
+)
+
+func init() {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	config.Logger = logrus.NewEntry(logger)
+}
+
+// noise is the kind of tail intermediate math leaves on a value
+const noise = "0.0000000000000000000000000001"
+
+func withNoise(value string) decimal.Decimal {
+	return decimal.RequireFromString(value).Add(decimal.RequireFromString(noise))
+}
+
+func assertScaleError(t *testing.T, err error, field string) {
+	t.Helper()
+
+	var scale_err *DecimalScaleError
+	if !errors.As(err, &scale_err) || !errors.Is(err, ErrDecimalScale) {
+		t.Fatalf("got %v, want a scale error", err)
+	}
+
+	if scale_err.Field != field {
+		t.Errorf("got an error on %s, want %s", scale_err.Field, field)
+	}
+}
+
+func TestNormalizeDecimal(t *testing.T) {
+	d := decimal.RequireFromString
+
+	tests := []struct {
+		value   decimal.Decimal
+		scale   int32
+		want    string
+		epsilon bool
+	}{
+		{withNoise("1.5"), 2, "1.5", true},
+		{d("1.23"), 2, "1.23", true},
+		{d("1.235"), 2, "1.24", false},
+		{d("0.0000000000000000004"), 16, "0", true},
+	}
+
+	for _, tt := range tests {
+		got, ok := NormalizeDecimal(tt.value, tt.scale, DefaultDecimalScaleEpsilon)
+		if !got.Equal(d(tt.want)) || ok != tt.epsilon {
+			t.Errorf("NormalizeDecimal(%s, %d) = %s %t, want %s %t", tt.value, tt.scale, got, ok, tt.want, tt.epsilon)
+		}
+	}
+}
+
+func TestOrderNormalizeDecimals(t *testing.T) {
+	d := decimal.RequireFromString
+	market := &Market{PricePrecision: 2, AmountPrecision: 4}
+
+	order := &Order{
+		Price:         decimal.NewNullDecimal(withNoise("10.25")),
+		StopPrice:     decimal.NullDecimal{},
+		Volume:        withNoise("1.5"),
+		OriginVolume:  withNoise("2"),
+		MakerFee:      withNoise("0.001"),
+		TakerFee:      withNoise("0.002"),
+		Locked:        withNoise("20.5"),
+		OriginLocked:  withNoise("20.5"),
+		FundsReceived: withNoise("0.5"),
+		FundsTraded:   withNoise("10.25"),
+		AvgPrice:      withNoise("10.25"),
+	}
+
+	if err := order.normalizeDecimals(market); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, got := range map[string]decimal.Decimal{
+		"price":          order.Price.Decimal,
+		"volume":         order.Volume,
+		"origin_volume":  order.OriginVolume,
+		"maker_fee":      order.MakerFee,
+		"taker_fee":      order.TakerFee,
+		"locked":         order.Locked,
+		"origin_locked":  order.OriginLocked,
+		"funds_received": order.FundsReceived,
+		"funds_traded":   order.FundsTraded,
+	} {
+		if got.Exponent() < -16 {
+			t.Errorf("%s kept scale %d", name, -got.Exponent())
+		}
+	}
+
+	if order.StopPrice.Valid {
+		t.Error("the stop price was set")
+	}
+
+	if !order.Price.Decimal.Equal(d("10.25")) || order.Price.Decimal.Exponent() < -2 || order.Volume.Exponent() < -4 || order.TakerFee.Exponent() < -6 || order.AvgPrice.Exponent() < -2 {
+		t.Errorf("got price %s volume %s taker fee %s avg price %s", order.Price.Decimal, order.Volume, order.TakerFee, order.AvgPrice)
+	}
+
+	// a price with more decimals than the market accepts wasn't rounded upstream
+	order.Price = decimal.NewNullDecimal(d("10.255"))
+	assertScaleError(t, order.normalizeDecimals(market), "price")
+}
+
+func TestTradeNormalizeDecimals(t *testing.T) {
+	market := &Market{PricePrecision: 2, AmountPrecision: 4}
+
+	trade := &Trade{Price: withNoise("10.25"), Amount: withNoise("1.5"), Total: withNoise("15.375")}
+	if err := trade.normalizeDecimals(market); err != nil {
+		t.Fatal(err)
+	}
+
+	if trade.Price.Exponent() < -2 || trade.Amount.Exponent() < -4 || trade.Total.Exponent() < -16 {
+		t.Errorf("got price %s amount %s total %s", trade.Price, trade.Amount, trade.Total)
+	}
+
+	trade.Amount = decimal.RequireFromString("1.00005")
+	assertScaleError(t, trade.normalizeDecimals(market), "amount")
+}
+
+func TestOperationsNormalizeDecimals(t *testing.T) {
+	liability := &Liability{Debit: withNoise("1"), Credit: decimal.Zero}
+	if err := liability.BeforeSave(nil); err != nil || liability.Debit.Exponent() < -16 {
+		t.Errorf("liability: %v debit %s", err, liability.Debit)
+	}
+
+	// the schema scale only loses more than the epsilon when it's configured below 5e-17
+	config.DecimalScaleEpsilon = decimal.New(1, -20)
+	defer func() { config.DecimalScaleEpsilon = decimal.Decimal{} }()
+
+	liability.Credit = decimal.RequireFromString("0.00000000001234567891")
+	assertScaleError(t, liability.BeforeSave(nil), "credit")
+	config.DecimalScaleEpsilon = decimal.Decimal{}
+
+	revenue := &Revenue{Credit: withNoise("0.25")}
+	if err := revenue.BeforeSave(nil); err != nil || revenue.Credit.Exponent() < -16 {
+		t.Errorf("revenue: %v credit %s", err, revenue.Credit)
+	}
+
+	commission := &Commission{EarnAmount: withNoise("0.0001")}
+	if err := commission.BeforeSave(nil); err != nil || commission.EarnAmount.Exponent() < -16 {
+		t.Errorf("commission: %v earn amount %s", err, commission.EarnAmount)
+	}
+}
+
+func TestTradingFeeNormalizeDecimals(t *testing.T) {
+	fee := &TradingFee{Maker: withNoise("0.001"), Taker: withNoise("0.0015")}
+	if err := fee.BeforeSave(nil); err != nil || fee.Maker.Exponent() < -6 || fee.Taker.Exponent() < -6 {
+		t.Errorf("got %v maker %s taker %s", err, fee.Maker, fee.Taker)
+	}
+
+	fee.Taker = decimal.RequireFromString("0.0015001")
+	assertScaleError(t, fee.BeforeSave(nil), "taker")
+}
+
+func TestAccountNormalizeFunds(t *testing.T) {
+	funds, err := normalizeAccountFunds(Account{Balance: withNoise("3")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if funds.Balance.Exponent() < -16 {
+		t.Errorf("balance kept scale %d", -funds.Balance.Exponent())
+	}
+
+	// locked isn't part of the update and must stay unset
+	if funds.Locked != (decimal.Decimal{}) {
+		t.Errorf("locked was set to %s", funds.Locked)
+	}
+}
@@ -0,0 +1,231 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/decimalutil"
+	"github.com/zsmartex/finex/types"
+)
+
+// FeeKickbackTradeReferenceIndex is the unique index of the active kickbacks on their trade reference, a friend
+// gets its fee back once per leg of a trade.
+const FeeKickbackTradeReferenceIndex = "index_fee_kickbacks_on_trade_reference"
+
+// FeeKickback is the part of its own fee paid back to a referred friend during its first days, besides the
+// commission of its referrer. Kickbacks are released with the commissions, in CommissionSettlementCurrency.
+type FeeKickback struct {
+	ID         int64           `json:"id" gorm:"primaryKey"`
+	MemberID   int64           `json:"member_id" gorm:"uniqueIndex:index_fee_kickbacks_on_trade_reference,where:state = 'active'"`
+	TradeID    int64           `json:"trade_id" gorm:"uniqueIndex:index_fee_kickbacks_on_trade_reference"`
+	CurrencyID string          `json:"currency_id" gorm:"uniqueIndex:index_fee_kickbacks_on_trade_reference"`
+	Amount     decimal.Decimal `json:"amount"`
+	// State is void once the trade is reverted, like the commissions
+	State     CommissionState `json:"state" gorm:"default:active"`
+	VoidedAt  sql.NullTime    `json:"voided_at"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	RoundingAudit
+}
+
+// feeKickbackConfig is the kickback of the referral config, none when referrals aren't configured.
+func feeKickbackConfig() types.ReferralKickback {
+	if config.Referral == nil {
+		return types.ReferralKickback{}
+	}
+
+	return config.Referral.Kickback
+}
+
+// FeeKickbackEnds returns when the kickbacks of a friend registered at registered_at end, after the days of the
+// kickback counted in UTC days from the day of the registration.
+func FeeKickbackEnds(registered_at time.Time, days int) time.Time {
+	return registered_at.UTC().Truncate(24*time.Hour).AddDate(0, 0, days)
+}
+
+// FeeKickbackEligible reports whether a friend registered at registered_at gets a kickback on a trade at traded_at.
+func FeeKickbackEligible(registered_at, traded_at time.Time) bool {
+	kickback := feeKickbackConfig()
+	if !kickback.Rate.IsPositive() || kickback.Days <= 0 {
+		return false
+	}
+
+	return traded_at.Before(FeeKickbackEnds(registered_at, kickback.Days))
+}
+
+// registeredAt is when the member registered, sub-accounts share the registration of their parent.
+func (m *Member) registeredAt() time.Time {
+	if parent := m.Parent(); parent != nil {
+		return parent.CreatedAt
+	}
+
+	return m.CreatedAt
+}
+
+// createFeeKickback inserts a kickback unless its trade reference already has one, it reports whether it was created.
+func createFeeKickback(tx *gorm.DB, kickback *FeeKickback) (bool, error) {
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(kickback)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		config.Logger.Warnf("Skipped a duplicate fee kickback of member %d on trade %d in %s", kickback.MemberID, kickback.TradeID, kickback.CurrencyID)
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// recordKickback pays the referred member of the order the kickback of its fee, computed from the exact fee
+// the member paid, and returns what's left of fee.
+func (t *Trade) recordKickback(fee Rounded, fee_exact decimal.Decimal, order *Order, tx *gorm.DB) (Rounded, error) {
+	member := order.Member()
+	if !member.HavingReferraller() || !FeeKickbackEligible(member.registeredAt(), t.CreatedAt) {
+		return fee, nil
+	}
+
+	kickback := RoundHalfUp(fee_exact.Mul(feeKickbackConfig().Rate), referralRewardPrecision)
+	if !kickback.Value.IsPositive() {
+		return fee, nil
+	}
+
+	created, err := createFeeKickback(tx, &FeeKickback{
+		MemberID:      member.ID,
+		TradeID:       t.ID,
+		CurrencyID:    order.IncomeCurrency().ID,
+		Amount:        kickback.Value,
+		RoundingAudit: NewRoundingAudit(kickback, RoundingPathReferralKickback),
+	})
+	if err != nil {
+		return fee, err
+	}
+
+	// the kickback of a trade executed again was paid the first time
+	if created {
+		if err := member.GetAccount(order.IncomeCurrency()).PlusFunds(tx, kickback.Value); err != nil {
+			return fee, err
+		}
+
+		if err := RevenueFeeDebit(tx, RevenueKindKickback, kickback.Value, order.IncomeCurrency(), t, order.MemberID); err != nil {
+			return fee, err
+		}
+	}
+
+	return fee.Less(kickback.Value), nil
+}
+
+// FeeKickbacksBTC values kickbacks in BTC at the stored prices of their currencies, rounded down like a payout.
+func FeeKickbacksBTC(kickbacks []*FeeKickback, prices []*MarketPrice) (decimal.Decimal, error) {
+	value := decimal.Zero
+
+	for _, kickback := range kickbacks {
+		amount, err := settlementValue(kickback.CurrencyID, kickback.Amount, prices)
+		if err != nil {
+			return decimal.Zero, err
+		}
+
+		value = value.Add(amount)
+	}
+
+	return decimalutil.RoundPayout(value, 8), nil
+}
+
+// PendingKickbackReleases returns the unsaved releases of the kickbacks paid on day to the members whose kickbacks
+// of that day weren't released yet, dated at CommissionReleaseAt like the releases of the commissions.
+func PendingKickbackReleases(tx *gorm.DB, day time.Time, prices []*MarketPrice) ([]*ReleaseCommission, error) {
+	from := pendingReleasesSince(day)
+	release_at := CommissionReleaseAt(day)
+
+	released := tx.
+		Model(&ReleaseCommission{}).
+		Select("member_id").
+		Where("kind = ? AND created_at >= ? AND created_at < ?", ReleaseCommissionKindKickback, release_at, release_at.AddDate(0, 0, 1))
+
+	var member_ids []int64
+	if result := tx.
+		Model(&FeeKickback{}).
+		Where("state = ? AND created_at >= ? AND created_at < ?", CommissionStateActive, from, release_at).
+		Where("member_id NOT IN (?)", released).
+		Distinct("member_id").
+		Order("member_id").
+		Pluck("member_id", &member_ids); result.Error != nil {
+		return nil, result.Error
+	}
+
+	releases := make([]*ReleaseCommission, 0, len(member_ids))
+	for _, member_id := range member_ids {
+		var kickbacks []*FeeKickback
+		if result := tx.Where("member_id = ? AND state = ? AND created_at >= ? AND created_at < ?", member_id, CommissionStateActive, from, release_at).Find(&kickbacks); result.Error != nil {
+			return nil, result.Error
+		}
+
+		// a member whose kickbacks can't be valued is released by a later run once the prices are there
+		earned_btc, err := FeeKickbacksBTC(kickbacks, prices)
+		if err != nil {
+			config.Logger.Errorf("Failed to release the fee kickbacks of member %d: %v", member_id, err)
+			continue
+		}
+
+		releases = append(releases, &ReleaseCommission{
+			AccountType: types.AccountTypeSpot,
+			MemberID:    member_id,
+			Kind:        ReleaseCommissionKindKickback,
+			EarnedBTC:   earned_btc,
+			CreatedAt:   release_at,
+		})
+	}
+
+	return releases, nil
+}
+
+// FeeKickbackStats is the kickback program of a referred member.
+type FeeKickbackStats struct {
+	Eligible bool
+	Rate     decimal.Decimal
+	// EndsAt is when the member stops getting its fees back
+	EndsAt time.Time
+	// Kickbacks are the active kickbacks paid to the member per currency
+	Kickbacks []*ReferralCodeEarning
+}
+
+// FeeKickbackStats returns the kickback program of the member at now.
+func (m *Member) FeeKickbackStats(now time.Time) *FeeKickbackStats {
+	kickback := feeKickbackConfig()
+	stats := &FeeKickbackStats{
+		Rate:      kickback.Rate,
+		Kickbacks: make([]*ReferralCodeEarning, 0),
+	}
+
+	if m.HavingReferraller() {
+		registered_at := m.registeredAt()
+		stats.EndsAt = FeeKickbackEnds(registered_at, kickback.Days)
+		stats.Eligible = FeeKickbackEligible(registered_at, now)
+	}
+
+	config.DataBase.
+		Model(&FeeKickback{}).
+		Select("currency_id, SUM(amount) AS amount").
+		Where("member_id = ? AND state = ?", m.ID, CommissionStateActive).
+		Group("currency_id").
+		Order("currency_id asc").
+		Scan(&stats.Kickbacks)
+
+	return stats
+}
+
+// releasedKickbackNote is the note of a reverted kickback, like the commissions it's adjusted on the next release
+// once it was released.
+func releasedKickbackNote(kickback *FeeKickback, now time.Time) string {
+	if CommissionReleased(kickback.CreatedAt, now) {
+		return "released fee kickback voided, adjusted on the next release"
+	}
+
+	return "fee kickback voided before its release"
+}
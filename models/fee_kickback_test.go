@@ -0,0 +1,85 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+func withFeeKickback(t *testing.T, rate string, days int) {
+	previous := config.Referral
+	config.Referral = &types.Referral{Kickback: types.ReferralKickback{Rate: decimal.RequireFromString(rate), Days: days}}
+	t.Cleanup(func() { config.Referral = previous })
+}
+
+func TestFeeKickbackEligibleBoundaryDay(t *testing.T) {
+	withFeeKickback(t, "0.1", 90)
+
+	tehran := time.FixedZone("IRST", 3*3600+1800)
+	tests := []struct {
+		name          string
+		registered_at time.Time
+		traded_at     time.Time
+		eligible      bool
+	}{
+		{"registration day", time.Date(2022, 1, 1, 23, 30, 0, 0, time.UTC), time.Date(2022, 1, 1, 23, 31, 0, 0, time.UTC), true},
+		{"last second of the 90th day", time.Date(2022, 1, 1, 23, 30, 0, 0, time.UTC), time.Date(2022, 3, 31, 23, 59, 59, 0, time.UTC), true},
+		{"first second of the 91st day", time.Date(2022, 1, 1, 23, 30, 0, 0, time.UTC), time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC), false},
+		// registered on the 2nd in Tehran, the 1st in UTC
+		{"registration day in UTC", time.Date(2022, 1, 2, 1, 0, 0, 0, tehran), time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC), false},
+		{"trade time in another zone", time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC), time.Date(2022, 4, 1, 2, 0, 0, 0, tehran), true},
+	}
+
+	for _, test := range tests {
+		if eligible := FeeKickbackEligible(test.registered_at, test.traded_at); eligible != test.eligible {
+			t.Errorf("%s: expected eligible %v, got %v", test.name, test.eligible, eligible)
+		}
+	}
+
+	if ends := FeeKickbackEnds(time.Date(2022, 1, 2, 1, 0, 0, 0, tehran), 90); !ends.Equal(time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the kickbacks to end on April 1st in UTC, got %v", ends)
+	}
+}
+
+func TestFeeKickbackDisabled(t *testing.T) {
+	registered_at := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	withFeeKickback(t, "0", 90)
+	if FeeKickbackEligible(registered_at, registered_at) {
+		t.Error("expected no kickback at a zero rate")
+	}
+
+	config.Referral = nil
+	if FeeKickbackEligible(registered_at, registered_at) {
+		t.Error("expected no kickback without a referral config")
+	}
+}
+
+func TestFeeKickbacksBTC(t *testing.T) {
+	d := decimal.RequireFromString
+	prices := []*MarketPrice{
+		{BaseUnit: "btc", QuoteUnit: "usdt", Price: d("25000")},
+		{BaseUnit: "eth", QuoteUnit: "btc", Price: d("0.05")},
+	}
+
+	value, err := FeeKickbacksBTC([]*FeeKickback{
+		{CurrencyID: "usdt", Amount: d("50")},
+		{CurrencyID: "eth", Amount: d("0.2")},
+		{CurrencyID: "btc", Amount: d("0.00000001")},
+	}, prices)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !value.Equal(d("0.01200001")) {
+		t.Errorf("expected 0.01200001 BTC, got %s", value)
+	}
+
+	if _, err := FeeKickbacksBTC([]*FeeKickback{{CurrencyID: "doge", Amount: d("1")}}, prices); err == nil {
+		t.Error("expected a kickback without a price to fail")
+	}
+}
@@ -0,0 +1,78 @@
+//go:build integration
+
+package models
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+// The test needs the DATABASE_* variables of a disposable database and KAFKA_URL for the events of the orders:
+//
+//	go test -tags integration -run 'FundsLock' ./models
+func TestFundsLockOfSimultaneousSubmits(t *testing.T) {
+	setupDelistingDatabase(t)
+
+	db := config.DataBase
+	db.Where("symbol = ?", "flkusdt").Delete(&Market{})
+	db.Where("id = ?", "flk").Delete(&Currency{})
+	db.Where("id = ?", 51).Delete(&Member{})
+	db.Where("member_id = ?", 51).Delete(&Account{})
+
+	db.Create(&Market{Symbol: "flkusdt", BaseUnit: "flk", QuoteUnit: "usdt", AmountPrecision: 4, PricePrecision: 2, State: string(types.MarketStateEndabled)})
+	db.Create(&[]*Currency{{ID: "flk", Type: "coin"}, {ID: "usdt", Type: "coin"}})
+	db.Create(&Member{ID: 51, UID: "ID51"})
+	db.Create(&Account{MemberID: 51, CurrencyID: "usdt", Balance: decimal.NewFromInt(100)})
+
+	// two API processes both accepted an order of 60 against the balance of 100
+	orders := make([]*Order, 2)
+	for i := range orders {
+		orders[i] = &Order{
+			ID: int64(9100 + i), MemberID: 51, Ask: "flk", Bid: "usdt", MarketID: "flkusdt", Type: SideBuy, OrdType: types.TypeLimit,
+			Price: decimal.NewNullDecimal(decimal.NewFromInt(60)), Volume: decimal.NewFromInt(1), OriginVolume: decimal.NewFromInt(1),
+			Locked: decimal.NewFromInt(60), OriginLocked: decimal.NewFromInt(60), State: StatePending,
+		}
+
+		if err := db.Create(orders[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, order := range orders {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			<-start
+
+			if err := SubmitOrder(id); err != nil {
+				t.Errorf("failed to submit order %d: %v", id, err)
+			}
+		}(order.ID)
+	}
+
+	close(start)
+	wg.Wait()
+
+	states := map[OrderState]int{}
+	for _, order := range orders {
+		db.First(order, order.ID)
+		states[order.State]++
+	}
+
+	if states[StateWait] != 1 || states[StateReject] != 1 {
+		t.Errorf("expected one order to wait and the other rejected, got %v", states)
+	}
+
+	var account *Account
+	db.Where("member_id = ? AND currency_id = ?", 51, "usdt").First(&account)
+	if !account.Balance.Equal(decimal.NewFromInt(40)) || !account.Locked.Equal(decimal.NewFromInt(60)) {
+		t.Errorf("expected 60 locked out of 100, got a balance of %s and %s locked", account.Balance, account.Locked)
+	}
+}
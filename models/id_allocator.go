@@ -0,0 +1,124 @@
+package models
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// DefaultIDBlockSize is the number of ids a process leases at once when order_ids.block_size isn't set.
+const DefaultIDBlockSize = 1000
+
+var ErrIDLease = errors.New("id_sequence.lease_failed")
+
+// IDSequence is the next id a process can lease a block of ids of a table from.
+type IDSequence struct {
+	Name      string `gorm:"primaryKey"`
+	NextID    int64
+	UpdatedAt time.Time
+}
+
+// IDLease leases the block of ids [first, first+size) for the process, no other lease ever returns them.
+type IDLease func(size int64) (first int64, err error)
+
+// IDAllocator assigns ids from blocks leased from a sequence, without a database round trip for every id.
+// A block is only used by the process which leased it, so ids stay unique across processes and restarts,
+// the ids left in the block of a process which stops are never used.
+type IDAllocator struct {
+	mutex sync.Mutex
+	size  int64
+	lease IDLease
+	next  int64
+	limit int64
+}
+
+func NewIDAllocator(size int64, lease IDLease) *IDAllocator {
+	if size <= 0 {
+		size = DefaultIDBlockSize
+	}
+
+	return &IDAllocator{size: size, lease: lease}
+}
+
+// Next returns the next id of the current block, a new block is leased once it's used up.
+func (a *IDAllocator) Next() (int64, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.next >= a.limit {
+		first, err := a.lease(a.size)
+		if err != nil {
+			return 0, err
+		}
+
+		a.next = first
+		a.limit = first + a.size
+	}
+
+	id := a.next
+	a.next++
+
+	return id, nil
+}
+
+// LeaseIDBlock moves the sequence of table past a block of size ids and returns the first one. The sequence starts
+// after the greatest id of the table, so the rows inserted before the sequence existed are never collided with.
+func LeaseIDBlock(tx *gorm.DB, table string, size int64) (int64, error) {
+	var first int64
+
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		var sequence *IDSequence
+		result := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("name = ?", table).
+			Limit(1).
+			Find(&sequence)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			var max_id int64
+			if result := tx.Table(table).Select("COALESCE(MAX(id), 0)").Scan(&max_id); result.Error != nil {
+				return result.Error
+			}
+
+			sequence = &IDSequence{Name: table, NextID: max_id + 1}
+			// a process leasing the first block at the same time makes this fail, it leases again after
+			if result := tx.Create(&sequence); result.Error != nil {
+				return result.Error
+			}
+		}
+
+		first = sequence.NextID
+		sequence.NextID += size
+
+		return tx.Save(&sequence).Error
+	})
+	if err != nil {
+		config.Logger.Errorf("Failed to lease %d ids of %s: %v", size, table, err)
+
+		return 0, ErrIDLease
+	}
+
+	return first, nil
+}
+
+var orderIDs *IDAllocator
+var orderIDsOnce sync.Once
+
+// NextOrderID assigns the id of an order without inserting it.
+func NextOrderID() (int64, error) {
+	orderIDsOnce.Do(func() {
+		orderIDs = NewIDAllocator(int64(config.OrderIDs.BlockSize), func(size int64) (int64, error) {
+			return LeaseIDBlock(config.DataBase, "orders", size)
+		})
+	})
+
+	return orderIDs.Next()
+}
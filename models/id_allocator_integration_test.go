@@ -0,0 +1,182 @@
+//go:build integration
+
+package models
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+// The tests need the DATABASE_* variables of a disposable database with the orders table, the benchmarks compare
+// the placements with and without async_persist:
+//
+//	go test -tags integration -run 'TestLeaseIDBlock' -bench 'OrderID' ./models
+func setupIDSequenceDatabase(tb testing.TB) {
+	if len(os.Getenv("DATABASE_HOST")) == 0 {
+		tb.Skip("DATABASE_HOST isn't set")
+	}
+
+	db, err := config.NewDatabase()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&IDSequence{}); err != nil {
+		tb.Fatal(err)
+	}
+
+	db.Where("name = ?", "orders").Delete(&IDSequence{})
+
+	config.DataBase = db
+	config.OrderIDs = &types.OrderIDsConfig{}
+}
+
+// Processes leasing at the same time, and restarted in the middle of their blocks, never get the same id.
+func TestLeaseIDBlockConcurrentRestarts(t *testing.T) {
+	setupIDSequenceDatabase(t)
+
+	var mutex sync.Mutex
+	used := make(map[int64]bool)
+
+	var wg sync.WaitGroup
+	for process := 0; process < 8; process++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for restart := 0; restart < 5; restart++ {
+				allocator := NewIDAllocator(50, func(size int64) (int64, error) {
+					return LeaseIDBlock(config.DataBase, "orders", size)
+				})
+
+				// every process crashes after using part of its block
+				for i := 0; i < 30; i++ {
+					id, err := allocator.Next()
+					if err != nil {
+						// two processes creating the first sequence row, the loser leases again
+						id, err = allocator.Next()
+					}
+
+					if err != nil {
+						t.Error(err)
+						return
+					}
+
+					mutex.Lock()
+					if used[id] {
+						t.Errorf("id %d assigned twice", id)
+					}
+					used[id] = true
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var max_order_id int64
+	config.DataBase.Table("orders").Select("COALESCE(MAX(id), 0)").Scan(&max_order_id)
+	for id := range used {
+		if id <= max_order_id {
+			t.Fatalf("id %d collides with the orders inserted before the sequence", id)
+		}
+	}
+}
+
+func benchmarkOrder(member_id int64) *Order {
+	return &Order{
+		UUID:         uuid.New(),
+		MemberID:     member_id,
+		MarketID:     "btcusdt",
+		Ask:          "btc",
+		Bid:          "usdt",
+		OrdType:      types.TypeLimit,
+		State:        StatePending,
+		Type:         SideBuy,
+		Price:        decimal.NewNullDecimal(decimal.NewFromInt(30000)),
+		Volume:       decimal.NewFromInt(1),
+		OriginVolume: decimal.NewFromInt(1),
+		Locked:       decimal.NewFromInt(30000),
+		OriginLocked: decimal.NewFromInt(30000),
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+}
+
+// benchmarkPlacements places b.N orders from 8 processes at once with place and reports the p50 and p99 of a placement.
+func benchmarkPlacements(b *testing.B, place func(order *Order) error) {
+	latencies := make([]time.Duration, b.N)
+
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for process := 0; process < 8; process++ {
+		wg.Add(1)
+		go func(process int) {
+			defer wg.Done()
+
+			for n := process; n < b.N; n += 8 {
+				started_at := time.Now()
+				if err := place(benchmarkOrder(int64(n%1000) + 1)); err != nil {
+					b.Error(err)
+					return
+				}
+				latencies[n] = time.Since(started_at)
+			}
+		}(process)
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	reportPercentiles(b, latencies)
+}
+
+// reportPercentiles reports the p50 and the p99 of latencies in microseconds.
+func reportPercentiles(b *testing.B, latencies []time.Duration) {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(float64(latencies[len(latencies)/2].Microseconds()), "p50-µs")
+	b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-µs")
+}
+
+// BenchmarkOrderIDInsert is the id of a placement taken from the insert of the order with the id of the orders
+// sequence, the path of the API without async_persist.
+func BenchmarkOrderIDInsert(b *testing.B) {
+	setupIDSequenceDatabase(b)
+
+	benchmarkPlacements(b, func(order *Order) error {
+		return config.DataBase.Create(&order).Error
+	})
+}
+
+// BenchmarkOrderIDLeased is the id of a placement taken from the leased block, the path of the API with
+// async_persist. The insert is left to the order processor.
+func BenchmarkOrderIDLeased(b *testing.B) {
+	setupIDSequenceDatabase(b)
+
+	benchmarkPlacements(b, func(order *Order) (err error) {
+		order.ID, err = NextOrderID()
+		return err
+	})
+}
+
+// BenchmarkOrderIDLeasedInsert is the insert of an order with a leased id, what the order processor does for the
+// API with async_persist.
+func BenchmarkOrderIDLeasedInsert(b *testing.B) {
+	setupIDSequenceDatabase(b)
+
+	benchmarkPlacements(b, func(order *Order) (err error) {
+		if order.ID, err = NextOrderID(); err != nil {
+			return err
+		}
+
+		return config.DataBase.Create(&order).Error
+	})
+}
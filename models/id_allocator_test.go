@@ -0,0 +1,125 @@
+package models
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// fakeSequence is the id sequence table shared by the processes of a test.
+type fakeSequence struct {
+	sync.Mutex
+	next   int64
+	leases int
+	fail   bool
+}
+
+func (s *fakeSequence) lease(size int64) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.fail {
+		return 0, ErrIDLease
+	}
+
+	first := s.next
+	s.next += size
+	s.leases++
+
+	return first, nil
+}
+
+func TestIDAllocatorLeasesBlocks(t *testing.T) {
+	sequence := &fakeSequence{next: 101}
+	allocator := NewIDAllocator(3, sequence.lease)
+
+	for want := int64(101); want <= 107; want++ {
+		if id, err := allocator.Next(); err != nil || id != want {
+			t.Fatalf("got (%d, %v), want %d", id, err, want)
+		}
+	}
+
+	if sequence.leases != 3 {
+		t.Errorf("expected a lease every 3 ids, got %d leases", sequence.leases)
+	}
+}
+
+// A process which crashes in the middle of its block never gets its ids back, the next process starts a new block.
+func TestIDAllocatorCrashSkipsTheTail(t *testing.T) {
+	sequence := &fakeSequence{next: 1}
+	used := make(map[int64]bool)
+
+	for restart := 0; restart < 5; restart++ {
+		allocator := NewIDAllocator(10, sequence.lease)
+
+		for i := 0; i <= restart; i++ {
+			id, err := allocator.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if used[id] {
+				t.Fatalf("id %d was used again after restart %d", id, restart)
+			}
+			used[id] = true
+		}
+	}
+
+	if sequence.next != 51 {
+		t.Errorf("expected every process to lease its own block, the sequence is at %d", sequence.next)
+	}
+}
+
+func TestIDAllocatorConcurrentProcesses(t *testing.T) {
+	sequence := &fakeSequence{next: 1}
+
+	var mutex sync.Mutex
+	used := make(map[int64]bool)
+
+	var wg sync.WaitGroup
+	for process := 0; process < 4; process++ {
+		allocator := NewIDAllocator(7, sequence.lease)
+
+		for worker := 0; worker < 4; worker++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for i := 0; i < 250; i++ {
+					id, err := allocator.Next()
+					if err != nil {
+						t.Error(err)
+						return
+					}
+
+					mutex.Lock()
+					if used[id] {
+						t.Errorf("id %d assigned twice", id)
+					}
+					used[id] = true
+					mutex.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if len(used) != 4000 {
+		t.Errorf("expected 4000 ids, got %d", len(used))
+	}
+}
+
+func TestIDAllocatorLeaseFailure(t *testing.T) {
+	sequence := &fakeSequence{next: 1, fail: true}
+	allocator := NewIDAllocator(2, sequence.lease)
+
+	if _, err := allocator.Next(); !errors.Is(err, ErrIDLease) {
+		t.Fatalf("expected the lease error, got %v", err)
+	}
+
+	// the next placement leases again once the sequence is back
+	sequence.fail = false
+	if id, err := allocator.Next(); err != nil || id != 1 {
+		t.Errorf("got (%d, %v) after the sequence came back", id, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/shopspring/decimal"
 	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/decimalutil"
 	"github.com/zsmartex/finex/types"
 )
 
@@ -68,19 +69,18 @@ func (m *IEO) PaymentCurrencies() []string {
 	return ids
 }
 
-func (m *IEO) GetPriceByParent(currency_id string) decimal.Decimal {
-	var price decimal.Decimal
-
+// GetPriceByParent returns the price of the IEO in currency_id, converted from its main payment currency at the stored prices.
+func (m *IEO) GetPriceByParent(currency_id string) (decimal.Decimal, error) {
 	if currency_id == m.MainPaymentCurrency {
-		price = m.Price
-	} else {
-		var currency *Currency
+		return m.Price, nil
+	}
 
-		config.DataBase.First(&currency, "id = ?", currency_id)
-		price = m.Price.Div(currency.Price).Round(8)
+	rate, err := ConversionRate(m.MainPaymentCurrency, currency_id, ConversionBridge(), StoredCurrencyPrices())
+	if err != nil {
+		return decimal.Zero, err
 	}
 
-	return price
+	return decimalutil.ConvertWithRate(m.Price, rate, 8), nil
 }
 
 func (m *IEO) MemberBoughtQuantity(member_id int64) decimal.Decimal {
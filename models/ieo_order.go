@@ -89,7 +89,7 @@ func SubmitIEOOrder(id int64) error {
 		order.State = StateWait
 		tx.Save(&order)
 
-		config.KafkaProducer.Produce("ieo_order_executor", order.ToJSON())
+		config.Bus.Publish("ieo_order_executor", "", order.ToJSON())
 
 		return nil
 	})
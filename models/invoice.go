@@ -0,0 +1,320 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/decimalutil"
+)
+
+// InvoicePrecision is the number of decimals every amount of an invoice is rounded to.
+const InvoicePrecision int32 = 8
+
+// roundInvoice rounds an amount of an invoice half up to InvoicePrecision.
+func roundInvoice(value decimal.Decimal) decimal.Decimal {
+	return decimalutil.Round(value, InvoicePrecision, decimalutil.HalfUp)
+}
+
+// InvoiceMonthLayout is the format of the `month` param, e.g. 2024-05.
+const InvoiceMonthLayout = "2006-01"
+
+type Invoice struct {
+	UID         string             `json:"uid"`
+	Month       string             `json:"month"`
+	From        time.Time          `json:"from"`
+	To          time.Time          `json:"to"`
+	Finalized   bool               `json:"finalized"`
+	Currencies  []*InvoiceCurrency `json:"currencies"`
+	Fees        []*InvoiceFee      `json:"fees"`
+	ReleasedBTC decimal.Decimal    `json:"released_btc"`
+	Releases    int64              `json:"releases"`
+	GeneratedAt time.Time          `json:"generated_at"`
+}
+
+// InvoiceCurrency is the statement of a single currency for the invoice month,
+// closing_balance is always opening_balance + credits - debits.
+type InvoiceCurrency struct {
+	CurrencyID        string          `json:"currency_id"`
+	OpeningBalance    decimal.Decimal `json:"opening_balance"`
+	Credits           decimal.Decimal `json:"credits"`
+	Debits            decimal.Decimal `json:"debits"`
+	FeesPaid          decimal.Decimal `json:"fees_paid"`
+	CommissionsEarned decimal.Decimal `json:"commissions_earned"`
+	Adjustments       decimal.Decimal `json:"adjustments"`
+	ClosingBalance    decimal.Decimal `json:"closing_balance"`
+}
+
+type InvoiceFee struct {
+	MarketID   string          `json:"market_id"`
+	CurrencyID string          `json:"currency_id"`
+	Amount     decimal.Decimal `json:"amount"`
+}
+
+// InvoiceLedgerEntry is the sum of the liabilities of a currency for one reference type.
+type InvoiceLedgerEntry struct {
+	CurrencyID    string
+	ReferenceType string
+	Credit        decimal.Decimal
+	Debit         decimal.Decimal
+}
+
+type InvoiceBalance struct {
+	CurrencyID string
+	Balance    decimal.Decimal
+}
+
+type InvoiceCommission struct {
+	CurrencyID string
+	Amount     decimal.Decimal
+}
+
+// InvoiceMonth returns the first and the last instant (exclusive) of a month in UTC.
+func InvoiceMonth(month string) (from, to time.Time, err error) {
+	from, err = time.ParseInLocation(InvoiceMonthLayout, month, time.UTC)
+	if err != nil {
+		return
+	}
+
+	to = from.AddDate(0, 1, 0)
+
+	return
+}
+
+// BuildInvoice assembles an invoice from the aggregated ledger of the month,
+// amounts are rounded before being summed so the totals match the lines they're made of.
+func BuildInvoice(from, to time.Time, opening []*InvoiceBalance, ledger []*InvoiceLedgerEntry, fees []*InvoiceFee, commissions []*InvoiceCommission) *Invoice {
+	invoice := &Invoice{
+		Month:       from.Format(InvoiceMonthLayout),
+		From:        from,
+		To:          to,
+		Currencies:  make([]*InvoiceCurrency, 0),
+		Fees:        make([]*InvoiceFee, 0),
+		ReleasedBTC: decimal.Zero,
+	}
+
+	currencies := make(map[string]*InvoiceCurrency)
+	currency := func(currency_id string) *InvoiceCurrency {
+		if c, ok := currencies[currency_id]; ok {
+			return c
+		}
+
+		c := &InvoiceCurrency{
+			CurrencyID:        currency_id,
+			OpeningBalance:    decimal.Zero,
+			Credits:           decimal.Zero,
+			Debits:            decimal.Zero,
+			FeesPaid:          decimal.Zero,
+			CommissionsEarned: decimal.Zero,
+			Adjustments:       decimal.Zero,
+		}
+		currencies[currency_id] = c
+
+		return c
+	}
+
+	for _, b := range opening {
+		c := currency(b.CurrencyID)
+		c.OpeningBalance = c.OpeningBalance.Add(roundInvoice(b.Balance))
+	}
+
+	for _, e := range ledger {
+		c := currency(e.CurrencyID)
+		credit := roundInvoice(e.Credit)
+		debit := roundInvoice(e.Debit)
+
+		c.Credits = c.Credits.Add(credit)
+		c.Debits = c.Debits.Add(debit)
+
+		if e.ReferenceType == "Adjustment" {
+			c.Adjustments = c.Adjustments.Add(credit).Sub(debit)
+		}
+	}
+
+	for _, f := range fees {
+		amount := roundInvoice(f.Amount)
+		if amount.IsZero() {
+			continue
+		}
+
+		c := currency(f.CurrencyID)
+		c.FeesPaid = c.FeesPaid.Add(amount)
+
+		invoice.Fees = append(invoice.Fees, &InvoiceFee{
+			MarketID:   f.MarketID,
+			CurrencyID: f.CurrencyID,
+			Amount:     amount,
+		})
+	}
+
+	for _, cm := range commissions {
+		c := currency(cm.CurrencyID)
+		c.CommissionsEarned = c.CommissionsEarned.Add(roundInvoice(cm.Amount))
+	}
+
+	for _, c := range currencies {
+		c.ClosingBalance = c.OpeningBalance.Add(c.Credits).Sub(c.Debits)
+		invoice.Currencies = append(invoice.Currencies, c)
+	}
+
+	sort.Slice(invoice.Currencies, func(i, j int) bool {
+		return invoice.Currencies[i].CurrencyID < invoice.Currencies[j].CurrencyID
+	})
+
+	sort.Slice(invoice.Fees, func(i, j int) bool {
+		if invoice.Fees[i].MarketID == invoice.Fees[j].MarketID {
+			return invoice.Fees[i].CurrencyID < invoice.Fees[j].CurrencyID
+		}
+
+		return invoice.Fees[i].MarketID < invoice.Fees[j].MarketID
+	})
+
+	return invoice
+}
+
+// Reconcile checks the invoice totals against its own lines and, when given, the ledger balances at the end of the month.
+func (i *Invoice) Reconcile(closing []*InvoiceBalance) error {
+	fees := make(map[string]decimal.Decimal)
+	for _, f := range i.Fees {
+		fees[f.CurrencyID] = fees[f.CurrencyID].Add(f.Amount)
+	}
+
+	balances := make(map[string]decimal.Decimal)
+	for _, b := range closing {
+		balances[b.CurrencyID] = balances[b.CurrencyID].Add(roundInvoice(b.Balance))
+	}
+
+	for _, c := range i.Currencies {
+		if !c.OpeningBalance.Add(c.Credits).Sub(c.Debits).Equal(c.ClosingBalance) {
+			return fmt.Errorf("currency %s: closing balance %s doesn't match the movements", c.CurrencyID, c.ClosingBalance)
+		}
+
+		if !fees[c.CurrencyID].Equal(c.FeesPaid) {
+			return fmt.Errorf("currency %s: fees paid %s doesn't match the fee lines %s", c.CurrencyID, c.FeesPaid, fees[c.CurrencyID])
+		}
+
+		if closing != nil && !balances[c.CurrencyID].Equal(c.ClosingBalance) {
+			return fmt.Errorf("currency %s: closing balance %s doesn't match the ledger balance %s", c.CurrencyID, c.ClosingBalance, balances[c.CurrencyID])
+		}
+	}
+
+	return nil
+}
+
+func invoiceCacheKey(member *Member, month string) string {
+	return "finex:invoice:" + strconv.FormatInt(member.ID, 10) + ":" + month
+}
+
+func getLedgerBalances(member *Member, before time.Time) ([]*InvoiceBalance, error) {
+	var balances []*InvoiceBalance
+
+	if result := config.DataBase.
+		Model(&Liability{}).
+		Select("currency_id, SUM(credit) - SUM(debit) AS balance").
+		Where("member_id = ? AND created_at < ?", member.ID, before).
+		Group("currency_id").
+		Scan(&balances); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return balances, nil
+}
+
+// GetInvoice returns the invoice of a member for a month, invoices of closed months never change
+// so they're cached forever once generated.
+func GetInvoice(member *Member, month string) (*Invoice, error) {
+	from, to, err := InvoiceMonth(month)
+	if err != nil {
+		return nil, err
+	}
+
+	finalized := !time.Now().Before(to)
+	cache_key := invoiceCacheKey(member, month)
+
+	if finalized {
+		if exist, _ := config.Redis.Exist(cache_key); exist {
+			if result, err := config.Redis.Get(cache_key); err == nil {
+				var invoice *Invoice
+				if err := json.Unmarshal([]byte(result.Val()), &invoice); err == nil {
+					return invoice, nil
+				}
+			}
+		}
+	}
+
+	opening, err := getLedgerBalances(member, from)
+	if err != nil {
+		return nil, err
+	}
+
+	var ledger []*InvoiceLedgerEntry
+	if result := config.DataBase.
+		Model(&Liability{}).
+		Select("currency_id, reference_type, SUM(credit) AS credit, SUM(debit) AS debit").
+		Where("member_id = ? AND created_at >= ? AND created_at < ?", member.ID, from, to).
+		Group("currency_id, reference_type").
+		Scan(&ledger); result.Error != nil {
+		return nil, result.Error
+	}
+
+	var fees []*InvoiceFee
+	if result := config.DataBase.
+		Table("revenues").
+		Select("trades.market_id AS market_id, revenues.currency_id AS currency_id, SUM(revenues.credit) - SUM(revenues.debit) AS amount").
+		Joins("JOIN trades ON trades.id = revenues.reference_id").
+		Where("revenues.reference_type = ? AND revenues.member_id = ?", "Trade", member.ID).
+		Where("revenues.created_at >= ? AND revenues.created_at < ?", from, to).
+		Group("trades.market_id, revenues.currency_id").
+		Scan(&fees); result.Error != nil {
+		return nil, result.Error
+	}
+
+	var commissions []*InvoiceCommission
+	if result := config.DataBase.
+		Model(&Commission{}).
+		Select("currency_id, SUM(earn_amount) AS amount").
+		Where("member_id = ? AND created_at >= ? AND created_at < ?", member.ID, from, to).
+		Group("currency_id").
+		Scan(&commissions); result.Error != nil {
+		return nil, result.Error
+	}
+
+	invoice := BuildInvoice(from, to, opening, ledger, fees, commissions)
+	invoice.UID = member.UID
+	invoice.Finalized = finalized
+	invoice.GeneratedAt = time.Now()
+
+	var releases []*ReleaseCommission
+	if result := config.DataBase.Where("member_id = ? AND created_at >= ? AND created_at < ?", member.ID, from, to).Find(&releases); result.Error != nil {
+		return nil, result.Error
+	}
+	for _, release := range releases {
+		invoice.ReleasedBTC = invoice.ReleasedBTC.Add(roundInvoice(release.EarnedBTC))
+	}
+	invoice.Releases = int64(len(releases))
+
+	// the ledger of an open month keeps moving while the invoice is assembled
+	var closing []*InvoiceBalance
+	if finalized {
+		if closing, err = getLedgerBalances(member, to); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := invoice.Reconcile(closing); err != nil {
+		config.Logger.Errorf("Invoice %s of member %d doesn't reconcile: %v", month, member.ID, err)
+		return nil, err
+	}
+
+	if finalized {
+		if body, err := json.Marshal(invoice); err == nil {
+			config.Redis.Set(cache_key, string(body), 0)
+		}
+	}
+
+	return invoice, nil
+}
@@ -0,0 +1,111 @@
+//go:build integration
+
+package models
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg/services"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// The test needs the DATABASE_* variables of a disposable database and REDIS_URL for the invoices of the closed
+// months:
+//
+//	go test -tags integration -run 'Invoice' ./models
+func setupInvoiceDatabase(t *testing.T) {
+	if len(os.Getenv("DATABASE_HOST")) == 0 || len(os.Getenv("REDIS_URL")) == 0 {
+		t.Skip("DATABASE_HOST and REDIS_URL aren't set")
+	}
+
+	db, err := config.NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&Liability{}, &Revenue{}, &Trade{}, &Commission{}, &ReleaseCommission{}); err != nil {
+		t.Fatal(err)
+	}
+
+	redis, err := services.NewRedisClient(os.Getenv("REDIS_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.DataBase = db
+	config.Redis = redis
+}
+
+// The invoice of a closed month carries the balances the ledger of the account shows at its start and its end.
+func TestInvoiceMatchesTheLedgerOfTheMonth(t *testing.T) {
+	setupInvoiceDatabase(t)
+
+	d := decimal.RequireFromString
+	db := config.DataBase
+	member := &Member{ID: 81, UID: "ID81"}
+
+	for _, model := range []interface{}{&Liability{}, &Revenue{}, &Commission{}, &ReleaseCommission{}} {
+		db.Where("member_id = ?", member.ID).Delete(model)
+	}
+	db.Where("id = ?", 9400).Delete(&Trade{})
+	config.Redis.Delete(invoiceCacheKey(member, "2024-05"))
+
+	at := func(day int) time.Time {
+		return time.Date(2024, 5, day, 12, 0, 0, 0, time.UTC)
+	}
+
+	// the entries of April open the month, the ones of June are left out of it
+	entries := []*Liability{
+		{MemberID: member.ID, CurrencyID: "usdt", ReferenceType: "Deposit", Credit: d("1000"), CreatedAt: at(1).AddDate(0, -1, 0)},
+		{MemberID: member.ID, CurrencyID: "btc", ReferenceType: "Deposit", Credit: d("0.5"), CreatedAt: at(1).AddDate(0, -1, 0)},
+		{MemberID: member.ID, CurrencyID: "usdt", ReferenceType: "Trade", Debit: d("300.05"), ReferenceID: 9400, CreatedAt: at(10)},
+		{MemberID: member.ID, CurrencyID: "btc", ReferenceType: "Trade", Credit: d("0.00999"), ReferenceID: 9400, CreatedAt: at(10)},
+		{MemberID: member.ID, CurrencyID: "usdt", ReferenceType: "Adjustment", Credit: d("5"), CreatedAt: at(20)},
+		{MemberID: member.ID, CurrencyID: "usdt", ReferenceType: "Withdraw", Debit: d("50"), CreatedAt: at(3).AddDate(0, 1, 0)},
+	}
+	if err := db.Create(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	db.Create(&Trade{ID: 9400, MarketID: "btcusdt", CreatedAt: at(10)})
+	db.Create(&Revenue{MemberID: member.ID, CurrencyID: "btc", ReferenceType: "Trade", ReferenceID: 9400, Kind: RevenueKindFee, MarketID: "btcusdt", Credit: d("0.00001"), CreatedAt: at(10)})
+
+	invoice, err := GetInvoice(member, "2024-05")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the statement of the account: its balances summed from its ledger at the start and the end of the month
+	from, to, _ := InvoiceMonth("2024-05")
+	opening := make(map[string]decimal.Decimal)
+	closing := make(map[string]decimal.Decimal)
+	for _, entry := range entries {
+		balance := entry.Credit.Sub(entry.Debit)
+		if entry.CreatedAt.Before(from) {
+			opening[entry.CurrencyID] = opening[entry.CurrencyID].Add(balance)
+		}
+
+		if entry.CreatedAt.Before(to) {
+			closing[entry.CurrencyID] = closing[entry.CurrencyID].Add(balance)
+		}
+	}
+
+	if len(invoice.Currencies) != len(closing) {
+		t.Fatalf("expected the %d currencies of the ledger, got %d", len(closing), len(invoice.Currencies))
+	}
+
+	for _, c := range invoice.Currencies {
+		if !c.OpeningBalance.Equal(opening[c.CurrencyID]) || !c.ClosingBalance.Equal(closing[c.CurrencyID]) {
+			t.Errorf("currency %s: expected %s to %s as the ledger shows, got %s to %s", c.CurrencyID,
+				opening[c.CurrencyID], closing[c.CurrencyID], c.OpeningBalance, c.ClosingBalance)
+		}
+	}
+
+	if len(invoice.Fees) != 1 || !invoice.Fees[0].Amount.Equal(d("0.00001")) || !invoice.Finalized {
+		t.Errorf("expected the fee of the trade on a finalized invoice, got %+v", invoice.Fees)
+	}
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestInvoiceMonth(t *testing.T) {
+	from, to, err := InvoiceMonth("2024-12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !from.Equal(time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)) || !to.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("InvoiceMonth(2024-12) = %v, %v", from, to)
+	}
+
+	if _, _, err := InvoiceMonth("2024-13"); err == nil {
+		t.Error("expected an error for an invalid month")
+	}
+}
+
+func TestBuildInvoiceReconciles(t *testing.T) {
+	d := decimal.RequireFromString
+	from, to, _ := InvoiceMonth("2024-05")
+
+	opening := []*InvoiceBalance{
+		{CurrencyID: "usdt", Balance: d("1000")},
+		{CurrencyID: "btc", Balance: d("0.5")},
+	}
+	ledger := []*InvoiceLedgerEntry{
+		{CurrencyID: "usdt", ReferenceType: "Trade", Credit: d("250.123456789"), Debit: d("100")},
+		{CurrencyID: "usdt", ReferenceType: "Adjustment", Credit: d("5"), Debit: d("0")},
+		{CurrencyID: "btc", ReferenceType: "Trade", Credit: d("0.01"), Debit: d("0.2")},
+		{CurrencyID: "eth", ReferenceType: "Trade", Credit: d("1"), Debit: d("0")},
+	}
+	fees := []*InvoiceFee{
+		{MarketID: "btcusdt", CurrencyID: "usdt", Amount: d("0.250000004")},
+		{MarketID: "ethusdt", CurrencyID: "usdt", Amount: d("0.1")},
+		{MarketID: "btcusdt", CurrencyID: "btc", Amount: d("0.00001")},
+		{MarketID: "ethusdt", CurrencyID: "eth", Amount: d("0")},
+	}
+	commissions := []*InvoiceCommission{
+		{CurrencyID: "usdt", Amount: d("0.05")},
+	}
+
+	invoice := BuildInvoice(from, to, opening, ledger, fees, commissions)
+
+	if invoice.Month != "2024-05" {
+		t.Errorf("got month %s", invoice.Month)
+	}
+
+	want := map[string]struct {
+		closing     string
+		fees        string
+		commissions string
+		adjustments string
+	}{
+		"btc":  {"0.31", "0.00001", "0", "0"},
+		"eth":  {"1", "0", "0", "0"},
+		"usdt": {"1155.12345679", "0.35", "0.05", "5"},
+	}
+
+	if len(invoice.Currencies) != len(want) {
+		t.Fatalf("got %d currencies, want %d", len(invoice.Currencies), len(want))
+	}
+
+	for _, c := range invoice.Currencies {
+		w := want[c.CurrencyID]
+
+		if !c.ClosingBalance.Equal(d(w.closing)) || !c.FeesPaid.Equal(d(w.fees)) || !c.CommissionsEarned.Equal(d(w.commissions)) || !c.Adjustments.Equal(d(w.adjustments)) {
+			t.Errorf("currency %s: got closing %s, fees %s, commissions %s, adjustments %s", c.CurrencyID, c.ClosingBalance, c.FeesPaid, c.CommissionsEarned, c.Adjustments)
+		}
+	}
+
+	if len(invoice.Fees) != 3 {
+		t.Errorf("expected zero fee lines to be dropped, got %d lines", len(invoice.Fees))
+	}
+
+	// balances of the ledger at the end of the month, as the account statement shows them
+	closing := []*InvoiceBalance{
+		{CurrencyID: "usdt", Balance: d("1155.123456789")},
+		{CurrencyID: "btc", Balance: d("0.31")},
+		{CurrencyID: "eth", Balance: d("1")},
+	}
+
+	if err := invoice.Reconcile(closing); err != nil {
+		t.Errorf("expected the invoice to reconcile: %v", err)
+	}
+
+	closing[1].Balance = d("0.32")
+	if err := invoice.Reconcile(closing); err == nil {
+		t.Error("expected a mismatch with the ledger balance")
+	}
+
+	invoice.Fees[0].Amount = d("1")
+	if err := invoice.Reconcile(nil); err == nil {
+		t.Error("expected a mismatch with the fee lines")
+	}
+}
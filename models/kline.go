@@ -0,0 +1,401 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// DefaultKlinePeriods are the periods the kline builder keeps when klines.periods isn't set.
+var DefaultKlinePeriods = []string{"1m", "5m", "15m", "1h", "4h", "1d"}
+
+// The stores of the klines, klines.store.
+const (
+	// KlineStoreDatabase keeps the candles in the klines table, the default
+	KlineStoreDatabase = "database"
+	// KlineStoreInflux keeps them in the candles_<period> measurements of InfluxDB, with the candles the price
+	// series and the candle integrity check read
+	KlineStoreInflux = "influx"
+)
+
+var ErrKlinePeriod = errors.New("public.k_line.invalid_period")
+
+// Kline is a candle the kline builder keeps from the trades of a market, Time is the start of its period.
+type Kline struct {
+	ID       int64           `json:"id" gorm:"primaryKey"`
+	MarketID string          `json:"market_id" gorm:"uniqueIndex:index_klines_on_market_id_and_period_and_time"`
+	Period   string          `json:"period" gorm:"uniqueIndex:index_klines_on_market_id_and_period_and_time"`
+	Time     time.Time       `json:"time" gorm:"uniqueIndex:index_klines_on_market_id_and_period_and_time"`
+	Open     decimal.Decimal `json:"open"`
+	High     decimal.Decimal `json:"high"`
+	Low      decimal.Decimal `json:"low"`
+	Close    decimal.Decimal `json:"close"`
+	// Volume is the base amount traded, QuoteVolume the quote total
+	Volume      decimal.Decimal `json:"volume"`
+	QuoteVolume decimal.Decimal `json:"quote_volume"`
+	// LastTradeID is the id of the last trade the candle holds, the trades of a market come in the order of their
+	// id so a trade delivered again is skipped
+	LastTradeID int64     `json:"last_trade_id"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// KlinePeriods returns the periods of klines.periods the builder keeps, DefaultKlinePeriods when it's not set.
+// Only the periods stored as candles are kept.
+func KlinePeriods() []string {
+	if config.Klines == nil || len(config.Klines.Periods) == 0 {
+		return DefaultKlinePeriods
+	}
+
+	periods := make([]string, 0, len(config.Klines.Periods))
+	for _, period := range config.Klines.Periods {
+		for _, stored := range CandlePeriods {
+			if period == stored {
+				periods = append(periods, period)
+			}
+		}
+	}
+
+	return periods
+}
+
+// KeptKlinePeriod reports whether the builder keeps the candles of period.
+func KeptKlinePeriod(period string) bool {
+	for _, kept := range KlinePeriods() {
+		if kept == period {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewKline returns the candle of period holding a single trade.
+func NewKline(period string, trade *Trade) *Kline {
+	return &Kline{
+		MarketID:    trade.MarketID,
+		Period:      period,
+		Time:        CandleBucket(trade.CreatedAt, PriceSeriesPeriods[period]),
+		Open:        trade.Price,
+		High:        trade.Price,
+		Low:         trade.Price,
+		Close:       trade.Price,
+		Volume:      trade.Amount,
+		QuoteVolume: trade.Total,
+		LastTradeID: trade.ID,
+	}
+}
+
+// Add adds a trade of the period of the candle, it's false when the candle holds it already.
+func (k *Kline) Add(trade *Trade) bool {
+	if trade.ID <= k.LastTradeID {
+		return false
+	}
+
+	k.add(trade)
+
+	return true
+}
+
+func (k *Kline) add(trade *Trade) {
+	if trade.Price.GreaterThan(k.High) {
+		k.High = trade.Price
+	}
+
+	if trade.Price.LessThan(k.Low) {
+		k.Low = trade.Price
+	}
+
+	k.Close = trade.Price
+	k.Volume = k.Volume.Add(trade.Amount)
+	k.QuoteVolume = k.QuoteVolume.Add(trade.Total)
+	if trade.ID > k.LastTradeID {
+		k.LastTradeID = trade.ID
+	}
+}
+
+// BuildKlines builds the candles of period from trades ordered by creation, periods without trades have no candle.
+func BuildKlines(period string, trades []*Trade) []*Kline {
+	klines := make([]*Kline, 0)
+
+	var kline *Kline
+	for _, trade := range trades {
+		if kline != nil && kline.Time.Equal(CandleBucket(trade.CreatedAt, PriceSeriesPeriods[period])) {
+			kline.add(trade)
+			continue
+		}
+
+		kline = NewKline(period, trade)
+		klines = append(klines, kline)
+	}
+
+	return klines
+}
+
+// FillKlines returns a candle for each period from from to to, the periods without trades carry the close of the
+// candle before them with no volume. The periods before the first candle and previous_close are left out, the
+// market didn't trade yet.
+func FillKlines(klines []*Kline, market, period string, from, to time.Time, previous_close decimal.Decimal) []*Kline {
+	duration := PriceSeriesPeriods[period]
+	filled := make([]*Kline, 0, len(klines))
+
+	i := 0
+	last_close := previous_close
+	for at := from; at.Before(to); at = at.Add(duration) {
+		for i < len(klines) && klines[i].Time.Before(at) {
+			i++
+		}
+
+		if i < len(klines) && klines[i].Time.Equal(at) {
+			filled = append(filled, klines[i])
+			last_close = klines[i].Close
+			continue
+		}
+
+		if last_close.IsZero() {
+			continue
+		}
+
+		filled = append(filled, &Kline{
+			MarketID:    market,
+			Period:      period,
+			Time:        at,
+			Open:        last_close,
+			High:        last_close,
+			Low:         last_close,
+			Close:       last_close,
+			Volume:      decimal.Zero,
+			QuoteVolume: decimal.Zero,
+		})
+	}
+
+	return filled
+}
+
+// KlineStore keeps the candles of the kline builder.
+type KlineStore interface {
+	// Apply adds a trade to the candles of each of periods, a candle holding the trade already is left as is
+	Apply(trade *Trade, periods []string) error
+	// Replace replaces the candles of period of a market from from to to with klines
+	Replace(market, period string, from, to time.Time, klines []*Kline) error
+	// Klines returns the candles of period of a market from from to to, oldest first
+	Klines(market, period string, from, to time.Time) ([]*Kline, error)
+	// LastClose returns the close of the last candle of period of a market before before, zero when there's none
+	LastClose(market, period string, before time.Time) decimal.Decimal
+}
+
+// Klines returns the store of klines.store.
+func Klines() KlineStore {
+	if config.Klines != nil && config.Klines.Store == KlineStoreInflux {
+		return influxKlineStore{}
+	}
+
+	return databaseKlineStore{db: config.DataBase}
+}
+
+// databaseKlineStore keeps the candles in the klines table.
+type databaseKlineStore struct {
+	db *gorm.DB
+}
+
+// Apply upserts the candle of each period in a single statement, the conflict only updates the candles whose last
+// trade is older than the trade.
+func (s databaseKlineStore) Apply(trade *Trade, periods []string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, period := range periods {
+			kline := NewKline(period, trade)
+
+			result := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "market_id"}, {Name: "period"}, {Name: "time"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"high":          gorm.Expr("GREATEST(klines.high, excluded.high)"),
+					"low":           gorm.Expr("LEAST(klines.low, excluded.low)"),
+					"close":         gorm.Expr("excluded.close"),
+					"volume":        gorm.Expr("klines.volume + excluded.volume"),
+					"quote_volume":  gorm.Expr("klines.quote_volume + excluded.quote_volume"),
+					"last_trade_id": gorm.Expr("excluded.last_trade_id"),
+					"updated_at":    gorm.Expr("excluded.updated_at"),
+				}),
+				Where: clause.Where{Exprs: []clause.Expression{gorm.Expr("klines.last_trade_id < excluded.last_trade_id")}},
+			}).Create(kline)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s databaseKlineStore) Replace(market, period string, from, to time.Time, klines []*Kline) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Where("market_id = ? AND period = ? AND time >= ? AND time < ?", market, period, from, to).Delete(&Kline{}); result.Error != nil {
+			return result.Error
+		}
+
+		if len(klines) == 0 {
+			return nil
+		}
+
+		return tx.CreateInBatches(klines, 500).Error
+	})
+}
+
+func (s databaseKlineStore) Klines(market, period string, from, to time.Time) ([]*Kline, error) {
+	var klines []*Kline
+	result := s.db.
+		Where("market_id = ? AND period = ? AND time >= ? AND time < ?", market, period, from, to).
+		Order("time asc").
+		Find(&klines)
+
+	return klines, result.Error
+}
+
+func (s databaseKlineStore) LastClose(market, period string, before time.Time) decimal.Decimal {
+	var kline *Kline
+	result := s.db.Where("market_id = ? AND period = ? AND time < ?", market, period, before).Order("time desc").Take(&kline)
+	if result.Error != nil {
+		return decimal.Zero
+	}
+
+	return kline.Close
+}
+
+// influxKlineStore keeps the candles in the candles_<period> measurements, a trade is added to the candle read back
+// from InfluxDB since a point can only be overwritten as a whole.
+type influxKlineStore struct{}
+
+func (s influxKlineStore) Apply(trade *Trade, periods []string) error {
+	for _, period := range periods {
+		kline := NewKline(period, trade)
+
+		stored, err := s.Klines(trade.MarketID, period, kline.Time, kline.Time.Add(PriceSeriesPeriods[period]))
+		if err != nil {
+			return err
+		}
+
+		if len(stored) > 0 {
+			kline = stored[0]
+			if !kline.Add(trade) {
+				continue
+			}
+		}
+
+		writeInfluxKline(kline)
+	}
+
+	return nil
+}
+
+func (s influxKlineStore) Replace(market, period string, from, to time.Time, klines []*Kline) error {
+	var rows []map[string]interface{}
+
+	query := fmt.Sprintf(
+		"DELETE FROM \"candles_%s\" WHERE \"market\"='%s' AND time >= %d AND time < %d",
+		period, market, from.UnixNano(), to.UnixNano(),
+	)
+	if err := config.InfluxDB.Query(query, &rows); err != nil {
+		return err
+	}
+
+	for _, kline := range klines {
+		writeInfluxKline(kline)
+	}
+
+	return nil
+}
+
+func (s influxKlineStore) Klines(market, period string, from, to time.Time) ([]*Kline, error) {
+	var rows []map[string]interface{}
+
+	query := fmt.Sprintf(
+		"SELECT * FROM \"candles_%s\" WHERE \"market\"='%s' AND time >= %d AND time < %d ORDER BY time ASC",
+		period, market, from.UnixNano(), to.UnixNano(),
+	)
+	if err := config.InfluxDB.Query(query, &rows); err != nil {
+		return nil, err
+	}
+
+	klines := make([]*Kline, 0, len(rows))
+	for _, row := range rows {
+		candle := candleFromInfluxRow(row)
+
+		var last_trade_id int64
+		if n, ok := row["last_trade_id"].(json.Number); ok {
+			last_trade_id, _ = n.Int64()
+		}
+
+		klines = append(klines, &Kline{
+			MarketID:    market,
+			Period:      period,
+			Time:        candle.Time,
+			Open:        candle.Open,
+			High:        candle.High,
+			Low:         candle.Low,
+			Close:       candle.Close,
+			Volume:      candle.Volume,
+			QuoteVolume: influxDecimal(row["quote_volume"]),
+			LastTradeID: last_trade_id,
+		})
+	}
+
+	return klines, nil
+}
+
+func (s influxKlineStore) LastClose(market, period string, before time.Time) decimal.Decimal {
+	return GetLastCandleCloseFromInflux(market, period, before)
+}
+
+func writeInfluxKline(kline *Kline) {
+	float := func(d decimal.Decimal) float64 {
+		f, _ := d.Float64()
+		return f
+	}
+
+	config.InfluxDB.NewPointAt("candles_"+kline.Period, map[string]string{"market": kline.MarketID}, map[string]interface{}{
+		"open":          float(kline.Open),
+		"high":          float(kline.High),
+		"low":           float(kline.Low),
+		"close":         float(kline.Close),
+		"volume":        float(kline.Volume),
+		"quote_volume":  float(kline.QuoteVolume),
+		"last_trade_id": kline.LastTradeID,
+	}, kline.Time)
+}
+
+// RebuildKlines rebuilds the candles of period of a market from the trades table, over the periods holding from to
+// to. The candles are replaced, the ones the builder missed and the ones holding trades reverted since included, and
+// the builder goes on from them: the trades they hold are skipped. It returns the number of candles built.
+func RebuildKlines(store KlineStore, market, period string, from, to time.Time) (int, error) {
+	duration, ok := PriceSeriesPeriods[period]
+	if !ok {
+		return 0, ErrKlinePeriod
+	}
+
+	from = CandleBucket(from, duration)
+	if bucket := CandleBucket(to, duration); bucket.Before(to) {
+		to = bucket.Add(duration)
+	}
+
+	var trades []*Trade
+	result := config.DataBase.
+		Where("market_id = ? AND created_at >= ? AND created_at < ? AND reverted_at IS NULL", market, from, to).
+		Order("created_at asc, id asc").
+		Find(&trades)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	klines := BuildKlines(period, trades)
+	if err := store.Replace(market, period, from, to, klines); err != nil {
+		return 0, err
+	}
+
+	return len(klines), nil
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestBuildKlines(t *testing.T) {
+	from := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	d := decimal.RequireFromString
+
+	trade := func(id int64, seconds int, price, amount string) *Trade {
+		return &Trade{
+			ID:        id,
+			MarketID:  "btcusdt",
+			Price:     d(price),
+			Amount:    d(amount),
+			Total:     d(price).Mul(d(amount)),
+			CreatedAt: from.Add(time.Duration(seconds) * time.Second),
+		}
+	}
+
+	trades := []*Trade{
+		trade(1, 5, "100", "1"),
+		trade(2, 20, "110", "2"),
+		trade(3, 40, "90", "1"),
+		trade(4, 59, "95", "1"),
+		trade(5, 130, "97", "3"),
+	}
+
+	klines := BuildKlines("1m", trades)
+	if len(klines) != 2 {
+		t.Fatalf("BuildKlines() built %d candles, want 2", len(klines))
+	}
+
+	first := klines[0]
+	if !first.Time.Equal(from) || first.Open.String() != "100" || first.High.String() != "110" || first.Low.String() != "90" || first.Close.String() != "95" {
+		t.Errorf("first candle = %s %s/%s/%s/%s", first.Time, first.Open, first.High, first.Low, first.Close)
+	}
+
+	if first.Volume.String() != "5" || first.QuoteVolume.String() != "505" || first.LastTradeID != 4 {
+		t.Errorf("first candle volume = %s, quote volume = %s, last trade = %d", first.Volume, first.QuoteVolume, first.LastTradeID)
+	}
+
+	second := klines[1]
+	if !second.Time.Equal(from.Add(2*time.Minute)) || second.Open.String() != "97" || second.Volume.String() != "3" {
+		t.Errorf("second candle = %s open %s volume %s", second.Time, second.Open, second.Volume)
+	}
+
+	hourly := BuildKlines("1h", trades)
+	if len(hourly) != 1 || hourly[0].Volume.String() != "8" || hourly[0].Close.String() != "97" {
+		t.Errorf("BuildKlines(1h) = %d candles", len(hourly))
+	}
+}
+
+func TestKlineAddSkipsTradesHeld(t *testing.T) {
+	at := time.Date(2022, 5, 1, 0, 0, 10, 0, time.UTC)
+	d := decimal.RequireFromString
+
+	kline := NewKline("1m", &Trade{ID: 7, MarketID: "btcusdt", Price: d("100"), Amount: d("1"), Total: d("100"), CreatedAt: at})
+
+	if kline.Add(&Trade{ID: 7, MarketID: "btcusdt", Price: d("120"), Amount: d("1"), Total: d("120"), CreatedAt: at}) {
+		t.Error("Add() of the trade the candle was built from = true")
+	}
+
+	if !kline.Add(&Trade{ID: 8, MarketID: "btcusdt", Price: d("120"), Amount: d("2"), Total: d("240"), CreatedAt: at}) {
+		t.Fatal("Add() of a new trade = false")
+	}
+
+	if kline.Add(&Trade{ID: 8, MarketID: "btcusdt", Price: d("120"), Amount: d("2"), Total: d("240"), CreatedAt: at}) {
+		t.Error("Add() of a trade delivered again = true")
+	}
+
+	if kline.High.String() != "120" || kline.Close.String() != "120" || kline.Volume.String() != "3" || kline.QuoteVolume.String() != "340" || kline.LastTradeID != 8 {
+		t.Errorf("candle = high %s close %s volume %s quote volume %s last trade %d", kline.High, kline.Close, kline.Volume, kline.QuoteVolume, kline.LastTradeID)
+	}
+}
+
+func TestFillKlines(t *testing.T) {
+	from := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	d := decimal.RequireFromString
+
+	kline := func(minutes int, close string) *Kline {
+		price := d(close)
+		return &Kline{Time: from.Add(time.Duration(minutes) * time.Minute), Open: price, High: price, Low: price, Close: price, Volume: d("1"), QuoteVolume: price}
+	}
+
+	type candle struct {
+		close  string
+		volume string
+	}
+
+	tests := []struct {
+		name           string
+		klines         []*Kline
+		previous_close string
+		want           []candle
+	}{
+		{
+			name:           "gaps carry the close",
+			klines:         []*Kline{kline(0, "10"), kline(3, "12")},
+			previous_close: "0",
+			want:           []candle{{"10", "1"}, {"10", "0"}, {"10", "0"}, {"12", "1"}, {"12", "0"}},
+		},
+		{
+			name:           "previous close",
+			klines:         []*Kline{kline(2, "11")},
+			previous_close: "9",
+			want:           []candle{{"9", "0"}, {"9", "0"}, {"11", "1"}, {"11", "0"}, {"11", "0"}},
+		},
+		{
+			name:           "before the first trade",
+			klines:         []*Kline{kline(3, "12")},
+			previous_close: "0",
+			want:           []candle{{"12", "1"}, {"12", "0"}},
+		},
+		{
+			name:           "no trade",
+			previous_close: "0",
+			want:           []candle{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filled := FillKlines(tt.klines, "btcusdt", "1m", from, from.Add(5*time.Minute), d(tt.previous_close))
+			if len(filled) != len(tt.want) {
+				t.Fatalf("FillKlines() = %d candles, want %d", len(filled), len(tt.want))
+			}
+
+			for i, want := range tt.want {
+				if filled[i].Close.String() != want.close || filled[i].Volume.String() != want.volume {
+					t.Errorf("candle %d = close %s volume %s, want close %s volume %s", i, filled[i].Close, filled[i].Volume, want.close, want.volume)
+				}
+
+				if !filled[i].Time.Equal(filled[0].Time.Add(time.Duration(i) * time.Minute)) {
+					t.Errorf("candle %d at %s", i, filled[i].Time)
+				}
+			}
+		})
+	}
+}
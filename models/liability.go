@@ -5,6 +5,7 @@ import (
 
 	"github.com/shopspring/decimal"
 	"github.com/zsmartex/finex/config"
+	"gorm.io/gorm"
 )
 
 type Liability struct {
@@ -28,33 +29,26 @@ func GetOperationsCode(currency *Currency, kind string) int32 {
 }
 
 func LiabilityCredit(amount decimal.Decimal, currency *Currency, reference Reference, kind string, member_id int64) {
-	code := GetOperationsCode(currency, kind)
-
-	liability := Liability{
-		Code:          code,
-		CurrencyID:    currency.ID,
-		ReferenceType: reference.Type,
-		ReferenceID:   reference.ID,
-		Credit:        amount,
-		MemberID:      member_id,
-	}
-
-	config.DataBase.Create(&liability)
+	writeLiability(config.DataBase, amount, decimal.Zero, currency, reference, kind, member_id)
 }
 
 func LiabilityDebit(amount decimal.Decimal, currency *Currency, reference Reference, kind string, member_id int64) {
-	code := GetOperationsCode(currency, kind)
+	writeLiability(config.DataBase, decimal.Zero, amount, currency, reference, kind, member_id)
+}
 
+// writeLiability writes an entry of credit and debit on the liability account of kind of the member with tx.
+func writeLiability(tx *gorm.DB, credit, debit decimal.Decimal, currency *Currency, reference Reference, kind string, member_id int64) error {
 	liability := Liability{
-		Code:          code,
+		Code:          GetOperationsCode(currency, kind),
 		CurrencyID:    currency.ID,
 		ReferenceType: reference.Type,
 		ReferenceID:   reference.ID,
-		Debit:         amount,
+		Debit:         debit,
+		Credit:        credit,
 		MemberID:      member_id,
 	}
 
-	config.DataBase.Create(&liability)
+	return tx.Create(&liability).Error
 }
 
 func LiabilityTranfer(amount decimal.Decimal, currency *Currency, reference Reference, from_kind, to_kind string, member_id int64) {
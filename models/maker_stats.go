@@ -0,0 +1,411 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/decimalutil"
+	"github.com/zsmartex/finex/types"
+)
+
+var (
+	ErrMakerStatsNotEnrolled = errors.New("account.maker_stats.not_enrolled")
+	ErrMakerStatsPeriod      = errors.New("account.maker_stats.invalid_period")
+)
+
+// MakerStatsPeriods are the periods the maker stats are served over.
+var MakerStatsPeriods = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// makerStatsMarketRow is the member of the rollups holding the volume of the whole market.
+const makerStatsMarketRow int64 = 0
+
+// makerStatsRatioPrecision is the scale the ratios of the maker stats are rounded to.
+const makerStatsRatioPrecision int32 = 8
+
+// MakerStatsRollup is the activity of an enrolled maker on a market over an hour, the hourly job writes the orders
+// and the trades of the hour and the quote sampler adds its samples while the hour runs.
+type MakerStatsRollup struct {
+	ID       int64     `json:"id" gorm:"primaryKey"`
+	MemberID int64     `json:"member_id" gorm:"uniqueIndex:index_maker_stats_rollups_on_member_market_hour"`
+	MarketID string    `json:"market_id" gorm:"uniqueIndex:index_maker_stats_rollups_on_member_market_hour;index"`
+	Hour     time.Time `json:"hour" gorm:"uniqueIndex:index_maker_stats_rollups_on_member_market_hour;index"`
+	// MakerVolume is the quote volume of the trades the member made, the volume of the market on its row
+	MakerVolume     decimal.Decimal `json:"maker_volume" gorm:"type:decimal(36,18);default:0"`
+	MakerFills      int64           `json:"maker_fills" gorm:"default:0"`
+	OrdersPlaced    int64           `json:"orders_placed" gorm:"default:0"`
+	OrdersCancelled int64           `json:"orders_cancelled" gorm:"default:0"`
+	// QuoteSamples are the samples which found orders of the member in the market, QuotedSamples the ones
+	// which found both a bid and an ask, SpreadSum sums the spread of these relative to their mid price
+	QuoteSamples  int64           `json:"quote_samples" gorm:"default:0"`
+	QuotedSamples int64           `json:"quoted_samples" gorm:"default:0"`
+	SpreadSum     decimal.Decimal `json:"spread_sum" gorm:"type:decimal(36,18);default:0"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// MakerProgramEnrolled reports whether the member is in the maker program, the program enrolls groups of members.
+func MakerProgramEnrolled(member *Member) bool {
+	for _, group := range config.MakerProgram.Groups {
+		if group == member.Group {
+			return true
+		}
+	}
+
+	return false
+}
+
+func makerStatsConflict() clause.OnConflict {
+	return clause.OnConflict{
+		Columns: []clause.Column{{Name: "member_id"}, {Name: "market_id"}, {Name: "hour"}},
+	}
+}
+
+// QuoteSample is the best bid and ask a maker had in a market when it was sampled.
+type QuoteSample struct {
+	MemberID int64
+	MarketID string
+	BestBid  decimal.NullDecimal
+	BestAsk  decimal.NullDecimal
+}
+
+// Spread returns the spread of the sample relative to its mid price, ok is false unless the maker quoted both sides.
+func (s *QuoteSample) Spread() (spread decimal.Decimal, ok bool) {
+	if !s.BestBid.Valid || !s.BestAsk.Valid || !s.BestBid.Decimal.IsPositive() || s.BestAsk.Decimal.LessThanOrEqual(s.BestBid.Decimal) {
+		return decimal.Zero, false
+	}
+
+	mid := s.BestAsk.Decimal.Add(s.BestBid.Decimal).Div(decimal.NewFromInt(2))
+
+	return s.BestAsk.Decimal.Sub(s.BestBid.Decimal).Div(mid), true
+}
+
+// SampleMakerQuotes adds a sample of the open limit orders of the enrolled makers to the rollups of the hour of at.
+func SampleMakerQuotes(tx *gorm.DB, at time.Time) error {
+	if len(config.MakerProgram.Groups) == 0 {
+		return nil
+	}
+
+	var samples []*QuoteSample
+	result := tx.Table("orders").
+		Select("orders.member_id, orders.market_id, MAX(CASE WHEN orders.type = ? THEN orders.price END) AS best_bid, MIN(CASE WHEN orders.type = ? THEN orders.price END) AS best_ask", SideBuy, SideSell).
+		Joins("JOIN members ON members.id = orders.member_id").
+		Where(`orders.state = ? AND orders.ord_type = ? AND members."group" IN ?`, StateWait, types.TypeLimit, config.MakerProgram.Groups).
+		Group("orders.member_id, orders.market_id").
+		Scan(&samples)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	hour := at.UTC().Truncate(time.Hour)
+	for _, sample := range samples {
+		rollup := &MakerStatsRollup{MemberID: sample.MemberID, MarketID: sample.MarketID, Hour: hour, QuoteSamples: 1}
+		if spread, ok := sample.Spread(); ok {
+			rollup.QuotedSamples = 1
+			rollup.SpreadSum = spread
+		}
+
+		conflict := makerStatsConflict()
+		conflict.DoUpdates = clause.Assignments(map[string]interface{}{
+			"quote_samples":  gorm.Expr("maker_stats_rollups.quote_samples + excluded.quote_samples"),
+			"quoted_samples": gorm.Expr("maker_stats_rollups.quoted_samples + excluded.quoted_samples"),
+			"spread_sum":     gorm.Expr("maker_stats_rollups.spread_sum + excluded.spread_sum"),
+			"updated_at":     gorm.Expr("excluded.updated_at"),
+		})
+
+		if result := tx.Clauses(conflict).Create(rollup); result.Error != nil {
+			return result.Error
+		}
+	}
+
+	return nil
+}
+
+type makerStatsKey struct {
+	MemberID int64
+	MarketID string
+}
+
+// RollupMakerStats writes the orders and the trades of the enrolled makers over the hour starting at hour,
+// with the volume of every market. A hour rolled up again is overwritten, the samples of the hour are kept.
+func RollupMakerStats(tx *gorm.DB, hour time.Time) error {
+	hour = hour.UTC().Truncate(time.Hour)
+	end := hour.Add(time.Hour)
+	rollups := make(map[makerStatsKey]*MakerStatsRollup)
+
+	rollup := func(member_id int64, market_id string) *MakerStatsRollup {
+		key := makerStatsKey{MemberID: member_id, MarketID: market_id}
+		if rollups[key] == nil {
+			rollups[key] = &MakerStatsRollup{MemberID: member_id, MarketID: market_id, Hour: hour}
+		}
+
+		return rollups[key]
+	}
+
+	var volumes []*struct {
+		MarketID string
+		Volume   decimal.Decimal
+	}
+	if result := tx.Model(&Trade{}).
+		Select("market_id, SUM(total) AS volume").
+		Where("created_at >= ? AND created_at < ? AND reverted_at IS NULL", hour, end).
+		Group("market_id").
+		Scan(&volumes); result.Error != nil {
+		return result.Error
+	}
+
+	for _, volume := range volumes {
+		rollup(makerStatsMarketRow, volume.MarketID).MakerVolume = volume.Volume
+	}
+
+	if len(config.MakerProgram.Groups) > 0 {
+		var fills []*struct {
+			MemberID   int64
+			MarketID   string
+			Volume     decimal.Decimal
+			MakerFills int64
+		}
+		if result := tx.Model(&Trade{}).
+			Select("trades.maker_id AS member_id, trades.market_id, SUM(trades.total) AS volume, COUNT(*) AS maker_fills").
+			Joins("JOIN members ON members.id = trades.maker_id").
+			Where(`trades.created_at >= ? AND trades.created_at < ? AND trades.reverted_at IS NULL AND members."group" IN ?`, hour, end, config.MakerProgram.Groups).
+			Group("trades.maker_id, trades.market_id").
+			Scan(&fills); result.Error != nil {
+			return result.Error
+		}
+
+		for _, fill := range fills {
+			r := rollup(fill.MemberID, fill.MarketID)
+			r.MakerVolume = fill.Volume
+			r.MakerFills = fill.MakerFills
+		}
+
+		var counts []*struct {
+			MemberID int64
+			MarketID string
+			Count    int64
+		}
+		for _, column := range []string{"created_at", "updated_at"} {
+			counts = counts[:0]
+
+			query := tx.Model(&Order{}).
+				Select("orders.member_id, orders.market_id, COUNT(*) AS count").
+				Joins("JOIN members ON members.id = orders.member_id").
+				Where(`orders.ord_type = ? AND members."group" IN ?`, types.TypeLimit, config.MakerProgram.Groups).
+				Where("orders."+column+" >= ? AND orders."+column+" < ?", hour, end).
+				Group("orders.member_id, orders.market_id")
+
+			// the orders are placed at their creation and cancelled at their last update
+			if column == "updated_at" {
+				query = query.Where("orders.state = ?", StateCancel)
+			}
+
+			if result := query.Scan(&counts); result.Error != nil {
+				return result.Error
+			}
+
+			for _, count := range counts {
+				if column == "created_at" {
+					rollup(count.MemberID, count.MarketID).OrdersPlaced = count.Count
+				} else {
+					rollup(count.MemberID, count.MarketID).OrdersCancelled = count.Count
+				}
+			}
+		}
+	}
+
+	for _, r := range rollups {
+		conflict := makerStatsConflict()
+		conflict.DoUpdates = clause.AssignmentColumns([]string{"maker_volume", "maker_fills", "orders_placed", "orders_cancelled", "updated_at"})
+
+		if result := tx.Clauses(conflict).Create(r); result.Error != nil {
+			return result.Error
+		}
+	}
+
+	return nil
+}
+
+// MakerTotals are the rollups of a maker summed over a period.
+type MakerTotals struct {
+	MemberID        int64
+	MakerVolume     decimal.Decimal
+	MakerFills      int64
+	OrdersPlaced    int64
+	OrdersCancelled int64
+	QuoteSamples    int64
+	QuotedSamples   int64
+	SpreadSum       decimal.Decimal
+}
+
+func ratio(numerator, denominator decimal.Decimal) decimal.Decimal {
+	if !denominator.IsPositive() {
+		return decimal.Zero
+	}
+
+	return decimalutil.Round(numerator.Div(denominator), makerStatsRatioPrecision, decimalutil.HalfUp)
+}
+
+func (t *MakerTotals) VolumeShare(market_volume decimal.Decimal) decimal.Decimal {
+	return ratio(t.MakerVolume, market_volume)
+}
+
+// AverageSpread is the mean spread of the samples which found the maker quoting both sides.
+func (t *MakerTotals) AverageSpread() decimal.Decimal {
+	return ratio(t.SpreadSum, decimal.NewFromInt(t.QuotedSamples))
+}
+
+// QuoteUptime is the part of the samples finding orders of the maker which found it quoting both sides.
+func (t *MakerTotals) QuoteUptime() decimal.Decimal {
+	return ratio(decimal.NewFromInt(t.QuotedSamples), decimal.NewFromInt(t.QuoteSamples))
+}
+
+// FillRatio is the number of maker fills per order placed.
+func (t *MakerTotals) FillRatio() decimal.Decimal {
+	return ratio(decimal.NewFromInt(t.MakerFills), decimal.NewFromInt(t.OrdersPlaced))
+}
+
+func (t *MakerTotals) CancelRatio() decimal.Decimal {
+	return ratio(decimal.NewFromInt(t.OrdersCancelled), decimal.NewFromInt(t.OrdersPlaced))
+}
+
+// MakerStatsPercentiles rank a maker among the enrolled makers of the market, each is the percentage of
+// the makers doing no better than it.
+type MakerStatsPercentiles struct {
+	VolumeShare   int64
+	AverageSpread int64
+	QuoteUptime   int64
+	FillRatio     int64
+	CancelRatio   int64
+}
+
+type MakerStats struct {
+	Market       string
+	Period       string
+	From         time.Time
+	To           time.Time
+	MarketVolume decimal.Decimal
+	MakerTotals
+	VolumeShare   decimal.Decimal
+	AverageSpread decimal.Decimal
+	QuoteUptime   decimal.Decimal
+	FillRatio     decimal.Decimal
+	CancelRatio   decimal.Decimal
+	// Makers is the number of enrolled makers active on the market over the period, the percentiles are among them
+	Makers      int64
+	Percentiles MakerStatsPercentiles
+}
+
+// percentile is the percentage of values no better than value, lower values are better when lower_better is set.
+func percentile(value decimal.Decimal, values []decimal.Decimal, lower_better bool) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	var no_better int64
+	for _, v := range values {
+		if (lower_better && v.GreaterThanOrEqual(value)) || (!lower_better && v.LessThanOrEqual(value)) {
+			no_better++
+		}
+	}
+
+	return no_better * 100 / int64(len(values))
+}
+
+// ComputeMakerStats computes the stats of member_id from the totals of the makers of a market, the percentiles
+// only tell where the member ranks and nothing of the other makers.
+func ComputeMakerStats(member_id int64, market_volume decimal.Decimal, totals []*MakerTotals) *MakerStats {
+	sort.Slice(totals, func(i, j int) bool { return totals[i].MemberID < totals[j].MemberID })
+
+	stats := &MakerStats{MarketVolume: market_volume, Makers: int64(len(totals))}
+
+	var shares, spreads, uptimes, fills, cancels []decimal.Decimal
+	for _, t := range totals {
+		shares = append(shares, t.VolumeShare(market_volume))
+		uptimes = append(uptimes, t.QuoteUptime())
+		fills = append(fills, t.FillRatio())
+		cancels = append(cancels, t.CancelRatio())
+
+		// makers which never quoted both sides have no spread to rank
+		if t.QuotedSamples > 0 {
+			spreads = append(spreads, t.AverageSpread())
+		}
+
+		if t.MemberID == member_id {
+			stats.MakerTotals = *t
+		}
+	}
+
+	if stats.MemberID != member_id {
+		stats.MemberID = member_id
+		return stats
+	}
+
+	stats.VolumeShare = stats.MakerTotals.VolumeShare(market_volume)
+	stats.AverageSpread = stats.MakerTotals.AverageSpread()
+	stats.QuoteUptime = stats.MakerTotals.QuoteUptime()
+	stats.FillRatio = stats.MakerTotals.FillRatio()
+	stats.CancelRatio = stats.MakerTotals.CancelRatio()
+
+	stats.Percentiles = MakerStatsPercentiles{
+		VolumeShare: percentile(stats.VolumeShare, shares, false),
+		QuoteUptime: percentile(stats.QuoteUptime, uptimes, false),
+		FillRatio:   percentile(stats.FillRatio, fills, false),
+		CancelRatio: percentile(stats.CancelRatio, cancels, true),
+	}
+
+	if stats.QuotedSamples > 0 {
+		stats.Percentiles.AverageSpread = percentile(stats.AverageSpread, spreads, true)
+	}
+
+	return stats
+}
+
+// LoadMakerStats reads the stats of member on market over the period ending with the last hour rolled up before now.
+func LoadMakerStats(tx *gorm.DB, member *Member, market string, period string, now time.Time) (*MakerStats, error) {
+	if !MakerProgramEnrolled(member) {
+		return nil, ErrMakerStatsNotEnrolled
+	}
+
+	duration, found := MakerStatsPeriods[period]
+	if !found {
+		return nil, ErrMakerStatsPeriod
+	}
+
+	to := now.UTC().Truncate(time.Hour)
+	from := to.Add(-duration)
+
+	var market_volume decimal.NullDecimal
+	if result := tx.Model(&MakerStatsRollup{}).
+		Select("SUM(maker_volume)").
+		Where("member_id = ? AND market_id = ? AND hour >= ? AND hour < ?", makerStatsMarketRow, market, from, to).
+		Scan(&market_volume); result.Error != nil {
+		return nil, result.Error
+	}
+
+	var totals []*MakerTotals
+	if result := tx.Model(&MakerStatsRollup{}).
+		Select("member_id, SUM(maker_volume) AS maker_volume, SUM(maker_fills) AS maker_fills, SUM(orders_placed) AS orders_placed, SUM(orders_cancelled) AS orders_cancelled, SUM(quote_samples) AS quote_samples, SUM(quoted_samples) AS quoted_samples, SUM(spread_sum) AS spread_sum").
+		Where("member_id <> ? AND market_id = ? AND hour >= ? AND hour < ?", makerStatsMarketRow, market, from, to).
+		Group("member_id").
+		Scan(&totals); result.Error != nil {
+		return nil, result.Error
+	}
+
+	stats := ComputeMakerStats(member.ID, market_volume.Decimal, totals)
+	stats.Market = market
+	stats.Period = period
+	stats.From = from
+	stats.To = to
+
+	return stats, nil
+}
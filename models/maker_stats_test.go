@@ -0,0 +1,96 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+func TestQuoteSampleSpread(t *testing.T) {
+	tests := []struct {
+		bid, ask string
+		spread   string
+		ok       bool
+	}{
+		{"99", "101", "0.02", true},
+		{"", "101", "0", false},
+		{"99", "", "0", false},
+		// a crossed quote is a sample with no spread
+		{"101", "101", "0", false},
+	}
+
+	for _, test := range tests {
+		sample := &QuoteSample{}
+		if len(test.bid) > 0 {
+			sample.BestBid = decimal.NewNullDecimal(decimal.RequireFromString(test.bid))
+		}
+
+		if len(test.ask) > 0 {
+			sample.BestAsk = decimal.NewNullDecimal(decimal.RequireFromString(test.ask))
+		}
+
+		spread, ok := sample.Spread()
+		if ok != test.ok || !spread.Equal(decimal.RequireFromString(test.spread)) {
+			t.Errorf("%s/%s: expected %s %v, got %s %v", test.bid, test.ask, test.spread, test.ok, spread, ok)
+		}
+	}
+}
+
+func TestComputeMakerStats(t *testing.T) {
+	totals := []*MakerTotals{
+		{MemberID: 1, MakerVolume: decimal.NewFromInt(600), MakerFills: 30, OrdersPlaced: 100, OrdersCancelled: 60, QuoteSamples: 60, QuotedSamples: 54, SpreadSum: decimal.RequireFromString("0.108")},
+		{MemberID: 2, MakerVolume: decimal.NewFromInt(300), MakerFills: 10, OrdersPlaced: 50, OrdersCancelled: 45, QuoteSamples: 60, QuotedSamples: 30, SpreadSum: decimal.RequireFromString("0.15")},
+		{MemberID: 3, MakerVolume: decimal.NewFromInt(100), MakerFills: 1, OrdersPlaced: 200, OrdersCancelled: 20, QuoteSamples: 10},
+		{MemberID: 4, MakerVolume: decimal.Zero, OrdersPlaced: 10, OrdersCancelled: 10, QuoteSamples: 60, QuotedSamples: 60, SpreadSum: decimal.RequireFromString("0.06")},
+	}
+
+	stats := ComputeMakerStats(1, decimal.NewFromInt(2000), totals)
+
+	expected := map[string][2]decimal.Decimal{
+		"volume share":   {stats.VolumeShare, decimal.RequireFromString("0.3")},
+		"average spread": {stats.AverageSpread, decimal.RequireFromString("0.002")},
+		"quote uptime":   {stats.QuoteUptime, decimal.RequireFromString("0.9")},
+		"fill ratio":     {stats.FillRatio, decimal.RequireFromString("0.3")},
+		"cancel ratio":   {stats.CancelRatio, decimal.RequireFromString("0.6")},
+	}
+	for name, values := range expected {
+		if !values[0].Equal(values[1]) {
+			t.Errorf("expected the %s to be %s, got %s", name, values[1], values[0])
+		}
+	}
+
+	// member 3 never quoted both sides, the spread ranks among the three others, the lowest spread best
+	percentiles := MakerStatsPercentiles{VolumeShare: 100, AverageSpread: 66, QuoteUptime: 75, FillRatio: 100, CancelRatio: 75}
+	if stats.Makers != 4 || stats.Percentiles != percentiles {
+		t.Errorf("expected %d makers ranked %+v, got %d %+v", 4, percentiles, stats.Makers, stats.Percentiles)
+	}
+
+	// a maker without activity over the period has nothing to rank
+	stats = ComputeMakerStats(5, decimal.NewFromInt(2000), totals)
+	if stats.MemberID != 5 || !stats.VolumeShare.IsZero() || stats.Percentiles != (MakerStatsPercentiles{}) {
+		t.Errorf("expected empty stats, got %+v", stats)
+	}
+}
+
+func TestMakerProgramEnrolled(t *testing.T) {
+	maker_program := config.MakerProgram
+	t.Cleanup(func() { config.MakerProgram = maker_program })
+
+	config.MakerProgram = &types.MakerProgramConfig{Groups: []string{"market-maker"}}
+
+	if !MakerProgramEnrolled(&Member{Group: "market-maker"}) || MakerProgramEnrolled(&Member{Group: "vip-1"}) {
+		t.Error("expected only the members of the enrolled groups to be enrolled")
+	}
+
+	if _, err := LoadMakerStats(nil, &Member{Group: "vip-1"}, "btcusdt", "7d", time.Now()); err != ErrMakerStatsNotEnrolled {
+		t.Errorf("expected %v, got %v", ErrMakerStatsNotEnrolled, err)
+	}
+
+	if _, err := LoadMakerStats(nil, &Member{Group: "market-maker"}, "btcusdt", "1y", time.Now()); err != ErrMakerStatsPeriod {
+		t.Errorf("expected %v, got %v", ErrMakerStatsPeriod, err)
+	}
+}
@@ -1,10 +1,14 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
+	"github.com/zsmartex/finex/decimalutil"
 	"strings"
 	"time"
 
 	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/types"
 	"github.com/zsmartex/pkg"
 )
 
@@ -20,12 +24,168 @@ type Market struct {
 	MaxPrice        decimal.Decimal `json:"max_price"`
 	MinPrice        decimal.Decimal `json:"min_price"`
 	MinAmount       decimal.Decimal `json:"min_amount"`
-	State           string          `json:"state"`
-	EngineID        int64           `json:"engine_id"`
-	Position        int32           `json:"position"`
-	Data            string          `json:"data"`
-	CreatedAt       time.Time       `json:"created_at"`
-	UpdatedAt       time.Time       `json:"updated_at"`
+	// MaxAmount and MaxQuoteAmount cap the size of an order in the base and the quote currency, zero doesn't cap it
+	MaxAmount      decimal.Decimal `json:"max_amount" gorm:"default:0"`
+	MaxQuoteAmount decimal.Decimal `json:"max_quote_amount" gorm:"default:0"`
+	// LotSize is the step of the order amounts and MinNotional their smallest quote amount, zero doesn't constrain them
+	LotSize         decimal.Decimal `json:"lot_size" gorm:"default:0"`
+	MinNotional     decimal.Decimal `json:"min_notional" gorm:"default:0"`
+	DailyPriceLimit decimal.Decimal `json:"daily_price_limit" gorm:"default:0"`
+	// BatchIntervalMs makes the engine match the market in batch auctions of this many milliseconds, zero matches continuously
+	BatchIntervalMs int64 `json:"batch_interval_ms" gorm:"default:0"`
+	// CircuitBreakerRate makes the engine take cancels only for CircuitBreakerCoolDownSec seconds when a trade moves the
+	// price more than this rate from the price CircuitBreakerWindowSec seconds before it, zero disables the breaker
+	CircuitBreakerRate        decimal.Decimal `json:"circuit_breaker_rate" gorm:"default:0"`
+	CircuitBreakerWindowSec   int64           `json:"circuit_breaker_window_sec" gorm:"default:0"`
+	CircuitBreakerCoolDownSec int64           `json:"circuit_breaker_cool_down_sec" gorm:"default:0"`
+	// ConfigVersion is the latest version of the configuration of the market, see MarketConfigVersion
+	ConfigVersion int64  `json:"config_version" gorm:"default:0"`
+	State         string `json:"state"`
+	EngineID      int64  `json:"engine_id"`
+	Position      int32  `json:"position"`
+	Data          string `json:"data"`
+	// ListingVisibleAt, ListingWarmupAt and ListingOpensAt are the schedule of the listing of a new market,
+	// they're null for markets which weren't listed with a schedule
+	ListingVisibleAt sql.NullTime `json:"listing_visible_at"`
+	ListingWarmupAt  sql.NullTime `json:"listing_warmup_at"`
+	ListingOpensAt   sql.NullTime `json:"listing_opens_at"`
+	// CancelOnly makes the market take cancels only, it's set while the market is delisted
+	CancelOnly bool `json:"cancel_only" gorm:"default:false"`
+	// TradingState is the state an admin set the book of the market to, see MarketTradingStateChange
+	TradingState types.TradingState `json:"trading_state" gorm:"default:trading"`
+	CreatedAt    time.Time          `json:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at"`
+}
+
+var (
+	ErrOrderAmountBelowMin      = errors.New("market.order.amount_below_min")
+	ErrOrderAmountAboveMax      = errors.New("market.order.amount_above_max")
+	ErrOrderQuoteAmountAboveMax = errors.New("market.order.quote_amount_above_max")
+	ErrOrderQuoteAmountBelowMin = errors.New("market.order.quote_amount_below_min")
+	ErrOrderAmountNotLotSize    = errors.New("market.order.amount_not_lot_size")
+)
+
+// MinBatchIntervalMs and MaxBatchIntervalMs bound the batch auctions of a market, shorter batches would cost
+// more than continuous matching and longer ones would leave orders waiting.
+const (
+	MinBatchIntervalMs = 10
+	MaxBatchIntervalMs = 60000
+)
+
+// ValidBatchInterval reports whether a market can match in batch auctions of interval_ms, zero is continuous matching.
+func ValidBatchInterval(interval_ms int64) bool {
+	return interval_ms == 0 || interval_ms >= MinBatchIntervalMs && interval_ms <= MaxBatchIntervalMs
+}
+
+// MaxCircuitBreakerWindowSec bounds the window of the circuit breaker of a market, the engine keeps a price for
+// every second of it with trades.
+const MaxCircuitBreakerWindowSec = 86400
+
+// ValidCircuitBreakerWindow reports whether a market can compare its trades to the price window_sec seconds before.
+func ValidCircuitBreakerWindow(window_sec int64) bool {
+	return window_sec >= 0 && window_sec <= MaxCircuitBreakerWindowSec
+}
+
+// DepthGroupings is how many price steps the depth of a market can be grouped by, the tick of its price and each
+// power of ten above it.
+const DepthGroupings = 5
+
+// DepthSteps returns the price steps the depth of the market can be grouped by, the narrowest first.
+func (m *Market) DepthSteps() []decimal.Decimal {
+	steps := make([]decimal.Decimal, 0, DepthGroupings)
+	for i := 0; i < DepthGroupings; i++ {
+		steps = append(steps, decimal.New(1, int32(i-m.PricePrecision)))
+	}
+
+	return steps
+}
+
+// ValidDepthStep reports whether the depth of the market can be grouped by step, zero doesn't group it.
+func (m *Market) ValidDepthStep(step decimal.Decimal) bool {
+	if step.IsZero() {
+		return true
+	}
+
+	for _, depth_step := range m.DepthSteps() {
+		if step.Equal(depth_step) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BatchInterval is the interval of the batch auctions of the market, zero when it matches continuously.
+func (m *Market) BatchInterval() time.Duration {
+	return time.Duration(m.BatchIntervalMs) * time.Millisecond
+}
+
+// CircuitBreakerWindow and CircuitBreakerCoolDown are the window and the cool-down of the circuit breaker of the market.
+func (m *Market) CircuitBreakerWindow() time.Duration {
+	return time.Duration(m.CircuitBreakerWindowSec) * time.Second
+}
+
+func (m *Market) CircuitBreakerCoolDown() time.Duration {
+	return time.Duration(m.CircuitBreakerCoolDownSec) * time.Second
+}
+
+// EngineTradingState is the state the engine sets the book of the market to: the state an admin set, cancel only
+// while the market is delisted unless it's halted.
+func (m *Market) EngineTradingState() types.TradingState {
+	switch {
+	case m.TradingState == types.TradingStateHalted:
+		return types.TradingStateHalted
+	case m.CancelOnly || m.TradingState == types.TradingStateCancelOnly:
+		return types.TradingStateCancelOnly
+	}
+
+	return types.TradingStateTrading
+}
+
+// ValidateOrderSize checks an order fits the size limits of the market, amounts equal to a limit are accepted.
+// quote_amount is zero when it isn't known before matching, for market sells, and isn't checked then.
+func (m *Market) ValidateOrderSize(amount, quote_amount decimal.Decimal) error {
+	if amount.LessThan(m.MinAmount) {
+		return ErrOrderAmountBelowMin
+	}
+
+	if m.MaxAmount.IsPositive() && amount.GreaterThan(m.MaxAmount) {
+		return ErrOrderAmountAboveMax
+	}
+
+	if m.LotSize.IsPositive() && !amount.Mod(m.LotSize).IsZero() {
+		return ErrOrderAmountNotLotSize
+	}
+
+	if m.MaxQuoteAmount.IsPositive() && quote_amount.GreaterThan(m.MaxQuoteAmount) {
+		return ErrOrderQuoteAmountAboveMax
+	}
+
+	if m.MinNotional.IsPositive() && quote_amount.IsPositive() && quote_amount.LessThan(m.MinNotional) {
+		return ErrOrderQuoteAmountBelowMin
+	}
+
+	return nil
+}
+
+// QuoteQuantityVolume is the volume a market buy of quote_quantity is placed with, what the quote quantity buys at
+// best_ask rounded down to the amount precision and the lot size of the market, within its max amount. The engine
+// stops the order once it spent its quote quantity, the volume only caps what it buys when the asks fell since.
+func (m *Market) QuoteQuantityVolume(quote_quantity, best_ask decimal.Decimal) decimal.Decimal {
+	if !best_ask.IsPositive() {
+		return decimal.Zero
+	}
+
+	volume := decimalutil.Round(quote_quantity.Div(best_ask), int32(m.AmountPrecision), decimalutil.Down)
+	if m.MaxAmount.IsPositive() && volume.GreaterThan(m.MaxAmount) {
+		volume = m.MaxAmount
+	}
+
+	if m.LotSize.IsPositive() {
+		volume = volume.Div(m.LotSize).Floor().Mul(m.LotSize)
+	}
+
+	return volume
 }
 
 func (m *Market) GetSymbol() pkg.Symbol {
@@ -33,13 +193,9 @@ func (m *Market) GetSymbol() pkg.Symbol {
 }
 
 func (m Market) round_price(val decimal.Decimal) decimal.Decimal {
-	value_rounded := val.Round(int32(m.PricePrecision))
-
-	return value_rounded
+	return decimalutil.RoundQuote(val, int32(m.PricePrecision))
 }
 
 func (m Market) round_amount(val decimal.Decimal) decimal.Decimal {
-	value_rounded := val.Round(int32(m.AmountPrecision))
-
-	return value_rounded
+	return decimalutil.RoundBase(val, int32(m.AmountPrecision))
 }
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+var ErrMarketConfigVersionNotFound = errors.New("admin.market.config_version_not_found")
+
+// MarketConfigFee is the trading fee of a member group on the market.
+type MarketConfigFee struct {
+	Group      string            `json:"group"`
+	MarketType types.AccountType `json:"market_type"`
+	Maker      decimal.Decimal   `json:"maker"`
+	Taker      decimal.Decimal   `json:"taker"`
+}
+
+// MarketConfig is the configuration the engine matches a market with, the fees of the groups
+// are the ones set for the market itself, the fees of every market aren't part of it.
+type MarketConfig struct {
+	AmountPrecision int             `json:"amount_precision"`
+	PricePrecision  int             `json:"price_precision"`
+	TotalPrecision  int             `json:"total_precision"`
+	MinPrice        decimal.Decimal `json:"min_price"`
+	MaxPrice        decimal.Decimal `json:"max_price"`
+	MinAmount       decimal.Decimal `json:"min_amount"`
+	MaxAmount       decimal.Decimal `json:"max_amount"`
+	MaxQuoteAmount  decimal.Decimal `json:"max_quote_amount"`
+	LotSize         decimal.Decimal `json:"lot_size"`
+	MinNotional     decimal.Decimal `json:"min_notional"`
+	DailyPriceLimit decimal.Decimal `json:"daily_price_limit"`
+	BatchIntervalMs int64           `json:"batch_interval_ms"`
+	// CircuitBreakerRate, CircuitBreakerWindowSec and CircuitBreakerCoolDownSec are the circuit breaker of the market
+	CircuitBreakerRate        decimal.Decimal            `json:"circuit_breaker_rate"`
+	CircuitBreakerWindowSec   int64                      `json:"circuit_breaker_window_sec"`
+	CircuitBreakerCoolDownSec int64                      `json:"circuit_breaker_cool_down_sec"`
+	FeatureFlags              map[types.FeatureFlag]bool `json:"feature_flags"`
+	TradingFees               []MarketConfigFee          `json:"trading_fees"`
+}
+
+// BuildMarketConfig returns the configuration of market with its feature flags and its trading fees.
+func BuildMarketConfig(market *Market, flags map[types.FeatureFlag]bool, trading_fees []*TradingFee) MarketConfig {
+	fees := make([]MarketConfigFee, 0, len(trading_fees))
+	for _, trading_fee := range trading_fees {
+		fees = append(fees, MarketConfigFee{
+			Group:      trading_fee.Group,
+			MarketType: trading_fee.MarketType,
+			Maker:      trading_fee.Maker,
+			Taker:      trading_fee.Taker,
+		})
+	}
+
+	return MarketConfig{
+		AmountPrecision:           market.AmountPrecision,
+		PricePrecision:            market.PricePrecision,
+		TotalPrecision:            market.TotalPrecision,
+		MinPrice:                  market.MinPrice,
+		MaxPrice:                  market.MaxPrice,
+		MinAmount:                 market.MinAmount,
+		MaxAmount:                 market.MaxAmount,
+		MaxQuoteAmount:            market.MaxQuoteAmount,
+		LotSize:                   market.LotSize,
+		MinNotional:               market.MinNotional,
+		DailyPriceLimit:           market.DailyPriceLimit,
+		BatchIntervalMs:           market.BatchIntervalMs,
+		CircuitBreakerRate:        market.CircuitBreakerRate,
+		CircuitBreakerWindowSec:   market.CircuitBreakerWindowSec,
+		CircuitBreakerCoolDownSec: market.CircuitBreakerCoolDownSec,
+		FeatureFlags:              flags,
+		TradingFees:               fees,
+	}
+}
+
+// MarketConfigVersion is an immutable snapshot of the configuration of a market, every change of the configuration
+// creates the next version. Trades keep the version the engine matched them with.
+type MarketConfigVersion struct {
+	ID       int64  `json:"id" gorm:"primaryKey"`
+	MarketID string `json:"market_id" gorm:"uniqueIndex:index_market_config_versions_on_market_id_and_version"`
+	Version  int64  `json:"version" gorm:"uniqueIndex:index_market_config_versions_on_market_id_and_version"`
+	// Config is the MarketConfig of the version in JSON
+	Config string `json:"config"`
+	// ActorUID is the uid of the admin who changed the configuration, empty for the first version
+	ActorUID  string    `json:"actor_uid"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MarketConfig decodes the configuration of the version.
+func (v *MarketConfigVersion) MarketConfig() (MarketConfig, error) {
+	var market_config MarketConfig
+	err := json.Unmarshal([]byte(v.Config), &market_config)
+
+	return market_config, err
+}
+
+// BumpMarketConfigVersion snapshots the configuration of market as written with tx, and makes it the next version.
+// It's called in the transaction changing the configuration, with the market locked so concurrent changes get
+// a version each.
+func BumpMarketConfigVersion(tx *gorm.DB, market *Market, actor_uid string) (*MarketConfigVersion, error) {
+	var locked *Market
+	if result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, market.ID); result.Error != nil {
+		return nil, result.Error
+	}
+
+	var trading_fees []*TradingFee
+	if result := tx.Where("market_id = ?", locked.Symbol).Order("id asc").Find(&trading_fees); result.Error != nil {
+		return nil, result.Error
+	}
+
+	encoded, err := json.Marshal(BuildMarketConfig(locked, marketFeatureFlags(tx, locked.Symbol), trading_fees))
+	if err != nil {
+		return nil, err
+	}
+
+	version := &MarketConfigVersion{
+		MarketID: locked.Symbol,
+		Version:  locked.ConfigVersion + 1,
+		Config:   string(encoded),
+		ActorUID: actor_uid,
+	}
+
+	if result := tx.Create(&version); result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result := tx.Model(locked).UpdateColumn("config_version", version.Version); result.Error != nil {
+		return nil, result.Error
+	}
+	market.ConfigVersion = version.Version
+
+	return version, nil
+}
+
+// FindMarketConfigVersion returns a version of the configuration of a market.
+func FindMarketConfigVersion(market_id string, version int64) (*MarketConfigVersion, error) {
+	var config_version *MarketConfigVersion
+	result := config.DataBase.Where("market_id = ? AND version = ?", market_id, version).Limit(1).Find(&config_version)
+	if result.Error != nil {
+		return nil, result.Error
+	} else if result.RowsAffected == 0 {
+		return nil, ErrMarketConfigVersionNotFound
+	}
+
+	return config_version, nil
+}
+
+// MarketConfigVersionAt returns the version of the configuration of a market in force at a time.
+func MarketConfigVersionAt(market_id string, at time.Time) (*MarketConfigVersion, error) {
+	var config_version *MarketConfigVersion
+	result := config.DataBase.Where("market_id = ? AND created_at <= ?", market_id, at).Order("version desc").Limit(1).Find(&config_version)
+	if result.Error != nil {
+		return nil, result.Error
+	} else if result.RowsAffected == 0 {
+		return nil, ErrMarketConfigVersionNotFound
+	}
+
+	return config_version, nil
+}
+
+// SnapshotMarketConfigs gives the first version of their configuration to the markets configured before it was versioned.
+const SnapshotMarketConfigs = "snapshot_market_configs"
+
+func init() {
+	RegisterBackgroundMigration(SnapshotMarketConfigs, snapshotMarketConfigs)
+}
+
+func snapshotMarketConfigs(tx *gorm.DB, cursor int64, batch_size int) (int64, int64, error) {
+	var markets []*Market
+	if result := tx.Where("id > ?", cursor).Order("id asc").Limit(batch_size).Find(&markets); result.Error != nil {
+		return cursor, 0, result.Error
+	}
+
+	if len(markets) == 0 {
+		return cursor, 0, nil
+	}
+
+	for _, market := range markets {
+		if market.ConfigVersion > 0 {
+			continue
+		}
+
+		if _, err := BumpMarketConfigVersion(tx, market, ""); err != nil {
+			return cursor, 0, err
+		}
+	}
+
+	return markets[len(markets)-1].ID, int64(len(markets)), nil
+}
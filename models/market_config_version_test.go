@@ -0,0 +1,55 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/types"
+)
+
+func TestMarketConfigSnapshot(t *testing.T) {
+	market := &Market{
+		Symbol:          "btcusdt",
+		AmountPrecision: 4,
+		PricePrecision:  2,
+		MinAmount:       decimal.RequireFromString("0.0001"),
+		MaxAmount:       decimal.NewFromInt(100),
+		DailyPriceLimit: decimal.RequireFromString("0.1"),
+		BatchIntervalMs: 200,
+	}
+	flags := map[types.FeatureFlag]bool{types.FeatureFlags[0]: true}
+	fees := []*TradingFee{{MarketID: "btcusdt", Group: "vip-1", Maker: decimal.RequireFromString("0.001"), Taker: decimal.RequireFromString("0.002")}}
+
+	encoded, err := json.Marshal(BuildMarketConfig(market, flags, fees))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// later changes of the market don't change the snapshot
+	market.MaxAmount = decimal.NewFromInt(5)
+	fees[0].Taker = decimal.Zero
+
+	version := &MarketConfigVersion{MarketID: "btcusdt", Version: 3, Config: string(encoded)}
+	market_config, err := version.MarketConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if market_config.AmountPrecision != 4 || market_config.PricePrecision != 2 || market_config.BatchIntervalMs != 200 {
+		t.Errorf("unexpected precisions %+v", market_config)
+	}
+
+	if !market_config.MaxAmount.Equal(decimal.NewFromInt(100)) || !market_config.DailyPriceLimit.Equal(decimal.RequireFromString("0.1")) {
+		t.Errorf("unexpected limits %s and %s", market_config.MaxAmount, market_config.DailyPriceLimit)
+	}
+
+	if !market_config.FeatureFlags[types.FeatureFlags[0]] {
+		t.Errorf("expected the feature flags of the version, got %v", market_config.FeatureFlags)
+	}
+
+	if len(market_config.TradingFees) != 1 || market_config.TradingFees[0].Group != "vip-1" || !market_config.TradingFees[0].Taker.Equal(decimal.RequireFromString("0.002")) {
+		t.Errorf("unexpected trading fees %+v", market_config.TradingFees)
+	}
+}
@@ -0,0 +1,246 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zsmartex/finex/config"
+)
+
+type MarketDataTier string
+
+var (
+	MarketDataTierAnonymous  MarketDataTier = "anonymous"
+	MarketDataTierMember     MarketDataTier = "member"
+	MarketDataTierSubscriber MarketDataTier = "subscriber"
+)
+
+var MarketDataTiers = []MarketDataTier{MarketDataTierAnonymous, MarketDataTierMember, MarketDataTierSubscriber}
+
+// marketDataPoliciesTTL is how long a replica serves the policies before reading them again.
+const marketDataPoliciesTTL = 30 * time.Second
+
+var (
+	ErrMarketDataTier         = errors.New("admin.market_data_policy.invalid_tier")
+	ErrMarketDataPolicyLimit  = errors.New("admin.market_data_policy.invalid_limit")
+	ErrMarketDataPolicyPeriod = errors.New("admin.market_data_policy.invalid_period")
+	ErrMarketDataPeriodDenied = errors.New("public.market_data.period_not_permitted")
+)
+
+// MarketDataPolicy is how much of the public market data a tier of users gets, a limit of 0 is no limit.
+// The policies are edited by admins and picked up by every replica within marketDataPoliciesTTL.
+type MarketDataPolicy struct {
+	Tier MarketDataTier `json:"tier" gorm:"primaryKey"`
+	// DepthLevels is the number of price levels of each side of the depth
+	DepthLevels int64 `json:"depth_levels"`
+	// TradesLimit is the number of recent trades
+	TradesLimit int64 `json:"trades_limit"`
+	// CandlePeriods are the comma separated periods of the candles which can be read, empty allows every period
+	CandlePeriods string    `json:"candle_periods"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// DefaultMarketDataPolicies are the policies of the tiers an admin didn't set, anonymous users get coarse data.
+var DefaultMarketDataPolicies = map[MarketDataTier]*MarketDataPolicy{
+	MarketDataTierAnonymous:  {Tier: MarketDataTierAnonymous, DepthLevels: 20, TradesLimit: 50, CandlePeriods: "1m"},
+	MarketDataTierMember:     {Tier: MarketDataTierMember},
+	MarketDataTierSubscriber: {Tier: MarketDataTierSubscriber},
+}
+
+// MarketDataTierOf is the tier of the user of a request, member is nil for anonymous requests.
+func MarketDataTierOf(member *Member) MarketDataTier {
+	switch {
+	case member == nil:
+		return MarketDataTierAnonymous
+	case member.MarketDataSubscription:
+		return MarketDataTierSubscriber
+	default:
+		return MarketDataTierMember
+	}
+}
+
+func ValidMarketDataTier(tier MarketDataTier) bool {
+	_, ok := DefaultMarketDataPolicies[tier]
+	return ok
+}
+
+// Validate checks the limits and periods of the policy.
+func (p *MarketDataPolicy) Validate() error {
+	if !ValidMarketDataTier(p.Tier) {
+		return ErrMarketDataTier
+	}
+
+	if p.DepthLevels < 0 || p.TradesLimit < 0 {
+		return ErrMarketDataPolicyLimit
+	}
+
+	for _, period := range p.Periods() {
+		if _, ok := PriceSeriesPeriods[period]; !ok {
+			return ErrMarketDataPolicyPeriod
+		}
+	}
+
+	return nil
+}
+
+// Periods returns the periods of the candles which can be read, none when every period can be.
+func (p *MarketDataPolicy) Periods() []string {
+	periods := make([]string, 0)
+	for _, period := range strings.Split(p.CandlePeriods, ",") {
+		if period = strings.TrimSpace(period); len(period) > 0 {
+			periods = append(periods, period)
+		}
+	}
+
+	return periods
+}
+
+// capLimit caps the requested number of rows to the limit of the policy, and reports whether it did.
+func capLimit(requested, limit int64) (int64, bool) {
+	if limit > 0 && (requested <= 0 || requested > limit) {
+		return limit, true
+	}
+
+	return requested, false
+}
+
+// DepthLimit returns the number of levels served for the requested number, and whether the policy cut it.
+func (p *MarketDataPolicy) DepthLimit(requested int64) (int64, bool) {
+	return capLimit(requested, p.DepthLevels)
+}
+
+// TradesCount returns the number of trades served for the requested number, and whether the policy cut it.
+func (p *MarketDataPolicy) TradesCount(requested int64) (int64, bool) {
+	return capLimit(requested, p.TradesLimit)
+}
+
+// AllowsCandlePeriod reports whether the candles of period can be read.
+func (p *MarketDataPolicy) AllowsCandlePeriod(period string) bool {
+	periods := p.Periods()
+	if len(periods) == 0 {
+		return true
+	}
+
+	for _, allowed := range periods {
+		if allowed == period {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DefaultCandlePeriod is the period served when none is asked for, fallback when the policy allows it.
+func (p *MarketDataPolicy) DefaultCandlePeriod(fallback string) string {
+	if periods := p.Periods(); !p.AllowsCandlePeriod(fallback) {
+		return periods[0]
+	}
+
+	return fallback
+}
+
+// FilterStreams drops the candle streams ("<market>.kline-<period>") of the periods the policy doesn't allow,
+// the websocket gateway asks for it when a client subscribes.
+func (p *MarketDataPolicy) FilterStreams(streams []string) []string {
+	filtered := make([]string, 0, len(streams))
+	for _, stream := range streams {
+		if i := strings.Index(stream, ".kline-"); i >= 0 && !p.AllowsCandlePeriod(stream[i+len(".kline-"):]) {
+			continue
+		}
+
+		filtered = append(filtered, stream)
+	}
+
+	return filtered
+}
+
+// MarketDataPolicyCache keeps the policies in memory for marketDataPoliciesTTL.
+type MarketDataPolicyCache struct {
+	sync.Mutex
+	policies  map[MarketDataTier]*MarketDataPolicy
+	expiresAt time.Time
+	load      func() []*MarketDataPolicy
+}
+
+func loadMarketDataPolicies() []*MarketDataPolicy {
+	var policies []*MarketDataPolicy
+	if result := config.DataBase.Find(&policies); result.Error != nil {
+		config.Logger.Errorf("Failed to load the market data policies: %v", result.Error)
+	}
+
+	return policies
+}
+
+// MarketDataPolicies are the policies served by the API.
+var MarketDataPolicies = &MarketDataPolicyCache{load: loadMarketDataPolicies}
+
+// BuildMarketDataPolicies sets the stored policies over the defaults.
+func BuildMarketDataPolicies(stored []*MarketDataPolicy) map[MarketDataTier]*MarketDataPolicy {
+	policies := make(map[MarketDataTier]*MarketDataPolicy, len(DefaultMarketDataPolicies))
+	for tier, policy := range DefaultMarketDataPolicies {
+		policies[tier] = policy
+	}
+
+	for _, policy := range stored {
+		if ValidMarketDataTier(policy.Tier) {
+			policies[policy.Tier] = policy
+		}
+	}
+
+	return policies
+}
+
+func (c *MarketDataPolicyCache) current() map[MarketDataTier]*MarketDataPolicy {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if c.policies == nil || now.After(c.expiresAt) {
+		c.policies = BuildMarketDataPolicies(c.load())
+		c.expiresAt = now.Add(marketDataPoliciesTTL)
+	}
+
+	return c.policies
+}
+
+// Invalidate reloads the policies on the next read, other replicas reload them within marketDataPoliciesTTL.
+func (c *MarketDataPolicyCache) Invalidate() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.policies = nil
+}
+
+// Of returns the policy of tier.
+func (c *MarketDataPolicyCache) Of(tier MarketDataTier) *MarketDataPolicy {
+	return c.current()[tier]
+}
+
+// All returns the policy of every tier, in the order of MarketDataTiers.
+func (c *MarketDataPolicyCache) All() []*MarketDataPolicy {
+	policies := c.current()
+
+	all := make([]*MarketDataPolicy, 0, len(MarketDataTiers))
+	for _, tier := range MarketDataTiers {
+		all = append(all, policies[tier])
+	}
+
+	return all
+}
+
+// SaveMarketDataPolicy stores the policy of its tier, it's served by this replica right away.
+func SaveMarketDataPolicy(policy *MarketDataPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	if result := config.DataBase.Save(policy); result.Error != nil {
+		return result.Error
+	}
+
+	MarketDataPolicies.Invalidate()
+
+	return nil
+}
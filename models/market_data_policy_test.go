@@ -0,0 +1,123 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMarketDataTierOf(t *testing.T) {
+	if tier := MarketDataTierOf(nil); tier != MarketDataTierAnonymous {
+		t.Errorf("expected an anonymous request to be anonymous, got %s", tier)
+	}
+
+	if tier := MarketDataTierOf(&Member{ID: 1}); tier != MarketDataTierMember {
+		t.Errorf("expected a member to be member, got %s", tier)
+	}
+
+	if tier := MarketDataTierOf(&Member{ID: 1, MarketDataSubscription: true}); tier != MarketDataTierSubscriber {
+		t.Errorf("expected a subscribed member to be subscriber, got %s", tier)
+	}
+}
+
+func TestMarketDataPolicyTiers(t *testing.T) {
+	policies := BuildMarketDataPolicies(nil)
+	streams := []string{"btcusdt.depth", "btcusdt.trades", "btcusdt.kline-1m", "btcusdt.kline-1h", "global.tickers"}
+
+	tests := []struct {
+		tier        MarketDataTier
+		depth       int64
+		depthCut    bool
+		trades      int64
+		tradesCut   bool
+		hourCandles bool
+		visible     []string
+	}{
+		{MarketDataTierAnonymous, 20, true, 50, true, false, []string{"btcusdt.depth", "btcusdt.trades", "btcusdt.kline-1m", "global.tickers"}},
+		{MarketDataTierMember, 100, false, 100, false, true, streams},
+		{MarketDataTierSubscriber, 100, false, 100, false, true, streams},
+	}
+
+	for _, test := range tests {
+		policy := policies[test.tier]
+
+		if depth, cut := policy.DepthLimit(100); depth != test.depth || cut != test.depthCut {
+			t.Errorf("%s: expected %d depth levels (cut %v), got %d (%v)", test.tier, test.depth, test.depthCut, depth, cut)
+		}
+
+		if trades, cut := policy.TradesCount(100); trades != test.trades || cut != test.tradesCut {
+			t.Errorf("%s: expected %d trades (cut %v), got %d (%v)", test.tier, test.trades, test.tradesCut, trades, cut)
+		}
+
+		if !policy.AllowsCandlePeriod("1m") || policy.AllowsCandlePeriod("1h") != test.hourCandles {
+			t.Errorf("%s: unexpected candle periods %q", test.tier, policy.CandlePeriods)
+		}
+
+		if visible := policy.FilterStreams(streams); !reflect.DeepEqual(visible, test.visible) {
+			t.Errorf("%s: expected streams %v, got %v", test.tier, test.visible, visible)
+		}
+	}
+}
+
+func TestMarketDataPolicyBelowLimit(t *testing.T) {
+	policy := DefaultMarketDataPolicies[MarketDataTierAnonymous]
+
+	if depth, cut := policy.DepthLimit(5); depth != 5 || cut {
+		t.Errorf("expected a request under the limit to be served as is, got %d (%v)", depth, cut)
+	}
+
+	if period := policy.DefaultCandlePeriod("1h"); period != "1m" {
+		t.Errorf("expected the default period to be an allowed one, got %s", period)
+	}
+
+	if period := DefaultMarketDataPolicies[MarketDataTierMember].DefaultCandlePeriod("1h"); period != "1h" {
+		t.Errorf("expected members to keep the default period, got %s", period)
+	}
+}
+
+func TestMarketDataPolicyRuntimeChanges(t *testing.T) {
+	stored := []*MarketDataPolicy{}
+	cache := &MarketDataPolicyCache{load: func() []*MarketDataPolicy { return stored }}
+
+	if depth, _ := cache.Of(MarketDataTierAnonymous).DepthLimit(100); depth != 20 {
+		t.Fatalf("expected the default anonymous policy, got %d levels", depth)
+	}
+
+	stored = []*MarketDataPolicy{{Tier: MarketDataTierAnonymous, DepthLevels: 5, CandlePeriods: "1m,5m"}}
+	cache.Invalidate()
+
+	policy := cache.Of(MarketDataTierAnonymous)
+	if depth, _ := policy.DepthLimit(100); depth != 5 {
+		t.Errorf("expected the stored policy to be served, got %d levels", depth)
+	}
+
+	if trades, cut := policy.TradesCount(100); trades != 100 || cut {
+		t.Errorf("expected no trades limit, got %d (%v)", trades, cut)
+	}
+
+	if !policy.AllowsCandlePeriod("5m") {
+		t.Error("expected the stored periods to be allowed")
+	}
+
+	if len(cache.All()) != len(MarketDataTiers) {
+		t.Errorf("expected a policy for every tier, got %d", len(cache.All()))
+	}
+}
+
+func TestMarketDataPolicyValidate(t *testing.T) {
+	tests := []struct {
+		policy *MarketDataPolicy
+		err    error
+	}{
+		{&MarketDataPolicy{Tier: MarketDataTierAnonymous, DepthLevels: 10, CandlePeriods: "1m, 1h"}, nil},
+		{&MarketDataPolicy{Tier: "premium"}, ErrMarketDataTier},
+		{&MarketDataPolicy{Tier: MarketDataTierMember, TradesLimit: -1}, ErrMarketDataPolicyLimit},
+		{&MarketDataPolicy{Tier: MarketDataTierMember, CandlePeriods: "7m"}, ErrMarketDataPolicyPeriod},
+	}
+
+	for _, test := range tests {
+		if err := test.policy.Validate(); !errors.Is(err, test.err) {
+			t.Errorf("%+v: expected %v, got %v", test.policy, test.err, err)
+		}
+	}
+}
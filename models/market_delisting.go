@@ -0,0 +1,602 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+	"github.com/zsmartex/pkg"
+)
+
+const (
+	// DefaultDelistingInterval is how often the due steps of the delistings run when delisting.interval isn't set.
+	DefaultDelistingInterval = 10 * time.Second
+	// DefaultDelistingCancelTimeout is how long the engine has to cancel the orders when delisting.cancel_timeout isn't set.
+	DefaultDelistingCancelTimeout = 5 * time.Minute
+)
+
+type MarketDelistingState string
+
+var (
+	MarketDelistingStateScheduled MarketDelistingState = "scheduled"
+	// MarketDelistingStateCancelOnly is a delisting whose market takes cancels only
+	MarketDelistingStateCancelOnly MarketDelistingState = "cancel_only"
+	// MarketDelistingStateCancelling is a delisting cancelling the orders of its market, it can't be aborted anymore
+	MarketDelistingStateCancelling MarketDelistingState = "cancelling"
+	// MarketDelistingStateHalted is a delisting whose market was halted and hidden
+	MarketDelistingStateHalted   MarketDelistingState = "halted"
+	MarketDelistingStateDelisted MarketDelistingState = "delisted"
+	MarketDelistingStateAborted  MarketDelistingState = "aborted"
+)
+
+// MarketDelistingStep is a step of a delisting, they run in the order they're declared.
+type MarketDelistingStep string
+
+var (
+	// MarketDelistingStepCancelOnly makes the market take cancels only from CancelOnlyAt
+	MarketDelistingStepCancelOnly MarketDelistingStep = "cancel_only"
+	// MarketDelistingStepCancelOrders cancels the orders of the market from CancelAt and releases their funds
+	MarketDelistingStepCancelOrders MarketDelistingStep = "cancel_orders"
+	// MarketDelistingStepHalt halts and hides the market from HaltAt
+	MarketDelistingStepHalt MarketDelistingStep = "halt"
+	// MarketDelistingStepSnapshot keeps the final ticker and candles of the market
+	MarketDelistingStepSnapshot MarketDelistingStep = "snapshot"
+	// MarketDelistingStepDelist marks the market delisted
+	MarketDelistingStepDelist MarketDelistingStep = "delist"
+)
+
+// DelistingSnapshotPeriods are the periods of the final candles kept by a delisting, DelistingTickerPeriod is its final ticker.
+var (
+	DelistingSnapshotPeriods = []string{"1m", "1h", "1d"}
+	DelistingTickerPeriod    = "24h"
+)
+
+var (
+	ErrDelistingSchedule     = errors.New("admin.market.invalid_delisting_schedule")
+	ErrDelistingMarketState  = errors.New("admin.market.not_delistable")
+	ErrDelistingExists       = errors.New("admin.market.delisting_exists")
+	ErrDelistingNotAbortable = errors.New("admin.market.delisting_not_abortable")
+	ErrMarketCancelOnly      = errors.New("market.order.market_cancel_only")
+
+	// errDelistingEnded stops the steps of a delisting aborted while they ran
+	errDelistingEnded = errors.New("the delisting ended")
+)
+
+// MarketDelisting is the delisting of a market, the market delister runs its steps once they're due. Every step
+// can run again after a failure, it's marked done once its effects are applied.
+type MarketDelisting struct {
+	ID       int64  `json:"id" gorm:"primaryKey"`
+	MarketID string `json:"market_id" gorm:"index"`
+	// CancelOnlyAt, CancelAt and HaltAt are when the market takes cancels only, when its orders are cancelled
+	// and when it's halted, the delisting completes right after the halt
+	CancelOnlyAt time.Time            `json:"cancel_only_at"`
+	CancelAt     time.Time            `json:"cancel_at"`
+	HaltAt       time.Time            `json:"halt_at"`
+	State        MarketDelistingState `json:"state" gorm:"default:scheduled;index"`
+	// CreatedBy is the uid of the admin who scheduled the delisting, empty when it was scheduled from the command line
+	CreatedBy        string       `json:"created_by"`
+	CancelOnlyDoneAt sql.NullTime `json:"cancel_only_done_at"`
+	// CancelStartedAt is when the orders started to be cancelled, the delisting can't be aborted from then
+	CancelStartedAt sql.NullTime `json:"cancel_started_at"`
+	CancelDoneAt    sql.NullTime `json:"cancel_done_at"`
+	HaltDoneAt      sql.NullTime `json:"halt_done_at"`
+	SnapshotDoneAt  sql.NullTime `json:"snapshot_done_at"`
+	DelistedAt      sql.NullTime `json:"delisted_at"`
+	AbortedAt       sql.NullTime `json:"aborted_at"`
+	CreatedAt       time.Time    `json:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at"`
+}
+
+// MarketDelistingLog is a line of the progress of a delisting.
+type MarketDelistingLog struct {
+	ID          int64               `json:"id" gorm:"primaryKey"`
+	DelistingID int64               `json:"delisting_id" gorm:"index"`
+	Step        MarketDelistingStep `json:"step"`
+	Message     string              `json:"message"`
+	CreatedAt   time.Time           `json:"created_at"`
+}
+
+// MarketDelistingSnapshot is a final candle of a delisted market, the one of DelistingTickerPeriod is its final ticker
+// over the day before the halt.
+type MarketDelistingSnapshot struct {
+	ID          int64           `json:"id" gorm:"primaryKey"`
+	DelistingID int64           `json:"delisting_id" gorm:"uniqueIndex:index_market_delisting_snapshots_on_delisting_id_and_period"`
+	Period      string          `json:"period" gorm:"uniqueIndex:index_market_delisting_snapshots_on_delisting_id_and_period"`
+	Time        time.Time       `json:"time"`
+	Open        decimal.Decimal `json:"open"`
+	High        decimal.Decimal `json:"high"`
+	Low         decimal.Decimal `json:"low"`
+	Close       decimal.Decimal `json:"close"`
+	Volume      decimal.Decimal `json:"volume"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// DelistingEngine is the matching engine as a delisting drives it.
+type DelistingEngine interface {
+	// Reload rebuilds the book of the market from its orders and its settings
+	Reload(market *Market) error
+	Cancel(order *Order) error
+}
+
+// BusDelistingEngine sends the commands of the delistings to the matching engine through the event bus.
+type BusDelistingEngine struct{}
+
+func (BusDelistingEngine) Reload(market *Market) error {
+	return config.Bus.Publish("matching", market.Symbol, map[string]interface{}{
+		"action": pkg.ActionReload,
+		"symbol": market.GetSymbol(),
+	})
+}
+
+func (BusDelistingEngine) Cancel(order *Order) error {
+	return config.Bus.Publish("matching", order.MarketID, map[string]interface{}{
+		"action": pkg.ActionCancel,
+		"order":  order.ToMatchingAttributes(),
+	})
+}
+
+func DelistingInterval() time.Duration {
+	if config.Delisting.Interval > 0 {
+		return config.Delisting.Interval
+	}
+
+	return DefaultDelistingInterval
+}
+
+func DelistingCancelTimeout() time.Duration {
+	if config.Delisting.CancelTimeout > 0 {
+		return config.Delisting.CancelTimeout
+	}
+
+	return DefaultDelistingCancelTimeout
+}
+
+// NewMarketDelisting checks the schedule of the delisting of market, a zero cancel_only_at makes it take cancels only right away.
+func NewMarketDelisting(market *Market, cancel_only_at, cancel_at, halt_at time.Time, created_by string, now time.Time) (*MarketDelisting, error) {
+	switch types.MarketState(market.State) {
+	case types.MarketStateEndabled, types.MarketStateHalted:
+	default:
+		return nil, ErrDelistingMarketState
+	}
+
+	if cancel_only_at.IsZero() {
+		cancel_only_at = now
+	}
+
+	if cancel_only_at.Before(now) || !cancel_at.After(now) || cancel_at.Before(cancel_only_at) || halt_at.Before(cancel_at) {
+		return nil, ErrDelistingSchedule
+	}
+
+	return &MarketDelisting{
+		MarketID:     market.Symbol,
+		CancelOnlyAt: cancel_only_at,
+		CancelAt:     cancel_at,
+		HaltAt:       halt_at,
+		State:        MarketDelistingStateScheduled,
+		CreatedBy:    created_by,
+	}, nil
+}
+
+// Terminal reports whether the delisting completed or was aborted.
+func (d *MarketDelisting) Terminal() bool {
+	return d.State == MarketDelistingStateDelisted || d.State == MarketDelistingStateAborted
+}
+
+// NextStep returns the step the delisting runs next and whether it's due at now, an empty step when it's terminal.
+func (d *MarketDelisting) NextStep(now time.Time) (MarketDelistingStep, bool) {
+	switch {
+	case d.Terminal():
+		return "", false
+	case !d.CancelOnlyDoneAt.Valid:
+		return MarketDelistingStepCancelOnly, !now.Before(d.CancelOnlyAt)
+	case !d.CancelDoneAt.Valid:
+		return MarketDelistingStepCancelOrders, !now.Before(d.CancelAt)
+	case !d.HaltDoneAt.Valid:
+		return MarketDelistingStepHalt, !now.Before(d.HaltAt)
+	case !d.SnapshotDoneAt.Valid:
+		return MarketDelistingStepSnapshot, true
+	default:
+		return MarketDelistingStepDelist, true
+	}
+}
+
+// Abortable reports whether the delisting can be aborted at now, until its orders start to be cancelled.
+func (d *MarketDelisting) Abortable(now time.Time) bool {
+	return !d.Terminal() && !d.CancelStartedAt.Valid && now.Before(d.CancelAt)
+}
+
+func (d *MarketDelisting) log(tx *gorm.DB, step MarketDelistingStep, format string, args ...interface{}) error {
+	message := fmt.Sprintf(format, args...)
+	config.Logger.Infof("Delisting of market %s, %s: %s", d.MarketID, step, message)
+
+	return tx.Create(&MarketDelistingLog{DelistingID: d.ID, Step: step, Message: message}).Error
+}
+
+// ScheduleMarketDelisting creates the delisting, a market has one delisting which isn't terminal at most.
+func ScheduleMarketDelisting(tx *gorm.DB, delisting *MarketDelisting) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		var market *Market
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&market, "symbol = ?", delisting.MarketID); result.Error != nil {
+			return result.Error
+		}
+
+		var pending int64
+		if result := tx.Model(&MarketDelisting{}).
+			Where("market_id = ? AND state NOT IN ?", delisting.MarketID, []MarketDelistingState{MarketDelistingStateDelisted, MarketDelistingStateAborted}).
+			Count(&pending); result.Error != nil {
+			return result.Error
+		}
+
+		if pending > 0 {
+			return ErrDelistingExists
+		}
+
+		if err := tx.Create(delisting).Error; err != nil {
+			return err
+		}
+
+		return delisting.log(tx, "", "scheduled, cancel only at %s, cancels at %s, halt at %s",
+			delisting.CancelOnlyAt.Format(time.RFC3339), delisting.CancelAt.Format(time.RFC3339), delisting.HaltAt.Format(time.RFC3339))
+	})
+}
+
+// lockDelisting reloads the delisting with its row locked, the delister and an abort can't run a step at the same time.
+func lockDelisting(tx *gorm.DB, delisting *MarketDelisting) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(delisting, delisting.ID).Error
+}
+
+// AbortMarketDelisting aborts the delisting before its orders are cancelled, the market takes orders again.
+func AbortMarketDelisting(tx *gorm.DB, delisting *MarketDelisting, actor_uid string, engine DelistingEngine, now time.Time) error {
+	var market *Market
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		if err := lockDelisting(tx, delisting); err != nil {
+			return err
+		}
+
+		if !delisting.Abortable(now) {
+			return ErrDelistingNotAbortable
+		}
+
+		if result := tx.First(&market, "symbol = ?", delisting.MarketID); result.Error != nil {
+			return result.Error
+		}
+
+		if market.CancelOnly {
+			if err := tx.Model(market).Update("cancel_only", false).Error; err != nil {
+				return err
+			}
+		}
+
+		delisting.State = MarketDelistingStateAborted
+		delisting.AbortedAt = sql.NullTime{Time: now, Valid: true}
+		if err := tx.Save(delisting).Error; err != nil {
+			return err
+		}
+
+		return delisting.log(tx, "", "aborted by %s", actorName(actor_uid))
+	})
+	if err != nil {
+		return err
+	}
+
+	if delisting.CancelOnlyDoneAt.Valid {
+		return engine.Reload(market)
+	}
+
+	return nil
+}
+
+func actorName(actor_uid string) string {
+	if len(actor_uid) == 0 {
+		return "the command line"
+	}
+
+	return actor_uid
+}
+
+// RunMarketDelisting runs the steps of the delisting due at now one after the other, it stops at the first step
+// which isn't due or is still in progress.
+func RunMarketDelisting(tx *gorm.DB, delisting *MarketDelisting, engine DelistingEngine, now time.Time) error {
+	for {
+		step, due := delisting.NextStep(now)
+		if !due {
+			return nil
+		}
+
+		done, err := runDelistingStep(tx, delisting, step, engine, now)
+		if errors.Is(err, errDelistingEnded) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", step, err)
+		}
+
+		if !done {
+			return nil
+		}
+	}
+}
+
+func runDelistingStep(tx *gorm.DB, delisting *MarketDelisting, step MarketDelistingStep, engine DelistingEngine, now time.Time) (bool, error) {
+	switch step {
+	case MarketDelistingStepCancelOnly:
+		return true, delistCancelOnly(tx, delisting, engine, now)
+	case MarketDelistingStepCancelOrders:
+		return delistCancelOrders(tx, delisting, engine, now)
+	case MarketDelistingStepHalt:
+		return true, delistHalt(tx, delisting, now)
+	case MarketDelistingStepSnapshot:
+		return true, delistSnapshot(tx, delisting, now)
+	case MarketDelistingStepDelist:
+		return true, delistMarket(tx, delisting, now)
+	}
+
+	return false, fmt.Errorf("unknown step %s", step)
+}
+
+// completeDelistingStep marks the step done with done_at unless the delisting was aborted meanwhile.
+func completeDelistingStep(tx *gorm.DB, delisting *MarketDelisting, step MarketDelistingStep, apply func(tx *gorm.DB, market *Market) error, message string) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if err := lockDelisting(tx, delisting); err != nil {
+			return err
+		}
+
+		if delisting.Terminal() {
+			return errDelistingEnded
+		}
+
+		var market *Market
+		if result := tx.First(&market, "symbol = ?", delisting.MarketID); result.Error != nil {
+			return result.Error
+		}
+
+		if err := apply(tx, market); err != nil {
+			return err
+		}
+
+		if err := tx.Save(delisting).Error; err != nil {
+			return err
+		}
+
+		return delisting.log(tx, step, message)
+	})
+}
+
+func delistCancelOnly(tx *gorm.DB, delisting *MarketDelisting, engine DelistingEngine, now time.Time) error {
+	var market *Market
+	if err := completeDelistingStep(tx, delisting, MarketDelistingStepCancelOnly, func(tx *gorm.DB, locked *Market) error {
+		market = locked
+
+		if err := tx.Model(market).Update("cancel_only", true).Error; err != nil {
+			return err
+		}
+
+		delisting.State = MarketDelistingStateCancelOnly
+		delisting.CancelOnlyDoneAt = sql.NullTime{Time: now, Valid: true}
+
+		return nil
+	}, "the market takes cancels only"); err != nil {
+		return err
+	}
+
+	// the engine reloaded twice after a failure is left as it was
+	return engine.Reload(market)
+}
+
+// delistCancelOrders sends the cancels of the orders of the market to the engine until none is left waiting,
+// the orders still waiting after the cancel timeout are cancelled without the engine.
+func delistCancelOrders(tx *gorm.DB, delisting *MarketDelisting, engine DelistingEngine, now time.Time) (bool, error) {
+	if !delisting.CancelStartedAt.Valid {
+		if err := tx.Transaction(func(tx *gorm.DB) error {
+			if err := lockDelisting(tx, delisting); err != nil {
+				return err
+			}
+
+			if delisting.Terminal() {
+				return ErrDelistingNotAbortable
+			}
+
+			delisting.State = MarketDelistingStateCancelling
+			delisting.CancelStartedAt = sql.NullTime{Time: now, Valid: true}
+			if err := tx.Save(delisting).Error; err != nil {
+				return err
+			}
+
+			return delisting.log(tx, MarketDelistingStepCancelOrders, "cancelling the orders")
+		}); err != nil {
+			return false, err
+		}
+	}
+
+	var orders []*Order
+	if result := tx.Where("market_id = ? AND state IN ?", delisting.MarketID, []OrderState{StateWait, StatePending}).Order("id asc").Find(&orders); result.Error != nil {
+		return false, result.Error
+	}
+
+	if len(orders) == 0 {
+		return true, completeDelistingStep(tx, delisting, MarketDelistingStepCancelOrders, func(tx *gorm.DB, market *Market) error {
+			delisting.CancelDoneAt = sql.NullTime{Time: now, Valid: true}
+
+			return nil
+		}, "no order left")
+	}
+
+	forced := now.Sub(delisting.CancelStartedAt.Time) >= DelistingCancelTimeout()
+
+	waiting := 0
+	for _, order := range orders {
+		// a pending order reaches the engine once its funds are locked, the engine rejects it
+		if order.State != StateWait {
+			continue
+		}
+
+		waiting++
+
+		var err error
+		if forced {
+			err = CancelOrder(order.ID)
+		} else {
+			err = engine.Cancel(order)
+		}
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if forced {
+		return false, delisting.log(tx, MarketDelistingStepCancelOrders, "cancelled %d orders left by the engine, %d orders pending", waiting, len(orders)-waiting)
+	}
+
+	return false, nil
+}
+
+func delistHalt(tx *gorm.DB, delisting *MarketDelisting, now time.Time) error {
+	return completeDelistingStep(tx, delisting, MarketDelistingStepHalt, func(tx *gorm.DB, market *Market) error {
+		if err := tx.Model(market).Update("state", types.MarketStateDisabled).Error; err != nil {
+			return err
+		}
+
+		delisting.State = MarketDelistingStateHalted
+		delisting.HaltDoneAt = sql.NullTime{Time: now, Valid: true}
+
+		return nil
+	}, "the market is halted and hidden")
+}
+
+// DelistingSnapshots returns the final ticker of the day before halt_at and the last candle of each DelistingSnapshotPeriods,
+// trades are ordered by creation. A market without trades has no snapshot.
+func DelistingSnapshots(market *Market, trades []*Trade, halt_at time.Time) []*MarketDelistingSnapshot {
+	snapshots := make([]*MarketDelistingSnapshot, 0)
+	if len(trades) == 0 {
+		return snapshots
+	}
+
+	last := trades[len(trades)-1]
+	for _, period := range DelistingSnapshotPeriods {
+		from := CandleBucket(last.CreatedAt, PriceSeriesPeriods[period])
+
+		candles := AggregateCandles(tradesSince(trades, from), PriceSeriesPeriods[period])
+		snapshots = append(snapshots, newDelistingSnapshot(period, RoundCandle(candles[len(candles)-1], market)))
+	}
+
+	from := halt_at.Add(-24 * time.Hour)
+	if day := tradesSince(trades, from); len(day) > 0 {
+		ticker := &Candle{Time: from, Open: day[0].Price, High: day[0].Price, Low: day[0].Price, Close: last.Price, Volume: decimal.Zero}
+		for _, trade := range day {
+			ticker.High = decimal.Max(ticker.High, trade.Price)
+			ticker.Low = decimal.Min(ticker.Low, trade.Price)
+			ticker.Volume = ticker.Volume.Add(trade.Amount)
+		}
+
+		snapshots = append(snapshots, newDelistingSnapshot(DelistingTickerPeriod, RoundCandle(ticker, market)))
+	}
+
+	return snapshots
+}
+
+func tradesSince(trades []*Trade, from time.Time) []*Trade {
+	for i, trade := range trades {
+		if !trade.CreatedAt.Before(from) {
+			return trades[i:]
+		}
+	}
+
+	return nil
+}
+
+func newDelistingSnapshot(period string, candle *Candle) *MarketDelistingSnapshot {
+	return &MarketDelistingSnapshot{
+		Period: period,
+		Time:   candle.Time,
+		Open:   candle.Open,
+		High:   candle.High,
+		Low:    candle.Low,
+		Close:  candle.Close,
+		Volume: candle.Volume,
+	}
+}
+
+func delistSnapshot(tx *gorm.DB, delisting *MarketDelisting, now time.Time) error {
+	return completeDelistingStep(tx, delisting, MarketDelistingStepSnapshot, func(tx *gorm.DB, market *Market) error {
+		// the largest candle starts at most a period before the last trade, the ticker a day before the halt
+		var last *Trade
+		result := tx.Where("market_id = ? AND reverted_at IS NULL", market.Symbol).Order("created_at desc").Limit(1).Find(&last)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		var trades []*Trade
+		if result.RowsAffected > 0 {
+			from := CandleBucket(last.CreatedAt, PriceSeriesPeriods[DelistingSnapshotPeriods[len(DelistingSnapshotPeriods)-1]])
+			if day := delisting.HaltDoneAt.Time.Add(-24 * time.Hour); day.Before(from) {
+				from = day
+			}
+
+			if result := tx.Where("market_id = ? AND reverted_at IS NULL AND created_at >= ?", market.Symbol, from).Order("created_at asc").Find(&trades); result.Error != nil {
+				return result.Error
+			}
+		}
+
+		snapshots := DelistingSnapshots(market, trades, delisting.HaltDoneAt.Time)
+		for _, snapshot := range snapshots {
+			snapshot.DelistingID = delisting.ID
+		}
+
+		if len(snapshots) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&snapshots).Error; err != nil {
+				return err
+			}
+		}
+
+		delisting.SnapshotDoneAt = sql.NullTime{Time: now, Valid: true}
+
+		return nil
+	}, "the final ticker and candles are kept")
+}
+
+func delistMarket(tx *gorm.DB, delisting *MarketDelisting, now time.Time) error {
+	return completeDelistingStep(tx, delisting, MarketDelistingStepDelist, func(tx *gorm.DB, market *Market) error {
+		if err := tx.Model(market).Update("state", types.MarketStateDelisted).Error; err != nil {
+			return err
+		}
+
+		delisting.State = MarketDelistingStateDelisted
+		delisting.DelistedAt = sql.NullTime{Time: now, Valid: true}
+
+		return nil
+	}, "the market is delisted")
+}
+
+// LastMarketDelisting returns the latest delisting of the market with its progress.
+func LastMarketDelisting(tx *gorm.DB, market_id string) (delisting *MarketDelisting, logs []*MarketDelistingLog, snapshots []*MarketDelistingSnapshot, err error) {
+	if result := tx.Where("market_id = ?", market_id).Order("id desc").First(&delisting); result.Error != nil {
+		return nil, nil, nil, result.Error
+	}
+
+	if result := tx.Where("delisting_id = ?", delisting.ID).Order("id asc").Find(&logs); result.Error != nil {
+		return nil, nil, nil, result.Error
+	}
+
+	if result := tx.Where("delisting_id = ?", delisting.ID).Order("id asc").Find(&snapshots); result.Error != nil {
+		return nil, nil, nil, result.Error
+	}
+
+	return delisting, logs, snapshots, nil
+}
+
+// OpenOrdersCount counts the orders of the market which aren't in a terminal state.
+func OpenOrdersCount(tx *gorm.DB, market_id string) (int64, error) {
+	var count int64
+	result := tx.Model(&Order{}).Where("market_id = ? AND state IN ?", market_id, []OrderState{StateWait, StatePending}).Count(&count)
+
+	return count, result.Error
+}
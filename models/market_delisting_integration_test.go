@@ -0,0 +1,315 @@
+//go:build integration
+
+package models
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+	"github.com/zsmartex/pkg/services"
+
+	"github.com/zsmartex/finex/bus"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/matching"
+	"github.com/zsmartex/finex/types"
+)
+
+// testDelistingEngine runs the book of the delisted market in the test, the cancels of the book are processed
+// like the order processor does.
+type testDelistingEngine struct {
+	t      *testing.T
+	engine *matching.Engine
+}
+
+type orderProcessorPublisher struct {
+	t *testing.T
+}
+
+func (p *orderProcessorPublisher) PublishTrade(trade *pkg.Trade, stamp matching.TradeStamp) {
+	p.t.Errorf("expected the delisted market not to trade, got %+v", trade)
+}
+
+func (p *orderProcessorPublisher) PublishCancel(key *pkg.OrderKey, reason matching.CancelReason) {
+	if err := CancelOrder(key.ID); err != nil {
+		p.t.Errorf("failed to cancel order %d: %v", key.ID, err)
+	}
+}
+
+func (p *orderProcessorPublisher) PublishCancelOutcome(key *pkg.OrderKey, outcome matching.CancelOutcome, command_id string) {
+	// the order processor leaves the orders already gone to what took them out
+	if outcome != matching.CancelOutcomeAlreadyGone {
+		p.PublishCancel(key, "")
+	}
+}
+
+func (p *orderProcessorPublisher) PublishReplace(replaced_key *pkg.OrderKey, order *pkg.Order, accepted bool) {
+}
+
+func (p *orderProcessorPublisher) PublishDecrement(key *pkg.OrderKey, quantity decimal.Decimal, reason matching.CancelReason) {
+}
+
+func (p *orderProcessorPublisher) PublishReprice(key *pkg.OrderKey, reason matching.CancelReason) {
+}
+
+func (p *orderProcessorPublisher) PublishAmend(key *pkg.OrderKey, quantity decimal.Decimal, accepted bool) {
+}
+
+func (p *orderProcessorPublisher) PublishBatch(batch_id string, entries []*events.BatchEntry) {
+}
+
+func (p *orderProcessorPublisher) PublishCircuitBreak(circuit_break *matching.CircuitBreak) {
+}
+
+func (e *testDelistingEngine) Reload(market *Market) error {
+	e.engine = matching.NewDetachedEngine(market.GetSymbol(), decimal.NewFromInt(10), matching.OrderBookConfig{Publisher: &orderProcessorPublisher{t: e.t}})
+
+	var orders []*Order
+	config.DataBase.Where("market_id = ? AND state = ?", market.Symbol, StateWait).Order("id asc").Find(&orders)
+	for _, order := range orders {
+		e.engine.Submit(order.ToMatchingAttributes())
+	}
+
+	e.engine.OrderBook.SetCancelOnly(market.CancelOnly)
+
+	return nil
+}
+
+func (e *testDelistingEngine) Cancel(order *Order) error {
+	e.engine.Cancel(order.ToMatchingAttributes())
+
+	return nil
+}
+
+// The tests need the DATABASE_* variables of a disposable database and KAFKA_URL for the events of the orders:
+//
+//	go test -tags integration -run 'Delisting' ./models
+func setupDelistingDatabase(t testing.TB) {
+	if len(os.Getenv("DATABASE_HOST")) == 0 || len(os.Getenv("KAFKA_URL")) == 0 {
+		t.Skip("DATABASE_HOST and KAFKA_URL aren't set")
+	}
+
+	db, err := config.NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&Market{}, &Currency{}, &Member{}, &Order{}, &Account{}, &Liability{}, &OperationsAccount{}, &Trade{},
+		&MarketDelisting{}, &MarketDelistingLog{}, &MarketDelistingSnapshot{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, model := range []interface{}{&MarketDelistingSnapshot{}, &MarketDelistingLog{}, &MarketDelisting{}, &Order{}, &Trade{}, &Liability{}} {
+		db.Where("1 = 1").Delete(model)
+	}
+	db.Where("symbol = ?", "dlsusdt").Delete(&Market{})
+	db.Where("id IN ?", []string{"dls", "usdt"}).Delete(&Currency{})
+	db.Where("id IN ?", []int64{41, 42}).Delete(&Member{})
+	db.Where("member_id IN ?", []int64{41, 42}).Delete(&Account{})
+
+	producer, err := services.NewKafkaProducer(strings.Split(os.Getenv("KAFKA_URL"), ","), config.Logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.KafkaProducer = producer
+	if config.RangoClient, err = services.NewRangoClient(producer); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Bus, err = bus.NewKafka(strings.Split(os.Getenv("KAFKA_URL"), ","), config.Logger); err != nil {
+		t.Fatal(err)
+	}
+
+	config.DataBase = db
+	config.Delisting = &types.DelistingConfig{}
+}
+
+func TestMarketDelistingFlow(t *testing.T) {
+	setupDelistingDatabase(t)
+
+	db := config.DataBase
+	now := time.Now().Truncate(time.Second)
+
+	market := &Market{Symbol: "dlsusdt", BaseUnit: "dls", QuoteUnit: "usdt", AmountPrecision: 4, PricePrecision: 2, State: string(types.MarketStateEndabled)}
+	db.Create(market)
+	db.Create(&[]*Currency{{ID: "dls", Type: "coin"}, {ID: "usdt", Type: "coin"}})
+	db.Create(&[]*Member{{ID: 41, UID: "ID41"}, {ID: 42, UID: "ID42"}})
+	db.Create(&[]*Account{
+		{MemberID: 41, CurrencyID: "usdt", Balance: decimal.NewFromInt(1000)},
+		{MemberID: 42, CurrencyID: "dls", Balance: decimal.NewFromInt(100)},
+	})
+	db.Create(&Trade{Price: decimal.NewFromInt(10), Amount: decimal.NewFromInt(1), Total: decimal.NewFromInt(10), MarketID: "dlsusdt", CreatedAt: now.Add(-time.Hour)})
+
+	// places an order in the book the way the order processor does, with its funds locked
+	next_id := int64(9000)
+	place := func(engine *testDelistingEngine, member_id int64, side OrderSide, price, volume string) *Order {
+		next_id++
+
+		order := &Order{
+			ID: next_id, MemberID: member_id, Ask: "dls", Bid: "usdt", MarketID: "dlsusdt", Type: side, OrdType: types.TypeLimit,
+			Price: decimal.NewNullDecimal(decimal.RequireFromString(price)), Volume: decimal.RequireFromString(volume),
+			OriginVolume: decimal.RequireFromString(volume), State: StatePending,
+		}
+		order.Locked = order.Volume
+		if side == SideBuy {
+			order.Locked = order.Price.Decimal.Mul(order.Volume)
+		}
+		order.OriginLocked = order.Locked
+
+		if err := db.Create(order).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := lockOrderFunds(order.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		engine.engine.Submit(order.ToMatchingAttributes())
+
+		return order
+	}
+
+	engine := &testDelistingEngine{t: t}
+	engine.Reload(market)
+
+	for i := 0; i < 5; i++ {
+		place(engine, 41, SideBuy, "9", "1")
+		place(engine, 42, SideSell, "11", "1")
+	}
+
+	delisting, err := NewMarketDelisting(market, now.Add(time.Minute), now.Add(time.Hour), now.Add(2*time.Hour), "ID1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ScheduleMarketDelisting(db, delisting); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ScheduleMarketDelisting(db, &MarketDelisting{MarketID: "dlsusdt", CancelAt: now.Add(time.Hour), HaltAt: now.Add(time.Hour)}); err != ErrDelistingExists {
+		t.Fatalf("expected a second delisting to be refused, got %v", err)
+	}
+
+	// T1, the market takes cancels only
+	if err := RunMarketDelisting(db, delisting, engine, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if delisting.State != MarketDelistingStateCancelOnly || !engine.engine.OrderBook.CancelOnly() {
+		t.Fatalf("expected the market to take cancels only, got %s", delisting.State)
+	}
+
+	rejected := place(engine, 41, SideBuy, "11", "1")
+	db.First(rejected, rejected.ID)
+	if rejected.State != StateCancel {
+		t.Errorf("expected an order placed in the cancel-only phase to be cancelled, got %d", rejected.State)
+	}
+
+	// running the same step again changes nothing
+	if err := RunMarketDelisting(db, delisting, engine, now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	// T2, the orders are cancelled, then the step completes on the next run
+	if err := RunMarketDelisting(db, delisting, engine, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AbortMarketDelisting(db, delisting, "ID1", engine, now.Add(time.Hour)); err != ErrDelistingNotAbortable {
+		t.Errorf("expected the delisting not to be abortable once its orders are cancelled, got %v", err)
+	}
+
+	if err := RunMarketDelisting(db, delisting, engine, now.Add(time.Hour+time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !delisting.CancelDoneAt.Valid {
+		t.Fatal("expected the orders to be cancelled")
+	}
+
+	// T3, halted, snapshotted and delisted in the same run
+	if err := RunMarketDelisting(db, delisting, engine, now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if delisting.State != MarketDelistingStateDelisted {
+		t.Fatalf("expected the market to be delisted, got %s", delisting.State)
+	}
+
+	db.First(market, market.ID)
+	if market.State != string(types.MarketStateDelisted) || !market.CancelOnly {
+		t.Errorf("unexpected market %s, cancel only %t", market.State, market.CancelOnly)
+	}
+
+	var open int64
+	db.Model(&Order{}).Where("market_id = ? AND state NOT IN ?", "dlsusdt", FinalOrderStates).Count(&open)
+	if open != 0 {
+		t.Errorf("expected no order left in a non-terminal state, got %d", open)
+	}
+
+	var accounts []*Account
+	db.Where("member_id IN ?", []int64{41, 42}).Find(&accounts)
+	for _, account := range accounts {
+		if !account.Locked.IsZero() {
+			t.Errorf("expected the funds of member %d in %s to be released, %s locked", account.MemberID, account.CurrencyID, account.Locked)
+		}
+	}
+
+	_, logs, snapshots, err := LastMarketDelisting(db, "dlsusdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(logs) != 7 {
+		t.Errorf("expected a log line for the schedule and each step, got %d", len(logs))
+	}
+
+	if len(snapshots) != len(DelistingSnapshotPeriods)+1 {
+		t.Errorf("expected the final ticker and candles, got %d", len(snapshots))
+	}
+}
+
+func TestMarketDelistingAbort(t *testing.T) {
+	setupDelistingDatabase(t)
+
+	db := config.DataBase
+	now := time.Now().Truncate(time.Second)
+
+	market := &Market{Symbol: "dlsusdt", BaseUnit: "dls", QuoteUnit: "usdt", State: string(types.MarketStateEndabled)}
+	db.Create(market)
+
+	engine := &testDelistingEngine{t: t}
+	engine.Reload(market)
+
+	delisting, err := NewMarketDelisting(market, time.Time{}, now.Add(time.Hour), now.Add(2*time.Hour), "ID1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ScheduleMarketDelisting(db, delisting); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RunMarketDelisting(db, delisting, engine, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AbortMarketDelisting(db, delisting, "ID1", engine, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	db.First(market, market.ID)
+	if delisting.State != MarketDelistingStateAborted || market.CancelOnly || engine.engine.OrderBook.CancelOnly() {
+		t.Errorf("expected the market to take orders again, delisting %s", delisting.State)
+	}
+
+	// the delister leaves an aborted delisting
+	if err := RunMarketDelisting(db, delisting, engine, now.Add(time.Hour)); err != nil || delisting.CancelStartedAt.Valid {
+		t.Errorf("expected the aborted delisting not to run, got %v", err)
+	}
+}
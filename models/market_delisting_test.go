@@ -0,0 +1,157 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/types"
+)
+
+func TestNewMarketDelisting(t *testing.T) {
+	now := time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+	market := &Market{Symbol: "btcusdt", State: string(types.MarketStateEndabled)}
+
+	delisting, err := NewMarketDelisting(market, time.Time{}, now.Add(time.Hour), now.Add(2*time.Hour), "U1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !delisting.CancelOnlyAt.Equal(now) || delisting.State != MarketDelistingStateScheduled || delisting.MarketID != "btcusdt" {
+		t.Errorf("unexpected delisting %+v", delisting)
+	}
+
+	tests := []struct {
+		name                               string
+		cancel_only_at, cancel_at, halt_at time.Time
+	}{
+		{"cancel only in the past", now.Add(-time.Minute), now.Add(time.Hour), now.Add(2 * time.Hour)},
+		{"cancels now", time.Time{}, now, now.Add(2 * time.Hour)},
+		{"cancels before cancel only", now.Add(2 * time.Hour), now.Add(time.Hour), now.Add(3 * time.Hour)},
+		{"halt before cancels", time.Time{}, now.Add(2 * time.Hour), now.Add(time.Hour)},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewMarketDelisting(market, tt.cancel_only_at, tt.cancel_at, tt.halt_at, "U1", now); err != ErrDelistingSchedule {
+			t.Errorf("%s: got %v, want %v", tt.name, err, ErrDelistingSchedule)
+		}
+	}
+
+	for _, state := range []types.MarketState{types.MarketStateDisabled, types.MarketStateArchived, types.MarketStateDelisted} {
+		if _, err := NewMarketDelisting(&Market{State: string(state)}, time.Time{}, now.Add(time.Hour), now.Add(2*time.Hour), "U1", now); err != ErrDelistingMarketState {
+			t.Errorf("%s: got %v, want %v", state, err, ErrDelistingMarketState)
+		}
+	}
+}
+
+func TestMarketDelistingNextStep(t *testing.T) {
+	start := time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+	delisting := &MarketDelisting{
+		CancelOnlyAt: start,
+		CancelAt:     start.Add(time.Hour),
+		HaltAt:       start.Add(2 * time.Hour),
+		State:        MarketDelistingStateScheduled,
+	}
+	done := sql.NullTime{Time: start, Valid: true}
+
+	expect := func(now time.Time, want MarketDelistingStep, want_due bool) {
+		t.Helper()
+
+		if step, due := delisting.NextStep(now); step != want || due != want_due {
+			t.Errorf("got %q due %t, want %q due %t", step, due, want, want_due)
+		}
+	}
+
+	expect(start.Add(-time.Nanosecond), MarketDelistingStepCancelOnly, false)
+	expect(start, MarketDelistingStepCancelOnly, true)
+
+	delisting.CancelOnlyDoneAt = done
+	expect(start.Add(time.Minute), MarketDelistingStepCancelOrders, false)
+	expect(start.Add(time.Hour), MarketDelistingStepCancelOrders, true)
+
+	// the cancels in progress keep the step until no order is left
+	delisting.CancelStartedAt = done
+	expect(start.Add(90*time.Minute), MarketDelistingStepCancelOrders, true)
+
+	delisting.CancelDoneAt = done
+	expect(start.Add(90*time.Minute), MarketDelistingStepHalt, false)
+	expect(start.Add(2*time.Hour), MarketDelistingStepHalt, true)
+
+	delisting.HaltDoneAt = done
+	expect(start.Add(2*time.Hour), MarketDelistingStepSnapshot, true)
+
+	delisting.SnapshotDoneAt = done
+	expect(start.Add(2*time.Hour), MarketDelistingStepDelist, true)
+
+	delisting.State = MarketDelistingStateDelisted
+	expect(start.Add(2*time.Hour), "", false)
+}
+
+func TestMarketDelistingAbortable(t *testing.T) {
+	start := time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+	delisting := &MarketDelisting{CancelOnlyAt: start, CancelAt: start.Add(time.Hour), State: MarketDelistingStateCancelOnly}
+
+	if !delisting.Abortable(start.Add(59 * time.Minute)) {
+		t.Error("expected the delisting to be abortable before its cancels")
+	}
+
+	if delisting.Abortable(start.Add(time.Hour)) {
+		t.Error("expected the delisting not to be abortable at its cancels")
+	}
+
+	delisting.CancelStartedAt = sql.NullTime{Time: start, Valid: true}
+	if delisting.Abortable(start) {
+		t.Error("expected the delisting not to be abortable once the cancels started")
+	}
+
+	aborted := &MarketDelisting{CancelAt: start.Add(time.Hour), State: MarketDelistingStateAborted}
+	if aborted.Abortable(start) {
+		t.Error("expected an aborted delisting not to be abortable again")
+	}
+}
+
+func TestDelistingSnapshots(t *testing.T) {
+	market := &Market{PricePrecision: 2, AmountPrecision: 4}
+	halt_at := time.Date(2022, 5, 11, 12, 0, 0, 0, time.UTC)
+
+	trade := func(at time.Time, price, amount string) *Trade {
+		return &Trade{Price: decimal.RequireFromString(price), Amount: decimal.RequireFromString(amount), CreatedAt: at}
+	}
+
+	trades := []*Trade{
+		// out of the day before the halt, in the last daily candle
+		trade(time.Date(2022, 5, 10, 11, 0, 0, 0, time.UTC), "8", "1"),
+		trade(time.Date(2022, 5, 10, 13, 0, 0, 0, time.UTC), "10", "1"),
+		trade(time.Date(2022, 5, 11, 9, 10, 0, 0, time.UTC), "12", "2"),
+		trade(time.Date(2022, 5, 11, 9, 30, 0, 0, time.UTC), "11", "0.5"),
+	}
+
+	snapshots := DelistingSnapshots(market, trades, halt_at)
+
+	by_period := make(map[string]*MarketDelistingSnapshot)
+	for _, snapshot := range snapshots {
+		by_period[snapshot.Period] = snapshot
+	}
+
+	minute := by_period["1m"]
+	if minute == nil || !minute.Time.Equal(time.Date(2022, 5, 11, 9, 30, 0, 0, time.UTC)) || !minute.Volume.Equal(decimal.RequireFromString("0.5")) {
+		t.Errorf("unexpected final 1m candle %+v", minute)
+	}
+
+	hour := by_period["1h"]
+	if hour == nil || !hour.Open.Equal(decimal.NewFromInt(12)) || !hour.Close.Equal(decimal.NewFromInt(11)) || !hour.Volume.Equal(decimal.RequireFromString("2.5")) {
+		t.Errorf("unexpected final 1h candle %+v", hour)
+	}
+
+	ticker := by_period[DelistingTickerPeriod]
+	if ticker == nil || !ticker.Open.Equal(decimal.NewFromInt(10)) || !ticker.High.Equal(decimal.NewFromInt(12)) ||
+		!ticker.Low.Equal(decimal.NewFromInt(10)) || !ticker.Close.Equal(decimal.NewFromInt(11)) || !ticker.Volume.Equal(decimal.RequireFromString("3.5")) {
+		t.Errorf("unexpected final ticker %+v", ticker)
+	}
+
+	if len(DelistingSnapshots(market, nil, halt_at)) != 0 {
+		t.Error("expected a market without trades to have no snapshot")
+	}
+}
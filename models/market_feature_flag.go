@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+type MarketFeatureFlag struct {
+	ID        int64             `json:"id" gorm:"primaryKey"`
+	MarketID  string            `json:"market_id"`
+	Name      types.FeatureFlag `json:"name"`
+	Enabled   bool              `json:"enabled"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// GetMarketFeatureFlags returns the value of every known feature flag of a market, flags never set are disabled.
+func GetMarketFeatureFlags(market_id string) map[types.FeatureFlag]bool {
+	return marketFeatureFlags(config.DataBase, market_id)
+}
+
+func marketFeatureFlags(tx *gorm.DB, market_id string) map[types.FeatureFlag]bool {
+	var market_flags []*MarketFeatureFlag
+	tx.Find(&market_flags, "market_id = ?", market_id)
+
+	flags := make(map[types.FeatureFlag]bool)
+	for _, name := range types.FeatureFlags {
+		flags[name] = false
+	}
+
+	for _, flag := range market_flags {
+		if _, ok := flags[flag.Name]; ok {
+			flags[flag.Name] = flag.Enabled
+		}
+	}
+
+	return flags
+}
+
+// SetMarketFeatureFlag sets a feature flag of a market with tx, the transaction bumping the version of its configuration.
+func SetMarketFeatureFlag(tx *gorm.DB, market_id string, name types.FeatureFlag, enabled bool) error {
+	var flag *MarketFeatureFlag
+
+	result := tx.
+		Where(MarketFeatureFlag{MarketID: market_id, Name: name}).
+		Assign(map[string]interface{}{"enabled": enabled}).
+		FirstOrCreate(&flag)
+
+	return result.Error
+}
@@ -0,0 +1,291 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// DefaultMarketGroup is the group of members and requests without one, markets without a group belong to it.
+const DefaultMarketGroup = "public"
+
+// marketVisibilityTTL is how long a replica serves the visibility before reading the groups again.
+const marketVisibilityTTL = 10 * time.Second
+
+var (
+	ErrMarketGroupInvalid  = errors.New("market_group.invalid_name")
+	ErrMarketGroupTaken    = errors.New("market_group.taken")
+	ErrMarketGroupDefault  = errors.New("market_group.default_group")
+	ErrMarketGroupMembers  = errors.New("market_group.has_members")
+	ErrMarketGroupNotFound = errors.New("market_group.not_found")
+)
+
+var marketGroupFormat = regexp.MustCompile(`^[a-z0-9_-]{2,32}$`)
+
+// MarketGroup is a set of markets shown to the members of a white-label partner.
+type MarketGroup struct {
+	ID          int64     `json:"id" gorm:"primaryKey"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// MarketGroupMarket assigns a market to a group, a market can belong to many groups.
+type MarketGroupMarket struct {
+	ID        int64     `json:"id" gorm:"primaryKey"`
+	GroupName string    `json:"group_name"`
+	MarketID  string    `json:"market_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MarketGroupOf returns the group of the member, members without one are in DefaultMarketGroup.
+func MarketGroupOf(member *Member) string {
+	if member == nil || len(member.MarketGroup) == 0 {
+		return DefaultMarketGroup
+	}
+
+	return member.MarketGroup
+}
+
+// BuildMarketVisibility returns the markets visible to each group, markets without an assignment
+// are visible to DefaultMarketGroup only.
+func BuildMarketVisibility(markets []string, assignments []*MarketGroupMarket) map[string]map[string]bool {
+	visibility := map[string]map[string]bool{DefaultMarketGroup: {}}
+
+	assigned := make(map[string]bool, len(assignments))
+	for _, assignment := range assignments {
+		if visibility[assignment.GroupName] == nil {
+			visibility[assignment.GroupName] = make(map[string]bool)
+		}
+
+		visibility[assignment.GroupName][assignment.MarketID] = true
+		assigned[assignment.MarketID] = true
+	}
+
+	for _, market := range markets {
+		if !assigned[market] {
+			visibility[DefaultMarketGroup][market] = true
+		}
+	}
+
+	return visibility
+}
+
+// MarketVisibilityCache keeps the visibility of the markets of each group in memory for marketVisibilityTTL.
+type MarketVisibilityCache struct {
+	sync.Mutex
+	visibility map[string]map[string]bool
+	expiresAt  time.Time
+	load       func() map[string]map[string]bool
+}
+
+func loadMarketVisibility() map[string]map[string]bool {
+	var markets []string
+	config.DataBase.Model(&Market{}).Pluck("symbol", &markets)
+
+	var assignments []*MarketGroupMarket
+	config.DataBase.Find(&assignments)
+
+	visibility := BuildMarketVisibility(markets, assignments)
+
+	// listings which aren't announced yet are hidden from every group
+	for _, market := range HiddenListings(time.Now()) {
+		for _, group_markets := range visibility {
+			delete(group_markets, market)
+		}
+	}
+
+	return visibility
+}
+
+// NewMarketVisibilityCache returns a cache of the visibility load returns.
+func NewMarketVisibilityCache(load func() map[string]map[string]bool) *MarketVisibilityCache {
+	return &MarketVisibilityCache{load: load}
+}
+
+// MarketVisibility is the visibility served by the API.
+var MarketVisibility = NewMarketVisibilityCache(loadMarketVisibility)
+
+func (v *MarketVisibilityCache) current() map[string]map[string]bool {
+	v.Lock()
+	defer v.Unlock()
+
+	now := time.Now()
+	if v.visibility == nil || now.After(v.expiresAt) {
+		v.visibility = v.load()
+		v.expiresAt = now.Add(marketVisibilityTTL)
+	}
+
+	return v.visibility
+}
+
+// Invalidate reloads the visibility on the next read, other replicas reload it within marketVisibilityTTL.
+func (v *MarketVisibilityCache) Invalidate() {
+	v.Lock()
+	defer v.Unlock()
+
+	v.visibility = nil
+}
+
+// Visible reports whether the market is visible to the group.
+func (v *MarketVisibilityCache) Visible(group, market string) bool {
+	return v.current()[group][market]
+}
+
+// Markets returns the markets visible to the group.
+func (v *MarketVisibilityCache) Markets(group string) map[string]bool {
+	markets := make(map[string]bool)
+	for market := range v.current()[group] {
+		markets[market] = true
+	}
+
+	return markets
+}
+
+// GetMarketGroup returns the group named name, DefaultMarketGroup exists without being created.
+func GetMarketGroup(name string) (*MarketGroup, error) {
+	var group *MarketGroup
+	if result := config.DataBase.First(&group, "name = ?", name); result.Error != nil {
+		if name == DefaultMarketGroup {
+			return &MarketGroup{Name: DefaultMarketGroup}, nil
+		}
+
+		return nil, ErrMarketGroupNotFound
+	}
+
+	return group, nil
+}
+
+func MarketGroupExists(name string) bool {
+	_, err := GetMarketGroup(name)
+
+	return err == nil
+}
+
+func CreateMarketGroup(name, description string) (*MarketGroup, error) {
+	if !marketGroupFormat.MatchString(name) {
+		return nil, ErrMarketGroupInvalid
+	}
+
+	if MarketGroupExists(name) {
+		return nil, ErrMarketGroupTaken
+	}
+
+	group := &MarketGroup{Name: name, Description: description}
+	if result := config.DataBase.Create(&group); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return group, nil
+}
+
+func (g *MarketGroup) Update(description string) error {
+	g.Description = description
+
+	return config.DataBase.Model(g).Update("description", description).Error
+}
+
+func (g *MarketGroup) Markets() []string {
+	markets := make([]string, 0)
+	config.DataBase.Model(&MarketGroupMarket{}).Where("group_name = ?", g.Name).Order("market_id asc").Pluck("market_id", &markets)
+
+	return markets
+}
+
+// SetMarkets replaces the markets of the group.
+func (g *MarketGroup) SetMarkets(markets []string) error {
+	err := config.DataBase.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Where("group_name = ?", g.Name).Delete(&MarketGroupMarket{}); result.Error != nil {
+			return result.Error
+		}
+
+		for _, market := range markets {
+			if result := tx.Create(&MarketGroupMarket{GroupName: g.Name, MarketID: market}); result.Error != nil {
+				return result.Error
+			}
+		}
+
+		return nil
+	})
+
+	MarketVisibility.Invalidate()
+
+	return err
+}
+
+// Delete removes a group without members and its assignments.
+func (g *MarketGroup) Delete() error {
+	if g.Name == DefaultMarketGroup {
+		return ErrMarketGroupDefault
+	}
+
+	var members int64
+	config.DataBase.Model(&Member{}).Where("market_group = ?", g.Name).Count(&members)
+	if members > 0 {
+		return ErrMarketGroupMembers
+	}
+
+	err := config.DataBase.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Where("group_name = ?", g.Name).Delete(&MarketGroupMarket{}); result.Error != nil {
+			return result.Error
+		}
+
+		return tx.Delete(g).Error
+	})
+
+	MarketVisibility.Invalidate()
+
+	return err
+}
+
+// SetMarketGroup assigns the member and its sub-accounts to the group.
+func (m *Member) SetMarketGroup(group string) error {
+	if !MarketGroupExists(group) {
+		return ErrMarketGroupNotFound
+	}
+
+	m.MarketGroup = group
+
+	return config.DataBase.Model(&Member{}).Where("id = ? OR parent_id = ?", m.ID, m.ID).Update("market_group", group).Error
+}
+
+// VisibleStreams keeps the streams of a websocket subscription the group can see.
+func (v *MarketVisibilityCache) VisibleStreams(group string, streams []string) []string {
+	visibility := v.current()
+
+	markets := make(map[string]bool)
+	for _, group_markets := range visibility {
+		for market := range group_markets {
+			markets[market] = true
+		}
+	}
+
+	return FilterStreams(streams, visibility[group], markets)
+}
+
+// FilterStreams drops the public streams ("<market>.<event>") of markets which aren't visible,
+// streams which aren't about a market are kept.
+func FilterStreams(streams []string, visible map[string]bool, markets map[string]bool) []string {
+	filtered := make([]string, 0, len(streams))
+	for _, stream := range streams {
+		market := stream
+		if i := strings.Index(stream, "."); i >= 0 {
+			market = stream[:i]
+		}
+
+		if markets[market] && !visible[market] {
+			continue
+		}
+
+		filtered = append(filtered, stream)
+	}
+
+	return filtered
+}
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func testMarketVisibility() *MarketVisibilityCache {
+	return &MarketVisibilityCache{load: func() map[string]map[string]bool {
+		return BuildMarketVisibility([]string{"btcusdt", "ethusdt", "ptkusdt", "xtkusdt"}, []*MarketGroupMarket{
+			{GroupName: "partner", MarketID: "btcusdt"},
+			{GroupName: "partner", MarketID: "ptkusdt"},
+			{GroupName: DefaultMarketGroup, MarketID: "btcusdt"},
+			{GroupName: "other", MarketID: "xtkusdt"},
+		})
+	}}
+}
+
+func sortedMarkets(markets map[string]bool) []string {
+	symbols := make([]string, 0, len(markets))
+	for market := range markets {
+		symbols = append(symbols, market)
+	}
+	sort.Strings(symbols)
+
+	return symbols
+}
+
+func TestMarketVisibility(t *testing.T) {
+	visibility := testMarketVisibility()
+
+	tests := []struct {
+		group   string
+		markets []string
+	}{
+		// ethusdt has no group so it's public, btcusdt is assigned to both
+		{DefaultMarketGroup, []string{"btcusdt", "ethusdt"}},
+		{"partner", []string{"btcusdt", "ptkusdt"}},
+		{"other", []string{"xtkusdt"}},
+		{"unknown", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := sortedMarkets(visibility.Markets(tt.group)); !reflect.DeepEqual(got, tt.markets) {
+			t.Errorf("Markets(%s) = %v, want %v", tt.group, got, tt.markets)
+		}
+	}
+
+	if visibility.Visible(DefaultMarketGroup, "ptkusdt") || visibility.Visible("partner", "ethusdt") || visibility.Visible("partner", "xtkusdt") {
+		t.Error("a market is visible outside of its groups")
+	}
+
+	// the markets returned to a request can't change the cached visibility of the other groups
+	visibility.Markets("partner")["xtkusdt"] = true
+	if visibility.Visible("partner", "xtkusdt") {
+		t.Error("the cached visibility was changed through Markets")
+	}
+}
+
+func TestMarketGroupOf(t *testing.T) {
+	if group := MarketGroupOf(nil); group != DefaultMarketGroup {
+		t.Errorf("MarketGroupOf(nil) = %s", group)
+	}
+
+	if group := MarketGroupOf(&Member{}); group != DefaultMarketGroup {
+		t.Errorf("MarketGroupOf(member without group) = %s", group)
+	}
+
+	if group := MarketGroupOf(&Member{MarketGroup: "partner"}); group != "partner" {
+		t.Errorf("MarketGroupOf(partner member) = %s", group)
+	}
+}
+
+func TestVisibleSummary(t *testing.T) {
+	d := decimal.RequireFromString
+	visibility := testMarketVisibility()
+
+	summary := &VolumeSummary{
+		TotalUSDTVolume: d("600"),
+		UpdatedAt:       time.Now(),
+		Markets: []*MarketVolumeSummary{
+			{Market: "btcusdt", USDTVolume: decimal.NewNullDecimal(d("100"))},
+			{Market: "ethusdt", USDTVolume: decimal.NewNullDecimal(d("200"))},
+			{Market: "ptkusdt", USDTVolume: decimal.NewNullDecimal(d("300"))},
+			{Market: "xtkusdt"},
+		},
+	}
+
+	tests := []struct {
+		group   string
+		markets []string
+		total   string
+	}{
+		{DefaultMarketGroup, []string{"btcusdt", "ethusdt"}, "300"},
+		{"partner", []string{"btcusdt", "ptkusdt"}, "400"},
+		{"other", []string{"xtkusdt"}, "0"},
+	}
+
+	for _, tt := range tests {
+		visible := summary.Visible(visibility.Markets(tt.group))
+
+		markets := make([]string, 0)
+		for _, market := range visible.Markets {
+			markets = append(markets, market.Market)
+		}
+
+		if !reflect.DeepEqual(markets, tt.markets) || !visible.TotalUSDTVolume.Equal(d(tt.total)) {
+			t.Errorf("%s summary = %v total %s, want %v total %s", tt.group, markets, visible.TotalUSDTVolume, tt.markets, tt.total)
+		}
+	}
+
+	if len(summary.Markets) != 4 || !summary.TotalUSDTVolume.Equal(d("600")) {
+		t.Error("filtering changed the cached summary")
+	}
+}
+
+func TestVisibleStreams(t *testing.T) {
+	visibility := testMarketVisibility()
+
+	streams := []string{"btcusdt.trades", "ethusdt.depth", "ptkusdt.trades", "ptkusdt.ob-snap", "global.tickers"}
+
+	tests := []struct {
+		group   string
+		streams []string
+	}{
+		{DefaultMarketGroup, []string{"btcusdt.trades", "ethusdt.depth", "global.tickers"}},
+		{"partner", []string{"btcusdt.trades", "ptkusdt.trades", "ptkusdt.ob-snap", "global.tickers"}},
+	}
+
+	for _, tt := range tests {
+		if got := visibility.VisibleStreams(tt.group, streams); !reflect.DeepEqual(got, tt.streams) {
+			t.Errorf("VisibleStreams(%s) = %v, want %v", tt.group, got, tt.streams)
+		}
+	}
+}
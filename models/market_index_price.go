@@ -0,0 +1,18 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+)
+
+// PushIndexPrice sends the index price of market from a price feed to the engine, which triggers the stop orders
+// triggered by the index price it crosses.
+func PushIndexPrice(market *Market, price decimal.Decimal) error {
+	return config.Bus.Publish("matching", market.Symbol, map[string]interface{}{
+		"action":      events.ActionIndexPrice,
+		"symbol":      market.GetSymbol(),
+		"index_price": events.IndexPriceUpdate{Price: price},
+	})
+}
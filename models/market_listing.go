@@ -0,0 +1,128 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+// DefaultListingWarmup is how long before the open of a listing its post-only warm-up starts.
+const DefaultListingWarmup = 10 * time.Minute
+
+type ListingPhase string
+
+var (
+	// ListingPhaseHidden is a listed market nobody can see yet
+	ListingPhaseHidden ListingPhase = "hidden"
+	// ListingPhaseAnnounced is a listed market shown with a countdown, it takes no orders
+	ListingPhaseAnnounced ListingPhase = "announced"
+	// ListingPhaseWarmup is a listed market taking limit orders which rest without matching
+	ListingPhaseWarmup ListingPhase = "warmup"
+	// ListingPhaseOpen is a market trading continuously, the phase of every market without a listing
+	ListingPhaseOpen ListingPhase = "open"
+)
+
+var (
+	ErrListingSchedule       = errors.New("admin.market.invalid_listing_schedule")
+	ErrListingOpened         = errors.New("admin.market.listing_already_open")
+	ErrMarketNotOpen         = errors.New("market.order.market_not_open")
+	ErrMarketWarmupLimitOnly = errors.New("market.order.warmup_limit_only")
+)
+
+// ListingPhase returns the phase of the listing of the market at now.
+func (m *Market) ListingPhase(now time.Time) ListingPhase {
+	switch {
+	case !m.ListingOpensAt.Valid || !now.Before(m.ListingOpensAt.Time):
+		return ListingPhaseOpen
+	case m.ListingVisibleAt.Valid && now.Before(m.ListingVisibleAt.Time):
+		return ListingPhaseHidden
+	case now.Before(m.ListingWarmupAt.Time):
+		return ListingPhaseAnnounced
+	default:
+		return ListingPhaseWarmup
+	}
+}
+
+// AcceptsOrder checks the listing of the market takes an order of ord_type at now, the matching engine
+// checks it again when the order reaches it.
+func (m *Market) AcceptsOrder(ord_type types.OrderType, now time.Time) error {
+	switch m.ListingPhase(now) {
+	case ListingPhaseHidden, ListingPhaseAnnounced:
+		return ErrMarketNotOpen
+	case ListingPhaseWarmup:
+		if ord_type != types.TypeLimit {
+			return ErrMarketWarmupLimitOnly
+		}
+	}
+
+	return nil
+}
+
+// NewListingSchedule checks a listing opening at opens_at, shown from visible_at and warming up for warmup.
+// A zero visible_at shows the market right away, a zero warmup is DefaultListingWarmup.
+func NewListingSchedule(visible_at, opens_at time.Time, warmup time.Duration, now time.Time) (visible, warmup_at sql.NullTime, err error) {
+	if warmup <= 0 {
+		warmup = DefaultListingWarmup
+	}
+
+	warmup_at = sql.NullTime{Time: opens_at.Add(-warmup), Valid: true}
+	if !warmup_at.Time.After(now) {
+		return visible, warmup_at, ErrListingSchedule
+	}
+
+	if !visible_at.IsZero() {
+		if visible_at.After(warmup_at.Time) {
+			return visible, warmup_at, ErrListingSchedule
+		}
+
+		visible = sql.NullTime{Time: visible_at, Valid: true}
+	}
+
+	return visible, warmup_at, nil
+}
+
+// ScheduleListing sets the listing schedule of the market, a market can't be listed again once it's open.
+func (m *Market) ScheduleListing(visible_at, opens_at time.Time, warmup time.Duration, now time.Time) error {
+	if m.ListingPhase(now) == ListingPhaseOpen && (m.ListingOpensAt.Valid || m.hasTraded()) {
+		return ErrListingOpened
+	}
+
+	visible, warmup_at, err := NewListingSchedule(visible_at, opens_at, warmup, now)
+	if err != nil {
+		return err
+	}
+
+	m.ListingVisibleAt = visible
+	m.ListingWarmupAt = warmup_at
+	m.ListingOpensAt = sql.NullTime{Time: opens_at, Valid: true}
+
+	if result := config.DataBase.Model(m).Updates(map[string]interface{}{
+		"listing_visible_at": m.ListingVisibleAt,
+		"listing_warmup_at":  m.ListingWarmupAt,
+		"listing_opens_at":   m.ListingOpensAt,
+	}); result.Error != nil {
+		return result.Error
+	}
+
+	MarketVisibility.Invalidate()
+
+	return nil
+}
+
+func (m *Market) hasTraded() bool {
+	var trades int64
+	config.DataBase.Model(&Trade{}).Where("market_id = ?", m.Symbol).Limit(1).Count(&trades)
+
+	return trades > 0
+}
+
+// HiddenListings returns the markets whose listing isn't shown yet at now.
+func HiddenListings(now time.Time) []string {
+	var markets []string
+	config.DataBase.Model(&Market{}).Where("listing_visible_at > ?", now).Pluck("symbol", &markets)
+
+	return markets
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zsmartex/finex/types"
+)
+
+func TestMarketListingPhase(t *testing.T) {
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	market := &Market{
+		ListingVisibleAt: sql.NullTime{Time: now.Add(time.Hour), Valid: true},
+		ListingWarmupAt:  sql.NullTime{Time: now.Add(2 * time.Hour), Valid: true},
+		ListingOpensAt:   sql.NullTime{Time: now.Add(3 * time.Hour), Valid: true},
+	}
+
+	cases := []struct {
+		at    time.Time
+		phase ListingPhase
+		limit error
+		other error
+	}{
+		{now, ListingPhaseHidden, ErrMarketNotOpen, ErrMarketNotOpen},
+		{now.Add(time.Hour), ListingPhaseAnnounced, ErrMarketNotOpen, ErrMarketNotOpen},
+		{now.Add(2 * time.Hour), ListingPhaseWarmup, nil, ErrMarketWarmupLimitOnly},
+		{now.Add(3*time.Hour - time.Nanosecond), ListingPhaseWarmup, nil, ErrMarketWarmupLimitOnly},
+		{now.Add(3 * time.Hour), ListingPhaseOpen, nil, nil},
+	}
+
+	for _, c := range cases {
+		if phase := market.ListingPhase(c.at); phase != c.phase {
+			t.Errorf("expected %s at %s, got %s", c.phase, c.at, phase)
+		}
+
+		if err := market.AcceptsOrder(types.TypeLimit, c.at); err != c.limit {
+			t.Errorf("expected %v for a limit order at %s, got %v", c.limit, c.at, err)
+		}
+
+		if err := market.AcceptsOrder(types.TypeMarket, c.at); err != c.other {
+			t.Errorf("expected %v for a market order at %s, got %v", c.other, c.at, err)
+		}
+	}
+
+	if phase := (&Market{}).ListingPhase(now); phase != ListingPhaseOpen {
+		t.Errorf("expected a market without a listing to be open, got %s", phase)
+	}
+}
+
+func TestNewListingSchedule(t *testing.T) {
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	opens_at := now.Add(time.Hour)
+
+	visible, warmup_at, err := NewListingSchedule(time.Time{}, opens_at, 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if visible.Valid || !warmup_at.Time.Equal(opens_at.Add(-DefaultListingWarmup)) {
+		t.Errorf("expected a visible listing with the default warm-up, got %v and %v", visible, warmup_at)
+	}
+
+	if _, _, err := NewListingSchedule(time.Time{}, opens_at, 2*time.Hour, now); !errors.Is(err, ErrListingSchedule) {
+		t.Errorf("expected a warm-up starting in the past to be refused, got %v", err)
+	}
+
+	if _, _, err := NewListingSchedule(opens_at, opens_at, 0, now); !errors.Is(err, ErrListingSchedule) {
+		t.Errorf("expected a listing shown after its warm-up to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/decimalutil"
+	"github.com/zsmartex/finex/events"
+)
+
+const (
+	// DefaultPrecisionChangeTimeout is how long a precision change waits for the engine when engine.rekey_timeout isn't set.
+	DefaultPrecisionChangeTimeout = 10 * time.Second
+	// precisionChangePoll is how often a precision change waiting for the engine checks whether it confirmed
+	precisionChangePoll = 100 * time.Millisecond
+)
+
+type MarketPrecisionChangeState string
+
+var (
+	// MarketPrecisionChangeStatePending is a change sent to the engine, the market keeps its precision meanwhile
+	MarketPrecisionChangeStatePending MarketPrecisionChangeState = "pending"
+	// MarketPrecisionChangeStateCompleted is a change the engine re-keyed the book for, the market has the new precision
+	MarketPrecisionChangeStateCompleted MarketPrecisionChangeState = "completed"
+	// MarketPrecisionChangeStateExpired is a change the engine didn't confirm in time, the market kept its precision
+	MarketPrecisionChangeStateExpired MarketPrecisionChangeState = "expired"
+)
+
+var (
+	ErrInvalidPricePrecision      = errors.New("admin.market.invalid_price_precision")
+	ErrPrecisionChangeExists      = errors.New("admin.market.precision_change_exists")
+	ErrPrecisionChangeUnsupported = errors.New("admin.market.precision_change_unsupported")
+	ErrPrecisionChangeUnconfirmed = errors.New("admin.market.precision_change_unconfirmed")
+
+	// errPrecisionChangeEnded stops the engine completing a change which expired before it re-keyed the book
+	errPrecisionChangeEnded = errors.New("the precision change ended")
+)
+
+// MarketPrecisionChange is a change of the price precision of a market. The market only takes the new precision once
+// its engine re-keyed the orders of the book to it, an admin's change waits for the engine and expires without it.
+type MarketPrecisionChange struct {
+	ID       int64  `json:"id" gorm:"primaryKey"`
+	MarketID string `json:"market_id" gorm:"index"`
+	// FromPricePrecision is the precision of the market when the change was made, PricePrecision the one it changes to
+	FromPricePrecision int                        `json:"from_price_precision"`
+	PricePrecision     int                        `json:"price_precision"`
+	State              MarketPrecisionChangeState `json:"state"`
+	// Repriced and Cancelled are the orders the engine moved to a price of the precision and the ones it cancelled
+	Repriced    int          `json:"repriced"`
+	Cancelled   int          `json:"cancelled"`
+	CreatedBy   string       `json:"created_by"`
+	CompletedAt sql.NullTime `json:"completed_at"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+func PrecisionChangeTimeout() time.Duration {
+	if config.Engine.RekeyTimeout > 0 {
+		return config.Engine.RekeyTimeout
+	}
+
+	return DefaultPrecisionChangeTimeout
+}
+
+// NewMarketPrecisionChange checks the price precision market changes to, the bounds of its prices must be
+// prices of the precision.
+func NewMarketPrecisionChange(market *Market, price_precision int, created_by string) (*MarketPrecisionChange, error) {
+	if price_precision < 0 || price_precision > int(SchemaDecimalScale) || price_precision == market.PricePrecision {
+		return nil, ErrInvalidPricePrecision
+	}
+
+	for _, bound := range []decimal.Decimal{market.MinPrice, market.MaxPrice} {
+		if !decimalutil.Round(bound, int32(price_precision), decimalutil.Down).Equal(bound) {
+			return nil, ErrInvalidPricePrecision
+		}
+	}
+
+	// the orders can't be re-keyed without the reprices of the order events
+	if !events.ProducesOrderReprices() {
+		return nil, ErrPrecisionChangeUnsupported
+	}
+
+	return &MarketPrecisionChange{
+		MarketID:           market.Symbol,
+		FromPricePrecision: market.PricePrecision,
+		PricePrecision:     price_precision,
+		State:              MarketPrecisionChangeStatePending,
+		CreatedBy:          created_by,
+	}, nil
+}
+
+// RequestMarketPrecisionChange creates the change and sends it to the engine of the market, a market has one
+// pending change at most. A pending change older than the timeout was left by a process which stopped waiting
+// for it, it's expired.
+func RequestMarketPrecisionChange(tx *gorm.DB, change *MarketPrecisionChange, market *Market, now time.Time) error {
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&Market{}, market.ID); result.Error != nil {
+			return result.Error
+		}
+
+		if result := tx.Model(&MarketPrecisionChange{}).
+			Where("market_id = ? AND state = ? AND created_at < ?", change.MarketID, MarketPrecisionChangeStatePending, now.Add(-PrecisionChangeTimeout())).
+			Update("state", MarketPrecisionChangeStateExpired); result.Error != nil {
+			return result.Error
+		}
+
+		var pending int64
+		if result := tx.Model(&MarketPrecisionChange{}).
+			Where("market_id = ? AND state = ?", change.MarketID, MarketPrecisionChangeStatePending).
+			Count(&pending); result.Error != nil {
+			return result.Error
+		}
+
+		if pending > 0 {
+			return ErrPrecisionChangeExists
+		}
+
+		return tx.Create(change).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return config.Bus.Publish("matching", market.Symbol, map[string]interface{}{
+		"action": events.ActionRekey,
+		"symbol": market.GetSymbol(),
+		"precision": events.PrecisionChange{
+			ID:             change.ID,
+			PricePrecision: int32(change.PricePrecision),
+		},
+	})
+}
+
+// AwaitMarketPrecisionChange waits for the engine to complete the change until the timeout, the change is expired
+// without it. ErrPrecisionChangeUnconfirmed is returned once it's expired, the market keeps its precision then.
+func AwaitMarketPrecisionChange(change *MarketPrecisionChange, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		if result := config.DataBase.First(change, change.ID); result.Error != nil {
+			return result.Error
+		}
+
+		switch {
+		case change.State == MarketPrecisionChangeStateCompleted:
+			return nil
+		case change.State == MarketPrecisionChangeStateExpired:
+			return ErrPrecisionChangeUnconfirmed
+		case time.Now().After(deadline):
+			// the engine may complete it at the same time, the state it's left in decides
+			result := config.DataBase.Model(change).Where("state = ?", MarketPrecisionChangeStatePending).Update("state", MarketPrecisionChangeStateExpired)
+			if result.Error != nil {
+				return result.Error
+			}
+
+			if result.RowsAffected > 0 {
+				return ErrPrecisionChangeUnconfirmed
+			}
+		default:
+			time.Sleep(precisionChangePoll)
+		}
+	}
+}
+
+// CompleteMarketPrecisionChange gives the market the precision of the change once its engine re-keyed the book,
+// with the next version of its configuration. A change which isn't pending anymore is left as it is.
+func CompleteMarketPrecisionChange(tx *gorm.DB, id int64, repriced, cancelled int, now time.Time) (*MarketPrecisionChange, error) {
+	change := &MarketPrecisionChange{}
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(change, id); result.Error != nil {
+			return result.Error
+		}
+
+		if change.State != MarketPrecisionChangeStatePending {
+			return errPrecisionChangeEnded
+		}
+
+		var market *Market
+		if result := tx.First(&market, "symbol = ?", change.MarketID); result.Error != nil {
+			return result.Error
+		}
+
+		if result := tx.Model(market).Update("price_precision", change.PricePrecision); result.Error != nil {
+			return result.Error
+		}
+
+		if _, err := BumpMarketConfigVersion(tx, market, change.CreatedBy); err != nil {
+			return err
+		}
+
+		change.State = MarketPrecisionChangeStateCompleted
+		change.Repriced = repriced
+		change.Cancelled = cancelled
+		change.CompletedAt = sql.NullTime{Time: now, Valid: true}
+
+		return tx.Save(change).Error
+	})
+
+	if errors.Is(err, errPrecisionChangeEnded) {
+		config.Logger.Warnf("Precision change %d of market %s is %s, the market isn't changed", change.ID, change.MarketID, change.State)
+		return change, nil
+	}
+
+	return change, err
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestNewMarketPrecisionChange(t *testing.T) {
+	market := &Market{Symbol: "btcusdt", PricePrecision: 4, MinPrice: decimal.RequireFromString("0.01"), MaxPrice: decimal.NewFromInt(100000)}
+
+	change, err := NewMarketPrecisionChange(market, 2, "U1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if change.FromPricePrecision != 4 || change.PricePrecision != 2 || change.State != MarketPrecisionChangeStatePending || change.MarketID != "btcusdt" {
+		t.Errorf("unexpected change %+v", change)
+	}
+
+	tests := []struct {
+		name            string
+		price_precision int
+	}{
+		{"same precision", 4},
+		{"negative precision", -1},
+		{"more decimals than the columns", 17},
+		{"min price of more decimals", 1},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewMarketPrecisionChange(market, tt.price_precision, "U1"); err != ErrInvalidPricePrecision {
+			t.Errorf("%s: got %v, want %v", tt.name, err, ErrInvalidPricePrecision)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestMarketValidateOrderSize(t *testing.T) {
+	d := decimal.RequireFromString
+	market := &Market{MinAmount: d("0.1"), MaxAmount: d("100"), MaxQuoteAmount: d("5000")}
+
+	tests := []struct {
+		amount, quote_amount string
+		want                 error
+	}{
+		{"0.1", "5", nil},
+		{"0.09999", "5", ErrOrderAmountBelowMin},
+		{"100", "5000", nil},
+		{"100.00001", "10", ErrOrderAmountAboveMax},
+		{"10", "5000.00001", ErrOrderQuoteAmountAboveMax},
+		// market sells don't have a quote amount before they're matched
+		{"100", "0", nil},
+	}
+
+	for _, tt := range tests {
+		if got := market.ValidateOrderSize(d(tt.amount), d(tt.quote_amount)); got != tt.want {
+			t.Errorf("ValidateOrderSize(%s, %s) = %v, want %v", tt.amount, tt.quote_amount, got, tt.want)
+		}
+	}
+
+	stepped := &Market{MinAmount: d("0.1"), LotSize: d("0.05"), MinNotional: d("10")}
+	for _, tt := range []struct {
+		amount, quote_amount string
+		want                 error
+	}{
+		{"0.15", "10", nil},
+		{"0.16", "10", ErrOrderAmountNotLotSize},
+		{"0.15", "9.99", ErrOrderQuoteAmountBelowMin},
+		// market sells are left to the engine
+		{"0.15", "0", nil},
+	} {
+		if got := stepped.ValidateOrderSize(d(tt.amount), d(tt.quote_amount)); got != tt.want {
+			t.Errorf("ValidateOrderSize(%s, %s) = %v, want %v with a lot size", tt.amount, tt.quote_amount, got, tt.want)
+		}
+	}
+
+	uncapped := &Market{MinAmount: d("0.1")}
+	if err := uncapped.ValidateOrderSize(d("1000000"), d("1000000000")); err != nil {
+		t.Errorf("got %v without caps", err)
+	}
+}
+
+func TestOrderQuoteAmount(t *testing.T) {
+	d := decimal.RequireFromString
+	market := &Market{MinAmount: d("0.1"), MaxAmount: d("100"), MaxQuoteAmount: d("5000")}
+
+	// a market buy is capped by the funds it locked, even when its estimated amount is within the cap
+	market_buy := &Order{Type: SideBuy, OriginVolume: d("50"), OriginLocked: d("5000.01")}
+	if err := market.ValidateOrderSize(market_buy.OriginVolume, market_buy.QuoteAmount()); err != ErrOrderQuoteAmountAboveMax {
+		t.Errorf("got %v for a market buy over the quote cap", err)
+	}
+
+	market_buy.OriginLocked = d("5000")
+	if err := market.ValidateOrderSize(market_buy.OriginVolume, market_buy.QuoteAmount()); err != nil {
+		t.Errorf("got %v for a market buy at the quote cap", err)
+	}
+
+	limit_sell := &Order{Type: SideSell, Price: decimal.NewNullDecimal(d("50")), OriginVolume: d("100"), OriginLocked: d("100")}
+	if got := limit_sell.QuoteAmount(); !got.Equal(d("5000")) {
+		t.Errorf("got %s for a limit sell", got)
+	}
+
+	market_sell := &Order{Type: SideSell, OriginVolume: d("100"), OriginLocked: d("100")}
+	if got := market_sell.QuoteAmount(); !got.IsZero() {
+		t.Errorf("got %s for a market sell", got)
+	}
+}
+
+func TestMarketQuoteQuantityVolume(t *testing.T) {
+	d := decimal.RequireFromString
+
+	cases := []struct {
+		name           string
+		market         Market
+		quote_quantity string
+		best_ask       string
+		want           string
+	}{
+		{"rounded down to the amount precision", Market{AmountPrecision: 4}, "100", "30000", "0.0033"},
+		{"rounded down to the lot size", Market{AmountPrecision: 4, LotSize: d("0.002")}, "100", "30000", "0.002"},
+		{"within the max amount", Market{AmountPrecision: 4, MaxAmount: d("0.001")}, "100", "30000", "0.001"},
+		{"without asks", Market{AmountPrecision: 4}, "100", "0", "0"},
+	}
+
+	for _, c := range cases {
+		if got := c.market.QuoteQuantityVolume(d(c.quote_quantity), d(c.best_ask)); !got.Equal(d(c.want)) {
+			t.Errorf("%s: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+}
+
+func TestMarketValidDepthStep(t *testing.T) {
+	d := decimal.RequireFromString
+	market := Market{PricePrecision: 2}
+
+	for _, step := range []string{"0", "0.01", "0.1", "1", "10", "100"} {
+		if !market.ValidDepthStep(d(step)) {
+			t.Errorf("expected the depth to be grouped by %s", step)
+		}
+	}
+
+	for _, step := range []string{"0.001", "0.05", "1000", "-1"} {
+		if market.ValidDepthStep(d(step)) {
+			t.Errorf("expected the depth not to be grouped by %s", step)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/types"
+)
+
+const (
+	// DefaultTradingStateChangeTimeout is how long a trading state change waits for the engine when
+	// engine.trading_state_timeout isn't set.
+	DefaultTradingStateChangeTimeout = 10 * time.Second
+	// tradingStateChangePoll is how often a trading state change waiting for the engine checks whether it confirmed
+	tradingStateChangePoll = 100 * time.Millisecond
+)
+
+type MarketTradingStateChangeState string
+
+var (
+	// MarketTradingStateChangeStatePending is a change sent to the engine, the market keeps its state meanwhile
+	MarketTradingStateChangeStatePending MarketTradingStateChangeState = "pending"
+	// MarketTradingStateChangeStateCompleted is a change the engine applied to the book, the market has the new state
+	MarketTradingStateChangeStateCompleted MarketTradingStateChangeState = "completed"
+	// MarketTradingStateChangeStateExpired is a change the engine didn't confirm in time, the market kept its state
+	MarketTradingStateChangeStateExpired MarketTradingStateChangeState = "expired"
+)
+
+var (
+	ErrInvalidTradingState           = errors.New("admin.market.invalid_trading_state")
+	ErrTradingStateChangeExists      = errors.New("admin.market.trading_state_change_exists")
+	ErrTradingStateChangeUnconfirmed = errors.New("admin.market.trading_state_change_unconfirmed")
+	ErrMarketHalted                  = errors.New("market.order.market_halted")
+
+	// errTradingStateChangeEnded stops the engine completing a change which expired before it applied it
+	errTradingStateChangeEnded = errors.New("the trading state change ended")
+)
+
+// MarketTradingStateChange is a change of the trading state of a market by an admin. The engine enforces the state
+// on the book, the market only takes it once the engine confirmed it applied it, a change expires without it.
+type MarketTradingStateChange struct {
+	ID       int64  `json:"id" gorm:"primaryKey"`
+	MarketID string `json:"market_id" gorm:"index"`
+	// FromTradingState is the state of the market when the change was made, TradingState the one it changes to
+	FromTradingState types.TradingState            `json:"from_trading_state"`
+	TradingState     types.TradingState            `json:"trading_state"`
+	State            MarketTradingStateChangeState `json:"state"`
+	CreatedBy        string                        `json:"created_by"`
+	CompletedAt      sql.NullTime                  `json:"completed_at"`
+	CreatedAt        time.Time                     `json:"created_at"`
+	UpdatedAt        time.Time                     `json:"updated_at"`
+}
+
+func TradingStateChangeTimeout() time.Duration {
+	if config.Engine.TradingStateTimeout > 0 {
+		return config.Engine.TradingStateTimeout
+	}
+
+	return DefaultTradingStateChangeTimeout
+}
+
+// NewMarketTradingStateChange checks the trading state market changes to.
+func NewMarketTradingStateChange(market *Market, trading_state types.TradingState, created_by string) (*MarketTradingStateChange, error) {
+	if !trading_state.Valid() {
+		return nil, ErrInvalidTradingState
+	}
+
+	return &MarketTradingStateChange{
+		MarketID:         market.Symbol,
+		FromTradingState: market.EngineTradingState(),
+		TradingState:     trading_state,
+		State:            MarketTradingStateChangeStatePending,
+		CreatedBy:        created_by,
+	}, nil
+}
+
+// RequestMarketTradingStateChange creates the change and sends it to the engine of the market, a market has one
+// pending change at most. A pending change older than the timeout was left by a process which stopped waiting
+// for it, it's expired.
+func RequestMarketTradingStateChange(tx *gorm.DB, change *MarketTradingStateChange, market *Market, now time.Time) error {
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&Market{}, market.ID); result.Error != nil {
+			return result.Error
+		}
+
+		if result := tx.Model(&MarketTradingStateChange{}).
+			Where("market_id = ? AND state = ? AND created_at < ?", change.MarketID, MarketTradingStateChangeStatePending, now.Add(-TradingStateChangeTimeout())).
+			Update("state", MarketTradingStateChangeStateExpired); result.Error != nil {
+			return result.Error
+		}
+
+		var pending int64
+		if result := tx.Model(&MarketTradingStateChange{}).
+			Where("market_id = ? AND state = ?", change.MarketID, MarketTradingStateChangeStatePending).
+			Count(&pending); result.Error != nil {
+			return result.Error
+		}
+
+		if pending > 0 {
+			return ErrTradingStateChangeExists
+		}
+
+		return tx.Create(change).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return config.Bus.Publish("matching", market.Symbol, map[string]interface{}{
+		"action": events.ActionTradingState,
+		"symbol": market.GetSymbol(),
+		"trading_state": events.TradingStateChange{
+			ID:    change.ID,
+			State: change.TradingState,
+		},
+	})
+}
+
+// AwaitMarketTradingStateChange waits for the engine to complete the change until the timeout, the change is
+// expired without it. ErrTradingStateChangeUnconfirmed is returned once it's expired, the market keeps its state then.
+func AwaitMarketTradingStateChange(change *MarketTradingStateChange, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		if result := config.DataBase.First(change, change.ID); result.Error != nil {
+			return result.Error
+		}
+
+		switch {
+		case change.State == MarketTradingStateChangeStateCompleted:
+			return nil
+		case change.State == MarketTradingStateChangeStateExpired:
+			return ErrTradingStateChangeUnconfirmed
+		case time.Now().After(deadline):
+			// the engine may complete it at the same time, the state it's left in decides
+			result := config.DataBase.Model(change).Where("state = ?", MarketTradingStateChangeStatePending).Update("state", MarketTradingStateChangeStateExpired)
+			if result.Error != nil {
+				return result.Error
+			}
+
+			if result.RowsAffected > 0 {
+				return ErrTradingStateChangeUnconfirmed
+			}
+		default:
+			time.Sleep(tradingStateChangePoll)
+		}
+	}
+}
+
+// CompleteMarketTradingStateChange gives the market the trading state of the change once its engine applied it to
+// the book, and tells the clients connected to the market. A change which isn't pending anymore is left as it is.
+func CompleteMarketTradingStateChange(tx *gorm.DB, id int64, now time.Time) (*MarketTradingStateChange, error) {
+	change := &MarketTradingStateChange{}
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(change, id); result.Error != nil {
+			return result.Error
+		}
+
+		if change.State != MarketTradingStateChangeStatePending {
+			return errTradingStateChangeEnded
+		}
+
+		if result := tx.Model(&Market{}).Where("symbol = ?", change.MarketID).Update("trading_state", change.TradingState); result.Error != nil {
+			return result.Error
+		}
+
+		change.State = MarketTradingStateChangeStateCompleted
+		change.CompletedAt = sql.NullTime{Time: now, Valid: true}
+
+		return tx.Save(change).Error
+	})
+
+	if errors.Is(err, errTradingStateChangeEnded) {
+		config.Logger.Warnf("Trading state change %d of market %s is %s, the market isn't changed", change.ID, change.MarketID, change.State)
+		return change, nil
+	}
+
+	if err != nil {
+		return change, err
+	}
+
+	config.RangoClient.EnqueueEvent("public", change.MarketID, "market_state", events.MarketStateEvent{
+		Market:       change.MarketID,
+		TradingState: change.TradingState,
+		At:           now.Unix(),
+	})
+
+	return change, nil
+}
@@ -0,0 +1,474 @@
+package models
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/decimalutil"
+)
+
+const (
+	// VolumeWindow is the period the rolling volumes are summed over.
+	VolumeWindow = 24 * time.Hour
+	// volumeBuckets is the number of one minute buckets in VolumeWindow.
+	volumeBuckets = int64(VolumeWindow / time.Minute)
+	// SparklinePoints is the number of hourly volumes of a market sparkline.
+	SparklinePoints = 24
+	// volumeMirrorExpiry drops the mirror of markets which stopped trading.
+	volumeMirrorExpiry = VolumeWindow + time.Hour
+)
+
+type VolumeBucket struct {
+	// Minute is the unix time of the bucket in minutes
+	Minute int64           `json:"m"`
+	Base   decimal.Decimal `json:"b"`
+	Quote  decimal.Decimal `json:"q"`
+	// Open, High, Low and Close are the prices of the trades of the minute, zero in the buckets mirrored before
+	// they were kept
+	Open  decimal.Decimal `json:"o"`
+	High  decimal.Decimal `json:"h"`
+	Low   decimal.Decimal `json:"l"`
+	Close decimal.Decimal `json:"c"`
+}
+
+// MarketVolume keeps the traded volume and prices of a market over the last VolumeWindow in preallocated minute
+// buckets.
+type MarketVolume struct {
+	Market      string          `json:"market"`
+	LastPrice   decimal.Decimal `json:"last_price"`
+	LastTradeAt time.Time       `json:"last_trade_at"`
+	buckets     [volumeBuckets]VolumeBucket
+}
+
+// marketVolumeMirror is the representation of a MarketVolume stored in Redis, only non empty buckets are kept.
+type marketVolumeMirror struct {
+	Market      string          `json:"market"`
+	LastPrice   decimal.Decimal `json:"last_price"`
+	LastTradeAt time.Time       `json:"last_trade_at"`
+	Buckets     []VolumeBucket  `json:"buckets"`
+}
+
+func NewMarketVolume(market string) *MarketVolume {
+	return &MarketVolume{Market: market}
+}
+
+func volumeMinute(at time.Time) int64 {
+	return at.Unix() / 60
+}
+
+func marketVolumeKey(market string) string {
+	return "finex:volume:" + market
+}
+
+// Add counts a trade in the bucket of its minute, trades older than the window kept by the buckets are ignored.
+func (v *MarketVolume) Add(at time.Time, price, amount, total decimal.Decimal) {
+	minute := volumeMinute(at)
+	bucket := &v.buckets[minute%volumeBuckets]
+
+	if bucket.Minute > minute {
+		return
+	}
+
+	if bucket.Minute < minute {
+		*bucket = VolumeBucket{Minute: minute, Base: decimal.Zero, Quote: decimal.Zero}
+	}
+
+	if bucket.High.IsZero() {
+		bucket.Open, bucket.High, bucket.Low = price, price, price
+	}
+
+	bucket.Base = bucket.Base.Add(amount)
+	bucket.Quote = bucket.Quote.Add(total)
+	// the trades of a market are executed in order, the last one of the minute closes it
+	bucket.High = decimal.Max(bucket.High, price)
+	bucket.Low = decimal.Min(bucket.Low, price)
+	bucket.Close = price
+
+	if !at.Before(v.LastTradeAt) {
+		v.LastPrice = price
+		v.LastTradeAt = at
+	}
+}
+
+// inWindow reports whether the bucket of minute counts in the window ending at now.
+func inWindow(minute, now_minute int64) bool {
+	return minute <= now_minute && minute > now_minute-volumeBuckets
+}
+
+// Volume returns the base and quote volumes of the last VolumeWindow.
+func (v *MarketVolume) Volume(now time.Time) (base, quote decimal.Decimal) {
+	now_minute := volumeMinute(now)
+	base = decimal.Zero
+	quote = decimal.Zero
+
+	for i := range v.buckets {
+		bucket := &v.buckets[i]
+		if bucket.Minute == 0 || !inWindow(bucket.Minute, now_minute) {
+			continue
+		}
+
+		base = base.Add(bucket.Base)
+		quote = quote.Add(bucket.Quote)
+	}
+
+	return
+}
+
+// MarketTicker are the statistics of the trades of a market over the last VolumeWindow.
+type MarketTicker struct {
+	Market string
+	// Open is the price of the first trade of the window, High and Low the extremes and Last the price of the last
+	// trade, the last price of the market when it didn't trade in the window
+	Open        decimal.Decimal
+	High        decimal.Decimal
+	Low         decimal.Decimal
+	Last        decimal.Decimal
+	Volume      decimal.Decimal
+	QuoteVolume decimal.Decimal
+	// PriceChangePercent is the change from Open to Last in percent, rounded to 2 decimals
+	PriceChangePercent decimal.Decimal
+	At                 time.Time
+}
+
+// Ticker rolls the minute buckets of the window ending at now up into the statistics of the window. A minute
+// drops out of the window once it's VolumeWindow old, with its prices and volume.
+func (v *MarketVolume) Ticker(now time.Time) *MarketTicker {
+	now_minute := volumeMinute(now)
+	ticker := &MarketTicker{
+		Market:             v.Market,
+		Open:               v.LastPrice,
+		High:               v.LastPrice,
+		Low:                v.LastPrice,
+		Last:               v.LastPrice,
+		Volume:             decimal.Zero,
+		QuoteVolume:        decimal.Zero,
+		PriceChangePercent: decimal.Zero,
+		At:                 now,
+	}
+
+	var first *VolumeBucket
+	for i := range v.buckets {
+		bucket := &v.buckets[i]
+		if bucket.Minute == 0 || !inWindow(bucket.Minute, now_minute) {
+			continue
+		}
+
+		ticker.Volume = ticker.Volume.Add(bucket.Base)
+		ticker.QuoteVolume = ticker.QuoteVolume.Add(bucket.Quote)
+
+		if bucket.High.IsZero() {
+			continue
+		}
+
+		if first == nil {
+			ticker.High = bucket.High
+			ticker.Low = bucket.Low
+		} else {
+			ticker.High = decimal.Max(ticker.High, bucket.High)
+			ticker.Low = decimal.Min(ticker.Low, bucket.Low)
+		}
+
+		if first == nil || bucket.Minute < first.Minute {
+			first = bucket
+		}
+	}
+
+	if first == nil {
+		return ticker
+	}
+
+	ticker.Open = first.Open
+	if !ticker.Open.IsZero() {
+		change := ticker.Last.Sub(ticker.Open).Div(ticker.Open).Mul(decimal.NewFromInt(100))
+		ticker.PriceChangePercent = decimalutil.Round(change, 2, decimalutil.HalfUp)
+	}
+
+	return ticker
+}
+
+// RoundTicker rounds the prices and the volume of a ticker to the precisions of its market.
+func RoundTicker(ticker *MarketTicker, market *Market) *MarketTicker {
+	rounded := *ticker
+	rounded.Open = market.round_price(ticker.Open)
+	rounded.High = market.round_price(ticker.High)
+	rounded.Low = market.round_price(ticker.Low)
+	rounded.Last = market.round_price(ticker.Last)
+	rounded.Volume = market.round_amount(ticker.Volume)
+
+	return &rounded
+}
+
+// Sparkline returns the base volume of each of the last SparklinePoints hours, the oldest first.
+func (v *MarketVolume) Sparkline(now time.Time) []decimal.Decimal {
+	now_minute := volumeMinute(now)
+	points := make([]decimal.Decimal, SparklinePoints)
+	for i := range points {
+		points[i] = decimal.Zero
+	}
+
+	for i := range v.buckets {
+		bucket := &v.buckets[i]
+		if bucket.Minute == 0 || !inWindow(bucket.Minute, now_minute) {
+			continue
+		}
+
+		point := SparklinePoints - 1 - int((now_minute-bucket.Minute)/60)
+		if point < 0 {
+			continue
+		}
+
+		points[point] = points[point].Add(bucket.Base)
+	}
+
+	return points
+}
+
+func (v *MarketVolume) MarshalJSON() ([]byte, error) {
+	mirror := marketVolumeMirror{
+		Market:      v.Market,
+		LastPrice:   v.LastPrice,
+		LastTradeAt: v.LastTradeAt,
+		Buckets:     make([]VolumeBucket, 0),
+	}
+
+	for _, bucket := range v.buckets {
+		if bucket.Minute > 0 {
+			mirror.Buckets = append(mirror.Buckets, bucket)
+		}
+	}
+
+	sort.Slice(mirror.Buckets, func(i, j int) bool {
+		return mirror.Buckets[i].Minute < mirror.Buckets[j].Minute
+	})
+
+	return json.Marshal(mirror)
+}
+
+func (v *MarketVolume) UnmarshalJSON(data []byte) error {
+	var mirror marketVolumeMirror
+	if err := json.Unmarshal(data, &mirror); err != nil {
+		return err
+	}
+
+	*v = MarketVolume{
+		Market:      mirror.Market,
+		LastPrice:   mirror.LastPrice,
+		LastTradeAt: mirror.LastTradeAt,
+	}
+
+	for _, bucket := range mirror.Buckets {
+		v.buckets[bucket.Minute%volumeBuckets] = bucket
+	}
+
+	return nil
+}
+
+// addCandle sets the bucket of the minute of a 1m candle, the quote volume is estimated at its close.
+func (v *MarketVolume) addCandle(candle *Candle) {
+	minute := volumeMinute(candle.Time)
+	v.buckets[minute%volumeBuckets] = VolumeBucket{
+		Minute: minute,
+		Base:   candle.Volume,
+		Quote:  candle.Volume.Mul(candle.Close),
+		Open:   candle.Open,
+		High:   candle.High,
+		Low:    candle.Low,
+		Close:  candle.Close,
+	}
+
+	if !candle.Time.Before(v.LastTradeAt) {
+		v.LastPrice = candle.Close
+		v.LastTradeAt = candle.Time
+	}
+}
+
+// RebuildMarketVolume counts the 1m candles of the last VolumeWindow. Candles only store the base volume,
+// the quote volume of a minute is estimated at its close.
+func RebuildMarketVolume(market string, now time.Time) *MarketVolume {
+	volume := NewMarketVolume(market)
+	to := now.Truncate(time.Minute).Add(time.Minute)
+
+	for _, candle := range GetCandlesFromInflux(market, "1m", to.Add(-VolumeWindow), to) {
+		volume.addCandle(candle)
+	}
+
+	if volume.LastPrice.IsZero() {
+		volume.LastPrice = GetLastCandleCloseFromInflux(market, "1m", to)
+	}
+
+	return volume
+}
+
+// LoadMarketVolume reads the volume of a market from its Redis mirror, it's rebuilt from the candles when there's none.
+func LoadMarketVolume(market string, now time.Time) *MarketVolume {
+	if exist, _ := config.Redis.Exist(marketVolumeKey(market)); exist {
+		if result, err := config.Redis.Get(marketVolumeKey(market)); err == nil {
+			volume := NewMarketVolume(market)
+			if err := json.Unmarshal([]byte(result.Val()), volume); err == nil {
+				return volume
+			}
+		}
+	}
+
+	return RebuildMarketVolume(market, now)
+}
+
+// VolumeCounters keeps the volume of every market in memory, updated from the executed trades,
+// and mirrors it to Redis for the API replicas.
+type VolumeCounters struct {
+	sync.Mutex
+	markets map[string]*MarketVolume
+	dirty   map[string]bool
+}
+
+// TradeVolumes are the counters of the trade executor.
+var TradeVolumes = NewVolumeCounters()
+
+func NewVolumeCounters() *VolumeCounters {
+	return &VolumeCounters{
+		markets: make(map[string]*MarketVolume),
+		dirty:   make(map[string]bool),
+	}
+}
+
+// Record counts a trade, the counter of a market is loaded from its mirror first so a restart keeps its history.
+func (c *VolumeCounters) Record(trade *Trade) {
+	c.Lock()
+	defer c.Unlock()
+
+	volume, ok := c.markets[trade.MarketID]
+	if !ok {
+		volume = LoadMarketVolume(trade.MarketID, time.Now())
+		c.markets[trade.MarketID] = volume
+	}
+
+	created_at := trade.CreatedAt
+	if created_at.IsZero() {
+		created_at = time.Now()
+	}
+
+	volume.Add(created_at, trade.Price, trade.Amount, trade.Total)
+	c.dirty[trade.MarketID] = true
+}
+
+// Mirror writes the counters changed since the last call to Redis.
+func (c *VolumeCounters) Mirror() {
+	c.Lock()
+	payloads := make(map[string][]byte, len(c.dirty))
+	for market := range c.dirty {
+		payload, err := json.Marshal(c.markets[market])
+		if err != nil {
+			config.Logger.Errorf("Failed to encode the volume of %s: %v", market, err)
+			continue
+		}
+
+		payloads[market] = payload
+	}
+	c.dirty = make(map[string]bool)
+	c.Unlock()
+
+	for market, payload := range payloads {
+		if err := config.Redis.Set(marketVolumeKey(market), string(payload), volumeMirrorExpiry); err != nil {
+			config.Logger.Errorf("Failed to mirror the volume of %s: %v", market, err)
+		}
+	}
+}
+
+// MirrorEvery mirrors the counters to Redis every period until the process exits.
+func (c *VolumeCounters) MirrorEvery(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.Mirror()
+	}
+}
+
+type MarketVolumeSummary struct {
+	Market      string
+	BaseVolume  decimal.Decimal
+	QuoteVolume decimal.Decimal
+	// USDTVolume is the quote volume in USDT, it isn't valid when the quote currency can't be converted
+	USDTVolume decimal.NullDecimal
+	LastPrice  decimal.Decimal
+	Sparkline  []decimal.Decimal
+}
+
+type VolumeSummary struct {
+	TotalUSDTVolume decimal.Decimal
+	Markets         []*MarketVolumeSummary
+	UpdatedAt       time.Time
+}
+
+// SummarizeVolumes sums the volumes of the markets in USDT, quote currencies without a USDT market
+// are converted at the cross rate of the markets they're traded in.
+func SummarizeVolumes(markets []*Market, volumes map[string]*MarketVolume, now time.Time) *VolumeSummary {
+	prices := make([]*MarketPrice, 0, len(markets))
+	for _, market := range markets {
+		if volume, ok := volumes[market.Symbol]; ok {
+			prices = append(prices, &MarketPrice{
+				BaseUnit:  market.BaseUnit,
+				QuoteUnit: market.QuoteUnit,
+				Price:     volume.LastPrice,
+			})
+		}
+	}
+
+	summary := &VolumeSummary{
+		TotalUSDTVolume: decimal.Zero,
+		Markets:         make([]*MarketVolumeSummary, 0, len(markets)),
+		UpdatedAt:       now,
+	}
+
+	for _, market := range markets {
+		volume, ok := volumes[market.Symbol]
+		if !ok {
+			volume = NewMarketVolume(market.Symbol)
+		}
+
+		base, quote := volume.Volume(now)
+		market_summary := &MarketVolumeSummary{
+			Market:      market.Symbol,
+			BaseVolume:  base,
+			QuoteVolume: quote,
+			LastPrice:   volume.LastPrice,
+			Sparkline:   volume.Sparkline(now),
+		}
+
+		if rate, ok := USDTRate(market.QuoteUnit, prices); ok {
+			usdt_volume := decimalutil.ConvertWithRate(quote, rate, 8)
+			market_summary.USDTVolume = decimal.NewNullDecimal(usdt_volume)
+			summary.TotalUSDTVolume = summary.TotalUSDTVolume.Add(usdt_volume)
+		}
+
+		summary.Markets = append(summary.Markets, market_summary)
+	}
+
+	return summary
+}
+
+// Visible returns the summary of the visible markets, the total only counts their volumes.
+func (s *VolumeSummary) Visible(markets map[string]bool) *VolumeSummary {
+	visible := &VolumeSummary{
+		TotalUSDTVolume: decimal.Zero,
+		Markets:         make([]*MarketVolumeSummary, 0, len(markets)),
+		UpdatedAt:       s.UpdatedAt,
+	}
+
+	for _, market := range s.Markets {
+		if !markets[market.Market] {
+			continue
+		}
+
+		visible.Markets = append(visible.Markets, market)
+		if market.USDTVolume.Valid {
+			visible.TotalUSDTVolume = visible.TotalUSDTVolume.Add(market.USDTVolume.Decimal)
+		}
+	}
+
+	return visible
+}
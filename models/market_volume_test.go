@@ -0,0 +1,194 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestMarketVolumeWindow(t *testing.T) {
+	d := decimal.RequireFromString
+	now := time.Date(2022, 5, 2, 12, 30, 0, 0, time.UTC)
+	volume := NewMarketVolume("btcusdt")
+
+	volume.Add(now.Add(-VolumeWindow), d("100"), d("5"), d("500"))
+	volume.Add(now.Add(-VolumeWindow+time.Minute), d("100"), d("1"), d("100"))
+	volume.Add(now.Add(-time.Hour), d("110"), d("2"), d("220"))
+	volume.Add(now.Add(-30*time.Second), d("120"), d("1"), d("120"))
+	volume.Add(now.Add(-30*time.Second), d("121"), d("1"), d("121"))
+
+	base, quote := volume.Volume(now)
+	if !base.Equal(d("5")) || !quote.Equal(d("561")) {
+		t.Errorf("expected a volume of 5 / 561, got %s / %s", base, quote)
+	}
+
+	if !volume.LastPrice.Equal(d("121")) {
+		t.Errorf("expected the last price to be 121, got %s", volume.LastPrice)
+	}
+
+	// a trade from a day ago doesn't overwrite the bucket of the current minute
+	volume.Add(now.Add(-VolumeWindow), d("90"), d("9"), d("810"))
+	if base, _ := volume.Volume(now); !base.Equal(d("5")) {
+		t.Errorf("expected an old trade to be ignored, got %s", base)
+	}
+
+	sparkline := volume.Sparkline(now)
+	if len(sparkline) != SparklinePoints || !sparkline[SparklinePoints-1].Equal(d("2")) || !sparkline[SparklinePoints-2].Equal(d("2")) || !sparkline[0].Equal(d("1")) {
+		t.Errorf("unexpected sparkline %v", sparkline)
+	}
+
+	payload, err := json.Marshal(volume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewMarketVolume("btcusdt")
+	if err := json.Unmarshal(payload, restored); err != nil {
+		t.Fatal(err)
+	}
+
+	if restored_base, restored_quote := restored.Volume(now); !restored_base.Equal(base) || !restored_quote.Equal(quote) || !restored.LastPrice.Equal(volume.LastPrice) {
+		t.Errorf("the mirror changed the volume to %s / %s", restored_base, restored_quote)
+	}
+}
+
+func TestMarketTickerWindow(t *testing.T) {
+	d := decimal.RequireFromString
+	now := time.Date(2022, 5, 2, 12, 30, 0, 0, time.UTC)
+	volume := NewMarketVolume("btcusdt")
+
+	// the oldest minute of the window, it drops off a minute later
+	oldest := now.Add(-VolumeWindow + time.Minute)
+	volume.Add(oldest, d("100"), d("1"), d("100"))
+	volume.Add(oldest.Add(10*time.Second), d("150"), d("1"), d("150"))
+	volume.Add(oldest.Add(20*time.Second), d("120"), d("1"), d("120"))
+	volume.Add(now.Add(-time.Hour), d("90"), d("2"), d("180"))
+	volume.Add(now.Add(-30*time.Second), d("110"), d("1"), d("110"))
+
+	tests := []struct {
+		name   string
+		at     time.Time
+		open   string
+		high   string
+		low    string
+		last   string
+		volume string
+		quote  string
+		change string
+	}{
+		{"oldest minute in the window", now, "100", "150", "90", "110", "6", "660", "10"},
+		{"end of the oldest minute", now.Add(59 * time.Second), "100", "150", "90", "110", "6", "660", "10"},
+		{"oldest minute dropped off", now.Add(time.Minute), "90", "110", "90", "110", "3", "290", "22.22"},
+		{"no trade in the window", now.Add(VolumeWindow), "110", "110", "110", "110", "0", "0", "0"},
+	}
+
+	for _, tt := range tests {
+		ticker := volume.Ticker(tt.at)
+		if !ticker.Open.Equal(d(tt.open)) || !ticker.High.Equal(d(tt.high)) || !ticker.Low.Equal(d(tt.low)) || !ticker.Last.Equal(d(tt.last)) {
+			t.Errorf("%s: prices %s/%s/%s/%s, want %s/%s/%s/%s", tt.name, ticker.Open, ticker.High, ticker.Low, ticker.Last, tt.open, tt.high, tt.low, tt.last)
+		}
+
+		if !ticker.Volume.Equal(d(tt.volume)) || !ticker.QuoteVolume.Equal(d(tt.quote)) || !ticker.PriceChangePercent.Equal(d(tt.change)) {
+			t.Errorf("%s: volume %s / %s change %s, want %s / %s change %s", tt.name, ticker.Volume, ticker.QuoteVolume, ticker.PriceChangePercent, tt.volume, tt.quote, tt.change)
+		}
+	}
+
+	// a restart rebuilds the ticker from the minutes of the mirror
+	payload, err := json.Marshal(volume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewMarketVolume("btcusdt")
+	if err := json.Unmarshal(payload, restored); err != nil {
+		t.Fatal(err)
+	}
+
+	got, want := restored.Ticker(now), volume.Ticker(now)
+	if !got.Open.Equal(want.Open) || !got.High.Equal(want.High) || !got.Low.Equal(want.Low) || !got.Last.Equal(want.Last) || !got.QuoteVolume.Equal(want.QuoteVolume) {
+		t.Errorf("the mirror changed the ticker to %+v, want %+v", got, want)
+	}
+}
+
+func TestMarketTickerOfAnOldMirror(t *testing.T) {
+	d := decimal.RequireFromString
+	now := time.Date(2022, 5, 2, 12, 30, 0, 0, time.UTC)
+
+	// the buckets mirrored before the prices were kept only have volumes
+	volume := NewMarketVolume("btcusdt")
+	payload := `{"market":"btcusdt","last_price":"105","last_trade_at":"2022-05-02T12:00:00Z","buckets":[{"m":27524880,"b":"2","q":"210"}]}`
+	if err := json.Unmarshal([]byte(payload), volume); err != nil {
+		t.Fatal(err)
+	}
+
+	ticker := volume.Ticker(now)
+	if !ticker.Open.Equal(d("105")) || !ticker.Low.Equal(d("105")) || !ticker.Volume.Equal(d("2")) || !ticker.PriceChangePercent.IsZero() {
+		t.Errorf("unexpected ticker %+v", ticker)
+	}
+
+	volume.Add(now, d("110"), d("1"), d("110"))
+	if ticker := volume.Ticker(now); !ticker.Open.Equal(d("110")) || !ticker.Low.Equal(d("110")) || !ticker.Volume.Equal(d("3")) {
+		t.Errorf("unexpected ticker %+v", ticker)
+	}
+}
+
+func TestUSDTRate(t *testing.T) {
+	d := decimal.RequireFromString
+	prices := []*MarketPrice{
+		{BaseUnit: "btc", QuoteUnit: "usdt", Price: d("30000")},
+		{BaseUnit: "eth", QuoteUnit: "btc", Price: d("0.05")},
+		{BaseUnit: "usdt", QuoteUnit: "vnd", Price: d("25000")},
+	}
+
+	tests := []struct {
+		currency string
+		rate     string
+		ok       bool
+	}{
+		{"usdt", "1", true},
+		{"btc", "30000", true},
+		{"eth", "1500", true},
+		{"vnd", "0.00004", true},
+		{"doge", "0", false},
+	}
+
+	for _, tt := range tests {
+		rate, ok := USDTRate(tt.currency, prices)
+		if ok != tt.ok || !rate.Equal(d(tt.rate)) {
+			t.Errorf("USDTRate(%s) = (%s, %v), want (%s, %v)", tt.currency, rate, ok, tt.rate, tt.ok)
+		}
+	}
+}
+
+func TestSummarizeVolumes(t *testing.T) {
+	d := decimal.RequireFromString
+	now := time.Date(2022, 5, 2, 12, 30, 0, 0, time.UTC)
+	markets := []*Market{
+		{Symbol: "btcusdt", BaseUnit: "btc", QuoteUnit: "usdt"},
+		{Symbol: "ethbtc", BaseUnit: "eth", QuoteUnit: "btc"},
+		{Symbol: "dogexyz", BaseUnit: "doge", QuoteUnit: "xyz"},
+	}
+
+	volumes := map[string]*MarketVolume{
+		"btcusdt": NewMarketVolume("btcusdt"),
+		"ethbtc":  NewMarketVolume("ethbtc"),
+	}
+	volumes["btcusdt"].Add(now, d("30000"), d("2"), d("60000"))
+	volumes["ethbtc"].Add(now, d("0.05"), d("10"), d("0.5"))
+
+	summary := SummarizeVolumes(markets, volumes, now)
+
+	if !summary.TotalUSDTVolume.Equal(d("75000")) {
+		t.Errorf("expected a total of 75000 USDT, got %s", summary.TotalUSDTVolume)
+	}
+
+	if len(summary.Markets) != 3 || !summary.Markets[1].USDTVolume.Decimal.Equal(d("15000")) {
+		t.Errorf("expected the ETH/BTC volume to be converted through BTC/USDT, got %+v", summary.Markets[1])
+	}
+
+	if summary.Markets[2].USDTVolume.Valid || !summary.Markets[2].BaseVolume.IsZero() {
+		t.Errorf("expected a market without trades nor rate to have no USDT volume, got %+v", summary.Markets[2])
+	}
+}
@@ -17,8 +17,15 @@ type Member struct {
 	State       string         `json:"state"`
 	ReferralUID sql.NullString `json:"referral_uid"`
 	Username    sql.NullString `json:"username"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
+	ParentID    sql.NullInt64  `json:"parent_id"`
+	// ReferralCodeID is the referral code the member signed up with
+	ReferralCodeID sql.NullInt64 `json:"referral_code_id"`
+	// MarketGroup is the group of the markets the member can see and trade
+	MarketGroup string `json:"market_group" gorm:"default:public"`
+	// MarketDataSubscription gives the member the market data of the subscriber tier
+	MarketDataSubscription bool      `json:"market_data_subscription" gorm:"default:false"`
+	CreatedAt              time.Time `json:"created_at"`
+	UpdatedAt              time.Time `json:"updated_at"`
 }
 
 func (m *Member) GetAccount(currency *Currency) *Account {
@@ -29,18 +36,28 @@ func (m *Member) GetAccount(currency *Currency) *Account {
 	return account
 }
 
+// referralUID is the referrer of the member, sub-accounts are attributed to the referrer of their parent.
+func (m *Member) referralUID() sql.NullString {
+	if parent := m.Parent(); parent != nil {
+		return parent.ReferralUID
+	}
+
+	return m.ReferralUID
+}
+
 func (m *Member) HavingReferraller() bool {
-	return m.ReferralUID.Valid
+	return m.referralUID().Valid
 }
 
 func (m *Member) GetRefMember() *Member {
-	if !m.ReferralUID.Valid {
+	referral_uid := m.referralUID()
+	if !referral_uid.Valid {
 		return nil
 	}
 
 	var member *Member
 
-	config.DataBase.First(&member, "uid = ?", m.ReferralUID)
+	config.DataBase.First(&member, "uid = ?", referral_uid)
 
 	return member
 }
@@ -0,0 +1,647 @@
+package models
+
+import (
+	"archive/zip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+var ReportKindMemberExport ReportKind = "member_export"
+
+// RedactedIdentifier replaces the identifiers of other members in an export.
+const RedactedIdentifier = "redacted"
+
+// DefaultMemberExportLinkTTL is how long a download link is valid for when member_exports.link_ttl isn't set.
+const DefaultMemberExportLinkTTL = 15 * time.Minute
+
+// memberExportChunk is the number of rows read at once while writing an export.
+const memberExportChunk = 1000
+
+const (
+	memberExportIVSize  = aes.BlockSize
+	memberExportMACSize = sha256.Size
+)
+
+var (
+	ErrMemberExportKey      = errors.New("admin.member_export.key_not_configured")
+	ErrMemberExportLink     = errors.New("admin.member_export.invalid_link")
+	ErrMemberExportNotReady = errors.New("admin.member_export.not_ready")
+	ErrMemberExportCorrupt  = errors.New("admin.member_export.corrupt")
+)
+
+type MemberExportParams struct {
+	MemberID int64 `json:"member_id"`
+}
+
+// memberExportReadme is the first file of an export, it tells the member what the other files hold.
+const memberExportReadme = `profile.json       the member account
+orders.csv         the orders placed by the member
+trades.csv         the trades of the orders of the member, one row per side the member took
+commissions.csv    the referral commissions earned by the member
+releases.csv       the commissions released to the member, adjustments take back released commissions
+transfers.csv      the transfers between the member and its sub-accounts or parent
+adjustments.csv    the balance adjustments made by admins
+audit_events.csv   the actions taken on the data of the member
+
+Identifiers of other members are replaced with "redacted". API keys are issued and held by the
+authentication service, their metadata is exported by it and isn't part of this export.
+`
+
+// memberExportKeys derives the keys encrypting the exports, authenticating them and signing their links
+// from member_exports.encryption_key, so no key is used for two purposes.
+func memberExportKeys() (encryption, authentication, link []byte, err error) {
+	key, err := hex.DecodeString(config.MemberExports.EncryptionKey)
+	if err != nil || len(key) != 32 {
+		return nil, nil, nil, ErrMemberExportKey
+	}
+
+	derive := func(purpose string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(purpose))
+		return mac.Sum(nil)
+	}
+
+	return derive("encryption"), derive("authentication"), derive("link"), nil
+}
+
+// memberExportEncrypter encrypts an export as it's written, with AES-CTR and an HMAC-SHA256 of the IV and
+// the ciphertext appended on Close, so an export of any size is encrypted without holding it in memory.
+type memberExportEncrypter struct {
+	w      io.Writer
+	stream cipher.Stream
+	mac    hash.Hash
+}
+
+func newMemberExportEncrypter(w io.Writer) (*memberExportEncrypter, error) {
+	encryption, authentication, _, err := memberExportKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, memberExportIVSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(iv); err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, authentication)
+	mac.Write(iv)
+
+	return &memberExportEncrypter{w: w, stream: cipher.NewCTR(block, iv), mac: mac}, nil
+}
+
+func (e *memberExportEncrypter) Write(p []byte) (int, error) {
+	ciphertext := make([]byte, len(p))
+	e.stream.XORKeyStream(ciphertext, p)
+	e.mac.Write(ciphertext)
+
+	return e.w.Write(ciphertext)
+}
+
+func (e *memberExportEncrypter) Close() error {
+	_, err := e.w.Write(e.mac.Sum(nil))
+	return err
+}
+
+// AuthenticateMemberExport checks an encrypted export of size bytes wasn't altered in the bucket, and rewinds it.
+func AuthenticateMemberExport(file io.ReadSeeker, size int64) error {
+	_, authentication, _, err := memberExportKeys()
+	if err != nil {
+		return err
+	}
+
+	if size < memberExportIVSize+memberExportMACSize {
+		return ErrMemberExportCorrupt
+	}
+
+	mac := hmac.New(sha256.New, authentication)
+	if _, err := io.CopyN(mac, file, size-memberExportMACSize); err != nil {
+		return err
+	}
+
+	tag := make([]byte, memberExportMACSize)
+	if _, err := io.ReadFull(file, tag); err != nil {
+		return err
+	}
+
+	if !hmac.Equal(tag, mac.Sum(nil)) {
+		return ErrMemberExportCorrupt
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+
+	return err
+}
+
+// DecryptMemberExport writes the zip of an encrypted export of size bytes to w. The export is authenticated
+// before anything is decrypted, an export which was altered writes nothing.
+func DecryptMemberExport(file io.ReadSeeker, size int64, w io.Writer) error {
+	if err := AuthenticateMemberExport(file, size); err != nil {
+		return err
+	}
+
+	encryption, _, _, err := memberExportKeys()
+	if err != nil {
+		return err
+	}
+
+	iv := make([]byte, memberExportIVSize)
+	if _, err := io.ReadFull(file, iv); err != nil {
+		return err
+	}
+
+	block, err := aes.NewCipher(encryption)
+	if err != nil {
+		return err
+	}
+
+	reader := &cipher.StreamReader{S: cipher.NewCTR(block, iv), R: io.LimitReader(file, size-memberExportIVSize-memberExportMACSize)}
+	_, err = io.Copy(w, reader)
+
+	return err
+}
+
+func memberExportSignature(link []byte, id uuid.UUID, expires int64) string {
+	mac := hmac.New(sha256.New, link)
+	fmt.Fprintf(mac, "%s:%d", id, expires)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignMemberExportLink returns when the download link of the export expires and its signature.
+func SignMemberExportLink(id uuid.UUID, now time.Time) (expires int64, signature string, err error) {
+	_, _, link, err := memberExportKeys()
+	if err != nil {
+		return 0, "", err
+	}
+
+	ttl := config.MemberExports.LinkTTL
+	if ttl <= 0 {
+		ttl = DefaultMemberExportLinkTTL
+	}
+
+	expires = now.Add(ttl).Unix()
+
+	return expires, memberExportSignature(link, id, expires), nil
+}
+
+// VerifyMemberExportLink checks the download link of the export was signed by us and hasn't expired.
+func VerifyMemberExportLink(id uuid.UUID, expires int64, signature string, now time.Time) error {
+	_, _, link, err := memberExportKeys()
+	if err != nil {
+		return err
+	}
+
+	if now.Unix() >= expires || !hmac.Equal([]byte(signature), []byte(memberExportSignature(link, id, expires))) {
+		return ErrMemberExportLink
+	}
+
+	return nil
+}
+
+// EnqueueMemberExport queues the export of member for the report generator and records who asked for it.
+func EnqueueMemberExport(member, actor *Member) (*ReportJob, error) {
+	if _, _, _, err := memberExportKeys(); err != nil {
+		return nil, err
+	}
+
+	job, err := EnqueueReportJob(ReportKindMemberExport, MemberExportParams{MemberID: member.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	RecordAuditEvent(member.ID, actor.UID, "member_export.requested", job.UUID.String())
+
+	return job, nil
+}
+
+// MemberExportMember returns the member an export job is for.
+func (j *ReportJob) MemberExportMember() (*Member, error) {
+	var params MemberExportParams
+	if err := json.Unmarshal([]byte(j.Params), &params); err != nil {
+		return nil, err
+	}
+
+	var member *Member
+	if result := config.DataBase.First(&member, params.MemberID); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return member, nil
+}
+
+// writeMemberExport encrypts the export of the member of the job to w.
+func (j *ReportJob) writeMemberExport(w io.Writer) error {
+	member, err := j.MemberExportMember()
+	if err != nil {
+		return err
+	}
+
+	encrypter, err := newMemberExportEncrypter(w)
+	if err != nil {
+		return err
+	}
+
+	if err := WriteMemberExport(config.DataBase, encrypter, member, config.Reports.ChunkSize); err != nil {
+		return err
+	}
+
+	return encrypter.Close()
+}
+
+// redactMemberID renders member_id when it's the member the export is for, and hides it otherwise.
+func redactMemberID(member *Member, member_id int64) string {
+	if member_id == member.ID {
+		return strconv.FormatInt(member_id, 10)
+	}
+
+	return RedactedIdentifier
+}
+
+// memberExportBatch returns the rows of a file of an export after the row with id after_id and the id of the last one,
+// a batch without rows ends the file.
+type memberExportBatch func(tx *gorm.DB, after_id int64, limit int) (records [][]string, last_id int64, err error)
+
+// writeMemberExportCSV writes a file of an export as CSV, in batches of at most chunk rows in id order.
+func writeMemberExportCSV(tx *gorm.DB, archive *zip.Writer, name string, header []string, chunk int, batch memberExportBatch) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	var after_id int64
+	for {
+		records, last_id, err := batch(tx, after_id, chunk)
+		if err != nil {
+			return err
+		}
+
+		if len(records) == 0 {
+			break
+		}
+
+		if err := writer.WriteAll(records); err != nil {
+			return err
+		}
+
+		after_id = last_id
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+func formatExportTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func memberExportOrderRecord(order *Order) []string {
+	price := ""
+	if order.Price.Valid {
+		price = order.Price.Decimal.String()
+	}
+
+	return []string{
+		strconv.FormatInt(order.ID, 10),
+		order.UUID.String(),
+		order.MarketID,
+		string(order.Side()),
+		string(order.OrdType),
+		price,
+		order.OriginVolume.String(),
+		order.Volume.String(),
+		order.FundsReceived.String(),
+		string(order.State),
+		formatExportTime(order.CreatedAt),
+	}
+}
+
+// memberExportTradeRecords renders the sides of trade the member took, the side of the counterparty is left out.
+func memberExportTradeRecords(member *Member, trade *Trade) [][]string {
+	records := make([][]string, 0, 1)
+
+	record := func(role string, order_id int64, side types.TakerType) []string {
+		return []string{
+			strconv.FormatInt(trade.ID, 10),
+			trade.MarketID,
+			role,
+			strconv.FormatInt(order_id, 10),
+			string(side),
+			trade.Price.String(),
+			trade.Amount.String(),
+			trade.Total.String(),
+			formatExportTime(trade.CreatedAt),
+		}
+	}
+
+	taker_side := trade.TakerType
+	maker_side := types.TypeBuy
+	if taker_side == types.TypeBuy {
+		maker_side = types.TypeSell
+	}
+
+	if trade.MakerID == member.ID {
+		records = append(records, record("maker", trade.MakerOrderID, maker_side))
+	}
+
+	if trade.TakerID == member.ID {
+		records = append(records, record("taker", trade.TakerOrderID, taker_side))
+	}
+
+	return records
+}
+
+func memberExportCommissionRecord(commission *Commission) []string {
+	return []string{
+		strconv.FormatInt(commission.ID, 10),
+		string(commission.AccountType),
+		RedactedIdentifier,
+		commission.CurrencyID,
+		commission.EarnAmount.String(),
+		string(commission.State),
+		formatExportTime(commission.CreatedAt),
+	}
+}
+
+func memberExportReleaseRecord(release *ReleaseCommission) []string {
+	return []string{
+		strconv.FormatInt(release.ID, 10),
+		string(release.AccountType),
+		string(release.Kind),
+		release.EarnedBTC.String(),
+		RedactedIdentifier,
+		formatExportTime(release.CreatedAt),
+	}
+}
+
+func memberExportTransferRecord(member *Member, transfer *Transfer) []string {
+	return []string{
+		strconv.FormatInt(transfer.ID, 10),
+		string(transfer.Kind),
+		redactMemberID(member, transfer.FromMemberID),
+		redactMemberID(member, transfer.ToMemberID),
+		transfer.CurrencyID,
+		transfer.Amount.String(),
+		formatExportTime(transfer.CreatedAt),
+	}
+}
+
+func memberExportAdjustmentRecord(liability *Liability) []string {
+	return []string{
+		strconv.FormatInt(liability.ID, 10),
+		liability.CurrencyID,
+		liability.Credit.String(),
+		liability.Debit.String(),
+		formatExportTime(liability.CreatedAt),
+	}
+}
+
+// WriteMemberExport writes everything held about member to w as a zip of CSV and JSON files. Every table is
+// read in chunks of chunk rows by id, so the export of a member with a long history uses bounded memory.
+func WriteMemberExport(tx *gorm.DB, w io.Writer, member *Member, chunk int) error {
+	if chunk <= 0 {
+		chunk = memberExportChunk
+	}
+
+	archive := zip.NewWriter(w)
+
+	readme, err := archive.Create("README.txt")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(readme, memberExportReadme); err != nil {
+		return err
+	}
+
+	profile, err := archive.Create("profile.json")
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(profile)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(member); err != nil {
+		return err
+	}
+
+	files := []struct {
+		name   string
+		header []string
+		batch  memberExportBatch
+	}{
+		{
+			"orders.csv",
+			[]string{"id", "uuid", "market", "side", "ord_type", "price", "origin_volume", "volume", "funds_received", "state", "created_at"},
+			func(tx *gorm.DB, after_id int64, limit int) ([][]string, int64, error) {
+				var orders []*Order
+				if result := tx.Where("member_id = ? AND id > ?", member.ID, after_id).Order("id asc").Limit(limit).Find(&orders); result.Error != nil || len(orders) == 0 {
+					return nil, 0, result.Error
+				}
+
+				records := make([][]string, len(orders))
+				for i, order := range orders {
+					records[i] = memberExportOrderRecord(order)
+				}
+
+				return records, orders[len(orders)-1].ID, nil
+			},
+		},
+		{
+			"trades.csv",
+			[]string{"id", "market", "role", "order_id", "side", "price", "amount", "total", "created_at"},
+			func(tx *gorm.DB, after_id int64, limit int) ([][]string, int64, error) {
+				var trades []*Trade
+				if result := tx.Where("(maker_id = ? OR taker_id = ?) AND id > ?", member.ID, member.ID, after_id).Order("id asc").Limit(limit).Find(&trades); result.Error != nil || len(trades) == 0 {
+					return nil, 0, result.Error
+				}
+
+				records := make([][]string, 0, len(trades))
+				for _, trade := range trades {
+					records = append(records, memberExportTradeRecords(member, trade)...)
+				}
+
+				return records, trades[len(trades)-1].ID, nil
+			},
+		},
+		{
+			"commissions.csv",
+			[]string{"id", "account_type", "friend_uid", "currency", "earn_amount", "state", "created_at"},
+			func(tx *gorm.DB, after_id int64, limit int) ([][]string, int64, error) {
+				var commissions []*Commission
+				if result := tx.Where("member_id = ? AND id > ?", member.ID, after_id).Order("id asc").Limit(limit).Find(&commissions); result.Error != nil || len(commissions) == 0 {
+					return nil, 0, result.Error
+				}
+
+				records := make([][]string, len(commissions))
+				for i, commission := range commissions {
+					records[i] = memberExportCommissionRecord(commission)
+				}
+
+				return records, commissions[len(commissions)-1].ID, nil
+			},
+		},
+		{
+			"releases.csv",
+			[]string{"id", "account_type", "kind", "earned_btc", "friend", "created_at"},
+			func(tx *gorm.DB, after_id int64, limit int) ([][]string, int64, error) {
+				var releases []*ReleaseCommission
+				if result := tx.Where("member_id = ? AND id > ?", member.ID, after_id).Order("id asc").Limit(limit).Find(&releases); result.Error != nil || len(releases) == 0 {
+					return nil, 0, result.Error
+				}
+
+				records := make([][]string, len(releases))
+				for i, release := range releases {
+					records[i] = memberExportReleaseRecord(release)
+				}
+
+				return records, releases[len(releases)-1].ID, nil
+			},
+		},
+		{
+			"transfers.csv",
+			[]string{"id", "kind", "from_member_id", "to_member_id", "currency", "amount", "created_at"},
+			func(tx *gorm.DB, after_id int64, limit int) ([][]string, int64, error) {
+				var transfers []*Transfer
+				if result := tx.Where("(from_member_id = ? OR to_member_id = ?) AND id > ?", member.ID, member.ID, after_id).Order("id asc").Limit(limit).Find(&transfers); result.Error != nil || len(transfers) == 0 {
+					return nil, 0, result.Error
+				}
+
+				records := make([][]string, len(transfers))
+				for i, transfer := range transfers {
+					records[i] = memberExportTransferRecord(member, transfer)
+				}
+
+				return records, transfers[len(transfers)-1].ID, nil
+			},
+		},
+		{
+			"adjustments.csv",
+			[]string{"id", "currency", "credit", "debit", "created_at"},
+			func(tx *gorm.DB, after_id int64, limit int) ([][]string, int64, error) {
+				var liabilities []*Liability
+				if result := tx.Where("member_id = ? AND reference_type = ? AND id > ?", member.ID, "Adjustment", after_id).Order("id asc").Limit(limit).Find(&liabilities); result.Error != nil || len(liabilities) == 0 {
+					return nil, 0, result.Error
+				}
+
+				records := make([][]string, len(liabilities))
+				for i, liability := range liabilities {
+					records[i] = memberExportAdjustmentRecord(liability)
+				}
+
+				return records, liabilities[len(liabilities)-1].ID, nil
+			},
+		},
+	}
+
+	for _, file := range files {
+		if err := writeMemberExportCSV(tx, archive, file.name, file.header, chunk, file.batch); err != nil {
+			return fmt.Errorf("%s: %w", file.name, err)
+		}
+	}
+
+	if err := writeMemberExportAudits(tx, archive, member, chunk); err != nil {
+		return fmt.Errorf("audit_events.csv: %w", err)
+	}
+
+	return archive.Close()
+}
+
+// writeMemberExportAudits writes the audit events of the member, followed by the audits of the sub-accounts it's the
+// parent or a sub-account of, each in id order.
+func writeMemberExportAudits(tx *gorm.DB, archive *zip.Writer, member *Member, chunk int) error {
+	file, err := archive.Create("audit_events.csv")
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write([]string{"source", "id", "action", "parent_id", "sub_account_id", "data", "created_at"}); err != nil {
+		return err
+	}
+
+	var after_id int64
+	for {
+		var events []*AuditEvent
+		if result := tx.Where("member_id = ? AND id > ?", member.ID, after_id).Order("id asc").Limit(chunk).Find(&events); result.Error != nil {
+			return result.Error
+		}
+
+		if len(events) == 0 {
+			break
+		}
+
+		for _, event := range events {
+			// the actor of an event is an admin or a worker, their uid isn't the member's to receive
+			if err := writer.Write([]string{"audit_event", strconv.FormatInt(event.ID, 10), event.Action, "", "", event.Data, formatExportTime(event.CreatedAt)}); err != nil {
+				return err
+			}
+		}
+
+		after_id = events[len(events)-1].ID
+	}
+
+	after_id = 0
+	for {
+		var audits []*SubAccountAudit
+		if result := tx.Where("(parent_id = ? OR sub_account_id = ?) AND id > ?", member.ID, member.ID, after_id).Order("id asc").Limit(chunk).Find(&audits); result.Error != nil {
+			return result.Error
+		}
+
+		if len(audits) == 0 {
+			break
+		}
+
+		for _, audit := range audits {
+			if err := writer.Write([]string{
+				"sub_account_audit",
+				strconv.FormatInt(audit.ID, 10),
+				audit.Action,
+				redactMemberID(member, audit.ParentID),
+				redactMemberID(member, audit.SubAccountID),
+				audit.Data,
+				formatExportTime(audit.CreatedAt),
+			}); err != nil {
+				return err
+			}
+		}
+
+		after_id = audits[len(audits)-1].ID
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+func withMemberExportKey(t *testing.T) {
+	exports := config.MemberExports
+	config.MemberExports = &types.MemberExportsConfig{EncryptionKey: strings.Repeat("ab", 32), LinkTTL: time.Minute}
+	t.Cleanup(func() { config.MemberExports = exports })
+}
+
+func encryptMemberExport(t *testing.T, plaintext []byte) []byte {
+	var encrypted bytes.Buffer
+	encrypter, err := newMemberExportEncrypter(&encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// written in pieces, the way the zip writer writes
+	encrypter.Write(plaintext[:10])
+	encrypter.Write(plaintext[10:])
+	if err := encrypter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return encrypted.Bytes()
+}
+
+func TestMemberExportEncryption(t *testing.T) {
+	withMemberExportKey(t)
+
+	plaintext := []byte(strings.Repeat("orders.csv,trades.csv\n", 100))
+	encrypted := encryptMemberExport(t, plaintext)
+
+	if bytes.Contains(encrypted, []byte("orders.csv")) {
+		t.Fatal("expected the export to be encrypted")
+	}
+
+	var decrypted bytes.Buffer
+	if err := DecryptMemberExport(bytes.NewReader(encrypted), int64(len(encrypted)), &decrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Error("expected the decrypted export to be the plaintext")
+	}
+
+	encrypted[len(encrypted)/2] ^= 1
+	decrypted.Reset()
+	if err := DecryptMemberExport(bytes.NewReader(encrypted), int64(len(encrypted)), &decrypted); !errors.Is(err, ErrMemberExportCorrupt) {
+		t.Errorf("expected an altered export to be rejected, got %v", err)
+	}
+
+	if decrypted.Len() != 0 {
+		t.Error("expected nothing of an altered export to be decrypted")
+	}
+}
+
+func TestMemberExportKeyRequired(t *testing.T) {
+	exports := config.MemberExports
+	config.MemberExports = &types.MemberExportsConfig{EncryptionKey: "abcd"}
+	t.Cleanup(func() { config.MemberExports = exports })
+
+	if _, err := newMemberExportEncrypter(&bytes.Buffer{}); !errors.Is(err, ErrMemberExportKey) {
+		t.Errorf("expected a short key to be refused, got %v", err)
+	}
+}
+
+func TestMemberExportLink(t *testing.T) {
+	withMemberExportKey(t)
+
+	id := uuid.New()
+	now := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+
+	expires, signature, err := SignMemberExportLink(id, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expires != now.Add(time.Minute).Unix() {
+		t.Errorf("expected the link to expire after link_ttl, got %d", expires)
+	}
+
+	if err := VerifyMemberExportLink(id, expires, signature, now.Add(30*time.Second)); err != nil {
+		t.Errorf("expected the link to be valid, got %v", err)
+	}
+
+	if err := VerifyMemberExportLink(id, expires, signature, now.Add(time.Minute)); !errors.Is(err, ErrMemberExportLink) {
+		t.Errorf("expected an expired link to be refused, got %v", err)
+	}
+
+	if err := VerifyMemberExportLink(id, expires+3600, signature, now); !errors.Is(err, ErrMemberExportLink) {
+		t.Errorf("expected an extended link to be refused, got %v", err)
+	}
+
+	if err := VerifyMemberExportLink(uuid.New(), expires, signature, now); !errors.Is(err, ErrMemberExportLink) {
+		t.Errorf("expected the link of another export to be refused, got %v", err)
+	}
+}
+
+func TestMemberExportRedaction(t *testing.T) {
+	member := &Member{ID: 7, UID: "ID7"}
+
+	trade := &Trade{
+		ID: 1, MarketID: "btcusdt", Price: decimal.NewFromInt(100), Amount: decimal.NewFromInt(2), Total: decimal.NewFromInt(200),
+		MakerID: 8, MakerOrderID: 80, TakerID: 7, TakerOrderID: 70, TakerType: types.TypeSell,
+	}
+
+	records := memberExportTradeRecords(member, trade)
+	if len(records) != 1 {
+		t.Fatalf("expected the side of the member only, got %v", records)
+	}
+
+	if got := strings.Join(records[0][:5], ","); got != "1,btcusdt,taker,70,sell" {
+		t.Errorf("unexpected trade record %s", got)
+	}
+
+	for _, field := range records[0] {
+		if field == "8" || field == "80" {
+			t.Errorf("expected the counterparty to be left out, got %v", records[0])
+		}
+	}
+
+	trade.MakerID, trade.MakerOrderID = 7, 71
+	if records := memberExportTradeRecords(member, trade); len(records) != 2 || records[0][2] != "maker" || records[0][4] != "buy" {
+		t.Errorf("expected both sides of a self-trade, got %v", records)
+	}
+
+	transfer := memberExportTransferRecord(member, &Transfer{ID: 3, FromMemberID: 7, ToMemberID: 9, Amount: decimal.NewFromInt(1)})
+	if transfer[2] != "7" || transfer[3] != RedactedIdentifier {
+		t.Errorf("expected the other member of a transfer to be redacted, got %v", transfer)
+	}
+
+	commission := memberExportCommissionRecord(&Commission{ID: 4, MemberID: 7, FriendUID: "ID9"})
+	for _, field := range commission {
+		if field == "ID9" {
+			t.Errorf("expected the friend of a commission to be redacted, got %v", commission)
+		}
+	}
+}
@@ -23,6 +23,7 @@ type OperationsAccount struct {
 	Type         OperationType
 	Kind         string
 	CurrencyType CurrencyType
+	CurrencyID   string // designates the account to a currency, see GetRevenueCode
 	Description  string
 	Scope        OperationScope
 	CreatedAt    time.Time
@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
@@ -15,6 +16,8 @@ import (
 
 	"github.com/zsmartex/finex/config"
 	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/decimalutil"
+	"github.com/zsmartex/finex/events"
 	"github.com/zsmartex/finex/models/concerns"
 	"github.com/zsmartex/finex/types"
 	"github.com/zsmartex/pkg"
@@ -60,8 +63,58 @@ type Order struct {
 	OriginLocked  decimal.Decimal     `json:"origin_locked" gorm:"default:0.0"`
 	FundsReceived decimal.Decimal     `json:"funds_received" gorm:"default:0.0"`
 	TradesCount   int64               `json:"trades_count" gorm:"default:0"`
-	CreatedAt     time.Time           `json:"created_at"`
-	UpdatedAt     time.Time           `json:"updated_at"`
+	// FundsTraded is the quote total of the fills of the order, AvgPrice the average price they were filled at
+	FundsTraded decimal.Decimal `json:"funds_traded" gorm:"default:0.0"`
+	AvgPrice    decimal.Decimal `json:"avg_price" gorm:"default:0.0"`
+	// ReplacedOrderID is the order this one replaced through a cancel-replace
+	ReplacedOrderID sql.NullInt64 `json:"replaced_order_id"`
+	// AlgoOrderUUID is the algo order which placed this one as a slice
+	AlgoOrderUUID uuid.NullUUID `json:"algo_order_uuid"`
+	// ConvertQuoteUUID is the conversion whose route the convert desk executes with this order
+	ConvertQuoteUUID uuid.NullUUID `json:"convert_quote_uuid"`
+	// ClientID is the id the member placed the order with, unique among the open orders of the member
+	ClientID uuid.NullUUID `json:"client_id"`
+	// PostOnly has the engine cancel the order rather than match it as taker
+	PostOnly bool `json:"post_only" gorm:"default:false"`
+	// TimeInForce is how long the part of the order not matched right away stays in the book
+	TimeInForce types.TimeInForce `json:"time_in_force" gorm:"default:GTC"`
+	// TrailingOffset makes the order a trailing stop, its stop price trails the market price at the offset
+	TrailingOffset decimal.NullDecimal `json:"trailing_offset"`
+	// SelfTradePrevention keeps the order from matching the orders of its member, they're matched when it's empty
+	SelfTradePrevention types.SelfTradePrevention `json:"self_trade_prevention"`
+	// DisplayQuantity makes the order an iceberg, the book only shows slices of it of the quantity
+	DisplayQuantity decimal.NullDecimal `json:"display_quantity"`
+	// ExpiresAt is when the engine cancels a good-till-date order
+	ExpiresAt sql.NullTime `json:"expires_at"`
+	// MaxSlippage is how far from the best price a market order is matched, the rest of it is cancelled
+	MaxSlippage decimal.NullDecimal `json:"max_slippage"`
+	// QuoteQuantity makes a market buy spend at most the quote amount, its locked funds, its volume only caps what it buys
+	QuoteQuantity decimal.NullDecimal `json:"quote_quantity"`
+	// TriggerBy is the price a stop order is triggered by, the last price when it's empty
+	TriggerBy types.TriggerBy `json:"trigger_by"`
+	// AmendPrice and AmendQuantity are the price and the quantity of an amend the engine didn't answer yet
+	AmendPrice    decimal.NullDecimal `json:"amend_price"`
+	AmendQuantity decimal.NullDecimal `json:"amend_quantity"`
+	// DoneAt is when the order left the book, filled, cancelled or rejected
+	DoneAt    sql.NullTime `json:"done_at"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+
+	// admitted is set on the save moving the order from pending to the book, its order event reports it created
+	admitted bool
+}
+
+// FinalOrderStates are the states an order doesn't leave.
+var FinalOrderStates = []OrderState{StateDone, StateCancel, StateReject}
+
+func (o *Order) Final() bool {
+	for _, state := range FinalOrderStates {
+		if o.State == state {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (o Order) Message() map[string]string {
@@ -157,6 +210,20 @@ func (o Order) MarketTypeVaildator(market_type types.AccountType) bool {
 	return false
 }
 
+// QuoteAmount is the size of the order in the quote currency, the funds locked by buy orders which includes the
+// funds of market buys, and the value at the limit price of sell orders. It's zero for market sells.
+func (o *Order) QuoteAmount() decimal.Decimal {
+	if o.Type == SideBuy {
+		return o.OriginLocked
+	}
+
+	if o.Price.Valid {
+		return o.Price.Decimal.Mul(o.OriginVolume)
+	}
+
+	return decimal.Zero
+}
+
 func (o *Order) Market() *Market {
 	market := &Market{}
 
@@ -200,10 +267,62 @@ func GetDepth(side OrderSide, market string) [][]decimal.Decimal {
 }
 
 func SubmitOrder(id int64) error {
+	order, err := lockOrderFunds(id)
+	if order == nil {
+		return err
+	}
+
+	if err == nil {
+		return order.submitToEngine()
+	}
+
+	return nil
+}
+
+// submitAttempts is how many times the submit of an order is published before it's left to the broker, the first
+// retry waits submitBackoff and every next one twice as long.
+const (
+	submitAttempts = 3
+	submitBackoff  = 100 * time.Millisecond
+)
+
+// submitToEngine hands an order whose funds are locked to the engine of its market. When the submit can't be
+// published the error is to be delivered again, the message which submitted the order is processed again and
+// submits it again: the engine drops the submits of the orders it already took.
+func (o *Order) submitToEngine() (err error) {
+	for attempt := 0; attempt < submitAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(submitBackoff << (attempt - 1))
+		}
+
+		err = config.Bus.Publish("matching", o.MarketID, map[string]interface{}{
+			"action":  pkg.ActionSubmit,
+			"order":   o.ToMatchingAttributes(),
+			"options": o.MatchingOptions(),
+		})
+		if err == nil {
+			return nil
+		}
+	}
+
+	return events.Redeliver(fmt.Errorf("failed to submit order %d: %w", o.ID, err))
+}
+
+// lockOrderFunds locks the funds of a pending order and moves it to wait, an order whose funds can't be locked
+// is rejected with ErrInsufficientBalance. The account is locked for the update, so of the orders of a member
+// submitted at once only the ones its balance covers are moved to wait. The order is nil when it doesn't exist.
+func lockOrderFunds(id int64) (*Order, error) {
+	order, _, err := lockPendingOrderFunds(id)
+
+	return order, err
+}
+
+// lockPendingOrderFunds is lockOrderFunds which also reports whether it moved the order to wait, false for an order
+// which wasn't pending anymore.
+func lockPendingOrderFunds(id int64) (order *Order, locked bool, err error) {
 	var account *Account
-	var order *Order
 
-	err := config.DataBase.Transaction(func(tx *gorm.DB) error {
+	err = config.DataBase.Transaction(func(tx *gorm.DB) error {
 		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "orders"}}).Where("id = ?", id).First(&order)
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return fmt.Errorf("can't find order by id : %d", order.ID)
@@ -216,37 +335,37 @@ func SubmitOrder(id int64) error {
 		account_tx := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "accounts"}})
 		account_tx.Where("member_id = ? AND currency_id = ?", order.MemberID, order.Currency().ID).FirstOrCreate(&account)
 		if err := account.LockFunds(account_tx, order.Locked); err != nil {
-			return err
+			return fmt.Errorf("%w: %v", ErrInsufficientBalance, err)
 		}
 
 		order.RecordSubmitOperations()
 
 		order.State = StateWait
+		order.admitted = true
 
 		tx.Save(&order)
+		locked = true
 
 		return nil
 	})
 
 	if err != nil {
+		locked = false
 		result := config.DataBase.Where("id = ?", id).First(&order)
 
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return err
+			return nil, false, err
 		}
 
 		order.State = StateReject
 		config.DataBase.Save(&order)
-	}
 
-	if err == nil {
-		config.KafkaProducer.Produce("matching", map[string]interface{}{
-			"action": pkg.ActionSubmit,
-			"order":  order.ToMatchingAttributes(),
-		})
+		if errors.Is(err, ErrInsufficientBalance) {
+			order.TriggerReject(RejectReasonInsufficientFunds)
+		}
 	}
 
-	return nil
+	return order, locked, err
 }
 
 func CancelOrder(id int64) error {
@@ -281,25 +400,158 @@ func CancelOrder(id int64) error {
 	return err
 }
 
-// Submit order to matching engine
+// DecrementOrder takes quantity off an order the engine keeps in the book, the funds locked for it are unlocked.
+func DecrementOrder(id int64, quantity decimal.Decimal) error {
+	return config.DataBase.Transaction(func(tx *gorm.DB) error {
+		var order *Order
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "orders"}}).Where("id = ?", id).First(&order)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("can't find order by id : %d", id)
+		}
+
+		if order.State != StateWait || !quantity.IsPositive() {
+			return nil
+		}
+
+		quantity = decimal.Min(quantity, order.Volume)
+		unlocked := order.decrementLocked(quantity)
+
+		if unlocked.IsPositive() {
+			var account *Account
+			account_tx := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "accounts"}})
+			account_tx.Where("member_id = ? AND currency_id = ?", order.MemberID, order.Currency().ID).FirstOrCreate(&account)
+			if err := account.UnlockFunds(tx, unlocked); err != nil {
+				return err
+			}
+
+			LiabilityTranfer(unlocked, order.Currency(), Reference{ID: order.ID, Type: string(order.Type)}, "locked", "main", order.MemberID)
+		}
+
+		// the quantity taken off was never placed, it's not counted as executed
+		order.Volume = order.Volume.Sub(quantity)
+		order.OriginVolume = order.OriginVolume.Sub(quantity)
+		order.Locked = order.Locked.Sub(unlocked)
+		order.OriginLocked = order.OriginLocked.Sub(unlocked)
+
+		return tx.Save(order).Error
+	})
+}
+
+// RepriceOrder moves an order the engine keeps in the book to the price and the stop price it re-keyed it at,
+// a zero price leaves the one the order doesn't have unset. A bid only moves down, the funds it locked at its
+// previous price stay locked until it's done.
+func RepriceOrder(id int64, price, stop_price decimal.Decimal) error {
+	return config.DataBase.Transaction(func(tx *gorm.DB) error {
+		var order *Order
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "orders"}}).Where("id = ?", id).First(&order)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("can't find order by id : %d", id)
+		}
+
+		if order.State != StateWait {
+			return nil
+		}
+
+		if price.IsPositive() {
+			order.Price = decimal.NewNullDecimal(price)
+		}
+
+		if stop_price.IsPositive() {
+			order.StopPrice = decimal.NewNullDecimal(stop_price)
+		}
+
+		return tx.Save(order).Error
+	})
+}
+
+// decrementLocked returns the funds locked for quantity of the volume of the order. A sell locks its volume,
+// a limit buy its volume at its price, a market buy is unlocked in proportion.
+func (o *Order) decrementLocked(quantity decimal.Decimal) decimal.Decimal {
+	switch {
+	case quantity.Equal(o.Volume):
+		return o.Locked
+	case o.Type == SideSell:
+		return decimal.Min(quantity, o.Locked)
+	case o.OrdType == types.TypeLimit:
+		return decimal.Min(decimalutil.RoundLocked(o.Price.Decimal.Mul(quantity), SchemaDecimalScale), o.Locked)
+	default:
+		return decimalutil.Round(o.Locked.Mul(quantity).Div(o.Volume), SchemaDecimalScale, decimalutil.Down)
+	}
+}
+
+var ErrInsufficientBalance = errors.New("market.account.insufficient_balance")
+
+// Submit order to matching engine, orders which don't fit in the available balance are rejected.
 func (o *Order) Submit() error {
-	member_balance := o.MemberBalance()
+	if err := o.ReserveFunds(); err != nil {
+		o.State = StateReject
+		config.DataBase.Save(&o)
 
-	if member_balance.LessThan(o.Locked) {
-		return errors.New("market.account.insufficient_balance")
+		return err
 	}
 
 	config.DataBase.Save(&o)
 
-	config.KafkaProducer.Produce("order_processor", map[string]interface{}{
-		"action": pkg.ActionSubmit,
-		"id":     o.ID,
-	})
+	event := events.NewOrder(pkg.ActionSubmit, o.ID, o.UUID, "")
+	if o.ClientID.Valid {
+		event.ClientID = &o.ClientID.UUID
+	}
+
+	config.Bus.Publish("order_processor", o.MarketID, events.EncodeOrder(event))
 
 	return nil
 }
 
+// SubmitNew hands an order which isn't inserted yet to the order processor with its id, the order processor
+// inserts it before submitting it, so the placement doesn't wait for the insert.
+func (o *Order) SubmitNew() error {
+	if err := o.ReserveFunds(); err != nil {
+		return err
+	}
+
+	attributes, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+
+	return config.Bus.Publish("order_processor", o.MarketID, events.EncodeOrder(events.NewOrderCreate(o.ID, o.UUID, attributes)))
+}
+
+// PersistOrder inserts an order handed over by SubmitNew and submits it, an order delivered twice is inserted once.
+func PersistOrder(attributes []byte) error {
+	var order *Order
+	if err := json.Unmarshal(attributes, &order); err != nil {
+		return err
+	}
+
+	if result := config.DataBase.Clauses(clause.OnConflict{DoNothing: true}).Create(&order); result.Error != nil {
+		return result.Error
+	}
+
+	return SubmitOrder(order.ID)
+}
+
+// BeforeCreate assigns the id of the order from the block leased by the process, every process inserting
+// orders takes its ids from the order id sequence.
+func (o *Order) BeforeCreate(tx *gorm.DB) (err error) {
+	if o.ID == 0 {
+		o.ID, err = NextOrderID()
+	}
+
+	return err
+}
+
 func (o *Order) BeforeSave(tx *gorm.DB) (err error) {
+	if o.Final() && !o.DoneAt.Valid {
+		o.DoneAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+
+	if len(o.MarketID) > 0 {
+		if err := o.normalizeDecimals(o.Market()); err != nil {
+			return err
+		}
+	}
+
 	o.TriggerEvent()
 
 	return nil
@@ -311,8 +563,27 @@ func (o *Order) TriggerEvent() {
 	}
 
 	member := o.Member()
+	order_json := o.ToJSON()
 
-	config.RangoClient.EnqueueEvent("private", member.UID, "order", o.ToJSON())
+	config.RangoClient.EnqueueEvent("private", member.UID, "order", order_json)
+	events.PublishPrivate(member.UID, entities.PrivateStreamOrder, entities.PrivateOrderMessage{
+		Event:  entities.PrivateStreamOrder,
+		Action: o.privateAction(),
+		Order:  order_json,
+	})
+}
+
+// privateAction is what the order event reports of the order, a rejected order never was in the book but it's
+// cancelled as far as the member is concerned.
+func (o *Order) privateAction() entities.PrivateOrderAction {
+	switch {
+	case o.State == StateCancel || o.State == StateReject:
+		return entities.PrivateOrderCancelled
+	case o.admitted:
+		return entities.PrivateOrderCreated
+	default:
+		return entities.PrivateOrderUpdated
+	}
 }
 
 func (o *Order) RecordSubmitOperations() {
@@ -393,7 +664,7 @@ func (o *Order) MemberBalance() decimal.Decimal {
 func (o *Order) ComputeLocked() (decimal.Decimal, error) {
 	if o.OrdType == types.TypeLimit {
 		if o.Type == SideBuy {
-			return o.Price.Decimal.Mul(o.Volume), nil
+			return decimalutil.RoundLocked(o.Price.Decimal.Mul(o.Volume), SchemaDecimalScale), nil
 		} else {
 			return o.Volume, nil
 		}
@@ -428,7 +699,7 @@ func (o *Order) ComputeLocked() (decimal.Decimal, error) {
 		if !expected_volume.IsZero() {
 			return decimal.Zero, errors.New("market.order.insufficient_market_liquidity")
 		}
-		return required_funds, nil
+		return decimalutil.RoundLocked(required_funds, SchemaDecimalScale), nil
 	}
 
 	return decimal.Zero, nil
@@ -450,18 +721,28 @@ func (o *Order) FundsUsed() decimal.Decimal {
 	return o.OriginLocked.Sub(o.Locked)
 }
 
-func (o *Order) AvgPrice() decimal.Decimal {
-	if o.Type == SideSell && o.FundsUsed().IsZero() || o.Type == SideBuy && o.FundsReceived.IsZero() {
-		return decimal.Zero
-	}
+// RecordFill adds the total of a fill to the funds traded of the order and moves its average price to the funds
+// traded over its executed volume, rounded to price_precision. The volume of the order is already taken off.
+func (o *Order) RecordFill(total decimal.Decimal, price_precision int32) {
+	o.FundsTraded = o.FundsTraded.Add(total)
+	o.updateAvgPrice(price_precision)
+}
 
-	market := o.Market()
+// RevertFill takes the total of a reverted fill off the funds traded of the order, its volume is already given back.
+func (o *Order) RevertFill(total decimal.Decimal, price_precision int32) {
+	o.FundsTraded = o.FundsTraded.Sub(total)
+	o.updateAvgPrice(price_precision)
+}
 
-	if o.Type == SideSell {
-		return market.round_price(o.FundsReceived.Div(o.FundsUsed()))
-	} else {
-		return market.round_price(o.FundsUsed().Div(o.FundsReceived))
+func (o *Order) updateAvgPrice(price_precision int32) {
+	executed := o.OriginVolume.Sub(o.Volume)
+	if !executed.IsPositive() || !o.FundsTraded.IsPositive() {
+		o.FundsTraded = decimal.Zero
+		o.AvgPrice = decimal.Zero
+		return
 	}
+
+	o.AvgPrice = decimalutil.DivQuote(o.FundsTraded, executed, price_precision)
 }
 
 func (o *Order) Side() types.TakerType {
@@ -501,21 +782,46 @@ func (o *Order) ToJSON() entities.OrderEntity {
 		SideString = "sell"
 	}
 
+	var done_at *time.Time
+	if o.DoneAt.Valid {
+		done_at = &o.DoneAt.Time
+	}
+
+	var expires_at *time.Time
+	if o.ExpiresAt.Valid {
+		expires_at = &o.ExpiresAt.Time
+	}
+
 	return entities.OrderEntity{
-		UUID:            o.UUID,
-		Market:          o.MarketID,
-		Side:            SideString,
-		OrdType:         o.OrdType,
-		Price:           o.Price,
-		StopPrice:       o.StopPrice,
-		AvgPrice:        o.AvgPrice(),
-		State:           StateString,
-		OriginVolume:    o.OriginVolume,
-		RemainingVolume: o.Volume,
-		ExecutedVolume:  o.OriginVolume.Sub(o.Volume),
-		TradesCount:     o.TradesCount,
-		CreatedAt:       o.CreatedAt,
-		UpdatedAt:       o.UpdatedAt,
+		UUID:                o.UUID,
+		Market:              o.MarketID,
+		Side:                SideString,
+		OrdType:             o.OrdType,
+		Price:               o.Price,
+		StopPrice:           o.StopPrice,
+		AvgPrice:            o.AvgPrice,
+		State:               StateString,
+		OriginVolume:        o.OriginVolume,
+		RemainingVolume:     o.Volume,
+		ExecutedVolume:      o.OriginVolume.Sub(o.Volume),
+		TradesCount:         o.TradesCount,
+		MakerFee:            o.MakerFee,
+		TakerFee:            o.TakerFee,
+		AlgoOrderUUID:       o.AlgoOrderUUID,
+		ClientID:            o.ClientID,
+		PostOnly:            o.PostOnly,
+		TimeInForce:         o.TimeInForce,
+		TrailingOffset:      o.TrailingOffset,
+		SelfTradePrevention: o.SelfTradePrevention,
+		DisplayQuantity:     o.DisplayQuantity,
+		ExpiresAt:           expires_at,
+		MaxSlippage:         o.MaxSlippage,
+		QuoteQuantity:       o.QuoteQuantity,
+		FundsReceived:       o.FundsReceived,
+		TriggerBy:           o.TriggerBy,
+		DoneAt:              done_at,
+		CreatedAt:           o.CreatedAt,
+		UpdatedAt:           o.UpdatedAt,
 	}
 }
 
@@ -524,6 +830,50 @@ func FloatToString(input_num float64) string {
 	return strconv.FormatFloat(input_num, 'f', 6, 64)
 }
 
+// MatchingOptions are the options the order is submitted to the engine with, nil when it has none.
+func (o *Order) MatchingOptions() *events.OrderOptions {
+	options := events.OrderOptions{PostOnly: o.PostOnly}
+	if o.TimeInForce != types.TimeInForceGTC {
+		options.TimeInForce = o.TimeInForce
+	}
+
+	if o.TrailingOffset.Valid {
+		options.TrailingOffset = &o.TrailingOffset.Decimal
+	}
+
+	options.SelfTradePrevention = o.SelfTradePrevention
+
+	if o.DisplayQuantity.Valid {
+		options.DisplayQuantity = &o.DisplayQuantity.Decimal
+	}
+
+	if o.ExpiresAt.Valid {
+		options.ExpiresAt = &o.ExpiresAt.Time
+	}
+
+	if o.MaxSlippage.Valid {
+		options.MaxSlippage = &o.MaxSlippage.Decimal
+	}
+
+	if o.ClientID.Valid {
+		options.ClientID = &o.ClientID.UUID
+	}
+
+	if o.QuoteQuantity.Valid {
+		options.QuoteQuantity = &o.QuoteQuantity.Decimal
+	}
+
+	if o.TriggerBy == types.TriggerByIndexPrice {
+		options.TriggerBy = o.TriggerBy
+	}
+
+	if !options.PostOnly && len(options.TimeInForce) == 0 && options.TrailingOffset == nil && len(options.SelfTradePrevention) == 0 && options.DisplayQuantity == nil && options.ExpiresAt == nil && options.MaxSlippage == nil && options.ClientID == nil && options.QuoteQuantity == nil && len(options.TriggerBy) == 0 {
+		return nil
+	}
+
+	return &options
+}
+
 func (o *Order) ToMatchingAttributes() *pkg.Order {
 	var side pkg.OrderSide
 	if o.Type == SideBuy {
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+)
+
+// fastAckOutboxTTL is how long an acknowledged order is kept for the processes persisting it.
+const fastAckOutboxTTL = time.Hour
+
+func fastAckOutboxKey(id int64) string {
+	return "finex:fast_ack:outbox:" + strconv.FormatInt(id, 10)
+}
+
+// Acknowledge places an order without writing to the database: its funds are reserved in the shadow balances,
+// it's kept in the outbox, handed to the order processor to be persisted and submitted to the engine. The order
+// stays pending until the order processor persisted it. It fails with ErrInsufficientBalance when the shadow balance
+// is short, any other failure leaves nothing behind and the order can be placed synchronously.
+func (o *Order) Acknowledge() error {
+	if FastAckBalances == nil {
+		return ErrFastAckClosed
+	}
+
+	currency_id := o.Ask
+	if o.Type == SideBuy {
+		currency_id = o.Bid
+	}
+
+	reservation := &ShadowReservation{
+		OrderID:    o.ID,
+		MemberID:   o.MemberID,
+		CurrencyID: currency_id,
+		Amount:     o.Locked,
+		ReservedAt: time.Now(),
+	}
+
+	if err := FastAckBalances.Reserve(reservation); err != nil {
+		return err
+	}
+
+	attributes, err := json.Marshal(o)
+	if err != nil {
+		FastAckBalances.Release(reservation)
+		return err
+	}
+
+	if err := config.Redis.Set(fastAckOutboxKey(o.ID), string(attributes), fastAckOutboxTTL); err != nil {
+		FastAckBalances.Release(reservation)
+		return err
+	}
+
+	// the order is persisted and submitted by the order processor whatever happens next
+	if err := config.Bus.Publish("order_processor", o.MarketID, events.EncodeOrder(events.NewOrderPersist(o.ID, o.UUID, attributes))); err != nil {
+		FastAckBalances.Release(reservation)
+		return err
+	}
+
+	return nil
+}
+
+// PersistAcknowledgedOrder inserts an order the API acknowledged, locks its funds and submits it to the engine.
+// When its funds can't be locked the shadow balances accepted an order the ledger doesn't cover: the order is
+// rejected as insufficient_funds before it reaches the engine and the fast path is closed. The order is kept in the
+// outbox until it's submitted, persisting it again submits it again while it waits.
+func PersistAcknowledgedOrder(attributes []byte) error {
+	var order *Order
+	if err := json.Unmarshal(attributes, &order); err != nil {
+		return err
+	}
+
+	if result := config.DataBase.Clauses(clause.OnConflict{DoNothing: true}).Create(&order); result.Error != nil {
+		return result.Error
+	}
+
+	persisted, locked, err := lockPendingOrderFunds(order.ID)
+	if persisted == nil {
+		return err
+	}
+
+	if err != nil {
+		reason := fmt.Sprintf("the funds of the acknowledged order %d couldn't be locked: %v", order.ID, err)
+		if err := CloseFastAck(reason); err != nil {
+			config.Logger.Errorf("Failed to close the fast order acknowledgement: %v", err)
+		}
+
+		return config.Redis.Delete(fastAckOutboxKey(order.ID))
+	}
+
+	// an order delivered again whose submit failed is still in the outbox, a Redis error submits it again too
+	if !locked {
+		if exist, err := config.Redis.Exist(fastAckOutboxKey(order.ID)); persisted.State != StateWait || (err == nil && !exist) {
+			return nil
+		}
+	}
+
+	// the outbox is kept when the submit fails, the order processor stops and the persist is delivered again
+	if err := persisted.submitToEngine(); err != nil {
+		return err
+	}
+
+	return config.Redis.Delete(fastAckOutboxKey(order.ID))
+}
+
+var errNotAcknowledged = errors.New("order isn't in the outbox")
+
+// PersistOutboxOrder persists an acknowledged order from the outbox, for the trades which come before
+// the order processor persisted their order.
+func PersistOutboxOrder(id int64) error {
+	exist, err := config.Redis.Exist(fastAckOutboxKey(id))
+	if err != nil {
+		return err
+	} else if !exist {
+		return errNotAcknowledged
+	}
+
+	result, err := config.Redis.Get(fastAckOutboxKey(id))
+	if err != nil {
+		return err
+	}
+
+	return PersistAcknowledgedOrder([]byte(result.Val()))
+}
+
+// Acknowledged reports whether the order is an acknowledged order the order processor didn't persist yet.
+func Acknowledged(id int64) bool {
+	exist, err := config.Redis.Exist(fastAckOutboxKey(id))
+
+	return err == nil && exist
+}
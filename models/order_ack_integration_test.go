@@ -0,0 +1,167 @@
+//go:build integration
+
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg/services"
+
+	"github.com/zsmartex/finex/bus"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/types"
+)
+
+// submitRecorder is a bus which fails the submits while down and records the ones it publishes.
+type submitRecorder struct {
+	bus.EventBus
+
+	mutex     sync.Mutex
+	down      bool
+	submitted []string
+}
+
+func (b *submitRecorder) Publish(topic string, key string, payload interface{}) error {
+	if topic != "matching" {
+		return b.EventBus.Publish(topic, key, payload)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.down {
+		return errors.New("broker is gone")
+	}
+
+	b.submitted = append(b.submitted, key)
+	return nil
+}
+
+// setupAcknowledgedOrders opens the outbox and the bus of the orders of member 61, who has 100 usdt.
+func setupAcknowledgedOrders(t *testing.T) *submitRecorder {
+	setupDelistingDatabase(t)
+
+	if len(os.Getenv("REDIS_URL")) == 0 {
+		t.Skip("REDIS_URL isn't set")
+	}
+
+	redis, err := services.NewRedisClient(os.Getenv("REDIS_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Redis = redis
+
+	db := config.DataBase
+	db.Where("symbol = ?", "ackusdt").Delete(&Market{})
+	db.Where("id = ?", "ack").Delete(&Currency{})
+	db.Where("id = ?", 61).Delete(&Member{})
+	db.Where("member_id = ?", 61).Delete(&Account{})
+
+	db.Create(&Market{Symbol: "ackusdt", BaseUnit: "ack", QuoteUnit: "usdt", AmountPrecision: 4, PricePrecision: 2, State: string(types.MarketStateEndabled)})
+	db.Create(&[]*Currency{{ID: "ack", Type: "coin"}, {ID: "usdt", Type: "coin"}})
+	db.Create(&Member{ID: 61, UID: "ID61"})
+	db.Create(&Account{MemberID: 61, CurrencyID: "usdt", Balance: decimal.NewFromInt(100)})
+
+	if err := ReopenFastAck(); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &submitRecorder{EventBus: config.Bus}
+	config.Bus = recorder
+	t.Cleanup(func() { config.Bus = recorder.EventBus })
+
+	return recorder
+}
+
+// acknowledgedOrder puts an order of the member locking locked in the outbox, as the API acknowledging it does.
+func acknowledgedOrder(t *testing.T, id int64, locked int64) []byte {
+	order := &Order{
+		ID: id, MemberID: 61, Ask: "ack", Bid: "usdt", MarketID: "ackusdt", Type: SideBuy, OrdType: types.TypeLimit,
+		Price: decimal.NewNullDecimal(decimal.NewFromInt(locked)), Volume: decimal.NewFromInt(1), OriginVolume: decimal.NewFromInt(1),
+		Locked: decimal.NewFromInt(locked), OriginLocked: decimal.NewFromInt(locked), State: StatePending,
+	}
+
+	attributes, err := json.Marshal(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.Redis.Set(fastAckOutboxKey(id), string(attributes), fastAckOutboxTTL); err != nil {
+		t.Fatal(err)
+	}
+
+	return attributes
+}
+
+// The tests need the DATABASE_* variables of a disposable database, KAFKA_URL for the events of the orders and
+// REDIS_URL for the outbox:
+//
+//	go test -tags integration -run 'PersistAcknowledged' ./models
+func TestPersistAcknowledgedOrderSubmitsAgain(t *testing.T) {
+	recorder := setupAcknowledgedOrders(t)
+	attributes := acknowledgedOrder(t, 9200, 60)
+
+	// the submit is lost, the persist is left to the broker with the order in the outbox
+	recorder.down = true
+	if err := PersistAcknowledgedOrder(attributes); !errors.Is(err, events.ErrRedeliver) {
+		t.Fatalf("expected the persist to be delivered again, got %v", err)
+	}
+
+	var order *Order
+	config.DataBase.First(&order, 9200)
+	if order.State != StateWait || !Acknowledged(9200) {
+		t.Fatalf("expected the order to wait in the outbox, got %v", order.State)
+	}
+
+	// the persist delivered again submits it, and only once
+	recorder.down = false
+	for i := 0; i < 2; i++ {
+		if err := PersistAcknowledgedOrder(attributes); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(recorder.submitted) != 1 || Acknowledged(9200) {
+		t.Errorf("expected the order submitted once out of the outbox, got %d submits", len(recorder.submitted))
+	}
+
+	var account *Account
+	config.DataBase.Where("member_id = ? AND currency_id = ?", 61, "usdt").First(&account)
+	if !account.Locked.Equal(decimal.NewFromInt(60)) {
+		t.Errorf("expected 60 locked once, got %s", account.Locked)
+	}
+}
+
+func TestPersistAcknowledgedOrderUncoveredClosesFastAck(t *testing.T) {
+	recorder := setupAcknowledgedOrders(t)
+
+	// the shadow balances accepted an order of 120 against the balance of 100
+	if err := PersistAcknowledgedOrder(acknowledgedOrder(t, 9210, 120)); err != nil {
+		t.Fatal(err)
+	}
+
+	var order *Order
+	config.DataBase.First(&order, 9210)
+	if order.State != StateReject || len(recorder.submitted) != 0 {
+		t.Errorf("expected the order rejected before the engine, got %v and %d submits", order.State, len(recorder.submitted))
+	}
+
+	closed, err := config.Redis.Exist(fastAckClosedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !closed {
+		t.Error("expected the fast path closed")
+	}
+
+	if err := ReopenFastAck(); err != nil {
+		t.Fatal(err)
+	}
+}
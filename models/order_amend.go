@@ -0,0 +1,176 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/decimalutil"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/types"
+)
+
+var (
+	ErrAmendNotOpen = errors.New("market.order.amend_not_open")
+	ErrAmendPending = errors.New("market.order.amend_pending")
+	// ErrAmendBelowFilled refuses an amend to a quantity the order already filled
+	ErrAmendBelowFilled = errors.New("market.order.amend_below_filled")
+)
+
+// AmendOrder sends an amend of an open limit order to price and quantity, quantity is the whole quantity of the
+// order, its filled part included. The funds the amended order needs beyond the ones it locked are locked right
+// away, the order is amended by ApplyAmend once the engine accepted it and the funds it doesn't need anymore are
+// unlocked then.
+func AmendOrder(order *Order, price, quantity decimal.Decimal) error {
+	err := config.DataBase.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "orders"}}).Where("id = ?", order.ID).First(&order); result.Error != nil {
+			return result.Error
+		}
+
+		if order.State != StateWait || order.OrdType != types.TypeLimit {
+			return ErrAmendNotOpen
+		}
+
+		if order.AmendQuantity.Valid {
+			return ErrAmendPending
+		}
+
+		filled := order.OriginVolume.Sub(order.Volume)
+		if quantity.LessThanOrEqual(filled) {
+			return ErrAmendBelowFilled
+		}
+
+		if extra := order.lockedAt(price, quantity.Sub(filled)).Sub(order.Locked); extra.IsPositive() {
+			var account *Account
+			tx.
+				Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "accounts"}}).
+				Where("member_id = ? AND currency_id = ?", order.MemberID, order.Currency().ID).
+				FirstOrCreate(&account)
+
+			if GetAvailableBalance(tx, account, order.ID).Available.LessThan(extra) {
+				return ErrInsufficientBalance
+			}
+
+			if err := account.LockFunds(tx, extra); err != nil {
+				return err
+			}
+
+			LiabilityTranfer(extra, order.Currency(), Reference{ID: order.ID, Type: string(order.Type)}, "main", "locked", order.MemberID)
+
+			order.Locked = order.Locked.Add(extra)
+			order.OriginLocked = order.OriginLocked.Add(extra)
+		}
+
+		order.AmendPrice = decimal.NewNullDecimal(price)
+		order.AmendQuantity = decimal.NewNullDecimal(quantity)
+
+		return tx.Save(order).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	config.Bus.Publish("matching", order.MarketID, map[string]interface{}{
+		"action":    events.ActionAmend,
+		"key":       order.ToMatchingAttributes().Key(),
+		"amendment": &events.Amendment{Price: &price, Quantity: &quantity},
+	})
+
+	return nil
+}
+
+// ApplyAmend gives an order the price and the quantity of the amend the engine accepted. The order processor and
+// the trade executor both apply it, whichever gets the amend first.
+func ApplyAmend(id int64) error {
+	return config.DataBase.Transaction(func(tx *gorm.DB) error {
+		var order *Order
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "orders"}}).Where("id = ?", id).First(&order); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("can't find order by id : %d", id)
+		}
+
+		if !order.AmendQuantity.Valid {
+			return nil
+		}
+
+		// the order left the book meanwhile, what it locked was given back with it
+		if order.State == StateWait {
+			filled := order.OriginVolume.Sub(order.Volume)
+
+			order.Price = order.AmendPrice
+			order.OriginVolume = order.AmendQuantity.Decimal
+			order.Volume = order.AmendQuantity.Decimal.Sub(filled)
+
+			if err := order.settleLocked(tx); err != nil {
+				return err
+			}
+		}
+
+		order.AmendPrice = decimal.NullDecimal{}
+		order.AmendQuantity = decimal.NullDecimal{}
+
+		return tx.Save(order).Error
+	})
+}
+
+// RejectAmend drops an amend the engine didn't accept, the funds locked for it are unlocked.
+func RejectAmend(id int64) error {
+	return config.DataBase.Transaction(func(tx *gorm.DB) error {
+		var order *Order
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "orders"}}).Where("id = ?", id).First(&order); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("can't find order by id : %d", id)
+		}
+
+		if !order.AmendQuantity.Valid {
+			return nil
+		}
+
+		if order.State == StateWait {
+			if err := order.settleLocked(tx); err != nil {
+				return err
+			}
+		}
+
+		order.AmendPrice = decimal.NullDecimal{}
+		order.AmendQuantity = decimal.NullDecimal{}
+
+		return tx.Save(order).Error
+	})
+}
+
+// lockedAt returns the funds a limit order needs for volume at price.
+func (o *Order) lockedAt(price, volume decimal.Decimal) decimal.Decimal {
+	if o.Type == SideSell {
+		return volume
+	}
+
+	return decimalutil.RoundLocked(price.Mul(volume), SchemaDecimalScale)
+}
+
+// settleLocked unlocks the funds the order locked beyond the ones its volume needs at its price.
+func (o *Order) settleLocked(tx *gorm.DB) error {
+	surplus := o.Locked.Sub(o.lockedAt(o.Price.Decimal, o.Volume))
+	if !surplus.IsPositive() {
+		return nil
+	}
+
+	var account *Account
+	tx.
+		Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "accounts"}}).
+		Where("member_id = ? AND currency_id = ?", o.MemberID, o.Currency().ID).
+		FirstOrCreate(&account)
+
+	if err := account.UnlockFunds(tx, surplus); err != nil {
+		return err
+	}
+
+	LiabilityTranfer(surplus, o.Currency(), Reference{ID: o.ID, Type: string(o.Type)}, "locked", "main", o.MemberID)
+
+	o.Locked = o.Locked.Sub(surplus)
+	o.OriginLocked = o.OriginLocked.Sub(surplus)
+
+	return nil
+}
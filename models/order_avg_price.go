@@ -0,0 +1,37 @@
+package models
+
+import "gorm.io/gorm"
+
+// BackfillOrdersAvgPrice sets the funds traded and the average price of the orders filled before they were recorded
+// with each fill, from the trades of the orders which weren't reverted.
+const BackfillOrdersAvgPrice = "backfill_orders_avg_price"
+
+func init() {
+	RegisterBackgroundMigration(BackfillOrdersAvgPrice, backfillOrdersAvgPrice)
+}
+
+func backfillOrdersAvgPrice(tx *gorm.DB, cursor int64, batch_size int) (int64, int64, error) {
+	ids := make([]int64, 0, batch_size)
+	if result := tx.Model(&Order{}).Where("id > ?", cursor).Order("id asc").Limit(batch_size).Pluck("id", &ids); result.Error != nil {
+		return cursor, 0, result.Error
+	}
+
+	if len(ids) == 0 {
+		return cursor, 0, nil
+	}
+
+	// the orders recorded with their fills already have funds traded, the batch can run again
+	result := tx.Exec(`
+		UPDATE orders SET funds_traded = fills.total, avg_price = ROUND(fills.total / fills.amount, markets.price_precision)
+		FROM (
+			SELECT orders.id AS order_id, SUM(trades.total) AS total, SUM(trades.amount) AS amount
+			FROM orders JOIN trades ON trades.maker_order_id = orders.id OR trades.taker_order_id = orders.id
+			WHERE orders.id IN ? AND trades.reverted_at IS NULL
+			GROUP BY orders.id
+		) AS fills, markets
+		WHERE orders.id = fills.order_id AND markets.symbol = orders.market_id AND orders.funds_traded = 0 AND fills.amount > 0`,
+		ids,
+	)
+
+	return ids[len(ids)-1], int64(len(ids)), result.Error
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/zsmartex/pkg"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+)
+
+// NewBatch returns a batch of a new id with nothing in it.
+func NewBatch(all_or_nothing bool) *events.Batch {
+	return &events.Batch{ID: uuid.NewString(), AllOrNothing: all_or_nothing, Entries: make([]*events.BatchEntry, 0)}
+}
+
+// SubmitBatch hands a batch of pending orders of market to submit and of open orders to cancel to the order
+// processor, the funds of the orders must have been reserved.
+func SubmitBatch(market string, batch *events.Batch) error {
+	return config.Bus.Publish("order_processor", market, events.EncodeOrder(events.NewOrderBatch(events.ActionBatch, batch)))
+}
+
+// ProcessBatch locks the funds of the orders of a batch and sends its submits and its cancels to the engine in one
+// command. An order whose funds can't be locked is rejected and left out, unless the batch is all or nothing, then
+// the funds of the others are unlocked and nothing reaches the engine.
+func ProcessBatch(batch *events.Batch) error {
+	matching_batch := &events.MatchingBatch{ID: batch.ID, Entries: make([]*events.MatchingPayload, 0, len(batch.Entries))}
+	locked := make([]int64, 0, len(batch.Entries))
+	failed := false
+
+	for _, entry := range batch.Entries {
+		switch entry.Action {
+		case pkg.ActionSubmit:
+			order, err := lockOrderFunds(entry.ID)
+			if order == nil || err != nil {
+				config.Logger.Warnf("Order %d of batch %s rejected: %v", entry.ID, batch.ID, err)
+				failed = true
+
+				continue
+			}
+
+			locked = append(locked, order.ID)
+			matching_batch.Entries = append(matching_batch.Entries, &events.MatchingPayload{
+				MatchingPayloadMessage: pkg.MatchingPayloadMessage{
+					Action: pkg.ActionSubmit,
+					Order:  order.ToMatchingAttributes(),
+				},
+				Options: order.MatchingOptions(),
+			})
+		case pkg.ActionCancel:
+			var order *Order
+			if result := config.DataBase.Where("id = ?", entry.ID).First(&order); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				config.Logger.Warnf("Order %d of batch %s to cancel not found", entry.ID, batch.ID)
+
+				continue
+			}
+
+			matching_batch.Entries = append(matching_batch.Entries, &events.MatchingPayload{
+				MatchingPayloadMessage: pkg.MatchingPayloadMessage{
+					Action: pkg.ActionCancelWithKey,
+					Key:    order.ToMatchingAttributes().Key(),
+				},
+			})
+		}
+	}
+
+	if batch.AllOrNothing && failed {
+		for _, id := range locked {
+			if err := CancelOrder(id); err != nil {
+				config.Logger.Errorf("Failed to cancel order %d of batch %s: %v", id, batch.ID, err)
+			}
+		}
+
+		return nil
+	}
+
+	if len(matching_batch.Entries) == 0 {
+		return nil
+	}
+
+	symbol, _ := matching_batch.Symbol()
+
+	return config.Bus.Publish("matching", strings.ToLower(symbol.ToSymbol("")), map[string]interface{}{
+		"action": events.ActionBatch,
+		"batch":  matching_batch,
+	})
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// ErrClientIDTaken refuses an order placed with the client id of an open order of the member which isn't the same
+// order.
+var ErrClientIDTaken = errors.New("market.order.client_id_taken")
+
+// OpenOrderByClientID returns the pending or open order of the member placed with client_id, nil when it has none.
+func OpenOrderByClientID(tx *gorm.DB, member_id int64, client_id uuid.UUID) *Order {
+	var order *Order
+	result := tx.Where("member_id = ? AND client_id = ? AND state IN ?", member_id, client_id, []OrderState{StatePending, StateWait}).First(&order)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil
+	}
+
+	return order
+}
+
+// CreateClientOrder inserts an order placed with a client id unless the member has an open order placed with it,
+// which is returned instead. The member row is locked so two placements with the same client id are checked one
+// after the other.
+func CreateClientOrder(order *Order) (existing *Order, err error) {
+	err = config.DataBase.Transaction(func(tx *gorm.DB) error {
+		var member *Member
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "members"}}).Where("id = ?", order.MemberID).First(&member).Error; err != nil {
+			return err
+		}
+
+		if existing = OpenOrderByClientID(tx, order.MemberID, order.ClientID.UUID); existing != nil {
+			return nil
+		}
+
+		return tx.Create(&order).Error
+	})
+
+	return existing, err
+}
@@ -0,0 +1,30 @@
+package models
+
+import "gorm.io/gorm"
+
+// BackfillOrdersDoneAt sets the done_at of the orders which left the book before it was recorded,
+// to their last update which is when they reached their final state.
+const BackfillOrdersDoneAt = "backfill_orders_done_at"
+
+func init() {
+	RegisterBackgroundMigration(BackfillOrdersDoneAt, backfillOrdersDoneAt)
+}
+
+func backfillOrdersDoneAt(tx *gorm.DB, cursor int64, batch_size int) (int64, int64, error) {
+	ids := make([]int64, 0, batch_size)
+	if result := tx.Model(&Order{}).Where("id > ?", cursor).Order("id asc").Limit(batch_size).Pluck("id", &ids); result.Error != nil {
+		return cursor, 0, result.Error
+	}
+
+	if len(ids) == 0 {
+		return cursor, 0, nil
+	}
+
+	// update_column skips the hooks, orders aren't sent to their members again
+	result := tx.
+		Model(&Order{}).
+		Where("id IN ? AND state IN ? AND done_at IS NULL", ids, FinalOrderStates).
+		UpdateColumn("done_at", gorm.Expr("updated_at"))
+
+	return ids[len(ids)-1], int64(len(ids)), result.Error
+}
@@ -0,0 +1,80 @@
+//go:build integration
+
+package models
+
+import (
+	"os"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg/services"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+// The benchmarks compare the placement of an order by the API with fast_ack off and on, from the check of its funds
+// to the event handing it over. They need the DATABASE_* variables of a disposable database, KAFKA_URL for the
+// events of the orders and REDIS_URL for the shadow balances:
+//
+//	go test -tags integration -run XXX -bench 'Placement' ./models
+func setupPlacementBenchmark(b *testing.B) {
+	setupDelistingDatabase(b)
+
+	db := config.DataBase
+	if err := db.AutoMigrate(&IDSequence{}); err != nil {
+		b.Fatal(err)
+	}
+	config.OrderIDs = &types.OrderIDsConfig{}
+
+	// the members 1 to 1000 the orders are placed for can afford all of them
+	db.Where("member_id BETWEEN ? AND ? AND currency_id = ?", 1, 1000, "usdt").Delete(&Account{})
+	balance := decimal.NewFromInt(30000).Mul(decimal.NewFromInt(int64(b.N) + 1))
+
+	accounts := make([]*Account, 0, 1000)
+	for member_id := int64(1); member_id <= 1000; member_id++ {
+		accounts = append(accounts, &Account{MemberID: member_id, CurrencyID: "usdt", Balance: balance})
+	}
+
+	if err := db.CreateInBatches(accounts, 100).Error; err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkPlacementSynchronous is the placement with fast_ack off: the order is inserted, its funds are checked
+// with the account locked and it's handed to the order processor.
+func BenchmarkPlacementSynchronous(b *testing.B) {
+	setupPlacementBenchmark(b)
+
+	benchmarkPlacements(b, func(order *Order) error {
+		if err := config.DataBase.Create(&order).Error; err != nil {
+			return err
+		}
+
+		return order.Submit()
+	})
+}
+
+// BenchmarkPlacementFastAck is the placement with fast_ack on: the id is taken from the leased block, the funds
+// are reserved in the shadow balances, the order is written to the outbox and handed to the order processor.
+func BenchmarkPlacementFastAck(b *testing.B) {
+	setupPlacementBenchmark(b)
+	store := setupRedisShadowStore(b)
+
+	redis, err := services.NewRedisClient(os.Getenv("REDIS_URL"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	config.Redis = redis
+
+	FastAckBalances = NewShadowBalances(ledgerShadow{}, store)
+	defer func() { FastAckBalances = nil }()
+
+	benchmarkPlacements(b, func(order *Order) (err error) {
+		if order.ID, err = NextOrderID(); err != nil {
+			return err
+		}
+
+		return order.Acknowledge()
+	})
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"github.com/google/uuid"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// RejectReasonInsufficientFunds is the reason of the orders the order processor rejected as the balance of their
+// member couldn't cover the funds to lock, the API accepted them before an order placed at the same time locked them.
+const RejectReasonInsufficientFunds = "insufficient_funds"
+
+// OrderRejectEvent is the order_reject event of a member, pushed when the order processor rejects an order the API
+// accepted. The order event pushed with it only has the reject state.
+type OrderRejectEvent struct {
+	UUID     uuid.UUID     `json:"uuid"`
+	ClientID uuid.NullUUID `json:"client_id"`
+	Market   string        `json:"market"`
+	Reason   string        `json:"reason"`
+}
+
+// TriggerReject pushes the order_reject event of the order to its member.
+func (o *Order) TriggerReject(reason string) {
+	config.Logger.Infof("Order %d rejected before it reached the engine, reason: %s", o.ID, reason)
+
+	member := o.Member()
+
+	config.RangoClient.EnqueueEvent("private", member.UID, "order_reject", OrderRejectEvent{
+		UUID:     o.UUID,
+		ClientID: o.ClientID,
+		Market:   o.MarketID,
+		Reason:   reason,
+	})
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/pkg"
+)
+
+var ErrReplaceNotOpen = errors.New("market.order.replace_not_open")
+
+// ReplaceOrder creates the replacement of an open order and sends both to the matching engine as one cancel-replace.
+// The replacement waits in pending until the engine accepted it, its funds are then taken from the replaced order by ApplyReplace.
+func ReplaceOrder(order, replacement *Order) error {
+	err := config.DataBase.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "orders"}}).Where("id = ?", order.ID).First(&order); result.Error != nil {
+			return result.Error
+		}
+
+		if order.State != StateWait {
+			return ErrReplaceNotOpen
+		}
+
+		var account *Account
+		tx.
+			Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "accounts"}}).
+			Where("member_id = ? AND currency_id = ?", order.MemberID, order.Currency().ID).
+			FirstOrCreate(&account)
+
+		// the funds of the replaced order are given back when the replace is applied
+		if GetAvailableBalance(tx, account, 0).Available.Add(order.Locked).LessThan(replacement.Locked) {
+			return ErrInsufficientBalance
+		}
+
+		replacement.State = StatePending
+		replacement.ReplacedOrderID = sql.NullInt64{Int64: order.ID, Valid: true}
+
+		return tx.Create(&replacement).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	config.Bus.Publish("matching", order.MarketID, map[string]interface{}{
+		"action":  events.ActionCancelReplace,
+		"key":     order.ToMatchingAttributes().Key(),
+		"order":   replacement.ToMatchingAttributes(),
+		"options": replacement.MatchingOptions(),
+	})
+
+	return nil
+}
+
+// ApplyReplace cancels the replaced order and locks the funds of its replacement in one transaction once the engine accepted
+// the cancel-replace. The order processor and the trade executor both apply it, whichever gets the replacement first.
+func ApplyReplace(id int64) error {
+	var replacement *Order
+
+	err := config.DataBase.Transaction(func(tx *gorm.DB) error {
+		orders_tx := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "orders"}})
+		if result := orders_tx.Where("id = ?", id).First(&replacement); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("can't find order by id : %d", id)
+		}
+
+		if replacement.State != StatePending || !replacement.ReplacedOrderID.Valid {
+			return nil
+		}
+
+		var order *Order
+		if result := orders_tx.Where("id = ?", replacement.ReplacedOrderID.Int64).First(&order); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("can't find order by id : %d", replacement.ReplacedOrderID.Int64)
+		}
+
+		var account *Account
+		account_tx := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "accounts"}})
+		account_tx.Where("member_id = ? AND currency_id = ?", replacement.MemberID, replacement.Currency().ID).FirstOrCreate(&account)
+
+		if order.State == StateWait {
+			if err := account.UnlockFunds(tx, order.Locked); err != nil {
+				return err
+			}
+
+			order.RecordCancelOperations()
+
+			order.State = StateCancel
+			tx.Save(order)
+
+			account_tx.Where("member_id = ? AND currency_id = ?", replacement.MemberID, replacement.Currency().ID).First(&account)
+		}
+
+		if err := account.LockFunds(tx, replacement.Locked); err != nil {
+			return err
+		}
+
+		replacement.RecordSubmitOperations()
+
+		replacement.State = StateWait
+		replacement.admitted = true
+		tx.Save(replacement)
+
+		return nil
+	})
+
+	if err != nil && replacement != nil && replacement.ReplacedOrderID.Valid {
+		// the engine already swapped the orders, neither of them can stay in the book
+		if cancel_err := CancelOrder(replacement.ReplacedOrderID.Int64); cancel_err != nil {
+			config.Logger.Errorf("Failed to cancel replaced order %d: %v", replacement.ReplacedOrderID.Int64, cancel_err)
+		}
+
+		RejectReplace(replacement.ID)
+
+		config.Bus.Publish("matching", replacement.MarketID, map[string]interface{}{
+			"action": pkg.ActionCancelWithKey,
+			"key":    replacement.ToMatchingAttributes().Key(),
+		})
+	}
+
+	return err
+}
+
+// RejectReplace rejects a replacement the engine didn't accept, the replaced order is left as it is.
+func RejectReplace(id int64) error {
+	return config.DataBase.Transaction(func(tx *gorm.DB) error {
+		var replacement *Order
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "orders"}}).Where("id = ?", id).First(&replacement); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("can't find order by id : %d", id)
+		}
+
+		if replacement.State != StatePending {
+			return nil
+		}
+
+		replacement.State = StateReject
+
+		return tx.Save(replacement).Error
+	})
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/types"
+)
+
+func TestDecrementLocked(t *testing.T) {
+	d := decimal.RequireFromString
+
+	cases := []struct {
+		name     string
+		order    Order
+		quantity string
+		unlocked string
+	}{
+		{"sell", Order{Type: SideSell, OrdType: types.TypeLimit, Volume: d("2"), Locked: d("2")}, "0.5", "0.5"},
+		{"limit buy", Order{Type: SideBuy, OrdType: types.TypeLimit, Price: decimal.NewNullDecimal(d("100")), Volume: d("2"), Locked: d("200")}, "0.5", "50"},
+		// what a fill at a better price left locked is unlocked with the rest of the order
+		{"whole limit buy", Order{Type: SideBuy, OrdType: types.TypeLimit, Price: decimal.NewNullDecimal(d("100")), Volume: d("1"), Locked: d("103")}, "1", "103"},
+		{"market buy", Order{Type: SideBuy, OrdType: types.TypeMarket, Volume: d("3"), Locked: d("100")}, "1", "33.3333333333333333"},
+	}
+
+	for _, c := range cases {
+		if unlocked := c.order.decrementLocked(d(c.quantity)); !unlocked.Equal(d(c.unlocked)) {
+			t.Errorf("%s: expected %s unlocked, got %s", c.name, c.unlocked, unlocked)
+		}
+	}
+}
+
+func TestRecordFill(t *testing.T) {
+	d := decimal.RequireFromString
+
+	order := Order{Type: SideBuy, OrdType: types.TypeLimit, OriginVolume: d("3"), Volume: d("3")}
+
+	fills := []struct{ amount, total, avg_price string }{
+		{"1", "100", "100"},
+		{"1", "103", "101.5"},
+		// 304.5 over 3, rounded to the price precision
+		{"1", "101.5", "101.5"},
+	}
+
+	for _, fill := range fills {
+		order.Volume = order.Volume.Sub(d(fill.amount))
+		order.RecordFill(d(fill.total), 2)
+
+		if !order.AvgPrice.Equal(d(fill.avg_price)) {
+			t.Errorf("expected an average price of %s, got %s", fill.avg_price, order.AvgPrice)
+		}
+	}
+
+	if !order.FundsTraded.Equal(d("304.5")) {
+		t.Errorf("expected 304.5 traded, got %s", order.FundsTraded)
+	}
+
+	// a reversal of the second fill
+	order.Volume = order.Volume.Add(d("1"))
+	order.RevertFill(d("103"), 2)
+	if !order.FundsTraded.Equal(d("201.5")) || !order.AvgPrice.Equal(d("100.75")) {
+		t.Errorf("expected 201.5 traded at 100.75, got %s at %s", order.FundsTraded, order.AvgPrice)
+	}
+
+	order.Volume = order.OriginVolume
+	order.RevertFill(d("201.5"), 2)
+	if !order.FundsTraded.IsZero() || !order.AvgPrice.IsZero() {
+		t.Errorf("expected nothing traded, got %s at %s", order.FundsTraded, order.AvgPrice)
+	}
+}
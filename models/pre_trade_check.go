@@ -0,0 +1,216 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/types"
+)
+
+// PreTradeContext is everything a pre-trade check can look at, it's loaded once before the checks run
+// so checks stay pure and don't query the database.
+type PreTradeContext struct {
+	Order  *Order
+	Member *Member
+	Market *Market
+	// Notional is the value of the order in the quote currency of its market, market sell orders
+	// are valued at the last price of the market
+	Notional decimal.Decimal
+}
+
+// PreTradeDenial is the error of a check denying an order, Code is the error code returned to the client.
+type PreTradeDenial struct {
+	Check string
+	Code  string
+}
+
+func (d *PreTradeDenial) Error() string {
+	return d.Code
+}
+
+// NewPreTradeDenial denies an order with the standardized code "market.order.pre_trade.<reason>".
+func NewPreTradeDenial(check, reason string) *PreTradeDenial {
+	return &PreTradeDenial{Check: check, Code: "market.order.pre_trade." + reason}
+}
+
+// PreTradeCheck accepts or denies an order before its funds are locked, Check returns nil to accept it
+// or a *PreTradeDenial.
+type PreTradeCheck interface {
+	Name() string
+	Check(ctx *PreTradeContext) *PreTradeDenial
+}
+
+// PreTradeCheckFactory builds a check from the params of its entry in the pre_trade_checks config.
+type PreTradeCheckFactory func(params map[string]string) (PreTradeCheck, error)
+
+// PreTradeRegistry runs its checks in the order they were registered, the first denial wins.
+type PreTradeRegistry struct {
+	sync.RWMutex
+	checks []PreTradeCheck
+}
+
+func NewPreTradeRegistry() *PreTradeRegistry {
+	return &PreTradeRegistry{checks: make([]PreTradeCheck, 0)}
+}
+
+func (r *PreTradeRegistry) Register(check PreTradeCheck) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.checks = append(r.checks, check)
+}
+
+// Reset replaces the checks of the registry.
+func (r *PreTradeRegistry) Reset(checks []PreTradeCheck) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.checks = checks
+}
+
+func (r *PreTradeRegistry) Run(ctx *PreTradeContext) *PreTradeDenial {
+	r.RLock()
+	defer r.RUnlock()
+
+	for _, check := range r.checks {
+		if denial := check.Check(ctx); denial != nil {
+			return denial
+		}
+	}
+
+	return nil
+}
+
+// PreTradeChecks are the checks run on every order placed through the API.
+var PreTradeChecks = NewPreTradeRegistry()
+
+var preTradeCheckFactories = map[string]PreTradeCheckFactory{
+	"notional_cap":       newNotionalCapCheck,
+	"restricted_members": newRestrictedMembersCheck,
+}
+
+// RegisterPreTradeCheckFactory makes a check available to the pre_trade_checks config under name.
+func RegisterPreTradeCheckFactory(name string, factory PreTradeCheckFactory) {
+	preTradeCheckFactories[name] = factory
+}
+
+// LoadPreTradeChecks registers the checks enabled by the deployment, in the order of the config.
+func LoadPreTradeChecks(configs []*types.PreTradeCheckConfig) error {
+	checks := make([]PreTradeCheck, 0, len(configs))
+
+	for _, c := range configs {
+		factory, ok := preTradeCheckFactories[c.Name]
+		if !ok {
+			return fmt.Errorf("unknown pre-trade check %s", c.Name)
+		}
+
+		check, err := factory(c.Params)
+		if err != nil {
+			return fmt.Errorf("pre-trade check %s: %w", c.Name, err)
+		}
+
+		checks = append(checks, check)
+	}
+
+	PreTradeChecks.Reset(checks)
+
+	return nil
+}
+
+// NotionalCapCheck denies orders worth more than the cap of their quote currency,
+// currencies without a cap are not limited.
+type NotionalCapCheck struct {
+	Caps map[string]decimal.Decimal
+}
+
+func newNotionalCapCheck(params map[string]string) (PreTradeCheck, error) {
+	check := &NotionalCapCheck{Caps: make(map[string]decimal.Decimal, len(params))}
+
+	for currency, value := range params {
+		limit, err := decimal.NewFromString(value)
+		if err != nil || !limit.IsPositive() {
+			return nil, fmt.Errorf("invalid cap %q for %s", value, currency)
+		}
+
+		check.Caps[strings.ToLower(currency)] = limit
+	}
+
+	return check, nil
+}
+
+func (c *NotionalCapCheck) Name() string {
+	return "notional_cap"
+}
+
+func (c *NotionalCapCheck) Check(ctx *PreTradeContext) *PreTradeDenial {
+	limit, ok := c.Caps[strings.ToLower(ctx.Market.QuoteUnit)]
+	if ok && ctx.Notional.GreaterThan(limit) {
+		return NewPreTradeDenial(c.Name(), "notional_cap_exceeded")
+	}
+
+	return nil
+}
+
+// RestrictedMembersCheck denies the orders of members which aren't active, are below MinLevel
+// or belong to one of the restricted groups.
+type RestrictedMembersCheck struct {
+	Groups   map[string]bool
+	MinLevel int32
+}
+
+func newRestrictedMembersCheck(params map[string]string) (PreTradeCheck, error) {
+	check := &RestrictedMembersCheck{Groups: make(map[string]bool)}
+
+	for _, group := range strings.Split(params["groups"], ",") {
+		if group = strings.TrimSpace(group); len(group) > 0 {
+			check.Groups[group] = true
+		}
+	}
+
+	if min_level, ok := params["min_level"]; ok {
+		level, err := strconv.ParseInt(min_level, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min_level %q", min_level)
+		}
+
+		check.MinLevel = int32(level)
+	}
+
+	return check, nil
+}
+
+func (c *RestrictedMembersCheck) Name() string {
+	return "restricted_members"
+}
+
+func (c *RestrictedMembersCheck) Check(ctx *PreTradeContext) *PreTradeDenial {
+	if ctx.Member.State != "active" || ctx.Member.Level < c.MinLevel || c.Groups[ctx.Member.Group] {
+		return NewPreTradeDenial(c.Name(), "member_restricted")
+	}
+
+	return nil
+}
+
+// NewPreTradeContext loads the context of the checks of an order, last_price values market sell orders.
+func NewPreTradeContext(order *Order, member *Member, market *Market, last_price decimal.Decimal) *PreTradeContext {
+	ctx := &PreTradeContext{
+		Order:  order,
+		Member: member,
+		Market: market,
+	}
+
+	switch {
+	case order.Type == SideBuy:
+		ctx.Notional = order.Locked
+	case order.Price.Valid:
+		ctx.Notional = order.Price.Decimal.Mul(order.Volume)
+	default:
+		ctx.Notional = last_price.Mul(order.Volume)
+	}
+
+	return ctx
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/types"
+)
+
+// tokenLevelCheck is a deployment specific check, it denies the orders of a token to members below level 3.
+type tokenLevelCheck struct {
+	token string
+}
+
+func (c *tokenLevelCheck) Name() string {
+	return "token_level"
+}
+
+func (c *tokenLevelCheck) Check(ctx *PreTradeContext) *PreTradeDenial {
+	if ctx.Market.BaseUnit == c.token && ctx.Member.Level < 3 {
+		return NewPreTradeDenial(c.Name(), "token_level_required")
+	}
+
+	return nil
+}
+
+func preTradeContext(member *Member, side OrderSide, price, volume string) *PreTradeContext {
+	d := decimal.RequireFromString
+	market := &Market{Symbol: "mytkusdt", BaseUnit: "mytk", QuoteUnit: "usdt"}
+
+	order := &Order{Type: side, Volume: d(volume), Price: decimal.NewNullDecimal(d(price))}
+	if side == SideBuy {
+		order.Locked = d(price).Mul(d(volume))
+	} else {
+		order.Locked = d(volume)
+	}
+
+	return NewPreTradeContext(order, member, market, decimal.Zero)
+}
+
+func TestPreTradeRegistry(t *testing.T) {
+	RegisterPreTradeCheckFactory("token_level", func(params map[string]string) (PreTradeCheck, error) {
+		return &tokenLevelCheck{token: params["token"]}, nil
+	})
+
+	err := LoadPreTradeChecks([]*types.PreTradeCheckConfig{
+		{Name: "restricted_members", Params: map[string]string{"groups": "restricted, frozen"}},
+		{Name: "token_level", Params: map[string]string{"token": "mytk"}},
+		{Name: "notional_cap", Params: map[string]string{"USDT": "1000"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer PreTradeChecks.Reset(nil)
+
+	tests := []struct {
+		name   string
+		member *Member
+		side   OrderSide
+		price  string
+		volume string
+		code   string
+	}{
+		{"allowed", &Member{State: "active", Level: 3, Group: "vip-1"}, SideBuy, "10", "100", ""},
+		{"restricted group", &Member{State: "active", Level: 3, Group: "frozen"}, SideBuy, "10", "1", "market.order.pre_trade.member_restricted"},
+		{"custom check", &Member{State: "active", Level: 2, Group: "vip-1"}, SideSell, "10", "1", "market.order.pre_trade.token_level_required"},
+		{"notional cap", &Member{State: "active", Level: 3, Group: "vip-1"}, SideSell, "10", "100.1", "market.order.pre_trade.notional_cap_exceeded"},
+		// checks run in order, the first denial wins
+		{"first denial", &Member{State: "pending", Level: 0, Group: "vip-1"}, SideBuy, "10", "1000", "market.order.pre_trade.member_restricted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			denial := PreTradeChecks.Run(preTradeContext(tt.member, tt.side, tt.price, tt.volume))
+
+			if len(tt.code) == 0 {
+				if denial != nil {
+					t.Errorf("got denial %s, want none", denial.Code)
+				}
+				return
+			}
+
+			if denial == nil || denial.Code != tt.code {
+				t.Errorf("got denial %v, want %s", denial, tt.code)
+			}
+		})
+	}
+}
+
+func TestLoadPreTradeChecksErrors(t *testing.T) {
+	defer PreTradeChecks.Reset(nil)
+
+	if err := LoadPreTradeChecks([]*types.PreTradeCheckConfig{{Name: "unknown"}}); err == nil {
+		t.Error("want an error for an unknown check")
+	}
+
+	if err := LoadPreTradeChecks([]*types.PreTradeCheckConfig{{Name: "notional_cap", Params: map[string]string{"usdt": "-1"}}}); err == nil {
+		t.Error("want an error for a negative cap")
+	}
+}
+
+func TestPreTradeNotional(t *testing.T) {
+	d := decimal.RequireFromString
+	market := &Market{QuoteUnit: "usdt"}
+
+	market_sell := &Order{Type: SideSell, Volume: d("2"), Locked: d("2")}
+	if ctx := NewPreTradeContext(market_sell, &Member{}, market, d("15")); !ctx.Notional.Equal(d("30")) {
+		t.Errorf("market sell notional = %s, want 30", ctx.Notional)
+	}
+
+	market_buy := &Order{Type: SideBuy, Volume: d("2"), Locked: d("31")}
+	if ctx := NewPreTradeContext(market_buy, &Member{}, market, d("15")); !ctx.Notional.Equal(d("31")) {
+		t.Errorf("market buy notional = %s, want the locked funds", ctx.Notional)
+	}
+}
@@ -0,0 +1,338 @@
+package models
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/finex/config"
+)
+
+// RateLimitBucket is a quota of requests of a member, each request counts against one bucket.
+type RateLimitBucket string
+
+var (
+	RateLimitBucketOrder      RateLimitBucket = "order"
+	RateLimitBucketCancel     RateLimitBucket = "cancel"
+	RateLimitBucketMarketData RateLimitBucket = "market_data"
+	// RateLimitBucketDefault counts the authenticated requests of no other bucket
+	RateLimitBucketDefault RateLimitBucket = "default"
+)
+
+var RateLimitBuckets = []RateLimitBucket{RateLimitBucketOrder, RateLimitBucketCancel, RateLimitBucketMarketData, RateLimitBucketDefault}
+
+// rateLimitOverridesTTL is how long a replica applies the overrides before reading them again.
+const rateLimitOverridesTTL = 30 * time.Second
+
+var (
+	ErrRateLimitBucket = errors.New("admin.rate_limit.invalid_bucket")
+	ErrRateLimitLimit  = errors.New("admin.rate_limit.invalid_limit")
+	ErrRateLimitWindow = errors.New("admin.rate_limit.invalid_window")
+)
+
+// RateLimitPolicy is the number of requests of a bucket a member can send per window.
+type RateLimitPolicy struct {
+	Limit  int
+	Window time.Duration
+}
+
+// DefaultRateLimitPolicies are the policies of the buckets missing from the rate_limits config.
+var DefaultRateLimitPolicies = map[RateLimitBucket]RateLimitPolicy{
+	RateLimitBucketOrder:      {Limit: 100, Window: 10 * time.Second},
+	RateLimitBucketCancel:     {Limit: 200, Window: 10 * time.Second},
+	RateLimitBucketMarketData: {Limit: 600, Window: time.Minute},
+	RateLimitBucketDefault:    {Limit: 1200, Window: time.Minute},
+}
+
+func ValidRateLimitBucket(bucket RateLimitBucket) bool {
+	_, ok := DefaultRateLimitPolicies[bucket]
+	return ok
+}
+
+// RateLimitPolicyOf is the policy of a bucket for every member without an override.
+func RateLimitPolicyOf(bucket RateLimitBucket) RateLimitPolicy {
+	policy := DefaultRateLimitPolicies[bucket]
+
+	if configured, ok := config.RateLimits[string(bucket)]; ok && configured != nil {
+		if configured.Limit > 0 {
+			policy.Limit = configured.Limit
+		}
+
+		if configured.Window > 0 {
+			policy.Window = configured.Window
+		}
+	}
+
+	return policy
+}
+
+// RateLimitOverride is the policy of a bucket for a member, set by an admin for the traders who need more,
+// or less, than everyone. Every replica applies it within rateLimitOverridesTTL.
+type RateLimitOverride struct {
+	ID       int64           `json:"id" gorm:"primaryKey"`
+	MemberID int64           `json:"member_id" gorm:"uniqueIndex:index_rate_limit_overrides_on_member_id_and_bucket"`
+	Bucket   RateLimitBucket `json:"bucket" gorm:"uniqueIndex:index_rate_limit_overrides_on_member_id_and_bucket"`
+	Limit    int             `json:"limit"`
+	// WindowSeconds is the window of the override, the window of the bucket when it's zero
+	WindowSeconds int64     `json:"window_seconds"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (o *RateLimitOverride) Validate() error {
+	if !ValidRateLimitBucket(o.Bucket) {
+		return ErrRateLimitBucket
+	}
+
+	if o.Limit <= 0 {
+		return ErrRateLimitLimit
+	}
+
+	if o.WindowSeconds < 0 {
+		return ErrRateLimitWindow
+	}
+
+	return nil
+}
+
+// Policy is the policy of the override over the policy of its bucket.
+func (o *RateLimitOverride) Policy() RateLimitPolicy {
+	policy := RateLimitPolicyOf(o.Bucket)
+	policy.Limit = o.Limit
+
+	if o.WindowSeconds > 0 {
+		policy.Window = time.Duration(o.WindowSeconds) * time.Second
+	}
+
+	return policy
+}
+
+type rateLimitOverrideKey struct {
+	MemberID int64
+	Bucket   RateLimitBucket
+}
+
+// RateLimitOverrideCache keeps the overrides in memory for rateLimitOverridesTTL.
+type RateLimitOverrideCache struct {
+	sync.Mutex
+	overrides map[rateLimitOverrideKey]*RateLimitOverride
+	expiresAt time.Time
+	load      func() []*RateLimitOverride
+}
+
+func loadRateLimitOverrides() []*RateLimitOverride {
+	var overrides []*RateLimitOverride
+	if result := config.DataBase.Find(&overrides); result.Error != nil {
+		config.Logger.Errorf("Failed to load the rate limit overrides: %v", result.Error)
+	}
+
+	return overrides
+}
+
+// NewRateLimitOverrideCache returns a cache of the overrides returned by load.
+func NewRateLimitOverrideCache(load func() []*RateLimitOverride) *RateLimitOverrideCache {
+	return &RateLimitOverrideCache{load: load}
+}
+
+// RateLimitOverrides are the overrides applied by the API.
+var RateLimitOverrides = NewRateLimitOverrideCache(loadRateLimitOverrides)
+
+func (c *RateLimitOverrideCache) current() map[rateLimitOverrideKey]*RateLimitOverride {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if c.overrides == nil || now.After(c.expiresAt) {
+		c.overrides = make(map[rateLimitOverrideKey]*RateLimitOverride)
+		for _, override := range c.load() {
+			c.overrides[rateLimitOverrideKey{override.MemberID, override.Bucket}] = override
+		}
+		c.expiresAt = now.Add(rateLimitOverridesTTL)
+	}
+
+	return c.overrides
+}
+
+// Invalidate reloads the overrides on the next read, other replicas reload them within rateLimitOverridesTTL.
+func (c *RateLimitOverrideCache) Invalidate() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.overrides = nil
+}
+
+// Of returns the override of a bucket for a member, nil when it has none.
+func (c *RateLimitOverrideCache) Of(member_id int64, bucket RateLimitBucket) *RateLimitOverride {
+	return c.current()[rateLimitOverrideKey{member_id, bucket}]
+}
+
+// PolicyOf is the policy of a bucket for a member, overridden reports whether an admin set it.
+func (c *RateLimitOverrideCache) PolicyOf(member_id int64, bucket RateLimitBucket) (policy RateLimitPolicy, overridden bool) {
+	if override := c.Of(member_id, bucket); override != nil {
+		return override.Policy(), true
+	}
+
+	return RateLimitPolicyOf(bucket), false
+}
+
+// SaveRateLimitOverride stores the override of a bucket for a member, it's applied by this replica right away.
+func SaveRateLimitOverride(override *RateLimitOverride) error {
+	if err := override.Validate(); err != nil {
+		return err
+	}
+
+	var existing *RateLimitOverride
+	if result := config.DataBase.Where("member_id = ? AND bucket = ?", override.MemberID, override.Bucket).Limit(1).Find(&existing); result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected > 0 {
+		override.ID = existing.ID
+		override.CreatedAt = existing.CreatedAt
+	}
+
+	if result := config.DataBase.Save(override); result.Error != nil {
+		return result.Error
+	}
+
+	RateLimitOverrides.Invalidate()
+
+	return nil
+}
+
+// DeleteRateLimitOverride puts a member back on the policy of the bucket.
+func DeleteRateLimitOverride(member_id int64, bucket RateLimitBucket) error {
+	if !ValidRateLimitBucket(bucket) {
+		return ErrRateLimitBucket
+	}
+
+	if result := config.DataBase.Where("member_id = ? AND bucket = ?", member_id, bucket).Delete(&RateLimitOverride{}); result.Error != nil {
+		return result.Error
+	}
+
+	RateLimitOverrides.Invalidate()
+
+	return nil
+}
+
+// RateLimitUsage is the state of a bucket of a member in the current window.
+type RateLimitUsage struct {
+	Bucket    RateLimitBucket
+	Limit     int
+	Window    time.Duration
+	Used      int
+	Remaining int
+	// ResetAt is the end of the window, the bucket is full again from then on
+	ResetAt    time.Time
+	Overridden bool
+}
+
+type rateLimitWindow struct {
+	policy  RateLimitPolicy
+	resetAt time.Time
+	used    int
+}
+
+// RateLimiter counts the requests of the members in fixed windows aligned on the clock. The counts are held by the
+// process, each API replica limits the requests it serves.
+type RateLimiter struct {
+	sync.Mutex
+	clock     clock.Clock
+	overrides *RateLimitOverrideCache
+	windows   map[rateLimitOverrideKey]*rateLimitWindow
+	// swept is when the windows over were last dropped
+	swept time.Time
+}
+
+func NewRateLimiter(rate_limiter_clock clock.Clock, overrides *RateLimitOverrideCache) *RateLimiter {
+	return &RateLimiter{
+		clock:     rate_limiter_clock,
+		overrides: overrides,
+		windows:   make(map[rateLimitOverrideKey]*rateLimitWindow),
+	}
+}
+
+// RateLimits is the limiter of the API.
+var RateLimits = NewRateLimiter(clock.Real, RateLimitOverrides)
+
+// window returns the current window of a bucket, a new one when the last is over or its policy changed.
+func (l *RateLimiter) window(key rateLimitOverrideKey, policy RateLimitPolicy, now time.Time) *rateLimitWindow {
+	window, ok := l.windows[key]
+	if !ok || !now.Before(window.resetAt) || window.policy != policy {
+		window = &rateLimitWindow{policy: policy, resetAt: now.Truncate(policy.Window).Add(policy.Window)}
+		l.windows[key] = window
+	}
+
+	return window
+}
+
+// sweep drops the windows over once a minute, so the members who went away don't stay in memory.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+
+	for key, window := range l.windows {
+		if !now.Before(window.resetAt) {
+			delete(l.windows, key)
+		}
+	}
+	l.swept = now
+}
+
+func (w *rateLimitWindow) usage(bucket RateLimitBucket, overridden bool) RateLimitUsage {
+	remaining := w.policy.Limit - w.used
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return RateLimitUsage{
+		Bucket:     bucket,
+		Limit:      w.policy.Limit,
+		Window:     w.policy.Window,
+		Used:       w.used,
+		Remaining:  remaining,
+		ResetAt:    w.resetAt,
+		Overridden: overridden,
+	}
+}
+
+// Take counts a request of the member against the bucket, allowed is false when the window has none left.
+// A refused request isn't counted.
+func (l *RateLimiter) Take(member_id int64, bucket RateLimitBucket) (usage RateLimitUsage, allowed bool) {
+	policy, overridden := l.overrides.PolicyOf(member_id, bucket)
+
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.clock.Now()
+	l.sweep(now)
+
+	window := l.window(rateLimitOverrideKey{member_id, bucket}, policy, now)
+	if window.used >= policy.Limit {
+		return window.usage(bucket, overridden), false
+	}
+
+	window.used++
+
+	return window.usage(bucket, overridden), true
+}
+
+// Inspect returns the usage of a bucket of the member without counting a request.
+func (l *RateLimiter) Inspect(member_id int64, bucket RateLimitBucket) RateLimitUsage {
+	policy, overridden := l.overrides.PolicyOf(member_id, bucket)
+
+	l.Lock()
+	defer l.Unlock()
+
+	return l.window(rateLimitOverrideKey{member_id, bucket}, policy, l.clock.Now()).usage(bucket, overridden)
+}
+
+// InspectAll returns the usage of every bucket of the member, in the order of RateLimitBuckets.
+func (l *RateLimiter) InspectAll(member_id int64) []RateLimitUsage {
+	usages := make([]RateLimitUsage, 0, len(RateLimitBuckets))
+	for _, bucket := range RateLimitBuckets {
+		usages = append(usages, l.Inspect(member_id, bucket))
+	}
+
+	return usages
+}
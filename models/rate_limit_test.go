@@ -0,0 +1,126 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zsmartex/finex/clock"
+)
+
+var rateLimitTestStart = time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestRateLimiter(fake *clock.Fake, overrides ...*RateLimitOverride) *RateLimiter {
+	return NewRateLimiter(fake, NewRateLimitOverrideCache(func() []*RateLimitOverride { return overrides }))
+}
+
+func TestRateLimiterWindowBoundary(t *testing.T) {
+	fake := clock.NewFake(rateLimitTestStart.Add(8 * time.Second))
+	limiter := newTestRateLimiter(fake)
+	policy := RateLimitPolicyOf(RateLimitBucketOrder)
+
+	for i := 1; i <= policy.Limit; i++ {
+		usage, allowed := limiter.Take(1, RateLimitBucketOrder)
+		if !allowed {
+			t.Fatalf("expected request %d to be allowed", i)
+		}
+
+		if usage.Remaining != policy.Limit-i {
+			t.Fatalf("expected %d requests left after %d, got %d", policy.Limit-i, i, usage.Remaining)
+		}
+	}
+
+	usage, allowed := limiter.Take(1, RateLimitBucketOrder)
+	if allowed || usage.Remaining != 0 || usage.Used != policy.Limit {
+		t.Fatalf("expected the request over the limit to be refused uncounted, got %+v allowed %v", usage, allowed)
+	}
+
+	if reset_at := rateLimitTestStart.Add(policy.Window); !usage.ResetAt.Equal(reset_at) {
+		t.Errorf("expected the window to reset at %v, got %v", reset_at, usage.ResetAt)
+	}
+
+	fake.Advance(2*time.Second - time.Nanosecond)
+	if _, allowed := limiter.Take(1, RateLimitBucketOrder); allowed {
+		t.Error("expected the last instant of the window to be refused")
+	}
+
+	fake.Advance(time.Nanosecond)
+	usage, allowed = limiter.Take(1, RateLimitBucketOrder)
+	if !allowed || usage.Remaining != policy.Limit-1 {
+		t.Fatalf("expected the next window to start full, got %+v allowed %v", usage, allowed)
+	}
+
+	if reset_at := rateLimitTestStart.Add(2 * policy.Window); !usage.ResetAt.Equal(reset_at) {
+		t.Errorf("expected the next window to reset at %v, got %v", reset_at, usage.ResetAt)
+	}
+}
+
+func TestRateLimiterBucketsAndMembers(t *testing.T) {
+	limiter := newTestRateLimiter(clock.NewFake(rateLimitTestStart))
+
+	limiter.Take(1, RateLimitBucketOrder)
+	limiter.Take(1, RateLimitBucketOrder)
+	limiter.Take(2, RateLimitBucketOrder)
+	limiter.Take(1, RateLimitBucketCancel)
+
+	usages := limiter.InspectAll(1)
+	if len(usages) != len(RateLimitBuckets) {
+		t.Fatalf("expected every bucket, got %d", len(usages))
+	}
+
+	used := map[RateLimitBucket]int{}
+	for _, usage := range usages {
+		used[usage.Bucket] = usage.Used
+	}
+
+	expected := map[RateLimitBucket]int{RateLimitBucketOrder: 2, RateLimitBucketCancel: 1, RateLimitBucketMarketData: 0, RateLimitBucketDefault: 0}
+	for bucket, count := range expected {
+		if used[bucket] != count {
+			t.Errorf("expected %d requests of %s, got %d", count, bucket, used[bucket])
+		}
+	}
+
+	if usage := limiter.Inspect(1, RateLimitBucketOrder); usage.Used != 2 {
+		t.Errorf("expected inspecting not to count a request, got %d", usage.Used)
+	}
+}
+
+func TestRateLimiterOverride(t *testing.T) {
+	limiter := newTestRateLimiter(clock.NewFake(rateLimitTestStart), &RateLimitOverride{MemberID: 1, Bucket: RateLimitBucketOrder, Limit: 2, WindowSeconds: 60})
+
+	for i := 0; i < 2; i++ {
+		if _, allowed := limiter.Take(1, RateLimitBucketOrder); !allowed {
+			t.Fatalf("expected request %d to be allowed", i)
+		}
+	}
+
+	usage, allowed := limiter.Take(1, RateLimitBucketOrder)
+	if allowed {
+		t.Error("expected the override to limit the member")
+	}
+
+	if !usage.Overridden || usage.Limit != 2 || usage.Window != time.Minute {
+		t.Errorf("expected the usage of the override, got %+v", usage)
+	}
+
+	if usage := limiter.Inspect(2, RateLimitBucketOrder); usage.Overridden || usage.Limit != RateLimitPolicyOf(RateLimitBucketOrder).Limit {
+		t.Errorf("expected other members on the policy of the bucket, got %+v", usage)
+	}
+}
+
+func TestRateLimitOverrideValidate(t *testing.T) {
+	tests := []struct {
+		override *RateLimitOverride
+		err      error
+	}{
+		{&RateLimitOverride{Bucket: RateLimitBucketCancel, Limit: 10}, nil},
+		{&RateLimitOverride{Bucket: "withdraw", Limit: 10}, ErrRateLimitBucket},
+		{&RateLimitOverride{Bucket: RateLimitBucketCancel}, ErrRateLimitLimit},
+		{&RateLimitOverride{Bucket: RateLimitBucketCancel, Limit: 10, WindowSeconds: -1}, ErrRateLimitWindow},
+	}
+
+	for _, test := range tests {
+		if err := test.override.Validate(); err != test.err {
+			t.Errorf("expected %+v to be %v, got %v", test.override, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// DefaultMaxReferralCodes is the number of referral codes a member can own when referral.max_codes isn't set.
+const DefaultMaxReferralCodes = 10
+
+// referralCodeBytes is the number of random bytes of a generated code.
+const referralCodeBytes = 4
+
+type ReferralCodeState string
+
+var (
+	ReferralCodeStateActive   ReferralCodeState = "active"
+	ReferralCodeStateDisabled ReferralCodeState = "disabled"
+)
+
+var (
+	ErrReferralCodeLimit       = errors.New("referral.code.limit_reached")
+	ErrReferralCodeInvalid     = errors.New("referral.code.invalid_code")
+	ErrReferralCodeTaken       = errors.New("referral.code.taken")
+	ErrReferralCodeFriendShare = errors.New("referral.code.invalid_friend_share")
+	ErrReferralCodeHasSignups  = errors.New("referral.code.has_signups")
+	ErrReferralCodeSubAccount  = errors.New("referral.code.sub_account")
+)
+
+var referralCodeFormat = regexp.MustCompile(`^[A-Z0-9]{4,20}$`)
+
+// ReferralCode is a named link a member shares to refer friends. Of the commission earned on the fees of a friend,
+// the referrer keeps 1 - FriendShare and the friend gets FriendShare back as a fee discount.
+type ReferralCode struct {
+	ID          int64             `json:"id" gorm:"primaryKey"`
+	MemberID    int64             `json:"member_id"`
+	Code        string            `json:"code"`
+	Label       string            `json:"label"`
+	FriendShare decimal.Decimal   `json:"friend_share" gorm:"default:0"`
+	Default     bool              `json:"default"`
+	State       ReferralCodeState `json:"state" gorm:"default:active"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// FeeDiscount is the part of a referral commission given back to the friend who paid the fee.
+type FeeDiscount struct {
+	ID             int64           `json:"id" gorm:"primaryKey"`
+	MemberID       int64           `json:"member_id"`
+	ReferralCodeID int64           `json:"referral_code_id"`
+	TradeID        int64           `json:"trade_id"`
+	CurrencyID     string          `json:"currency_id"`
+	Amount         decimal.Decimal `json:"amount"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	RoundingAudit
+}
+
+// ReferralCodeEarning is the sum of the commissions earned through a code in a currency.
+type ReferralCodeEarning struct {
+	CurrencyID string          `json:"currency_id"`
+	Amount     decimal.Decimal `json:"amount"`
+}
+
+type ReferralCodeStats struct {
+	Signups        int64                  `json:"signups"`
+	TradingFriends int64                  `json:"trading_friends"`
+	Earnings       []*ReferralCodeEarning `json:"earnings"`
+	Discounts      []*ReferralCodeEarning `json:"discounts"`
+}
+
+func (c *ReferralCode) Active() bool {
+	return c.State == ReferralCodeStateActive
+}
+
+// Split divides the commission earned on a friend fee between the referrer and the friend.
+func (c *ReferralCode) Split(commission decimal.Decimal) (referrer, friend decimal.Decimal) {
+	r, f := c.SplitRounded(Rounded{Exact: commission, Value: commission})
+
+	return r.Value, f.Value
+}
+
+// SplitRounded divides a rounded commission, the friend share is rounded from the exact commission
+// and the referrer keeps the rest of the rounded one.
+func (c *ReferralCode) SplitRounded(commission Rounded) (referrer, friend Rounded) {
+	friend = RoundHalfUp(commission.Exact.Mul(c.FriendShare), referralRewardPrecision)
+	referrer = Rounded{
+		Exact: commission.Exact.Sub(friend.Exact),
+		Value: commission.Value.Sub(friend.Value),
+		Mode:  RoundingModeHalfUp,
+	}
+
+	return referrer, friend
+}
+
+func maxReferralCodes() int {
+	if config.Referral != nil && config.Referral.MaxCodes > 0 {
+		return config.Referral.MaxCodes
+	}
+
+	return DefaultMaxReferralCodes
+}
+
+func validFriendShare(friend_share decimal.Decimal) bool {
+	return !friend_share.IsNegative() && friend_share.LessThanOrEqual(decimal.NewFromInt(1))
+}
+
+// NormalizeReferralCode returns the canonical form of a code typed by a user.
+func NormalizeReferralCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func generateReferralCode() (string, error) {
+	b := make([]byte, referralCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return strings.ToUpper(hex.EncodeToString(b)), nil
+}
+
+func (m *Member) ReferralCodes() []*ReferralCode {
+	codes := make([]*ReferralCode, 0)
+
+	config.DataBase.Order("id asc").Find(&codes, "member_id = ?", m.ID)
+
+	return codes
+}
+
+// GetReferralCode returns the code of the member, or nil when the member doesn't own it.
+func (m *Member) GetReferralCode(code string) *ReferralCode {
+	var referral_code *ReferralCode
+
+	if result := config.DataBase.First(&referral_code, "code = ? AND member_id = ?", NormalizeReferralCode(code), m.ID); result.Error != nil {
+		return nil
+	}
+
+	return referral_code
+}
+
+// CreateReferralCode creates a code for the member, a random one when code is empty.
+// The first code of a member becomes its default one.
+func (m *Member) CreateReferralCode(code, label string, friend_share decimal.Decimal, is_default bool) (*ReferralCode, error) {
+	if m.IsSubAccount() {
+		return nil, ErrReferralCodeSubAccount
+	}
+
+	if !validFriendShare(friend_share) {
+		return nil, ErrReferralCodeFriendShare
+	}
+
+	code = NormalizeReferralCode(code)
+	if len(code) == 0 {
+		generated, err := generateReferralCode()
+		if err != nil {
+			return nil, err
+		}
+
+		code = generated
+	} else if !referralCodeFormat.MatchString(code) {
+		return nil, ErrReferralCodeInvalid
+	}
+
+	referral_code := &ReferralCode{
+		MemberID:    m.ID,
+		Code:        code,
+		Label:       label,
+		FriendShare: friend_share,
+		State:       ReferralCodeStateActive,
+	}
+
+	err := config.DataBase.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		tx.Model(&ReferralCode{}).Where("member_id = ?", m.ID).Count(&count)
+		if count >= int64(maxReferralCodes()) {
+			return ErrReferralCodeLimit
+		}
+
+		var taken int64
+		tx.Model(&ReferralCode{}).Where("code = ?", code).Count(&taken)
+		if taken > 0 {
+			return ErrReferralCodeTaken
+		}
+
+		referral_code.Default = is_default || count == 0
+		if referral_code.Default {
+			if result := tx.Model(&ReferralCode{}).Where("member_id = ?", m.ID).Update("default", false); result.Error != nil {
+				return result.Error
+			}
+		}
+
+		return tx.Create(&referral_code).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return referral_code, nil
+}
+
+// Update changes the label, the split and the default flag of the code, nil values are left unchanged.
+func (c *ReferralCode) Update(label *string, friend_share *decimal.Decimal, is_default *bool) error {
+	if friend_share != nil && !validFriendShare(*friend_share) {
+		return ErrReferralCodeFriendShare
+	}
+
+	return config.DataBase.Transaction(func(tx *gorm.DB) error {
+		updates := make(map[string]interface{})
+
+		if label != nil {
+			c.Label = *label
+			updates["label"] = c.Label
+		}
+
+		if friend_share != nil {
+			c.FriendShare = *friend_share
+			updates["friend_share"] = c.FriendShare
+		}
+
+		if is_default != nil && *is_default && !c.Default {
+			if result := tx.Model(&ReferralCode{}).Where("member_id = ?", c.MemberID).Update("default", false); result.Error != nil {
+				return result.Error
+			}
+
+			c.Default = true
+			updates["default"] = true
+		}
+
+		if len(updates) == 0 {
+			return nil
+		}
+
+		return tx.Model(c).Updates(updates).Error
+	})
+}
+
+// Delete removes a code nobody signed up with, codes with signups stay for their commissions.
+func (c *ReferralCode) Delete() error {
+	var signups int64
+	config.DataBase.Model(&Member{}).Where("referral_code_id = ?", c.ID).Count(&signups)
+	if signups > 0 {
+		return ErrReferralCodeHasSignups
+	}
+
+	return config.DataBase.Delete(c).Error
+}
+
+func (c *ReferralCode) SetState(state ReferralCodeState) error {
+	c.State = state
+
+	return config.DataBase.Model(c).Update("state", state).Error
+}
+
+func (c *ReferralCode) Stats() *ReferralCodeStats {
+	stats := &ReferralCodeStats{
+		Earnings:  make([]*ReferralCodeEarning, 0),
+		Discounts: make([]*ReferralCodeEarning, 0),
+	}
+
+	config.DataBase.Model(&Member{}).Where("referral_code_id = ?", c.ID).Count(&stats.Signups)
+
+	config.DataBase.
+		Model(&Commission{}).
+		Where("referral_code_id = ?", c.ID).
+		Distinct("friend_uid").
+		Count(&stats.TradingFriends)
+
+	activeCommissions(config.DataBase).
+		Select("commissions.currency_id, "+commissionEarned+" AS amount").
+		Where("commissions.referral_code_id = ?", c.ID).
+		Group("commissions.currency_id").
+		Order("commissions.currency_id asc").
+		Scan(&stats.Earnings)
+
+	config.DataBase.
+		Model(&FeeDiscount{}).
+		Select("currency_id, SUM(amount) AS amount").
+		Where("referral_code_id = ?", c.ID).
+		Group("currency_id").
+		Order("currency_id asc").
+		Scan(&stats.Discounts)
+
+	return stats
+}
+
+// ReferralCode returns the code the member signed up with, or nil when it didn't use one.
+// Sub-accounts are attributed to the code of their parent.
+func (m *Member) ReferralCode() *ReferralCode {
+	if parent := m.Parent(); parent != nil {
+		return parent.ReferralCode()
+	}
+
+	if !m.ReferralCodeID.Valid {
+		return nil
+	}
+
+	var referral_code *ReferralCode
+	if result := config.DataBase.First(&referral_code, m.ReferralCodeID.Int64); result.Error != nil {
+		return nil
+	}
+
+	return referral_code
+}
+
+// AttributeReferralCode records the code a member signed up with, members referred by UID only
+// are attributed to the default code of their referrer when code is empty. Attribution happens once.
+func (m *Member) AttributeReferralCode(code string) {
+	if m.ReferralCodeID.Valid || m.IsSubAccount() {
+		return
+	}
+
+	var referral_code *ReferralCode
+
+	if code = NormalizeReferralCode(code); len(code) > 0 {
+		if result := config.DataBase.First(&referral_code, "code = ? AND state = ?", code, ReferralCodeStateActive); result.Error != nil {
+			return
+		}
+	} else if m.ReferralUID.Valid {
+		var referrer *Member
+		if result := config.DataBase.First(&referrer, "uid = ?", m.ReferralUID.String); result.Error != nil {
+			return
+		}
+
+		if result := config.DataBase.First(&referral_code, "member_id = ? AND \"default\" = ? AND state = ?", referrer.ID, true, ReferralCodeStateActive); result.Error != nil {
+			return
+		}
+	} else {
+		return
+	}
+
+	if referral_code.MemberID == m.ID {
+		return
+	}
+
+	var referrer *Member
+	if result := config.DataBase.First(&referrer, referral_code.MemberID); result.Error != nil {
+		return
+	}
+
+	if m.ReferralUID.Valid && m.ReferralUID.String != referrer.UID {
+		return
+	}
+
+	m.ReferralCodeID = sql.NullInt64{Int64: referral_code.ID, Valid: true}
+	m.ReferralUID = sql.NullString{String: referrer.UID, Valid: true}
+
+	if result := config.DataBase.Model(m).Updates(map[string]interface{}{
+		"referral_code_id": m.ReferralCodeID,
+		"referral_uid":     m.ReferralUID,
+	}); result.Error != nil {
+		config.Logger.Errorf("Failed to attribute referral code %s to member %d: %v", referral_code.Code, m.ID, result.Error)
+	}
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestReferralCodeSplit(t *testing.T) {
+	d := decimal.RequireFromString
+
+	tests := []struct {
+		friend_share string
+		commission   string
+		referrer     string
+		friend       string
+	}{
+		{"0", "1.5", "1.5", "0"},
+		{"0.3", "1.5", "1.05", "0.45"},
+		{"1", "1.5", "0", "1.5"},
+		{"0.333", "0.00000001", "0.00000001", "0"},
+	}
+
+	for _, tt := range tests {
+		code := &ReferralCode{FriendShare: d(tt.friend_share)}
+		referrer, friend := code.Split(d(tt.commission))
+
+		if !referrer.Equal(d(tt.referrer)) || !friend.Equal(d(tt.friend)) {
+			t.Errorf("Split(%s) with a friend share of %s = (%s, %s), want (%s, %s)", tt.commission, tt.friend_share, referrer, friend, tt.referrer, tt.friend)
+		}
+
+		if !referrer.Add(friend).Equal(d(tt.commission)) {
+			t.Errorf("Split(%s) doesn't add up", tt.commission)
+		}
+	}
+}
+
+func TestReferralCodeFormat(t *testing.T) {
+	code, err := generateReferralCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !referralCodeFormat.MatchString(code) {
+		t.Errorf("generated code %s doesn't match the code format", code)
+	}
+
+	if NormalizeReferralCode(" summer22 ") != "SUMMER22" {
+		t.Errorf("unexpected normalized code %s", NormalizeReferralCode(" summer22 "))
+	}
+
+	for _, invalid := range []string{"ABC", "SUMMER-22", "ÉTÉ2022"} {
+		if referralCodeFormat.MatchString(invalid) {
+			t.Errorf("expected %s to be rejected", invalid)
+		}
+	}
+
+	if validFriendShare(decimal.NewFromFloat(1.1)) || validFriendShare(decimal.NewFromFloat(-0.1)) {
+		t.Error("expected friend shares outside of [0, 1] to be rejected")
+	}
+}
@@ -1,19 +1,162 @@
 package models
 
 import (
+	"errors"
 	"time"
 
 	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/decimalutil"
 	"github.com/zsmartex/finex/types"
 )
 
+type ReleaseCommissionKind string
+
+var (
+	ReleaseCommissionKindRelease ReleaseCommissionKind = "release"
+	// ReleaseCommissionKindAdjustment takes back released commissions of reverted trades, its EarnedBTC is negative
+	ReleaseCommissionKindAdjustment ReleaseCommissionKind = "adjustment"
+	// ReleaseCommissionKindKickback releases the fee kickbacks of a referred friend
+	ReleaseCommissionKindKickback ReleaseCommissionKind = "kickback"
+	// ReleaseCommissionKindKickbackAdjustment takes back released kickbacks of reverted trades, its EarnedBTC is negative
+	ReleaseCommissionKindKickbackAdjustment ReleaseCommissionKind = "kickback_adjustment"
+)
+
 type ReleaseCommission struct {
 	ID          int64
 	AccountType types.AccountType
 	MemberID    int64
+	Kind        ReleaseCommissionKind `gorm:"default:release"`
 	EarnedBTC   decimal.Decimal
 	FriendTrade int64
 	Friend      int64
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
+
+var ErrCommissionBackfillRange = errors.New("commission.backfill.invalid_range")
+
+// CommissionReleaseAt is when the release job releases the commissions earned on day, at the following midnight.
+func CommissionReleaseAt(day time.Time) time.Time {
+	return pendingReleasesSince(day).AddDate(0, 0, 1)
+}
+
+// settlementValue values an amount of currency_id in CommissionSettlementCurrency at the stored prices.
+func settlementValue(currency_id string, amount decimal.Decimal, prices []*MarketPrice) (decimal.Decimal, error) {
+	rate, err := ConversionRate(currency_id, CommissionSettlementCurrency, ConversionBridge(), prices)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return amount.Mul(rate), nil
+}
+
+// EarnedBTC values commissions in BTC at the stored prices of their currencies, rounded down like a payout.
+func EarnedBTC(commissions []*Commission, prices []*MarketPrice) (decimal.Decimal, error) {
+	earned := decimal.Zero
+
+	for _, commission := range commissions {
+		value, err := settlementValue(commission.CurrencyID, commission.EarnAmount, prices)
+		if err != nil {
+			return decimal.Zero, err
+		}
+
+		earned = earned.Add(value)
+	}
+
+	return decimalutil.RoundPayout(earned, 8), nil
+}
+
+// PendingCommissionReleases returns the unsaved releases of the commissions earned on day by the members whose
+// commissions of that day weren't released yet. The releases are dated at CommissionReleaseAt, like the release job
+// dates them, so statements count them on the same day whenever they're created.
+func PendingCommissionReleases(tx *gorm.DB, day time.Time, prices []*MarketPrice) ([]*ReleaseCommission, error) {
+	from := pendingReleasesSince(day)
+	release_at := CommissionReleaseAt(day)
+
+	var groups []struct {
+		MemberID    int64
+		FriendTrade int64
+	}
+
+	// a release of the commissions of a day has traded friends, the releases of signups only don't
+	released := tx.
+		Model(&ReleaseCommission{}).
+		Select("member_id").
+		Where("kind = ? AND friend_trade > 0 AND created_at >= ? AND created_at < ?", ReleaseCommissionKindRelease, release_at, release_at.AddDate(0, 0, 1))
+
+	if result := tx.
+		Model(&Commission{}).
+		Select("member_id, COUNT(DISTINCT friend_uid) AS friend_trade").
+		Where("state = ? AND created_at >= ? AND created_at < ?", CommissionStateActive, from, release_at).
+		Where("member_id NOT IN (?)", released).
+		Group("member_id").
+		Order("member_id").
+		Scan(&groups); result.Error != nil {
+		return nil, result.Error
+	}
+
+	releases := make([]*ReleaseCommission, 0, len(groups))
+	for _, group := range groups {
+		var commissions []*Commission
+		if result := tx.Where("member_id = ? AND state = ? AND created_at >= ? AND created_at < ?", group.MemberID, CommissionStateActive, from, release_at).Find(&commissions); result.Error != nil {
+			return nil, result.Error
+		}
+
+		// a member whose commissions can't be valued is released by a later run once the prices are there
+		earned_btc, err := EarnedBTC(commissions, prices)
+		if err != nil {
+			config.Logger.Errorf("Failed to release the commissions of member %d: %v", group.MemberID, err)
+			continue
+		}
+
+		releases = append(releases, &ReleaseCommission{
+			AccountType: types.AccountTypeSpot,
+			MemberID:    group.MemberID,
+			Kind:        ReleaseCommissionKindRelease,
+			EarnedBTC:   earned_btc,
+			FriendTrade: group.FriendTrade,
+			CreatedAt:   release_at,
+		})
+	}
+
+	return releases, nil
+}
+
+// BackfillCommissionReleases releases the commissions of the days from from to to, both included, which the release
+// job missed. Days which aren't over at now are left to the job. With dry_run the releases are returned unsaved.
+func BackfillCommissionReleases(tx *gorm.DB, from, to, now time.Time, dry_run bool) ([]*ReleaseCommission, error) {
+	from = pendingReleasesSince(from)
+	to = pendingReleasesSince(to)
+	if to.Before(from) {
+		return nil, ErrCommissionBackfillRange
+	}
+
+	prices := StoredCurrencyPrices()
+
+	backfilled := make([]*ReleaseCommission, 0)
+	for day := from; !day.After(to) && !CommissionReleaseAt(day).After(now); day = day.AddDate(0, 0, 1) {
+		releases, err := PendingCommissionReleases(tx, day, prices)
+		if err != nil {
+			return backfilled, err
+		}
+
+		kickbacks, err := PendingKickbackReleases(tx, day, prices)
+		if err != nil {
+			return backfilled, err
+		}
+		releases = append(releases, kickbacks...)
+
+		if !dry_run && len(releases) > 0 {
+			if result := tx.Create(&releases); result.Error != nil {
+				return backfilled, result.Error
+			}
+		}
+
+		backfilled = append(backfilled, releases...)
+	}
+
+	return backfilled, nil
+}
@@ -0,0 +1,204 @@
+package models
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/zsmartex/finex/config"
+)
+
+type ReportKind string
+
+var (
+	ReportKindCommissions ReportKind = "commissions"
+)
+
+type ReportJobState string
+
+var (
+	ReportJobStatePending ReportJobState = "pending"
+	ReportJobStateRunning ReportJobState = "running"
+	ReportJobStateDone    ReportJobState = "done"
+	ReportJobStateFailed  ReportJobState = "failed"
+)
+
+var (
+	ErrReportJobNotFound = errors.New("record.not_found")
+	ErrReportStorage     = errors.New("admin.report.storage_not_configured")
+)
+
+// ReportJob is a report generated by the report generator daemon, for reports too large to be served in one request.
+type ReportJob struct {
+	ID         int64          `json:"id" gorm:"primaryKey"`
+	UUID       uuid.UUID      `json:"uuid"`
+	Kind       ReportKind     `json:"kind"`
+	Params     string         `json:"params"`
+	State      ReportJobState `json:"state" gorm:"default:pending"`
+	Location   string         `json:"location"`
+	Error      string         `json:"error"`
+	FinishedAt sql.NullTime   `json:"finished_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+type CommissionReportParams struct {
+	Year int `json:"year"`
+}
+
+func EnqueueReportJob(kind ReportKind, params interface{}) (*ReportJob, error) {
+	if len(config.Reports.StoragePath) == 0 {
+		return nil, ErrReportStorage
+	}
+
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &ReportJob{
+		UUID:   uuid.New(),
+		Kind:   kind,
+		Params: string(encoded),
+		State:  ReportJobStatePending,
+	}
+
+	if result := config.DataBase.Create(&job); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return job, nil
+}
+
+func GetReportJob(id uuid.UUID) (*ReportJob, error) {
+	var job *ReportJob
+	if result := config.DataBase.First(&job, "uuid = ?", id); result.Error != nil {
+		return nil, ErrReportJobNotFound
+	}
+
+	return job, nil
+}
+
+// Claim moves a pending job to running, it reports false when another generator took it first.
+func (j *ReportJob) Claim() bool {
+	result := config.DataBase.
+		Model(&ReportJob{}).
+		Where("id = ? AND state = ?", j.ID, ReportJobStatePending).
+		Update("state", ReportJobStateRunning)
+
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+
+	j.State = ReportJobStateRunning
+
+	return true
+}
+
+// FileName is the name of the file of the report in the report bucket.
+func (j *ReportJob) FileName() string {
+	if j.Kind == ReportKindMemberExport {
+		return fmt.Sprintf("%s-%s.zip.enc", j.Kind, j.UUID)
+	}
+
+	return fmt.Sprintf("%s-%s.csv", j.Kind, j.UUID)
+}
+
+// Generate writes the report to the report bucket and records where it is, or why it failed.
+func (j *ReportJob) Generate(now time.Time) error {
+	location, err := j.write(now)
+
+	updates := map[string]interface{}{
+		"finished_at": sql.NullTime{Time: time.Now(), Valid: true},
+	}
+
+	if err != nil {
+		updates["state"] = ReportJobStateFailed
+		updates["error"] = err.Error()
+	} else {
+		updates["state"] = ReportJobStateDone
+		updates["location"] = location
+	}
+
+	if result := config.DataBase.Model(j).Updates(updates); result.Error != nil {
+		return result.Error
+	}
+
+	if err == nil && j.Kind == ReportKindMemberExport {
+		if member, err := j.MemberExportMember(); err == nil {
+			RecordAuditEvent(member.ID, "", "member_export.fulfilled", j.UUID.String())
+		}
+	}
+
+	return err
+}
+
+func (j *ReportJob) write(now time.Time) (string, error) {
+	return writeStorageFile(j.FileName(), func(w io.Writer) error {
+		switch j.Kind {
+		case ReportKindCommissions:
+			var params CommissionReportParams
+			if err := json.Unmarshal([]byte(j.Params), &params); err != nil {
+				return err
+			}
+
+			writer := bufio.NewWriter(w)
+			if err := WriteCommissionStatement(config.DataBase, writer, params.Year, now, config.Reports.ChunkSize, writer.Flush); err != nil {
+				return err
+			}
+
+			return writer.Flush()
+		case ReportKindMemberExport:
+			return j.writeMemberExport(w)
+		default:
+			return fmt.Errorf("unknown report kind: %s", j.Kind)
+		}
+	})
+}
+
+// storagePath is where the file name is in the report bucket.
+func storagePath(name string) (string, error) {
+	if len(config.Reports.StoragePath) == 0 {
+		return "", ErrReportStorage
+	}
+
+	return filepath.Join(config.Reports.StoragePath, filepath.FromSlash(name)), nil
+}
+
+// writeStorageFile writes a file of the report bucket with write and returns where it is.
+func writeStorageFile(name string, write func(w io.Writer) error) (string, error) {
+	location, err := storagePath(name)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(location), 0755); err != nil {
+		return "", err
+	}
+
+	// the file is written next to its final name so it's never seen half written
+	file, err := os.Create(location + ".part")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if err := write(file); err != nil {
+		os.Remove(location + ".part")
+		return "", err
+	}
+
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	return location, os.Rename(location+".part", location)
+}
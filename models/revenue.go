@@ -4,9 +4,30 @@ import (
 	"time"
 
 	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
 	"github.com/zsmartex/finex/config"
 )
 
+// RevenueKind is what a revenue operation books, the fee of a trade or a part of it paid back.
+type RevenueKind string
+
+var (
+	// RevenueKindFee is the fee of a trade, in full
+	RevenueKindFee RevenueKind = "fee"
+	// RevenueKindReferralCommission is the part of a fee earned by the referrer of the member who paid it
+	RevenueKindReferralCommission RevenueKind = "referral_commission"
+	// RevenueKindRebate is the part of a fee given back to the member who paid it with its referral code
+	RevenueKindRebate RevenueKind = "rebate"
+	// RevenueKindKickback is the part of a fee given back to a referred member during its first days
+	RevenueKindKickback RevenueKind = "kickback"
+	// RevenueKindConvert is what the convert desk kept or lacked on a conversion
+	RevenueKindConvert RevenueKind = "convert"
+)
+
+// FeeRevenueKinds are the kinds of the operations of the fees of the trades, the revenue report nets them.
+var FeeRevenueKinds = []RevenueKind{RevenueKindFee, RevenueKindReferralCommission, RevenueKindRebate, RevenueKindKickback}
+
 type Revenue struct {
 	ID            int64           `json:"id"`
 	Code          int32           `json:"code"`
@@ -14,15 +35,24 @@ type Revenue struct {
 	MemberID      int64           `json:"member_id"`
 	ReferenceType string          `json:"reference_type"`
 	ReferenceID   int64           `json:"reference_id"`
+	Kind          RevenueKind     `json:"kind" gorm:"index"`
+	MarketID      string          `json:"market_id" gorm:"index"`
 	Debit         decimal.Decimal `json:"debit"`
 	Credit        decimal.Decimal `json:"credit"`
 	CreatedAt     time.Time       `json:"created_at"`
 	UpdatedAt     time.Time       `json:"updated_at"`
+	RoundingAudit
 }
 
+// GetRevenueCode returns the code of the revenue account of the currency, the account designated to the currency
+// when it has one, else the account of its currency type.
 func GetRevenueCode(currency *Currency) int32 {
 	var operations_account OperationsAccount
-	config.DataBase.Where("type = ? AND currency_type = ?", TypeRevenue, currency.Type).Find(&operations_account)
+	if result := config.DataBase.Where("type = ? AND currency_id = ?", TypeRevenue, currency.ID).Limit(1).Find(&operations_account); result.RowsAffected > 0 {
+		return operations_account.Code
+	}
+
+	config.DataBase.Where("type = ? AND currency_type = ? AND (currency_id IS NULL OR currency_id = '')", TypeRevenue, currency.Type).Find(&operations_account)
 
 	return operations_account.Code
 }
@@ -35,6 +65,7 @@ func RevenueCredit(amount decimal.Decimal, currency *Currency, reference Referen
 		CurrencyID:    currency.ID,
 		ReferenceType: reference.Type,
 		ReferenceID:   reference.ID,
+		Kind:          revenueKindOf(reference),
 		Credit:        amount,
 		MemberID:      member_id,
 	}
@@ -42,6 +73,40 @@ func RevenueCredit(amount decimal.Decimal, currency *Currency, reference Referen
 	config.DataBase.Create(&revenue)
 }
 
+// RevenueFeeCredit credits the fee a member paid on a trade with the exact fee it was rounded from, the parts
+// of it paid back are debited from the same account by RevenueFeeDebit.
+func RevenueFeeCredit(fee Rounded, currency *Currency, trade *Trade, member_id int64) {
+	revenue := Revenue{
+		Code:          GetRevenueCode(currency),
+		CurrencyID:    currency.ID,
+		ReferenceType: "Trade",
+		ReferenceID:   trade.ID,
+		Kind:          RevenueKindFee,
+		MarketID:      trade.MarketID,
+		Credit:        fee.Value,
+		MemberID:      member_id,
+		RoundingAudit: NewRoundingAudit(fee, RoundingPathTradeFee),
+	}
+
+	config.DataBase.Create(&revenue)
+}
+
+// RevenueFeeDebit debits the part of the fee a member paid on a trade which was paid back, kind tells to whom.
+func RevenueFeeDebit(tx *gorm.DB, kind RevenueKind, amount decimal.Decimal, currency *Currency, trade *Trade, member_id int64) error {
+	revenue := Revenue{
+		Code:          GetRevenueCode(currency),
+		CurrencyID:    currency.ID,
+		ReferenceType: "Trade",
+		ReferenceID:   trade.ID,
+		Kind:          kind,
+		MarketID:      trade.MarketID,
+		Debit:         amount,
+		MemberID:      member_id,
+	}
+
+	return tx.Create(&revenue).Error
+}
+
 func RevenueDebit(amount decimal.Decimal, currency *Currency, reference Reference, member_id int64) {
 	code := GetRevenueCode(currency)
 
@@ -50,6 +115,7 @@ func RevenueDebit(amount decimal.Decimal, currency *Currency, reference Referenc
 		CurrencyID:    currency.ID,
 		ReferenceType: reference.Type,
 		ReferenceID:   reference.ID,
+		Kind:          revenueKindOf(reference),
 		Debit:         amount,
 		MemberID:      member_id,
 	}
@@ -61,3 +127,12 @@ func RevenueTranfer(amount decimal.Decimal, currency *Currency, reference Refere
 	RevenueCredit(amount, currency, reference, member_id)
 	RevenueDebit(amount, currency, reference, member_id)
 }
+
+// revenueKindOf returns the kind of the revenue operations of a reference, empty when it isn't a known one.
+func revenueKindOf(reference Reference) RevenueKind {
+	if reference.Type == "ConvertQuote" {
+		return RevenueKindConvert
+	}
+
+	return ""
+}
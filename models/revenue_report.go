@@ -0,0 +1,344 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/decimalutil"
+)
+
+var ErrRevenueReportPeriod = errors.New("admin.report.invalid_period")
+
+// RevenueReportPeriods are the periods the revenue report is grouped by.
+var RevenueReportPeriods = []string{"day", "month"}
+
+// revenueUSDTPrecision is the scale the USDT values of the revenue report are rounded to.
+const revenueUSDTPrecision int32 = 8
+
+// FeeRevenue is the fee revenue booked in a currency on a market over a UTC day, the fees credited and the parts
+// of them paid back debited.
+type FeeRevenue struct {
+	Day                 time.Time       `json:"day"`
+	CurrencyID          string          `json:"currency_id"`
+	MarketID            string          `json:"market_id"`
+	Fees                decimal.Decimal `json:"fees"`
+	ReferralCommissions decimal.Decimal `json:"referral_commissions"`
+	Rebates             decimal.Decimal `json:"rebates"`
+	Kickbacks           decimal.Decimal `json:"kickbacks"`
+}
+
+// Net is the fees less the referral commissions, the rebates and the kickbacks paid from them.
+func (r *FeeRevenue) Net() decimal.Decimal {
+	return r.Fees.Sub(r.ReferralCommissions).Sub(r.Rebates).Sub(r.Kickbacks)
+}
+
+func (r *FeeRevenue) add(other *FeeRevenue) {
+	r.Fees = r.Fees.Add(other.Fees)
+	r.ReferralCommissions = r.ReferralCommissions.Add(other.ReferralCommissions)
+	r.Rebates = r.Rebates.Add(other.Rebates)
+	r.Kickbacks = r.Kickbacks.Add(other.Kickbacks)
+}
+
+func (r *FeeRevenue) equal(other *FeeRevenue) bool {
+	return r.Fees.Equal(other.Fees) && r.ReferralCommissions.Equal(other.ReferralCommissions) &&
+		r.Rebates.Equal(other.Rebates) && r.Kickbacks.Equal(other.Kickbacks)
+}
+
+type feeRevenueKey struct {
+	Day        time.Time
+	CurrencyID string
+	MarketID   string
+}
+
+func (r *FeeRevenue) key() feeRevenueKey {
+	return feeRevenueKey{Day: r.Day.UTC(), CurrencyID: r.CurrencyID, MarketID: r.MarketID}
+}
+
+// RevenueDailyStat is the fee revenue of a day as the revenue stats job found it once the day ended. It's written
+// once, the revenue report flags the days whose operations don't add up to it anymore.
+type RevenueDailyStat struct {
+	ID                  int64           `json:"id" gorm:"primaryKey"`
+	Day                 time.Time       `json:"day" gorm:"uniqueIndex:index_revenue_daily_stats_on_day_currency_market"`
+	CurrencyID          string          `json:"currency_id" gorm:"uniqueIndex:index_revenue_daily_stats_on_day_currency_market"`
+	MarketID            string          `json:"market_id" gorm:"uniqueIndex:index_revenue_daily_stats_on_day_currency_market"`
+	Fees                decimal.Decimal `json:"fees" gorm:"type:decimal(36,18);default:0"`
+	ReferralCommissions decimal.Decimal `json:"referral_commissions" gorm:"type:decimal(36,18);default:0"`
+	Rebates             decimal.Decimal `json:"rebates" gorm:"type:decimal(36,18);default:0"`
+	Kickbacks           decimal.Decimal `json:"kickbacks" gorm:"type:decimal(36,18);default:0"`
+	CreatedAt           time.Time       `json:"created_at"`
+}
+
+func (s *RevenueDailyStat) revenue() *FeeRevenue {
+	return &FeeRevenue{
+		Day:                 s.Day,
+		CurrencyID:          s.CurrencyID,
+		MarketID:            s.MarketID,
+		Fees:                s.Fees,
+		ReferralCommissions: s.ReferralCommissions,
+		Rebates:             s.Rebates,
+		Kickbacks:           s.Kickbacks,
+	}
+}
+
+// feeRevenueAmount is the amount booked of a kind of fee revenue operation.
+type feeRevenueAmount struct {
+	Day        time.Time
+	CurrencyID string
+	MarketID   string
+	Kind       RevenueKind
+	Amount     decimal.Decimal
+}
+
+// foldFeeRevenues groups the amounts by day, currency and market, in this order. Amounts without a kind are
+// fees booked before the kinds, net of the referral rewards paid from them.
+func foldFeeRevenues(amounts []*feeRevenueAmount) []*FeeRevenue {
+	revenues := make(map[feeRevenueKey]*FeeRevenue)
+
+	for _, amount := range amounts {
+		key := feeRevenueKey{Day: amount.Day.UTC(), CurrencyID: amount.CurrencyID, MarketID: amount.MarketID}
+
+		revenue := revenues[key]
+		if revenue == nil {
+			revenue = &FeeRevenue{Day: key.Day, CurrencyID: key.CurrencyID, MarketID: key.MarketID}
+			revenues[key] = revenue
+		}
+
+		// the parts of the fees paid back are debits, they're summed positive
+		switch amount.Kind {
+		case RevenueKindFee, "":
+			revenue.Fees = revenue.Fees.Add(amount.Amount)
+		case RevenueKindReferralCommission:
+			revenue.ReferralCommissions = revenue.ReferralCommissions.Sub(amount.Amount)
+		case RevenueKindRebate:
+			revenue.Rebates = revenue.Rebates.Sub(amount.Amount)
+		case RevenueKindKickback:
+			revenue.Kickbacks = revenue.Kickbacks.Sub(amount.Amount)
+		}
+	}
+
+	folded := make([]*FeeRevenue, 0, len(revenues))
+	for _, revenue := range revenues {
+		folded = append(folded, revenue)
+	}
+
+	sort.Slice(folded, func(i, j int) bool {
+		a, b := folded[i], folded[j]
+		switch {
+		case !a.Day.Equal(b.Day):
+			return a.Day.Before(b.Day)
+		case a.CurrencyID != b.CurrencyID:
+			return a.CurrencyID < b.CurrencyID
+		default:
+			return a.MarketID < b.MarketID
+		}
+	})
+
+	return folded
+}
+
+// FeeRevenues sums the fee revenue operations booked between from and to per UTC day, currency and market. The
+// operations of the trades booked before they had a market take the market of their trade.
+func FeeRevenues(tx *gorm.DB, from, to time.Time) ([]*FeeRevenue, error) {
+	var amounts []*feeRevenueAmount
+
+	if result := tx.Table("revenues").
+		Select("DATE_TRUNC('day', revenues.created_at AT TIME ZONE 'UTC') AS day, revenues.currency_id, COALESCE(NULLIF(revenues.market_id, ''), trades.market_id, '') AS market_id, COALESCE(revenues.kind, '') AS kind, SUM(revenues.credit - revenues.debit) AS amount").
+		Joins("LEFT JOIN trades ON revenues.reference_type = 'Trade' AND trades.id = revenues.reference_id").
+		Where("revenues.created_at >= ? AND revenues.created_at < ?", from, to).
+		Where("revenues.kind IN ? OR (COALESCE(revenues.kind, '') = '' AND revenues.reference_type IN ?)", FeeRevenueKinds, []string{"Trade", "TradeReversal"}).
+		Group("day, revenues.currency_id, COALESCE(NULLIF(revenues.market_id, ''), trades.market_id, ''), COALESCE(revenues.kind, '')").
+		Scan(&amounts); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return foldFeeRevenues(amounts), nil
+}
+
+// RollupRevenueStats writes the fee revenue of the UTC day starting at day, a day rolled up again is left as it was.
+func RollupRevenueStats(tx *gorm.DB, day time.Time) error {
+	day = day.UTC().Truncate(24 * time.Hour)
+
+	revenues, err := FeeRevenues(tx, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+
+	for _, revenue := range revenues {
+		stat := &RevenueDailyStat{
+			Day:                 revenue.Day,
+			CurrencyID:          revenue.CurrencyID,
+			MarketID:            revenue.MarketID,
+			Fees:                revenue.Fees,
+			ReferralCommissions: revenue.ReferralCommissions,
+			Rebates:             revenue.Rebates,
+			Kickbacks:           revenue.Kickbacks,
+		}
+		if result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(stat); result.Error != nil {
+			return result.Error
+		}
+	}
+
+	return nil
+}
+
+// RevenuePeriodStart returns the start of the UTC day or month of at.
+func RevenuePeriodStart(at time.Time, period string) time.Time {
+	at = at.UTC()
+	if period == "month" {
+		return time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	return at.Truncate(24 * time.Hour)
+}
+
+// RevenuePeriodEnd returns the end of the UTC day or month starting at start.
+func RevenuePeriodEnd(start time.Time, period string) time.Time {
+	if period == "month" {
+		return start.AddDate(0, 1, 0)
+	}
+
+	return start.AddDate(0, 0, 1)
+}
+
+// RevenueReportRow is the fee revenue of a currency on a market over a period, valued in USDT at the prices of
+// the end of the period, and reconciled against the daily stats of the days of the period which were rolled up.
+type RevenueReportRow struct {
+	Period time.Time
+	FeeRevenue
+	Net decimal.Decimal
+	// USDTRate is the USDT value of the currency at the end of the period, NetUSDT the net revenue at it.
+	// Both are null when the currency had no price then.
+	USDTRate decimal.NullDecimal
+	NetUSDT  decimal.NullDecimal
+	// StatsNet is the net revenue of the daily stats of the period
+	StatsNet decimal.Decimal
+	// Reconciled is set when every day of the row was rolled up, Discrepancy when one of them doesn't match its stats
+	Reconciled  bool
+	Discrepancy bool
+}
+
+// BuildRevenueReport groups the daily revenues and stats by period, currency and market. A rolled up day is one
+// with stats, its revenues without stats or stats without revenues are discrepancies. rate values a currency in
+// USDT at the end of a period.
+func BuildRevenueReport(revenues []*FeeRevenue, stats []*RevenueDailyStat, period string, rate func(currency string, end time.Time) (decimal.Decimal, bool)) []*RevenueReportRow {
+	rolled_days := make(map[time.Time]bool)
+	daily_stats := make(map[feeRevenueKey]*FeeRevenue)
+	for _, stat := range stats {
+		revenue := stat.revenue()
+		rolled_days[revenue.Day.UTC()] = true
+		daily_stats[revenue.key()] = revenue
+	}
+
+	daily_revenues := make(map[feeRevenueKey]*FeeRevenue)
+	for _, revenue := range revenues {
+		daily_revenues[revenue.key()] = revenue
+	}
+
+	rows := make(map[feeRevenueKey]*RevenueReportRow)
+	row := func(day feeRevenueKey) *RevenueReportRow {
+		key := feeRevenueKey{Day: RevenuePeriodStart(day.Day, period), CurrencyID: day.CurrencyID, MarketID: day.MarketID}
+		if rows[key] == nil {
+			rows[key] = &RevenueReportRow{
+				Period:     key.Day,
+				FeeRevenue: FeeRevenue{Day: key.Day, CurrencyID: key.CurrencyID, MarketID: key.MarketID},
+				Reconciled: true,
+			}
+		}
+
+		return rows[key]
+	}
+
+	for key, revenue := range daily_revenues {
+		r := row(key)
+		r.add(revenue)
+
+		stat, found := daily_stats[key]
+		switch {
+		case !rolled_days[key.Day]:
+			r.Reconciled = false
+		case !found || !stat.equal(revenue):
+			r.Discrepancy = true
+		}
+	}
+
+	for key, stat := range daily_stats {
+		r := row(key)
+		r.StatsNet = r.StatsNet.Add(stat.Net())
+
+		if _, found := daily_revenues[key]; !found && !stat.Net().IsZero() {
+			r.Discrepancy = true
+		}
+	}
+
+	report := make([]*RevenueReportRow, 0, len(rows))
+	for _, r := range rows {
+		r.Net = r.FeeRevenue.Net()
+
+		if usdt_rate, ok := rate(r.CurrencyID, RevenuePeriodEnd(r.Period, period)); ok {
+			r.USDTRate = decimal.NewNullDecimal(usdt_rate)
+			r.NetUSDT = decimal.NewNullDecimal(decimalutil.Round(r.Net.Mul(usdt_rate), revenueUSDTPrecision, decimalutil.HalfUp))
+		}
+
+		report = append(report, r)
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		switch {
+		case !a.Period.Equal(b.Period):
+			return a.Period.Before(b.Period)
+		case a.CurrencyID != b.CurrencyID:
+			return a.CurrencyID < b.CurrencyID
+		default:
+			return a.MarketID < b.MarketID
+		}
+	})
+
+	return report
+}
+
+// RevenueReport reports the fee revenue between the periods of from and to. A period not over yet is valued at
+// the prices of now, the others at the prices of their end.
+func RevenueReport(tx *gorm.DB, period string, from, to, now time.Time, prices_at func(at time.Time) []*MarketPrice) ([]*RevenueReportRow, error) {
+	valid := false
+	for _, p := range RevenueReportPeriods {
+		valid = valid || p == period
+	}
+
+	if !valid {
+		return nil, ErrRevenueReportPeriod
+	}
+
+	from = RevenuePeriodStart(from, period)
+	to = RevenuePeriodEnd(RevenuePeriodStart(to, period), period)
+
+	revenues, err := FeeRevenues(tx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []*RevenueDailyStat
+	if result := tx.Where("day >= ? AND day < ?", from, to).Find(&stats); result.Error != nil {
+		return nil, result.Error
+	}
+
+	prices := make(map[time.Time][]*MarketPrice)
+	rate := func(currency string, end time.Time) (decimal.Decimal, bool) {
+		if end.After(now) {
+			end = now
+		}
+
+		if _, found := prices[end]; !found {
+			prices[end] = prices_at(end)
+		}
+
+		return USDTRate(currency, prices[end])
+	}
+
+	return BuildRevenueReport(revenues, stats, period, rate), nil
+}
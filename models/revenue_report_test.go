@@ -0,0 +1,133 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestFoldFeeRevenues(t *testing.T) {
+	d := decimal.RequireFromString
+	day := time.Date(2022, 5, 10, 0, 0, 0, 0, time.UTC)
+
+	revenues := foldFeeRevenues([]*feeRevenueAmount{
+		{Day: day, CurrencyID: "usdt", MarketID: "btcusdt", Kind: RevenueKindFee, Amount: d("10")},
+		{Day: day, CurrencyID: "usdt", MarketID: "btcusdt", Kind: RevenueKindReferralCommission, Amount: d("-2")},
+		{Day: day, CurrencyID: "usdt", MarketID: "btcusdt", Kind: RevenueKindRebate, Amount: d("-1")},
+		{Day: day, CurrencyID: "usdt", MarketID: "btcusdt", Kind: RevenueKindKickback, Amount: d("-0.5")},
+		// booked before the kinds, net of the rewards
+		{Day: day, CurrencyID: "usdt", MarketID: "btcusdt", Amount: d("3")},
+		{Day: day, CurrencyID: "btc", MarketID: "btcusdt", Kind: RevenueKindFee, Amount: d("0.001")},
+	})
+
+	if len(revenues) != 2 || revenues[0].CurrencyID != "btc" {
+		t.Fatalf("expected the revenues of btc then usdt, got %+v", revenues)
+	}
+
+	usdt := revenues[1]
+	if !usdt.Fees.Equal(d("13")) || !usdt.ReferralCommissions.Equal(d("2")) || !usdt.Rebates.Equal(d("1")) || !usdt.Kickbacks.Equal(d("0.5")) {
+		t.Errorf("unexpected usdt revenue %+v", usdt)
+	}
+
+	if !usdt.Net().Equal(d("9.5")) {
+		t.Errorf("expected a net revenue of 9.5, got %s", usdt.Net())
+	}
+}
+
+func TestBuildRevenueReport(t *testing.T) {
+	d := decimal.RequireFromString
+	may := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	june := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	revenue := func(day time.Time, currency, fees string) *FeeRevenue {
+		return &FeeRevenue{Day: day, CurrencyID: currency, MarketID: "btcusdt", Fees: d(fees), Kickbacks: d("1")}
+	}
+
+	stat := func(day time.Time, currency, fees string) *RevenueDailyStat {
+		return &RevenueDailyStat{Day: day, CurrencyID: currency, MarketID: "btcusdt", Fees: d(fees), Kickbacks: d("1")}
+	}
+
+	revenues := []*FeeRevenue{
+		revenue(may, "usdt", "10"),
+		revenue(may.AddDate(0, 0, 1), "usdt", "20"),
+		revenue(may, "btc", "3"),
+		// booked after its day was rolled up
+		revenue(june, "usdt", "7"),
+		// not rolled up yet
+		revenue(june.AddDate(0, 0, 1), "btc", "2"),
+	}
+
+	stats := []*RevenueDailyStat{
+		stat(may, "usdt", "10"),
+		stat(may.AddDate(0, 0, 1), "usdt", "20"),
+		stat(may, "btc", "3"),
+		stat(june, "usdt", "5"),
+	}
+
+	rates := map[time.Time]decimal.Decimal{june: d("30000"), june.AddDate(0, 1, 0): d("20000")}
+	rate := func(currency string, end time.Time) (decimal.Decimal, bool) {
+		if currency == "usdt" {
+			return decimal.NewFromInt(1), true
+		}
+
+		rate, found := rates[end]
+		return rate, found
+	}
+
+	report := BuildRevenueReport(revenues, stats, "month", rate)
+	if len(report) != 4 {
+		t.Fatalf("expected a row per month and currency, got %d", len(report))
+	}
+
+	may_btc, may_usdt, june_btc, june_usdt := report[0], report[1], report[2], report[3]
+
+	if !may_usdt.Period.Equal(may) || !may_usdt.Net.Equal(d("28")) || !may_usdt.StatsNet.Equal(d("28")) || !may_usdt.Reconciled || may_usdt.Discrepancy {
+		t.Errorf("unexpected reconciled usdt row of may %+v", may_usdt)
+	}
+
+	// valued at the price of the end of its month
+	if !may_btc.NetUSDT.Valid || !may_btc.NetUSDT.Decimal.Equal(d("60000")) {
+		t.Errorf("expected the btc of may valued at 30000, got %+v", may_btc.NetUSDT)
+	}
+
+	if !june_usdt.Discrepancy || !june_usdt.StatsNet.Equal(d("4")) || !june_usdt.Net.Equal(d("6")) {
+		t.Errorf("expected the usdt row of june to be flagged, got %+v", june_usdt)
+	}
+
+	if june_btc.Reconciled || june_btc.Discrepancy || !june_btc.NetUSDT.Decimal.Equal(d("20000")) {
+		t.Errorf("expected the btc row of june not to be reconciled yet, got %+v", june_btc)
+	}
+
+	// a day whose stats lost their operations is a discrepancy too
+	report = BuildRevenueReport(nil, stats[:1], "day", rate)
+	if len(report) != 1 || !report[0].Discrepancy {
+		t.Errorf("expected the stats without operations to be flagged, got %+v", report)
+	}
+}
+
+func TestMarketPricesAt(t *testing.T) {
+	at := time.Date(2022, 5, 31, 0, 0, 0, 0, time.UTC)
+	markets := []*Market{
+		{Symbol: "btcusdt", BaseUnit: "btc", QuoteUnit: "usdt"},
+		{Symbol: "ethbtc", BaseUnit: "eth", QuoteUnit: "btc"},
+		{Symbol: "newusdt", BaseUnit: "new", QuoteUnit: "usdt"},
+	}
+
+	closes := map[string]decimal.Decimal{"btcusdt": decimal.NewFromInt(30000), "ethbtc": decimal.RequireFromString("0.05")}
+	prices := MarketPricesAt(markets, at, func(market string, before time.Time) decimal.Decimal {
+		if !before.Equal(at) {
+			t.Errorf("expected the closes before %s, got %s", at, before)
+		}
+
+		return closes[market]
+	})
+
+	if len(prices) != 2 {
+		t.Fatalf("expected the market without trades to be left out, got %d prices", len(prices))
+	}
+
+	if rate, ok := USDTRate("eth", prices); !ok || !rate.Equal(decimal.NewFromInt(1500)) {
+		t.Errorf("expected eth at 1500 through btc, got %s", rate)
+	}
+}
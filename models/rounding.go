@@ -0,0 +1,161 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/decimalutil"
+)
+
+// RoundingMode is how an exact value was rounded to the value booked.
+type RoundingMode string
+
+var (
+	// RoundingModeHalfUp rounds halves away from zero
+	RoundingModeHalfUp = RoundingMode(decimalutil.HalfUp)
+)
+
+// RoundingPath is the code path which rounded a booked value, the rounding drift report is grouped by it.
+type RoundingPath string
+
+var (
+	// RoundingPathTradeFee is the fee of a trade booked as revenue, the referral rewards paid from it are debited
+	RoundingPathTradeFee RoundingPath = "trade.fee"
+	// RoundingPathReferralCommission is the part of a referral reward earned by the referrer
+	RoundingPathReferralCommission RoundingPath = "trade.referral_commission"
+	// RoundingPathReferralDiscount is the part of a referral reward given back to the friend
+	RoundingPathReferralDiscount RoundingPath = "trade.referral_discount"
+	// RoundingPathReferralKickback is the part of its own fee given back to a referred friend
+	RoundingPathReferralKickback RoundingPath = "trade.referral_kickback"
+)
+
+// referralRewardPrecision is the scale referral rewards and their split are rounded to.
+const referralRewardPrecision int32 = 8
+
+// DefaultRoundingDriftThreshold is the drift of a currency, day and path the report flags when
+// rounding_drift_threshold isn't set.
+var DefaultRoundingDriftThreshold = decimal.New(1, -8)
+
+// Rounded is a value booked from an exact one, the exact value is carried through the fee pipeline
+// so each step computes from it instead of from the rounded result of the previous step.
+type Rounded struct {
+	Exact decimal.Decimal
+	Value decimal.Decimal
+	Mode  RoundingMode
+}
+
+// RoundHalfUp rounds exact to places with RoundingModeHalfUp.
+func RoundHalfUp(exact decimal.Decimal, places int32) Rounded {
+	return Rounded{Exact: exact, Value: decimalutil.Round(exact, places, decimalutil.HalfUp), Mode: RoundingModeHalfUp}
+}
+
+// Drift is the booked value minus the exact one.
+func (r Rounded) Drift() decimal.Decimal {
+	return r.Value.Sub(r.Exact)
+}
+
+// Less takes an amount booked elsewhere from both values, so the drift of that amount stays with the row it's booked on.
+func (r Rounded) Less(amount decimal.Decimal) Rounded {
+	return Rounded{Exact: r.Exact.Sub(amount), Value: r.Value.Sub(amount), Mode: r.Mode}
+}
+
+// RoundingAudit are the columns of a row booked from a rounded value, the row amount is the rounded value.
+type RoundingAudit struct {
+	// RoundingExact is the value before rounding, null on rows booked before the audit
+	RoundingExact decimal.NullDecimal `json:"rounding_exact"`
+	RoundingMode  RoundingMode        `json:"rounding_mode"`
+	RoundingPath  RoundingPath        `json:"rounding_path"`
+}
+
+func NewRoundingAudit(r Rounded, path RoundingPath) RoundingAudit {
+	return RoundingAudit{
+		RoundingExact: decimal.NullDecimal{Decimal: r.Exact, Valid: true},
+		RoundingMode:  r.Mode,
+		RoundingPath:  path,
+	}
+}
+
+// RoundingDrift is the total drift of the rows of a path in a currency over a day.
+type RoundingDrift struct {
+	CurrencyID string          `json:"currency_id"`
+	Day        time.Time       `json:"day"`
+	Path       RoundingPath    `json:"path"`
+	Rows       int64           `json:"rows"`
+	Drift      decimal.Decimal `json:"drift"`
+	// Flagged is set when the absolute drift exceeds the threshold
+	Flagged bool `json:"flagged"`
+}
+
+func roundingDriftThreshold() decimal.Decimal {
+	if config.RoundingDriftThreshold.IsPositive() {
+		return config.RoundingDriftThreshold
+	}
+
+	return DefaultRoundingDriftThreshold
+}
+
+// roundingDriftSources are the tables audited for rounding, with their booked amount.
+var roundingDriftSources = []struct {
+	table  string
+	amount string
+}{
+	{"revenues", "credit"},
+	{"commissions", "earn_amount"},
+	{"fee_discounts", "amount"},
+}
+
+// FlagRoundingDrift flags the drifts above threshold, or the configured threshold when it's zero,
+// and sorts them by day, currency and path.
+func FlagRoundingDrift(drifts []*RoundingDrift, threshold decimal.Decimal) []*RoundingDrift {
+	if !threshold.IsPositive() {
+		threshold = roundingDriftThreshold()
+	}
+
+	for _, drift := range drifts {
+		drift.Flagged = drift.Drift.Abs().GreaterThan(threshold)
+	}
+
+	sort.SliceStable(drifts, func(i, j int) bool {
+		a, b := drifts[i], drifts[j]
+		switch {
+		case !a.Day.Equal(b.Day):
+			return a.Day.Before(b.Day)
+		case a.CurrencyID != b.CurrencyID:
+			return a.CurrencyID < b.CurrencyID
+		default:
+			return a.Path < b.Path
+		}
+	})
+
+	return drifts
+}
+
+// RoundingDriftReport sums the drift of the audited rows per currency, UTC day and path between from and to.
+func RoundingDriftReport(tx *gorm.DB, from, to time.Time, currency_id string, threshold decimal.Decimal) ([]*RoundingDrift, error) {
+	drifts := make([]*RoundingDrift, 0)
+
+	for _, source := range roundingDriftSources {
+		var rows []*RoundingDrift
+
+		query := tx.Table(source.table).
+			Select("currency_id, DATE_TRUNC('day', created_at AT TIME ZONE 'UTC') AS day, rounding_path AS path, COUNT(*) AS rows, SUM("+source.amount+" - rounding_exact) AS drift").
+			Where("rounding_exact IS NOT NULL AND created_at >= ? AND created_at < ?", from, to).
+			Group("currency_id, day, rounding_path")
+
+		if len(currency_id) > 0 {
+			query = query.Where("currency_id = ?", currency_id)
+		}
+
+		if result := query.Scan(&rows); result.Error != nil {
+			return nil, result.Error
+		}
+
+		drifts = append(drifts, rows...)
+	}
+
+	return FlagRoundingDrift(drifts, threshold), nil
+}
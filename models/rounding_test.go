@@ -0,0 +1,94 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestTradeFeeRounding(t *testing.T) {
+	d := decimal.RequireFromString
+
+	trade := &Trade{
+		Amount: d("0.123456789"), Total: d("1.2345678901234567"),
+		MakerOrderID: 1, TakerOrderID: 2,
+	}
+
+	seller := &Order{ID: 1, Type: SideSell, MakerFee: d("0.001")}
+	fee := trade.Fee(seller)
+
+	if !fee.Exact.Equal(d("0.0012345678901234567")) || !fee.Value.Equal(d("0.0012345678901235")) || fee.Mode != RoundingModeHalfUp {
+		t.Fatalf("unexpected seller fee %+v", fee)
+	}
+
+	if !fee.Drift().Equal(d("0.0000000000000000433")) {
+		t.Errorf("unexpected drift %s", fee.Drift())
+	}
+
+	buyer := &Order{ID: 2, Type: SideBuy, TakerFee: d("0.002")}
+	if fee := trade.Fee(buyer); !fee.Value.Equal(d("0.000246913578")) || !fee.Drift().IsZero() {
+		t.Errorf("expected the buyer fee on the amount without drift, got %+v", fee)
+	}
+}
+
+func TestReferralRoundingAttribution(t *testing.T) {
+	d := decimal.RequireFromString
+
+	fee := Rounded{Exact: d("0.0000001234"), Value: d("0.0000001234"), Mode: RoundingModeHalfUp}
+
+	reward := RoundHalfUp(fee.Exact.Mul(d("0.3")), referralRewardPrecision)
+	code := &ReferralCode{FriendShare: d("0.5")}
+	commission, discount := code.SplitRounded(reward)
+
+	if !reward.Value.Equal(d("0.00000004")) || !discount.Value.Equal(d("0.00000002")) || !commission.Value.Equal(d("0.00000002")) {
+		t.Fatalf("unexpected split %s %s of %s", commission.Value, discount.Value, reward.Value)
+	}
+
+	// the friend share is rounded from the exact reward, not from the rounded one
+	if !discount.Exact.Equal(d("0.00000001851")) || !commission.Exact.Equal(d("0.00000001851")) {
+		t.Errorf("unexpected exact split %s %s", commission.Exact, discount.Exact)
+	}
+
+	if !commission.Drift().Add(discount.Drift()).Equal(reward.Drift()) {
+		t.Errorf("expected the drift of the split to add up to the drift of the reward")
+	}
+
+	// the revenue keeps the drift of the fee only, the reward drift is booked on the commission and the discount
+	revenue := fee.Less(reward.Value)
+	if !revenue.Drift().Equal(fee.Drift()) || !revenue.Value.Add(reward.Value).Equal(fee.Value) {
+		t.Errorf("unexpected revenue %+v", revenue)
+	}
+}
+
+func TestFlagRoundingDrift(t *testing.T) {
+	d := decimal.RequireFromString
+
+	day := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	drifts := FlagRoundingDrift([]*RoundingDrift{
+		{CurrencyID: "usdt", Day: day.AddDate(0, 0, 1), Path: RoundingPathTradeFee, Drift: d("0.00000002")},
+		{CurrencyID: "usdt", Day: day, Path: RoundingPathTradeFee, Drift: d("0.00000001")},
+		{CurrencyID: "btc", Day: day, Path: RoundingPathReferralCommission, Drift: d("-0.0000005")},
+	}, decimal.Zero)
+
+	want := []struct {
+		currency string
+		day      time.Time
+		flagged  bool
+	}{
+		{"btc", day, true},
+		{"usdt", day, false},
+		{"usdt", day.AddDate(0, 0, 1), true},
+	}
+
+	for i, w := range want {
+		if drifts[i].CurrencyID != w.currency || !drifts[i].Day.Equal(w.day) || drifts[i].Flagged != w.flagged {
+			t.Errorf("drift %d = %+v, want %+v", i, drifts[i], w)
+		}
+	}
+
+	if drifts := FlagRoundingDrift(drifts, d("0.000001")); drifts[0].Flagged || drifts[2].Flagged {
+		t.Error("expected a higher threshold to flag nothing")
+	}
+}
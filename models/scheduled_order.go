@@ -0,0 +1,332 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/decimalutil"
+	"github.com/zsmartex/finex/types"
+)
+
+const (
+	// DefaultScheduledOrdersGraceWindow is how late a scheduled order is placed when scheduled_orders.grace_window isn't set.
+	DefaultScheduledOrdersGraceWindow = time.Minute
+	// DefaultScheduledOrdersMaxPending is the number of scheduled orders of a member when scheduled_orders.max_pending isn't set.
+	DefaultScheduledOrdersMaxPending = 20
+	// DefaultScheduledOrdersMaxAhead is how far ahead an order is scheduled when scheduled_orders.max_ahead isn't set.
+	DefaultScheduledOrdersMaxAhead = 30 * 24 * time.Hour
+)
+
+type ScheduledOrderState string
+
+var (
+	ScheduledOrderStateScheduled ScheduledOrderState = "scheduled"
+	// ScheduledOrderStateExecuted is a scheduled order whose order was placed, the order has its own state
+	ScheduledOrderStateExecuted  ScheduledOrderState = "executed"
+	ScheduledOrderStateRejected  ScheduledOrderState = "rejected"
+	ScheduledOrderStateExpired   ScheduledOrderState = "expired"
+	ScheduledOrderStateCancelled ScheduledOrderState = "cancelled"
+)
+
+var (
+	ErrScheduledOrderExecuteAt    = errors.New("scheduled_order.invalid_execute_at")
+	ErrScheduledOrderTooMany      = errors.New("scheduled_order.too_many")
+	ErrScheduledOrderNotScheduled = errors.New("scheduled_order.not_scheduled")
+	// ErrScheduledOrderNotLockable refuses the market bids when the funds are locked on schedule, their funds aren't known before they're placed
+	ErrScheduledOrderNotLockable  = errors.New("scheduled_order.market_bid_not_lockable")
+	ErrScheduledOrderMarketHalted = errors.New("scheduled_order.market_halted")
+	ErrScheduledOrderMarketClosed = errors.New("scheduled_order.market_closed")
+)
+
+// ScheduledOrder is an order a member asked to place at ExecuteAt, the scheduled order scheduler places it.
+// With scheduled_orders.lock_on_schedule the funds of the order are locked from when it's scheduled.
+type ScheduledOrder struct {
+	ID        int64               `json:"id" gorm:"primaryKey"`
+	UUID      uuid.UUID           `json:"uuid" gorm:"default:gen_random_uuid()"`
+	MemberID  int64               `json:"member_id" gorm:"index"`
+	MarketID  string              `json:"market_id"`
+	Side      types.OrderSide     `json:"side"`
+	OrdType   types.OrderType     `json:"ord_type"`
+	Price     decimal.NullDecimal `json:"price"`
+	StopPrice decimal.NullDecimal `json:"stop_price"`
+	Quantity  decimal.Decimal     `json:"quantity"`
+	PostOnly  bool                `json:"post_only" gorm:"default:false"`
+	ExecuteAt time.Time           `json:"execute_at" gorm:"index"`
+	State     ScheduledOrderState `json:"state" gorm:"default:scheduled;index"`
+	// CurrencyID is the currency the order spends, Locked the funds locked in it until the order is placed
+	CurrencyID string          `json:"currency_id"`
+	Locked     decimal.Decimal `json:"locked" gorm:"default:0"`
+	// OrderUUID is the order placed at the execution
+	OrderUUID uuid.NullUUID `json:"order_uuid"`
+	// Reason is why the order couldn't be placed
+	Reason     sql.NullString `json:"reason"`
+	ExecutedAt sql.NullTime   `json:"executed_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+func ScheduledOrdersGraceWindow() time.Duration {
+	if config.ScheduledOrders.GraceWindow > 0 {
+		return config.ScheduledOrders.GraceWindow
+	}
+
+	return DefaultScheduledOrdersGraceWindow
+}
+
+func scheduledOrdersMaxPending() int64 {
+	if config.ScheduledOrders.MaxPending > 0 {
+		return int64(config.ScheduledOrders.MaxPending)
+	}
+
+	return DefaultScheduledOrdersMaxPending
+}
+
+func scheduledOrdersMaxAhead() time.Duration {
+	if config.ScheduledOrders.MaxAhead > 0 {
+		return config.ScheduledOrders.MaxAhead
+	}
+
+	return DefaultScheduledOrdersMaxAhead
+}
+
+// NewScheduledOrder builds the order of a member to place on market at execute_at, the funds it locks on
+// schedule are the funds the order would lock.
+func NewScheduledOrder(member *Member, market *Market, side types.OrderSide, ord_type types.OrderType, price, stop_price decimal.NullDecimal, quantity decimal.Decimal, post_only bool, execute_at, now time.Time) (*ScheduledOrder, error) {
+	if !execute_at.After(now) || execute_at.After(now.Add(scheduledOrdersMaxAhead())) {
+		return nil, ErrScheduledOrderExecuteAt
+	}
+
+	scheduled_order := &ScheduledOrder{
+		MemberID:   member.ID,
+		MarketID:   market.Symbol,
+		Side:       side,
+		OrdType:    ord_type,
+		Price:      price,
+		StopPrice:  stop_price,
+		Quantity:   quantity,
+		PostOnly:   post_only,
+		ExecuteAt:  execute_at,
+		State:      ScheduledOrderStateScheduled,
+		CurrencyID: market.BaseUnit,
+		Locked:     decimal.Zero,
+	}
+
+	if side == types.SideBuy {
+		scheduled_order.CurrencyID = market.QuoteUnit
+	}
+
+	if !config.ScheduledOrders.LockOnSchedule {
+		return scheduled_order, nil
+	}
+
+	switch {
+	case side == types.SideSell:
+		scheduled_order.Locked = quantity
+	case ord_type == types.TypeLimit:
+		scheduled_order.Locked = decimalutil.RoundLocked(price.Decimal.Mul(quantity), SchemaDecimalScale)
+	default:
+		return nil, ErrScheduledOrderNotLockable
+	}
+
+	return scheduled_order, nil
+}
+
+// ScheduledOrderDue is what the scheduler does with a scheduled order.
+type ScheduledOrderDue int
+
+const (
+	ScheduledOrderWait ScheduledOrderDue = iota
+	ScheduledOrderExecute
+	// ScheduledOrderExpire is a scheduled order missed by more than the grace window, the scheduler was down
+	ScheduledOrderExpire
+)
+
+// Due tells what to do with the scheduled order at now, it's placed from ExecuteAt to ExecuteAt plus grace.
+func (s *ScheduledOrder) Due(now time.Time, grace time.Duration) ScheduledOrderDue {
+	switch {
+	case now.Before(s.ExecuteAt):
+		return ScheduledOrderWait
+	case now.After(s.ExecuteAt.Add(grace)):
+		return ScheduledOrderExpire
+	default:
+		return ScheduledOrderExecute
+	}
+}
+
+// MarketRejection returns why the order can't be placed on market now, nil when it can.
+func (s *ScheduledOrder) MarketRejection(market *Market) error {
+	switch market.EngineTradingState() {
+	case types.TradingStateHalted:
+		return ErrScheduledOrderMarketHalted
+	case types.TradingStateCancelOnly:
+		return ErrScheduledOrderMarketClosed
+	}
+
+	switch types.MarketState(market.State) {
+	case types.MarketStateEndabled:
+		return nil
+	case types.MarketStateHalted:
+		return ErrScheduledOrderMarketHalted
+	default:
+		return ErrScheduledOrderMarketClosed
+	}
+}
+
+func (s *ScheduledOrder) reference() Reference {
+	return Reference{ID: s.ID, Type: "ScheduledOrder"}
+}
+
+func (s *ScheduledOrder) currency() *Currency {
+	var currency *Currency
+	config.DataBase.First(&currency, "id = ?", s.CurrencyID)
+
+	return currency
+}
+
+func lockScheduledOrderAccount(tx *gorm.DB, s *ScheduledOrder) (*Account, *gorm.DB) {
+	var account *Account
+
+	account_tx := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "accounts"}})
+	account_tx.Where("member_id = ? AND currency_id = ?", s.MemberID, s.CurrencyID).FirstOrCreate(&account)
+
+	return account, account_tx
+}
+
+// CreateScheduledOrder records a scheduled order and locks its funds, within the limit of scheduled orders of a member.
+func CreateScheduledOrder(s *ScheduledOrder) error {
+	return config.DataBase.Transaction(func(tx *gorm.DB) error {
+		var pending int64
+		tx.Model(&ScheduledOrder{}).Where("member_id = ? AND state = ?", s.MemberID, ScheduledOrderStateScheduled).Count(&pending)
+		if pending >= scheduledOrdersMaxPending() {
+			return ErrScheduledOrderTooMany
+		}
+
+		if !s.Locked.IsPositive() {
+			return tx.Create(s).Error
+		}
+
+		account, account_tx := lockScheduledOrderAccount(tx, s)
+		if GetAvailableBalance(tx, account, 0).Available.LessThan(s.Locked) {
+			return ErrInsufficientBalance
+		}
+
+		if err := tx.Create(s).Error; err != nil {
+			return err
+		}
+
+		if err := account.LockFunds(account_tx, s.Locked); err != nil {
+			return err
+		}
+
+		LiabilityTranfer(s.Locked, s.currency(), s.reference(), "main", "locked", s.MemberID)
+
+		return nil
+	})
+}
+
+// takeScheduledOrder locks the row of a scheduled order which hasn't run yet in tx and unlocks its funds.
+func takeScheduledOrder(tx *gorm.DB, s *ScheduledOrder) error {
+	if result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(s, s.ID); result.Error != nil {
+		return result.Error
+	}
+
+	if s.State != ScheduledOrderStateScheduled {
+		return ErrScheduledOrderNotScheduled
+	}
+
+	if !s.Locked.IsPositive() {
+		return nil
+	}
+
+	account, account_tx := lockScheduledOrderAccount(tx, s)
+	if err := account.UnlockFunds(account_tx, s.Locked); err != nil {
+		return err
+	}
+
+	LiabilityTranfer(s.Locked, s.currency(), s.reference(), "locked", "main", s.MemberID)
+
+	return nil
+}
+
+// CloseScheduledOrder cancels, expires or rejects a scheduled order for reason and unlocks its funds.
+func CloseScheduledOrder(s *ScheduledOrder, state ScheduledOrderState, reason string) error {
+	return config.DataBase.Transaction(func(tx *gorm.DB) error {
+		if err := takeScheduledOrder(tx, s); err != nil {
+			return err
+		}
+
+		s.State = state
+		if len(reason) > 0 {
+			s.Reason = sql.NullString{String: reason, Valid: true}
+		}
+
+		return tx.Save(s).Error
+	})
+}
+
+// ExecuteScheduledOrder inserts the order of a scheduled order and submits it. The funds locked on schedule are
+// unlocked in the transaction inserting the order pending, the available balance holds them back from then on.
+// The scheduled order is rejected with the reason when the order is.
+func ExecuteScheduledOrder(s *ScheduledOrder, order *Order, now time.Time) error {
+	err := config.DataBase.Transaction(func(tx *gorm.DB) error {
+		if err := takeScheduledOrder(tx, s); err != nil {
+			return err
+		}
+
+		if err := tx.Create(order).Error; err != nil {
+			return err
+		}
+
+		s.State = ScheduledOrderStateExecuted
+		s.OrderUUID = uuid.NullUUID{UUID: order.UUID, Valid: true}
+		s.ExecutedAt = sql.NullTime{Time: now, Valid: true}
+
+		return tx.Save(s).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := order.Submit(); err != nil {
+		s.State = ScheduledOrderStateRejected
+		s.Reason = sql.NullString{String: err.Error(), Valid: true}
+
+		return config.DataBase.Save(s).Error
+	}
+
+	return nil
+}
+
+func (s *ScheduledOrder) ToJSON() entities.ScheduledOrderEntity {
+	var executed_at *time.Time
+	if s.ExecutedAt.Valid {
+		executed_at = &s.ExecutedAt.Time
+	}
+
+	return entities.ScheduledOrderEntity{
+		UUID:       s.UUID,
+		Market:     s.MarketID,
+		Side:       s.Side,
+		OrdType:    s.OrdType,
+		Price:      s.Price,
+		StopPrice:  s.StopPrice,
+		Quantity:   s.Quantity,
+		PostOnly:   s.PostOnly,
+		ExecuteAt:  s.ExecuteAt,
+		State:      string(s.State),
+		Locked:     s.Locked,
+		OrderUUID:  s.OrderUUID,
+		Reason:     s.Reason.String,
+		ExecutedAt: executed_at,
+		CreatedAt:  s.CreatedAt,
+		UpdatedAt:  s.UpdatedAt,
+	}
+}
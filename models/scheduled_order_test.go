@@ -0,0 +1,109 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+func TestScheduledOrderDue(t *testing.T) {
+	execute_at := time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+	scheduled_order := &ScheduledOrder{ExecuteAt: execute_at}
+	grace := time.Minute
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want ScheduledOrderDue
+	}{
+		{"a nanosecond early", execute_at.Add(-time.Nanosecond), ScheduledOrderWait},
+		{"on time", execute_at, ScheduledOrderExecute},
+		{"late within the grace window", execute_at.Add(30 * time.Second), ScheduledOrderExecute},
+		{"at the end of the grace window", execute_at.Add(grace), ScheduledOrderExecute},
+		{"missed", execute_at.Add(grace + time.Nanosecond), ScheduledOrderExpire},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scheduled_order.Due(tt.now, grace); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduledOrderMarketRejection(t *testing.T) {
+	scheduled_order := &ScheduledOrder{}
+
+	tests := []struct {
+		state types.MarketState
+		want  error
+	}{
+		{types.MarketStateEndabled, nil},
+		{types.MarketStateHalted, ErrScheduledOrderMarketHalted},
+		{types.MarketStateDisabled, ErrScheduledOrderMarketClosed},
+	}
+
+	for _, tt := range tests {
+		if got := scheduled_order.MarketRejection(&Market{State: string(tt.state)}); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestNewScheduledOrder(t *testing.T) {
+	scheduled_orders := config.ScheduledOrders
+	t.Cleanup(func() { config.ScheduledOrders = scheduled_orders })
+
+	now := time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+	member := &Member{ID: 1}
+	market := &Market{Symbol: "btcusdt", BaseUnit: "btc", QuoteUnit: "usdt"}
+	price := decimal.NewNullDecimal(decimal.RequireFromString("100.5"))
+	quantity := decimal.RequireFromString("2")
+
+	config.ScheduledOrders = &types.ScheduledOrdersConfig{MaxAhead: time.Hour}
+
+	for _, execute_at := range []time.Time{now, now.Add(-time.Second), now.Add(time.Hour + time.Second)} {
+		if _, err := NewScheduledOrder(member, market, types.SideBuy, types.TypeLimit, price, decimal.NullDecimal{}, quantity, false, execute_at, now); err != ErrScheduledOrderExecuteAt {
+			t.Errorf("expected an order at %v to be refused, got %v", execute_at, err)
+		}
+	}
+
+	// the funds are locked when the order is placed
+	scheduled_order, err := NewScheduledOrder(member, market, types.SideBuy, types.TypeLimit, price, decimal.NullDecimal{}, quantity, false, now.Add(time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if scheduled_order.CurrencyID != "usdt" || !scheduled_order.Locked.IsZero() {
+		t.Errorf("expected nothing locked in usdt, got %s %s", scheduled_order.Locked, scheduled_order.CurrencyID)
+	}
+
+	config.ScheduledOrders.LockOnSchedule = true
+
+	scheduled_order, err = NewScheduledOrder(member, market, types.SideBuy, types.TypeLimit, price, decimal.NullDecimal{}, quantity, false, now.Add(time.Second), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !scheduled_order.Locked.Equal(decimal.RequireFromString("201")) {
+		t.Errorf("expected the bid to lock 201 usdt, got %s", scheduled_order.Locked)
+	}
+
+	scheduled_order, err = NewScheduledOrder(member, market, types.SideSell, types.TypeMarket, decimal.NullDecimal{}, decimal.NullDecimal{}, quantity, false, now.Add(time.Second), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if scheduled_order.CurrencyID != "btc" || !scheduled_order.Locked.Equal(quantity) {
+		t.Errorf("expected the ask to lock 2 btc, got %s %s", scheduled_order.Locked, scheduled_order.CurrencyID)
+	}
+
+	if _, err := NewScheduledOrder(member, market, types.SideBuy, types.TypeMarket, decimal.NullDecimal{}, decimal.NullDecimal{}, quantity, false, now.Add(time.Second), now); err != ErrScheduledOrderNotLockable {
+		t.Errorf("expected a market bid to be refused, got %v", err)
+	}
+}
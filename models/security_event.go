@@ -0,0 +1,187 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+)
+
+// SecurityEventKind is a sensitive action on the account of a member.
+type SecurityEventKind string
+
+const (
+	SecurityEventAPIKeyCreated  SecurityEventKind = "api_key.created"
+	SecurityEventAPIKeyDisabled SecurityEventKind = "api_key.disabled"
+	// SecurityEventAPIKeyScopesChanged carries the kid of the key with its previous and its new scopes
+	SecurityEventAPIKeyScopesChanged SecurityEventKind = "api_key.scopes_changed"
+	SecurityEventCancelAll           SecurityEventKind = "orders.cancel_all"
+	SecurityEventRestricted          SecurityEventKind = "member.restricted"
+	SecurityEventLargeFill           SecurityEventKind = "trade.large_fill"
+)
+
+var SecurityEventKinds = []SecurityEventKind{
+	SecurityEventAPIKeyCreated,
+	SecurityEventAPIKeyDisabled,
+	SecurityEventAPIKeyScopesChanged,
+	SecurityEventCancelAll,
+	SecurityEventRestricted,
+	SecurityEventLargeFill,
+}
+
+// externalSecurityEventKinds are the kinds of the actions taken in the auth service, it reports them through the broker.
+var externalSecurityEventKinds = []SecurityEventKind{
+	SecurityEventAPIKeyCreated,
+	SecurityEventAPIKeyDisabled,
+	SecurityEventAPIKeyScopesChanged,
+	SecurityEventRestricted,
+}
+
+var ErrSecurityEventKind = errors.New("security_event.invalid_kind")
+
+func ValidSecurityEventKind(kind SecurityEventKind) bool {
+	for _, k := range SecurityEventKinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SecurityEvent is a sensitive action on the account of a member, written in the transaction of the action
+// so it's there whenever the action is. Members list their own events and are notified of them.
+type SecurityEvent struct {
+	ID       int64             `json:"id" gorm:"primaryKey"`
+	MemberID int64             `json:"member_id" gorm:"index"`
+	Kind     SecurityEventKind `json:"kind" gorm:"index"`
+	// ActorUID is the uid of who took the action when it isn't the member, an admin cancelling the orders of everyone
+	ActorUID string `json:"actor_uid"`
+	// Data is the detail of the action in JSON, the market of a cancel all, the trade of a large fill
+	Data      string    `json:"data"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// RecordSecurityEvent writes a security event with tx, the transaction of the action it describes.
+func RecordSecurityEvent(tx *gorm.DB, member_id int64, kind SecurityEventKind, actor_uid string, data map[string]interface{}) (*SecurityEvent, error) {
+	if !ValidSecurityEventKind(kind) {
+		return nil, ErrSecurityEventKind
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &SecurityEvent{
+		MemberID: member_id,
+		Kind:     kind,
+		ActorUID: actor_uid,
+		Data:     string(encoded),
+	}
+
+	if result := tx.Create(&event); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return event, nil
+}
+
+// NotifySecurityEvents sends the events to the private streams of their members, once the transaction
+// which wrote them is committed.
+func NotifySecurityEvents(events []*SecurityEvent) {
+	for _, event := range events {
+		var member *Member
+		if result := config.DataBase.First(&member, event.MemberID); result.Error != nil {
+			config.Logger.Errorf("Failed to notify the security event %d: %v", event.ID, result.Error)
+			continue
+		}
+
+		config.RangoClient.EnqueueEvent("private", member.UID, "security_event", event)
+	}
+}
+
+// LargeFillNotional returns the notional in the quote currency above which a fill is a security event,
+// found is false when large fills of the currency aren't reported.
+func LargeFillNotional(quote_unit string) (notional decimal.Decimal, found bool) {
+	notional, found = config.SecurityEvents.LargeFillNotional[quote_unit]
+
+	return notional, found && notional.IsPositive()
+}
+
+// IsLargeFill reports whether a fill of total in quote_unit is above the large fill notional of the currency.
+func IsLargeFill(quote_unit string, total decimal.Decimal) bool {
+	notional, found := LargeFillNotional(quote_unit)
+
+	return found && total.GreaterThan(notional)
+}
+
+// RecordLargeFills writes the large fill events of the members of a trade with tx, the transaction executing it.
+// Each member gets one event, the fake orders of the quantex bot have none.
+func RecordLargeFills(tx *gorm.DB, trade *Trade, quote_unit string, members []int64) ([]*SecurityEvent, error) {
+	if !IsLargeFill(quote_unit, trade.Total) {
+		return nil, nil
+	}
+
+	events := make([]*SecurityEvent, 0, len(members))
+	for _, member_id := range members {
+		event, err := RecordSecurityEvent(tx, member_id, SecurityEventLargeFill, "", map[string]interface{}{
+			"market":   trade.MarketID,
+			"trade_id": trade.ID,
+			"price":    trade.Price,
+			"amount":   trade.Amount,
+			"total":    trade.Total,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// ExternalSecurityEvent is a security event of the auth service, which owns the API keys and the restrictions.
+type ExternalSecurityEvent struct {
+	MemberUID string                 `json:"member_uid"`
+	Kind      SecurityEventKind      `json:"kind"`
+	ActorUID  string                 `json:"actor_uid"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// RecordExternalSecurityEvent writes and notifies a security event reported by the auth service.
+func RecordExternalSecurityEvent(payload []byte) error {
+	var external ExternalSecurityEvent
+	if err := json.Unmarshal(payload, &external); err != nil {
+		return events.Malformed(err)
+	}
+
+	valid := false
+	for _, kind := range externalSecurityEventKinds {
+		valid = valid || kind == external.Kind
+	}
+
+	if !valid {
+		return events.Malformed(ErrSecurityEventKind)
+	}
+
+	var member *Member
+	if result := config.DataBase.First(&member, "uid = ?", external.MemberUID); result.Error != nil {
+		return result.Error
+	}
+
+	event, err := RecordSecurityEvent(config.DataBase, member.ID, external.Kind, external.ActorUID, external.Data)
+	if err != nil {
+		return err
+	}
+
+	config.RangoClient.EnqueueEvent("private", member.UID, "security_event", event)
+
+	return nil
+}
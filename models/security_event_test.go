@@ -0,0 +1,59 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+func TestIsLargeFill(t *testing.T) {
+	security_events := config.SecurityEvents
+	t.Cleanup(func() { config.SecurityEvents = security_events })
+
+	config.SecurityEvents = &types.SecurityEventsConfig{
+		LargeFillNotional: map[string]decimal.Decimal{
+			"usdt": decimal.NewFromInt(100000),
+			"btc":  decimal.Zero,
+		},
+	}
+
+	tests := []struct {
+		quote_unit string
+		total      string
+		large      bool
+	}{
+		{"usdt", "100000.01", true},
+		{"usdt", "100000", false},
+		{"usdt", "5", false},
+		// a zero notional doesn't report every fill
+		{"btc", "10", false},
+		{"eth", "1000000", false},
+	}
+
+	for _, tt := range tests {
+		if large := IsLargeFill(tt.quote_unit, decimal.RequireFromString(tt.total)); large != tt.large {
+			t.Errorf("expected a fill of %s %s to be large %v, got %v", tt.total, tt.quote_unit, tt.large, large)
+		}
+	}
+}
+
+func TestRecordSecurityEventKinds(t *testing.T) {
+	if _, err := RecordSecurityEvent(nil, 1, SecurityEventKind("order.created"), "", nil); !errors.Is(err, ErrSecurityEventKind) {
+		t.Errorf("expected an unknown kind to be refused, got %v", err)
+	}
+
+	// the auth service only reports the actions it owns, cancel alls are recorded by the API
+	for _, payload := range []string{
+		`{"member_uid": "ID123", "kind": "orders.cancel_all"}`,
+		`{"member_uid": "ID123", "kind": "trade.large_fill"}`,
+		`{"member_uid": "ID123", "kind": "password.changed"}`,
+	} {
+		if err := RecordExternalSecurityEvent([]byte(payload)); !errors.Is(err, ErrSecurityEventKind) {
+			t.Errorf("expected %s to be refused, got %v", payload, err)
+		}
+	}
+}
@@ -0,0 +1,428 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// fastAckClosedKey holds the reason the fast path was closed for, it's removed by an operator.
+const fastAckClosedKey = "finex:fast_ack:closed"
+
+var ErrFastAckClosed = errors.New("fast_ack.closed")
+
+// errShadowUnloaded is the error of an update of an account which isn't loaded in the shadow balances.
+var errShadowUnloaded = errors.New("shadow account isn't loaded")
+
+// FastAckBalances are the shadow balances of the API processes, nil when the fast path isn't enabled.
+var FastAckBalances *ShadowBalances
+
+type ShadowAccount struct {
+	MemberID   int64
+	CurrencyID string
+}
+
+// ShadowReservation are the funds of an order acknowledged before it's persisted.
+type ShadowReservation struct {
+	OrderID    int64           `json:"order_id"`
+	MemberID   int64           `json:"member_id"`
+	CurrencyID string          `json:"currency_id"`
+	Amount     decimal.Decimal `json:"amount"`
+	ReservedAt time.Time       `json:"reserved_at"`
+}
+
+func (r *ShadowReservation) account() ShadowAccount {
+	return ShadowAccount{MemberID: r.MemberID, CurrencyID: r.CurrencyID}
+}
+
+// ShadowState is the shadow balance of an account: its available balance in the ledger, Snapshot, less the
+// reservations of its orders which weren't persisted yet.
+type ShadowState struct {
+	Snapshot     decimal.Decimal              `json:"snapshot"`
+	Reservations map[int64]*ShadowReservation `json:"reservations"`
+	// Loaded is false for an account the store doesn't hold, its next order loads it from the ledger
+	Loaded bool `json:"-"`
+}
+
+func newShadowState() *ShadowState {
+	return &ShadowState{Reservations: make(map[int64]*ShadowReservation)}
+}
+
+// Reserved is the sum of the reservations of the account.
+func (s *ShadowState) Reserved() decimal.Decimal {
+	reserved := decimal.Zero
+	for _, reservation := range s.Reservations {
+		reserved = reserved.Add(reservation.Amount)
+	}
+
+	return reserved
+}
+
+// Available is the shadow balance of the account.
+func (s *ShadowState) Available() decimal.Decimal {
+	return s.Snapshot.Sub(s.Reserved())
+}
+
+// ShadowStore holds the shadow balances the API processes share.
+type ShadowStore interface {
+	// Load returns the state of an account, not Loaded when the store doesn't hold it
+	Load(account ShadowAccount) (*ShadowState, error)
+	// Update applies update to the state of an account and stores it, atomically with the updates of every process:
+	// update runs again on the state stored meanwhile when another process changed the account. Nothing is stored
+	// when update fails, an account left without reservations is unloaded.
+	Update(account ShadowAccount, update func(state *ShadowState) error) error
+	// Accounts returns the accounts the store holds
+	Accounts() ([]ShadowAccount, error)
+}
+
+// ShadowLedger is what the shadow balances are loaded from and reconciled with.
+type ShadowLedger interface {
+	// Available is the available balance of an account
+	Available(member_id int64, currency_id string) (decimal.Decimal, error)
+	// Persisted returns which of the orders were persisted, in any state
+	Persisted(order_ids []int64) (map[int64]bool, error)
+	// Closed returns why the fast path was closed for every process, empty while it's open
+	Closed() (string, error)
+	// Close closes the fast path for every process
+	Close(reason string) error
+}
+
+// ShadowBalances reserve the funds of the orders acknowledged before they're persisted. The shadow balance
+// of an account is its available balance in the ledger, loaded on the first order, less the reservations
+// of the orders which weren't persisted yet. The balances are kept in a store shared by the API processes, the
+// orders of a member placed through several processes reserve from the same balance.
+//
+// Reconcile releases the reservations of the persisted orders with the balance they were persisted in, and
+// closes the fast path when the shadow can't be trusted: an order waits too long to be persisted, or the shadow
+// balance of an account with unpersisted orders exceeds the ledger. Closed shadow balances refuse every reservation,
+// the orders are placed synchronously until an operator reopens them.
+type ShadowBalances struct {
+	mutex  sync.Mutex
+	ledger ShadowLedger
+	store  ShadowStore
+	closed string
+}
+
+func NewShadowBalances(ledger ShadowLedger, store ShadowStore) *ShadowBalances {
+	return &ShadowBalances{
+		ledger: ledger,
+		store:  store,
+	}
+}
+
+// Reserve reserves the funds of an order, it fails with ErrFastAckClosed while the fast path is closed
+// and ErrInsufficientBalance when the shadow balance is short.
+func (s *ShadowBalances) Reserve(reservation *ShadowReservation) error {
+	if len(s.Closed()) > 0 {
+		return ErrFastAckClosed
+	}
+
+	reserve := func(state *ShadowState) error {
+		if state.Available().LessThan(reservation.Amount) {
+			return ErrInsufficientBalance
+		}
+
+		state.Reservations[reservation.OrderID] = reservation
+
+		return nil
+	}
+
+	err := s.store.Update(reservation.account(), func(state *ShadowState) error {
+		if !state.Loaded {
+			return errShadowUnloaded
+		}
+
+		return reserve(state)
+	})
+	if !errors.Is(err, errShadowUnloaded) {
+		return err
+	}
+
+	// the ledger is read out of the update so the placements of the account don't wait for it
+	available, err := s.ledger.Available(reservation.MemberID, reservation.CurrencyID)
+	if err != nil {
+		return err
+	}
+
+	return s.store.Update(reservation.account(), func(state *ShadowState) error {
+		// loaded by another process meanwhile
+		if !state.Loaded {
+			state.Snapshot = available
+			state.Loaded = true
+		}
+
+		return reserve(state)
+	})
+}
+
+// Available is the shadow balance of an account, false when it isn't loaded.
+func (s *ShadowBalances) Available(member_id int64, currency_id string) (decimal.Decimal, bool) {
+	state, err := s.store.Load(ShadowAccount{MemberID: member_id, CurrencyID: currency_id})
+	if err != nil || !state.Loaded {
+		return decimal.Zero, false
+	}
+
+	return state.Available(), true
+}
+
+// Closed returns why the fast path is closed, empty while it's open.
+func (s *ShadowBalances) Closed() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.closed
+}
+
+func (s *ShadowBalances) close(reason string) {
+	if len(s.closed) == 0 {
+		config.Logger.Errorf("Fast order acknowledgement closed: %s", reason)
+	}
+
+	s.closed = reason
+}
+
+// Release releases the reservation of an order which wasn't acknowledged after all.
+func (s *ShadowBalances) Release(reservation *ShadowReservation) {
+	err := s.store.Update(reservation.account(), func(state *ShadowState) error {
+		if _, ok := state.Reservations[reservation.OrderID]; !ok {
+			return errShadowUnloaded
+		}
+
+		delete(state.Reservations, reservation.OrderID)
+
+		return nil
+	})
+
+	// a reservation left behind is released by the reconcile once it's stale, and closes the fast path
+	if err != nil && !errors.Is(err, errShadowUnloaded) {
+		config.Logger.Errorf("Failed to release the reservation of order %d: %v", reservation.OrderID, err)
+	}
+}
+
+// Reconcile releases the reservations of the persisted orders and reloads the balances of their accounts,
+// then closes the fast path of every process if it can't be trusted. Accounts without unpersisted orders
+// are unloaded, their next order loads them from the ledger. The fast path closed by a failed reconcile
+// reopens on the next one. Every process reconciles the accounts of all of them, each account is updated
+// atomically so the reconciles of the processes can run at once.
+func (s *ShadowBalances) Reconcile(now time.Time, max_pending_age time.Duration, max_drift decimal.Decimal) error {
+	accounts, err := s.store.Accounts()
+	if err != nil {
+		return err
+	}
+
+	order_ids := make([]int64, 0)
+	for _, account := range accounts {
+		state, err := s.store.Load(account)
+		if err != nil {
+			return err
+		}
+
+		for order_id := range state.Reservations {
+			order_ids = append(order_ids, order_id)
+		}
+	}
+
+	closed_by, err := s.ledger.Closed()
+	if err != nil {
+		return err
+	}
+
+	// the balances are read once the persisted orders are known so they hold the funds those orders locked,
+	// the orders persisted while they're read are left reserved until the next reconcile
+	persisted, err := s.persisted(order_ids)
+	if err != nil {
+		return err
+	}
+
+	balances := make(map[ShadowAccount]decimal.Decimal, len(accounts))
+	for _, account := range accounts {
+		available, err := s.ledger.Available(account.MemberID, account.CurrencyID)
+		if err != nil {
+			return err
+		}
+
+		balances[account] = available
+	}
+
+	persisted_since, err := s.persisted(order_ids)
+	if err != nil {
+		return err
+	}
+
+	var anomaly string
+	for _, account := range accounts {
+		var account_anomaly string
+
+		err := s.store.Update(account, func(state *ShadowState) error {
+			// unloaded by the reconcile of another process meanwhile
+			if !state.Loaded {
+				return errShadowUnloaded
+			}
+
+			account_anomaly = ""
+			shadow := state.Available()
+
+			// the orders reserved since the accounts were read are unpersisted
+			unpersisted := decimal.Zero
+			for order_id, reservation := range state.Reservations {
+				if persisted[order_id] {
+					delete(state.Reservations, order_id)
+				} else if !persisted_since[order_id] {
+					unpersisted = unpersisted.Add(reservation.Amount)
+				}
+			}
+
+			drift := shadow.Sub(balances[account].Sub(unpersisted))
+			if unpersisted.IsPositive() && drift.GreaterThan(max_drift) {
+				account_anomaly = fmt.Sprintf("the shadow balance of member %d in %s exceeds the ledger by %s", account.MemberID, account.CurrencyID, drift)
+			}
+
+			state.Snapshot = balances[account]
+
+			// the orders which weren't persisted in time are left to the operator, they'd keep the fast path closed
+			for order_id, reservation := range state.Reservations {
+				if now.Sub(reservation.ReservedAt) > max_pending_age {
+					account_anomaly = fmt.Sprintf("order %d wasn't persisted in %s", order_id, max_pending_age)
+					delete(state.Reservations, order_id)
+				}
+			}
+
+			return nil
+		})
+		if err != nil && !errors.Is(err, errShadowUnloaded) {
+			return err
+		}
+
+		if len(account_anomaly) > 0 {
+			anomaly = account_anomaly
+		}
+	}
+
+	s.mutex.Lock()
+
+	switch {
+	case len(anomaly) > 0:
+		s.close(anomaly)
+	case len(closed_by) > 0:
+		s.close(closed_by)
+	case len(s.closed) > 0:
+		config.Logger.Infof("Fast order acknowledgement reopened, it was closed as %s", s.closed)
+		s.closed = ""
+	}
+
+	s.mutex.Unlock()
+
+	// an anomaly closes the fast path of every process until an operator reopens it
+	if len(anomaly) > 0 && anomaly != closed_by {
+		return s.ledger.Close(anomaly)
+	}
+
+	return nil
+}
+
+func (s *ShadowBalances) persisted(order_ids []int64) (map[int64]bool, error) {
+	if len(order_ids) == 0 {
+		return map[int64]bool{}, nil
+	}
+
+	return s.ledger.Persisted(order_ids)
+}
+
+// ReconcileEvery reconciles the shadow balances with the ledger every interval, failures close the fast path.
+func (s *ShadowBalances) ReconcileEvery(interval, max_pending_age time.Duration, max_drift decimal.Decimal) {
+	for {
+		time.Sleep(interval)
+
+		if err := s.Reconcile(time.Now(), max_pending_age, max_drift); err != nil {
+			s.mutex.Lock()
+			s.close(fmt.Sprintf("failed to reconcile: %v", err))
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// ledgerShadow is the ledger of the database, closures are shared through Redis.
+type ledgerShadow struct{}
+
+func (ledgerShadow) Available(member_id int64, currency_id string) (decimal.Decimal, error) {
+	var account *Account
+	if result := config.DataBase.Where("member_id = ? AND currency_id = ?", member_id, currency_id).Limit(1).Find(&account); result.Error != nil {
+		return decimal.Zero, result.Error
+	} else if result.RowsAffected == 0 {
+		return decimal.Zero, nil
+	}
+
+	return GetAvailableBalance(config.DataBase, account, 0).Available, nil
+}
+
+func (ledgerShadow) Persisted(order_ids []int64) (map[int64]bool, error) {
+	var ids []int64
+	if result := config.DataBase.Model(&Order{}).Where("id IN ?", order_ids).Pluck("id", &ids); result.Error != nil {
+		return nil, result.Error
+	}
+
+	persisted := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		persisted[id] = true
+	}
+
+	return persisted, nil
+}
+
+func (ledgerShadow) Closed() (string, error) {
+	exist, err := config.Redis.Exist(fastAckClosedKey)
+	if err != nil || !exist {
+		return "", err
+	}
+
+	result, err := config.Redis.Get(fastAckClosedKey)
+	if err != nil {
+		return "", err
+	}
+
+	return result.Val(), nil
+}
+
+// StartFastAck opens the shadow balances the API processes share in Redis and reconciles them as configured. The
+// fast path stays closed when Redis can't be reached.
+func StartFastAck() {
+	store, err := newRedisShadowStore(os.Getenv("REDIS_URL"))
+	if err != nil {
+		config.Logger.Errorf("Failed to open the shadow balances, orders are placed synchronously: %v", err)
+		return
+	}
+
+	FastAckBalances = NewShadowBalances(ledgerShadow{}, store)
+
+	interval := config.FastAck.ReconcileInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	max_pending_age := config.FastAck.MaxPendingAge
+	if max_pending_age <= 0 {
+		max_pending_age = 10 * time.Second
+	}
+
+	go FastAckBalances.ReconcileEvery(interval, max_pending_age, config.FastAck.MaxDrift)
+}
+
+func (ledgerShadow) Close(reason string) error {
+	return CloseFastAck(reason)
+}
+
+// CloseFastAck closes the fast path of every API process until ReopenFastAck.
+func CloseFastAck(reason string) error {
+	return config.Redis.Set(fastAckClosedKey, reason, 0)
+}
+
+// ReopenFastAck reopens the fast path closed by CloseFastAck, API processes reopen on their next reconcile.
+func ReopenFastAck() error {
+	return config.Redis.Delete(fastAckClosedKey)
+}
@@ -0,0 +1,335 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// memoryShadowStore is a store of the shadow balances in memory, the balances sharing it are the processes sharing
+// Redis. The states are stored encoded as Redis stores them.
+type memoryShadowStore struct {
+	mutex  sync.Mutex
+	states map[ShadowAccount][]byte
+}
+
+func newMemoryShadowStore() *memoryShadowStore {
+	return &memoryShadowStore{states: make(map[ShadowAccount][]byte)}
+}
+
+func (s *memoryShadowStore) load(account ShadowAccount) (*ShadowState, error) {
+	state := newShadowState()
+
+	payload, ok := s.states[account]
+	if !ok {
+		return state, nil
+	}
+
+	if err := json.Unmarshal(payload, state); err != nil {
+		return nil, err
+	}
+	state.Loaded = true
+
+	return state, nil
+}
+
+func (s *memoryShadowStore) Load(account ShadowAccount) (*ShadowState, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.load(account)
+}
+
+func (s *memoryShadowStore) Update(account ShadowAccount, update func(state *ShadowState) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	state, err := s.load(account)
+	if err != nil {
+		return err
+	}
+
+	if err := update(state); err != nil {
+		return err
+	}
+
+	if len(state.Reservations) == 0 {
+		delete(s.states, account)
+		return nil
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	s.states[account] = payload
+
+	return nil
+}
+
+func (s *memoryShadowStore) Accounts() ([]ShadowAccount, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	accounts := make([]ShadowAccount, 0, len(s.states))
+	for account := range s.states {
+		accounts = append(accounts, account)
+	}
+
+	return accounts, nil
+}
+
+// testLedger is a ledger of fixed balances, persisted_after lists the orders persisted from the second
+// Persisted of a reconcile on.
+type testLedger struct {
+	available       map[int64]decimal.Decimal
+	persisted       map[int64]bool
+	persisted_after map[int64]bool
+	calls           int
+	closed          string
+}
+
+func (l *testLedger) Available(member_id int64, currency_id string) (decimal.Decimal, error) {
+	return l.available[member_id], nil
+}
+
+func (l *testLedger) Persisted(order_ids []int64) (map[int64]bool, error) {
+	l.calls++
+
+	persisted := map[int64]bool{}
+	for _, id := range order_ids {
+		if l.persisted[id] || l.calls%2 == 0 && l.persisted_after[id] {
+			persisted[id] = true
+		}
+	}
+
+	return persisted, nil
+}
+
+func (l *testLedger) Closed() (string, error) {
+	return l.closed, nil
+}
+
+func (l *testLedger) Close(reason string) error {
+	l.closed = reason
+	return nil
+}
+
+func newTestLedger(available string) *testLedger {
+	return &testLedger{
+		available:       map[int64]decimal.Decimal{1: decimal.RequireFromString(available)},
+		persisted:       map[int64]bool{},
+		persisted_after: map[int64]bool{},
+	}
+}
+
+func reservation(order_id int64, amount string, at time.Time) *ShadowReservation {
+	return &ShadowReservation{OrderID: order_id, MemberID: 1, CurrencyID: "usdt", Amount: decimal.RequireFromString(amount), ReservedAt: at}
+}
+
+func TestShadowBalancesReserve(t *testing.T) {
+	d := decimal.RequireFromString
+	now := time.Now()
+	balances := NewShadowBalances(newTestLedger("100"), newMemoryShadowStore())
+
+	if err := balances.Reserve(reservation(1, "60", now)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := balances.Reserve(reservation(2, "50", now)); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("expected the unpersisted order to hold its funds, got %v", err)
+	}
+
+	if err := balances.Reserve(reservation(3, "40", now)); err != nil {
+		t.Errorf("expected the rest of the balance to be reserved, got %v", err)
+	}
+
+	if available, ok := balances.Available(1, "usdt"); !ok || !available.Equal(d("0")) {
+		t.Errorf("expected nothing left, got %s", available)
+	}
+}
+
+// The processes sharing the shadow balances reserve from the same balance, a member can't overspend it by placing
+// orders through several of them.
+func TestShadowBalancesSharedByProcesses(t *testing.T) {
+	d := decimal.RequireFromString
+	now := time.Now()
+	ledger := newTestLedger("100")
+	store := newMemoryShadowStore()
+	first, second := NewShadowBalances(ledger, store), NewShadowBalances(ledger, store)
+
+	if err := first.Reserve(reservation(1, "60", now)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.Reserve(reservation(2, "50", now)); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("expected the order of the other process to hold its funds, got %v", err)
+	}
+
+	if err := second.Reserve(reservation(3, "40", now)); err != nil {
+		t.Fatal(err)
+	}
+
+	// an order released by a process frees its funds for every process
+	second.Release(reservation(3, "40", now))
+	if available, ok := first.Available(1, "usdt"); !ok || !available.Equal(d("40")) {
+		t.Errorf("expected 40 left, got %s", available)
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	reserved := decimal.Zero
+	for process := 0; process < 8; process++ {
+		balances := NewShadowBalances(ledger, store)
+
+		wg.Add(1)
+		go func(process int) {
+			defer wg.Done()
+
+			for i := 0; i < 20; i++ {
+				if err := balances.Reserve(reservation(int64(100+process*20+i), "1", now)); err == nil {
+					mutex.Lock()
+					reserved = reserved.Add(d("1"))
+					mutex.Unlock()
+				}
+			}
+		}(process)
+	}
+	wg.Wait()
+
+	if !reserved.Equal(d("40")) {
+		t.Errorf("expected the processes to reserve the 40 left between them, got %s", reserved)
+	}
+}
+
+func TestShadowBalancesReconcileReleasesPersistedOrders(t *testing.T) {
+	d := decimal.RequireFromString
+	now := time.Now()
+	ledger := newTestLedger("100")
+	balances := NewShadowBalances(ledger, newMemoryShadowStore())
+
+	balances.Reserve(reservation(1, "60", now))
+	balances.Reserve(reservation(2, "10", now))
+
+	// order 1 locked its funds in the ledger
+	ledger.persisted[1] = true
+	ledger.available[1] = d("40")
+
+	if err := balances.Reconcile(now, time.Minute, decimal.Zero); err != nil {
+		t.Fatal(err)
+	}
+
+	if available, _ := balances.Available(1, "usdt"); !available.Equal(d("30")) {
+		t.Errorf("expected the ledger balance less the unpersisted order, got %s", available)
+	}
+
+	ledger.persisted[2] = true
+	ledger.available[1] = d("30")
+	balances.Reconcile(now, time.Minute, decimal.Zero)
+
+	if _, ok := balances.Available(1, "usdt"); ok {
+		t.Error("expected the account without unpersisted orders to be unloaded")
+	}
+
+	if closed := balances.Closed(); len(closed) > 0 {
+		t.Errorf("expected the fast path to stay open, it's closed as %s", closed)
+	}
+}
+
+func TestShadowBalancesReconcileOrderPersistedMeanwhile(t *testing.T) {
+	d := decimal.RequireFromString
+	now := time.Now()
+	ledger := newTestLedger("100")
+	balances := NewShadowBalances(ledger, newMemoryShadowStore())
+
+	balances.Reserve(reservation(1, "60", now))
+
+	// order 1 is persisted while the balance is read, the balance holds its funds
+	ledger.persisted_after[1] = true
+	ledger.available[1] = d("40")
+
+	balances.Reconcile(now, time.Minute, decimal.Zero)
+
+	if closed := balances.Closed(); len(closed) > 0 {
+		t.Errorf("expected no drift, closed as %s", closed)
+	}
+
+	if available, _ := balances.Available(1, "usdt"); !available.Equal(d("-20")) {
+		t.Errorf("expected the order to stay reserved until the next reconcile, got %s", available)
+	}
+}
+
+func TestShadowBalancesCloseOnDrift(t *testing.T) {
+	d := decimal.RequireFromString
+	now := time.Now()
+	ledger := newTestLedger("100")
+	balances := NewShadowBalances(ledger, newMemoryShadowStore())
+
+	balances.Reserve(reservation(1, "60", now))
+
+	// a withdrawal the shadow doesn't know of
+	ledger.available[1] = d("50")
+
+	balances.Reconcile(now, time.Minute, d("1"))
+
+	if len(balances.Closed()) == 0 || len(ledger.closed) == 0 {
+		t.Fatal("expected the drift to close the fast path of every process")
+	}
+
+	if err := balances.Reserve(reservation(2, "1", now)); !errors.Is(err, ErrFastAckClosed) {
+		t.Errorf("expected the closed fast path to refuse orders, got %v", err)
+	}
+
+	// the operator reopens once the order is persisted
+	ledger.persisted[1] = true
+	ledger.closed = ""
+	balances.Reconcile(now, time.Minute, d("1"))
+
+	if closed := balances.Closed(); len(closed) > 0 {
+		t.Errorf("expected the fast path to reopen, it's closed as %s", closed)
+	}
+}
+
+func TestShadowBalancesCloseOnStaleOrder(t *testing.T) {
+	now := time.Now()
+	ledger := newTestLedger("100")
+	balances := NewShadowBalances(ledger, newMemoryShadowStore())
+
+	balances.Reserve(reservation(1, "60", now.Add(-time.Minute)))
+	balances.Reconcile(now, 10*time.Second, decimal.Zero)
+
+	if len(ledger.closed) == 0 {
+		t.Fatal("expected the order which wasn't persisted to close the fast path")
+	}
+
+	if _, ok := balances.Available(1, "usdt"); ok {
+		t.Error("expected the stale order to be left to the operator")
+	}
+}
+
+func BenchmarkShadowBalancesReserve(b *testing.B) {
+	ledger := &testLedger{available: map[int64]decimal.Decimal{}}
+	balances := NewShadowBalances(ledger, newMemoryShadowStore())
+	amount := decimal.NewFromInt(1)
+	now := time.Now()
+
+	for member_id := int64(1); member_id <= 1000; member_id++ {
+		ledger.available[member_id] = decimal.NewFromInt(int64(b.N) + 1)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		balances.Reserve(&ShadowReservation{
+			OrderID:    int64(n),
+			MemberID:   int64(n%1000) + 1,
+			CurrencyID: "usdt" + strconv.Itoa(n%2),
+			Amount:     amount,
+			ReservedAt: now,
+		})
+	}
+}
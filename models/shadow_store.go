@@ -0,0 +1,138 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// fastAckAccountsKey is the set of the accounts held by the shadow balances, each as member_id:currency_id.
+const fastAckAccountsKey = "finex:fast_ack:accounts"
+
+// shadowUpdateAttempts is how many times an update runs again while other processes keep changing its account.
+const shadowUpdateAttempts = 16
+
+var errShadowContended = errors.New("shadow account changed by other processes on every attempt")
+
+func shadowAccountMember(account ShadowAccount) string {
+	return strconv.FormatInt(account.MemberID, 10) + ":" + account.CurrencyID
+}
+
+func shadowAccountKey(account ShadowAccount) string {
+	return "finex:fast_ack:account:" + shadowAccountMember(account)
+}
+
+// redisShadowStore keeps the state of each account as JSON in a key of its own. An update watches the key of its
+// account and writes it in a transaction, which fails when another process wrote the key meanwhile: the update runs
+// again on the state that process stored. The services client of the other Redis callers only offers get and set.
+type redisShadowStore struct {
+	client *redis.Client
+}
+
+func newRedisShadowStore(url string) (*redisShadowStore, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redisShadowStore{client: redis.NewClient(options)}, nil
+}
+
+func loadShadowState(ctx context.Context, client redis.Cmdable, key string) (*ShadowState, error) {
+	state := newShadowState()
+
+	payload, err := client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(payload, state); err != nil {
+		return nil, err
+	}
+
+	if state.Reservations == nil {
+		state.Reservations = make(map[int64]*ShadowReservation)
+	}
+	state.Loaded = true
+
+	return state, nil
+}
+
+func (s *redisShadowStore) Load(account ShadowAccount) (*ShadowState, error) {
+	return loadShadowState(context.Background(), s.client, shadowAccountKey(account))
+}
+
+func (s *redisShadowStore) Update(account ShadowAccount, update func(state *ShadowState) error) error {
+	ctx := context.Background()
+	key := shadowAccountKey(account)
+
+	for attempt := 0; attempt < shadowUpdateAttempts; attempt++ {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			state, err := loadShadowState(ctx, tx, key)
+			if err != nil {
+				return err
+			}
+
+			if err := update(state); err != nil {
+				return err
+			}
+
+			var payload []byte
+			if len(state.Reservations) > 0 {
+				if payload, err = json.Marshal(state); err != nil {
+					return err
+				}
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if len(payload) == 0 {
+					pipe.Del(ctx, key)
+					pipe.SRem(ctx, fastAckAccountsKey, shadowAccountMember(account))
+				} else {
+					pipe.Set(ctx, key, payload, 0)
+					pipe.SAdd(ctx, fastAckAccountsKey, shadowAccountMember(account))
+				}
+
+				return nil
+			})
+
+			return err
+		}, key)
+
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+
+	return errShadowContended
+}
+
+func (s *redisShadowStore) Accounts() ([]ShadowAccount, error) {
+	members, err := s.client.SMembers(context.Background(), fastAckAccountsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := make([]ShadowAccount, 0, len(members))
+	for _, member := range members {
+		parts := strings.SplitN(member, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		member_id, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		accounts = append(accounts, ShadowAccount{MemberID: member_id, CurrencyID: parts[1]})
+	}
+
+	return accounts, nil
+}
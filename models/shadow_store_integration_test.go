@@ -0,0 +1,137 @@
+//go:build integration
+
+package models
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// The tests need the REDIS_URL of a disposable Redis:
+//
+//	REDIS_URL=redis://localhost:6379/15 go test -tags integration -run 'RedisShadow' ./models
+func openRedisShadowStore(t testing.TB) *redisShadowStore {
+	if len(os.Getenv("REDIS_URL")) == 0 {
+		t.Skip("REDIS_URL isn't set")
+	}
+
+	store, err := newRedisShadowStore(os.Getenv("REDIS_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+// setupRedisShadowStore opens the store with nothing in it.
+func setupRedisShadowStore(t testing.TB) *redisShadowStore {
+	store := openRedisShadowStore(t)
+	if err := store.client.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+// API processes with clients of their own reserve from the same balances, none is overspent.
+func TestRedisShadowStoreSharedByProcesses(t *testing.T) {
+	d := decimal.RequireFromString
+	now := time.Now()
+	setupRedisShadowStore(t)
+	ledger := newTestLedger("100")
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	reserved := decimal.Zero
+	for process := 0; process < 8; process++ {
+		// each process has a client of its own
+		balances := NewShadowBalances(ledger, openRedisShadowStore(t))
+
+		wg.Add(1)
+		go func(process int) {
+			defer wg.Done()
+
+			for i := 0; i < 30; i++ {
+				err := balances.Reserve(reservation(int64(process*30+i+1), "1", now))
+				if err == nil {
+					mutex.Lock()
+					reserved = reserved.Add(d("1"))
+					mutex.Unlock()
+				} else if !errors.Is(err, ErrInsufficientBalance) {
+					t.Error(err)
+				}
+			}
+		}(process)
+	}
+	wg.Wait()
+
+	store := openRedisShadowStore(t)
+	balances := NewShadowBalances(ledger, store)
+	if available, ok := balances.Available(1, "usdt"); !reserved.Equal(d("100")) || !ok || !available.IsZero() {
+		t.Errorf("expected the 100 reserved once between the processes, got %s reserved and %s left", reserved, available)
+	}
+
+	// the persisted orders are released and the account unloaded by the reconcile of any process
+	for id := int64(1); id <= 240; id++ {
+		ledger.persisted[id] = true
+	}
+	ledger.available[1] = decimal.Zero
+
+	if err := balances.Reconcile(now, time.Minute, decimal.Zero); err != nil {
+		t.Fatal(err)
+	}
+
+	if accounts, err := store.Accounts(); err != nil || len(accounts) != 0 {
+		t.Errorf("expected the account to be unloaded, got %v %v", accounts, err)
+	}
+}
+
+// BenchmarkRedisShadowStoreReserve reports the p50 and p99 of a reservation against the Redis of REDIS_URL, with
+// 8 processes placing the orders of 1000 members.
+func BenchmarkRedisShadowStoreReserve(b *testing.B) {
+	setupRedisShadowStore(b)
+	ledger := &testLedger{available: map[int64]decimal.Decimal{}}
+	for member_id := int64(1); member_id <= 1000; member_id++ {
+		ledger.available[member_id] = decimal.NewFromInt(int64(b.N) + 1)
+	}
+
+	balances := make([]*ShadowBalances, 8)
+	for i := range balances {
+		balances[i] = NewShadowBalances(ledger, openRedisShadowStore(b))
+	}
+
+	latencies := make([]time.Duration, b.N)
+	amount := decimal.NewFromInt(1)
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for process := range balances {
+		wg.Add(1)
+		go func(process int) {
+			defer wg.Done()
+
+			for n := process; n < b.N; n += len(balances) {
+				started_at := time.Now()
+				balances[process].Reserve(&ShadowReservation{
+					OrderID:    int64(n),
+					MemberID:   int64(n%1000) + 1,
+					CurrencyID: "usdt",
+					Amount:     amount,
+					ReservedAt: now,
+				})
+				latencies[n] = time.Since(started_at)
+			}
+		}(process)
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	reportPercentiles(b, latencies)
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultStreamExpiringNotice is how long before its token expires a session is noticed when stream_auth.expiring_notice isn't set.
+const DefaultStreamExpiringNotice = time.Minute
+
+type StreamAuthKind string
+
+var (
+	StreamAuthJWT StreamAuthKind = "jwt"
+	// StreamAuthAPIKey sessions are authenticated by the gateway with an API key, they don't expire
+	StreamAuthAPIKey StreamAuthKind = "api_key"
+)
+
+// StreamNotice is a message the websocket gateway sends a session about its authentication.
+type StreamNotice string
+
+var (
+	// StreamNoticeAuthExpiring asks for an auth message with a fresh token before the token of the session expires
+	StreamNoticeAuthExpiring StreamNotice = "auth_expiring"
+	// StreamNoticeAuthDowngraded tells the session its private streams stopped, its public streams go on
+	StreamNoticeAuthDowngraded StreamNotice = "auth_downgraded"
+)
+
+var (
+	ErrStreamAuthMemberMismatch = errors.New("stream.auth.member_mismatch")
+	ErrStreamAuthExpired        = errors.New("stream.auth.token_expired")
+	ErrStreamSessionClosed      = errors.New("stream.session.closed")
+)
+
+// StreamSession is the authentication of a websocket connection. A session authenticated with a token receives
+// the private streams of its member until the token expires, unless an auth message brings a fresh token of the
+// same member. It's then downgraded to the public streams rather than disconnected, a later auth message upgrades
+// it again. A token of another member closes the session.
+type StreamSession struct {
+	UID       string
+	Kind      StreamAuthKind
+	ExpiresAt time.Time
+	// Private is set while the session receives the private streams of its member
+	Private bool
+	Closed  bool
+	noticed bool
+}
+
+func NewStreamSession(uid string, kind StreamAuthKind, expires_at time.Time) *StreamSession {
+	return &StreamSession{
+		UID:       uid,
+		Kind:      kind,
+		ExpiresAt: expires_at,
+		Private:   true,
+	}
+}
+
+// NoticeAt is when the session is sent auth_expiring.
+func (s *StreamSession) NoticeAt(expiring_notice time.Duration) time.Time {
+	if expiring_notice <= 0 {
+		expiring_notice = DefaultStreamExpiringNotice
+	}
+
+	return s.ExpiresAt.Add(-expiring_notice)
+}
+
+// Tick returns the notices the session is due at now, the gateway calls it on its timer and before
+// every private event.
+func (s *StreamSession) Tick(now time.Time, expiring_notice time.Duration) []StreamNotice {
+	if s.Kind == StreamAuthAPIKey || s.Closed || !s.Private {
+		return nil
+	}
+
+	if !now.Before(s.ExpiresAt) {
+		s.Private = false
+		return []StreamNotice{StreamNoticeAuthDowngraded}
+	}
+
+	if !s.noticed && !now.Before(s.NoticeAt(expiring_notice)) {
+		s.noticed = true
+		return []StreamNotice{StreamNoticeAuthExpiring}
+	}
+
+	return nil
+}
+
+// Refresh extends the session with a fresh token of its member, expiring at expires_at. A token of another member
+// closes the session, an expired token leaves it as it was.
+func (s *StreamSession) Refresh(uid string, expires_at, now time.Time) error {
+	if s.Closed {
+		return ErrStreamSessionClosed
+	}
+
+	if uid != s.UID {
+		s.Closed = true
+		s.Private = false
+		return ErrStreamAuthMemberMismatch
+	}
+
+	if !now.Before(expires_at) {
+		return ErrStreamAuthExpired
+	}
+
+	s.ExpiresAt = expires_at
+	s.Private = true
+	s.noticed = false
+
+	return nil
+}
+
+// Receives reports whether an event of a public or a private stream is sent to the session at now, private events
+// stop at the expiry of the token even when the gateway didn't tick the session yet.
+func (s *StreamSession) Receives(private bool, now time.Time) bool {
+	if s.Closed {
+		return false
+	}
+
+	if !private {
+		return true
+	}
+
+	return s.Private && (s.Kind == StreamAuthAPIKey || now.Before(s.ExpiresAt))
+}
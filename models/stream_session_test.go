@@ -0,0 +1,127 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// streamEvent is an event the gateway has for a session at at.
+type streamEvent struct {
+	at      time.Duration
+	private bool
+}
+
+// deliver plays the events to the session as the gateway does, ticking it before every event, and returns
+// the notices and the events which were sent.
+func deliver(session *StreamSession, start time.Time, events []streamEvent) (notices []StreamNotice, sent []streamEvent) {
+	for _, event := range events {
+		now := start.Add(event.at)
+		notices = append(notices, session.Tick(now, time.Minute)...)
+
+		if session.Receives(event.private, now) {
+			sent = append(sent, event)
+		}
+	}
+
+	return notices, sent
+}
+
+// orderUpdates are private order updates every 30s, with a public trade in between, for 5 minutes.
+func orderUpdates() []streamEvent {
+	events := make([]streamEvent, 0)
+	for at := time.Duration(0); at < 5*time.Minute; at += 30 * time.Second {
+		events = append(events, streamEvent{at: at, private: true}, streamEvent{at: at + 15*time.Second})
+	}
+
+	return events
+}
+
+func TestStreamSessionExpiresDuringOrderUpdates(t *testing.T) {
+	start := time.Date(2022, 5, 2, 10, 0, 0, 0, time.UTC)
+	session := NewStreamSession("ID1", StreamAuthJWT, start.Add(2*time.Minute))
+
+	notices, sent := deliver(session, start, orderUpdates())
+
+	if len(notices) != 2 || notices[0] != StreamNoticeAuthExpiring || notices[1] != StreamNoticeAuthDowngraded {
+		t.Fatalf("expected auth_expiring then auth_downgraded, got %v", notices)
+	}
+
+	var private, public int
+	for _, event := range sent {
+		if event.private {
+			private++
+			if event.at >= 2*time.Minute {
+				t.Errorf("expected no order update after the expiry, got one at %s", event.at)
+			}
+		} else {
+			public++
+		}
+	}
+
+	if private != 4 || public != 10 {
+		t.Errorf("expected the 4 order updates before the expiry and every public event, got %d and %d", private, public)
+	}
+
+	if session.Closed {
+		t.Errorf("expected the session to be downgraded rather than closed")
+	}
+}
+
+func TestStreamSessionRefresh(t *testing.T) {
+	start := time.Date(2022, 5, 2, 10, 0, 0, 0, time.UTC)
+	session := NewStreamSession("ID1", StreamAuthJWT, start.Add(2*time.Minute))
+
+	if notices := session.Tick(start.Add(90*time.Second), time.Minute); len(notices) != 1 || notices[0] != StreamNoticeAuthExpiring {
+		t.Fatalf("expected auth_expiring, got %v", notices)
+	}
+
+	if err := session.Refresh("ID1", start.Add(time.Minute), start.Add(90*time.Second)); !errors.Is(err, ErrStreamAuthExpired) {
+		t.Fatalf("expected an expired token to be refused, got %v", err)
+	}
+
+	if err := session.Refresh("ID1", start.Add(10*time.Minute), start.Add(90*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if notices := session.Tick(start.Add(3*time.Minute), time.Minute); len(notices) != 0 || !session.Receives(true, start.Add(3*time.Minute)) {
+		t.Errorf("expected the refreshed session to go on, got %v", notices)
+	}
+
+	if notices := session.Tick(start.Add(9*time.Minute), time.Minute); len(notices) != 1 || notices[0] != StreamNoticeAuthExpiring {
+		t.Errorf("expected auth_expiring before the fresh token expires, got %v", notices)
+	}
+
+	// a downgraded session is upgraded by a later auth message
+	session.Tick(start.Add(10*time.Minute), time.Minute)
+	if err := session.Refresh("ID1", start.Add(20*time.Minute), start.Add(11*time.Minute)); err != nil || !session.Receives(true, start.Add(11*time.Minute)) {
+		t.Errorf("expected the downgraded session to be upgraded: %v", err)
+	}
+}
+
+func TestStreamSessionRefreshWithAnotherMember(t *testing.T) {
+	start := time.Date(2022, 5, 2, 10, 0, 0, 0, time.UTC)
+	session := NewStreamSession("ID1", StreamAuthJWT, start.Add(2*time.Minute))
+
+	if err := session.Refresh("ID2", start.Add(time.Hour), start.Add(90*time.Second)); !errors.Is(err, ErrStreamAuthMemberMismatch) {
+		t.Fatalf("expected the token of another member to be refused, got %v", err)
+	}
+
+	if !session.Closed || session.Receives(false, start.Add(90*time.Second)) || session.Receives(true, start.Add(90*time.Second)) {
+		t.Errorf("expected the session to be closed")
+	}
+
+	if err := session.Refresh("ID1", start.Add(time.Hour), start.Add(100*time.Second)); !errors.Is(err, ErrStreamSessionClosed) {
+		t.Errorf("expected a closed session to stay closed, got %v", err)
+	}
+}
+
+func TestStreamSessionAPIKeyExempt(t *testing.T) {
+	start := time.Date(2022, 5, 2, 10, 0, 0, 0, time.UTC)
+	session := NewStreamSession("ID1", StreamAuthAPIKey, time.Time{})
+
+	notices, sent := deliver(session, start, orderUpdates())
+	if len(notices) != 0 || len(sent) != len(orderUpdates()) {
+		t.Errorf("expected an API key session to receive everything without notices, got %v and %d events", notices, len(sent))
+	}
+}
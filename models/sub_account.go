@@ -0,0 +1,152 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// MaxSubAccounts is the number of sub-accounts a member can own.
+const MaxSubAccounts = 20
+
+var (
+	ErrSubAccountLimit  = errors.New("account.sub_account.limit_reached")
+	ErrNestedSubAccount = errors.New("account.sub_account.nested")
+)
+
+// SubAccountAudit records an action taken by a parent member on one of its sub-accounts.
+type SubAccountAudit struct {
+	ID           int64     `json:"id" gorm:"primaryKey"`
+	ParentID     int64     `json:"parent_id"`
+	SubAccountID int64     `json:"sub_account_id"`
+	Action       string    `json:"action"`
+	Data         string    `json:"data"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (m *Member) IsSubAccount() bool {
+	return m.ParentID.Valid
+}
+
+func (m *Member) Parent() *Member {
+	if !m.ParentID.Valid {
+		return nil
+	}
+
+	var parent *Member
+	if result := config.DataBase.First(&parent, m.ParentID.Int64); result.Error != nil {
+		return nil
+	}
+
+	return parent
+}
+
+func (m *Member) SubAccounts() []*Member {
+	sub_accounts := make([]*Member, 0)
+
+	config.DataBase.Order("id asc").Find(&sub_accounts, "parent_id = ?", m.ID)
+
+	return sub_accounts
+}
+
+// GetSubAccount returns the sub-account of the member with the given UID, or nil when the member doesn't own it.
+func (m *Member) GetSubAccount(uid string) *Member {
+	var sub_account *Member
+
+	if result := config.DataBase.First(&sub_account, "uid = ? AND parent_id = ?", uid, m.ID); result.Error != nil {
+		return nil
+	}
+
+	return sub_account
+}
+
+// CreateSubAccount creates a member owned by m, it inherits the level and the group of its parent. The row of m is
+// locked for the update while its sub-accounts are counted, so concurrent creations can't go past MaxSubAccounts.
+func (m *Member) CreateSubAccount(label string) (*Member, error) {
+	if m.IsSubAccount() {
+		return nil, ErrNestedSubAccount
+	}
+
+	uid, err := generateSubAccountUID()
+	if err != nil {
+		return nil, err
+	}
+
+	sub_account := &Member{
+		UID:         uid,
+		Email:       subAccountEmail(m.Email, uid),
+		Level:       m.Level,
+		Role:        "member",
+		Group:       m.Group,
+		State:       m.State,
+		MarketGroup: m.MarketGroup,
+		Username:    sql.NullString{String: label, Valid: len(label) > 0},
+		ParentID:    sql.NullInt64{Int64: m.ID, Valid: true},
+	}
+
+	err = config.DataBase.Transaction(func(tx *gorm.DB) error {
+		var parent *Member
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "members"}}).Where("id = ?", m.ID).First(&parent); result.Error != nil {
+			return result.Error
+		}
+
+		var count int64
+		if result := tx.Model(&Member{}).Where("parent_id = ?", m.ID).Count(&count); result.Error != nil {
+			return result.Error
+		}
+
+		if count >= MaxSubAccounts {
+			return ErrSubAccountLimit
+		}
+
+		return tx.Create(&sub_account).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	RecordSubAccountAudit(m, sub_account, "create", label)
+
+	return sub_account, nil
+}
+
+func RecordSubAccountAudit(parent, sub_account *Member, action, data string) {
+	audit := &SubAccountAudit{
+		ParentID:     parent.ID,
+		SubAccountID: sub_account.ID,
+		Action:       action,
+		Data:         data,
+	}
+
+	if result := config.DataBase.Create(&audit); result.Error != nil {
+		config.Logger.Errorf("Failed to record sub-account audit of member %d: %v", parent.ID, result.Error)
+	}
+}
+
+func generateSubAccountUID() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return "SA" + strings.ToUpper(hex.EncodeToString(b)), nil
+}
+
+// subAccountEmail tags the parent email with the sub-account uid so emails stay unique.
+func subAccountEmail(email, uid string) string {
+	local, domain, found := strings.Cut(email, "@")
+	if !found {
+		return strings.ToLower(uid) + "@" + email
+	}
+
+	return local + "+" + strings.ToLower(uid) + "@" + domain
+}
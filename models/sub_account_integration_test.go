@@ -0,0 +1,110 @@
+//go:build integration
+
+package models
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// The tests need the DATABASE_* variables of a disposable database:
+//
+//	go test -tags integration -run 'SubAccount' ./models
+func setupSubAccountDatabase(t *testing.T) *Member {
+	if len(os.Getenv("DATABASE_HOST")) == 0 {
+		t.Skip("DATABASE_HOST isn't set")
+	}
+
+	db, err := config.NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&Member{}, &Account{}, &Currency{}, &Transfer{}, &Liability{}, &OperationsAccount{}, &SubAccountAudit{}); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Where("parent_id = ?", 91).Delete(&Member{})
+	db.Where("id = ?", 91).Delete(&Member{})
+	db.Where("member_id = ?", 91).Delete(&Account{})
+	db.Where("from_member_id = ? OR to_member_id = ?", 91, 91).Delete(&Transfer{})
+	db.Where("member_id = ?", 91).Delete(&Liability{})
+
+	parent := &Member{ID: 91, UID: "ID91", Email: "parent91@example.com"}
+	if err := db.Create(parent).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	config.DataBase = db
+
+	return parent
+}
+
+// Creations of the same parent at once never go past MaxSubAccounts.
+func TestCreateSubAccountConcurrentLimit(t *testing.T) {
+	parent := setupSubAccountDatabase(t)
+
+	var created, limited int64
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < MaxSubAccounts+10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := parent.CreateSubAccount("")
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			switch {
+			case err == nil:
+				created++
+			case errors.Is(err, ErrSubAccountLimit):
+				limited++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != MaxSubAccounts || limited != 10 {
+		t.Errorf("expected %d sub-accounts created and 10 refused, got %d and %d", MaxSubAccounts, created, limited)
+	}
+}
+
+// A transfer the balance doesn't cover leaves neither the transfer nor entries of the ledger behind.
+func TestCreateTransferRollsBackTheLedger(t *testing.T) {
+	parent := setupSubAccountDatabase(t)
+	db := config.DataBase
+
+	sub_account, err := parent.CreateSubAccount("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	currency := &Currency{ID: "usdt", Type: "coin"}
+	db.Where("id = ?", currency.ID).FirstOrCreate(currency)
+	db.Create(&Account{MemberID: parent.ID, CurrencyID: currency.ID, Balance: decimal.NewFromInt(10)})
+
+	if _, err := CreateTransfer(TransferKindSubAccount, parent, sub_account, currency, decimal.NewFromInt(4)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreateTransfer(TransferKindSubAccount, parent, sub_account, currency, decimal.NewFromInt(7)); err == nil {
+		t.Fatal("expected the transfer past the balance to fail")
+	}
+
+	var transfers, entries int64
+	db.Model(&Transfer{}).Where("from_member_id = ?", parent.ID).Count(&transfers)
+	db.Model(&Liability{}).Where("member_id IN ? AND reference_type = ?", []int64{parent.ID, sub_account.ID}, "Transfer").Count(&entries)
+	if transfers != 1 || entries != 2 {
+		t.Errorf("expected the entries of the first transfer only, got %d transfers and %d entries", transfers, entries)
+	}
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSubAccountEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		uid   string
+		want  string
+	}{
+		{"business@zsmart.tech", "SA0A1B2C3D4E", "business+sa0a1b2c3d4e@zsmart.tech"},
+		{"invalid", "SA0A1B2C3D4E", "sa0a1b2c3d4e@invalid"},
+	}
+
+	for _, tt := range tests {
+		if got := subAccountEmail(tt.email, tt.uid); got != tt.want {
+			t.Errorf("subAccountEmail(%s, %s) = %s, want %s", tt.email, tt.uid, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateSubAccountUID(t *testing.T) {
+	uid, err := generateSubAccountUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(uid) != 12 || !strings.HasPrefix(uid, "SA") || strings.ToUpper(uid) != uid {
+		t.Errorf("unexpected uid %s", uid)
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"database/sql"
 	"encoding/json"
 	"os"
 	"sort"
@@ -11,6 +12,7 @@ import (
 	"github.com/zsmartex/finex/config"
 	api_admin_entities "github.com/zsmartex/finex/controllers/admin_controllers/entities"
 	api_entities "github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/decimalutil"
 	"github.com/zsmartex/finex/types"
 	"github.com/zsmartex/pkg"
 	"gorm.io/gorm"
@@ -23,12 +25,20 @@ type Trade struct {
 	Total        decimal.Decimal `json:"total" validate:"ValidateTotal"`
 	MakerOrderID int64           `json:"maker_order_id"`
 	TakerOrderID int64           `json:"taker_order_id"`
-	MarketID     string          `json:"market_id"`
+	MarketID     string          `json:"market_id" gorm:"uniqueIndex:index_trades_on_market_id_and_trade_sequence,where:trade_sequence > 0"`
 	MakerID      int64           `json:"maker_id"`
 	TakerID      int64           `json:"taker_id"`
 	TakerType    types.TakerType `json:"taker_type"`
-	CreatedAt    time.Time       `json:"created_at"`
-	UpdatedAt    time.Time       `json:"updated_at"`
+	// ConfigVersion is the version of the configuration of the market the engine matched the trade with,
+	// zero for the trades matched before it was versioned or published by an engine on trade events before v4
+	ConfigVersion int64 `json:"config_version" gorm:"default:0"`
+	// TradeSequence is the sequence the engine numbered the trade with in its market, a redelivered trade event finds
+	// its trade by it. Zero for the trades published on trade events before v6
+	TradeSequence int64 `json:"trade_sequence" gorm:"uniqueIndex:index_trades_on_market_id_and_trade_sequence;default:0"`
+	// RevertedAt is set when an admin reverted the trade
+	RevertedAt sql.NullTime `json:"reverted_at"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
 }
 
 func (t Trade) ValidatePrice(Price decimal.Decimal) bool {
@@ -51,6 +61,15 @@ func (t *Trade) Market() *Market {
 	return market
 }
 
+// LastTradeSequence returns the greatest sequence of the trades of market, zero when none was numbered.
+func LastTradeSequence(market_id string) int64 {
+	var sequence int64
+
+	config.DataBase.Model(&Trade{}).Where("market_id = ?", market_id).Select("COALESCE(MAX(trade_sequence), 0)").Scan(&sequence)
+
+	return sequence
+}
+
 func (t *Trade) Maker() *Member {
 	var member *Member
 
@@ -166,22 +185,25 @@ func (t *Trade) RecordCompleteOperations(seller_matching_order, buyer_matching_o
 		Type: "Trade",
 	}
 
-	t.RecordLiabilityDebit(seller_order, buyer_order, is_seller_fake, is_buyer_fake, reference)
-	t.RecordLiabilityCredit(seller_order, buyer_order, is_seller_fake, is_buyer_fake, reference)
-	t.RecordLiabilityTransfer(seller_order, buyer_order, is_seller_fake, is_buyer_fake, reference)
-
-	seller_fee := decimal.Zero
-	buyer_fee := decimal.Zero
+	// the fees are rounded once, the income, the revenues and the referral rewards are booked from them
+	var seller_fee, buyer_fee Rounded
 
 	if !is_seller_fake {
-		seller_fee = t.Total.Mul(t.OrderFee(seller_order))
+		seller_fee = t.Fee(seller_order)
 	}
 
 	if !is_buyer_fake {
-		buyer_fee = t.Amount.Mul(t.OrderFee(buyer_order))
+		buyer_fee = t.Fee(buyer_order)
 	}
 
-	s_fee, b_fee, err := t.RecordReferrals(
+	t.RecordLiabilityDebit(seller_order, buyer_order, is_seller_fake, is_buyer_fake, reference)
+	t.RecordLiabilityCredit(seller_fee, buyer_fee, seller_order, buyer_order, is_seller_fake, is_buyer_fake, reference)
+	t.RecordLiabilityTransfer(seller_order, buyer_order, is_seller_fake, is_buyer_fake, reference)
+
+	// the fees are credited in full, the referral rewards paid from them are debited
+	t.RecordRevenues(seller_fee, buyer_fee, seller_order, buyer_order, is_seller_fake, is_buyer_fake, reference, tx)
+
+	_, _, err := t.RecordReferrals(
 		seller_fee,
 		buyer_fee,
 		seller_order,
@@ -192,16 +214,7 @@ func (t *Trade) RecordCompleteOperations(seller_matching_order, buyer_matching_o
 		tx,
 	)
 
-	if err != nil {
-		return err
-	}
-
-	seller_fee = s_fee
-	buyer_fee = b_fee
-
-	t.RecordRevenues(seller_fee, buyer_fee, seller_order, buyer_order, is_seller_fake, is_buyer_fake, reference, tx)
-
-	return nil
+	return err
 }
 
 func (t *Trade) RecordLiabilityDebit(seller_order, buyer_order *Order, is_seller_fake, is_buyer_fake bool, reference Reference) {
@@ -229,9 +242,9 @@ func (t *Trade) RecordLiabilityDebit(seller_order, buyer_order *Order, is_seller
 	}
 }
 
-func (t *Trade) RecordLiabilityCredit(seller_order, buyer_order *Order, is_seller_fake, is_buyer_fake bool, reference Reference) {
+func (t *Trade) RecordLiabilityCredit(seller_fee, buyer_fee Rounded, seller_order, buyer_order *Order, is_seller_fake, is_buyer_fake bool, reference Reference) {
 	if !is_seller_fake {
-		seller_income := t.Total.Sub(t.Total.Mul(t.OrderFee(t.SellerOrder())))
+		seller_income := t.Total.Sub(seller_fee.Value)
 		LiabilityDebit(
 			seller_income,
 			seller_order.IncomeCurrency(),
@@ -242,7 +255,7 @@ func (t *Trade) RecordLiabilityCredit(seller_order, buyer_order *Order, is_selle
 	}
 
 	if !is_buyer_fake {
-		buyer_income := t.Amount.Sub(t.Amount.Mul(t.OrderFee(t.BuyerOrder())))
+		buyer_income := t.Amount.Sub(buyer_fee.Value)
 		LiabilityDebit(
 			buyer_income,
 			buyer_order.IncomeCurrency(),
@@ -282,7 +295,7 @@ func (t *Trade) RecordLiabilityTransfer(seller_order, buyer_order *Order, is_sel
 	}
 }
 
-func (t *Trade) RecordReferrals(seller_fee, buyer_fee decimal.Decimal, seller_order, buyer_order *Order, is_seller_fake, is_buyer_fake bool, reference Reference, tx *gorm.DB) (decimal.Decimal, decimal.Decimal, error) {
+func (t *Trade) RecordReferrals(seller_fee, buyer_fee Rounded, seller_order, buyer_order *Order, is_seller_fake, is_buyer_fake bool, reference Reference, tx *gorm.DB) (Rounded, Rounded, error) {
 	if !config.Referral.Enabled {
 		return seller_fee, buyer_fee, nil
 	}
@@ -293,101 +306,178 @@ func (t *Trade) RecordReferrals(seller_fee, buyer_fee decimal.Decimal, seller_or
 	var refCurrency *Currency
 	config.DataBase.First(&refCurrency, "id = ?", strings.ToLower(config.Referral.Currency))
 
-	for _, reward := range config.Referral.Rewards {
-		if !is_seller_fake && seller_fee.IsPositive() {
-			member := seller_order.Member()
-			if !member.HavingReferraller() {
-				break
-			}
+	if !is_seller_fake && seller_fee.Value.IsPositive() {
+		fee, err := t.recordReferral(seller_fee, seller_order, refCurrency, tx)
+		if err != nil {
+			return seller_fee, buyer_fee, err
+		}
 
-			refMember := member.GetRefMember()
-			refHoldAccount := refMember.GetAccount(refCurrency)
-
-			if refHoldAccount.Balance.GreaterThanOrEqual(reward.HoldAmount) {
-				reward_amount := seller_fee.Mul(reward.Reward).Round(8)
-				if err := refMember.GetAccount(seller_order.IncomeCurrency()).PlusFunds(tx, reward_amount); err != nil {
-					return seller_fee, buyer_fee, err
-				}
-				seller_fee = seller_fee.Sub(reward_amount)
-
-				if result := tx.Create(
-					&Commission{
-						AccountType:     "spot",
-						MemberID:        refMember.ID,
-						FriendUID:       member.UID,
-						EarnAmount:      reward_amount,
-						CurrencyID:      seller_order.IncomeCurrency().ID,
-						ParentID:        t.ID,
-						ParentCreatedAt: t.CreatedAt,
-					},
-				); result.Error != nil {
-					return seller_fee, buyer_fee, result.Error
-				}
-
-				break
-			}
+		fee, err = t.recordKickback(fee, seller_fee.Exact, seller_order, tx)
+		if err != nil {
+			return seller_fee, buyer_fee, err
+		}
+		seller_fee = fee
+	}
+
+	if !is_buyer_fake && buyer_fee.Value.IsPositive() {
+		fee, err := t.recordReferral(buyer_fee, buyer_order, refCurrency, tx)
+		if err != nil {
+			return seller_fee, buyer_fee, err
 		}
+
+		fee, err = t.recordKickback(fee, buyer_fee.Exact, buyer_order, tx)
+		if err != nil {
+			return seller_fee, buyer_fee, err
+		}
+		buyer_fee = fee
+	}
+
+	return seller_fee, buyer_fee, nil
+}
+
+// recordReferral pays the referrer of the order member its reward on the fee and returns what's left of the fee.
+// When the member signed up with a referral code, the reward is split with the member as a fee discount.
+// The reward is computed from the exact fee, what's left of the fee keeps the rounding of the fee only.
+func (t *Trade) recordReferral(fee Rounded, order *Order, refCurrency *Currency, tx *gorm.DB) (Rounded, error) {
+	member := order.Member()
+	if !member.HavingReferraller() {
+		return fee, nil
 	}
 
+	// friends referred by UID are attributed to the default code of their referrer on their first trade
+	if !member.ReferralCodeID.Valid {
+		member.AttributeReferralCode("")
+	}
+
+	referral_code := member.ReferralCode()
+	if referral_code != nil && !referral_code.Active() {
+		return fee, nil
+	}
+
+	refMember := member.GetRefMember()
+	if refMember == nil {
+		return fee, nil
+	}
+	refHoldAccount := refMember.GetAccount(refCurrency)
+
 	for _, reward := range config.Referral.Rewards {
-		if !is_buyer_fake && buyer_fee.IsPositive() {
-			member := buyer_order.Member()
-			if !member.HavingReferraller() {
-				break
+		if refHoldAccount.Balance.LessThan(reward.HoldAmount) {
+			continue
+		}
+
+		reward_amount := RoundHalfUp(fee.Exact.Mul(reward.Reward), referralRewardPrecision)
+		earn_amount := reward_amount
+		discount := Rounded{}
+		referral_code_id := sql.NullInt64{}
+		if referral_code != nil {
+			earn_amount, discount = referral_code.SplitRounded(reward_amount)
+			referral_code_id = sql.NullInt64{Int64: referral_code.ID, Valid: true}
+		}
+
+		created, err := createCommission(tx, &Commission{
+			AccountType:     "spot",
+			MemberID:        refMember.ID,
+			FriendUID:       member.UID,
+			EarnAmount:      earn_amount.Value,
+			CurrencyID:      order.IncomeCurrency().ID,
+			ParentID:        t.ID,
+			ParentCreatedAt: t.CreatedAt,
+			Level:           CommissionLevelDirect,
+			ReferralCodeID:  referral_code_id,
+			Source:          CommissionSourceTrade,
+			FeeAmount:       decimal.NewNullDecimal(fee.Value),
+			FeeExact:        decimal.NewNullDecimal(fee.Exact),
+			Rate:            decimal.NewNullDecimal(reward.Reward),
+			RoundingAudit:   NewRoundingAudit(earn_amount, RoundingPathReferralCommission),
+		})
+		if err != nil {
+			return fee, err
+		}
+
+		// the referral of a trade executed again was paid the first time, with its discount
+		if !created {
+			return fee.Less(reward_amount.Value), nil
+		}
+
+		if err := refMember.GetAccount(order.IncomeCurrency()).PlusFunds(tx, earn_amount.Value); err != nil {
+			return fee, err
+		}
+
+		if err := RevenueFeeDebit(tx, RevenueKindReferralCommission, earn_amount.Value, order.IncomeCurrency(), t, order.MemberID); err != nil {
+			return fee, err
+		}
+
+		if discount.Value.IsPositive() {
+			if err := member.GetAccount(order.IncomeCurrency()).PlusFunds(tx, discount.Value); err != nil {
+				return fee, err
+			}
+
+			if err := RevenueFeeDebit(tx, RevenueKindRebate, discount.Value, order.IncomeCurrency(), t, order.MemberID); err != nil {
+				return fee, err
 			}
 
-			refMember := member.GetRefMember()
-			refHoldAccount := refMember.GetAccount(refCurrency)
-
-			if refHoldAccount.Balance.GreaterThanOrEqual(reward.HoldAmount) {
-				reward_amount := buyer_fee.Mul(reward.Reward).Round(8)
-				if err := refMember.GetAccount(buyer_order.IncomeCurrency()).PlusFunds(tx, reward_amount); err != nil {
-					return seller_fee, buyer_fee, err
-				}
-				buyer_fee = buyer_fee.Sub(reward_amount)
-
-				if result := tx.Create(
-					&Commission{
-						AccountType:     "spot",
-						MemberID:        refMember.ID,
-						FriendUID:       member.UID,
-						EarnAmount:      reward_amount,
-						CurrencyID:      buyer_order.IncomeCurrency().ID,
-						ParentID:        t.ID,
-						ParentCreatedAt: t.CreatedAt,
-					},
-				); result.Error != nil {
-					return seller_fee, buyer_fee, result.Error
-				}
-
-				break
+			if result := tx.Create(
+				&FeeDiscount{
+					MemberID:       member.ID,
+					ReferralCodeID: referral_code.ID,
+					TradeID:        t.ID,
+					CurrencyID:     order.IncomeCurrency().ID,
+					Amount:         discount.Value,
+					RoundingAudit:  NewRoundingAudit(discount, RoundingPathReferralDiscount),
+				},
+			); result.Error != nil {
+				return fee, result.Error
 			}
 		}
+
+		return fee.Less(reward_amount.Value), nil
 	}
 
-	return seller_fee, buyer_fee, nil
+	return fee, nil
 }
 
-func (t *Trade) RecordRevenues(seller_fee, buyer_fee decimal.Decimal, seller_order, buyer_order *Order, is_seller_fake, is_buyer_fake bool, reference Reference, tx *gorm.DB) {
-	if !is_seller_fake && seller_fee.IsPositive() {
-		RevenueCredit(
+func (t *Trade) RecordRevenues(seller_fee, buyer_fee Rounded, seller_order, buyer_order *Order, is_seller_fake, is_buyer_fake bool, reference Reference, tx *gorm.DB) {
+	if !is_seller_fake && seller_fee.Value.IsPositive() {
+		RevenueFeeCredit(
 			seller_fee,
 			seller_order.IncomeCurrency(),
-			reference,
+			t,
 			seller_order.MemberID,
 		)
 	}
 
-	if !is_buyer_fake && buyer_fee.IsPositive() {
-		RevenueCredit(
+	if !is_buyer_fake && buyer_fee.Value.IsPositive() {
+		RevenueFeeCredit(
 			buyer_fee,
 			buyer_order.IncomeCurrency(),
-			reference,
+			t,
 			buyer_order.MemberID,
 		)
 	}
 }
 
+// Leg returns what the trade took from and gave to the member of order, the fee is taken from the income.
+func (t *Trade) Leg(order *Order) (outcome, income, fee decimal.Decimal) {
+	if order.Type == SideSell {
+		outcome = t.Amount
+		income = t.Total
+	} else {
+		outcome = t.Total
+		income = t.Amount
+	}
+
+	return outcome, income, income.Mul(t.OrderFee(order))
+}
+
+// Fee returns the fee the member of order pays on the trade, rounded to the scale its income is booked at.
+func (t *Trade) Fee(order *Order) Rounded {
+	_, income, _ := t.Leg(order)
+
+	exact := income.Mul(t.OrderFee(order))
+
+	return Rounded{Exact: exact, Value: decimalutil.RoundFee(exact, SchemaDecimalScale), Mode: RoundingModeHalfUp}
+}
+
 func (t *Trade) OrderFee(order *Order) decimal.Decimal {
 	if int64(t.MakerOrderID) == order.ID {
 		return order.MakerFee
@@ -442,8 +532,10 @@ func (t *Trade) ForUser(member *Member) api_entities.TradeEntity {
 		Fee:         t.OrderFee(order),
 		FeeAmount:   fee_amount,
 		TakerType:   t.TakerType,
+		TakerSide:   t.TakerType,
 		Side:        side,
 		OrderID:     t.ID,
+		ClientID:    order.ClientID,
 		CreatedAt:   t.CreatedAt,
 	}
 }
@@ -544,6 +636,8 @@ func (t *Trade) ToJSON() api_admin_entities.TradeEntity {
 		TakerFee:         taker_fee,
 		TakerFeeAmount:   taker_fee_amount,
 		TakerFeeCurrency: taker_fee_currency,
+		ConfigVersion:    t.ConfigVersion,
+		RevertedAt:       t.RevertedAt,
 		CreatedAt:        t.CreatedAt,
 		UpdatedAt:        t.UpdatedAt,
 	}
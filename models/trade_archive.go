@@ -0,0 +1,206 @@
+package models
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TradeArchiveDateLayout is the layout of the date of a daily trade archive, days are in UTC.
+const TradeArchiveDateLayout = "2006-01-02"
+
+// tradeArchiveChunk is the number of trades read at once while writing an archive.
+const tradeArchiveChunk = 5000
+
+var (
+	ErrTradeArchiveDate     = errors.New("public.trade_archive.invalid_date")
+	ErrTradeArchiveNotFound = errors.New("public.trade_archive.not_found")
+)
+
+var TradeArchiveHeader = []string{"id", "price", "amount", "total", "taker_type", "created_at"}
+
+// ParseTradeArchiveDate parses the date of an archive, days which aren't over at now have no archive yet.
+func ParseTradeArchiveDate(date string, now time.Time) (time.Time, error) {
+	day, err := time.Parse(TradeArchiveDateLayout, date)
+	if err != nil || day.AddDate(0, 0, 1).After(now) {
+		return time.Time{}, ErrTradeArchiveDate
+	}
+
+	return day, nil
+}
+
+// TradeArchiveName is the name of the archive of the trades of market on day in the report bucket.
+func TradeArchiveName(market string, day time.Time) string {
+	return path.Join("trades", market, day.Format(TradeArchiveDateLayout)+".csv.gz")
+}
+
+func tradeArchiveRecord(trade *Trade) []string {
+	return []string{
+		strconv.FormatInt(trade.ID, 10),
+		trade.Price.String(),
+		trade.Amount.String(),
+		trade.Total.String(),
+		string(trade.TakerType),
+		trade.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// dayTrades scopes a query to the trades of market on day which weren't reverted.
+func dayTrades(tx *gorm.DB, market string, day time.Time) *gorm.DB {
+	return tx.
+		Model(&Trade{}).
+		Where("market_id = ? AND created_at >= ? AND created_at < ? AND reverted_at IS NULL", market, day, day.AddDate(0, 0, 1))
+}
+
+// WriteTradeArchive writes the trades of market on day as CSV in id order and returns how many there were.
+func WriteTradeArchive(tx *gorm.DB, w io.Writer, market string, day time.Time) (int64, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(TradeArchiveHeader); err != nil {
+		return 0, err
+	}
+
+	var count, after_id int64
+	for {
+		var trades []*Trade
+		if result := dayTrades(tx, market, day).Where("id > ?", after_id).Order("id asc").Limit(tradeArchiveChunk).Find(&trades); result.Error != nil {
+			return count, result.Error
+		}
+
+		if len(trades) == 0 {
+			break
+		}
+
+		for _, trade := range trades {
+			if err := writer.Write(tradeArchiveRecord(trade)); err != nil {
+				return count, err
+			}
+		}
+
+		count += int64(len(trades))
+		after_id = trades[len(trades)-1].ID
+	}
+
+	writer.Flush()
+
+	return count, writer.Error()
+}
+
+// GenerateTradeArchive writes the gzipped archive of the trades of market on day to the report bucket. An archive
+// already written is kept and days without trades get none, so the job can run again over the same days.
+func GenerateTradeArchive(tx *gorm.DB, market string, day time.Time) (generated bool, err error) {
+	if _, err := TradeArchiveSize(market, day); err == nil {
+		return false, nil
+	}
+
+	var trades int64
+	if result := dayTrades(tx, market, day).Count(&trades); result.Error != nil {
+		return false, result.Error
+	}
+
+	if trades == 0 {
+		return false, nil
+	}
+
+	_, err = writeStorageFile(TradeArchiveName(market, day), func(w io.Writer) error {
+		gz := gzip.NewWriter(w)
+		if _, err := WriteTradeArchive(tx, gz, market, day); err != nil {
+			return err
+		}
+
+		return gz.Close()
+	})
+
+	return err == nil, err
+}
+
+// TradeArchiveSize returns the size of the archive of market on day.
+func TradeArchiveSize(market string, day time.Time) (int64, error) {
+	location, err := storagePath(TradeArchiveName(market, day))
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(location)
+	if err != nil {
+		return 0, ErrTradeArchiveNotFound
+	}
+
+	return info.Size(), nil
+}
+
+// OpenTradeArchive opens the archive of market on day, the caller closes it.
+func OpenTradeArchive(market string, day time.Time) (*os.File, int64, error) {
+	location, err := storagePath(TradeArchiveName(market, day))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	file, err := os.Open(location)
+	if err != nil {
+		return nil, 0, ErrTradeArchiveNotFound
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return file, info.Size(), nil
+}
+
+// TradeArchiveDate is an archive available for download.
+type TradeArchiveDate struct {
+	Date string `json:"date"`
+	Size int64  `json:"size"`
+}
+
+// TradeArchiveDates lists the archives of market, the oldest first.
+func TradeArchiveDates(market string) ([]*TradeArchiveDate, error) {
+	location, err := storagePath(path.Join("trades", market))
+	if err != nil {
+		return nil, err
+	}
+
+	dates := make([]*TradeArchiveDate, 0)
+
+	entries, err := os.ReadDir(location)
+	if os.IsNotExist(err) {
+		return dates, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		date := strings.TrimSuffix(entry.Name(), ".csv.gz")
+		if entry.IsDir() || date == entry.Name() {
+			continue
+		}
+
+		if _, err := time.Parse(TradeArchiveDateLayout, date); err != nil {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		dates = append(dates, &TradeArchiveDate{Date: date, Size: info.Size()})
+	}
+
+	sort.Slice(dates, func(i, j int) bool {
+		return dates[i].Date < dates[j].Date
+	})
+
+	return dates, nil
+}
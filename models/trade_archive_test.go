@@ -0,0 +1,107 @@
+package models
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+func withReportStorage(t *testing.T) string {
+	storage := t.TempDir()
+
+	reports := config.Reports
+	config.Reports = &types.ReportsConfig{StoragePath: storage}
+	t.Cleanup(func() { config.Reports = reports })
+
+	return storage
+}
+
+func TestParseTradeArchiveDate(t *testing.T) {
+	now := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+
+	if day, err := ParseTradeArchiveDate("2024-05-01", now); err != nil || !day.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected yesterday to be archived, got (%s, %v)", day, err)
+	}
+
+	for _, date := range []string{"2024-05-02", "2024-05-03", "05/01/2024", "../../etc", ""} {
+		if _, err := ParseTradeArchiveDate(date, now); !errors.Is(err, ErrTradeArchiveDate) {
+			t.Errorf("expected %q to be refused, got %v", date, err)
+		}
+	}
+}
+
+func TestTradeArchiveDates(t *testing.T) {
+	storage := withReportStorage(t)
+
+	if dates, err := TradeArchiveDates("btcusdt"); err != nil || len(dates) != 0 {
+		t.Fatalf("expected no archive before the first run, got (%v, %v)", dates, err)
+	}
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for _, d := range []time.Time{day, day.AddDate(0, 0, -1)} {
+		if _, err := writeStorageFile(TradeArchiveName("btcusdt", d), func(w io.Writer) error {
+			_, err := w.Write([]byte("archive"))
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// an archive being written isn't listed
+	if err := os.WriteFile(filepath.Join(storage, "trades", "btcusdt", "2024-05-02.csv.gz.part"), []byte("half"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dates, err := TradeArchiveDates("btcusdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*TradeArchiveDate{{Date: "2024-04-30", Size: 7}, {Date: "2024-05-01", Size: 7}}
+	if !reflect.DeepEqual(dates, want) {
+		t.Errorf("got the dates %+v", dates)
+	}
+
+	if size, err := TradeArchiveSize("btcusdt", day); err != nil || size != 7 {
+		t.Errorf("got the size (%d, %v)", size, err)
+	}
+
+	if _, _, err := OpenTradeArchive("btcusdt", day.AddDate(0, 0, 1)); !errors.Is(err, ErrTradeArchiveNotFound) {
+		t.Errorf("expected no archive for a day which wasn't archived, got %v", err)
+	}
+}
+
+func TestGenerateTradeArchiveKeepsTheArchive(t *testing.T) {
+	withReportStorage(t)
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := writeStorageFile(TradeArchiveName("btcusdt", day), func(w io.Writer) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// the day is already archived, the trades aren't read again
+	if generated, err := GenerateTradeArchive(nil, "btcusdt", day); generated || err != nil {
+		t.Errorf("expected the archive to be kept, got (%t, %v)", generated, err)
+	}
+}
+
+func TestWriteStorageFileFailure(t *testing.T) {
+	storage := withReportStorage(t)
+
+	if _, err := writeStorageFile("trades/btcusdt/2024-05-01.csv.gz", func(w io.Writer) error {
+		return errors.New("database went away")
+	}); err == nil {
+		t.Fatal("expected the error of the writer")
+	}
+
+	if entries, _ := os.ReadDir(filepath.Join(storage, "trades", "btcusdt")); len(entries) != 0 {
+		t.Errorf("expected nothing left behind, got %d files", len(entries))
+	}
+}
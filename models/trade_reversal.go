@@ -0,0 +1,411 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/zsmartex/finex/config"
+)
+
+type TradeReversalState string
+
+var (
+	TradeReversalStatePending  TradeReversalState = "pending"
+	TradeReversalStateExecuted TradeReversalState = "executed"
+	TradeReversalStateRejected TradeReversalState = "rejected"
+)
+
+var (
+	ErrTradeReversalRequested    = errors.New("admin.trade_reversal.already_requested")
+	ErrTradeReversalReverted     = errors.New("admin.trade_reversal.trade_reverted")
+	ErrTradeReversalNotPending   = errors.New("admin.trade_reversal.not_pending")
+	ErrTradeReversalSameAdmin    = errors.New("admin.trade_reversal.same_admin")
+	ErrTradeReversalOrderOpen    = errors.New("admin.trade_reversal.order_open")
+	ErrTradeReversalInsufficient = errors.New("admin.trade_reversal.insufficient_balance")
+)
+
+// TradeReversal busts a trade. It's requested by an admin and executed once a second admin approved it.
+type TradeReversal struct {
+	ID          int64              `json:"id" gorm:"primaryKey"`
+	TradeID     int64              `json:"trade_id"`
+	Reason      string             `json:"reason"`
+	State       TradeReversalState `json:"state" gorm:"default:pending"`
+	RequestedBy int64              `json:"requested_by"`
+	ReviewedBy  sql.NullInt64      `json:"reviewed_by"`
+	ExecutedAt  sql.NullTime       `json:"executed_at"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+}
+
+// TradeReversalEntry is one change made by a reversal, the entries of a reversal are its audit trail.
+type TradeReversalEntry struct {
+	ID              int64           `json:"id" gorm:"primaryKey"`
+	TradeReversalID int64           `json:"trade_reversal_id"`
+	Kind            string          `json:"kind"`
+	ReferenceID     int64           `json:"reference_id"`
+	MemberID        int64           `json:"member_id"`
+	CurrencyID      string          `json:"currency_id"`
+	Amount          decimal.Decimal `json:"amount"`
+	Note            string          `json:"note"`
+	CreatedAt       time.Time       `json:"created_at"`
+}
+
+// RequestTradeReversal records the request of an admin to revert a trade, nothing changes until another admin approves it.
+func RequestTradeReversal(trade *Trade, admin *Member, reason string) (*TradeReversal, error) {
+	if trade.RevertedAt.Valid {
+		return nil, ErrTradeReversalReverted
+	}
+
+	var pending int64
+	config.DataBase.Model(&TradeReversal{}).Where("trade_id = ? AND state = ?", trade.ID, TradeReversalStatePending).Count(&pending)
+	if pending > 0 {
+		return nil, ErrTradeReversalRequested
+	}
+
+	reversal := &TradeReversal{
+		TradeID:     trade.ID,
+		Reason:      reason,
+		State:       TradeReversalStatePending,
+		RequestedBy: admin.ID,
+	}
+
+	if result := config.DataBase.Create(&reversal); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return reversal, nil
+}
+
+func (r *TradeReversal) Entries() []*TradeReversalEntry {
+	entries := make([]*TradeReversalEntry, 0)
+
+	config.DataBase.Order("id asc").Find(&entries, "trade_reversal_id = ?", r.ID)
+
+	return entries
+}
+
+func (r *TradeReversal) review(admin *Member) error {
+	if r.State != TradeReversalStatePending {
+		return ErrTradeReversalNotPending
+	}
+
+	if r.RequestedBy == admin.ID {
+		return ErrTradeReversalSameAdmin
+	}
+
+	return nil
+}
+
+func (r *TradeReversal) Reject(admin *Member) error {
+	if err := r.review(admin); err != nil {
+		return err
+	}
+
+	r.State = TradeReversalStateRejected
+	r.ReviewedBy = sql.NullInt64{Int64: admin.ID, Valid: true}
+
+	return config.DataBase.Save(r).Error
+}
+
+// Approve reverts the trade in one transaction: the balances of both members, the filled volume of the orders,
+// the fee discounts, the referral commissions, the fee kickbacks and the ledger operations of the trade.
+func (r *TradeReversal) Approve(admin *Member) error {
+	if err := r.review(admin); err != nil {
+		return err
+	}
+
+	return config.DataBase.Transaction(func(tx *gorm.DB) error {
+		reverser := &tradeReverser{tx: tx, reversal: r, now: time.Now()}
+
+		return reverser.revert(admin)
+	})
+}
+
+type tradeReverser struct {
+	tx       *gorm.DB
+	reversal *TradeReversal
+	now      time.Time
+}
+
+func (v *tradeReverser) record(kind string, reference_id, member_id int64, currency_id string, amount decimal.Decimal, note string) error {
+	return v.tx.Create(&TradeReversalEntry{
+		TradeReversalID: v.reversal.ID,
+		Kind:            kind,
+		ReferenceID:     reference_id,
+		MemberID:        member_id,
+		CurrencyID:      currency_id,
+		Amount:          amount,
+		Note:            note,
+	}).Error
+}
+
+func (v *tradeReverser) lockedAccount(member_id int64, currency_id string) *Account {
+	var account *Account
+
+	v.tx.
+		Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "accounts"}}).
+		Where("member_id = ? AND currency_id = ?", member_id, currency_id).
+		FirstOrCreate(&account)
+
+	return account
+}
+
+// subFunds takes funds back from a member, the reversal fails when the member already spent them.
+func (v *tradeReverser) subFunds(member_id int64, currency_id string, amount decimal.Decimal) error {
+	if !amount.IsPositive() {
+		return nil
+	}
+
+	if err := v.lockedAccount(member_id, currency_id).SubFunds(v.tx, amount); err != nil {
+		return fmt.Errorf("%w: %v", ErrTradeReversalInsufficient, err)
+	}
+
+	return nil
+}
+
+func (v *tradeReverser) plusFunds(member_id int64, currency_id string, amount decimal.Decimal) error {
+	if !amount.IsPositive() {
+		return nil
+	}
+
+	return v.lockedAccount(member_id, currency_id).PlusFunds(v.tx, amount)
+}
+
+func (v *tradeReverser) revert(admin *Member) error {
+	var trade *Trade
+	if result := v.tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "trades"}}).First(&trade, v.reversal.TradeID); result.Error != nil {
+		return result.Error
+	}
+
+	if trade.RevertedAt.Valid {
+		return ErrTradeReversalReverted
+	}
+
+	orders := make([]*Order, 0, 2)
+	for _, id := range []int64{trade.MakerOrderID, trade.TakerOrderID} {
+		var order *Order
+		// orders of the liquidity provider aren't stored
+		if result := v.tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "orders"}}).Where("id = ?", id).First(&order); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			continue
+		}
+
+		if order.State == StateWait || order.State == StatePending {
+			return ErrTradeReversalOrderOpen
+		}
+
+		orders = append(orders, order)
+	}
+
+	for _, order := range orders {
+		if err := v.revertLeg(trade, order); err != nil {
+			return err
+		}
+	}
+
+	if err := v.revertFeeDiscounts(trade); err != nil {
+		return err
+	}
+
+	if err := v.clawbackCommissions(trade); err != nil {
+		return err
+	}
+
+	if err := v.voidFeeKickbacks(trade); err != nil {
+		return err
+	}
+
+	if err := v.mirrorOperations(trade); err != nil {
+		return err
+	}
+
+	trade.RevertedAt = sql.NullTime{Time: v.now, Valid: true}
+	if result := v.tx.Model(trade).Update("reverted_at", trade.RevertedAt); result.Error != nil {
+		return result.Error
+	}
+
+	v.reversal.State = TradeReversalStateExecuted
+	v.reversal.ReviewedBy = sql.NullInt64{Int64: admin.ID, Valid: true}
+	v.reversal.ExecutedAt = sql.NullTime{Time: v.now, Valid: true}
+
+	return v.tx.Save(v.reversal).Error
+}
+
+// revertLeg gives the member of order back what it paid and takes back what it received net of the fee.
+// The reverted volume isn't offered again, a done order becomes cancelled.
+func (v *tradeReverser) revertLeg(trade *Trade, order *Order) error {
+	outcome, income, fee := trade.Leg(order)
+	real_income := income.Sub(fee)
+
+	income_currency := order.IncomeCurrency().ID
+	outcome_currency := order.OutcomeCurrency().ID
+
+	if err := v.subFunds(order.MemberID, income_currency, real_income); err != nil {
+		return err
+	}
+
+	if err := v.record("account", order.ID, order.MemberID, income_currency, real_income.Neg(), "income taken back"); err != nil {
+		return err
+	}
+
+	if err := v.plusFunds(order.MemberID, outcome_currency, outcome); err != nil {
+		return err
+	}
+
+	if err := v.record("account", order.ID, order.MemberID, outcome_currency, outcome, "outcome given back"); err != nil {
+		return err
+	}
+
+	if err := v.record("fee", order.ID, order.MemberID, income_currency, fee.Neg(), "fee refunded"); err != nil {
+		return err
+	}
+
+	order.Volume = order.Volume.Add(trade.Amount)
+	order.FundsReceived = order.FundsReceived.Sub(income)
+	order.RevertFill(trade.Total, int32(order.Market().PricePrecision))
+	order.TradesCount--
+	if order.State == StateDone {
+		order.State = StateCancel
+	}
+
+	if result := v.tx.Save(order); result.Error != nil {
+		return result.Error
+	}
+
+	return v.record("order", order.ID, order.MemberID, "", trade.Amount.Neg(), "filled volume reverted")
+}
+
+func (v *tradeReverser) revertFeeDiscounts(trade *Trade) error {
+	var discounts []*FeeDiscount
+	v.tx.Find(&discounts, "trade_id = ?", trade.ID)
+
+	for _, discount := range discounts {
+		if err := v.subFunds(discount.MemberID, discount.CurrencyID, discount.Amount); err != nil {
+			return err
+		}
+
+		if result := v.tx.Delete(discount); result.Error != nil {
+			return result.Error
+		}
+
+		if err := v.record("fee_discount", discount.ID, discount.MemberID, discount.CurrencyID, discount.Amount.Neg(), "fee discount taken back"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// clawbackCommissions voids the referral commissions of the trade and takes them back from the referrers.
+// Commissions the release job already counted are taken out of the next release with an adjustment.
+func (v *tradeReverser) clawbackCommissions(trade *Trade) error {
+	var commissions []*Commission
+	v.tx.
+		Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "commissions"}}).
+		Where("parent_id = ? AND state = ?", trade.ID, CommissionStateActive).
+		Find(&commissions)
+
+	for _, commission := range commissions {
+		if err := v.subFunds(commission.MemberID, commission.CurrencyID, commission.EarnAmount); err != nil {
+			return err
+		}
+
+		commission.State = CommissionStateVoid
+		commission.VoidedAt = sql.NullTime{Time: v.now, Valid: true}
+		if result := v.tx.Save(commission); result.Error != nil {
+			return result.Error
+		}
+
+		note := "commission voided before its release"
+		if CommissionReleased(commission.CreatedAt, v.now) {
+			note = "released commission voided, adjusted on the next release"
+		}
+
+		if err := v.record("commission", commission.ID, commission.MemberID, commission.CurrencyID, commission.EarnAmount.Neg(), note); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// voidFeeKickbacks voids the fee kickbacks of the trade and takes them back from the friends, like the commissions.
+func (v *tradeReverser) voidFeeKickbacks(trade *Trade) error {
+	var kickbacks []*FeeKickback
+	v.tx.
+		Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "fee_kickbacks"}}).
+		Where("trade_id = ? AND state = ?", trade.ID, CommissionStateActive).
+		Find(&kickbacks)
+
+	for _, kickback := range kickbacks {
+		if err := v.subFunds(kickback.MemberID, kickback.CurrencyID, kickback.Amount); err != nil {
+			return err
+		}
+
+		kickback.State = CommissionStateVoid
+		kickback.VoidedAt = sql.NullTime{Time: v.now, Valid: true}
+		if result := v.tx.Save(kickback); result.Error != nil {
+			return result.Error
+		}
+
+		if err := v.record("fee_kickback", kickback.ID, kickback.MemberID, kickback.CurrencyID, kickback.Amount.Neg(), releasedKickbackNote(kickback, v.now)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mirrorOperations writes the opposite of every ledger operation of the trade, referenced to the reversal.
+func (v *tradeReverser) mirrorOperations(trade *Trade) error {
+	var liabilities []*Liability
+	v.tx.Find(&liabilities, "reference_type = ? AND reference_id = ?", "Trade", trade.ID)
+
+	for _, liability := range liabilities {
+		if result := v.tx.Create(&Liability{
+			Code:          liability.Code,
+			CurrencyID:    liability.CurrencyID,
+			MemberID:      liability.MemberID,
+			ReferenceType: "TradeReversal",
+			ReferenceID:   v.reversal.ID,
+			Debit:         liability.Credit,
+			Credit:        liability.Debit,
+		}); result.Error != nil {
+			return result.Error
+		}
+
+		if err := v.record("liability", liability.ID, liability.MemberID, liability.CurrencyID, liability.Debit.Sub(liability.Credit).Neg(), "liability mirrored"); err != nil {
+			return err
+		}
+	}
+
+	var revenues []*Revenue
+	v.tx.Find(&revenues, "reference_type = ? AND reference_id = ?", "Trade", trade.ID)
+
+	for _, revenue := range revenues {
+		if result := v.tx.Create(&Revenue{
+			Code:          revenue.Code,
+			CurrencyID:    revenue.CurrencyID,
+			MemberID:      revenue.MemberID,
+			ReferenceType: "TradeReversal",
+			ReferenceID:   v.reversal.ID,
+			Kind:          revenue.Kind,
+			MarketID:      revenue.MarketID,
+			Debit:         revenue.Credit,
+			Credit:        revenue.Debit,
+		}); result.Error != nil {
+			return result.Error
+		}
+
+		if err := v.record("revenue", revenue.ID, revenue.MemberID, revenue.CurrencyID, revenue.Debit.Sub(revenue.Credit).Neg(), "revenue mirrored"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestTradeLeg(t *testing.T) {
+	d := decimal.RequireFromString
+	trade := &Trade{Price: d("100"), Amount: d("2"), Total: d("200"), MakerOrderID: 1, TakerOrderID: 2}
+
+	seller := &Order{ID: 1, Type: SideSell, MakerFee: d("0.001"), TakerFee: d("0.002")}
+	outcome, income, fee := trade.Leg(seller)
+	if !outcome.Equal(d("2")) || !income.Equal(d("200")) || !fee.Equal(d("0.2")) {
+		t.Errorf("unexpected seller leg %s / %s / %s", outcome, income, fee)
+	}
+
+	buyer := &Order{ID: 2, Type: SideBuy, MakerFee: d("0.001"), TakerFee: d("0.002")}
+	outcome, income, fee = trade.Leg(buyer)
+	if !outcome.Equal(d("200")) || !income.Equal(d("2")) || !fee.Equal(d("0.004")) {
+		t.Errorf("unexpected buyer leg %s / %s / %s", outcome, income, fee)
+	}
+}
+
+func TestCommissionReleased(t *testing.T) {
+	created_at := time.Date(2022, 5, 2, 15, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		voided_at time.Time
+		released  bool
+	}{
+		{"voided the same day", time.Date(2022, 5, 2, 23, 59, 0, 0, time.UTC), false},
+		{"voided after the release", time.Date(2022, 5, 3, 0, 0, 0, 0, time.UTC), true},
+		{"voided days later", time.Date(2022, 5, 9, 10, 0, 0, 0, time.UTC), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if released := CommissionReleased(created_at, test.voided_at); released != test.released {
+				t.Errorf("expected released to be %v, got %v", test.released, released)
+			}
+		})
+	}
+}
+
+func TestTradeReversalNeedsAnotherAdmin(t *testing.T) {
+	requester := &Member{ID: 1}
+	reviewer := &Member{ID: 2}
+
+	reversal := &TradeReversal{TradeID: 7, State: TradeReversalStatePending, RequestedBy: requester.ID}
+	if err := reversal.review(requester); !errors.Is(err, ErrTradeReversalSameAdmin) {
+		t.Errorf("expected the requester not to approve its own reversal, got %v", err)
+	}
+
+	if err := reversal.review(reviewer); err != nil {
+		t.Errorf("expected another admin to review the reversal, got %v", err)
+	}
+
+	reversal.State = TradeReversalStateExecuted
+	if err := reversal.review(reviewer); !errors.Is(err, ErrTradeReversalNotPending) {
+		t.Errorf("expected an executed reversal not to be reviewed again, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/finex/config"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TransferKind = string
+
+var (
+	TransferKindSubAccount TransferKind = "sub_account"
+)
+
+// Transfer moves main funds of a currency between two members inside the exchange.
+type Transfer struct {
+	ID           int64           `json:"id" gorm:"primaryKey"`
+	Kind         TransferKind    `json:"kind"`
+	FromMemberID int64           `json:"from_member_id"`
+	ToMemberID   int64           `json:"to_member_id"`
+	CurrencyID   string          `json:"currency_id"`
+	Amount       decimal.Decimal `json:"amount"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+// CreateTransfer moves amount from the account of from to the account of to, the accounts are locked for the
+// update and the entries of the ledger are written in the same transaction.
+func CreateTransfer(kind TransferKind, from, to *Member, currency *Currency, amount decimal.Decimal) (*Transfer, error) {
+	transfer := &Transfer{
+		Kind:         kind,
+		FromMemberID: from.ID,
+		ToMemberID:   to.ID,
+		CurrencyID:   currency.ID,
+		Amount:       amount,
+	}
+
+	// make sure both accounts exist before locking them
+	from.GetAccount(currency)
+	to.GetAccount(currency)
+
+	err := config.DataBase.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Create(&transfer); result.Error != nil {
+			return result.Error
+		}
+
+		var from_account *Account
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "accounts"}}).Where("member_id = ? AND currency_id = ?", from.ID, currency.ID).First(&from_account); result.Error != nil {
+			return result.Error
+		}
+
+		if err := from_account.SubFunds(tx, amount); err != nil {
+			return err
+		}
+
+		var to_account *Account
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "accounts"}}).Where("member_id = ? AND currency_id = ?", to.ID, currency.ID).First(&to_account); result.Error != nil {
+			return result.Error
+		}
+
+		if err := to_account.PlusFunds(tx, amount); err != nil {
+			return err
+		}
+
+		return transfer.RecordOperations(tx, currency)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+// RecordOperations writes the entries of the transfer on the ledger with tx.
+func (t *Transfer) RecordOperations(tx *gorm.DB, currency *Currency) error {
+	reference := Reference{
+		ID:   t.ID,
+		Type: "Transfer",
+	}
+
+	if err := writeLiability(tx, decimal.Zero, t.Amount, currency, reference, "main", t.FromMemberID); err != nil {
+		return err
+	}
+
+	return writeLiability(tx, t.Amount, decimal.Zero, currency, reference, "main", t.ToMemberID)
+}
@@ -0,0 +1,66 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+)
+
+// apiVersionReportPeriod is how often the request count of every API version is written to InfluxDB.
+var apiVersionReportPeriod = time.Minute
+
+var apiVersionRequests sync.Map // entities.Version => *int64
+var apiVersionReporter sync.Once
+
+// APIVersion tags the requests of a route group with its API version, announces the deprecation
+// of the version when configured and counts the requests for the "api_requests" measurement.
+func APIVersion(version entities.Version) fiber.Handler {
+	apiVersionReporter.Do(func() {
+		go reportAPIVersionRequests()
+	})
+
+	counter, _ := apiVersionRequests.LoadOrStore(version, new(int64))
+
+	return func(c *fiber.Ctx) error {
+		c.Locals("APIVersion", version)
+		atomic.AddInt64(counter.(*int64), 1)
+
+		if version_config, ok := config.APIVersions[version.String()]; ok {
+			if deprecation, err := time.Parse(time.RFC3339, version_config.Deprecation); err == nil {
+				c.Set("Deprecation", "@"+strconv.FormatInt(deprecation.Unix(), 10))
+			}
+
+			if sunset, err := time.Parse(time.RFC3339, version_config.Sunset); err == nil {
+				c.Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+		}
+
+		return c.Next()
+	}
+}
+
+func reportAPIVersionRequests() {
+	ticker := time.NewTicker(apiVersionReportPeriod)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		apiVersionRequests.Range(func(key, value interface{}) bool {
+			count := atomic.SwapInt64(value.(*int64), 0)
+			if count == 0 {
+				return true
+			}
+
+			config.InfluxDB.NewPoint("api_requests", map[string]string{"version": key.(entities.Version).String()}, map[string]interface{}{
+				"count": count,
+			})
+
+			return true
+		})
+	}
+}
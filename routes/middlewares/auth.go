@@ -3,6 +3,8 @@ package middlewares
 import (
 	"database/sql"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"os"
 	"strings"
 
@@ -45,15 +47,44 @@ type Auth struct {
 	Username    null.String `json:"username"`
 	Role        string      `json:"role"`
 	ReferralUID null.String `json:"referral_uid"`
-	Level       int32       `json:"level"`
-	Audience    []string    `json:"aud,omitempty"`
+	// ReferralCode is the referral code the user signed up with
+	ReferralCode null.String `json:"referral_code"`
+	Level        int32       `json:"level"`
+	Audience     []string    `json:"aud,omitempty"`
+	// Scopes are the scopes of the API key of the request, sent in the tokens of APIKeySubject
+	Scopes []string `json:"scopes,omitempty"`
 
 	jwt.StandardClaims
 }
 
+// ErrPublicKey is returned when JWT_PUBLIC_KEY isn't a valid public key.
+var ErrPublicKey = errors.New("jwt public key")
+
+// ParseToken verifies a JWT and returns its claims, the errors of an expired token are jwt.ValidationErrorExpired.
+func ParseToken(token string) (*Auth, error) {
+	public_key_pem, err := base64.StdEncoding.DecodeString(os.Getenv("JWT_PUBLIC_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPublicKey, err)
+	}
+
+	public_key, err := jwt.ParseRSAPublicKeyFromPEM(public_key_pem)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPublicKey, err)
+	}
+
+	var auth Auth
+	if _, err := jwt.ParseWithClaims(token, &auth, func(t *jwt.Token) (interface{}, error) {
+		return public_key, nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return &auth, nil
+}
+
 func Authenticate(c *fiber.Ctx) error {
 	var err error
-	var auth Auth
+	var auth *Auth
 
 	var member *models.Member
 
@@ -67,27 +98,12 @@ func Authenticate(c *fiber.Ctx) error {
 
 	token = strings.Replace(token, "Bearer ", "", -1)
 
-	public_key_pem, err := base64.StdEncoding.DecodeString(os.Getenv("JWT_PUBLIC_KEY"))
-
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"errors": []string{ServerInternalError},
-		})
-	}
-
-	public_key, err := jwt.ParseRSAPublicKeyFromPEM(public_key_pem)
-
-	if err != nil {
+	auth, err = ParseToken(token)
+	if errors.Is(err, ErrPublicKey) {
 		return c.Status(500).JSON(fiber.Map{
 			"errors": []string{ServerInternalError},
 		})
-	}
-
-	_, err = jwt.ParseWithClaims(token, &auth, func(t *jwt.Token) (interface{}, error) {
-		return public_key, nil
-	})
-
-	if err != nil {
+	} else if err != nil {
 		return c.Status(422).JSON(fiber.Map{
 			"errors": []string{JwtDecodeAndVerify},
 		})
@@ -111,7 +127,12 @@ func Authenticate(c *fiber.Ctx) error {
 		Level: auth.Level,
 	})
 
+	if !member.ReferralCodeID.Valid && auth.ReferralCode.Valid {
+		member.AttributeReferralCode(auth.ReferralCode.String)
+	}
+
 	c.Locals("CurrentUser", member)
+	c.Locals("Scopes", auth.GrantedScopes())
 
 	return c.Next()
 }
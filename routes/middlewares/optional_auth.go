@@ -0,0 +1,15 @@
+package middlewares
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// OptionalAuthenticate authenticates the requests which send a session, the others go on anonymously.
+// Public endpoints use it to serve more to members than to anonymous users.
+func OptionalAuthenticate(c *fiber.Ctx) error {
+	if len(c.Get("Authorization")) == 0 {
+		return c.Next()
+	}
+
+	return Authenticate(c)
+}
@@ -0,0 +1,88 @@
+package middlewares
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+const (
+	defaultDownloadRateLimit       = 10
+	defaultDownloadRateLimitWindow = time.Minute
+)
+
+// DownloadRateLimit limits the endpoints serving bulk downloads per client IP, in a bucket apart
+// from the rest of the API so downloads don't use up the requests of the other endpoints.
+func DownloadRateLimit() fiber.Handler {
+	max := config.Downloads.RateLimit
+	if max <= 0 {
+		max = defaultDownloadRateLimit
+	}
+
+	window := config.Downloads.RateLimitWindow
+	if window <= 0 {
+		window = defaultDownloadRateLimitWindow
+	}
+
+	return limiter.New(limiter.Config{
+		Max:        max,
+		Expiration: window,
+		LimitReached: func(c *fiber.Ctx) error {
+			return c.Status(fiber.StatusTooManyRequests).JSON(helpers.Errors{
+				Errors: []string{"public.download.rate_limited"},
+			})
+		},
+	})
+}
+
+var (
+	orderRequest      = regexp.MustCompile(`^/api/v[0-9]+/(market/orders(/[^/]+)?|algo_orders/?)$`)
+	cancelRequest     = regexp.MustCompile(`^/api/v[0-9]+/(market/orders(/[^/]+)?|algo_orders/[^/]+)/cancel$`)
+	marketDataRequest = regexp.MustCompile(`^/api/v[0-9]+/public/markets/[^/]+/(depth|trades|price_series)$`)
+)
+
+// rateLimitBucket returns the bucket a request counts against.
+func rateLimitBucket(method, path string) models.RateLimitBucket {
+	switch {
+	case method == fiber.MethodPost && cancelRequest.MatchString(path):
+		return models.RateLimitBucketCancel
+	case (method == fiber.MethodPost || method == fiber.MethodPut) && orderRequest.MatchString(path):
+		return models.RateLimitBucketOrder
+	case method == fiber.MethodGet && marketDataRequest.MatchString(path):
+		return models.RateLimitBucketMarketData
+	default:
+		return models.RateLimitBucketDefault
+	}
+}
+
+// RateLimit counts the authenticated requests against the quotas of their member with limiter, and sends the
+// state of the bucket of the request in the X-RateLimit headers. Anonymous requests go on uncounted.
+func RateLimit(limiter *models.RateLimiter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		CurrentUser, ok := c.Locals("CurrentUser").(*models.Member)
+		if !ok || CurrentUser == nil {
+			return c.Next()
+		}
+
+		usage, allowed := limiter.Take(CurrentUser.ID, rateLimitBucket(c.Method(), c.Path()))
+
+		c.Set("X-RateLimit-Limit", strconv.Itoa(usage.Limit))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(usage.Remaining))
+		c.Set("X-RateLimit-Reset", strconv.FormatInt(usage.ResetAt.Unix(), 10))
+
+		if !allowed {
+			return c.Status(fiber.StatusTooManyRequests).JSON(helpers.Errors{
+				Errors: []string{"authz.rate_limited"},
+			})
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,98 @@
+package middlewares
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/clock"
+	"github.com/zsmartex/finex/models"
+)
+
+func TestRateLimitBucket(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		bucket models.RateLimitBucket
+	}{
+		{"POST", "/api/v2/market/orders", models.RateLimitBucketOrder},
+		{"PUT", "/api/v3/market/orders/8a1f", models.RateLimitBucketOrder},
+		{"POST", "/api/v2/algo_orders/", models.RateLimitBucketOrder},
+		{"POST", "/api/v2/market/orders/8a1f/cancel", models.RateLimitBucketCancel},
+		{"POST", "/api/v2/market/orders/cancel", models.RateLimitBucketCancel},
+		{"POST", "/api/v2/algo_orders/8a1f/cancel", models.RateLimitBucketCancel},
+		{"GET", "/api/v2/public/markets/btcusdt/depth", models.RateLimitBucketMarketData},
+		{"GET", "/api/v2/public/markets/btcusdt/trades/archive", models.RateLimitBucketDefault},
+		{"GET", "/api/v2/market/orders", models.RateLimitBucketDefault},
+		{"GET", "/api/v2/account/limits", models.RateLimitBucketDefault},
+	}
+
+	for _, test := range tests {
+		if bucket := rateLimitBucket(test.method, test.path); bucket != test.bucket {
+			t.Errorf("expected %s %s in %s, got %s", test.method, test.path, test.bucket, bucket)
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	start := time.Date(2022, 6, 1, 12, 0, 59, 0, time.UTC)
+	fake := clock.NewFake(start)
+	overrides := models.NewRateLimitOverrideCache(func() []*models.RateLimitOverride {
+		return []*models.RateLimitOverride{{MemberID: 1, Bucket: models.RateLimitBucketDefault, Limit: 2}}
+	})
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if c.Get("Authorization") == "member" {
+			c.Locals("CurrentUser", &models.Member{ID: 1})
+		}
+
+		return c.Next()
+	}, RateLimit(models.NewRateLimiter(fake, overrides)))
+	app.Get("/api/v2/account/balances", func(c *fiber.Ctx) error {
+		return c.SendStatus(200)
+	})
+
+	request := func(authorization string) (status int, limit, remaining, reset string) {
+		req := httptest.NewRequest("GET", "/api/v2/account/balances", nil)
+		req.Header.Set("Authorization", authorization)
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.StatusCode, resp.Header.Get("X-RateLimit-Limit"), resp.Header.Get("X-RateLimit-Remaining"), resp.Header.Get("X-RateLimit-Reset")
+	}
+
+	reset_at := strconv.FormatInt(start.Truncate(time.Minute).Add(time.Minute).Unix(), 10)
+	tests := []struct {
+		status    int
+		remaining string
+	}{
+		{200, "1"},
+		{200, "0"},
+		{429, "0"},
+	}
+
+	for i, test := range tests {
+		status, limit, remaining, reset := request("member")
+		if status != test.status || limit != "2" || remaining != test.remaining || reset != reset_at {
+			t.Errorf("request %d: expected %d with 2/%s reset at %s, got %d with %s/%s reset at %s", i, test.status, test.remaining, reset_at, status, limit, remaining, reset)
+		}
+	}
+
+	fake.Advance(time.Second)
+
+	next_reset_at := strconv.FormatInt(start.Truncate(time.Minute).Add(2*time.Minute).Unix(), 10)
+	if status, _, remaining, reset := request("member"); status != 200 || remaining != "1" || reset != next_reset_at {
+		t.Errorf("expected the next window to serve the request, got %d with %s left reset at %s", status, remaining, reset)
+	}
+
+	if status, limit, _, _ := request(""); status != 200 || len(limit) > 0 {
+		t.Errorf("expected anonymous requests to go on without headers, got %d with limit %q", status, limit)
+	}
+}
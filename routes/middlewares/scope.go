@@ -0,0 +1,71 @@
+package middlewares
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/controllers/helpers"
+)
+
+// Scope is what an API key is allowed to do, the auth service which owns the keys sends the scopes of the key
+// of a request in its token. Each authenticated route requires one with RequireScope.
+type Scope string
+
+const (
+	// ScopeRead reads the account, the orders and the history, every key has it
+	ScopeRead Scope = "read"
+	// ScopeTrade places and cancels orders and algo orders
+	ScopeTrade Scope = "trade"
+	// ScopeIEOWrite takes part in IEOs
+	ScopeIEOWrite Scope = "ieo:write"
+	// ScopeConvertWrite quotes and accepts conversions
+	ScopeConvertWrite Scope = "convert:write"
+	// ScopeTransferWrite moves funds between a member and its sub-accounts
+	ScopeTransferWrite Scope = "transfer:write"
+	// ScopeAccountWrite manages the sub-accounts and the referral codes
+	ScopeAccountWrite Scope = "account:write"
+	// ScopeAdmin is required on top of the admin role by the admin routes
+	ScopeAdmin Scope = "admin"
+)
+
+var Scopes = []Scope{ScopeRead, ScopeTrade, ScopeIEOWrite, ScopeConvertWrite, ScopeTransferWrite, ScopeAccountWrite, ScopeAdmin}
+
+// APIKeySubject is the subject of the tokens the auth service issues for the requests signed with an API key,
+// the other tokens are sessions which have every scope.
+const APIKeySubject = "api_key"
+
+var AuthzInsufficientScope = "authz.insufficient_scope"
+
+// GrantedScopes are the scopes of the token, every key can read and the unknown scopes are ignored.
+func (a *Auth) GrantedScopes() []Scope {
+	if a.Subject != APIKeySubject {
+		return Scopes
+	}
+
+	granted := []Scope{ScopeRead}
+	for _, scope := range a.Scopes {
+		for _, known := range Scopes {
+			if Scope(scope) == known && known != ScopeRead {
+				granted = append(granted, known)
+			}
+		}
+	}
+
+	return granted
+}
+
+// RequireScope refuses the requests whose token wasn't granted scope, it comes after Authenticate.
+func RequireScope(scope Scope) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		granted, _ := c.Locals("Scopes").([]Scope)
+
+		for _, s := range granted {
+			if s == scope {
+				return c.Next()
+			}
+		}
+
+		return c.Status(403).JSON(helpers.Errors{
+			Errors: []string{AuthzInsufficientScope},
+		})
+	}
+}
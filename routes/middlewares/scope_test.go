@@ -0,0 +1,57 @@
+package middlewares
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestGrantedScopes(t *testing.T) {
+	tests := []struct {
+		auth *Auth
+		want []Scope
+	}{
+		// sessions
+		{&Auth{StandardClaims: jwt.StandardClaims{Subject: "session"}}, Scopes},
+		{&Auth{Scopes: []string{"read"}}, Scopes},
+		// API keys
+		{&Auth{StandardClaims: jwt.StandardClaims{Subject: APIKeySubject}}, []Scope{ScopeRead}},
+		{&Auth{Scopes: []string{"convert:write", "withdraw", "read"}, StandardClaims: jwt.StandardClaims{Subject: APIKeySubject}}, []Scope{ScopeRead, ScopeConvertWrite}},
+	}
+
+	for _, test := range tests {
+		if granted := test.auth.GrantedScopes(); !reflect.DeepEqual(granted, test.want) {
+			t.Errorf("expected %v to be granted %v, got %v", test.auth.Scopes, test.want, granted)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	app := fiber.New()
+	app.Post("/convert", func(c *fiber.Ctx) error {
+		c.Locals("Scopes", []Scope{ScopeRead, ScopeConvertWrite})
+		return c.Next()
+	}, RequireScope(ScopeConvertWrite), func(c *fiber.Ctx) error {
+		return c.SendStatus(201)
+	})
+	app.Post("/ieo", func(c *fiber.Ctx) error {
+		c.Locals("Scopes", []Scope{ScopeRead, ScopeConvertWrite})
+		return c.Next()
+	}, RequireScope(ScopeIEOWrite), func(c *fiber.Ctx) error {
+		return c.SendStatus(201)
+	})
+
+	for path, status := range map[string]int{"/convert": 201, "/ieo": 403} {
+		response, err := app.Test(httptest.NewRequest("POST", path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.StatusCode != status {
+			t.Errorf("expected %s to respond %d, got %d", path, status, response.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package middlewares
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+// SubAccount lets a parent member act on one of its sub-accounts by sending its uid in the X-Sub-Account header,
+// the sub-account becomes the CurrentUser of the request and the parent is kept as ParentUser.
+func SubAccount(c *fiber.Ctx) error {
+	uid := c.Get("X-Sub-Account")
+	if len(uid) == 0 {
+		return c.Next()
+	}
+
+	CurrentUser := c.Locals("CurrentUser").(*models.Member)
+
+	sub_account := CurrentUser.GetSubAccount(uid)
+	if sub_account == nil {
+		return c.Status(403).JSON(helpers.Errors{
+			Errors: []string{"authz.invalid_sub_account"},
+		})
+	}
+
+	models.RecordSubAccountAudit(CurrentUser, sub_account, c.Method()+" "+c.Path(), string(c.Body()))
+
+	c.Locals("ParentUser", CurrentUser)
+	c.Locals("CurrentUser", sub_account)
+
+	return c.Next()
+}
@@ -2,13 +2,17 @@ package routes
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/etag"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 
 	"github.com/zsmartex/finex/controllers"
+	"github.com/zsmartex/finex/controllers/account_controllers"
 	"github.com/zsmartex/finex/controllers/admin_controllers"
+	"github.com/zsmartex/finex/controllers/entities"
 	"github.com/zsmartex/finex/controllers/ieo_controllers"
 	"github.com/zsmartex/finex/controllers/market_controllers"
 	"github.com/zsmartex/finex/controllers/referral_controllers"
+	"github.com/zsmartex/finex/models"
 	"github.com/zsmartex/finex/routes/middlewares"
 )
 
@@ -16,51 +20,194 @@ func SetupRouter() *fiber.App {
 	app := fiber.New()
 	app.Use(logger.New())
 
-	api_v2_public := app.Group("/api/v2/public")
-	{
-		api_v2_public.Get("/timestamp", controllers.GetTimestamp)
-		api_v2_public.Get("/global_price", controllers.GetGlobalPrice)
-		api_v2_public.Get("/ieo/list", controllers.GetIEOList)
-		api_v2_public.Get("/ieo/:id", controllers.GetIEO)
-		api_v2_public.Get("/markets/:market/depth", controllers.GetDepth)
+	app.Use("/api/v2", middlewares.APIVersion(entities.V2))
+	app.Use("/api/v3", middlewares.APIVersion(entities.V3))
+
+	download_rate_limit := middlewares.DownloadRateLimit()
+	// quotas of the members, counted before SubAccount so a parent acting on its sub-accounts uses its own
+	rate_limit := middlewares.RateLimit(models.RateLimits)
+
+	// every authenticated route requires a scope of the API key of the request, sessions have them all
+	read := middlewares.RequireScope(middlewares.ScopeRead)
+	trade := middlewares.RequireScope(middlewares.ScopeTrade)
+	ieo_write := middlewares.RequireScope(middlewares.ScopeIEOWrite)
+	convert_write := middlewares.RequireScope(middlewares.ScopeConvertWrite)
+	transfer_write := middlewares.RequireScope(middlewares.ScopeTransferWrite)
+	account_write := middlewares.RequireScope(middlewares.ScopeAccountWrite)
+
+	// public and market routes are served by every API version, handlers render the entities for the version of the request
+	for _, version := range []entities.Version{entities.V2, entities.V3} {
+		api_public := app.Group("/api/" + version.String() + "/public")
+		{
+			api_public.Get("/timestamp", controllers.GetTimestamp)
+			api_public.Get("/global_price", controllers.GetGlobalPrice)
+			api_public.Get("/summary", controllers.GetSummary)
+			api_public.Get("/ieo/list", controllers.GetIEOList)
+			api_public.Get("/ieo/:id", controllers.GetIEO)
+			api_public.Get("/markets", controllers.GetMarkets)
+			api_public.Get("/markets/tickers", controllers.GetTickers)
+			api_public.Get("/listings", controllers.GetUpcomingListings)
+			api_public.Get("/markets/:market/listing", controllers.GetMarketListing)
+			api_public.Get("/markets/:market/ticker", controllers.GetBookTicker)
+			api_public.Get("/markets/:market/tickers", controllers.GetTicker)
+			// market data is served by the market data policy of the tier of the user, members send their session
+			api_public.Get("/markets/:market/depth", middlewares.OptionalAuthenticate, rate_limit, controllers.GetDepth)
+			api_public.Get("/markets/:market/trades", middlewares.OptionalAuthenticate, rate_limit, controllers.GetPublicTrades)
+			api_public.Get("/markets/:market/trades/archive", download_rate_limit, controllers.GetTradeArchive)
+			api_public.Get("/markets/:market/price_series", middlewares.OptionalAuthenticate, rate_limit, etag.New(), controllers.GetPriceSeries)
+			api_public.Get("/markets/:market/k-line", middlewares.OptionalAuthenticate, rate_limit, etag.New(), controllers.GetKLine)
+			api_public.Get("/streams", middlewares.OptionalAuthenticate, controllers.GetVisibleStreams)
+			api_public.Post("/streams/auth", controllers.AuthenticateStream)
+		}
+
+		api_market := app.Group("/api/"+version.String()+"/market", middlewares.Authenticate, rate_limit, middlewares.SubAccount)
+		{
+			api_market.Post("/orders", trade, market_controllers.CreateOrder)
+			api_market.Post("/orders/batch", trade, market_controllers.CreateOrderBatch)
+			api_market.Get("/orders", read, market_controllers.GetOrders)
+			api_market.Delete("/orders", trade, market_controllers.DeleteOrders)
+			api_market.Get("/orders/client/:client_id", read, market_controllers.GetOrderByClientID)
+			api_market.Delete("/orders/client/:client_id", trade, market_controllers.CancelOrderByClientID)
+			api_market.Get("/orders/:uuid", read, market_controllers.GetOrderByUUID)
+			api_market.Put("/orders/:uuid", trade, market_controllers.ReplaceOrderByUUID)
+			api_market.Get("/orders/:uuid/position", read, market_controllers.GetOrderPositionByUUID)
+			api_market.Put("/orders/:uuid/amend", trade, market_controllers.AmendOrderByUUID)
+			api_market.Post("/orders/:uuid/cancel", trade, market_controllers.CancelOrderByUUID)
+			api_market.Post("/orders/cancel", trade, market_controllers.CancelAllOrders)
+			api_market.Get("/trades", read, market_controllers.GetTrades)
+		}
 	}
 
-	api_v2_admin := app.Group("/api/v2/admin", middlewares.Authenticate, middlewares.AdminVaildator)
+	api_v2_admin := app.Group("/api/v2/admin", middlewares.Authenticate, rate_limit, middlewares.AdminVaildator, middlewares.RequireScope(middlewares.ScopeAdmin))
 	{
 		api_v2_admin.Get("/trades", admin_controllers.GetTrades)
+		api_v2_admin.Post("/trades/:id/reversal", admin_controllers.RequestTradeReversal)
+		api_v2_admin.Get("/trade_reversals", admin_controllers.GetTradeReversals)
+		api_v2_admin.Post("/trade_reversals/:id/approve", admin_controllers.ApproveTradeReversal)
+		api_v2_admin.Post("/trade_reversals/:id/reject", admin_controllers.RejectTradeReversal)
+		api_v2_admin.Get("/candle_discrepancies", admin_controllers.GetCandleDiscrepancies)
+		api_v2_admin.Get("/reports/commissions", admin_controllers.GetCommissionsReport)
+		api_v2_admin.Get("/reports/rounding_drift", admin_controllers.GetRoundingDriftReport)
+		api_v2_admin.Get("/reports/revenue", admin_controllers.GetRevenueReport)
+		api_v2_admin.Get("/reports/jobs/:uuid", admin_controllers.GetReportJob)
+		api_v2_admin.Get("/background_migrations", admin_controllers.GetBackgroundMigrations)
+		api_v2_admin.Post("/background_migrations/:name", admin_controllers.EnqueueBackgroundMigration)
 		api_v2_admin.Get("/ieo/list", admin_controllers.GetIEOList)
 		api_v2_admin.Get("/ieo/:id", admin_controllers.GetIEO)
 		api_v2_admin.Post("/ieo", admin_controllers.CreateIEO)
 		api_v2_admin.Put("/ieo", admin_controllers.UpdateIEO)
 		api_v2_admin.Delete("/ieo", admin_controllers.DeleteIEO)
+		api_v2_admin.Post("/ieo/:id/unarchive", admin_controllers.UnarchiveIEO)
 		api_v2_admin.Post("/ieo/currencies", admin_controllers.AddIEOCurrencies)
 		api_v2_admin.Delete("/ieo/currencies", admin_controllers.RemoveIEOCurrencies)
 
 		api_v2_admin.Post("/orders/:uuid/cancel", admin_controllers.CancelOrder)
 		api_v2_admin.Post("/orders/cancel", admin_controllers.CancelAllOrders)
+
+		api_v2_admin.Get("/security_events", admin_controllers.GetSecurityEvents)
+		api_v2_admin.Get("/dead_letters", admin_controllers.GetDeadLetters)
+		api_v2_admin.Post("/dead_letters/:id/reinject", admin_controllers.ReinjectDeadLetter)
+
+		api_v2_admin.Get("/members/:uid/invoices", admin_controllers.GetMemberInvoice)
+		api_v2_admin.Put("/members/:uid/market_group", admin_controllers.UpdateMemberMarketGroup)
+		api_v2_admin.Get("/members/:uid/rate_limits", admin_controllers.GetMemberRateLimits)
+		api_v2_admin.Put("/members/:uid/rate_limits/:bucket", admin_controllers.UpdateMemberRateLimit)
+		api_v2_admin.Delete("/members/:uid/rate_limits/:bucket", admin_controllers.DeleteMemberRateLimit)
+		api_v2_admin.Post("/members/:uid/exports", admin_controllers.CreateMemberExport)
+		api_v2_admin.Get("/member_exports/:uuid", admin_controllers.GetMemberExport)
+		api_v2_admin.Get("/member_exports/:uuid/download", admin_controllers.DownloadMemberExport)
+
+		api_v2_admin.Put("/members/:uid/market_data_subscription", admin_controllers.UpdateMemberMarketDataSubscription)
+		api_v2_admin.Get("/market_data_policies", admin_controllers.GetMarketDataPolicies)
+		api_v2_admin.Put("/market_data_policies/:tier", admin_controllers.UpdateMarketDataPolicy)
+
+		api_v2_admin.Get("/market_groups", admin_controllers.GetMarketGroups)
+		api_v2_admin.Post("/market_groups", admin_controllers.CreateMarketGroup)
+		api_v2_admin.Put("/market_groups/:name", admin_controllers.UpdateMarketGroup)
+		api_v2_admin.Delete("/market_groups/:name", admin_controllers.DeleteMarketGroup)
+		api_v2_admin.Put("/market_groups/:name/markets", admin_controllers.SetMarketGroupMarkets)
+
+		api_v2_admin.Get("/markets", admin_controllers.GetMarkets)
+		api_v2_admin.Get("/markets/:market/settings", admin_controllers.GetMarketSettings)
+		api_v2_admin.Put("/markets/:market/settings", admin_controllers.UpdateMarketSettings)
+		api_v2_admin.Get("/markets/:market/config", admin_controllers.GetMarketConfig)
+		api_v2_admin.Put("/markets/:market/precision", admin_controllers.UpdateMarketPrecision)
+		api_v2_admin.Put("/markets/:market/trading_state", admin_controllers.UpdateMarketTradingState)
+		api_v2_admin.Post("/markets/:market/circuit_breaker/lift", admin_controllers.LiftMarketCircuitBreaker)
+		api_v2_admin.Post("/markets/:market/auction/start", admin_controllers.StartMarketAuction)
+		api_v2_admin.Post("/markets/:market/auction/uncross", admin_controllers.UncrossMarketAuction)
+		api_v2_admin.Post("/markets/:market/invariants/check", admin_controllers.CheckMarketInvariants)
+		api_v2_admin.Put("/markets/:market/listing", admin_controllers.ScheduleMarketListing)
+		api_v2_admin.Post("/markets/:market/unarchive", admin_controllers.UnarchiveMarket)
+		api_v2_admin.Post("/markets/:market/delisting", admin_controllers.ScheduleMarketDelisting)
+		api_v2_admin.Get("/markets/:market/delisting", admin_controllers.GetMarketDelisting)
+		api_v2_admin.Post("/markets/:market/delisting/abort", admin_controllers.AbortMarketDelisting)
+
+		api_v2_admin.Get("/referral_codes", admin_controllers.GetReferralCodes)
+		api_v2_admin.Put("/referral_codes/:code/state", admin_controllers.UpdateReferralCodeState)
+	}
+
+	api_v2_account := app.Group("/api/v2/account", middlewares.Authenticate, rate_limit)
+	{
+		api_v2_account.Get("/balances", read, middlewares.SubAccount, account_controllers.GetBalances)
+		api_v2_account.Get("/invoices", read, middlewares.SubAccount, account_controllers.GetInvoice)
+		api_v2_account.Get("/security_events", read, middlewares.SubAccount, account_controllers.GetSecurityEvents)
+		api_v2_account.Get("/limits", read, account_controllers.GetLimits)
+		api_v2_account.Get("/maker_stats", read, middlewares.SubAccount, account_controllers.GetMakerStats)
+
+		api_v2_account.Get("/sub_accounts", read, account_controllers.GetSubAccounts)
+		api_v2_account.Post("/sub_accounts", account_write, account_controllers.CreateSubAccount)
+		api_v2_account.Get("/sub_accounts/balances", read, account_controllers.GetSubAccountsBalances)
+		api_v2_account.Post("/sub_accounts/transfers", transfer_write, account_controllers.CreateSubAccountTransfer)
 	}
 
-	api_v2_market := app.Group("/api/v2/market", middlewares.Authenticate)
+	api_v2_algo_orders := app.Group("/api/v2/algo_orders", middlewares.Authenticate, rate_limit, middlewares.SubAccount)
 	{
-		api_v2_market.Post("/orders", market_controllers.CreateOrder)
-		api_v2_market.Get("/orders", market_controllers.GetOrders)
-		api_v2_market.Get("/orders/:uuid", market_controllers.GetOrderByUUID)
-		api_v2_market.Post("/orders/:uuid/cancel", market_controllers.CancelOrderByUUID)
-		api_v2_market.Post("/orders/cancel", market_controllers.CancelAllOrders)
-		api_v2_market.Get("/trades", market_controllers.GetTrades)
+		api_v2_algo_orders.Post("/", trade, market_controllers.CreateAlgoOrder)
+		api_v2_algo_orders.Get("/", read, market_controllers.GetAlgoOrders)
+		api_v2_algo_orders.Get("/:uuid", read, market_controllers.GetAlgoOrderByUUID)
+		api_v2_algo_orders.Post("/:uuid/cancel", trade, market_controllers.CancelAlgoOrderByUUID)
 	}
 
-	api_v2_ieo := app.Group("/api/v2/ieo", middlewares.Authenticate)
+	api_v2_scheduled_orders := app.Group("/api/v2/scheduled_orders", middlewares.Authenticate, rate_limit, middlewares.SubAccount)
 	{
-		api_v2_ieo.Post("/", ieo_controllers.CreateIEOOrder)
-		api_v2_ieo.Get("/:id", ieo_controllers.GetIEO)
+		api_v2_scheduled_orders.Post("/", trade, market_controllers.CreateScheduledOrder)
+		api_v2_scheduled_orders.Get("/", read, market_controllers.GetScheduledOrders)
+		api_v2_scheduled_orders.Post("/:uuid/cancel", trade, market_controllers.CancelScheduledOrderByUUID)
 	}
 
-	api_v2_referral := app.Group("/api/v2/referral", middlewares.Authenticate)
+	api_v2_convert := app.Group("/api/v2/convert", middlewares.Authenticate, rate_limit, middlewares.SubAccount)
 	{
-		api_v2_referral.Get("/", referral_controllers.GetReleaseCommission)
-		api_v2_referral.Get("/commissions", referral_controllers.GetCommissions)
+		api_v2_convert.Post("/quote", convert_write, market_controllers.CreateConvertQuote)
+		api_v2_convert.Post("/accept", convert_write, market_controllers.AcceptConvertQuote)
 	}
 
+	api_v2_ieo := app.Group("/api/v2/ieo", middlewares.Authenticate, rate_limit)
+	{
+		api_v2_ieo.Post("/", ieo_write, ieo_controllers.CreateIEOOrder)
+		api_v2_ieo.Get("/:id", read, ieo_controllers.GetIEO)
+	}
+
+	api_v2_referral := app.Group("/api/v2/referral", middlewares.Authenticate, rate_limit)
+	{
+		api_v2_referral.Get("/", read, referral_controllers.GetReleaseCommission)
+		api_v2_referral.Get("/commissions", read, referral_controllers.GetCommissions)
+		api_v2_referral.Get("/kickback", read, referral_controllers.GetFeeKickback)
+
+		api_v2_referral.Get("/codes", read, referral_controllers.GetReferralCodes)
+		api_v2_referral.Post("/codes", account_write, referral_controllers.CreateReferralCode)
+		api_v2_referral.Put("/codes/:code", account_write, referral_controllers.UpdateReferralCode)
+		api_v2_referral.Delete("/codes/:code", account_write, referral_controllers.DeleteReferralCode)
+		api_v2_referral.Get("/codes/:code/stats", read, referral_controllers.GetReferralCodeStats)
+	}
+
+	api_v2_commissions := app.Group("/api/v2/commissions", middlewares.Authenticate, rate_limit)
+	{
+		api_v2_commissions.Get("/:id/explain", read, referral_controllers.ExplainCommission)
+	}
+
+	// the websocket authenticates the JWT of its handshake itself, browsers send it as the token parameter
+	app.Get("/api/v2/ws/private", controllers.ConnectPrivateStream)
+
 	return app
 }
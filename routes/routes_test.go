@@ -0,0 +1,85 @@
+package routes
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/routes/middlewares"
+	"github.com/zsmartex/finex/types"
+)
+
+// isUse reports whether a route of the stack holds the middlewares of a group, fiber doesn't export it.
+func isUse(route *fiber.Route) bool {
+	return reflect.ValueOf(route).Elem().FieldByName("use").Bool()
+}
+
+func handlerPointer(handler fiber.Handler) uintptr {
+	return reflect.ValueOf(handler).Pointer()
+}
+
+func hasHandler(route *fiber.Route, pointer uintptr) bool {
+	for _, handler := range route.Handlers {
+		if handlerPointer(handler) == pointer {
+			return true
+		}
+	}
+
+	return false
+}
+
+// covers reports whether the middlewares of a group registered on prefix run before the route of path.
+func covers(prefix, path string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// TestAuthenticatedRoutesRequireAScope keeps a route taking API keys from being added without the scope they need.
+func TestAuthenticatedRoutesRequireAScope(t *testing.T) {
+	config.Downloads = &types.DownloadsConfig{}
+
+	app := SetupRouter()
+
+	authenticate := handlerPointer(middlewares.Authenticate)
+	// the scope checks are closures of the same function, they share its code
+	require_scope := handlerPointer(middlewares.RequireScope(middlewares.ScopeRead))
+
+	authenticated := 0
+	for _, routes := range app.Stack() {
+		groups := make([]*fiber.Route, 0)
+		for _, route := range routes {
+			if isUse(route) {
+				groups = append(groups, route)
+			}
+		}
+
+		for _, route := range routes {
+			if isUse(route) || route.Method == fiber.MethodHead {
+				continue
+			}
+
+			guarded, scoped := hasHandler(route, authenticate), hasHandler(route, require_scope)
+			for _, group := range groups {
+				if covers(group.Path, route.Path) {
+					guarded = guarded || hasHandler(group, authenticate)
+					scoped = scoped || hasHandler(group, require_scope)
+				}
+			}
+
+			if !guarded {
+				continue
+			}
+
+			authenticated++
+			if !scoped {
+				t.Errorf("%s %s is authenticated without requiring a scope", route.Method, route.Path)
+			}
+		}
+	}
+
+	if authenticated == 0 {
+		t.Fatal("expected authenticated routes")
+	}
+}
@@ -0,0 +1,164 @@
+//go:build ignore
+
+// Measures the bandwidth a client of the public depth stream uses with and without the batched stream and
+// permessage-deflate, over a reproducible synthetic load:
+//
+//	go run scripts/market_data_bandwidth.go -markets 20 -rate 200 -duration 60s -batch 50ms
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/decimalutil"
+	"github.com/zsmartex/finex/events"
+)
+
+type frame struct {
+	at      time.Duration
+	market  string
+	payload interface{}
+}
+
+// websocketHeader is the size of the header of a server frame, server frames aren't masked.
+func websocketHeader(size int) int {
+	switch {
+	case size < 126:
+		return 2
+	case size < 1<<16:
+		return 4
+	default:
+		return 10
+	}
+}
+
+type meter struct {
+	frames     int
+	raw        int
+	compressed int
+	buffer     bytes.Buffer
+	deflate    *flate.Writer
+}
+
+func newMeter() *meter {
+	m := &meter{}
+	// permessage-deflate with context takeover, the compressor is kept between messages
+	m.deflate, _ = flate.NewWriter(&m.buffer, flate.DefaultCompression)
+
+	return m
+}
+
+// send counts a frame the way the gateway writes it, {"<market>.<event>": payload}.
+func (m *meter) send(stream string, payload interface{}) {
+	message, _ := json.Marshal(map[string]interface{}{stream: payload})
+
+	m.frames++
+	m.raw += len(message) + websocketHeader(len(message))
+
+	m.buffer.Reset()
+	m.deflate.Write(message)
+	m.deflate.Flush()
+	// the sync flush trailer 00 00 ff ff isn't sent
+	size := m.buffer.Len() - 4
+	m.compressed += size + websocketHeader(size)
+}
+
+func diffs(random *rand.Rand, markets, rate int, duration time.Duration) []frame {
+	frames := make([]frame, 0)
+	sequences := make(map[string]int64)
+
+	for i := 0; i < markets; i++ {
+		market := fmt.Sprintf("coin%dusdt", i)
+		mid := 1000 + random.Float64()*1000
+
+		for at := time.Duration(0); at < duration; {
+			at += time.Duration(random.ExpFloat64() / float64(rate) * float64(time.Second))
+
+			depth := &pkg.DepthJSON{Asks: make([][]decimal.Decimal, 0), Bids: make([][]decimal.Decimal, 0)}
+			for levels := random.Intn(3) + 1; levels > 0; levels-- {
+				level := []decimal.Decimal{
+					decimalutil.Round(decimal.NewFromFloat(mid+(random.Float64()-0.5)*10), 2, decimalutil.HalfUp),
+					decimalutil.Round(decimal.NewFromFloat(random.Float64()*5), 6, decimalutil.HalfUp),
+				}
+
+				if level[0].GreaterThan(decimal.NewFromFloat(mid)) {
+					depth.Asks = append(depth.Asks, level)
+				} else {
+					depth.Bids = append(depth.Bids, level)
+				}
+			}
+
+			sequences[market]++
+			depth.Sequence = sequences[market]
+			frames = append(frames, frame{at: at, market: market, payload: depth})
+		}
+	}
+
+	sort.Slice(frames, func(i, j int) bool {
+		return frames[i].at < frames[j].at
+	})
+
+	return frames
+}
+
+func main() {
+	markets := flag.Int("markets", 20, "markets the client subscribed to")
+	rate := flag.Int("rate", 200, "depth diffs per second per market")
+	duration := flag.Duration("duration", time.Minute, "simulated time")
+	batch := flag.Duration("batch", 50*time.Millisecond, "interval of the batched stream")
+	seed := flag.Int64("seed", 1, "seed of the load")
+	flag.Parse()
+
+	frames := diffs(rand.New(rand.NewSource(*seed)), *markets, *rate, *duration)
+
+	per_event := newMeter()
+	for _, f := range frames {
+		per_event.send(f.market+".depth", f.payload)
+	}
+
+	batched := newMeter()
+	windows := make(map[int64]*events.StreamBatcher)
+	order := make([]int64, 0)
+	for _, f := range frames {
+		window := int64(f.at / *batch)
+		batcher, ok := windows[window]
+		if !ok {
+			batcher = events.NewStreamBatcher("depth", *batch)
+			windows[window] = batcher
+			order = append(order, window)
+		}
+
+		batcher.Add(f.market, f.payload.(*pkg.DepthJSON).Sequence, f.payload)
+	}
+
+	for _, window := range order {
+		markets, batches := windows[window].Flush()
+		for i, market := range markets {
+			batched.send(market+".depth"+events.BatchSuffix, batches[i])
+		}
+	}
+
+	report := func(name string, m *meter, raw bool) {
+		size := m.compressed
+		if raw {
+			size = m.raw
+		}
+
+		fmt.Printf("%-28s %10d frames %14d bytes %8.1f%% of per-event\n", name, m.frames, size, 100*float64(size)/float64(per_event.raw))
+	}
+
+	fmt.Printf("%d markets, %d diffs/s each, %s, batches of %s, %d diffs\n", *markets, *rate, *duration, *batch, len(frames))
+	report("per-event", per_event, true)
+	report("per-event + deflate", per_event, false)
+	report("batched", batched, true)
+	report("batched + deflate", batched, false)
+}
@@ -0,0 +1,589 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/bus"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+// ActionHeartbeat is the command the engine sends itself to check its consumer still receives from the broker.
+const ActionHeartbeat pkg.PayloadAction = "heartbeat"
+
+const (
+	defaultHeartbeatInterval   = 5 * time.Second
+	defaultHeartbeatTimeout    = 30 * time.Second
+	defaultReconnectBackoff    = 500 * time.Millisecond
+	defaultReconnectMaxBackoff = 30 * time.Second
+	defaultMaxOutage           = time.Minute
+	// recentCommandsSize is the number of records processed remembered to drop the ones redelivered after a reconnect.
+	recentCommandsSize = 8192
+)
+
+type heartbeatPayload struct {
+	Action pkg.PayloadAction `json:"action"`
+	SentAt time.Time         `json:"sent_at"`
+}
+
+// ConsumerSettings are the durations of the engine config, defaulted when they're not set.
+type ConsumerSettings struct {
+	HeartbeatInterval   time.Duration
+	HeartbeatTimeout    time.Duration
+	ReconnectBackoff    time.Duration
+	ReconnectMaxBackoff time.Duration
+	MaxOutage           time.Duration
+}
+
+func orDefault(d, fallback time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+
+	return fallback
+}
+
+func NewConsumerSettings(engine_config *types.EngineConfig) ConsumerSettings {
+	return ConsumerSettings{
+		HeartbeatInterval:   orDefault(engine_config.HeartbeatInterval, defaultHeartbeatInterval),
+		HeartbeatTimeout:    orDefault(engine_config.HeartbeatTimeout, defaultHeartbeatTimeout),
+		ReconnectBackoff:    orDefault(engine_config.ReconnectBackoff, defaultReconnectBackoff),
+		ReconnectMaxBackoff: orDefault(engine_config.ReconnectMaxBackoff, defaultReconnectMaxBackoff),
+		MaxOutage:           orDefault(engine_config.MaxOutage, defaultMaxOutage),
+	}
+}
+
+// ReconnectBackoff is the delay before the reconnect attempt, doubled from min on every attempt up to max.
+// Half of it is random so engines cut off by the same broker restart don't reconnect all at once.
+func ReconnectBackoff(attempt int, min, max time.Duration, random func() float64) time.Duration {
+	backoff := min
+	for i := 0; i < attempt && backoff < max; i++ {
+		backoff *= 2
+	}
+
+	if backoff > max {
+		backoff = max
+	}
+
+	return backoff/2 + time.Duration(random()*float64(backoff/2))
+}
+
+// ConsumerStatus is the health of the broker consumer shown by the engine status endpoint.
+type ConsumerStatus struct {
+	Down            bool       `json:"down"`
+	DownSince       *time.Time `json:"down_since"`
+	LastHeartbeatAt *time.Time `json:"last_heartbeat_at"`
+	Reconnects      uint64     `json:"reconnects"`
+	Halted          bool       `json:"halted"`
+}
+
+// ConsumerHealth tracks whether the consumer receives from the broker.
+type ConsumerHealth struct {
+	sync.RWMutex
+	down           bool
+	down_since     time.Time
+	last_heartbeat time.Time
+	// waiting_since is when the heartbeat timeout started, the last heartbeat or the last connect
+	waiting_since time.Time
+	reconnects    uint64
+	halted        bool
+}
+
+// NewConsumerHealth starts healthy, the heartbeat timeout runs from now.
+func NewConsumerHealth(now time.Time) *ConsumerHealth {
+	return &ConsumerHealth{waiting_since: now}
+}
+
+// Connected restarts the heartbeat timeout for a new consumer, it stays down until a heartbeat comes back.
+func (h *ConsumerHealth) Connected(now time.Time) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.waiting_since = now
+}
+
+// Stale reports whether no heartbeat came back for timeout.
+func (h *ConsumerHealth) Stale(now time.Time, timeout time.Duration) bool {
+	h.RLock()
+	defer h.RUnlock()
+
+	return now.Sub(h.waiting_since) > timeout
+}
+
+// Down marks the consumer down, the outage runs from the first call.
+func (h *ConsumerHealth) Down(now time.Time) {
+	h.Lock()
+	defer h.Unlock()
+
+	if !h.down {
+		h.down = true
+		h.down_since = now
+	}
+}
+
+// Up records a heartbeat which went through the broker, it reports whether the consumer was down and its markets are halted.
+func (h *ConsumerHealth) Up(now time.Time) (recovered, halted bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	recovered, halted = h.down, h.halted
+	if h.down {
+		h.reconnects++
+	}
+
+	h.down = false
+	h.halted = false
+	h.last_heartbeat = now
+	h.waiting_since = now
+
+	return
+}
+
+// Halt reports whether the outage lasted longer than max_outage and the markets weren't halted yet.
+func (h *ConsumerHealth) Halt(now time.Time, max_outage time.Duration) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	if !h.down || h.halted || now.Sub(h.down_since) < max_outage {
+		return false
+	}
+
+	h.halted = true
+
+	return true
+}
+
+func (h *ConsumerHealth) Status() *ConsumerStatus {
+	h.RLock()
+	defer h.RUnlock()
+
+	status := &ConsumerStatus{
+		Down:       h.down,
+		Reconnects: h.reconnects,
+		Halted:     h.halted,
+	}
+
+	if h.down {
+		down_since := h.down_since
+		status.DownSince = &down_since
+	}
+
+	if !h.last_heartbeat.IsZero() {
+		last_heartbeat := h.last_heartbeat
+		status.LastHeartbeatAt = &last_heartbeat
+	}
+
+	return status
+}
+
+// recentCommands remembers the records of the last processed commands. Records are committed after they're
+// processed, so the ones processed just before the broker went away are delivered again after the reconnect. A record
+// is known by its topic, partition and offset rather than its payload: two commands alike, a reload sent twice, are
+// two records and both are processed.
+type recentCommands struct {
+	seen  map[recentRecord]bool
+	order []recentRecord
+	next  int
+}
+
+type recentRecord struct {
+	topic     string
+	partition int32
+	offset    int64
+}
+
+func newRecentCommands(size int) *recentCommands {
+	return &recentCommands{
+		seen:  make(map[recentRecord]bool, size),
+		order: make([]recentRecord, size),
+	}
+}
+
+// Seen reports whether the record was already processed, and remembers it otherwise. A record the driver doesn't
+// number is never seen.
+func (r *recentCommands) Seen(record *bus.Message) bool {
+	if record.Delivery == nil {
+		return false
+	}
+
+	key := recentRecord{topic: record.Topic, partition: record.Delivery.Partition, offset: record.Delivery.Offset}
+	if r.seen[key] {
+		return true
+	}
+
+	delete(r.seen, r.order[r.next])
+	r.order[r.next] = key
+	r.next = (r.next + 1) % len(r.order)
+	r.seen[key] = true
+
+	return false
+}
+
+// Dispatch hands a command to the engines, done is called once they processed it: the record of the command is only
+// committed then. An error is logged and the command dropped, done is called anyway unless the error is an
+// ErrNotDispatched: the command wasn't taken and its record is left for the broker to deliver again.
+type Dispatch func(payload []byte, done func()) error
+
+// ErrNotDispatched is wrapped by the errors of the commands a Dispatch didn't take.
+var ErrNotDispatched = errors.New("command not dispatched")
+
+// Inline dispatches the commands to handle in the consumer goroutine, each done when handle returns.
+func Inline(handle func(payload []byte) error) Dispatch {
+	return func(payload []byte, done func()) error {
+		defer done()
+
+		return handle(payload)
+	}
+}
+
+// ConsumerSupervisor consumes the commands of the engine, reconnecting to the broker when it goes away.
+// The engine sends itself heartbeats through the broker so a consumer which silently stopped receiving is noticed too.
+type ConsumerSupervisor struct {
+	Health   *ConsumerHealth
+	Settings ConsumerSettings
+	// OnHalt and OnResume are called when the outage exceeds MaxOutage and when the consumer recovers from it
+	OnHalt   func()
+	OnResume func()
+
+	topics      []string
+	dispatch    Dispatch
+	recent      *recentCommands
+	newConsumer func() (bus.Consumer, error)
+	mutex       sync.Mutex
+	consumer    bus.Consumer
+
+	// stopping is closed by Stop, stopped once Run returned
+	stopping chan struct{}
+	stopped  chan struct{}
+	stop     sync.Once
+}
+
+// NewConsumerSupervisor consumes topics for group from the event bus.
+func NewConsumerSupervisor(group string, topics []string, dispatch Dispatch) *ConsumerSupervisor {
+	return newConsumerSupervisor(topics, dispatch, func() (bus.Consumer, error) {
+		return config.Bus.Consumer(group, topics...)
+	})
+}
+
+func newConsumerSupervisor(topics []string, dispatch Dispatch, new_consumer func() (bus.Consumer, error)) *ConsumerSupervisor {
+	return &ConsumerSupervisor{
+		Health:      NewConsumerHealth(time.Now()),
+		Settings:    NewConsumerSettings(config.Engine),
+		topics:      topics,
+		dispatch:    dispatch,
+		recent:      newRecentCommands(recentCommandsSize),
+		newConsumer: new_consumer,
+		stopping:    make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+}
+
+// Run consumes until Stop is called.
+func (s *ConsumerSupervisor) Run() {
+	defer close(s.stopped)
+
+	go s.watch()
+
+	for {
+		consumer := s.connect()
+		if consumer == nil {
+			return
+		}
+
+		err := s.consume(consumer)
+		if s.isStopping() {
+			s.drop(consumer)
+			config.Logger.Infof("Consumer of %v stopped", s.topics)
+			return
+		}
+
+		if err != nil {
+			config.Logger.Errorf("Consumer of %v lost the broker: %v", s.topics, err)
+		}
+
+		s.Health.Down(time.Now())
+		s.drop(consumer)
+	}
+}
+
+// Stop stops polling the broker and waits for the commands dispatched to be done and their records committed, then
+// closes the consumer. The records polled but not dispatched yet aren't committed, the broker delivers them again to
+// the next engine. Run must have been called.
+func (s *ConsumerSupervisor) Stop() {
+	s.stop.Do(func() {
+		close(s.stopping)
+		s.wake()
+	})
+
+	<-s.stopped
+}
+
+func (s *ConsumerSupervisor) isStopping() bool {
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// wake sends a heartbeat to the topics so a poll waiting for records returns and sees the consumer is stopping.
+func (s *ConsumerSupervisor) wake() {
+	if config.Bus == nil {
+		return
+	}
+
+	for _, topic := range s.topics {
+		if err := config.Bus.Publish(topic, "", heartbeatPayload{Action: ActionHeartbeat, SentAt: time.Now()}); err != nil {
+			config.Logger.Errorf("Failed to wake the consumer of topic %s: %v", topic, err)
+		}
+	}
+}
+
+// connect subscribes a new consumer to the topics, retrying with backoff until the broker is back. It returns nil
+// when the supervisor is stopped meanwhile.
+func (s *ConsumerSupervisor) connect() bus.Consumer {
+	for attempt := 0; ; attempt++ {
+		if s.isStopping() {
+			return nil
+		}
+
+		consumer, err := s.newConsumer()
+		if err == nil {
+			s.mutex.Lock()
+			s.consumer = consumer
+			s.mutex.Unlock()
+
+			s.Health.Connected(time.Now())
+
+			return consumer
+		}
+
+		backoff := ReconnectBackoff(attempt, s.Settings.ReconnectBackoff, s.Settings.ReconnectMaxBackoff, rand.Float64)
+		config.Logger.Errorf("Failed to connect the consumer of %v, retrying in %s: %v", s.topics, backoff, err)
+		s.Health.Down(time.Now())
+
+		select {
+		case <-time.After(backoff):
+		case <-s.stopping:
+			return nil
+		}
+	}
+}
+
+// drop closes the consumer unless the watchdog already did.
+func (s *ConsumerSupervisor) drop(consumer bus.Consumer) {
+	s.mutex.Lock()
+	current := s.consumer == consumer
+	if current {
+		s.consumer = nil
+	}
+	s.mutex.Unlock()
+
+	if current {
+		consumer.Close()
+	}
+}
+
+// consume polls the records of consumer and dispatches their commands until the poll fails or the supervisor is
+// stopped, it returns once the commands dispatched are done and their records committed.
+func (s *ConsumerSupervisor) consume(consumer bus.Consumer) error {
+	commits := newCommitter(consumer.Commit)
+	defer commits.Close()
+
+	for !s.isStopping() {
+		records, err := consumer.Poll()
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			// the records left aren't committed, nor the ones after them
+			if s.isStopping() {
+				return nil
+			}
+
+			if !s.subscribed(record.Topic) {
+				continue
+			}
+
+			s.process(record, commits.Add(record))
+		}
+	}
+
+	return nil
+}
+
+func (s *ConsumerSupervisor) subscribed(topic string) bool {
+	for _, t := range s.topics {
+		if t == topic {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *ConsumerSupervisor) process(record *bus.Message, pending *pendingRecord) {
+	payload := record.Value
+
+	var heartbeat heartbeatPayload
+	if err := json.Unmarshal(payload, &heartbeat); err == nil && heartbeat.Action == ActionHeartbeat {
+		s.beat()
+		pending.Done()
+		return
+	}
+
+	if s.recent.Seen(record) {
+		config.Logger.Warnf("Dropped a command delivered twice, partition %d offset %d of %s: %s", record.Delivery.Partition, record.Delivery.Offset, record.Topic, string(payload))
+		pending.Done()
+		return
+	}
+
+	config.Logger.Debugf("Recevie message from topics: %v payload: %s", s.topics, string(payload))
+	err := s.dispatch(payload, pending.Done)
+	if errors.Is(err, ErrNotDispatched) {
+		config.Logger.Warnf("Command not dispatched, it's left to the broker: %v", err)
+		pending.Skip()
+	} else if err != nil {
+		// a command the engine can't process is dropped, the engines go on with the next one
+		config.Logger.Errorf("Worker error: %v, dropped command: %s", err, string(payload))
+	}
+}
+
+// committer commits the records of a consumer in the order they were polled, each once its command is done: the
+// commands of different markets are done out of order, and committing a record commits the ones before it.
+type committer struct {
+	commit  func(records ...*bus.Message) error
+	pending chan *pendingRecord
+	stopped chan struct{}
+}
+
+type pendingRecord struct {
+	record *bus.Message
+	done   chan struct{}
+	// skipped is set before done is closed when the command wasn't dispatched
+	skipped bool
+}
+
+// Done marks the command of the record processed, it's called once.
+func (p *pendingRecord) Done() {
+	close(p.done)
+}
+
+// Skip marks the command of the record not dispatched: neither the record nor the ones after it are committed.
+func (p *pendingRecord) Skip() {
+	p.skipped = true
+	close(p.done)
+}
+
+// committerCapacity is the number of records waiting for their commands before the consumer stops polling.
+const committerCapacity = 4096
+
+func newCommitter(commit func(records ...*bus.Message) error) *committer {
+	c := &committer{
+		commit:  commit,
+		pending: make(chan *pendingRecord, committerCapacity),
+		stopped: make(chan struct{}),
+	}
+
+	go c.run()
+
+	return c
+}
+
+// Add queues the commit of record until its command is done or skipped, it blocks while committerCapacity records
+// are waiting.
+func (c *committer) Add(record *bus.Message) *pendingRecord {
+	pending := &pendingRecord{record: record, done: make(chan struct{})}
+	c.pending <- pending
+
+	return pending
+}
+
+// Close waits for the commands of the records added to be done and commits them.
+func (c *committer) Close() {
+	close(c.pending)
+	<-c.stopped
+}
+
+func (c *committer) run() {
+	defer close(c.stopped)
+
+	skipped := false
+	for pending := range c.pending {
+		<-pending.done
+
+		// committing a record after one skipped would commit the skipped one too
+		skipped = skipped || pending.skipped
+		if skipped {
+			continue
+		}
+
+		if err := c.commit(pending.record); err != nil {
+			config.Logger.Errorf("Failed to commit the command from topic %s: %v", pending.record.Topic, err)
+		}
+	}
+}
+
+func (s *ConsumerSupervisor) beat() {
+	now := time.Now()
+
+	recovered, halted := s.Health.Up(now)
+	if !recovered {
+		return
+	}
+
+	config.Logger.Infof("Consumer of %v recovered", s.topics)
+	if halted && s.OnResume != nil {
+		s.OnResume()
+	}
+}
+
+// watch sends the heartbeats, reconnects the consumer when they stop coming back and halts the markets on long outages.
+func (s *ConsumerSupervisor) watch() {
+	ticker := time.NewTicker(s.Settings.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-s.stopping:
+			return
+		}
+
+		for _, topic := range s.topics {
+			if err := config.Bus.Publish(topic, "", heartbeatPayload{Action: ActionHeartbeat, SentAt: now}); err != nil {
+				config.Logger.Errorf("Failed to send the heartbeat to topic %s: %v", topic, err)
+			}
+		}
+
+		if s.Health.Stale(now, s.Settings.HeartbeatTimeout) {
+			s.Health.Down(now)
+
+			s.mutex.Lock()
+			consumer := s.consumer
+			s.mutex.Unlock()
+
+			// closing the consumer makes its poll fail, and the consumer loop reconnects
+			if consumer != nil {
+				config.Logger.Errorf("No heartbeat came back to the consumer of %v for %s, reconnecting", s.topics, s.Settings.HeartbeatTimeout)
+				s.drop(consumer)
+			}
+		}
+
+		if s.Health.Halt(now, s.Settings.MaxOutage) {
+			config.Logger.Errorf("Consumer of %v is down for more than %s, halting its markets", s.topics, s.Settings.MaxOutage)
+			if s.OnHalt != nil {
+				s.OnHalt()
+			}
+		}
+	}
+}
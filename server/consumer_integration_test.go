@@ -0,0 +1,129 @@
+//go:build integration
+
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/zsmartex/finex/bus"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+// TestConsumerBrokerRestart restarts the broker container and checks the commands sent after it's back are processed once.
+// It needs KAFKA_URL and FINEX_TEST_KAFKA_CONTAINER, the name of the docker container of the broker:
+//
+//	go test -tags integration -run TestConsumerBrokerRestart ./server
+func TestConsumerBrokerRestart(t *testing.T) {
+	container := os.Getenv("FINEX_TEST_KAFKA_CONTAINER")
+	if len(container) == 0 || len(os.Getenv("KAFKA_URL")) == 0 {
+		t.Skip("KAFKA_URL and FINEX_TEST_KAFKA_CONTAINER aren't set")
+	}
+
+	brokers := strings.Split(os.Getenv("KAFKA_URL"), ",")
+	config.Logger = logrus.NewEntry(logrus.New())
+	config.Engine = &types.EngineConfig{
+		HeartbeatInterval:   time.Second,
+		HeartbeatTimeout:    5 * time.Second,
+		ReconnectBackoff:    200 * time.Millisecond,
+		ReconnectMaxBackoff: 2 * time.Second,
+		MaxOutage:           time.Hour,
+	}
+
+	event_bus, err := bus.NewKafka(brokers, config.Logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer event_bus.Close()
+	config.Bus = event_bus
+
+	topic := fmt.Sprintf("matching_test_%d", time.Now().UnixNano())
+
+	var mutex sync.Mutex
+	processed := make(map[string]int)
+	consumer := NewConsumerSupervisor(topic, []string{topic}, Inline(func(payload []byte) error {
+		var command struct {
+			Key string `json:"key"`
+		}
+		if err := json.Unmarshal(payload, &command); err != nil {
+			return err
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		processed[command.Key]++
+		return nil
+	}))
+
+	go consumer.Run()
+
+	send := func(prefix string, count int) []string {
+		commands := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			command := fmt.Sprintf("%s-%d", prefix, i)
+			for event_bus.Publish(topic, "", map[string]string{"action": "test", "key": command}) != nil {
+				time.Sleep(200 * time.Millisecond)
+			}
+			commands = append(commands, command)
+		}
+
+		return commands
+	}
+
+	waitProcessed := func(commands []string) {
+		deadline := time.Now().Add(time.Minute)
+		for time.Now().Before(deadline) {
+			mutex.Lock()
+			done := true
+			for _, command := range commands {
+				if processed[command] == 0 {
+					done = false
+					break
+				}
+			}
+			mutex.Unlock()
+
+			if done {
+				return
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		t.Fatal("the commands weren't processed in time")
+	}
+
+	waitProcessed(send("before", 10))
+
+	if output, err := exec.Command("docker", "restart", container).CombinedOutput(); err != nil {
+		t.Fatalf("failed to restart the broker: %v %s", err, output)
+	}
+
+	after := send("after", 50)
+	waitProcessed(after)
+
+	// leave time for redeliveries to show up
+	time.Sleep(3 * config.Engine.HeartbeatInterval)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, command := range after {
+		if processed[command] != 1 {
+			t.Errorf("command %s was processed %d times", command, processed[command])
+		}
+	}
+
+	if consumer.Health.Status().Down {
+		t.Error("the consumer is still down")
+	}
+}
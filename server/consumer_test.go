@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/zsmartex/finex/bus"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/types"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	min, max := 500*time.Millisecond, 30*time.Second
+	low := func() float64 { return 0 }
+	high := func() float64 { return 1 }
+
+	tests := []struct {
+		attempt   int
+		low, high time.Duration
+	}{
+		{0, 250 * time.Millisecond, 500 * time.Millisecond},
+		{1, 500 * time.Millisecond, time.Second},
+		{3, 2 * time.Second, 4 * time.Second},
+		{10, 15 * time.Second, 30 * time.Second},
+		{1000, 15 * time.Second, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := ReconnectBackoff(tt.attempt, min, max, low); got != tt.low {
+			t.Errorf("attempt %d: got %s with no jitter, want %s", tt.attempt, got, tt.low)
+		}
+
+		if got := ReconnectBackoff(tt.attempt, min, max, high); got != tt.high {
+			t.Errorf("attempt %d: got %s with full jitter, want %s", tt.attempt, got, tt.high)
+		}
+	}
+}
+
+func TestConsumerHealthOutage(t *testing.T) {
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	timeout, max_outage := 30*time.Second, time.Minute
+	health := NewConsumerHealth(now)
+
+	if health.Stale(now.Add(timeout), timeout) {
+		t.Fatal("the consumer is stale before the timeout")
+	}
+
+	// the broker goes away, no heartbeat comes back
+	down_at := now.Add(timeout + time.Second)
+	if !health.Stale(down_at, timeout) {
+		t.Fatal("the consumer isn't stale after the timeout")
+	}
+	health.Down(down_at)
+
+	// a reconnect doesn't bring the consumer up, only a heartbeat does
+	health.Connected(down_at.Add(10 * time.Second))
+	health.Down(down_at.Add(10 * time.Second))
+
+	status := health.Status()
+	if !status.Down || !status.DownSince.Equal(down_at) {
+		t.Errorf("got down %t since %v, want down since %s", status.Down, status.DownSince, down_at)
+	}
+
+	if health.Halt(down_at.Add(max_outage-time.Second), max_outage) {
+		t.Error("the markets were halted before the max outage")
+	}
+
+	if !health.Halt(down_at.Add(max_outage), max_outage) {
+		t.Fatal("the markets weren't halted after the max outage")
+	}
+
+	if health.Halt(down_at.Add(2*max_outage), max_outage) {
+		t.Error("the markets were halted twice")
+	}
+
+	recovered, halted := health.Up(down_at.Add(2 * max_outage))
+	if !recovered || !halted {
+		t.Errorf("got recovered %t halted %t, want both", recovered, halted)
+	}
+
+	status = health.Status()
+	if status.Down || status.Halted || status.Reconnects != 1 || status.DownSince != nil {
+		t.Errorf("got %+v after the recovery", status)
+	}
+
+	if recovered, _ := health.Up(down_at.Add(3 * max_outage)); recovered {
+		t.Error("a heartbeat of a healthy consumer counted as a recovery")
+	}
+}
+
+func TestRecentCommands(t *testing.T) {
+	recent := newRecentCommands(2)
+	record := func(offset int64) *bus.Message {
+		return &bus.Message{Topic: "matching", Value: []byte(`{"action":"reload"}`), Delivery: &bus.Delivery{Offset: offset}}
+	}
+
+	if recent.Seen(record(1)) || recent.Seen(record(2)) {
+		t.Fatal("new records were seen")
+	}
+
+	if !recent.Seen(record(1)) {
+		t.Error("a redelivered record wasn't seen")
+	}
+
+	// 3 evicts 1, the oldest record
+	recent.Seen(record(3))
+	if recent.Seen(record(1)) {
+		t.Error("an evicted record was still seen")
+	}
+
+	if recent.Seen(&bus.Message{Topic: "matching", Value: []byte(`{"action":"reload"}`)}) {
+		t.Error("a record without a delivery was seen")
+	}
+}
+
+// Commands alike are processed each time they're sent, only a record delivered again is dropped.
+func TestConsumerProcessesCommandsAlike(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	config.Logger = logrus.NewEntry(logger)
+	config.Engine = &types.EngineConfig{HeartbeatInterval: time.Hour}
+
+	reload := []byte(`{"action":"reload","symbol":{"base_currency":"btc","quote_currency":"usdt"}}`)
+	broker := &fakeBroker{}
+	for i, offset := range []int64{0, 1, 1} {
+		broker.records = append(broker.records, &bus.Message{
+			Topic:    "matching",
+			Key:      []byte(strconv.Itoa(i)),
+			Value:    reload,
+			Delivery: &bus.Delivery{Offset: offset},
+		})
+	}
+
+	var mutex sync.Mutex
+	processed := 0
+	consumer := newConsumerSupervisor([]string{"matching"}, Inline(func(payload []byte) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		processed++
+		return nil
+	}), broker.consumer)
+
+	go consumer.Run()
+
+	for {
+		broker.mutex.Lock()
+		committed := broker.committed
+		broker.mutex.Unlock()
+
+		if committed == len(broker.records) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	consumer.Stop()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if processed != 2 {
+		t.Errorf("expected both reloads to be processed and the redelivery dropped, got %d processed", processed)
+	}
+
+	if broker.err != nil {
+		t.Error(broker.err)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/emirpasic/gods/trees/redblacktree"
 	GrpcEngine "github.com/zsmartex/pkg/Grpc/engine"
@@ -16,18 +18,47 @@ import (
 
 	"github.com/shopspring/decimal"
 	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
 	"github.com/zsmartex/finex/matching"
+	"github.com/zsmartex/finex/matching/replay"
+	"github.com/zsmartex/finex/matching/wal"
 	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/types"
 	"github.com/zsmartex/pkg"
 )
 
 type EngineServer struct {
-	Engines map[pkg.Symbol]*matching.Engine
+	// enginesMutex guards Engines, a market is reloaded while the status router and the other markets read them
+	enginesMutex sync.RWMutex
+	Engines      map[pkg.Symbol]*matching.Engine
+	// Consumer is the health of the broker consumer feeding the engines
+	Consumer *ConsumerHealth
+	// Capture records the commands and the output of the engines for replays, nil unless engine.capture_dir is set
+	Capture *replay.Recorder
+	// Logs are the write-ahead logs of the books, empty unless engine.wal_dir is set
+	Logs map[pkg.Symbol]*wal.Log
+	// Router hands the commands of each market to a lane of its own, nil when they're processed inline
+	Router *EngineRouter
+	// Streams serves the public channels of the books to websocket clients, nil when they aren't served
+	Streams *PublicStreams
 }
 
-func NewEngineServer() *EngineServer {
+// NewEngineServer returns the server of the engines of every market, public_streams serves their public channels
+// when it's not nil.
+func NewEngineServer(public_streams *PublicStreams) *EngineServer {
 	worker := &EngineServer{
 		Engines: make(map[pkg.Symbol]*matching.Engine),
+		Logs:    make(map[pkg.Symbol]*wal.Log),
+		Streams: public_streams,
+	}
+
+	if len(config.Engine.CaptureDir) > 0 {
+		capture, err := replay.Create(config.Engine.CaptureDir, config.Engine.CaptureSalt, config.Engine.CaptureCheckpoint, time.Now())
+		if err != nil {
+			config.Logger.Errorf("Failed to start the engine capture, the engine runs without it: %v", err)
+		} else {
+			worker.Capture = capture
+		}
 	}
 
 	worker.Reload(pkg.Symbol{BaseCurrency: "ALL", QuoteCurrency: "ALL"})
@@ -36,34 +67,138 @@ func NewEngineServer() *EngineServer {
 }
 
 func (w *EngineServer) Process(payload []byte) error {
-	var matching_payload pkg.MatchingPayloadMessage
+	var matching_payload events.MatchingPayload
 	if err := json.Unmarshal(payload, &matching_payload); err != nil {
-		return err
+		return events.Malformed(err)
+	}
+
+	matching_payload.ReceivedAt = time.Now()
+
+	return w.ProcessCommand(&matching_payload)
+}
+
+// ProcessCommand processes a command decoded from the broker. The commands of different markets may be processed at
+// once, by the lanes of an EngineRouter; the ones of no market, which change the engines, are processed alone.
+func (w *EngineServer) ProcessCommand(matching_payload *events.MatchingPayload) error {
+	if w.Capture != nil {
+		// the command is recorded before the trades it matches
+		if err := w.Capture.Command(matching_payload, time.Now()); err != nil {
+			config.Logger.Errorf("Failed to capture command: %v", err)
+		}
+		defer w.checkpoint(matching_payload)
+	}
+
+	if engine := w.commandEngine(matching_payload); engine != nil {
+		defer engine.Metrics.Receive(matching_payload.ReceivedAt)()
+		defer w.logCommand(engine, matching_payload)()
+
+		if config.Engine.InvariantCheckEveryCommand {
+			defer engine.CheckInvariants(config.Engine.InvariantViolationHalts)
+		}
 	}
 
 	switch matching_payload.Action {
 	case pkg.ActionSubmit:
 		order := matching_payload.Order
-		return w.SubmitOrder(order)
+		if order == nil {
+			return events.Malformed(errors.New("submit without an order"))
+		}
+		return w.SubmitOrder(order, matching_payload.Options)
 	case pkg.ActionCancel:
 		order := matching_payload.Order
-		return w.CancelOrder(order)
+		if order == nil {
+			return events.Malformed(errors.New("cancel without an order"))
+		}
+		return w.CancelOrderWithKey(order.Key(), matching_payload.CommandID)
 	case pkg.ActionCancelWithKey:
 		key := matching_payload.Key
-		return w.CancelOrderWithKey(key)
+		return w.CancelOrderWithKey(key, matching_payload.CommandID)
+	case events.ActionCancelReplace:
+		return w.CancelReplaceOrder(matching_payload.Key, matching_payload.Order, matching_payload.Options)
+	case events.ActionAmend:
+		return w.AmendOrder(matching_payload.Key, matching_payload.Amendment)
+	case events.ActionRekey:
+		return w.RekeyMarket(matching_payload.Symbol, matching_payload.Precision)
+	case events.ActionBatch:
+		return w.SubmitBatch(matching_payload.Batch)
+	case events.ActionCancelAll:
+		return w.CancelAll(matching_payload.Symbol, matching_payload.CancelAll)
+	case events.ActionTradingState:
+		return w.SetTradingState(matching_payload.Symbol, matching_payload.TradingState)
+	case events.ActionStartAuction:
+		return w.StartAuction(matching_payload.Symbol)
+	case events.ActionUncross:
+		return w.UncrossAuction(matching_payload.Symbol)
+	case events.ActionLiftCircuitBreaker:
+		return w.LiftCircuitBreaker(matching_payload.Symbol)
+	case events.ActionIndexPrice:
+		return w.UpdateIndexPrice(matching_payload.Symbol, matching_payload.IndexPrice)
 	case pkg.ActionNew:
 		w.InitializeEngine(matching_payload.Symbol)
 	case pkg.ActionReload:
 		w.Reload(matching_payload.Symbol)
 	default:
-		config.Logger.Fatalf("Unknown action: %s", matching_payload.Action)
+		return events.Malformed(fmt.Errorf("unknown action: %s", matching_payload.Action))
 	}
 
 	return nil
 }
 
-func (s *EngineServer) SubmitOrder(order *pkg.Order) error {
-	engine := s.Engines[order.Symbol]
+// commandEngine returns the initialized engine of the market of a command, nil when there's none or the command
+// isn't of one market.
+func (s *EngineServer) commandEngine(command *events.MatchingPayload) *matching.Engine {
+	symbol, found := command.Market()
+	if !found {
+		return nil
+	}
+
+	engine := s.GetEngineBySymbol(symbol)
+	if engine == nil || !engine.Initialized {
+		return nil
+	}
+
+	return engine
+}
+
+// logCommand appends a command to the log of the book of engine before the engine processes it, the function it
+// returns snapshots the book once it did when a snapshot is due. A command the log failed to take is processed
+// anyway, the book is snapshotted right after so its log has no gap.
+func (s *EngineServer) logCommand(engine *matching.Engine, command *events.MatchingPayload) func() {
+	log, found := s.Logs[engine.Symbol]
+	if !found {
+		return func() {}
+	}
+
+	_, err := log.Append(command, time.Now())
+	if err != nil {
+		config.Logger.Errorf("Failed to log command of %s: %v", engine.Symbol.String(), err)
+	}
+
+	return func() {
+		if err == nil && !log.SnapshotDue() {
+			return
+		}
+
+		if err := log.Snapshot(engine, time.Now()); err != nil {
+			config.Logger.Errorf("Failed to snapshot the book of %s: %v", engine.Symbol.String(), err)
+		}
+	}
+}
+
+// checkpoint records the depth of the market of a command when it's due.
+func (s *EngineServer) checkpoint(command *events.MatchingPayload) {
+	engine := s.commandEngine(command)
+	if engine == nil {
+		return
+	}
+
+	if err := s.Capture.Checkpoint(engine, time.Now()); err != nil {
+		config.Logger.Errorf("Failed to capture the depth of %s: %v", engine.Symbol.String(), err)
+	}
+}
+
+func (s *EngineServer) SubmitOrder(order *pkg.Order, options *events.OrderOptions) error {
+	engine := s.GetEngineBySymbol(order.Symbol)
 
 	if engine == nil {
 		return errors.New("engine not found")
@@ -78,12 +213,24 @@ func (s *EngineServer) SubmitOrder(order *pkg.Order) error {
 		return nil
 	}
 
-	engine.Submit(order)
+	// orders loaded on reload aren't checked, a lowered limit doesn't cancel the orders already in the book
+	if !engine.SizeLimits.Accept(order) {
+		config.Logger.Warnf("Order %d of %s rejected by the size limits", order.ID, order.Symbol.String())
+		engine.OrderBook.PublishCancel(order.Key(), matching.CancelReasonOrderSize)
+		return nil
+	}
+
+	engine.SubmitWithOptions(order, options)
 	return nil
 }
 
-func (s *EngineServer) CancelOrderWithKey(key *pkg.OrderKey) error {
-	engine := s.Engines[key.Symbol]
+// CancelOrderWithKey cancels an order for the cancel command command_id, its producer gets the outcome with the id.
+func (s *EngineServer) CancelOrderWithKey(key *pkg.OrderKey, command_id string) error {
+	if key == nil {
+		return errors.New("cancel without a key")
+	}
+
+	engine := s.GetEngineBySymbol(key.Symbol)
 
 	if engine == nil {
 		return errors.New("engine not found")
@@ -93,12 +240,19 @@ func (s *EngineServer) CancelOrderWithKey(key *pkg.OrderKey) error {
 		return errors.New("engine is not ready")
 	}
 
-	engine.CancelWithKey(key)
+	if outcome := engine.CancelCommand(key, command_id); outcome != matching.CancelOutcomeCancelled {
+		config.Logger.Infof("Cancel of order %d of %s: %s", key.ID, key.Symbol.String(), outcome)
+	}
+
 	return nil
 }
 
-func (s *EngineServer) CancelOrder(order *pkg.Order) error {
-	engine := s.Engines[order.Symbol]
+func (s *EngineServer) CancelReplaceOrder(key *pkg.OrderKey, order *pkg.Order, options *events.OrderOptions) error {
+	if key == nil || order == nil {
+		return errors.New("cancel replace needs the key of the replaced order and the new order")
+	}
+
+	engine := s.GetEngineBySymbol(key.Symbol)
 
 	if engine == nil {
 		return errors.New("engine not found")
@@ -108,23 +262,308 @@ func (s *EngineServer) CancelOrder(order *pkg.Order) error {
 		return errors.New("engine is not ready")
 	}
 
-	engine.Cancel(order)
+	if !engine.CancelReplace(key, order, options) {
+		config.Logger.Infof("Replace of order %d by order %d rejected", key.ID, order.ID)
+	}
+
 	return nil
 }
 
-func (s EngineServer) GetEngineBySymbol(symbol pkg.Symbol) *matching.Engine {
-	engine, found := s.Engines[symbol]
+// AmendOrder amends the order of key, the order processor gets the outcome.
+func (s *EngineServer) AmendOrder(key *pkg.OrderKey, amendment *events.Amendment) error {
+	if key == nil || amendment == nil {
+		return errors.New("amend needs the key of the order and the amendment")
+	}
 
-	if found {
-		return engine
+	engine := s.GetEngineBySymbol(key.Symbol)
+
+	if engine == nil {
+		return errors.New("engine not found")
+	}
+
+	if !engine.Initialized {
+		return errors.New("engine is not ready")
+	}
+
+	if !engine.Amend(key, amendment) {
+		config.Logger.Infof("Amend of order %d of %s rejected", key.ID, key.Symbol.String())
+	}
+
+	return nil
+}
+
+// SubmitBatch processes the submits and the cancels of a batch in one cycle of the engine of their market, the order
+// processor gets the outcome of each of them.
+func (s *EngineServer) SubmitBatch(batch *events.MatchingBatch) error {
+	if batch == nil {
+		return errors.New("batch without entries")
+	}
+
+	symbol, found := batch.Symbol()
+	if !found {
+		return errors.New("batch without entries")
+	}
+
+	for _, entry := range batch.Entries {
+		if entry.Order != nil && entry.Order.Symbol != symbol || entry.Key != nil && entry.Key.Symbol != symbol {
+			return fmt.Errorf("batch %s has entries of more than one market", batch.ID)
+		}
+	}
+
+	engine := s.GetEngineBySymbol(symbol)
+
+	if engine == nil {
+		return errors.New("engine not found")
+	}
+
+	if !engine.Initialized {
+		return errors.New("engine is not ready")
+	}
+
+	accepted := 0
+	for _, entry := range engine.ProcessBatch(batch) {
+		if entry.Accepted {
+			accepted++
+		}
+	}
+	config.Logger.Infof("Batch %s of %s: %d of %d entries accepted", batch.ID, symbol.String(), accepted, len(batch.Entries))
+
+	return nil
+}
+
+// CancelAll cancels the orders of the member of cancel_all in the book of symbol, in every book when symbol is
+// empty. The order processor gets a cancel of each order.
+func (s *EngineServer) CancelAll(symbol pkg.Symbol, cancel_all *events.CancelAll) error {
+	if cancel_all == nil {
+		return errors.New("cancel all without a member")
+	}
+
+	engines := make([]*matching.Engine, 0)
+	if len(symbol.BaseCurrency) == 0 && len(symbol.QuoteCurrency) == 0 {
+		for _, engine := range s.engines() {
+			if engine.Initialized {
+				engines = append(engines, engine)
+			}
+		}
+	} else {
+		engine := s.GetEngineBySymbol(symbol)
+
+		if engine == nil {
+			return errors.New("engine not found")
+		}
+
+		if !engine.Initialized {
+			return errors.New("engine is not ready")
+		}
+
+		engines = append(engines, engine)
+	}
+
+	for _, engine := range engines {
+		// a cancel all of every market is captured and logged as a cancel all of each of them
+		snapshot := func() {}
+		if len(symbol.BaseCurrency) == 0 {
+			command := &events.MatchingPayload{CancelAll: cancel_all}
+			command.Action, command.Symbol = events.ActionCancelAll, engine.Symbol
+			if s.Capture != nil {
+				if err := s.Capture.Command(command, time.Now()); err != nil {
+					config.Logger.Errorf("Failed to capture command: %v", err)
+				}
+			}
+
+			snapshot = s.logCommand(engine, command)
+		}
+
+		if ids := engine.CancelAll(cancel_all.MemberID, cancel_all.Side); len(ids) > 0 {
+			config.Logger.Infof("Cancel all of member %d on %s: %d orders cancelled", cancel_all.MemberID, engine.Symbol.String(), len(ids))
+		}
+
+		snapshot()
+	}
+
+	return nil
+}
+
+// RekeyMarket re-keys the book of a market to the price precision of a change, then gives the market the precision
+// unless the change expired meanwhile. The book is re-keyed either way, a price rounded to fewer decimals is still
+// a price of the precision the market keeps.
+func (s *EngineServer) RekeyMarket(symbol pkg.Symbol, precision *events.PrecisionChange) error {
+	if precision == nil {
+		return errors.New("rekey without a precision change")
+	}
+
+	engine := s.GetEngineBySymbol(symbol)
+
+	if engine == nil {
+		return errors.New("engine not found")
+	}
+
+	if !engine.Initialized {
+		return errors.New("engine is not ready")
+	}
+
+	repricing := engine.Reprice(precision.PricePrecision)
+	config.Logger.Infof("%s re-keyed to price precision %d, %d orders repriced and %d cancelled", symbol.String(), precision.PricePrecision, repricing.Repriced, repricing.Cancelled)
+
+	_, err := models.CompleteMarketPrecisionChange(config.DataBase, precision.ID, repricing.Repriced, repricing.Cancelled, time.Now())
+
+	return err
+}
+
+// SetTradingState sets the book of a market to the state of a change, then completes the change so the admin who
+// made it gets the confirmation and the market takes the state.
+func (s *EngineServer) SetTradingState(symbol pkg.Symbol, change *events.TradingStateChange) error {
+	if change == nil || !change.State.Valid() {
+		return errors.New("trading state without a valid state")
+	}
+
+	engine := s.GetEngineBySymbol(symbol)
+
+	if engine == nil {
+		return errors.New("engine not found")
+	}
+
+	if !engine.Initialized {
+		return errors.New("engine is not ready")
+	}
+
+	engine.SetTradingState(change.State)
+	config.Logger.Infof("%s is %s", symbol.String(), change.State)
+
+	_, err := models.CompleteMarketTradingStateChange(config.DataBase, change.ID, time.Now())
+
+	return err
+}
+
+// StartAuction holds the book of a market in a call auction until an uncross command of the market.
+func (s *EngineServer) StartAuction(symbol pkg.Symbol) error {
+	engine := s.GetEngineBySymbol(symbol)
+
+	if engine == nil {
+		return errors.New("engine not found")
+	}
+
+	if !engine.Initialized {
+		return errors.New("engine is not ready")
+	}
+
+	engine.StartAuction()
+
+	return nil
+}
+
+// UncrossAuction uncrosses the call auction of the book of a market at its clearing price.
+func (s *EngineServer) UncrossAuction(symbol pkg.Symbol) error {
+	engine := s.GetEngineBySymbol(symbol)
+
+	if engine == nil {
+		return errors.New("engine not found")
+	}
+
+	if !engine.Initialized {
+		return errors.New("engine is not ready")
+	}
+
+	if uncross, found := engine.Uncross(); found {
+		config.Logger.Infof("%s uncrossed %s at %s", symbol.String(), uncross.Volume, uncross.Price)
+	} else {
+		config.Logger.Infof("%s ended its call auction without trades", symbol.String())
 	}
 
 	return nil
 }
 
+func (s *EngineServer) LiftCircuitBreaker(symbol pkg.Symbol) error {
+	engine := s.GetEngineBySymbol(symbol)
+
+	if engine == nil {
+		return errors.New("engine not found")
+	}
+
+	if !engine.Initialized {
+		return errors.New("engine is not ready")
+	}
+
+	if !engine.LiftCircuitBreaker() {
+		config.Logger.Infof("%s circuit breaker isn't tripped", symbol.String())
+	}
+
+	return nil
+}
+
+// UpdateIndexPrice moves the index price of the book of a market, from the price feed of the market.
+func (s *EngineServer) UpdateIndexPrice(symbol pkg.Symbol, update *events.IndexPriceUpdate) error {
+	if update == nil || !update.Price.IsPositive() {
+		return errors.New("index price without a positive price")
+	}
+
+	engine := s.GetEngineBySymbol(symbol)
+
+	if engine == nil {
+		return errors.New("engine not found")
+	}
+
+	if !engine.Initialized {
+		return errors.New("engine is not ready")
+	}
+
+	engine.UpdateIndexPrice(update.Price)
+
+	return nil
+}
+
+// GetEngineBySymbol returns the engine of symbol, nil when it isn't loaded.
+func (s *EngineServer) GetEngineBySymbol(symbol pkg.Symbol) *matching.Engine {
+	s.enginesMutex.RLock()
+	defer s.enginesMutex.RUnlock()
+
+	return s.Engines[symbol]
+}
+
+// serveEngine makes engine the engine of symbol, in place of the one it had.
+func (s *EngineServer) serveEngine(symbol pkg.Symbol, engine *matching.Engine) {
+	s.enginesMutex.Lock()
+	defer s.enginesMutex.Unlock()
+
+	s.Engines[symbol] = engine
+}
+
+// engines returns the engines of every market, the reloads don't change the map returned.
+func (s *EngineServer) engines() map[pkg.Symbol]*matching.Engine {
+	s.enginesMutex.RLock()
+	defer s.enginesMutex.RUnlock()
+
+	engines := make(map[pkg.Symbol]*matching.Engine, len(s.Engines))
+	for symbol, engine := range s.Engines {
+		engines[symbol] = engine
+	}
+
+	return engines
+}
+
+// Ticker returns the best prices and the last trade of the book of symbol for the readers of the engine process,
+// without waiting for the matching. It's false when the engine of symbol isn't loaded.
+func (s *EngineServer) Ticker(symbol pkg.Symbol) (matching.TickerSnapshot, bool) {
+	engine := s.GetEngineBySymbol(symbol)
+	if engine == nil {
+		return matching.TickerSnapshot{}, false
+	}
+
+	return engine.Ticker(), true
+}
+
 func (s *EngineServer) FetchOrder(ctx context.Context, req *GrpcEngine.FetchOrderRequest) (*GrpcEngine.FetchOrderResponse, error) {
 	key := req.OrderKey.ToOrderKey()
 	engine := s.GetEngineBySymbol(key.Symbol)
+	if engine == nil {
+		return nil, errors.New("engine not found")
+	}
+
+	// the levels of a side can't be compared with a key of another side
+	if key.Side != pkg.SideSell && key.Side != pkg.SideBuy {
+		return nil, fmt.Errorf("can't find order with uuid: %s in orderbook", key.UUID.String())
+	}
+
 	pl := matching.NewPriceLevel(key.Side, key.Price)
 
 	var price_levels *redblacktree.Tree
@@ -205,7 +644,8 @@ func (s *EngineServer) CalcMarketOrder(ctx context.Context, req *GrpcEngine.Calc
 func (s *EngineServer) Reload(symbol pkg.Symbol) {
 	if symbol.BaseCurrency == "ALL" && symbol.QuoteCurrency == "ALL" {
 		var markets []models.Market
-		config.DataBase.Where("state = ?", "enabled").Find(&markets)
+		// markets halted by a consumer outage are still served, they're resumed once the consumer is back
+		config.DataBase.Where("state IN ?", []types.MarketState{types.MarketStateEndabled, types.MarketStateHalted}).Find(&markets)
 		for _, market := range markets {
 			s.InitializeEngine(market.GetSymbol())
 		}
@@ -222,17 +662,215 @@ func (s *EngineServer) InitializeEngine(symbol pkg.Symbol) {
 		lastPrice = trade.Price
 	}
 
-	engine := matching.NewEngine(symbol, lastPrice)
-	s.Engines[symbol] = engine
-	s.LoadOrders(engine)
+	var market *models.Market
+	config.DataBase.First(&market, "symbol = ?", strings.ToLower(symbol.ToSymbol("")))
+
+	// the trades are published with the fees of the rates every member group pays, the members of a group with
+	// rates of its own are charged them by the trade executor
+	trading_fee := models.TradingFeeFor("any", "spot", market.Symbol)
+
+	book_config := matching.OrderBookConfig{
+		DailyPriceLimit:     market.DailyPriceLimit,
+		Flags:               matching.NewFeatureFlags(models.GetMarketFeatureFlags(market.Symbol)),
+		SlowCycleThreshold:  config.Engine.SlowCycleThreshold,
+		ConfigVersion:       market.ConfigVersion,
+		ExpirySweepInterval: config.Engine.ExpirySweepInterval,
+		SizeLimits: matching.OrderSizeLimits{
+			MinAmount:       market.MinAmount,
+			MaxAmount:       market.MaxAmount,
+			MaxQuoteAmount:  market.MaxQuoteAmount,
+			LotSize:         market.LotSize,
+			MinNotional:     market.MinNotional,
+			AmountPrecision: int32(market.AmountPrecision),
+		},
+		Fees: matching.FeeSchedule{
+			Maker: trading_fee.Maker,
+			Taker: trading_fee.Taker,
+			Scale: models.SchemaDecimalScale,
+		},
+		CircuitBreaker: matching.CircuitBreakerConfig{
+			Rate:     market.CircuitBreakerRate,
+			Window:   market.CircuitBreakerWindow(),
+			CoolDown: market.CircuitBreakerCoolDown(),
+		},
+	}
+
+	// the trades of a fresh book are numbered after the ones executed and the ones the book before it could have
+	// published, its sequence started at the time it was built and it matched less than a trade a microsecond. A book
+	// restored from its log goes on from the sequence of its image instead
+	book_config.TradeSequence = models.LastTradeSequence(market.Symbol)
+	if now := time.Now().UnixMicro(); now > book_config.TradeSequence {
+		book_config.TradeSequence = now
+	}
+
+	if market.DailyPriceLimit.IsPositive() {
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		book_config.PreviousClose = models.GetLastCandleCloseFromInflux(market.Symbol, "1d", today)
+	}
+
+	// a market leaving the batch mode loads its orders in a batch, they're uncrossed once before it switches
+	previous := s.GetEngineBySymbol(symbol)
+	found := previous != nil
+	if found && previous.OrderBook.BatchInterval() > 0 {
+		book_config.BatchInterval = previous.OrderBook.BatchInterval()
+	} else {
+		book_config.BatchInterval = market.BatchInterval()
+	}
+
+	if s.Capture != nil {
+		book_config.Publisher = s.Capture.Publisher(matching.NewBusPublisher(symbol))
+	}
+
+	// the trades replayed from the log aren't broadcast again, the log gate stays the outermost publisher
+	if s.Streams != nil {
+		if book_config.Publisher == nil {
+			book_config.Publisher = matching.NewBusPublisher(symbol)
+		}
+		book_config.Publisher = s.Streams.Publisher(symbol, book_config.Publisher)
+	}
+
+	log := s.openLog(symbol)
+	if log != nil {
+		if book_config.Publisher == nil {
+			book_config.Publisher = matching.NewBusPublisher(symbol)
+		}
+		book_config.Publisher = log.Publisher(book_config.Publisher)
+	}
+
+	engine := matching.NewEngine(symbol, lastPrice, book_config)
+	if market.ListingOpensAt.Valid && time.Now().Before(market.ListingOpensAt.Time) {
+		engine.OrderBook.SetListing(&matching.ListingSchedule{
+			WarmupAt: market.ListingWarmupAt.Time,
+			OpensAt:  market.ListingOpensAt.Time,
+		})
+	}
+
+	// the book replaced must not open its listing nor uncross its batch, it would match the orders loaded in the new one too
+	if found {
+		previous.OrderBook.StopListing()
+		previous.OrderBook.StopBatch()
+		previous.OrderBook.StopAuction()
+		previous.OrderBook.StopExpirySweep()
+		// the subscribers of the depth diffs go on with the sequence of the book replaced
+		engine.OrderBook.Depth.Continue(previous.OrderBook.Depth)
+		// a reload neither lifts a tripped circuit breaker nor forgets the prices of its window
+		engine.OrderBook.ContinueCircuitBreaker(previous.OrderBook)
+		engine.OrderBook.ContinueIndexPrice(previous.OrderBook)
+		// a book reloaded during its call auction stays in it, the orders loaded wait for the uncross
+		engine.OrderBook.ContinueAuction(previous.OrderBook)
+		// the trades matched again after a reload aren't numbered as the ones of the book replaced
+		engine.OrderBook.ContinueTradeSequence(previous.OrderBook)
+	}
+
+	// a book replaced handed its subscribers over with its diffs, the clients subscribing from now on take the
+	// snapshots of the new one
+	if s.Streams != nil {
+		if !found {
+			engine.OrderBook.Depth.Subscribe(s.Streams)
+		}
+		s.Streams.Serve(engine)
+	}
+
+	// a book the engine didn't have yet is restored from its log, the commands processed since it was last
+	// snapshotted are replayed
+	if !found && log != nil && !log.Empty() {
+		replayed := log.Restore(engine)
+		config.Logger.Infof("%v engine restored from its log, %d commands replayed.", symbol.String(), replayed)
+	} else {
+		s.LoadOrders(engine)
+	}
+	// the orders the order processor didn't reprice yet are re-keyed again
+	if events.ProducesOrderReprices() {
+		engine.OrderBook.Reprice(int32(market.PricePrecision))
+	}
+	engine.OrderBook.SetBatchInterval(market.BatchInterval())
+	// the orders of a market being delisted or halted are loaded before it rejects the new ones
+	engine.OrderBook.SetTradingState(market.EngineTradingState())
 	engine.Initialized = true
+
+	// the book is served once it's loaded, the status and the other markets read the engines meanwhile
+	s.serveEngine(symbol, engine)
+
+	// the log goes on from the book as it's served now
+	if log != nil {
+		if err := log.Snapshot(engine, time.Now()); err != nil {
+			config.Logger.Errorf("Failed to snapshot the book of %s: %v", symbol.String(), err)
+		}
+	}
+
+	if s.Capture != nil {
+		if err := s.Capture.Book(engine, book_config, time.Now()); err != nil {
+			config.Logger.Errorf("Failed to capture the book of %s: %v", symbol.String(), err)
+		}
+	}
+
 	config.Logger.Infof("%v engine reloaded.", symbol.String())
 }
 
+// openLog returns the write-ahead log of the book of symbol, opened on the first call, nil when the books aren't
+// logged or the log can't be opened.
+func (s *EngineServer) openLog(symbol pkg.Symbol) *wal.Log {
+	if len(config.Engine.WALDir) == 0 {
+		return nil
+	}
+
+	if log, found := s.Logs[symbol]; found {
+		return log
+	}
+
+	log, err := wal.Open(config.Engine.WALDir, strings.ToLower(symbol.ToSymbol("")), config.Engine.WALSnapshotEvery)
+	if err != nil {
+		config.Logger.Errorf("Failed to open the log of %s, its book is loaded from the database: %v", symbol.String(), err)
+		return nil
+	}
+
+	s.Logs[symbol] = log
+
+	return log
+}
+
 func (s *EngineServer) LoadOrders(engine *matching.Engine) {
 	var orders []models.Order
 	config.DataBase.Where("market_id = ? AND state = ?", strings.ToLower(engine.Symbol.ToSymbol("")), models.StateWait).Order("id asc").Find(&orders)
 	for _, order := range orders {
-		engine.Submit(order.ToMatchingAttributes())
+		engine.SubmitWithOptions(order.ToMatchingAttributes(), order.MatchingOptions())
 	}
 }
+
+func (s *EngineServer) markets() []string {
+	engines := s.engines()
+	markets := make([]string, 0, len(engines))
+	for symbol := range engines {
+		markets = append(markets, strings.ToLower(symbol.ToSymbol("")))
+	}
+
+	return markets
+}
+
+// HaltMarkets stops the API from accepting orders for the markets of the engine while its consumer is down.
+func (s *EngineServer) HaltMarkets() {
+	result := config.DataBase.
+		Model(&models.Market{}).
+		Where("symbol IN ? AND state = ?", s.markets(), types.MarketStateEndabled).
+		Update("state", types.MarketStateHalted)
+	if result.Error != nil {
+		config.Logger.Errorf("Failed to halt markets: %v", result.Error)
+		return
+	}
+
+	config.Logger.Warnf("Halted %d markets", result.RowsAffected)
+}
+
+// ResumeMarkets enables the markets halted by HaltMarkets again.
+func (s *EngineServer) ResumeMarkets() {
+	result := config.DataBase.
+		Model(&models.Market{}).
+		Where("symbol IN ? AND state = ?", s.markets(), types.MarketStateHalted).
+		Update("state", types.MarketStateEndabled)
+	if result.Error != nil {
+		config.Logger.Errorf("Failed to resume markets: %v", result.Error)
+		return
+	}
+
+	config.Logger.Infof("Resumed %d markets", result.RowsAffected)
+}
@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+
+	"github.com/zsmartex/finex/matching"
+	"github.com/zsmartex/pkg"
+)
+
+var (
+	bookOrdersDesc = prometheus.NewDesc(
+		"finex_matching_book_orders",
+		"Orders resting in the book of the market, by side.",
+		[]string{"market", "side"}, nil,
+	)
+
+	commandBacklogDesc = prometheus.NewDesc(
+		"finex_matching_command_backlog",
+		"Commands of the market waiting in its lane for the engine.",
+		[]string{"market"}, nil,
+	)
+)
+
+// engineCollector reads the books and the lanes of the engines when Prometheus scrapes them, the matching path
+// doesn't keep them up to date.
+type engineCollector struct {
+	server *EngineServer
+}
+
+// Collector returns the collector of the books and the lanes of the engines of the server.
+func (s *EngineServer) Collector() prometheus.Collector {
+	return &engineCollector{server: s}
+}
+
+func (c *engineCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- bookOrdersDesc
+	descs <- commandBacklogDesc
+}
+
+func (c *engineCollector) Collect(metrics chan<- prometheus.Metric) {
+	for symbol, engine := range c.server.engines() {
+		market := matching.MarketLabel(symbol)
+
+		bids, asks := engine.OrderBook.Depth.OrderCounts()
+		metrics <- prometheus.MustNewConstMetric(bookOrdersDesc, prometheus.GaugeValue, float64(bids), market, string(pkg.SideBuy))
+		metrics <- prometheus.MustNewConstMetric(bookOrdersDesc, prometheus.GaugeValue, float64(asks), market, string(pkg.SideSell))
+
+		if lane := c.server.laneStatus(symbol); lane != nil {
+			metrics <- prometheus.MustNewConstMetric(commandBacklogDesc, prometheus.GaugeValue, float64(lane.Queued), market)
+		}
+	}
+}
+
+// serveMetrics serves the metrics of matching.PrometheusRegistry in the Prometheus format.
+func serveMetrics() fiber.Handler {
+	handler := fasthttpadaptor.NewFastHTTPHandler(promhttp.HandlerFor(matching.PrometheusRegistry, promhttp.HandlerOpts{}))
+
+	return func(c *fiber.Ctx) error {
+		handler(c.Context())
+		return nil
+	}
+}
@@ -0,0 +1,280 @@
+package engine
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/matching"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/streams"
+	"github.com/zsmartex/finex/types"
+	"github.com/zsmartex/pkg"
+)
+
+// publicStreamWriteTimeout is how long a write waits on a public client, a client not reading is closed.
+const publicStreamWriteTimeout = 10 * time.Second
+
+// publicTickerInterval is the time between two checks of the tickers of the books, a ticker is published when it moved.
+var publicTickerInterval = time.Second
+
+// GlobalTickersChannel carries the tickers of every market served by the engine.
+const GlobalTickersChannel = "global.tickers"
+
+var ErrPublicStreamUpgradeRequired = errors.New("stream.websocket.upgrade_required")
+
+// PublicStreamTrade is a trade published on the <market>.trades channel, Sequence numbers it among the trades of the
+// market.
+type PublicStreamTrade struct {
+	Sequence  int64           `json:"sequence"`
+	Market    string          `json:"market"`
+	Price     decimal.Decimal `json:"price"`
+	Amount    decimal.Decimal `json:"amount"`
+	Total     decimal.Decimal `json:"total"`
+	TakerType types.TakerType `json:"taker_type"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// PublicStreams serves the public channels of the books of the engine to websocket clients: the depth diffs of
+// <market>.depth after its snapshot, the trades of <market>.trades and the tickers of <market>.ticker and
+// global.tickers. The books are in the process, a client subscribing to a depth gets the diffs following its
+// snapshot without a gap.
+type PublicStreams struct {
+	Hub *streams.PublicHub
+
+	mutex sync.RWMutex
+	// markets are the engines served by market id
+	markets map[string]*matching.Engine
+	// tickers are the sequences of the last tickers published
+	tickers map[string]int64
+}
+
+func NewPublicStreams() *PublicStreams {
+	s := &PublicStreams{
+		Hub:     streams.NewPublicHub(streams.QueueSizes(), streams.NewMetrics(), config.StreamFanout.Shards),
+		markets: make(map[string]*matching.Engine),
+		tickers: make(map[string]int64),
+	}
+	s.Hub.Known = s.known
+	s.Hub.Snapshot = s.snapshot
+
+	return s
+}
+
+func publicMarket(symbol pkg.Symbol) string {
+	return strings.ToLower(symbol.ToSymbol(""))
+}
+
+// Serve publishes the channels of engine, an engine reloaded replaces the one of its market.
+func (s *PublicStreams) Serve(engine *matching.Engine) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.markets[publicMarket(engine.Symbol)] = engine
+}
+
+func (s *PublicStreams) engineOf(market string) *matching.Engine {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.markets[market]
+}
+
+// publicGroupAccess is what the clients of a group of markets see of the public channels: the channels of the
+// markets visible to the group, and their tickers only on global.tickers.
+type publicGroupAccess struct {
+	streams *PublicStreams
+	group   string
+}
+
+func (a *publicGroupAccess) Allowed(channel string) bool {
+	if channel == GlobalTickersChannel {
+		return true
+	}
+
+	dot := strings.LastIndexByte(channel, '.')
+
+	return a.streams.known(channel) && models.MarketVisibility.Visible(a.group, channel[:dot])
+}
+
+func (a *publicGroupAccess) Filter(channel string, payload interface{}) interface{} {
+	tickers, ok := payload.(map[string]matching.TickerSnapshot)
+	if channel != GlobalTickersChannel || !ok {
+		return payload
+	}
+
+	visible := models.MarketVisibility.Markets(a.group)
+	filtered := make(map[string]matching.TickerSnapshot, len(visible))
+	for market, ticker := range tickers {
+		if visible[market] {
+			filtered[market] = ticker
+		}
+	}
+
+	return filtered
+}
+
+func (s *PublicStreams) known(channel string) bool {
+	if channel == GlobalTickersChannel {
+		return true
+	}
+
+	dot := strings.LastIndexByte(channel, '.')
+	if dot < 0 {
+		return false
+	}
+
+	switch channel[dot+1:] {
+	case "depth", "trades", "ticker":
+		return s.engineOf(channel[:dot]) != nil
+	default:
+		return false
+	}
+}
+
+// snapshot queues the snapshot of the depth of a market with the book locked, the diffs it gets after are queued
+// behind it.
+func (s *PublicStreams) snapshot(channel string, queue func(snapshot interface{})) bool {
+	if !strings.HasSuffix(channel, ".depth") {
+		return false
+	}
+
+	engine := s.engineOf(strings.TrimSuffix(channel, ".depth"))
+	if engine == nil {
+		return false
+	}
+
+	engine.OrderBook.Depth.SnapshotThen(func(snapshot *matching.DepthSnapshot) {
+		queue(snapshot)
+	})
+
+	return true
+}
+
+// DepthDiff publishes the diffs of the books on <market>.depth.
+func (s *PublicStreams) DepthDiff(symbol pkg.Symbol, diff matching.DepthDiff) {
+	s.Hub.Publish(publicMarket(symbol)+".depth", diff)
+}
+
+// publicTradePublisher publishes the trades of a book on <market>.trades before handing them to the publisher of
+// the book.
+type publicTradePublisher struct {
+	matching.Publisher
+	streams *PublicStreams
+	market  string
+}
+
+// Publisher returns the publisher of the book of symbol publishing its trades on <market>.trades, then to next.
+func (s *PublicStreams) Publisher(symbol pkg.Symbol, next matching.Publisher) matching.Publisher {
+	return &publicTradePublisher{Publisher: next, streams: s, market: publicMarket(symbol)}
+}
+
+func (p *publicTradePublisher) PublishTrade(trade *pkg.Trade, stamp matching.TradeStamp) {
+	taker_type := types.TypeSell
+	if trade.TakerOrder.Side == pkg.SideBuy {
+		taker_type = types.TypeBuy
+	}
+
+	p.streams.Hub.Publish(p.market+".trades", &PublicStreamTrade{
+		Sequence:  stamp.Sequence,
+		Market:    p.market,
+		Price:     trade.Price,
+		Amount:    trade.Quantity,
+		Total:     trade.Total,
+		TakerType: taker_type,
+		CreatedAt: stamp.MatchedAt,
+	})
+
+	p.Publisher.PublishTrade(trade, stamp)
+}
+
+// PublishTickers publishes the ticker of every book which moved since it was last published on <market>.ticker, and
+// the tickers of every book on global.tickers when one of them moved, a client gets the ones of its market group.
+func (s *PublicStreams) PublishTickers() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tickers := make(map[string]matching.TickerSnapshot, len(s.markets))
+	moved := false
+	for market, engine := range s.markets {
+		ticker := engine.Ticker()
+		tickers[market] = ticker
+
+		if sequence, ok := s.tickers[market]; ok && sequence == ticker.Sequence {
+			continue
+		}
+
+		s.tickers[market] = ticker.Sequence
+		s.Hub.Publish(market+".ticker", ticker)
+		moved = true
+	}
+
+	if moved {
+		s.Hub.Publish(GlobalTickersChannel, tickers)
+	}
+}
+
+// ReportTickers publishes the tickers every publicTickerInterval, it never returns.
+func (s *PublicStreams) ReportTickers() {
+	for range time.Tick(publicTickerInterval) {
+		s.PublishTickers()
+	}
+}
+
+// NewPublicRouter serves the public channels on /api/v2/ws/public, a client starts with the channels of the
+// channels parameter, separated by commas, and subscribes to the others once connected. See docs/public_stream.md.
+func (s *PublicStreams) NewPublicRouter() *fiber.App {
+	app := fiber.New()
+
+	app.Get("/api/v2/ws/public", s.connect)
+
+	return app
+}
+
+func (s *PublicStreams) connect(c *fiber.Ctx) error {
+	key := c.Get(fiber.HeaderSecWebSocketKey)
+	if !strings.EqualFold(c.Get("Upgrade"), "websocket") || len(key) == 0 || c.Get(fiber.HeaderSecWebSocketVersion) != "13" {
+		c.Set(fiber.HeaderSecWebSocketVersion, "13")
+
+		return c.Status(426).JSON(helpers.Errors{
+			Errors: []string{ErrPublicStreamUpgradeRequired.Error()},
+		})
+	}
+
+	// the clients of a group see the markets of their group only, as on the API
+	access := &publicGroupAccess{streams: s, group: helpers.MarketGroup(c)}
+
+	var channels []string
+	for _, channel := range strings.Split(c.Query("channels"), ",") {
+		channel = strings.TrimSpace(channel)
+		if len(channel) == 0 {
+			continue
+		}
+
+		if !access.Allowed(channel) {
+			return c.Status(422).JSON(helpers.Errors{
+				Errors: []string{streams.ErrUnknownChannel.Error()},
+			})
+		}
+
+		channels = append(channels, channel)
+	}
+
+	c.Status(101)
+	c.Set("Upgrade", "websocket")
+	c.Set(fiber.HeaderConnection, "Upgrade")
+	c.Set(fiber.HeaderSecWebSocketAccept, streams.WebsocketAccept(key))
+
+	c.Context().Hijack(func(conn net.Conn) {
+		s.Hub.Serve(streams.NewWebsocket(conn, publicStreamWriteTimeout), channels, access)
+	})
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/matching"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/types"
+)
+
+// newGroupedPublicStreams serves btcusdt to every group and ethusdt to the vip group only, the group of
+// vip.example.com.
+func newGroupedPublicStreams(t *testing.T) *PublicStreams {
+	config.StreamFanout = &types.StreamFanoutConfig{}
+	config.MarketGroupDomains = map[string]string{"vip.example.com": "vip"}
+
+	visibility := models.MarketVisibility
+	models.MarketVisibility = models.NewMarketVisibilityCache(func() map[string]map[string]bool {
+		return models.BuildMarketVisibility([]string{"btcusdt", "ethusdt"}, []*models.MarketGroupMarket{
+			{GroupName: "vip", MarketID: "btcusdt"},
+			{GroupName: "vip", MarketID: "ethusdt"},
+			{GroupName: models.DefaultMarketGroup, MarketID: "btcusdt"},
+		})
+	})
+
+	s := NewPublicStreams()
+	t.Cleanup(func() {
+		s.Hub.Close()
+		models.MarketVisibility = visibility
+		config.MarketGroupDomains = nil
+	})
+
+	for _, symbol := range []pkg.Symbol{routerBTC, routerETH} {
+		s.Serve(matching.NewDetachedEngine(symbol, decimal.NewFromInt(100), matching.OrderBookConfig{Publisher: &nopPublisher{}}))
+	}
+
+	return s
+}
+
+func publicHandshake(s *PublicStreams, host, channels string) int {
+	request := httptest.NewRequest(fiber.MethodGet, "/api/v2/ws/public?channels="+channels, nil)
+	request.Host = host
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set(fiber.HeaderConnection, "Upgrade")
+	request.Header.Set(fiber.HeaderSecWebSocketKey, "dGhlIHNhbXBsZSBub25jZQ==")
+	request.Header.Set(fiber.HeaderSecWebSocketVersion, "13")
+
+	response, err := s.NewPublicRouter().Test(request)
+	if err != nil {
+		return 0
+	}
+
+	return response.StatusCode
+}
+
+func TestPublicStreamRefusesHiddenMarkets(t *testing.T) {
+	s := newGroupedPublicStreams(t)
+
+	if status := publicHandshake(s, "example.com", "btcusdt.depth,ethusdt.depth"); status != 422 {
+		t.Errorf("expected the handshake with a hidden market refused, got %d", status)
+	}
+
+	// a later subscription goes through the access of the connection
+	access := &publicGroupAccess{streams: s, group: models.DefaultMarketGroup}
+	for channel, allowed := range map[string]bool{
+		"btcusdt.trades": true,
+		"ethusdt.trades": false,
+		"ethusdt.ticker": false,
+		"dogeusdt.depth": false,
+		"global.tickers": true,
+	} {
+		if access.Allowed(channel) != allowed {
+			t.Errorf("expected %s allowed to the default group: %t", channel, allowed)
+		}
+	}
+
+	vip := &publicGroupAccess{streams: s, group: "vip"}
+	if !vip.Allowed("ethusdt.depth") {
+		t.Error("expected the vip group to see ethusdt")
+	}
+}
+
+func TestPublicStreamFiltersGlobalTickers(t *testing.T) {
+	s := newGroupedPublicStreams(t)
+
+	tickers := map[string]matching.TickerSnapshot{"btcusdt": {Sequence: 1}, "ethusdt": {Sequence: 2}}
+
+	access := &publicGroupAccess{streams: s, group: models.DefaultMarketGroup}
+	filtered := access.Filter(GlobalTickersChannel, tickers).(map[string]matching.TickerSnapshot)
+	if _, hidden := filtered["ethusdt"]; hidden || len(filtered) != 1 {
+		t.Errorf("expected the tickers of btcusdt only, got %v", filtered)
+	}
+
+	vip := &publicGroupAccess{streams: s, group: "vip"}
+	if filtered := vip.Filter(GlobalTickersChannel, tickers).(map[string]matching.TickerSnapshot); len(filtered) != 2 {
+		t.Errorf("expected the tickers of both markets, got %v", filtered)
+	}
+}
@@ -0,0 +1,225 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+)
+
+// defaultLaneCapacity is the number of commands of a market waiting for its engine when engine.lane_capacity isn't set.
+const defaultLaneCapacity = 1024
+
+// ErrRouterClosed refuses the commands routed once the router is closed, they're not committed and the broker
+// delivers them again to the next engine.
+var ErrRouterClosed = fmt.Errorf("engine router is closed: %w", ErrNotDispatched)
+
+// EngineRouter hands the commands of each market to a goroutine of its own, a lane, so a burst of commands of one
+// market doesn't delay the matching of the others. The lanes are started with the first command of their market and
+// process its commands in the order they're routed. A command which isn't of one market, a reload or a cancel all of
+// every market, waits for the lanes to be idle and is processed alone: it may replace the engines the lanes use.
+type EngineRouter struct {
+	// Park parks a command which can't be decoded or validated as a dead letter before it's done, the ones it fails
+	// to park are dropped. Nil drops them all
+	Park func(payload []byte, err error) error
+
+	handle   func(command *events.MatchingPayload) error
+	capacity int
+
+	// mutex guards the lanes against the status readers, only the goroutine routing the commands changes them
+	mutex  sync.RWMutex
+	lanes  map[pkg.Symbol]*lane
+	closed bool
+	// routed counts the commands in the lanes, a command of no market waits for it to be zero
+	routed sync.WaitGroup
+	// stopped counts the goroutines of the lanes, Close waits for them
+	stopped sync.WaitGroup
+}
+
+// lane is the queue of the commands of a market and the goroutine processing them.
+type lane struct {
+	commands chan routedCommand
+	// blocked counts the commands which waited for room in the lane, the backpressure of its market
+	blocked uint64
+}
+
+type routedCommand struct {
+	command *events.MatchingPayload
+	payload []byte
+	done    func()
+}
+
+// LaneStatus is the queue of the commands of a market shown by the engine status endpoint.
+type LaneStatus struct {
+	Queued   int    `json:"queued"`
+	Capacity int    `json:"capacity"`
+	Blocked  uint64 `json:"blocked"`
+}
+
+// NewEngineRouter returns a router processing the commands with handle, each lane holding capacity commands,
+// defaultLaneCapacity when it's not positive.
+func NewEngineRouter(handle func(command *events.MatchingPayload) error, capacity int) *EngineRouter {
+	if capacity <= 0 {
+		capacity = defaultLaneCapacity
+	}
+
+	return &EngineRouter{
+		handle:   handle,
+		capacity: capacity,
+		lanes:    make(map[pkg.Symbol]*lane),
+	}
+}
+
+// Route hands the command of payload to the lane of its market, done is called once the engine processed it. It's
+// called from one goroutine, the consumer one, and blocks while the lane is full. A command which can't be decoded is
+// parked and done right away; a command refused by the engine is logged and dropped by its lane.
+func (r *EngineRouter) Route(payload []byte, done func()) error {
+	command := new(events.MatchingPayload)
+	if err := json.Unmarshal(payload, command); err != nil {
+		r.drop(payload, events.Malformed(err))
+		done()
+		return nil
+	}
+
+	command.ReceivedAt = time.Now()
+
+	symbol, found := command.Market()
+	if !found {
+		return r.processAlone(command, payload, done)
+	}
+
+	l, err := r.lane(symbol)
+	if err != nil {
+		return err
+	}
+
+	routed := routedCommand{command: command, payload: payload, done: done}
+
+	r.routed.Add(1)
+	select {
+	case l.commands <- routed:
+	default:
+		atomic.AddUint64(&l.blocked, 1)
+		l.commands <- routed
+	}
+
+	return nil
+}
+
+// processAlone processes a command of no market once the lanes processed the commands routed before it.
+func (r *EngineRouter) processAlone(command *events.MatchingPayload, payload []byte, done func()) error {
+	r.mutex.RLock()
+	closed := r.closed
+	r.mutex.RUnlock()
+
+	if closed {
+		return ErrRouterClosed
+	}
+
+	r.routed.Wait()
+	defer done()
+
+	if err := r.handle(command); err != nil {
+		r.drop(payload, err)
+	}
+
+	return nil
+}
+
+// lane returns the lane of symbol, started when it's the first command of the market.
+func (r *EngineRouter) lane(symbol pkg.Symbol) (*lane, error) {
+	r.mutex.RLock()
+	l, found := r.lanes[symbol]
+	closed := r.closed
+	r.mutex.RUnlock()
+
+	if closed {
+		return nil, ErrRouterClosed
+	}
+
+	if found {
+		return l, nil
+	}
+
+	l = &lane{commands: make(chan routedCommand, r.capacity)}
+
+	r.mutex.Lock()
+	r.lanes[symbol] = l
+	r.mutex.Unlock()
+
+	r.stopped.Add(1)
+	go r.run(l)
+
+	return l, nil
+}
+
+func (r *EngineRouter) run(l *lane) {
+	defer r.stopped.Done()
+
+	for routed := range l.commands {
+		// a command the engine can't process is dropped, the lane goes on with the next one
+		if err := r.handle(routed.command); err != nil {
+			r.drop(routed.payload, err)
+		}
+
+		routed.done()
+		r.routed.Done()
+	}
+}
+
+// drop logs the command of payload the engine couldn't process, a malformed one is parked as a dead letter first.
+func (r *EngineRouter) drop(payload []byte, err error) {
+	if errors.Is(err, events.ErrMalformed) && r.Park != nil {
+		park_err := r.Park(payload, err)
+		if park_err == nil {
+			config.Logger.Warnf("Parked a malformed command: %v", err)
+			return
+		}
+
+		config.Logger.Errorf("Failed to park a malformed command: %v", park_err)
+	}
+
+	config.Logger.Errorf("Worker error: %v, dropped command: %s", err, string(payload))
+}
+
+// Close stops routing and waits for the lanes to process the commands they hold. The commands routed after it are
+// refused with ErrRouterClosed. It must not be called while a command is routed.
+func (r *EngineRouter) Close() {
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return
+	}
+
+	r.closed = true
+	for _, l := range r.lanes {
+		close(l.commands)
+	}
+	r.mutex.Unlock()
+
+	r.stopped.Wait()
+}
+
+// LaneStatus returns the queue of the commands of symbol, false when no command of the market was routed yet.
+func (r *EngineRouter) LaneStatus(symbol pkg.Symbol) (LaneStatus, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	l, found := r.lanes[symbol]
+	if !found {
+		return LaneStatus{}, false
+	}
+
+	return LaneStatus{
+		Queued:   len(l.commands),
+		Capacity: cap(l.commands),
+		Blocked:  atomic.LoadUint64(&l.blocked),
+	}, true
+}
@@ -0,0 +1,335 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/bus"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/matching"
+	"github.com/zsmartex/finex/types"
+)
+
+var (
+	routerBTC = pkg.Symbol{BaseCurrency: "BTC", QuoteCurrency: "USDT"}
+	routerETH = pkg.Symbol{BaseCurrency: "ETH", QuoteCurrency: "USDT"}
+)
+
+// routedPayload is a command of symbol tagged with id, a reload when symbol is empty.
+func routedPayload(t testing.TB, symbol pkg.Symbol, id string) []byte {
+	action := events.ActionTradingState
+	if len(symbol.BaseCurrency) == 0 {
+		action = pkg.ActionReload
+	}
+
+	payload, err := json.Marshal(&events.MatchingPayload{
+		MatchingPayloadMessage: pkg.MatchingPayloadMessage{Action: action, Symbol: symbol},
+		CommandID:              id,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return payload
+}
+
+func TestRouterKeepsTheOrderOfEachMarket(t *testing.T) {
+	var mutex sync.Mutex
+	processed := make(map[pkg.Symbol][]string)
+
+	router := NewEngineRouter(func(command *events.MatchingPayload) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		processed[command.Symbol] = append(processed[command.Symbol], command.CommandID)
+		return nil
+	}, 4)
+
+	var done int64
+	for i := 0; i < 100; i++ {
+		for _, symbol := range []pkg.Symbol{routerBTC, routerETH} {
+			err := router.Route(routedPayload(t, symbol, fmt.Sprint(i)), func() { atomic.AddInt64(&done, 1) })
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	router.Close()
+
+	if done != 200 {
+		t.Fatalf("expected the 200 commands to be done once the router closed, got %d", done)
+	}
+
+	for _, symbol := range []pkg.Symbol{routerBTC, routerETH} {
+		for i, id := range processed[symbol] {
+			if id != fmt.Sprint(i) {
+				t.Fatalf("expected the commands of %s in the order routed, got %s at %d", symbol.String(), id, i)
+			}
+		}
+	}
+}
+
+func TestRouterProcessesCommandsOfNoMarketAlone(t *testing.T) {
+	var running, overlapped int64
+	reloaded := make(chan struct{})
+
+	router := NewEngineRouter(func(command *events.MatchingPayload) error {
+		if command.Action == pkg.ActionReload {
+			if atomic.LoadInt64(&running) != 0 {
+				atomic.StoreInt64(&overlapped, 1)
+			}
+			close(reloaded)
+			return nil
+		}
+
+		atomic.AddInt64(&running, 1)
+		time.Sleep(time.Millisecond)
+		atomic.AddInt64(&running, -1)
+		return nil
+	}, 16)
+	defer router.Close()
+
+	for i := 0; i < 10; i++ {
+		router.Route(routedPayload(t, routerBTC, fmt.Sprint(i)), func() {})
+		router.Route(routedPayload(t, routerETH, fmt.Sprint(i)), func() {})
+	}
+
+	reload_done := false
+	if err := router.Route(routedPayload(t, pkg.Symbol{}, "reload"), func() { reload_done = true }); err != nil {
+		t.Fatal(err)
+	}
+
+	// the reload is processed in the goroutine routing it, once the lanes are idle
+	select {
+	case <-reloaded:
+	default:
+		t.Fatal("expected the reload to be processed before Route returned")
+	}
+
+	if overlapped != 0 || !reload_done {
+		t.Error("expected the reload to wait for the commands routed before it")
+	}
+}
+
+func TestRouterReportsBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	router := NewEngineRouter(func(command *events.MatchingPayload) error {
+		<-release
+		return nil
+	}, 1)
+
+	// the lane takes the first command, holds the second and makes the third wait for room
+	router.Route(routedPayload(t, routerBTC, "1"), func() {})
+	router.Route(routedPayload(t, routerBTC, "2"), func() {})
+
+	routed := make(chan struct{})
+	go func() {
+		router.Route(routedPayload(t, routerBTC, "3"), func() {})
+		close(routed)
+	}()
+
+	for {
+		if status, _ := router.LaneStatus(routerBTC); status.Blocked == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, found := router.LaneStatus(routerETH); found {
+		t.Error("expected no lane for a market without commands")
+	}
+
+	close(release)
+	<-routed
+	router.Close()
+
+	if status, _ := router.LaneStatus(routerBTC); status.Queued != 0 || status.Capacity != 1 {
+		t.Errorf("expected the lane to be drained, got %+v", status)
+	}
+}
+
+// The latency of a command counts the time it waited in its lane behind a slow one.
+func TestRouterLatencyCountsTheQueueWait(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	config.Logger = logrus.NewEntry(logger)
+	config.Engine = &types.EngineConfig{}
+
+	server := &EngineServer{Engines: make(map[pkg.Symbol]*matching.Engine)}
+	engine := matching.NewDetachedEngine(routerBTC, decimal.NewFromInt(100), matching.OrderBookConfig{Publisher: &nopPublisher{}})
+	engine.Initialized = true
+	server.serveEngine(routerBTC, engine)
+
+	release := make(chan struct{})
+	router := NewEngineRouter(func(command *events.MatchingPayload) error {
+		if command.Order.ID == 1 {
+			<-release
+		}
+
+		return server.ProcessCommand(command)
+	}, 4)
+
+	for id := int64(1); id <= 2; id++ {
+		payload, err := json.Marshal(&events.MatchingPayload{
+			MatchingPayloadMessage: pkg.MatchingPayloadMessage{Action: pkg.ActionSubmit, Order: statusOrder(id, routerBTC, pkg.SideBuy, "100")},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := router.Route(payload, func() {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	router.Close()
+
+	if count := engine.Metrics.Latency.Count(); count != 2 {
+		t.Fatalf("expected the cycles of the 2 commands, got %d", count)
+	}
+
+	if latency := engine.Metrics.Latency.Quantile(0.5); latency < 50*time.Millisecond {
+		t.Errorf("expected the 50ms the commands waited in the latency of both, got %s", latency)
+	}
+}
+
+func TestClosedRouterRefusesCommands(t *testing.T) {
+	router := NewEngineRouter(func(command *events.MatchingPayload) error { return nil }, 0)
+	router.Route(routedPayload(t, routerBTC, "1"), func() {})
+	router.Close()
+
+	done := false
+	err := router.Route(routedPayload(t, routerBTC, "2"), func() { done = true })
+	if !errors.Is(err, ErrNotDispatched) || done {
+		t.Errorf("expected the command to be left to the broker, got %v", err)
+	}
+
+	if status, _ := router.LaneStatus(routerBTC); status.Capacity != defaultLaneCapacity {
+		t.Errorf("expected the default capacity, got %d", status.Capacity)
+	}
+}
+
+func TestCommitterCommitsInPollOrder(t *testing.T) {
+	var committed []string
+	commits := newCommitter(func(records ...*bus.Message) error {
+		for _, record := range records {
+			committed = append(committed, string(record.Value))
+		}
+		return nil
+	})
+
+	first := commits.Add(&bus.Message{Value: []byte("1")})
+	second := commits.Add(&bus.Message{Value: []byte("2")})
+	third := commits.Add(&bus.Message{Value: []byte("3")})
+	fourth := commits.Add(&bus.Message{Value: []byte("4")})
+
+	// the commands of another market are done first, their records wait for the ones polled before them
+	third.Done()
+	second.Done()
+	first.Done()
+	fourth.Skip()
+	commits.Add(&bus.Message{Value: []byte("5")}).Done()
+
+	commits.Close()
+
+	if fmt.Sprint(committed) != "[1 2 3]" {
+		t.Errorf("expected the records before the one skipped to be committed in order, got %v", committed)
+	}
+}
+
+// BenchmarkEngineRouter routes commands holding their engine for the same time, as a log written to disk does, to one
+// market and to two: the commands of the two markets don't wait for each other and take about half the time of one.
+func BenchmarkEngineRouter(b *testing.B) {
+	slow := func(command *events.MatchingPayload) error {
+		time.Sleep(100 * time.Microsecond)
+		return nil
+	}
+
+	for _, bench := range []struct {
+		name    string
+		markets []pkg.Symbol
+	}{
+		{"one market", []pkg.Symbol{routerBTC}},
+		{"two markets", []pkg.Symbol{routerBTC, routerETH}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			payloads := make([][]byte, len(bench.markets))
+			for i, symbol := range bench.markets {
+				payloads[i] = routedPayload(b, symbol, "1")
+			}
+
+			router := NewEngineRouter(slow, 0)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				router.Route(payloads[i%len(payloads)], func() {})
+			}
+
+			router.Close()
+		})
+	}
+}
+
+func TestRouterParksMalformedCommands(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	config.Logger = logrus.NewEntry(logger)
+
+	router := NewEngineRouter(func(command *events.MatchingPayload) error {
+		if command.CommandID == "invalid" {
+			return events.Malformed(errors.New("submit without an order"))
+		}
+
+		return errors.New("order not found")
+	}, 0)
+
+	var mutex sync.Mutex
+	var parked []string
+	router.Park = func(payload []byte, err error) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if !errors.Is(err, events.ErrMalformed) {
+			t.Errorf("expected only the malformed commands parked, got %v", err)
+		}
+
+		parked = append(parked, string(payload))
+		return nil
+	}
+
+	var done int32
+	count := func() { atomic.AddInt32(&done, 1) }
+
+	truncated := []byte(`{"action":"submit","order":{"id":`)
+	if err := router.Route(truncated, count); err != nil {
+		t.Errorf("expected the command which can't be decoded to be taken, got %v", err)
+	}
+
+	invalid := routedPayload(t, routerBTC, "invalid")
+	router.Route(invalid, count)
+	router.Route(routedPayload(t, routerBTC, "refused"), count)
+	router.Route(routedPayload(t, pkg.Symbol{}, "invalid"), count)
+	router.Close()
+
+	if got := atomic.LoadInt32(&done); got != 4 {
+		t.Errorf("expected the 4 commands done, got %d", got)
+	}
+
+	if len(parked) != 3 || parked[0] != string(truncated) || parked[1] != string(invalid) {
+		t.Errorf("expected the truncated and the invalid commands parked, got %v", parked)
+	}
+}
@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/matching"
+)
+
+// defaultShutdownTimeout bounds the shutdown of the engine when engine.shutdown_timeout isn't set.
+const defaultShutdownTimeout = 20 * time.Second
+
+// ShutdownTimeout is engine.shutdown_timeout, defaulted when it's not set.
+func ShutdownTimeout() time.Duration {
+	return orDefault(config.Engine.ShutdownTimeout, defaultShutdownTimeout)
+}
+
+// Shutdown stops the engine without losing the commands it accepted: the consumer stops polling and waits for the
+// lanes to process the commands dispatched and their records to be committed, the last depth frames are published,
+// then the books are snapshotted to their logs and the capture is closed. The commands polled after the consumer
+// stopped aren't committed, the broker delivers them to the next engine.
+//
+// It returns an error when the shutdown takes longer than timeout, the process exits anyway: the records not
+// committed yet are delivered again to the next engine.
+func (s *EngineServer) Shutdown(consumer *ConsumerSupervisor, timeout time.Duration) error {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		s.shutdown(consumer)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("engine didn't shut down in %s", timeout)
+	}
+}
+
+func (s *EngineServer) shutdown(consumer *ConsumerSupervisor) {
+	if consumer != nil {
+		consumer.Stop()
+	}
+
+	if s.Router != nil {
+		s.Router.Close()
+	}
+
+	now := time.Now()
+	served := s.engines()
+	engines := make([]*matching.Engine, 0, len(served))
+	for _, engine := range served {
+		// the timers of the books would match after their snapshots
+		engine.OrderBook.StopListing()
+		engine.OrderBook.StopBatch()
+		engine.OrderBook.StopExpirySweep()
+
+		engine.OrderBook.Depth.Notification.PublishFrame(now)
+		engines = append(engines, engine)
+	}
+
+	matching.DepthBatches.Publish()
+
+	for symbol, log := range s.Logs {
+		if engine, found := served[symbol]; found {
+			if err := log.Snapshot(engine, now); err != nil {
+				config.Logger.Errorf("Failed to snapshot the book of %s: %v", symbol.String(), err)
+			}
+		}
+
+		if err := log.Close(); err != nil {
+			config.Logger.Errorf("Failed to close the log of %s: %v", symbol.String(), err)
+		}
+	}
+
+	if s.Capture != nil {
+		if err := s.Capture.Close(engines, now); err != nil {
+			config.Logger.Errorf("Failed to close the engine capture: %v", err)
+		}
+	}
+
+	config.Logger.Info("Engine shut down.")
+}
@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/bus"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/matching"
+	"github.com/zsmartex/finex/types"
+)
+
+// fakeBroker holds the records of a topic, a consumer polls them from the last one committed as a group does.
+type fakeBroker struct {
+	mutex     sync.Mutex
+	records   []*bus.Message
+	committed int
+	err       error
+}
+
+func (b *fakeBroker) consumer() (bus.Consumer, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return &fakeConsumer{broker: b, position: b.committed}, nil
+}
+
+type fakeConsumer struct {
+	broker   *fakeBroker
+	position int
+	closed   bool
+}
+
+func (c *fakeConsumer) Poll() ([]*bus.Message, error) {
+	c.broker.mutex.Lock()
+	defer c.broker.mutex.Unlock()
+
+	end := c.position + 10
+	if end > len(c.broker.records) {
+		end = len(c.broker.records)
+	}
+
+	records := c.broker.records[c.position:end]
+	c.position = end
+
+	if len(records) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	return records, nil
+}
+
+// Commit commits the records, the key of a record is its offset and they must be committed in order.
+func (c *fakeConsumer) Commit(records ...*bus.Message) error {
+	c.broker.mutex.Lock()
+	defer c.broker.mutex.Unlock()
+
+	for _, record := range records {
+		if c.closed {
+			c.broker.err = fmt.Errorf("record %s committed after its consumer was closed", record.Key)
+		}
+
+		if offset, _ := strconv.Atoi(string(record.Key)); offset != c.broker.committed {
+			c.broker.err = fmt.Errorf("record %s committed after record %d", record.Key, c.broker.committed-1)
+		}
+
+		c.broker.committed++
+	}
+
+	return nil
+}
+
+func (c *fakeConsumer) Close() {
+	c.broker.mutex.Lock()
+	defer c.broker.mutex.Unlock()
+
+	c.closed = true
+}
+
+func TestShutdownLosesNoAcceptedCommand(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	config.Logger = logrus.NewEntry(logger)
+	config.Engine = &types.EngineConfig{HeartbeatInterval: time.Hour}
+
+	broker := &fakeBroker{}
+	for i := 0; i < 400; i++ {
+		symbol := routerBTC
+		if i%2 == 1 {
+			symbol = routerETH
+		}
+
+		broker.records = append(broker.records, &bus.Message{
+			Topic: "matching",
+			Key:   []byte(strconv.Itoa(i)),
+			Value: routedPayload(t, symbol, strconv.Itoa(i)),
+		})
+	}
+
+	var mutex sync.Mutex
+	processed := make(map[string]int)
+
+	// serve runs an engine on the broker until count commands were processed, then shuts it down
+	serve := func(count int) {
+		router := NewEngineRouter(func(command *events.MatchingPayload) error {
+			time.Sleep(100 * time.Microsecond)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			processed[command.CommandID]++
+			return nil
+		}, 8)
+
+		server := &EngineServer{Engines: make(map[pkg.Symbol]*matching.Engine), Router: router}
+		consumer := newConsumerSupervisor([]string{"matching"}, router.Route, broker.consumer)
+
+		go consumer.Run()
+
+		for {
+			mutex.Lock()
+			total := len(processed)
+			mutex.Unlock()
+
+			if total >= count {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		if err := server.Shutdown(consumer, 10*time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	serve(100)
+
+	broker.mutex.Lock()
+	committed := broker.committed
+	broker.mutex.Unlock()
+
+	mutex.Lock()
+	// every command taken before the shutdown was processed and committed, the ones after it are left to the broker
+	if len(processed) != committed {
+		t.Errorf("expected the %d commands committed to be the ones processed, got %d processed", committed, len(processed))
+	}
+
+	for i := 0; i < committed; i++ {
+		if processed[strconv.Itoa(i)] != 1 {
+			t.Errorf("expected command %d committed to be processed once, got %d", i, processed[strconv.Itoa(i)])
+		}
+	}
+	mutex.Unlock()
+
+	// the next engine goes on from the last record committed
+	serve(len(broker.records))
+
+	for i := range broker.records {
+		if processed[strconv.Itoa(i)] != 1 {
+			t.Errorf("expected command %d to be processed once across the shutdown, got %d", i, processed[strconv.Itoa(i)])
+		}
+	}
+
+	if broker.err != nil {
+		t.Error(broker.err)
+	}
+
+	if broker.committed != len(broker.records) {
+		t.Errorf("expected the %d records committed, got %d", len(broker.records), broker.committed)
+	}
+}
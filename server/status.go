@@ -0,0 +1,369 @@
+package engine
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/matching"
+	"github.com/zsmartex/finex/types"
+	"github.com/zsmartex/pkg"
+)
+
+type EngineStatus struct {
+	Market       string                     `json:"market"`
+	Initialized  bool                       `json:"initialized"`
+	MarketPrice  decimal.Decimal            `json:"market_price"`
+	FeatureFlags map[types.FeatureFlag]bool `json:"feature_flags"`
+	Cycles       *CycleStatus               `json:"cycles"`
+	// ConsumerDown is set while the engine can't receive the orders of the market from the broker
+	ConsumerDown bool `json:"consumer_down"`
+	// Lane is the queue of the commands of the market, nil before its first command
+	Lane *LaneStatus `json:"lane"`
+}
+
+// CycleStatus summarizes the matching cycles of a market since the engine started,
+// latencies are the upper bounds of the histogram buckets holding the percentiles.
+type CycleStatus struct {
+	Count           uint64   `json:"count"`
+	P50             float64  `json:"p50_ms"`
+	P95             float64  `json:"p95_ms"`
+	P99             float64  `json:"p99_ms"`
+	SlowCycles      uint64   `json:"slow_cycles"`
+	MaxCascadeDepth int      `json:"max_cascade_depth"`
+	CascadeDepths   []uint64 `json:"cascade_depths"`
+	// InvariantViolations is how many invariant violations the checks of the book found
+	InvariantViolations uint64 `json:"invariant_violations"`
+}
+
+// metricsReportPeriod is how often the cycle metrics of every market are written to InfluxDB.
+var metricsReportPeriod = time.Minute
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func NewCycleStatus(metrics *matching.CycleMetrics) *CycleStatus {
+	return &CycleStatus{
+		Count:               metrics.Latency.Count(),
+		P50:                 milliseconds(metrics.Latency.Quantile(0.5)),
+		P95:                 milliseconds(metrics.Latency.Quantile(0.95)),
+		P99:                 milliseconds(metrics.Latency.Quantile(0.99)),
+		SlowCycles:          metrics.SlowCycles(),
+		MaxCascadeDepth:     metrics.MaxCascadeDepth(),
+		CascadeDepths:       metrics.CascadeDepths(),
+		InvariantViolations: metrics.InvariantViolations(),
+	}
+}
+
+func (s *EngineServer) Status() []*EngineStatus {
+	engines := s.engines()
+	statuses := make([]*EngineStatus, 0, len(engines))
+	consumer := s.ConsumerStatus()
+
+	for symbol, engine := range engines {
+		statuses = append(statuses, &EngineStatus{
+			Market:       strings.ToLower(symbol.ToSymbol("")),
+			Initialized:  engine.Initialized,
+			MarketPrice:  engine.OrderBook.LastPrice(),
+			FeatureFlags: engine.OrderBook.Flags.Map(),
+			Cycles:       NewCycleStatus(engine.Metrics),
+			ConsumerDown: consumer.Down,
+			Lane:         s.laneStatus(symbol),
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Market < statuses[j].Market
+	})
+
+	return statuses
+}
+
+// laneStatus returns the queue of the commands of symbol, nil without a router or before its first command.
+func (s *EngineServer) laneStatus(symbol pkg.Symbol) *LaneStatus {
+	if s.Router == nil {
+		return nil
+	}
+
+	lane, found := s.Router.LaneStatus(symbol)
+	if !found {
+		return nil
+	}
+
+	return &lane
+}
+
+// ConsumerStatus is the health of the broker consumer, an engine without one is always up.
+func (s *EngineServer) ConsumerStatus() *ConsumerStatus {
+	if s.Consumer == nil {
+		return &ConsumerStatus{}
+	}
+
+	return s.Consumer.Status()
+}
+
+// ReportMetrics writes the cycle metrics of every market to InfluxDB until the process exits.
+func (s *EngineServer) ReportMetrics() {
+	ticker := time.NewTicker(metricsReportPeriod)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		consumer := s.ConsumerStatus()
+		outage := time.Duration(0)
+		if consumer.DownSince != nil {
+			outage = now.Sub(*consumer.DownSince)
+		}
+
+		config.InfluxDB.NewPoint("matching_consumer", map[string]string{}, map[string]interface{}{
+			"down":           consumer.Down,
+			"halted":         consumer.Halted,
+			"reconnects":     int64(consumer.Reconnects),
+			"outage_seconds": outage.Seconds(),
+		})
+
+		for _, status := range s.Status() {
+			config.InfluxDB.NewPoint("matching_cycles", map[string]string{"market": status.Market}, map[string]interface{}{
+				"count":                int64(status.Cycles.Count),
+				"p50_ms":               status.Cycles.P50,
+				"p95_ms":               status.Cycles.P95,
+				"p99_ms":               status.Cycles.P99,
+				"slow_cycles":          int64(status.Cycles.SlowCycles),
+				"max_cascade_depth":    status.Cycles.MaxCascadeDepth,
+				"invariant_violations": int64(status.Cycles.InvariantViolations),
+			})
+		}
+	}
+}
+
+// CheckInvariants checks the invariants of every book every engine.invariant_check_interval until the process exits.
+func (s *EngineServer) CheckInvariants() {
+	if config.Engine.InvariantCheckInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(config.Engine.InvariantCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, status := range s.Status() {
+			if !status.Initialized {
+				continue
+			}
+
+			if _, err := s.CheckMarketInvariants(status.Market); err != nil {
+				config.Logger.Errorf("Failed to check the invariants of %s: %v", status.Market, err)
+			}
+		}
+	}
+}
+
+// CheckMarketInvariants checks the invariants of the book of market, see Engine.CheckInvariants.
+func (s *EngineServer) CheckMarketInvariants(market string) (*matching.InvariantCheck, error) {
+	engine, err := s.engineOf(market)
+	if err != nil {
+		return nil, err
+	}
+
+	violations := engine.CheckInvariants(config.Engine.InvariantViolationHalts)
+
+	return &matching.InvariantCheck{
+		Market:     market,
+		Violations: violations,
+		Halted:     len(violations) > 0 && config.Engine.InvariantViolationHalts,
+	}, nil
+}
+
+// NewStatusRouter serves the state of the engines of this process for operators.
+func (s *EngineServer) NewStatusRouter() *fiber.App {
+	app := fiber.New()
+
+	app.Get("/status", func(c *fiber.Ctx) error {
+		return c.Status(200).JSON(s.Status())
+	})
+
+	app.Get("/status/consumer", func(c *fiber.Ctx) error {
+		return c.Status(200).JSON(s.ConsumerStatus())
+	})
+
+	// the Prometheus metrics of the engines, see docs/engine_metrics.md
+	app.Get("/metrics", serveMetrics())
+
+	// the resting orders of a side of a market in priority order, /members shows who they belong to
+	app.Get("/orders/:market", func(c *fiber.Ctx) error {
+		return s.serveOrdersSnapshot(c, false)
+	})
+
+	app.Get("/orders/:market/members", func(c *fiber.Ctx) error {
+		return s.serveOrdersSnapshot(c, true)
+	})
+
+	// what's ahead of an order resting in the book of a market, 404 once it left the book
+	app.Get("/orders/:market/:id/position", func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+		if err != nil {
+			return c.Status(422).JSON(helpers.Errors{Errors: []string{"engine.orders.invalid_id"}})
+		}
+
+		position, err := s.PositionOf(c.Params("market"), id)
+		if err != nil {
+			return c.Status(404).JSON(helpers.Errors{Errors: []string{err.Error()}})
+		}
+
+		return c.Status(200).JSON(position)
+	})
+
+	// the depth of a market with its levels grouped by a price step, the API serves it for depth?step=
+	app.Get("/depth/:market", func(c *fiber.Ctx) error {
+		query := &depthQuery{Limit: depthLimit}
+		if err := c.QueryParser(query); err != nil || query.Step.IsNegative() {
+			return c.Status(422).JSON(helpers.Errors{Errors: []string{"engine.depth.invalid_query"}})
+		}
+
+		if query.Limit <= 0 || query.Limit > depthLimit {
+			return c.Status(422).JSON(helpers.Errors{Errors: []string{"engine.depth.invalid_limit"}})
+		}
+
+		depth, err := s.Depth(c.Params("market"), query.Limit, query.Step)
+		if err != nil {
+			return c.Status(404).JSON(helpers.Errors{Errors: []string{err.Error()}})
+		}
+
+		return c.Status(200).JSON(depth)
+	})
+
+	// the latest trades of a market from the tape of its book, the newest first, the API serves them for trades
+	app.Get("/trades/:market", func(c *fiber.Ctx) error {
+		query := &recentTradesQuery{Limit: matching.TradeTapeSize}
+		if err := c.QueryParser(query); err != nil || query.SinceID < 0 {
+			return c.Status(422).JSON(helpers.Errors{Errors: []string{"engine.trades.invalid_query"}})
+		}
+
+		if query.Limit <= 0 || query.Limit > matching.TradeTapeSize {
+			return c.Status(422).JSON(helpers.Errors{Errors: []string{"engine.trades.invalid_limit"}})
+		}
+
+		trades, err := s.RecentTrades(c.Params("market"), query.Limit, query.SinceID)
+		if err != nil {
+			return c.Status(404).JSON(helpers.Errors{Errors: []string{err.Error()}})
+		}
+
+		return c.Status(200).JSON(trades)
+	})
+
+	// checks the invariants of the book of a market on demand, the violations are handled as the periodic checks' are
+	app.Post("/invariants/:market", func(c *fiber.Ctx) error {
+		check, err := s.CheckMarketInvariants(c.Params("market"))
+		if err != nil {
+			return c.Status(404).JSON(helpers.Errors{Errors: []string{err.Error()}})
+		}
+
+		return c.Status(200).JSON(check)
+	})
+
+	return app
+}
+
+// ordersSnapshotLimit is the most orders an order-by-order snapshot returns, and what it returns by default.
+const ordersSnapshotLimit = 1000
+
+// ErrEngineNotFound is returned for a market without an engine in this process.
+var ErrEngineNotFound = errors.New("engine.not_found")
+
+// engineOf returns the engine of market, ErrEngineNotFound when this process has none.
+func (s *EngineServer) engineOf(market string) (*matching.Engine, error) {
+	for symbol, engine := range s.engines() {
+		if strings.ToLower(symbol.ToSymbol("")) == market {
+			return engine, nil
+		}
+	}
+
+	return nil, ErrEngineNotFound
+}
+
+// OrdersSnapshot returns the first limit orders resting on side of the book of market, see Engine.OrdersSnapshot.
+func (s *EngineServer) OrdersSnapshot(market string, side pkg.OrderSide, limit int, members bool) ([]*matching.RestingOrder, error) {
+	engine, err := s.engineOf(market)
+	if err != nil {
+		return nil, err
+	}
+
+	return engine.OrdersSnapshot(side, limit, members), nil
+}
+
+// PositionOf returns the position of the order of id in the book of market, see Engine.PositionOf.
+func (s *EngineServer) PositionOf(market string, id int64) (*matching.QueuePosition, error) {
+	engine, err := s.engineOf(market)
+	if err != nil {
+		return nil, err
+	}
+
+	return engine.PositionOf(id)
+}
+
+// Depth returns the best limit groups of each side of the book of market, see Engine.Depth.
+func (s *EngineServer) Depth(market string, limit int, step decimal.Decimal) (*matching.GroupedDepth, error) {
+	engine, err := s.engineOf(market)
+	if err != nil {
+		return nil, err
+	}
+
+	return engine.Depth(limit, step), nil
+}
+
+// RecentTrades returns up to limit of the trades of the book of market after since_id, see Engine.RecentTrades.
+func (s *EngineServer) RecentTrades(market string, limit int, since_id int64) ([]matching.TapeTrade, error) {
+	engine, err := s.engineOf(market)
+	if err != nil {
+		return nil, err
+	}
+
+	return engine.RecentTrades(limit, since_id), nil
+}
+
+type recentTradesQuery struct {
+	Limit   int   `query:"limit"`
+	SinceID int64 `query:"since_id"`
+}
+
+// depthLimit is the most groups of each side a grouped depth returns, and what it returns by default.
+const depthLimit = 1000
+
+type depthQuery struct {
+	Limit int             `query:"limit"`
+	Step  decimal.Decimal `query:"step"`
+}
+
+type ordersSnapshotQuery struct {
+	Side  pkg.OrderSide `query:"side"`
+	Limit int           `query:"limit"`
+}
+
+func (s *EngineServer) serveOrdersSnapshot(c *fiber.Ctx, members bool) error {
+	query := &ordersSnapshotQuery{Limit: ordersSnapshotLimit}
+	if err := c.QueryParser(query); err != nil {
+		return c.Status(422).JSON(helpers.Errors{Errors: []string{"engine.orders.invalid_query"}})
+	}
+
+	if query.Side != pkg.SideSell && query.Side != pkg.SideBuy {
+		return c.Status(422).JSON(helpers.Errors{Errors: []string{"engine.orders.invalid_side"}})
+	}
+
+	if query.Limit <= 0 || query.Limit > ordersSnapshotLimit {
+		return c.Status(422).JSON(helpers.Errors{Errors: []string{"engine.orders.invalid_limit"}})
+	}
+
+	orders, err := s.OrdersSnapshot(c.Params("market"), query.Side, query.Limit, members)
+	if err != nil {
+		return c.Status(404).JSON(helpers.Errors{Errors: []string{err.Error()}})
+	}
+
+	return c.Status(200).JSON(orders)
+}
@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/matching"
+)
+
+type nopPublisher struct{}
+
+func (p *nopPublisher) PublishTrade(trade *pkg.Trade, stamp matching.TradeStamp) {}
+
+func (p *nopPublisher) PublishCancel(key *pkg.OrderKey, reason matching.CancelReason) {}
+
+func (p *nopPublisher) PublishCancelOutcome(key *pkg.OrderKey, outcome matching.CancelOutcome, command_id string) {
+}
+
+func (p *nopPublisher) PublishReplace(replaced_key *pkg.OrderKey, order *pkg.Order, accepted bool) {
+}
+
+func (p *nopPublisher) PublishDecrement(key *pkg.OrderKey, quantity decimal.Decimal, reason matching.CancelReason) {
+}
+
+func (p *nopPublisher) PublishReprice(key *pkg.OrderKey, reason matching.CancelReason) {}
+
+func (p *nopPublisher) PublishAmend(key *pkg.OrderKey, quantity decimal.Decimal, accepted bool) {}
+
+func (p *nopPublisher) PublishBatch(batch_id string, entries []*events.BatchEntry) {}
+
+func (p *nopPublisher) PublishCircuitBreak(circuit_break *matching.CircuitBreak) {}
+
+func statusOrder(id int64, symbol pkg.Symbol, side pkg.OrderSide, price string) *pkg.Order {
+	return &pkg.Order{
+		ID:        id,
+		UUID:      uuid.New(),
+		Symbol:    symbol,
+		MemberID:  id,
+		Side:      side,
+		Type:      pkg.TypeLimit,
+		Price:     decimal.RequireFromString(price),
+		Quantity:  decimal.NewFromInt(1),
+		CreatedAt: time.Now(),
+	}
+}
+
+// The status is polled while the markets are reloaded and matched.
+func TestStatusWhileReloading(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	config.Logger = logrus.NewEntry(logger)
+
+	server := &EngineServer{Engines: make(map[pkg.Symbol]*matching.Engine)}
+	load := func(symbol pkg.Symbol) *matching.Engine {
+		engine := matching.NewDetachedEngine(symbol, decimal.NewFromInt(100), matching.OrderBookConfig{Publisher: &nopPublisher{}})
+		engine.Initialized = true
+
+		return engine
+	}
+
+	symbols := []pkg.Symbol{routerBTC, routerETH}
+	for _, symbol := range symbols {
+		server.serveEngine(symbol, load(symbol))
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			if statuses := server.Status(); len(statuses) != len(symbols) {
+				t.Errorf("expected the status of %d markets, got %d", len(symbols), len(statuses))
+				return
+			}
+			time.Sleep(10 * time.Microsecond)
+		}
+	}()
+
+	id := int64(0)
+	for reload := 0; reload < 100; reload++ {
+		for _, symbol := range symbols {
+			engine := load(symbol)
+			server.serveEngine(symbol, engine)
+
+			// a trade moves the market price the status reads
+			for _, side := range []pkg.OrderSide{pkg.SideSell, pkg.SideBuy} {
+				id++
+				engine.Submit(statusOrder(id, symbol, side, "101"))
+			}
+		}
+	}
+
+	close(done)
+	wg.Wait()
+
+	for _, status := range server.Status() {
+		if !status.MarketPrice.Equal(decimal.NewFromInt(101)) {
+			t.Errorf("expected %s at the price of its last trade, got %s", status.Market, status.MarketPrice)
+		}
+	}
+}
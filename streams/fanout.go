@@ -0,0 +1,323 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/zsmartex/finex/config"
+)
+
+// ChannelKind groups the channels sharing a drop policy.
+type ChannelKind string
+
+var (
+	KindDepth   ChannelKind = "depth"
+	KindTrades  ChannelKind = "trades"
+	KindTicker  ChannelKind = "ticker"
+	KindKline   ChannelKind = "kline"
+	KindPrivate ChannelKind = "private"
+)
+
+var ChannelKinds = []ChannelKind{KindDepth, KindTrades, KindTicker, KindKline, KindPrivate}
+
+// DropPolicy is what a connection does with the updates of a channel it can't queue anymore.
+type DropPolicy int
+
+const (
+	// DropResync drops the updates queued on the channel and queues a resync notice in their place, the client
+	// gets the channel again from a snapshot. A snapshot supersedes the updates queued before it.
+	DropResync DropPolicy = iota
+	// KeepLatest only keeps the latest update of the channel, each one carries the whole state.
+	KeepLatest
+	// Disconnect closes the connection rather than dropping an update, the client replays what it missed
+	// from the since endpoints once reconnected.
+	Disconnect
+)
+
+var policies = map[ChannelKind]DropPolicy{
+	KindDepth:   DropResync,
+	KindTrades:  DropResync,
+	KindTicker:  KeepLatest,
+	KindKline:   KeepLatest,
+	KindPrivate: Disconnect,
+}
+
+// DefaultQueueSizes are the updates a connection queues per channel of each kind, for the kinds missing
+// from stream_fanout.queue_sizes.
+var DefaultQueueSizes = map[ChannelKind]int{
+	KindDepth:   256,
+	KindTrades:  256,
+	KindTicker:  1,
+	KindKline:   1,
+	KindPrivate: 1024,
+}
+
+var (
+	// ErrSlowConsumer closes a connection which didn't read the updates it can't have dropped
+	ErrSlowConsumer = errors.New("stream.slow_consumer")
+	ErrConnClosed   = errors.New("stream.closed")
+)
+
+// KindOf returns the kind of a channel. Public channels are named <market>.<event>, global.tickers included,
+// private channels are the bare events of a member. The top-N depth snapshots, <market>.depth.top5 and top20,
+// carry the whole top of the book, they're kept latest as tickers are.
+func KindOf(channel string) ChannelKind {
+	dot := strings.LastIndexByte(channel, '.')
+	if dot < 0 {
+		return KindPrivate
+	}
+
+	event := strings.TrimSuffix(channel[dot+1:], "-batch")
+	switch {
+	case event == "depth":
+		return KindDepth
+	case event == "trades":
+		return KindTrades
+	case strings.HasPrefix(event, "kline"):
+		return KindKline
+	default:
+		return KindTicker
+	}
+}
+
+// QueueSizes returns the queue size of every kind, from stream_fanout.queue_sizes over DefaultQueueSizes.
+func QueueSizes() map[ChannelKind]int {
+	sizes := make(map[ChannelKind]int, len(DefaultQueueSizes))
+	for kind, size := range DefaultQueueSizes {
+		sizes[kind] = size
+	}
+
+	for kind, size := range config.StreamFanout.QueueSizes {
+		if _, ok := sizes[ChannelKind(kind)]; ok && size > 0 {
+			sizes[ChannelKind(kind)] = size
+		}
+	}
+
+	return sizes
+}
+
+// Message is an update of a channel sent to a connection.
+type Message struct {
+	Channel string
+	Payload interface{}
+	// Snapshot is set on the whole state of a channel, it supersedes the updates queued before it
+	Snapshot bool
+	// Resync is set on the notice queued in place of dropped updates, the client fetches a snapshot of the channel
+	Resync bool
+}
+
+type channelQueue struct {
+	kind     ChannelKind
+	policy   DropPolicy
+	size     int
+	messages []Message
+	// resyncing is set from a resync notice to the next snapshot, updates in between are dropped
+	resyncing bool
+}
+
+// Conn queues the updates of a websocket connection in a bounded queue per channel, publishers never block on it.
+// The writer of the connection takes the updates with Next, the channels with queued updates take turns.
+type Conn struct {
+	mutex   sync.Mutex
+	sizes   map[ChannelKind]int
+	metrics *Metrics
+	queues  map[string]*channelQueue
+	// ready are the channels with queued updates, in turn
+	ready  []string
+	queued int
+	wake   chan struct{}
+	done   chan struct{}
+	err    error
+}
+
+// NewConn returns a connection queuing up to sizes updates per channel of each kind, and counting what it drops in metrics.
+func NewConn(sizes map[ChannelKind]int, metrics *Metrics) *Conn {
+	return &Conn{
+		sizes:   sizes,
+		metrics: metrics,
+		queues:  make(map[string]*channelQueue),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+func (c *Conn) queue(channel string) *channelQueue {
+	q, ok := c.queues[channel]
+	if !ok {
+		kind := KindOf(channel)
+		size := c.sizes[kind]
+		if size <= 0 {
+			size = 1
+		}
+
+		q = &channelQueue{kind: kind, policy: policies[kind], size: size}
+		c.queues[channel] = q
+	}
+
+	return q
+}
+
+// Send queues an update without blocking. It returns ErrSlowConsumer when it closed the connection, and the
+// error the connection was closed with after.
+func (c *Conn) Send(message Message) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+
+	q := c.queue(message.Channel)
+	queued := len(q.messages)
+
+	switch q.policy {
+	case KeepLatest:
+		c.drop(q, len(q.messages))
+		q.messages = append(q.messages[:0], message)
+	case DropResync:
+		switch {
+		case message.Snapshot:
+			c.drop(q, len(q.messages))
+			q.messages = append(q.messages[:0], message)
+			q.resyncing = false
+		case q.resyncing:
+			c.drop(q, 1)
+		case len(q.messages) >= q.size:
+			c.drop(q, len(q.messages))
+			q.messages = append(q.messages[:0], Message{Channel: message.Channel, Resync: true})
+			q.resyncing = true
+			c.metrics.of(q.kind).resync()
+		default:
+			q.messages = append(q.messages, message)
+		}
+	case Disconnect:
+		if len(q.messages) >= q.size {
+			c.metrics.of(q.kind).disconnect()
+			c.closeLocked(ErrSlowConsumer)
+
+			return ErrSlowConsumer
+		}
+
+		q.messages = append(q.messages, message)
+	}
+
+	c.queued += len(q.messages) - queued
+
+	if queued == 0 && len(q.messages) > 0 {
+		c.ready = append(c.ready, message.Channel)
+
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (c *Conn) drop(q *channelQueue, count int) {
+	if count > 0 {
+		c.metrics.of(q.kind).drop(count)
+	}
+}
+
+// Next returns the next update to write, it blocks until there's one. It returns the error the connection
+// was closed with once it's closed, the updates still queued are discarded.
+func (c *Conn) Next(ctx context.Context) (Message, error) {
+	for {
+		c.mutex.Lock()
+		if c.err != nil {
+			err := c.err
+			c.mutex.Unlock()
+
+			return Message{}, err
+		}
+
+		if len(c.ready) > 0 {
+			channel := c.ready[0]
+			c.ready[0] = ""
+			c.ready = c.ready[1:]
+
+			q := c.queues[channel]
+			message := q.messages[0]
+			q.messages[0] = Message{}
+			q.messages = q.messages[1:]
+			if len(q.messages) > 0 {
+				c.ready = append(c.ready, channel)
+			} else {
+				q.messages = nil
+			}
+			c.queued--
+			c.mutex.Unlock()
+
+			return message, nil
+		}
+		c.mutex.Unlock()
+
+		select {
+		case <-c.wake:
+		case <-c.done:
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		}
+	}
+}
+
+// Unsubscribe drops the updates queued on a channel.
+func (c *Conn) Unsubscribe(channel string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	q, ok := c.queues[channel]
+	if !ok {
+		return
+	}
+
+	c.queued -= len(q.messages)
+	delete(c.queues, channel)
+
+	for i, ready := range c.ready {
+		if ready == channel {
+			c.ready = append(c.ready[:i], c.ready[i+1:]...)
+			break
+		}
+	}
+}
+
+// Queued returns the number of updates waiting to be written.
+func (c *Conn) Queued() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.queued
+}
+
+// Err returns the error the connection was closed with, nil while it's open.
+func (c *Conn) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.err
+}
+
+// Close closes the connection, the updates still queued are discarded.
+func (c *Conn) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closeLocked(ErrConnClosed)
+}
+
+func (c *Conn) closeLocked(err error) {
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	c.queues = nil
+	c.ready = nil
+	c.queued = 0
+	close(c.done)
+}
@@ -0,0 +1,250 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testQueueSizes = map[ChannelKind]int{
+	KindDepth:   4,
+	KindTrades:  4,
+	KindTicker:  1,
+	KindKline:   1,
+	KindPrivate: 4,
+}
+
+func next(t *testing.T, conn *Conn) Message {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	message, err := conn.Next(ctx)
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+
+	return message
+}
+
+func TestKindOf(t *testing.T) {
+	cases := map[string]ChannelKind{
+		"btcusdt.depth":       KindDepth,
+		"btcusdt.depth-batch": KindDepth,
+		"btcusdt.depth.top5":  KindTicker,
+		"btcusdt.trades":      KindTrades,
+		"global.tickers":      KindTicker,
+		"btcusdt.ticker":      KindTicker,
+		"btcusdt.kline-1m":    KindKline,
+		"order":               KindPrivate,
+		"trade":               KindPrivate,
+	}
+
+	for channel, want := range cases {
+		if got := KindOf(channel); got != want {
+			t.Errorf("KindOf(%q) = %v, want %v", channel, got, want)
+		}
+	}
+}
+
+func TestFullDepthChannelAsksForResync(t *testing.T) {
+	metrics := NewMetrics()
+	conn := NewConn(testQueueSizes, metrics)
+
+	for i := 0; i < 6; i++ {
+		if err := conn.Send(Message{Channel: "btcusdt.depth", Payload: i}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	if message := next(t, conn); !message.Resync || message.Channel != "btcusdt.depth" {
+		t.Fatalf("Next() = %+v, want a resync notice", message)
+	}
+
+	// diffs are dropped until the snapshot
+	conn.Send(Message{Channel: "btcusdt.depth", Payload: 6})
+	conn.Send(Message{Channel: "btcusdt.depth", Payload: "snapshot", Snapshot: true})
+	conn.Send(Message{Channel: "btcusdt.depth", Payload: 7})
+
+	if message := next(t, conn); !message.Snapshot {
+		t.Fatalf("Next() = %+v, want the snapshot", message)
+	}
+	if message := next(t, conn); message.Payload != 7 {
+		t.Fatalf("Next() = %+v, want the diff after the snapshot", message)
+	}
+
+	counts := metrics.Of(KindDepth)
+	if counts.Dropped != 6 || counts.Resyncs != 1 || counts.Disconnects != 0 {
+		t.Errorf("metrics = %+v, want 6 dropped and 1 resync", counts)
+	}
+}
+
+func TestSnapshotSupersedesQueuedDiffs(t *testing.T) {
+	metrics := NewMetrics()
+	conn := NewConn(testQueueSizes, metrics)
+
+	conn.Send(Message{Channel: "btcusdt.depth", Payload: 1})
+	conn.Send(Message{Channel: "btcusdt.depth", Payload: 2})
+	conn.Send(Message{Channel: "btcusdt.depth", Payload: "snapshot", Snapshot: true})
+
+	if queued := conn.Queued(); queued != 1 {
+		t.Fatalf("Queued() = %d, want 1", queued)
+	}
+	if message := next(t, conn); !message.Snapshot {
+		t.Fatalf("Next() = %+v, want the snapshot", message)
+	}
+	if counts := metrics.Of(KindDepth); counts.Dropped != 2 || counts.Resyncs != 0 {
+		t.Errorf("metrics = %+v, want 2 dropped without resync", counts)
+	}
+}
+
+func TestTickerKeepsTheLatest(t *testing.T) {
+	metrics := NewMetrics()
+	conn := NewConn(testQueueSizes, metrics)
+
+	for i := 0; i < 10; i++ {
+		conn.Send(Message{Channel: "global.tickers", Payload: i})
+	}
+
+	if message := next(t, conn); message.Payload != 9 {
+		t.Fatalf("Next() = %+v, want the latest ticker", message)
+	}
+	if counts := metrics.Of(KindTicker); counts.Dropped != 9 {
+		t.Errorf("metrics = %+v, want 9 dropped", counts)
+	}
+}
+
+func TestFullPrivateChannelDisconnects(t *testing.T) {
+	metrics := NewMetrics()
+	conn := NewConn(testQueueSizes, metrics)
+
+	for i := 0; i < 4; i++ {
+		if err := conn.Send(Message{Channel: "order", Payload: i}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	if err := conn.Send(Message{Channel: "order", Payload: 4}); !errors.Is(err, ErrSlowConsumer) {
+		t.Fatalf("Send() error = %v, want ErrSlowConsumer", err)
+	}
+	if err := conn.Send(Message{Channel: "global.tickers"}); !errors.Is(err, ErrSlowConsumer) {
+		t.Fatalf("Send() after the disconnect error = %v, want ErrSlowConsumer", err)
+	}
+	if _, err := conn.Next(context.Background()); !errors.Is(err, ErrSlowConsumer) {
+		t.Fatalf("Next() error = %v, want ErrSlowConsumer", err)
+	}
+
+	counts := metrics.Of(KindPrivate)
+	if counts.Dropped != 0 || counts.Disconnects != 1 {
+		t.Errorf("metrics = %+v, want 1 disconnect and nothing dropped", counts)
+	}
+}
+
+func TestChannelsTakeTurns(t *testing.T) {
+	conn := NewConn(testQueueSizes, NewMetrics())
+
+	conn.Send(Message{Channel: "btcusdt.depth", Payload: "d1"})
+	conn.Send(Message{Channel: "btcusdt.depth", Payload: "d2"})
+	conn.Send(Message{Channel: "order", Payload: "o1"})
+
+	var got []interface{}
+	for i := 0; i < 3; i++ {
+		got = append(got, next(t, conn).Payload)
+	}
+
+	if got[0] != "d1" || got[1] != "o1" || got[2] != "d2" {
+		t.Errorf("Next() order = %v, want [d1 o1 d2]", got)
+	}
+}
+
+func TestNextWaitsForAnUpdate(t *testing.T) {
+	conn := NewConn(testQueueSizes, NewMetrics())
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		conn.Send(Message{Channel: "order", Payload: "o1"})
+	}()
+
+	if message := next(t, conn); message.Payload != "o1" {
+		t.Fatalf("Next() = %+v, want o1", message)
+	}
+
+	conn.Close()
+	if _, err := conn.Next(context.Background()); !errors.Is(err, ErrConnClosed) {
+		t.Fatalf("Next() error = %v, want ErrConnClosed", err)
+	}
+}
+
+// TestSlowReaderStaysBounded publishes depth diffs and tickers of many markets as fast as it can to a connection
+// reading one update a millisecond, the queue and the heap stay bounded.
+func TestSlowReaderStaysBounded(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load test")
+	}
+
+	sizes := map[ChannelKind]int{KindDepth: 64, KindTrades: 64, KindTicker: 1, KindKline: 1, KindPrivate: 1024}
+	metrics := NewMetrics()
+	conn := NewConn(sizes, metrics)
+
+	markets := []string{"btcusdt", "ethusdt", "ethbtc", "trxusdt"}
+	bound := len(markets)*sizes[KindDepth] + sizes[KindTicker]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			if _, err := conn.Next(ctx); err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	payload := make([]byte, 512)
+	max_queued := 0
+	for i := 0; i < 200000; i++ {
+		market := markets[i%len(markets)]
+		if err := conn.Send(Message{Channel: market + ".depth", Payload: payload}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		if i%100 == 0 {
+			conn.Send(Message{Channel: "global.tickers", Payload: payload})
+		}
+
+		if queued := conn.Queued(); queued > max_queued {
+			max_queued = queued
+		}
+	}
+
+	runtime.GC()
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+
+	cancel()
+	wg.Wait()
+
+	if max_queued > bound {
+		t.Errorf("queued %d updates, want at most %d", max_queued, bound)
+	}
+
+	if grown := int64(after.HeapAlloc) - int64(before.HeapAlloc); grown > 4<<20 {
+		t.Errorf("heap grew by %d bytes, want less than 4MB", grown)
+	}
+
+	if counts := metrics.Of(KindDepth); counts.Resyncs == 0 || counts.Dropped == 0 {
+		t.Errorf("metrics = %+v, want the slow reader resynced", counts)
+	}
+}
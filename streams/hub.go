@@ -0,0 +1,211 @@
+package streams
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/zsmartex/finex/bus"
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+)
+
+const (
+	// DefaultPingInterval and DefaultPongTimeout keep the websocket connections alive when stream_fanout doesn't set them
+	DefaultPingInterval = 30 * time.Second
+	DefaultPongTimeout  = time.Minute
+)
+
+// PingInterval is stream_fanout.ping_interval, defaulted when it's not set.
+func PingInterval() time.Duration {
+	if config.StreamFanout != nil && config.StreamFanout.PingInterval > 0 {
+		return config.StreamFanout.PingInterval
+	}
+
+	return DefaultPingInterval
+}
+
+// PongTimeout is stream_fanout.pong_timeout, defaulted when it's not set.
+func PongTimeout() time.Duration {
+	if config.StreamFanout != nil && config.StreamFanout.PongTimeout > 0 {
+		return config.StreamFanout.PongTimeout
+	}
+
+	return DefaultPongTimeout
+}
+
+// PrivateHub is the hub of the private websocket connections of the process, the API processes start it.
+var PrivateHub *Hub
+
+// Hub fans the private events of the members out to their websocket connections. A member has as many connections
+// as they opened, each of them receives the events of the streams it subscribed to. Publishing never waits on a
+// connection: one too slow to take its events is closed with ErrSlowConsumer and left out of the hub.
+type Hub struct {
+	mutex   sync.RWMutex
+	sizes   map[ChannelKind]int
+	metrics *Metrics
+	members map[string]map[*PrivateConn]struct{}
+}
+
+func NewHub(sizes map[ChannelKind]int, metrics *Metrics) *Hub {
+	return &Hub{
+		sizes:   sizes,
+		metrics: metrics,
+		members: make(map[string]map[*PrivateConn]struct{}),
+	}
+}
+
+// PrivateConn is a connection of a member to the hub, its streams are the channels of its Conn.
+type PrivateConn struct {
+	*Conn
+	UID string
+
+	mutex   sync.Mutex
+	streams map[string]bool
+}
+
+// Subscribe adds streams to the ones the connection receives.
+func (c *PrivateConn) Subscribe(streams ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, stream := range streams {
+		c.streams[stream] = true
+	}
+}
+
+// Unsubscribe stops streams, the events queued on them are dropped.
+func (c *PrivateConn) Unsubscribe(streams ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, stream := range streams {
+		delete(c.streams, stream)
+		c.Conn.Unsubscribe(stream)
+	}
+}
+
+func (c *PrivateConn) Subscribed(stream string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.streams[stream]
+}
+
+// Streams returns the streams the connection receives, in no order.
+func (c *PrivateConn) Streams() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	streams := make([]string, 0, len(c.streams))
+	for stream := range c.streams {
+		streams = append(streams, stream)
+	}
+
+	return streams
+}
+
+// Connect adds a connection of the member uid, it receives no stream until it subscribes.
+func (h *Hub) Connect(uid string) *PrivateConn {
+	conn := &PrivateConn{
+		Conn:    NewConn(h.sizes, h.metrics),
+		UID:     uid,
+		streams: make(map[string]bool),
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	conns, ok := h.members[uid]
+	if !ok {
+		conns = make(map[*PrivateConn]struct{})
+		h.members[uid] = conns
+	}
+	conns[conn] = struct{}{}
+
+	return conn
+}
+
+// Disconnect closes conn and takes it out of the hub.
+func (h *Hub) Disconnect(conn *PrivateConn) {
+	conn.Close()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.remove(conn)
+}
+
+func (h *Hub) remove(conn *PrivateConn) {
+	conns := h.members[conn.UID]
+	delete(conns, conn)
+
+	if len(conns) == 0 {
+		delete(h.members, conn.UID)
+	}
+}
+
+// Connections returns the number of connections of the member uid.
+func (h *Hub) Connections(uid string) int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return len(h.members[uid])
+}
+
+// Publish queues payload on stream to every connection of the member uid subscribed to it.
+func (h *Hub) Publish(uid string, stream string, payload json.RawMessage) {
+	h.mutex.RLock()
+	slow := make([]*PrivateConn, 0)
+	for conn := range h.members[uid] {
+		if !conn.Subscribed(stream) {
+			continue
+		}
+
+		if err := conn.Send(Message{Channel: stream, Payload: payload}); err != nil {
+			slow = append(slow, conn)
+		}
+	}
+	h.mutex.RUnlock()
+
+	if len(slow) == 0 {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, conn := range slow {
+		h.remove(conn)
+	}
+}
+
+// Handle publishes a private event of the bus to the connections of its member. An event which can't be decoded is
+// logged and skipped, the broadcast has no dead letters.
+func (h *Hub) Handle(message *bus.Message) error {
+	event, err := events.DecodePrivateEvent(message.Value)
+	if err != nil {
+		config.Logger.Errorf("Failed to decode a private event: %v", err)
+		return nil
+	}
+
+	h.Publish(event.UID, event.Stream, event.Payload)
+
+	return nil
+}
+
+// StartPrivateHub starts the hub of the process on the private events of the bus, the broadcast is taken again
+// when the bus fails.
+func StartPrivateHub() {
+	PrivateHub = NewHub(QueueSizes(), NewMetrics())
+
+	go func() {
+		for {
+			if err := config.Bus.Broadcast(events.PrivateStreamTopic, PrivateHub.Handle); err != nil {
+				config.Logger.Errorf("Failed to broadcast the private events: %v", err)
+			}
+
+			time.Sleep(time.Second)
+		}
+	}()
+}
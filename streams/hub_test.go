@@ -0,0 +1,98 @@
+package streams
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/zsmartex/finex/bus"
+	"github.com/zsmartex/finex/events"
+)
+
+func TestHubFansOutToEveryConnectionOfTheMember(t *testing.T) {
+	hub := NewHub(testQueueSizes, NewMetrics())
+
+	browser := hub.Connect("ID1")
+	browser.Subscribe("order", "trade")
+	mobile := hub.Connect("ID1")
+	mobile.Subscribe("order")
+	other := hub.Connect("ID2")
+	other.Subscribe("order", "trade")
+
+	hub.Publish("ID1", "order", json.RawMessage(`{"event":"order","action":"created"}`))
+	hub.Publish("ID1", "trade", json.RawMessage(`{"event":"trade"}`))
+
+	for _, conn := range []*PrivateConn{browser, mobile} {
+		if message := next(t, conn.Conn); message.Channel != "order" || string(message.Payload.(json.RawMessage)) != `{"event":"order","action":"created"}` {
+			t.Errorf("expected the order event on every connection of the member, got %+v", message)
+		}
+	}
+
+	if message := next(t, browser.Conn); message.Channel != "trade" {
+		t.Errorf("expected the trade event on the connection subscribed to it, got %+v", message)
+	}
+
+	// mobile didn't subscribe to trade, the other member receives nothing of ID1
+	if mobile.Queued() != 0 || other.Queued() != 0 {
+		t.Errorf("expected nothing else queued, got %d on mobile and %d on the other member", mobile.Queued(), other.Queued())
+	}
+
+	browser.Unsubscribe("order")
+	hub.Publish("ID1", "order", json.RawMessage(`{"event":"order","action":"updated"}`))
+
+	if browser.Queued() != 0 || mobile.Queued() != 1 {
+		t.Errorf("expected the order event only on the connection still subscribed, got %d and %d", browser.Queued(), mobile.Queued())
+	}
+
+	hub.Disconnect(mobile)
+	if hub.Connections("ID1") != 1 {
+		t.Errorf("expected one connection of ID1 left, got %d", hub.Connections("ID1"))
+	}
+}
+
+func TestHubClosesSlowConnectionWithoutBlocking(t *testing.T) {
+	metrics := NewMetrics()
+	hub := NewHub(testQueueSizes, metrics)
+
+	slow := hub.Connect("ID1")
+	slow.Subscribe("balance")
+	reading := hub.Connect("ID1")
+	reading.Subscribe("balance")
+
+	for i := 0; i <= testQueueSizes[KindPrivate]; i++ {
+		hub.Publish("ID1", "balance", json.RawMessage(`{"event":"balance"}`))
+		next(t, reading.Conn)
+	}
+
+	if !errors.Is(slow.Err(), ErrSlowConsumer) {
+		t.Fatalf("expected the connection not reading to be closed as slow, got %v", slow.Err())
+	}
+
+	if reading.Err() != nil {
+		t.Errorf("expected the connection reading to stay open, got %v", reading.Err())
+	}
+
+	if hub.Connections("ID1") != 1 {
+		t.Errorf("expected the slow connection out of the hub, got %d connections", hub.Connections("ID1"))
+	}
+
+	if disconnects := metrics.Of(KindPrivate).Disconnects; disconnects != 1 {
+		t.Errorf("expected one disconnect counted, got %d", disconnects)
+	}
+}
+
+func TestHubHandlesThePrivateEventsOfTheBus(t *testing.T) {
+	hub := NewHub(testQueueSizes, NewMetrics())
+
+	conn := hub.Connect("ID1")
+	conn.Subscribe("trade")
+
+	value, _ := json.Marshal(&events.PrivateEvent{UID: "ID1", Stream: "trade", Payload: json.RawMessage(`{"event":"trade"}`)})
+	if err := hub.Handle(&bus.Message{Topic: events.PrivateStreamTopic, Key: []byte("ID1"), Value: value}); err != nil {
+		t.Fatal(err)
+	}
+
+	if message := next(t, conn.Conn); string(message.Payload.(json.RawMessage)) != `{"event":"trade"}` {
+		t.Errorf("expected the trade event of the bus, got %+v", message)
+	}
+}
@@ -0,0 +1,74 @@
+package streams
+
+import (
+	"sync/atomic"
+
+	"github.com/zsmartex/finex/config"
+)
+
+type kindCounters struct {
+	dropped     uint64
+	resyncs     uint64
+	disconnects uint64
+}
+
+func (c *kindCounters) drop(count int) {
+	atomic.AddUint64(&c.dropped, uint64(count))
+}
+
+func (c *kindCounters) resync() {
+	atomic.AddUint64(&c.resyncs, 1)
+}
+
+func (c *kindCounters) disconnect() {
+	atomic.AddUint64(&c.disconnects, 1)
+}
+
+// Metrics counts the updates the connections dropped, the resyncs they asked for and the connections closed
+// for being too slow, per channel kind.
+type Metrics struct {
+	kinds map[ChannelKind]*kindCounters
+}
+
+func NewMetrics() *Metrics {
+	kinds := make(map[ChannelKind]*kindCounters, len(ChannelKinds))
+	for _, kind := range ChannelKinds {
+		kinds[kind] = &kindCounters{}
+	}
+
+	return &Metrics{kinds: kinds}
+}
+
+func (m *Metrics) of(kind ChannelKind) *kindCounters {
+	return m.kinds[kind]
+}
+
+// KindMetrics are the counts of a channel kind since the metrics were created.
+type KindMetrics struct {
+	Dropped     uint64
+	Resyncs     uint64
+	Disconnects uint64
+}
+
+func (m *Metrics) Of(kind ChannelKind) KindMetrics {
+	counters := m.of(kind)
+
+	return KindMetrics{
+		Dropped:     atomic.LoadUint64(&counters.dropped),
+		Resyncs:     atomic.LoadUint64(&counters.resyncs),
+		Disconnects: atomic.LoadUint64(&counters.disconnects),
+	}
+}
+
+// Report writes the counts of every kind to InfluxDB.
+func (m *Metrics) Report() {
+	for _, kind := range ChannelKinds {
+		counts := m.Of(kind)
+
+		config.InfluxDB.NewPoint("stream_backpressure", map[string]string{"kind": string(kind)}, map[string]interface{}{
+			"dropped":     int64(counts.Dropped),
+			"resyncs":     int64(counts.Resyncs),
+			"disconnects": int64(counts.Disconnects),
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package streams
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+)
+
+// DefaultHubShards is the number of shards of a public hub when stream_fanout.shards isn't set.
+const DefaultHubShards = 16
+
+var ErrUnknownChannel = errors.New("stream.unknown")
+
+// PublicHub fans the public channels of the markets out to the websocket connections subscribed to them. The channels
+// are spread over shards by their name, each shard has its subscribers and a goroutine of its own broadcasting the
+// updates of its channels: a publisher only appends the update to the queue of the shard and never waits on the
+// connections, and a channel with thousands of subscribers doesn't hold up the channels of the other shards.
+type PublicHub struct {
+	sizes   map[ChannelKind]int
+	metrics *Metrics
+	shards  []*hubShard
+
+	// Known reports whether a channel can be subscribed to, every channel can when it's nil
+	Known func(channel string) bool
+	// Snapshot takes the snapshot a connection subscribing to channel starts with and hands it to queue before the
+	// next update of the channel is published. It's false for the channels without snapshots
+	Snapshot func(channel string, queue func(snapshot interface{})) bool
+}
+
+type hubOpKind int
+
+const (
+	hubPublish hubOpKind = iota
+	hubSubscribe
+	hubUnsubscribe
+)
+
+type hubOp struct {
+	kind    hubOpKind
+	conn    *Conn
+	message Message
+}
+
+// hubChannel are the subscribers of a channel, latest is the last update of a channel only kept latest: a
+// connection subscribing to it starts with it.
+type hubChannel struct {
+	conns  map[*Conn]struct{}
+	latest *Message
+}
+
+type hubShard struct {
+	mutex sync.Mutex
+	ops   []hubOp
+	wake  chan struct{}
+	done  chan struct{}
+
+	// channels are only used by the goroutine of the shard
+	channels map[string]*hubChannel
+}
+
+// NewPublicHub returns a hub of shards shards, its connections queue up to sizes updates per channel of each kind.
+func NewPublicHub(sizes map[ChannelKind]int, metrics *Metrics, shards int) *PublicHub {
+	if shards <= 0 {
+		shards = DefaultHubShards
+	}
+
+	hub := &PublicHub{
+		sizes:   sizes,
+		metrics: metrics,
+		shards:  make([]*hubShard, shards),
+	}
+
+	for i := range hub.shards {
+		shard := &hubShard{
+			wake:     make(chan struct{}, 1),
+			done:     make(chan struct{}),
+			channels: make(map[string]*hubChannel),
+		}
+		hub.shards[i] = shard
+
+		go shard.run()
+	}
+
+	return hub
+}
+
+// Connect returns a connection of the hub, it receives no channel until it subscribes.
+func (h *PublicHub) Connect() *Conn {
+	return NewConn(h.sizes, h.metrics)
+}
+
+func (h *PublicHub) shard(channel string) *hubShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(channel))
+
+	return h.shards[hash.Sum32()%uint32(len(h.shards))]
+}
+
+// Publish queues an update of channel to its subscribers.
+func (h *PublicHub) Publish(channel string, payload interface{}) {
+	h.shard(channel).queue(hubOp{kind: hubPublish, message: Message{Channel: channel, Payload: payload}})
+}
+
+// Subscribe adds conn to the subscribers of channel. The connection starts with the snapshot of the channel, or the
+// latest update of a channel kept latest, then gets the updates published after it.
+func (h *PublicHub) Subscribe(conn *Conn, channel string) error {
+	if h.Known != nil && !h.Known(channel) {
+		return ErrUnknownChannel
+	}
+
+	shard := h.shard(channel)
+	if h.Snapshot != nil && h.Snapshot(channel, func(snapshot interface{}) {
+		shard.queue(hubOp{kind: hubSubscribe, conn: conn, message: Message{Channel: channel, Payload: snapshot, Snapshot: true}})
+	}) {
+		return nil
+	}
+
+	shard.queue(hubOp{kind: hubSubscribe, conn: conn, message: Message{Channel: channel}})
+
+	return nil
+}
+
+// Unsubscribe takes conn out of the subscribers of channel, the updates it queued on the channel are dropped.
+func (h *PublicHub) Unsubscribe(conn *Conn, channel string) {
+	h.shard(channel).queue(hubOp{kind: hubUnsubscribe, conn: conn, message: Message{Channel: channel}})
+}
+
+// Close stops the shards, the updates they didn't broadcast yet are dropped.
+func (h *PublicHub) Close() {
+	for _, shard := range h.shards {
+		close(shard.done)
+	}
+}
+
+func (s *hubShard) queue(op hubOp) {
+	s.mutex.Lock()
+	s.ops = append(s.ops, op)
+	s.mutex.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *hubShard) run() {
+	var ops []hubOp
+
+	for {
+		s.mutex.Lock()
+		ops, s.ops = s.ops, ops[:0]
+		s.mutex.Unlock()
+
+		if len(ops) == 0 {
+			select {
+			case <-s.wake:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+
+		for i := range ops {
+			s.apply(&ops[i])
+			ops[i] = hubOp{}
+		}
+	}
+}
+
+func (s *hubShard) channel(name string) *hubChannel {
+	channel, ok := s.channels[name]
+	if !ok {
+		channel = &hubChannel{conns: make(map[*Conn]struct{})}
+		s.channels[name] = channel
+	}
+
+	return channel
+}
+
+func (s *hubShard) apply(op *hubOp) {
+	switch op.kind {
+	case hubPublish:
+		channel := s.channel(op.message.Channel)
+		if policies[KindOf(op.message.Channel)] == KeepLatest {
+			message := op.message
+			channel.latest = &message
+		}
+
+		for conn := range channel.conns {
+			// a connection closed leaves the channels it's still subscribed to on their next update
+			if err := conn.Send(op.message); err != nil {
+				delete(channel.conns, conn)
+			}
+		}
+	case hubSubscribe:
+		channel := s.channel(op.message.Channel)
+		channel.conns[op.conn] = struct{}{}
+
+		switch {
+		case op.message.Snapshot:
+			op.conn.Send(op.message)
+		case channel.latest != nil:
+			op.conn.Send(*channel.latest)
+		}
+	case hubUnsubscribe:
+		if channel, ok := s.channels[op.message.Channel]; ok {
+			delete(channel.conns, op.conn)
+		}
+
+		op.conn.Unsubscribe(op.message.Channel)
+	}
+}
@@ -0,0 +1,259 @@
+package streams
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestPublicHubBroadcastsToThousandsOfConnections broadcasts 10k updates over the trades channels of 10 markets to
+// 1k connections, each one reading its channel on a goroutine of its own, and checks every connection got every
+// update of its channel in order.
+func TestPublicHubBroadcastsToThousandsOfConnections(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load test")
+	}
+
+	const connections, markets, updates = 1000, 10, 10000
+
+	sizes := map[ChannelKind]int{KindDepth: updates, KindTrades: updates, KindTicker: 1, KindKline: 1, KindPrivate: 1}
+	metrics := NewMetrics()
+	hub := NewPublicHub(sizes, metrics, 4)
+	defer hub.Close()
+
+	channel := func(market int) string {
+		return fmt.Sprintf("market%d.trades", market)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	errs := make(chan error, connections)
+	var wg sync.WaitGroup
+	for i := 0; i < connections; i++ {
+		conn := hub.Connect()
+		if err := hub.Subscribe(conn, channel(i%markets)); err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		go func(conn *Conn, channel string) {
+			defer wg.Done()
+
+			for want := 0; want < updates/markets; want++ {
+				message, err := conn.Next(ctx)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				if message.Channel != channel || message.Payload != want {
+					errs <- fmt.Errorf("expected update %d of %s, got %+v", want, channel, message)
+					return
+				}
+			}
+		}(conn, channel(i%markets))
+	}
+
+	for i := 0; i < updates; i++ {
+		hub.Publish(channel(i%markets), i/markets)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	if counts := metrics.Of(KindTrades); counts.Dropped != 0 || counts.Resyncs != 0 {
+		t.Errorf("expected nothing dropped, got %+v", counts)
+	}
+}
+
+func BenchmarkPublicHubBroadcast(b *testing.B) {
+	sizes := map[ChannelKind]int{KindDepth: 256, KindTrades: 256, KindTicker: 1, KindKline: 1, KindPrivate: 1}
+	hub := NewPublicHub(sizes, NewMetrics(), DefaultHubShards)
+	defer hub.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < 1000; i++ {
+		conn := hub.Connect()
+		hub.Subscribe(conn, "btcusdt.trades")
+
+		go func() {
+			for {
+				if _, err := conn.Next(ctx); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hub.Publish("btcusdt.trades", i)
+	}
+}
+
+func TestPublicHubStartsDepthFromItsSnapshot(t *testing.T) {
+	hub := NewPublicHub(testQueueSizes, NewMetrics(), 2)
+	defer hub.Close()
+
+	hub.Snapshot = func(channel string, queue func(snapshot interface{})) bool {
+		if channel != "btcusdt.depth" {
+			return false
+		}
+
+		queue("snapshot")
+		return true
+	}
+
+	early := hub.Connect()
+	hub.Subscribe(early, "btcusdt.depth")
+
+	hub.Publish("btcusdt.depth", 1)
+
+	conn := hub.Connect()
+	hub.Subscribe(conn, "btcusdt.depth")
+
+	hub.Publish("btcusdt.depth", 2)
+
+	if message := next(t, conn); !message.Snapshot || message.Payload != "snapshot" {
+		t.Fatalf("expected the connection to start from the snapshot, got %+v", message)
+	}
+	if message := next(t, conn); message.Payload != 2 {
+		t.Fatalf("expected the diff published after the snapshot, got %+v", message)
+	}
+
+	for _, want := range []interface{}{"snapshot", 1, 2} {
+		if message := next(t, early); message.Payload != want {
+			t.Fatalf("expected %v on the connection subscribed first, got %+v", want, message)
+		}
+	}
+}
+
+func TestPublicHubStartsTickersFromTheLatest(t *testing.T) {
+	hub := NewPublicHub(testQueueSizes, NewMetrics(), 2)
+	defer hub.Close()
+
+	hub.Publish("global.tickers", 1)
+	hub.Publish("global.tickers", 2)
+
+	conn := hub.Connect()
+	hub.Subscribe(conn, "global.tickers")
+
+	if message := next(t, conn); message.Payload != 2 {
+		t.Fatalf("expected the latest tickers, got %+v", message)
+	}
+
+	// an update queued before the unsubscribe is dropped with it
+	hub.Publish("global.tickers", 3)
+	hub.Unsubscribe(conn, "global.tickers")
+	hub.Publish("global.tickers", 4)
+
+	// the updates of a channel are applied in order, the other connection got the last one once conn is out
+	other := hub.Connect()
+	hub.Subscribe(other, "global.tickers")
+	next(t, other)
+
+	if queued := conn.Queued(); queued != 0 {
+		t.Errorf("expected nothing queued once unsubscribed, got %d", queued)
+	}
+}
+
+func TestPublicHubRefusesUnknownChannels(t *testing.T) {
+	hub := NewPublicHub(testQueueSizes, NewMetrics(), 2)
+	defer hub.Close()
+
+	hub.Known = func(channel string) bool {
+		return channel == "btcusdt.trades"
+	}
+
+	if err := hub.Subscribe(hub.Connect(), "dogeusdt.trades"); !errors.Is(err, ErrUnknownChannel) {
+		t.Errorf("expected ErrUnknownChannel, got %v", err)
+	}
+}
+
+// readPublicMessage reads the next text frame of the server into message, it fails on any other frame.
+func readPublicMessage(t *testing.T, reader *bufio.Reader, message interface{}) {
+	t.Helper()
+
+	opcode, payload := readServerFrame(reader)
+	if opcode != OpText {
+		t.Fatalf("expected a text frame, got %x %q", opcode, payload)
+	}
+
+	if err := json.Unmarshal(payload, message); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublicSessionClosesClientFallingBehind(t *testing.T) {
+	metrics := NewMetrics()
+	hub := NewPublicHub(testQueueSizes, metrics, 2)
+	defer hub.Close()
+
+	hub.Snapshot = func(channel string, queue func(snapshot interface{})) bool {
+		if channel != "btcusdt.depth" {
+			return false
+		}
+
+		queue(map[string]int64{"sequence": 7})
+		return true
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	reader := bufio.NewReader(client)
+
+	go hub.Serve(NewWebsocket(server, time.Second), []string{"btcusdt.depth"}, nil)
+
+	var snapshot PublicMessage
+	readPublicMessage(t, reader, &snapshot)
+	if snapshot.Channel != "btcusdt.depth" || snapshot.Type != "snapshot" {
+		t.Fatalf("expected the snapshot of the depth, got %+v", snapshot)
+	}
+
+	go client.Write(clientFrame(true, OpText, []byte(`{"event":"subscribe","channels":["global.tickers"]}`)))
+
+	var reply PublicReply
+	readPublicMessage(t, reader, &reply)
+	if reply.Event != "subscribed" || len(reply.Channels) != 2 {
+		t.Fatalf("expected the connection subscribed to both channels, got %+v", reply)
+	}
+
+	// the client stops reading, the diffs pile up behind the one being written
+	for i := 1; i <= 2*testQueueSizes[KindDepth]; i++ {
+		hub.Publish("btcusdt.depth", i)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for metrics.Of(KindDepth).Resyncs == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the depth of the client to overflow")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for {
+		opcode, payload := readServerFrame(reader)
+		if opcode == OpText {
+			continue
+		}
+
+		if opcode != OpClose || binary.BigEndian.Uint16(payload) != CloseTryAgainLater || string(payload[2:]) != ErrSlowConsumer.Error() {
+			t.Errorf("expected the client closed as slow, got %x %q", opcode, payload)
+		}
+		return
+	}
+}
@@ -0,0 +1,222 @@
+package streams
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"time"
+)
+
+// PublicMessage is an update of a public channel sent to a client, Type is snapshot for the whole state of a depth
+// the diffs after it apply on, update otherwise.
+type PublicMessage struct {
+	Channel string      `json:"channel"`
+	Type    string      `json:"type"`
+	Data    interface{} `json:"data"`
+}
+
+// PublicRequest is a message of a client, subscribe and unsubscribe take the channels.
+type PublicRequest struct {
+	Event    string   `json:"event"`
+	Channels []string `json:"channels"`
+}
+
+// PublicReply answers a request of a client with the channels the connection is subscribed to, and the error of the
+// request when it failed.
+type PublicReply struct {
+	Event    string   `json:"event"`
+	Channels []string `json:"channels"`
+	Error    string   `json:"error,omitempty"`
+}
+
+var ErrInvalidRequest = errors.New("stream.request.invalid")
+
+// PublicAccess is what a client of the hub may see, a client served without one sees every channel.
+type PublicAccess interface {
+	// Allowed reports whether the client may subscribe to channel, a channel it can't is unknown to it
+	Allowed(channel string) bool
+	// Filter returns the part of payload, an update of channel, the client gets
+	Filter(channel string, payload interface{}) interface{}
+}
+
+// publicSession is a websocket connection to the public hub, its reader takes the requests of the client while its
+// writer sends the updates of the channels it subscribed to.
+type publicSession struct {
+	hub       *PublicHub
+	websocket *Websocket
+	conn      *Conn
+	access    PublicAccess
+	// channels are only used by the reader
+	channels map[string]bool
+}
+
+// Serve serves the public channels of the hub access allows on websocket until the client leaves or falls behind,
+// the connection starts with channels. The client is pinged every PingInterval and given up on after PongTimeout
+// without a frame.
+func (h *PublicHub) Serve(websocket *Websocket, channels []string, access PublicAccess) {
+	session := &publicSession{
+		hub:       h,
+		websocket: websocket,
+		conn:      h.Connect(),
+		access:    access,
+		channels:  make(map[string]bool),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer session.close()
+
+	if err := session.subscribe(channels); err != nil {
+		session.reply("", err)
+		websocket.Close(ClosePolicyViolation, err.Error())
+		return
+	}
+
+	go session.write(ctx)
+	go session.keepalive(ctx)
+
+	session.read()
+}
+
+func (s *publicSession) subscribe(channels []string) error {
+	for _, channel := range channels {
+		if s.access != nil && !s.access.Allowed(channel) {
+			return ErrUnknownChannel
+		}
+
+		if err := s.hub.Subscribe(s.conn, channel); err != nil {
+			return err
+		}
+		s.channels[channel] = true
+	}
+
+	return nil
+}
+
+func (s *publicSession) unsubscribe(channels []string) {
+	for _, channel := range channels {
+		if s.channels[channel] {
+			s.hub.Unsubscribe(s.conn, channel)
+			delete(s.channels, channel)
+		}
+	}
+}
+
+func (s *publicSession) close() {
+	s.conn.Close()
+
+	for channel := range s.channels {
+		s.hub.Unsubscribe(s.conn, channel)
+	}
+}
+
+func (s *publicSession) send(message interface{}) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	return s.websocket.WriteText(payload)
+}
+
+func (s *publicSession) reply(event string, err error) error {
+	channels := make([]string, 0, len(s.channels))
+	for channel := range s.channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+
+	reply := PublicReply{Event: event, Channels: channels}
+	if err != nil {
+		reply.Event = "error"
+		reply.Error = err.Error()
+	}
+
+	return s.send(reply)
+}
+
+func (s *publicSession) read() {
+	for {
+		_, payload, err := s.websocket.ReadMessage(PongTimeout())
+		switch {
+		case errors.Is(err, io.EOF):
+			s.websocket.Close(CloseNormal, "")
+			return
+		case errors.Is(err, ErrWebsocketTooLarge):
+			s.websocket.Close(CloseTooLarge, err.Error())
+			return
+		case errors.Is(err, ErrWebsocketProtocol):
+			s.websocket.Close(CloseProtocolError, err.Error())
+			return
+		case err != nil:
+			s.websocket.Close(CloseNormal, "")
+			return
+		}
+
+		var request *PublicRequest
+		switch {
+		case json.Unmarshal(payload, &request) != nil || request == nil:
+			err = s.reply("", ErrInvalidRequest)
+		case request.Event == "subscribe":
+			err = s.reply("subscribed", s.subscribe(request.Channels))
+		case request.Event == "unsubscribe":
+			s.unsubscribe(request.Channels)
+			err = s.reply("unsubscribed", nil)
+		default:
+			err = s.reply("", ErrInvalidRequest)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// write sends the updates of the hub. A channel which had to drop updates asks for a resync, the client fell behind:
+// it's closed rather than resynced, it subscribes again once reconnected and starts from a snapshot.
+func (s *publicSession) write(ctx context.Context) {
+	for {
+		message, err := s.conn.Next(ctx)
+		if err != nil {
+			return
+		}
+
+		if message.Resync {
+			s.websocket.Close(CloseTryAgainLater, ErrSlowConsumer.Error())
+			return
+		}
+
+		update := PublicMessage{Channel: message.Channel, Type: "update", Data: message.Payload}
+		if s.access != nil {
+			update.Data = s.access.Filter(message.Channel, message.Payload)
+		}
+
+		if message.Snapshot {
+			update.Type = "snapshot"
+		}
+
+		if err := s.send(update); err != nil {
+			s.websocket.Close(CloseNormal, "")
+			return
+		}
+	}
+}
+
+func (s *publicSession) keepalive(ctx context.Context) {
+	ticker := time.NewTicker(PingInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.websocket.Ping(); err != nil {
+				s.websocket.Close(CloseNormal, "")
+				return
+			}
+		}
+	}
+}
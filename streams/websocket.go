@@ -0,0 +1,246 @@
+package streams
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// The opcodes of the websocket frames, RFC 6455 section 5.2.
+const (
+	opContinuation byte = 0x0
+	OpText         byte = 0x1
+	OpBinary       byte = 0x2
+	OpClose        byte = 0x8
+	OpPing         byte = 0x9
+	OpPong         byte = 0xA
+)
+
+// The status codes of the close frames sent by the server, RFC 6455 section 7.4.1.
+const (
+	CloseNormal          uint16 = 1000
+	CloseProtocolError   uint16 = 1002
+	ClosePolicyViolation uint16 = 1008
+	CloseTooLarge        uint16 = 1009
+	// CloseTryAgainLater closes a slow consumer, the client reconnects and replays what it missed
+	CloseTryAgainLater uint16 = 1013
+)
+
+// MaxWebsocketMessage bounds the messages read from a client, the requests of the clients are small.
+const MaxWebsocketMessage = 64 << 10
+
+// websocketGUID is appended to the key of a handshake to compute its accept, RFC 6455 section 1.3.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	// ErrWebsocketClosed is returned by the writes after the close frame was sent
+	ErrWebsocketClosed   = errors.New("websocket closed")
+	ErrWebsocketProtocol = errors.New("websocket protocol error")
+	ErrWebsocketTooLarge = errors.New("websocket message too large")
+)
+
+// WebsocketAccept returns the Sec-WebSocket-Accept answering a handshake with the Sec-WebSocket-Key key.
+func WebsocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Websocket is the server side of a websocket connection taken over from its handshake. A single goroutine reads
+// it, the writes are safe from any goroutine and each waits at most the write timeout on the client.
+type Websocket struct {
+	conn          net.Conn
+	reader        *bufio.Reader
+	write_timeout time.Duration
+
+	mutex   sync.Mutex
+	closing bool
+}
+
+func NewWebsocket(conn net.Conn, write_timeout time.Duration) *Websocket {
+	return &Websocket{
+		conn:          conn,
+		reader:        bufio.NewReader(conn),
+		write_timeout: write_timeout,
+	}
+}
+
+// ReadMessage returns the next text or binary message of the client, with its opcode. It answers the pings itself
+// and returns io.EOF once the client closed the connection. A timeout above zero is how long it waits for each frame,
+// the pongs of the client included: a client which stopped answering the pings is given up on.
+func (w *Websocket) ReadMessage(timeout time.Duration) (byte, []byte, error) {
+	var opcode byte
+	var message []byte
+
+	for {
+		if timeout > 0 {
+			if err := w.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+				return 0, nil, err
+			}
+		}
+
+		fin, frame_opcode, payload, err := w.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frame_opcode {
+		case OpPing:
+			if err := w.write(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			// the close is echoed with the status of the client
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			w.write(OpClose, payload)
+
+			return 0, nil, io.EOF
+		case opContinuation:
+			if opcode == 0 {
+				return 0, nil, ErrWebsocketProtocol
+			}
+		case OpText, OpBinary:
+			if opcode != 0 {
+				return 0, nil, ErrWebsocketProtocol
+			}
+			opcode = frame_opcode
+		default:
+			return 0, nil, ErrWebsocketProtocol
+		}
+
+		if len(message)+len(payload) > MaxWebsocketMessage {
+			return 0, nil, ErrWebsocketTooLarge
+		}
+
+		message = append(message, payload...)
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+func (w *Websocket) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(w.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+
+	// no extension is negotiated, and the frames of the clients are masked
+	if header[0]&0x70 != 0 || header[1]&0x80 == 0 {
+		return false, 0, nil, ErrWebsocketProtocol
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(w.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(w.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+
+	// control frames aren't fragmented
+	if opcode >= OpClose && (!fin || length > 125) {
+		return false, 0, nil, ErrWebsocketProtocol
+	}
+
+	if length > MaxWebsocketMessage {
+		return false, 0, nil, ErrWebsocketTooLarge
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(w.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(w.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// write sends payload in a single unmasked frame.
+func (w *Websocket) write(opcode byte, payload []byte) error {
+	header := make([]byte, 0, 10)
+	header = append(header, 0x80|opcode)
+
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closing {
+		return ErrWebsocketClosed
+	}
+	w.closing = opcode == OpClose
+
+	if w.write_timeout > 0 {
+		if err := w.conn.SetWriteDeadline(time.Now().Add(w.write_timeout)); err != nil {
+			return err
+		}
+	}
+
+	buffers := net.Buffers{header, payload}
+	_, err := buffers.WriteTo(w.conn)
+
+	return err
+}
+
+func (w *Websocket) WriteText(payload []byte) error {
+	return w.write(OpText, payload)
+}
+
+func (w *Websocket) Ping() error {
+	return w.write(OpPing, nil)
+}
+
+// Close sends a close frame with code and reason unless one was sent already, then closes the connection without
+// waiting for the close of the client.
+func (w *Websocket) Close(code uint16, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+	payload = append(payload, reason...)
+	if len(payload) > 125 {
+		payload = payload[:125]
+	}
+
+	w.write(OpClose, payload)
+
+	return w.conn.Close()
+}
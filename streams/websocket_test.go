@@ -0,0 +1,144 @@
+package streams
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// clientFrame encodes a frame as a client sends it, masked.
+func clientFrame(fin bool, opcode byte, payload []byte) []byte {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+
+	frame := []byte{first}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	default:
+		frame = append(frame, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	}
+
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	return frame
+}
+
+// readServerFrame reads an unmasked frame of the server, the opcode is zero when it failed.
+func readServerFrame(reader *bufio.Reader) (byte, []byte) {
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, nil
+	}
+
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var extended [2]byte
+		io.ReadFull(reader, extended[:])
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return 0, nil
+	}
+
+	return header[0] & 0x0F, payload
+}
+
+func TestWebsocketAccept(t *testing.T) {
+	// the handshake of RFC 6455 section 1.3
+	if accept := WebsocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("WebsocketAccept() = %s", accept)
+	}
+}
+
+func TestWebsocketReadsMessagesAndAnswersPings(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	websocket := NewWebsocket(server, time.Second)
+	client_reader := bufio.NewReader(client)
+
+	go func() {
+		client.Write(clientFrame(true, OpPing, []byte("keepalive")))
+		// a message fragmented around a pong
+		client.Write(clientFrame(false, OpText, []byte(`{"event":`)))
+		client.Write(clientFrame(true, OpPong, nil))
+		client.Write(clientFrame(true, opContinuation, []byte(`"subscribe"}`)))
+	}()
+
+	pong := make(chan []byte, 1)
+	go func() {
+		opcode, payload := readServerFrame(client_reader)
+		if opcode != OpPong {
+			payload = nil
+		}
+		pong <- payload
+	}()
+
+	opcode, message, err := websocket.ReadMessage(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if payload := <-pong; string(payload) != "keepalive" {
+		t.Errorf("expected the ping answered with its payload, got %q", payload)
+	}
+
+	if opcode != OpText || string(message) != `{"event":"subscribe"}` {
+		t.Errorf("expected the fragments joined in a text message, got %x %q", opcode, message)
+	}
+
+	go websocket.WriteText(make([]byte, 300))
+	if opcode, payload := readServerFrame(client_reader); opcode != OpText || len(payload) != 300 {
+		t.Errorf("expected a text frame of 300 bytes, got %x of %d", opcode, len(payload))
+	}
+
+	go client.Write(clientFrame(true, OpClose, []byte{0x03, 0xE8}))
+	go func() {
+		if _, _, err := websocket.ReadMessage(time.Second); !errors.Is(err, io.EOF) {
+			t.Errorf("expected io.EOF once the client closed, got %v", err)
+		}
+	}()
+
+	if opcode, payload := readServerFrame(client_reader); opcode != OpClose || binary.BigEndian.Uint16(payload) != CloseNormal {
+		t.Errorf("expected the close echoed, got %x %v", opcode, payload)
+	}
+
+	if err := websocket.WriteText([]byte("late")); !errors.Is(err, ErrWebsocketClosed) {
+		t.Errorf("expected no write after the close, got %v", err)
+	}
+}
+
+func TestWebsocketRefusesUnmaskedFrames(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go client.Write([]byte{0x81, 0x02, 'h', 'i'})
+
+	if _, _, err := NewWebsocket(server, time.Second).ReadMessage(time.Second); !errors.Is(err, ErrWebsocketProtocol) {
+		t.Errorf("expected a protocol error, got %v", err)
+	}
+}
+
+func TestWebsocketGivesUpOnSilentClient(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	var timeout net.Error
+	if _, _, err := NewWebsocket(server, time.Second).ReadMessage(20 * time.Millisecond); !errors.As(err, &timeout) || !timeout.Timeout() {
+		t.Errorf("expected the read to time out, got %v", err)
+	}
+}
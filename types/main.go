@@ -1,6 +1,10 @@
 package types
 
-import "github.com/shopspring/decimal"
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
 
 type Depth struct {
 	Asks     [][]decimal.Decimal `json:"asks"`
@@ -38,14 +42,342 @@ const (
 	TypeMarket OrderType = "market"
 )
 
+// TimeInForce is how long the part of an order which isn't matched right away stays in the book.
+type TimeInForce string
+
+const (
+	// TimeInForceGTC rests it until it's cancelled, orders without a time in force are good till cancelled
+	TimeInForceGTC TimeInForce = "GTC"
+	// TimeInForceIOC cancels it, the part matched right away is kept
+	TimeInForceIOC TimeInForce = "IOC"
+	// TimeInForceFOK cancels the whole order unless it's matched in full right away
+	TimeInForceFOK TimeInForce = "FOK"
+	// TimeInForceGTD rests it until its expiry, it's cancelled then
+	TimeInForceGTD TimeInForce = "GTD"
+)
+
+// TimeInForces are the times in force of the orders.
+var TimeInForces = []TimeInForce{TimeInForceGTC, TimeInForceIOC, TimeInForceFOK, TimeInForceGTD}
+
+// SelfTradePrevention is what the engine does when an order would match a resting order of the same member,
+// orders without one are matched as any other.
+type SelfTradePrevention string
+
+const (
+	// SelfTradePreventionCancelNewest cancels the incoming order, the resting one is kept
+	SelfTradePreventionCancelNewest SelfTradePrevention = "cancel_newest"
+	// SelfTradePreventionCancelOldest cancels the resting order, the incoming one goes on matching
+	SelfTradePreventionCancelOldest SelfTradePrevention = "cancel_oldest"
+	// SelfTradePreventionCancelBoth cancels both orders
+	SelfTradePreventionCancelBoth SelfTradePrevention = "cancel_both"
+	// SelfTradePreventionDecrementAndCancel takes the smaller quantity of the two off both, the one left
+	// without quantity is cancelled, both are when they had the same
+	SelfTradePreventionDecrementAndCancel SelfTradePrevention = "decrement_and_cancel"
+)
+
+// SelfTradePreventions are the self-trade prevention policies of the orders.
+var SelfTradePreventions = []SelfTradePrevention{
+	SelfTradePreventionCancelNewest,
+	SelfTradePreventionCancelOldest,
+	SelfTradePreventionCancelBoth,
+	SelfTradePreventionDecrementAndCancel,
+}
+
+// TriggerBy is the price a stop order is triggered by, the orders without one are triggered by the last trade price.
+type TriggerBy string
+
+const (
+	// TriggerByLastPrice triggers the stop order once the price of the trades of its book reaches its stop price
+	TriggerByLastPrice TriggerBy = "last_price"
+	// TriggerByIndexPrice triggers it once the index price pushed to the engine for its market reaches it
+	TriggerByIndexPrice TriggerBy = "index_price"
+)
+
 type Config struct {
-	Referral *Referral `yaml:"referral"`
+	Referral    *Referral                    `yaml:"referral"`
+	APIVersions map[string]*APIVersionConfig `yaml:"api_versions"`
+	// EventBus configures the bus carrying the commands of the engine and the events of the workers
+	EventBus *EventBusConfig `yaml:"event_bus"`
+	// EventVersions is the version of every broker event producers emit
+	EventVersions map[string]int `yaml:"event_versions"`
+	Engine        *EngineConfig  `yaml:"engine"`
+	// CandleIntegrity configures the nightly check of the stored candles
+	CandleIntegrity *CandleIntegrityConfig `yaml:"candle_integrity"`
+	// Klines configures the candles the kline builder keeps from the trades
+	Klines *KlinesConfig `yaml:"klines"`
+	// PreTradeChecks are the checks run on the orders placed through the API, in order
+	PreTradeChecks []*PreTradeCheckConfig `yaml:"pre_trade_checks"`
+	// MarketGroupDomains is the market group of the public requests of each domain
+	MarketGroupDomains map[string]string `yaml:"market_group_domains"`
+	AlgoOrders         *AlgoOrdersConfig `yaml:"algo_orders"`
+	// DecimalScaleEpsilon is the largest change rounding a decimal to the scale of its column may make on save
+	DecimalScaleEpsilon decimal.Decimal `yaml:"decimal_scale_epsilon"`
+	// RoundingDriftThreshold is the drift of a currency, day and code path the rounding report flags
+	RoundingDriftThreshold decimal.Decimal `yaml:"rounding_drift_threshold"`
+	Reports                *ReportsConfig  `yaml:"reports"`
+	// BackgroundMigrations configures the worker running the data migrations too long for a deploy
+	BackgroundMigrations *BackgroundMigrationsConfig `yaml:"background_migrations"`
+	// MarketData configures the public market data streams published to the websocket gateway
+	MarketData *MarketDataConfig `yaml:"market_data"`
+	// ConversionBridge is the currency conversions go through when two currencies aren't traded against each other
+	ConversionBridge string `yaml:"conversion_bridge"`
+	// Downloads configures the endpoints serving bulk downloads
+	Downloads *DownloadsConfig `yaml:"downloads"`
+	OrderIDs  *OrderIDsConfig  `yaml:"order_ids"`
+	// MemberExports configures the exports of everything held about a member, requested by admins
+	MemberExports *MemberExportsConfig `yaml:"member_exports"`
+	// Archival configures the job archiving the IEOs and the markets which ended long ago
+	Archival *ArchivalConfig `yaml:"archival"`
+	// FastAck configures the placement acknowledging orders before they're persisted
+	FastAck *FastAckConfig `yaml:"fast_ack"`
+	// StreamAuth configures the re-authentication of the private websocket sessions
+	StreamAuth *StreamAuthConfig `yaml:"stream_auth"`
+	// SecurityEvents configures the feed of the sensitive actions on the accounts of the members
+	SecurityEvents *SecurityEventsConfig `yaml:"security_events"`
+	// RateLimits are the quotas of requests of the members per bucket, order, cancel, market_data and default
+	RateLimits map[string]*RateLimitConfig `yaml:"rate_limits"`
+	// StreamFanout configures the queues of the websocket connections
+	StreamFanout *StreamFanoutConfig `yaml:"stream_fanout"`
+	// Convert configures the instant conversions between currencies
+	Convert *ConvertConfig `yaml:"convert"`
+	// MakerProgram configures the statistics of the members providing liquidity
+	MakerProgram *MakerProgramConfig `yaml:"maker_program"`
+	// ScheduledOrders configures the orders placed at a time chosen by their member
+	ScheduledOrders *ScheduledOrdersConfig `yaml:"scheduled_orders"`
+	// Delisting configures the delisting of the markets
+	Delisting *DelistingConfig `yaml:"delisting"`
+}
+
+type EventBusConfig struct {
+	// Driver is the broker of the bus, kafka, the brokers are KAFKA_URL
+	Driver string `yaml:"driver"`
+}
+
+type DelistingConfig struct {
+	// Interval is how often the market delister runs the due steps of the delistings
+	Interval time.Duration `yaml:"interval"`
+	// CancelTimeout is how long the delister waits for the engine to cancel the orders of a market
+	// before it cancels the ones left itself
+	CancelTimeout time.Duration `yaml:"cancel_timeout"`
+}
+
+type ScheduledOrdersConfig struct {
+	// LockOnSchedule locks the funds of an order when it's scheduled rather than when it's placed
+	LockOnSchedule bool `yaml:"lock_on_schedule"`
+	// GraceWindow is how late an order missed while the scheduler was down is still placed, it expires after
+	GraceWindow time.Duration `yaml:"grace_window"`
+	// MaxPending is the number of orders a member can have scheduled
+	MaxPending int `yaml:"max_pending"`
+	// MaxAhead is how far ahead an order can be scheduled
+	MaxAhead time.Duration `yaml:"max_ahead"`
+}
+
+type MakerProgramConfig struct {
+	// Groups are the groups of members enrolled in the maker program
+	Groups []string `yaml:"groups"`
+	// SampleInterval is the time between two samples of the quotes of the enrolled makers
+	SampleInterval time.Duration `yaml:"sample_interval"`
+}
+
+type ConvertConfig struct {
+	// DeskUID is the member paying the conversions and executing their routes, conversions are disabled without it
+	DeskUID string `yaml:"desk_uid"`
+	// QuoteTTL is how long a quote can be accepted
+	QuoteTTL time.Duration `yaml:"quote_ttl"`
+	// Buffer is the part of what a route gets at the depth kept by the desk, it covers the price moving until the route is executed
+	Buffer decimal.Decimal `yaml:"buffer"`
+}
+
+type StreamFanoutConfig struct {
+	// QueueSizes are the updates a connection queues per channel of each kind, depth, trades, ticker, kline and private
+	QueueSizes map[string]int `yaml:"queue_sizes"`
+	// PingInterval is the time between two pings of a private websocket connection, PongTimeout how long the client
+	// can stay silent before its connection is closed
+	PingInterval time.Duration `yaml:"ping_interval"`
+	PongTimeout  time.Duration `yaml:"pong_timeout"`
+	// Shards is the number of shards the public channels are spread over, each broadcasts its channels on a
+	// goroutine of its own
+	Shards int `yaml:"shards"`
+}
+
+type RateLimitConfig struct {
+	// Limit is the number of requests of the bucket a member can send per Window
+	Limit  int           `yaml:"limit"`
+	Window time.Duration `yaml:"window"`
+}
+
+type SecurityEventsConfig struct {
+	// LargeFillNotional is the total in each quote currency above which a fill is reported to its members,
+	// fills of the currencies missing aren't reported
+	LargeFillNotional map[string]decimal.Decimal `yaml:"large_fill_notional"`
+}
+
+type StreamAuthConfig struct {
+	// ExpiringNotice is how long before its token expires a session is asked for a fresh one
+	ExpiringNotice time.Duration `yaml:"expiring_notice"`
+}
+
+type FastAckConfig struct {
+	// Enabled makes the API reserve the funds of an order in the shadow balances, submit it to the engine and respond
+	// with it pending, the order processor persists it. Needs the order event v4
+	Enabled bool `yaml:"enabled"`
+	// ReconcileInterval is the time between two reconciliations of the shadow balances with the ledger
+	ReconcileInterval time.Duration `yaml:"reconcile_interval"`
+	// MaxPendingAge is how long an acknowledged order can wait to be persisted before the fast path is closed
+	MaxPendingAge time.Duration `yaml:"max_pending_age"`
+	// MaxDrift is how much the shadow balance of an account with unpersisted orders may exceed the ledger
+	// before the fast path is closed
+	MaxDrift decimal.Decimal `yaml:"max_drift"`
+}
+
+type ArchivalConfig struct {
+	// IEOAfter is how long after it ended or was disabled an IEO is archived, IEOs aren't archived when it's zero
+	IEOAfter time.Duration `yaml:"ieo_after"`
+	// MarketAfter is how long a disabled market goes without trades before it's archived, markets aren't archived when it's zero
+	MarketAfter time.Duration `yaml:"market_after"`
+}
+
+type MemberExportsConfig struct {
+	// EncryptionKey is the hex encoded 32 byte key the exports are encrypted in the report bucket and their links signed with
+	EncryptionKey string `yaml:"encryption_key"`
+	// LinkTTL is how long the download link of an export is valid for
+	LinkTTL time.Duration `yaml:"link_ttl"`
+}
+
+type OrderIDsConfig struct {
+	// BlockSize is the number of order ids a process leases at once
+	BlockSize int `yaml:"block_size"`
+	// AsyncPersist makes the API hand the orders it places to the order processor with their id,
+	// the order processor inserts them instead of the API
+	AsyncPersist bool `yaml:"async_persist"`
+}
+
+type DownloadsConfig struct {
+	// RateLimit is the number of downloads a client IP can request per RateLimitWindow
+	RateLimit       int           `yaml:"rate_limit"`
+	RateLimitWindow time.Duration `yaml:"rate_limit_window"`
+}
+
+type MarketDataConfig struct {
+	// DepthInterval is the time the depth changes are coalesced for before they're published as one depth frame
+	DepthInterval time.Duration `yaml:"depth_interval"`
+	// DepthTopInterval is the least time between two top-N depth snapshots of a market, 200ms when it's not set
+	DepthTopInterval time.Duration `yaml:"depth_top_interval"`
+	// DepthBatchInterval is the time between two frames of the batched depth stream, the stream is off when it's zero
+	DepthBatchInterval time.Duration `yaml:"depth_batch_interval"`
+	// TradesBatchInterval is the time between two frames of the batched trades stream, the stream is off when it's zero
+	TradesBatchInterval time.Duration `yaml:"trades_batch_interval"`
+}
+
+type BackgroundMigrationsConfig struct {
+	// BatchSize is the number of rows a batch of a migration goes through
+	BatchSize int `yaml:"batch_size"`
+	// Throttle is the pause between two batches, it leaves room for the live traffic
+	Throttle time.Duration `yaml:"throttle"`
+}
+
+type ReportsConfig struct {
+	// StoragePath is the directory the reports generated asynchronously are written to, the mount of the report bucket
+	StoragePath string `yaml:"storage_path"`
+	// ChunkSize is the number of members a report is generated for at once
+	ChunkSize int `yaml:"chunk_size"`
+}
+
+type AlgoOrdersConfig struct {
+	// SliceInterval is the time between two child orders of a TWAP
+	SliceInterval time.Duration `yaml:"slice_interval"`
+	// MaxDuration is the longest TWAP a member can place
+	MaxDuration time.Duration `yaml:"max_duration"`
+	// MaxOpenOrders is the number of open orders of a member above which slices wait
+	MaxOpenOrders int `yaml:"max_open_orders"`
+}
+
+type PreTradeCheckConfig struct {
+	Name   string            `yaml:"name"`
+	Params map[string]string `yaml:"params"`
+}
+
+type CandleIntegrityConfig struct {
+	// Markets is the number of markets sampled each night
+	Markets int `yaml:"markets"`
+	// Periods are the candle periods checked on each sampled market
+	Periods []string `yaml:"periods"`
+	// AutoRepair overwrites the stored candles drifting more than RepairThreshold
+	AutoRepair bool `yaml:"auto_repair"`
+	// RepairThreshold is the drift of a field, relative to the value computed from the trades, above which a candle is repaired
+	RepairThreshold decimal.Decimal `yaml:"repair_threshold"`
+}
+
+type KlinesConfig struct {
+	// Store is where the candles are kept, database for the klines table or influx for the candles_<period> measurements
+	Store string `yaml:"store"`
+	// Periods are the periods of the candles kept for each market
+	Periods []string `yaml:"periods"`
+}
+
+type EngineConfig struct {
+	// SlowCycleThreshold is the matching cycle latency above which the cycle is logged
+	SlowCycleThreshold time.Duration `yaml:"slow_cycle_threshold"`
+	// HeartbeatInterval is how often the engine sends itself a heartbeat through the broker
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+	// HeartbeatTimeout is how long the consumer can go without receiving a heartbeat before it's reconnected
+	HeartbeatTimeout time.Duration `yaml:"heartbeat_timeout"`
+	// ReconnectBackoff is the delay before the first reconnect, it doubles on every failed attempt up to ReconnectMaxBackoff
+	ReconnectBackoff    time.Duration `yaml:"reconnect_backoff"`
+	ReconnectMaxBackoff time.Duration `yaml:"reconnect_max_backoff"`
+	// MaxOutage is how long the consumer can be down before its markets are halted
+	MaxOutage time.Duration `yaml:"max_outage"`
+	// CaptureDir is where the engine records the commands it processes for replays, empty disables the capture
+	CaptureDir string `yaml:"capture_dir"`
+	// CaptureSalt is the key member IDs are hashed with in the captures
+	CaptureSalt string `yaml:"capture_salt"`
+	// CaptureCheckpoint is the time between two depth records of a market in the captures
+	CaptureCheckpoint time.Duration `yaml:"capture_checkpoint"`
+	// RekeyTimeout is how long a change of the price precision of a market waits for the engine to re-key the book
+	RekeyTimeout time.Duration `yaml:"rekey_timeout"`
+	// ExpirySweepInterval is the time between two sweeps of the good-till-date orders past their expiry
+	ExpirySweepInterval time.Duration `yaml:"expiry_sweep_interval"`
+	// StatusURL is where the API reaches the status router of the engine, ENGINE_STATUS_PORT of the engine process
+	StatusURL string `yaml:"status_url"`
+	// WALDir is where the engine logs the commands of each book and their snapshots, empty rebuilds the books from the database
+	WALDir string `yaml:"wal_dir"`
+	// WALSnapshotEvery is the number of commands of a book between two snapshots, zero only snapshots the books on reload
+	WALSnapshotEvery int `yaml:"wal_snapshot_every"`
+	// TradingStateTimeout is how long a change of the trading state of a market waits for the engine to apply it
+	TradingStateTimeout time.Duration `yaml:"trading_state_timeout"`
+	// InvariantCheckEveryCommand checks the invariants of a book after every command, for debugging
+	InvariantCheckEveryCommand bool `yaml:"invariant_check_every_command"`
+	// InvariantCheckInterval is the time between two checks of the invariants of every book, zero disables them
+	InvariantCheckInterval time.Duration `yaml:"invariant_check_interval"`
+	// InvariantViolationHalts halts a book found breaking its invariants
+	InvariantViolationHalts bool `yaml:"invariant_violation_halts"`
+	// LaneCapacity is the number of commands of a market waiting for its engine before the consumer waits for room
+	LaneCapacity int `yaml:"lane_capacity"`
+	// ShutdownTimeout bounds the time the engine takes to drain its lanes and snapshot its books once it's asked to stop
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// APIVersionConfig announces the retirement of an API version, times are in RFC 3339.
+type APIVersionConfig struct {
+	Deprecation string `yaml:"deprecation"`
+	Sunset      string `yaml:"sunset"`
 }
 
 type Referral struct {
 	Enabled  bool                   `yaml:"enabled"`
 	Currency string                 `yaml:"currency"`
 	Rewards  []ConfigReferralReward `yaml:"rewards"`
+	// MaxCodes is the number of referral codes a member can create
+	MaxCodes int `yaml:"max_codes"`
+	// Kickback gives the referred friends part of their own fees back, besides the commission of their referrer
+	Kickback ReferralKickback `yaml:"kickback"`
+}
+
+type ReferralKickback struct {
+	// Rate is the part of its fees a friend gets back, zero turns the kickbacks off
+	Rate decimal.Decimal `yaml:"rate"`
+	// Days is how long a friend gets its fees back, in UTC days from the day it registered
+	Days int `yaml:"days"`
 }
 
 type ConfigReferralReward struct {
@@ -58,8 +390,31 @@ type MarketState string
 var (
 	MarketStateEndabled MarketState = "enabled"
 	MarketStateDisabled MarketState = "disabled"
+	// MarketStateHalted is set by the matching engine while it can't consume orders, orders are rejected until it recovers
+	MarketStateHalted MarketState = "halted"
+	// MarketStateArchived is set on ended IEOs and disabled markets by the archival job, they're left out of listings
+	MarketStateArchived MarketState = "archived"
+	// MarketStateDelisted is set on the markets whose delisting completed, they take no order and are left out of listings
+	MarketStateDelisted MarketState = "delisted"
 )
 
+// TradingState is what the book of a market takes, set by an admin and enforced by the matching engine.
+type TradingState string
+
+var (
+	// TradingStateTrading takes and matches every command
+	TradingStateTrading TradingState = "trading"
+	// TradingStateCancelOnly takes the cancels only, the orders submitted are rejected
+	TradingStateCancelOnly TradingState = "cancel_only"
+	// TradingStateHalted takes nothing, the book is frozen: no order is submitted, cancelled nor triggered
+	TradingStateHalted TradingState = "halted"
+)
+
+// Valid reports whether the state is one of the trading states.
+func (s TradingState) Valid() bool {
+	return s == TradingStateTrading || s == TradingStateCancelOnly || s == TradingStateHalted
+}
+
 type AccountType string
 
 var (
@@ -68,3 +423,13 @@ var (
 	AccountTypeMargin  AccountType = "margin"
 	AccountTypeFutures AccountType = "futures"
 )
+
+// FeatureFlag is a per-market switch of a matching engine behavior.
+type FeatureFlag string
+
+var (
+	FeatureUsePriceLevelBook FeatureFlag = "use_price_level_book"
+	FeatureFifoTiebreakV2    FeatureFlag = "fifo_tiebreak_v2"
+)
+
+var FeatureFlags = []FeatureFlag{FeatureUsePriceLevelBook, FeatureFifoTiebreakV2}
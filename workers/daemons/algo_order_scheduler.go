@@ -0,0 +1,169 @@
+package daemons
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/types"
+)
+
+// algoSchedulerTick is how often the scheduler looks for algo orders due for a slice.
+const algoSchedulerTick = time.Second
+
+// AlgoOrderScheduler places the slices of the TWAP orders. Its whole state is in the database,
+// a restarted scheduler carries on from the children already placed.
+type AlgoOrderScheduler struct {
+	Running bool
+}
+
+func NewAlgoOrderScheduler() *AlgoOrderScheduler {
+	return &AlgoOrderScheduler{Running: true}
+}
+
+func (s *AlgoOrderScheduler) Stop() {
+	s.Running = false
+}
+
+func (s *AlgoOrderScheduler) Start() {
+	for s.Running {
+		now := time.Now()
+
+		var algo_orders []*models.AlgoOrder
+		config.DataBase.
+			Where("(state = ? AND next_slice_at <= ?) OR state = ?", models.AlgoOrderStateActive, now, models.AlgoOrderStateCancelling).
+			Order("next_slice_at asc").
+			Find(&algo_orders)
+
+		for _, algo_order := range algo_orders {
+			if algo_order.State == models.AlgoOrderStateCancelling {
+				s.finishCancel(algo_order)
+				continue
+			}
+
+			s.process(algo_order, now)
+		}
+
+		time.Sleep(algoSchedulerTick)
+	}
+}
+
+func (s *AlgoOrderScheduler) save(algo_order *models.AlgoOrder) {
+	if result := config.DataBase.Save(algo_order); result.Error != nil {
+		config.Logger.Errorf("Failed to save algo order %s: %v", algo_order.UUID, result.Error)
+	}
+}
+
+// finishCancel cancels the open children of a cancelled algo order, it's cancelled once none is left.
+func (s *AlgoOrderScheduler) finishCancel(algo_order *models.AlgoOrder) {
+	executed, _, open_orders := models.AlgoProgress(algo_order.Children())
+	algo_order.Executed = executed
+
+	if len(open_orders) > 0 {
+		models.CancelChildren(open_orders)
+		return
+	}
+
+	algo_order.State = models.AlgoOrderStateCancelled
+	s.save(algo_order)
+}
+
+func (s *AlgoOrderScheduler) process(algo_order *models.AlgoOrder, now time.Time) {
+	interval := models.AlgoSliceInterval()
+
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", algo_order.MarketID); result.Error != nil {
+		config.Logger.Errorf("Failed to find the market of algo order %s: %v", algo_order.UUID, result.Error)
+		return
+	}
+
+	if market.State != string(types.MarketStateEndabled) {
+		algo_order.Pause(now, interval)
+		s.save(algo_order)
+		return
+	}
+
+	executed, open, open_orders := models.AlgoProgress(algo_order.Children())
+	algo_order.Executed = executed
+
+	// a slice left in the book gives its quantity back to the schedule
+	models.CancelChildren(open_orders)
+
+	if algo_order.Finished(now, interval) {
+		if len(open_orders) == 0 {
+			algo_order.State = models.AlgoOrderStateDone
+		}
+
+		algo_order.NextSliceAt = now.Add(interval)
+		s.save(algo_order)
+		return
+	}
+
+	algo_order.NextSliceAt = now.Add(interval)
+
+	var member *models.Member
+	if result := config.DataBase.First(&member, algo_order.MemberID); result.Error != nil {
+		config.Logger.Errorf("Failed to find the member of algo order %s: %v", algo_order.UUID, result.Error)
+		return
+	}
+
+	if member.OpenOrdersCount() >= models.MaxOpenOrders() {
+		s.save(algo_order)
+		return
+	}
+
+	since := algo_order.StartAt
+	if algo_order.LastSliceAt.Valid {
+		since = algo_order.LastSliceAt.Time
+	}
+
+	var market_volume decimal.NullDecimal
+	config.DataBase.
+		Model(&models.Trade{}).
+		Select("SUM(amount)").
+		Where("market_id = ? AND created_at >= ?", market.Symbol, since).
+		Scan(&market_volume)
+
+	slice := models.TWAPSlice(
+		algo_order.Quantity, executed.Add(open), algo_order.StartAt, algo_order.EndAt, now,
+		algo_order.MaxParticipation, market_volume.Decimal, int32(market.AmountPrecision),
+	)
+
+	if !slice.IsPositive() || slice.LessThan(market.MinAmount) {
+		// the remainder is too small to be placed
+		if algo_order.Quantity.Sub(executed.Add(open)).LessThan(market.MinAmount) && len(open_orders) == 0 {
+			algo_order.State = models.AlgoOrderStateDone
+		}
+
+		s.save(algo_order)
+		return
+	}
+
+	params := &helpers.CreateOrderParams{
+		Market:        market.Symbol,
+		Side:          algo_order.Side,
+		OrdType:       algo_order.OrdType,
+		Quantity:      decimal.NewNullDecimal(slice),
+		AlgoOrderUUID: algo_order.UUIDRef(),
+	}
+	if algo_order.OrdType == types.TypeLimit {
+		params.Price = algo_order.Price
+	}
+
+	errs := new(helpers.Errors)
+	if params.CreateOrder(member, errs); errs.Size() > 0 {
+		algo_order.LastError = sql.NullString{String: strings.Join(errs.Errors, ","), Valid: true}
+		config.Logger.Warnf("Failed to place a slice of algo order %s: %s", algo_order.UUID, algo_order.LastError.String)
+	} else {
+		algo_order.LastError = sql.NullString{}
+		algo_order.Slices++
+		algo_order.LastSliceAt = sql.NullTime{Time: now, Valid: true}
+	}
+
+	s.save(algo_order)
+}
@@ -0,0 +1,57 @@
+package daemons
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+)
+
+// backgroundMigratorIdle is how often the migrator looks for a queued migration when there's none to run.
+const backgroundMigratorIdle = 10 * time.Second
+
+// BackgroundMigrator runs the queued background migrations one batch at a time, oldest first.
+// A single migrator should run, the batch and the cursor of a migration are committed together so it resumes where it stopped.
+type BackgroundMigrator struct {
+	Running bool
+}
+
+func NewBackgroundMigrator() *BackgroundMigrator {
+	return &BackgroundMigrator{Running: true}
+}
+
+func (m *BackgroundMigrator) Stop() {
+	m.Running = false
+}
+
+func (m *BackgroundMigrator) Start() {
+	for m.Running {
+		migration := models.NextBackgroundMigration()
+		if migration == nil {
+			time.Sleep(backgroundMigratorIdle)
+			continue
+		}
+
+		err := config.DataBase.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Step(tx, config.BackgroundMigrations.BatchSize, time.Now()); err != nil {
+				return err
+			}
+
+			return tx.Save(migration).Error
+		})
+
+		if err != nil {
+			config.Logger.Errorf("Background migration %s failed at cursor %d: %v", migration.Name, migration.Cursor, err)
+			migration.Fail(err)
+			continue
+		}
+
+		if migration.State == models.BackgroundMigrationStateDone {
+			config.Logger.Infof("Background migration %s done, %d rows in %d batches", migration.Name, migration.Processed, migration.Batches)
+		}
+
+		time.Sleep(config.BackgroundMigrations.Throttle)
+	}
+}
@@ -0,0 +1,123 @@
+package daemons
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/decimalutil"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/types"
+)
+
+// convertHedgerTick is how often the hedger looks at the accepted conversions.
+const convertHedgerTick = time.Second
+
+// ConvertHedger executes the routes of the accepted conversions for the convert desk, one market order per leg,
+// each leg converting what the previous one got. What a leg didn't spend and what the route got over or under
+// the quote are booked to the revenues. Its whole state is in the database, like the algo order scheduler.
+type ConvertHedger struct {
+	Running bool
+}
+
+func NewConvertHedger() *ConvertHedger {
+	return &ConvertHedger{Running: true}
+}
+
+func (h *ConvertHedger) Stop() {
+	h.Running = false
+}
+
+func (h *ConvertHedger) Start() {
+	for h.Running {
+		var quotes []*models.ConvertQuote
+		config.DataBase.Where("state = ?", models.ConvertQuoteStateAccepted).Order("id asc").Find(&quotes)
+
+		for _, quote := range quotes {
+			h.process(quote, time.Now())
+		}
+
+		time.Sleep(convertHedgerTick)
+	}
+}
+
+func (h *ConvertHedger) save(quote *models.ConvertQuote) {
+	if result := config.DataBase.Save(quote); result.Error != nil {
+		config.Logger.Errorf("Failed to save convert quote %s: %v", quote.UUID, result.Error)
+	}
+}
+
+func (h *ConvertHedger) process(quote *models.ConvertQuote, now time.Time) {
+	legs, err := quote.Legs()
+	if err != nil {
+		config.Logger.Errorf("Failed to load the route of convert quote %s: %v", quote.UUID, err)
+		return
+	}
+
+	if quote.HedgeOrderID.Valid {
+		var order *models.Order
+		if result := config.DataBase.Limit(1).Find(&order, quote.HedgeOrderID.Int64); result.Error != nil || result.RowsAffected == 0 || !order.Final() {
+			return
+		}
+
+		leg := legs[quote.HedgeStep]
+		models.BookConvertRevenue(quote, leg.From, quote.CompleteHedgeStep(order))
+		h.save(quote)
+	}
+
+	if quote.HedgeStep >= len(legs) {
+		quote.Settle(now)
+		models.BookConvertRevenue(quote, quote.ToCurrency, quote.Difference)
+		h.save(quote)
+		return
+	}
+
+	h.placeLeg(quote, legs[quote.HedgeStep])
+}
+
+// placeLeg places the market order of the leg for the desk, a hedge amount too small to be placed is kept by the desk.
+func (h *ConvertHedger) placeLeg(quote *models.ConvertQuote, leg models.ConvertLeg) {
+	desk, err := models.ConvertDesk()
+	if err != nil {
+		config.Logger.Errorf("Failed to find the convert desk for quote %s: %v", quote.UUID, err)
+		return
+	}
+
+	quantity := quote.HedgeAmount
+	side := types.SideSell
+	if leg.Side == models.SideBuy {
+		side = types.SideBuy
+		quantity, _ = leg.Output(quote.HedgeAmount, models.GetDepth(leg.BookSide(), leg.Market))
+	}
+	quantity = decimalutil.Round(quantity, leg.AmountPrecision, decimalutil.Down)
+
+	if !quantity.IsPositive() || quantity.LessThan(leg.MinAmount) {
+		models.BookConvertRevenue(quote, leg.From, quote.CompleteHedgeStep(nil))
+		h.save(quote)
+		return
+	}
+
+	params := &helpers.CreateOrderParams{
+		Market:           leg.Market,
+		Side:             side,
+		OrdType:          types.TypeMarket,
+		Quantity:         decimal.NewNullDecimal(quantity),
+		ConvertQuoteUUID: uuid.NullUUID{UUID: quote.UUID, Valid: true},
+	}
+
+	errs := new(helpers.Errors)
+	if order := params.CreateOrder(desk, errs); errs.Size() > 0 {
+		quote.LastError = sql.NullString{String: strings.Join(errs.Errors, ","), Valid: true}
+		config.Logger.Warnf("Failed to place leg %s of convert quote %s: %s", leg.Market, quote.UUID, quote.LastError.String)
+	} else {
+		quote.LastError = sql.NullString{}
+		quote.HedgeOrderID = sql.NullInt64{Int64: order.ID, Valid: true}
+	}
+
+	h.save(quote)
+}
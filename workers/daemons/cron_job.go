@@ -13,7 +13,7 @@ type CronJob struct {
 }
 
 func NewCronJob() *CronJob {
-	jobs := []jobs.Job{&cron.GlobalPriceJob{}, &cron.ReleaseCommissionJob{}}
+	jobs := []jobs.Job{&cron.GlobalPriceJob{}, &cron.ReleaseCommissionJob{}, &cron.CandleIntegrityJob{}, &cron.TradeArchiveJob{}, &cron.ArchivalJob{}, &cron.MakerStatsSampleJob{}, &cron.MakerStatsRollupJob{}, &cron.RevenueStatsJob{}}
 
 	return &CronJob{Running: true, Jobs: jobs}
 }
@@ -0,0 +1,43 @@
+package daemons
+
+import (
+	"time"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+)
+
+// MarketDelister runs the steps of the delistings once they're due. Its whole state is in the database,
+// the steps missed while it was down run when it's back.
+type MarketDelister struct {
+	Running bool
+	Engine  models.DelistingEngine
+}
+
+func NewMarketDelister() *MarketDelister {
+	return &MarketDelister{Running: true, Engine: models.BusDelistingEngine{}}
+}
+
+func (d *MarketDelister) Stop() {
+	d.Running = false
+}
+
+func (d *MarketDelister) Start() {
+	for d.Running {
+		now := time.Now()
+
+		var delistings []*models.MarketDelisting
+		config.DataBase.
+			Where("state NOT IN ?", []models.MarketDelistingState{models.MarketDelistingStateDelisted, models.MarketDelistingStateAborted}).
+			Order("id asc").
+			Find(&delistings)
+
+		for _, delisting := range delistings {
+			if err := models.RunMarketDelisting(config.DataBase, delisting, d.Engine, now); err != nil {
+				config.Logger.Errorf("Failed to run the delisting of market %s: %v", delisting.MarketID, err)
+			}
+		}
+
+		time.Sleep(models.DelistingInterval())
+	}
+}
@@ -0,0 +1,50 @@
+package daemons
+
+import (
+	"time"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+)
+
+// reportGeneratorTick is how often the generator looks for pending reports.
+const reportGeneratorTick = 5 * time.Second
+
+// ReportGenerator writes the reports requested asynchronously to the report bucket, one at a time.
+type ReportGenerator struct {
+	Running bool
+}
+
+func NewReportGenerator() *ReportGenerator {
+	return &ReportGenerator{Running: true}
+}
+
+func (g *ReportGenerator) Stop() {
+	g.Running = false
+}
+
+func (g *ReportGenerator) Start() {
+	// reports left running by a generator which stopped are started over
+	config.DataBase.
+		Model(&models.ReportJob{}).
+		Where("state = ?", models.ReportJobStateRunning).
+		Update("state", models.ReportJobStatePending)
+
+	for g.Running {
+		var jobs []*models.ReportJob
+		config.DataBase.Where("state = ?", models.ReportJobStatePending).Order("id asc").Find(&jobs)
+
+		for _, job := range jobs {
+			if !job.Claim() {
+				continue
+			}
+
+			config.Logger.Infof("Generating %s report %s", job.Kind, job.UUID)
+			if err := job.Generate(time.Now()); err != nil {
+				config.Logger.Errorf("Failed to generate %s report %s: %v", job.Kind, job.UUID, err)
+			}
+		}
+
+		time.Sleep(reportGeneratorTick)
+	}
+}
@@ -0,0 +1,91 @@
+package daemons
+
+import (
+	"strings"
+	"time"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/helpers"
+	"github.com/zsmartex/finex/models"
+)
+
+// ScheduledOrderScheduler places the scheduled orders at their time. Its whole state is in the database, the orders
+// missed while it was down are placed when it's back within the grace window and expired after it.
+type ScheduledOrderScheduler struct {
+	Running bool
+}
+
+func NewScheduledOrderScheduler() *ScheduledOrderScheduler {
+	return &ScheduledOrderScheduler{Running: true}
+}
+
+func (s *ScheduledOrderScheduler) Stop() {
+	s.Running = false
+}
+
+func (s *ScheduledOrderScheduler) Start() {
+	for s.Running {
+		now := time.Now()
+
+		var scheduled_orders []*models.ScheduledOrder
+		config.DataBase.
+			Where("state = ? AND execute_at <= ?", models.ScheduledOrderStateScheduled, now).
+			Order("execute_at asc, id asc").
+			Find(&scheduled_orders)
+
+		for _, scheduled_order := range scheduled_orders {
+			s.process(scheduled_order, now)
+		}
+
+		// the orders are placed in the second of their time
+		time.Sleep(time.Until(now.Truncate(time.Second).Add(time.Second)))
+	}
+}
+
+func (s *ScheduledOrderScheduler) close(scheduled_order *models.ScheduledOrder, state models.ScheduledOrderState, reason string) {
+	if err := models.CloseScheduledOrder(scheduled_order, state, reason); err != nil {
+		config.Logger.Errorf("Failed to close scheduled order %s as %s: %v", scheduled_order.UUID, state, err)
+	}
+}
+
+func (s *ScheduledOrderScheduler) process(scheduled_order *models.ScheduledOrder, now time.Time) {
+	if scheduled_order.Due(now, models.ScheduledOrdersGraceWindow()) == models.ScheduledOrderExpire {
+		config.Logger.Warnf("Scheduled order %s missed its time %s, it expired", scheduled_order.UUID, scheduled_order.ExecuteAt)
+		s.close(scheduled_order, models.ScheduledOrderStateExpired, "")
+		return
+	}
+
+	var market *models.Market
+	if result := config.DataBase.First(&market, "symbol = ?", scheduled_order.MarketID); result.Error != nil {
+		config.Logger.Errorf("Failed to find the market of scheduled order %s: %v", scheduled_order.UUID, result.Error)
+		return
+	}
+
+	if err := scheduled_order.MarketRejection(market); err != nil {
+		s.close(scheduled_order, models.ScheduledOrderStateRejected, err.Error())
+		return
+	}
+
+	var member *models.Member
+	if result := config.DataBase.First(&member, scheduled_order.MemberID); result.Error != nil {
+		config.Logger.Errorf("Failed to find the member of scheduled order %s: %v", scheduled_order.UUID, result.Error)
+		return
+	}
+
+	errs := new(helpers.Errors)
+	params := helpers.ScheduledOrderParams(scheduled_order)
+	if helpers.Vaildate(params, errs); errs.Size() > 0 {
+		s.close(scheduled_order, models.ScheduledOrderStateRejected, strings.Join(errs.Errors, ","))
+		return
+	}
+
+	order := params.BuildOrder(member, errs)
+	if errs.Size() > 0 {
+		s.close(scheduled_order, models.ScheduledOrderStateRejected, strings.Join(errs.Errors, ","))
+		return
+	}
+
+	if err := models.ExecuteScheduledOrder(scheduled_order, order, now); err != nil {
+		config.Logger.Errorf("Failed to place scheduled order %s: %v", scheduled_order.UUID, err)
+	}
+}
@@ -0,0 +1,32 @@
+package engines
+
+import (
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/models"
+)
+
+// DeadLetterRecorderWorker keeps the messages the consumers parked on the dead letter topic, the admins list them
+// and re-inject them once the consumer is fixed.
+type DeadLetterRecorderWorker struct {
+}
+
+func NewDeadLetterRecorderWorker() *DeadLetterRecorderWorker {
+	return &DeadLetterRecorderWorker{}
+}
+
+func (w DeadLetterRecorderWorker) Process(payload []byte) error {
+	parked, err := events.DecodeDeadLetter(payload)
+	if err != nil {
+		return err
+	}
+
+	dead_letter, err := models.RecordDeadLetter(parked)
+	if err != nil {
+		return err
+	}
+
+	config.Logger.Warnf("Dead letter %d of topic %s parked, attempt %d: %s", dead_letter.ID, dead_letter.Topic, dead_letter.Attempts, dead_letter.Error)
+
+	return nil
+}
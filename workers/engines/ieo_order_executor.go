@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 
 	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
 	"github.com/zsmartex/finex/models"
 )
 
@@ -17,7 +18,7 @@ func NewIEOOrderExecutorWorker() *IEOOrderExecutorWorker {
 func (w *IEOOrderExecutorWorker) Process(payload []byte) error {
 	var payload_ieo_order_message *models.IEOOrderJSON
 	if err := json.Unmarshal(payload, &payload_ieo_order_message); err != nil {
-		return err
+		return events.Malformed(err)
 	}
 
 	var ieo_order *models.IEOOrder
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 
 	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
 	"github.com/zsmartex/finex/models"
 )
 
@@ -28,7 +29,7 @@ func NewIEOOrderProcessorWorker() *IEOOrderProcessorWorker {
 func (w *IEOOrderProcessorWorker) Process(payload []byte) error {
 	var payload_ieo_order_message *models.IEOOrderJSON
 	if err := json.Unmarshal(payload, &payload_ieo_order_message); err != nil {
-		return err
+		return events.Malformed(err)
 	}
 
 	if err := models.SubmitIEOOrder(payload_ieo_order_message.ID); err != nil {
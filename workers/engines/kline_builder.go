@@ -0,0 +1,38 @@
+package engines
+
+import (
+	"github.com/zsmartex/finex/events"
+	"github.com/zsmartex/finex/models"
+)
+
+// KlineBuilderWorker keeps the candles of every market from the trades the trade executor committed, for each period
+// of klines.periods. A trade delivered again is skipped by the candles holding it already.
+type KlineBuilderWorker struct {
+	store   models.KlineStore
+	periods []string
+}
+
+func NewKlineBuilderWorker() *KlineBuilderWorker {
+	return &KlineBuilderWorker{
+		store:   models.Klines(),
+		periods: models.KlinePeriods(),
+	}
+}
+
+func (w *KlineBuilderWorker) Process(payload []byte) error {
+	executed_trade, err := events.DecodeExecutedTrade(payload)
+	if err != nil {
+		return err
+	}
+
+	trade := &models.Trade{
+		ID:        executed_trade.ID,
+		MarketID:  executed_trade.Market,
+		Price:     executed_trade.Price,
+		Amount:    executed_trade.Amount,
+		Total:     executed_trade.Total,
+		CreatedAt: executed_trade.CreatedAt,
+	}
+
+	return w.store.Apply(trade, w.periods)
+}
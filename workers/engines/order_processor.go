@@ -1,18 +1,14 @@
 package engines
 
 import (
-	"encoding/json"
+	"fmt"
 
 	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/events"
 	"github.com/zsmartex/finex/models"
 	"github.com/zsmartex/pkg"
 )
 
-type OrderProcessorPayloadMessage struct {
-	Action pkg.PayloadAction `json:"action"`
-	ID     int64             `json:"id"`
-}
-
 type OrderProcessorWorker struct {
 }
 
@@ -32,8 +28,7 @@ func NewOrderProcessorWorker() *OrderProcessorWorker {
 }
 
 func (w OrderProcessorWorker) Process(payload []byte) error {
-	var order_processor_payload OrderProcessorPayloadMessage
-	err := json.Unmarshal(payload, &order_processor_payload)
+	order_processor_payload, err := events.DecodeOrder(payload)
 	if err != nil {
 		return err
 	}
@@ -41,10 +36,83 @@ func (w OrderProcessorWorker) Process(payload []byte) error {
 	id := order_processor_payload.ID
 
 	switch order_processor_payload.Action {
+	case events.ActionCreate:
+		err = models.PersistOrder(order_processor_payload.Attributes)
+	case events.ActionPersist:
+		err = models.PersistAcknowledgedOrder(order_processor_payload.Attributes)
 	case pkg.ActionSubmit:
 		err = models.SubmitOrder(id)
 	case pkg.ActionCancel:
+		if len(order_processor_payload.Reason) > 0 {
+			config.Logger.Infof("Order %d cancelled by matching engine, reason: %s", id, order_processor_payload.Reason)
+		}
+
+		if order_processor_payload.ReplacedID > 0 {
+			err = models.RejectReplace(id)
+		} else {
+			err = models.CancelOrder(id)
+		}
+	case events.ActionCancelAlreadyGone:
+		// the trades or the cancel which took the order out of the book set its state
+		config.Logger.Infof("Order %d already gone when cancel command %q reached matching engine", id, order_processor_payload.CommandID)
+	case events.ActionCancelNeverExisted:
+		// the engine doesn't hold the order, its funds are unlocked. A pending order is left to its submit
+		config.Logger.Warnf("Order %d unknown to matching engine, cancel command %q", id, order_processor_payload.CommandID)
+
 		err = models.CancelOrder(id)
+	case events.ActionCancelRejected:
+		// the market is halted, the order stays in the book and keeps its funds locked
+		config.Logger.Infof("Cancel command %q of order %d rejected by matching engine, reason: %s", order_processor_payload.CommandID, id, order_processor_payload.Reason)
+	case events.ActionDecrement:
+		if order_processor_payload.Quantity == nil {
+			return events.Malformed(fmt.Errorf("decrement of order %d without a quantity", id))
+		}
+
+		config.Logger.Infof("Order %d decremented by %s by matching engine, reason: %s", id, order_processor_payload.Quantity, order_processor_payload.Reason)
+
+		err = models.DecrementOrder(id, *order_processor_payload.Quantity)
+	case events.ActionReprice:
+		if order_processor_payload.Price == nil || order_processor_payload.StopPrice == nil {
+			return events.Malformed(fmt.Errorf("reprice of order %d without its prices", id))
+		}
+
+		config.Logger.Infof("Order %d repriced to %s, stop price %s by matching engine, reason: %s", id, order_processor_payload.Price, order_processor_payload.StopPrice, order_processor_payload.Reason)
+
+		err = models.RepriceOrder(id, *order_processor_payload.Price, *order_processor_payload.StopPrice)
+	case events.ActionCancelReplace:
+		config.Logger.Infof("Order %d replaced by order %d", order_processor_payload.ReplacedID, id)
+
+		err = models.ApplyReplace(id)
+	case events.ActionAmend:
+		config.Logger.Infof("Order %d amended by matching engine", id)
+
+		err = models.ApplyAmend(id)
+	case events.ActionAmendRejected:
+		config.Logger.Infof("Amend of order %d rejected by matching engine", id)
+
+		err = models.RejectAmend(id)
+	case events.ActionBatch:
+		if order_processor_payload.Batch == nil {
+			return events.Malformed(fmt.Errorf("batch without entries"))
+		}
+
+		err = models.ProcessBatch(order_processor_payload.Batch)
+	case events.ActionBatchResult:
+		if order_processor_payload.Batch == nil {
+			return events.Malformed(fmt.Errorf("batch result without entries"))
+		}
+
+		// the cancels and the trades of the entries are processed on their own, the result is only logged
+		accepted := 0
+		for _, entry := range order_processor_payload.Batch.Entries {
+			if entry.Accepted {
+				accepted++
+			} else {
+				config.Logger.Infof("Order %d %s of batch %s rejected by matching engine, reason: %s", entry.ID, entry.Action, order_processor_payload.Batch.ID, entry.Reason)
+			}
+		}
+
+		config.Logger.Infof("Batch %s processed by matching engine, %d of %d entries accepted", order_processor_payload.Batch.ID, accepted, len(order_processor_payload.Batch.Entries))
 	}
 
 	if err != nil {
@@ -0,0 +1,18 @@
+package engines
+
+import (
+	"github.com/zsmartex/finex/models"
+)
+
+// SecurityEventRecorderWorker records the security events of the auth service, which owns the API keys
+// and the restrictions of the members. It produces them after the action is committed.
+type SecurityEventRecorderWorker struct {
+}
+
+func NewSecurityEventRecorderWorker() *SecurityEventRecorderWorker {
+	return &SecurityEventRecorderWorker{}
+}
+
+func (w SecurityEventRecorderWorker) Process(payload []byte) error {
+	return models.RecordExternalSecurityEvent(payload)
+}
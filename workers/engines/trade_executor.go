@@ -1,11 +1,12 @@
 package engines
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/zsmartex/pkg"
 	"gorm.io/gorm"
@@ -13,6 +14,8 @@ import (
 
 	"github.com/shopspring/decimal"
 	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/controllers/entities"
+	"github.com/zsmartex/finex/events"
 	"github.com/zsmartex/finex/models"
 	"github.com/zsmartex/finex/types"
 )
@@ -25,9 +28,28 @@ type TradeExecutor struct {
 	TradePayload *pkg.Trade
 	MakerOrder   *models.Order
 	TakerOrder   *models.Order
+	// MatchedAt is the time the engine matched the trade at, nil for the trade events before v3
+	MatchedAt *time.Time
+	// ConfigVersion is the version of the market configuration the engine matched the trade with
+	ConfigVersion int64
+	// Sequence is the sequence of the trade in its market, zero for the trade events before v6
+	Sequence int64
+	// SecurityEvents are the events written with the trade, its members are notified once it's committed
+	SecurityEvents []*models.SecurityEvent
 }
 
+// volumeMirrorPeriod is how often the trade volumes are mirrored to Redis for the API.
+var volumeMirrorPeriod = 5 * time.Second
+
+// tradeBatches is the batched public trades stream, it's off unless market_data.trades_batch_interval is set.
+var tradeBatches *events.StreamBatcher
+
 func NewTradeExecutorWorker() *TradeExecutorWorker {
+	go models.TradeVolumes.MirrorEvery(volumeMirrorPeriod)
+
+	tradeBatches = events.NewStreamBatcher("trades", config.MarketData.TradesBatchInterval)
+	tradeBatches.Start()
+
 	return &TradeExecutorWorker{}
 }
 
@@ -40,11 +62,49 @@ func (w *TradeExecutorWorker) Process(payload []byte) error {
 		TakerOrder: &models.Order{},
 	}
 
-	if err := json.Unmarshal(payload, &trade_executor.TradePayload); err != nil {
+	trade_event, err := events.DecodeTrade(payload)
+	if err != nil {
 		return err
 	}
+	trade_executor.TradePayload = &trade_event.Trade
+	trade_executor.MatchedAt = trade_event.MatchedAt
+	trade_executor.ConfigVersion = trade_event.ConfigVersion
+	trade_executor.Sequence = trade_event.Sequence
 
 	trade, err := trade_executor.CreateTradeAndStrikeOrders()
+	if errors.Is(err, errReplacementPending) {
+		// the trades of a replacement can come before the order processor applied the replace
+		for _, order := range []*models.Order{trade_executor.MakerOrder, trade_executor.TakerOrder} {
+			if order.State == models.StatePending && order.ReplacedOrderID.Valid {
+				if err := models.ApplyReplace(order.ID); err != nil {
+					return err
+				}
+			}
+		}
+
+		trade, err = trade_executor.CreateTradeAndStrikeOrders()
+	}
+
+	if errors.Is(err, errAmendPending) {
+		// the trades of an amended order can come before the order processor applied the amend
+		for _, order := range trade_executor.amendsPending() {
+			if err := models.ApplyAmend(order.ID); err != nil {
+				return err
+			}
+		}
+
+		trade, err = trade_executor.CreateTradeAndStrikeOrders()
+	}
+
+	if errors.Is(err, errTradeExecuted) {
+		config.Logger.Infof("Skipped the trade %d of %s, it was already executed", trade_executor.Sequence, trade_executor.TradePayload.Symbol.ToSymbol(""))
+		return nil
+	}
+
+	if err != nil && trade_executor.persistAcknowledged() {
+		trade, err = trade_executor.CreateTradeAndStrikeOrders()
+	}
+
 	if err != nil {
 		var orders []*models.Order
 
@@ -62,9 +122,10 @@ func (w *TradeExecutorWorker) Process(payload []byte) error {
 				continue
 			}
 
-			config.KafkaProducer.Produce("matching", map[string]interface{}{
-				"action": pkg.ActionSubmit,
-				"order":  order.ToMatchingAttributes(),
+			config.Bus.Publish("matching", order.MarketID, map[string]interface{}{
+				"action":  pkg.ActionSubmit,
+				"order":   order.ToMatchingAttributes(),
+				"options": order.MatchingOptions(),
 			})
 		}
 		return err
@@ -74,6 +135,67 @@ func (w *TradeExecutorWorker) Process(payload []byte) error {
 	return nil
 }
 
+var errTradeExecuted = errors.New("trade is already executed")
+
+var errReplacementPending = errors.New("replacement order isn't applied yet")
+
+// replacementPending reports whether an order of the trade is a replacement still waiting for its funds.
+func (t *TradeExecutor) replacementPending() bool {
+	if !t.IsMakerOrderFake() && t.MakerOrder.State == models.StatePending && t.MakerOrder.ReplacedOrderID.Valid {
+		return true
+	}
+
+	return !t.IsTakerOrderFake() && t.TakerOrder.State == models.StatePending && t.TakerOrder.ReplacedOrderID.Valid
+}
+
+var errAmendPending = errors.New("amend of order isn't applied yet")
+
+// amendsPending returns the orders of the trade the engine matched with an amend which isn't applied yet.
+func (t *TradeExecutor) amendsPending() []*models.Order {
+	var orders []*models.Order
+
+	if !t.IsMakerOrderFake() && amendPending(t.MakerOrder, &t.TradePayload.MakerOrder) {
+		orders = append(orders, t.MakerOrder)
+	}
+
+	if !t.IsTakerOrderFake() && amendPending(t.TakerOrder, &t.TradePayload.TakerOrder) {
+		orders = append(orders, t.TakerOrder)
+	}
+
+	return orders
+}
+
+// amendPending reports whether the engine matched the order at the price and the quantity of its pending amend.
+func amendPending(order *models.Order, matched *pkg.Order) bool {
+	return order.AmendQuantity.Valid && order.AmendQuantity.Decimal.Equal(matched.Quantity) && order.AmendPrice.Decimal.Equal(matched.Price)
+}
+
+// persistAcknowledged persists the orders of the trade which were acknowledged before they were persisted,
+// the trades of an acknowledged order can come before the order processor persisted it.
+func (t *TradeExecutor) persistAcknowledged() bool {
+	var persisted bool
+
+	fakes := map[int64]bool{
+		t.TradePayload.MakerOrder.ID: t.IsMakerOrderFake(),
+		t.TradePayload.TakerOrder.ID: t.IsTakerOrderFake(),
+	}
+
+	for id, fake := range fakes {
+		if fake || !models.Acknowledged(id) {
+			continue
+		}
+
+		if err := models.PersistOutboxOrder(id); err != nil {
+			config.Logger.Errorf("Failed to persist the acknowledged order %d: %v", id, err)
+			continue
+		}
+
+		persisted = true
+	}
+
+	return persisted
+}
+
 func (t *TradeExecutor) IsMakerOrderFake() bool {
 	return t.TradePayload.MakerOrder.IsFake()
 }
@@ -123,6 +245,7 @@ func (t *TradeExecutor) VaildateTrade() error {
 
 func (t *TradeExecutor) CreateTradeAndStrikeOrders() (*models.Trade, error) {
 	var trade *models.Trade
+	t.SecurityEvents = nil
 
 	err := config.DataBase.Transaction(func(tx *gorm.DB) error {
 		var accounts []*models.Account
@@ -151,6 +274,49 @@ func (t *TradeExecutor) CreateTradeAndStrikeOrders() (*models.Trade, error) {
 		}
 		config.Logger.Info("3")
 
+		if t.replacementPending() {
+			return errReplacementPending
+		}
+
+		if len(t.amendsPending()) > 0 {
+			return errAmendPending
+		}
+
+		var side types.TakerType
+		if t.TradePayload.TakerOrder.Side == pkg.SideSell {
+			side = types.TypeSell
+		} else {
+			side = types.TypeBuy
+		}
+
+		trade = &models.Trade{
+			Price:         t.TradePayload.Price,
+			Amount:        t.TradePayload.Quantity,
+			Total:         t.TradePayload.Total,
+			MakerOrderID:  t.TradePayload.MakerOrder.ID,
+			TakerOrderID:  t.TradePayload.TakerOrder.ID,
+			MarketID:      strings.ToLower(t.TradePayload.Symbol.ToSymbol("")),
+			MakerID:       t.TradePayload.MakerOrder.MemberID,
+			TakerID:       t.TradePayload.TakerOrder.MemberID,
+			TakerType:     side,
+			ConfigVersion: t.ConfigVersion,
+			TradeSequence: t.Sequence,
+		}
+
+		if t.MatchedAt != nil {
+			trade.CreatedAt = *t.MatchedAt
+		}
+
+		// the trade is inserted before anything moves, a redelivered trade event finds its trade and changes nothing
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&trade)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return errTradeExecuted
+		}
+
 		if err := t.VaildateTrade(); err != nil {
 			return err
 		}
@@ -189,29 +355,11 @@ func (t *TradeExecutor) CreateTradeAndStrikeOrders() (*models.Trade, error) {
 			accounts_table[account.CurrencyID+":"+strconv.FormatInt(account.MemberID, 10)] = account
 		}
 
-		var side types.TakerType
-		if t.TradePayload.TakerOrder.Side == pkg.SideSell {
-			side = types.TypeSell
-		} else {
-			side = types.TypeBuy
-		}
-
-		trade = &models.Trade{
-			Price:        t.TradePayload.Price,
-			Amount:       t.TradePayload.Quantity,
-			Total:        t.TradePayload.Total,
-			MakerOrderID: t.TradePayload.MakerOrder.ID,
-			TakerOrderID: t.TradePayload.TakerOrder.ID,
-			MarketID:     strings.ToLower(t.TradePayload.Symbol.ToSymbol("")),
-			MakerID:      t.TradePayload.MakerOrder.MemberID,
-			TakerID:      t.TradePayload.TakerOrder.MemberID,
-			TakerType:    side,
-		}
-
 		if !t.IsMakerOrderFake() {
 			if err := t.Strike(
 				trade,
 				t.MakerOrder,
+				market,
 				accounts_table[t.MakerOrder.OutcomeCurrency().ID+":"+strconv.FormatInt(t.MakerOrder.MemberID, 10)],
 				accounts_table[t.MakerOrder.IncomeCurrency().ID+":"+strconv.FormatInt(t.MakerOrder.MemberID, 10)],
 				tx,
@@ -224,6 +372,7 @@ func (t *TradeExecutor) CreateTradeAndStrikeOrders() (*models.Trade, error) {
 			if err := t.Strike(
 				trade,
 				t.TakerOrder,
+				market,
 				accounts_table[t.TakerOrder.OutcomeCurrency().ID+":"+strconv.FormatInt(t.TakerOrder.MemberID, 10)],
 				accounts_table[t.TakerOrder.IncomeCurrency().ID+":"+strconv.FormatInt(t.TakerOrder.MemberID, 10)],
 				tx,
@@ -239,7 +388,19 @@ func (t *TradeExecutor) CreateTradeAndStrikeOrders() (*models.Trade, error) {
 		if !t.IsTakerOrderFake() {
 			tx.Save(&t.TakerOrder)
 		}
-		tx.Create(&trade)
+
+		members := make([]int64, 0, 2)
+		if !t.IsMakerOrderFake() {
+			members = append(members, t.MakerOrder.MemberID)
+		}
+		if !t.IsTakerOrderFake() && t.TakerOrder.MemberID != t.MakerOrder.MemberID {
+			members = append(members, t.TakerOrder.MemberID)
+		}
+
+		security_events, err := models.RecordLargeFills(tx, trade, market.QuoteUnit, members)
+		if err != nil {
+			return err
+		}
 
 		if !t.IsMakerOrderFake() || !t.IsTakerOrderFake() {
 			if err := trade.RecordCompleteOperations(t.TradePayload.SellOrder(), t.TradePayload.BuyOrder(), tx); err != nil {
@@ -247,6 +408,8 @@ func (t *TradeExecutor) CreateTradeAndStrikeOrders() (*models.Trade, error) {
 			}
 		}
 
+		t.SecurityEvents = security_events
+
 		// return nil will commit the whole transaction
 		return nil
 	})
@@ -254,16 +417,8 @@ func (t *TradeExecutor) CreateTradeAndStrikeOrders() (*models.Trade, error) {
 	return trade, err
 }
 
-func (t *TradeExecutor) Strike(trade *models.Trade, order *models.Order, outcome_account, income_account *models.Account, tx *gorm.DB) error {
-	var outcome_value, income_value decimal.Decimal
-	if order.Type == models.SideSell {
-		outcome_value = trade.Amount
-		income_value = trade.Total
-	} else {
-		outcome_value = trade.Total
-		income_value = trade.Amount
-	}
-	fee := income_value.Mul(trade.OrderFee(order))
+func (t *TradeExecutor) Strike(trade *models.Trade, order *models.Order, market *models.Market, outcome_account, income_account *models.Account, tx *gorm.DB) error {
+	outcome_value, income_value, fee := trade.Leg(order)
 	real_income_value := income_value.Sub(fee)
 
 	if err := outcome_account.UnlockAndSubFunds(tx, outcome_value); err != nil {
@@ -276,6 +431,7 @@ func (t *TradeExecutor) Strike(trade *models.Trade, order *models.Order, outcome
 	order.Volume = order.Volume.Sub(trade.Amount)
 	order.Locked = order.Locked.Sub(outcome_value)
 	order.FundsReceived = income_value.Add(order.FundsReceived)
+	order.RecordFill(trade.Total, int32(market.PricePrecision))
 	order.TradesCount += 1
 
 	if order.Volume.IsZero() {
@@ -295,20 +451,41 @@ func (t *TradeExecutor) Strike(trade *models.Trade, order *models.Order, outcome
 	return nil
 }
 
+func (t *TradeExecutor) publishPrivateTrade(trade *models.Trade, member *models.Member) {
+	trade_json := trade.ForUser(member)
+
+	config.RangoClient.EnqueueEvent("private", member.UID, "trade", trade_json)
+	events.PublishPrivate(member.UID, entities.PrivateStreamTrade, entities.PrivateTradeMessage{
+		Event: entities.PrivateStreamTrade,
+		Trade: trade_json,
+	})
+}
+
 func (t *TradeExecutor) PublishTrade(trade *models.Trade) {
 	if !t.IsMakerOrderFake() {
-		maker := trade.Maker()
-		config.RangoClient.EnqueueEvent("private", maker.UID, "trade", trade.ForUser(maker))
+		t.publishPrivateTrade(trade, trade.Maker())
 	}
 
 	if !t.IsTakerOrderFake() {
-		taker := trade.Taker()
-		config.RangoClient.EnqueueEvent("private", taker.UID, "trade", trade.ForUser(taker))
+		t.publishPrivateTrade(trade, trade.Taker())
 	}
 
+	trade_json := trade.TradeGlobalJSON()
 	config.RangoClient.EnqueueEvent("public", trade.MarketID, "trades", map[string]interface{}{
-		"trades": []interface{}{trade.TradeGlobalJSON()},
+		"trades": []interface{}{trade_json},
 	})
+	tradeBatches.Add(trade.MarketID, trade.ID, trade_json)
 
 	trade.WriteToInflux()
+	models.TradeVolumes.Record(trade)
+	events.PublishExecutedTrade(&events.ExecutedTrade{
+		ID:        trade.ID,
+		Market:    trade.MarketID,
+		Price:     trade.Price,
+		Amount:    trade.Amount,
+		Total:     trade.Total,
+		CreatedAt: trade.CreatedAt,
+	})
+
+	models.NotifySecurityEvents(t.SecurityEvents)
 }
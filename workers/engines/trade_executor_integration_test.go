@@ -0,0 +1,119 @@
+//go:build integration
+
+package engines
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"github.com/zsmartex/pkg"
+
+	"github.com/zsmartex/finex/config"
+	"github.com/zsmartex/finex/models"
+	"github.com/zsmartex/finex/types"
+)
+
+func setupTradeExecutorDatabase(t *testing.T) {
+	if len(os.Getenv("DATABASE_HOST")) == 0 {
+		t.Skip("DATABASE_HOST isn't set")
+	}
+
+	config.Logger = logrus.NewEntry(logrus.New())
+
+	db, err := config.NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AutoMigrate(&models.Market{}, &models.Currency{}, &models.Member{}, &models.Order{}, &models.Account{},
+		&models.Liability{}, &models.OperationsAccount{}, &models.Revenue{}, &models.Trade{}); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Where("market_id = ?", "tseusdt").Delete(&models.Trade{})
+	db.Where("market_id = ?", "tseusdt").Delete(&models.Order{})
+	db.Where("symbol = ?", "tseusdt").Delete(&models.Market{})
+	db.Where("id IN ?", []string{"tse", "usdt"}).Delete(&models.Currency{})
+	db.Where("id IN ?", []int64{61, 62}).Delete(&models.Member{})
+	db.Where("member_id IN ?", []int64{61, 62}).Delete(&models.Account{})
+
+	config.DataBase = db
+	config.Referral = &types.Referral{}
+	config.SecurityEvents = &types.SecurityEventsConfig{}
+}
+
+// The test needs the DATABASE_* variables of a disposable database:
+//
+//	go test -tags integration -run 'TradeExecutor' ./workers/engines
+func TestTradeExecutorRedeliveredTrade(t *testing.T) {
+	setupTradeExecutorDatabase(t)
+
+	db := config.DataBase
+	db.Create(&models.Market{Symbol: "tseusdt", BaseUnit: "tse", QuoteUnit: "usdt", AmountPrecision: 4, PricePrecision: 2, State: string(types.MarketStateEndabled)})
+	db.Create(&[]*models.Currency{{ID: "tse", Type: "coin"}, {ID: "usdt", Type: "coin"}})
+	db.Create(&[]*models.Member{{ID: 61, UID: "ID61"}, {ID: 62, UID: "ID62"}})
+	db.Create(&[]*models.Account{
+		{MemberID: 61, CurrencyID: "tse", Locked: decimal.NewFromInt(1)},
+		{MemberID: 62, CurrencyID: "usdt", Locked: decimal.NewFromInt(100)},
+	})
+
+	price := decimal.NewNullDecimal(decimal.NewFromInt(100))
+	db.Create(&[]*models.Order{
+		{
+			ID: 9200, MemberID: 61, Ask: "tse", Bid: "usdt", MarketID: "tseusdt", Type: models.SideSell, OrdType: types.TypeLimit, Price: price,
+			Volume: decimal.NewFromInt(1), OriginVolume: decimal.NewFromInt(1), Locked: decimal.NewFromInt(1), OriginLocked: decimal.NewFromInt(1), State: models.StateWait,
+		},
+		{
+			ID: 9201, MemberID: 62, Ask: "tse", Bid: "usdt", MarketID: "tseusdt", Type: models.SideBuy, OrdType: types.TypeLimit, Price: price,
+			Volume: decimal.NewFromInt(1), OriginVolume: decimal.NewFromInt(1), Locked: decimal.NewFromInt(100), OriginLocked: decimal.NewFromInt(100), State: models.StateWait,
+		},
+	})
+
+	symbol := pkg.Symbol{BaseCurrency: "TSE", QuoteCurrency: "USDT"}
+	payload := &pkg.Trade{
+		Symbol:     symbol,
+		Price:      decimal.NewFromInt(100),
+		Quantity:   decimal.NewFromInt(1),
+		Total:      decimal.NewFromInt(100),
+		MakerOrder: pkg.Order{ID: 9200, Symbol: symbol, MemberID: 61, Side: pkg.SideSell, Price: decimal.NewFromInt(100), Quantity: decimal.NewFromInt(1)},
+		TakerOrder: pkg.Order{ID: 9201, Symbol: symbol, MemberID: 62, Side: pkg.SideBuy, Price: decimal.NewFromInt(100), Quantity: decimal.NewFromInt(1)},
+	}
+
+	// the broker delivers the trade event again, as after a rebalance before its offset was committed
+	for delivery := 1; delivery <= 2; delivery++ {
+		executor := &TradeExecutor{TradePayload: payload, MakerOrder: &models.Order{}, TakerOrder: &models.Order{}, Sequence: 7}
+
+		_, err := executor.CreateTradeAndStrikeOrders()
+		if delivery == 1 && err != nil {
+			t.Fatalf("failed to execute the trade: %v", err)
+		}
+
+		if delivery == 2 && !errors.Is(err, errTradeExecuted) {
+			t.Fatalf("expected the trade delivered again to be skipped, got %v", err)
+		}
+	}
+
+	var trades int64
+	db.Model(&models.Trade{}).Where("market_id = ? AND trade_sequence = ?", "tseusdt", 7).Count(&trades)
+	if trades != 1 {
+		t.Errorf("expected one trade of sequence 7, got %d", trades)
+	}
+
+	balances := map[string]string{
+		"61:tse": "0/0", "61:usdt": "100/0",
+		"62:tse": "1/0", "62:usdt": "0/0",
+	}
+
+	var accounts []*models.Account
+	db.Where("member_id IN ?", []int64{61, 62}).Find(&accounts)
+	for _, account := range accounts {
+		key := strconv.FormatInt(account.MemberID, 10) + ":" + account.CurrencyID
+		if got := account.Balance.String() + "/" + account.Locked.String(); got != balances[key] {
+			t.Errorf("expected the balance/locked of %s to move once to %s, got %s", key, balances[key], got)
+		}
+	}
+}